@@ -0,0 +1,181 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package checkcmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+	tlsutils "github.com/trustbloc/edge-core/pkg/utils/tls"
+)
+
+const (
+	adminURLFlagName  = "admin-url"
+	adminURLFlagUsage = "Base URL of the wallet-server administrative API, eg. https://localhost:8090/admin." +
+		" Alternatively, this can be set with the following environment variable: " + adminURLEnvKey
+	adminURLEnvKey = "WALLET_SERVER_ADMIN_URL"
+
+	adminTokenFlagName  = "admin-token"
+	adminTokenFlagUsage = "Bearer token for the administrative API." +
+		" Alternatively, this can be set with the following environment variable: " + adminTokenEnvKey
+	adminTokenEnvKey = "WALLET_SERVER_ADMIN_TOKEN" // nolint:gosec // false positive on 'TOKEN'
+
+	subFlagName  = "sub"
+	subFlagUsage = "The 'sub' of the user to check."
+
+	tlsCACertsFlagName  = "tls-cacerts"
+	tlsCACertsFlagUsage = "Comma-Separated list of ca certs path." +
+		" Alternatively, this can be set with the following environment variable: " + tlsCACertsEnvKey
+	tlsCACertsEnvKey = "WALLET_SERVER_TLS_CACERTS"
+
+	userCheckPath = "/users/check"
+)
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// GetCheckUserCmd returns the Cobra command that checks the consistency of a user's wallet data.
+func GetCheckUserCmd() *cobra.Command {
+	cmd := createCheckUserCmd(nil)
+
+	createFlags(cmd)
+
+	return cmd
+}
+
+func createCheckUserCmd(client httpClient) *cobra.Command {
+	return &cobra.Command{
+		Use:   "check-user",
+		Short: "Check a user's wallet data",
+		Long: "Cross-checks the user record, tokens, bootstrap data, vaults and keystores of a user" +
+			" and prints a report with suggested repairs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			adminURL, err := cmdutils.GetUserSetVarFromString(cmd, adminURLFlagName, adminURLEnvKey, false)
+			if err != nil {
+				return err
+			}
+
+			token, err := cmdutils.GetUserSetVarFromString(cmd, adminTokenFlagName, adminTokenEnvKey, false)
+			if err != nil {
+				return err
+			}
+
+			sub, err := cmd.Flags().GetString(subFlagName)
+			if err != nil || sub == "" {
+				return fmt.Errorf("%s is required", subFlagName)
+			}
+
+			if client == nil {
+				client, err = newHTTPClient(cmd)
+				if err != nil {
+					return err
+				}
+			}
+
+			report, err := fetchReport(cmd.ErrOrStderr(), client, adminURL, token, sub)
+			if err != nil {
+				return err
+			}
+
+			return printReport(cmd.OutOrStdout(), report)
+		},
+	}
+}
+
+func createFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(adminURLFlagName, "", "", adminURLFlagUsage)
+	cmd.Flags().StringP(adminTokenFlagName, "", "", adminTokenFlagUsage)
+	cmd.Flags().StringP(subFlagName, "", "", subFlagUsage)
+	cmd.Flags().StringArrayP(tlsCACertsFlagName, "", []string{}, tlsCACertsFlagUsage)
+}
+
+func newHTTPClient(cmd *cobra.Command) (*http.Client, error) {
+	rootCAs, err := cmdutils.GetUserSetVarFromArrayString(cmd, tlsCACertsFlagName, tlsCACertsEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure root CAs: %w", err)
+	}
+
+	if len(rootCAs) == 0 {
+		return &http.Client{}, nil
+	}
+
+	certPool, err := tlsutils.GetCertPool(false, rootCAs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init tls cert pool: %w", err)
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    certPool,
+		MinVersion: tls.VersionTLS12,
+	}}}, nil
+}
+
+func fetchReport(stderr io.Writer, client httpClient, adminURL, token, sub string) (*oidc.CheckReport, error) {
+	endpoint := strings.TrimSuffix(adminURL, "/") + userCheckPath + "?sub=" + url.QueryEscape(sub)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call admin api: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			fmt.Fprintf(stderr, "failed to close response body: %s\n", errClose)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin api response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin api returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	report := &oidc.CheckReport{}
+
+	err = json.Unmarshal(body, report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse report: %w", err)
+	}
+
+	return report, nil
+}
+
+func printReport(w io.Writer, report *oidc.CheckReport) error {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to format report: %w", err)
+	}
+
+	fmt.Fprintln(w, string(out))
+
+	if !report.Healthy {
+		return fmt.Errorf("wallet data of user %s is inconsistent", report.Sub)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package checkcmd // nolint:testpackage // using private types in tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+)
+
+func TestCheckUserCmd(t *testing.T) {
+	t.Run("prints healthy report", func(t *testing.T) {
+		srv := mockAdminServer(t, "token", &oidc.CheckReport{Sub: "123", Healthy: true})
+		defer srv.Close()
+
+		out := &bytes.Buffer{}
+		cmd := GetCheckUserCmd()
+		cmd.SetOut(out)
+		cmd.SetArgs([]string{
+			"--" + adminURLFlagName, srv.URL + "/admin/",
+			"--" + adminTokenFlagName, "token",
+			"--" + subFlagName, "123",
+		})
+
+		require.NoError(t, cmd.Execute())
+		require.Contains(t, out.String(), `"healthy": true`)
+	})
+
+	t.Run("error if user data is inconsistent", func(t *testing.T) {
+		srv := mockAdminServer(t, "token", &oidc.CheckReport{Sub: "123", Checks: []*oidc.CheckResult{{
+			Name: "tokens", Status: oidc.CheckFailed, Repair: "ask the user to log in again",
+		}}})
+		defer srv.Close()
+
+		out := &bytes.Buffer{}
+		cmd := GetCheckUserCmd()
+		cmd.SetOut(out)
+		cmd.SetArgs([]string{
+			"--" + adminURLFlagName, srv.URL + "/admin",
+			"--" + adminTokenFlagName, "token",
+			"--" + subFlagName, "123",
+		})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "inconsistent")
+		require.Contains(t, out.String(), "ask the user to log in again")
+	})
+
+	t.Run("error if unauthorized", func(t *testing.T) {
+		srv := mockAdminServer(t, "token", &oidc.CheckReport{})
+		defer srv.Close()
+
+		cmd := GetCheckUserCmd()
+		cmd.SetArgs([]string{
+			"--" + adminURLFlagName, srv.URL + "/admin",
+			"--" + adminTokenFlagName, "wrong",
+			"--" + subFlagName, "123",
+		})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "status 401")
+	})
+
+	t.Run("writes the errors closing the response apart from the report", func(t *testing.T) {
+		report, err := json.Marshal(&oidc.CheckReport{Sub: "123", Healthy: true})
+		require.NoError(t, err)
+
+		out, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		cmd := createCheckUserCmd(&mockHTTPClient{resp: &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       &failingCloser{Reader: bytes.NewReader(report)},
+		}})
+		createFlags(cmd)
+		cmd.SetOut(out)
+		cmd.SetErr(stderr)
+		cmd.SetArgs([]string{
+			"--" + adminURLFlagName, "http://localhost/admin",
+			"--" + adminTokenFlagName, "token",
+			"--" + subFlagName, "123",
+		})
+
+		require.NoError(t, cmd.Execute())
+		require.NotContains(t, out.String(), "failed to close response body")
+		require.Contains(t, stderr.String(), "failed to close response body: test")
+	})
+
+	t.Run("error if sub is missing", func(t *testing.T) {
+		cmd := GetCheckUserCmd()
+		cmd.SetArgs([]string{
+			"--" + adminURLFlagName, "http://localhost/admin",
+			"--" + adminTokenFlagName, "token",
+		})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "sub is required")
+	})
+
+	t.Run("error if admin url is missing", func(t *testing.T) {
+		cmd := GetCheckUserCmd()
+		cmd.SetArgs([]string{"--" + subFlagName, "123"})
+
+		require.Error(t, cmd.Execute())
+	})
+
+	t.Run("error if ca certs are invalid", func(t *testing.T) {
+		cmd := GetCheckUserCmd()
+		cmd.SetArgs([]string{
+			"--" + adminURLFlagName, "http://localhost/admin",
+			"--" + adminTokenFlagName, "token",
+			"--" + subFlagName, "123",
+			"--" + tlsCACertsFlagName, "INVALID",
+		})
+
+		require.Error(t, cmd.Execute())
+	})
+}
+
+func mockAdminServer(t *testing.T, token string, report *oidc.CheckReport) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		require.Equal(t, "/admin"+userCheckPath, r.URL.Path)
+		require.Equal(t, report.Sub, r.URL.Query().Get("sub"))
+		require.NoError(t, json.NewEncoder(w).Encode(report))
+	}))
+}
+
+type mockHTTPClient struct {
+	resp *http.Response
+}
+
+func (c *mockHTTPClient) Do(*http.Request) (*http.Response, error) {
+	return c.resp, nil
+}
+
+type failingCloser struct {
+	io.Reader
+}
+
+func (c *failingCloser) Close() error {
+	return errors.New("test")
+}
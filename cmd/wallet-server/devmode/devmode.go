@@ -0,0 +1,102 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package devmode runs in-process fakes of the services the wallet server depends on: the OIDC provider and
+// hub-auth, the hub-kms and the EDV server. They keep their data in memory, so that the login and onboarding of the
+// users can be run without deploying the services, e.g. by frontend developers and in CI. They are not secure.
+package devmode
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const edvPath = "/encrypted-data-vaults"
+
+var logger = log.New("edge-agent/devmode")
+
+// Services are the fakes, served on the same address.
+type Services struct {
+	// URL is the base URL of the fakes, e.g. http://127.0.0.1:8077.
+	URL    string
+	server *http.Server
+}
+
+// Start serves the fakes on the address, e.g. localhost:8077, or on a random port if it has none.
+func Start(addr string) (*Services, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	url := "http://" + listener.Addr().String()
+
+	hubAuth, err := newHubAuth(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the hub-auth: %w", err)
+	}
+
+	keyServer, err := newKeyServer(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the hub-kms: %w", err)
+	}
+
+	vaultServer, err := newVaultServer(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the edv: %w", err)
+	}
+
+	router := mux.NewRouter()
+	hubAuth.register(router)
+	keyServer.register(router)
+	vaultServer.register(router)
+
+	s := &Services{
+		URL:    url,
+		server: &http.Server{Handler: router},
+	}
+
+	go func() {
+		serveErr := s.server.Serve(listener)
+		if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+			logger.Errorf("dev mode services closed unexpectedly: %s", serveErr)
+		}
+	}()
+
+	logger.Warnf("dev mode: serving the fakes of the OIDC provider, hub-auth, hub-kms and EDV on %s", url)
+
+	return s, nil
+}
+
+// OIDCProviderURL is the issuer of the fake OIDC provider, which is also the fake hub-auth.
+func (s *Services) OIDCProviderURL() string {
+	return s.URL
+}
+
+// HubAuthURL is the URL of the fake hub-auth.
+func (s *Services) HubAuthURL() string {
+	return s.URL
+}
+
+// KMSURL is the URL of the fake hub-kms, both for the authz and the ops keystores.
+func (s *Services) KMSURL() string {
+	return s.URL
+}
+
+// EDVURL is the URL of the fake EDV server, both for the key and the user vaults.
+func (s *Services) EDVURL() string {
+	return s.URL + edvPath
+}
+
+// Close stops serving the fakes. Their data is lost.
+func (s *Services) Close() error {
+	return s.server.Close()
+}
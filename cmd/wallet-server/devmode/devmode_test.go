@@ -0,0 +1,325 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package devmode_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	oidcp "github.com/coreos/go-oidc"
+	cryptoapi "github.com/hyperledger/aries-framework-go/pkg/crypto"
+	webcrypto "github.com/hyperledger/aries-framework-go/pkg/crypto/webkms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/webkms"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edv/pkg/restapi/models"
+	"golang.org/x/oauth2"
+
+	"github.com/trustbloc/edge-agent/cmd/wallet-server/devmode"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+)
+
+const clientID = "wallet-server"
+
+func TestServices_OIDCProvider(t *testing.T) {
+	s := startServices(t)
+
+	provider, err := oidcp.NewProvider(context.Background(), s.OIDCProviderURL())
+	require.NoError(t, err)
+
+	config := &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: "secret",
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  "https://wallet.example.com/oidc/callback",
+		Scopes:       []string{oidcp.ScopeOpenID},
+	}
+
+	t.Run("logs the default user in", func(t *testing.T) {
+		token := login(t, config, config.AuthCodeURL("state"))
+
+		idToken, err := provider.Verifier(&oidcp.Config{ClientID: clientID}).Verify(context.Background(),
+			token.Extra("id_token").(string))
+		require.NoError(t, err)
+		require.Equal(t, devmode.DefaultSub, idToken.Subject)
+
+		info, err := provider.UserInfo(context.Background(), config.TokenSource(context.Background(), token))
+		require.NoError(t, err)
+		require.Equal(t, devmode.DefaultSub, info.Subject)
+		require.Equal(t, devmode.DefaultSub+"@example.com", info.Email)
+	})
+
+	t.Run("logs the user of the login hint in", func(t *testing.T) {
+		token := login(t, config, config.AuthCodeURL("state", oauth2.SetAuthURLParam("login_hint", "alice"),
+			oauth2.SetAuthURLParam("nonce", "123")))
+
+		idToken, err := provider.Verifier(&oidcp.Config{ClientID: clientID}).Verify(context.Background(),
+			token.Extra("id_token").(string))
+		require.NoError(t, err)
+		require.Equal(t, "alice", idToken.Subject)
+		require.Equal(t, "123", idToken.Nonce)
+
+		refreshed, err := config.TokenSource(context.Background(),
+			&oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+		require.NoError(t, err)
+		require.NotEqual(t, token.AccessToken, refreshed.AccessToken)
+
+		// the refresh tokens are only used once
+		_, err = config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+		require.Error(t, err)
+	})
+
+	t.Run("issues client credentials tokens", func(t *testing.T) {
+		resp, err := http.PostForm(provider.Endpoint().TokenURL, url.Values{
+			"grant_type": {"client_credentials"}, "client_id": {clientID},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("rejects invalid requests", func(t *testing.T) {
+		_, err := config.Exchange(context.Background(), "unknown")
+		require.Error(t, err)
+
+		resp, err := http.PostForm(provider.Endpoint().TokenURL, url.Values{"grant_type": {"password"}})
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, err = noRedirects().Get(provider.Endpoint().AuthURL + "?redirect_uri=callback")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		_, err = provider.UserInfo(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "x"}))
+		require.Error(t, err)
+	})
+}
+
+func TestServices_HubAuth(t *testing.T) {
+	s := startServices(t)
+
+	token := login(t, &oauth2.Config{
+		ClientID:    clientID,
+		Endpoint:    oauth2.Endpoint{AuthURL: s.URL + "/authorize", TokenURL: s.URL + "/token"},
+		RedirectURL: "https://wallet.example.com/oidc/callback",
+	}, s.URL+"/authorize?client_id="+clientID+"&redirect_uri=https://wallet.example.com/oidc/callback")
+
+	authorization := "Bearer " + base64.StdEncoding.EncodeToString([]byte(token.AccessToken))
+
+	resp := request(t, http.MethodGet, s.HubAuthURL()+"/bootstrap", authorization, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = request(t, http.MethodPost, s.HubAuthURL()+"/secret", authorization, []byte(`{"secret":"AQID"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = request(t, http.MethodPost, s.HubAuthURL()+"/bootstrap", authorization,
+		[]byte(`{"data":{"edvVaultURL":"https://edv.example.com/encrypted-data-vaults/123"}}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	r, err := http.NewRequest(http.MethodGet, s.HubAuthURL()+"/bootstrap", nil)
+	require.NoError(t, err)
+	r.Header.Set("Authorization", authorization)
+
+	bootstrap, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+
+	data := &struct {
+		Data struct {
+			UserEDVVaultURL string `json:"edvVaultURL"`
+		} `json:"data"`
+	}{}
+	require.NoError(t, json.NewDecoder(bootstrap.Body).Decode(data))
+	require.NoError(t, bootstrap.Body.Close())
+	require.Equal(t, "https://edv.example.com/encrypted-data-vaults/123", data.Data.UserEDVVaultURL)
+
+	resp = request(t, http.MethodPost, s.HubAuthURL()+"/bootstrap", authorization, []byte(`[`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = request(t, http.MethodPost, s.HubAuthURL()+"/secret", authorization, []byte(`[`))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = request(t, http.MethodGet, s.HubAuthURL()+"/bootstrap", "Bearer "+token.AccessToken, nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = request(t, http.MethodGet, s.HubAuthURL()+"/bootstrap",
+		"Bearer "+base64.StdEncoding.EncodeToString([]byte("unknown")), nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestServices_KMS(t *testing.T) {
+	s := startServices(t)
+
+	keystoreURL, err := webkms.CreateKeyStore(http.DefaultClient, s.KMSURL(), "did:example:123", "", json.Marshal)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(keystoreURL, s.KMSURL()+"/kms/keystores/"))
+
+	keyManager := webkms.New(keystoreURL, http.DefaultClient)
+	crypto := webcrypto.New(keystoreURL, http.DefaultClient)
+
+	t.Run("signs with ed25519 keys", func(t *testing.T) {
+		keyID, keyURL, err := keyManager.Create(kms.ED25519)
+		require.NoError(t, err)
+
+		pubKey, err := keyManager.ExportPubKeyBytes(keyID)
+		require.NoError(t, err)
+
+		signature, err := crypto.Sign([]byte("message"), keyURL)
+		require.NoError(t, err)
+		require.True(t, ed25519.Verify(pubKey, []byte("message"), signature))
+	})
+
+	t.Run("computes macs with hmac keys", func(t *testing.T) {
+		_, keyURL, err := keyManager.Create(kms.HMACSHA256Tag256)
+		require.NoError(t, err)
+
+		mac, err := crypto.ComputeMAC([]byte("data"), keyURL)
+		require.NoError(t, err)
+
+		again, err := crypto.ComputeMAC([]byte("data"), keyURL)
+		require.NoError(t, err)
+		require.Equal(t, mac, again)
+	})
+
+	t.Run("wraps and unwraps keys with ecdh keys", func(t *testing.T) {
+		keyID, keyURL, err := keyManager.Create(kms.ECDH256KWAES256GCM)
+		require.NoError(t, err)
+
+		pubKeyBytes, err := keyManager.ExportPubKeyBytes(keyID)
+		require.NoError(t, err)
+
+		pubKey := &cryptoapi.PublicKey{}
+		require.NoError(t, json.Unmarshal(pubKeyBytes, pubKey))
+
+		cek := bytes.Repeat([]byte{1}, 32)
+
+		wrapped, err := crypto.WrapKey(cek, []byte("apu"), []byte("apv"), pubKey)
+		require.NoError(t, err)
+
+		unwrapped, err := crypto.UnwrapKey(wrapped, keyURL)
+		require.NoError(t, err)
+		require.Equal(t, cek, unwrapped)
+	})
+
+	// the webkms clients do not check the statuses of the responses
+	t.Run("errors", func(t *testing.T) {
+		resp := request(t, http.MethodPost, keystoreURL+"/keys", "", []byte(`{"keyType":"unknown"}`))
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = request(t, http.MethodGet, keystoreURL+"/keys/unknown/export", "", nil)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = request(t, http.MethodPost, s.KMSURL()+"/kms/keystores/unknown/keys", "", []byte(`{"keyType":"ED25519"}`))
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		message := []byte(`{"message":"` + base64.URLEncoding.EncodeToString([]byte("message")) + `"}`)
+
+		resp = request(t, http.MethodPost, keystoreURL+"/keys/unknown/sign", "", message)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		keyID, _, err := keyManager.Create(kms.HMACSHA256Tag256)
+		require.NoError(t, err)
+
+		resp = request(t, http.MethodGet, keystoreURL+"/keys/"+keyID+"/export", "", nil)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = request(t, http.MethodPost, keystoreURL+"/keys/"+keyID+"/sign", "", message)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = request(t, http.MethodPost, keystoreURL+"/keys/"+keyID+"/sign", "", []byte(`[`))
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		other, err := webkms.CreateKeyStore(http.DefaultClient, s.KMSURL(), "did:example:456", "", json.Marshal)
+		require.NoError(t, err)
+
+		// the keys are only used through their keystore
+		resp = request(t, http.MethodPost, other+"/keys/"+keyID+"/computemac", "",
+			[]byte(`{"data":"`+base64.URLEncoding.EncodeToString([]byte("data"))+`"}`))
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestServices_EDV(t *testing.T) {
+	s := startServices(t)
+
+	vaultURL, _, err := sds.New(s.EDVURL()).CreateDataVault(&models.DataVaultConfiguration{
+		Controller:  "did:example:123",
+		ReferenceID: "ref",
+		KEK:         models.IDTypePair{ID: "https://example.com/kms/12345", Type: "AesKeyWrappingKey2019"},
+		HMAC:        models.IDTypePair{ID: "https://example.com/kms/67891", Type: "Sha256HmacKey2019"},
+	})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(vaultURL, s.EDVURL()+"/"))
+}
+
+func TestStart(t *testing.T) {
+	s := startServices(t)
+
+	_, err := devmode.Start(strings.TrimPrefix(s.URL, "http://"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to listen")
+}
+
+func startServices(t *testing.T) *devmode.Services {
+	t.Helper()
+
+	s, err := devmode.Start("localhost:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	return s
+}
+
+// login follows the redirect of the authorization URL to the callback, and exchanges its code for the tokens.
+func login(t *testing.T, config *oauth2.Config, authURL string) *oauth2.Token {
+	t.Helper()
+
+	resp, err := noRedirects().Get(authURL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(callback.String(), config.RedirectURL))
+
+	token, err := config.Exchange(context.Background(), callback.Query().Get("code"))
+	require.NoError(t, err)
+
+	return token
+}
+
+func request(t *testing.T, method, u, authorization string, body []byte) *http.Response {
+	t.Helper()
+
+	r, err := http.NewRequest(method, u, bytes.NewReader(body))
+	require.NoError(t, err)
+	r.Header.Set("Authorization", authorization)
+
+	resp, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return resp
+}
+
+func noRedirects() *http.Client {
+	return &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package devmode
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/trustbloc/edv/pkg/edvprovider/memedvprovider"
+	"github.com/trustbloc/edv/pkg/restapi/operation"
+)
+
+// vaultConfigurationsStoreName is the store of the vault configurations, which the EDV server creates on startup.
+const vaultConfigurationsStoreName = "data_vault_configurations"
+
+// vaultServer is the EDV server, with its vaults in memory and without authorization.
+type vaultServer struct {
+	url        string
+	operations *operation.Operation
+}
+
+func newVaultServer(url string) (*vaultServer, error) {
+	provider := memedvprovider.NewProvider()
+
+	err := provider.CreateStore(vaultConfigurationsStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the vault configurations store: %w", err)
+	}
+
+	return &vaultServer{
+		url:        url,
+		operations: operation.New(&operation.Config{Provider: provider}),
+	}, nil
+}
+
+func (v *vaultServer) register(router *mux.Router) {
+	for _, handler := range v.operations.GetRESTHandlers() {
+		router.HandleFunc(handler.Path(), v.withURL(handler.Handle())).Methods(handler.Method())
+	}
+}
+
+// withURL has the EDV server locate the vaults and documents it creates with the URL of the fakes: it prefixes
+// them with the host of the requests, which has no scheme.
+func (v *vaultServer) withURL(handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Host = v.url
+
+		handle(w, r)
+	}
+}
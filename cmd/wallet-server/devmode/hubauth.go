@@ -0,0 +1,415 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package devmode
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+)
+
+// DefaultSub is the user logged in by the fake OIDC provider when the authorization request has no login_hint.
+const DefaultSub = "dev-user"
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	jwksPath      = "/jwks"
+	authorizePath = "/authorize"
+	tokenPath     = "/token"
+	userInfoPath  = "/userinfo"
+	secretPath    = "/secret"
+	bootstrapPath = "/bootstrap"
+
+	signingKeyID   = "devmode"
+	signingKeySize = 2048
+	tokenTTL       = time.Hour
+)
+
+// hubAuth fakes the hub-auth, which is also the OIDC provider of the wallet server: it logs the users in without
+// asking for credentials, and keeps their secret shares and bootstrap data.
+type hubAuth struct {
+	url        string
+	signingKey *rsa.PrivateKey
+	mutex      sync.Mutex
+	// codes and refreshTokens hold the grants not redeemed yet
+	codes         map[string]*grant
+	refreshTokens map[string]*grant
+	// accessTokens holds the subs of the tokens, and secrets and bootstrapData are indexed by sub
+	accessTokens  map[string]string
+	secrets       map[string][]byte
+	bootstrapData map[string]json.RawMessage
+}
+
+type grant struct {
+	sub      string
+	clientID string
+	nonce    string
+}
+
+type providerMetadata struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	UserInfoEndpoint         string   `json:"userinfo_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	ResponseTypes            []string `json:"response_types_supported"`
+	GrantTypes               []string `json:"grant_types_supported"`
+	SubjectTypes             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgs       []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+type userClaims struct {
+	Sub   string `json:"sub"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type idTokenClaims struct {
+	userClaims
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+	Nonce    string `json:"nonce,omitempty"`
+}
+
+type secretRequest struct {
+	Secret []byte `json:"secret"`
+}
+
+type userBootstrapData struct {
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+func newHubAuth(url string) (*hubAuth, error) {
+	signingKey, err := rsa.GenerateKey(rand.Reader, signingKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the signing key: %w", err)
+	}
+
+	return &hubAuth{
+		url:           url,
+		signingKey:    signingKey,
+		codes:         make(map[string]*grant),
+		refreshTokens: make(map[string]*grant),
+		accessTokens:  make(map[string]string),
+		secrets:       make(map[string][]byte),
+		bootstrapData: make(map[string]json.RawMessage),
+	}, nil
+}
+
+func (h *hubAuth) register(router *mux.Router) {
+	router.HandleFunc(discoveryPath, h.discoveryHandler).Methods(http.MethodGet)
+	router.HandleFunc(jwksPath, h.jwksHandler).Methods(http.MethodGet)
+	router.HandleFunc(authorizePath, h.authorizeHandler).Methods(http.MethodGet)
+	router.HandleFunc(tokenPath, h.tokenHandler).Methods(http.MethodPost)
+	router.HandleFunc(userInfoPath, h.userInfoHandler).Methods(http.MethodGet)
+	router.HandleFunc(secretPath, h.saveSecretHandler).Methods(http.MethodPost)
+	router.HandleFunc(bootstrapPath, h.bootstrapDataHandler).Methods(http.MethodGet)
+	router.HandleFunc(bootstrapPath, h.saveBootstrapDataHandler).Methods(http.MethodPost)
+}
+
+func (h *hubAuth) discoveryHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	common.WriteResponse(w, logger, &providerMetadata{
+		Issuer:                   h.url,
+		AuthorizationEndpoint:    h.url + authorizePath,
+		TokenEndpoint:            h.url + tokenPath,
+		UserInfoEndpoint:         h.url + userInfoPath,
+		JWKSURI:                  h.url + jwksPath,
+		ResponseTypes:            []string{"code"},
+		GrantTypes:               []string{"authorization_code", "refresh_token", "client_credentials"},
+		SubjectTypes:             []string{"public"},
+		IDTokenSigningAlgs:       []string{"RS256"},
+		TokenEndpointAuthMethods: []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func (h *hubAuth) jwksHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	common.WriteResponse(w, logger, map[string][]*jsonWebKey{
+		"keys": {{
+			KeyType:   "RSA",
+			Algorithm: "RS256",
+			Use:       "sig",
+			KeyID:     signingKeyID,
+			N:         base64.RawURLEncoding.EncodeToString(h.signingKey.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(h.signingKey.E)).Bytes()),
+		}},
+	})
+}
+
+// authorizeHandler logs the user of the login_hint in, or the default user, and redirects with the code at once.
+func (h *hubAuth) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirectURL.IsAbs() {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid redirect_uri '%s'", query.Get("redirect_uri"))
+
+		return
+	}
+
+	sub := query.Get("login_hint")
+	if sub == "" {
+		sub = DefaultSub
+	}
+
+	code := uuid.New().String()
+
+	h.mutex.Lock()
+	h.codes[code] = &grant{sub: sub, clientID: query.Get("client_id"), nonce: query.Get("nonce")}
+	h.mutex.Unlock()
+
+	params := redirectURL.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURL.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (h *hubAuth) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid token request: %s", err)
+
+		return
+	}
+
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+
+	var g *grant
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "authorization_code":
+		g = h.redeem(h.codes, r.PostForm.Get("code"))
+	case "refresh_token":
+		g = h.redeem(h.refreshTokens, r.PostForm.Get("refresh_token"))
+	case "client_credentials":
+		g = &grant{sub: clientID, clientID: clientID}
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "unsupported grant_type '%s'", grantType)
+
+		return
+	}
+
+	if g == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid grant")
+
+		return
+	}
+
+	resp, err := h.issueTokens(g, r.PostForm.Get("grant_type") != "client_credentials")
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to issue tokens: %s", err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	common.WriteResponse(w, logger, resp)
+}
+
+func (h *hubAuth) userInfoHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.sub(w, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	common.WriteResponse(w, logger, claimsOf(sub))
+}
+
+func (h *hubAuth) saveSecretHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.hubAuthSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &secretRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid secret request: %s", err)
+
+		return
+	}
+
+	h.mutex.Lock()
+	h.secrets[sub] = request.Secret
+	h.mutex.Unlock()
+}
+
+func (h *hubAuth) bootstrapDataHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.hubAuthSub(w, r)
+	if !ok {
+		return
+	}
+
+	h.mutex.Lock()
+	data := h.bootstrapData[sub]
+	h.mutex.Unlock()
+
+	common.WriteResponse(w, logger, &userBootstrapData{Data: data})
+}
+
+func (h *hubAuth) saveBootstrapDataHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.hubAuthSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &userBootstrapData{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid bootstrap data: %s", err)
+
+		return
+	}
+
+	h.mutex.Lock()
+	h.bootstrapData[sub] = request.Data
+	h.mutex.Unlock()
+}
+
+// redeem removes the grant of the code or refresh token, so that it is only used once.
+func (h *hubAuth) redeem(grants map[string]*grant, key string) *grant {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	g := grants[key]
+	delete(grants, key)
+
+	return g
+}
+
+func (h *hubAuth) issueTokens(g *grant, withUser bool) (*tokenResponse, error) {
+	resp := &tokenResponse{
+		AccessToken: uuid.New().String(),
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(tokenTTL.Seconds()),
+	}
+
+	h.mutex.Lock()
+	h.accessTokens[resp.AccessToken] = g.sub
+
+	if withUser {
+		resp.RefreshToken = uuid.New().String()
+		h.refreshTokens[resp.RefreshToken] = &grant{sub: g.sub, clientID: g.clientID}
+	}
+	h.mutex.Unlock()
+
+	if !withUser {
+		return resp, nil
+	}
+
+	now := time.Now()
+
+	idToken, err := h.sign(&idTokenClaims{
+		userClaims: *claimsOf(g.sub),
+		Issuer:     h.url,
+		Audience:   g.clientID,
+		IssuedAt:   now.Unix(),
+		Expiry:     now.Add(tokenTTL).Unix(),
+		Nonce:      g.nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp.IDToken = idToken
+
+	return resp, nil
+}
+
+// sign returns the claims as a JWT signed with RS256.
+func (h *hubAuth) sign(claims interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": signingKeyID, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, h.signingKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// hubAuthSub returns the sub of the access token of a request to the hub-auth, which sends it base64 encoded.
+func (h *hubAuth) hubAuthSub(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusUnauthorized, "invalid access token: %s", err)
+
+		return "", false
+	}
+
+	return h.sub(w, string(token))
+}
+
+func (h *hubAuth) sub(w http.ResponseWriter, accessToken string) (string, bool) {
+	h.mutex.Lock()
+	sub, ok := h.accessTokens[accessToken]
+	h.mutex.Unlock()
+
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusUnauthorized, "unknown access token")
+	}
+
+	return sub, ok
+}
+
+func claimsOf(sub string) *userClaims {
+	return &userClaims{Sub: sub, Name: sub, Email: sub + "@example.com"}
+}
@@ -0,0 +1,499 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package devmode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	cryptoapi "github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/hyperledger/aries-framework-go/pkg/storage/mem"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+)
+
+const (
+	keystoresPath = "/kms/keystores"
+	keystorePath  = keystoresPath + "/{keystoreID}"
+	keysPath      = keystorePath + "/keys"
+	keyPath       = keysPath + "/{keyID}"
+
+	primaryKeyURI = "local-lock://devmode"
+)
+
+// keyServer fakes the hub-kms: the keys of all its keystores are in the same local KMS, and the requests are
+// neither authenticated nor authorized. Its keystores are not secured with zcaps.
+type keyServer struct {
+	url    string
+	kms    *localkms.LocalKMS
+	crypto *tinkcrypto.Crypto
+	mutex  sync.RWMutex
+	// keystores holds the controllers of the keystores, and keys the keystores of the keys
+	keystores map[string]string
+	keys      map[string]string
+}
+
+// kmsProvider keeps the keys of the local KMS in memory, unencrypted.
+type kmsProvider struct {
+	storage ariesstorage.Provider
+	lock    secretlock.Service
+}
+
+func (p *kmsProvider) StorageProvider() ariesstorage.Provider {
+	return p.storage
+}
+
+func (p *kmsProvider) SecretLock() secretlock.Service {
+	return p.lock
+}
+
+// the requests and responses of the key server, whose bytes are base64 URL encoded.
+type (
+	keystoreRequest struct {
+		Controller string `json:"controller,omitempty"`
+		VaultID    string `json:"vaultID,omitempty"`
+	}
+
+	keyRequest struct {
+		KeyType string `json:"keyType,omitempty"`
+	}
+
+	exportKeyResponse struct {
+		PublicKey string `json:"publicKey"`
+	}
+
+	signRequest struct {
+		Message string `json:"message"`
+	}
+
+	signResponse struct {
+		Signature string `json:"signature"`
+	}
+
+	computeMACRequest struct {
+		Data string `json:"data"`
+	}
+
+	computeMACResponse struct {
+		MAC string `json:"mac"`
+	}
+
+	wrapKeyRequest struct {
+		CEK       string    `json:"cek"`
+		APU       string    `json:"apu"`
+		APV       string    `json:"apv"`
+		RecPubKey publicKey `json:"recPubKey"`
+	}
+
+	wrapKeyResponse struct {
+		WrappedKey wrappedKey `json:"wrappedKey"`
+	}
+
+	unwrapKeyRequest struct {
+		WrappedKey wrappedKey `json:"wrappedKey"`
+	}
+
+	unwrapKeyResponse struct {
+		Key string `json:"key"`
+	}
+
+	publicKey struct {
+		KID   string `json:"kid,omitempty"`
+		X     string `json:"x,omitempty"`
+		Y     string `json:"y,omitempty"`
+		Curve string `json:"curve,omitempty"`
+		Type  string `json:"type,omitempty"`
+	}
+
+	wrappedKey struct {
+		KID          string    `json:"kid,omitempty"`
+		EncryptedCEK string    `json:"encryptedCEK,omitempty"`
+		EPK          publicKey `json:"epk,omitempty"`
+		Alg          string    `json:"alg,omitempty"`
+		APU          string    `json:"apu,omitempty"`
+		APV          string    `json:"apv,omitempty"`
+	}
+)
+
+func newKeyServer(url string) (*keyServer, error) {
+	keyManager, err := localkms.New(primaryKeyURI, &kmsProvider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the local kms: %w", err)
+	}
+
+	crypto, err := tinkcrypto.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the crypto: %w", err)
+	}
+
+	return &keyServer{
+		url:       url,
+		kms:       keyManager,
+		crypto:    crypto,
+		keystores: make(map[string]string),
+		keys:      make(map[string]string),
+	}, nil
+}
+
+func (k *keyServer) register(router *mux.Router) {
+	router.HandleFunc(keystoresPath, k.createKeystoreHandler).Methods(http.MethodPost)
+	router.HandleFunc(keystorePath, k.keystoreHandler).Methods(http.MethodGet)
+	router.HandleFunc(keystorePath+"/controller", k.updateControllerHandler).Methods(http.MethodPost)
+	router.HandleFunc(keystorePath+"/wrap", k.wrapKeyHandler).Methods(http.MethodPost)
+	router.HandleFunc(keysPath, k.createKeyHandler).Methods(http.MethodPost)
+	router.HandleFunc(keyPath+"/export", k.exportKeyHandler).Methods(http.MethodGet)
+	router.HandleFunc(keyPath+"/sign", k.signHandler).Methods(http.MethodPost)
+	router.HandleFunc(keyPath+"/computemac", k.computeMACHandler).Methods(http.MethodPost)
+	router.HandleFunc(keyPath+"/unwrap", k.unwrapKeyHandler).Methods(http.MethodPost)
+}
+
+func (k *keyServer) createKeystoreHandler(w http.ResponseWriter, r *http.Request) {
+	request := &keystoreRequest{}
+	if !decode(w, r, request) {
+		return
+	}
+
+	id := uuid.New().String()
+
+	k.mutex.Lock()
+	k.keystores[id] = request.Controller
+	k.mutex.Unlock()
+
+	w.Header().Set("Location", k.url+keystoresPath+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (k *keyServer) keystoreHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := k.keystore(w, r)
+	if !ok {
+		return
+	}
+
+	k.mutex.RLock()
+	controller := k.keystores[id]
+	k.mutex.RUnlock()
+
+	common.WriteResponse(w, logger, &keystoreRequest{Controller: controller})
+}
+
+func (k *keyServer) updateControllerHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := k.keystore(w, r)
+	if !ok {
+		return
+	}
+
+	request := &keystoreRequest{}
+	if !decode(w, r, request) {
+		return
+	}
+
+	k.mutex.Lock()
+	k.keystores[id] = request.Controller
+	k.mutex.Unlock()
+}
+
+func (k *keyServer) createKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := k.keystore(w, r)
+	if !ok {
+		return
+	}
+
+	request := &keyRequest{}
+	if !decode(w, r, request) {
+		return
+	}
+
+	keyID, _, err := k.kms.Create(kms.KeyType(request.KeyType))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to create %s key: %s", request.KeyType, err)
+
+		return
+	}
+
+	k.mutex.Lock()
+	k.keys[keyID] = id
+	k.mutex.Unlock()
+
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%s/keys/%s", k.url, keystoresPath, id, keyID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (k *keyServer) exportKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, _, ok := k.key(w, r)
+	if !ok {
+		return
+	}
+
+	pubKey, err := k.kms.ExportPubKeyBytes(keyID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to export key %s: %s", keyID, err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &exportKeyResponse{PublicKey: base64.URLEncoding.EncodeToString(pubKey)})
+}
+
+func (k *keyServer) signHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, kh, ok := k.key(w, r)
+	if !ok {
+		return
+	}
+
+	request := &signRequest{}
+	if !decode(w, r, request) {
+		return
+	}
+
+	message, ok := decodeBytes(w, request.Message)
+	if !ok {
+		return
+	}
+
+	signature, err := k.crypto.Sign(message, kh)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to sign with key %s: %s", keyID, err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &signResponse{Signature: base64.URLEncoding.EncodeToString(signature)})
+}
+
+func (k *keyServer) computeMACHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, kh, ok := k.key(w, r)
+	if !ok {
+		return
+	}
+
+	request := &computeMACRequest{}
+	if !decode(w, r, request) {
+		return
+	}
+
+	data, ok := decodeBytes(w, request.Data)
+	if !ok {
+		return
+	}
+
+	mac, err := k.crypto.ComputeMAC(data, kh)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to compute mac with key %s: %s", keyID, err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &computeMACResponse{MAC: base64.URLEncoding.EncodeToString(mac)})
+}
+
+// wrapKeyHandler wraps the key for the recipient public key, which needs no key of the keystore.
+func (k *keyServer) wrapKeyHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := k.keystore(w, r)
+	if !ok {
+		return
+	}
+
+	request := &wrapKeyRequest{}
+	if !decode(w, r, request) {
+		return
+	}
+
+	recPubKey, err := request.RecPubKey.toPublicKey()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid recipient public key: %s", err)
+
+		return
+	}
+
+	fields, err := decodeEach(request.CEK, request.APU, request.APV)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid wrap key request: %s", err)
+
+		return
+	}
+
+	wrapped, err := k.crypto.WrapKey(fields[0], fields[1], fields[2], recPubKey)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to wrap key: %s", err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &wrapKeyResponse{WrappedKey: fromWrappedKey(wrapped)})
+}
+
+func (k *keyServer) unwrapKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, kh, ok := k.key(w, r)
+	if !ok {
+		return
+	}
+
+	request := &unwrapKeyRequest{}
+	if !decode(w, r, request) {
+		return
+	}
+
+	wrapped, err := request.WrappedKey.toWrappedKey()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid unwrap key request: %s", err)
+
+		return
+	}
+
+	key, err := k.crypto.UnwrapKey(wrapped, kh)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to unwrap key with key %s: %s", keyID, err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &unwrapKeyResponse{Key: base64.URLEncoding.EncodeToString(key)})
+}
+
+// keystore returns the ID of the keystore of the request, writing a not found response if it does not exist.
+func (k *keyServer) keystore(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := mux.Vars(r)["keystoreID"]
+
+	k.mutex.RLock()
+	_, ok := k.keystores[id]
+	k.mutex.RUnlock()
+
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "keystore %s not found", id)
+	}
+
+	return id, ok
+}
+
+// key returns the ID and handle of the key of the request, writing a not found response if it does not exist in
+// the keystore of the request.
+func (k *keyServer) key(w http.ResponseWriter, r *http.Request) (string, interface{}, bool) {
+	keystoreID, keyID := mux.Vars(r)["keystoreID"], mux.Vars(r)["keyID"]
+
+	k.mutex.RLock()
+	owner, ok := k.keys[keyID]
+	k.mutex.RUnlock()
+
+	if !ok || owner != keystoreID {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "key %s not found in keystore %s", keyID, keystoreID)
+
+		return "", nil, false
+	}
+
+	kh, err := k.kms.Get(keyID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to get key %s: %s", keyID, err)
+
+		return "", nil, false
+	}
+
+	return keyID, kh, true
+}
+
+func (p *publicKey) toPublicKey() (*cryptoapi.PublicKey, error) {
+	fields, err := decodeEach(p.KID, p.X, p.Y, p.Curve, p.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cryptoapi.PublicKey{
+		KID:   string(fields[0]),
+		X:     fields[1],
+		Y:     fields[2],
+		Curve: string(fields[3]),
+		Type:  string(fields[4]),
+	}, nil
+}
+
+func fromPublicKey(key *cryptoapi.PublicKey) publicKey {
+	return publicKey{
+		KID:   base64.URLEncoding.EncodeToString([]byte(key.KID)),
+		X:     base64.URLEncoding.EncodeToString(key.X),
+		Y:     base64.URLEncoding.EncodeToString(key.Y),
+		Curve: base64.URLEncoding.EncodeToString([]byte(key.Curve)),
+		Type:  base64.URLEncoding.EncodeToString([]byte(key.Type)),
+	}
+}
+
+func (k *wrappedKey) toWrappedKey() (*cryptoapi.RecipientWrappedKey, error) {
+	epk, err := k.EPK.toPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := decodeEach(k.KID, k.EncryptedCEK, k.Alg, k.APU, k.APV)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cryptoapi.RecipientWrappedKey{
+		KID:          string(fields[0]),
+		EncryptedCEK: fields[1],
+		EPK:          *epk,
+		Alg:          string(fields[2]),
+		APU:          fields[3],
+		APV:          fields[4],
+	}, nil
+}
+
+func fromWrappedKey(key *cryptoapi.RecipientWrappedKey) wrappedKey {
+	return wrappedKey{
+		KID:          base64.URLEncoding.EncodeToString([]byte(key.KID)),
+		EncryptedCEK: base64.URLEncoding.EncodeToString(key.EncryptedCEK),
+		EPK:          fromPublicKey(&key.EPK),
+		Alg:          base64.URLEncoding.EncodeToString([]byte(key.Alg)),
+		APU:          base64.URLEncoding.EncodeToString(key.APU),
+		APV:          base64.URLEncoding.EncodeToString(key.APV),
+	}
+}
+
+func decode(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err)
+
+		return false
+	}
+
+	return true
+}
+
+func decodeBytes(w http.ResponseWriter, value string) ([]byte, bool) {
+	b, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid base64 value: %s", err)
+
+		return nil, false
+	}
+
+	return b, true
+}
+
+func decodeEach(values ...string) ([][]byte, error) {
+	decoded := make([][]byte, len(values))
+
+	for i, value := range values {
+		b, err := base64.URLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 value: %w", err)
+		}
+
+		decoded[i] = b
+	}
+
+	return decoded, nil
+}
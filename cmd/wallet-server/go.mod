@@ -12,12 +12,16 @@ require (
 	github.com/duo-labs/webauthn v0.0.0-20200714211715-1daaee874e43
 	github.com/google/uuid v1.1.2
 	github.com/gorilla/mux v1.8.0
+	github.com/hyperledger/aries-framework-go v0.1.5-0.20201124194436-a37f1c10fd4e
 	github.com/pquerna/cachecontrol v0.0.0-20200819021114-67c6ae64274f // indirect
 	github.com/rs/cors v1.7.0
 	github.com/spf13/cobra v0.0.6
 	github.com/stretchr/testify v1.6.1
 	github.com/trustbloc/edge-agent v0.0.0-00010101000000-000000000000
 	github.com/trustbloc/edge-core v0.1.5-0.20201126210935-53388acb41fc
+	github.com/trustbloc/edv v0.1.5-0.20201129165709-60c7f39d8096
+	golang.org/x/oauth2 v0.0.0-20200902213428-5d25da1a8d43
+	gopkg.in/yaml.v2 v2.2.8
 )
 
 replace github.com/trustbloc/edge-agent => ../..
@@ -8,6 +8,7 @@ package main
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/cmd/wallet-server/checkcmd"
 	"github.com/trustbloc/edge-agent/cmd/wallet-server/startcmd"
 	"github.com/trustbloc/edge-core/pkg/log"
 )
@@ -23,6 +24,7 @@ func main() {
 	}
 
 	rootCmd.AddCommand(startcmd.GetStartCmd(&startcmd.HTTPServer{}))
+	rootCmd.AddCommand(checkcmd.GetCheckUserCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		logger.Fatalf("Failed to run http server: %s", err.Error())
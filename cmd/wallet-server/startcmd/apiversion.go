@@ -0,0 +1,93 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// API versioning config.
+const (
+	apiLegacyRoutesFlagName  = "api-legacy-routes"
+	apiLegacyRoutesFlagUsage = "Optional. Set to false to serve the REST API under its version prefix only, e.g." +
+		" /v1/oidc/login. The routes without prefix are served as version 1, or as the version of the API-Version" +
+		" header, with deprecation headers otherwise. Defaults to true." +
+		" Alternatively, this can be set with the following environment variable: " + apiLegacyRoutesEnvKey
+	apiLegacyRoutesEnvKey = "HTTP_SERVER_API_LEGACY_ROUTES"
+)
+
+func createAPIVersioningFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(apiLegacyRoutesFlagName, "", "", apiLegacyRoutesFlagUsage)
+}
+
+func getAPIVersioningConfig(cmd *cobra.Command) (*common.VersioningConfig, error) {
+	config := &common.VersioningConfig{Legacy: true, Unversioned: unversionedPaths()}
+
+	legacy := cmdutils.GetUserSetOptionalVarFromString(cmd, apiLegacyRoutesFlagName, apiLegacyRoutesEnvKey)
+	if legacy == "" {
+		return config, nil
+	}
+
+	var err error
+
+	config.Legacy, err = strconv.ParseBool(legacy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value '%s': %w", apiLegacyRoutesFlagName, legacy, err)
+	}
+
+	return config, nil
+}
+
+// unversionedPaths are the paths outside of the wallet API, which are not versioned: the healthcheck, the OpenAPI
+// document and the DIDComm endpoint of the other agents.
+func unversionedPaths() []string {
+	return []string{healthCheckPath, openAPIPath, didcommPath}
+}
+
+// versioned negotiates the version of the API of the requests, serving the legacy routes unless configured
+// otherwise.
+func versioned(root http.Handler, config *common.VersioningConfig) http.Handler {
+	if config == nil {
+		config = &common.VersioningConfig{Legacy: true, Unversioned: unversionedPaths()}
+	}
+
+	return common.Versioning(root, config)
+}
+
+// versionMatcher only matches the requests of the versions of the API the handler serves, so that the handlers of
+// the other versions of its path are tried next.
+func versionMatcher(handler common.Handler) mux.MatcherFunc {
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		return common.SupportsVersion(handler, common.APIVersion(r))
+	}
+}
+
+// documentedPaths are the paths of the handler in the OpenAPI document, one per version of the API it serves.
+func documentedPaths(handler common.Handler, path string) []string {
+	for _, unversioned := range unversionedPaths() {
+		if path == unversioned {
+			return []string{path}
+		}
+	}
+
+	var paths []string
+
+	for version := 1; version <= common.LatestAPIVersion; version++ {
+		if common.SupportsVersion(handler, version) {
+			paths = append(paths, common.VersionPrefix(version)+path)
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,111 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/authz"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Authorization policy config.
+const (
+	authzPolicyFlagName  = "authorization-policy"
+	authzPolicyFlagUsage = "Optional. Path of a YAML or JSON file of the rules the users must meet to call the" +
+		" endpoints, by path prefix and method: a login, attributes of the user, e.g. a group, or a recent" +
+		" authentication of a high assurance level, e.g. to export the wallet. The admin endpoints are authorized" +
+		" too, once the admin token is checked." +
+		" Alternatively, this can be set with the following environment variable: " + authzPolicyEnvKey
+	authzPolicyEnvKey = "HTTP_SERVER_AUTHORIZATION_POLICY"
+
+	authzOPAURLFlagName  = "authorization-opa-url"
+	authzOPAURLFlagUsage = "Optional. URL of the rule of an Open Policy Agent deciding whether the users may call" +
+		" the endpoints, e.g. http://opa:8181/v1/data/edgeagent/authz. The requests must also meet the rules of " +
+		authzPolicyFlagName + " if set." +
+		" Alternatively, this can be set with the following environment variable: " + authzOPAURLEnvKey
+	authzOPAURLEnvKey = "HTTP_SERVER_AUTHORIZATION_OPA_URL"
+)
+
+// the policy decisions hold up the requests.
+const authzOPATimeout = 5 * time.Second
+
+type authzParameters struct {
+	rules  *authz.Rules
+	opaURL string
+}
+
+func createAuthzFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(authzPolicyFlagName, "", "", authzPolicyFlagUsage)
+	cmd.Flags().StringP(authzOPAURLFlagName, "", "", authzOPAURLFlagUsage)
+}
+
+// getAuthzParams returns nil if the endpoints are not authorized by policy.
+func getAuthzParams(cmd *cobra.Command) (*authzParameters, error) {
+	policy := cmdutils.GetUserSetOptionalVarFromString(cmd, authzPolicyFlagName, authzPolicyEnvKey)
+	opaURL := cmdutils.GetUserSetOptionalVarFromString(cmd, authzOPAURLFlagName, authzOPAURLEnvKey)
+
+	if policy == "" && opaURL == "" {
+		return nil, nil
+	}
+
+	params := &authzParameters{opaURL: opaURL}
+
+	if opaURL != "" {
+		u, err := url.Parse(opaURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid %s '%s': must be an HTTP URL", authzOPAURLFlagName, opaURL)
+		}
+	}
+
+	if policy != "" {
+		rules, err := authz.Load(policy)
+		if err != nil {
+			return nil, err
+		}
+
+		params.rules = rules
+	}
+
+	return params, nil
+}
+
+// authorization returns the middleware authorizing the users of the sessions and bearer tokens by policy, or nil if
+// not configured.
+func authorization(config *httpServerParameters, users user.Store) common.Middleware {
+	if config.authz == nil {
+		return nil
+	}
+
+	var engines []authz.Engine
+
+	if config.authz.rules != nil {
+		engines = append(engines, config.authz.rules)
+	}
+
+	if config.authz.opaURL != "" {
+		engines = append(engines, authz.NewOPA(config.authz.opaURL, &http.Client{
+			Timeout: authzOPATimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: config.tls.config,
+				Proxy:           config.proxy,
+			},
+		}))
+	}
+
+	cookies := cookie.NewStore(nil, nil, cookie.WithKeyRing(config.keys.sessionCookieKeys))
+
+	return oidc.Authorization(authz.All(engines...), users, cookies)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/bearer"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/binding"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Bearer token config.
+const (
+	bearerTokenValidationFlagName  = "bearer-token-validation"
+	bearerTokenValidationFlagUsage = "Optional. Lets non-browser clients call the API with an OAuth2 access token" +
+		" of the OIDC provider in an 'Authorization: Bearer' header. Either 'introspection', to validate the tokens" +
+		" with the token introspection endpoint of the provider, or 'jwt', to validate JWT tokens with the signing" +
+		" keys of the provider. Bearer tokens are not accepted if not set." +
+		" Alternatively, this can be set with the following environment variable: " + bearerTokenValidationEnvKey
+	bearerTokenValidationEnvKey = "HTTP_SERVER_BEARER_TOKEN_VALIDATION"
+
+	bearerIntrospectionURLFlagName  = "bearer-introspection-url"
+	bearerIntrospectionURLFlagUsage = "URL of the token introspection endpoint of the OIDC provider." +
+		" The agent authenticates with its OIDC client credentials. Required for 'introspection' validation." +
+		" Alternatively, this can be set with the following environment variable: " + bearerIntrospectionURLEnvKey
+	bearerIntrospectionURLEnvKey = "HTTP_SERVER_BEARER_INTROSPECTION_URL"
+
+	bearerAudienceFlagName  = "bearer-audience"
+	bearerAudienceFlagUsage = "Optional. Audience the JWT tokens must be issued for with 'jwt' validation." +
+		" Defaults to the OIDC client ID of the agent, so that the tokens the provider issued to other clients," +
+		" such as their id_tokens, are rejected." +
+		" Alternatively, this can be set with the following environment variable: " + bearerAudienceEnvKey
+	bearerAudienceEnvKey = "HTTP_SERVER_BEARER_AUDIENCE"
+
+	introspectionValidation = "introspection"
+	jwtValidation           = "jwt"
+)
+
+type bearerParameters struct {
+	validation       string
+	introspectionURL string
+	audience         string
+}
+
+func createBearerFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(bearerTokenValidationFlagName, "", "", bearerTokenValidationFlagUsage)
+	cmd.Flags().StringP(bearerIntrospectionURLFlagName, "", "", bearerIntrospectionURLFlagUsage)
+	cmd.Flags().StringP(bearerAudienceFlagName, "", "", bearerAudienceFlagUsage)
+}
+
+// getBearerParams returns nil if bearer tokens are not accepted.
+func getBearerParams(cmd *cobra.Command) (*bearerParameters, error) {
+	params := &bearerParameters{
+		validation: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			bearerTokenValidationFlagName, bearerTokenValidationEnvKey),
+		introspectionURL: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			bearerIntrospectionURLFlagName, bearerIntrospectionURLEnvKey),
+		audience: cmdutils.GetUserSetOptionalVarFromString(cmd, bearerAudienceFlagName, bearerAudienceEnvKey),
+	}
+
+	switch params.validation {
+	case "":
+		return nil, nil
+	case jwtValidation:
+	case introspectionValidation:
+		if params.introspectionURL == "" {
+			return nil, fmt.Errorf("%s must be set for introspection validation", bearerIntrospectionURLFlagName)
+		}
+	default:
+		return nil, fmt.Errorf("invalid bearerTokenValidation value '%s': must be %s or %s",
+			params.validation, introspectionValidation, jwtValidation)
+	}
+
+	return params, nil
+}
+
+// apiMiddleware returns the middleware of the handlers serving the wallet users: the middleware of the server,
+// followed by the login of the remembered users, the end of the sessions of the deactivated users, the session
+// binding, the validation of bearer tokens, refused to the deactivated users, the authorization by policy and the
+// replay of the retried requests when enabled.
+func apiMiddleware(config *httpServerParameters, provider oidc2.Provider, store storage.Provider,
+	remembered *remember.Store, binder *binding.Binder, users user.Store) ([]common.Middleware, error) {
+	middleware := make([]common.Middleware, 0, len(config.middleware)+6)
+	middleware = append(middleware, config.middleware...)
+
+	cookies := cookie.NewStore(nil, nil, cookie.WithKeyRing(config.keys.sessionCookieKeys))
+
+	if remembered != nil {
+		middleware = append(middleware, remember.Middleware(remembered, cookies))
+	}
+
+	middleware = append(middleware, oidc.DeactivatedSessions(users, cookies))
+
+	if binder != nil {
+		middleware = append(middleware, binder.Middleware(cookies))
+	}
+
+	if config.bearer != nil {
+		bearerValidation, err := bearerMiddleware(config, provider, users)
+		if err != nil {
+			return nil, err
+		}
+
+		middleware = append(middleware, bearerValidation)
+	}
+
+	// the users of the bearer tokens are authorized too, and the requests denied are not recorded for their retries
+	if authorize := authorization(config, users); authorize != nil {
+		middleware = append(middleware, authorize)
+	}
+
+	// the retries are told apart by the user of their session or bearer token
+	if config.idempotencyTTL > 0 {
+		idempotency, err := oidc.Idempotency(store, cookies, config.idempotencyTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		middleware = append(middleware, idempotency)
+	}
+
+	return middleware, nil
+}
+
+// bearerMiddleware validates the bearer tokens of the requests, and rejects those of the deactivated users.
+func bearerMiddleware(config *httpServerParameters, provider oidc2.Provider,
+	users user.Store) (common.Middleware, error) {
+	var introspector bearer.Introspector
+
+	switch config.bearer.validation {
+	case introspectionValidation:
+		tokenIntrospector := bearer.NewTokenIntrospector(&bearer.IntrospectionConfig{
+			Endpoint:     config.bearer.introspectionURL,
+			ClientID:     config.oidc.clientID,
+			ClientSecret: config.oidc.clientSecret,
+			HTTPClient: &http.Client{Transport: &http.Transport{
+				TLSClientConfig: config.tls.config,
+				Proxy:           config.proxy,
+			}},
+		})
+
+		err := watchClientSecret(config.secrets, tokenIntrospector.SetClientSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		introspector = tokenIntrospector
+	default:
+		introspector = bearer.NewJWTValidator(provider, bearerAudience(config))
+	}
+
+	return bearer.Middleware(oidc.DeactivatedBearers(introspector, users)), nil
+}
+
+// bearerAudience returns the audience of the JWT bearer tokens, the OIDC client ID of the agent by default.
+func bearerAudience(config *httpServerParameters) string {
+	if config.bearer.audience != "" {
+		return config.bearer.audience
+	}
+
+	return config.oidc.clientID
+}
@@ -0,0 +1,167 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/binding"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Session binding config.
+const (
+	sessionBindingFlagName  = "session-binding"
+	sessionBindingFlagUsage = "Optional. Attributes of the clients the sessions of the users are bound to when" +
+		" they log in: " + bindUserAgent + ", " + bindNetwork + " (the network of the IP address of the client)" +
+		" and " + bindClientCertificate + " (the TLS client certificate). A session used by another client is" +
+		" reported and ended, so that the user authenticates again. The sessions are not bound if not set." +
+		" Alternatively, this can be set with the following environment variable (in CSV format): " +
+		sessionBindingEnvKey
+	sessionBindingEnvKey = "HTTP_SERVER_SESSION_BINDING"
+
+	sessionBindingModeFlagName  = "session-binding-mode"
+	sessionBindingModeFlagUsage = "Optional. " + bindingModeEnforce + " to end the sessions used by other clients," +
+		" or " + bindingModeReport + " to only report them, e.g. to try out the binding. Defaults to " +
+		bindingModeEnforce + "." +
+		" Alternatively, this can be set with the following environment variable: " + sessionBindingModeEnvKey
+	sessionBindingModeEnvKey = "HTTP_SERVER_SESSION_BINDING_MODE"
+
+	sessionBindingIPv4PrefixFlagName  = "session-binding-ipv4-prefix"
+	sessionBindingIPv4PrefixFlagUsage = "Optional. Length of the prefix of the IPv4 addresses bound to the" +
+		" sessions with " + bindNetwork + ", from 0 (not bound) to 32 (the address). Defaults to 24." +
+		" Alternatively, this can be set with the following environment variable: " + sessionBindingIPv4PrefixEnvKey
+	sessionBindingIPv4PrefixEnvKey = "HTTP_SERVER_SESSION_BINDING_IPV4_PREFIX"
+
+	sessionBindingIPv6PrefixFlagName  = "session-binding-ipv6-prefix"
+	sessionBindingIPv6PrefixFlagUsage = "Optional. Length of the prefix of the IPv6 addresses bound to the" +
+		" sessions with " + bindNetwork + ", from 0 (not bound) to 128 (the address). Defaults to 64." +
+		" Alternatively, this can be set with the following environment variable: " + sessionBindingIPv6PrefixEnvKey
+	sessionBindingIPv6PrefixEnvKey = "HTTP_SERVER_SESSION_BINDING_IPV6_PREFIX"
+)
+
+// Bound attributes.
+const (
+	bindUserAgent         = "user-agent"
+	bindNetwork           = "network"
+	bindClientCertificate = "client-certificate"
+)
+
+// Binding modes.
+const (
+	bindingModeEnforce = "enforce"
+	bindingModeReport  = "report"
+)
+
+const (
+	defaultIPv4Prefix = 24
+	defaultIPv6Prefix = 64
+)
+
+func createSessionBindingFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP(sessionBindingFlagName, "", []string{}, sessionBindingFlagUsage)
+	cmd.Flags().StringP(sessionBindingModeFlagName, "", "", sessionBindingModeFlagUsage)
+	cmd.Flags().StringP(sessionBindingIPv4PrefixFlagName, "", "", sessionBindingIPv4PrefixFlagUsage)
+	cmd.Flags().StringP(sessionBindingIPv6PrefixFlagName, "", "", sessionBindingIPv6PrefixFlagUsage)
+}
+
+// getSessionBindingConfig returns nil if the sessions are not bound. The audit log and events are set by the
+// router.
+func getSessionBindingConfig(cmd *cobra.Command) (*binding.Config, error) {
+	attributes, err := cmdutils.GetUserSetVarFromArrayString(cmd, sessionBindingFlagName, sessionBindingEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+
+	config := &binding.Config{}
+
+	for _, attribute := range attributes {
+		switch attribute {
+		case bindUserAgent:
+			config.UserAgent = true
+		case bindNetwork:
+			config.IPv4PrefixLength, err = getPrefixLength(cmd, sessionBindingIPv4PrefixFlagName,
+				sessionBindingIPv4PrefixEnvKey, defaultIPv4Prefix)
+			if err != nil {
+				return nil, err
+			}
+
+			config.IPv6PrefixLength, err = getPrefixLength(cmd, sessionBindingIPv6PrefixFlagName,
+				sessionBindingIPv6PrefixEnvKey, defaultIPv6Prefix)
+			if err != nil {
+				return nil, err
+			}
+		case bindClientCertificate:
+			config.ClientCertificate = true
+		default:
+			return nil, fmt.Errorf("invalid %s value '%s': must be %s, %s or %s",
+				sessionBindingFlagName, attribute, bindUserAgent, bindNetwork, bindClientCertificate)
+		}
+	}
+
+	switch mode := cmdutils.GetUserSetOptionalVarFromString(cmd,
+		sessionBindingModeFlagName, sessionBindingModeEnvKey); mode {
+	case "", bindingModeEnforce:
+	case bindingModeReport:
+		config.ReportOnly = true
+	default:
+		return nil, fmt.Errorf("invalid %s value '%s': must be %s or %s",
+			sessionBindingModeFlagName, mode, bindingModeEnforce, bindingModeReport)
+	}
+
+	config.TrustForwardedFor, err = getTrustForwardedFor(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// fails early on invalid prefix lengths
+	_, err = binding.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", sessionBindingFlagName, err)
+	}
+
+	return config, nil
+}
+
+func getPrefixLength(cmd *cobra.Command, flagName, envKey string, defaultLength int) (int, error) {
+	value := cmdutils.GetUserSetOptionalVarFromString(cmd, flagName, envKey)
+	if value == "" {
+		return defaultLength, nil
+	}
+
+	length, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s value '%s': %w", flagName, value, err)
+	}
+
+	return length, nil
+}
+
+// sessionBinder returns nil if the sessions are not bound.
+func sessionBinder(config *binding.Config, auditLog *audit.Store, events outbox.Dispatcher) (*binding.Binder, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	config.Audit = auditLog
+	config.Events = events
+
+	binder, err := binding.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init session binding: %w", err)
+	}
+
+	return binder, nil
+}
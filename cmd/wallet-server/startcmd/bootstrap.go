@@ -0,0 +1,93 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Bootstrap data signing config.
+const (
+	bootstrapSigningFlagName  = "bootstrap-signing"
+	bootstrapSigningFlagUsage = "Optional. Set to true to sign the bootstrap data of the users with a key of the" +
+		" agent, see " + agentSigningKeysFlagName + ", and to verify the signature when the data is read back from" +
+		" hub-auth. Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + bootstrapSigningEnvKey
+	bootstrapSigningEnvKey = "HTTP_SERVER_BOOTSTRAP_SIGNING"
+
+	bootstrapSignatureRequiredFlagName  = "bootstrap-signature-required"
+	bootstrapSignatureRequiredFlagUsage = "Optional. Set to true to reject the bootstrap data posted before it was" +
+		" signed, once the data of all the users is. Requires " + bootstrapSigningFlagName + ". Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + bootstrapSignatureRequiredEnvKey
+	bootstrapSignatureRequiredEnvKey = "HTTP_SERVER_BOOTSTRAP_SIGNATURE_REQUIRED"
+)
+
+type bootstrapSigningParameters struct {
+	require bool
+}
+
+func createBootstrapSigningFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(bootstrapSigningFlagName, "", "", bootstrapSigningFlagUsage)
+	cmd.Flags().StringP(bootstrapSignatureRequiredFlagName, "", "", bootstrapSignatureRequiredFlagUsage)
+}
+
+// getBootstrapSigningParams returns nil if the bootstrap data is not signed.
+func getBootstrapSigningParams(cmd *cobra.Command) (*bootstrapSigningParameters, error) {
+	enabled, err := getOptionalBool(cmd, bootstrapSigningFlagName, bootstrapSigningEnvKey)
+	if err != nil {
+		return nil, err
+	}
+
+	require, err := getOptionalBool(cmd, bootstrapSignatureRequiredFlagName, bootstrapSignatureRequiredEnvKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !enabled {
+		if require {
+			return nil, fmt.Errorf("%s requires %s", bootstrapSignatureRequiredFlagName, bootstrapSigningFlagName)
+		}
+
+		return nil, nil
+	}
+
+	return &bootstrapSigningParameters{require: require}, nil
+}
+
+func getOptionalBool(cmd *cobra.Command, flagName, envKey string) (bool, error) {
+	value := cmdutils.GetUserSetOptionalVarFromString(cmd, flagName, envKey)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value '%s': %w", flagName, value, err)
+	}
+
+	return b, nil
+}
+
+// bootstrapSigning returns nil if the bootstrap data is not signed.
+func bootstrapSigning(config *httpServerParameters, store storage.Provider) (*oidc.BootstrapSigningConfig, error) {
+	if config.bootstrapSigning == nil {
+		return nil, nil
+	}
+
+	signer, err := newAgentSigner(config, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init bootstrap data signer: %w", err)
+	}
+
+	return &oidc.BootstrapSigningConfig{Signer: signer, Require: config.bootstrapSigning.require}, nil
+}
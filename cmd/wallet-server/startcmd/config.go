@@ -0,0 +1,315 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+	"gopkg.in/yaml.v2"
+)
+
+// Config file.
+const (
+	configFileFlagName  = "config-file"
+	configFileFlagUsage = "Optional. Path to a YAML or JSON file setting options by their flag names," +
+		" e.g. 'oidc-opurl: https://issuer.example.com'. Options set on the command line or with their" +
+		" environment variables take precedence over the file. The " + agentLogLevelFlagName + ", " +
+		agentUIURLFlagName + " and " + corsAllowedOriginsFlagName + " options are reloaded from the file on SIGHUP." +
+		" Alternatively, this can be set with the following environment variable: " + configFileEnvKey
+	configFileEnvKey = "HTTP_SERVER_CONFIG_FILE"
+
+	// every flag names its environment variable at the end of its usage.
+	envKeyUsagePrefix = "Alternatively, this can be set with the following environment variable: "
+)
+
+// options reloaded from the config file at runtime.
+var reloadableOptions = map[string]bool{ // nolint:gochecknoglobals // constant set
+	agentLogLevelFlagName:      true,
+	agentUIURLFlagName:         true,
+	corsAllowedOriginsFlagName: true,
+}
+
+type configFile struct {
+	path string
+	cmd  *cobra.Command
+	// options set on the command line, which the file does not override
+	cliFlags map[string]bool
+	values   map[string][]string
+}
+
+func createConfigFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(configFileFlagName, "", "", configFileFlagUsage)
+}
+
+// loadConfigFile sets the options of the config file that are set neither on the command line nor with their
+// environment variables. It returns nil if there is no config file.
+func loadConfigFile(cmd *cobra.Command) (*configFile, error) {
+	path := cmdutils.GetUserSetOptionalVarFromString(cmd, configFileFlagName, configFileEnvKey)
+	if path == "" {
+		return nil, nil
+	}
+
+	c := &configFile{
+		path:     path,
+		cmd:      cmd,
+		cliFlags: make(map[string]bool),
+	}
+
+	for name := range reloadableOptions {
+		c.cliFlags[name] = cmd.Flags().Changed(name)
+	}
+
+	values, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range values {
+		c.cliFlags[name] = cmd.Flags().Changed(name)
+
+		if c.overridden(name) {
+			continue
+		}
+
+		for _, v := range value {
+			err = cmd.Flags().Set(name, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value '%s' in %s: %w", name, v, path, err)
+			}
+		}
+	}
+
+	c.values = values
+
+	return c, nil
+}
+
+// read returns the values of the options in the file, by their flag names.
+func (c *configFile) read() (map[string][]string, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(c.path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// JSON documents are YAML documents too
+	raw := make(map[string]interface{})
+
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", c.path, err)
+	}
+
+	values := make(map[string][]string, len(raw))
+
+	for name, value := range raw {
+		if name == configFileFlagName || c.cmd.Flags().Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown option %s in config file %s", name, c.path)
+		}
+
+		switch v := value.(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range v {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+		case map[interface{}]interface{}:
+			return nil, fmt.Errorf("option %s in config file %s must be a value or a list of values", name, c.path)
+		default:
+			values[name] = []string{fmt.Sprint(v)}
+		}
+	}
+
+	return values, nil
+}
+
+// overridden tells whether the option is set on the command line or with its environment variable.
+func (c *configFile) overridden(name string) bool {
+	if c.cliFlags[name] {
+		return true
+	}
+
+	_, isSet := os.LookupEnv(envKey(c.cmd, name))
+
+	return isSet
+}
+
+// lookup returns the value of the option, taking the command line first, then the environment and then the file.
+func (c *configFile) lookup(name string) []string {
+	if c.cliFlags[name] {
+		if values, err := c.cmd.Flags().GetStringArray(name); err == nil {
+			return values
+		}
+
+		value, err := c.cmd.Flags().GetString(name)
+		if err != nil {
+			return nil
+		}
+
+		return []string{value}
+	}
+
+	if value, isSet := os.LookupEnv(envKey(c.cmd, name)); isSet {
+		if value == "" {
+			return nil
+		}
+
+		return strings.Split(value, ",")
+	}
+
+	return c.values[name]
+}
+
+// reload re-reads the file. Only the reloadable options take effect; changes to the others are logged, as they
+// need a restart.
+func (c *configFile) reload() error {
+	values, err := c.read()
+	if err != nil {
+		return err
+	}
+
+	for name := range union(values, c.values) {
+		if !reloadableOptions[name] && !reflect.DeepEqual(values[name], c.values[name]) {
+			logger.Warnf("option %s changed in %s: restart the server to apply it", name, c.path)
+		}
+	}
+
+	c.values = values
+
+	return nil
+}
+
+// watch calls apply on every SIGHUP.
+func (c *configFile) watch(apply func() error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			err := apply()
+			if err != nil {
+				logger.Errorf("failed to reload config file %s: %s", c.path, err.Error())
+
+				continue
+			}
+
+			logger.Infof("reloaded config file %s", c.path)
+		}
+	}()
+}
+
+// reloadSettings re-reads the config file, and applies its log level, agent UI URL and CORS allowed origins.
+func reloadSettings(parameters *httpServerParameters, cors *corsSwitch) error {
+	config := parameters.config
+
+	err := config.reload()
+	if err != nil {
+		return err
+	}
+
+	agentUIURL := first(config.lookup(agentUIURLFlagName))
+	if agentUIURL == "" {
+		return fmt.Errorf("%s must be set", agentUIURLFlagName)
+	}
+
+	err = setLogLevel(first(config.lookup(agentLogLevelFlagName)))
+	if err != nil {
+		return fmt.Errorf("failed to set log level: %w", err)
+	}
+
+	for _, setDashboard := range parameters.dashboards {
+		setDashboard(agentUIURL + "/dashboard")
+	}
+
+	origins := config.lookup(corsAllowedOriginsFlagName)
+	if len(origins) == 0 {
+		origins = []string{agentUIURL}
+	}
+
+	cors.setAllowedOrigins(origins)
+
+	return nil
+}
+
+// validateParameters reports all the invalid options at once.
+func validateParameters(parameters *httpServerParameters) error {
+	urls := []struct {
+		name     string
+		value    string
+		optional bool
+	}{
+		{name: oidcProviderURLFlagName, value: parameters.oidc.providerURL},
+		{name: oidcCallbackURLFlagName, value: parameters.oidc.callbackURL},
+		{name: authzKMSURLFlagName, value: parameters.keyServer.authzKMSURL},
+		{name: opsKMSURLFlagName, value: parameters.keyServer.opsKMSURL},
+		{name: keyEDVURLFlagName, value: parameters.keyServer.keyEDVURL},
+		{name: userEDVURLFlagName, value: parameters.userEDVURL, optional: true},
+		{name: hubAuthURLFlagName, value: parameters.hubAuthURL},
+	}
+
+	var problems []string
+
+	for _, u := range urls {
+		if u.value == "" && u.optional {
+			continue
+		}
+
+		parsed, err := url.Parse(u.value)
+		if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s '%s' is not an absolute URL", u.name, u.value))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// envKey returns the environment variable of the flag, as named by its usage.
+func envKey(cmd *cobra.Command, name string) string {
+	usage := cmd.Flags().Lookup(name).Usage
+
+	i := strings.LastIndex(usage, envKeyUsagePrefix)
+	if i < 0 {
+		return ""
+	}
+
+	return usage[i+len(envKeyUsagePrefix):]
+}
+
+func union(a, b map[string][]string) map[string]bool {
+	names := make(map[string]bool, len(a)+len(b))
+
+	for name := range a {
+		names[name] = true
+	}
+
+	for name := range b {
+		names[name] = true
+	}
+
+	return names
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
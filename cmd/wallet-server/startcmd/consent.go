@@ -0,0 +1,49 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Consent config.
+const (
+	consentVersionFlagName  = "consent-version"
+	consentVersionFlagUsage = "Optional. Version of the terms of service and privacy policy the users must accept" +
+		" before they are onboarded. Users who accepted a previous version are prompted again." +
+		" Consent is not required if not set." +
+		" Alternatively, this can be set with the following environment variable: " + consentVersionEnvKey
+	consentVersionEnvKey = "HTTP_SERVER_CONSENT_VERSION"
+
+	consentPageURLFlagName  = "consent-page-url"
+	consentPageURLFlagUsage = "Optional. Page of the wallet UI asking the users for their consent." +
+		" Defaults to the consent page of the agent UI." +
+		" Alternatively, this can be set with the following environment variable: " + consentPageURLEnvKey
+	consentPageURLEnvKey = "HTTP_SERVER_CONSENT_PAGE_URL"
+)
+
+func createConsentFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(consentVersionFlagName, "", "", consentVersionFlagUsage)
+	cmd.Flags().StringP(consentPageURLFlagName, "", "", consentPageURLFlagUsage)
+}
+
+// getConsentConfig returns nil if consent is not required.
+func getConsentConfig(cmd *cobra.Command, agentUIURL string) *oidc.ConsentConfig {
+	version := cmdutils.GetUserSetOptionalVarFromString(cmd, consentVersionFlagName, consentVersionEnvKey)
+	if version == "" {
+		return nil
+	}
+
+	pageURL := cmdutils.GetUserSetOptionalVarFromString(cmd, consentPageURLFlagName, consentPageURLEnvKey)
+	if pageURL == "" {
+		pageURL = agentUIURL + "/consent"
+	}
+
+	return &oidc.ConsentConfig{Version: version, PageURL: pageURL}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/rs/cors"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// CORS config.
+const (
+	corsAllowedOriginsFlagName  = "cors-allowed-origins"
+	corsAllowedOriginsFlagUsage = "Optional. Origins allowed to call the API from a browser, '*' allowing any origin." +
+		" Defaults to the agent UI URL." +
+		" Alternatively, this can be set with the following environment variable: " + corsAllowedOriginsEnvKey
+	corsAllowedOriginsEnvKey = "HTTP_SERVER_CORS_ALLOWED_ORIGINS"
+
+	corsAllowedMethodsFlagName  = "cors-allowed-methods"
+	corsAllowedMethodsFlagUsage = "Optional. HTTP methods allowed in cross-origin requests." +
+		" Defaults to GET, POST and DELETE." +
+		" Alternatively, this can be set with the following environment variable: " + corsAllowedMethodsEnvKey
+	corsAllowedMethodsEnvKey = "HTTP_SERVER_CORS_ALLOWED_METHODS"
+
+	corsAllowCredentialsFlagName  = "cors-allow-credentials"
+	corsAllowCredentialsFlagUsage = "Optional. Whether cross-origin requests may carry the session cookie." +
+		" Defaults to true." +
+		" Alternatively, this can be set with the following environment variable: " + corsAllowCredentialsEnvKey
+	corsAllowCredentialsEnvKey = "HTTP_SERVER_CORS_ALLOW_CREDENTIALS"
+
+	corsMaxAgeFlagName  = "cors-max-age"
+	corsMaxAgeFlagUsage = "Optional. Number of seconds browsers may cache the response to a preflight request." +
+		" Defaults to 600." +
+		" Alternatively, this can be set with the following environment variable: " + corsMaxAgeEnvKey
+	corsMaxAgeEnvKey  = "HTTP_SERVER_CORS_MAX_AGE"
+	corsMaxAgeDefault = 600
+)
+
+type corsParameters struct {
+	allowedOrigins   []string
+	allowedMethods   []string
+	allowCredentials bool
+	maxAge           int
+}
+
+func createCORSFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP(corsAllowedOriginsFlagName, "", []string{}, corsAllowedOriginsFlagUsage)
+	cmd.Flags().StringArrayP(corsAllowedMethodsFlagName, "", []string{}, corsAllowedMethodsFlagUsage)
+	cmd.Flags().StringP(corsAllowCredentialsFlagName, "", "", corsAllowCredentialsFlagUsage)
+	cmd.Flags().StringP(corsMaxAgeFlagName, "", "", corsMaxAgeFlagUsage)
+}
+
+func getCORSParams(cmd *cobra.Command, agentUIURL string) (*corsParameters, error) {
+	params := &corsParameters{
+		allowedOrigins:   []string{agentUIURL},
+		allowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+		allowCredentials: true,
+		maxAge:           corsMaxAgeDefault,
+	}
+
+	origins, err := cmdutils.GetUserSetVarFromArrayString(cmd, corsAllowedOriginsFlagName, corsAllowedOriginsEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure CORS allowed origins: %w", err)
+	}
+
+	if len(origins) > 0 {
+		params.allowedOrigins = origins
+	}
+
+	methods, err := cmdutils.GetUserSetVarFromArrayString(cmd, corsAllowedMethodsFlagName, corsAllowedMethodsEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure CORS allowed methods: %w", err)
+	}
+
+	if len(methods) > 0 {
+		params.allowedMethods = methods
+	}
+
+	credentials := cmdutils.GetUserSetOptionalVarFromString(cmd, corsAllowCredentialsFlagName, corsAllowCredentialsEnvKey)
+	if credentials != "" {
+		params.allowCredentials, err = strconv.ParseBool(credentials)
+		if err != nil {
+			return nil, fmt.Errorf("invalid corsAllowCredentials value '%s': %w", credentials, err)
+		}
+	}
+
+	maxAge := cmdutils.GetUserSetOptionalVarFromString(cmd, corsMaxAgeFlagName, corsMaxAgeEnvKey)
+	if maxAge != "" {
+		params.maxAge, err = strconv.Atoi(maxAge)
+		if err != nil || params.maxAge < 0 {
+			return nil, fmt.Errorf("invalid corsMaxAge value '%s': must be a non-negative integer", maxAge)
+		}
+	}
+
+	return params, nil
+}
+
+// corsHandler answers the preflight requests of the allowed origins and adds the CORS headers to their requests.
+func corsHandler(params *corsParameters, handler http.Handler) http.Handler {
+	return cors.New(cors.Options{
+		AllowedOrigins: params.allowedOrigins,
+		AllowedMethods: params.allowedMethods,
+		AllowedHeaders: []string{
+			"Origin", "Accept", "Content-Type", "X-Requested-With", "Authorization", oidc.IdempotencyKeyHeader,
+		},
+		// the wallet UI follows the Link headers of the pages of the lists
+		ExposedHeaders:   []string{oidc.IdempotentReplayedHeader, "Link"},
+		AllowCredentials: params.allowCredentials,
+		MaxAge:           params.maxAge,
+	}).Handler(handler)
+}
+
+// corsSwitch is a CORS handler whose allowed origins can change at runtime.
+type corsSwitch struct {
+	params  corsParameters
+	next    http.Handler
+	mutex   sync.RWMutex
+	handler http.Handler
+}
+
+func newCORSSwitch(params *corsParameters, next http.Handler) *corsSwitch {
+	return &corsSwitch{
+		params:  *params,
+		next:    next,
+		handler: corsHandler(params, next),
+	}
+}
+
+func (s *corsSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.RLock()
+	handler := s.handler
+	s.mutex.RUnlock()
+
+	handler.ServeHTTP(w, r)
+}
+
+func (s *corsSwitch) setAllowedOrigins(origins []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.params.allowedOrigins = origins
+	s.handler = corsHandler(&s.params, s.next)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/cmd/wallet-server/devmode"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Dev mode config.
+const (
+	devModeFlagName  = "dev-mode"
+	devModeFlagUsage = "Optional. Set to true to run in-process fakes of the OIDC provider, hub-auth, hub-kms and EDV," +
+		" which keep their data in memory and log every user in without credentials, for development and CI only." +
+		" They replace the OIDC provider, client, hub-auth, KMS and EDV options that are not set. Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + devModeEnvKey
+	devModeEnvKey = "HTTP_SERVER_DEV_MODE"
+
+	devModeHostFlagName  = "dev-mode-host"
+	devModeHostFlagUsage = "Optional. Address the fakes of the dev mode listen on, e.g. localhost:8077." +
+		" Defaults to a random port of localhost." +
+		" Alternatively, this can be set with the following environment variable: " + devModeHostEnvKey
+	devModeHostEnvKey = "HTTP_SERVER_DEV_MODE_HOST"
+
+	defaultDevModeHost  = "localhost:0"
+	devModeClientID     = "wallet-server"
+	devModeClientSecret = "dev-mode" // nolint:gosec // the fake OIDC provider does not check it
+)
+
+func createDevModeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(devModeFlagName, "", "", devModeFlagUsage)
+	cmd.Flags().StringP(devModeHostFlagName, "", "", devModeHostFlagUsage)
+}
+
+// startDevMode starts the fakes of the dev mode and points the options of the services they replace to them, unless
+// these are set. It returns nil if the dev mode is off.
+func startDevMode(cmd *cobra.Command) (*devmode.Services, error) {
+	enabled := cmdutils.GetUserSetOptionalVarFromString(cmd, devModeFlagName, devModeEnvKey)
+	if enabled == "" {
+		return nil, nil
+	}
+
+	on, err := strconv.ParseBool(enabled)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value '%s': %w", devModeFlagName, enabled, err)
+	}
+
+	if !on {
+		return nil, nil
+	}
+
+	host := cmdutils.GetUserSetOptionalVarFromString(cmd, devModeHostFlagName, devModeHostEnvKey)
+	if host == "" {
+		host = defaultDevModeHost
+	}
+
+	services, err := devmode.Start(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the dev mode: %w", err)
+	}
+
+	for _, option := range []struct {
+		flagName, envKey, value string
+	}{
+		{oidcProviderURLFlagName, oidcProviderURLEnvKey, services.OIDCProviderURL()},
+		{oidcClientIDFlagName, oidcClientIDEnvKey, devModeClientID},
+		{oidcClientSecretFlagName, oidcClientSecretEnvKey, devModeClientSecret},
+		{hubAuthURLFlagName, hubAuthURLEnvKey, services.HubAuthURL()},
+		{authzKMSURLFlagName, authzKMSURLEnvKey, services.KMSURL()},
+		{opsKMSURLFlagName, opsKMSURLEnvKey, services.KMSURL()},
+		{keyEDVURLFlagName, keyEDVURLEnvKey, services.EDVURL()},
+		{userEDVURLFlagName, userEDVURLEnvKey, services.EDVURL()},
+		{agentSigningKeysFlagName, agentSigningKeysEnvKey, localSigningKeys},
+	} {
+		if cmdutils.GetUserSetOptionalVarFromString(cmd, option.flagName, option.envKey) != "" {
+			continue
+		}
+
+		err = cmd.Flags().Set(option.flagName, option.value)
+		if err != nil {
+			closeDevMode(services)
+
+			return nil, fmt.Errorf("failed to set %s for the dev mode: %w", option.flagName, err)
+		}
+	}
+
+	return services, nil
+}
+
+func closeDevMode(services *devmode.Services) {
+	err := services.Close()
+	if err != nil {
+		logger.Warnf("failed to close the dev mode services: %s", err)
+	}
+}
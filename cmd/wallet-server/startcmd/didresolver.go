@@ -0,0 +1,189 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/httpbinding"
+	vdrkey "github.com/hyperledger/aries-framework-go/pkg/vdr/key"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/didresolver"
+	didresolverops "github.com/trustbloc/edge-agent/pkg/restapi/didresolver"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// DID resolver config.
+const (
+	didResolverURLFlagName  = "did-resolver-url"
+	didResolverURLFlagUsage = "Optional. URL of the universal resolver resolving the DIDs of the methods no driver" +
+		" accepts, e.g. https://resolver.example.com/1.0/identifiers. Only did:key, did:web and the methods of " +
+		didResolverDriversFlagName + " are resolved if not set." +
+		" Alternatively, this can be set with the following environment variable: " + didResolverURLEnvKey
+	didResolverURLEnvKey = "HTTP_SERVER_DID_RESOLVER_URL"
+
+	didResolverDriversFlagName  = "did-resolver-drivers"
+	didResolverDriversFlagUsage = "Optional. HTTP drivers of DID methods, as method=url, e.g." +
+		" orb=https://orb.example.com/sidetree/v1/identifiers. The DIDs of the method are resolved by appending" +
+		" them to the URL." +
+		" Alternatively, this can be set with the following environment variable: " + didResolverDriversEnvKey
+	didResolverDriversEnvKey = "HTTP_SERVER_DID_RESOLVER_DRIVERS"
+
+	didResolverCacheSizeFlagName  = "did-resolver-cache-size"
+	didResolverCacheSizeFlagUsage = "Optional. Number of DID documents cached. Set to 0 to disable the cache." +
+		" Defaults to 100." +
+		" Alternatively, this can be set with the following environment variable: " + didResolverCacheSizeEnvKey
+	didResolverCacheSizeEnvKey = "HTTP_SERVER_DID_RESOLVER_CACHE_SIZE"
+
+	didResolverCacheTTLFlagName  = "did-resolver-cache-ttl"
+	didResolverCacheTTLFlagUsage = "Optional. Duration the DID documents are cached for, e.g. 10m. Defaults to 5m." +
+		" Alternatively, this can be set with the following environment variable: " + didResolverCacheTTLEnvKey
+	didResolverCacheTTLEnvKey = "HTTP_SERVER_DID_RESOLVER_CACHE_TTL"
+
+	didResolverTimeout = 10 * time.Second
+)
+
+type didResolverParameters struct {
+	universalResolverURL string
+	// the URLs of the HTTP drivers, by method.
+	drivers   map[string]string
+	cacheSize int
+	cacheTTL  time.Duration
+}
+
+func createDIDResolverFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(didResolverURLFlagName, "", "", didResolverURLFlagUsage)
+	cmd.Flags().StringArrayP(didResolverDriversFlagName, "", []string{}, didResolverDriversFlagUsage)
+	cmd.Flags().StringP(didResolverCacheSizeFlagName, "", "", didResolverCacheSizeFlagUsage)
+	cmd.Flags().StringP(didResolverCacheTTLFlagName, "", "", didResolverCacheTTLFlagUsage)
+}
+
+func getDIDResolverParams(cmd *cobra.Command) (*didResolverParameters, error) {
+	params := &didResolverParameters{
+		universalResolverURL: cmdutils.GetUserSetOptionalVarFromString(cmd, didResolverURLFlagName,
+			didResolverURLEnvKey),
+		drivers: make(map[string]string),
+	}
+
+	if params.universalResolverURL != "" {
+		_, err := url.ParseRequestURI(params.universalResolverURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", didResolverURLFlagName, params.universalResolverURL, err)
+		}
+	}
+
+	for _, driver := range cmdutils.GetUserSetOptionalVarFromArrayString(cmd, didResolverDriversFlagName,
+		didResolverDriversEnvKey) {
+		parts := strings.SplitN(driver, "=", 2) // nolint:gomnd // method and url
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid %s value '%s': must be method=url", didResolverDriversFlagName, driver)
+		}
+
+		_, err := url.ParseRequestURI(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", didResolverDriversFlagName, driver, err)
+		}
+
+		params.drivers[parts[0]] = parts[1]
+	}
+
+	cacheSize := cmdutils.GetUserSetOptionalVarFromString(cmd, didResolverCacheSizeFlagName,
+		didResolverCacheSizeEnvKey)
+	if cacheSize != "" {
+		size, err := strconv.Atoi(cacheSize)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid %s value '%s': must be a non-negative integer",
+				didResolverCacheSizeFlagName, cacheSize)
+		}
+
+		// the resolver disables its cache for a negative size
+		params.cacheSize = size
+		if size == 0 {
+			params.cacheSize = -1
+		}
+	}
+
+	cacheTTL := cmdutils.GetUserSetOptionalVarFromString(cmd, didResolverCacheTTLFlagName, didResolverCacheTTLEnvKey)
+	if cacheTTL != "" {
+		var err error
+
+		params.cacheTTL, err = parsePositiveDuration(didResolverCacheTTLFlagName, cacheTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+// newDIDResolver returns the resolver of the DIDs of the flows of the agent. It resolves the methods of the HTTP
+// drivers, did:key and did:web, and the other methods with the universal resolver if any.
+func newDIDResolver(config *httpServerParameters) (*didresolver.Resolver, error) {
+	params := config.didResolver
+	if params == nil {
+		params = &didResolverParameters{}
+	}
+
+	var drivers []didresolver.Driver
+
+	// the HTTP drivers take precedence over the built-in ones
+	for method, driverURL := range params.drivers {
+		accepted := method
+
+		driver, err := httpbinding.New(driverURL,
+			httpbinding.WithTimeout(didResolverTimeout),
+			httpbinding.WithTLSConfig(config.tls.config),
+			httpbinding.WithAccept(func(m string) bool {
+				return m == accepted
+			}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create did:%s driver: %w", method, err)
+		}
+
+		drivers = append(drivers, driver)
+	}
+
+	resolverConfig := &didresolver.Config{
+		Drivers: append(drivers,
+			vdrkey.New(),
+			didresolver.NewWebDriver(&http.Client{
+				Timeout:   didResolverTimeout,
+				Transport: &http.Transport{TLSClientConfig: config.tls.config, Proxy: config.proxy},
+			}),
+		),
+		CacheSize: params.cacheSize,
+		CacheTTL:  params.cacheTTL,
+	}
+
+	if params.universalResolverURL != "" {
+		fallback, err := httpbinding.New(params.universalResolverURL,
+			httpbinding.WithTimeout(didResolverTimeout),
+			httpbinding.WithTLSConfig(config.tls.config),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create universal resolver driver: %w", err)
+		}
+
+		resolverConfig.Fallback = fallback
+	}
+
+	return didresolver.New(resolverConfig), nil
+}
+
+// addDIDResolverHandlers lets the operators check how the agent resolves DIDs. The endpoint is served by the admin
+// API, as resolving a DID makes the agent send requests to the domains of the DIDs.
+func addDIDResolverHandlers(adminRouter *mux.Router, config *httpServerParameters, resolver *didresolver.Resolver) {
+	mount(adminRouter, didresolverops.New(&didresolverops.Config{Resolver: resolver}).GetRESTHandlers(),
+		config.middleware, config.openapi)
+}
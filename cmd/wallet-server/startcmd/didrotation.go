@@ -0,0 +1,84 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/agent"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// DID key rotation config.
+const (
+	didKeyRotationFlagName  = "did-key-rotation"
+	didKeyRotationFlagUsage = "Optional. Set to true to let the users rotate the keys of their DIDs and of the" +
+		" pairwise DIDs of their DIDComm connections: a new key is created in the ops KMS and signs from then on," +
+		" while the previous key stays in the DID document for the grace period. Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + didKeyRotationEnvKey
+	didKeyRotationEnvKey = "HTTP_SERVER_DID_KEY_ROTATION"
+
+	didKeyRotationIntervalFlagName  = "did-key-rotation-interval"
+	didKeyRotationIntervalFlagUsage = "Optional. Age of the keys of the DIDs rotated on a schedule, e.g. 720h." +
+		" Enables " + didKeyRotationFlagName + ". The keys are only rotated on request of the users if not set." +
+		" Alternatively, this can be set with the following environment variable: " + didKeyRotationIntervalEnvKey
+	didKeyRotationIntervalEnvKey = "HTTP_SERVER_DID_KEY_ROTATION_INTERVAL"
+
+	didKeyGracePeriodFlagName  = "did-key-grace-period"
+	didKeyGracePeriodFlagUsage = "Optional. How long the previous key of a rotated DID stays in its document," +
+		" e.g. 48h. Defaults to 24h." +
+		" Alternatively, this can be set with the following environment variable: " + didKeyGracePeriodEnvKey
+	didKeyGracePeriodEnvKey = "HTTP_SERVER_DID_KEY_GRACE_PERIOD"
+)
+
+func createDIDKeyRotationFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(didKeyRotationFlagName, "", "", didKeyRotationFlagUsage)
+	cmd.Flags().StringP(didKeyRotationIntervalFlagName, "", "", didKeyRotationIntervalFlagUsage)
+	cmd.Flags().StringP(didKeyGracePeriodFlagName, "", "", didKeyGracePeriodFlagUsage)
+}
+
+// getDIDKeyRotationConfig returns nil if the keys of the DIDs are not rotated.
+func getDIDKeyRotationConfig(cmd *cobra.Command) (*agent.KeyRotationConfig, error) {
+	config := &agent.KeyRotationConfig{}
+
+	var err error
+
+	interval := cmdutils.GetUserSetOptionalVarFromString(cmd, didKeyRotationIntervalFlagName,
+		didKeyRotationIntervalEnvKey)
+	if interval != "" {
+		config.Interval, err = parsePositiveDuration(didKeyRotationIntervalFlagName, interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	enabled := interval != ""
+
+	enabledConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, didKeyRotationFlagName, didKeyRotationEnvKey)
+	if enabledConfig != "" && !enabled {
+		enabled, err = strconv.ParseBool(enabledConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", didKeyRotationFlagName, enabledConfig, err)
+		}
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	gracePeriod := cmdutils.GetUserSetOptionalVarFromString(cmd, didKeyGracePeriodFlagName, didKeyGracePeriodEnvKey)
+	if gracePeriod != "" {
+		config.GracePeriod, err = parsePositiveDuration(didKeyGracePeriodFlagName, gracePeriod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/escrow"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/mtls"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Share escrow config.
+const (
+	shareEscrowsFlagName  = "share-escrows"
+	shareEscrowsFlagUsage = "Optional. Escrow services holding shares of the agent's share of the secrets of the" +
+		" users, as id=url, e.g. escrow1=https://escrow1.example.com. The agent recovers the share from the escrows" +
+		" if it lost it, with POST " + adminBasePath + "users/escrow/recovery or at the next login of the user," +
+		" and authenticates with its client certificate. The share is not escrowed if not set." +
+		" Alternatively, this can be set with the following environment variable: " + shareEscrowsEnvKey
+	shareEscrowsEnvKey = "HTTP_SERVER_SHARE_ESCROWS"
+
+	shareEscrowTokensFlagName  = "share-escrow-tokens"
+	shareEscrowTokensFlagUsage = "Optional. Bearer tokens the agent authenticates with to the escrow services, as" +
+		" id=token." +
+		" Alternatively, this can be set with the following environment variable: " + shareEscrowTokensEnvKey
+	shareEscrowTokensEnvKey = "HTTP_SERVER_SHARE_ESCROW_TOKENS"
+
+	shareEscrowRetiredFlagName  = "share-escrow-retired"
+	shareEscrowRetiredFlagUsage = "Optional. IDs of the escrow services rotated out, which receive no new shares." +
+		" The shares are re-issued to the other escrows and deleted from the retired ones at the next login of the" +
+		" users, or with POST " + adminBasePath + "users/escrow/reissue." +
+		" Alternatively, this can be set with the following environment variable: " + shareEscrowRetiredEnvKey
+	shareEscrowRetiredEnvKey = "HTTP_SERVER_SHARE_ESCROW_RETIRED"
+
+	shareEscrowThresholdFlagName  = "share-escrow-threshold"
+	shareEscrowThresholdFlagUsage = "Optional. Number of escrow services whose shares recover the agent's share of" +
+		" a secret. Each escrow holds a copy of the share if 1. Defaults to a majority of the escrows not retired." +
+		" Alternatively, this can be set with the following environment variable: " + shareEscrowThresholdEnvKey
+	shareEscrowThresholdEnvKey = "HTTP_SERVER_SHARE_ESCROW_THRESHOLD"
+)
+
+type shareEscrowParameters struct {
+	escrows   []*shareEscrow
+	threshold int
+}
+
+type shareEscrow struct {
+	id      string
+	url     string
+	token   string
+	retired bool
+}
+
+func createShareEscrowFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP(shareEscrowsFlagName, "", []string{}, shareEscrowsFlagUsage)
+	cmd.Flags().StringArrayP(shareEscrowTokensFlagName, "", []string{}, shareEscrowTokensFlagUsage)
+	cmd.Flags().StringArrayP(shareEscrowRetiredFlagName, "", []string{}, shareEscrowRetiredFlagUsage)
+	cmd.Flags().StringP(shareEscrowThresholdFlagName, "", "", shareEscrowThresholdFlagUsage)
+}
+
+// getShareEscrowParams returns the escrow services of the secret shares, or nil if there is none.
+func getShareEscrowParams(cmd *cobra.Command) (*shareEscrowParameters, error) { // nolint:gocyclo // flat parsing
+	params := &shareEscrowParameters{}
+	escrows := map[string]*shareEscrow{}
+
+	for _, value := range cmdutils.GetUserSetOptionalVarFromArrayString(cmd, shareEscrowsFlagName,
+		shareEscrowsEnvKey) {
+		parts := strings.SplitN(value, "=", 2) // nolint:gomnd // id and url
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid %s value '%s': must be id=url", shareEscrowsFlagName, value)
+		}
+
+		_, err := url.ParseRequestURI(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", shareEscrowsFlagName, value, err)
+		}
+
+		if _, ok := escrows[parts[0]]; ok {
+			return nil, fmt.Errorf("invalid %s value '%s': duplicate id", shareEscrowsFlagName, value)
+		}
+
+		e := &shareEscrow{id: parts[0], url: parts[1]}
+		escrows[e.id] = e
+		params.escrows = append(params.escrows, e)
+	}
+
+	for _, value := range cmdutils.GetUserSetOptionalVarFromArrayString(cmd, shareEscrowTokensFlagName,
+		shareEscrowTokensEnvKey) {
+		parts := strings.SplitN(value, "=", 2) // nolint:gomnd // id and token
+		if len(parts) != 2 || escrows[parts[0]] == nil {
+			// the value holds a token
+			return nil, fmt.Errorf("invalid %s value: must be id=token for an id of %s", shareEscrowTokensFlagName,
+				shareEscrowsFlagName)
+		}
+
+		escrows[parts[0]].token = parts[1]
+	}
+
+	for _, id := range cmdutils.GetUserSetOptionalVarFromArrayString(cmd, shareEscrowRetiredFlagName,
+		shareEscrowRetiredEnvKey) {
+		if escrows[id] == nil {
+			return nil, fmt.Errorf("invalid %s value '%s': not an id of %s", shareEscrowRetiredFlagName, id,
+				shareEscrowsFlagName)
+		}
+
+		escrows[id].retired = true
+	}
+
+	threshold := cmdutils.GetUserSetOptionalVarFromString(cmd, shareEscrowThresholdFlagName,
+		shareEscrowThresholdEnvKey)
+	if threshold != "" {
+		var err error
+
+		params.threshold, err = strconv.Atoi(threshold)
+		if err != nil || params.threshold < 1 {
+			return nil, fmt.Errorf("invalid %s value '%s': must be a positive integer", shareEscrowThresholdFlagName,
+				threshold)
+		}
+	}
+
+	if len(params.escrows) == 0 {
+		return nil, nil
+	}
+
+	return params, nil
+}
+
+// newShareEscrow returns the escrow of the secret shares with the escrow services of the config, reached with the
+// TLS configuration, client certificate and proxy of the agent.
+func newShareEscrow(config *httpServerParameters) (*escrow.Service, error) {
+	if config.shareEscrow == nil {
+		return nil, nil
+	}
+
+	tlsConfig := config.tls.config
+	if config.tls.clientCert != nil {
+		tlsConfig = mtls.WithClientCertificate(tlsConfig, config.tls.clientCert)
+	}
+
+	client := sds.NewHTTPClient(tlsConfig, config.proxy)
+	escrowConfig := &escrow.Config{Threshold: config.shareEscrow.threshold}
+
+	for _, e := range config.shareEscrow.escrows {
+		escrowConfig.Escrows = append(escrowConfig.Escrows, &escrow.Escrow{
+			ID:        e.id,
+			Custodian: escrow.NewHTTPCustodian(e.url, e.token, client),
+			Retired:   e.retired,
+		})
+	}
+
+	service, err := escrow.New(escrowConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init share escrow: %w", err)
+	}
+
+	return service, nil
+}
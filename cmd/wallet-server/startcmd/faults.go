@@ -0,0 +1,47 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/faults"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Fault injection config.
+const (
+	faultInjectionFlagName  = "fault-injection"
+	faultInjectionFlagUsage = "Optional. Path to a YAML or JSON file of rules injecting latency, 5xx responses and" +
+		" connection resets at configurable rates into the requests to the hub-auth, KMS and EDV servers, optionally" +
+		" per endpoint, e.g. 'rules: [{endpoint: https://edv.example.com, error-rate: 0.1, latency: 2s," +
+		" latency-rate: 0.5, reset-rate: 0.05}]', to test the resilience of the onboarding in staging. Never set it" +
+		" in production. No fault is injected if not set." +
+		" Alternatively, this can be set with the following environment variable: " + faultInjectionEnvKey
+	faultInjectionEnvKey = "HTTP_SERVER_FAULT_INJECTION"
+)
+
+func createFaultInjectionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(faultInjectionFlagName, "", "", faultInjectionFlagUsage)
+}
+
+// getFaultInjectionConfig returns nil if no fault is injected.
+func getFaultInjectionConfig(cmd *cobra.Command) (*faults.Config, error) {
+	file := cmdutils.GetUserSetOptionalVarFromString(cmd, faultInjectionFlagName, faultInjectionEnvKey)
+	if file == "" {
+		return nil, nil
+	}
+
+	config, err := faults.Load(file)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Warnf("injecting faults into the requests to the hub-auth, KMS and EDV servers with %d rules",
+		len(config.Rules))
+
+	return config, nil
+}
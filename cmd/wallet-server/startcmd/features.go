@@ -0,0 +1,139 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	features2 "github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Feature flag config.
+const (
+	featureFlagsFlagName  = "feature-flags"
+	featureFlagsFlagUsage = "Optional. Path to a YAML or JSON file, or http(s) URL serving it, mapping features to" +
+		" the flags enabling them for everyone, for a percentage of the users or for some tenants or users, e.g." +
+		" 'oidc4vci: {percentage: 10}' or 'device-login: {tenants: [b]}'. The flags are reloaded periodically and" +
+		" served to the UI at /features. Every feature is enabled if not set, and so are the features without flag." +
+		" Alternatively, this can be set with the following environment variable: " + featureFlagsEnvKey
+	featureFlagsEnvKey = "HTTP_SERVER_FEATURE_FLAGS"
+
+	featureFlagsIntervalFlagName  = "feature-flags-refresh-interval"
+	featureFlagsIntervalFlagUsage = "Optional. Interval between the reloads of the feature flags, e.g. 1m." +
+		" Defaults to 30s." +
+		" Alternatively, this can be set with the following environment variable: " + featureFlagsIntervalEnvKey
+	featureFlagsIntervalEnvKey = "HTTP_SERVER_FEATURE_FLAGS_REFRESH_INTERVAL"
+)
+
+const featureFlagsTimeout = 10 * time.Second
+
+type featureFlagParameters struct {
+	source   string
+	interval time.Duration
+	// created by the router, and started with the server
+	service *features2.Service
+}
+
+func createFeatureFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(featureFlagsFlagName, "", "", featureFlagsFlagUsage)
+	cmd.Flags().StringP(featureFlagsIntervalFlagName, "", "", featureFlagsIntervalFlagUsage)
+}
+
+// getFeatureFlagParams returns nil if the features are not flagged.
+func getFeatureFlagParams(cmd *cobra.Command) (*featureFlagParameters, error) {
+	source := cmdutils.GetUserSetOptionalVarFromString(cmd, featureFlagsFlagName, featureFlagsEnvKey)
+	if source == "" {
+		return nil, nil
+	}
+
+	params := &featureFlagParameters{source: source}
+
+	interval := cmdutils.GetUserSetOptionalVarFromString(cmd, featureFlagsIntervalFlagName,
+		featureFlagsIntervalEnvKey)
+	if interval != "" {
+		var err error
+
+		params.interval, err = parsePositiveDuration(featureFlagsIntervalFlagName, interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+// flags returns the feature flags created by the router, nil if the features are not flagged.
+func (p *featureFlagParameters) flags() *features2.Service {
+	if p == nil {
+		return nil
+	}
+
+	return p.service
+}
+
+// initFeatureFlags loads the feature flags of the config, whose tenants are those of the users.
+func initFeatureFlags(config *httpServerParameters, users user.Store) error {
+	params := config.featureFlags
+	if params == nil {
+		return nil
+	}
+
+	source := features2.NewFileSource(params.source)
+
+	if u, err := url.Parse(params.source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		source = features2.NewRemoteSource(params.source, &http.Client{
+			Timeout: featureFlagsTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: config.tls.config,
+				Proxy:           config.proxy,
+			},
+		})
+	}
+
+	service, err := features2.New(&features2.Config{
+		Source: source,
+		Tenant: func(sub string) (string, error) {
+			usr, err := users.Get(sub)
+			if err != nil {
+				return "", err
+			}
+
+			tenant, _ := usr.Attributes[oidc.TenantAttribute].(string) // nolint:errcheck // users may have none
+
+			return tenant, nil
+		},
+		Interval: params.interval,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init feature flags: %w", err)
+	}
+
+	params.service = service
+
+	return nil
+}
+
+func addFeatureHandlers(router *mux.Router, config *httpServerParameters, middleware []common.Middleware) {
+	featureOps := features.New(&features.Config{
+		Features: config.featureFlags.flags(),
+		Keys: &features.KeyConfig{
+			Ring: config.keys.sessionCookieKeys,
+		},
+	})
+
+	mount(router, featureOps.GetRESTHandlers(), middleware, config.openapi)
+}
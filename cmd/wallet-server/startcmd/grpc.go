@@ -0,0 +1,129 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/grpcapi"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+	tlsutils "github.com/trustbloc/edge-core/pkg/utils/tls"
+)
+
+// gRPC config.
+const (
+	grpcHostURLFlagName  = "grpc-host-url"
+	grpcHostURLFlagUsage = "Optional. URL to run the gRPC server on, serving the user lookups, token refreshes," +
+		" onboardings and bootstrap data to the backend services with mutual TLS. It uses the certificate of the" +
+		" HTTP server. The gRPC server is disabled if not set." +
+		" Alternatively, this can be set with the following environment variable: " + grpcHostURLEnvKey
+	grpcHostURLEnvKey = "HTTP_SERVER_GRPC_HOST_URL"
+
+	grpcClientCACertsFlagName  = "grpc-client-cacerts"
+	grpcClientCACertsFlagUsage = "Comma-Separated list of the paths of the CA certificates of the gRPC clients." +
+		" Required if the gRPC server is enabled." +
+		" Alternatively, this can be set with the following environment variable: " + grpcClientCACertsEnvKey
+	grpcClientCACertsEnvKey = "HTTP_SERVER_GRPC_CLIENT_CACERTS"
+
+	grpcAllowedClientsFlagName  = "grpc-allowed-clients"
+	grpcAllowedClientsFlagUsage = "Optional. Common names or DNS names of the certificates of the gRPC clients" +
+		" allowed to call the server. Any client with a certificate of the client CAs is allowed if not set." +
+		" Alternatively, this can be set with the following environment variable: " + grpcAllowedClientsEnvKey
+	grpcAllowedClientsEnvKey = "HTTP_SERVER_GRPC_ALLOWED_CLIENTS"
+)
+
+type grpcParameters struct {
+	hostURL        string
+	certificate    tls.Certificate
+	clientCAs      *x509.CertPool
+	allowedClients []string
+	// set once the router created the backend
+	backend grpcapi.Backend
+}
+
+func createGRPCFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(grpcHostURLFlagName, "", "", grpcHostURLFlagUsage)
+	cmd.Flags().StringArrayP(grpcClientCACertsFlagName, "", []string{}, grpcClientCACertsFlagUsage)
+	cmd.Flags().StringArrayP(grpcAllowedClientsFlagName, "", []string{}, grpcAllowedClientsFlagUsage)
+}
+
+// getGRPCParams returns nil if the gRPC server is disabled.
+func getGRPCParams(cmd *cobra.Command, tlsParams *tlsParameters) (*grpcParameters, error) {
+	hostURL := cmdutils.GetUserSetOptionalVarFromString(cmd, grpcHostURLFlagName, grpcHostURLEnvKey)
+	if hostURL == "" {
+		return nil, nil
+	}
+
+	params := &grpcParameters{hostURL: hostURL}
+
+	if tlsParams.certFile == "" || tlsParams.keyFile == "" {
+		return nil, fmt.Errorf("the gRPC server requires both %s and %s", tlsCertFileFlagName, tlsKeyFileFlagName)
+	}
+
+	var err error
+
+	params.certificate, err = tls.LoadX509KeyPair(tlsParams.certFile, tlsParams.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the gRPC server certificate: %w", err)
+	}
+
+	clientCACerts, err := cmdutils.GetUserSetVarFromArrayString(cmd, grpcClientCACertsFlagName,
+		grpcClientCACertsEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure gRPC client CA certs: %w", err)
+	}
+
+	if len(clientCACerts) == 0 {
+		return nil, fmt.Errorf("%s must be set for the gRPC server to verify the clients", grpcClientCACertsFlagName)
+	}
+
+	params.clientCAs, err = tlsutils.GetCertPool(false, clientCACerts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init gRPC client cert pool: %w", err)
+	}
+
+	params.allowedClients, err = cmdutils.GetUserSetVarFromArrayString(cmd, grpcAllowedClientsFlagName,
+		grpcAllowedClientsEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure gRPC allowed clients: %w", err)
+	}
+
+	return params, nil
+}
+
+// startGRPCServer starts serving the gRPC calls in the background. The returned server is stopped by the caller.
+func startGRPCServer(params *grpcParameters) (*grpcapi.Server, error) {
+	srv, err := grpcapi.New(&grpcapi.Config{
+		Backend:        params.backend,
+		Certificate:    params.certificate,
+		ClientCAs:      params.clientCAs,
+		AllowedClients: params.allowedClients,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", params.hostURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", params.hostURL, err)
+	}
+
+	logger.Infof("starting grpc-server on %s...", listener.Addr())
+
+	go func() {
+		serveErr := srv.Serve(listener)
+		if serveErr != nil {
+			logger.Errorf("grpc server closed unexpectedly: %s", serveErr)
+		}
+	}()
+
+	return srv, nil
+}
@@ -0,0 +1,224 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/headers"
+	"github.com/trustbloc/edge-agent/pkg/restapi/csp"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Security headers config.
+const (
+	securityHeadersCSPFlagName  = "security-headers-csp"
+	securityHeadersCSPFlagUsage = "Optional. Content-Security-Policy of the responses, e.g. \"default-src 'self'\"." +
+		" The browsers report the violations of the policy to " + csp.ReportPath + ", listed by GET " +
+		adminBasePath + "csp-reports." +
+		" Alternatively, this can be set with the following environment variable: " + securityHeadersCSPEnvKey
+	securityHeadersCSPEnvKey = "HTTP_SERVER_SECURITY_HEADERS_CSP"
+
+	securityHeadersCSPReportOnlyFlagName  = "security-headers-csp-report-only"
+	securityHeadersCSPReportOnlyFlagUsage = "Optional. Set to true to send the content security policy in the" +
+		" Content-Security-Policy-Report-Only header: the browsers report its violations without blocking them," +
+		" e.g. to try a policy out. The frame ancestors are still enforced. Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " +
+		securityHeadersCSPReportOnlyEnvKey
+	securityHeadersCSPReportOnlyEnvKey = "HTTP_SERVER_SECURITY_HEADERS_CSP_REPORT_ONLY"
+
+	securityHeadersHSTSFlagName  = "security-headers-hsts"
+	securityHeadersHSTSFlagUsage = "Optional. Strict-Transport-Security of the responses, e.g." +
+		" \"max-age=63072000; includeSubDomains\", for the browsers to only reach the agent over HTTPS." +
+		" Alternatively, this can be set with the following environment variable: " + securityHeadersHSTSEnvKey
+	securityHeadersHSTSEnvKey = "HTTP_SERVER_SECURITY_HEADERS_HSTS"
+
+	securityHeadersContentTypeOptionsFlagName  = "security-headers-content-type-options"
+	securityHeadersContentTypeOptionsFlagUsage = "Optional. X-Content-Type-Options of the responses, e.g. nosniff." +
+		" Alternatively, this can be set with the following environment variable: " +
+		securityHeadersContentTypeOptionsEnvKey
+	securityHeadersContentTypeOptionsEnvKey = "HTTP_SERVER_SECURITY_HEADERS_CONTENT_TYPE_OPTIONS"
+
+	securityHeadersReferrerPolicyFlagName  = "security-headers-referrer-policy"
+	securityHeadersReferrerPolicyFlagUsage = "Optional. Referrer-Policy of the responses, e.g. no-referrer." +
+		" Alternatively, this can be set with the following environment variable: " +
+		securityHeadersReferrerPolicyEnvKey
+	securityHeadersReferrerPolicyEnvKey = "HTTP_SERVER_SECURITY_HEADERS_REFERRER_POLICY"
+
+	securityHeadersFrameAncestorsFlagName  = "security-headers-frame-ancestors"
+	securityHeadersFrameAncestorsFlagUsage = "Optional. Sources allowed to embed the pages of the agent, set as the" +
+		" frame-ancestors directive of the content security policy, e.g. 'none'." +
+		" Alternatively, this can be set with the following environment variable: " +
+		securityHeadersFrameAncestorsEnvKey
+	securityHeadersFrameAncestorsEnvKey = "HTTP_SERVER_SECURITY_HEADERS_FRAME_ANCESTORS"
+
+	securityHeadersRouteFlagName  = "security-headers-route"
+	securityHeadersRouteFlagUsage = "Optional. Security header of the routes of a path prefix in place of the header" +
+		" above, e.g. \"/oidc/wallet/attachments:csp=sandbox\". The headers are csp, csp-report-only, hsts," +
+		" content-type-options, referrer-policy and frame-ancestors, and an empty value removes a header." +
+		" The longest prefix matching a request wins. Can be repeated." +
+		" Alternatively, this can be set with the following environment variable: " + securityHeadersRouteEnvKey
+	securityHeadersRouteEnvKey = "HTTP_SERVER_SECURITY_HEADERS_ROUTE"
+)
+
+// Keys of the security headers of the routes.
+const (
+	cspKey                = "csp"
+	cspReportOnlyKey      = "csp-report-only"
+	hstsKey               = "hsts"
+	contentTypeOptionsKey = "content-type-options"
+	referrerPolicyKey     = "referrer-policy"
+	frameAncestorsKey     = "frame-ancestors"
+)
+
+type securityHeadersParameters struct {
+	config *headers.Config
+}
+
+func createSecurityHeadersFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(securityHeadersCSPFlagName, "", "", securityHeadersCSPFlagUsage)
+	cmd.Flags().StringP(securityHeadersCSPReportOnlyFlagName, "", "", securityHeadersCSPReportOnlyFlagUsage)
+	cmd.Flags().StringP(securityHeadersHSTSFlagName, "", "", securityHeadersHSTSFlagUsage)
+	cmd.Flags().StringP(securityHeadersContentTypeOptionsFlagName, "", "", securityHeadersContentTypeOptionsFlagUsage)
+	cmd.Flags().StringP(securityHeadersReferrerPolicyFlagName, "", "", securityHeadersReferrerPolicyFlagUsage)
+	cmd.Flags().StringP(securityHeadersFrameAncestorsFlagName, "", "", securityHeadersFrameAncestorsFlagUsage)
+	cmd.Flags().StringArrayP(securityHeadersRouteFlagName, "", []string{}, securityHeadersRouteFlagUsage)
+}
+
+// getSecurityHeadersParams returns nil if no security header is set.
+func getSecurityHeadersParams(cmd *cobra.Command) (*securityHeadersParameters, error) {
+	defaults := &headers.Headers{ReportURI: csp.ReportPath}
+
+	for key, flag := range map[string][2]string{
+		cspKey:                {securityHeadersCSPFlagName, securityHeadersCSPEnvKey},
+		cspReportOnlyKey:      {securityHeadersCSPReportOnlyFlagName, securityHeadersCSPReportOnlyEnvKey},
+		hstsKey:               {securityHeadersHSTSFlagName, securityHeadersHSTSEnvKey},
+		contentTypeOptionsKey: {securityHeadersContentTypeOptionsFlagName, securityHeadersContentTypeOptionsEnvKey},
+		referrerPolicyKey:     {securityHeadersReferrerPolicyFlagName, securityHeadersReferrerPolicyEnvKey},
+		frameAncestorsKey:     {securityHeadersFrameAncestorsFlagName, securityHeadersFrameAncestorsEnvKey},
+	} {
+		value := cmdutils.GetUserSetOptionalVarFromString(cmd, flag[0], flag[1])
+		if value == "" {
+			continue
+		}
+
+		err := setSecurityHeader(defaults, key, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value: %w", flag[0], err)
+		}
+	}
+
+	routes, err := getSecurityHeaderRoutes(cmd, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	if *defaults == (headers.Headers{ReportURI: csp.ReportPath}) && len(routes) == 0 {
+		return nil, nil
+	}
+
+	return &securityHeadersParameters{config: &headers.Config{Default: defaults, Routes: routes}}, nil
+}
+
+// getSecurityHeaderRoutes returns the routes with their own headers, which default to the headers of the other
+// routes. The headers of the same prefix are merged.
+func getSecurityHeaderRoutes(cmd *cobra.Command, defaults *headers.Headers) ([]*headers.Route, error) {
+	values, err := cmdutils.GetUserSetVarFromArrayString(cmd, securityHeadersRouteFlagName,
+		securityHeadersRouteEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", securityHeadersRouteFlagName, err)
+	}
+
+	var routes []*headers.Route
+
+	byPrefix := map[string]*headers.Route{}
+
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") || !strings.Contains(parts[1], "=") {
+			return nil, fmt.Errorf("invalid %s value '%s': must be <path prefix>:<header>=<value>",
+				securityHeadersRouteFlagName, value)
+		}
+
+		route, ok := byPrefix[parts[0]]
+		if !ok {
+			route = &headers.Route{Prefix: parts[0], Headers: &headers.Headers{}}
+			*route.Headers = *defaults
+
+			byPrefix[parts[0]] = route
+			routes = append(routes, route)
+		}
+
+		kv := strings.SplitN(parts[1], "=", 2)
+
+		err = setSecurityHeader(route.Headers, kv[0], kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", securityHeadersRouteFlagName, value, err)
+		}
+	}
+
+	return routes, nil
+}
+
+// setSecurityHeader sets the header of the key.
+func setSecurityHeader(h *headers.Headers, key, value string) error {
+	switch key {
+	case cspKey:
+		h.ContentSecurityPolicy = value
+	case cspReportOnlyKey:
+		if value == "" {
+			h.ReportOnly = false
+
+			return nil
+		}
+
+		reportOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s '%s': %w", key, value, err)
+		}
+
+		h.ReportOnly = reportOnly
+	case hstsKey:
+		h.StrictTransportSecurity = value
+	case contentTypeOptionsKey:
+		h.ContentTypeOptions = value
+	case referrerPolicyKey:
+		h.ReferrerPolicy = value
+	case frameAncestorsKey:
+		h.FrameAncestors = value
+	default:
+		return fmt.Errorf("unknown header %s", key)
+	}
+
+	return nil
+}
+
+// addCSPReportHandlers collects the violations of the content security policy reported by the browsers, listed
+// on the admin API.
+func addCSPReportHandlers(root, adminRouter *mux.Router, config *httpServerParameters) {
+	ops := csp.New(&csp.Config{})
+
+	// the browsers report without credentials
+	mount(root, ops.GetRESTHandlers(), nil, config.openapi)
+
+	if adminRouter != nil {
+		mount(adminRouter, ops.GetAdminRESTHandlers(), config.middleware, config.openapi)
+	}
+}
+
+// headers returns nil if no security header is set.
+func (p *securityHeadersParameters) headers() *headers.Config {
+	if p == nil {
+		return nil
+	}
+
+	return p.config
+}
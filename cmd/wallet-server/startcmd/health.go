@@ -0,0 +1,108 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/health"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/mtls"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Dependency watcher config.
+const (
+	dependencyCheckIntervalFlagName  = "dependency-check-interval"
+	dependencyCheckIntervalFlagUsage = "Optional. Interval between the probes of the OIDC provider, hub-kms and the" +
+		" user EDV server, e.g. 30s. While they are down, the onboarding of the new users is deferred and the user" +
+		" info is served from its cache, and /status reports the degraded features." +
+		" The dependencies are not probed if not set." +
+		" Alternatively, this can be set with the following environment variable: " + dependencyCheckIntervalEnvKey
+	dependencyCheckIntervalEnvKey = "HTTP_SERVER_DEPENDENCY_CHECK_INTERVAL"
+
+	dependencyCheckTimeoutFlagName  = "dependency-check-timeout"
+	dependencyCheckTimeoutFlagUsage = "Optional. Timeout of each probe of a dependency, e.g. 2s. Defaults to 5s." +
+		" Alternatively, this can be set with the following environment variable: " + dependencyCheckTimeoutEnvKey
+	dependencyCheckTimeoutEnvKey = "HTTP_SERVER_DEPENDENCY_CHECK_TIMEOUT"
+
+	// probed on the discovery document of the provider, and on the health endpoints of hub-kms and the EDV server
+	providerDiscoveryPath = "/.well-known/openid-configuration"
+)
+
+type healthParameters struct {
+	interval time.Duration
+	timeout  time.Duration
+	// created by the router, and started with the server
+	watcher *health.Watcher
+}
+
+func createHealthFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(dependencyCheckIntervalFlagName, "", "", dependencyCheckIntervalFlagUsage)
+	cmd.Flags().StringP(dependencyCheckTimeoutFlagName, "", "", dependencyCheckTimeoutFlagUsage)
+}
+
+// getHealthParams returns nil if the dependencies are not probed.
+func getHealthParams(cmd *cobra.Command) (*healthParameters, error) {
+	intervalConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, dependencyCheckIntervalFlagName,
+		dependencyCheckIntervalEnvKey)
+	if intervalConfig == "" {
+		return nil, nil
+	}
+
+	params := &healthParameters{}
+
+	var err error
+
+	params.interval, err = parsePositiveDuration(dependencyCheckIntervalFlagName, intervalConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, dependencyCheckTimeoutFlagName,
+		dependencyCheckTimeoutEnvKey)
+	if timeoutConfig != "" {
+		params.timeout, err = parsePositiveDuration(dependencyCheckTimeoutFlagName, timeoutConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+// newHealthWatcher returns the watcher probing the OIDC provider, hub-kms and the user EDV server of the config.
+func newHealthWatcher(config *httpServerParameters) *health.Watcher {
+	tlsConfig := config.tls.config
+	if config.tls.clientCert != nil {
+		tlsConfig = mtls.WithClientCertificate(tlsConfig, config.tls.clientCert)
+	}
+
+	client := sds.NewHTTPClient(tlsConfig, config.proxy)
+
+	probes := map[string]health.Probe{
+		oidc.DependencyOIDCProvider: health.HTTPProbe(client,
+			strings.TrimSuffix(config.oidc.providerURL, "/")+providerDiscoveryPath),
+	}
+
+	if config.keyServer.authzKMSURL != "" {
+		probes[oidc.DependencyKMS] = health.HTTPProbe(client, health.CheckURL(config.keyServer.authzKMSURL))
+	}
+
+	if config.userEDVURL != "" {
+		probes[oidc.DependencyEDV] = health.HTTPProbe(client, health.CheckURL(config.userEDVURL))
+	}
+
+	return health.NewWatcher(&health.Config{
+		Probes:   probes,
+		Interval: config.health.interval,
+		Timeout:  config.health.timeout,
+	})
+}
@@ -0,0 +1,101 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys/hsm"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// HSM config.
+const (
+	hsmLibraryFlagName  = "hsm-library"
+	hsmLibraryFlagUsage = "Optional. Path to the PKCS#11 library of the HSM holding the AES keys sealing the session" +
+		" cookies and the records of the agent at rest, so that they never exist in the memory of the agent. The" +
+		" agent must be built with the pkcs11 build tag. The keys are held in memory if not set." +
+		" Alternatively, this can be set with the following environment variable: " + hsmLibraryEnvKey
+	hsmLibraryEnvKey = "HTTP_SERVER_HSM_LIBRARY"
+
+	hsmTokenLabelFlagName  = "hsm-token-label"
+	hsmTokenLabelFlagUsage = "Label of the HSM token holding the keys. Required if " + hsmLibraryFlagName + " is set." +
+		" Alternatively, this can be set with the following environment variable: " + hsmTokenLabelEnvKey
+	hsmTokenLabelEnvKey = "HTTP_SERVER_HSM_TOKEN_LABEL"
+
+	hsmPINFlagName  = "hsm-pin"
+	hsmPINFlagUsage = "PIN of the HSM token. Required if " + hsmLibraryFlagName + " is set." +
+		" Alternatively, this can be set with the following environment variable: " + hsmPINEnvKey
+	hsmPINEnvKey = "HTTP_SERVER_HSM_PIN"
+
+	hsmCookieKeyLabelFlagName  = "hsm-cookie-key-label"
+	hsmCookieKeyLabelFlagUsage = "Optional. Label of the HSM key sealing the session cookies, in place of " +
+		sessionCookieAuthKeyFlagName + " and " + sessionCookieEncKeyFlagName + ". The key is cluster-wide, like them." +
+		" Alternatively, this can be set with the following environment variable: " + hsmCookieKeyLabelEnvKey
+	hsmCookieKeyLabelEnvKey = "HTTP_SERVER_HSM_COOKIE_KEY_LABEL"
+
+	hsmPseudonymKeyLabelFlagName  = "hsm-pseudonym-key-label"
+	hsmPseudonymKeyLabelFlagUsage = "Optional. Label of the HSM key encrypting the subs of the pseudonymous IDs of" +
+		" the users, in place of " + pseudonymEncKeyFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + hsmPseudonymKeyLabelEnvKey
+	hsmPseudonymKeyLabelEnvKey = "HTTP_SERVER_HSM_PSEUDONYM_KEY_LABEL"
+)
+
+type hsmParameters struct {
+	keys              keys.Provider
+	cookieKeyLabel    string
+	pseudonymKeyLabel string
+}
+
+func createHSMFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(hsmLibraryFlagName, "", "", hsmLibraryFlagUsage)
+	cmd.Flags().StringP(hsmTokenLabelFlagName, "", "", hsmTokenLabelFlagUsage)
+	cmd.Flags().StringP(hsmPINFlagName, "", "", hsmPINFlagUsage)
+	cmd.Flags().StringP(hsmCookieKeyLabelFlagName, "", "", hsmCookieKeyLabelFlagUsage)
+	cmd.Flags().StringP(hsmPseudonymKeyLabelFlagName, "", "", hsmPseudonymKeyLabelFlagUsage)
+}
+
+// getHSMParams returns nil if the keys are held in memory.
+func getHSMParams(cmd *cobra.Command) (*hsmParameters, error) {
+	library := cmdutils.GetUserSetOptionalVarFromString(cmd, hsmLibraryFlagName, hsmLibraryEnvKey)
+	if library == "" {
+		return nil, nil
+	}
+
+	tokenLabel, err := cmdutils.GetUserSetVarFromString(cmd, hsmTokenLabelFlagName, hsmTokenLabelEnvKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	pin, err := cmdutils.GetUserSetVarFromString(cmd, hsmPINFlagName, hsmPINEnvKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &hsmParameters{
+		cookieKeyLabel: cmdutils.GetUserSetOptionalVarFromString(cmd, hsmCookieKeyLabelFlagName,
+			hsmCookieKeyLabelEnvKey),
+		pseudonymKeyLabel: cmdutils.GetUserSetOptionalVarFromString(cmd, hsmPseudonymKeyLabelFlagName,
+			hsmPseudonymKeyLabelEnvKey),
+	}
+
+	if params.cookieKeyLabel == "" && params.pseudonymKeyLabel == "" {
+		return nil, fmt.Errorf("%s is set without %s or %s", hsmLibraryFlagName, hsmCookieKeyLabelFlagName,
+			hsmPseudonymKeyLabelFlagName)
+	}
+
+	token, err := hsm.Open(&hsm.Config{Library: library, TokenLabel: tokenLabel, PIN: pin})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure hsm: %w", err)
+	}
+
+	params.keys = token
+
+	return params, nil
+}
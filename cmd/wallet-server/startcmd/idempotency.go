@@ -0,0 +1,40 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Idempotency key config.
+const (
+	idempotencyTTLFlagName  = "idempotency-key-ttl"
+	idempotencyTTLFlagUsage = "Optional. How long the responses of the mutating requests of the users sent with an " +
+		oidc.IdempotencyKeyHeader + " header are replayed for the retries of the requests with the same key, e.g. 24h," +
+		" instead of serving them again. The responses are kept in the transient store. The requests are always" +
+		" served if not set." +
+		" Alternatively, this can be set with the following environment variable: " + idempotencyTTLEnvKey
+	idempotencyTTLEnvKey = "HTTP_SERVER_IDEMPOTENCY_KEY_TTL"
+)
+
+func createIdempotencyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(idempotencyTTLFlagName, "", "", idempotencyTTLFlagUsage)
+}
+
+// getIdempotencyTTL returns 0 if the retries are always served.
+func getIdempotencyTTL(cmd *cobra.Command) (time.Duration, error) {
+	ttl := cmdutils.GetUserSetOptionalVarFromString(cmd, idempotencyTTLFlagName, idempotencyTTLEnvKey)
+	if ttl == "" {
+		return 0, nil
+	}
+
+	return parsePositiveDuration(idempotencyTTLFlagName, ttl)
+}
@@ -0,0 +1,230 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	oidcp "github.com/coreos/go-oidc"
+	"github.com/spf13/cobra"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Trusted issuers config.
+const (
+	oidcJWKSURLsFlagName  = "oidc-jwks-urls"
+	oidcJWKSURLsFlagUsage = "Optional. URLs of JWKS documents whose keys sign the id_tokens of the OIDC provider" +
+		" besides those of its jwks_uri, e.g. the keys published ahead of a rotation." +
+		" Alternatively, this can be set with the following environment variable (in CSV format): " +
+		oidcJWKSURLsEnvKey
+	oidcJWKSURLsEnvKey = "HTTP_SERVER_OIDC_JWKS_URLS"
+
+	oidcJWKSRefreshIntervalFlagName  = "oidc-jwks-refresh-interval"
+	oidcJWKSRefreshIntervalFlagUsage = "Optional. Interval between the refreshes of the signing keys of the OIDC" +
+		" provider in the background, e.g. 5m. The keys are also refreshed when an id_token is signed with an" +
+		" unknown key. Defaults to 15m." +
+		" Alternatively, this can be set with the following environment variable: " + oidcJWKSRefreshIntervalEnvKey
+	oidcJWKSRefreshIntervalEnvKey = "HTTP_SERVER_OIDC_JWKS_REFRESH_INTERVAL"
+
+	oidcJWKSMaxStaleFlagName  = "oidc-jwks-max-stale"
+	oidcJWKSMaxStaleFlagUsage = "Optional. Duration the signing keys of the OIDC provider keep verifying the" +
+		" id_tokens once their refreshes fail, e.g. 48h. The id_tokens verified with stale keys are counted by the" +
+		" admin API. Defaults to 24h." +
+		" Alternatively, this can be set with the following environment variable: " + oidcJWKSMaxStaleEnvKey
+	oidcJWKSMaxStaleEnvKey = "HTTP_SERVER_OIDC_JWKS_MAX_STALE"
+
+	oidcTrustedIssuerFlagName  = "oidc-trusted-issuer"
+	oidcTrustedIssuerFlagUsage = "Optional. Issuer whose id_tokens are accepted besides those of the OIDC provider," +
+		" e.g. the previous issuer while the provider migrates to a new one." +
+		" Alternatively, this can be set with the following environment variable: " + oidcTrustedIssuerEnvKey
+	oidcTrustedIssuerEnvKey = "HTTP_SERVER_OIDC_TRUSTED_ISSUER"
+
+	oidcTrustedIssuerJWKSURLsFlagName  = "oidc-trusted-issuer-jwks-urls"
+	oidcTrustedIssuerJWKSURLsFlagUsage = "Optional. URLs of the JWKS documents of the trusted issuer." +
+		" Defaults to the jwks_uri of its discovery document." +
+		" Alternatively, this can be set with the following environment variable (in CSV format): " +
+		oidcTrustedIssuerJWKSURLsEnvKey
+	oidcTrustedIssuerJWKSURLsEnvKey = "HTTP_SERVER_OIDC_TRUSTED_ISSUER_JWKS_URLS"
+
+	jwksTimeout = 10 * time.Second
+)
+
+type issuerParameters struct {
+	jwksURLs        []string
+	refreshInterval time.Duration
+	maxStale        time.Duration
+	trustedIssuer   string
+	trustedJWKSURLs []string
+	// set by the router
+	trusted []*oidc2.TrustedIssuer
+	keys    map[string]*oidc2.KeySet
+}
+
+func createIssuerFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP(oidcJWKSURLsFlagName, "", []string{}, oidcJWKSURLsFlagUsage)
+	cmd.Flags().StringP(oidcJWKSRefreshIntervalFlagName, "", "", oidcJWKSRefreshIntervalFlagUsage)
+	cmd.Flags().StringP(oidcJWKSMaxStaleFlagName, "", "", oidcJWKSMaxStaleFlagUsage)
+	cmd.Flags().StringP(oidcTrustedIssuerFlagName, "", "", oidcTrustedIssuerFlagUsage)
+	cmd.Flags().StringArrayP(oidcTrustedIssuerJWKSURLsFlagName, "", []string{}, oidcTrustedIssuerJWKSURLsFlagUsage)
+}
+
+func getIssuerParams(cmd *cobra.Command) (*issuerParameters, error) {
+	params := &issuerParameters{
+		jwksURLs:      cmdutils.GetUserSetOptionalVarFromArrayString(cmd, oidcJWKSURLsFlagName, oidcJWKSURLsEnvKey),
+		trustedIssuer: cmdutils.GetUserSetOptionalVarFromString(cmd, oidcTrustedIssuerFlagName, oidcTrustedIssuerEnvKey),
+		trustedJWKSURLs: cmdutils.GetUserSetOptionalVarFromArrayString(cmd, oidcTrustedIssuerJWKSURLsFlagName,
+			oidcTrustedIssuerJWKSURLsEnvKey),
+	}
+
+	if len(params.trustedJWKSURLs) > 0 && params.trustedIssuer == "" {
+		return nil, fmt.Errorf("%s requires %s", oidcTrustedIssuerJWKSURLsFlagName, oidcTrustedIssuerFlagName)
+	}
+
+	var err error
+
+	interval := cmdutils.GetUserSetOptionalVarFromString(cmd, oidcJWKSRefreshIntervalFlagName,
+		oidcJWKSRefreshIntervalEnvKey)
+	if interval != "" {
+		params.refreshInterval, err = parsePositiveDuration(oidcJWKSRefreshIntervalFlagName, interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxStale := cmdutils.GetUserSetOptionalVarFromString(cmd, oidcJWKSMaxStaleFlagName, oidcJWKSMaxStaleEnvKey)
+	if maxStale != "" {
+		params.maxStale, err = parsePositiveDuration(oidcJWKSMaxStaleFlagName, maxStale)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+// initTrustedIssuers caches the signing keys of the OIDC provider, and of the trusted issuer if any, for them to
+// verify the id_tokens by issuer. The keys cached by the provider verify the id_tokens if it advertises no
+// jwks_uri and no other JWKS URL is set.
+func initTrustedIssuers(config *httpServerParameters, provider *oidcp.Provider) error {
+	params := config.issuers
+	if params == nil {
+		return nil
+	}
+
+	metadata := &issuerMetadata{}
+
+	err := provider.Claims(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to read the discovery document of the OIDC provider: %w", err)
+	}
+
+	urls := params.jwksURLs
+	if metadata.JWKSURI != "" {
+		urls = append([]string{metadata.JWKSURI}, urls...)
+	}
+
+	if len(urls) == 0 && params.trustedIssuer == "" {
+		logger.Warnf("the OIDC provider advertises no jwks_uri: its signing keys are not refreshed in the background")
+
+		return nil
+	}
+
+	params.keys = make(map[string]*oidc2.KeySet)
+
+	err = params.trust(config, metadata.Issuer, urls, metadata.Algorithms)
+	if err != nil {
+		return err
+	}
+
+	if params.trustedIssuer == "" {
+		return nil
+	}
+
+	urls = params.trustedJWKSURLs
+	metadata = &issuerMetadata{}
+
+	if len(urls) == 0 {
+		trusted, discoveryErr := initOIDCProvider(params.trustedIssuer, config.dependencyMaxRetries,
+			config.tls.config, config.proxy)
+		if discoveryErr != nil {
+			return fmt.Errorf("failed to discover trusted issuer: %w", discoveryErr)
+		}
+
+		err = trusted.Claims(metadata)
+		if err != nil || metadata.JWKSURI == "" {
+			return fmt.Errorf("trusted issuer %s advertises no jwks_uri: set %s", params.trustedIssuer,
+				oidcTrustedIssuerJWKSURLsFlagName)
+		}
+
+		urls = []string{metadata.JWKSURI}
+	}
+
+	return params.trust(config, params.trustedIssuer, urls, metadata.Algorithms)
+}
+
+type issuerMetadata struct {
+	Issuer     string   `json:"issuer"`
+	JWKSURI    string   `json:"jwks_uri"`
+	Algorithms []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// trust accepts the id_tokens of the issuer signed with the keys of the JWKS documents, with the algorithms of the
+// issuer, or RS256 if it advertises none.
+func (p *issuerParameters) trust(config *httpServerParameters, issuer string, urls, algorithms []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("missing JWKS URL of issuer %s: set %s", issuer, oidcJWKSURLsFlagName)
+	}
+
+	keys, err := oidc2.NewKeySet(&oidc2.KeySetConfig{
+		URLs: urls,
+		HTTPClient: &http.Client{
+			Timeout:   jwksTimeout,
+			Transport: &http.Transport{TLSClientConfig: config.tls.config, Proxy: config.proxy},
+		},
+		RefreshInterval: p.refreshInterval,
+		MaxStale:        p.maxStale,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init the keys of issuer %s: %w", issuer, err)
+	}
+
+	p.trusted = append(p.trusted, &oidc2.TrustedIssuer{URL: issuer, Keys: keys, Algorithms: algorithms})
+	p.keys[issuer] = keys
+
+	return nil
+}
+
+// trustedIssuers returns nil if the provider verifies the id_tokens.
+func (p *issuerParameters) trustedIssuers() []*oidc2.TrustedIssuer {
+	if p == nil {
+		return nil
+	}
+
+	return p.trusted
+}
+
+// keySets returns nil if the provider verifies the id_tokens.
+func (p *issuerParameters) keySets() map[string]*oidc2.KeySet {
+	if p == nil {
+		return nil
+	}
+
+	return p.keys
+}
+
+// providerAdapter verifies the id_tokens with the keys of the trusted issuers, if any.
+func providerAdapter(config *httpServerParameters, provider *oidcp.Provider) *oidc2.ProviderAdapter {
+	return &oidc2.ProviderAdapter{
+		OP:        provider,
+		TLSConfig: config.tls.config,
+		Proxy:     config.proxy,
+		Issuers:   config.issuers.trustedIssuers(),
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Janitor config.
+const (
+	janitorIntervalFlagName  = "janitor-interval"
+	janitorIntervalFlagUsage = "Optional. Interval between the cleanups of the expired transient records and of the" +
+		" tokens of deleted users, e.g. 10m. The cleanup stats are served by the admin API." +
+		" Nothing is cleaned up if not set." +
+		" Alternatively, this can be set with the following environment variable: " + janitorIntervalEnvKey
+	janitorIntervalEnvKey = "HTTP_SERVER_JANITOR_INTERVAL"
+
+	janitorBatchSizeFlagName  = "janitor-batch-size"
+	janitorBatchSizeFlagUsage = "Optional. Maximum number of records of each kind deleted by a cleanup." +
+		" Defaults to 100." +
+		" Alternatively, this can be set with the following environment variable: " + janitorBatchSizeEnvKey
+	janitorBatchSizeEnvKey = "HTTP_SERVER_JANITOR_BATCH_SIZE"
+
+	janitorTransientTTLFlagName  = "janitor-transient-ttl"
+	janitorTransientTTLFlagUsage = "Optional. Duration the pending logins and the user data exports are kept for," +
+		" e.g. 12h. Defaults to 24h." +
+		" Alternatively, this can be set with the following environment variable: " + janitorTransientTTLEnvKey
+	janitorTransientTTLEnvKey = "HTTP_SERVER_JANITOR_TRANSIENT_TTL"
+)
+
+func createJanitorFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(janitorIntervalFlagName, "", "", janitorIntervalFlagUsage)
+	cmd.Flags().StringP(janitorBatchSizeFlagName, "", "", janitorBatchSizeFlagUsage)
+	cmd.Flags().StringP(janitorTransientTTLFlagName, "", "", janitorTransientTTLFlagUsage)
+}
+
+// getJanitorConfig returns nil if nothing is cleaned up.
+func getJanitorConfig(cmd *cobra.Command) (*oidc.JanitorConfig, error) {
+	intervalConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, janitorIntervalFlagName, janitorIntervalEnvKey)
+	if intervalConfig == "" {
+		return nil, nil
+	}
+
+	config := &oidc.JanitorConfig{}
+
+	var err error
+
+	config.Interval, err = parsePositiveDuration(janitorIntervalFlagName, intervalConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSizeConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, janitorBatchSizeFlagName, janitorBatchSizeEnvKey)
+	if batchSizeConfig != "" {
+		config.BatchSize, err = strconv.Atoi(batchSizeConfig)
+		if err != nil || config.BatchSize < 1 {
+			return nil, fmt.Errorf("invalid %s value '%s': must be a positive integer",
+				janitorBatchSizeFlagName, batchSizeConfig)
+		}
+	}
+
+	ttlConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, janitorTransientTTLFlagName, janitorTransientTTLEnvKey)
+	if ttlConfig != "" {
+		config.TransientTTL, err = parsePositiveDuration(janitorTransientTTLFlagName, ttlConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func parsePositiveDuration(flagName, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s value '%s': must be a positive duration", flagName, value)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,46 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Keystore cache config.
+const (
+	keystoreCacheTTLFlagName  = "keystore-cache-ttl"
+	keystoreCacheTTLFlagUsage = "Optional. Duration the keystore metadata of the users, the URLs of their keystores" +
+		" and keys and the capabilities of their vaults, is cached for, e.g. 10m. The metadata of a user is fetched" +
+		" again once the KMS denies a request of the user." +
+		" The metadata is fetched from hub-auth before each KMS call if not set." +
+		" Alternatively, this can be set with the following environment variable: " + keystoreCacheTTLEnvKey
+	keystoreCacheTTLEnvKey = "HTTP_SERVER_KEYSTORE_CACHE_TTL"
+)
+
+func createKeystoreCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(keystoreCacheTTLFlagName, "", "", keystoreCacheTTLFlagUsage)
+}
+
+// getKeystoreCacheConfig returns nil if the keystore metadata is not cached.
+func getKeystoreCacheConfig(cmd *cobra.Command) (*oidc.KeystoreCacheConfig, error) {
+	value := cmdutils.GetUserSetOptionalVarFromString(cmd, keystoreCacheTTLFlagName, keystoreCacheTTLEnvKey)
+	if value == "" {
+		return nil, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid %s value '%s': must be a positive duration", keystoreCacheTTLFlagName, value)
+	}
+
+	return &oidc.KeystoreCacheConfig{TTL: ttl}, nil
+}
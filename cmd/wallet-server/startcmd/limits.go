@@ -0,0 +1,288 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/limits"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Request limits config.
+const (
+	limitsMaxBodySizeFlagName  = "limits-max-body-size"
+	limitsMaxBodySizeFlagUsage = "Optional. Size in bytes of the largest request body, answered with 413 beyond." +
+		" The uploads of attachments are only bounded by their own limit unless set for their route." +
+		" The bodies are unlimited if not set." +
+		" Alternatively, this can be set with the following environment variable: " + limitsMaxBodySizeEnvKey
+	limitsMaxBodySizeEnvKey = "HTTP_SERVER_LIMITS_MAX_BODY_SIZE"
+
+	limitsMaxHeaderSizeFlagName  = "limits-max-header-size"
+	limitsMaxHeaderSizeFlagUsage = "Optional. Size in bytes of the largest request headers, answered with 431 beyond." +
+		" The server reads 1 MB of headers at most." +
+		" Alternatively, this can be set with the following environment variable: " + limitsMaxHeaderSizeEnvKey
+	limitsMaxHeaderSizeEnvKey = "HTTP_SERVER_LIMITS_MAX_HEADER_SIZE"
+
+	limitsReadTimeoutFlagName  = "limits-read-timeout"
+	limitsReadTimeoutFlagUsage = "Optional. Time the clients have to send the request bodies, e.g. 30s, answered" +
+		" with 408 beyond. The bodies are read at the pace of the clients if not set." +
+		" Alternatively, this can be set with the following environment variable: " + limitsReadTimeoutEnvKey
+	limitsReadTimeoutEnvKey = "HTTP_SERVER_LIMITS_READ_TIMEOUT"
+
+	limitsWriteTimeoutFlagName  = "limits-write-timeout"
+	limitsWriteTimeoutFlagUsage = "Optional. Time the handlers have to respond, e.g. 1m, including their calls to" +
+		" the OIDC provider, hub-auth, KMS and EDV servers. The notification streams are exempted unless set for" +
+		" their route." +
+		" Alternatively, this can be set with the following environment variable: " + limitsWriteTimeoutEnvKey
+	limitsWriteTimeoutEnvKey = "HTTP_SERVER_LIMITS_WRITE_TIMEOUT"
+
+	limitsRouteFlagName  = "limits-route"
+	limitsRouteFlagUsage = "Optional. Limits of the routes of a path prefix in place of the limits above, e.g." +
+		" /oidc/wallet/attachments:max-body-size=1073741824,read-timeout=10m. The limits not listed are those above," +
+		" and 0 lifts a limit. The longest prefix matching a request wins. Can be repeated." +
+		" Alternatively, this can be set with the following environment variable: " + limitsRouteEnvKey
+	limitsRouteEnvKey = "HTTP_SERVER_LIMITS_ROUTE"
+
+	limitsReadHeaderTimeoutFlagName  = "limits-read-header-timeout"
+	limitsReadHeaderTimeoutFlagUsage = "Optional. Time the clients have to send the request headers, e.g. 10s," +
+		" protecting the server from the clients holding connections open with slow requests (slowloris)." +
+		" Alternatively, this can be set with the following environment variable: " + limitsReadHeaderTimeoutEnvKey
+	limitsReadHeaderTimeoutEnvKey = "HTTP_SERVER_LIMITS_READ_HEADER_TIMEOUT"
+
+	limitsIdleTimeoutFlagName  = "limits-idle-timeout"
+	limitsIdleTimeoutFlagUsage = "Optional. Time the idle keep-alive connections are kept open for, e.g. 2m." +
+		" Alternatively, this can be set with the following environment variable: " + limitsIdleTimeoutEnvKey
+	limitsIdleTimeoutEnvKey = "HTTP_SERVER_LIMITS_IDLE_TIMEOUT"
+)
+
+// Keys of the limits of the routes.
+const (
+	maxBodySizeKey   = "max-body-size"
+	maxHeaderSizeKey = "max-header-size"
+	readTimeoutKey   = "read-timeout"
+	writeTimeoutKey  = "write-timeout"
+)
+
+// the routes exempted from some of the default limits, unless configured.
+const (
+	attachmentsRoute   = oidcBasePath + "wallet/attachments"
+	notificationsRoute = "/notifications"
+)
+
+type limitsParameters struct {
+	routes *limits.Config
+	server *limits.ServerConfig
+}
+
+// limitedServer is a server whose connections are bounded.
+type limitedServer interface {
+	SetLimits(config *limits.ServerConfig)
+}
+
+func createLimitsFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(limitsMaxBodySizeFlagName, "", "", limitsMaxBodySizeFlagUsage)
+	cmd.Flags().StringP(limitsMaxHeaderSizeFlagName, "", "", limitsMaxHeaderSizeFlagUsage)
+	cmd.Flags().StringP(limitsReadTimeoutFlagName, "", "", limitsReadTimeoutFlagUsage)
+	cmd.Flags().StringP(limitsWriteTimeoutFlagName, "", "", limitsWriteTimeoutFlagUsage)
+	cmd.Flags().StringArrayP(limitsRouteFlagName, "", []string{}, limitsRouteFlagUsage)
+	cmd.Flags().StringP(limitsReadHeaderTimeoutFlagName, "", "", limitsReadHeaderTimeoutFlagUsage)
+	cmd.Flags().StringP(limitsIdleTimeoutFlagName, "", "", limitsIdleTimeoutFlagUsage)
+}
+
+// getLimitsParams returns nil if the requests are unlimited.
+func getLimitsParams(cmd *cobra.Command) (*limitsParameters, error) {
+	defaults := &limits.Limits{}
+
+	for key, flag := range map[string][2]string{
+		maxBodySizeKey:   {limitsMaxBodySizeFlagName, limitsMaxBodySizeEnvKey},
+		maxHeaderSizeKey: {limitsMaxHeaderSizeFlagName, limitsMaxHeaderSizeEnvKey},
+		readTimeoutKey:   {limitsReadTimeoutFlagName, limitsReadTimeoutEnvKey},
+		writeTimeoutKey:  {limitsWriteTimeoutFlagName, limitsWriteTimeoutEnvKey},
+	} {
+		err := setLimits(defaults, map[string]string{
+			key: cmdutils.GetUserSetOptionalVarFromString(cmd, flag[0], flag[1]),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value: %w", flag[0], err)
+		}
+	}
+
+	routes, err := getLimitedRoutes(cmd, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := getServerLimits(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &limitsParameters{server: server}
+
+	if *defaults != (limits.Limits{}) || len(routes) > 0 {
+		params.routes = &limits.Config{Default: defaults, Routes: exemptStreams(defaults, routes)}
+	}
+
+	if params.routes == nil && params.server == nil {
+		return nil, nil
+	}
+
+	return params, nil
+}
+
+// getLimitedRoutes returns the routes with their own limits, which default to the limits of the other routes.
+func getLimitedRoutes(cmd *cobra.Command, defaults *limits.Limits) ([]*limits.Route, error) {
+	values, err := cmdutils.GetUserSetVarFromArrayString(cmd, limitsRouteFlagName, limitsRouteEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", limitsRouteFlagName, err)
+	}
+
+	routes := make([]*limits.Route, 0, len(values))
+
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			return nil, fmt.Errorf("invalid %s value '%s': must be <path prefix>:<limit>=<value>,...",
+				limitsRouteFlagName, value)
+		}
+
+		settings := map[string]string{}
+
+		for _, setting := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(setting, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid %s value '%s': must be <path prefix>:<limit>=<value>,...",
+					limitsRouteFlagName, value)
+			}
+
+			settings[kv[0]] = kv[1]
+		}
+
+		route := &limits.Route{Prefix: parts[0], Limits: &limits.Limits{}}
+		*route.Limits = *defaults
+
+		err = setLimits(route.Limits, settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", limitsRouteFlagName, value, err)
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
+}
+
+// setLimits sets the limits of the non-empty settings, by key.
+func setLimits(l *limits.Limits, settings map[string]string) error {
+	for key, value := range settings {
+		if value == "" {
+			continue
+		}
+
+		var err error
+
+		switch key {
+		case maxBodySizeKey:
+			l.MaxBodyBytes, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && l.MaxBodyBytes < 0 {
+				err = fmt.Errorf("negative size")
+			}
+		case maxHeaderSizeKey:
+			l.MaxHeaderBytes, err = strconv.Atoi(value)
+			if err == nil && l.MaxHeaderBytes < 0 {
+				err = fmt.Errorf("negative size")
+			}
+		case readTimeoutKey:
+			l.ReadTimeout, err = parseTimeout(value)
+		case writeTimeoutKey:
+			l.WriteTimeout, err = parseTimeout(value)
+		default:
+			return fmt.Errorf("unknown limit %s", key)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid %s '%s': %w", key, value, err)
+		}
+	}
+
+	return nil
+}
+
+// parseTimeout parses a duration, of which 0 lifts the timeout.
+func parseTimeout(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		return 0, fmt.Errorf("negative duration")
+	}
+
+	return d, err
+}
+
+// exemptStreams lifts the body limit of the uploads of attachments, which have their own, and the write timeout of
+// the notification streams, unless their routes are configured.
+func exemptStreams(defaults *limits.Limits, routes []*limits.Route) []*limits.Route {
+	configured := map[string]bool{}
+
+	for _, route := range routes {
+		configured[route.Prefix] = true
+	}
+
+	if !configured[attachmentsRoute] && defaults.MaxBodyBytes > 0 {
+		exempted := *defaults
+		exempted.MaxBodyBytes = 0
+		routes = append(routes, &limits.Route{Prefix: attachmentsRoute, Limits: &exempted})
+	}
+
+	if !configured[notificationsRoute] && defaults.WriteTimeout > 0 {
+		exempted := *defaults
+		exempted.WriteTimeout = 0
+		routes = append(routes, &limits.Route{Prefix: notificationsRoute, Limits: &exempted})
+	}
+
+	return routes
+}
+
+// getServerLimits returns nil if the connections are unlimited.
+func getServerLimits(cmd *cobra.Command) (*limits.ServerConfig, error) {
+	config := &limits.ServerConfig{}
+
+	var err error
+
+	value := cmdutils.GetUserSetOptionalVarFromString(cmd, limitsReadHeaderTimeoutFlagName,
+		limitsReadHeaderTimeoutEnvKey)
+	if value != "" {
+		config.ReadHeaderTimeout, err = parsePositiveDuration(limitsReadHeaderTimeoutFlagName, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	value = cmdutils.GetUserSetOptionalVarFromString(cmd, limitsIdleTimeoutFlagName, limitsIdleTimeoutEnvKey)
+	if value != "" {
+		config.IdleTimeout, err = parsePositiveDuration(limitsIdleTimeoutFlagName, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	value = cmdutils.GetUserSetOptionalVarFromString(cmd, limitsMaxHeaderSizeFlagName, limitsMaxHeaderSizeEnvKey)
+	if value != "" {
+		// parsed with the limits of the routes
+		config.MaxHeaderBytes, _ = strconv.Atoi(value) // nolint:errcheck // validated by setLimits
+	}
+
+	if *config == (limits.ServerConfig{}) {
+		return nil, nil
+	}
+
+	return config, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Login lockout config.
+const (
+	lockoutMaxFailuresFlagName  = "login-lockout-max-failures"
+	lockoutMaxFailuresFlagUsage = "Optional. Number of failed logins, e.g. invalid states or authorization codes," +
+		" after which a client IP address or a user is locked out of the login. The lockouts are served by the" +
+		" admin API. Failed logins are not tracked if not set." +
+		" Alternatively, this can be set with the following environment variable: " + lockoutMaxFailuresEnvKey
+	lockoutMaxFailuresEnvKey = "HTTP_SERVER_LOGIN_LOCKOUT_MAX_FAILURES"
+
+	lockoutWindowFlagName  = "login-lockout-window"
+	lockoutWindowFlagUsage = "Optional. How long the failed logins are remembered for after the last one, e.g. 30m." +
+		" Defaults to 15m." +
+		" Alternatively, this can be set with the following environment variable: " + lockoutWindowEnvKey
+	lockoutWindowEnvKey = "HTTP_SERVER_LOGIN_LOCKOUT_WINDOW"
+
+	lockoutDurationFlagName  = "login-lockout-duration"
+	lockoutDurationFlagUsage = "Optional. Duration of the first lockout, doubled by each further lockout, e.g. 5m." +
+		" Defaults to 1m." +
+		" Alternatively, this can be set with the following environment variable: " + lockoutDurationEnvKey
+	lockoutDurationEnvKey = "HTTP_SERVER_LOGIN_LOCKOUT_DURATION"
+
+	lockoutMaxDurationFlagName  = "login-lockout-max-duration"
+	lockoutMaxDurationFlagUsage = "Optional. Maximum duration of the lockouts, e.g. 24h. Defaults to 1h." +
+		" Alternatively, this can be set with the following environment variable: " + lockoutMaxDurationEnvKey
+	lockoutMaxDurationEnvKey = "HTTP_SERVER_LOGIN_LOCKOUT_MAX_DURATION"
+
+	lockoutTrustForwardedForFlagName  = "login-lockout-trust-forwarded-for"
+	lockoutTrustForwardedForFlagUsage = "Optional. Set to true to take the client IP addresses from the" +
+		" X-Forwarded-For header added by the reverse proxy of the server. Only set behind such a proxy." +
+		" Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + lockoutTrustForwardedForEnvKey
+	lockoutTrustForwardedForEnvKey = "HTTP_SERVER_LOGIN_LOCKOUT_TRUST_FORWARDED_FOR"
+)
+
+func createLockoutFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(lockoutMaxFailuresFlagName, "", "", lockoutMaxFailuresFlagUsage)
+	cmd.Flags().StringP(lockoutWindowFlagName, "", "", lockoutWindowFlagUsage)
+	cmd.Flags().StringP(lockoutDurationFlagName, "", "", lockoutDurationFlagUsage)
+	cmd.Flags().StringP(lockoutMaxDurationFlagName, "", "", lockoutMaxDurationFlagUsage)
+	cmd.Flags().StringP(lockoutTrustForwardedForFlagName, "", "", lockoutTrustForwardedForFlagUsage)
+}
+
+// getLockoutConfig returns nil if failed logins are not tracked.
+func getLockoutConfig(cmd *cobra.Command) (*oidc.LockoutConfig, error) { // nolint:funlen // one option at a time
+	maxFailures := cmdutils.GetUserSetOptionalVarFromString(cmd, lockoutMaxFailuresFlagName, lockoutMaxFailuresEnvKey)
+	if maxFailures == "" {
+		return nil, nil
+	}
+
+	config := &oidc.LockoutConfig{}
+
+	var err error
+
+	config.MaxFailures, err = strconv.Atoi(maxFailures)
+	if err != nil || config.MaxFailures < 1 {
+		return nil, fmt.Errorf("invalid %s value '%s': must be a positive integer",
+			lockoutMaxFailuresFlagName, maxFailures)
+	}
+
+	window := cmdutils.GetUserSetOptionalVarFromString(cmd, lockoutWindowFlagName, lockoutWindowEnvKey)
+	if window != "" {
+		config.Window, err = parsePositiveDuration(lockoutWindowFlagName, window)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	duration := cmdutils.GetUserSetOptionalVarFromString(cmd, lockoutDurationFlagName, lockoutDurationEnvKey)
+	if duration != "" {
+		config.Duration, err = parsePositiveDuration(lockoutDurationFlagName, duration)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxDuration := cmdutils.GetUserSetOptionalVarFromString(cmd, lockoutMaxDurationFlagName, lockoutMaxDurationEnvKey)
+	if maxDuration != "" {
+		config.MaxDuration, err = parsePositiveDuration(lockoutMaxDurationFlagName, maxDuration)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	trust := cmdutils.GetUserSetOptionalVarFromString(cmd,
+		lockoutTrustForwardedForFlagName, lockoutTrustForwardedForEnvKey)
+	if trust != "" {
+		config.TrustForwardedFor, err = strconv.ParseBool(trust)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", lockoutTrustForwardedForFlagName, trust, err)
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/logging"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Logging config.
+const (
+	logFormatFlagName  = "log-format"
+	logFormatFlagUsage = "Optional. Format of the log lines: text or json. The access and refresh tokens and the" +
+		" secret shares are always redacted, and the user subs are pseudonymized in the debug lines." +
+		" Defaults to text." +
+		" Alternatively, this can be set with the following environment variable: " + logFormatEnvKey
+	logFormatEnvKey = "HTTP_SERVER_LOG_FORMAT"
+
+	logSamplingInitialFlagName  = "log-sampling-initial"
+	logSamplingInitialFlagUsage = "Optional. Number of lines of the same message logged each second before the" +
+		" message is sampled. The error lines are never sampled. Every line is logged if not set." +
+		" Alternatively, this can be set with the following environment variable: " + logSamplingInitialEnvKey
+	logSamplingInitialEnvKey = "HTTP_SERVER_LOG_SAMPLING_INITIAL"
+
+	logSamplingThereafterFlagName  = "log-sampling-thereafter"
+	logSamplingThereafterFlagUsage = "Optional. Once a message is sampled, only every Nth line of it is logged" +
+		" for the rest of the second. Enables the sampling. Defaults to dropping the lines." +
+		" Alternatively, this can be set with the following environment variable: " + logSamplingThereafterEnvKey
+	logSamplingThereafterEnvKey = "HTTP_SERVER_LOG_SAMPLING_THEREAFTER"
+)
+
+func createLoggingFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(logFormatFlagName, "", "", logFormatFlagUsage)
+	cmd.Flags().StringP(logSamplingInitialFlagName, "", "", logSamplingInitialFlagUsage)
+	cmd.Flags().StringP(logSamplingThereafterFlagName, "", "", logSamplingThereafterFlagUsage)
+}
+
+func getLoggingConfig(cmd *cobra.Command) (*logging.Config, error) {
+	config := &logging.Config{
+		Format: cmdutils.GetUserSetOptionalVarFromString(cmd, logFormatFlagName, logFormatEnvKey),
+	}
+
+	switch config.Format {
+	case "", logging.FormatText, logging.FormatJSON:
+	default:
+		return nil, fmt.Errorf("invalid %s value '%s': must be %s or %s",
+			logFormatFlagName, config.Format, logging.FormatText, logging.FormatJSON)
+	}
+
+	initial, err := getNonNegativeInt(cmd, logSamplingInitialFlagName, logSamplingInitialEnvKey)
+	if err != nil {
+		return nil, err
+	}
+
+	thereafter, err := getNonNegativeInt(cmd, logSamplingThereafterFlagName, logSamplingThereafterEnvKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if initial > 0 || thereafter > 0 {
+		config.Sampling = &logging.SamplingConfig{Initial: initial, Thereafter: thereafter}
+	}
+
+	return config, nil
+}
+
+func getNonNegativeInt(cmd *cobra.Command, flagName, envKey string) (int, error) {
+	value := cmdutils.GetUserSetOptionalVarFromString(cmd, flagName, envKey)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s value '%s': must be a non-negative integer", flagName, value)
+	}
+
+	return n, nil
+}
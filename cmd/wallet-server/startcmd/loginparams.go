@@ -0,0 +1,46 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Login parameters config.
+const (
+	oidcLoginParamsFlagName  = "oidc-login-params"
+	oidcLoginParamsFlagUsage = "Optional. Parameters of the authentication requests the wallet UI may pass to the" +
+		" login endpoint, to be forwarded to the OIDC provider once validated: " + oidc.LoginHintParam + ", " +
+		oidc.PromptParam + ", " + oidc.UILocalesParam + " or " + oidc.MaxAgeParam + ". None by default." +
+		" Alternatively, this can be set with the following environment variable: " + oidcLoginParamsEnvKey
+	oidcLoginParamsEnvKey = "HTTP_SERVER_OIDC_LOGIN_PARAMS"
+)
+
+func createLoginParamsFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP(oidcLoginParamsFlagName, "", []string{}, oidcLoginParamsFlagUsage)
+}
+
+func getLoginParams(cmd *cobra.Command) ([]string, error) {
+	params, err := cmdutils.GetUserSetVarFromArrayString(cmd, oidcLoginParamsFlagName, oidcLoginParamsEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure login parameters: %w", err)
+	}
+
+	for _, param := range params {
+		switch param {
+		case oidc.LoginHintParam, oidc.PromptParam, oidc.UILocalesParam, oidc.MaxAgeParam:
+		default:
+			return nil, fmt.Errorf("invalid %s value '%s': not a supported parameter", oidcLoginParamsFlagName, param)
+		}
+	}
+
+	return params, nil
+}
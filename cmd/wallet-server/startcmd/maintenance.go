@@ -0,0 +1,131 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/maintenance"
+	maintenanceops "github.com/trustbloc/edge-agent/pkg/restapi/maintenance"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Maintenance config.
+const (
+	maintenanceBlockSessionsFlagName  = "maintenance-block-sessions"
+	maintenanceBlockSessionsFlagUsage = "Optional. Set to true to also refuse the requests of the users logged in" +
+		" while the agent is under maintenance, not only the logins. The agent enters and leaves the maintenance" +
+		" with PUT " + adminBasePath + "maintenance. Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + maintenanceBlockSessionsEnvKey
+	maintenanceBlockSessionsEnvKey = "HTTP_SERVER_MAINTENANCE_BLOCK_SESSIONS"
+
+	maintenanceRetryAfterFlagName  = "maintenance-retry-after"
+	maintenanceRetryAfterFlagUsage = "Optional. Delay the clients refused under maintenance are told to retry" +
+		" after, in the Retry-After header of the 503 responses, unless the maintenance sets it. Defaults to 5m." +
+		" Alternatively, this can be set with the following environment variable: " + maintenanceRetryAfterEnvKey
+	maintenanceRetryAfterEnvKey = "HTTP_SERVER_MAINTENANCE_RETRY_AFTER"
+
+	maintenancePageFlagName  = "maintenance-page"
+	maintenancePageFlagUsage = "Optional. Path to the html/template of the 503 page served to the browsers under" +
+		" maintenance, executed with the mode, e.g. {{.Message}}. Defaults to a page showing the message." +
+		" Alternatively, this can be set with the following environment variable: " + maintenancePageEnvKey
+	maintenancePageEnvKey = "HTTP_SERVER_MAINTENANCE_PAGE"
+)
+
+type maintenanceParameters struct {
+	config  *maintenance.Config
+	service *maintenance.Service
+}
+
+func createMaintenanceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(maintenanceBlockSessionsFlagName, "", "", maintenanceBlockSessionsFlagUsage)
+	cmd.Flags().StringP(maintenanceRetryAfterFlagName, "", "", maintenanceRetryAfterFlagUsage)
+	cmd.Flags().StringP(maintenancePageFlagName, "", "", maintenancePageFlagUsage)
+}
+
+// getMaintenanceParams returns the configuration of the maintenance mode. The storage is set by the router.
+func getMaintenanceParams(cmd *cobra.Command) (*maintenanceParameters, error) {
+	config := &maintenance.Config{}
+
+	block := cmdutils.GetUserSetOptionalVarFromString(cmd, maintenanceBlockSessionsFlagName,
+		maintenanceBlockSessionsEnvKey)
+	if block != "" {
+		var err error
+
+		config.BlockSessions, err = strconv.ParseBool(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", maintenanceBlockSessionsFlagName, block, err)
+		}
+	}
+
+	retryAfter := cmdutils.GetUserSetOptionalVarFromString(cmd, maintenanceRetryAfterFlagName,
+		maintenanceRetryAfterEnvKey)
+	if retryAfter != "" {
+		var err error
+
+		config.RetryAfter, err = parsePositiveDuration(maintenanceRetryAfterFlagName, retryAfter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page := cmdutils.GetUserSetOptionalVarFromString(cmd, maintenancePageFlagName, maintenancePageEnvKey)
+	if page != "" {
+		bits, err := ioutil.ReadFile(filepath.Clean(page))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", maintenancePageFlagName, err)
+		}
+
+		config.Page = string(bits)
+	}
+
+	return &maintenanceParameters{config: config}, nil
+}
+
+// initMaintenance loads the maintenance mode from the storage shared by the instances of the agent.
+func initMaintenance(params *maintenanceParameters, store storage.Provider) error {
+	if params == nil {
+		return nil
+	}
+
+	params.config.Storage = store
+
+	service, err := maintenance.New(params.config)
+	if err != nil {
+		return fmt.Errorf("failed to init maintenance mode: %w", err)
+	}
+
+	params.service = service
+
+	return nil
+}
+
+// mode returns nil if the maintenance mode is not initialized.
+func (p *maintenanceParameters) mode() *maintenance.Service {
+	if p == nil {
+		return nil
+	}
+
+	return p.service
+}
+
+func addMaintenanceHandlers(adminRouter *mux.Router, config *httpServerParameters) error {
+	ops, err := maintenanceops.New(&maintenanceops.Config{Maintenance: config.maintenance.mode()})
+	if err != nil {
+		return err
+	}
+
+	mount(adminRouter, ops.GetRESTHandlers(), config.middleware, config.openapi)
+
+	return nil
+}
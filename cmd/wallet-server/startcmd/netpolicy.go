@@ -0,0 +1,284 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/netpolicy"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Network policy config.
+const (
+	publicAllowFlagName  = "network-public-allow"
+	publicAllowFlagUsage = "Optional. Networks, e.g. 10.0.0.0/8, or IP addresses whose clients are allowed to call" +
+		" the endpoints outside the admin API. All the clients are allowed if not set." +
+		" Alternatively, this can be set with the following environment variable: " + publicAllowEnvKey
+	publicAllowEnvKey = "HTTP_SERVER_NETWORK_PUBLIC_ALLOW"
+
+	publicDenyFlagName  = "network-public-deny"
+	publicDenyFlagUsage = "Optional. Networks or IP addresses whose clients are denied the endpoints outside the" +
+		" admin API, even if allowed." +
+		" Alternatively, this can be set with the following environment variable: " + publicDenyEnvKey
+	publicDenyEnvKey = "HTTP_SERVER_NETWORK_PUBLIC_DENY"
+
+	publicAllowCountriesFlagName  = "network-public-allow-countries"
+	publicAllowCountriesFlagUsage = "Optional. Countries, e.g. CA, whose clients are allowed to call the endpoints" +
+		" outside the admin API. Requires a GeoIP database. All the countries are allowed if not set." +
+		" Alternatively, this can be set with the following environment variable: " + publicAllowCountriesEnvKey
+	publicAllowCountriesEnvKey = "HTTP_SERVER_NETWORK_PUBLIC_ALLOW_COUNTRIES"
+
+	publicDenyCountriesFlagName  = "network-public-deny-countries"
+	publicDenyCountriesFlagUsage = "Optional. Countries whose clients are denied the endpoints outside the admin API." +
+		" Requires a GeoIP database." +
+		" Alternatively, this can be set with the following environment variable: " + publicDenyCountriesEnvKey
+	publicDenyCountriesEnvKey = "HTTP_SERVER_NETWORK_PUBLIC_DENY_COUNTRIES"
+
+	adminAllowFlagName  = "network-admin-allow"
+	adminAllowFlagUsage = "Optional. Networks or IP addresses whose clients are allowed to call the admin API." +
+		" All the clients are allowed if not set." +
+		" Alternatively, this can be set with the following environment variable: " + adminAllowEnvKey
+	adminAllowEnvKey = "HTTP_SERVER_NETWORK_ADMIN_ALLOW"
+
+	adminDenyFlagName  = "network-admin-deny"
+	adminDenyFlagUsage = "Optional. Networks or IP addresses whose clients are denied the admin API, even if allowed." +
+		" Alternatively, this can be set with the following environment variable: " + adminDenyEnvKey
+	adminDenyEnvKey = "HTTP_SERVER_NETWORK_ADMIN_DENY"
+
+	adminAllowCountriesFlagName  = "network-admin-allow-countries"
+	adminAllowCountriesFlagUsage = "Optional. Countries whose clients are allowed to call the admin API." +
+		" Requires a GeoIP database. All the countries are allowed if not set." +
+		" Alternatively, this can be set with the following environment variable: " + adminAllowCountriesEnvKey
+	adminAllowCountriesEnvKey = "HTTP_SERVER_NETWORK_ADMIN_ALLOW_COUNTRIES"
+
+	adminDenyCountriesFlagName  = "network-admin-deny-countries"
+	adminDenyCountriesFlagUsage = "Optional. Countries whose clients are denied the admin API." +
+		" Requires a GeoIP database." +
+		" Alternatively, this can be set with the following environment variable: " + adminDenyCountriesEnvKey
+	adminDenyCountriesEnvKey = "HTTP_SERVER_NETWORK_ADMIN_DENY_COUNTRIES"
+
+	geoIPDatabaseFlagName  = "geoip-db"
+	geoIPDatabaseFlagUsage = "Optional. Path to the CSV file of the countries of the networks, with one" +
+		" network,country record per line, e.g. 192.0.2.0/24,CA. Required by the country restrictions." +
+		" Alternatively, this can be set with the following environment variable: " + geoIPDatabaseEnvKey
+	geoIPDatabaseEnvKey = "HTTP_SERVER_GEOIP_DB"
+
+	networkTrustForwardedForFlagName  = "network-trust-forwarded-for"
+	networkTrustForwardedForFlagUsage = "Optional. Set to true to take the client IP addresses of the network" +
+		" policies and of the session binding from the X-Forwarded-For header added by the reverse proxy of the" +
+		" server. Only set behind such a proxy. Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + networkTrustForwardedForEnvKey
+	networkTrustForwardedForEnvKey = "HTTP_SERVER_NETWORK_TRUST_FORWARDED_FOR"
+)
+
+// Endpoint groups.
+const (
+	publicGroup = "public"
+	adminGroup  = "admin"
+)
+
+type networkPolicyParameters struct {
+	public            *netpolicy.Policy
+	admin             *netpolicy.Policy
+	locator           netpolicy.Locator
+	trustForwardedFor bool
+}
+
+// policyFlags are the flags of the network policy of an endpoint group.
+type policyFlags struct {
+	allow, allowEnv                   string
+	deny, denyEnv                     string
+	allowCountries, allowCountriesEnv string
+	denyCountries, denyCountriesEnv   string
+}
+
+func createNetworkPolicyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP(publicAllowFlagName, "", []string{}, publicAllowFlagUsage)
+	cmd.Flags().StringArrayP(publicDenyFlagName, "", []string{}, publicDenyFlagUsage)
+	cmd.Flags().StringArrayP(publicAllowCountriesFlagName, "", []string{}, publicAllowCountriesFlagUsage)
+	cmd.Flags().StringArrayP(publicDenyCountriesFlagName, "", []string{}, publicDenyCountriesFlagUsage)
+	cmd.Flags().StringArrayP(adminAllowFlagName, "", []string{}, adminAllowFlagUsage)
+	cmd.Flags().StringArrayP(adminDenyFlagName, "", []string{}, adminDenyFlagUsage)
+	cmd.Flags().StringArrayP(adminAllowCountriesFlagName, "", []string{}, adminAllowCountriesFlagUsage)
+	cmd.Flags().StringArrayP(adminDenyCountriesFlagName, "", []string{}, adminDenyCountriesFlagUsage)
+	cmd.Flags().StringP(geoIPDatabaseFlagName, "", "", geoIPDatabaseFlagUsage)
+	cmd.Flags().StringP(networkTrustForwardedForFlagName, "", "", networkTrustForwardedForFlagUsage)
+}
+
+// getNetworkPolicyParameters returns nil if all the clients are allowed.
+func getNetworkPolicyParameters(cmd *cobra.Command) (*networkPolicyParameters, error) {
+	public, err := getNetworkPolicy(cmd, &policyFlags{
+		allow: publicAllowFlagName, allowEnv: publicAllowEnvKey,
+		deny: publicDenyFlagName, denyEnv: publicDenyEnvKey,
+		allowCountries: publicAllowCountriesFlagName, allowCountriesEnv: publicAllowCountriesEnvKey,
+		denyCountries: publicDenyCountriesFlagName, denyCountriesEnv: publicDenyCountriesEnvKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := getNetworkPolicy(cmd, &policyFlags{
+		allow: adminAllowFlagName, allowEnv: adminAllowEnvKey,
+		deny: adminDenyFlagName, denyEnv: adminDenyEnvKey,
+		allowCountries: adminAllowCountriesFlagName, allowCountriesEnv: adminAllowCountriesEnvKey,
+		denyCountries: adminDenyCountriesFlagName, denyCountriesEnv: adminDenyCountriesEnvKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if public == nil && admin == nil {
+		return nil, nil
+	}
+
+	params := &networkPolicyParameters{public: public, admin: admin}
+
+	geoIPDatabase := cmdutils.GetUserSetOptionalVarFromString(cmd, geoIPDatabaseFlagName, geoIPDatabaseEnvKey)
+	if geoIPDatabase != "" {
+		params.locator, err = netpolicy.LoadCountries(geoIPDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure network policies: %w", err)
+		}
+	}
+
+	if params.locator == nil && (geofenced(public) || geofenced(admin)) {
+		return nil, fmt.Errorf("the country restrictions of the network policies require the %s option",
+			geoIPDatabaseFlagName)
+	}
+
+	params.trustForwardedFor, err = getTrustForwardedFor(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// getTrustForwardedFor reports whether the client IP addresses are taken from the X-Forwarded-For header.
+func getTrustForwardedFor(cmd *cobra.Command) (bool, error) {
+	trust := cmdutils.GetUserSetOptionalVarFromString(cmd,
+		networkTrustForwardedForFlagName, networkTrustForwardedForEnvKey)
+	if trust == "" {
+		return false, nil
+	}
+
+	trusted, err := strconv.ParseBool(trust)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value '%s': %w", networkTrustForwardedForFlagName, trust, err)
+	}
+
+	return trusted, nil
+}
+
+// getNetworkPolicy returns nil if the policy of the endpoint group allows all the clients.
+func getNetworkPolicy(cmd *cobra.Command, flags *policyFlags) (*netpolicy.Policy, error) {
+	allow, err := cmdutils.GetUserSetVarFromArrayString(cmd, flags.allow, flags.allowEnv, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", flags.allow, err)
+	}
+
+	deny, err := cmdutils.GetUserSetVarFromArrayString(cmd, flags.deny, flags.denyEnv, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", flags.deny, err)
+	}
+
+	allowCountries, err := cmdutils.GetUserSetVarFromArrayString(cmd, flags.allowCountries, flags.allowCountriesEnv,
+		true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", flags.allowCountries, err)
+	}
+
+	denyCountries, err := cmdutils.GetUserSetVarFromArrayString(cmd, flags.denyCountries, flags.denyCountriesEnv, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure %s: %w", flags.denyCountries, err)
+	}
+
+	if len(allow) == 0 && len(deny) == 0 && len(allowCountries) == 0 && len(denyCountries) == 0 {
+		return nil, nil
+	}
+
+	policy := &netpolicy.Policy{}
+
+	policy.Allow, err = netpolicy.ParseNetworks(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", flags.allow, err)
+	}
+
+	policy.Deny, err = netpolicy.ParseNetworks(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", flags.deny, err)
+	}
+
+	policy.AllowCountries, err = netpolicy.ParseCountries(allowCountries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", flags.allowCountries, err)
+	}
+
+	policy.DenyCountries, err = netpolicy.ParseCountries(denyCountries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", flags.denyCountries, err)
+	}
+
+	return policy, nil
+}
+
+func geofenced(policy *netpolicy.Policy) bool {
+	return policy != nil && (len(policy.AllowCountries) > 0 || len(policy.DenyCountries) > 0)
+}
+
+// networkPolicy returns the middleware enforcing the policy of the endpoint group, or nil if there is none.
+func networkPolicy(params *networkPolicyParameters, group string, auditLog *audit.Store) (mux.MiddlewareFunc, error) {
+	if params == nil {
+		return nil, nil
+	}
+
+	policy := params.public
+	if group == adminGroup {
+		policy = params.admin
+	}
+
+	if policy == nil {
+		return nil, nil
+	}
+
+	middleware, err := netpolicy.Middleware(&netpolicy.Config{
+		Group:             group,
+		Policy:            policy,
+		Locator:           params.locator,
+		TrustForwardedFor: params.trustForwardedFor,
+		Audit:             auditLog,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init %s network policy: %w", group, err)
+	}
+
+	return mux.MiddlewareFunc(middleware), nil
+}
+
+// exceptAdmin applies the middleware to the requests outside the admin API, which has its own network policy.
+func exceptAdmin(middleware mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		guarded := middleware(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, adminBasePath) {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			guarded.ServeHTTP(w, r)
+		})
+	}
+}
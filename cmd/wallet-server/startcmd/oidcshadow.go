@@ -0,0 +1,145 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+
+	oidcp "github.com/coreos/go-oidc"
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// OIDC shadow mode config.
+const (
+	oidcShadowProviderURLFlagName  = "oidc-shadow-opurl"
+	oidcShadowProviderURLFlagUsage = "Optional. URL of the OpenID Connect provider the OIDC client is being migrated" +
+		" to. The id_tokens and the user info are then verified with this provider too, in the background, and the" +
+		" results that differ from those of the current provider are logged and counted, without serving the" +
+		" users. The comparisons are limited to the users targeted by the 'oidc-shadow' feature flag, if any." +
+		" Alternatively, this can be set with the following environment variable: " + oidcShadowProviderURLEnvKey
+	oidcShadowProviderURLEnvKey = "HTTP_SERVER_OIDC_SHADOW_OPURL"
+
+	oidcShadowClientIDFlagName  = "oidc-shadow-clientid"
+	oidcShadowClientIDFlagUsage = "OAuth2 client_id at the candidate OIDC provider. Required with " +
+		oidcShadowProviderURLFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + oidcShadowClientIDEnvKey
+	oidcShadowClientIDEnvKey = "HTTP_SERVER_OIDC_SHADOW_CLIENTID"
+
+	oidcShadowClientSecretFlagName  = "oidc-shadow-clientsecret" // nolint:gosec // false positive on 'secret'
+	oidcShadowClientSecretFlagUsage = "OAuth2 client secret at the candidate OIDC provider. Required with " +
+		oidcShadowProviderURLFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + oidcShadowClientSecretEnvKey
+	oidcShadowClientSecretEnvKey = "HTTP_SERVER_OIDC_SHADOW_CLIENTSECRET" // nolint:gosec // false positive on 'SECRET'
+
+	oidcShadowIgnoredClaimsFlagName  = "oidc-shadow-ignored-claims"
+	oidcShadowIgnoredClaimsFlagUsage = "Optional. Claims that may differ between the providers," +
+		" e.g. those the candidate does not support." +
+		" Alternatively, this can be set with the following environment variable: " + oidcShadowIgnoredClaimsEnvKey
+	oidcShadowIgnoredClaimsEnvKey = "HTTP_SERVER_OIDC_SHADOW_IGNORED_CLAIMS"
+
+	oidcShadowStatsPath = "/oidc/shadow/stats"
+)
+
+type oidcShadowParameters struct {
+	providerURL   string
+	clientID      string
+	clientSecret  string
+	ignoredClaims []string
+}
+
+func createOIDCShadowFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(oidcShadowProviderURLFlagName, "", "", oidcShadowProviderURLFlagUsage)
+	cmd.Flags().StringP(oidcShadowClientIDFlagName, "", "", oidcShadowClientIDFlagUsage)
+	cmd.Flags().StringP(oidcShadowClientSecretFlagName, "", "", oidcShadowClientSecretFlagUsage)
+	cmd.Flags().StringArrayP(oidcShadowIgnoredClaimsFlagName, "", []string{}, oidcShadowIgnoredClaimsFlagUsage)
+}
+
+// getOIDCShadowParams returns nil if no candidate provider is configured.
+func getOIDCShadowParams(cmd *cobra.Command) (*oidcShadowParameters, error) {
+	providerURL := cmdutils.GetUserSetOptionalVarFromString(cmd, oidcShadowProviderURLFlagName,
+		oidcShadowProviderURLEnvKey)
+	if providerURL == "" {
+		return nil, nil
+	}
+
+	params := &oidcShadowParameters{
+		providerURL: providerURL,
+		clientID:    cmdutils.GetUserSetOptionalVarFromString(cmd, oidcShadowClientIDFlagName, oidcShadowClientIDEnvKey),
+	}
+
+	params.clientSecret = cmdutils.GetUserSetOptionalVarFromString(cmd, oidcShadowClientSecretFlagName,
+		oidcShadowClientSecretEnvKey)
+
+	if params.clientID == "" || params.clientSecret == "" {
+		return nil, fmt.Errorf("%s requires %s and %s", oidcShadowProviderURLFlagName,
+			oidcShadowClientIDFlagName, oidcShadowClientSecretFlagName)
+	}
+
+	var err error
+
+	params.ignoredClaims, err = cmdutils.GetUserSetVarFromArrayString(cmd, oidcShadowIgnoredClaimsFlagName,
+		oidcShadowIgnoredClaimsEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OIDC shadow ignored claims: %w", err)
+	}
+
+	return params, nil
+}
+
+// shadowOIDCClient returns the primary client as is unless a candidate provider is configured, in which case the
+// results of the primary client are compared to those of the candidate, and the totals served to the admins.
+func shadowOIDCClient(adminRouter *mux.Router, config *httpServerParameters,
+	primary oidc2.Client) (oidc2.Client, error) {
+	if config.oidcShadow == nil {
+		return primary, nil
+	}
+
+	provider, err := initOIDCProvider(config.oidcShadow.providerURL, config.dependencyMaxRetries, config.tls.config,
+		config.proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init candidate OIDC provider: %w", err)
+	}
+
+	shadow, err := oidc2.NewShadow(&oidc2.ShadowConfig{
+		Primary: primary,
+		Candidate: oidc2.NewClient(&oidc2.Config{
+			TLSConfig:    config.tls.config,
+			Proxy:        config.proxy,
+			Provider:     providerAdapter(config, provider),
+			CallbackURL:  config.oidc.callbackURL,
+			ClientID:     config.oidcShadow.clientID,
+			ClientSecret: config.oidcShadow.clientSecret,
+			Scopes:       []string{oidcp.ScopeOpenID, "profile", "email"},
+		}),
+		Features:      config.featureFlags.flags(),
+		IgnoredClaims: config.oidcShadow.ignoredClaims,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init OIDC shadow client: %w", err)
+	}
+
+	if adminRouter != nil {
+		mount(adminRouter, []common.Handler{
+			common.NewHTTPHandler(oidcShadowStatsPath, http.MethodGet,
+				func(w http.ResponseWriter, _ *http.Request) {
+					stats := shadow.Stats()
+					common.WriteResponse(w, logger, &stats)
+				},
+				&common.OperationSpec{
+					Summary:   "Returns the totals of the comparisons of the candidate OIDC provider.",
+					Responses: map[int]interface{}{http.StatusOK: &oidc2.ShadowStats{}},
+				}),
+		}, config.middleware, config.openapi)
+	}
+
+	return shadow, nil
+}
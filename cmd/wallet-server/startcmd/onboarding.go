@@ -0,0 +1,48 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Onboarding template config.
+const (
+	onboardingTemplateFlagName  = "onboarding-template"
+	onboardingTemplateFlagUsage = "Optional. Path to a YAML or JSON file of the templates choosing the resources" +
+		" provisioned for new users among authz-keystore, ops-vault, ops-keystore, ops-keys and user-vault, with the" +
+		" resources each depends on and an optional per-resource timeout, e.g. 'default: {resources: [{name:" +
+		" authz-keystore}, {name: ops-keystore, depends-on: [authz-keystore]}, {name: ops-keys, depends-on:" +
+		" [ops-keystore], params: {timeout: 10s}}]}', and optionally per tenant under 'tenants'. The users of a" +
+		" tenant with a template of its own are never onboarded from the provisioning pool. Defaults to all the" +
+		" resources of the deployment." +
+		" Alternatively, this can be set with the following environment variable: " + onboardingTemplateEnvKey
+	onboardingTemplateEnvKey = "HTTP_SERVER_ONBOARDING_TEMPLATE"
+)
+
+func createOnboardingTemplateFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(onboardingTemplateFlagName, "", "", onboardingTemplateFlagUsage)
+}
+
+// getOnboardingTemplates returns nil if the users are provisioned with the resources of the deployment.
+func getOnboardingTemplates(cmd *cobra.Command) (*oidc.OnboardingTemplates, error) {
+	file := cmdutils.GetUserSetOptionalVarFromString(cmd, onboardingTemplateFlagName, onboardingTemplateEnvKey)
+	if file == "" {
+		return nil, nil
+	}
+
+	templates, err := oidc.LoadOnboardingTemplates(file)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("onboarding the users with the templates of %s, and of %d tenants", file, len(templates.Tenants))
+
+	return templates, nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Outbox config.
+const (
+	outboxMaxAttemptsFlagName  = "outbox-max-attempts"
+	outboxMaxAttemptsFlagUsage = "Optional. Number of failed deliveries of an event to the webhooks after which it" +
+		" is dead, no longer holding back the later events of its user. The deliveries are retried with an" +
+		" exponential backoff of up to 1h, and the dead events are kept for the admins to requeue or discard." +
+		" Defaults to 12." +
+		" Alternatively, this can be set with the following environment variable: " + outboxMaxAttemptsEnvKey
+	outboxMaxAttemptsEnvKey = "HTTP_SERVER_OUTBOX_MAX_ATTEMPTS"
+)
+
+func createOutboxFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(outboxMaxAttemptsFlagName, "", "", outboxMaxAttemptsFlagUsage)
+}
+
+// getOutboxMaxAttempts returns 0 for the default number of attempts.
+func getOutboxMaxAttempts(cmd *cobra.Command) (int, error) {
+	value := cmdutils.GetUserSetOptionalVarFromString(cmd, outboxMaxAttemptsFlagName, outboxMaxAttemptsEnvKey)
+	if value == "" {
+		return 0, nil
+	}
+
+	attempts, err := strconv.Atoi(value)
+	if err != nil || attempts < 1 {
+		return 0, fmt.Errorf("invalid %s value '%s': must be a positive integer", outboxMaxAttemptsFlagName, value)
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Provisioning pool config.
+const (
+	provisioningPoolSizeFlagName  = "provisioning-pool-size"
+	provisioningPoolSizeFlagUsage = "Optional. Number of keystore and vault sets provisioned ahead of the first" +
+		" login of new users, with a client credentials token of the OAuth2 client for OIDC." +
+		" New users are provisioned on demand if not set or while the pool is empty." +
+		" Alternatively, this can be set with the following environment variable: " + provisioningPoolSizeEnvKey
+	provisioningPoolSizeEnvKey = "HTTP_SERVER_PROVISIONING_POOL_SIZE"
+
+	provisioningPoolIntervalFlagName  = "provisioning-pool-interval"
+	provisioningPoolIntervalFlagUsage = "Optional. Interval between the refills of the provisioning pool, e.g. 5m." +
+		" Defaults to 1m. The pool is also refilled once a set is claimed." +
+		" Alternatively, this can be set with the following environment variable: " + provisioningPoolIntervalEnvKey
+	provisioningPoolIntervalEnvKey = "HTTP_SERVER_PROVISIONING_POOL_INTERVAL"
+)
+
+func createProvisioningPoolFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(provisioningPoolSizeFlagName, "", "", provisioningPoolSizeFlagUsage)
+	cmd.Flags().StringP(provisioningPoolIntervalFlagName, "", "", provisioningPoolIntervalFlagUsage)
+}
+
+// getProvisioningPoolConfig returns nil if new users are provisioned on demand. The token of the pool is set
+// once the OIDC client is created.
+func getProvisioningPoolConfig(cmd *cobra.Command) (*oidc.ProvisioningPoolConfig, error) {
+	sizeConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, provisioningPoolSizeFlagName, provisioningPoolSizeEnvKey)
+	if sizeConfig == "" {
+		return nil, nil
+	}
+
+	size, err := strconv.Atoi(sizeConfig)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid %s value '%s': must be a non-negative integer",
+			provisioningPoolSizeFlagName, sizeConfig)
+	}
+
+	if size == 0 {
+		return nil, nil
+	}
+
+	config := &oidc.ProvisioningPoolConfig{Size: size}
+
+	intervalConfig := cmdutils.GetUserSetOptionalVarFromString(cmd,
+		provisioningPoolIntervalFlagName, provisioningPoolIntervalEnvKey)
+	if intervalConfig != "" {
+		config.Interval, err = time.ParseDuration(intervalConfig)
+		if err != nil || config.Interval <= 0 {
+			return nil, fmt.Errorf("invalid %s value '%s': must be a positive duration",
+				provisioningPoolIntervalFlagName, intervalConfig)
+		}
+	}
+
+	return config, nil
+}
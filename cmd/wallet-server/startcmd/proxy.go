@@ -0,0 +1,73 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/proxy"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Outbound proxy config.
+const (
+	proxyURLFlagName  = "proxy-url"
+	proxyURLFlagUsage = "Optional. URL of the proxy the requests to the OIDC provider, hub-auth, KMS and EDV" +
+		" servers go through, e.g. http://proxy.example.com:3128. The requests connect directly if not set." +
+		" Alternatively, this can be set with the following environment variable: " + proxyURLEnvKey
+	proxyURLEnvKey = "HTTP_SERVER_PROXY_URL"
+
+	proxyUsernameFlagName  = "proxy-username"
+	proxyUsernameFlagUsage = "Optional. Username authenticating the agent to the proxy." +
+		" Alternatively, this can be set with the following environment variable: " + proxyUsernameEnvKey
+	proxyUsernameEnvKey = "HTTP_SERVER_PROXY_USERNAME"
+
+	proxyPasswordFlagName  = "proxy-password"
+	proxyPasswordFlagUsage = "Optional. Password authenticating the agent to the proxy." +
+		" Alternatively, this can be set with the following environment variable: " + proxyPasswordEnvKey
+	proxyPasswordEnvKey = "HTTP_SERVER_PROXY_PASSWORD" // nolint:gosec // false positive on 'PASSWORD'
+
+	noProxyFlagName  = "no-proxy"
+	noProxyFlagUsage = "Optional. Comma-separated list of destinations reached without the proxy: host names," +
+		" domains starting with '.', IP addresses and CIDR ranges, optionally followed by a port, or URLs," +
+		" e.g. kms.internal,.svc.cluster.local,10.0.0.0/8,https://edv.example.com:8443." +
+		" Alternatively, this can be set with the following environment variable: " + noProxyEnvKey
+	noProxyEnvKey = "HTTP_SERVER_NO_PROXY"
+)
+
+func createProxyFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(proxyURLFlagName, "", "", proxyURLFlagUsage)
+	cmd.Flags().StringP(proxyUsernameFlagName, "", "", proxyUsernameFlagUsage)
+	cmd.Flags().StringP(proxyPasswordFlagName, "", "", proxyPasswordFlagUsage)
+	cmd.Flags().StringArrayP(noProxyFlagName, "", []string{}, noProxyFlagUsage)
+}
+
+// getProxy returns nil if the requests do not go through a proxy.
+func getProxy(cmd *cobra.Command) (proxy.Func, error) {
+	proxyURL := cmdutils.GetUserSetOptionalVarFromString(cmd, proxyURLFlagName, proxyURLEnvKey)
+	if proxyURL == "" {
+		return nil, nil
+	}
+
+	noProxy, err := cmdutils.GetUserSetVarFromArrayString(cmd, noProxyFlagName, noProxyEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure no-proxy destinations: %w", err)
+	}
+
+	proxyFunc, err := proxy.New(&proxy.Config{
+		URL:      proxyURL,
+		Username: cmdutils.GetUserSetOptionalVarFromString(cmd, proxyUsernameFlagName, proxyUsernameEnvKey),
+		Password: cmdutils.GetUserSetOptionalVarFromString(cmd, proxyPasswordFlagName, proxyPasswordEnvKey),
+		NoProxy:  noProxy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxy: %w", err)
+	}
+
+	return proxyFunc, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Pseudonym config.
+const (
+	pseudonymHMACKeyFlagName  = "pseudonym-hmac-key"
+	pseudonymHMACKeyFlagUsage = "Optional. Path to the 32-byte key deriving the pseudonymous IDs of the users from" +
+		" their subs. The users are then stored under their IDs, which also control their keystores, and their subs" +
+		" are only kept encrypted with " + pseudonymEncKeyFlagName + ". Set it on new deployments only: the users" +
+		" onboarded with their subs are not found under their IDs. The subs are used as is if not set." +
+		" Alternatively, this can be set with the following environment variable: " + pseudonymHMACKeyEnvKey
+	pseudonymHMACKeyEnvKey = "HTTP_SERVER_PSEUDONYM_HMAC_KEY"
+
+	pseudonymEncKeyFlagName  = "pseudonym-enc-key"
+	pseudonymEncKeyFlagUsage = "Path to the 32-byte key encrypting the subs of the pseudonymous IDs of the users." +
+		" Required if " + pseudonymHMACKeyFlagName + " is set, unless the key is held in an HSM." +
+		" Alternatively, this can be set with the following environment variable: " + pseudonymEncKeyEnvKey
+	pseudonymEncKeyEnvKey = "HTTP_SERVER_PSEUDONYM_ENC_KEY"
+)
+
+func createPseudonymFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(pseudonymHMACKeyFlagName, "", "", pseudonymHMACKeyFlagUsage)
+	cmd.Flags().StringP(pseudonymEncKeyFlagName, "", "", pseudonymEncKeyFlagUsage)
+}
+
+// getPseudonymConfig returns nil if the subs of the users are used as is. The storage is set by the router.
+func getPseudonymConfig(cmd *cobra.Command, hsmParams *hsmParameters) (*pseudonym.Config, error) {
+	hmacKeyPath := cmdutils.GetUserSetOptionalVarFromString(cmd, pseudonymHMACKeyFlagName, pseudonymHMACKeyEnvKey)
+	if hmacKeyPath == "" {
+		return nil, nil
+	}
+
+	hmacKey, err := parseKey(hmacKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure pseudonym hmac key: %w", err)
+	}
+
+	if hsmParams != nil && hsmParams.pseudonymKeyLabel != "" {
+		cipher, cipherErr := hsmParams.keys.Cipher(hsmParams.pseudonymKeyLabel)
+		if cipherErr != nil {
+			return nil, fmt.Errorf("failed to configure pseudonym enc key: %w", cipherErr)
+		}
+
+		return &pseudonym.Config{HMACKey: hmacKey, Cipher: cipher}, nil
+	}
+
+	encKeyPath, err := cmdutils.GetUserSetVarFromString(cmd, pseudonymEncKeyFlagName, pseudonymEncKeyEnvKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	encKey, err := parseKey(encKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure pseudonym enc key: %w", err)
+	}
+
+	return &pseudonym.Config{HMACKey: hmacKey, EncryptionKey: encKey}, nil
+}
+
+// pseudonyms returns nil if the subs of the users are used as is.
+func pseudonyms(config *pseudonym.Config, store storage.Provider) (*pseudonym.Mapper, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	config.Storage = store
+
+	ids, err := pseudonym.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init pseudonyms: %w", err)
+	}
+
+	return ids, nil
+}
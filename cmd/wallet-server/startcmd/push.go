@@ -0,0 +1,237 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/agent"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	push2 "github.com/trustbloc/edge-agent/pkg/restapi/common/push"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+	"github.com/trustbloc/edge-agent/pkg/restapi/push"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+	"golang.org/x/oauth2"
+)
+
+// Push notifications config.
+const (
+	pushFCMCredentialsFlagName  = "push-fcm-credentials"
+	pushFCMCredentialsFlagUsage = "Optional. Path of the JSON key of the Firebase service account sending the" +
+		" push notifications of the Android and iOS apps registered with FCM." +
+		" Alternatively, this can be set with the following environment variable: " + pushFCMCredentialsEnvKey
+	pushFCMCredentialsEnvKey = "HTTP_SERVER_PUSH_FCM_CREDENTIALS"
+
+	pushAPNsKeyFlagName  = "push-apns-key"
+	pushAPNsKeyFlagUsage = "Optional. Path of the .p8 token signing key sending the push notifications of the iOS" +
+		" apps registered with APNs. Requires the APNs key id, team id and topic." +
+		" Alternatively, this can be set with the following environment variable: " + pushAPNsKeyEnvKey
+	pushAPNsKeyEnvKey = "HTTP_SERVER_PUSH_APNS_KEY"
+
+	pushAPNsKeyIDFlagName  = "push-apns-key-id"
+	pushAPNsKeyIDFlagUsage = "Key id of the APNs token signing key." +
+		" Alternatively, this can be set with the following environment variable: " + pushAPNsKeyIDEnvKey
+	pushAPNsKeyIDEnvKey = "HTTP_SERVER_PUSH_APNS_KEY_ID"
+
+	pushAPNsTeamIDFlagName  = "push-apns-team-id"
+	pushAPNsTeamIDFlagUsage = "Team id of the Apple developer account of the APNs token signing key." +
+		" Alternatively, this can be set with the following environment variable: " + pushAPNsTeamIDEnvKey
+	pushAPNsTeamIDEnvKey = "HTTP_SERVER_PUSH_APNS_TEAM_ID"
+
+	pushAPNsTopicFlagName  = "push-apns-topic"
+	pushAPNsTopicFlagUsage = "Bundle id of the iOS app receiving the APNs notifications." +
+		" Alternatively, this can be set with the following environment variable: " + pushAPNsTopicEnvKey
+	pushAPNsTopicEnvKey = "HTTP_SERVER_PUSH_APNS_TOPIC"
+
+	pushAPNsURLFlagName  = "push-apns-url"
+	pushAPNsURLFlagUsage = "Optional. URL of APNs, e.g. " + push2.APNsDevelopmentURL +
+		" for the development builds of the app. Defaults to " + push2.APNsProductionURL + "." +
+		" Alternatively, this can be set with the following environment variable: " + pushAPNsURLEnvKey
+	pushAPNsURLEnvKey = "HTTP_SERVER_PUSH_APNS_URL"
+
+	pushVAPIDKeyFlagName  = "push-vapid-key"
+	pushVAPIDKeyFlagUsage = "Optional. Path of the PEM encoded P-256 VAPID key sending the WebPush notifications" +
+		" of the browsers. Requires the VAPID subject." +
+		" Alternatively, this can be set with the following environment variable: " + pushVAPIDKeyEnvKey
+	pushVAPIDKeyEnvKey = "HTTP_SERVER_PUSH_VAPID_KEY"
+
+	pushVAPIDSubjectFlagName  = "push-vapid-subject"
+	pushVAPIDSubjectFlagUsage = "mailto: or https: URL the push services contact the operator of the server at." +
+		" Alternatively, this can be set with the following environment variable: " + pushVAPIDSubjectEnvKey
+	pushVAPIDSubjectEnvKey = "HTTP_SERVER_PUSH_VAPID_SUBJECT"
+)
+
+type pushParameters struct {
+	fcmCredentials []byte
+	apns           *push2.APNsConfig
+	webPush        *push2.WebPushConfig
+}
+
+func createPushFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(pushFCMCredentialsFlagName, "", "", pushFCMCredentialsFlagUsage)
+	cmd.Flags().StringP(pushAPNsKeyFlagName, "", "", pushAPNsKeyFlagUsage)
+	cmd.Flags().StringP(pushAPNsKeyIDFlagName, "", "", pushAPNsKeyIDFlagUsage)
+	cmd.Flags().StringP(pushAPNsTeamIDFlagName, "", "", pushAPNsTeamIDFlagUsage)
+	cmd.Flags().StringP(pushAPNsTopicFlagName, "", "", pushAPNsTopicFlagUsage)
+	cmd.Flags().StringP(pushAPNsURLFlagName, "", "", pushAPNsURLFlagUsage)
+	cmd.Flags().StringP(pushVAPIDKeyFlagName, "", "", pushVAPIDKeyFlagUsage)
+	cmd.Flags().StringP(pushVAPIDSubjectFlagName, "", "", pushVAPIDSubjectFlagUsage)
+}
+
+// getPushParams returns nil if no push provider is configured.
+func getPushParams(cmd *cobra.Command) (*pushParameters, error) {
+	params := &pushParameters{}
+
+	fcmCredentials := cmdutils.GetUserSetOptionalVarFromString(cmd, pushFCMCredentialsFlagName,
+		pushFCMCredentialsEnvKey)
+	if fcmCredentials != "" {
+		var err error
+
+		params.fcmCredentials, err = ioutil.ReadFile(filepath.Clean(fcmCredentials))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FCM credentials: %w", err)
+		}
+	}
+
+	apnsKey := cmdutils.GetUserSetOptionalVarFromString(cmd, pushAPNsKeyFlagName, pushAPNsKeyEnvKey)
+	if apnsKey != "" {
+		key, err := readECKey(apnsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load APNs key: %w", err)
+		}
+
+		params.apns = &push2.APNsConfig{
+			Key:    key,
+			KeyID:  cmdutils.GetUserSetOptionalVarFromString(cmd, pushAPNsKeyIDFlagName, pushAPNsKeyIDEnvKey),
+			TeamID: cmdutils.GetUserSetOptionalVarFromString(cmd, pushAPNsTeamIDFlagName, pushAPNsTeamIDEnvKey),
+			Topic:  cmdutils.GetUserSetOptionalVarFromString(cmd, pushAPNsTopicFlagName, pushAPNsTopicEnvKey),
+			URL:    cmdutils.GetUserSetOptionalVarFromString(cmd, pushAPNsURLFlagName, pushAPNsURLEnvKey),
+		}
+
+		if params.apns.KeyID == "" || params.apns.TeamID == "" || params.apns.Topic == "" {
+			return nil, fmt.Errorf("the APNs key requires %s, %s and %s",
+				pushAPNsKeyIDFlagName, pushAPNsTeamIDFlagName, pushAPNsTopicFlagName)
+		}
+	}
+
+	vapidKey := cmdutils.GetUserSetOptionalVarFromString(cmd, pushVAPIDKeyFlagName, pushVAPIDKeyEnvKey)
+	if vapidKey != "" {
+		key, err := readECKey(vapidKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load VAPID key: %w", err)
+		}
+
+		params.webPush = &push2.WebPushConfig{
+			Key:     key,
+			Subject: cmdutils.GetUserSetOptionalVarFromString(cmd, pushVAPIDSubjectFlagName, pushVAPIDSubjectEnvKey),
+		}
+
+		if params.webPush.Subject == "" {
+			return nil, fmt.Errorf("the VAPID key requires %s", pushVAPIDSubjectFlagName)
+		}
+	}
+
+	if params.fcmCredentials == nil && params.apns == nil && params.webPush == nil {
+		return nil, nil
+	}
+
+	return params, nil
+}
+
+func readECKey(file string) (*ecdsa.PrivateKey, error) {
+	bits, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+
+	return push2.ParseECKey(bits)
+}
+
+// addPushHandlers pushes the DIDComm messages and the credentials received by the agent to the devices of their
+// user, while the user has no notification stream open.
+func addPushHandlers(router *mux.Router, config *httpServerParameters, store storage.Provider, bus *events.Bus,
+	presence push.Presence, middleware []common.Middleware) error {
+	httpClient := sds.NewHTTPClient(config.tls.config, config.proxy)
+	providers := make(map[string]push2.Provider)
+
+	pushConfig := &push.Config{
+		Events:  bus,
+		Storage: store,
+		Keys: &push.KeyConfig{
+			Ring: config.keys.sessionCookieKeys,
+		},
+		Providers: providers,
+		Alerts: map[string]*push.Alert{
+			agent.TopicDIDCommMessage: {
+				Title: "New message",
+				Body:  "You have a new message in your wallet.",
+			},
+			agent.TopicCredentialReceived: {
+				Title: "New credential",
+				Body:  "A new credential was saved to your wallet.",
+			},
+		},
+		Presence: presence,
+	}
+
+	if config.push.fcmCredentials != nil {
+		projectID, tokens, err := push2.FCMCredentials(
+			context.WithValue(context.Background(), oauth2.HTTPClient, httpClient), config.push.fcmCredentials)
+		if err != nil {
+			return fmt.Errorf("failed to load FCM credentials: %w", err)
+		}
+
+		providers[push2.PlatformFCM], err = push2.NewFCM(&push2.FCMConfig{
+			ProjectID:   projectID,
+			TokenSource: tokens,
+			HTTPClient:  httpClient,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to init FCM: %w", err)
+		}
+	}
+
+	if config.push.apns != nil {
+		config.push.apns.HTTPClient = httpClient
+
+		apns, err := push2.NewAPNs(config.push.apns)
+		if err != nil {
+			return fmt.Errorf("failed to init APNs: %w", err)
+		}
+
+		providers[push2.PlatformAPNs] = apns
+	}
+
+	if config.push.webPush != nil {
+		config.push.webPush.HTTPClient = httpClient
+
+		webPush, err := push2.NewWebPush(config.push.webPush)
+		if err != nil {
+			return fmt.Errorf("failed to init WebPush: %w", err)
+		}
+
+		providers[push2.PlatformWebPush] = webPush
+		pushConfig.VAPIDPublicKey = webPush.PublicKey()
+	}
+
+	pushOps, err := push.New(pushConfig)
+	if err != nil {
+		return fmt.Errorf("failed to init push ops: %w", err)
+	}
+
+	mount(router, pushOps.GetRESTHandlers(), middleware, config.openapi)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Quota config.
+const (
+	quotaDocumentsFlagName  = "quota-documents"
+	quotaDocumentsFlagUsage = "Optional. Maximum number of documents the agent writes to the vault of each user:" +
+		" credentials, attachment chunks, backups and preferences. The writes over quota are answered with 507." +
+		" Unlimited if not set." +
+		" Alternatively, this can be set with the following environment variable: " + quotaDocumentsEnvKey
+	quotaDocumentsEnvKey = "HTTP_SERVER_QUOTA_DOCUMENTS"
+
+	quotaKeysFlagName  = "quota-keys"
+	quotaKeysFlagUsage = "Optional. Maximum number of keys the agent creates in the keystores of each user." +
+		" The rotations of the keys of the users over quota fail. Unlimited if not set." +
+		" Alternatively, this can be set with the following environment variable: " + quotaKeysEnvKey
+	quotaKeysEnvKey = "HTTP_SERVER_QUOTA_KEYS"
+)
+
+func createQuotaFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(quotaDocumentsFlagName, "", "", quotaDocumentsFlagUsage)
+	cmd.Flags().StringP(quotaKeysFlagName, "", "", quotaKeysFlagUsage)
+}
+
+// getQuotaConfig returns nil if the resources of the users are unlimited.
+func getQuotaConfig(cmd *cobra.Command) (*oidc.QuotaConfig, error) {
+	documentsConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, quotaDocumentsFlagName, quotaDocumentsEnvKey)
+	keysConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, quotaKeysFlagName, quotaKeysEnvKey)
+
+	if documentsConfig == "" && keysConfig == "" {
+		return nil, nil
+	}
+
+	config := &oidc.QuotaConfig{}
+
+	var err error
+
+	config.Documents, err = parseQuota(quotaDocumentsFlagName, documentsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Keys, err = parseQuota(quotaKeysFlagName, keysConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// parseQuota returns 0, which is unlimited, if the quota is not set.
+func parseQuota(flagName, value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	quota, err := strconv.Atoi(value)
+	if err != nil || quota < 1 {
+		return 0, fmt.Errorf("invalid %s value '%s': must be a positive integer", flagName, value)
+	}
+
+	return quota, nil
+}
@@ -0,0 +1,37 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Remember-me config.
+const (
+	rememberTTLFlagName  = "remember-me-ttl"
+	rememberTTLFlagUsage = "Optional. How long the users who ask for it at login stay logged in past the expiry of" +
+		" their session, e.g. 720h. The users are not remembered if not set." +
+		" Alternatively, this can be set with the following environment variable: " + rememberTTLEnvKey
+	rememberTTLEnvKey = "HTTP_SERVER_REMEMBER_ME_TTL"
+)
+
+func createRememberFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(rememberTTLFlagName, "", "", rememberTTLFlagUsage)
+}
+
+// getRememberTTL returns 0 if the users are not remembered.
+func getRememberTTL(cmd *cobra.Command) (time.Duration, error) {
+	ttl := cmdutils.GetUserSetOptionalVarFromString(cmd, rememberTTLFlagName, rememberTTLEnvKey)
+	if ttl == "" {
+		return 0, nil
+	}
+
+	return parsePositiveDuration(rememberTTLFlagName, ttl)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Vault key rotation config.
+const (
+	keyRotationFlagName  = "vault-key-rotation"
+	keyRotationFlagUsage = "Optional. Set to true to let the admins rotate the keys of the EDV vaults of the users" +
+		" with the admin API: new keys are created, the documents of the vault are re-encrypted with them in the" +
+		" background and the bootstrap data of the user is updated. Defaults to false." +
+		" Alternatively, this can be set with the following environment variable: " + keyRotationEnvKey
+	keyRotationEnvKey = "HTTP_SERVER_VAULT_KEY_ROTATION"
+
+	keyRotationIntervalFlagName  = "vault-key-rotation-interval"
+	keyRotationIntervalFlagUsage = "Optional. Age of the keys of the EDV vaults rotated on a schedule, e.g. 2160h." +
+		" Enables " + keyRotationFlagName + ". The keys are only rotated on request of the admins if not set." +
+		" Alternatively, this can be set with the following environment variable: " + keyRotationIntervalEnvKey
+	keyRotationIntervalEnvKey = "HTTP_SERVER_VAULT_KEY_ROTATION_INTERVAL"
+
+	keyRotationBatchSizeFlagName  = "vault-key-rotation-batch-size"
+	keyRotationBatchSizeFlagUsage = "Optional. Number of documents re-encrypted between two saves of the progress" +
+		" of a rotation. Defaults to 100." +
+		" Alternatively, this can be set with the following environment variable: " + keyRotationBatchSizeEnvKey
+	keyRotationBatchSizeEnvKey = "HTTP_SERVER_VAULT_KEY_ROTATION_BATCH_SIZE"
+
+	keyRotationStoresFlagName  = "vault-key-rotation-stores"
+	keyRotationStoresFlagUsage = "Optional. Stores of the EDV vaults whose documents are re-encrypted." +
+		" Defaults to the credentials store." +
+		" Alternatively, this can be set with the following environment variable (in CSV format): " +
+		keyRotationStoresEnvKey
+	keyRotationStoresEnvKey = "HTTP_SERVER_VAULT_KEY_ROTATION_STORES"
+)
+
+func createKeyRotationFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(keyRotationFlagName, "", "", keyRotationFlagUsage)
+	cmd.Flags().StringP(keyRotationIntervalFlagName, "", "", keyRotationIntervalFlagUsage)
+	cmd.Flags().StringP(keyRotationBatchSizeFlagName, "", "", keyRotationBatchSizeFlagUsage)
+	cmd.Flags().StringArrayP(keyRotationStoresFlagName, "", []string{}, keyRotationStoresFlagUsage)
+}
+
+// getKeyRotationConfig returns nil if the keys of the vaults are not rotated.
+func getKeyRotationConfig(cmd *cobra.Command) (*oidc.KeyRotationConfig, error) {
+	config := &oidc.KeyRotationConfig{}
+
+	var err error
+
+	interval := cmdutils.GetUserSetOptionalVarFromString(cmd, keyRotationIntervalFlagName, keyRotationIntervalEnvKey)
+	if interval != "" {
+		config.Interval, err = parsePositiveDuration(keyRotationIntervalFlagName, interval)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	enabled := interval != ""
+
+	enabledConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, keyRotationFlagName, keyRotationEnvKey)
+	if enabledConfig != "" && !enabled {
+		enabled, err = strconv.ParseBool(enabledConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", keyRotationFlagName, enabledConfig, err)
+		}
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	batchSize := cmdutils.GetUserSetOptionalVarFromString(cmd, keyRotationBatchSizeFlagName, keyRotationBatchSizeEnvKey)
+	if batchSize != "" {
+		config.BatchSize, err = strconv.Atoi(batchSize)
+		if err != nil || config.BatchSize < 1 {
+			return nil, fmt.Errorf("invalid %s value '%s': must be a positive integer",
+				keyRotationBatchSizeFlagName, batchSize)
+		}
+	}
+
+	config.Stores, err = cmdutils.GetUserSetVarFromArrayString(cmd,
+		keyRotationStoresFlagName, keyRotationStoresEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/audit"
+	audit2 "github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/scim"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// SCIM config.
+const (
+	scimTokenFlagName  = "scim-token"
+	scimTokenFlagUsage = "Optional. Bearer token the IdP of the enterprise calls the SCIM endpoint under " +
+		scimBasePath + " with to deactivate and deprovision the users. The SCIM endpoint is disabled if not set." +
+		" Alternatively, this can be set with the following environment variable: " + scimTokenEnvKey
+	scimTokenEnvKey = "HTTP_SERVER_SCIM_TOKEN"
+)
+
+const scimBasePath = "/scim/v2/"
+
+func createSCIMFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(scimTokenFlagName, "", "", scimTokenFlagUsage)
+}
+
+func getSCIMToken(cmd *cobra.Command) string {
+	return cmdutils.GetUserSetOptionalVarFromString(cmd, scimTokenFlagName, scimTokenEnvKey)
+}
+
+// addSCIMHandlers serves the SCIM endpoint to the IdP bearing the SCIM token, if any.
+func addSCIMHandlers(root *mux.Router, config *httpServerParameters, users *oidc.Operation,
+	auditLog *audit2.Store) error {
+	if config.scimToken == "" {
+		return nil
+	}
+
+	scimOps, err := scim.New(&scim.Config{Users: users})
+	if err != nil {
+		return fmt.Errorf("failed to init scim ops: %w", err)
+	}
+
+	scimRouter := root.PathPrefix(scimBasePath).Subrouter()
+	scimRouter.Use(mux.MiddlewareFunc(audit.Middleware(auditLog)), adminAuth(config.scimToken))
+
+	mount(scimRouter, scimOps.GetRESTHandlers(), config.middleware, config.openapi)
+
+	return nil
+}
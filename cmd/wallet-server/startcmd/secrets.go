@@ -0,0 +1,343 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/mtls"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/secrets"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Secrets provider config.
+const (
+	secretsProviderFlagName  = "secrets-provider"
+	secretsProviderFlagUsage = "Optional. Secrets manager holding the cookie keys, the OIDC client secret and the" +
+		" TLS client certificate named by the secret-* options: " + vaultSecretsProvider + " or " +
+		awsSecretsProvider + ". The secrets are read from their static options if not set." +
+		" Alternatively, this can be set with the following environment variable: " + secretsProviderEnvKey
+	secretsProviderEnvKey = "HTTP_SERVER_SECRETS_PROVIDER"
+
+	secretsRefreshIntervalFlagName  = "secrets-refresh-interval"
+	secretsRefreshIntervalFlagUsage = "Optional. Interval between the fetches of the secrets, picking up their" +
+		" rotations, e.g. 10m. Defaults to 5m." +
+		" Alternatively, this can be set with the following environment variable: " + secretsRefreshIntervalEnvKey
+	secretsRefreshIntervalEnvKey = "HTTP_SERVER_SECRETS_REFRESH_INTERVAL"
+
+	vaultSecretsProvider = "vault"
+	awsSecretsProvider   = "aws"
+)
+
+// Vault config.
+const (
+	vaultAddressFlagName  = "vault-address"
+	vaultAddressFlagUsage = "Address of the HashiCorp Vault server, e.g. https://vault.example.com:8200." +
+		" Alternatively, this can be set with the following environment variable: " + vaultAddressEnvKey
+	vaultAddressEnvKey = "HTTP_SERVER_VAULT_ADDRESS"
+
+	vaultTokenFlagName  = "vault-token"
+	vaultTokenFlagUsage = "Token authenticating the agent to the Vault server." +
+		" Alternatively, this can be set with the following environment variable: " + vaultTokenEnvKey
+	vaultTokenEnvKey = "HTTP_SERVER_VAULT_TOKEN" // nolint:gosec // false positive on 'TOKEN'
+
+	vaultMountFlagName  = "vault-mount"
+	vaultMountFlagUsage = "Optional. Path of the KV version 2 secrets engine. Defaults to secret." +
+		" Alternatively, this can be set with the following environment variable: " + vaultMountEnvKey
+	vaultMountEnvKey = "HTTP_SERVER_VAULT_MOUNT"
+)
+
+// AWS Secrets Manager config.
+const (
+	awsRegionFlagName  = "aws-region"
+	awsRegionFlagUsage = "AWS region of the secrets." +
+		" Alternatively, this can be set with the following environment variable: " + awsRegionEnvKey
+	awsRegionEnvKey = "HTTP_SERVER_AWS_REGION"
+
+	awsAccessKeyIDFlagName  = "aws-access-key-id"
+	awsAccessKeyIDFlagUsage = "AWS access key ID of the agent." +
+		" Alternatively, this can be set with the following environment variable: " + awsAccessKeyIDEnvKey
+	awsAccessKeyIDEnvKey = "HTTP_SERVER_AWS_ACCESS_KEY_ID"
+
+	awsSecretAccessKeyFlagName  = "aws-secret-access-key" // nolint:gosec // false positive on 'secret'
+	awsSecretAccessKeyFlagUsage = "AWS secret access key of the agent." +
+		" Alternatively, this can be set with the following environment variable: " + awsSecretAccessKeyEnvKey
+	awsSecretAccessKeyEnvKey = "HTTP_SERVER_AWS_SECRET_ACCESS_KEY" // nolint:gosec // false positive on 'SECRET'
+
+	awsSessionTokenFlagName  = "aws-session-token"
+	awsSessionTokenFlagUsage = "Optional. AWS session token of temporary credentials." +
+		" Alternatively, this can be set with the following environment variable: " + awsSessionTokenEnvKey
+	awsSessionTokenEnvKey = "HTTP_SERVER_AWS_SESSION_TOKEN" // nolint:gosec // false positive on 'TOKEN'
+
+	awsSecretsEndpointFlagName  = "aws-secrets-endpoint"
+	awsSecretsEndpointFlagUsage = "Optional. Endpoint of AWS Secrets Manager, e.g. a VPC endpoint." +
+		" Defaults to the endpoint of the region." +
+		" Alternatively, this can be set with the following environment variable: " + awsSecretsEndpointEnvKey
+	awsSecretsEndpointEnvKey = "HTTP_SERVER_AWS_SECRETS_ENDPOINT"
+)
+
+// Secret names.
+const (
+	cookieAuthKeySecretFlagName  = "secret-cookie-auth-key"
+	cookieAuthKeySecretFlagUsage = "Optional. Name of the secret holding the 32-byte key authenticating session" +
+		" cookies, raw or base64-encoded. Replaces " + sessionCookieAuthKeyFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + cookieAuthKeySecretEnvKey
+	cookieAuthKeySecretEnvKey = "HTTP_SERVER_SECRET_COOKIE_AUTH_KEY"
+
+	cookieEncKeySecretFlagName  = "secret-cookie-enc-key"
+	cookieEncKeySecretFlagUsage = "Optional. Name of the secret holding the 32-byte key encrypting session" +
+		" cookies, raw or base64-encoded. Replaces " + sessionCookieEncKeyFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + cookieEncKeySecretEnvKey
+	cookieEncKeySecretEnvKey = "HTTP_SERVER_SECRET_COOKIE_ENC_KEY"
+
+	oidcClientSecretSecretFlagName  = "secret-oidc-clientsecret" // nolint:gosec // false positive on 'secret'
+	oidcClientSecretSecretFlagUsage = "Optional. Name of the secret holding the OAuth2 client secret for OIDC." +
+		" Replaces " + oidcClientSecretFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + oidcClientSecretSecretEnvKey
+	oidcClientSecretSecretEnvKey = "HTTP_SERVER_SECRET_OIDC_CLIENTSECRET" // nolint:gosec // false positive on 'SECRET'
+
+	tlsClientCertSecretFlagName  = "secret-tls-client-cert"
+	tlsClientCertSecretFlagUsage = "Optional. Name of the secret holding the PEM-encoded client certificate for" +
+		" mutual TLS. Replaces " + tlsClientCertFileFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + tlsClientCertSecretEnvKey
+	tlsClientCertSecretEnvKey = "HTTP_SERVER_SECRET_TLS_CLIENT_CERT"
+
+	tlsClientKeySecretFlagName  = "secret-tls-client-key"
+	tlsClientKeySecretFlagUsage = "Optional. Name of the secret holding the PEM-encoded private key of the client" +
+		" certificate. Replaces " + tlsClientKeyFileFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + tlsClientKeySecretEnvKey
+	tlsClientKeySecretEnvKey = "HTTP_SERVER_SECRET_TLS_CLIENT_KEY"
+)
+
+const cookieKeyLen = 32
+
+type secretsParameters struct {
+	watcher          *secrets.Watcher
+	cookieAuthKey    string
+	cookieEncKey     string
+	oidcClientSecret string
+	tlsClientCert    string
+	tlsClientKey     string
+}
+
+func createSecretsFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(secretsProviderFlagName, "", "", secretsProviderFlagUsage)
+	cmd.Flags().StringP(secretsRefreshIntervalFlagName, "", "", secretsRefreshIntervalFlagUsage)
+	cmd.Flags().StringP(vaultAddressFlagName, "", "", vaultAddressFlagUsage)
+	cmd.Flags().StringP(vaultTokenFlagName, "", "", vaultTokenFlagUsage)
+	cmd.Flags().StringP(vaultMountFlagName, "", "", vaultMountFlagUsage)
+	cmd.Flags().StringP(awsRegionFlagName, "", "", awsRegionFlagUsage)
+	cmd.Flags().StringP(awsAccessKeyIDFlagName, "", "", awsAccessKeyIDFlagUsage)
+	cmd.Flags().StringP(awsSecretAccessKeyFlagName, "", "", awsSecretAccessKeyFlagUsage)
+	cmd.Flags().StringP(awsSessionTokenFlagName, "", "", awsSessionTokenFlagUsage)
+	cmd.Flags().StringP(awsSecretsEndpointFlagName, "", "", awsSecretsEndpointFlagUsage)
+	cmd.Flags().StringP(cookieAuthKeySecretFlagName, "", "", cookieAuthKeySecretFlagUsage)
+	cmd.Flags().StringP(cookieEncKeySecretFlagName, "", "", cookieEncKeySecretFlagUsage)
+	cmd.Flags().StringP(oidcClientSecretSecretFlagName, "", "", oidcClientSecretSecretFlagUsage)
+	cmd.Flags().StringP(tlsClientCertSecretFlagName, "", "", tlsClientCertSecretFlagUsage)
+	cmd.Flags().StringP(tlsClientKeySecretFlagName, "", "", tlsClientKeySecretFlagUsage)
+}
+
+// getSecretsParams returns nil if the secrets are not read from a secrets manager. The client certificate of the
+// TLS parameters is the one held by the secrets manager, if named.
+func getSecretsParams(cmd *cobra.Command, tlsParams *tlsParameters) (*secretsParameters, error) { // nolint:gocyclo
+	provider, err := getSecretsProvider(cmd, tlsParams.config)
+	if err != nil || provider == nil {
+		return nil, err
+	}
+
+	params := &secretsParameters{
+		cookieAuthKey: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			cookieAuthKeySecretFlagName, cookieAuthKeySecretEnvKey),
+		cookieEncKey: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			cookieEncKeySecretFlagName, cookieEncKeySecretEnvKey),
+		oidcClientSecret: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			oidcClientSecretSecretFlagName, oidcClientSecretSecretEnvKey),
+		tlsClientCert: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			tlsClientCertSecretFlagName, tlsClientCertSecretEnvKey),
+		tlsClientKey: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			tlsClientKeySecretFlagName, tlsClientKeySecretEnvKey),
+	}
+
+	if (params.cookieAuthKey == "") != (params.cookieEncKey == "") {
+		return nil, fmt.Errorf("both %s and %s must be set", cookieAuthKeySecretFlagName, cookieEncKeySecretFlagName)
+	}
+
+	if (params.tlsClientCert == "") != (params.tlsClientKey == "") {
+		return nil, fmt.Errorf("both %s and %s must be set", tlsClientCertSecretFlagName, tlsClientKeySecretFlagName)
+	}
+
+	var interval time.Duration
+
+	if value := cmdutils.GetUserSetOptionalVarFromString(cmd,
+		secretsRefreshIntervalFlagName, secretsRefreshIntervalEnvKey); value != "" {
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid %s value '%s': must be a positive duration",
+				secretsRefreshIntervalFlagName, value)
+		}
+	}
+
+	params.watcher = secrets.NewWatcher(&secrets.WatcherConfig{Provider: provider, Interval: interval})
+
+	if params.tlsClientCert != "" {
+		if tlsParams.clientCert != nil {
+			return nil, fmt.Errorf("%s cannot be set along with %s", tlsClientCertSecretFlagName, tlsClientCertFileFlagName)
+		}
+
+		tlsParams.clientCert, err = watchClientCertificate(params)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return params, nil
+}
+
+func getSecretsProvider(cmd *cobra.Command, tlsConfig *tls.Config) (secrets.Provider, error) {
+	switch name := cmdutils.GetUserSetOptionalVarFromString(cmd, secretsProviderFlagName, secretsProviderEnvKey); name {
+	case "":
+		return nil, nil
+	case vaultSecretsProvider:
+		address, err := cmdutils.GetUserSetVarFromString(cmd, vaultAddressFlagName, vaultAddressEnvKey, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure vault address: %w", err)
+		}
+
+		token, err := cmdutils.GetUserSetVarFromString(cmd, vaultTokenFlagName, vaultTokenEnvKey, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure vault token: %w", err)
+		}
+
+		return secrets.NewVault(&secrets.VaultConfig{
+			Address:   address,
+			Token:     token,
+			Mount:     cmdutils.GetUserSetOptionalVarFromString(cmd, vaultMountFlagName, vaultMountEnvKey),
+			TLSConfig: tlsConfig,
+		}), nil
+	case awsSecretsProvider:
+		region, err := cmdutils.GetUserSetVarFromString(cmd, awsRegionFlagName, awsRegionEnvKey, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure aws region: %w", err)
+		}
+
+		accessKeyID, err := cmdutils.GetUserSetVarFromString(cmd, awsAccessKeyIDFlagName, awsAccessKeyIDEnvKey, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure aws access key id: %w", err)
+		}
+
+		secretAccessKey, err := cmdutils.GetUserSetVarFromString(cmd,
+			awsSecretAccessKeyFlagName, awsSecretAccessKeyEnvKey, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure aws secret access key: %w", err)
+		}
+
+		return secrets.NewAWS(&secrets.AWSConfig{
+			Region:          region,
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+			SessionToken: cmdutils.GetUserSetOptionalVarFromString(cmd,
+				awsSessionTokenFlagName, awsSessionTokenEnvKey),
+			Endpoint: cmdutils.GetUserSetOptionalVarFromString(cmd,
+				awsSecretsEndpointFlagName, awsSecretsEndpointEnvKey),
+			TLSConfig: tlsConfig,
+		}), nil
+	default:
+		return nil, fmt.Errorf("invalid %s value '%s': must be %s or %s",
+			secretsProviderFlagName, name, vaultSecretsProvider, awsSecretsProvider)
+	}
+}
+
+// watchCookieKeys returns the ring of the cookie keys held by the secrets manager, following their rotations.
+func watchCookieKeys(params *secretsParameters) (*cookie.KeyRing, error) {
+	var ring *cookie.KeyRing
+
+	err := params.watcher.Watch(func(values [][]byte) error {
+		authKey, err := cookieKey(params.cookieAuthKey, values[0])
+		if err != nil {
+			return err
+		}
+
+		encKey, err := cookieKey(params.cookieEncKey, values[1])
+		if err != nil {
+			return err
+		}
+
+		if ring == nil {
+			ring = cookie.NewKeyRing(authKey, encKey)
+		} else {
+			ring.Rotate(authKey, encKey)
+		}
+
+		return nil
+	}, params.cookieAuthKey, params.cookieEncKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure session cookie keys: %w", err)
+	}
+
+	return ring, nil
+}
+
+// watchClientCertificate returns the client certificate held by the secrets manager, following its rotations.
+func watchClientCertificate(params *secretsParameters) (mtls.ClientCertificateFunc, error) {
+	var holder *mtls.CertHolder
+
+	err := params.watcher.Watch(func(values [][]byte) error {
+		if holder == nil {
+			var err error
+
+			holder, err = mtls.NewCertHolder(values[0], values[1])
+
+			return err
+		}
+
+		return holder.Update(values[0], values[1])
+	}, params.tlsClientCert, params.tlsClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure tls client certificate: %w", err)
+	}
+
+	return holder.GetClientCertificate, nil
+}
+
+// watchClientSecret hands the OIDC client secret to the consumer, and then its rotations, if the secret is held by
+// the secrets manager.
+func watchClientSecret(params *secretsParameters, setSecret func(string)) error {
+	if params == nil || params.oidcClientSecret == "" {
+		return nil
+	}
+
+	err := params.watcher.Watch(func(values [][]byte) error {
+		setSecret(string(values[0]))
+
+		return nil
+	}, params.oidcClientSecret)
+	if err != nil {
+		return fmt.Errorf("failed to configure OIDC client secret: %w", err)
+	}
+
+	return nil
+}
+
+// cookieKey accepts the key raw or base64-encoded, as secrets managers often hold text.
+func cookieKey(name string, value []byte) ([]byte, error) {
+	if len(value) == cookieKeyLen {
+		return value, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil || len(key) != cookieKeyLen {
+		return nil, fmt.Errorf("secret %s: need key of %d bytes, raw or base64-encoded", name, cookieKeyLen)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/agent"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/signer"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Agent signing keys config.
+const (
+	agentSigningKeysFlagName  = "agent-signing-keys"
+	agentSigningKeysFlagUsage = "Optional. Where the keys the agent signs with, e.g. the bootstrap data of the users," +
+		" are held: '" + kmsSigningKeys + "' for a key of the agent keystore of the ops KMS, created on first start" +
+		" and shared by the instances of the agent, or '" + localSigningKeys + "' for a key held in memory, for" +
+		" development only. Defaults to '" + kmsSigningKeys + "', and to '" + localSigningKeys + "' in dev mode." +
+		" Alternatively, this can be set with the following environment variable: " + agentSigningKeysEnvKey
+	agentSigningKeysEnvKey = "HTTP_SERVER_AGENT_SIGNING_KEYS"
+
+	kmsSigningKeys   = "kms"
+	localSigningKeys = "local"
+)
+
+func createAgentSigningKeysFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(agentSigningKeysFlagName, "", "", agentSigningKeysFlagUsage)
+}
+
+func getAgentSigningKeys(cmd *cobra.Command) (string, error) {
+	keys := cmdutils.GetUserSetOptionalVarFromString(cmd, agentSigningKeysFlagName, agentSigningKeysEnvKey)
+
+	switch keys {
+	case "":
+		return kmsSigningKeys, nil
+	case kmsSigningKeys, localSigningKeys:
+		return keys, nil
+	default:
+		return "", fmt.Errorf("invalid %s value '%s': must be %s or %s",
+			agentSigningKeysFlagName, keys, kmsSigningKeys, localSigningKeys)
+	}
+}
+
+// newAgentSigner returns the signer of the agent, on the ops KMS unless local keys are configured.
+func newAgentSigner(config *httpServerParameters, store storage.Provider) (signer.Signer, error) {
+	if config.signingKeys == localSigningKeys {
+		logger.Warnf("signing with a key held in memory, for development only")
+
+		return signer.NewLocalSigner()
+	}
+
+	return agent.NewKeySigner(&agent.FrameworkConfig{
+		OpsKMSURL:         config.keyServer.opsKMSURL,
+		TLSConfig:         config.tls.config,
+		ClientCertificate: config.tls.clientCert,
+		Proxy:             config.proxy,
+		Storage:           store,
+	})
+}
@@ -0,0 +1,129 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/httpbinding"
+	vdrkey "github.com/hyperledger/aries-framework-go/pkg/vdr/key"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/siop"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// SIOP config.
+const (
+	siopRedirectURLFlagName  = "siop-redirect-url"
+	siopRedirectURLFlagUsage = "Optional. URL of the SIOP callback of the agent," +
+		" e.g. https://agent.example.com/siop/callback, which the wallets of the users send their self-issued" +
+		" id_tokens to. Setting it lets the users log in with a DID of their wallet (SIOPv2) at /siop/login, as" +
+		" well as with the OIDC provider." +
+		" Alternatively, this can be set with the following environment variable: " + siopRedirectURLEnvKey
+	siopRedirectURLEnvKey = "HTTP_SERVER_SIOP_REDIRECT_URL"
+
+	siopDIDMethodsFlagName  = "siop-did-methods"
+	siopDIDMethodsFlagUsage = "Optional. Methods of the DIDs the users log in with, e.g. key. Defaults to key." +
+		" The DIDs of the other methods than key are resolved with the resolver of " + siopResolverURLFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " + siopDIDMethodsEnvKey
+	siopDIDMethodsEnvKey = "HTTP_SERVER_SIOP_DID_METHODS"
+
+	siopResolverURLFlagName  = "siop-resolver-url"
+	siopResolverURLFlagUsage = "Optional. URL of the DID resolver (e.g. a universal resolver) resolving the DIDs" +
+		" of the other methods than key. Only did:key is supported if not set." +
+		" Alternatively, this can be set with the following environment variable: " + siopResolverURLEnvKey
+	siopResolverURLEnvKey = "HTTP_SERVER_SIOP_RESOLVER_URL"
+
+	siopKeyMethod = "key"
+)
+
+type siopParameters struct {
+	redirectURL string
+	didMethods  []string
+	resolverURL string
+}
+
+func createSIOPFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(siopRedirectURLFlagName, "", "", siopRedirectURLFlagUsage)
+	cmd.Flags().StringArrayP(siopDIDMethodsFlagName, "", []string{}, siopDIDMethodsFlagUsage)
+	cmd.Flags().StringP(siopResolverURLFlagName, "", "", siopResolverURLFlagUsage)
+}
+
+// getSIOPParams returns nil if the users cannot log in with a DID.
+func getSIOPParams(cmd *cobra.Command) (*siopParameters, error) {
+	params := &siopParameters{
+		redirectURL: cmdutils.GetUserSetOptionalVarFromString(cmd, siopRedirectURLFlagName, siopRedirectURLEnvKey),
+		didMethods:  cmdutils.GetUserSetOptionalVarFromArrayString(cmd, siopDIDMethodsFlagName, siopDIDMethodsEnvKey),
+		resolverURL: cmdutils.GetUserSetOptionalVarFromString(cmd, siopResolverURLFlagName, siopResolverURLEnvKey),
+	}
+
+	if params.redirectURL == "" {
+		return nil, nil
+	}
+
+	_, err := url.ParseRequestURI(params.redirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value '%s': %w", siopRedirectURLFlagName, params.redirectURL, err)
+	}
+
+	if params.resolverURL != "" {
+		_, err = url.ParseRequestURI(params.resolverURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value '%s': %w", siopResolverURLFlagName, params.resolverURL, err)
+		}
+	}
+
+	if len(params.didMethods) == 0 {
+		params.didMethods = []string{siopKeyMethod}
+	}
+
+	for _, method := range params.didMethods {
+		if method != siopKeyMethod && params.resolverURL == "" {
+			return nil, fmt.Errorf("did method %s of %s requires %s",
+				method, siopDIDMethodsFlagName, siopResolverURLFlagName)
+		}
+	}
+
+	return params, nil
+}
+
+// newSIOPConfig returns the SIOP config of the params, whose resolver of the DIDs other than did:key trusts the
+// CAs of the TLS config.
+func newSIOPConfig(params *siopParameters, tlsConfig *tls.Config) (*oidc.SIOPConfig, error) {
+	resolver := siop.VDRResolver{vdrkey.New()}
+
+	if params.resolverURL != "" {
+		methods := make(map[string]bool, len(params.didMethods))
+
+		for _, method := range params.didMethods {
+			methods[method] = true
+		}
+
+		vdr, err := httpbinding.New(params.resolverURL,
+			httpbinding.WithTLSConfig(tlsConfig),
+			httpbinding.WithAccept(func(method string) bool {
+				return methods[method]
+			}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create did resolver: %w", err)
+		}
+
+		resolver = append(resolver, vdr)
+	}
+
+	return &oidc.SIOPConfig{
+		RelyingParty: siop.New(&siop.Config{
+			ClientID:   params.redirectURL,
+			DIDMethods: params.didMethods,
+			Resolver:   resolver,
+		}),
+	}, nil
+}
@@ -8,6 +8,7 @@ package startcmd
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -15,20 +16,45 @@ import (
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	oidcp "github.com/coreos/go-oidc"
 	"github.com/duo-labs/webauthn/webauthn"
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/activity"
+	"github.com/trustbloc/edge-agent/pkg/restapi/agent"
+	"github.com/trustbloc/edge-agent/pkg/restapi/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/binding"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/claims"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/faults"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/headers"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/health"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/limits"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/logging"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/mtls"
 	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/openapi"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/proxy"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	audit2 "github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/lock"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
 	"github.com/trustbloc/edge-agent/pkg/restapi/device"
+	"github.com/trustbloc/edge-agent/pkg/restapi/inbox"
+	"github.com/trustbloc/edge-agent/pkg/restapi/notifications"
 	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/webhook"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"github.com/trustbloc/edge-core/pkg/storage"
-	"github.com/trustbloc/edge-core/pkg/storage/memstore"
 	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
 	tlsutils "github.com/trustbloc/edge-core/pkg/utils/tls"
 )
@@ -68,6 +94,17 @@ const (
 		" Alternatively, this can be set with the following environment variable: " + tlsCACertsEnvKey
 	tlsCACertsEnvKey = "TLS_CACERTS"
 
+	tlsClientCertFileFlagName  = "tls-client-cert-file"
+	tlsClientCertFileFlagUsage = "Optional. Client certificate presented to the hub-auth, KMS and EDV servers" +
+		" requiring mutual TLS. It is reloaded once the file is rotated." +
+		" Alternatively, this can be set with the following environment variable: " + tlsClientCertFileEnvKey
+	tlsClientCertFileEnvKey = "TLS_CLIENT_CERT_FILE"
+
+	tlsClientKeyFileFlagName  = "tls-client-key-file"
+	tlsClientKeyFileFlagUsage = "Optional. Key of the TLS client certificate." +
+		" Alternatively, this can be set with the following environment variable: " + tlsClientKeyFileEnvKey
+	tlsClientKeyFileEnvKey = "TLS_CLIENT_KEY_FILE"
+
 	dependencyMaxRetriesFlagName   = "dep-maxretries"
 	dependencyMaxRetriesFlagEnvKey = "HTTP_SERVER_DEP_MAXRETRIES"
 	dependencyMaxRetriesFlagUsage  = "Optional. Sets the maximum number of retries while establishing connections with" +
@@ -75,38 +112,102 @@ const (
 		" Alternatively, this can be set with the following environment variable: " + dependencyMaxRetriesFlagEnvKey
 	dependencyMaxRetriesDefault = uint64(120) // nolint:gomnd // false positive ("magic number")
 
+	adminTokenFlagName  = "admin-token"
+	adminTokenFlagUsage = "Optional. Bearer token required to call the administrative API under " + adminBasePath +
+		" The administrative API is disabled if not set." +
+		" Alternatively, this can be set with the following environment variable: " + adminTokenEnvKey
+	adminTokenEnvKey = "HTTP_SERVER_ADMIN_TOKEN" // nolint:gosec // false positive on 'TOKEN'
+
+	agentDIDCommURLFlagName  = "agent-didcomm-url"
+	agentDIDCommURLFlagUsage = "Optional. Public URL other agents use to reach the " + didcommPath + " endpoint." +
+		" Enables the embedded Aries agent and its API under " + agentBasePath + ", using the ops KMS for its keys." +
+		" Alternatively, this can be set with the following environment variable: " + agentDIDCommURLEnvKey
+	agentDIDCommURLEnvKey = "HTTP_SERVER_AGENT_DIDCOMM_URL"
+
+	oidc4vciClientIDFlagName  = "oidc4vci-client-id"
+	oidc4vciClientIDFlagUsage = "Optional. Client ID of the agent at credential issuers using the OpenID4VCI" +
+		" authorization code flow." +
+		" Alternatively, this can be set with the following environment variable: " + oidc4vciClientIDEnvKey
+	oidc4vciClientIDEnvKey = "HTTP_SERVER_OIDC4VCI_CLIENT_ID"
+
+	oidc4vciRedirectURLFlagName  = "oidc4vci-redirect-url"
+	oidc4vciRedirectURLFlagUsage = "Optional. Public URL of the " + agentBasePath + "oidc4vci/callback endpoint" +
+		" registered with credential issuers using the OpenID4VCI authorization code flow." +
+		" Alternatively, this can be set with the following environment variable: " + oidc4vciRedirectURLEnvKey
+	oidc4vciRedirectURLEnvKey = "HTTP_SERVER_OIDC4VCI_REDIRECT_URL"
+
+	onboardingWorkersFlagName  = "onboarding-workers"
+	onboardingWorkersFlagUsage = "Optional. Number of new users whose keystores and vaults are provisioned at once," +
+		" in the background after their first login. Default is 4." +
+		" Alternatively, this can be set with the following environment variable: " + onboardingWorkersEnvKey
+	onboardingWorkersEnvKey  = "HTTP_SERVER_ONBOARDING_WORKERS"
+	onboardingWorkersDefault = 4
+
+	onboardingStepTimeoutFlagName  = "onboarding-step-timeout"
+	onboardingStepTimeoutFlagUsage = "Optional. Timeout of each call to the hub-auth, KMS and EDV servers while a" +
+		" new user is onboarded, e.g. 30s. Default is 30s." +
+		" Alternatively, this can be set with the following environment variable: " + onboardingStepTimeoutEnvKey
+	onboardingStepTimeoutEnvKey  = "HTTP_SERVER_ONBOARDING_STEP_TIMEOUT"
+	onboardingStepTimeoutDefault = 30 * time.Second
+
+	onboardingLockTTLFlagName  = "onboarding-lock-ttl"
+	onboardingLockTTLFlagUsage = "Optional. How long the onboarding of a new user stays locked at most when the" +
+		" instance onboarding the user crashes, e.g. 5m. The concurrent logins of a new user wait for the lock" +
+		" and onboard the user once. Default is 5m." +
+		" Alternatively, this can be set with the following environment variable: " + onboardingLockTTLEnvKey
+	onboardingLockTTLEnvKey = "HTTP_SERVER_ONBOARDING_LOCK_TTL"
+
 	oidcBasePath    = "/oidc/"
 	healthCheckPath = "/healthcheck"
+	openAPIPath     = "/openapi.json"
 	deviceBasePath  = "/device/"
+	adminBasePath   = "/admin/"
+	agentBasePath   = "/agent/"
+	didcommPath     = "/didcomm"
+
+	webhookTimeout = 10 * time.Second
+	// how long the audit chain of a shared database stays locked at most by an instance that crashed appending to it
+	auditLockTTL = 10 * time.Second
+)
+
+// OpenAPI document of the REST API.
+const (
+	openAPITitle   = "Edge Agent wallet server"
+	openAPIVersion = "1.0.0"
 )
 
 // Key management config.
 const (
 	authzKMSURLFlagName  = "authz-kms-url"
-	authzKMSURLFlagUsage = "Authorization KMS Server URL"
-	authzKMSURLEnvKey    = "HTTP_SERVER_AUTHZ_KMS_URL"
+	authzKMSURLFlagUsage = "Authorization KMS Server URL." +
+		" Alternatively, this can be set with the following environment variable: " + authzKMSURLEnvKey
+	authzKMSURLEnvKey = "HTTP_SERVER_AUTHZ_KMS_URL"
 
 	opsKMSURLFlagName  = "ops-kms-url"
-	opsKMSURLFlagUsage = "Operational KMS Server URL"
-	opsKMSURLEnvKey    = "HTTP_SERVER_OPS_KMS_URL"
+	opsKMSURLFlagUsage = "Operational KMS Server URL." +
+		" Alternatively, this can be set with the following environment variable: " + opsKMSURLEnvKey
+	opsKMSURLEnvKey = "HTTP_SERVER_OPS_KMS_URL"
 
 	keyEDVURLFlagName  = "key-edv-url"
-	keyEDVURLFlagUsage = "Operational key EDV Server URL"
-	keyEDVURLEnvKey    = "HTTP_SERVER_KEY_EDV_URL"
+	keyEDVURLFlagUsage = "Operational key EDV Server URL." +
+		" Alternatively, this can be set with the following environment variable: " + keyEDVURLEnvKey
+	keyEDVURLEnvKey = "HTTP_SERVER_KEY_EDV_URL"
 )
 
 // EDV config.
 const (
 	userEDVURLFlagName  = "user-edv-url"
-	userEDVURLFlagUsage = "User EDV Server URL"
-	userEDVURLEnvKey    = "HTTP_SERVER_USER_EDV_URL"
+	userEDVURLFlagUsage = "User EDV Server URL." +
+		" Alternatively, this can be set with the following environment variable: " + userEDVURLEnvKey
+	userEDVURLEnvKey = "HTTP_SERVER_USER_EDV_URL"
 )
 
 // Hub auth config.
 const (
 	hubAuthURLFlagName  = "hub-auth-url"
-	hubAuthURLFlagUsage = "Hub Auth Servr URL"
-	hubAuthURLEnvKey    = "HTTP_SERVER_HUB_AUTH_URL"
+	hubAuthURLFlagUsage = "Hub Auth Servr URL." +
+		" Alternatively, this can be set with the following environment variable: " + hubAuthURLEnvKey
+	hubAuthURLEnvKey = "HTTP_SERVER_HUB_AUTH_URL"
 )
 
 // OIDC config.
@@ -131,17 +232,43 @@ const (
 	oidcCallbackURLFlagUsage = "Base URL for the OIDC callback endpoint." +
 		" Alternatively, this can be set with the following environment variable: " + oidcCallbackURLEnvKey
 	oidcCallbackURLEnvKey = "HTTP_SERVER_OIDC_CALLBACK"
+
+	oidcClaimAttributesFlagName  = "oidc-claim-attributes"
+	oidcClaimAttributesFlagUsage = "Comma-separated list of claim=attribute pairs mapping custom OIDC claims into" +
+		" the user profile. The attribute name defaults to the claim name if omitted." +
+		" Alternatively, this can be set with the following environment variable: " + oidcClaimAttributesEnvKey
+	oidcClaimAttributesEnvKey = "HTTP_SERVER_OIDC_CLAIM_ATTRIBUTES"
+
+	oidcClaimAllowListFlagName  = "oidc-claim-allow-list"
+	oidcClaimAllowListFlagUsage = "Optional. Comma-separated list of the only user info claims sent to the wallet UI." +
+		" All claims are sent if not set." +
+		" Alternatively, this can be set with the following environment variable: " + oidcClaimAllowListEnvKey
+	oidcClaimAllowListEnvKey = "HTTP_SERVER_OIDC_CLAIM_ALLOW_LIST"
+
+	oidcClaimDenyListFlagName  = "oidc-claim-deny-list"
+	oidcClaimDenyListFlagUsage = "Optional. Comma-separated list of user info claims never sent to the wallet UI," +
+		" e.g. employee_id,groups." +
+		" Alternatively, this can be set with the following environment variable: " + oidcClaimDenyListEnvKey
+	oidcClaimDenyListEnvKey = "HTTP_SERVER_OIDC_CLAIM_DENY_LIST"
+
+	oidcClaimRenameFlagName  = "oidc-claim-rename"
+	oidcClaimRenameFlagUsage = "Optional. Comma-separated list of claim=name pairs renaming the user info claims" +
+		" sent to the wallet UI. The allow and deny lists match the claim names before they are renamed." +
+		" Alternatively, this can be set with the following environment variable: " + oidcClaimRenameEnvKey
+	oidcClaimRenameEnvKey = "HTTP_SERVER_OIDC_CLAIM_RENAME"
 )
 
 // Keys.
 const (
 	sessionCookieAuthKeyFlagName  = "cookie-auth-key"
 	sessionCookieAuthKeyFlagUsage = "Path to the pem-encoded 32-byte key to use to authenticate session cookies." +
+		" The key is cluster-wide: every instance of the agent sharing a database must use the same key." +
 		" Alternatively, this can be set with the following environment variable: " + sessionCookieAuthKeyEnvKey
 	sessionCookieAuthKeyEnvKey = "HTTP_SERVER_COOKIE_AUTH_KEY"
 
 	sessionCookieEncKeyFlagName  = "cookie-enc-key"
 	sessionCookieEncKeyFlagUsage = "Path to the pem-encoded 32-byte key to use to encrypt session cookies." +
+		" The key is cluster-wide: every instance of the agent sharing a database must use the same key." +
 		" Alternatively, this can be set with the following environment variable: " + sessionCookieEncKeyEnvKey
 	sessionCookieEncKeyEnvKey = "HTTP_SERVER_COOKIE_ENC_KEY"
 )
@@ -171,15 +298,24 @@ type server interface {
 }
 
 // HTTPServer represents an actual HTTP server implementation.
-type HTTPServer struct{}
+type HTTPServer struct {
+	limits *limits.ServerConfig
+}
+
+// SetLimits bounds the connections of the clients of the server.
+func (s *HTTPServer) SetLimits(config *limits.ServerConfig) {
+	s.limits = config
+}
 
 // ListenAndServe starts the server using the standard Go HTTP server implementation.
 func (s *HTTPServer) ListenAndServe(host, certFile, keyFile string, handler http.Handler) error {
+	srv := limits.NewServer(host, handler, s.limits)
+
 	if certFile != "" && keyFile != "" {
-		return http.ListenAndServeTLS(host, certFile, keyFile, handler)
+		return srv.ListenAndServeTLS(certFile, keyFile)
 	}
 
-	return http.ListenAndServe(host, handler)
+	return srv.ListenAndServe()
 }
 
 type httpServerParameters struct {
@@ -188,6 +324,7 @@ type httpServerParameters struct {
 	hostURL              string
 	tls                  *tlsParameters
 	oidc                 *oidcParameters
+	oidcShadow           *oidcShadowParameters
 	keys                 *keyParameters
 	webAuth              *webauthParameters
 	keyServer            *keyServerParameters
@@ -195,19 +332,83 @@ type httpServerParameters struct {
 	hubAuthURL           string
 	agentUIURL           string
 	logLevel             string
+	adminToken           string
+	scimToken            string
+	agentDIDCommURL      string
+	oidc4vciClientID     string
+	oidc4vciRedirectURL  string
+	onboardingWorkers    int
+	onboardingTimeout    time.Duration
+	onboardingLockTTL    time.Duration
+	cors                 *corsParameters
+	bearer               *bearerParameters
+	proxy                proxy.Func
+	consent              *oidc.ConsentConfig
+	userInfoCache        *oidc.UserInfoCacheConfig
+	keystoreCache        *oidc.KeystoreCacheConfig
+	siop                 *siopParameters
+	didResolver          *didResolverParameters
+	health               *healthParameters
+	limits               *limitsParameters
+	securityHeaders      *securityHeadersParameters
+	provisioningPool     *oidc.ProvisioningPoolConfig
+	janitor              *oidc.JanitorConfig
+	quotas               *oidc.QuotaConfig
+	bootstrapSigning     *bootstrapSigningParameters
+	signingKeys          string
+	stepUp               *oidc.StepUpConfig
+	loginParams          []string
+	versioning           *common.VersioningConfig
+	lockout              *oidc.LockoutConfig
+	networkPolicy        *networkPolicyParameters
+	tokenExchange        *oidc.TokenExchangeConfig
+	keyRotation          *oidc.KeyRotationConfig
+	didKeyRotation       *agent.KeyRotationConfig
+	logging              *logging.Config
+	tokenVault           *tokens.VaultConfig
+	userDirectory        *userDirectoryParameters
+	featureFlags         *featureFlagParameters
+	faults               *faults.Config
+	onboardingTemplates  *oidc.OnboardingTemplates
+	database             *databaseParameters
+	maintenance          *maintenanceParameters
+	startupValidation    string
+	shareEscrow          *shareEscrowParameters
+	statusListTTL        time.Duration
+	issuers              *issuerParameters
+	sessionBinding       *binding.Config
+	pseudonyms           *pseudonym.Config
+	rememberTTL          time.Duration
+	idempotencyTTL       time.Duration
+	authz                *authzParameters
+	outboxMaxAttempts    int
+	grpc                 *grpcParameters
+	push                 *pushParameters
+	secrets              *secretsParameters
+	config               *configFile
+	middleware           []common.Middleware
+	// holds the events until they are delivered, created by the router
+	outbox *outbox.Store
+	// set the wallet dashboard of the handlers once the agent UI URL is reloaded
+	dashboards []func(url string)
+	// describes the mounted handlers, created by the router
+	openapi *openapi.Document
 }
 
 type tlsParameters struct {
-	certFile string
-	keyFile  string
-	config   *tls.Config
+	certFile   string
+	keyFile    string
+	config     *tls.Config
+	clientCert mtls.ClientCertificateFunc
 }
 
 type oidcParameters struct {
-	providerURL  string
-	clientID     string
-	clientSecret string
-	callbackURL  string
+	providerURL     string
+	clientID        string
+	clientSecret    string
+	callbackURL     string
+	claimAttributes map[string]string
+	claimsFilter    *claims.Filter
 }
 
 type webauthParameters struct {
@@ -217,8 +418,10 @@ type webauthParameters struct {
 }
 
 type keyParameters struct {
-	sessionCookieAuthKey []byte
-	sessionCookieEncKey  []byte
+	sessionCookieKeys *cookie.KeyRing
+	// rotated keys are read from the secrets manager, the same on every instance of the agent
+	rotated bool
+	cluster *cookie.Cluster
 }
 
 type keyServerParameters struct {
@@ -227,21 +430,38 @@ type keyServerParameters struct {
 	keyEDVURL   string
 }
 
-// GetStartCmd returns the Cobra start command.
-func GetStartCmd(srv server) *cobra.Command {
-	startCmd := createStartCmd(srv)
+// GetStartCmd returns the Cobra start command. The middleware is applied to every REST handler of the server;
+// middleware wrapping the response writer must keep it a http.Flusher for the notification stream to work.
+func GetStartCmd(srv server, middleware ...common.Middleware) *cobra.Command {
+	startCmd := createStartCmd(srv, middleware)
 
 	createFlags(startCmd)
 
 	return startCmd
 }
 
-func createStartCmd(srv server) *cobra.Command { //nolint:funlen,gocyclo // no real logic
+func createStartCmd( //nolint:funlen,gocyclo // no real logic
+	srv server, middleware []common.Middleware) *cobra.Command {
 	return &cobra.Command{
 		Use:   "start",
 		Short: "Start http server",
 		Long:  "Start http server",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := loadConfigFile(cmd)
+			if err != nil {
+				return err
+			}
+
+			// the fakes of the dev mode stand in for the services that are not set, so they start first
+			devMode, err := startDevMode(cmd)
+			if err != nil {
+				return err
+			}
+
+			if devMode != nil {
+				defer closeDevMode(devMode)
+			}
+
 			hostURL, hostURLErr := cmdutils.GetUserSetVarFromString(cmd, hostURLFlagName, hostURLEnvKey, false)
 			if hostURLErr != nil {
 				return hostURLErr
@@ -262,7 +482,17 @@ func createStartCmd(srv server) *cobra.Command { //nolint:funlen,gocyclo // no r
 				return err
 			}
 
-			oidcParams, err := getOIDCParams(cmd)
+			secretsParams, err := getSecretsParams(cmd, tlsParams)
+			if err != nil {
+				return err
+			}
+
+			oidcParams, err := getOIDCParams(cmd, secretsParams)
+			if err != nil {
+				return err
+			}
+
+			oidcShadowParams, err := getOIDCShadowParams(cmd)
 			if err != nil {
 				return err
 			}
@@ -272,7 +502,12 @@ func createStartCmd(srv server) *cobra.Command { //nolint:funlen,gocyclo // no r
 				return err
 			}
 
-			keys, err := getKeyParams(cmd)
+			hsmParams, err := getHSMParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			keys, err := getKeyParams(cmd, secretsParams, hsmParams)
 			if err != nil {
 				return err
 			}
@@ -297,12 +532,249 @@ func createStartCmd(srv server) *cobra.Command { //nolint:funlen,gocyclo // no r
 				return fmt.Errorf("hub-auth url : %w", err)
 			}
 
+			adminToken := cmdutils.GetUserSetOptionalVarFromString(cmd, adminTokenFlagName, adminTokenEnvKey)
+
+			agentDIDCommURL := cmdutils.GetUserSetOptionalVarFromString(cmd,
+				agentDIDCommURLFlagName, agentDIDCommURLEnvKey)
+
+			oidc4vciClientID := cmdutils.GetUserSetOptionalVarFromString(cmd,
+				oidc4vciClientIDFlagName, oidc4vciClientIDEnvKey)
+
+			oidc4vciRedirectURL := cmdutils.GetUserSetOptionalVarFromString(cmd,
+				oidc4vciRedirectURLFlagName, oidc4vciRedirectURLEnvKey)
+
+			onboardingWorkers, err := getOnboardingWorkers(cmd)
+			if err != nil {
+				return err
+			}
+
+			onboardingTimeout, err := getOnboardingStepTimeout(cmd)
+			if err != nil {
+				return err
+			}
+
+			onboardingLockTTL, err := getOnboardingLockTTL(cmd)
+			if err != nil {
+				return err
+			}
+
+			corsParams, err := getCORSParams(cmd, agentUIURL)
+			if err != nil {
+				return err
+			}
+
+			bearerParams, err := getBearerParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			proxyFunc, err := getProxy(cmd)
+			if err != nil {
+				return err
+			}
+
+			userInfoCache, err := getUserInfoCacheConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			keystoreCache, err := getKeystoreCacheConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			siopParams, err := getSIOPParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			provisioningPool, err := getProvisioningPoolConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			janitor, err := getJanitorConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			quotas, err := getQuotaConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			bootstrapSigningParams, err := getBootstrapSigningParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			signingKeys, err := getAgentSigningKeys(cmd)
+			if err != nil {
+				return err
+			}
+
+			didResolverParams, err := getDIDResolverParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			healthParams, err := getHealthParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			limitsParams, err := getLimitsParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			securityHeaders, err := getSecurityHeadersParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			stepUp, err := getStepUpConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			loginParams, err := getLoginParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			versioning, err := getAPIVersioningConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			lockout, err := getLockoutConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			networkPolicy, err := getNetworkPolicyParameters(cmd)
+			if err != nil {
+				return err
+			}
+
+			keyRotation, err := getKeyRotationConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			didKeyRotation, err := getDIDKeyRotationConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			loggingConfig, err := getLoggingConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			tokenVault, err := getTokenVaultConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			userDirectory, err := getUserDirectoryParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			featureFlags, err := getFeatureFlagParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			faultConfig, err := getFaultInjectionConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			onboardingTemplates, err := getOnboardingTemplates(cmd)
+			if err != nil {
+				return err
+			}
+
+			database, err := getDatabaseParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			maintenanceParams, err := getMaintenanceParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			startupValidation, err := getStartupValidation(cmd)
+			if err != nil {
+				return err
+			}
+
+			shareEscrowParams, err := getShareEscrowParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			statusListTTL, err := getStatusListTTL(cmd)
+			if err != nil {
+				return err
+			}
+
+			issuerParams, err := getIssuerParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			sessionBinding, err := getSessionBindingConfig(cmd)
+			if err != nil {
+				return err
+			}
+
+			pseudonymConfig, err := getPseudonymConfig(cmd, hsmParams)
+			if err != nil {
+				return err
+			}
+
+			rememberTTL, err := getRememberTTL(cmd)
+			if err != nil {
+				return err
+			}
+
+			idempotencyTTL, err := getIdempotencyTTL(cmd)
+			if err != nil {
+				return err
+			}
+
+			authzParams, err := getAuthzParams(cmd)
+			if err != nil {
+				return err
+			}
+
+			outboxMaxAttempts, err := getOutboxMaxAttempts(cmd)
+			if err != nil {
+				return err
+			}
+
+			grpcParams, err := getGRPCParams(cmd, tlsParams)
+			if err != nil {
+				return err
+			}
+
+			pushParams, err := getPushParams(cmd)
+			if err != nil {
+				return err
+			}
+
 			parameters := &httpServerParameters{
 				dependencyMaxRetries: retries,
 				srv:                  srv,
 				hostURL:              hostURL,
 				tls:                  tlsParams,
 				oidc:                 oidcParams,
+				oidcShadow:           oidcShadowParams,
 				webAuth:              webAuthParams,
 				keys:                 keys,
 				keyServer:            keyServer,
@@ -310,6 +782,66 @@ func createStartCmd(srv server) *cobra.Command { //nolint:funlen,gocyclo // no r
 				hubAuthURL:           hubAuthURL,
 				agentUIURL:           agentUIURL,
 				logLevel:             logLevel,
+				adminToken:           adminToken,
+				scimToken:            getSCIMToken(cmd),
+				agentDIDCommURL:      agentDIDCommURL,
+				oidc4vciClientID:     oidc4vciClientID,
+				oidc4vciRedirectURL:  oidc4vciRedirectURL,
+				onboardingWorkers:    onboardingWorkers,
+				onboardingTimeout:    onboardingTimeout,
+				onboardingLockTTL:    onboardingLockTTL,
+				cors:                 corsParams,
+				bearer:               bearerParams,
+				proxy:                proxyFunc,
+				consent:              getConsentConfig(cmd, agentUIURL),
+				userInfoCache:        userInfoCache,
+				keystoreCache:        keystoreCache,
+				siop:                 siopParams,
+				didResolver:          didResolverParams,
+				provisioningPool:     provisioningPool,
+				janitor:              janitor,
+				quotas:               quotas,
+				bootstrapSigning:     bootstrapSigningParams,
+				signingKeys:          signingKeys,
+				health:               healthParams,
+				limits:               limitsParams,
+				securityHeaders:      securityHeaders,
+				stepUp:               stepUp,
+				loginParams:          loginParams,
+				versioning:           versioning,
+				lockout:              lockout,
+				networkPolicy:        networkPolicy,
+				tokenExchange:        getTokenExchangeConfig(cmd),
+				keyRotation:          keyRotation,
+				didKeyRotation:       didKeyRotation,
+				logging:              loggingConfig,
+				tokenVault:           tokenVault,
+				userDirectory:        userDirectory,
+				featureFlags:         featureFlags,
+				faults:               faultConfig,
+				onboardingTemplates:  onboardingTemplates,
+				database:             database,
+				maintenance:          maintenanceParams,
+				startupValidation:    startupValidation,
+				shareEscrow:          shareEscrowParams,
+				statusListTTL:        statusListTTL,
+				issuers:              issuerParams,
+				sessionBinding:       sessionBinding,
+				pseudonyms:           pseudonymConfig,
+				rememberTTL:          rememberTTL,
+				idempotencyTTL:       idempotencyTTL,
+				authz:                authzParams,
+				outboxMaxAttempts:    outboxMaxAttempts,
+				grpc:                 grpcParams,
+				push:                 pushParams,
+				secrets:              secretsParams,
+				config:               config,
+				middleware:           middleware,
+			}
+
+			err = validateParameters(parameters)
+			if err != nil {
+				return err
 			}
 
 			return startHTTPServer(parameters)
@@ -330,16 +862,75 @@ func createFlags(startCmd *cobra.Command) {
 	startCmd.Flags().StringP(keyEDVURLFlagName, "", "", keyEDVURLFlagUsage)
 	startCmd.Flags().StringP(userEDVURLFlagName, "", "", userEDVURLFlagUsage)
 	startCmd.Flags().StringP(hubAuthURLFlagName, "", "", hubAuthURLFlagUsage)
+	startCmd.Flags().StringP(adminTokenFlagName, "", "", adminTokenFlagUsage)
+	startCmd.Flags().StringP(agentDIDCommURLFlagName, "", "", agentDIDCommURLFlagUsage)
+	startCmd.Flags().StringP(oidc4vciClientIDFlagName, "", "", oidc4vciClientIDFlagUsage)
+	startCmd.Flags().StringP(oidc4vciRedirectURLFlagName, "", "", oidc4vciRedirectURLFlagUsage)
+	startCmd.Flags().StringP(onboardingWorkersFlagName, "", "", onboardingWorkersFlagUsage)
+	startCmd.Flags().StringP(onboardingStepTimeoutFlagName, "", "", onboardingStepTimeoutFlagUsage)
+	startCmd.Flags().StringP(onboardingLockTTLFlagName, "", "", onboardingLockTTLFlagUsage)
 	createOIDCFlags(startCmd)
 	createTLSFlags(startCmd)
 	createKeyFlags(startCmd)
 	createWebAuthFlags(startCmd)
+	createCORSFlags(startCmd)
+	createBearerFlags(startCmd)
+	createConsentFlags(startCmd)
+	createSecretsFlags(startCmd)
+	createConfigFlags(startCmd)
+	createProxyFlags(startCmd)
+	createUserInfoCacheFlags(startCmd)
+	createKeystoreCacheFlags(startCmd)
+	createSIOPFlags(startCmd)
+	createDIDResolverFlags(startCmd)
+	createProvisioningPoolFlags(startCmd)
+	createJanitorFlags(startCmd)
+	createQuotaFlags(startCmd)
+	createBootstrapSigningFlags(startCmd)
+	createAgentSigningKeysFlags(startCmd)
+	createHealthFlags(startCmd)
+	createLimitsFlags(startCmd)
+	createSecurityHeadersFlags(startCmd)
+	createStepUpFlags(startCmd)
+	createLoginParamsFlags(startCmd)
+	createAPIVersioningFlags(startCmd)
+	createLockoutFlags(startCmd)
+	createNetworkPolicyFlags(startCmd)
+	createTokenExchangeFlags(startCmd)
+	createKeyRotationFlags(startCmd)
+	createDIDKeyRotationFlags(startCmd)
+	createLoggingFlags(startCmd)
+	createTokenStoreFlags(startCmd)
+	createUserDirectoryFlags(startCmd)
+	createSCIMFlags(startCmd)
+	createFeatureFlags(startCmd)
+	createFaultInjectionFlags(startCmd)
+	createOnboardingTemplateFlags(startCmd)
+	createDatabaseFlags(startCmd)
+	createHSMFlags(startCmd)
+	createMaintenanceFlags(startCmd)
+	createStartupValidationFlags(startCmd)
+	createShareEscrowFlags(startCmd)
+	createVerificationFlags(startCmd)
+	createIssuerFlags(startCmd)
+	createSessionBindingFlags(startCmd)
+	createPseudonymFlags(startCmd)
+	createRememberFlags(startCmd)
+	createIdempotencyFlags(startCmd)
+	createAuthzFlags(startCmd)
+	createOutboxFlags(startCmd)
+	createGRPCFlags(startCmd)
+	createPushFlags(startCmd)
+	createOIDCShadowFlags(startCmd)
+	createDevModeFlags(startCmd)
 }
 
 func createTLSFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP(tlsKeyFileFlagName, tlsKeyFileFlagShorthand, "", tlsKeyFileFlagUsage)
 	cmd.Flags().StringP(tlsCertFileFlagName, tlsCertFileFlagShorthand, "", tlsCertFileFlagUsage)
 	cmd.Flags().StringArrayP(tlsCACertsFlagName, "", []string{}, tlsCACertsFlagUsage)
+	cmd.Flags().StringP(tlsClientCertFileFlagName, "", "", tlsClientCertFileFlagUsage)
+	cmd.Flags().StringP(tlsClientKeyFileFlagName, "", "", tlsClientKeyFileFlagUsage)
 }
 
 func createOIDCFlags(cmd *cobra.Command) {
@@ -347,6 +938,10 @@ func createOIDCFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP(oidcClientIDFlagName, "", "", oidcClientIDFlagUsage)
 	cmd.Flags().StringP(oidcClientSecretFlagName, "", "", oidcClientSecretFlagUsage)
 	cmd.Flags().StringP(oidcCallbackURLFlagName, "", "", oidcCallbackURLFlagUsage)
+	cmd.Flags().StringArrayP(oidcClaimAttributesFlagName, "", []string{}, oidcClaimAttributesFlagUsage)
+	cmd.Flags().StringArrayP(oidcClaimAllowListFlagName, "", []string{}, oidcClaimAllowListFlagUsage)
+	cmd.Flags().StringArrayP(oidcClaimDenyListFlagName, "", []string{}, oidcClaimDenyListFlagUsage)
+	cmd.Flags().StringArrayP(oidcClaimRenameFlagName, "", []string{}, oidcClaimRenameFlagUsage)
 }
 
 func createKeyFlags(cmd *cobra.Command) {
@@ -383,6 +978,46 @@ func getDependencyMaxRetries(cmd *cobra.Command) (uint64, error) {
 	return maxRetries, nil
 }
 
+func getOnboardingWorkers(cmd *cobra.Command) (int, error) {
+	workersConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, onboardingWorkersFlagName, onboardingWorkersEnvKey)
+	if workersConfig == "" {
+		return onboardingWorkersDefault, nil
+	}
+
+	workers, err := strconv.Atoi(workersConfig)
+	if err != nil || workers < 1 {
+		return 0, fmt.Errorf("invalid onboardingWorkers value '%s': must be a positive integer", workersConfig)
+	}
+
+	return workers, nil
+}
+
+func getOnboardingStepTimeout(cmd *cobra.Command) (time.Duration, error) {
+	timeoutConfig := cmdutils.GetUserSetOptionalVarFromString(cmd,
+		onboardingStepTimeoutFlagName, onboardingStepTimeoutEnvKey)
+	if timeoutConfig == "" {
+		return onboardingStepTimeoutDefault, nil
+	}
+
+	timeout, err := time.ParseDuration(timeoutConfig)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s value '%s': must be a positive duration",
+			onboardingStepTimeoutFlagName, timeoutConfig)
+	}
+
+	return timeout, nil
+}
+
+// getOnboardingLockTTL returns 0 if the lock TTL defaults to that of the Operation.
+func getOnboardingLockTTL(cmd *cobra.Command) (time.Duration, error) {
+	ttlConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, onboardingLockTTLFlagName, onboardingLockTTLEnvKey)
+	if ttlConfig == "" {
+		return 0, nil
+	}
+
+	return parsePositiveDuration(onboardingLockTTLFlagName, ttlConfig)
+}
+
 func getTLSParams(cmd *cobra.Command) (*tlsParameters, error) {
 	params := &tlsParameters{}
 
@@ -415,10 +1050,27 @@ func getTLSParams(cmd *cobra.Command) (*tlsParameters, error) {
 		}
 	}
 
+	clientCertFile := cmdutils.GetUserSetOptionalVarFromString(cmd, tlsClientCertFileFlagName, tlsClientCertFileEnvKey)
+	clientKeyFile := cmdutils.GetUserSetOptionalVarFromString(cmd, tlsClientKeyFileFlagName, tlsClientKeyFileEnvKey)
+
+	if clientCertFile != "" || clientKeyFile != "" {
+		if clientCertFile == "" || clientKeyFile == "" {
+			return nil, fmt.Errorf("both %s and %s must be set for mutual TLS",
+				tlsClientCertFileFlagName, tlsClientKeyFileFlagName)
+		}
+
+		loader, err := mtls.NewCertLoader(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure tls client certificate: %w", err)
+		}
+
+		params.clientCert = loader.GetClientCertificate
+	}
+
 	return params, nil
 }
 
-func getOIDCParams(cmd *cobra.Command) (*oidcParameters, error) {
+func getOIDCParams(cmd *cobra.Command, secretsParams *secretsParameters) (*oidcParameters, error) {
 	params := &oidcParameters{}
 
 	var err error
@@ -428,8 +1080,9 @@ func getOIDCParams(cmd *cobra.Command) (*oidcParameters, error) {
 		return nil, fmt.Errorf("failed to configure OIDC clientID: %w", err)
 	}
 
+	// the client secret held by the secrets manager is fetched along with the OIDC client
 	params.clientSecret, err = cmdutils.GetUserSetVarFromString(
-		cmd, oidcClientSecretFlagName, oidcClientSecretEnvKey, false)
+		cmd, oidcClientSecretFlagName, oidcClientSecretEnvKey, secretsParams != nil && secretsParams.oidcClientSecret != "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure OIDC client secret: %w", err)
 	}
@@ -446,10 +1099,55 @@ func getOIDCParams(cmd *cobra.Command) (*oidcParameters, error) {
 		return nil, fmt.Errorf("failed to configure OIDC provider URL: %w", err)
 	}
 
+	claimAttributes, err := cmdutils.GetUserSetVarFromArrayString(
+		cmd, oidcClaimAttributesFlagName, oidcClaimAttributesEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OIDC claim attributes: %w", err)
+	}
+
+	params.claimAttributes, err = claims.ParseAttributeMapping(claimAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OIDC claim attributes: %w", err)
+	}
+
+	params.claimsFilter, err = getClaimsFilter(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure OIDC claims filter: %w", err)
+	}
+
 	return params, nil
 }
 
-func getWebAuthParams(cmd *cobra.Command) (*webauthParameters, error) {
+// getClaimsFilter returns nil if all user info claims are sent to the wallet UI.
+func getClaimsFilter(cmd *cobra.Command) (*claims.Filter, error) {
+	allow, err := cmdutils.GetUserSetVarFromArrayString(cmd, oidcClaimAllowListFlagName, oidcClaimAllowListEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := cmdutils.GetUserSetVarFromArrayString(cmd, oidcClaimDenyListFlagName, oidcClaimDenyListEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	renames, err := cmdutils.GetUserSetVarFromArrayString(cmd, oidcClaimRenameFlagName, oidcClaimRenameEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(allow) == 0 && len(deny) == 0 && len(renames) == 0 {
+		return nil, nil
+	}
+
+	rename, err := claims.ParseAttributeMapping(renames)
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims.Filter{Allow: allow, Deny: deny, Rename: rename}, nil
+}
+
+func getWebAuthParams(cmd *cobra.Command) (*webauthParameters, error) {
 	params := &webauthParameters{}
 
 	var err error
@@ -475,8 +1173,25 @@ func getWebAuthParams(cmd *cobra.Command) (*webauthParameters, error) {
 	return params, nil
 }
 
-func getKeyParams(cmd *cobra.Command) (*keyParameters, error) {
-	params := &keyParameters{}
+func getKeyParams(cmd *cobra.Command, secretsParams *secretsParameters,
+	hsmParams *hsmParameters) (*keyParameters, error) {
+	if hsmParams != nil && hsmParams.cookieKeyLabel != "" {
+		cipher, err := hsmParams.keys.Cipher(hsmParams.cookieKeyLabel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure session cookie key: %w", err)
+		}
+
+		return &keyParameters{sessionCookieKeys: cookie.NewCipherKeyRing(cipher)}, nil
+	}
+
+	if secretsParams != nil && secretsParams.cookieAuthKey != "" {
+		ring, err := watchCookieKeys(secretsParams)
+		if err != nil {
+			return nil, err
+		}
+
+		return &keyParameters{sessionCookieKeys: ring, rotated: true}, nil
+	}
 
 	sessionCookieAuthKeyPath, err := cmdutils.GetUserSetVarFromString(cmd,
 		sessionCookieAuthKeyFlagName, sessionCookieAuthKeyEnvKey, false)
@@ -484,7 +1199,7 @@ func getKeyParams(cmd *cobra.Command) (*keyParameters, error) {
 		return nil, fmt.Errorf("failed to configure session cookie auth key: %w", err)
 	}
 
-	params.sessionCookieAuthKey, err = parseKey(sessionCookieAuthKeyPath)
+	sessionCookieAuthKey, err := parseKey(sessionCookieAuthKeyPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure session cookie auth key: %w", err)
 	}
@@ -495,12 +1210,12 @@ func getKeyParams(cmd *cobra.Command) (*keyParameters, error) {
 		return nil, fmt.Errorf("failed to configure session cookie enc key: %w", err)
 	}
 
-	params.sessionCookieEncKey, err = parseKey(sessionCookieEncKeyPath)
+	sessionCookieEncKey, err := parseKey(sessionCookieEncKeyPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure session cooie enc key: %w", err)
 	}
 
-	return params, nil
+	return &keyParameters{sessionCookieKeys: cookie.NewKeyRing(sessionCookieAuthKey, sessionCookieEncKey)}, nil
 }
 
 func getKeyServerParams(cmd *cobra.Command) (*keyServerParameters, error) {
@@ -547,7 +1262,8 @@ func parseKey(file string) ([]byte, error) {
 	return bits, nil
 }
 
-func initOIDCProvider(providerURL string, retries uint64, tlsConfig *tls.Config) (*oidcp.Provider, error) {
+func initOIDCProvider(providerURL string, retries uint64, tlsConfig *tls.Config,
+	proxyFunc proxy.Func) (*oidcp.Provider, error) {
 	var provider *oidcp.Provider
 
 	err := backoff.RetryNotify(
@@ -558,6 +1274,7 @@ func initOIDCProvider(providerURL string, retries uint64, tlsConfig *tls.Config)
 					context.Background(),
 					&http.Client{Transport: &http.Transport{
 						TLSClientConfig: tlsConfig,
+						Proxy:           proxyFunc,
 					}},
 				),
 				providerURL,
@@ -578,8 +1295,41 @@ func initOIDCProvider(providerURL string, retries uint64, tlsConfig *tls.Config)
 	return provider, nil
 }
 
+// deviceAuthorizationURL returns the device authorization endpoint advertised by the provider, if any.
+func deviceAuthorizationURL(provider *oidcp.Provider) string {
+	metadata := struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	}{}
+
+	err := provider.Claims(&metadata)
+	if err != nil {
+		logger.Warnf("failed to read the device authorization endpoint of the OIDC provider: %s", err)
+	}
+
+	return metadata.DeviceAuthorizationEndpoint
+}
+
+// providerMetadata returns the discovery document of the provider, which the client is validated against.
+func providerMetadata(provider *oidcp.Provider) *oidc2.Metadata {
+	metadata := &oidc2.Metadata{}
+
+	err := provider.Claims(metadata)
+	if err != nil {
+		logger.Warnf("failed to read the discovery document of the OIDC provider: %s", err)
+
+		return nil
+	}
+
+	return metadata
+}
+
 func startHTTPServer(parameters *httpServerParameters) error {
-	err := setLogLevel(parameters.logLevel)
+	err := logging.Initialize(parameters.logging)
+	if err != nil {
+		return fmt.Errorf("failed to initialize logging: %w", err)
+	}
+
+	err = setLogLevel(parameters.logLevel)
 	if err != nil {
 		return fmt.Errorf("failed to set log level: %w", err)
 	}
@@ -589,14 +1339,55 @@ func startHTTPServer(parameters *httpServerParameters) error {
 		return fmt.Errorf("failed to configure router: %w", err)
 	}
 
-	handler := cors.New(
-		cors.Options{
-			AllowedMethods:   []string{http.MethodGet, http.MethodPost},
-			AllowedHeaders:   []string{"Origin", "Accept", "Content-Type", "X-Requested-With", "Authorization"},
-			AllowedOrigins:   []string{parameters.agentUIURL},
-			AllowCredentials: true,
-		},
-	).Handler(router)
+	if parameters.secrets != nil {
+		parameters.secrets.watcher.Start()
+	}
+
+	if srv, ok := parameters.srv.(limitedServer); ok && parameters.limits != nil && parameters.limits.server != nil {
+		srv.SetLimits(parameters.limits.server)
+	}
+
+	if flags := parameters.featureFlags.flags(); flags != nil {
+		flags.Start()
+		defer flags.Stop()
+	}
+
+	if parameters.health != nil {
+		parameters.health.watcher.Start()
+		defer parameters.health.watcher.Stop()
+	}
+
+	if cluster := parameters.keys.cluster; cluster != nil {
+		cluster.Start()
+		defer cluster.Stop()
+	}
+
+	if mode := parameters.maintenance.mode(); mode != nil {
+		mode.Start()
+		defer mode.Stop()
+	}
+
+	for _, keys := range parameters.issuers.keySets() {
+		keys.Start()
+		defer keys.Stop()
+	}
+
+	handler := newCORSSwitch(parameters.cors, router)
+
+	if parameters.config != nil {
+		parameters.config.watch(func() error {
+			return reloadSettings(parameters, handler)
+		})
+	}
+
+	if parameters.grpc != nil {
+		grpcServer, grpcErr := startGRPCServer(parameters.grpc)
+		if grpcErr != nil {
+			return fmt.Errorf("failed to start grpc server: %w", grpcErr)
+		}
+
+		defer grpcServer.Stop()
+	}
 
 	logger.Infof("starting http-server on %s...", parameters.hostURL)
 
@@ -613,72 +1404,607 @@ func startHTTPServer(parameters *httpServerParameters) error {
 func router(config *httpServerParameters) (http.Handler, error) {
 	root := mux.NewRouter()
 
-	root.HandleFunc(healthCheckPath, healthCheckHandler).Methods(http.MethodGet)
+	config.openapi = openapi.New(openAPITitle, openAPIVersion)
+
+	mount(root, []common.Handler{
+		common.NewHTTPHandler(healthCheckPath, http.MethodGet, healthCheckHandler, &common.OperationSpec{
+			Summary:   "Reports the server is up.",
+			Responses: map[int]interface{}{http.StatusOK: &healthCheckResp{}},
+		}),
+		common.NewHTTPHandler(openAPIPath, http.MethodGet, config.openapi.Handler(), &common.OperationSpec{
+			Summary: "Returns this OpenAPI document.",
+		}),
+	}, nil, config.openapi)
 
 	oidcRouter := root.PathPrefix(oidcBasePath).Subrouter()
 
-	store := memstore.NewProvider()
+	// the login and onboarding state is shared by the instances of the agent sharing the database
+	store, err := newStorageProvider(config.database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init storage: %w", err)
+	}
+
+	// the secrets manager serves the same rotated keys to every instance
+	if config.database.shared() && !config.keys.rotated {
+		config.keys.cluster, err = cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: store,
+			Ring:    config.keys.sessionCookieKeys,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to join the cluster: %w", err)
+		}
+	}
+
+	// the instances sharing the database append to the same audit chain under its lock
+	var auditLog *audit2.Store
+
+	if config.database.shared() {
+		auditLog, err = audit2.NewSharedStore(store, &lock.Config{TTL: auditLockTTL})
+	} else {
+		auditLog, err = audit2.NewStore(store)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to init audit store: %w", err)
+	}
+
+	publicPolicy, err := networkPolicy(config.networkPolicy, publicGroup, auditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicPolicy != nil {
+		root.Use(exceptAdmin(publicPolicy))
+	}
+
+	if config.limits != nil && config.limits.routes != nil {
+		root.Use(mux.MiddlewareFunc(limits.Middleware(config.limits.routes)))
+	}
+
+	err = initMaintenance(config.maintenance, store)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sessions blocked under maintenance leave the agent up for its probes and administration
+	root.Use(mux.MiddlewareFunc(config.maintenance.mode().Middleware(adminBasePath, healthCheckPath)))
+
+	adminPolicy, err := networkPolicy(config.networkPolicy, adminGroup, auditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := newDIDResolver(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var adminRouter *mux.Router
+
+	if config.adminToken != "" {
+		adminRouter = root.PathPrefix(adminBasePath).Subrouter()
+
+		if adminPolicy != nil {
+			adminRouter.Use(adminPolicy)
+		}
+
+		// audited ahead of the authentication, so that rejected attempts are recorded too
+		adminRouter.Use(mux.MiddlewareFunc(audit.Middleware(auditLog)), adminAuth(config.adminToken))
+	}
+
+	webhooks, err := events.NewWebhookStore(store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init webhook store: %w", err)
+	}
+
+	bus := events.NewBus(&events.BusConfig{
+		Webhooks: webhooks,
+		HTTPClient: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{TLSClientConfig: config.tls.config},
+		},
+	})
+
+	provider, err := initOIDCProvider(config.oidc.providerURL, config.dependencyMaxRetries, config.tls.config,
+		config.proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init OIDC provider: %w", err)
+	}
+
+	err = initTrustedIssuers(config, provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init trusted issuers: %w", err)
+	}
+
+	var remembered *remember.Store
+
+	if config.rememberTTL > 0 {
+		remembered, err = remember.NewStore(store, config.rememberTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init remember-me store: %w", err)
+		}
+	}
+
+	// the handlers notifying the users without a state change of their own enqueue their events too, so that the
+	// events are retried when the webhooks are unavailable
+	config.outbox, err = outbox.NewStore(store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init outbox store: %w", err)
+	}
+
+	binder, err := sessionBinder(config.sessionBinding, auditLog, config.outbox)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := pseudonyms(config.pseudonyms, store)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := user.NewStore(store, user.WithPseudonyms(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to init user store: %w", err)
+	}
+
+	err = initFeatureFlags(config, users)
+	if err != nil {
+		return nil, err
+	}
+
+	// the administrators authenticated with the admin token may also be required to be logged in users meeting
+	// the policy
+	if authorize := authorization(config, users); adminRouter != nil && authorize != nil {
+		adminRouter.Use(mux.MiddlewareFunc(authorize))
+	}
+
+	// the handlers serving the wallet users also accept the access tokens of non-browser clients
+	api, err := apiMiddleware(config, providerAdapter(config, provider), store, remembered, binder, users)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init API middleware: %w", err)
+	}
 
-	err := addOIDCHandlers(oidcRouter, config, store)
+	oidcOps, err := addOIDCHandlers(oidcRouter, adminRouter, config, store, bus, provider, auditLog, remembered,
+		binder, ids, api)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add OIDC handlers: %w", err)
 	}
 
+	err = validateStartup(config.startupValidation, oidcOps)
+	if err != nil {
+		return nil, err
+	}
+
+	// the status of the dependencies is served ahead of the login
+	mount(root, oidcOps.GetStatusRESTHandlers(), nil, config.openapi)
+
+	if config.grpc != nil {
+		config.grpc.backend = oidcOps
+	}
+
+	if adminRouter != nil {
+		err = addWebhookHandlers(adminRouter, config, webhooks, auditLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add webhook handlers: %w", err)
+		}
+
+		err = addAuditHandlers(adminRouter, config, auditLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add audit handlers: %w", err)
+		}
+
+		addDIDResolverHandlers(adminRouter, config, resolver)
+
+		if config.maintenance.mode() != nil {
+			err = addMaintenanceHandlers(adminRouter, config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add maintenance handlers: %w", err)
+			}
+		}
+	}
+
+	err = addSCIMHandlers(root, config, oidcOps, auditLog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add scim handlers: %w", err)
+	}
+
+	addFeatureHandlers(root, config, api)
+
+	err = addVerificationHandlers(root, config, resolver, api)
+	if err != nil {
+		return nil, err
+	}
+
+	notificationOps, err := addNotificationHandlers(root, config, bus, api)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add notification handlers: %w", err)
+	}
+
 	deviceRouter := root.PathPrefix(deviceBasePath).Subrouter()
 
-	err = addDeviceHandlers(deviceRouter, config, store)
+	err = addDeviceHandlers(deviceRouter, config, store, ids, api)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add device handlers: %w", err)
 	}
 
-	return root, nil
+	if config.agentDIDCommURL != "" {
+		err = addAgentHandlers(root, config, store, oidcOps, bus, api)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add agent handlers: %w", err)
+		}
+
+		err = addInboxHandlers(root, config, store, bus, api)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add inbox handlers: %w", err)
+		}
+
+		err = addActivityHandlers(root, config, store, bus, api)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add activity handlers: %w", err)
+		}
+
+		if config.push != nil {
+			err = addPushHandlers(root, config, store, bus, notificationOps, api)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add push handlers: %w", err)
+			}
+		}
+	}
+
+	handler := versioned(root, config.versioning)
+
+	// set on every response, the pages of the agent UI and the 404s included
+	if h := config.securityHeaders.headers(); h != nil {
+		addCSPReportHandlers(root, adminRouter, config)
+
+		handler = headers.Middleware(h)(handler)
+	}
+
+	return handler, nil
 }
 
-func addOIDCHandlers(router *mux.Router, config *httpServerParameters, store storage.Provider) error {
-	provider, err := initOIDCProvider(config.oidc.providerURL, config.dependencyMaxRetries, config.tls.config)
+func addOIDCHandlers(router, adminRouter *mux.Router, config *httpServerParameters, store storage.Provider,
+	bus *events.Bus, provider *oidcp.Provider, auditLog *audit2.Store, remembered *remember.Store,
+	binder *binding.Binder, ids *pseudonym.Mapper, middleware []common.Middleware) (*oidc.Operation, error) {
+	oidcClient := oidc2.NewClient(&oidc2.Config{
+		TLSConfig:    config.tls.config,
+		Proxy:        config.proxy,
+		Provider:     providerAdapter(config, provider),
+		CallbackURL:  config.oidc.callbackURL,
+		ClientID:     config.oidc.clientID,
+		ClientSecret: config.oidc.clientSecret,
+		Scopes:       []string{oidcp.ScopeOpenID, "profile", "email"},
+		// lets CLI tools and devices without a browser log in with the device authorization grant
+		DeviceAuthorizationURL: deviceAuthorizationURL(provider),
+		// validated by the self-check of the configuration
+		Metadata: providerMetadata(provider),
+	})
+
+	err := watchClientSecret(config.secrets, oidcClient.SetClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.provisioningPool != nil {
+		config.provisioningPool.Token = func(ctx context.Context) (string, error) {
+			token, tokenErr := oidcClient.ClientCredentialsToken(ctx)
+			if tokenErr != nil {
+				return "", tokenErr
+			}
+
+			return token.AccessToken, nil
+		}
+	}
+
+	if config.tokenExchange != nil {
+		config.tokenExchange.Exchanger = oidcClient
+	}
+
+	// the secrets and the client credentials remain those of the primary client
+	client, err := shadowOIDCClient(adminRouter, config, oidcClient)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenStore, err := newTokenStore(config)
+	if err != nil {
+		return nil, err
+	}
+
+	userDirectory, err := newUserDirectory(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var watcher *health.Watcher
+
+	if config.health != nil {
+		config.health.watcher = newHealthWatcher(config)
+		watcher = config.health.watcher
+	}
+
+	signing, err := bootstrapSigning(config, store)
 	if err != nil {
-		return fmt.Errorf("failed to init OIDC provider: %w", err)
+		return nil, err
+	}
+
+	shareEscrow, err := newShareEscrow(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var siopConfig *oidc.SIOPConfig
+
+	if config.siop != nil {
+		siopConfig, err = newSIOPConfig(config.siop, config.tls.config)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	oidcOps, err := oidc.New(&oidc.Config{
-		WalletDashboard: config.agentUIURL + "/dashboard",
-		TLSConfig:       config.tls.config,
-		OIDCClient: oidc2.NewClient(&oidc2.Config{
-			TLSConfig:    config.tls.config,
-			Provider:     &oidc2.ProviderAdapter{OP: provider, TLSConfig: config.tls.config},
-			CallbackURL:  config.oidc.callbackURL,
-			ClientID:     config.oidc.clientID,
-			ClientSecret: config.oidc.clientSecret,
-			Scopes:       []string{oidcp.ScopeOpenID, "profile", "email"},
-		}),
+		WalletDashboard:   config.agentUIURL + "/dashboard",
+		TLSConfig:         config.tls.config,
+		ClientCertificate: config.tls.clientCert,
+		Proxy:             config.proxy,
+		OIDCClient:        client,
 		Storage: &oidc.StorageConfig{
 			Storage:          store,
-			TransientStorage: memstore.NewProvider(),
+			TransientStorage: store,
 		},
+		Tokens: tokenStore,
 		Keys: &oidc.KeyConfig{
-			Auth: config.keys.sessionCookieAuthKey,
-			Enc:  config.keys.sessionCookieEncKey,
+			Ring: config.keys.sessionCookieKeys,
 		},
 		KeyServer: &oidc.KeyServerConfig{
 			AuthzKMSURL: config.keyServer.authzKMSURL,
 			OpsKMSURL:   config.keyServer.opsKMSURL,
 			KeyEDVURL:   config.keyServer.keyEDVURL,
 		},
-		UserEDVURL: config.userEDVURL,
-		HubAuthURL: config.hubAuthURL,
+		UserEDVURL:            config.userEDVURL,
+		HubAuthURL:            config.hubAuthURL,
+		ClaimsMapper:          claims.NewPipeline(claims.Standard(), claims.Attributes(config.oidc.claimAttributes)),
+		ClaimsFilter:          config.oidc.claimsFilter,
+		Onboarding:            &oidc.OnboardingConfig{Workers: config.onboardingWorkers},
+		OnboardingStepTimeout: config.onboardingTimeout,
+		OnboardingLockTTL:     config.onboardingLockTTL,
+		Events: &oidc.EventsConfig{
+			Dispatcher:  bus,
+			Outbox:      config.outbox,
+			MaxAttempts: config.outboxMaxAttempts,
+		},
+		Consent:             config.consent,
+		UserInfoCache:       config.userInfoCache,
+		KeystoreCache:       config.keystoreCache,
+		SIOP:                siopConfig,
+		Health:              watcher,
+		ProvisioningPool:    config.provisioningPool,
+		OnboardingTemplates: config.onboardingTemplates,
+		Janitor:             config.janitor,
+		Quotas:              config.quotas,
+		BootstrapSigning:    signing,
+		StepUp:              config.stepUp,
+		LoginParams:         config.loginParams,
+		Lockout:             config.lockout,
+		TokenExchange:       config.tokenExchange,
+		KeyRotation:         config.keyRotation,
+		SessionBinding:      binder,
+		Pseudonyms:          ids,
+		Remember:            remembered,
+		Audit:               auditLog,
+		UserDirectory:       userDirectory,
+		Features:            config.featureFlags.flags(),
+		Faults:              config.faults,
+		Maintenance:         config.maintenance.mode(),
+		Escrow:              shareEscrow,
+		IssuerKeys:          config.issuers.keySets(),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to init oidc ops: %w", err)
+		return nil, fmt.Errorf("failed to init oidc ops: %w", err)
 	}
 
-	for _, handler := range oidcOps.GetRESTHandlers() {
-		router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+	mount(router, oidcOps.GetRESTHandlers(), middleware, config.openapi)
+
+	config.dashboards = append(config.dashboards, oidcOps.SetWalletDashboard)
+
+	if adminRouter != nil {
+		mount(adminRouter, oidcOps.GetAdminRESTHandlers(), config.middleware, config.openapi)
 	}
 
+	return oidcOps, nil
+}
+
+func addWebhookHandlers(adminRouter *mux.Router, config *httpServerParameters, webhooks *events.WebhookStore,
+	auditLog *audit2.Store) error {
+	webhookOps, err := webhook.New(&webhook.Config{Webhooks: webhooks, Audit: auditLog, Outbox: config.outbox})
+	if err != nil {
+		return fmt.Errorf("failed to init webhook ops: %w", err)
+	}
+
+	mount(adminRouter, webhookOps.GetRESTHandlers(), config.middleware, config.openapi)
+
+	return nil
+}
+
+func addAuditHandlers(adminRouter *mux.Router, config *httpServerParameters, auditLog *audit2.Store) error {
+	auditOps, err := audit.New(&audit.Config{Audit: auditLog})
+	if err != nil {
+		return fmt.Errorf("failed to init audit ops: %w", err)
+	}
+
+	mount(adminRouter, auditOps.GetRESTHandlers(), config.middleware, config.openapi)
+
+	return nil
+}
+
+func addNotificationHandlers(router *mux.Router, config *httpServerParameters, bus *events.Bus,
+	middleware []common.Middleware) (*notifications.Operation, error) {
+	notificationOps, err := notifications.New(&notifications.Config{
+		Events: bus,
+		Keys: &notifications.KeyConfig{
+			Ring: config.keys.sessionCookieKeys,
+		},
+		Topics: []string{
+			oidc.TopicUserOnboarded,
+			agent.TopicDIDCommMessage,
+			agent.TopicCredentialReceived,
+			agent.TopicCredentialTransfer,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to init notification ops: %w", err)
+	}
+
+	mount(router, notificationOps.GetRESTHandlers(), middleware, config.openapi)
+
+	return notificationOps, nil
+}
+
+// addInboxHandlers keeps the DIDComm messages received by the agent in the inbox of their user.
+func addInboxHandlers(router *mux.Router, config *httpServerParameters, store storage.Provider, bus *events.Bus,
+	middleware []common.Middleware) error {
+	inboxOps, err := inbox.New(&inbox.Config{
+		Events:  bus,
+		Storage: store,
+		Keys: &inbox.KeyConfig{
+			Ring: config.keys.sessionCookieKeys,
+		},
+		Topics: []string{agent.TopicDIDCommMessage},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init inbox ops: %w", err)
+	}
+
+	mount(router, inboxOps.GetRESTHandlers(), middleware, config.openapi)
+
 	return nil
 }
 
-func addDeviceHandlers(router *mux.Router, config *httpServerParameters, store storage.Provider) error {
+// addActivityHandlers records the credentials received and transferred, the presentations shared and the connections
+// established by the agent in the timeline of their user.
+func addActivityHandlers(router *mux.Router, config *httpServerParameters, store storage.Provider, bus *events.Bus,
+	middleware []common.Middleware) error {
+	activityOps, err := activity.New(&activity.Config{
+		Events:  bus,
+		Storage: store,
+		Keys: &activity.KeyConfig{
+			Ring: config.keys.sessionCookieKeys,
+		},
+		Topics: []string{
+			agent.TopicCredentialReceived,
+			agent.TopicPresentationShared,
+			agent.TopicConnectionEstablished,
+			agent.TopicCredentialTransfer,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init activity ops: %w", err)
+	}
+
+	mount(router, activityOps.GetRESTHandlers(), middleware, config.openapi)
+
+	return nil
+}
+
+// mount registers the handlers on the router behind the middleware, and describes them in the OpenAPI document.
+func mount(router *mux.Router, handlers []common.Handler, middleware []common.Middleware, doc *openapi.Document) {
+	for _, handler := range common.Wrap(handlers, middleware...) {
+		route := router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method()).
+			MatcherFunc(versionMatcher(handler))
+
+		path, err := route.GetPathTemplate()
+		if err == nil && doc != nil {
+			for _, documented := range documentedPaths(handler, path) {
+				doc.Add(documented, handler.Method(), handler.Spec())
+			}
+		}
+	}
+}
+
+// adminAuth only lets through requests bearing the admin token.
+func adminAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+			if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func addAgentHandlers(root *mux.Router, config *httpServerParameters, store storage.Provider,
+	vault *oidc.Operation, bus *events.Bus, middleware []common.Middleware) error {
+	inbound := agent.NewInbound(config.agentDIDCommURL)
+
+	framework, err := agent.NewFramework(&agent.FrameworkConfig{
+		OpsKMSURL:         config.keyServer.opsKMSURL,
+		TLSConfig:         config.tls.config,
+		ClientCertificate: config.tls.clientCert,
+		Proxy:             config.proxy,
+		Storage:           store,
+		Inbound:           inbound,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init aries agent: %w", err)
+	}
+
+	ctx, err := framework.Context()
+	if err != nil {
+		return fmt.Errorf("failed to get aries context: %w", err)
+	}
+
+	agentOps, err := agent.New(&agent.Config{
+		Aries: ctx,
+		Keys: &agent.KeyConfig{
+			Ring: config.keys.sessionCookieKeys,
+		},
+		TLSConfig: config.tls.config,
+		Proxy:     config.proxy,
+		OIDC4VCI: &agent.OIDC4VCIConfig{
+			ClientID:         config.oidc4vciClientID,
+			RedirectURL:      config.oidc4vciRedirectURL,
+			Vault:            vault,
+			TransientStorage: store,
+		},
+		OIDC4VP: &agent.OIDC4VPConfig{
+			Vault:            vault,
+			TransientStorage: store,
+		},
+		Events: &agent.EventsConfig{
+			Dispatcher: config.outbox,
+			Storage:    store,
+		},
+		Storage:     store,
+		KeyRotation: config.didKeyRotation,
+		Transfers: &agent.TransferConfig{
+			Vault:   vault,
+			Storage: store,
+		},
+		Features: config.featureFlags.flags(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init agent ops: %w", err)
+	}
+
+	vault.AddWalletSource(agentOps.WalletMetadata())
+
+	root.Handle(didcommPath, inbound).Methods(http.MethodPost)
+
+	router := root.PathPrefix(agentBasePath).Subrouter()
+
+	mount(router, agentOps.GetRESTHandlers(), middleware, config.openapi)
+
+	return nil
+}
+
+func addDeviceHandlers(router *mux.Router, config *httpServerParameters, store storage.Provider,
+	ids *pseudonym.Mapper, middleware []common.Middleware) error {
 	webAuthn, err := webauthn.New(&webauthn.Config{
 		RPDisplayName: config.webAuth.rpDisplayName, // Display Name for your site
 		RPID:          config.webAuth.rpID,          // Generally the domain name for your site
@@ -693,21 +2019,21 @@ func addDeviceHandlers(router *mux.Router, config *httpServerParameters, store s
 		TLSConfig:       config.tls.config,
 		Storage: &device.StorageConfig{
 			Storage:      store,
-			SessionStore: memstore.NewProvider(),
+			SessionStore: store,
 		},
 		Keys: &device.KeyConfig{
-			Auth: config.keys.sessionCookieAuthKey,
-			Enc:  config.keys.sessionCookieEncKey,
+			Ring: config.keys.sessionCookieKeys,
 		},
-		Webauthn: webAuthn,
+		Webauthn:   webAuthn,
+		Pseudonyms: ids,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to init device ops: %w", err)
 	}
 
-	for _, handler := range deviceOps.GetRESTHandlers() {
-		router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
-	}
+	mount(router, deviceOps.GetRESTHandlers(), middleware, config.openapi)
+
+	config.dashboards = append(config.dashboards, deviceOps.SetWalletDashboard)
 
 	return nil
 }
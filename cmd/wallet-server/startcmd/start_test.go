@@ -10,33 +10,239 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/agent"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/binding"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/headers"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys/hsm"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/limits"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/logging"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/openapi"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/signer"
+	audit2 "github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"gopkg.in/yaml.v2"
 )
 
 type mockServer struct {
-	Err error
+	Err     error
+	Handler http.Handler
 }
 
 func (s *mockServer) ListenAndServe(host, certFile, keyFile string, handler http.Handler) error {
+	s.Handler = handler
+
 	return s.Err
 }
 
+func TestRouter_Middleware(t *testing.T) {
+	router, err := router(&httpServerParameters{
+		oidc: &oidcParameters{providerURL: mockOIDCProvider(t)},
+		tls:  &tlsParameters{},
+		keys: &keyParameters{},
+		webAuth: &webauthParameters{
+			rpDisplayName: "Foobar Corp.",
+			rpID:          "localhost",
+			rpOrigin:      "http://localhost",
+		},
+		keyServer: &keyServerParameters{
+			authzKMSURL: "http://localhost",
+		},
+		middleware: []common.Middleware{func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "applied")
+				next.ServeHTTP(w, r)
+			})
+		}},
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notifications", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, "applied", w.Header().Get("X-Test"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, healthCheckPath, nil))
+	require.Empty(t, w.Header().Get("X-Test"))
+}
+
+func TestRouter_OpenAPI(t *testing.T) {
+	router, err := router(&httpServerParameters{
+		oidc: &oidcParameters{providerURL: mockOIDCProvider(t)},
+		tls:  &tlsParameters{},
+		keys: &keyParameters{},
+		webAuth: &webauthParameters{
+			rpDisplayName: "Foobar Corp.",
+			rpID:          "localhost",
+			rpOrigin:      "http://localhost",
+		},
+		keyServer: &keyServerParameters{
+			authzKMSURL: "http://localhost",
+		},
+		adminToken: "admin",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	doc := &openapi.Document{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), doc))
+	require.Equal(t, openAPITitle, doc.Info.Title)
+
+	for path, method := range map[string]string{
+		healthCheckPath:              "get",
+		openAPIPath:                  "get",
+		"/v1/oidc/login":             "get",
+		"/v1/oidc/userinfo/export":   "get",
+		"/v1/admin/users/check":      "get",
+		"/v1/admin/webhooks":         "post",
+		"/v1/notifications":          "get",
+		"/v1/device/register/finish": "post",
+	} {
+		require.Contains(t, doc.Paths, path)
+		require.Contains(t, doc.Paths[path], method, path)
+	}
+
+	require.NotContains(t, doc.Paths, "/oidc/login")
+	require.NotContains(t, doc.Paths, "/v1"+healthCheckPath)
+
+	export := doc.Paths["/v1/oidc/userinfo/export"]["get"]
+	require.Equal(t, "getOidcUserinfoExport", export.OperationID)
+	require.Equal(t, "#/components/schemas/oidc.UserDataExport",
+		export.Responses["200"].Content["application/json"].Schema.Ref)
+	require.Contains(t, doc.Components.Schemas, "oidc.UserDataExport")
+
+	ids := make(map[string]bool)
+
+	for _, item := range doc.Paths {
+		for _, op := range item {
+			require.False(t, ids[op.OperationID], op.OperationID)
+			ids[op.OperationID] = true
+		}
+	}
+}
+
+func TestRouter_AuditsAdminRequests(t *testing.T) {
+	router, err := router(&httpServerParameters{
+		oidc: &oidcParameters{providerURL: mockOIDCProvider(t)},
+		tls:  &tlsParameters{},
+		keys: &keyParameters{},
+		webAuth: &webauthParameters{
+			rpDisplayName: "Foobar Corp.",
+			rpID:          "localhost",
+			rpOrigin:      "http://localhost",
+		},
+		keyServer: &keyServerParameters{
+			authzKMSURL: "http://localhost",
+		},
+		adminToken: "token",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, adminBasePath+"webhooks", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, adminBasePath+"audit", nil)
+	req.Header.Set("Authorization", "Bearer token")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var entries []*audit2.Entry
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+	require.Len(t, entries, 1)
+	require.Equal(t, audit2.ActionAdmin, entries[0].Action)
+	require.Contains(t, string(entries[0].Details), `"status":401`)
+}
+
+func TestRouter_Versioning(t *testing.T) {
+	config := &httpServerParameters{
+		oidc: &oidcParameters{providerURL: mockOIDCProvider(t)},
+		tls:  &tlsParameters{},
+		keys: &keyParameters{},
+		webAuth: &webauthParameters{
+			rpDisplayName: "Foobar Corp.",
+			rpID:          "localhost",
+			rpOrigin:      "http://localhost",
+		},
+		keyServer: &keyServerParameters{
+			authzKMSURL: "http://localhost",
+		},
+	}
+
+	t.Run("serves the API under its version prefix and the legacy routes", func(t *testing.T) {
+		router, err := router(config)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/notifications", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Equal(t, "1", w.Header().Get(common.APIVersionHeader))
+		require.Empty(t, w.Header().Get("Deprecation"))
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notifications", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Equal(t, "true", w.Header().Get("Deprecation"))
+	})
+
+	t.Run("serves the API under its version prefix only", func(t *testing.T) {
+		config.versioning = &common.VersioningConfig{Unversioned: unversionedPaths()}
+
+		router, err := router(config)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notifications", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/notifications", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, healthCheckPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
 func TestListenAndServe(t *testing.T) {
 	router, err := router(&httpServerParameters{
 		oidc: &oidcParameters{providerURL: mockOIDCProvider(t)},
@@ -226,7 +432,7 @@ func TestStartCmdWithMissingArg(t *testing.T) {
 
 		err := startCmd.Execute()
 		require.Error(t, err)
-		require.Contains(t, err.Error(), "failed to init OIDC provider")
+		require.Contains(t, err.Error(), "oidc-opurl 'INVALID' is not an absolute URL")
 	})
 
 	t.Run("missing oidc client ID", func(t *testing.T) {
@@ -550,184 +756,3433 @@ func TestStartCmdValidArgs(t *testing.T) {
 	require.NoError(t, err)
 }
 
-func TestStartCmdValidArgsEnvVar(t *testing.T) {
+func TestStartCmdWithInvalidClaimAttributes(t *testing.T) {
 	startCmd := GetStartCmd(&mockServer{})
 
-	err := os.Setenv(hostURLEnvKey, "localhost:8080")
-	require.NoError(t, err)
+	startCmd.SetArgs(append(validArgs(t), "--"+oidcClaimAttributesFlagName, "=attribute"))
 
-	err = os.Setenv(tlsCertFileEnvKey, "cert")
-	require.NoError(t, err)
+	err := startCmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to configure OIDC claim attributes")
+}
 
-	err = os.Setenv(tlsKeyFileEnvKey, "key")
-	require.NoError(t, err)
+func TestStartCmdWithAdminToken(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
 
-	err = os.Setenv(agentUIURLEnvKey, "ui")
-	require.NoError(t, err)
+	startCmd.SetArgs(append(validArgs(t), "--"+adminTokenFlagName, "token"))
 
-	err = os.Setenv(oidcProviderURLEnvKey, mockOIDCProvider(t))
-	require.NoError(t, err)
+	require.NoError(t, startCmd.Execute())
+}
 
-	err = os.Setenv(oidcClientIDEnvKey, uuid.New().String())
-	require.NoError(t, err)
+func TestStartCmdWithSCIM(t *testing.T) {
+	srv := &mockServer{}
 
-	err = os.Setenv(oidcClientSecretEnvKey, uuid.New().String())
-	require.NoError(t, err)
+	startCmd := GetStartCmd(srv)
+	startCmd.SetArgs(append(validArgs(t), "--"+scimTokenFlagName, "token"))
+	require.NoError(t, startCmd.Execute())
+	require.Equal(t, "token", getSCIMToken(startCmd))
 
-	err = os.Setenv(oidcCallbackURLEnvKey, "http://test.com/callback")
-	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/scim/v2/Users/alice", nil))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
 
-	err = os.Setenv(sessionCookieEncKeyEnvKey, key(t))
-	require.NoError(t, err)
+	r := httptest.NewRequest(http.MethodGet, "/v1/scim/v2/Users/alice", nil)
+	r.Header.Set("Authorization", "Bearer token")
 
-	err = os.Setenv(sessionCookieAuthKeyEnvKey, key(t))
-	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "application/scim+json", w.Header().Get("Content-Type"))
+}
 
-	err = os.Setenv(webAuthRPDisplayEnvKey, "Foobar Corp.")
-	require.NoError(t, err)
+func TestStartCmdWithFeatureFlags(t *testing.T) {
+	t.Run("serves the feature flags", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "features.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte("device-login:\n  tenants: [b]\n"), 0600))
 
-	err = os.Setenv(webAuthRPIDEnvKey, "http://localhost")
-	require.NoError(t, err)
+		srv := &mockServer{}
 
-	err = os.Setenv(webAuthRPOriginEnvKey, "localhost")
-	require.NoError(t, err)
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+featureFlagsFlagName, file,
+			"--"+featureFlagsIntervalFlagName, "1m",
+		))
+		require.NoError(t, startCmd.Execute())
 
-	err = os.Setenv(authzKMSURLEnvKey, "localhost")
-	require.NoError(t, err)
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/features", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"features": {"device-login": false}}`, w.Body.String())
+	})
 
-	err = os.Setenv(opsKMSURLEnvKey, "localhost")
-	require.NoError(t, err)
+	t.Run("error if the feature flags are invalid", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "features.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte("device-login:\n  percentage: 200\n"), 0600))
 
-	err = os.Setenv(keyEDVURLEnvKey, "localhost")
-	require.NoError(t, err)
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+featureFlagsFlagName, file))
 
-	err = os.Setenv(hubAuthURLEnvKey, "localhost")
-	require.NoError(t, err)
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init feature flags")
+	})
 
-	err = startCmd.Execute()
+	t.Run("error if the refresh interval is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+featureFlagsFlagName, "features.yaml",
+			"--"+featureFlagsIntervalFlagName, "soon",
+		))
 
-	require.NoError(t, err)
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), featureFlagsIntervalFlagName)
+	})
 }
 
-func TestStartCmdWithBlankEnvVar(t *testing.T) {
-	t.Run("test blank host env var", func(t *testing.T) {
+func TestStartCmdWithFaultInjection(t *testing.T) {
+	t.Run("injects faults into the requests to the key servers", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "faults.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte("rules:\n  - error-rate: 0.1\n"), 0600))
+
 		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+faultInjectionFlagName, file))
+		require.NoError(t, startCmd.Execute())
 
-		err := os.Setenv(hostURLEnvKey, "")
+		config, err := getFaultInjectionConfig(startCmd)
 		require.NoError(t, err)
+		require.Len(t, config.Rules, 1)
+	})
 
-		err = os.Setenv(tlsCertFileEnvKey, "cert")
-		require.NoError(t, err)
+	t.Run("error if the rules are invalid", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "faults.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte("rules:\n  - reset-rate: 2\n"), 0600))
 
-		err = os.Setenv(tlsKeyFileEnvKey, "key")
-		require.NoError(t, err)
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+faultInjectionFlagName, file))
 
-		err = startCmd.Execute()
+		err := startCmd.Execute()
 		require.Error(t, err)
-		require.Equal(t, "HTTP_SERVER_HOST_URL value is empty", err.Error())
+		require.Contains(t, err.Error(), "invalid fault injection rule 0")
 	})
 }
 
-func TestHealthCheckHandler(t *testing.T) {
-	result := httptest.NewRecorder()
-	healthCheckHandler(result, nil)
-	require.Equal(t, http.StatusOK, result.Code)
-}
-
-func checkFlagPropertiesCorrect(t *testing.T, cmd *cobra.Command, flagName, flagShorthand, flagUsage string) {
-	flag := cmd.Flag(flagName)
+func TestStartCmdWithOnboardingTemplate(t *testing.T) {
+	t.Run("onboards the users with the templates", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "onboarding.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte(
+			"tenants:\n  acme:\n    resources: [{name: authz-keystore}]\n"), 0600))
 
-	require.NotNil(t, flag)
-	require.Equal(t, flagName, flag.Name)
-	require.Equal(t, flagShorthand, flag.Shorthand)
-	require.Equal(t, flagUsage, flag.Usage)
-	require.Equal(t, "", flag.Value.String())
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+onboardingTemplateFlagName, file))
+		require.NoError(t, startCmd.Execute())
 
-	flagAnnotations := flag.Annotations
-	require.Nil(t, flagAnnotations)
-}
+		templates, err := getOnboardingTemplates(startCmd)
+		require.NoError(t, err)
+		require.Nil(t, templates.Default)
+		require.Len(t, templates.Tenants["acme"].Resources, 1)
+	})
 
-func mockOIDCProvider(t *testing.T) string {
-	h := &testOIDCProvider{}
-	srv := httptest.NewServer(h)
-	h.baseURL = srv.URL
+	t.Run("error if a template is invalid", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "onboarding.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte("default:\n  resources: [{name: ops-vault}]\n"), 0600))
 
-	t.Cleanup(srv.Close)
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+onboardingTemplateFlagName, file))
 
-	return srv.URL
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid default onboarding template")
+	})
 }
 
-type oidcConfigJSON struct {
-	Issuer      string   `json:"issuer"`
-	AuthURL     string   `json:"authorization_endpoint"`
-	TokenURL    string   `json:"token_endpoint"`
-	JWKSURL     string   `json:"jwks_uri"`
-	UserInfoURL string   `json:"userinfo_endpoint"`
-	Algorithms  []string `json:"id_token_signing_alg_values_supported"`
-}
+func TestStartCmdWithDatabase(t *testing.T) {
+	t.Run("keeps the records in memory by default", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+databaseTypeFlagName, databaseTypeMem))
+		require.NoError(t, startCmd.Execute())
+	})
 
-type testOIDCProvider struct {
-	baseURL string
-}
+	t.Run("error if the database type is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+databaseTypeFlagName, "leveldb"))
 
-func (t *testOIDCProvider) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
-	response, err := json.Marshal(&oidcConfigJSON{
-		Issuer:      t.baseURL,
-		AuthURL:     fmt.Sprintf("%s/oauth2/auth", t.baseURL),
-		TokenURL:    fmt.Sprintf("%s/oauth2/token", t.baseURL),
-		JWKSURL:     fmt.Sprintf("%s/oauth2/certs", t.baseURL),
-		UserInfoURL: fmt.Sprintf("%s/oauth2/userinfo", t.baseURL),
-		Algorithms:  []string{"RS256"},
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid database-type value 'leveldb'")
 	})
-	if err != nil {
-		panic(err)
-	}
-
-	_, err = w.Write(response)
-	if err != nil {
-		panic(err)
-	}
-}
 
-func cert(t *testing.T) string {
-	file, err := ioutil.TempFile("", "*.pem")
-	require.NoError(t, err)
+	t.Run("error if the database url is missing", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+databaseTypeFlagName, databaseTypeCouchDB))
 
-	t.Cleanup(func() {
-		fileErr := file.Close()
-		require.NoError(t, fileErr)
-		fileErr = os.Remove(file.Name())
-		require.NoError(t, fileErr)
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "database-url is required with database-type couchdb")
 	})
 
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			Organization: []string{"Test"},
-		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(time.Hour),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-	}
+	t.Run("error if the database is unreachable", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+databaseTypeFlagName, databaseTypeMySQL,
+			"--"+databaseURLFlagName, "user:password@tcp(127.0.0.1:1)/",
+		))
 
-	secret, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	require.NoError(t, err)
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to connect to mysql")
+	})
+}
 
-	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &secret.PublicKey, secret)
-	require.NoError(t, err)
+func TestStartCmdWithMaintenance(t *testing.T) {
+	t.Run("serves a custom page under maintenance", func(t *testing.T) {
+		page := filepath.Join(t.TempDir(), "maintenance.html")
+		require.NoError(t, ioutil.WriteFile(page, []byte("<p>{{.Message}}</p>"), 0o600))
 
-	err = pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: der})
-	require.NoError(t, err)
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+maintenanceBlockSessionsFlagName, "true",
+			"--"+maintenanceRetryAfterFlagName, "1m",
+			"--"+maintenancePageFlagName, page,
+		))
+		require.NoError(t, startCmd.Execute())
+	})
 
-	return file.Name()
-}
+	t.Run("error if maintenance-block-sessions is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+maintenanceBlockSessionsFlagName, "sometimes"))
 
-func key(t *testing.T) string {
-	t.Helper()
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid maintenance-block-sessions value 'sometimes'")
+	})
 
-	key := make([]byte, 32)
+	t.Run("error if maintenance-retry-after is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+maintenanceRetryAfterFlagName, "-1m"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), maintenanceRetryAfterFlagName)
+	})
+
+	t.Run("error if maintenance-page cannot be read", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+maintenancePageFlagName, "/nonexistent/maintenance.html"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read maintenance-page")
+	})
+
+	t.Run("error if maintenance-page is not a template", func(t *testing.T) {
+		page := filepath.Join(t.TempDir(), "maintenance.html")
+		require.NoError(t, ioutil.WriteFile(page, []byte("<p>{{.Message</p>"), 0o600))
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+maintenancePageFlagName, page))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid maintenance page")
+	})
+}
+
+func TestStartCmdWithStartupValidation(t *testing.T) {
+	t.Run("starts with an invalid configuration unless validation fails the startup", func(t *testing.T) {
+		for _, mode := range []string{startupValidationOff, startupValidationWarn} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+startupValidationFlagName, mode))
+			require.NoError(t, startCmd.Execute())
+		}
+	})
+
+	t.Run("error if the configuration is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+startupValidationFlagName, startupValidationFail))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid configuration: oidc client:")
+		require.Contains(t, err.Error(), "authz kms: unreachable")
+	})
+
+	t.Run("error if the mode is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+startupValidationFlagName, "strict"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid startup-validation value 'strict'")
+	})
+}
+
+func TestStartCmdWithShareEscrow(t *testing.T) {
+	t.Run("escrows the secret shares", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+shareEscrowsFlagName, "a=https://escrow-a.example.com",
+			"--"+shareEscrowsFlagName, "b=https://escrow-b.example.com",
+			"--"+shareEscrowsFlagName, "c=https://escrow-c.example.com",
+			"--"+shareEscrowTokensFlagName, "a=token",
+			"--"+shareEscrowRetiredFlagName, "c",
+			"--"+shareEscrowThresholdFlagName, "2",
+		))
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("error if the escrows are invalid", func(t *testing.T) {
+		escrowA := []string{"--" + shareEscrowsFlagName, "a=https://escrow-a.example.com"}
+
+		for _, tc := range []struct {
+			args []string
+			err  string
+		}{
+			{args: []string{"--" + shareEscrowsFlagName, "a"}, err: "invalid share-escrows value 'a': must be id=url"},
+			{args: []string{"--" + shareEscrowsFlagName, "a=escrow"}, err: "invalid share-escrows value 'a=escrow'"},
+			{args: append(escrowA, escrowA...), err: "duplicate id"},
+			{
+				args: append(escrowA, "--"+shareEscrowTokensFlagName, "b=token"),
+				err:  "invalid share-escrow-tokens value: must be id=token",
+			},
+			{
+				args: append(escrowA, "--"+shareEscrowRetiredFlagName, "b"),
+				err:  "invalid share-escrow-retired value 'b'",
+			},
+			{
+				args: append(escrowA, "--"+shareEscrowThresholdFlagName, "0"),
+				err:  "invalid share-escrow-threshold value '0'",
+			},
+			{
+				args: append(escrowA, "--"+shareEscrowThresholdFlagName, "2"),
+				err:  "failed to init share escrow: invalid threshold 2",
+			},
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), tc.args...))
+
+			err := startCmd.Execute()
+			require.Error(t, err, tc.err)
+			require.Contains(t, err.Error(), tc.err)
+		}
+	})
+}
+
+func TestStartCmdWithStatusListTTL(t *testing.T) {
+	t.Run("caches the status lists", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+statusListTTLFlagName, "10m"))
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("error if the ttl is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+statusListTTLFlagName, "-1m"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid status-list-ttl value '-1m'")
+	})
+}
+
+func TestStartCmdWithTrustedIssuers(t *testing.T) {
+	t.Run("refreshes the keys of the provider and of the issuer it migrates from", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+adminTokenFlagName, "token",
+			"--"+oidcJWKSURLsFlagName, mockOIDCProvider(t)+"/next",
+			"--"+oidcJWKSRefreshIntervalFlagName, "5m",
+			"--"+oidcJWKSMaxStaleFlagName, "48h",
+			"--"+oidcTrustedIssuerFlagName, mockOIDCProvider(t),
+		))
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("with the keys of the trusted issuer", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+oidcTrustedIssuerFlagName, "https://old.example.com",
+			"--"+oidcTrustedIssuerJWKSURLsFlagName, mockOIDCProvider(t)+"/old",
+		))
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("error if the trusted issuer cannot be discovered", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+dependencyMaxRetriesFlagName, "1",
+			"--"+oidcTrustedIssuerFlagName, server.URL,
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to discover trusted issuer")
+	})
+
+	t.Run("error if the keys of the trusted issuer are set without issuer", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+oidcTrustedIssuerJWKSURLsFlagName, "https://old.example.com/jwks"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "oidc-trusted-issuer-jwks-urls requires oidc-trusted-issuer")
+	})
+
+	t.Run("error if the durations are invalid", func(t *testing.T) {
+		for _, flag := range []string{oidcJWKSRefreshIntervalFlagName, oidcJWKSMaxStaleFlagName} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+flag, "-1m"))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '-1m'")
+		}
+	})
+}
+
+func TestStartCmdWithAgent(t *testing.T) {
+	t.Run("embeds the aries agent", func(t *testing.T) {
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", "https://kms.example.com/kms/keystores/123")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer kms.Close()
+
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+opsKMSURLFlagName, kms.URL,
+			"--"+agentDIDCommURLFlagName, "https://agent.example.com/didcomm",
+		))
+
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("configures the oidc4vci client", func(t *testing.T) {
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", "https://kms.example.com/kms/keystores/123")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer kms.Close()
+
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+opsKMSURLFlagName, kms.URL,
+			"--"+agentDIDCommURLFlagName, "https://agent.example.com/didcomm",
+			"--"+oidc4vciClientIDFlagName, "wallet",
+			"--"+oidc4vciRedirectURLFlagName, "https://wallet.example.com/agent/oidc4vci/callback",
+		))
+
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("configures the onboarding workers", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t), "--"+onboardingWorkersFlagName, "2"))
+
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("error if onboarding workers is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t), "--"+onboardingWorkersFlagName, "0"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid onboardingWorkers value '0'")
+	})
+
+	t.Run("configures the onboarding step timeout", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t), "--"+onboardingStepTimeoutFlagName, "1m"))
+
+		require.NoError(t, startCmd.Execute())
+
+		timeout, err := getOnboardingStepTimeout(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, timeout)
+	})
+
+	t.Run("error if onboarding step timeout is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t), "--"+onboardingStepTimeoutFlagName, "0s"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid onboarding-step-timeout value '0s'")
+	})
+
+	t.Run("configures the onboarding lock TTL", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t), "--"+onboardingLockTTLFlagName, "2m"))
+
+		require.NoError(t, startCmd.Execute())
+
+		ttl, err := getOnboardingLockTTL(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, 2*time.Minute, ttl)
+
+		ttl, err = getOnboardingLockTTL(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Zero(t, ttl)
+	})
+
+	t.Run("error if onboarding lock TTL is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t), "--"+onboardingLockTTLFlagName, "-1m"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid onboarding-lock-ttl value '-1m'")
+	})
+
+	t.Run("error if agent keystore cannot be created", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+opsKMSURLFlagName, "http://127.0.0.1:0",
+			"--"+agentDIDCommURLFlagName, "https://agent.example.com/didcomm",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to add agent handlers")
+	})
+}
+
+func TestStartCmdWithCORS(t *testing.T) {
+	preflight := func(t *testing.T, handler http.Handler, origin, method string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodOptions, "/oidc/login", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		return w
+	}
+
+	t.Run("allows the agent UI by default", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(validArgs(t))
+		require.NoError(t, startCmd.Execute())
+
+		w := preflight(t, srv.Handler, "ui", http.MethodDelete)
+		require.Equal(t, "ui", w.Header().Get("Access-Control-Allow-Origin"))
+		require.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		require.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+		w = preflight(t, srv.Handler, "https://other.example.com", http.MethodGet)
+		require.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("allows the configured origins and methods", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+corsAllowedOriginsFlagName, "https://spa.example.com",
+			"--"+corsAllowedOriginsFlagName, "https://*.wallet.example.com",
+			"--"+corsAllowedMethodsFlagName, http.MethodGet,
+			"--"+corsAllowCredentialsFlagName, "false",
+			"--"+corsMaxAgeFlagName, "60",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		w := preflight(t, srv.Handler, "https://spa.example.com", http.MethodGet)
+		require.Equal(t, "https://spa.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		require.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+		require.Equal(t, "60", w.Header().Get("Access-Control-Max-Age"))
+
+		w = preflight(t, srv.Handler, "https://eu.wallet.example.com", http.MethodGet)
+		require.Equal(t, "https://eu.wallet.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+		w = preflight(t, srv.Handler, "https://spa.example.com", http.MethodPost)
+		require.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+		w = preflight(t, srv.Handler, "ui", http.MethodGet)
+		require.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("error if allow credentials is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+corsAllowCredentialsFlagName, "maybe"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid corsAllowCredentials value 'maybe'")
+	})
+
+	t.Run("error if max age is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+corsMaxAgeFlagName, "-1"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid corsMaxAge value '-1'")
+	})
+}
+
+func TestStartCmdWithClientCertificate(t *testing.T) {
+	t.Run("configures mutual TLS", func(t *testing.T) {
+		certFile, keyFile := clientKeyPair(t)
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+tlsClientCertFileFlagName, certFile,
+			"--"+tlsClientKeyFileFlagName, keyFile,
+		))
+
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("error if the key is missing", func(t *testing.T) {
+		certFile, _ := clientKeyPair(t)
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+tlsClientCertFileFlagName, certFile))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "both tls-client-cert-file and tls-client-key-file must be set")
+	})
+
+	t.Run("error if the certificate cannot be loaded", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+tlsClientCertFileFlagName, cert(t),
+			"--"+tlsClientKeyFileFlagName, key(t),
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure tls client certificate")
+	})
+}
+
+func TestStartCmdWithBearerTokens(t *testing.T) {
+	t.Run("validates the bearer tokens with the introspection endpoint", func(t *testing.T) {
+		introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte(`{"active": false}`))
+			require.NoError(t, err)
+		}))
+		defer introspection.Close()
+
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+bearerTokenValidationFlagName, introspectionValidation,
+			"--"+bearerIntrospectionURLFlagName, introspection.URL,
+		))
+		require.NoError(t, startCmd.Execute())
+
+		r := httptest.NewRequest(http.MethodGet, "/notifications", nil)
+		r.Header.Set("Authorization", "Bearer expired")
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Body.String(), "token is not active")
+
+		w = httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notifications", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("validates the bearer tokens as jwt", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+bearerTokenValidationFlagName, jwtValidation,
+			"--"+bearerAudienceFlagName, "wallet",
+		))
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("the audience of the jwt bearer tokens defaults to the oidc client id", func(t *testing.T) {
+		config := &httpServerParameters{bearer: &bearerParameters{}, oidc: &oidcParameters{clientID: "agent"}}
+		require.Equal(t, "agent", bearerAudience(config))
+
+		config.bearer.audience = "wallet"
+		require.Equal(t, "wallet", bearerAudience(config))
+	})
+
+	t.Run("error if the introspection endpoint is missing", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+bearerTokenValidationFlagName, introspectionValidation))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "bearer-introspection-url must be set for introspection validation")
+	})
+
+	t.Run("error if the validation is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+bearerTokenValidationFlagName, "opaque"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid bearerTokenValidation value 'opaque'")
+	})
+}
+
+func TestStartCmdWithSecretsProvider(t *testing.T) {
+	certFile, keyFile := clientKeyPair(t)
+
+	certPEM, err := ioutil.ReadFile(filepath.Clean(certFile))
+	require.NoError(t, err)
+
+	keyPEM, err := ioutil.ReadFile(filepath.Clean(keyFile))
+	require.NoError(t, err)
+
+	vault := mockVault(t, map[string]string{
+		"/v1/secret/data/cookies/auth":  base64.StdEncoding.EncodeToString(randomKey(t)),
+		"/v1/secret/data/cookies/enc":   hex.EncodeToString(randomKey(t)[:16]),
+		"/v1/secret/data/oidc":          uuid.New().String(),
+		"/v1/secret/data/tls/client":    string(certPEM),
+		"/v1/secret/data/tls/clientkey": string(keyPEM),
+	})
+
+	vaultArgs := func(t *testing.T) []string {
+		return append(validArgs(t),
+			"--"+secretsProviderFlagName, vaultSecretsProvider,
+			"--"+vaultAddressFlagName, vault,
+			"--"+vaultTokenFlagName, "token",
+		)
+	}
+
+	t.Run("reads the secrets from vault", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(vaultArgs(t),
+			"--"+secretsRefreshIntervalFlagName, "1m",
+			"--"+cookieAuthKeySecretFlagName, "cookies/auth",
+			"--"+cookieEncKeySecretFlagName, "cookies/enc",
+			"--"+oidcClientSecretSecretFlagName, "oidc",
+			"--"+tlsClientCertSecretFlagName, "tls/client",
+			"--"+tlsClientKeySecretFlagName, "tls/clientkey",
+		))
+
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("reads the secrets from aws secrets manager", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+secretsProviderFlagName, awsSecretsProvider,
+			"--"+awsRegionFlagName, "us-east-1",
+			"--"+awsAccessKeyIDFlagName, "id",
+			"--"+awsSecretAccessKeyFlagName, "secret",
+		))
+
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("error if the secret cannot be fetched", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(vaultArgs(t), "--"+oidcClientSecretSecretFlagName, "missing"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure OIDC client secret")
+		require.Contains(t, err.Error(), "secret not found")
+	})
+
+	t.Run("error if the cookie key is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(vaultArgs(t),
+			"--"+cookieAuthKeySecretFlagName, "oidc",
+			"--"+cookieEncKeySecretFlagName, "cookies/enc",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "secret oidc: need key of 32 bytes")
+	})
+
+	t.Run("error if only one cookie key is named", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(vaultArgs(t), "--"+cookieAuthKeySecretFlagName, "cookies/auth"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "both secret-cookie-auth-key and secret-cookie-enc-key must be set")
+	})
+
+	t.Run("error if the client certificate is also read from a file", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(vaultArgs(t),
+			"--"+tlsClientCertFileFlagName, certFile,
+			"--"+tlsClientKeyFileFlagName, keyFile,
+			"--"+tlsClientCertSecretFlagName, "tls/client",
+			"--"+tlsClientKeySecretFlagName, "tls/clientkey",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "secret-tls-client-cert cannot be set along with tls-client-cert-file")
+	})
+
+	t.Run("error if the vault token is missing", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+secretsProviderFlagName, vaultSecretsProvider,
+			"--"+vaultAddressFlagName, vault,
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure vault token")
+	})
+
+	t.Run("error if the refresh interval is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(vaultArgs(t), "--"+secretsRefreshIntervalFlagName, "-1m"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid secrets-refresh-interval value '-1m'")
+	})
+
+	t.Run("error if the provider is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+secretsProviderFlagName, "keychain"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid secrets-provider value 'keychain'")
+	})
+}
+
+func TestStartCmdWithConfigFile(t *testing.T) {
+	t.Run("reads the options from a yaml file", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs([]string{"--" + configFileFlagName, writeConfigFile(t, "yaml", validArgs(t))})
+
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("reads the options from a json file", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs([]string{"--" + configFileFlagName, writeConfigFile(t, "json", validArgs(t))})
+
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("command line and environment take precedence over the file", func(t *testing.T) {
+		file := writeConfigFile(t, "yaml", append(validArgs(t), "--"+agentLogLevelFlagName, "INVALID"))
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs([]string{"--" + configFileFlagName, file, "--" + agentLogLevelFlagName, "DEBUG"})
+		require.NoError(t, startCmd.Execute())
+
+		require.NoError(t, os.Setenv(agentLogLevelEnvKey, "INFO"))
+
+		t.Cleanup(func() {
+			require.NoError(t, os.Unsetenv(agentLogLevelEnvKey))
+		})
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs([]string{"--" + configFileFlagName, file})
+		require.NoError(t, startCmd.Execute())
+	})
+
+	t.Run("reloads the CORS allowed origins on SIGHUP", func(t *testing.T) {
+		args := append(validArgs(t), "--"+corsAllowedOriginsFlagName, "http://wallet.example.com")
+		file := writeConfigFile(t, "yaml", args)
+
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs([]string{"--" + configFileFlagName, file})
+		require.NoError(t, startCmd.Execute())
+
+		preflight := func(origin string) string {
+			r := httptest.NewRequest(http.MethodOptions, "/notifications", nil)
+			r.Header.Set("Origin", origin)
+			r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, r)
+
+			return w.Header().Get("Access-Control-Allow-Origin")
+		}
+
+		require.Equal(t, "http://wallet.example.com", preflight("http://wallet.example.com"))
+		require.Empty(t, preflight("http://new.example.com"))
+
+		writeConfigFile(t, "yaml", append(args, "--"+corsAllowedOriginsFlagName, "http://new.example.com"), file)
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+		require.Eventually(t, func() bool {
+			return preflight("http://new.example.com") == "http://new.example.com"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("error if the file sets an unknown option", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs([]string{"--" + configFileFlagName, writeConfigFile(t, "yaml", []string{"--unknown", "value"})})
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown option unknown in config file")
+	})
+
+	t.Run("error if the file cannot be parsed", func(t *testing.T) {
+		file := writeConfigFile(t, "yaml", nil)
+		require.NoError(t, ioutil.WriteFile(file, []byte("host-url: [localhost"), 0600))
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs([]string{"--" + configFileFlagName, file})
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse config file")
+	})
+
+	t.Run("error if an option holds a map", func(t *testing.T) {
+		file := writeConfigFile(t, "yaml", nil)
+		require.NoError(t, ioutil.WriteFile(file, []byte("host-url:\n  host: localhost"), 0600))
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs([]string{"--" + configFileFlagName, file})
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "option host-url in config file")
+	})
+
+	t.Run("error if the file is missing", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs([]string{"--" + configFileFlagName, "missing.yaml"})
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read config file")
+	})
+}
+
+func TestStartCmdWithInvalidURLs(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
+	startCmd.SetArgs(append(validArgs(t),
+		"--"+authzKMSURLFlagName, "kms",
+		"--"+hubAuthURLFlagName, "/hub",
+	))
+
+	err := startCmd.Execute()
+	require.Error(t, err)
+	require.Contains(t, err.Error(),
+		"invalid configuration: authz-kms-url 'kms' is not an absolute URL; hub-auth-url '/hub' is not an absolute URL")
+}
+
+func TestStartCmdWithProxy(t *testing.T) {
+	var proxied int32
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+
+		r.RequestURI = ""
+
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		defer func() {
+			require.NoError(t, resp.Body.Close())
+		}()
+
+		w.WriteHeader(resp.StatusCode)
+		_, err = io.Copy(w, resp.Body)
+		require.NoError(t, err)
+	}))
+	defer proxyServer.Close()
+
+	t.Run("discovers the OIDC provider through the proxy", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+proxyURLFlagName, proxyServer.URL,
+			"--"+proxyUsernameFlagName, "agent",
+			"--"+proxyPasswordFlagName, "secret",
+		))
+
+		require.NoError(t, startCmd.Execute())
+		require.NotZero(t, atomic.LoadInt32(&proxied))
+	})
+
+	t.Run("connects directly to the no-proxy destinations", func(t *testing.T) {
+		atomic.StoreInt32(&proxied, 0)
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+proxyURLFlagName, proxyServer.URL,
+			// the provider, and the key servers validated at startup
+			"--"+noProxyFlagName, "127.0.0.1",
+			"--"+noProxyFlagName, "localhost",
+		))
+
+		require.NoError(t, startCmd.Execute())
+		require.Zero(t, atomic.LoadInt32(&proxied))
+	})
+
+	t.Run("error if the proxy URL is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+proxyURLFlagName, "proxy"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure proxy: invalid proxy URL 'proxy'")
+	})
+}
+
+func TestStartCmdWithUserInfoCache(t *testing.T) {
+	t.Run("caches the user info for the configured TTL", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+userInfoCacheTTLFlagName, "5m"))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getUserInfoCacheConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, 5*time.Minute, config.TTL)
+	})
+
+	t.Run("does not cache the user info by default", func(t *testing.T) {
+		config, err := getUserInfoCacheConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if the TTL is invalid", func(t *testing.T) {
+		for _, ttl := range []string{"forever", "-1m"} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+userInfoCacheTTLFlagName, ttl))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid userinfo-cache-ttl value '"+ttl+"'")
+		}
+	})
+}
+
+func TestStartCmdWithKeystoreCache(t *testing.T) {
+	t.Run("caches the keystore metadata for the configured TTL", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+keystoreCacheTTLFlagName, "10m"))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getKeystoreCacheConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, 10*time.Minute, config.TTL)
+	})
+
+	t.Run("does not cache the keystore metadata by default", func(t *testing.T) {
+		config, err := getKeystoreCacheConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if the TTL is invalid", func(t *testing.T) {
+		for _, ttl := range []string{"forever", "0s"} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+keystoreCacheTTLFlagName, ttl))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid keystore-cache-ttl value '"+ttl+"'")
+		}
+	})
+}
+
+func TestStartCmdWithSIOP(t *testing.T) {
+	t.Run("lets the users log in with a did", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+siopRedirectURLFlagName, "https://agent.example.com/siop/callback",
+			"--"+siopDIDMethodsFlagName, "key",
+			"--"+siopDIDMethodsFlagName, "trustbloc",
+			"--"+siopResolverURLFlagName, "https://resolver.example.com/1.0/identifiers",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getSIOPParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, []string{"key", "trustbloc"}, params.didMethods)
+
+		config, err := newSIOPConfig(params, nil)
+		require.NoError(t, err)
+		require.NotNil(t, config.RelyingParty)
+		require.Contains(t, config.RelyingParty.FormatRequest("state", "nonce"), "did%3Atrustbloc")
+	})
+
+	t.Run("supports did:key by default", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{
+			"--" + siopRedirectURLFlagName, "https://agent.example.com/siop/callback",
+		}))
+
+		params, err := getSIOPParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, []string{"key"}, params.didMethods)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		params, err := getSIOPParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("error if the config is invalid", func(t *testing.T) {
+		tests := []struct {
+			args []string
+			err  string
+		}{{
+			args: []string{"--" + siopRedirectURLFlagName, "callback"},
+			err:  "invalid siop-redirect-url value",
+		}, {
+			args: []string{
+				"--" + siopRedirectURLFlagName, "https://agent.example.com/siop/callback",
+				"--" + siopResolverURLFlagName, "resolver",
+			},
+			err: "invalid siop-resolver-url value",
+		}, {
+			args: []string{
+				"--" + siopRedirectURLFlagName, "https://agent.example.com/siop/callback",
+				"--" + siopDIDMethodsFlagName, "trustbloc",
+			},
+			err: "did method trustbloc of siop-did-methods requires siop-resolver-url",
+		}}
+
+		for _, test := range tests {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), test.args...))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.err)
+		}
+	})
+}
+
+func TestStartCmdWithProvisioningPool(t *testing.T) {
+	t.Run("pre-provisions the configured number of sets", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+provisioningPoolSizeFlagName, "5",
+			"--"+provisioningPoolIntervalFlagName, "1h",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getProvisioningPoolConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, 5, config.Size)
+		require.Equal(t, time.Hour, config.Interval)
+	})
+
+	t.Run("provisions new users on demand by default", func(t *testing.T) {
+		config, err := getProvisioningPoolConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{"--" + provisioningPoolSizeFlagName, "0"}))
+
+		config, err = getProvisioningPoolConfig(startCmd)
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if the pool size is invalid", func(t *testing.T) {
+		for _, size := range []string{"many", "-1"} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+provisioningPoolSizeFlagName, size))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid provisioning-pool-size value '"+size+"'")
+		}
+	})
+
+	t.Run("error if the refill interval is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+provisioningPoolSizeFlagName, "1",
+			"--"+provisioningPoolIntervalFlagName, "often",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid provisioning-pool-interval value 'often'")
+	})
+}
+
+func TestStartCmdWithJanitor(t *testing.T) {
+	t.Run("cleans up at the configured interval", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+janitorIntervalFlagName, "10m",
+			"--"+janitorBatchSizeFlagName, "50",
+			"--"+janitorTransientTTLFlagName, "12h",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getJanitorConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &oidc.JanitorConfig{
+			Interval:     10 * time.Minute,
+			BatchSize:    50,
+			TransientTTL: 12 * time.Hour,
+		}, config)
+	})
+
+	t.Run("does not clean up by default", func(t *testing.T) {
+		config, err := getJanitorConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			janitorIntervalFlagName:     "often",
+			janitorBatchSizeFlagName:    "0",
+			janitorTransientTTLFlagName: "-1h",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t),
+				"--"+janitorIntervalFlagName, "10m",
+				"--"+flag, value,
+			))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+	})
+}
+
+func TestStartCmdWithDIDResolver(t *testing.T) {
+	t.Run("resolves the dids with the drivers and the universal resolver", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+didResolverURLFlagName, "https://resolver.example.com/1.0/identifiers",
+			"--"+didResolverDriversFlagName, "orb=https://orb.example.com/sidetree/v1/identifiers",
+			"--"+didResolverCacheSizeFlagName, "0",
+			"--"+didResolverCacheTTLFlagName, "1m",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getDIDResolverParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &didResolverParameters{
+			universalResolverURL: "https://resolver.example.com/1.0/identifiers",
+			drivers:              map[string]string{"orb": "https://orb.example.com/sidetree/v1/identifiers"},
+			cacheSize:            -1,
+			cacheTTL:             time.Minute,
+		}, params)
+
+		resolver, err := newDIDResolver(&httpServerParameters{didResolver: params, tls: &tlsParameters{}})
+		require.NoError(t, err)
+
+		doc, err := resolver.Resolve("did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK")
+		require.NoError(t, err)
+		require.Equal(t, "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK", doc.ID)
+	})
+
+	t.Run("resolves did:key and did:web by default", func(t *testing.T) {
+		params, err := getDIDResolverParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Equal(t, &didResolverParameters{drivers: map[string]string{}}, params)
+
+		resolver, err := newDIDResolver(&httpServerParameters{didResolver: params, tls: &tlsParameters{}})
+		require.NoError(t, err)
+
+		_, err = resolver.Resolve("did:orb:123")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "did method not supported: orb")
+	})
+
+	t.Run("error if the config is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			didResolverURLFlagName:       "resolver",
+			didResolverDriversFlagName:   "orb",
+			didResolverCacheSizeFlagName: "-1",
+			didResolverCacheTTLFlagName:  "0s",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+flag, value))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+didResolverDriversFlagName, "orb=orb"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid did-resolver-drivers value 'orb=orb'")
+	})
+}
+
+func TestStartCmdWithQuotas(t *testing.T) {
+	t.Run("bounds the resources of the users", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+quotaDocumentsFlagName, "1000",
+			"--"+quotaKeysFlagName, "20",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getQuotaConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &oidc.QuotaConfig{Documents: 1000, Keys: 20}, config)
+	})
+
+	t.Run("leaves the other resource unlimited", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+quotaKeysFlagName, "20"))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getQuotaConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &oidc.QuotaConfig{Keys: 20}, config)
+	})
+
+	t.Run("unlimited by default", func(t *testing.T) {
+		config, err := getQuotaConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if a quota is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			quotaDocumentsFlagName: "many",
+			quotaKeysFlagName:      "0",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+flag, value))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+	})
+}
+
+func TestStartCmdWithBootstrapSigning(t *testing.T) {
+	t.Run("signs the bootstrap data", func(t *testing.T) {
+		kms := mockAgentKMS()
+		defer kms.Close()
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+opsKMSURLFlagName, kms.URL,
+			"--"+bootstrapSigningFlagName, "true",
+			"--"+bootstrapSignatureRequiredFlagName, "true",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getBootstrapSigningParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &bootstrapSigningParameters{require: true}, params)
+	})
+
+	t.Run("does not sign by default", func(t *testing.T) {
+		params, err := getBootstrapSigningParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("creates the signer of the agent keystore", func(t *testing.T) {
+		kms := mockAgentKMS()
+		defer kms.Close()
+
+		config := &httpServerParameters{
+			tls:              &tlsParameters{},
+			keyServer:        &keyServerParameters{opsKMSURL: kms.URL},
+			bootstrapSigning: &bootstrapSigningParameters{require: true},
+		}
+
+		signing, err := bootstrapSigning(config, memstore.NewProvider())
+		require.NoError(t, err)
+		require.NotNil(t, signing.Signer)
+		require.True(t, signing.Require)
+
+		config.keyServer.opsKMSURL = "http://localhost:-1"
+
+		_, err = bootstrapSigning(config, memstore.NewProvider())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init bootstrap data signer")
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			bootstrapSigningFlagName:           "sometimes",
+			bootstrapSignatureRequiredFlagName: "sometimes",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+bootstrapSigningFlagName, "true", "--"+flag, value))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+	})
+
+	t.Run("error if the signature is required but the data is not signed", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+bootstrapSignatureRequiredFlagName, "true"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "bootstrap-signature-required requires bootstrap-signing")
+	})
+}
+
+// mockAgentKMS creates the agent keystore and its signing key.
+func mockAgentKMS() *httptest.Server {
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		location := server.URL + "/kms/keystores/agent"
+		if strings.HasSuffix(r.URL.Path, "/keys") {
+			location += "/keys/signing"
+		}
+
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	return server
+}
+
+func TestStartCmdWithHealth(t *testing.T) {
+	t.Run("probes the dependencies at the configured interval", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+dependencyCheckIntervalFlagName, "30s",
+			"--"+dependencyCheckTimeoutFlagName, "2s",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getHealthParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &healthParameters{interval: 30 * time.Second, timeout: 2 * time.Second}, params)
+	})
+
+	t.Run("does not probe by default", func(t *testing.T) {
+		params, err := getHealthParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("serves the status of the dependencies", func(t *testing.T) {
+		config := &httpServerParameters{
+			oidc: &oidcParameters{providerURL: mockOIDCProvider(t)},
+			tls:  &tlsParameters{},
+			keys: &keyParameters{},
+			webAuth: &webauthParameters{
+				rpDisplayName: "Foobar Corp.",
+				rpID:          "localhost",
+				rpOrigin:      "http://localhost",
+			},
+			keyServer: &keyServerParameters{
+				authzKMSURL: "http://localhost",
+			},
+			userEDVURL: "http://localhost/encrypted-data-vaults",
+			health:     &healthParameters{interval: time.Hour},
+		}
+
+		router, err := router(config)
+		require.NoError(t, err)
+
+		config.health.watcher.Check()
+		require.True(t, config.health.watcher.Up(oidc.DependencyOIDCProvider))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		status := &oidc.AgentStatus{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), status))
+		require.Contains(t, status.Dependencies, oidc.DependencyKMS)
+		require.Contains(t, status.Dependencies, oidc.DependencyEDV)
+		require.Equal(t, oidc.FeatureAvailable, status.Features.Login)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			dependencyCheckIntervalFlagName: "often",
+			dependencyCheckTimeoutFlagName:  "0s",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t),
+				"--"+dependencyCheckIntervalFlagName, "30s",
+				"--"+flag, value,
+			))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+	})
+}
+
+func TestStartCmdWithLimits(t *testing.T) {
+	t.Run("bounds the requests and the connections", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+limitsMaxBodySizeFlagName, "1048576",
+			"--"+limitsMaxHeaderSizeFlagName, "16384",
+			"--"+limitsReadTimeoutFlagName, "30s",
+			"--"+limitsWriteTimeoutFlagName, "1m",
+			"--"+limitsRouteFlagName, "/oidc/wallet/attachments:max-body-size=1073741824,read-timeout=10m",
+			"--"+limitsReadHeaderTimeoutFlagName, "10s",
+			"--"+limitsIdleTimeoutFlagName, "2m",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getLimitsParams(startCmd)
+		require.NoError(t, err)
+
+		defaults := &limits.Limits{
+			MaxBodyBytes:   1048576,
+			MaxHeaderBytes: 16384,
+			ReadTimeout:    30 * time.Second,
+			WriteTimeout:   time.Minute,
+		}
+		require.Equal(t, defaults, params.routes.Default)
+		require.Equal(t, []*limits.Route{{
+			Prefix: attachmentsRoute,
+			Limits: &limits.Limits{
+				MaxBodyBytes:   1073741824,
+				MaxHeaderBytes: 16384,
+				ReadTimeout:    10 * time.Minute,
+				WriteTimeout:   time.Minute,
+			},
+		}, {
+			// the notification streams outlive the write timeout
+			Prefix: notificationsRoute,
+			Limits: &limits.Limits{MaxBodyBytes: 1048576, MaxHeaderBytes: 16384, ReadTimeout: 30 * time.Second},
+		}}, params.routes.Routes)
+		require.Equal(t, &limits.ServerConfig{
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    16384,
+		}, params.server)
+	})
+
+	t.Run("lifts the body limit of the uploads of attachments", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{"--" + limitsMaxBodySizeFlagName, "1024"}))
+
+		params, err := getLimitsParams(startCmd)
+		require.NoError(t, err)
+		require.Nil(t, params.server)
+		require.Equal(t, []*limits.Route{{Prefix: attachmentsRoute, Limits: &limits.Limits{}}}, params.routes.Routes)
+	})
+
+	t.Run("answers the requests beyond their limits with 413", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{"--" + limitsMaxBodySizeFlagName, "16"}))
+
+		params, err := getLimitsParams(startCmd)
+		require.NoError(t, err)
+
+		router, err := router(&httpServerParameters{
+			oidc: &oidcParameters{providerURL: mockOIDCProvider(t)},
+			tls:  &tlsParameters{},
+			keys: &keyParameters{},
+			webAuth: &webauthParameters{
+				rpDisplayName: "Foobar Corp.",
+				rpID:          "localhost",
+				rpOrigin:      "http://localhost",
+			},
+			keyServer: &keyServerParameters{
+				authzKMSURL: "http://localhost",
+			},
+			limits: params,
+		})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/userinfo",
+			strings.NewReader(strings.Repeat("a", 17))))
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("sets the limits of the server", func(t *testing.T) {
+		srv := &HTTPServer{}
+		srv.SetLimits(&limits.ServerConfig{ReadHeaderTimeout: time.Second})
+		require.Equal(t, time.Second, srv.limits.ReadHeaderTimeout)
+	})
+
+	t.Run("unlimited by default", func(t *testing.T) {
+		params, err := getLimitsParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			limitsMaxBodySizeFlagName:       "1MB",
+			limitsMaxHeaderSizeFlagName:     "-1",
+			limitsReadTimeoutFlagName:       "slow",
+			limitsWriteTimeoutFlagName:      "-1m",
+			limitsRouteFlagName:             "/oidc:timeout=1m",
+			limitsReadHeaderTimeoutFlagName: "0s",
+			limitsIdleTimeoutFlagName:       "idle",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+flag, value))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value", flag)
+		}
+
+		for _, value := range []string{"oidc:read-timeout=1m", "/oidc", "/oidc:read-timeout"} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+limitsRouteFlagName, value))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "must be <path prefix>:<limit>=<value>", value)
+		}
+	})
+}
+
+func TestStartCmdWithSecurityHeaders(t *testing.T) {
+	t.Run("sets the security headers and collects the csp reports", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+adminTokenFlagName, "token",
+			"--"+securityHeadersCSPFlagName, "default-src 'self'",
+			"--"+securityHeadersCSPReportOnlyFlagName, "true",
+			"--"+securityHeadersHSTSFlagName, "max-age=63072000",
+			"--"+securityHeadersContentTypeOptionsFlagName, "nosniff",
+			"--"+securityHeadersReferrerPolicyFlagName, "no-referrer",
+			"--"+securityHeadersFrameAncestorsFlagName, "'none'",
+			"--"+securityHeadersRouteFlagName, "/oidc/wallet/attachments:csp=sandbox",
+			"--"+securityHeadersRouteFlagName, "/oidc/wallet/attachments:csp-report-only=false",
+			"--"+securityHeadersRouteFlagName, "/healthcheck:hsts=",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getSecurityHeadersParams(startCmd)
+		require.NoError(t, err)
+
+		defaults := &headers.Headers{
+			ContentSecurityPolicy:   "default-src 'self'",
+			ReportOnly:              true,
+			ReportURI:               "/csp-report",
+			StrictTransportSecurity: "max-age=63072000",
+			ContentTypeOptions:      "nosniff",
+			ReferrerPolicy:          "no-referrer",
+			FrameAncestors:          "'none'",
+		}
+		require.Equal(t, defaults, params.config.Default)
+
+		attachments := *defaults
+		attachments.ContentSecurityPolicy = "sandbox"
+		attachments.ReportOnly = false
+
+		health := *defaults
+		health.StrictTransportSecurity = ""
+
+		require.Equal(t, []*headers.Route{
+			{Prefix: "/oidc/wallet/attachments", Headers: &attachments},
+			{Prefix: "/healthcheck", Headers: &health},
+		}, params.config.Routes)
+
+		// the unknown routes too
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Equal(t, "default-src 'self'; report-uri /csp-report",
+			w.Header().Get(headers.ContentSecurityPolicyReportOnly))
+		require.Equal(t, "frame-ancestors 'none'", w.Header().Get(headers.ContentSecurityPolicy))
+		require.Equal(t, "nosniff", w.Header().Get(headers.ContentTypeOptions))
+
+		r := httptest.NewRequest(http.MethodPost, "/csp-report",
+			strings.NewReader(`{"csp-report":{"blocked-uri":"inline"}}`))
+		r.Header.Set("Content-Type", "application/csp-report")
+
+		w = httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		r = httptest.NewRequest(http.MethodGet, adminBasePath+"csp-reports", nil)
+		r.Header.Set("Authorization", "Bearer token")
+
+		w = httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"total":1`)
+	})
+
+	t.Run("sets no security header by default", func(t *testing.T) {
+		params, err := getSecurityHeadersParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+securityHeadersCSPReportOnlyFlagName, "maybe"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+securityHeadersCSPReportOnlyFlagName+" value")
+
+		for value, msg := range map[string]string{
+			"oidc:csp=sandbox":         "must be <path prefix>:<header>=<value>",
+			"/oidc":                    "must be <path prefix>:<header>=<value>",
+			"/oidc:csp":                "must be <path prefix>:<header>=<value>",
+			"/oidc:cors=*":             "unknown header cors",
+			"/oidc:csp-report-only=1m": "invalid csp-report-only '1m'",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+securityHeadersRouteFlagName, value))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), msg, value)
+		}
+	})
+}
+
+func TestStartCmdWithStepUp(t *testing.T) {
+	t.Run("serves the step-up endpoint if step-up is required", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+stepUpACRValuesFlagName, "phr",
+			"--"+stepUpACRValuesFlagName, "phrh",
+			"--"+stepUpAMRValuesFlagName, "mfa",
+			"--"+stepUpMaxAgeFlagName, "10m",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getStepUpConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &oidc.StepUpConfig{
+			ACRValues: []string{"phr", "phrh"},
+			AMRValues: []string{"mfa"},
+			MaxAge:    10 * time.Minute,
+		}, config)
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/stepup", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("does not require step-up by default", func(t *testing.T) {
+		config, err := getStepUpConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if the max age is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+stepUpMaxAgeFlagName, "0s"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+stepUpMaxAgeFlagName+" value '0s'")
+	})
+}
+
+func TestStartCmdWithLoginParams(t *testing.T) {
+	t.Run("forwards the allowed login parameters", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+oidcLoginParamsFlagName, oidc.PromptParam,
+			"--"+oidcLoginParamsFlagName, oidc.UILocalesParam,
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getLoginParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, []string{oidc.PromptParam, oidc.UILocalesParam}, params)
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/login?prompt=login", nil))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Contains(t, w.Header().Get("Location"), "prompt=login")
+
+		w = httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/login?login_hint=alice", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("does not forward any parameter by default", func(t *testing.T) {
+		params, err := getLoginParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Empty(t, params)
+	})
+
+	t.Run("error if a parameter is not supported", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+oidcLoginParamsFlagName, "redirect_uri"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+oidcLoginParamsFlagName+" value 'redirect_uri'")
+	})
+}
+
+func TestStartCmdWithAPIVersioning(t *testing.T) {
+	t.Run("serves the legacy routes by default", func(t *testing.T) {
+		config, err := getAPIVersioningConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.True(t, config.Legacy)
+		require.Contains(t, config.Unversioned, healthCheckPath)
+	})
+
+	t.Run("serves the API under its version prefix only", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+apiLegacyRoutesFlagName, "false"))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getAPIVersioningConfig(startCmd)
+		require.NoError(t, err)
+		require.False(t, config.Legacy)
+	})
+
+	t.Run("error if the option is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+apiLegacyRoutesFlagName, "sometimes"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+apiLegacyRoutesFlagName+" value 'sometimes'")
+	})
+}
+
+func TestStartCmdWithLoginLockout(t *testing.T) {
+	t.Run("serves the lockouts to the admins if failed logins are tracked", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+adminTokenFlagName, "token",
+			"--"+lockoutMaxFailuresFlagName, "3",
+			"--"+lockoutWindowFlagName, "30m",
+			"--"+lockoutDurationFlagName, "5m",
+			"--"+lockoutMaxDurationFlagName, "24h",
+			"--"+lockoutTrustForwardedForFlagName, "true",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getLockoutConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &oidc.LockoutConfig{
+			MaxFailures:       3,
+			Window:            30 * time.Minute,
+			Duration:          5 * time.Minute,
+			MaxDuration:       24 * time.Hour,
+			TrustForwardedFor: true,
+		}, config)
+
+		r := httptest.NewRequest(http.MethodGet, adminBasePath+"lockouts", nil)
+		r.Header.Set("Authorization", "Bearer token")
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("does not track failed logins by default", func(t *testing.T) {
+		config, err := getLockoutConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			lockoutMaxFailuresFlagName:       "0",
+			lockoutWindowFlagName:            "long",
+			lockoutDurationFlagName:          "-1m",
+			lockoutMaxDurationFlagName:       "0s",
+			lockoutTrustForwardedForFlagName: "maybe",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t),
+				"--"+lockoutMaxFailuresFlagName, "3",
+				"--"+flag, value,
+			))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+	})
+}
+
+func TestStartCmdWithOIDCShadow(t *testing.T) {
+	t.Run("compares the oidc client with the candidate provider and serves the stats to the admins", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+adminTokenFlagName, "token",
+			"--"+oidcShadowProviderURLFlagName, mockOIDCProvider(t),
+			"--"+oidcShadowClientIDFlagName, uuid.New().String(),
+			"--"+oidcShadowClientSecretFlagName, uuid.New().String(),
+			"--"+oidcShadowIgnoredClaimsFlagName, "groups",
+			"--"+oidcShadowIgnoredClaimsFlagName, "locale",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getOIDCShadowParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, []string{"groups", "locale"}, params.ignoredClaims)
+
+		r := httptest.NewRequest(http.MethodGet, adminBasePath+"oidc/shadow/stats", nil)
+		r.Header.Set("Authorization", "Bearer token")
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stats := &oidc2.ShadowStats{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), stats))
+		require.Zero(t, stats.Comparisons)
+	})
+
+	t.Run("does not compare the oidc client by default", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t), "--"+adminTokenFlagName, "token"))
+		require.NoError(t, startCmd.Execute())
+
+		r := httptest.NewRequest(http.MethodGet, adminBasePath+"oidc/shadow/stats", nil)
+		r.Header.Set("Authorization", "Bearer token")
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("error if the candidate client is not set", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+oidcShadowProviderURLFlagName, mockOIDCProvider(t),
+			"--"+oidcShadowClientSecretFlagName, uuid.New().String(),
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), oidcShadowProviderURLFlagName+" requires "+oidcShadowClientIDFlagName)
+	})
+}
+
+func TestStartCmdWithNetworkPolicy(t *testing.T) {
+	geoIPDatabase := filepath.Join(t.TempDir(), "geoip.csv")
+	require.NoError(t, ioutil.WriteFile(geoIPDatabase, []byte("192.0.2.0/24,CA\n"), 0600))
+
+	t.Run("applies the policies of the public and admin endpoints", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+adminTokenFlagName, "token",
+			"--"+publicAllowCountriesFlagName, "ca",
+			"--"+publicDenyFlagName, "192.0.2.66",
+			"--"+adminAllowFlagName, "10.0.0.0/8",
+			"--"+geoIPDatabaseFlagName, geoIPDatabase,
+			"--"+networkTrustForwardedForFlagName, "true",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getNetworkPolicyParameters(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, []string{"CA"}, params.public.AllowCountries)
+		require.Len(t, params.public.Deny, 1)
+		require.Len(t, params.admin.Allow, 1)
+		require.NotNil(t, params.locator)
+		require.True(t, params.trustForwardedFor)
+
+		for _, test := range []struct {
+			path   string
+			client string
+			status int
+		}{
+			{healthCheckPath, "192.0.2.1", http.StatusOK},
+			{healthCheckPath, "192.0.2.66", http.StatusForbidden},
+			{healthCheckPath, "198.51.100.1", http.StatusForbidden},
+			{adminBasePath + "audit", "10.0.0.1", http.StatusOK},
+			{adminBasePath + "audit", "192.0.2.1", http.StatusForbidden},
+		} {
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			r.Header.Set("X-Forwarded-For", test.client)
+			r.Header.Set("Authorization", "Bearer token")
+
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, r)
+			require.Equal(t, test.status, w.Code, test.path+" from "+test.client)
+		}
+	})
+
+	t.Run("allows all the clients by default", func(t *testing.T) {
+		params, err := getNetworkPolicyParameters(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for _, test := range []struct {
+			args []string
+			msg  string
+		}{
+			{[]string{"--" + publicAllowFlagName, "example.com"}, "invalid " + publicAllowFlagName + " value"},
+			{[]string{"--" + adminDenyFlagName, "10.0.0.0/40"}, "invalid " + adminDenyFlagName + " value"},
+			{
+				[]string{"--" + adminDenyCountriesFlagName, "Canada", "--" + geoIPDatabaseFlagName, geoIPDatabase},
+				"invalid " + adminDenyCountriesFlagName + " value",
+			},
+			{[]string{"--" + publicDenyCountriesFlagName, "US"}, "require the " + geoIPDatabaseFlagName + " option"},
+			{
+				[]string{"--" + publicDenyCountriesFlagName, "US", "--" + geoIPDatabaseFlagName, "missing.csv"},
+				"failed to open GeoIP database",
+			},
+			{
+				[]string{"--" + publicDenyFlagName, "10.0.0.1", "--" + networkTrustForwardedForFlagName, "maybe"},
+				"invalid " + networkTrustForwardedForFlagName + " value 'maybe'",
+			},
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), test.args...))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.msg)
+		}
+	})
+}
+
+func TestStartCmdWithTokenExchange(t *testing.T) {
+	t.Run("exchanges the access tokens for the configured audiences", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+tokenExchangeHubAuthAudienceFlagName, "https://hub-auth.example.com",
+			"--"+tokenExchangeKMSAudienceFlagName, "https://hub-kms.example.com",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		require.Equal(t, &oidc.TokenExchangeConfig{
+			HubAuthAudience: "https://hub-auth.example.com",
+			KMSAudience:     "https://hub-kms.example.com",
+		}, getTokenExchangeConfig(startCmd))
+	})
+
+	t.Run("does not exchange the access tokens by default", func(t *testing.T) {
+		require.Nil(t, getTokenExchangeConfig(GetStartCmd(&mockServer{})))
+	})
+}
+
+func TestStartCmdWithVaultKeyRotation(t *testing.T) {
+	t.Run("serves the rotations to the admins if the keys are rotated", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+adminTokenFlagName, "token",
+			"--"+keyRotationIntervalFlagName, "2160h",
+			"--"+keyRotationBatchSizeFlagName, "50",
+			"--"+keyRotationStoresFlagName, "credentials",
+			"--"+keyRotationStoresFlagName, "metadata",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getKeyRotationConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &oidc.KeyRotationConfig{
+			Interval:  2160 * time.Hour,
+			BatchSize: 50,
+			Stores:    []string{"credentials", "metadata"},
+		}, config)
+
+		r := httptest.NewRequest(http.MethodGet, adminBasePath+"vaults/rotation?sub=unknown", nil)
+		r.Header.Set("Authorization", "Bearer token")
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "was never rotated")
+	})
+
+	t.Run("lets the admins rotate the keys without a schedule", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+keyRotationFlagName, "true"))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getKeyRotationConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &oidc.KeyRotationConfig{Stores: []string{}}, config)
+	})
+
+	t.Run("does not rotate the keys by default", func(t *testing.T) {
+		config, err := getKeyRotationConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			keyRotationFlagName:          "maybe",
+			keyRotationIntervalFlagName:  "0s",
+			keyRotationBatchSizeFlagName: "none",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t),
+				"--"+keyRotationFlagName, "true",
+				"--"+flag, value,
+			))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+	})
+}
+
+func TestStartCmdWithDIDKeyRotation(t *testing.T) {
+	t.Run("rotates the keys of the dids on a schedule", func(t *testing.T) {
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", "https://kms.example.com/kms/keystores/123")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer kms.Close()
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+opsKMSURLFlagName, kms.URL,
+			"--"+agentDIDCommURLFlagName, "https://agent.example.com/didcomm",
+			"--"+didKeyRotationIntervalFlagName, "720h",
+			"--"+didKeyGracePeriodFlagName, "48h",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getDIDKeyRotationConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &agent.KeyRotationConfig{Interval: 720 * time.Hour, GracePeriod: 48 * time.Hour}, config)
+	})
+
+	t.Run("lets the users rotate the keys without a schedule", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+didKeyRotationFlagName, "true"))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getDIDKeyRotationConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &agent.KeyRotationConfig{}, config)
+	})
+
+	t.Run("does not rotate the keys by default", func(t *testing.T) {
+		config, err := getDIDKeyRotationConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			didKeyRotationFlagName:         "maybe",
+			didKeyRotationIntervalFlagName: "0s",
+			didKeyGracePeriodFlagName:      "never",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t),
+				"--"+didKeyRotationFlagName, "true",
+				"--"+flag, value,
+			))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+	})
+}
+
+func TestStartCmdWithLogging(t *testing.T) {
+	t.Run("logs sampled JSON lines", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+logFormatFlagName, logging.FormatJSON,
+			"--"+logSamplingInitialFlagName, "10",
+			"--"+logSamplingThereafterFlagName, "100",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getLoggingConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &logging.Config{
+			Format:   logging.FormatJSON,
+			Sampling: &logging.SamplingConfig{Initial: 10, Thereafter: 100},
+		}, config)
+	})
+
+	t.Run("logs every text line by default", func(t *testing.T) {
+		config, err := getLoggingConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Equal(t, &logging.Config{}, config)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			logFormatFlagName:             "xml",
+			logSamplingInitialFlagName:    "many",
+			logSamplingThereafterFlagName: "-1",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+flag, value))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+	})
+}
+
+func TestStartCmdWithTokenStore(t *testing.T) {
+	t.Run("keeps the tokens in vault", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+tokenStoreFlagName, tokenStoreVault,
+			"--"+tokenVaultURLFlagName, "https://vault.example.com:8200",
+			"--"+tokenVaultTokenFlagName, "token",
+			"--"+tokenVaultMountFlagName, "kv",
+			"--"+tokenVaultPathFlagName, "agent/tokens",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getTokenVaultConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &tokens.VaultConfig{
+			URL:   "https://vault.example.com:8200",
+			Token: "token",
+			Mount: "kv",
+			Path:  "agent/tokens",
+		}, config)
+	})
+
+	t.Run("keeps the tokens in the storage by default", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+tokenStoreFlagName, tokenStoreStorage))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getTokenVaultConfig(startCmd)
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if the token store is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+tokenStoreFlagName, "database"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+tokenStoreFlagName+" value 'database'")
+	})
+
+	t.Run("error if vault is not configured", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+tokenStoreFlagName, tokenStoreVault,
+			"--"+tokenVaultTokenFlagName, "token",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), tokenVaultURLFlagName)
+
+		startCmd = GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+tokenStoreFlagName, tokenStoreVault,
+			"--"+tokenVaultURLFlagName, "vault:8200",
+			"--"+tokenVaultTokenFlagName, "token",
+		))
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init token vault store")
+	})
+}
+
+func TestStartCmdWithUserDirectory(t *testing.T) {
+	t.Run("resolves the profiles from ldap", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+userDirectoryFlagName, userDirectoryLDAP,
+			"--"+userDirectoryURLFlagName, "ldaps://ldap.example.com:636",
+			"--"+userDirectoryTTLFlagName, "30m",
+			"--"+userDirectoryBindDNFlagName, "cn=agent,dc=example,dc=com",
+			"--"+userDirectoryBindPasswordFlagName, "secret",
+			"--"+userDirectoryBaseDNFlagName, "ou=people,dc=example,dc=com",
+			"--"+userDirectoryFilterFlagName, "(mail=%s)",
+			"--"+userDirectoryAttributesFlagName, "name=displayName",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getUserDirectoryParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &userDirectoryParameters{
+			ttl: 30 * time.Minute,
+			ldap: &user.LDAPConfig{
+				URL:          "ldaps://ldap.example.com:636",
+				BindDN:       "cn=agent,dc=example,dc=com",
+				BindPassword: "secret",
+				BaseDN:       "ou=people,dc=example,dc=com",
+				Filter:       "(mail=%s)",
+				Attributes:   map[string]string{"name": "displayName"},
+			},
+		}, params)
+	})
+
+	t.Run("resolves the profiles from scim", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+userDirectoryFlagName, userDirectorySCIM,
+			"--"+userDirectoryURLFlagName, "https://scim.example.com/scim/v2",
+			"--"+userDirectoryTokenFlagName, "token",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getUserDirectoryParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &user.SCIMConfig{
+			URL:        "https://scim.example.com/scim/v2",
+			Token:      "token",
+			Attributes: map[string]string{},
+		}, params.scim)
+	})
+
+	t.Run("resolves no profiles by default", func(t *testing.T) {
+		params, err := getUserDirectoryParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--" + userDirectoryFlagName, "ad"},
+			{"--" + userDirectoryFlagName, userDirectorySCIM},
+			{"--" + userDirectoryFlagName, userDirectoryLDAP, "--" + userDirectoryURLFlagName, "ldap://ldap"},
+			{
+				"--" + userDirectoryFlagName, userDirectorySCIM, "--" + userDirectoryURLFlagName, "https://scim",
+				"--" + userDirectoryTTLFlagName, "-1m",
+			},
+			{
+				"--" + userDirectoryFlagName, userDirectorySCIM, "--" + userDirectoryURLFlagName, "https://scim",
+				"--" + userDirectoryAttributesFlagName, "=title",
+			},
+			{
+				"--" + userDirectoryFlagName, userDirectoryLDAP, "--" + userDirectoryURLFlagName, "https://ldap",
+				"--" + userDirectoryBaseDNFlagName, "dc=example",
+			},
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), args...))
+
+			require.Error(t, startCmd.Execute(), args)
+		}
+	})
+}
+
+func TestStartCmdWithSessionBinding(t *testing.T) {
+	t.Run("binds the sessions to the clients", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+sessionBindingFlagName, bindUserAgent,
+			"--"+sessionBindingFlagName, bindNetwork,
+			"--"+sessionBindingFlagName, bindClientCertificate,
+			"--"+sessionBindingIPv6PrefixFlagName, "56",
+			"--"+sessionBindingModeFlagName, bindingModeReport,
+			"--"+networkTrustForwardedForFlagName, "true",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getSessionBindingConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &binding.Config{
+			UserAgent:         true,
+			IPv4PrefixLength:  24,
+			IPv6PrefixLength:  56,
+			ClientCertificate: true,
+			TrustForwardedFor: true,
+			ReportOnly:        true,
+		}, config)
+	})
+
+	t.Run("enforces the binding by default", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+sessionBindingFlagName, bindUserAgent))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getSessionBindingConfig(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, &binding.Config{UserAgent: true}, config)
+	})
+
+	t.Run("does not bind the sessions by default", func(t *testing.T) {
+		config, err := getSessionBindingConfig(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if an option is invalid", func(t *testing.T) {
+		for flag, value := range map[string]string{
+			sessionBindingFlagName:           "cookie",
+			sessionBindingModeFlagName:       "block",
+			sessionBindingIPv4PrefixFlagName: "wide",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t),
+				"--"+sessionBindingFlagName, bindNetwork,
+				"--"+flag, value,
+			))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+flag+" value '"+value+"'")
+		}
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+sessionBindingFlagName, bindNetwork,
+			"--"+sessionBindingIPv6PrefixFlagName, "129",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid IPv6 prefix length 129")
+	})
+}
+
+func TestStartCmdWithPseudonyms(t *testing.T) {
+	t.Run("derives pseudonymous ids of the users", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+pseudonymHMACKeyFlagName, key(t),
+			"--"+pseudonymEncKeyFlagName, key(t),
+		))
+		require.NoError(t, startCmd.Execute())
+
+		config, err := getPseudonymConfig(startCmd, nil)
+		require.NoError(t, err)
+		require.Len(t, config.HMACKey, 32)
+		require.Len(t, config.EncryptionKey, 32)
+	})
+
+	t.Run("uses the subs as is by default", func(t *testing.T) {
+		config, err := getPseudonymConfig(GetStartCmd(&mockServer{}), nil)
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("error if the encryption key is missing", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+pseudonymHMACKeyFlagName, key(t)))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), pseudonymEncKeyFlagName)
+	})
+
+	t.Run("error if a key is invalid", func(t *testing.T) {
+		for _, flag := range []string{pseudonymHMACKeyFlagName, pseudonymEncKeyFlagName} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t),
+				"--"+pseudonymHMACKeyFlagName, key(t),
+				"--"+pseudonymEncKeyFlagName, key(t),
+				"--"+flag, "missing.key",
+			))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "failed to read file missing.key")
+		}
+	})
+}
+
+func TestStartCmdWithHSM(t *testing.T) {
+	t.Run("seals the cookies and the subs with the keys of the hsm", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{"--" + pseudonymHMACKeyFlagName, key(t)}))
+
+		hsmParams := &hsmParameters{
+			keys:              keys.Software{"cookies": make([]byte, 32), "subs": make([]byte, 32)},
+			cookieKeyLabel:    "cookies",
+			pseudonymKeyLabel: "subs",
+		}
+
+		keyParams, err := getKeyParams(startCmd, nil, hsmParams)
+		require.NoError(t, err)
+		require.NotNil(t, keyParams.sessionCookieKeys)
+
+		config, err := getPseudonymConfig(startCmd, hsmParams)
+		require.NoError(t, err)
+		require.NotNil(t, config.Cipher)
+		require.Empty(t, config.EncryptionKey)
+
+		hsmParams.keys = keys.Software{}
+
+		_, err = getKeyParams(startCmd, nil, hsmParams)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure session cookie key")
+
+		_, err = getPseudonymConfig(startCmd, hsmParams)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure pseudonym enc key")
+	})
+
+	t.Run("error if the hsm is not configured", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--" + hsmLibraryFlagName, "/usr/lib/softhsm/libsofthsm2.so"},
+			{"--" + hsmLibraryFlagName, "/usr/lib/softhsm/libsofthsm2.so", "--" + hsmTokenLabelFlagName, "agent"},
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), args...))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "Neither")
+		}
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+hsmLibraryFlagName, "/usr/lib/softhsm/libsofthsm2.so",
+			"--"+hsmTokenLabelFlagName, "agent",
+			"--"+hsmPINFlagName, "1234",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "hsm-library is set without hsm-cookie-key-label or hsm-pseudonym-key-label")
+	})
+
+	t.Run("error if the agent is built without pkcs11 support", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+hsmLibraryFlagName, "/usr/lib/softhsm/libsofthsm2.so",
+			"--"+hsmTokenLabelFlagName, "agent",
+			"--"+hsmPINFlagName, "1234",
+			"--"+hsmCookieKeyLabelFlagName, "cookies",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.True(t, errors.Is(err, hsm.ErrUnsupported))
+	})
+}
+
+func TestStartCmdWithRememberMe(t *testing.T) {
+	// the remember-me middleware deletes the cookies of invalid tokens
+	forgets := func(t *testing.T, srv *mockServer) bool {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil)
+		r.AddCookie(&http.Cookie{Name: remember.CookieName, Value: "invalid"})
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		for _, c := range w.Result().Cookies() { // nolint:bodyclose // recorded response
+			if c.Name == remember.CookieName {
+				return c.MaxAge < 0
+			}
+		}
+
+		return false
+	}
+
+	t.Run("remembers the users who ask for it", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t), "--"+rememberTTLFlagName, "720h"))
+		require.NoError(t, startCmd.Execute())
+
+		ttl, err := getRememberTTL(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, 720*time.Hour, ttl)
+		require.True(t, forgets(t, srv))
+	})
+
+	t.Run("does not remember the users by default", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(validArgs(t))
+		require.NoError(t, startCmd.Execute())
+		require.False(t, forgets(t, srv))
+	})
+
+	t.Run("error if the ttl is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+rememberTTLFlagName, "forever"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+rememberTTLFlagName+" value 'forever'")
+	})
+}
+
+func TestStartCmdWithIdempotencyKeys(t *testing.T) {
+	t.Run("replays the responses of the retried requests", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t), "--"+idempotencyTTLFlagName, "24h"))
+		require.NoError(t, startCmd.Execute())
+
+		ttl, err := getIdempotencyTTL(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, 24*time.Hour, ttl)
+
+		// the agent UI may send the keys
+		req := httptest.NewRequest(http.MethodOptions, "/oidc/login", nil)
+		req.Header.Set("Origin", "ui")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", oidc.IdempotencyKeyHeader)
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, req)
+		require.Equal(t, oidc.IdempotencyKeyHeader, w.Header().Get("Access-Control-Allow-Headers"))
+	})
+
+	t.Run("error if the ttl is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+idempotencyTTLFlagName, "0s"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+idempotencyTTLFlagName+" value '0s'")
+	})
+}
+
+func TestStartCmdWithOutbox(t *testing.T) {
+	t.Run("serves the dead letters of the outbox", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+adminTokenFlagName, "token",
+			"--"+outboxMaxAttemptsFlagName, "3",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		attempts, err := getOutboxMaxAttempts(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, 3, attempts)
+
+		r := httptest.NewRequest(http.MethodGet, "/admin/webhooks/dead-letters", nil)
+		r.Header.Set("Authorization", "Bearer token")
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, "[]", w.Body.String())
+	})
+
+	t.Run("error if the max attempts are invalid", func(t *testing.T) {
+		for _, value := range []string{"0", "many"} {
+			startCmd := GetStartCmd(&mockServer{})
+			startCmd.SetArgs(append(validArgs(t), "--"+outboxMaxAttemptsFlagName, value))
+
+			err := startCmd.Execute()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid "+outboxMaxAttemptsFlagName+" value '"+value+"'")
+		}
+	})
+}
+
+func TestStartCmdWithAuthorizationPolicy(t *testing.T) {
+	policy := func(t *testing.T, content string) string {
+		t.Helper()
+
+		file := filepath.Join(t.TempDir(), "policy.yaml")
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+		return file
+	}
+
+	t.Run("authorizes the users by policy", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+adminTokenFlagName, "token",
+			"--"+authzPolicyFlagName, policy(t, "rules:\n"+
+				"  - {prefix: /admin/, attributes: {groups: [wallet-admins]}}\n"+
+				"  - {prefix: /oidc/userinfo/export, step-up: {amr-values: [mfa]}}\n"),
+		))
+		require.NoError(t, startCmd.Execute())
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/userinfo/export", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "access denied: not logged in")
+
+		// the administrators must also be logged in users of the group
+		r := httptest.NewRequest(http.MethodGet, "/admin/users/usage", nil)
+		r.Header.Set("Authorization", "Bearer token")
+
+		w = httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "access denied")
+	})
+
+	t.Run("authorizes the users with an Open Policy Agent", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+authzOPAURLFlagName, "http://opa:8181/v1/data/edgeagent/authz",
+			"--"+authzPolicyFlagName, policy(t, `{"rules": []}`),
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getAuthzParams(startCmd)
+		require.NoError(t, err)
+		require.Equal(t, "http://opa:8181/v1/data/edgeagent/authz", params.opaURL)
+		require.NotNil(t, params.rules)
+	})
+
+	t.Run("does not authorize by policy by default", func(t *testing.T) {
+		params, err := getAuthzParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("error if the policy is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+authzPolicyFlagName, policy(t, "rules:\n  - {prefix: /admin/}\n")))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid authorization rule 0")
+	})
+
+	t.Run("error if the OPA URL is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+authzOPAURLFlagName, "opa:8181"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+authzOPAURLFlagName)
+	})
+}
+
+func TestStartCmdWithGRPC(t *testing.T) {
+	grpcArgs := func(t *testing.T, args ...string) []string {
+		t.Helper()
+
+		certFile, keyFile := clientKeyPair(t)
+
+		return append(append(validArgs(t),
+			"--"+tlsCertFileFlagName, certFile,
+			"--"+tlsKeyFileFlagName, keyFile,
+			"--"+grpcHostURLFlagName, "localhost:0",
+			"--"+grpcClientCACertsFlagName, cert(t),
+		), args...)
+	}
+
+	t.Run("serves gRPC along with HTTP", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(grpcArgs(t,
+			"--"+grpcAllowedClientsFlagName, "wallet-admin",
+			"--"+grpcAllowedClientsFlagName, "hub-auth",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getGRPCParams(startCmd, &tlsParameters{
+			certFile: startCmd.Flag(tlsCertFileFlagName).Value.String(),
+			keyFile:  startCmd.Flag(tlsKeyFileFlagName).Value.String(),
+		})
+		require.NoError(t, err)
+		require.Equal(t, "localhost:0", params.hostURL)
+		require.NotNil(t, params.clientCAs)
+		require.Equal(t, []string{"wallet-admin", "hub-auth"}, params.allowedClients)
+	})
+
+	t.Run("does not serve gRPC by default", func(t *testing.T) {
+		params, err := getGRPCParams(GetStartCmd(&mockServer{}), &tlsParameters{})
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("error if the client CAs are missing", func(t *testing.T) {
+		certFile, keyFile := clientKeyPair(t)
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+tlsCertFileFlagName, certFile,
+			"--"+tlsKeyFileFlagName, keyFile,
+			"--"+grpcHostURLFlagName, "localhost:0",
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), grpcClientCACertsFlagName+" must be set")
+	})
+
+	t.Run("error if the server certificate cannot be loaded", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+grpcHostURLFlagName, "localhost:0",
+			"--"+grpcClientCACertsFlagName, cert(t),
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to load the gRPC server certificate")
+	})
+
+	t.Run("error if the server certificate is not set", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{"--" + grpcHostURLFlagName, "localhost:0"}))
+
+		_, err := getGRPCParams(startCmd, &tlsParameters{})
+		require.EqualError(t, err, "the gRPC server requires both tls-cert-file and tls-key-file")
+	})
+
+	t.Run("error if the gRPC server cannot listen", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(grpcArgs(t), "--"+grpcHostURLFlagName, "invalid:address:0"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to start grpc server")
+	})
+}
+
+func TestStartCmdWithPush(t *testing.T) {
+	t.Run("pushes the events of the agent", func(t *testing.T) {
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", "https://kms.example.com/kms/keystores/123")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer kms.Close()
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+opsKMSURLFlagName, kms.URL,
+			"--"+agentDIDCommURLFlagName, "https://agent.example.com/didcomm",
+			"--"+pushFCMCredentialsFlagName, fcmCredentials(t),
+			"--"+pushAPNsKeyFlagName, ecKey(t),
+			"--"+pushAPNsKeyIDFlagName, "key",
+			"--"+pushAPNsTeamIDFlagName, "team",
+			"--"+pushAPNsTopicFlagName, "com.example.wallet",
+			"--"+pushVAPIDKeyFlagName, ecKey(t),
+			"--"+pushVAPIDSubjectFlagName, "mailto:admin@example.com",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		params, err := getPushParams(startCmd)
+		require.NoError(t, err)
+		require.NotEmpty(t, params.fcmCredentials)
+		require.Equal(t, "com.example.wallet", params.apns.Topic)
+		require.Equal(t, "mailto:admin@example.com", params.webPush.Subject)
+	})
+
+	t.Run("does not push by default", func(t *testing.T) {
+		params, err := getPushParams(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, params)
+	})
+
+	t.Run("error if a key cannot be loaded", func(t *testing.T) {
+		for flag, msg := range map[string]string{
+			pushFCMCredentialsFlagName: "failed to read FCM credentials",
+			pushAPNsKeyFlagName:        "failed to load APNs key",
+			pushVAPIDKeyFlagName:       "failed to load VAPID key",
+		} {
+			startCmd := GetStartCmd(&mockServer{})
+			require.NoError(t, startCmd.ParseFlags([]string{"--" + flag, "/invalid/path"}))
+
+			_, err := getPushParams(startCmd)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), msg)
+		}
+
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{"--" + pushVAPIDKeyFlagName, key(t)}))
+
+		_, err := getPushParams(startCmd)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no PEM block found")
+	})
+
+	t.Run("error if the APNs config is incomplete", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{
+			"--" + pushAPNsKeyFlagName, ecKey(t),
+			"--" + pushAPNsKeyIDFlagName, "key",
+		}))
+
+		_, err := getPushParams(startCmd)
+		require.EqualError(t, err, "the APNs key requires push-apns-key-id, push-apns-team-id and push-apns-topic")
+	})
+
+	t.Run("error if the VAPID subject is missing", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		require.NoError(t, startCmd.ParseFlags([]string{"--" + pushVAPIDKeyFlagName, ecKey(t)}))
+
+		_, err := getPushParams(startCmd)
+		require.EqualError(t, err, "the VAPID key requires push-vapid-subject")
+	})
+
+	t.Run("error if the FCM credentials are invalid", func(t *testing.T) {
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Location", "https://kms.example.com/kms/keystores/123")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer kms.Close()
+
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+opsKMSURLFlagName, kms.URL,
+			"--"+agentDIDCommURLFlagName, "https://agent.example.com/didcomm",
+			"--"+pushFCMCredentialsFlagName, key(t),
+		))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to add push handlers")
+	})
+}
+
+func TestStartCmdWithClaimsFilter(t *testing.T) {
+	t.Run("filters the user info claims", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+oidcClaimDenyListFlagName, "employee_id",
+			"--"+oidcClaimDenyListFlagName, "groups",
+			"--"+oidcClaimRenameFlagName, "email=mail",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		filter, err := getClaimsFilter(startCmd)
+		require.NoError(t, err)
+		require.Empty(t, filter.Allow)
+		require.Equal(t, []string{"employee_id", "groups"}, filter.Deny)
+		require.Equal(t, map[string]string{"email": "mail"}, filter.Rename)
+	})
+
+	t.Run("sends all user info claims by default", func(t *testing.T) {
+		filter, err := getClaimsFilter(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, filter)
+	})
+
+	t.Run("error if a claim rename is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+oidcClaimRenameFlagName, "email="))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to configure OIDC claims filter: invalid claim mapping 'email='")
+	})
+}
+
+func TestStartCmdWithConsent(t *testing.T) {
+	t.Run("serves the consent endpoints if consent is required", func(t *testing.T) {
+		srv := &mockServer{}
+		startCmd := GetStartCmd(srv)
+		startCmd.SetArgs(append(validArgs(t), "--"+consentVersionFlagName, "v1"))
+		require.NoError(t, startCmd.Execute())
+
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/consent", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("defaults to the consent page of the agent UI", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		require.Nil(t, getConsentConfig(startCmd, "ui"))
+
+		require.NoError(t, startCmd.Flags().Set(consentVersionFlagName, "v1"))
+		require.Equal(t, "ui/consent", getConsentConfig(startCmd, "ui").PageURL)
+
+		require.NoError(t, startCmd.Flags().Set(consentPageURLFlagName, "https://ui.example.com/terms"))
+		require.Equal(t, "https://ui.example.com/terms", getConsentConfig(startCmd, "ui").PageURL)
+	})
+}
+
+func TestStartCmdWithAgentSigningKeys(t *testing.T) {
+	t.Run("signs with a key of the ops KMS by default", func(t *testing.T) {
+		keys, err := getAgentSigningKeys(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Equal(t, kmsSigningKeys, keys)
+
+		kms := mockAgentKMS()
+		defer kms.Close()
+
+		s, err := newAgentSigner(&httpServerParameters{
+			tls:         &tlsParameters{},
+			keyServer:   &keyServerParameters{opsKMSURL: kms.URL},
+			signingKeys: keys,
+		}, memstore.NewProvider())
+		require.NoError(t, err)
+		require.IsType(t, &signer.KMSSigner{}, s)
+	})
+
+	t.Run("signs with a local key", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t),
+			"--"+agentSigningKeysFlagName, localSigningKeys,
+			"--"+bootstrapSigningFlagName, "true",
+		))
+		require.NoError(t, startCmd.Execute())
+
+		s, err := newAgentSigner(&httpServerParameters{signingKeys: localSigningKeys}, memstore.NewProvider())
+		require.NoError(t, err)
+		require.IsType(t, &signer.LocalSigner{}, s)
+	})
+
+	t.Run("error if the option is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+agentSigningKeysFlagName, "hsm"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid "+agentSigningKeysFlagName+" value 'hsm'")
+	})
+}
+
+func TestStartCmdWithDevMode(t *testing.T) {
+	args := func(t *testing.T) []string {
+		t.Helper()
+
+		return []string{
+			"--" + hostURLFlagName, "localhost:8080", "--" + tlsCertFileFlagName, "cert",
+			"--" + tlsKeyFileFlagName, "key",
+			"--" + agentUIURLFlagName, "ui",
+			"--" + oidcCallbackURLFlagName, "http://test.com/callback",
+			"--" + sessionCookieAuthKeyFlagName, key(t),
+			"--" + sessionCookieEncKeyFlagName, key(t),
+			"--" + webAuthRPDisplayFlagName, "Foobar Corp.",
+			"--" + webAuthRPIDFlagName, "localhost",
+			"--" + webAuthRPOriginFlagName, "http://localhost",
+			"--" + devModeFlagName, "true",
+		}
+	}
+
+	t.Run("points the services to the fakes", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(args(t))
+		require.NoError(t, startCmd.Execute())
+
+		url := startCmd.Flag(oidcProviderURLFlagName).Value.String()
+		require.True(t, strings.HasPrefix(url, "http://127.0.0.1:"))
+		require.Equal(t, url, startCmd.Flag(hubAuthURLFlagName).Value.String())
+		require.Equal(t, url, startCmd.Flag(authzKMSURLFlagName).Value.String())
+		require.Equal(t, url, startCmd.Flag(opsKMSURLFlagName).Value.String())
+		require.Equal(t, url+"/encrypted-data-vaults", startCmd.Flag(keyEDVURLFlagName).Value.String())
+		require.Equal(t, url+"/encrypted-data-vaults", startCmd.Flag(userEDVURLFlagName).Value.String())
+		require.Equal(t, devModeClientID, startCmd.Flag(oidcClientIDFlagName).Value.String())
+		require.Equal(t, localSigningKeys, startCmd.Flag(agentSigningKeysFlagName).Value.String())
+	})
+
+	t.Run("keeps the services that are set", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(args(t), "--"+hubAuthURLFlagName, "http://hub-auth.example.com"))
+		require.NoError(t, startCmd.Execute())
+
+		require.Equal(t, "http://hub-auth.example.com", startCmd.Flag(hubAuthURLFlagName).Value.String())
+		require.NotEqual(t, "http://hub-auth.example.com", startCmd.Flag(authzKMSURLFlagName).Value.String())
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		services, err := startDevMode(GetStartCmd(&mockServer{}))
+		require.NoError(t, err)
+		require.Nil(t, services)
+	})
+
+	t.Run("error if the dev mode is invalid", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(validArgs(t), "--"+devModeFlagName, "maybe"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid dev-mode value 'maybe'")
+	})
+
+	t.Run("error if the fakes cannot listen", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+		startCmd.SetArgs(append(args(t), "--"+devModeHostFlagName, "localhost:-1"))
+
+		err := startCmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to start the dev mode")
+	})
+}
+
+func TestDeviceAuthorizationURL(t *testing.T) {
+	providerURL := mockOIDCProvider(t)
+
+	provider, err := initOIDCProvider(providerURL, 0, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, providerURL+"/oauth2/device/auth", deviceAuthorizationURL(provider))
+}
+
+func TestAdminAuth(t *testing.T) {
+	handler := adminAuth("token")(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("accepts the admin token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, adminBasePath, nil)
+		req.Header.Set("Authorization", "Bearer token")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("rejects a missing or wrong token", func(t *testing.T) {
+		for _, header := range []string{"", "Bearer wrong", "token2"} {
+			req := httptest.NewRequest(http.MethodGet, adminBasePath, nil)
+			req.Header.Set("Authorization", header)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+	})
+}
+
+func TestStartCmdValidArgsEnvVar(t *testing.T) {
+	startCmd := GetStartCmd(&mockServer{})
+
+	err := os.Setenv(hostURLEnvKey, "localhost:8080")
+	require.NoError(t, err)
+
+	err = os.Setenv(tlsCertFileEnvKey, "cert")
+	require.NoError(t, err)
+
+	err = os.Setenv(tlsKeyFileEnvKey, "key")
+	require.NoError(t, err)
+
+	err = os.Setenv(agentUIURLEnvKey, "ui")
+	require.NoError(t, err)
+
+	err = os.Setenv(oidcProviderURLEnvKey, mockOIDCProvider(t))
+	require.NoError(t, err)
+
+	err = os.Setenv(oidcClientIDEnvKey, uuid.New().String())
+	require.NoError(t, err)
+
+	err = os.Setenv(oidcClientSecretEnvKey, uuid.New().String())
+	require.NoError(t, err)
+
+	err = os.Setenv(oidcCallbackURLEnvKey, "http://test.com/callback")
+	require.NoError(t, err)
+
+	err = os.Setenv(sessionCookieEncKeyEnvKey, key(t))
+	require.NoError(t, err)
+
+	err = os.Setenv(sessionCookieAuthKeyEnvKey, key(t))
+	require.NoError(t, err)
+
+	err = os.Setenv(webAuthRPDisplayEnvKey, "Foobar Corp.")
+	require.NoError(t, err)
+
+	err = os.Setenv(webAuthRPIDEnvKey, "http://localhost")
+	require.NoError(t, err)
+
+	err = os.Setenv(webAuthRPOriginEnvKey, "localhost")
+	require.NoError(t, err)
+
+	err = os.Setenv(authzKMSURLEnvKey, "http://localhost")
+	require.NoError(t, err)
+
+	err = os.Setenv(opsKMSURLEnvKey, "http://localhost")
+	require.NoError(t, err)
+
+	err = os.Setenv(keyEDVURLEnvKey, "http://localhost")
+	require.NoError(t, err)
+
+	err = os.Setenv(hubAuthURLEnvKey, "http://localhost")
+	require.NoError(t, err)
+
+	err = startCmd.Execute()
+
+	require.NoError(t, err)
+}
+
+func TestStartCmdWithBlankEnvVar(t *testing.T) {
+	t.Run("test blank host env var", func(t *testing.T) {
+		startCmd := GetStartCmd(&mockServer{})
+
+		err := os.Setenv(hostURLEnvKey, "")
+		require.NoError(t, err)
+
+		err = os.Setenv(tlsCertFileEnvKey, "cert")
+		require.NoError(t, err)
+
+		err = os.Setenv(tlsKeyFileEnvKey, "key")
+		require.NoError(t, err)
+
+		err = startCmd.Execute()
+		require.Error(t, err)
+		require.Equal(t, "HTTP_SERVER_HOST_URL value is empty", err.Error())
+	})
+}
+
+func TestHealthCheckHandler(t *testing.T) {
+	result := httptest.NewRecorder()
+	healthCheckHandler(result, nil)
+	require.Equal(t, http.StatusOK, result.Code)
+}
+
+// validArgs returns a minimal set of valid arguments for the start command.
+func validArgs(t *testing.T) []string {
+	t.Helper()
+
+	return []string{
+		"--" + hostURLFlagName, "localhost:8080", "--" + tlsCertFileFlagName, "cert",
+		"--" + tlsKeyFileFlagName, "key",
+		"--" + agentUIURLFlagName, "ui",
+		"--" + oidcProviderURLFlagName, mockOIDCProvider(t),
+		"--" + oidcClientIDFlagName, uuid.New().String(),
+		"--" + oidcClientSecretFlagName, uuid.New().String(),
+		"--" + oidcCallbackURLFlagName, "http://test.com/callback",
+		"--" + sessionCookieAuthKeyFlagName, key(t),
+		"--" + sessionCookieEncKeyFlagName, key(t),
+		"--" + webAuthRPDisplayFlagName, "Foobar Corp.",
+		"--" + webAuthRPIDFlagName, "localhost",
+		"--" + webAuthRPOriginFlagName, "http://localhost",
+		"--" + authzKMSURLFlagName, "http://localhost",
+		"--" + opsKMSURLFlagName, "http://localhost",
+		"--" + keyEDVURLFlagName, "http://localhost",
+		"--" + hubAuthURLFlagName, "http://localhost",
+	}
+}
+
+func checkFlagPropertiesCorrect(t *testing.T, cmd *cobra.Command, flagName, flagShorthand, flagUsage string) {
+	flag := cmd.Flag(flagName)
+
+	require.NotNil(t, flag)
+	require.Equal(t, flagName, flag.Name)
+	require.Equal(t, flagShorthand, flag.Shorthand)
+	require.Equal(t, flagUsage, flag.Usage)
+	require.Equal(t, "", flag.Value.String())
+
+	flagAnnotations := flag.Annotations
+	require.Nil(t, flagAnnotations)
+}
+
+func mockOIDCProvider(t *testing.T) string {
+	h := &testOIDCProvider{}
+	srv := httptest.NewServer(h)
+	h.baseURL = srv.URL
+
+	t.Cleanup(srv.Close)
+
+	return srv.URL
+}
+
+type oidcConfigJSON struct {
+	Issuer      string   `json:"issuer"`
+	AuthURL     string   `json:"authorization_endpoint"`
+	TokenURL    string   `json:"token_endpoint"`
+	JWKSURL     string   `json:"jwks_uri"`
+	UserInfoURL string   `json:"userinfo_endpoint"`
+	DeviceURL   string   `json:"device_authorization_endpoint"`
+	Algorithms  []string `json:"id_token_signing_alg_values_supported"`
+}
+
+type testOIDCProvider struct {
+	baseURL string
+}
+
+func (t *testOIDCProvider) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	response, err := json.Marshal(&oidcConfigJSON{
+		Issuer:      t.baseURL,
+		AuthURL:     fmt.Sprintf("%s/oauth2/auth", t.baseURL),
+		TokenURL:    fmt.Sprintf("%s/oauth2/token", t.baseURL),
+		JWKSURL:     fmt.Sprintf("%s/oauth2/certs", t.baseURL),
+		UserInfoURL: fmt.Sprintf("%s/oauth2/userinfo", t.baseURL),
+		DeviceURL:   fmt.Sprintf("%s/oauth2/device/auth", t.baseURL),
+		Algorithms:  []string{"RS256"},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = w.Write(response)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func cert(t *testing.T) string {
+	file, err := ioutil.TempFile("", "*.pem")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		fileErr := file.Close()
+		require.NoError(t, fileErr)
+		fileErr = os.Remove(file.Name())
+		require.NoError(t, fileErr)
+	})
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Test"},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	secret, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &secret.PublicKey, secret)
+	require.NoError(t, err)
+
+	err = pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, err)
+
+	return file.Name()
+}
+
+// clientKeyPair writes a client certificate and its private key to temporary files.
+func clientKeyPair(t *testing.T) (string, string) {
+	t.Helper()
+
+	secret, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wallet-server"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &secret.PublicKey, secret)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(secret)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "client")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, os.RemoveAll(dir))
+	})
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile
+}
+
+// writeConfigFile writes the options of the command line arguments to a config file, the last value of repeated
+// options being a list. It writes to a temporary file unless given one.
+func writeConfigFile(t *testing.T, format string, args []string, file ...string) string {
+	t.Helper()
+
+	options := make(map[string]interface{})
+
+	for i := 0; i+1 < len(args); i += 2 {
+		name := strings.TrimPrefix(args[i], "--")
+
+		if value, found := options[name]; found {
+			options[name] = []interface{}{value, args[i+1]}
+
+			continue
+		}
+
+		options[name] = args[i+1]
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+
+	if format == "json" {
+		data, err = json.Marshal(options)
+	} else {
+		data, err = yaml.Marshal(options)
+	}
+
+	require.NoError(t, err)
+
+	if len(file) == 0 {
+		dir, err := ioutil.TempDir("", "config")
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			require.NoError(t, os.RemoveAll(dir))
+		})
+
+		file = []string{filepath.Join(dir, "config."+format)}
+	}
+
+	require.NoError(t, ioutil.WriteFile(file[0], data, 0600))
+
+	return file[0]
+}
+
+// mockVault serves the values of the KV version 2 secrets by their API paths.
+func mockVault(t *testing.T, values map[string]string) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value, found := values[r.URL.Path]
+		if !found || r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"data": map[string]string{"value": value}},
+		})
+		require.NoError(t, err)
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv.URL
+}
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return key
+}
+
+func key(t *testing.T) string {
+	t.Helper()
+
+	key := make([]byte, 32)
 
 	n, err := rand.Reader.Read(key)
 	require.NoError(t, err)
@@ -747,6 +4202,57 @@ func key(t *testing.T) string {
 	return file.Name()
 }
 
+// ecKey writes a PEM encoded P-256 private key to a temporary file.
+func ecKey(t *testing.T) string {
+	t.Helper()
+
+	secret, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(secret)
+	require.NoError(t, err)
+
+	return tempFile(t, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+// fcmCredentials writes the JSON key of a Firebase service account to a temporary file.
+func fcmCredentials(t *testing.T) string {
+	t.Helper()
+
+	secret, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	bits, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "project",
+		"client_email": "push@project.iam.gserviceaccount.com",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(secret),
+		})),
+	})
+	require.NoError(t, err)
+
+	return tempFile(t, bits)
+}
+
+func tempFile(t *testing.T, content []byte) string {
+	t.Helper()
+
+	file, err := ioutil.TempFile("", "test_*")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, file.Close())
+		require.NoError(t, os.Remove(file.Name()))
+	})
+
+	_, err = file.Write(content)
+	require.NoError(t, err)
+
+	return file.Name()
+}
+
 func invalidKey(t *testing.T) string {
 	t.Helper()
 
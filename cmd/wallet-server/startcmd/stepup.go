@@ -0,0 +1,72 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Step-up authentication config.
+const (
+	stepUpACRValuesFlagName  = "step-up-acr-values"
+	stepUpACRValuesFlagUsage = "Optional. Authentication context classes accepted for the sensitive operations," +
+		" e.g. the export of the user data, in order of preference. Users authenticated otherwise must" +
+		" authenticate again with the OIDC provider, which is sent these classes as acr_values." +
+		" Alternatively, this can be set with the following environment variable: " + stepUpACRValuesEnvKey
+	stepUpACRValuesEnvKey = "HTTP_SERVER_STEP_UP_ACR_VALUES"
+
+	stepUpAMRValuesFlagName  = "step-up-amr-values"
+	stepUpAMRValuesFlagUsage = "Optional. Authentication methods accepted for the sensitive operations, e.g. mfa." +
+		" Alternatively, this can be set with the following environment variable: " + stepUpAMRValuesEnvKey
+	stepUpAMRValuesEnvKey = "HTTP_SERVER_STEP_UP_AMR_VALUES"
+
+	stepUpMaxAgeFlagName  = "step-up-max-age"
+	stepUpMaxAgeFlagUsage = "Optional. How long an authentication allows the sensitive operations for, e.g. 10m." +
+		" Defaults to 5m. Step-up authentication is required if any of the step-up options is set." +
+		" Alternatively, this can be set with the following environment variable: " + stepUpMaxAgeEnvKey
+	stepUpMaxAgeEnvKey = "HTTP_SERVER_STEP_UP_MAX_AGE"
+)
+
+func createStepUpFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP(stepUpACRValuesFlagName, "", []string{}, stepUpACRValuesFlagUsage)
+	cmd.Flags().StringArrayP(stepUpAMRValuesFlagName, "", []string{}, stepUpAMRValuesFlagUsage)
+	cmd.Flags().StringP(stepUpMaxAgeFlagName, "", "", stepUpMaxAgeFlagUsage)
+}
+
+// getStepUpConfig returns nil if step-up authentication is not required.
+func getStepUpConfig(cmd *cobra.Command) (*oidc.StepUpConfig, error) {
+	acrValues, err := cmdutils.GetUserSetVarFromArrayString(cmd, stepUpACRValuesFlagName, stepUpACRValuesEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure step-up acr values: %w", err)
+	}
+
+	amrValues, err := cmdutils.GetUserSetVarFromArrayString(cmd, stepUpAMRValuesFlagName, stepUpAMRValuesEnvKey, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure step-up amr values: %w", err)
+	}
+
+	maxAgeConfig := cmdutils.GetUserSetOptionalVarFromString(cmd, stepUpMaxAgeFlagName, stepUpMaxAgeEnvKey)
+
+	if len(acrValues) == 0 && len(amrValues) == 0 && maxAgeConfig == "" {
+		return nil, nil
+	}
+
+	config := &oidc.StepUpConfig{ACRValues: acrValues, AMRValues: amrValues}
+
+	if maxAgeConfig != "" {
+		config.MaxAge, err = parsePositiveDuration(stepUpMaxAgeFlagName, maxAgeConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,50 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Token exchange config.
+const (
+	tokenExchangeHubAuthAudienceFlagName  = "token-exchange-hub-auth-audience"
+	tokenExchangeHubAuthAudienceFlagUsage = "Optional. Audience of the tokens of hub-auth. The access tokens of the" +
+		" users are exchanged with the OIDC provider for tokens of this audience (RFC 8693) before they are sent" +
+		" to hub-auth, for providers issuing opaque access tokens. The access tokens are sent as is if not set." +
+		" Alternatively, this can be set with the following environment variable: " + tokenExchangeHubAuthAudienceEnvKey
+	tokenExchangeHubAuthAudienceEnvKey = "HTTP_SERVER_TOKEN_EXCHANGE_HUB_AUTH_AUDIENCE"
+
+	tokenExchangeKMSAudienceFlagName  = "token-exchange-kms-audience"
+	tokenExchangeKMSAudienceFlagUsage = "Optional. Audience of the tokens of the authz and ops KMS, like" +
+		" " + tokenExchangeHubAuthAudienceFlagName + " for hub-auth." +
+		" Alternatively, this can be set with the following environment variable: " + tokenExchangeKMSAudienceEnvKey
+	tokenExchangeKMSAudienceEnvKey = "HTTP_SERVER_TOKEN_EXCHANGE_KMS_AUDIENCE"
+)
+
+func createTokenExchangeFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(tokenExchangeHubAuthAudienceFlagName, "", "", tokenExchangeHubAuthAudienceFlagUsage)
+	cmd.Flags().StringP(tokenExchangeKMSAudienceFlagName, "", "", tokenExchangeKMSAudienceFlagUsage)
+}
+
+// getTokenExchangeConfig returns nil if the access tokens are not exchanged. The exchanger is the OIDC client.
+func getTokenExchangeConfig(cmd *cobra.Command) *oidc.TokenExchangeConfig {
+	config := &oidc.TokenExchangeConfig{
+		HubAuthAudience: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			tokenExchangeHubAuthAudienceFlagName, tokenExchangeHubAuthAudienceEnvKey),
+		KMSAudience: cmdutils.GetUserSetOptionalVarFromString(cmd,
+			tokenExchangeKMSAudienceFlagName, tokenExchangeKMSAudienceEnvKey),
+	}
+
+	if config.HubAuthAudience == "" && config.KMSAudience == "" {
+		return nil
+	}
+
+	return config
+}
@@ -0,0 +1,119 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Token store config.
+const (
+	tokenStoreFlagName  = "token-store"
+	tokenStoreFlagUsage = "Optional. Where the OAuth access and refresh tokens of the users are kept: storage, in" +
+		" the database of the agent, or vault, in the KV version 2 secrets engine of a HashiCorp Vault." +
+		" Defaults to storage." +
+		" Alternatively, this can be set with the following environment variable: " + tokenStoreEnvKey
+	tokenStoreEnvKey = "HTTP_SERVER_TOKEN_STORE"
+
+	tokenVaultURLFlagName  = "token-vault-url"
+	tokenVaultURLFlagUsage = "URL of the Vault server keeping the tokens, e.g. https://vault.example.com:8200." +
+		" Required if " + tokenStoreFlagName + " is vault." +
+		" Alternatively, this can be set with the following environment variable: " + tokenVaultURLEnvKey
+	tokenVaultURLEnvKey = "HTTP_SERVER_TOKEN_VAULT_URL"
+
+	tokenVaultTokenFlagName  = "token-vault-token"
+	tokenVaultTokenFlagUsage = "Token authenticating the agent to Vault. It must be allowed to create, read, list" +
+		" and delete the secrets under " + tokenVaultPathFlagName + ". Required if " + tokenStoreFlagName +
+		" is vault." +
+		" Alternatively, this can be set with the following environment variable: " + tokenVaultTokenEnvKey
+	tokenVaultTokenEnvKey = "HTTP_SERVER_TOKEN_VAULT_TOKEN"
+
+	tokenVaultMountFlagName  = "token-vault-mount"
+	tokenVaultMountFlagUsage = "Optional. Path of the KV secrets engine of Vault. Defaults to secret." +
+		" Alternatively, this can be set with the following environment variable: " + tokenVaultMountEnvKey
+	tokenVaultMountEnvKey = "HTTP_SERVER_TOKEN_VAULT_MOUNT"
+
+	tokenVaultPathFlagName  = "token-vault-path"
+	tokenVaultPathFlagUsage = "Optional. Path of the secrets of the users in the KV secrets engine." +
+		" Defaults to edgeagent/tokens." +
+		" Alternatively, this can be set with the following environment variable: " + tokenVaultPathEnvKey
+	tokenVaultPathEnvKey = "HTTP_SERVER_TOKEN_VAULT_PATH"
+)
+
+const tokenVaultTimeout = 10 * time.Second
+
+// Token stores.
+const (
+	tokenStoreStorage = "storage"
+	tokenStoreVault   = "vault"
+)
+
+func createTokenStoreFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(tokenStoreFlagName, "", "", tokenStoreFlagUsage)
+	cmd.Flags().StringP(tokenVaultURLFlagName, "", "", tokenVaultURLFlagUsage)
+	cmd.Flags().StringP(tokenVaultTokenFlagName, "", "", tokenVaultTokenFlagUsage)
+	cmd.Flags().StringP(tokenVaultMountFlagName, "", "", tokenVaultMountFlagUsage)
+	cmd.Flags().StringP(tokenVaultPathFlagName, "", "", tokenVaultPathFlagUsage)
+}
+
+// getTokenVaultConfig returns nil if the tokens are kept in the storage of the agent. The HTTP client is set
+// by the router.
+func getTokenVaultConfig(cmd *cobra.Command) (*tokens.VaultConfig, error) {
+	switch tokenStore := cmdutils.GetUserSetOptionalVarFromString(cmd, tokenStoreFlagName, tokenStoreEnvKey); tokenStore {
+	case "", tokenStoreStorage:
+		return nil, nil
+	case tokenStoreVault:
+	default:
+		return nil, fmt.Errorf("invalid %s value '%s': must be %s or %s",
+			tokenStoreFlagName, tokenStore, tokenStoreStorage, tokenStoreVault)
+	}
+
+	vaultURL, err := cmdutils.GetUserSetVarFromString(cmd, tokenVaultURLFlagName, tokenVaultURLEnvKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	vaultToken, err := cmdutils.GetUserSetVarFromString(cmd, tokenVaultTokenFlagName, tokenVaultTokenEnvKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokens.VaultConfig{
+		URL:   vaultURL,
+		Token: vaultToken,
+		Mount: cmdutils.GetUserSetOptionalVarFromString(cmd, tokenVaultMountFlagName, tokenVaultMountEnvKey),
+		Path:  cmdutils.GetUserSetOptionalVarFromString(cmd, tokenVaultPathFlagName, tokenVaultPathEnvKey),
+	}, nil
+}
+
+// newTokenStore returns nil if the tokens are kept in the storage of the agent.
+func newTokenStore(config *httpServerParameters) (tokens.Store, error) {
+	if config.tokenVault == nil {
+		return nil, nil
+	}
+
+	config.tokenVault.HTTPClient = &http.Client{
+		Timeout: tokenVaultTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: config.tls.config,
+			Proxy:           config.proxy,
+		},
+	}
+
+	tokenStore, err := tokens.NewVaultStore(config.tokenVault)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init token vault store: %w", err)
+	}
+
+	return tokenStore, nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/claims"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// User directory config.
+const (
+	userDirectoryFlagName  = "user-directory"
+	userDirectoryFlagUsage = "Optional. Directory the profiles of the users are resolved from on their first login" +
+		" and cached by the agent: ldap, an LDAP server, or scim, a SCIM 2.0 endpoint. The profiles come from the" +
+		" claims of the OIDC provider if not set, and for the users the directory has no entry for." +
+		" Alternatively, this can be set with the following environment variable: " + userDirectoryEnvKey
+	userDirectoryEnvKey = "HTTP_SERVER_USER_DIRECTORY"
+
+	userDirectoryURLFlagName  = "user-directory-url"
+	userDirectoryURLFlagUsage = "URL of the directory, e.g. ldaps://ldap.example.com:636 or" +
+		" https://scim.example.com/scim/v2. Required if " + userDirectoryFlagName + " is set." +
+		" Alternatively, this can be set with the following environment variable: " + userDirectoryURLEnvKey
+	userDirectoryURLEnvKey = "HTTP_SERVER_USER_DIRECTORY_URL"
+
+	userDirectoryTTLFlagName  = "user-directory-ttl"
+	userDirectoryTTLFlagUsage = "Optional. Duration the profiles resolved from the directory are cached for." +
+		" Defaults to 1h." +
+		" Alternatively, this can be set with the following environment variable: " + userDirectoryTTLEnvKey
+	userDirectoryTTLEnvKey = "HTTP_SERVER_USER_DIRECTORY_TTL"
+
+	userDirectoryFilterFlagName  = "user-directory-filter"
+	userDirectoryFilterFlagUsage = "Optional. Filter finding the entry of a user, with %s replaced with the sub of" +
+		" the user. Defaults to (uid=%s) for ldap and to userName eq %s for scim." +
+		" Alternatively, this can be set with the following environment variable: " + userDirectoryFilterEnvKey
+	userDirectoryFilterEnvKey = "HTTP_SERVER_USER_DIRECTORY_FILTER"
+
+	userDirectoryAttributesFlagName  = "user-directory-attributes"
+	userDirectoryAttributesFlagUsage = "Optional. Comma-separated list of claim=attribute pairs mapping the" +
+		" attributes of the directory entries into the user profile. For ldap, they override the default cn," +
+		" givenName, sn and mail attributes of the name, given_name, family_name and email claims." +
+		" Alternatively, this can be set with the following environment variable: " + userDirectoryAttributesEnvKey
+	userDirectoryAttributesEnvKey = "HTTP_SERVER_USER_DIRECTORY_ATTRIBUTES"
+
+	userDirectoryBindDNFlagName  = "user-directory-bind-dn"
+	userDirectoryBindDNFlagUsage = "Optional. DN the agent binds to the LDAP server with. The agent binds" +
+		" anonymously if not set." +
+		" Alternatively, this can be set with the following environment variable: " + userDirectoryBindDNEnvKey
+	userDirectoryBindDNEnvKey = "HTTP_SERVER_USER_DIRECTORY_BIND_DN"
+
+	userDirectoryBindPasswordFlagName  = "user-directory-bind-password"
+	userDirectoryBindPasswordFlagUsage = "Password of " + userDirectoryBindDNFlagName + "." +
+		" Alternatively, this can be set with the following environment variable: " +
+		userDirectoryBindPasswordEnvKey
+	userDirectoryBindPasswordEnvKey = "HTTP_SERVER_USER_DIRECTORY_BIND_PASSWORD"
+
+	userDirectoryBaseDNFlagName  = "user-directory-base-dn"
+	userDirectoryBaseDNFlagUsage = "Base of the search of the users in the LDAP server, e.g." +
+		" ou=people,dc=example,dc=com. Required if " + userDirectoryFlagName + " is ldap." +
+		" Alternatively, this can be set with the following environment variable: " + userDirectoryBaseDNEnvKey
+	userDirectoryBaseDNEnvKey = "HTTP_SERVER_USER_DIRECTORY_BASE_DN"
+
+	userDirectoryTokenFlagName  = "user-directory-token"
+	userDirectoryTokenFlagUsage = "Optional. Bearer token authenticating the agent to the SCIM endpoint." +
+		" Alternatively, this can be set with the following environment variable: " + userDirectoryTokenEnvKey
+	userDirectoryTokenEnvKey = "HTTP_SERVER_USER_DIRECTORY_TOKEN"
+)
+
+const userDirectoryTimeout = 10 * time.Second
+
+// User directories.
+const (
+	userDirectoryLDAP = "ldap"
+	userDirectorySCIM = "scim"
+)
+
+type userDirectoryParameters struct {
+	ttl  time.Duration
+	ldap *user.LDAPConfig
+	scim *user.SCIMConfig
+}
+
+func createUserDirectoryFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(userDirectoryFlagName, "", "", userDirectoryFlagUsage)
+	cmd.Flags().StringP(userDirectoryURLFlagName, "", "", userDirectoryURLFlagUsage)
+	cmd.Flags().StringP(userDirectoryTTLFlagName, "", "", userDirectoryTTLFlagUsage)
+	cmd.Flags().StringP(userDirectoryFilterFlagName, "", "", userDirectoryFilterFlagUsage)
+	cmd.Flags().StringArrayP(userDirectoryAttributesFlagName, "", []string{}, userDirectoryAttributesFlagUsage)
+	cmd.Flags().StringP(userDirectoryBindDNFlagName, "", "", userDirectoryBindDNFlagUsage)
+	cmd.Flags().StringP(userDirectoryBindPasswordFlagName, "", "", userDirectoryBindPasswordFlagUsage)
+	cmd.Flags().StringP(userDirectoryBaseDNFlagName, "", "", userDirectoryBaseDNFlagUsage)
+	cmd.Flags().StringP(userDirectoryTokenFlagName, "", "", userDirectoryTokenFlagUsage)
+}
+
+// getUserDirectoryParams returns nil if the profiles of the users come from the OIDC provider. The TLS config
+// and HTTP client are set by the router.
+func getUserDirectoryParams(cmd *cobra.Command) (*userDirectoryParameters, error) {
+	directory := cmdutils.GetUserSetOptionalVarFromString(cmd, userDirectoryFlagName, userDirectoryEnvKey)
+	switch directory {
+	case "":
+		return nil, nil
+	case userDirectoryLDAP, userDirectorySCIM:
+	default:
+		return nil, fmt.Errorf("invalid %s value '%s': must be %s or %s",
+			userDirectoryFlagName, directory, userDirectoryLDAP, userDirectorySCIM)
+	}
+
+	directoryURL, err := cmdutils.GetUserSetVarFromString(cmd, userDirectoryURLFlagName, userDirectoryURLEnvKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &userDirectoryParameters{}
+
+	ttl := cmdutils.GetUserSetOptionalVarFromString(cmd, userDirectoryTTLFlagName, userDirectoryTTLEnvKey)
+	if ttl != "" {
+		params.ttl, err = parsePositiveDuration(userDirectoryTTLFlagName, ttl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pairs, err := cmdutils.GetUserSetVarFromArrayString(
+		cmd, userDirectoryAttributesFlagName, userDirectoryAttributesEnvKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := claims.ParseAttributeMapping(pairs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", userDirectoryAttributesFlagName, err)
+	}
+
+	filter := cmdutils.GetUserSetOptionalVarFromString(cmd, userDirectoryFilterFlagName, userDirectoryFilterEnvKey)
+
+	if directory == userDirectorySCIM {
+		params.scim = &user.SCIMConfig{
+			URL:        directoryURL,
+			Token:      cmdutils.GetUserSetOptionalVarFromString(cmd, userDirectoryTokenFlagName, userDirectoryTokenEnvKey),
+			Filter:     filter,
+			Attributes: attributes,
+		}
+
+		return params, nil
+	}
+
+	baseDN, err := cmdutils.GetUserSetVarFromString(cmd, userDirectoryBaseDNFlagName, userDirectoryBaseDNEnvKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	params.ldap = &user.LDAPConfig{
+		URL:    directoryURL,
+		BindDN: cmdutils.GetUserSetOptionalVarFromString(cmd, userDirectoryBindDNFlagName, userDirectoryBindDNEnvKey),
+		BindPassword: cmdutils.GetUserSetOptionalVarFromString(cmd, userDirectoryBindPasswordFlagName,
+			userDirectoryBindPasswordEnvKey),
+		BaseDN:     baseDN,
+		Filter:     filter,
+		Attributes: attributes,
+	}
+
+	return params, nil
+}
+
+// newUserDirectory returns nil if the profiles of the users come from the OIDC provider.
+func newUserDirectory(config *httpServerParameters) (*user.ReadThroughConfig, error) {
+	params := config.userDirectory
+	if params == nil {
+		return nil, nil
+	}
+
+	var (
+		directory user.Directory
+		err       error
+	)
+
+	if params.scim != nil {
+		params.scim.HTTPClient = &http.Client{
+			Timeout: userDirectoryTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: config.tls.config,
+				Proxy:           config.proxy,
+			},
+		}
+
+		directory, err = user.NewSCIMDirectory(params.scim)
+	} else {
+		params.ldap.TLSConfig = config.tls.config
+		params.ldap.Timeout = userDirectoryTimeout
+
+		directory, err = user.NewLDAPDirectory(params.ldap)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to init user directory: %w", err)
+	}
+
+	return &user.ReadThroughConfig{Directory: directory, TTL: params.ttl}, nil
+}
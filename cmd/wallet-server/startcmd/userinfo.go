@@ -0,0 +1,45 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// User info cache config.
+const (
+	userInfoCacheTTLFlagName  = "userinfo-cache-ttl"
+	userInfoCacheTTLFlagUsage = "Optional. Duration the claims of the user info of the OIDC provider are cached for," +
+		" e.g. 5m. The wallet UI bypasses the cache with the refresh=true query parameter." +
+		" The claims are fetched from the provider on each request if not set." +
+		" Alternatively, this can be set with the following environment variable: " + userInfoCacheTTLEnvKey
+	userInfoCacheTTLEnvKey = "HTTP_SERVER_USERINFO_CACHE_TTL"
+)
+
+func createUserInfoCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(userInfoCacheTTLFlagName, "", "", userInfoCacheTTLFlagUsage)
+}
+
+// getUserInfoCacheConfig returns nil if the user info is not cached.
+func getUserInfoCacheConfig(cmd *cobra.Command) (*oidc.UserInfoCacheConfig, error) {
+	value := cmdutils.GetUserSetOptionalVarFromString(cmd, userInfoCacheTTLFlagName, userInfoCacheTTLEnvKey)
+	if value == "" {
+		return nil, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid %s value '%s': must be a positive duration", userInfoCacheTTLFlagName, value)
+	}
+
+	return &oidc.UserInfoCacheConfig{TTL: ttl}, nil
+}
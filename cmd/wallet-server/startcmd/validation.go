@@ -0,0 +1,81 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+)
+
+// Startup validation config.
+const (
+	startupValidationFlagName  = "startup-validation"
+	startupValidationFlagUsage = "Optional. Validates the configuration of the agent against its live dependencies" +
+		" at startup: the OIDC client against the provider, the reachability of hub-auth, the KMS and EDV servers" +
+		" with the TLS configuration and client certificate of the agent, the session cookie keys and the storage." +
+		" One of " + startupValidationOff + ", " + startupValidationWarn + " (logs the failed checks) and " +
+		startupValidationFail + " (refuses to start). Defaults to " + startupValidationWarn + "." +
+		" The configuration is validated on demand with GET " + adminBasePath + "validate." +
+		" Alternatively, this can be set with the following environment variable: " + startupValidationEnvKey
+	startupValidationEnvKey = "HTTP_SERVER_STARTUP_VALIDATION"
+
+	startupValidationOff  = "off"
+	startupValidationWarn = "warn"
+	startupValidationFail = "fail"
+
+	startupValidationTimeout = 30 * time.Second
+)
+
+func createStartupValidationFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(startupValidationFlagName, "", "", startupValidationFlagUsage)
+}
+
+func getStartupValidation(cmd *cobra.Command) (string, error) {
+	mode := cmdutils.GetUserSetOptionalVarFromString(cmd, startupValidationFlagName, startupValidationEnvKey)
+
+	switch mode {
+	case "":
+		return startupValidationWarn, nil
+	case startupValidationOff, startupValidationWarn, startupValidationFail:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid %s value '%s': must be one of %s, %s and %s", startupValidationFlagName,
+			mode, startupValidationOff, startupValidationWarn, startupValidationFail)
+	}
+}
+
+// validateStartup validates the configuration of the agent against its dependencies, so that it fails fast rather
+// than on the first login of a user.
+func validateStartup(mode string, ops *oidc.Operation) error {
+	if mode == startupValidationOff {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), startupValidationTimeout)
+	defer cancel()
+
+	err := ops.Validate(ctx).Err()
+	if err == nil {
+		logger.Infof("validated the configuration against the dependencies")
+
+		return nil
+	}
+
+	if mode == startupValidationFail {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	logger.Warnf("invalid configuration: %s", err.Error())
+
+	return nil
+}
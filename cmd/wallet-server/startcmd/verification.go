@@ -0,0 +1,69 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/didresolver"
+	"github.com/trustbloc/edge-agent/pkg/restapi/verification"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+)
+
+// Credential verification config.
+const (
+	statusListTTLFlagName  = "status-list-ttl"
+	statusListTTLFlagUsage = "Optional. Duration the status lists of the credentials verified are cached for," +
+		" e.g. 10m. Defaults to 5m." +
+		" Alternatively, this can be set with the following environment variable: " + statusListTTLEnvKey
+	statusListTTLEnvKey = "HTTP_SERVER_STATUS_LIST_TTL"
+
+	statusListTimeout = 10 * time.Second
+)
+
+func createVerificationFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP(statusListTTLFlagName, "", "", statusListTTLFlagUsage)
+}
+
+// getStatusListTTL returns the duration the status lists are cached for, or 0 for the default.
+func getStatusListTTL(cmd *cobra.Command) (time.Duration, error) {
+	ttl := cmdutils.GetUserSetOptionalVarFromString(cmd, statusListTTLFlagName, statusListTTLEnvKey)
+	if ttl == "" {
+		return 0, nil
+	}
+
+	return parsePositiveDuration(statusListTTLFlagName, ttl)
+}
+
+// addVerificationHandlers lets the logged-in users verify their credentials and presentations. The DIDs of the
+// issuers and holders are resolved with the resolver of the agent.
+func addVerificationHandlers(router *mux.Router, config *httpServerParameters, resolver *didresolver.Resolver,
+	middleware []common.Middleware) error {
+	verificationOps, err := verification.New(&verification.Config{
+		Resolver: resolver,
+		HTTPClient: &http.Client{
+			Timeout:   statusListTimeout,
+			Transport: &http.Transport{TLSClientConfig: config.tls.config, Proxy: config.proxy},
+		},
+		StatusListTTL: config.statusListTTL,
+		Keys: &verification.KeyConfig{
+			Ring: config.keys.sessionCookieKeys,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to init verification operations: %w", err)
+	}
+
+	mount(router, verificationOps.GetRESTHandlers(), middleware, config.openapi)
+
+	return nil
+}
@@ -0,0 +1,321 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package edgeagent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Base paths of the REST API of the wallet server.
+const (
+	oidcBasePath  = "/oidc"
+	agentBasePath = "/agent"
+)
+
+const (
+	defaultMaxRetries = 2
+	defaultBackoff    = 200 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+var logger = log.New("edge-agent/client")
+
+// TokenSource returns the access token sent with a request.
+type TokenSource func(ctx context.Context) (string, error)
+
+// RetryPolicy tells how the client retries the requests the server could not serve: the requests that failed to
+// be sent, or were answered with 429, 502, 503 or 504. The requests that change the state of the wallet (POST) are
+// only retried if the server refused them with 429 or 503, as the other failures may have been processed.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt. Zero disables the retries.
+	MaxRetries int
+	// Backoff is the wait before the first retry, doubled with every retry up to MaxBackoff. A Retry-After header
+	// of the server takes precedence.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy retries twice, after 200ms then 400ms.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxRetries: defaultMaxRetries, Backoff: defaultBackoff, MaxBackoff: defaultMaxBackoff}
+}
+
+// StatusError is the error of a request the server answered with a non-2xx status.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Message is the errMessage of the error response, or the body if the server did not send an error response.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s returned status %d: %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+// Client is a client of the REST API of the wallet server.
+//
+// The client authenticates with the session cookies of the server by default: it keeps the cookies set by a login
+// in its cookie jar and sends them with the next requests. Backend services may instead authenticate with the
+// access tokens of the OIDC provider, see WithBearerToken, if the server validates bearer tokens.
+type Client struct {
+	serverURL  string
+	httpClient *http.Client
+	jar        http.CookieJar
+	token      TokenSource
+	retry      *RetryPolicy
+}
+
+// Option configures the client.
+type Option func(c *Client)
+
+// WithHTTPClient sends the requests with the HTTP client. The client is copied, and given a cookie jar if it has
+// none.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		copied := *httpClient
+		c.httpClient = &copied
+	}
+}
+
+// WithTLSConfig sets the TLS configuration of the client's connections.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Client) {
+		c.httpClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}}
+	}
+}
+
+// WithCookieJar keeps the session cookies in the jar, e.g. to share a session between clients.
+func WithCookieJar(jar http.CookieJar) Option {
+	return func(c *Client) {
+		c.jar = jar
+	}
+}
+
+// WithBearerToken authenticates the requests with the access token in an 'Authorization: Bearer' header.
+func WithBearerToken(token string) Option {
+	return WithTokenSource(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// WithTokenSource authenticates each request with the access token returned by the source, e.g. to refresh
+// expired tokens.
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) {
+		c.token = source
+	}
+}
+
+// WithRetryPolicy sets how the client retries the failed requests. Defaults to DefaultRetryPolicy.
+func WithRetryPolicy(policy *RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// New returns a new Client of the wallet server at serverURL.
+func New(serverURL string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url: %w", err)
+	}
+
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid server url: %s", serverURL)
+	}
+
+	c := &Client{
+		serverURL:  strings.TrimSuffix(serverURL, "/"),
+		httpClient: &http.Client{},
+		retry:      DefaultRetryPolicy(),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.jar != nil {
+		c.httpClient.Jar = c.jar
+	}
+
+	if c.httpClient.Jar == nil {
+		// cookiejar.New never fails without options
+		c.httpClient.Jar, _ = cookiejar.New(nil) // nolint:errcheck // see above
+	}
+
+	if c.retry == nil {
+		c.retry = &RetryPolicy{}
+	}
+
+	return c, nil
+}
+
+// getJSON sends a GET request and decodes the response into result, if not nil.
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, result interface{}) error {
+	endpoint := c.serverURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	return c.send(ctx, http.MethodGet, endpoint, nil, result)
+}
+
+// postJSON sends the JSON of the request, if not nil, and decodes the response into result, if not nil.
+func (c *Client) postJSON(ctx context.Context, path string, request, result interface{}) error {
+	var body []byte
+
+	if request != nil {
+		var err error
+
+		body, err = json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+
+	return c.send(ctx, http.MethodPost, c.serverURL+path, body, result)
+}
+
+// send sends the request, retrying it as per the retry policy, and decodes the response into result, if not nil.
+func (c *Client) send(ctx context.Context, method, endpoint string, body []byte, result interface{}) error {
+	for attempt := 0; ; attempt++ {
+		status, header, reply, err := c.do(ctx, method, endpoint, body)
+
+		wait, retry := c.retryAfter(attempt, method, status, header, err)
+		if !retry {
+			if err != nil {
+				return err
+			}
+
+			return decodeReply(method, endpoint, status, reply, result)
+		}
+
+		logger.Debugf("retrying %s %s in %s", method, endpoint, wait)
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("%s %s: %w", method, endpoint, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, endpoint string, body []byte) (int, http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != nil {
+		token, errToken := c.token(ctx)
+		if errToken != nil {
+			return 0, nil, nil, fmt.Errorf("failed to get access token: %w", errToken)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Errorf("failed to close response body: %s", errClose)
+		}
+	}()
+
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return resp.StatusCode, resp.Header, reply, nil
+}
+
+// retryAfter tells whether the attempt is retried, and after how long.
+func (c *Client) retryAfter(attempt int, method string, status int, header http.Header,
+	err error) (time.Duration, bool) {
+	if attempt >= c.retry.MaxRetries || !retryable(method, status, err) {
+		return 0, false
+	}
+
+	wait := c.retry.Backoff << uint(attempt)
+
+	if seconds, errAtoi := strconv.Atoi(header.Get("Retry-After")); errAtoi == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	if c.retry.MaxBackoff > 0 && wait > c.retry.MaxBackoff {
+		wait = c.retry.MaxBackoff
+	}
+
+	return wait, true
+}
+
+func retryable(method string, status int, err error) bool {
+	refused := status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+
+	if method == http.MethodPost {
+		return err == nil && refused
+	}
+
+	return err != nil || refused || status == http.StatusBadGateway || status == http.StatusGatewayTimeout
+}
+
+func decodeReply(method, endpoint string, status int, reply []byte, result interface{}) error {
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		statusErr := &StatusError{Method: method, URL: endpoint, StatusCode: status, Message: string(reply)}
+
+		errResponse := &common.ErrorResponse{}
+		if json.Unmarshal(reply, errResponse) == nil && errResponse.Message != "" {
+			statusErr.Message = errResponse.Message
+		}
+
+		return statusErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	err := json.Unmarshal(reply, result)
+	if err != nil {
+		return fmt.Errorf("failed to decode response of %s %s: %w", method, endpoint, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,393 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package edgeagent_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/client/edgeagent"
+	"github.com/trustbloc/edge-agent/pkg/restapi/agent"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("error if server url is invalid", func(t *testing.T) {
+		_, err := edgeagent.New("wallet.example.com")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid server url")
+
+		_, err = edgeagent.New("http://%zz")
+		require.Error(t, err)
+	})
+
+	t.Run("login url", func(t *testing.T) {
+		client, err := edgeagent.New("https://wallet.example.com/")
+		require.NoError(t, err)
+		require.Equal(t, "https://wallet.example.com/oidc/login", client.LoginURL())
+	})
+}
+
+func TestClient_CookieAuth(t *testing.T) {
+	server := newMockServer(t)
+	defer server.Close()
+
+	client, err := edgeagent.New(server.URL)
+	require.NoError(t, err)
+
+	_, err = client.UserInfo(context.Background(), false)
+	requireStatus(t, err, http.StatusForbidden, "not logged in")
+
+	authorization, err := client.DeviceLogin(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "device-code", authorization.DeviceCode)
+
+	status, err := client.DeviceToken(context.Background(), "pending")
+	require.NoError(t, err)
+	require.Equal(t, oidc.DeviceLoginPending, status.Status)
+
+	status, err = client.DeviceToken(context.Background(), authorization.DeviceCode)
+	require.NoError(t, err)
+	require.Equal(t, oidc.DeviceLoginCompleted, status.Status)
+
+	claims, err := client.UserInfo(context.Background(), true)
+	require.NoError(t, err)
+	require.Equal(t, "john", claims["sub"])
+	require.Equal(t, "true", server.query().Get("refresh"))
+
+	bootstrap, err := client.Bootstrap(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "https://edv.example.com/vault", bootstrap.UserEDVVaultURL)
+
+	onboarding, err := client.OnboardingStatus(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "completed", onboarding.Status)
+
+	require.NoError(t, client.Logout(context.Background()))
+
+	_, err = client.Bootstrap(context.Background())
+	requireStatus(t, err, http.StatusForbidden, "not logged in")
+}
+
+func TestClient_BearerAuth(t *testing.T) {
+	server := newMockServer(t)
+	defer server.Close()
+
+	client, err := edgeagent.New(server.URL, edgeagent.WithBearerToken("token"))
+	require.NoError(t, err)
+
+	claims, err := client.UserInfo(context.Background(), false)
+	require.NoError(t, err)
+	require.Equal(t, "john", claims["sub"])
+	require.Equal(t, "Bearer token", server.authorization())
+
+	client, err = edgeagent.New(server.URL, edgeagent.WithTokenSource(func(context.Context) (string, error) {
+		return "", errors.New("expired")
+	}), edgeagent.WithRetryPolicy(nil))
+	require.NoError(t, err)
+
+	_, err = client.UserInfo(context.Background(), false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to get access token: expired")
+}
+
+func TestClient_SharedCookieJar(t *testing.T) {
+	server := newMockServer(t)
+	defer server.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+
+	login, err := edgeagent.New(server.URL, edgeagent.WithCookieJar(jar), edgeagent.WithHTTPClient(server.Client()))
+	require.NoError(t, err)
+
+	_, err = login.DeviceToken(context.Background(), "device-code")
+	require.NoError(t, err)
+
+	client, err := edgeagent.New(server.URL, edgeagent.WithCookieJar(jar))
+	require.NoError(t, err)
+
+	_, err = client.UserInfo(context.Background(), false)
+	require.NoError(t, err)
+}
+
+func TestClient_Retry(t *testing.T) {
+	policy := &edgeagent.RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond}
+
+	t.Run("retries the reads the server could not serve", func(t *testing.T) {
+		server := newMockServer(t)
+		defer server.Close()
+
+		server.failures = []int{http.StatusBadGateway, http.StatusServiceUnavailable}
+
+		client, err := edgeagent.New(server.URL, edgeagent.WithBearerToken("token"), edgeagent.WithRetryPolicy(policy))
+		require.NoError(t, err)
+
+		_, err = client.UserInfo(context.Background(), false)
+		require.NoError(t, err)
+		require.Equal(t, 3, server.calls("/oidc/userinfo"))
+	})
+
+	t.Run("gives up after the max retries", func(t *testing.T) {
+		server := newMockServer(t)
+		defer server.Close()
+
+		server.failures = []int{http.StatusGatewayTimeout, http.StatusGatewayTimeout, http.StatusGatewayTimeout}
+
+		client, err := edgeagent.New(server.URL, edgeagent.WithBearerToken("token"), edgeagent.WithRetryPolicy(policy))
+		require.NoError(t, err)
+
+		_, err = client.UserInfo(context.Background(), false)
+		requireStatus(t, err, http.StatusGatewayTimeout, "failure")
+		require.Equal(t, 3, server.calls("/oidc/userinfo"))
+	})
+
+	t.Run("retries the writes the server refused only", func(t *testing.T) {
+		server := newMockServer(t)
+		defer server.Close()
+
+		server.failures = []int{http.StatusTooManyRequests, http.StatusBadGateway}
+
+		client, err := edgeagent.New(server.URL, edgeagent.WithRetryPolicy(policy))
+		require.NoError(t, err)
+
+		_, err = client.DeviceLogin(context.Background())
+		requireStatus(t, err, http.StatusBadGateway, "failure")
+		require.Equal(t, 2, server.calls("/oidc/login/device"))
+	})
+
+	t.Run("does not retry the client errors", func(t *testing.T) {
+		server := newMockServer(t)
+		defer server.Close()
+
+		client, err := edgeagent.New(server.URL, edgeagent.WithRetryPolicy(policy))
+		require.NoError(t, err)
+
+		_, err = client.OnboardingStatus(context.Background())
+		requireStatus(t, err, http.StatusForbidden, "not logged in")
+		require.Equal(t, 1, server.calls("/oidc/onboarding/status"))
+	})
+
+	t.Run("stops waiting once the context is done", func(t *testing.T) {
+		server := newMockServer(t)
+		defer server.Close()
+
+		server.failures = []int{http.StatusServiceUnavailable}
+		server.retryAfter = "60"
+
+		client, err := edgeagent.New(server.URL, edgeagent.WithBearerToken("token"))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err = client.UserInfo(ctx, false)
+		require.Error(t, err)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
+
+func TestClient_Wallet(t *testing.T) {
+	server := newMockServer(t)
+	defer server.Close()
+
+	client, err := edgeagent.New(server.URL, edgeagent.WithBearerToken("token"))
+	require.NoError(t, err)
+
+	doc, err := client.CreateDID(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, "did:key:z6Mk", doc.ID)
+
+	connectionID, err := client.AcceptInvitation(context.Background(), &agent.AcceptInvitationRequest{Label: "wallet"})
+	require.NoError(t, err)
+	require.Equal(t, "connection1", connectionID)
+
+	connections, err := client.Connections(context.Background(), &edgeagent.ConnectionsQuery{State: "completed"})
+	require.NoError(t, err)
+	require.Len(t, connections, 1)
+	require.Equal(t, "completed", server.query().Get("state"))
+	require.NotContains(t, server.query(), "my_did")
+
+	interaction, err := client.ResolveInteraction(context.Background(), &agent.InteractionRequest{Type: "waci"})
+	require.NoError(t, err)
+	require.Equal(t, "waci", interaction.Type)
+
+	response, err := client.RespondInteraction(context.Background(), &agent.RespondRequest{Holder: "did:key:z6Mk"})
+	require.NoError(t, err)
+	require.Equal(t, "vp", response.Presentation)
+
+	offer, err := client.RedeemOffer(context.Background(), &agent.RedeemOfferRequest{Offer: "offer"})
+	require.NoError(t, err)
+	require.Len(t, offer.Credentials, 1)
+
+	session, err := client.ResolvePresentationRequest(context.Background(), "openid4vp://?request_uri=x")
+	require.NoError(t, err)
+	require.Equal(t, "session1", session.ID)
+
+	session, err = client.PresentationSession(context.Background(), "session1")
+	require.NoError(t, err)
+	require.Equal(t, "session1", server.query().Get("id"))
+	require.Equal(t, "pending", session.Status)
+
+	session, err = client.SubmitPresentation(context.Background(), &agent.SubmitPresentationRequest{ID: "session1"})
+	require.NoError(t, err)
+	require.Equal(t, "submitted", session.Status)
+
+	server.invalidJSON = true
+
+	_, err = client.CreateDID(context.Background(), "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to create did")
+}
+
+func requireStatus(t *testing.T, err error, status int, message string) {
+	t.Helper()
+
+	statusErr := &edgeagent.StatusError{}
+	require.True(t, errors.As(err, &statusErr), "%v", err)
+	require.Equal(t, status, statusErr.StatusCode)
+	require.Equal(t, message, statusErr.Message)
+}
+
+type mockServer struct {
+	*httptest.Server
+	mutex             sync.Mutex
+	counts            map[string]int
+	failures          []int
+	retryAfter        string
+	invalidJSON       bool
+	lastAuthorization string
+	lastQuery         url.Values
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	t.Helper()
+
+	s := &mockServer{counts: make(map[string]int)}
+
+	authenticated := func(r *http.Request) bool {
+		cookie, err := r.Cookie("session")
+
+		return r.Header.Get("Authorization") == "Bearer token" || err == nil && cookie.Value == "john"
+	}
+
+	routes := map[string]interface{}{
+		"/oidc/login/device": map[string]interface{}{"device_code": "device-code"},
+		"/oidc/userinfo": map[string]interface{}{
+			"sub":       "john",
+			"bootstrap": &oidc.BootstrapData{UserEDVVaultURL: "https://edv.example.com/vault"},
+		},
+		"/oidc/onboarding/status": &oidc.OnboardingStatus{Status: "completed"},
+		"/agent/dids":             map[string]interface{}{"@context": "https://www.w3.org/ns/did/v1", "id": "did:key:z6Mk"},
+		"/agent/invitations":      &agent.AcceptInvitationResponse{ConnectionID: "connection1"},
+		"/agent/connections": map[string]interface{}{
+			"connections": []interface{}{map[string]string{"ConnectionID": "connection1"}},
+		},
+		"/agent/interactions/resolve": &agent.Interaction{Type: "waci"},
+		"/agent/interactions/respond": &agent.RespondResponse{Presentation: "vp"},
+		"/agent/oidc4vci/offers":      &agent.RedeemOfferResponse{Credentials: []*agent.IssuedCredential{{ID: "vc1"}}},
+		"/agent/oidc4vp/responses":    &agent.PresentationSession{ID: "session1", Status: "submitted"},
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.counts[r.URL.Path]++
+		s.lastAuthorization = r.Header.Get("Authorization")
+		s.lastQuery = r.URL.Query()
+
+		var failure int
+
+		if len(s.failures) > 0 {
+			failure, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.mutex.Unlock()
+
+		if failure != 0 {
+			w.Header().Set("Retry-After", s.retryAfter)
+			w.WriteHeader(failure)
+			fmt.Fprint(w, "failure")
+
+			return
+		}
+
+		if s.invalidJSON {
+			fmt.Fprint(w, "{")
+
+			return
+		}
+
+		var body interface{}
+
+		switch r.URL.Path {
+		case "/oidc/login/device/token":
+			request := &oidc.DeviceTokenRequest{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(request))
+
+			if request.DeviceCode == "pending" {
+				w.WriteHeader(http.StatusAccepted)
+				body = &oidc.DeviceLoginStatus{Status: oidc.DeviceLoginPending}
+
+				break
+			}
+
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "john", Path: "/"})
+			body = &oidc.DeviceLoginStatus{Status: oidc.DeviceLoginCompleted}
+		case "/oidc/logout":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "", Path: "/", MaxAge: -1})
+
+			return
+		case "/oidc/login/device":
+			body = routes[r.URL.Path]
+		case "/agent/oidc4vp/requests":
+			body = &agent.PresentationSession{ID: "session1", Status: "pending"}
+		default:
+			if !authenticated(r) {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, `{"errMessage":"not logged in"}`)
+
+				return
+			}
+
+			body = routes[r.URL.Path]
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(body))
+	}))
+
+	return s
+}
+
+func (s *mockServer) calls(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.counts[path]
+}
+
+func (s *mockServer) query() url.Values {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lastQuery
+}
+
+func (s *mockServer) authorization() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lastAuthorization
+}
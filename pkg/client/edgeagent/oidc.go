@@ -0,0 +1,116 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package edgeagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+)
+
+const bootstrapDataKey = "bootstrap"
+
+// LoginURL returns the URL a browser is sent to for the user to log in. The server redirects the browser to the
+// OIDC provider, then to the wallet once logged in.
+func (c *Client) LoginURL() string {
+	return c.serverURL + oidcBasePath + "/login"
+}
+
+// DeviceLogin starts the login of the client. The user authorizes the login at the verification URI of the
+// returned authorization, while the client polls the login with DeviceToken.
+func (c *Client) DeviceLogin(ctx context.Context) (*oidc2.DeviceAuthorization, error) {
+	authorization := &oidc2.DeviceAuthorization{}
+
+	err := c.postJSON(ctx, oidcBasePath+"/login/device", nil, authorization)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device login: %w", err)
+	}
+
+	return authorization, nil
+}
+
+// DeviceToken polls the login of the client. Once the login completed, the session cookie of the user is kept in
+// the cookie jar of the client.
+func (c *Client) DeviceToken(ctx context.Context, deviceCode string) (*oidc.DeviceLoginStatus, error) {
+	status := &oidc.DeviceLoginStatus{}
+
+	err := c.postJSON(ctx, oidcBasePath+"/login/device/token", &oidc.DeviceTokenRequest{DeviceCode: deviceCode},
+		status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to poll device login: %w", err)
+	}
+
+	return status, nil
+}
+
+// UserInfo returns the claims of the user logged in, along with the bootstrap data and config of the wallet.
+// The server caches the claims unless refresh is set.
+func (c *Client) UserInfo(ctx context.Context, refresh bool) (map[string]interface{}, error) {
+	query := url.Values{}
+	if refresh {
+		query.Set("refresh", "true")
+	}
+
+	claims := make(map[string]interface{})
+
+	err := c.getJSON(ctx, oidcBasePath+"/userinfo", query, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user info: %w", err)
+	}
+
+	return claims, nil
+}
+
+// Bootstrap returns the bootstrap data of the wallet of the user logged in.
+func (c *Client) Bootstrap(ctx context.Context) (*oidc.BootstrapData, error) {
+	userInfo := make(map[string]json.RawMessage)
+
+	err := c.getJSON(ctx, oidcBasePath+"/userinfo", nil, &userInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bootstrap data: %w", err)
+	}
+
+	data := &oidc.BootstrapData{}
+
+	raw, ok := userInfo[bootstrapDataKey]
+	if !ok {
+		return data, nil
+	}
+
+	err = json.Unmarshal(raw, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bootstrap data: %w", err)
+	}
+
+	return data, nil
+}
+
+// OnboardingStatus returns the progress of the onboarding of the user logged in.
+func (c *Client) OnboardingStatus(ctx context.Context) (*oidc.OnboardingStatus, error) {
+	status := &oidc.OnboardingStatus{}
+
+	err := c.getJSON(ctx, oidcBasePath+"/onboarding/status", nil, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get onboarding status: %w", err)
+	}
+
+	return status, nil
+}
+
+// Logout ends the session of the user logged in.
+func (c *Client) Logout(ctx context.Context) error {
+	err := c.getJSON(ctx, oidcBasePath+"/logout", nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to log out: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package edgeagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/trustbloc/edge-agent/pkg/restapi/agent"
+)
+
+// ConnectionsQuery filters the connections of the user. The empty fields match any connection.
+type ConnectionsQuery struct {
+	State    string
+	MyDID    string
+	TheirDID string
+}
+
+// CreateDID creates a DID of the user logged in with the DID method, or the default method of the server if empty.
+func (c *Client) CreateDID(ctx context.Context, method string) (*did.Doc, error) {
+	raw := json.RawMessage{}
+
+	err := c.postJSON(ctx, agentBasePath+"/dids", &agent.CreateDIDRequest{Method: method}, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create did: %w", err)
+	}
+
+	doc, err := did.ParseDocument(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse did document: %w", err)
+	}
+
+	return doc, nil
+}
+
+// AcceptInvitation accepts an out-of-band invitation and returns the id of the connection.
+func (c *Client) AcceptInvitation(ctx context.Context, request *agent.AcceptInvitationRequest) (string, error) {
+	response := &agent.AcceptInvitationResponse{}
+
+	err := c.postJSON(ctx, agentBasePath+"/invitations", request, response)
+	if err != nil {
+		return "", fmt.Errorf("failed to accept invitation: %w", err)
+	}
+
+	return response.ConnectionID, nil
+}
+
+// Connections lists the DIDComm connections of the user logged in.
+func (c *Client) Connections(ctx context.Context, query *ConnectionsQuery) ([]*didexchange.Connection, error) {
+	values := url.Values{}
+
+	if query != nil {
+		for key, value := range map[string]string{
+			"state": query.State, "my_did": query.MyDID, "their_did": query.TheirDID,
+		} {
+			if value != "" {
+				values.Set(key, value)
+			}
+		}
+	}
+
+	response := &agent.ConnectionsResponse{}
+
+	err := c.getJSON(ctx, agentBasePath+"/connections", values, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	return response.Connections, nil
+}
+
+// ResolveInteraction resolves a CHAPI or WACI interaction received by the wallet.
+func (c *Client) ResolveInteraction(ctx context.Context,
+	request *agent.InteractionRequest) (*agent.Interaction, error) {
+	interaction := &agent.Interaction{}
+
+	err := c.postJSON(ctx, agentBasePath+"/interactions/resolve", request, interaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve interaction: %w", err)
+	}
+
+	return interaction, nil
+}
+
+// RespondInteraction answers an interaction on behalf of the user logged in.
+func (c *Client) RespondInteraction(ctx context.Context,
+	request *agent.RespondRequest) (*agent.RespondResponse, error) {
+	response := &agent.RespondResponse{}
+
+	err := c.postJSON(ctx, agentBasePath+"/interactions/respond", request, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to respond to interaction: %w", err)
+	}
+
+	return response, nil
+}
+
+// RedeemOffer redeems an OIDC4VCI credential offer. The credentials are saved to the vault of the user, unless
+// the user must first authorize the issuance at the returned authorization URL.
+func (c *Client) RedeemOffer(ctx context.Context,
+	request *agent.RedeemOfferRequest) (*agent.RedeemOfferResponse, error) {
+	response := &agent.RedeemOfferResponse{}
+
+	err := c.postJSON(ctx, agentBasePath+"/oidc4vci/offers", request, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem offer: %w", err)
+	}
+
+	return response, nil
+}
+
+// ResolvePresentationRequest resolves an OIDC4VP presentation request, passed by value or by reference in the
+// request URI, and matches the credentials of the user against it.
+func (c *Client) ResolvePresentationRequest(ctx context.Context,
+	requestURI string) (*agent.PresentationSession, error) {
+	session := &agent.PresentationSession{}
+
+	err := c.postJSON(ctx, agentBasePath+"/oidc4vp/requests", &agent.ResolvePresentationRequest{Request: requestURI},
+		session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve presentation request: %w", err)
+	}
+
+	return session, nil
+}
+
+// PresentationSession returns the presentation session of the id.
+func (c *Client) PresentationSession(ctx context.Context, id string) (*agent.PresentationSession, error) {
+	session := &agent.PresentationSession{}
+
+	err := c.getJSON(ctx, agentBasePath+"/oidc4vp/requests", url.Values{"id": {id}}, session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presentation session: %w", err)
+	}
+
+	return session, nil
+}
+
+// SubmitPresentation presents the credentials the user selected to the verifier of a presentation session.
+func (c *Client) SubmitPresentation(ctx context.Context,
+	request *agent.SubmitPresentationRequest) (*agent.PresentationSession, error) {
+	session := &agent.PresentationSession{}
+
+	err := c.postJSON(ctx, agentBasePath+"/oidc4vp/responses", request, session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit presentation: %w", err)
+	}
+
+	return session, nil
+}
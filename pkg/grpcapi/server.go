@@ -0,0 +1,224 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/jobs"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var logger = log.New("grpcapi")
+
+// ServiceName is the name of the gRPC service.
+const ServiceName = "trustbloc.edgeagent.Users"
+
+// Backend serves the calls of the service. It is implemented by the OIDC operations of the REST API, so that
+// both APIs share the same stores and clients.
+type Backend interface {
+	User(sub string) (*user.User, error)
+	RefreshTokens(ctx context.Context, sub string) (time.Time, error)
+	Onboard(ctx context.Context, sub string) (*oidc.OnboardingStatus, error)
+	Bootstrap(ctx context.Context, sub string) (*oidc.BootstrapData, error)
+}
+
+// UserRequest identifies the user of a call.
+type UserRequest struct {
+	Sub string `json:"sub"`
+}
+
+// GetUserResponse is the profile of the user.
+type GetUserResponse struct {
+	User *user.User `json:"user"`
+}
+
+// RefreshTokensResponse is the expiry of the refreshed access token of the user.
+type RefreshTokensResponse struct {
+	Expiry time.Time `json:"expiry"`
+}
+
+// OnboardUserResponse is the status of the onboarding of the user.
+type OnboardUserResponse struct {
+	Status *oidc.OnboardingStatus `json:"status"`
+}
+
+// GetBootstrapDataResponse is the bootstrap data of the user.
+type GetBootstrapDataResponse struct {
+	Data *oidc.BootstrapData `json:"data"`
+}
+
+// Config of the gRPC server.
+type Config struct {
+	Backend Backend
+	// Certificate of the server.
+	Certificate tls.Certificate
+	// ClientCAs verify the certificates of the clients, which are required.
+	ClientCAs *x509.CertPool
+	// AllowedClients are the common names or DNS names of the certificates of the clients allowed to call the
+	// service. Any client with a verified certificate is allowed if empty.
+	AllowedClients []string
+}
+
+// Server serves the operations on the users of the agent over gRPC with mutual TLS.
+type Server struct {
+	backend Backend
+	allowed map[string]bool
+	server  *grpc.Server
+}
+
+// New returns a new gRPC server.
+func New(config *Config) (*Server, error) {
+	if config.Backend == nil {
+		return nil, errors.New("missing backend")
+	}
+
+	if config.ClientCAs == nil {
+		return nil, errors.New("missing client CAs")
+	}
+
+	s := &Server{
+		backend: config.Backend,
+		allowed: make(map[string]bool),
+	}
+
+	for _, name := range config.AllowedClients {
+		s.allowed[name] = true
+	}
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{config.Certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    config.ClientCAs,
+		MinVersion:   tls.VersionTLS12,
+	})
+
+	s.server = grpc.NewServer(grpc.Creds(creds), grpc.UnaryInterceptor(s.authenticate))
+	s.server.RegisterService(&serviceDesc, s)
+
+	return s, nil
+}
+
+// Serve accepts the connections of the listener until the server is stopped.
+func (s *Server) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Stop the server, waiting for the calls under way.
+func (s *Server) Stop() {
+	s.server.GracefulStop()
+}
+
+// authenticate rejects the calls of the clients that are not allowed.
+func (s *Server) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	client, err := clientName(ctx, s.allowed)
+	if err != nil {
+		logger.Warnf("rejected call to %s: %s", info.FullMethod, err)
+
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	logger.Debugf("call to %s by %s", info.FullMethod, client)
+
+	return handler(ctx, req)
+}
+
+// clientName returns the name of the verified certificate of the client that is allowed.
+func clientName(ctx context.Context, allowed map[string]bool) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", errors.New("unknown peer")
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", errors.New("missing verified client certificate")
+	}
+
+	cert := info.State.VerifiedChains[0][0]
+
+	if len(allowed) == 0 {
+		return cert.Subject.CommonName, nil
+	}
+
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		if allowed[name] {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("client %s is not allowed", cert.Subject.CommonName)
+}
+
+func (s *Server) getUser(_ context.Context, req *UserRequest) (*GetUserResponse, error) {
+	usr, err := s.backend.User(req.Sub)
+	if err != nil {
+		return nil, toStatus("failed to get user", err)
+	}
+
+	return &GetUserResponse{User: usr}, nil
+}
+
+func (s *Server) refreshTokens(ctx context.Context, req *UserRequest) (*RefreshTokensResponse, error) {
+	expiry, err := s.backend.RefreshTokens(ctx, req.Sub)
+	if err != nil {
+		return nil, toStatus("failed to refresh tokens", err)
+	}
+
+	return &RefreshTokensResponse{Expiry: expiry}, nil
+}
+
+func (s *Server) onboardUser(ctx context.Context, req *UserRequest) (*OnboardUserResponse, error) {
+	onboarding, err := s.backend.Onboard(ctx, req.Sub)
+	if err != nil {
+		return nil, toStatus("failed to onboard user", err)
+	}
+
+	return &OnboardUserResponse{Status: onboarding}, nil
+}
+
+func (s *Server) getBootstrapData(ctx context.Context, req *UserRequest) (*GetBootstrapDataResponse, error) {
+	data, err := s.backend.Bootstrap(ctx, req.Sub)
+	if err != nil {
+		return nil, toStatus("failed to get bootstrap data", err)
+	}
+
+	return &GetBootstrapDataResponse{Data: data}, nil
+}
+
+// toStatus maps the errors of the backend to the codes of gRPC, as the REST API maps them to HTTP statuses.
+func toStatus(msg string, err error) error {
+	code := codes.Internal
+
+	switch {
+	case errors.Is(err, storage.ErrValueNotFound):
+		code = codes.NotFound
+	case errors.Is(err, jobs.ErrQueueFull), errors.Is(err, jobs.ErrQueueStopped):
+		code = codes.Unavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+
+	return status.Errorf(code, "%s: %s", msg, err)
+}
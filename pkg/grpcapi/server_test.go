@@ -0,0 +1,256 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package grpcapi_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/grpcapi"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/jobs"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("error if the backend is missing", func(t *testing.T) {
+		_, err := grpcapi.New(&grpcapi.Config{ClientCAs: x509.NewCertPool()})
+		require.EqualError(t, err, "missing backend")
+	})
+
+	t.Run("error if the client CAs are missing", func(t *testing.T) {
+		_, err := grpcapi.New(&grpcapi.Config{Backend: &mockBackend{}})
+		require.EqualError(t, err, "missing client CAs")
+	})
+}
+
+func TestServer(t *testing.T) {
+	ca := newCA(t)
+	expiry := time.Now().Add(time.Hour).Round(time.Second).UTC()
+
+	backend := &mockBackend{
+		user:      &user.User{Sub: "user"},
+		expiry:    expiry,
+		status:    &oidc.OnboardingStatus{Status: oidc.OnboardingCompleted},
+		bootstrap: &oidc.BootstrapData{UserEDVVaultURL: "https://edv.example.com/encrypted-data-vaults/123"},
+	}
+
+	t.Run("serves the calls of the clients", func(t *testing.T) {
+		client := setup(t, ca, backend, nil, ca.issue(t, "wallet-admin"))
+
+		usr, err := client.GetUser(context.Background(), "user")
+		require.NoError(t, err)
+		require.Equal(t, "user", usr.User.Sub)
+
+		refreshed, err := client.RefreshTokens(context.Background(), "user")
+		require.NoError(t, err)
+		require.True(t, expiry.Equal(refreshed.Expiry))
+
+		onboarded, err := client.OnboardUser(context.Background(), "user")
+		require.NoError(t, err)
+		require.Equal(t, oidc.OnboardingCompleted, onboarded.Status.Status)
+
+		data, err := client.GetBootstrapData(context.Background(), "user")
+		require.NoError(t, err)
+		require.Equal(t, backend.bootstrap, data.Data)
+	})
+
+	t.Run("serves the allowed clients", func(t *testing.T) {
+		client := setup(t, ca, backend, []string{"wallet-admin"}, ca.issue(t, "wallet-admin"))
+
+		_, err := client.GetUser(context.Background(), "user")
+		require.NoError(t, err)
+	})
+
+	t.Run("rejects the clients that are not allowed", func(t *testing.T) {
+		client := setup(t, ca, backend, []string{"wallet-admin"}, ca.issue(t, "other"))
+
+		_, err := client.GetUser(context.Background(), "user")
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+		require.Contains(t, err.Error(), "client other is not allowed")
+	})
+
+	t.Run("rejects the clients without a certificate", func(t *testing.T) {
+		client := setup(t, ca, backend, nil)
+
+		_, err := client.GetUser(context.Background(), "user")
+		require.Error(t, err)
+	})
+
+	t.Run("rejects the clients with a certificate of another CA", func(t *testing.T) {
+		client := setup(t, ca, backend, nil, newCA(t).issue(t, "wallet-admin"))
+
+		_, err := client.GetUser(context.Background(), "user")
+		require.Error(t, err)
+	})
+
+	t.Run("maps the errors of the backend", func(t *testing.T) {
+		client := setup(t, ca, &mockBackend{err: fmt.Errorf("test: %w", storage.ErrValueNotFound)}, nil,
+			ca.issue(t, "wallet-admin"))
+
+		_, err := client.GetUser(context.Background(), "unknown")
+		require.Equal(t, codes.NotFound, status.Code(err))
+		require.Contains(t, err.Error(), "failed to get user")
+
+		client = setup(t, ca, &mockBackend{err: jobs.ErrQueueFull}, nil, ca.issue(t, "wallet-admin"))
+
+		_, err = client.OnboardUser(context.Background(), "user")
+		require.Equal(t, codes.Unavailable, status.Code(err))
+
+		client = setup(t, ca, &mockBackend{err: errors.New("test")}, nil, ca.issue(t, "wallet-admin"))
+
+		_, err = client.RefreshTokens(context.Background(), "user")
+		require.Equal(t, codes.Internal, status.Code(err))
+		require.Contains(t, err.Error(), "failed to refresh tokens: test")
+
+		_, err = client.GetBootstrapData(context.Background(), "user")
+		require.Equal(t, codes.Internal, status.Code(err))
+		require.Contains(t, err.Error(), "failed to get bootstrap data: test")
+	})
+}
+
+func setup(t *testing.T, ca *testCA, backend grpcapi.Backend, allowed []string,
+	clientCert ...tls.Certificate) *grpcapi.Client {
+	t.Helper()
+
+	server, err := grpcapi.New(&grpcapi.Config{
+		Backend:        backend,
+		Certificate:    ca.issue(t, "localhost"),
+		ClientCAs:      ca.pool(),
+		AllowedClients: allowed,
+	})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		require.NoError(t, server.Serve(listener))
+	}()
+
+	t.Cleanup(server.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, listener.Addr().String(), grpc.WithTransportCredentials(
+		credentials.NewTLS(&tls.Config{
+			Certificates: clientCert,
+			RootCAs:      ca.pool(),
+			ServerName:   "localhost",
+			MinVersion:   tls.VersionTLS12,
+		}),
+	))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, conn.Close())
+	})
+
+	return grpcapi.NewClient(conn)
+}
+
+type mockBackend struct {
+	user      *user.User
+	expiry    time.Time
+	status    *oidc.OnboardingStatus
+	bootstrap *oidc.BootstrapData
+	err       error
+}
+
+func (m *mockBackend) User(string) (*user.User, error) {
+	return m.user, m.err
+}
+
+func (m *mockBackend) RefreshTokens(context.Context, string) (time.Time, error) {
+	return m.expiry, m.err
+}
+
+func (m *mockBackend) Onboard(context.Context, string) (*oidc.OnboardingStatus, error) {
+	return m.status, m.err
+}
+
+func (m *mockBackend) Bootstrap(context.Context, string) (*oidc.BootstrapData, error) {
+	return m.bootstrap, m.err
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	return pool
+}
+
+// issue returns a certificate of the name for both the server and the clients.
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package grpcapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Methods of the service.
+const (
+	GetUserMethod          = "/" + ServiceName + "/GetUser"
+	RefreshTokensMethod    = "/" + ServiceName + "/RefreshTokens"
+	OnboardUserMethod      = "/" + ServiceName + "/OnboardUser"
+	GetBootstrapDataMethod = "/" + ServiceName + "/GetBootstrapData"
+)
+
+// serviceDesc describes the service by hand as the messages are not generated from protobuf definitions.
+var serviceDesc = grpc.ServiceDesc{ // nolint:gochecknoglobals // registered with the grpc server
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetUser",
+			Handler: unaryHandler(GetUserMethod,
+				func(s *Server, ctx context.Context, req *UserRequest) (interface{}, error) {
+					return s.getUser(ctx, req)
+				}),
+		},
+		{
+			MethodName: "RefreshTokens",
+			Handler: unaryHandler(RefreshTokensMethod,
+				func(s *Server, ctx context.Context, req *UserRequest) (interface{}, error) {
+					return s.refreshTokens(ctx, req)
+				}),
+		},
+		{
+			MethodName: "OnboardUser",
+			Handler: unaryHandler(OnboardUserMethod,
+				func(s *Server, ctx context.Context, req *UserRequest) (interface{}, error) {
+					return s.onboardUser(ctx, req)
+				}),
+		},
+		{
+			MethodName: "GetBootstrapData",
+			Handler: unaryHandler(GetBootstrapDataMethod,
+				func(s *Server, ctx context.Context, req *UserRequest) (interface{}, error) {
+					return s.getBootstrapData(ctx, req)
+				}),
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+type method func(s *Server, ctx context.Context, req *UserRequest) (interface{}, error)
+
+// unaryHandler decodes the request of the method and passes it through the interceptor of the server.
+func unaryHandler(fullMethod string, m method) func(interface{}, context.Context, func(interface{}) error,
+	grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+		interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := &UserRequest{}
+
+		err := dec(req)
+		if err != nil {
+			return nil, err
+		}
+
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return m(srv.(*Server), ctx, req.(*UserRequest))
+		}
+
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}, handler)
+	}
+}
+
+// Client calls the service over a connection established with the certificate of the client.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// NewClient returns a new client of the service.
+func NewClient(conn *grpc.ClientConn) *Client {
+	return &Client{conn: conn}
+}
+
+// GetUser returns the profile of the user.
+func (c *Client) GetUser(ctx context.Context, sub string) (*GetUserResponse, error) {
+	resp := &GetUserResponse{}
+
+	err := c.invoke(ctx, GetUserMethod, sub, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// RefreshTokens refreshes the tokens of the user with the OIDC provider.
+func (c *Client) RefreshTokens(ctx context.Context, sub string) (*RefreshTokensResponse, error) {
+	resp := &RefreshTokensResponse{}
+
+	err := c.invoke(ctx, RefreshTokensMethod, sub, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// OnboardUser provisions the keystores and vaults of the user if not done yet.
+func (c *Client) OnboardUser(ctx context.Context, sub string) (*OnboardUserResponse, error) {
+	resp := &OnboardUserResponse{}
+
+	err := c.invoke(ctx, OnboardUserMethod, sub, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// GetBootstrapData returns the bootstrap data of the user.
+func (c *Client) GetBootstrapData(ctx context.Context, sub string) (*GetBootstrapDataResponse, error) {
+	resp := &GetBootstrapDataResponse{}
+
+	err := c.invoke(ctx, GetBootstrapDataMethod, sub, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (c *Client) invoke(ctx context.Context, fullMethod, sub string, resp interface{}) error {
+	return c.conn.Invoke(ctx, fullMethod, &UserRequest{Sub: sub}, resp, grpc.CallContentSubtype(CodecName))
+}
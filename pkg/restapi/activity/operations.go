@@ -0,0 +1,287 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package activity
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/query"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/activity"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Endpoints.
+const (
+	activityPath = "/activity"
+)
+
+const (
+	userSubCookieName = "user_sub"
+	typeParam         = "type"
+	sinceParam        = "since"
+	untilParam        = "until"
+)
+
+// the activities may also be filtered with the filter parameter.
+var pageOptions = &query.Options{
+	DefaultLimit: 20,
+	MaxLimit:     100,
+	Fields: map[string]query.FieldType{
+		"type":     query.String,
+		"occurred": query.Time,
+	},
+}
+
+var logger = log.New("edge-agent/activity")
+
+// Subscriber subscribes to the events of the bus.
+type Subscriber interface {
+	Subscribe(handler events.Handler, topics ...string) func()
+}
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Events  Subscriber
+	Storage storage.Provider
+	Keys    *KeyConfig
+	// Topics of the events recorded in the timeline of their user, e.g. the credentials received by the agent.
+	Topics []string
+	// Capacity is the number of activities kept per user. Defaults to activity.DefaultCapacity.
+	Capacity int
+}
+
+// KeyConfig holds configuration for cryptographic keys.
+type KeyConfig struct {
+	Auth []byte
+	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
+}
+
+// Page is a page of the timeline of a user. Next is the cursor of the following page, if any.
+type Page struct {
+	Activities []*activity.Activity `json:"activities"`
+	Next       string               `json:"next,omitempty"`
+}
+
+// Operation records what happens in the wallet of each user, e.g. the credentials saved and the presentations
+// shared, so that the wallet UI shows their recent activity.
+type Operation struct {
+	activities  *activity.Store
+	cookies     cookie.Store
+	unsubscribe func()
+}
+
+// filter selects the activities of a page.
+type filter struct {
+	types  map[string]bool
+	since  time.Time
+	until  time.Time
+	params *query.Params
+}
+
+// New returns a new Operation subscribed to the events of the topics.
+func New(config *Config) (*Operation, error) {
+	if config.Events == nil {
+		return nil, errors.New("missing event bus")
+	}
+
+	if len(config.Topics) == 0 {
+		return nil, errors.New("missing activity topics")
+	}
+
+	activities, err := activity.NewStore(config.Storage, config.Capacity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init activity store: %w", err)
+	}
+
+	o := &Operation{
+		activities: activities,
+		cookies:    cookie.NewStore(config.Keys.Auth, config.Keys.Enc, cookie.WithKeyRing(config.Keys.Ring)),
+	}
+
+	o.unsubscribe = config.Events.Subscribe(o.record, config.Topics...)
+
+	return o, nil
+}
+
+// Close stops recording the activities.
+func (o *Operation) Close() {
+	o.unsubscribe()
+}
+
+// GetRESTHandlers get all controller API handler available for this service.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(activityPath, http.MethodGet, o.listHandler, &common.OperationSpec{
+			Summary: "Lists the activities of the user logged in, from the most recent.",
+			Params: append([]common.Param{
+				common.QueryParam(typeParam, "Type of the activities to list, e.g. credential.received. May be repeated."),
+				common.QueryParam(sinceParam, "Lists the activities that occurred at or after this RFC 3339 time."),
+				common.QueryParam(untilParam, "Lists the activities that occurred before this RFC 3339 time."),
+				common.HeaderParam("If-None-Match", "ETag of the page the client has."),
+			}, pageOptions.Spec("activities")...),
+			Responses: map[int]interface{}{
+				http.StatusOK:          &Page{},
+				http.StatusNotModified: nil,
+			},
+		}),
+	}
+}
+
+// record keeps the event in the timeline of its user. The bus may dispatch an event more than once: the timeline
+// ignores the activities it holds already.
+func (o *Operation) record(e *outbox.Event) {
+	if e.Subject == "" {
+		return
+	}
+
+	err := o.activities.Add(e.Subject, &activity.Activity{
+		ID:       e.ID,
+		Type:     e.Topic,
+		Details:  e.Payload,
+		Occurred: e.Created,
+	})
+	if err != nil {
+		logger.Warnf("failed to record activity of event %s: %s", e.ID, err.Error())
+	}
+}
+
+func (o *Operation) listHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling list activities request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := parseFilter(r.URL.Query())
+	if err != nil {
+		query.WriteError(w, err)
+
+		return
+	}
+
+	all, err := o.activities.List(sub)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	page, err := f.page(all)
+	if err != nil {
+		query.WriteError(w, err)
+
+		return
+	}
+
+	query.SetNextLink(w, r, page.Next)
+	common.WriteConditionalResponse(w, r, logger, page)
+}
+
+func parseFilter(values url.Values) (*filter, error) {
+	params, err := query.Parse(values, pageOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &filter{types: make(map[string]bool), params: params}
+
+	for _, t := range values[typeParam] {
+		f.types[t] = true
+	}
+
+	for param, t := range map[string]*time.Time{sinceParam: &f.since, untilParam: &f.until} {
+		if value := values.Get(param); value != "" {
+			*t, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, &query.ParamError{Param: param, Reason: err.Error()}
+			}
+		}
+	}
+
+	return f, nil
+}
+
+// page returns the activities following the cursor that match the filter. The cursor is the id of the last
+// activity of the previous page, so that the activities recorded in between do not shift the pages.
+func (f *filter) page(all []*activity.Activity) (*Page, error) {
+	matched := make([]*activity.Activity, 0, len(all))
+
+	for _, a := range all {
+		if f.matches(a) {
+			matched = append(matched, a)
+		}
+	}
+
+	start, end, next, err := f.params.Page(len(matched), func(i int) string { return matched[i].ID })
+	if err != nil {
+		return nil, err
+	}
+
+	return &Page{Activities: matched[start:end], Next: next}, nil
+}
+
+func (f *filter) matches(a *activity.Activity) bool {
+	if len(f.types) != 0 && !f.types[a.Type] {
+		return false
+	}
+
+	if !f.since.IsZero() && a.Occurred.Before(f.since) {
+		return false
+	}
+
+	if !f.until.IsZero() && !a.Occurred.Before(f.until) {
+		return false
+	}
+
+	return f.params.Filter.Matches(func(field string) interface{} {
+		switch field {
+		case "type":
+			return a.Type
+		case "occurred":
+			return a.Occurred
+		}
+
+		return nil
+	})
+}
+
+func (o *Operation) userSub(w http.ResponseWriter, r *http.Request) (string, bool) {
+	jar, err := o.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return "", false
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return "", false
+	}
+
+	sub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return "", false
+	}
+
+	return sub, true
+}
@@ -0,0 +1,291 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package activity // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/query"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/activity"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("returns an instance", func(t *testing.T) {
+		o, err := New(config())
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 1)
+	})
+
+	t.Run("error if event bus is missing", func(t *testing.T) {
+		c := config()
+		c.Events = nil
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing event bus")
+	})
+
+	t.Run("error if topics are missing", func(t *testing.T) {
+		c := config()
+		c.Topics = nil
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing activity topics")
+	})
+
+	t.Run("error if store cannot be opened", func(t *testing.T) {
+		c := config()
+		c.Storage = &mockstore.Provider{ErrOpenStoreHandle: errors.New("test")}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init activity store")
+	})
+}
+
+func TestOperation_Record(t *testing.T) {
+	bus := events.NewBus(&events.BusConfig{})
+	c := config()
+	c.Events = bus
+
+	o := newOperation(t, c, "sub")
+
+	expected := newEvent(t, "credential.received", "sub", time.Now())
+	require.NoError(t, bus.Dispatch(expected))
+	require.NoError(t, bus.Dispatch(expected))
+	require.NoError(t, bus.Dispatch(newEvent(t, "user.login", "sub", time.Now())))
+	require.NoError(t, bus.Dispatch(newEvent(t, "credential.received", "", time.Now())))
+
+	page := list(t, o, "")
+	require.Len(t, page.Activities, 1)
+	require.Equal(t, expected.ID, page.Activities[0].ID)
+	require.Equal(t, "credential.received", page.Activities[0].Type)
+	require.JSONEq(t, `{"test":"value"}`, string(page.Activities[0].Details))
+	require.True(t, expected.Created.Equal(page.Activities[0].Occurred))
+
+	o.Close()
+
+	require.NoError(t, bus.Dispatch(newEvent(t, "credential.received", "sub", time.Now())))
+	require.Len(t, list(t, o, "").Activities, 1)
+}
+
+func TestOperation_ListHandler(t *testing.T) {
+	t.Run("pages through the activities from the most recent", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+		ids := record(t, o, 5)
+
+		page := list(t, o, "?limit=2")
+		require.Equal(t, []string{ids[4], ids[3]}, activityIDs(page))
+		require.Equal(t, query.EncodeCursor(ids[3]), page.Next)
+
+		// the activities recorded in between do not shift the pages
+		o.record(newEvent(t, "credential.received", "sub", time.Now().Add(time.Hour)))
+
+		page = list(t, o, "?limit=2&cursor="+page.Next)
+		require.Equal(t, []string{ids[2], ids[1]}, activityIDs(page))
+
+		page = list(t, o, "?limit=2&cursor="+page.Next)
+		require.Equal(t, []string{ids[0]}, activityIDs(page))
+		require.Empty(t, page.Next)
+	})
+
+	t.Run("filters the activities by type and time", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+		start := time.Now().Add(-time.Hour)
+
+		o.record(newEvent(t, "credential.received", "sub", start))
+		o.record(newEvent(t, "presentation.shared", "sub", start.Add(time.Minute)))
+		o.record(newEvent(t, "connection.established", "sub", start.Add(2*time.Minute)))
+		o.record(newEvent(t, "credential.received", "other", start))
+
+		page := list(t, o, "?type=presentation.shared&type=connection.established")
+		require.Len(t, page.Activities, 2)
+		require.Equal(t, "connection.established", page.Activities[0].Type)
+		require.Equal(t, "presentation.shared", page.Activities[1].Type)
+
+		page = list(t, o, "?since="+start.Add(time.Minute).Format(time.RFC3339)+
+			"&until="+start.Add(2*time.Minute).Format(time.RFC3339))
+		require.Len(t, page.Activities, 1)
+		require.Equal(t, "presentation.shared", page.Activities[0].Type)
+
+		require.Empty(t, list(t, o, "?type=unknown").Activities)
+
+		page = list(t, o, "?filter=type!%3Dcredential.received&filter=occurred%3E%3D"+
+			start.Add(2*time.Minute).Format(time.RFC3339))
+		require.Len(t, page.Activities, 1)
+		require.Equal(t, "connection.established", page.Activities[0].Type)
+	})
+
+	t.Run("links the next page", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+		ids := record(t, o, 2)
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, activityPath+"?limit=1", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `</activity?cursor=`+query.EncodeCursor(ids[1])+`&limit=1>; rel="next"`,
+			w.Header().Get("Link"))
+	})
+
+	t.Run("err badrequest if parameters are invalid", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+		record(t, o, 1)
+
+		for query, msg := range map[string]string{
+			"?since=yesterday":  "invalid since parameter",
+			"?until=1":          "invalid until parameter",
+			"?limit=0":          "invalid limit parameter",
+			"?limit=101":        "invalid limit parameter",
+			"?limit=ten":        "invalid limit parameter",
+			"?cursor=unknown":   "unknown or expired cursor",
+			"?filter=id%3D%3D1": "cannot filter by id",
+		} {
+			w := httptest.NewRecorder()
+			o.listHandler(w, httptest.NewRequest(http.MethodGet, activityPath+query, nil))
+			require.Equal(t, http.StatusBadRequest, w.Code, query)
+			require.Contains(t, w.Body.String(), msg, query)
+		}
+	})
+
+	t.Run("err internalservererror if activities cannot be fetched", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+
+		var err error
+
+		o.activities, err = activity.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{"sub": []byte(`{"activities":[]}`)},
+			ErrGet: errors.New("test"),
+		}}, 0)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, activityPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch activities")
+	})
+
+	t.Run("err forbidden if user is not logged in", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{}
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, activityPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "not logged in")
+	})
+
+	t.Run("err badrequest if cookies cannot be opened", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, activityPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "cannot open cookies")
+	})
+
+	t.Run("err internalservererror if user sub cookie is invalid", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: 1},
+		}}
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, activityPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "invalid user sub cookie format")
+	})
+}
+
+func config() *Config {
+	return &Config{
+		Events:  events.NewBus(&events.BusConfig{}),
+		Storage: memstore.NewProvider(),
+		Keys: &KeyConfig{
+			Auth: []byte(uuid.New().String()),
+			Enc:  []byte(uuid.New().String())[:32],
+		},
+		Topics: []string{"credential.received", "presentation.shared", "connection.established"},
+	}
+}
+
+func newOperation(t *testing.T, c *Config, sub string) *Operation {
+	t.Helper()
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+		Cookies: map[interface{}]interface{}{userSubCookieName: sub},
+	}}
+
+	return o
+}
+
+func newEvent(t *testing.T, topic, sub string, created time.Time) *outbox.Event {
+	t.Helper()
+
+	e, err := outbox.NewEvent(topic, sub, map[string]string{"test": "value"})
+	require.NoError(t, err)
+
+	e.Created = created
+
+	return e
+}
+
+// record records n credentials received by the user "sub", a second apart, and returns their ids from the oldest.
+func record(t *testing.T, o *Operation, n int) []string {
+	t.Helper()
+
+	ids := make([]string, 0, n)
+	start := time.Now()
+
+	for i := 0; i < n; i++ {
+		e := newEvent(t, "credential.received", "sub", start.Add(time.Duration(i)*time.Second))
+		o.record(e)
+
+		ids = append(ids, e.ID)
+	}
+
+	return ids
+}
+
+func list(t *testing.T, o *Operation, query string) *Page {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.listHandler(w, httptest.NewRequest(http.MethodGet, activityPath+query, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	page := &Page{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), page))
+
+	return page
+}
+
+func activityIDs(page *Page) []string {
+	ids := make([]string, 0, len(page.Activities))
+
+	for _, a := range page.Activities {
+		ids = append(ids, a.ID)
+	}
+
+	return ids
+}
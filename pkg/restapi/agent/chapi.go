@@ -0,0 +1,104 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+const chapiDataType = "VerifiablePresentation"
+
+// chapiRequest is the credential of a CHAPI get (query) or store (offer) event.
+type chapiRequest struct {
+	Web struct {
+		VerifiablePresentation json.RawMessage `json:"VerifiablePresentation"`
+	} `json:"web"`
+}
+
+type chapiPresentation struct {
+	Query      json.RawMessage `json:"query,omitempty"`
+	Challenge  string          `json:"challenge,omitempty"`
+	Domain     string          `json:"domain,omitempty"`
+	Credential json.RawMessage `json:"verifiableCredential,omitempty"`
+}
+
+// chapiWebCredential is the response handed back to the browser.
+type chapiWebCredential struct {
+	DataType string      `json:"dataType"`
+	Data     interface{} `json:"data"`
+}
+
+func resolveCHAPI(payload json.RawMessage) (*Interaction, error) {
+	request := &chapiRequest{}
+
+	err := json.Unmarshal(payload, request)
+	if err != nil || len(request.Web.VerifiablePresentation) == 0 {
+		return nil, fmt.Errorf("%w: not a chapi verifiable presentation request", errInvalidInteraction)
+	}
+
+	vp := &chapiPresentation{}
+
+	err = json.Unmarshal(request.Web.VerifiablePresentation, vp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid chapi verifiable presentation: %s", errInvalidInteraction, err.Error())
+	}
+
+	interaction := &Interaction{Type: InteractionCHAPI, raw: request.Web.VerifiablePresentation}
+
+	switch {
+	case len(vp.Query) != 0:
+		interaction.Purpose = PurposeRequest
+		interaction.Query = vp.Query
+		interaction.Challenge = vp.Challenge
+		interaction.Domain = vp.Domain
+	case len(vp.Credential) != 0:
+		interaction.Purpose = PurposeOffer
+
+		interaction.Credentials, err = credentialList(vp.Credential)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: chapi request has neither a query nor credentials", errInvalidInteraction)
+	}
+
+	return interaction, nil
+}
+
+// chapiResponse returns the presentation for a query, or acknowledges an offer by echoing it.
+func chapiResponse(interaction *Interaction, presentation string) (json.RawMessage, error) {
+	var data interface{} = interaction.raw
+
+	if interaction.Purpose == PurposeRequest {
+		data = presentation
+	}
+
+	bits, err := json.Marshal(&chapiWebCredential{DataType: chapiDataType, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal chapi response: %w", err)
+	}
+
+	return bits, nil
+}
+
+// credentialList accepts a single credential or an array of them.
+func credentialList(raw json.RawMessage) ([]json.RawMessage, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		return []json.RawMessage{raw}, nil
+	}
+
+	var list []json.RawMessage
+
+	err := json.Unmarshal(raw, &list)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid credentials: %s", errInvalidInteraction, err.Error())
+	}
+
+	return list, nil
+}
@@ -0,0 +1,380 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Credential formats.
+const (
+	formatLDPVC = "ldp_vc"
+	formatJWTVC = "jwt_vc"
+	formatJWTVP = "jwt_vp"
+)
+
+// presentationDefinition describes the credentials a verifier asks for, as defined by DIF Presentation Exchange.
+type presentationDefinition struct {
+	ID               string             `json:"id"`
+	Name             string             `json:"name,omitempty"`
+	Purpose          string             `json:"purpose,omitempty"`
+	InputDescriptors []*inputDescriptor `json:"input_descriptors"`
+}
+
+type inputDescriptor struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+	// Schema is a list of {"uri": ...} objects, or a single one in older drafts of the specification.
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	Constraints *constraints    `json:"constraints,omitempty"`
+}
+
+type constraints struct {
+	Fields []*field `json:"fields,omitempty"`
+}
+
+type field struct {
+	Path     []string     `json:"path"`
+	Filter   *fieldFilter `json:"filter,omitempty"`
+	Optional bool         `json:"optional,omitempty"`
+}
+
+// fieldFilter is the subset of JSON Schema supported in field filters.
+type fieldFilter struct {
+	Type     string        `json:"type,omitempty"`
+	Const    interface{}   `json:"const,omitempty"`
+	Enum     []interface{} `json:"enum,omitempty"`
+	Pattern  string        `json:"pattern,omitempty"`
+	Contains *fieldFilter  `json:"contains,omitempty"`
+}
+
+type schemaURI struct {
+	URI string `json:"uri"`
+}
+
+// storedCredential is a credential of the user's vault decoded for matching.
+type storedCredential struct {
+	id     string
+	format string
+	raw    json.RawMessage
+	// docs are the JSON documents the descriptor paths are evaluated against. The claims of a JWT
+	// credential are matched along with its "vc" claim, since verifiers use both styles of path.
+	docs []interface{}
+}
+
+// decodeStoredCredential decodes a JSON-LD credential, or a JWT credential saved as a JSON string.
+func decodeStoredCredential(id string, raw []byte) (*storedCredential, error) {
+	var doc interface{}
+
+	err := json.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential: %w", err)
+	}
+
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		return &storedCredential{id: id, format: formatLDPVC, raw: raw, docs: []interface{}{v}}, nil
+	case string:
+		claims := make(map[string]interface{})
+
+		err = decodeJWTClaims(v, &claims)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwt credential: %w", err)
+		}
+
+		docs := []interface{}{claims}
+
+		if vc, ok := claims["vc"]; ok {
+			docs = append(docs, vc)
+		}
+
+		return &storedCredential{id: id, format: formatJWTVC, raw: raw, docs: docs}, nil
+	default:
+		return nil, fmt.Errorf("unsupported credential format")
+	}
+}
+
+// matches tells whether the credential satisfies the schema and the required fields of the descriptor.
+func (d *inputDescriptor) matches(credential *storedCredential) (bool, error) {
+	uris, err := d.schemaURIs()
+	if err != nil {
+		return false, err
+	}
+
+	for _, doc := range credential.docs {
+		if len(uris) > 0 && !hasSchema(doc, uris) {
+			continue
+		}
+
+		ok, errMatch := d.fieldsMatch(doc)
+		if errMatch != nil || ok {
+			return ok, errMatch
+		}
+	}
+
+	return false, nil
+}
+
+func (d *inputDescriptor) schemaURIs() ([]string, error) {
+	if len(d.Schema) == 0 {
+		return nil, nil
+	}
+
+	var list []*schemaURI
+
+	if json.Unmarshal(d.Schema, &list) != nil {
+		single := &schemaURI{}
+
+		err := json.Unmarshal(d.Schema, single)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema of input descriptor %s: %w", d.ID, err)
+		}
+
+		list = []*schemaURI{single}
+	}
+
+	uris := make([]string, 0, len(list))
+
+	for _, s := range list {
+		if s != nil && s.URI != "" {
+			uris = append(uris, s.URI)
+		}
+	}
+
+	return uris, nil
+}
+
+func (d *inputDescriptor) fieldsMatch(doc interface{}) (bool, error) {
+	if d.Constraints == nil {
+		return true, nil
+	}
+
+	for _, f := range d.Constraints.Fields {
+		if f.Optional {
+			continue
+		}
+
+		ok, err := f.matches(doc)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// matches tells whether any of the paths of the field selects a value accepted by its filter.
+func (f *field) matches(doc interface{}) (bool, error) {
+	for _, path := range f.Path {
+		values, err := selectPath(doc, path)
+		if err != nil {
+			return false, err
+		}
+
+		for _, v := range values {
+			if f.Filter == nil || f.Filter.accepts(v) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func (f *fieldFilter) accepts(v interface{}) bool {
+	if f.Type != "" && !hasJSONType(v, f.Type) {
+		return false
+	}
+
+	if f.Const != nil && !reflect.DeepEqual(f.Const, v) {
+		return false
+	}
+
+	if len(f.Enum) > 0 && !containsValue(f.Enum, v) {
+		return false
+	}
+
+	if f.Pattern != "" {
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+
+		matched, err := regexp.MatchString(f.Pattern, s)
+		if err != nil || !matched {
+			return false
+		}
+	}
+
+	if f.Contains != nil {
+		list, ok := v.([]interface{})
+		if !ok {
+			return false
+		}
+
+		for _, item := range list {
+			if f.Contains.accepts(item) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func hasJSONType(v interface{}, jsonType string) bool {
+	switch jsonType {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == float64(int64(n))
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	default:
+		return false
+	}
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasSchema tells whether one of the schema URIs is a context or a type of the credential.
+// Types are also matched as the fragment or the last path segment of a URI.
+func hasSchema(doc interface{}, uris []string) bool {
+	credential, ok := doc.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	var values []string
+
+	for _, key := range []string{"@context", "type"} {
+		switch v := credential[key].(type) {
+		case string:
+			values = append(values, v)
+		case []interface{}:
+			for _, item := range v {
+				if str, isString := item.(string); isString {
+					values = append(values, str)
+				}
+			}
+		}
+	}
+
+	for _, uri := range uris {
+		for _, v := range values {
+			if uri == v || strings.HasSuffix(uri, "#"+v) || strings.HasSuffix(uri, "/"+v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// selectPath evaluates the subset of JSONPath used by input descriptors: member names in dot or bracket
+// notation, array indexes and wildcards.
+func selectPath(doc interface{}, path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, "$") || strings.Contains(path, "..") || strings.Contains(path, "[?") {
+		return nil, fmt.Errorf("%w: unsupported json path '%s'", errInvalidPresentationRequest, path)
+	}
+
+	nodes := []interface{}{doc}
+	rest := path[1:]
+
+	for rest != "" {
+		var (
+			selector string
+			quoted   bool
+		)
+
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+
+			selector, rest = rest[1:end], rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("%w: invalid json path '%s'", errInvalidPresentationRequest, path)
+			}
+
+			selector, rest = rest[1:end], rest[end+1:]
+
+			if unquoted := strings.Trim(selector, `'"`); len(unquoted) == len(selector)-2 {
+				selector, quoted = unquoted, true
+			}
+		default:
+			return nil, fmt.Errorf("%w: invalid json path '%s'", errInvalidPresentationRequest, path)
+		}
+
+		if selector == "" {
+			return nil, fmt.Errorf("%w: invalid json path '%s'", errInvalidPresentationRequest, path)
+		}
+
+		nodes = selectChildren(nodes, selector, quoted)
+	}
+
+	return nodes, nil
+}
+
+func selectChildren(nodes []interface{}, selector string, quoted bool) []interface{} {
+	var children []interface{}
+
+	wildcard := selector == "*" && !quoted
+
+	for _, node := range nodes {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			if wildcard {
+				for _, child := range v {
+					children = append(children, child)
+				}
+			} else if child, ok := v[selector]; ok {
+				children = append(children, child)
+			}
+		case []interface{}:
+			if wildcard {
+				children = append(children, v...)
+			} else if i, err := strconv.Atoi(selector); err == nil && !quoted && i >= 0 && i < len(v) {
+				children = append(children, v[i])
+			}
+		}
+	}
+
+	return children
+}
@@ -0,0 +1,119 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectPath(t *testing.T) {
+	doc := make(map[string]interface{})
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": ["VerifiableCredential", "UniversityDegreeCredential"],
+		"credentialSubject": {"degree": {"type": "BachelorDegree"}, "first-name": "Jayden"}
+	}`), &doc))
+
+	t.Run("selects values", func(t *testing.T) {
+		for path, expected := range map[string][]interface{}{
+			"$.credentialSubject.degree.type":      {"BachelorDegree"},
+			"$['credentialSubject']['first-name']": {"Jayden"},
+			"$.type[1]":                            {"UniversityDegreeCredential"},
+			"$.type[*]":                            {"VerifiableCredential", "UniversityDegreeCredential"},
+			"$.credentialSubject.*.type":           {"BachelorDegree"},
+			"$.type[2]":                            nil,
+			"$.issuer":                             nil,
+		} {
+			values, err := selectPath(doc, path)
+			require.NoError(t, err, path)
+			require.Equal(t, expected, values, path)
+		}
+	})
+
+	t.Run("error if path is not supported", func(t *testing.T) {
+		for _, path := range []string{"type", "$..type", "$.type[?(@ == 'x')]", "$.type[0", "$.", "$type"} {
+			_, err := selectPath(doc, path)
+			require.True(t, errors.Is(err, errInvalidPresentationRequest), path)
+		}
+	})
+}
+
+func TestFieldFilter(t *testing.T) {
+	for _, test := range []struct {
+		filter string
+		value  interface{}
+		accept bool
+	}{
+		{`{"type": "string"}`, "value", true},
+		{`{"type": "string"}`, 1.0, false},
+		{`{"type": "integer"}`, 1.0, true},
+		{`{"type": "integer"}`, 1.5, false},
+		{`{"type": "boolean"}`, true, true},
+		{`{"type": "object"}`, []interface{}{}, false},
+		{`{"type": "unknown"}`, "value", false},
+		{`{"const": "value"}`, "value", true},
+		{`{"const": "value"}`, "other", false},
+		{`{"enum": ["a", "b"]}`, "b", true},
+		{`{"enum": ["a", "b"]}`, "c", false},
+		{`{"pattern": "^did:example:"}`, "did:example:123", true},
+		{`{"pattern": "^did:example:"}`, "did:key:123", false},
+		{`{"pattern": "("}`, "(", false},
+		{`{"pattern": "."}`, 1.0, false},
+		{`{"contains": {"const": "b"}}`, []interface{}{"a", "b"}, true},
+		{`{"contains": {"const": "c"}}`, []interface{}{"a", "b"}, false},
+		{`{"contains": {"const": "a"}}`, "a", false},
+	} {
+		filter := &fieldFilter{}
+		require.NoError(t, json.Unmarshal([]byte(test.filter), filter))
+		require.Equal(t, test.accept, filter.accepts(test.value), test.filter)
+	}
+}
+
+func TestInputDescriptor_Matches(t *testing.T) {
+	degree, err := decodeStoredCredential("urn:uuid:degree", []byte(degreeCredential))
+	require.NoError(t, err)
+
+	t.Run("matches the schema", func(t *testing.T) {
+		for schema, expected := range map[string]bool{
+			`[{"uri": "https://www.w3.org/2018/credentials/v1"}]`:                 true,
+			`{"uri": "https://example.com/schemas/UniversityDegreeCredential"}`:   true,
+			`[{"uri": "https://example.com/schemas#UniversityDegreeCredential"}]`: true,
+			`[{"uri": "https://example.com/schemas/PermanentResidentCard"}, {}]`:  false,
+		} {
+			ok, err := (&inputDescriptor{Schema: json.RawMessage(schema)}).matches(degree)
+			require.NoError(t, err)
+			require.Equal(t, expected, ok, schema)
+		}
+	})
+
+	t.Run("skips optional fields", func(t *testing.T) {
+		descriptor := &inputDescriptor{Constraints: &constraints{Fields: []*field{
+			{Path: []string{"$.credentialSubject.id"}},
+			{Path: []string{"$.expirationDate"}, Optional: true},
+		}}}
+
+		ok, err := descriptor.matches(degree)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("error if schema is invalid", func(t *testing.T) {
+		_, err := (&inputDescriptor{Schema: json.RawMessage(`"uri"`)}).matches(degree)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid schema of input descriptor")
+	})
+}
+
+func TestDecodeStoredCredential(t *testing.T) {
+	for _, raw := range []string{"{", "42", `"not a jwt"`} {
+		_, err := decodeStoredCredential("urn:uuid:1", []byte(raw))
+		require.Error(t, err, raw)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Event topics published by the agent.
+const (
+	TopicDIDCommMessage        = "didcomm.message"
+	TopicCredentialReceived    = "credential.received"
+	TopicPresentationShared    = "presentation.shared"
+	TopicConnectionEstablished = "connection.established"
+	// TopicCredentialTransfer is published to both the sender and the recipient of a credential on every change of
+	// the status of its transfer.
+	TopicCredentialTransfer = "credential.transfer"
+)
+
+const connectionsStoreName = "edgeagent_connections"
+
+// EventsConfig enables the events of the agent.
+type EventsConfig struct {
+	Dispatcher outbox.Dispatcher
+	// Storage keeps track of the user owning each connection, so that DIDComm messages can be attributed to them.
+	Storage storage.Provider
+}
+
+type didcommMessagePayload struct {
+	ConnectionID string `json:"connectionID"`
+	Type         string `json:"type"`
+	State        string `json:"state"`
+}
+
+type credentialReceivedPayload struct {
+	ID     string `json:"id"`
+	Format string `json:"format"`
+	Issuer string `json:"issuer,omitempty"`
+}
+
+type presentationSharedPayload struct {
+	ID       string `json:"id"`
+	Verifier string `json:"verifier"`
+	Name     string `json:"name,omitempty"`
+}
+
+type credentialTransferPayload struct {
+	ID           string `json:"id"`
+	Direction    string `json:"direction"`
+	Status       string `json:"status"`
+	ConnectionID string `json:"connectionID"`
+	CredentialID string `json:"credentialID,omitempty"`
+}
+
+type connectionEstablishedPayload struct {
+	ConnectionID string `json:"connectionID"`
+}
+
+func (o *Operation) saveConnectionOwner(connectionID, sub string) error {
+	if o.events == nil {
+		return nil
+	}
+
+	err := o.connectionOwners.Put(connectionID, []byte(sub))
+	if err != nil {
+		return fmt.Errorf("failed to save owner of connection %s: %w", connectionID, err)
+	}
+
+	return nil
+}
+
+func (o *Operation) watchExchange(states <-chan service.StateMsg) {
+	for msg := range states {
+		o.handleStateMsg(msg)
+	}
+}
+
+// handleStateMsg notifies the owner of the connection of each message processed by the did-exchange protocol, and
+// of the connection once established.
+func (o *Operation) handleStateMsg(msg service.StateMsg) {
+	if msg.Type != service.PostState || msg.Msg == nil || msg.Properties == nil {
+		return
+	}
+
+	connectionID, ok := msg.Properties.All()["connectionID"].(string)
+	if !ok || connectionID == "" {
+		return
+	}
+
+	sub, err := o.connectionOwners.Get(connectionID)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		logger.Debugf("ignoring message of connection %s without owner", connectionID)
+
+		return
+	}
+
+	if err != nil {
+		logger.Warnf("failed to fetch owner of connection %s: %s", connectionID, err.Error())
+
+		return
+	}
+
+	o.notify(TopicDIDCommMessage, string(sub), &didcommMessagePayload{
+		ConnectionID: connectionID,
+		Type:         msg.Msg.Type(),
+		State:        msg.StateID,
+	})
+
+	if msg.StateID == didexchange.StateIDCompleted {
+		o.notify(TopicConnectionEstablished, string(sub), &connectionEstablishedPayload{ConnectionID: connectionID})
+	}
+}
+
+// notify publishes an event of the user in the background. The notifications are only retried if the Dispatcher
+// enqueues them in the outbox, as the server does.
+func (o *Operation) notify(topic, sub string, payload interface{}) {
+	if o.events == nil {
+		return
+	}
+
+	e, err := outbox.NewEvent(topic, sub, payload)
+	if err != nil {
+		logger.Warnf("failed to create %s event: %s", topic, err.Error())
+
+		return
+	}
+
+	go func() {
+		errDispatch := o.events.Dispatcher.Dispatch(e)
+		if errDispatch != nil {
+			logger.Warnf("failed to dispatch event %s: %s", e.ID, errDispatch.Error())
+		}
+	}()
+}
@@ -0,0 +1,300 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/client/outofband"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	protocoldidexchange "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	mockdidexchange "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/protocol/didexchange"
+	mockprovider "github.com/hyperledger/aries-framework-go/pkg/mock/provider"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+const responseMsgType = "https://didcomm.org/didexchange/1.0/response"
+
+func TestNew_Events(t *testing.T) {
+	t.Run("watches the did-exchange messages", func(t *testing.T) {
+		c := config()
+		c.Events = &EventsConfig{Dispatcher: newMockDispatcher(), Storage: memstore.NewProvider()}
+
+		o, err := New(c)
+		require.NoError(t, err)
+		require.NotNil(t, o.connectionOwners)
+	})
+
+	t.Run("error if connections store cannot be opened", func(t *testing.T) {
+		c := config()
+		c.Events = &EventsConfig{Storage: &mockstore.Provider{ErrCreateStore: errors.New("test")}}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open connections store")
+	})
+
+	t.Run("error if did-exchange messages cannot be registered", func(t *testing.T) {
+		c := config()
+		c.Events = &EventsConfig{Storage: memstore.NewProvider()}
+		c.Aries.(*mockprovider.Provider).ServiceMap[protocoldidexchange.DIDExchange] =
+			&mockdidexchange.MockDIDExchangeSvc{RegisterMsgEventErr: errors.New("test")}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to register for did-exchange messages")
+	})
+}
+
+func TestOperation_DIDCommMessageEvent(t *testing.T) {
+	t.Run("notifies the user who accepted the invitation", func(t *testing.T) {
+		o, dispatcher := newEventsOperation(t)
+		sub := acceptInvitation(t, o, "connection")
+
+		o.handleStateMsg(stateMsg(service.PostState, "connection"))
+
+		e := dispatcher.next(t)
+		require.Equal(t, TopicDIDCommMessage, e.Topic)
+		require.Equal(t, sub, e.Subject)
+		require.JSONEq(t,
+			`{"connectionID":"connection","type":"`+responseMsgType+`","state":"responded"}`, string(e.Payload))
+	})
+
+	t.Run("notifies the user once the connection is established", func(t *testing.T) {
+		o, dispatcher := newEventsOperation(t)
+		sub := acceptInvitation(t, o, "connection")
+
+		msg := stateMsg(service.PostState, "connection")
+		msg.StateID = protocoldidexchange.StateIDCompleted
+
+		o.handleStateMsg(msg)
+
+		events := map[string]*outbox.Event{}
+
+		for i := 0; i < 2; i++ {
+			e := dispatcher.next(t)
+			events[e.Topic] = e
+		}
+
+		require.Contains(t, events, TopicDIDCommMessage)
+		require.Equal(t, sub, events[TopicConnectionEstablished].Subject)
+		require.JSONEq(t, `{"connectionID":"connection"}`, string(events[TopicConnectionEstablished].Payload))
+	})
+
+	t.Run("ignores messages that are not of a user", func(t *testing.T) {
+		o, dispatcher := newEventsOperation(t)
+		acceptInvitation(t, o, "connection")
+
+		o.handleStateMsg(stateMsg(service.PreState, "connection"))
+		o.handleStateMsg(stateMsg(service.PostState, "other"))
+		o.handleStateMsg(service.StateMsg{Type: service.PostState})
+
+		dispatcher.none(t)
+	})
+
+	t.Run("ignores messages if owner cannot be fetched", func(t *testing.T) {
+		o, dispatcher := newEventsOperation(t)
+
+		var err error
+
+		o.connectionOwners, err = (&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{"connection": []byte("sub")}, ErrGet: errors.New("test"),
+		}}).OpenStore(connectionsStoreName)
+		require.NoError(t, err)
+
+		o.handleStateMsg(stateMsg(service.PostState, "connection"))
+
+		dispatcher.none(t)
+	})
+
+	t.Run("internal server error if connection owner cannot be saved", func(t *testing.T) {
+		o, _ := newEventsOperation(t)
+		o.oob = &mockOOBClient{acceptFunc: func(*outofband.Invitation, string) (string, error) {
+			return "connection", nil
+		}}
+
+		var err error
+
+		o.connectionOwners, err = (&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{}, ErrPut: errors.New("test"),
+		}}).OpenStore(connectionsStoreName)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.acceptInvitationHandler(w, httptest.NewRequest(http.MethodPost, invitationsPath,
+			bytes.NewReader(marshal(t, &AcceptInvitationRequest{Invitation: &outofband.Invitation{}}))))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save owner of connection connection")
+	})
+}
+
+func TestOperation_CredentialReceivedEvent(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	o, sub := newOIDC4VCIOperation(t)
+	dispatcher := newMockDispatcher()
+	o.events = &EventsConfig{Dispatcher: dispatcher}
+
+	response := &RedeemOfferResponse{}
+	w := redeemAs(t, o, sub, &RedeemOfferRequest{
+		Offer:  issuer.offerURI(t, issuer.preAuthorizedOffer(false)),
+		Holder: holderDID,
+	}, response)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	ids := make([]string, 0, len(response.Credentials))
+
+	for range response.Credentials {
+		e := dispatcher.next(t)
+		require.Equal(t, TopicCredentialReceived, e.Topic)
+		require.Equal(t, sub, e.Subject)
+
+		payload := &credentialReceivedPayload{}
+		require.NoError(t, json.Unmarshal(e.Payload, payload))
+		require.Equal(t, issuer.URL, payload.Issuer)
+
+		ids = append(ids, payload.ID)
+	}
+
+	require.ElementsMatch(t, []string{response.Credentials[0].ID, response.Credentials[1].ID}, ids)
+}
+
+func TestOperation_PresentationSharedEvent(t *testing.T) {
+	verifier := newMockVerifier(t)
+	defer verifier.Close()
+
+	o, sub := newOIDC4VPOperation(t)
+	dispatcher := newMockDispatcher()
+	o.events = &EventsConfig{Dispatcher: dispatcher}
+
+	id := resolveDegreeRequest(t, o, sub, presentationRequestParams(verifier.URL+"/response"))
+
+	w := submitAs(t, o, sub, &SubmitPresentationRequest{ID: id, Holder: holderDID}, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	e := dispatcher.next(t)
+	require.Equal(t, TopicPresentationShared, e.Topic)
+	require.Equal(t, sub, e.Subject)
+
+	payload := &presentationSharedPayload{}
+	require.NoError(t, json.Unmarshal(e.Payload, payload))
+	require.Equal(t, id, payload.ID)
+	require.Equal(t, verifierID, payload.Verifier)
+
+	// the presentations the verifier rejects are not shared
+	verifier.status = http.StatusBadRequest
+	id = resolveDegreeRequest(t, o, sub, presentationRequestParams(verifier.URL+"/response"))
+
+	w = submitAs(t, o, sub, &SubmitPresentationRequest{ID: id, Holder: holderDID}, nil)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	dispatcher.none(t)
+}
+
+func newEventsOperation(t *testing.T) (*Operation, *mockDispatcher) {
+	t.Helper()
+
+	dispatcher := newMockDispatcher()
+
+	c := config()
+	c.Events = &EventsConfig{Dispatcher: dispatcher, Storage: memstore.NewProvider()}
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{
+		userSubCookieName: uuid.New().String(),
+	}}}
+
+	return o, dispatcher
+}
+
+// acceptInvitation accepts an invitation as a new user and returns their sub.
+func acceptInvitation(t *testing.T, o *Operation, connectionID string) string {
+	t.Helper()
+
+	sub := uuid.New().String()
+
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{
+		userSubCookieName: sub,
+	}}}
+	o.oob = &mockOOBClient{acceptFunc: func(*outofband.Invitation, string) (string, error) {
+		return connectionID, nil
+	}}
+
+	w := httptest.NewRecorder()
+	o.acceptInvitationHandler(w, httptest.NewRequest(http.MethodPost, invitationsPath,
+		bytes.NewReader(marshal(t, &AcceptInvitationRequest{Invitation: &outofband.Invitation{}}))))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	return sub
+}
+
+func stateMsg(msgType service.StateMsgType, connectionID string) service.StateMsg {
+	return service.StateMsg{
+		ProtocolName: protocoldidexchange.DIDExchange,
+		Type:         msgType,
+		StateID:      "responded",
+		Msg:          service.DIDCommMsgMap{"@type": responseMsgType},
+		Properties:   mockProperties{"connectionID": connectionID},
+	}
+}
+
+type mockProperties map[string]interface{}
+
+func (m mockProperties) All() map[string]interface{} {
+	return m
+}
+
+type mockDispatcher struct {
+	events chan *outbox.Event
+}
+
+func newMockDispatcher() *mockDispatcher {
+	return &mockDispatcher{events: make(chan *outbox.Event, 10)}
+}
+
+func (m *mockDispatcher) Dispatch(e *outbox.Event) error {
+	m.events <- e
+
+	return nil
+}
+
+func (m *mockDispatcher) next(t *testing.T) *outbox.Event {
+	t.Helper()
+
+	select {
+	case e := <-m.events:
+		return e
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for event")
+
+		return nil
+	}
+}
+
+func (m *mockDispatcher) none(t *testing.T) {
+	t.Helper()
+
+	select {
+	case e := <-m.events:
+		require.FailNow(t, "unexpected event", e.Topic)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	webcrypto "github.com/hyperledger/aries-framework-go/pkg/crypto/webkms"
+	arieshttp "github.com/hyperledger/aries-framework-go/pkg/didcomm/transport/http"
+	"github.com/hyperledger/aries-framework-go/pkg/framework/aries"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/webkms"
+	"github.com/hyperledger/aries-framework-go/pkg/storage/mem"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/mtls"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/proxy"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	agentStoreName   = "edgeagent_agent"
+	keystoreKey      = "keystore_url"
+	keystoreOwner    = "edge-agent"
+	keystoreLocation = "Location"
+)
+
+// FrameworkConfig holds the configuration of the embedded Aries agent.
+type FrameworkConfig struct {
+	OpsKMSURL string
+	TLSConfig *tls.Config
+	// ClientCertificate is presented to the ops KMS if it requires mutual TLS.
+	ClientCertificate mtls.ClientCertificateFunc
+	// Proxy routes the requests to the ops KMS through an outbound proxy. They connect directly if not set.
+	Proxy   proxy.Func
+	Storage storage.Provider
+	Inbound *Inbound
+}
+
+// NewFramework returns an Aries framework whose keys are kept in a keystore on the ops KMS.
+// The keystore is created on first start and its URL is kept in storage.
+func NewFramework(config *FrameworkConfig) (*aries.Aries, error) {
+	httpClient := kmsHTTPClient(config)
+
+	keystoreURL, err := agentKeystore(httpClient, config.OpsKMSURL, config.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open agent keystore: %w", err)
+	}
+
+	outbound, err := arieshttp.NewOutbound(arieshttp.WithOutboundTLSConfig(config.TLSConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbound transport: %w", err)
+	}
+
+	framework, err := aries.New(
+		aries.WithStoreProvider(mem.NewProvider()),
+		aries.WithProtocolStateStoreProvider(mem.NewProvider()),
+		aries.WithKMS(func(kms.Provider) (kms.KeyManager, error) {
+			return webkms.New(keystoreURL, httpClient), nil
+		}),
+		aries.WithCrypto(webcrypto.New(keystoreURL, httpClient)),
+		aries.WithInboundTransport(config.Inbound),
+		aries.WithOutboundTransports(outbound),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start aries framework: %w", err)
+	}
+
+	return framework, nil
+}
+
+// kmsHTTPClient returns the client of the requests to the ops KMS.
+func kmsHTTPClient(config *FrameworkConfig) *http.Client {
+	kmsTLSConfig := config.TLSConfig
+	if config.ClientCertificate != nil {
+		kmsTLSConfig = mtls.WithClientCertificate(config.TLSConfig, config.ClientCertificate)
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: kmsTLSConfig, Proxy: config.Proxy}}
+}
+
+func agentKeystore(httpClient *http.Client, opsKMSURL string, p storage.Provider) (string, error) {
+	s, err := store.Open(p, agentStoreName)
+	if err != nil {
+		return "", fmt.Errorf("failed to open store: %w", err)
+	}
+
+	keystoreURL, err := s.Get(keystoreKey)
+	if err == nil {
+		return string(keystoreURL), nil
+	}
+
+	if !errors.Is(err, storage.ErrValueNotFound) {
+		return "", fmt.Errorf("failed to read keystore url: %w", err)
+	}
+
+	url, err := webkms.CreateKeyStore(httpClient, opsKMSURL, keystoreOwner, "", json.Marshal)
+	if err != nil {
+		return "", fmt.Errorf("failed to create keystore: %w", err)
+	}
+
+	if url == "" {
+		return "", fmt.Errorf("ops kms did not return the %s of the new keystore", keystoreLocation)
+	}
+
+	err = s.Put(keystoreKey, []byte(url))
+	if err != nil {
+		return "", fmt.Errorf("failed to save keystore url: %w", err)
+	}
+
+	return url, nil
+}
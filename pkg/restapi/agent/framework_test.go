@@ -0,0 +1,154 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal functions
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNewFramework(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		kms := mockKMS(t, "https://kms.example.com/kms/keystores/123")
+		defer kms.Close()
+
+		inbound := NewInbound("https://agent.example.com/didcomm")
+
+		framework, err := NewFramework(&FrameworkConfig{
+			OpsKMSURL: kms.URL,
+			Storage:   memstore.NewProvider(),
+			Inbound:   inbound,
+		})
+		require.NoError(t, err)
+
+		defer func() {
+			require.NoError(t, framework.Close())
+		}()
+
+		ctx, err := framework.Context()
+		require.NoError(t, err)
+		require.Equal(t, inbound.Endpoint(), ctx.ServiceEndpoint())
+
+		_, err = New(&Config{Aries: ctx, Keys: &KeyConfig{}})
+		require.NoError(t, err)
+	})
+
+	t.Run("presents the client certificate to the ops kms", func(t *testing.T) {
+		kms := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Len(t, r.TLS.PeerCertificates, 1)
+
+			w.Header().Set(keystoreLocation, "https://kms.example.com/kms/keystores/123")
+			w.WriteHeader(http.StatusCreated)
+		}))
+		kms.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+		kms.StartTLS()
+
+		defer kms.Close()
+
+		framework, err := NewFramework(&FrameworkConfig{
+			OpsKMSURL: kms.URL,
+			TLSConfig: kms.Client().Transport.(*http.Transport).TLSClientConfig,
+			ClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &kms.TLS.Certificates[0], nil
+			},
+			Storage: memstore.NewProvider(),
+			Inbound: NewInbound("https://agent.example.com/didcomm"),
+		})
+		require.NoError(t, err)
+		require.NoError(t, framework.Close())
+	})
+
+	t.Run("error if keystore cannot be created", func(t *testing.T) {
+		_, err := NewFramework(&FrameworkConfig{
+			OpsKMSURL: "http://localhost:-1",
+			Storage:   memstore.NewProvider(),
+			Inbound:   NewInbound("https://agent.example.com/didcomm"),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open agent keystore")
+	})
+}
+
+func TestAgentKeystore(t *testing.T) {
+	t.Run("creates the keystore once", func(t *testing.T) {
+		keystoreURL := "https://kms.example.com/kms/keystores/123"
+		requests := 0
+
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+
+			w.Header().Set(keystoreLocation, keystoreURL)
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer kms.Close()
+
+		p := memstore.NewProvider()
+
+		for i := 0; i < 2; i++ {
+			result, err := agentKeystore(http.DefaultClient, kms.URL, p)
+			require.NoError(t, err)
+			require.Equal(t, keystoreURL, result)
+		}
+
+		require.Equal(t, 1, requests)
+	})
+
+	t.Run("error if kms does not return the keystore url", func(t *testing.T) {
+		kms := mockKMS(t, "")
+		defer kms.Close()
+
+		_, err := agentKeystore(http.DefaultClient, kms.URL, memstore.NewProvider())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "did not return the Location")
+	})
+
+	t.Run("error if store cannot be opened", func(t *testing.T) {
+		_, err := agentKeystore(http.DefaultClient, "", &mockstore.Provider{ErrCreateStore: errors.New("test")})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open store")
+	})
+
+	t.Run("error if keystore url cannot be read", func(t *testing.T) {
+		_, err := agentKeystore(http.DefaultClient, "", &mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{keystoreKey: []byte("https://kms.example.com/kms/keystores/123")},
+			ErrGet: errors.New("test"),
+		}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read keystore url")
+	})
+
+	t.Run("error if keystore url cannot be saved", func(t *testing.T) {
+		kms := mockKMS(t, "https://kms.example.com/kms/keystores/123")
+		defer kms.Close()
+
+		_, err := agentKeystore(http.DefaultClient, kms.URL, &mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  make(map[string][]byte),
+			ErrPut: errors.New("test"),
+		}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save keystore url")
+	})
+}
+
+func mockKMS(t *testing.T, keystoreURL string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if keystoreURL != "" {
+			w.Header().Set(keystoreLocation, keystoreURL)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+}
@@ -0,0 +1,71 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	arieshttp "github.com/hyperledger/aries-framework-go/pkg/didcomm/transport/http"
+)
+
+// Inbound is a DIDComm HTTP inbound transport served by the wallet server's own router instead of
+// a dedicated listener.
+type Inbound struct {
+	endpoint string
+	handler  http.Handler
+	mutex    sync.RWMutex
+}
+
+// NewInbound returns an inbound transport reachable by other agents at the given endpoint.
+func NewInbound(endpoint string) *Inbound {
+	return &Inbound{endpoint: endpoint}
+}
+
+// Start is called by the framework with the handler of inbound messages.
+func (i *Inbound) Start(prov transport.Provider) error {
+	handler, err := arieshttp.NewInboundHandler(prov)
+	if err != nil {
+		return err
+	}
+
+	i.mutex.Lock()
+	i.handler = handler
+	i.mutex.Unlock()
+
+	return nil
+}
+
+// Stop stops accepting messages.
+func (i *Inbound) Stop() error {
+	i.mutex.Lock()
+	i.handler = nil
+	i.mutex.Unlock()
+
+	return nil
+}
+
+// Endpoint returns the public endpoint of the transport.
+func (i *Inbound) Endpoint() string {
+	return i.endpoint
+}
+
+// ServeHTTP hands DIDComm messages to the framework.
+func (i *Inbound) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i.mutex.RLock()
+	handler := i.handler
+	i.mutex.RUnlock()
+
+	if handler == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	handler.ServeHTTP(w, r)
+}
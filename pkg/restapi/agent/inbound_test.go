@@ -0,0 +1,77 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	commontransport "github.com/hyperledger/aries-framework-go/pkg/didcomm/common/transport"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/transport"
+	mockpackager "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/packager"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/agent"
+)
+
+func TestInbound(t *testing.T) {
+	t.Run("hands messages to the framework once started", func(t *testing.T) {
+		inbound := agent.NewInbound("https://agent.example.com/didcomm")
+		require.Equal(t, "https://agent.example.com/didcomm", inbound.Endpoint())
+
+		w := httptest.NewRecorder()
+		inbound.ServeHTTP(w, didcommRequest())
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		received := make(chan []byte, 1)
+
+		require.NoError(t, inbound.Start(&mockTransportProvider{handler: func(msg []byte, _, _ string) error {
+			received <- msg
+
+			return nil
+		}}))
+
+		w = httptest.NewRecorder()
+		inbound.ServeHTTP(w, didcommRequest())
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Equal(t, "message", string(<-received))
+
+		require.NoError(t, inbound.Stop())
+
+		w = httptest.NewRecorder()
+		inbound.ServeHTTP(w, didcommRequest())
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("error if there is no message handler", func(t *testing.T) {
+		require.Error(t, agent.NewInbound("").Start(&mockTransportProvider{}))
+	})
+}
+
+func didcommRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/didcomm", bytes.NewReader([]byte("packed")))
+	r.Header.Set("Content-Type", "application/didcomm-envelope-enc")
+
+	return r
+}
+
+type mockTransportProvider struct {
+	handler transport.InboundMessageHandler
+}
+
+func (m *mockTransportProvider) InboundMessageHandler() transport.InboundMessageHandler {
+	return m.handler
+}
+
+func (m *mockTransportProvider) Packager() commontransport.Packager {
+	return &mockpackager.Packager{UnpackValue: &commontransport.Envelope{Message: []byte("message")}}
+}
+
+func (m *mockTransportProvider) AriesFrameworkID() string {
+	return "test"
+}
@@ -0,0 +1,192 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+)
+
+// Interaction endpoints.
+const (
+	resolveInteractionPath = "/interactions/resolve"
+	respondInteractionPath = "/interactions/respond"
+)
+
+// Interaction protocols.
+const (
+	InteractionCHAPI = "chapi"
+	InteractionWACI  = "waci"
+)
+
+// Interaction purposes.
+const (
+	PurposeOffer   = "offer"
+	PurposeRequest = "request"
+)
+
+var errInvalidInteraction = errors.New("invalid interaction")
+
+// InteractionRequest carries a CHAPI or WACI payload received by the wallet UI.
+type InteractionRequest struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Interaction is a credential offer or a presentation request made by an issuer or a verifier.
+type Interaction struct {
+	Type        string            `json:"type"`
+	Purpose     string            `json:"purpose"`
+	From        string            `json:"from,omitempty"`
+	Challenge   string            `json:"challenge,omitempty"`
+	Domain      string            `json:"domain,omitempty"`
+	Query       json.RawMessage   `json:"query,omitempty"`
+	Credentials []json.RawMessage `json:"credentials,omitempty"`
+
+	callbackURL string
+	raw         json.RawMessage
+}
+
+// RespondRequest asks the agent to answer an interaction on behalf of the user. Holder is a DID created
+// through the agent; Credentials are the credentials the user chose to present.
+type RespondRequest struct {
+	InteractionRequest
+	Holder      string            `json:"holder,omitempty"`
+	Credentials []json.RawMessage `json:"credentials,omitempty"`
+}
+
+// RespondResponse is the answer to an interaction. Response is the CHAPI response for the browser, or the
+// reply of the issuer or verifier to a WACI response.
+type RespondResponse struct {
+	Interaction  *Interaction    `json:"interaction"`
+	Presentation string          `json:"presentation,omitempty"`
+	Response     json.RawMessage `json:"response,omitempty"`
+}
+
+func (o *Operation) resolveInteractionHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling resolve interaction request")
+
+	if !o.loggedIn(w, r) {
+		return
+	}
+
+	request := &InteractionRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	interaction, err := o.resolveInteraction(request)
+	if err != nil {
+		writeInteractionError(w, "failed to resolve interaction", err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, interaction)
+}
+
+func (o *Operation) respondInteractionHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling respond interaction request")
+
+	if !o.loggedIn(w, r) {
+		return
+	}
+
+	request := &RespondRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	interaction, err := o.resolveInteraction(&request.InteractionRequest)
+	if err != nil {
+		writeInteractionError(w, "failed to resolve interaction", err)
+
+		return
+	}
+
+	response, err := o.respond(interaction, request)
+	if err != nil {
+		writeInteractionError(w, "failed to respond to interaction", err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, response)
+}
+
+func (o *Operation) resolveInteraction(request *InteractionRequest) (*Interaction, error) {
+	if len(request.Payload) == 0 || string(request.Payload) == "null" {
+		return nil, fmt.Errorf("%w: missing payload", errInvalidInteraction)
+	}
+
+	switch request.Type {
+	case InteractionCHAPI:
+		return resolveCHAPI(request.Payload)
+	case InteractionWACI:
+		return o.resolveWACI(request.Payload)
+	default:
+		return nil, fmt.Errorf("%w: unsupported type '%s'", errInvalidInteraction, request.Type)
+	}
+}
+
+func (o *Operation) respond(interaction *Interaction, request *RespondRequest) (*RespondResponse, error) {
+	response := &RespondResponse{Interaction: interaction}
+
+	if interaction.Purpose == PurposeRequest {
+		if request.Holder == "" {
+			return nil, fmt.Errorf("%w: a holder is required to present credentials", errInvalidInteraction)
+		}
+
+		aud := interaction.Domain
+		if aud == "" {
+			aud = interaction.From
+		}
+
+		vp, err := o.signPresentation(request.Holder, request.Credentials, aud, interaction.Challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		response.Presentation = vp
+	}
+
+	var err error
+
+	switch interaction.Type {
+	case InteractionCHAPI:
+		response.Response, err = chapiResponse(interaction, response.Presentation)
+	case InteractionWACI:
+		response.Response, err = o.respondWACI(interaction, request.Holder, response.Presentation)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func writeInteractionError(w http.ResponseWriter, msg string, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errInvalidInteraction) {
+		status = http.StatusBadRequest
+	}
+
+	common.WriteErrorResponsef(w, logger, status, "%s: %s", msg, err.Error())
+}
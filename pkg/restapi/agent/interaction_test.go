@@ -0,0 +1,374 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	mockcrypto "github.com/hyperledger/aries-framework-go/pkg/mock/crypto"
+	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+const (
+	holderDID  = "did:peer:holder"
+	credential = `{
+		"@context": ["https://www.w3.org/2018/credentials/v1"],
+		"id": "http://example.edu/credentials/1872",
+		"type": ["VerifiableCredential"],
+		"issuer": "did:example:76e12ec712ebc6f1c221ebfeb1f",
+		"issuanceDate": "2010-01-01T19:23:24Z",
+		"credentialSubject": {"id": "did:example:ebfeb1f712ebc6f1c276e12ec21"}
+	}`
+)
+
+func TestOperation_CHAPIInteraction(t *testing.T) {
+	query := json.RawMessage(`{"web": {"VerifiablePresentation": {
+		"query": [{"type": "QueryByExample"}], "challenge": "abc", "domain": "verifier.example.com"
+	}}}`)
+
+	t.Run("resolves a presentation request", func(t *testing.T) {
+		interaction := &Interaction{}
+		w := handle(t, newInteractionOperation(t).resolveInteractionHandler, resolveInteractionPath,
+			&InteractionRequest{Type: InteractionCHAPI, Payload: query}, interaction)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, PurposeRequest, interaction.Purpose)
+		require.Equal(t, "abc", interaction.Challenge)
+		require.Equal(t, "verifier.example.com", interaction.Domain)
+		require.NotEmpty(t, interaction.Query)
+	})
+
+	t.Run("presents credentials signed by the holder", func(t *testing.T) {
+		response := &RespondResponse{}
+		w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath, &RespondRequest{
+			InteractionRequest: InteractionRequest{Type: InteractionCHAPI, Payload: query},
+			Holder:             holderDID,
+			Credentials:        []json.RawMessage{json.RawMessage(credential)},
+		}, response)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		claims := &struct {
+			Issuer   string `json:"iss"`
+			Audience string `json:"aud"`
+			Nonce    string `json:"nonce"`
+		}{}
+		require.NoError(t, decodeJWTClaims(response.Presentation, claims))
+		require.Equal(t, holderDID, claims.Issuer)
+		require.Equal(t, "verifier.example.com", claims.Audience)
+		require.Equal(t, "abc", claims.Nonce)
+
+		webCredential := &chapiWebCredential{}
+		require.NoError(t, json.Unmarshal(response.Response, webCredential))
+		require.Equal(t, chapiDataType, webCredential.DataType)
+		require.Equal(t, response.Presentation, webCredential.Data)
+	})
+
+	t.Run("resolves a credential offer", func(t *testing.T) {
+		for _, creds := range []string{credential, "[" + credential + "," + credential + "]"} {
+			offer := json.RawMessage(`{"web": {"VerifiablePresentation": {
+				"type": ["VerifiablePresentation"], "verifiableCredential": ` + creds + `}}}`)
+
+			response := &RespondResponse{}
+			w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath,
+				&RespondRequest{InteractionRequest: InteractionRequest{Type: InteractionCHAPI, Payload: offer}}, response)
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, PurposeOffer, response.Interaction.Purpose)
+			require.NotEmpty(t, response.Interaction.Credentials)
+			require.Empty(t, response.Presentation)
+			require.Contains(t, string(response.Response), "verifiableCredential")
+		}
+	})
+
+	t.Run("bad request if payload is invalid", func(t *testing.T) {
+		for _, payload := range []string{
+			`{}`,
+			`{"web": {"VerifiablePresentation": []}}`,
+			`{"web": {"VerifiablePresentation": {"type": "VerifiablePresentation"}}}`,
+		} {
+			w := handle(t, newInteractionOperation(t).resolveInteractionHandler, resolveInteractionPath,
+				&InteractionRequest{Type: InteractionCHAPI, Payload: json.RawMessage(payload)}, nil)
+			require.Equal(t, http.StatusBadRequest, w.Code, payload)
+		}
+	})
+
+	t.Run("bad request if holder is missing", func(t *testing.T) {
+		w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath,
+			&RespondRequest{InteractionRequest: InteractionRequest{Type: InteractionCHAPI, Payload: query}}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "holder is required")
+	})
+
+	t.Run("internal server error if presentation cannot be signed", func(t *testing.T) {
+		o1 := newInteractionOperation(t)
+		o1.vdr = &mockvdr.MockVDRegistry{ResolveErr: errors.New("test")}
+
+		o2 := newInteractionOperation(t)
+		o2.kms = &mockkms.KeyManager{GetKeyErr: errors.New("test")}
+
+		o3 := newInteractionOperation(t)
+		o3.crypto = &mockcrypto.Crypto{SignErr: errors.New("test")}
+
+		o4 := newInteractionOperation(t)
+		o4.vdr = &mockvdr.MockVDRegistry{ResolveValue: &did.Doc{ID: holderDID}}
+
+		for _, o := range []*Operation{o1, o2, o3, o4} {
+			w := handle(t, o.respondInteractionHandler, respondInteractionPath, &RespondRequest{
+				InteractionRequest: InteractionRequest{Type: InteractionCHAPI, Payload: query},
+				Holder:             holderDID,
+				Credentials:        []json.RawMessage{json.RawMessage(credential)},
+			}, nil)
+			require.Equal(t, http.StatusInternalServerError, w.Code)
+		}
+	})
+
+	t.Run("internal server error if credential is invalid", func(t *testing.T) {
+		w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath, &RespondRequest{
+			InteractionRequest: InteractionRequest{Type: InteractionCHAPI, Payload: query},
+			Holder:             holderDID,
+			Credentials:        []json.RawMessage{json.RawMessage(`"invalid"`)},
+		}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "invalid credential")
+	})
+}
+
+func TestOperation_WACIInteraction(t *testing.T) {
+	t.Run("presents credentials to the verifier callback", func(t *testing.T) {
+		verifier := mockWACIServer(t, PurposeRequest)
+		defer verifier.Close()
+
+		response := &RespondResponse{}
+		w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath, &RespondRequest{
+			InteractionRequest: waciRequest(t, verifier.URL+"/challenge"),
+			Holder:             holderDID,
+			Credentials:        []json.RawMessage{json.RawMessage(credential)},
+		}, response)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, PurposeRequest, response.Interaction.Purpose)
+		require.Equal(t, "did:example:verifier", response.Interaction.From)
+		require.NotEmpty(t, response.Presentation)
+		require.JSONEq(t, `{"status": "accepted"}`, string(response.Response))
+	})
+
+	t.Run("accepts a credential offer", func(t *testing.T) {
+		issuer := mockWACIServer(t, PurposeOffer)
+		defer issuer.Close()
+
+		response := &RespondResponse{}
+		w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath, &RespondRequest{
+			InteractionRequest: waciRequest(t, issuer.URL+"/challenge"),
+			Holder:             holderDID,
+		}, response)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, PurposeOffer, response.Interaction.Purpose)
+		require.Empty(t, response.Presentation)
+	})
+
+	t.Run("resolves an inline challenge token", func(t *testing.T) {
+		interaction := &Interaction{}
+		w := handle(t, newInteractionOperation(t).resolveInteractionHandler, resolveInteractionPath,
+			&InteractionRequest{Type: InteractionWACI, Payload: marshal(t, &waciPayload{
+				ChallengeToken: challengeToken(t, PurposeRequest, "https://verifier.example.com/callback"),
+			})}, interaction)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, PurposeRequest, interaction.Purpose)
+		require.JSONEq(t, `{"id": "definition"}`, string(interaction.Query))
+	})
+
+	t.Run("bad request if challenge is invalid", func(t *testing.T) {
+		for _, p := range []*waciPayload{
+			{},
+			{ChallengeToken: "invalid"},
+			{ChallengeToken: "a.!.c"},
+			{ChallengeToken: challengeToken(t, PurposeRequest, "")},
+			{ChallengeToken: challengeToken(t, "unknown", "https://verifier.example.com/callback")},
+		} {
+			w := handle(t, newInteractionOperation(t).resolveInteractionHandler, resolveInteractionPath,
+				&InteractionRequest{Type: InteractionWACI, Payload: marshal(t, p)}, nil)
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("bad request if holder is missing", func(t *testing.T) {
+		w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath, &RespondRequest{
+			InteractionRequest: InteractionRequest{Type: InteractionWACI, Payload: marshal(t, &waciPayload{
+				ChallengeToken: challengeToken(t, PurposeOffer, "https://issuer.example.com/callback"),
+			})},
+		}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("internal server error if challenge cannot be fetched", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		w := handle(t, newInteractionOperation(t).resolveInteractionHandler, resolveInteractionPath,
+			waciRequest(t, server.URL+"/challenge"), nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch challenge token")
+	})
+
+	t.Run("bad request if challenge response is invalid", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte("{}"))
+			require.NoError(t, err)
+		}))
+		defer server.Close()
+
+		w := handle(t, newInteractionOperation(t).resolveInteractionHandler, resolveInteractionPath,
+			waciRequest(t, server.URL+"/challenge"), nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("internal server error if callback fails", func(t *testing.T) {
+		w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath, &RespondRequest{
+			InteractionRequest: InteractionRequest{Type: InteractionWACI, Payload: marshal(t, &waciPayload{
+				ChallengeToken: challengeToken(t, PurposeOffer, "http://localhost:-1/callback"),
+			})},
+			Holder: holderDID,
+		}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to post waci response")
+	})
+}
+
+func TestOperation_Interaction(t *testing.T) {
+	t.Run("bad request if type is unsupported", func(t *testing.T) {
+		w := handle(t, newInteractionOperation(t).resolveInteractionHandler, resolveInteractionPath,
+			&InteractionRequest{Type: "unknown", Payload: json.RawMessage(`{}`)}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "unsupported type")
+	})
+
+	t.Run("bad request if payload is missing", func(t *testing.T) {
+		w := handle(t, newInteractionOperation(t).respondInteractionHandler, respondInteractionPath,
+			&RespondRequest{InteractionRequest: InteractionRequest{Type: InteractionCHAPI}}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing payload")
+	})
+
+	t.Run("bad request if body is invalid", func(t *testing.T) {
+		o := newInteractionOperation(t)
+
+		for _, handler := range []http.HandlerFunc{o.resolveInteractionHandler, o.respondInteractionHandler} {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodPost, resolveInteractionPath, bytes.NewReader([]byte("{"))))
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o := newInteractionOperation(t)
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		for _, handler := range []http.HandlerFunc{o.resolveInteractionHandler, o.respondInteractionHandler} {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodPost, resolveInteractionPath, nil))
+			require.Equal(t, http.StatusForbidden, w.Code)
+		}
+	})
+}
+
+// newInteractionOperation returns an Operation able to sign for holderDID.
+func newInteractionOperation(t *testing.T) *Operation {
+	t.Helper()
+
+	o := newOperation(t)
+	o.vdr = &mockvdr.MockVDRegistry{
+		ResolveFunc: func(id string, _ ...vdrapi.ResolveOpts) (*did.Doc, error) {
+			require.Equal(t, holderDID, id)
+
+			return &did.Doc{ID: holderDID, VerificationMethod: []did.VerificationMethod{{ID: "#key1"}}}, nil
+		},
+	}
+	o.kms = &mockkms.KeyManager{}
+	o.crypto = &mockcrypto.Crypto{SignValue: []byte("signature")}
+
+	return o
+}
+
+func handle(t *testing.T, handler http.HandlerFunc, path string,
+	request, response interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(marshal(t, request))))
+
+	if response != nil && w.Code < http.StatusMultipleChoices {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(response))
+	}
+
+	return w
+}
+
+func waciRequest(t *testing.T, challengeURL string) InteractionRequest {
+	t.Helper()
+
+	return InteractionRequest{
+		Type:    InteractionWACI,
+		Payload: marshal(t, &waciPayload{ChallengeTokenURL: challengeURL}),
+	}
+}
+
+// mockWACIServer serves a challenge token and checks the response token posted to its callback.
+func mockWACIServer(t *testing.T, purpose string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.HandleFunc("/challenge", func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(marshal(t, &waciPayload{ChallengeToken: challengeToken(t, purpose, server.URL+"/callback")}))
+		require.NoError(t, err)
+	})
+
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		body := &waciResponseBody{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(body))
+
+		claims := &waciResponse{}
+		require.NoError(t, decodeJWTClaims(body.ResponseToken, claims))
+		require.Equal(t, holderDID, claims.Issuer)
+		require.Equal(t, "did:example:verifier", claims.Audience)
+		require.Equal(t, "challenge-id", claims.Challenge)
+		require.Equal(t, purpose == PurposeRequest, claims.Presentation != "")
+
+		_, err := w.Write([]byte(`{"status": "accepted"}`))
+		require.NoError(t, err)
+	})
+
+	return server
+}
+
+func challengeToken(t *testing.T, purpose, callbackURL string) string {
+	t.Helper()
+
+	claims := marshal(t, &waciChallenge{
+		ID:          "challenge-id",
+		Issuer:      "did:example:verifier",
+		Purpose:     purpose,
+		CallbackURL: callbackURL,
+		Definition:  json.RawMessage(`{"id": "definition"}`),
+		Manifest:    json.RawMessage(`{"id": "manifest"}`),
+	})
+
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(claims) + "." + uuid.New().String()
+}
@@ -0,0 +1,34 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"fmt"
+
+	webcrypto "github.com/hyperledger/aries-framework-go/pkg/crypto/webkms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/webkms"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/signer"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+)
+
+// NewKeySigner returns a signer of an Ed25519 key of the agent keystore on the ops KMS, which are created on first
+// start. The Inbound of the config is not used.
+func NewKeySigner(config *FrameworkConfig) (*signer.KMSSigner, error) {
+	httpClient := kmsHTTPClient(config)
+
+	keystoreURL, err := agentKeystore(httpClient, config.OpsKMSURL, config.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open agent keystore: %w", err)
+	}
+
+	s, err := store.Open(config.Storage, agentStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	return signer.NewKMSSigner(webkms.New(keystoreURL, httpClient), webcrypto.New(keystoreURL, httpClient), s)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal functions
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+)
+
+func TestNewKeySigner(t *testing.T) {
+	t.Run("signs and verifies with the key of the agent keystore", func(t *testing.T) {
+		kms := newSigningKMS(t)
+		p := memstore.NewProvider()
+
+		signer, err := NewKeySigner(&FrameworkConfig{OpsKMSURL: kms.server.URL, Storage: p})
+		require.NoError(t, err)
+		require.Equal(t, 1, kms.keys())
+
+		kid, err := signer.KeyID()
+		require.NoError(t, err)
+
+		signature, err := signer.Sign([]byte("data"), kid)
+		require.NoError(t, err)
+		require.NoError(t, signer.Verify(signature, []byte("data"), kid))
+
+		err = signer.Verify(signature, []byte("tampered"), kid)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to verify signature")
+
+		// the other instances of the agent share the key
+		other, err := NewKeySigner(&FrameworkConfig{OpsKMSURL: kms.server.URL, Storage: p})
+		require.NoError(t, err)
+
+		otherKID, err := other.KeyID()
+		require.NoError(t, err)
+		require.Equal(t, kid, otherKID)
+		require.NoError(t, other.Verify(signature, []byte("data"), kid))
+		require.Equal(t, 1, kms.keys())
+	})
+
+	t.Run("error if the agent keystore cannot be opened", func(t *testing.T) {
+		_, err := NewKeySigner(&FrameworkConfig{OpsKMSURL: "http://localhost:-1", Storage: memstore.NewProvider()})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open agent keystore")
+	})
+}
+
+// signingKMS fakes the Ed25519 keys of a keystore of the ops KMS.
+type signingKMS struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	ids    map[string]ed25519.PrivateKey
+}
+
+func newSigningKMS(t *testing.T) *signingKMS {
+	t.Helper()
+
+	kms := &signingKMS{ids: map[string]ed25519.PrivateKey{}}
+	kms.server = httptest.NewServer(http.HandlerFunc(kms.handle))
+
+	t.Cleanup(kms.server.Close)
+
+	return kms
+}
+
+func (k *signingKMS) keys() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return len(k.ids)
+}
+
+func (k *signingKMS) handle(w http.ResponseWriter, r *http.Request) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/kms/keystores"), "/")
+
+	switch {
+	// create keystore
+	case len(segments) == 1:
+		w.Header().Set(keystoreLocation, k.server.URL+"/kms/keystores/agent")
+		w.WriteHeader(http.StatusCreated)
+	// create key
+	case len(segments) == 3:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		kid := uuid.New().String()
+		k.ids[kid] = key
+
+		w.Header().Set(keystoreLocation, k.server.URL+"/kms/keystores/agent/keys/"+kid)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		k.handleKeyOperation(w, r, segments[3], segments[4])
+	}
+}
+
+func (k *signingKMS) handleKeyOperation(w http.ResponseWriter, r *http.Request, kid, operation string) {
+	key, ok := k.ids[kid]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	req := &struct {
+		Message   string `json:"message"`
+		Signature string `json:"signature"`
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	msg, err := base64.URLEncoding.DecodeString(req.Message)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if operation == "sign" {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"signature": base64.URLEncoding.EncodeToString(ed25519.Sign(key, msg)),
+		})
+
+		return
+	}
+
+	signature, err := base64.URLEncoding.DecodeString(req.Signature)
+	if err != nil || !ed25519.Verify(key.Public().(ed25519.PublicKey), msg, signature) {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
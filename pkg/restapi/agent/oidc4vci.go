@@ -0,0 +1,623 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Endpoints.
+const (
+	credentialOffersPath = "/oidc4vci/offers"
+	oidc4vciCallbackPath = "/oidc4vci/callback"
+)
+
+const (
+	oidc4vciStoreName      = "edgeagent_oidc4vci_trx"
+	issuerMetadataPath     = "/.well-known/openid-credential-issuer"
+	authServerMetadataPath = "/.well-known/oauth-authorization-server"
+	preAuthorizedGrantType = "urn:ietf:params:oauth:grant-type:pre-authorized_code"
+	authorizationGrantType = "authorization_code"
+	authorizationDetails   = "openid_credential"
+	proofType              = "jwt"
+	proofJWTType           = "openid4vci-proof+jwt"
+)
+
+var errInvalidOffer = errors.New("invalid credential offer")
+
+// OIDC4VCIConfig holds configuration for redeeming OpenID4VCI credential offers.
+// ClientID and RedirectURL are only needed for offers that use the authorization code flow.
+type OIDC4VCIConfig struct {
+	ClientID         string
+	RedirectURL      string
+	Vault            CredentialVault
+	TransientStorage storage.Provider
+}
+
+// CredentialVault stores the credentials issued to a user.
+type CredentialVault interface {
+	SaveCredential(sub, id string, credential []byte) error
+}
+
+// RedeemOfferRequest is the body of a redeem credential offer request.
+type RedeemOfferRequest struct {
+	Offer   string `json:"offer"`
+	Holder  string `json:"holder"`
+	UserPin string `json:"userPin,omitempty"`
+}
+
+// RedeemOfferResponse lists the credentials saved to the user's vault, or the URL where
+// the user authorizes the issuance if the offer has no pre-authorized code.
+type RedeemOfferResponse struct {
+	AuthorizationURL string              `json:"authorizationURL,omitempty"`
+	Credentials      []*IssuedCredential `json:"credentials,omitempty"`
+}
+
+// IssuedCredential is a credential saved to the user's vault.
+type IssuedCredential struct {
+	ID         string          `json:"id"`
+	Format     string          `json:"format"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+type credentialOffer struct {
+	CredentialIssuer string            `json:"credential_issuer"`
+	Credentials      []json.RawMessage `json:"credentials"`
+	Grants           struct {
+		AuthorizationCode *authorizationCodeGrant `json:"authorization_code,omitempty"`
+		PreAuthorizedCode *preAuthorizedCodeGrant `json:"urn:ietf:params:oauth:grant-type:pre-authorized_code,omitempty"`
+	} `json:"grants"`
+}
+
+type authorizationCodeGrant struct {
+	IssuerState string `json:"issuer_state,omitempty"`
+}
+
+type preAuthorizedCodeGrant struct {
+	Code            string `json:"pre-authorized_code"`
+	UserPinRequired bool   `json:"user_pin_required,omitempty"`
+}
+
+type credentialSpec struct {
+	ID     string   `json:"id,omitempty"`
+	Type   string   `json:"type,omitempty"`
+	Format string   `json:"format"`
+	Types  []string `json:"types,omitempty"`
+}
+
+type issuerMetadata struct {
+	CredentialIssuer      string            `json:"credential_issuer"`
+	AuthorizationServer   string            `json:"authorization_server,omitempty"`
+	AuthorizationEndpoint string            `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint         string            `json:"token_endpoint,omitempty"`
+	CredentialEndpoint    string            `json:"credential_endpoint"`
+	CredentialsSupported  []*credentialSpec `json:"credentials_supported,omitempty"`
+}
+
+type authServerMetadata struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	CNonce      string `json:"c_nonce,omitempty"`
+}
+
+type credentialRequest struct {
+	Format string           `json:"format"`
+	Types  []string         `json:"types,omitempty"`
+	Proof  *credentialProof `json:"proof"`
+}
+
+type credentialProof struct {
+	ProofType string `json:"proof_type"`
+	JWT       string `json:"jwt"`
+}
+
+type credentialResponse struct {
+	Format     string          `json:"format"`
+	Credential json.RawMessage `json:"credential"`
+	CNonce     string          `json:"c_nonce,omitempty"`
+}
+
+// proofClaims are the claims of the proof-of-possession of the holder's key.
+type proofClaims struct {
+	Issuer   string `json:"iss,omitempty"`
+	Audience string `json:"aud"`
+	IssuedAt int64  `json:"iat"`
+	Nonce    string `json:"nonce,omitempty"`
+}
+
+// issuance is an offer being redeemed. Issuances waiting for the user's authorization
+// are kept in the transient store until the callback.
+type issuance struct {
+	Sub         string            `json:"sub"`
+	Holder      string            `json:"holder"`
+	Issuer      *issuerMetadata   `json:"issuer"`
+	Credentials []*credentialSpec `json:"credentials"`
+}
+
+func (o *Operation) redeemOfferHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling redeem credential offer request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	if !o.features.Enabled(features.OIDC4VCI, sub) {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "credential offers are not enabled for the user")
+
+		return
+	}
+
+	request := &RedeemOfferRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	response, err := o.redeemOffer(sub, request)
+	if err != nil {
+		writeOfferError(w, "failed to redeem credential offer", err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, response)
+}
+
+func (o *Operation) oidc4vciCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling oidc4vci callback: %s", r.URL.String())
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing state parameter")
+
+		return
+	}
+
+	iss, err := o.popIssuance(state)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid state parameter")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to fetch issuance: %s", err.Error())
+
+		return
+	}
+
+	if iss.Sub != sub {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "issuance was started by another user")
+
+		return
+	}
+
+	if authErr := r.URL.Query().Get("error"); authErr != "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "authorization failed: %s", authErr)
+
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing code parameter")
+
+		return
+	}
+
+	token, err := o.requestToken(iss.Issuer.TokenEndpoint, url.Values{
+		"grant_type":   {authorizationGrantType},
+		"code":         {code},
+		"redirect_uri": {o.oidc4vci.RedirectURL},
+		"client_id":    {o.oidc4vci.ClientID},
+	})
+	if err != nil {
+		writeOfferError(w, "failed to redeem credential offer", err)
+
+		return
+	}
+
+	credentials, err := o.issue(iss, token)
+	if err != nil {
+		writeOfferError(w, "failed to redeem credential offer", err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &RedeemOfferResponse{Credentials: credentials})
+}
+
+// redeemOffer runs the pre-authorized code flow if the offer allows it, otherwise it starts the
+// authorization code flow which is completed by the callback.
+func (o *Operation) redeemOffer(sub string, request *RedeemOfferRequest) (*RedeemOfferResponse, error) {
+	if request.Holder == "" {
+		return nil, fmt.Errorf("%w: a holder is required to redeem an offer", errInvalidOffer)
+	}
+
+	offer, err := o.parseOffer(request.Offer)
+	if err != nil {
+		return nil, err
+	}
+
+	iss, err := o.prepareIssuance(sub, request.Holder, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	preAuthorized := offer.Grants.PreAuthorizedCode
+	if preAuthorized == nil {
+		issuerState := ""
+		if offer.Grants.AuthorizationCode != nil {
+			issuerState = offer.Grants.AuthorizationCode.IssuerState
+		}
+
+		authURL, errAuth := o.authorizationURL(iss, issuerState)
+		if errAuth != nil {
+			return nil, errAuth
+		}
+
+		return &RedeemOfferResponse{AuthorizationURL: authURL}, nil
+	}
+
+	if preAuthorized.UserPinRequired && request.UserPin == "" {
+		return nil, fmt.Errorf("%w: a user pin is required", errInvalidOffer)
+	}
+
+	form := url.Values{
+		"grant_type":          {preAuthorizedGrantType},
+		"pre-authorized_code": {preAuthorized.Code},
+	}
+
+	if request.UserPin != "" {
+		form.Set("user_pin", request.UserPin)
+	}
+
+	token, err := o.requestToken(iss.Issuer.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := o.issue(iss, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedeemOfferResponse{Credentials: credentials}, nil
+}
+
+// parseOffer reads the offer passed by value or by reference in a credential offer URI.
+func (o *Operation) parseOffer(offerURI string) (*credentialOffer, error) {
+	u, err := url.Parse(offerURI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidOffer, err.Error())
+	}
+
+	var bits []byte
+
+	switch {
+	case u.Query().Get("credential_offer") != "":
+		bits = []byte(u.Query().Get("credential_offer"))
+	case u.Query().Get("credential_offer_uri") != "":
+		bits, err = o.sendHTTPRequest(http.MethodGet, u.Query().Get("credential_offer_uri"), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch credential offer: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: uri has no credential_offer or credential_offer_uri", errInvalidOffer)
+	}
+
+	offer := &credentialOffer{}
+
+	err = json.Unmarshal(bits, offer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidOffer, err.Error())
+	}
+
+	if offer.CredentialIssuer == "" {
+		return nil, fmt.Errorf("%w: missing credential_issuer", errInvalidOffer)
+	}
+
+	if len(offer.Credentials) == 0 {
+		return nil, fmt.Errorf("%w: no credentials offered", errInvalidOffer)
+	}
+
+	return offer, nil
+}
+
+// prepareIssuance discovers the issuer's endpoints and what each offered credential is.
+func (o *Operation) prepareIssuance(sub, holder string, offer *credentialOffer) (*issuance, error) {
+	metadata, err := o.issuerMetadata(offer.CredentialIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	iss := &issuance{Sub: sub, Holder: holder, Issuer: metadata}
+
+	for _, raw := range offer.Credentials {
+		spec := &credentialSpec{}
+
+		var id string
+
+		if json.Unmarshal(raw, &id) == nil {
+			spec = metadata.supportedCredential(id)
+			if spec == nil {
+				return nil, fmt.Errorf("%w: issuer does not support credential '%s'", errInvalidOffer, id)
+			}
+		} else if err = json.Unmarshal(raw, spec); err != nil {
+			return nil, fmt.Errorf("%w: invalid credential: %s", errInvalidOffer, err.Error())
+		}
+
+		iss.Credentials = append(iss.Credentials, spec)
+	}
+
+	return iss, nil
+}
+
+func (o *Operation) issuerMetadata(issuer string) (*issuerMetadata, error) {
+	bits, err := o.sendHTTPRequest(http.MethodGet, strings.TrimSuffix(issuer, "/")+issuerMetadataPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch issuer metadata: %w", err)
+	}
+
+	metadata := &issuerMetadata{}
+
+	err = json.Unmarshal(bits, metadata)
+	if err != nil || metadata.CredentialEndpoint == "" {
+		return nil, fmt.Errorf("%w: invalid issuer metadata", errInvalidOffer)
+	}
+
+	if metadata.CredentialIssuer == "" {
+		metadata.CredentialIssuer = issuer
+	}
+
+	if metadata.TokenEndpoint != "" && metadata.AuthorizationEndpoint != "" {
+		return metadata, nil
+	}
+
+	authServer := metadata.AuthorizationServer
+	if authServer == "" {
+		authServer = issuer
+	}
+
+	bits, err = o.sendHTTPRequest(http.MethodGet, strings.TrimSuffix(authServer, "/")+authServerMetadataPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch authorization server metadata: %w", err)
+	}
+
+	authMetadata := &authServerMetadata{}
+
+	err = json.Unmarshal(bits, authMetadata)
+	if err != nil || authMetadata.TokenEndpoint == "" {
+		return nil, fmt.Errorf("%w: invalid authorization server metadata", errInvalidOffer)
+	}
+
+	if metadata.TokenEndpoint == "" {
+		metadata.TokenEndpoint = authMetadata.TokenEndpoint
+	}
+
+	if metadata.AuthorizationEndpoint == "" {
+		metadata.AuthorizationEndpoint = authMetadata.AuthorizationEndpoint
+	}
+
+	return metadata, nil
+}
+
+func (m *issuerMetadata) supportedCredential(id string) *credentialSpec {
+	for _, spec := range m.CredentialsSupported {
+		if spec.ID == id {
+			return spec
+		}
+	}
+
+	return nil
+}
+
+// authorizationURL saves the issuance and returns the URL where the user authorizes it.
+func (o *Operation) authorizationURL(iss *issuance, issuerState string) (string, error) {
+	if o.oidc4vci.ClientID == "" || o.oidc4vci.RedirectURL == "" {
+		return "", errors.New("the authorization code flow is not configured")
+	}
+
+	if iss.Issuer.AuthorizationEndpoint == "" {
+		return "", fmt.Errorf("%w: issuer has no authorization endpoint", errInvalidOffer)
+	}
+
+	details := make([]*credentialSpec, len(iss.Credentials))
+
+	for i, spec := range iss.Credentials {
+		details[i] = &credentialSpec{Type: authorizationDetails, Format: spec.Format, Types: spec.Types}
+	}
+
+	detailsBits, err := json.Marshal(details)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal authorization details: %w", err)
+	}
+
+	authURL, err := url.Parse(iss.Issuer.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %s", errInvalidOffer, err.Error())
+	}
+
+	state := uuid.New().String()
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", o.oidc4vci.ClientID)
+	query.Set("redirect_uri", o.oidc4vci.RedirectURL)
+	query.Set("state", state)
+	query.Set("authorization_details", string(detailsBits))
+
+	if issuerState != "" {
+		query.Set("issuer_state", issuerState)
+	}
+
+	authURL.RawQuery = query.Encode()
+
+	err = store.Save(o.issuances, state, iss)
+	if err != nil {
+		return "", fmt.Errorf("failed to save issuance: %w", err)
+	}
+
+	return authURL.String(), nil
+}
+
+func (o *Operation) popIssuance(state string) (*issuance, error) {
+	bits, err := o.issuances.Get(state)
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.issuances.Delete(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete issuance: %w", err)
+	}
+
+	iss := &issuance{}
+
+	return iss, json.Unmarshal(bits, iss)
+}
+
+// issue requests each offered credential with a proof of possession of the holder's key,
+// and saves it to the user's vault.
+func (o *Operation) issue(iss *issuance, token *tokenResponse) ([]*IssuedCredential, error) {
+	signer, err := o.holderSigner(iss.Holder)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := token.CNonce
+	issued := make([]*IssuedCredential, 0, len(iss.Credentials))
+
+	for _, spec := range iss.Credentials {
+		proof, errSign := signJWT(&proofClaims{
+			Issuer:   o.oidc4vci.ClientID,
+			Audience: iss.Issuer.CredentialIssuer,
+			IssuedAt: time.Now().Unix(),
+			Nonce:    nonce,
+		}, jose.Headers{jose.HeaderType: proofJWTType}, signer)
+		if errSign != nil {
+			return nil, errSign
+		}
+
+		response, errRequest := o.requestCredential(iss.Issuer.CredentialEndpoint, token.AccessToken,
+			&credentialRequest{
+				Format: spec.Format,
+				Types:  spec.Types,
+				Proof:  &credentialProof{ProofType: proofType, JWT: proof},
+			})
+		if errRequest != nil {
+			return nil, errRequest
+		}
+
+		// the issuer may rotate the nonce with every credential
+		if response.CNonce != "" {
+			nonce = response.CNonce
+		}
+
+		id := uuid.New().URN()
+
+		err = o.vault.SaveCredential(iss.Sub, id, response.Credential)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save credential to vault: %w", err)
+		}
+
+		o.notify(TopicCredentialReceived, iss.Sub, &credentialReceivedPayload{
+			ID:     id,
+			Format: response.Format,
+			Issuer: iss.Issuer.CredentialIssuer,
+		})
+
+		issued = append(issued, &IssuedCredential{ID: id, Format: response.Format, Credential: response.Credential})
+	}
+
+	return issued, nil
+}
+
+func (o *Operation) requestToken(endpoint string, form url.Values) (*tokenResponse, error) {
+	bits, err := o.postForm(endpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request access token: %w", err)
+	}
+
+	token := &tokenResponse{}
+
+	err = json.Unmarshal(bits, token)
+	if err != nil || token.AccessToken == "" {
+		return nil, errors.New("token endpoint did not return an access token")
+	}
+
+	return token, nil
+}
+
+func (o *Operation) requestCredential(endpoint, accessToken string,
+	request *credentialRequest) (*credentialResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal credential request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credential request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	bits, err := o.doHTTPRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request credential: %w", err)
+	}
+
+	response := &credentialResponse{}
+
+	err = json.Unmarshal(bits, response)
+	if err != nil || len(response.Credential) == 0 {
+		return nil, errors.New("credential endpoint did not return a credential")
+	}
+
+	return response, nil
+}
+
+func writeOfferError(w http.ResponseWriter, msg string, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errInvalidOffer) {
+		status = http.StatusBadRequest
+	}
+
+	common.WriteErrorResponsef(w, logger, status, "%s: %s", msg, err.Error())
+}
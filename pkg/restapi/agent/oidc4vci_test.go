@@ -0,0 +1,568 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+const degreeTypes = `["VerifiableCredential", "UniversityDegreeCredential"]`
+
+func TestOperation_RedeemOffer(t *testing.T) {
+	t.Run("redeems a pre-authorized offer into the vault", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+
+		response := &RedeemOfferResponse{}
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.preAuthorizedOffer(false)),
+			Holder: holderDID,
+		}, response)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Len(t, response.Credentials, 2)
+		require.Equal(t, "jwt_vc_json", response.Credentials[0].Format)
+
+		// the second proof uses the nonce returned with the first credential
+		require.Equal(t, []string{"token-nonce", "credential-nonce"}, issuer.nonces)
+		require.Equal(t, preAuthorizedGrantType, issuer.tokenForm.Get("grant_type"))
+		require.Equal(t, "pre-auth", issuer.tokenForm.Get("pre-authorized_code"))
+
+		vault := o.vault.(*mockVault)
+		require.Len(t, vault.saved, 2)
+		require.Equal(t, sub, vault.sub)
+		require.JSONEq(t, `"issued.credential.jwt"`, string(vault.saved[response.Credentials[1].ID]))
+	})
+
+	t.Run("fetches an offer passed by reference", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		issuer.offer = issuer.preAuthorizedOffer(false)
+
+		o, sub := newOIDC4VCIOperation(t)
+
+		response := &RedeemOfferResponse{}
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  "openid-credential-offer://?credential_offer_uri=" + url.QueryEscape(issuer.URL+"/offer"),
+			Holder: holderDID,
+		}, response)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Len(t, response.Credentials, 2)
+	})
+
+	t.Run("sends the user pin", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.preAuthorizedOffer(true)),
+			Holder: holderDID,
+		}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "a user pin is required")
+
+		w = redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:   issuer.offerURI(t, issuer.preAuthorizedOffer(true)),
+			Holder:  holderDID,
+			UserPin: "1234",
+		}, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "1234", issuer.tokenForm.Get("user_pin"))
+	})
+
+	t.Run("bad request if offer is invalid", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+
+		for _, offer := range []string{
+			"openid-credential-offer://",
+			"openid-credential-offer://?credential_offer=invalid",
+			"%",
+			"openid-credential-offer://?credential_offer=" + url.QueryEscape(`{"credentials": ["id"]}`),
+			issuer.offerURI(t, map[string]interface{}{"credential_issuer": issuer.URL}),
+			issuer.offerURI(t, map[string]interface{}{
+				"credential_issuer": issuer.URL, "credentials": []string{"unknown"},
+			}),
+			issuer.offerURI(t, map[string]interface{}{"credential_issuer": issuer.URL, "credentials": []int{1}}),
+		} {
+			w := redeemAs(t, o, sub, &RedeemOfferRequest{Offer: offer, Holder: holderDID}, nil)
+			require.Equal(t, http.StatusBadRequest, w.Code, offer)
+		}
+	})
+
+	t.Run("bad request if holder is missing", func(t *testing.T) {
+		o, sub := newOIDC4VCIOperation(t)
+
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{Offer: "openid-credential-offer://"}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "a holder is required")
+	})
+
+	t.Run("bad request if issuer metadata is invalid", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		issuer.metadata = map[string]interface{}{"credential_issuer": issuer.URL}
+
+		o, sub := newOIDC4VCIOperation(t)
+
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.preAuthorizedOffer(false)),
+			Holder: holderDID,
+		}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "invalid issuer metadata")
+	})
+
+	t.Run("internal server error if issuer fails", func(t *testing.T) {
+		for _, path := range []string{
+			"/offer", issuerMetadataPath, authServerMetadataPath, "/token", "/credential",
+		} {
+			issuer := newMockIssuer(t)
+			issuer.failPath = path
+			issuer.offer = issuer.preAuthorizedOffer(false)
+
+			o, sub := newOIDC4VCIOperation(t)
+
+			w := redeemAs(t, o, sub, &RedeemOfferRequest{
+				Offer:  "openid-credential-offer://?credential_offer_uri=" + url.QueryEscape(issuer.URL+"/offer"),
+				Holder: holderDID,
+			}, nil)
+			require.Equal(t, http.StatusInternalServerError, w.Code, path)
+
+			issuer.Close()
+		}
+	})
+
+	t.Run("internal server error if credential cannot be saved", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+		o.vault = &mockVault{err: errors.New("test")}
+
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.preAuthorizedOffer(false)),
+			Holder: holderDID,
+		}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save credential to vault")
+	})
+
+	t.Run("internal server error if proof cannot be signed", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+		o.kms = &mockkms.KeyManager{GetKeyErr: errors.New("test")}
+
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.preAuthorizedOffer(false)),
+			Holder: holderDID,
+		}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("bad request if body is invalid", func(t *testing.T) {
+		o, sub := newOIDC4VCIOperation(t)
+
+		loginAs(o, sub)
+
+		w := httptest.NewRecorder()
+		o.redeemOfferHandler(w, httptest.NewRequest(http.MethodPost, credentialOffersPath, strings.NewReader("{")))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o, _ := newOIDC4VCIOperation(t)
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		w := httptest.NewRecorder()
+		o.redeemOfferHandler(w, httptest.NewRequest(http.MethodPost, credentialOffersPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("forbidden if credential offers are not enabled for the user", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+
+		var err error
+
+		o.features, err = features.New(&features.Config{Source: &features.MockSource{
+			Flags: map[string]*features.Flag{features.OIDC4VCI: {Users: []string{"someone-else"}}},
+		}})
+		require.NoError(t, err)
+
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.preAuthorizedOffer(false)),
+			Holder: holderDID,
+		}, nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "credential offers are not enabled for the user")
+		require.Empty(t, issuer.nonces)
+	})
+}
+
+func TestOperation_OIDC4VCIAuthorizationCode(t *testing.T) {
+	t.Run("redeems the offer once the user authorizes it", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+
+		response := &RedeemOfferResponse{}
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.authorizationCodeOffer()),
+			Holder: holderDID,
+		}, response)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.True(t, strings.HasPrefix(response.AuthorizationURL, issuer.URL+"/authorize?"))
+
+		authURL, err := url.Parse(response.AuthorizationURL)
+		require.NoError(t, err)
+		require.Equal(t, "wallet", authURL.Query().Get("client_id"))
+		require.Equal(t, "issuer-state", authURL.Query().Get("issuer_state"))
+		require.Contains(t, authURL.Query().Get("authorization_details"), authorizationDetails)
+
+		state := authURL.Query().Get("state")
+
+		result := &RedeemOfferResponse{}
+		w = callbackAs(t, o, sub, "?state="+state+"&code=code")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+		require.Len(t, result.Credentials, 2)
+		require.Equal(t, authorizationGrantType, issuer.tokenForm.Get("grant_type"))
+		require.Equal(t, "code", issuer.tokenForm.Get("code"))
+
+		// the state cannot be replayed
+		w = callbackAs(t, o, sub, "?state="+state+"&code=code")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("internal server error if the flow is not configured", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+		o.oidc4vci.ClientID = ""
+
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.authorizationCodeOffer()),
+			Holder: holderDID,
+		}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "not configured")
+	})
+
+	t.Run("internal server error if issuance cannot be saved", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+		o.issuances = &mockstore.MockStore{Store: map[string][]byte{}, ErrPut: errors.New("test")}
+
+		w := redeemAs(t, o, sub, &RedeemOfferRequest{
+			Offer:  issuer.offerURI(t, issuer.authorizationCodeOffer()),
+			Holder: holderDID,
+		}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save issuance")
+	})
+
+	t.Run("callback errors", func(t *testing.T) {
+		issuer := newMockIssuer(t)
+		defer issuer.Close()
+
+		o, sub := newOIDC4VCIOperation(t)
+
+		start := func() string {
+			response := &RedeemOfferResponse{}
+			w := redeemAs(t, o, sub, &RedeemOfferRequest{
+				Offer:  issuer.offerURI(t, issuer.authorizationCodeOffer()),
+				Holder: holderDID,
+			}, response)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			authURL, err := url.Parse(response.AuthorizationURL)
+			require.NoError(t, err)
+
+			return authURL.Query().Get("state")
+		}
+
+		w := callbackAs(t, o, sub, "?code=code")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing state parameter")
+
+		w = callbackAs(t, o, sub, "?state=unknown&code=code")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "invalid state parameter")
+
+		w = callbackAs(t, o, uuid.New().String(), "?state="+start()+"&code=code")
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = callbackAs(t, o, sub, "?state="+start()+"&error=access_denied")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "access_denied")
+
+		w = callbackAs(t, o, sub, "?state="+start())
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing code parameter")
+
+		issuer.failPath = "/token"
+		w = callbackAs(t, o, sub, "?state="+start()+"&code=code")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		issuer.failPath = "/credential"
+		w = callbackAs(t, o, sub, "?state="+start()+"&code=code")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("internal server error if issuance cannot be read", func(t *testing.T) {
+		o, sub := newOIDC4VCIOperation(t)
+		o.issuances = &mockstore.MockStore{Store: map[string][]byte{"state": []byte("{")}}
+
+		w := callbackAs(t, o, sub, "?state=state&code=code")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		o.issuances = &mockstore.MockStore{
+			Store: map[string][]byte{"state": []byte("{}")}, ErrDelete: errors.New("test"),
+		}
+
+		w = callbackAs(t, o, sub, "?state=state&code=code")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o, _ := newOIDC4VCIOperation(t)
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		w := httptest.NewRecorder()
+		o.oidc4vciCallbackHandler(w, httptest.NewRequest(http.MethodGet, oidc4vciCallbackPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+type mockVault struct {
+	mutex sync.Mutex
+	sub   string
+	saved map[string][]byte
+	err   error
+}
+
+func (m *mockVault) SaveCredential(sub, id string, credential []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	if m.saved == nil {
+		m.saved = map[string][]byte{}
+	}
+
+	m.sub = sub
+	m.saved[id] = credential
+
+	return nil
+}
+
+func (m *mockVault) Credentials(sub string) (map[string][]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.sub = sub
+
+	return m.saved, nil
+}
+
+// mockIssuer is an OpenID4VCI issuer whose authorization server metadata is served separately.
+type mockIssuer struct {
+	*httptest.Server
+	t         *testing.T
+	offer     interface{}
+	metadata  interface{}
+	failPath  string
+	tokenForm url.Values
+	nonces    []string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	issuer := &mockIssuer{t: t}
+	issuer.Server = httptest.NewServer(http.HandlerFunc(issuer.serveHTTP))
+	issuer.metadata = map[string]interface{}{
+		"credential_issuer":   issuer.URL,
+		"credential_endpoint": issuer.URL + "/credential",
+		"credentials_supported": []interface{}{
+			map[string]interface{}{
+				"id": "UniversityDegree", "format": "jwt_vc_json", "types": json.RawMessage(degreeTypes),
+			},
+		},
+	}
+
+	return issuer
+}
+
+func (m *mockIssuer) preAuthorizedOffer(pinRequired bool) interface{} {
+	return map[string]interface{}{
+		"credential_issuer": m.URL,
+		"credentials": []interface{}{
+			"UniversityDegree",
+			map[string]interface{}{"format": "jwt_vc_json", "types": json.RawMessage(degreeTypes)},
+		},
+		"grants": map[string]interface{}{
+			preAuthorizedGrantType: map[string]interface{}{
+				"pre-authorized_code": "pre-auth",
+				"user_pin_required":   pinRequired,
+			},
+		},
+	}
+}
+
+func (m *mockIssuer) authorizationCodeOffer() interface{} {
+	return map[string]interface{}{
+		"credential_issuer": m.URL,
+		"credentials":       []string{"UniversityDegree", "UniversityDegree"},
+		"grants": map[string]interface{}{
+			"authorization_code": map[string]interface{}{"issuer_state": "issuer-state"},
+		},
+	}
+}
+
+func (m *mockIssuer) offerURI(t *testing.T, offer interface{}) string {
+	t.Helper()
+
+	return "openid-credential-offer://?credential_offer=" + url.QueryEscape(string(marshal(t, offer)))
+}
+
+func (m *mockIssuer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == m.failPath {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	var response interface{}
+
+	switch r.URL.Path {
+	case "/offer":
+		response = m.offer
+	case issuerMetadataPath:
+		response = m.metadata
+	case authServerMetadataPath:
+		response = &authServerMetadata{AuthorizationEndpoint: m.URL + "/authorize", TokenEndpoint: m.URL + "/token"}
+	case "/token":
+		require.NoError(m.t, r.ParseForm())
+		m.tokenForm = r.PostForm
+
+		response = &tokenResponse{AccessToken: "access-token", TokenType: "bearer", CNonce: "token-nonce"}
+	case "/credential":
+		require.Equal(m.t, "Bearer access-token", r.Header.Get("Authorization"))
+
+		request := &credentialRequest{}
+		require.NoError(m.t, json.NewDecoder(r.Body).Decode(request))
+		require.Equal(m.t, proofType, request.Proof.ProofType)
+
+		header := map[string]string{}
+		headerBits, err := base64.RawURLEncoding.DecodeString(strings.Split(request.Proof.JWT, ".")[0])
+		require.NoError(m.t, err)
+		require.NoError(m.t, json.Unmarshal(headerBits, &header))
+		require.Equal(m.t, proofJWTType, header["typ"])
+		require.Equal(m.t, holderDID+"#key1", header["kid"])
+
+		claims := &proofClaims{}
+		require.NoError(m.t, decodeJWTClaims(request.Proof.JWT, claims))
+		require.Equal(m.t, m.URL, claims.Audience)
+		m.nonces = append(m.nonces, claims.Nonce)
+
+		response = &credentialResponse{
+			Format:     request.Format,
+			Credential: json.RawMessage(`"issued.credential.jwt"`),
+			CNonce:     "credential-nonce",
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	_, err := w.Write(marshal(m.t, response))
+	require.NoError(m.t, err)
+}
+
+// newOIDC4VCIOperation returns an Operation able to redeem offers for a new user.
+func newOIDC4VCIOperation(t *testing.T) (*Operation, string) {
+	t.Helper()
+
+	c := config()
+	c.OIDC4VCI = &OIDC4VCIConfig{
+		ClientID:         "wallet",
+		RedirectURL:      "https://wallet.example.com/agent/oidc4vci/callback",
+		Vault:            &mockVault{},
+		TransientStorage: mockstore.NewMockStoreProvider(),
+	}
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	signer := newInteractionOperation(t)
+	o.vdr, o.kms, o.crypto = signer.vdr, signer.kms, signer.crypto
+
+	return o, uuid.New().String()
+}
+
+func loginAs(o *Operation, sub string) {
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: sub}}}
+}
+
+func redeemAs(t *testing.T, o *Operation, sub string, request, response interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	loginAs(o, sub)
+
+	return handle(t, o.redeemOfferHandler, credentialOffersPath, request, response)
+}
+
+func callbackAs(t *testing.T, o *Operation, sub, query string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	loginAs(o, sub)
+
+	w := httptest.NewRecorder()
+	o.oidc4vciCallbackHandler(w, httptest.NewRequest(http.MethodGet, oidc4vciCallbackPath+query, nil))
+
+	return w
+}
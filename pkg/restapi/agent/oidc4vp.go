@@ -0,0 +1,633 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Endpoints.
+const (
+	presentationRequestsPath  = "/oidc4vp/requests"
+	presentationResponsesPath = "/oidc4vp/responses"
+)
+
+const (
+	oidc4vpStoreName       = "edgeagent_oidc4vp_trx"
+	vpTokenResponseType    = "vp_token"
+	directPostResponseMode = "direct_post"
+	fragmentResponseMode   = "fragment"
+)
+
+// Presentation request statuses.
+const (
+	PresentationPending    = "pending"
+	PresentationSubmitting = "submitting"
+	PresentationSubmitted  = "submitted"
+	PresentationFailed     = "failed"
+)
+
+var errInvalidPresentationRequest = errors.New("invalid presentation request")
+
+// OIDC4VPConfig holds configuration for answering OpenID4VP presentation requests.
+type OIDC4VPConfig struct {
+	Vault            CredentialReader
+	TransientStorage storage.Provider
+}
+
+// CredentialReader reads the credentials of a user.
+type CredentialReader interface {
+	Credentials(sub string) (map[string][]byte, error)
+}
+
+// ResolvePresentationRequest is the body of a request to resolve an OpenID4VP authorization request.
+type ResolvePresentationRequest struct {
+	Request string `json:"request"`
+}
+
+// SubmitPresentationRequest asks the agent to answer a presentation request on behalf of the user.
+// Credentials maps input descriptor ids to the id of the credential the user chose; descriptors
+// left out are answered with their first matching credential.
+type SubmitPresentationRequest struct {
+	ID          string            `json:"id"`
+	Holder      string            `json:"holder"`
+	Credentials map[string]string `json:"credentials,omitempty"`
+}
+
+// PresentationSession is the progress of a presentation request, as shown to the wallet UI.
+type PresentationSession struct {
+	ID          string             `json:"id"`
+	Status      string             `json:"status"`
+	Verifier    string             `json:"verifier"`
+	Name        string             `json:"name,omitempty"`
+	Purpose     string             `json:"purpose,omitempty"`
+	Matches     []*DescriptorMatch `json:"matches"`
+	RedirectURL string             `json:"redirectURL,omitempty"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// DescriptorMatch lists the credentials of the user's vault that satisfy an input descriptor.
+type DescriptorMatch struct {
+	ID          string               `json:"id"`
+	Name        string               `json:"name,omitempty"`
+	Purpose     string               `json:"purpose,omitempty"`
+	Credentials []*MatchedCredential `json:"credentials"`
+}
+
+// MatchedCredential is a credential of the user's vault.
+type MatchedCredential struct {
+	ID         string          `json:"id"`
+	Format     string          `json:"format"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+// authorizationRequest is an OpenID4VP authorization request, passed by value or in a request object.
+type authorizationRequest struct {
+	ClientID                  string                  `json:"client_id"`
+	ResponseType              string                  `json:"response_type,omitempty"`
+	ResponseMode              string                  `json:"response_mode,omitempty"`
+	ResponseURI               string                  `json:"response_uri,omitempty"`
+	RedirectURI               string                  `json:"redirect_uri,omitempty"`
+	Nonce                     string                  `json:"nonce"`
+	State                     string                  `json:"state,omitempty"`
+	PresentationDefinition    *presentationDefinition `json:"presentation_definition,omitempty"`
+	PresentationDefinitionURI string                  `json:"presentation_definition_uri,omitempty"`
+}
+
+type presentationSubmission struct {
+	ID            string               `json:"id"`
+	DefinitionID  string               `json:"definition_id"`
+	DescriptorMap []*descriptorMapping `json:"descriptor_map"`
+}
+
+type descriptorMapping struct {
+	ID         string             `json:"id"`
+	Format     string             `json:"format"`
+	Path       string             `json:"path"`
+	PathNested *descriptorMapping `json:"path_nested,omitempty"`
+}
+
+type directPostReply struct {
+	RedirectURI string `json:"redirect_uri,omitempty"`
+}
+
+// presentationSession is a presentation request kept in the transient store while the user decides
+// what to present.
+type presentationSession struct {
+	*PresentationSession
+	Sub     string                `json:"sub"`
+	Request *authorizationRequest `json:"request"`
+}
+
+func (o *Operation) resolvePresentationRequestHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling resolve presentation request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &ResolvePresentationRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	session, err := o.resolvePresentationRequest(sub, request.Request)
+	if err != nil {
+		writePresentationError(w, "failed to resolve presentation request", err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.WriteResponse(w, logger, session.PresentationSession)
+}
+
+func (o *Operation) presentationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling presentation status request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	session, ok := o.userPresentationSession(w, sub, r.URL.Query().Get("id"))
+	if !ok {
+		return
+	}
+
+	common.WriteResponse(w, logger, session.PresentationSession)
+}
+
+func (o *Operation) submitPresentationHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling submit presentation request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &SubmitPresentationRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	session, ok := o.userPresentationSession(w, sub, request.ID)
+	if !ok {
+		return
+	}
+
+	err = o.submitPresentation(session, request)
+	if err != nil {
+		writePresentationError(w, "failed to submit presentation", err)
+
+		return
+	}
+
+	common.WriteResponse(w, logger, session.PresentationSession)
+}
+
+// userPresentationSession fetches a presentation session of the logged in user, or writes the error response.
+func (o *Operation) userPresentationSession(w http.ResponseWriter, sub, id string) (*presentationSession, bool) {
+	if id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing presentation request id")
+
+		return nil, false
+	}
+
+	session, err := o.presentationSession(id)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "presentation request not found")
+
+		return nil, false
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to fetch presentation request: %s", err.Error())
+
+		return nil, false
+	}
+
+	if session.Sub != sub {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "presentation request belongs to another user")
+
+		return nil, false
+	}
+
+	return session, true
+}
+
+// resolvePresentationRequest parses the authorization request and matches it against the credentials in
+// the user's vault. The session waits in the transient store for the user to submit a presentation.
+func (o *Operation) resolvePresentationRequest(sub, requestURI string) (*presentationSession, error) {
+	request, err := o.parseAuthorizationRequest(requestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := o.oidc4vp.Vault.Credentials(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials from vault: %w", err)
+	}
+
+	matches, err := matchCredentials(request.PresentationDefinition, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &presentationSession{
+		PresentationSession: &PresentationSession{
+			ID:       uuid.New().String(),
+			Status:   PresentationPending,
+			Verifier: request.ClientID,
+			Name:     request.PresentationDefinition.Name,
+			Purpose:  request.PresentationDefinition.Purpose,
+			Matches:  matches,
+		},
+		Sub:     sub,
+		Request: request,
+	}
+
+	err = store.Save(o.presentations, session.ID, session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save presentation request: %w", err)
+	}
+
+	return session, nil
+}
+
+// parseAuthorizationRequest reads the request passed by value or by reference in an OpenID4VP request URI.
+// Like WACI challenge tokens, request objects are not verified: the verifier is shown to the user, and
+// the vp_token is bound to its client_id and nonce.
+func (o *Operation) parseAuthorizationRequest(requestURI string) (*authorizationRequest, error) {
+	u, err := url.Parse(requestURI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidPresentationRequest, err.Error())
+	}
+
+	query := u.Query()
+
+	var request *authorizationRequest
+
+	if query.Get("request_uri") != "" {
+		request, err = o.fetchRequestObject(query.Get("request_uri"))
+		if err != nil {
+			return nil, err
+		}
+
+		if query.Get("client_id") != "" && query.Get("client_id") != request.ClientID {
+			return nil, fmt.Errorf("%w: client_id does not match the request object", errInvalidPresentationRequest)
+		}
+	} else {
+		request = &authorizationRequest{
+			ClientID:                  query.Get("client_id"),
+			ResponseType:              query.Get("response_type"),
+			ResponseMode:              query.Get("response_mode"),
+			ResponseURI:               query.Get("response_uri"),
+			RedirectURI:               query.Get("redirect_uri"),
+			Nonce:                     query.Get("nonce"),
+			State:                     query.Get("state"),
+			PresentationDefinitionURI: query.Get("presentation_definition_uri"),
+		}
+
+		if definition := query.Get("presentation_definition"); definition != "" {
+			request.PresentationDefinition = &presentationDefinition{}
+
+			err = json.Unmarshal([]byte(definition), request.PresentationDefinition)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid presentation_definition: %s",
+					errInvalidPresentationRequest, err.Error())
+			}
+		}
+	}
+
+	if request.PresentationDefinition == nil && request.PresentationDefinitionURI != "" {
+		request.PresentationDefinition, err = o.fetchPresentationDefinition(request.PresentationDefinitionURI)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return request, validateAuthorizationRequest(request)
+}
+
+func (o *Operation) fetchRequestObject(requestURI string) (*authorizationRequest, error) {
+	bits, err := o.sendHTTPRequest(http.MethodGet, requestURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch request object: %w", err)
+	}
+
+	request := &authorizationRequest{}
+
+	if json.Valid(bits) {
+		err = json.Unmarshal(bits, request)
+	} else {
+		err = decodeJWTClaims(strings.TrimSpace(string(bits)), request)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid request object: %s", errInvalidPresentationRequest, err.Error())
+	}
+
+	return request, nil
+}
+
+func (o *Operation) fetchPresentationDefinition(definitionURI string) (*presentationDefinition, error) {
+	bits, err := o.sendHTTPRequest(http.MethodGet, definitionURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch presentation definition: %w", err)
+	}
+
+	definition := &presentationDefinition{}
+
+	err = json.Unmarshal(bits, definition)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid presentation definition: %s", errInvalidPresentationRequest, err.Error())
+	}
+
+	return definition, nil
+}
+
+func validateAuthorizationRequest(request *authorizationRequest) error {
+	switch {
+	case request.ClientID == "":
+		return fmt.Errorf("%w: missing client_id", errInvalidPresentationRequest)
+	case request.ResponseType != "" && request.ResponseType != vpTokenResponseType:
+		return fmt.Errorf("%w: unsupported response_type '%s'", errInvalidPresentationRequest, request.ResponseType)
+	case request.Nonce == "":
+		return fmt.Errorf("%w: missing nonce", errInvalidPresentationRequest)
+	case request.PresentationDefinition == nil || len(request.PresentationDefinition.InputDescriptors) == 0:
+		return fmt.Errorf("%w: missing presentation definition", errInvalidPresentationRequest)
+	}
+
+	switch request.ResponseMode {
+	case directPostResponseMode:
+		if request.ResponseURI == "" {
+			return fmt.Errorf("%w: missing response_uri", errInvalidPresentationRequest)
+		}
+	case "", fragmentResponseMode:
+		if request.RedirectURI == "" {
+			return fmt.Errorf("%w: missing redirect_uri", errInvalidPresentationRequest)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported response_mode '%s'", errInvalidPresentationRequest, request.ResponseMode)
+	}
+
+	return nil
+}
+
+// matchCredentials lists the credentials that satisfy each input descriptor of the definition.
+// Credentials the agent cannot decode are left out.
+func matchCredentials(definition *presentationDefinition, raw map[string][]byte) ([]*DescriptorMatch, error) {
+	ids := make([]string, 0, len(raw))
+
+	for id := range raw {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	credentials := make([]*storedCredential, 0, len(ids))
+
+	for _, id := range ids {
+		credential, err := decodeStoredCredential(id, raw[id])
+		if err != nil {
+			logger.Warnf("skipping credential %s: %s", id, err.Error())
+
+			continue
+		}
+
+		credentials = append(credentials, credential)
+	}
+
+	matches := make([]*DescriptorMatch, len(definition.InputDescriptors))
+
+	for i, descriptor := range definition.InputDescriptors {
+		matches[i] = &DescriptorMatch{
+			ID:          descriptor.ID,
+			Name:        descriptor.Name,
+			Purpose:     descriptor.Purpose,
+			Credentials: []*MatchedCredential{},
+		}
+
+		for _, credential := range credentials {
+			ok, err := descriptor.matches(credential)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				matches[i].Credentials = append(matches[i].Credentials, &MatchedCredential{
+					ID:         credential.id,
+					Format:     credential.format,
+					Credential: credential.raw,
+				})
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// submitPresentation signs a vp_token with the credentials chosen for each input descriptor and sends it
+// to the verifier. The outcome is recorded in the session for the wallet UI.
+func (o *Operation) submitPresentation(session *presentationSession, request *SubmitPresentationRequest) error {
+	if session.Status != PresentationPending {
+		return fmt.Errorf("%w: presentation request is %s", errInvalidPresentationRequest, session.Status)
+	}
+
+	if request.Holder == "" {
+		return fmt.Errorf("%w: a holder is required to submit a presentation", errInvalidPresentationRequest)
+	}
+
+	credentials, submission, err := selectCredentials(session, request.Credentials)
+	if err != nil {
+		return err
+	}
+
+	session.Status = PresentationSubmitting
+
+	err = store.Save(o.presentations, session.ID, session)
+	if err != nil {
+		return fmt.Errorf("failed to save presentation request: %w", err)
+	}
+
+	redirectURL, err := o.sendPresentation(session.Request, request.Holder, credentials, submission)
+	if err != nil {
+		session.Status = PresentationFailed
+		session.Error = err.Error()
+	} else {
+		session.Status = PresentationSubmitted
+		session.RedirectURL = redirectURL
+
+		o.notify(TopicPresentationShared, session.Sub, &presentationSharedPayload{
+			ID:       session.ID,
+			Verifier: session.Verifier,
+			Name:     session.Name,
+		})
+	}
+
+	if errSave := store.Save(o.presentations, session.ID, session); errSave != nil {
+		logger.Errorf("failed to save presentation request %s: %s", session.ID, errSave.Error())
+	}
+
+	return err
+}
+
+// selectCredentials returns the credentials to present, once each, and the submission mapping every input
+// descriptor to its credential inside the vp_token.
+func selectCredentials(session *presentationSession,
+	selections map[string]string) ([]json.RawMessage, *presentationSubmission, error) {
+	var credentials []json.RawMessage
+
+	submission := &presentationSubmission{
+		ID:           uuid.New().String(),
+		DefinitionID: session.Request.PresentationDefinition.ID,
+	}
+
+	indexes := make(map[string]int)
+
+	for _, match := range session.Matches {
+		credential, err := selectCredential(match, selections[match.ID])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		i, ok := indexes[credential.ID]
+		if !ok {
+			i = len(credentials)
+			indexes[credential.ID] = i
+
+			credentials = append(credentials, presentable(credential))
+		}
+
+		submission.DescriptorMap = append(submission.DescriptorMap, &descriptorMapping{
+			ID:     match.ID,
+			Format: formatJWTVP,
+			Path:   "$",
+			PathNested: &descriptorMapping{
+				ID:     match.ID,
+				Format: credential.Format,
+				Path:   fmt.Sprintf("$.vp.verifiableCredential[%d]", i),
+			},
+		})
+	}
+
+	return credentials, submission, nil
+}
+
+func selectCredential(match *DescriptorMatch, id string) (*MatchedCredential, error) {
+	if len(match.Credentials) == 0 {
+		return nil, fmt.Errorf("%w: no credential matches input descriptor %s", errInvalidPresentationRequest, match.ID)
+	}
+
+	if id == "" {
+		return match.Credentials[0], nil
+	}
+
+	for _, credential := range match.Credentials {
+		if credential.ID == id {
+			return credential, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: credential %s does not match input descriptor %s",
+		errInvalidPresentationRequest, id, match.ID)
+}
+
+// presentable returns the credential as it is embedded in a presentation: JWT credentials are
+// stored as JSON strings but embedded as compact JWTs.
+func presentable(credential *MatchedCredential) json.RawMessage {
+	var jwt string
+
+	if credential.Format == formatJWTVC && json.Unmarshal(credential.Credential, &jwt) == nil {
+		return json.RawMessage(jwt)
+	}
+
+	return credential.Credential
+}
+
+// sendPresentation signs the vp_token and sends the authorization response. It returns where the
+// wallet UI should send the user next, if anywhere.
+func (o *Operation) sendPresentation(request *authorizationRequest, holder string,
+	credentials []json.RawMessage, submission *presentationSubmission) (string, error) {
+	vpToken, err := o.signPresentation(holder, credentials, request.ClientID, request.Nonce)
+	if err != nil {
+		return "", err
+	}
+
+	submissionBits, err := json.Marshal(submission)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal presentation submission: %w", err)
+	}
+
+	form := url.Values{
+		"vp_token":                {vpToken},
+		"presentation_submission": {string(submissionBits)},
+	}
+
+	if request.State != "" {
+		form.Set("state", request.State)
+	}
+
+	if request.ResponseMode != directPostResponseMode {
+		return request.RedirectURI + "#" + form.Encode(), nil
+	}
+
+	reply, err := o.postForm(request.ResponseURI, form)
+	if err != nil {
+		return "", fmt.Errorf("failed to post authorization response: %w", err)
+	}
+
+	response := &directPostReply{}
+
+	if len(reply) != 0 && json.Unmarshal(reply, response) != nil {
+		logger.Warnf("ignoring invalid reply to authorization response: %s", string(reply))
+	}
+
+	return response.RedirectURI, nil
+}
+
+func (o *Operation) presentationSession(id string) (*presentationSession, error) {
+	bits, err := o.presentations.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &presentationSession{}
+
+	return session, json.Unmarshal(bits, session)
+}
+
+func writePresentationError(w http.ResponseWriter, msg string, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errInvalidPresentationRequest) {
+		status = http.StatusBadRequest
+	}
+
+	common.WriteErrorResponsef(w, logger, status, "%s: %s", msg, err.Error())
+}
@@ -0,0 +1,492 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+const (
+	verifierID       = "https://verifier.example.com"
+	degreeCredential = `{
+		"@context": ["https://www.w3.org/2018/credentials/v1"],
+		"id": "http://example.edu/credentials/3732",
+		"type": ["VerifiableCredential", "UniversityDegreeCredential"],
+		"issuer": "did:example:76e12ec712ebc6f1c221ebfeb1f",
+		"issuanceDate": "2020-03-10T04:24:12.164Z",
+		"credentialSubject": {"id": "did:example:ebfeb1f712ebc6f1c276e12ec21"}
+	}`
+	degreeDefinition = `{
+		"id": "degree-check",
+		"name": "Degree check",
+		"purpose": "Prove that you graduated",
+		"input_descriptors": [{
+			"id": "degree",
+			"name": "University degree",
+			"schema": [{"uri": "https://www.w3.org/2018/credentials#VerifiableCredential"}],
+			"constraints": {"fields": [{
+				"path": ["$.type"],
+				"filter": {"type": "array", "contains": {"const": "UniversityDegreeCredential"}}
+			}]}
+		}]
+	}`
+)
+
+func TestOperation_ResolvePresentationRequest(t *testing.T) {
+	t.Run("matches the credentials in the user vault", func(t *testing.T) {
+		o, sub := newOIDC4VPOperation(t)
+
+		session := &PresentationSession{}
+
+		w := resolveAs(t, o, sub, presentationRequestURI(presentationRequestParams("https://verifier.example.com")), session)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.NotEmpty(t, session.ID)
+		require.Equal(t, PresentationPending, session.Status)
+		require.Equal(t, verifierID, session.Verifier)
+		require.Equal(t, "Degree check", session.Name)
+		require.Equal(t, "Prove that you graduated", session.Purpose)
+		require.Len(t, session.Matches, 1)
+		require.Equal(t, "degree", session.Matches[0].ID)
+		require.Len(t, session.Matches[0].Credentials, 2)
+		require.Equal(t, "urn:uuid:degree", session.Matches[0].Credentials[0].ID)
+		require.Equal(t, formatLDPVC, session.Matches[0].Credentials[0].Format)
+		require.JSONEq(t, degreeCredential, string(session.Matches[0].Credentials[0].Credential))
+		require.Equal(t, "urn:uuid:jwt", session.Matches[0].Credentials[1].ID)
+		require.Equal(t, formatJWTVC, session.Matches[0].Credentials[1].Format)
+		require.Equal(t, sub, o.oidc4vp.Vault.(*mockVault).sub)
+	})
+
+	t.Run("fetches the request object and the presentation definition", func(t *testing.T) {
+		verifier := newMockVerifier(t)
+		defer verifier.Close()
+
+		params := presentationRequestParams(verifier.URL + "/response")
+		params.Del("presentation_definition")
+		params.Set("presentation_definition_uri", verifier.URL+"/definition")
+
+		claims := make(map[string]string)
+		for k := range params {
+			claims[k] = params.Get(k)
+		}
+
+		verifier.requestObject = "eyJhbGciOiJFUzI1NiJ9." +
+			base64.RawURLEncoding.EncodeToString(marshal(t, claims)) + ".c2lnbmF0dXJl"
+
+		o, sub := newOIDC4VPOperation(t)
+
+		session := &PresentationSession{}
+
+		w := resolveAs(t, o, sub, presentationRequestURI(url.Values{
+			"client_id":   {verifierID},
+			"request_uri": {verifier.URL + "/request"},
+		}), session)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Equal(t, verifierID, session.Verifier)
+		require.Len(t, session.Matches[0].Credentials, 2)
+	})
+
+	t.Run("error if the request is invalid", func(t *testing.T) {
+		for _, test := range []struct {
+			name   string
+			params func(url.Values)
+			msg    string
+		}{
+			{"client_id", func(p url.Values) { p.Del("client_id") }, "missing client_id"},
+			{"response_type", func(p url.Values) { p.Set("response_type", "id_token") }, "unsupported response_type"},
+			{"nonce", func(p url.Values) { p.Del("nonce") }, "missing nonce"},
+			{"definition", func(p url.Values) { p.Del("presentation_definition") }, "missing presentation definition"},
+			{"invalid definition", func(p url.Values) {
+				p.Set("presentation_definition", "{")
+			}, "invalid presentation_definition"},
+			{"response_uri", func(p url.Values) { p.Del("response_uri") }, "missing response_uri"},
+			{"redirect_uri", func(p url.Values) { p.Del("response_mode") }, "missing redirect_uri"},
+			{"response_mode", func(p url.Values) { p.Set("response_mode", "query") }, "unsupported response_mode"},
+			{"json path", func(p url.Values) {
+				p.Set("presentation_definition", `{"input_descriptors": [{"id": "degree", "constraints": {
+					"fields": [{"path": ["$..type"]}]}}]}`)
+			}, "unsupported json path"},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				o, sub := newOIDC4VPOperation(t)
+
+				params := presentationRequestParams("https://verifier.example.com/response")
+				test.params(params)
+
+				w := resolveAs(t, o, sub, presentationRequestURI(params), nil)
+				require.Equal(t, http.StatusBadRequest, w.Code)
+				require.Contains(t, w.Body.String(), test.msg)
+			})
+		}
+	})
+
+	t.Run("error if the request object does not match the client id", func(t *testing.T) {
+		verifier := newMockVerifier(t)
+		defer verifier.Close()
+
+		verifier.requestObject = `{"client_id": "https://other.example.com"}`
+
+		o, sub := newOIDC4VPOperation(t)
+
+		w := resolveAs(t, o, sub, presentationRequestURI(url.Values{
+			"client_id":   {verifierID},
+			"request_uri": {verifier.URL + "/request"},
+		}), nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "client_id does not match the request object")
+	})
+
+	t.Run("error if the request object cannot be fetched", func(t *testing.T) {
+		o, sub := newOIDC4VPOperation(t)
+
+		w := resolveAs(t, o, sub, presentationRequestURI(url.Values{"request_uri": {"http://localhost:-1"}}), nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch request object")
+	})
+
+	t.Run("error if the vault cannot be read", func(t *testing.T) {
+		o, sub := newOIDC4VPOperation(t)
+		o.oidc4vp.Vault.(*mockVault).err = errors.New("test")
+
+		w := resolveAs(t, o, sub, presentationRequestURI(presentationRequestParams("https://verifier.example.com")), nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to read credentials from vault")
+	})
+
+	t.Run("error if not logged in", func(t *testing.T) {
+		o, _ := newOIDC4VPOperation(t)
+
+		w := handle(t, o.resolvePresentationRequestHandler, presentationRequestsPath, &ResolvePresentationRequest{}, nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestOperation_SubmitPresentation(t *testing.T) {
+	t.Run("posts the vp token to the verifier", func(t *testing.T) {
+		verifier := newMockVerifier(t)
+		defer verifier.Close()
+
+		o, sub := newOIDC4VPOperation(t)
+		id := resolveDegreeRequest(t, o, sub, presentationRequestParams(verifier.URL+"/response"))
+
+		session := &PresentationSession{}
+
+		w := submitAs(t, o, sub, &SubmitPresentationRequest{ID: id, Holder: holderDID}, session)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, PresentationSubmitted, session.Status)
+		require.Equal(t, "https://verifier.example.com/done", session.RedirectURL)
+
+		require.Equal(t, "state", verifier.form.Get("state"))
+
+		claims := &struct {
+			Audience string `json:"aud"`
+			Nonce    string `json:"nonce"`
+			VP       struct {
+				Holder      string            `json:"holder"`
+				Credentials []json.RawMessage `json:"verifiableCredential"`
+			} `json:"vp"`
+		}{}
+		require.NoError(t, decodeJWTClaims(verifier.form.Get("vp_token"), claims))
+		require.Equal(t, verifierID, claims.Audience)
+		require.Equal(t, "nonce", claims.Nonce)
+		require.Equal(t, holderDID, claims.VP.Holder)
+		require.Len(t, claims.VP.Credentials, 1)
+
+		submission := &presentationSubmission{}
+		require.NoError(t, json.Unmarshal([]byte(verifier.form.Get("presentation_submission")), submission))
+		require.Equal(t, "degree-check", submission.DefinitionID)
+		require.Len(t, submission.DescriptorMap, 1)
+		require.Equal(t, "degree", submission.DescriptorMap[0].ID)
+		require.Equal(t, formatJWTVP, submission.DescriptorMap[0].Format)
+		require.Equal(t, formatLDPVC, submission.DescriptorMap[0].PathNested.Format)
+		require.Equal(t, "$.vp.verifiableCredential[0]", submission.DescriptorMap[0].PathNested.Path)
+
+		status := &PresentationSession{}
+
+		w = statusAs(t, o, sub, id, status)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, PresentationSubmitted, status.Status)
+	})
+
+	t.Run("presents the credentials chosen by the user", func(t *testing.T) {
+		verifier := newMockVerifier(t)
+		defer verifier.Close()
+
+		o, sub := newOIDC4VPOperation(t)
+		id := resolveDegreeRequest(t, o, sub, presentationRequestParams(verifier.URL+"/response"))
+
+		w := submitAs(t, o, sub, &SubmitPresentationRequest{
+			ID:          id,
+			Holder:      holderDID,
+			Credentials: map[string]string{"degree": "urn:uuid:jwt"},
+		}, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		submission := &presentationSubmission{}
+		require.NoError(t, json.Unmarshal([]byte(verifier.form.Get("presentation_submission")), submission))
+		require.Equal(t, formatJWTVC, submission.DescriptorMap[0].PathNested.Format)
+	})
+
+	t.Run("returns the redirect url in fragment response mode", func(t *testing.T) {
+		o, sub := newOIDC4VPOperation(t)
+
+		params := presentationRequestParams("")
+		params.Del("response_mode")
+		params.Set("redirect_uri", "https://verifier.example.com/callback")
+
+		id := resolveDegreeRequest(t, o, sub, params)
+
+		session := &PresentationSession{}
+
+		w := submitAs(t, o, sub, &SubmitPresentationRequest{ID: id, Holder: holderDID}, session)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, PresentationSubmitted, session.Status)
+
+		redirectURL, err := url.Parse(session.RedirectURL)
+		require.NoError(t, err)
+		require.Equal(t, "verifier.example.com", redirectURL.Host)
+
+		fragment, err := url.ParseQuery(redirectURL.Fragment)
+		require.NoError(t, err)
+		require.NotEmpty(t, fragment.Get("vp_token"))
+		require.Equal(t, "state", fragment.Get("state"))
+	})
+
+	t.Run("records the failure if the verifier rejects the response", func(t *testing.T) {
+		verifier := newMockVerifier(t)
+		defer verifier.Close()
+
+		verifier.status = http.StatusBadRequest
+
+		o, sub := newOIDC4VPOperation(t)
+		id := resolveDegreeRequest(t, o, sub, presentationRequestParams(verifier.URL+"/response"))
+
+		w := submitAs(t, o, sub, &SubmitPresentationRequest{ID: id, Holder: holderDID}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to post authorization response")
+
+		status := &PresentationSession{}
+
+		w = statusAs(t, o, sub, id, status)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, PresentationFailed, status.Status)
+		require.Contains(t, status.Error, "failed to post authorization response")
+
+		w = submitAs(t, o, sub, &SubmitPresentationRequest{ID: id, Holder: holderDID}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "presentation request is failed")
+	})
+
+	t.Run("error if the submission is invalid", func(t *testing.T) {
+		o, sub := newOIDC4VPOperation(t)
+		id := resolveDegreeRequest(t, o, sub, presentationRequestParams("https://verifier.example.com/response"))
+
+		for _, test := range []struct {
+			request *SubmitPresentationRequest
+			msg     string
+		}{
+			{&SubmitPresentationRequest{ID: id}, "a holder is required"},
+			{&SubmitPresentationRequest{
+				ID: id, Holder: holderDID, Credentials: map[string]string{"degree": "urn:uuid:plain"},
+			}, "credential urn:uuid:plain does not match input descriptor degree"},
+		} {
+			w := submitAs(t, o, sub, test.request, nil)
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), test.msg)
+		}
+	})
+
+	t.Run("error if no credential matches a descriptor", func(t *testing.T) {
+		o, sub := newOIDC4VPOperation(t)
+
+		params := presentationRequestParams("https://verifier.example.com/response")
+		params.Set("presentation_definition", `{"input_descriptors": [{"id": "passport", "constraints": {
+			"fields": [{"path": ["$.type"], "filter": {"contains": {"const": "Passport"}}}]}}]}`)
+
+		id := resolveDegreeRequest(t, o, sub, params)
+
+		w := submitAs(t, o, sub, &SubmitPresentationRequest{ID: id, Holder: holderDID}, nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "no credential matches input descriptor passport")
+	})
+
+	t.Run("error if the presentation request is not the user's", func(t *testing.T) {
+		o, sub := newOIDC4VPOperation(t)
+		id := resolveDegreeRequest(t, o, sub, presentationRequestParams("https://verifier.example.com/response"))
+
+		w := submitAs(t, o, uuid.New().String(), &SubmitPresentationRequest{ID: id, Holder: holderDID}, nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = statusAs(t, o, uuid.New().String(), id, nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("error if the presentation request is unknown", func(t *testing.T) {
+		o, sub := newOIDC4VPOperation(t)
+
+		w := submitAs(t, o, sub, &SubmitPresentationRequest{ID: uuid.New().String(), Holder: holderDID}, nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = statusAs(t, o, sub, "", nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing presentation request id")
+	})
+}
+
+// mockVerifier serves request objects and presentation definitions by reference, and receives
+// direct_post authorization responses.
+type mockVerifier struct {
+	*httptest.Server
+	t             *testing.T
+	requestObject string
+	status        int
+	form          url.Values
+}
+
+func newMockVerifier(t *testing.T) *mockVerifier {
+	t.Helper()
+
+	m := &mockVerifier{t: t}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+
+	return m
+}
+
+func (m *mockVerifier) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var body string
+
+	switch r.URL.Path {
+	case "/request":
+		body = m.requestObject
+	case "/definition":
+		body = degreeDefinition
+	case "/response":
+		require.NoError(m.t, r.ParseForm())
+		m.form = r.PostForm
+
+		if m.status != 0 {
+			w.WriteHeader(m.status)
+
+			return
+		}
+
+		body = `{"redirect_uri": "https://verifier.example.com/done"}`
+	default:
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	_, err := w.Write([]byte(body))
+	require.NoError(m.t, err)
+}
+
+func newOIDC4VPOperation(t *testing.T) (*Operation, string) {
+	t.Helper()
+
+	c := config()
+	c.OIDC4VP = &OIDC4VPConfig{
+		Vault: &mockVault{saved: map[string][]byte{
+			"urn:uuid:degree": []byte(degreeCredential),
+			"urn:uuid:jwt":    jwtCredential(t),
+			"urn:uuid:plain":  []byte(credential),
+			"urn:uuid:junk":   []byte("42"),
+		}},
+		TransientStorage: mockstore.NewMockStoreProvider(),
+	}
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	signer := newInteractionOperation(t)
+	o.vdr, o.kms, o.crypto = signer.vdr, signer.kms, signer.crypto
+
+	return o, uuid.New().String()
+}
+
+// jwtCredential returns the degree credential as a JWT, saved as a JSON string like OpenID4VCI does.
+func jwtCredential(t *testing.T) []byte {
+	t.Helper()
+
+	claims := map[string]interface{}{
+		"iss": "did:example:76e12ec712ebc6f1c221ebfeb1f",
+		"sub": "did:example:ebfeb1f712ebc6f1c276e12ec21",
+		"jti": "http://example.edu/credentials/3733",
+		"nbf": 1583814252,
+		"vc":  json.RawMessage(degreeCredential),
+	}
+
+	return marshal(t, "eyJhbGciOiJFZERTQSJ9."+base64.RawURLEncoding.EncodeToString(marshal(t, claims))+".c2ln")
+}
+
+func presentationRequestParams(responseURI string) url.Values {
+	return url.Values{
+		"client_id":               {verifierID},
+		"response_type":           {vpTokenResponseType},
+		"response_mode":           {directPostResponseMode},
+		"response_uri":            {responseURI},
+		"nonce":                   {"nonce"},
+		"state":                   {"state"},
+		"presentation_definition": {degreeDefinition},
+	}
+}
+
+func presentationRequestURI(params url.Values) string {
+	return "openid4vp://?" + params.Encode()
+}
+
+func resolveDegreeRequest(t *testing.T, o *Operation, sub string, params url.Values) string {
+	t.Helper()
+
+	session := &PresentationSession{}
+
+	w := resolveAs(t, o, sub, presentationRequestURI(params), session)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	return session.ID
+}
+
+func resolveAs(t *testing.T, o *Operation, sub, requestURI string, response interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	loginAs(o, sub)
+
+	return handle(t, o.resolvePresentationRequestHandler, presentationRequestsPath,
+		&ResolvePresentationRequest{Request: requestURI}, response)
+}
+
+func submitAs(t *testing.T, o *Operation, sub string, request, response interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	loginAs(o, sub)
+
+	return handle(t, o.submitPresentationHandler, presentationResponsesPath, request, response)
+}
+
+func statusAs(t *testing.T, o *Operation, sub, id string, response interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	loginAs(o, sub)
+
+	w := httptest.NewRecorder()
+	o.presentationStatusHandler(w, httptest.NewRequest(http.MethodGet, presentationRequestsPath+"?id="+id, nil))
+
+	if response != nil && w.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(response))
+	}
+
+	return w
+}
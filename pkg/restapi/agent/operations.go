@@ -0,0 +1,510 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/client/outofband"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/hyperledger/aries-framework-go/pkg/store/connection"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/proxy"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Endpoints.
+const (
+	didsPath        = "/dids"
+	invitationsPath = "/invitations"
+	connectionsPath = "/connections"
+)
+
+const (
+	userSubCookieName = "user_sub"
+	defaultDIDMethod  = "peer"
+	defaultLabel      = "edge-agent"
+)
+
+var logger = log.New("edge-agent/aries-agent")
+
+// Provider is the context of the embedded Aries framework.
+type Provider interface {
+	Service(id string) (interface{}, error)
+	KMS() kms.KeyManager
+	Crypto() crypto.Crypto
+	ServiceEndpoint() string
+	StorageProvider() ariesstorage.Provider
+	ProtocolStateStorageProvider() ariesstorage.Provider
+	VDRegistry() vdrapi.Registry
+}
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Aries     Provider
+	Label     string
+	Keys      *KeyConfig
+	TLSConfig *tls.Config
+	// Proxy routes the requests to the credential issuers through an outbound proxy.
+	Proxy    proxy.Func
+	OIDC4VCI *OIDC4VCIConfig
+	OIDC4VP  *OIDC4VPConfig
+	Events   *EventsConfig
+	// Storage keeps track of the user owning each DID, so that the DIDs are backed up with the wallets.
+	// The DIDs are not tracked if nil.
+	Storage storage.Provider
+	// KeyRotation rotates the keys of the DIDs on request of the users, and on a schedule. The keys are never
+	// rotated if nil.
+	KeyRotation *KeyRotationConfig
+	// Transfers enables the transfer of credentials between the users over their DIDComm connections. The
+	// credentials cannot be transferred if nil.
+	Transfers *TransferConfig
+	// Features gates the redemption of credential offers with the OIDC4VCI flag. The feature is enabled if nil.
+	Features *features.Service
+}
+
+// KeyConfig holds configuration for cryptographic keys.
+type KeyConfig struct {
+	Auth []byte
+	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
+}
+
+type didRegistry interface {
+	Create(method string, opts ...vdrapi.DocOpts) (*did.Doc, error)
+	Resolve(did string, opts ...vdrapi.ResolveOpts) (*did.Doc, error)
+	Store(doc *did.Doc) error
+}
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type oobClient interface {
+	AcceptInvitation(i *outofband.Invitation, myLabel string, opts ...outofband.MessageOption) (string, error)
+}
+
+type connectionClient interface {
+	QueryConnections(request *didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error)
+}
+
+// Operation implements the DID and connection management operations of the embedded agent.
+type Operation struct {
+	cookies          cookie.Store
+	vdr              didRegistry
+	kms              kms.KeyManager
+	crypto           crypto.Crypto
+	oob              oobClient
+	connections      connectionClient
+	httpClient       httpClient
+	label            string
+	oidc4vci         *OIDC4VCIConfig
+	vault            CredentialVault
+	issuances        storage.Store
+	oidc4vp          *OIDC4VPConfig
+	presentations    storage.Store
+	events           *EventsConfig
+	connectionOwners storage.Store
+	features         *features.Service
+	didOwners        storage.Store
+	records          connectionRecorder
+	keyRotations     *keyRotations
+	transfers        *transfers
+}
+
+// CreateDIDRequest is the body of a create DID request.
+type CreateDIDRequest struct {
+	Method string `json:"method,omitempty"`
+}
+
+// AcceptInvitationRequest is the body of an accept invitation request.
+type AcceptInvitationRequest struct {
+	Invitation *outofband.Invitation `json:"invitation"`
+	Label      string                `json:"label,omitempty"`
+}
+
+// AcceptInvitationResponse is the response to an accept invitation request.
+type AcceptInvitationResponse struct {
+	ConnectionID string `json:"connectionID"`
+}
+
+// ConnectionsResponse is the response to a connections query.
+type ConnectionsResponse struct {
+	Connections []*didexchange.Connection `json:"connections"`
+}
+
+// New returns a new Operation.
+func New(config *Config) (*Operation, error) {
+	oob, err := outofband.New(config.Aries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create out-of-band client: %w", err)
+	}
+
+	exchange, err := didexchange.New(config.Aries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create did-exchange client: %w", err)
+	}
+
+	// the agent completes the exchanges started by accepted invitations on its own
+	actions := make(chan service.DIDCommAction)
+
+	err = exchange.RegisterActionEvent(actions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register for did-exchange actions: %w", err)
+	}
+
+	go service.AutoExecuteActionEvent(actions)
+
+	records, err := connection.NewRecorder(config.Aries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection recorder: %w", err)
+	}
+
+	label := config.Label
+	if label == "" {
+		label = defaultLabel
+	}
+
+	op := &Operation{
+		cookies:     cookie.NewStore(config.Keys.Auth, config.Keys.Enc, cookie.WithKeyRing(config.Keys.Ring)),
+		vdr:         config.Aries.VDRegistry(),
+		kms:         config.Aries.KMS(),
+		crypto:      config.Aries.Crypto(),
+		oob:         oob,
+		connections: exchange,
+		httpClient:  &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLSConfig, Proxy: config.Proxy}},
+		label:       label,
+		records:     records,
+		features:    config.Features,
+	}
+
+	if config.Storage != nil {
+		op.didOwners, err = store.Open(config.Storage, didsStoreName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open dids store: %w", err)
+		}
+	}
+
+	if config.OIDC4VCI != nil {
+		op.oidc4vci = config.OIDC4VCI
+		op.vault = config.OIDC4VCI.Vault
+
+		op.issuances, err = store.Open(config.OIDC4VCI.TransientStorage, oidc4vciStoreName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open oidc4vci store: %w", err)
+		}
+	}
+
+	if config.OIDC4VP != nil {
+		op.oidc4vp = config.OIDC4VP
+
+		op.presentations, err = store.Open(config.OIDC4VP.TransientStorage, oidc4vpStoreName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open oidc4vp store: %w", err)
+		}
+	}
+
+	if config.Events != nil {
+		err = op.watchEvents(config.Events, exchange)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Transfers != nil {
+		err = op.watchTransfers(config.Transfers, config.Aries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.KeyRotation != nil {
+		op.keyRotations, err = newKeyRotations(config.KeyRotation, config.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		op.keyRotations.schedule(op.checkKeys)
+	}
+
+	return op, nil
+}
+
+func (o *Operation) watchEvents(config *EventsConfig, exchange *didexchange.Client) error {
+	var err error
+
+	o.events = config
+
+	o.connectionOwners, err = store.Open(config.Storage, connectionsStoreName)
+	if err != nil {
+		return fmt.Errorf("failed to open connections store: %w", err)
+	}
+
+	states := make(chan service.StateMsg)
+
+	err = exchange.RegisterMsgEvent(states)
+	if err != nil {
+		return fmt.Errorf("failed to register for did-exchange messages: %w", err)
+	}
+
+	go o.watchExchange(states)
+
+	return nil
+}
+
+// GetRESTHandlers get all controller API handler available for this service.
+func (o *Operation) GetRESTHandlers() []common.Handler { // nolint:funlen // one spec per handler
+	handlers := []common.Handler{
+		common.NewHTTPHandler(didsPath, http.MethodPost, o.createDIDHandler, &common.OperationSpec{
+			Summary:   "Creates a DID of the user logged in and returns its DID document.",
+			Request:   &CreateDIDRequest{},
+			Responses: map[int]interface{}{http.StatusCreated: json.RawMessage{}},
+		}),
+		common.NewHTTPHandler(invitationsPath, http.MethodPost, o.acceptInvitationHandler, &common.OperationSpec{
+			Summary:   "Accepts an out-of-band invitation.",
+			Request:   &AcceptInvitationRequest{},
+			Responses: map[int]interface{}{http.StatusOK: &AcceptInvitationResponse{}},
+		}),
+		common.NewHTTPHandler(connectionsPath, http.MethodGet, o.connectionsHandler, &common.OperationSpec{
+			Summary: "Lists the DIDComm connections of the user logged in.",
+			Params: []common.Param{
+				common.QueryParam("state", "State of the connections."),
+				common.QueryParam("my_did", "DID of the user."),
+				common.QueryParam("their_did", "DID of the other party."),
+			},
+			Responses: map[int]interface{}{http.StatusOK: &ConnectionsResponse{}},
+		}),
+		common.NewHTTPHandler(resolveInteractionPath, http.MethodPost, o.resolveInteractionHandler, &common.OperationSpec{
+			Summary:   "Resolves a CHAPI or WACI interaction.",
+			Request:   &InteractionRequest{},
+			Responses: map[int]interface{}{http.StatusOK: &Interaction{}},
+		}),
+		common.NewHTTPHandler(respondInteractionPath, http.MethodPost, o.respondInteractionHandler, &common.OperationSpec{
+			Summary:   "Responds to an interaction.",
+			Request:   &RespondRequest{},
+			Responses: map[int]interface{}{http.StatusOK: &RespondResponse{}},
+		}),
+	}
+
+	if o.oidc4vci != nil {
+		handlers = append(handlers,
+			common.NewHTTPHandler(credentialOffersPath, http.MethodPost, o.redeemOfferHandler, &common.OperationSpec{
+				Summary:   "Redeems an OIDC4VCI credential offer.",
+				Request:   &RedeemOfferRequest{},
+				Responses: map[int]interface{}{http.StatusOK: &RedeemOfferResponse{}},
+			}),
+			common.NewHTTPHandler(oidc4vciCallbackPath, http.MethodGet, o.oidc4vciCallbackHandler, &common.OperationSpec{
+				Summary: "Completes the redemption of an offer with the authorization code of the issuer.",
+				Params: []common.Param{
+					common.QueryParam("state", "State of the authorization request."),
+					common.QueryParam("code", "Authorization code."),
+					common.QueryParam("error", "Error of the authorization."),
+				},
+				Responses: map[int]interface{}{http.StatusOK: &RedeemOfferResponse{}},
+			}),
+		)
+	}
+
+	if o.oidc4vp != nil {
+		handlers = append(handlers,
+			common.NewHTTPHandler(presentationRequestsPath, http.MethodPost, o.resolvePresentationRequestHandler,
+				&common.OperationSpec{
+					Summary:   "Resolves an OIDC4VP presentation request.",
+					Request:   &ResolvePresentationRequest{},
+					Responses: map[int]interface{}{http.StatusCreated: &PresentationSession{}},
+				}),
+			common.NewHTTPHandler(presentationRequestsPath, http.MethodGet, o.presentationStatusHandler,
+				&common.OperationSpec{
+					Summary:   "Returns a presentation session.",
+					Params:    []common.Param{{Name: "id", In: common.InQuery, Description: "Id of the session.", Required: true}},
+					Responses: map[int]interface{}{http.StatusOK: &PresentationSession{}},
+				}),
+			common.NewHTTPHandler(presentationResponsesPath, http.MethodPost, o.submitPresentationHandler,
+				&common.OperationSpec{
+					Summary:   "Submits the presentation of the selected credentials.",
+					Request:   &SubmitPresentationRequest{},
+					Responses: map[int]interface{}{http.StatusOK: &PresentationSession{}},
+				}),
+		)
+	}
+
+	if o.keyRotations != nil {
+		handlers = append(handlers, o.keyRotationHandlers()...)
+	}
+
+	if o.transfers != nil {
+		handlers = append(handlers, o.transferHandlers()...)
+	}
+
+	return handlers
+}
+
+func (o *Operation) createDIDHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling create did request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &CreateDIDRequest{}
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(request)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+			return
+		}
+	}
+
+	method := request.Method
+	if method == "" {
+		method = defaultDIDMethod
+	}
+
+	doc, err := o.vdr.Create(method)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to create did: %s", err.Error())
+
+		return
+	}
+
+	bits, err := doc.JSONBytes()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to marshal did document: %s", err.Error())
+
+		return
+	}
+
+	if o.didOwners != nil {
+		err = o.didOwners.Put(doc.ID, []byte(sub))
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to save owner of did %s: %s", doc.ID, err.Error())
+
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.WriteResponse(w, logger, json.RawMessage(bits))
+}
+
+func (o *Operation) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling accept invitation request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &AcceptInvitationRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if request.Invitation == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing invitation")
+
+		return
+	}
+
+	label := request.Label
+	if label == "" {
+		label = o.label
+	}
+
+	connectionID, err := o.oob.AcceptInvitation(request.Invitation, label)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to accept invitation: %s", err.Error())
+
+		return
+	}
+
+	err = o.saveConnectionOwner(connectionID, sub)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &AcceptInvitationResponse{ConnectionID: connectionID})
+}
+
+func (o *Operation) connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling connections request")
+
+	if !o.loggedIn(w, r) {
+		return
+	}
+
+	connections, err := o.connections.QueryConnections(&didexchange.QueryConnectionsParams{
+		State:    r.URL.Query().Get("state"),
+		MyDID:    r.URL.Query().Get("my_did"),
+		TheirDID: r.URL.Query().Get("their_did"),
+	})
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to query connections: %s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &ConnectionsResponse{Connections: connections})
+}
+
+func (o *Operation) loggedIn(w http.ResponseWriter, r *http.Request) bool {
+	_, ok := o.userSub(w, r)
+
+	return ok
+}
+
+func (o *Operation) userSub(w http.ResponseWriter, r *http.Request) (string, bool) {
+	jar, err := o.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return "", false
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return "", false
+	}
+
+	sub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return "", false
+	}
+
+	return sub, true
+}
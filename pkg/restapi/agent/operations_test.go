@@ -0,0 +1,394 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/client/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/client/outofband"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	protocoldidexchange "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/didexchange"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/mediator"
+	protocoloutofband "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/outofband"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	mockdidexchange "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/protocol/didexchange"
+	mockroute "github.com/hyperledger/aries-framework-go/pkg/mock/didcomm/protocol/mediator"
+	mockprovider "github.com/hyperledger/aries-framework-go/pkg/mock/provider"
+	mockstorage "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/store/connection"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		o, err := New(config())
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 5)
+		require.Equal(t, defaultLabel, o.label)
+	})
+
+	t.Run("registers the oidc4vci handlers", func(t *testing.T) {
+		c := config()
+		c.OIDC4VCI = &OIDC4VCIConfig{Vault: &mockVault{}, TransientStorage: mockstore.NewMockStoreProvider()}
+
+		o, err := New(c)
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 7)
+	})
+
+	t.Run("error if oidc4vci store cannot be opened", func(t *testing.T) {
+		c := config()
+		c.OIDC4VCI = &OIDC4VCIConfig{TransientStorage: &mockstore.Provider{ErrCreateStore: errors.New("test")}}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open oidc4vci store")
+	})
+
+	t.Run("registers the oidc4vp handlers", func(t *testing.T) {
+		c := config()
+		c.OIDC4VP = &OIDC4VPConfig{Vault: &mockVault{}, TransientStorage: mockstore.NewMockStoreProvider()}
+
+		o, err := New(c)
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 8)
+	})
+
+	t.Run("error if oidc4vp store cannot be opened", func(t *testing.T) {
+		c := config()
+		c.OIDC4VP = &OIDC4VPConfig{TransientStorage: &mockstore.Provider{ErrCreateStore: errors.New("test")}}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open oidc4vp store")
+	})
+
+	t.Run("error if out-of-band service is missing", func(t *testing.T) {
+		c := config()
+		c.Aries.(*mockprovider.Provider).ServiceErr = errors.New("test")
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create out-of-band client")
+	})
+
+	t.Run("error if did-exchange service is missing", func(t *testing.T) {
+		c := config()
+		delete(c.Aries.(*mockprovider.Provider).ServiceMap, protocoldidexchange.DIDExchange)
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create did-exchange client")
+	})
+
+	t.Run("error if did-exchange actions cannot be registered", func(t *testing.T) {
+		c := config()
+		c.Aries.(*mockprovider.Provider).ServiceMap[protocoldidexchange.DIDExchange] =
+			&mockdidexchange.MockDIDExchangeSvc{RegisterActionEventErr: errors.New("test")}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to register for did-exchange actions")
+	})
+}
+
+func TestOperation_CreateDID(t *testing.T) {
+	t.Run("creates a peer did by default", func(t *testing.T) {
+		o := newOperation(t)
+		o.vdr = &mockvdr.MockVDRegistry{
+			CreateFunc: func(method string, _ ...vdrapi.DocOpts) (*did.Doc, error) {
+				require.Equal(t, defaultDIDMethod, method)
+
+				return &did.Doc{Context: []string{did.Context}, ID: "did:peer:123"}, nil
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.createDIDHandler(w, httptest.NewRequest(http.MethodPost, didsPath, nil))
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), "did:peer:123")
+	})
+
+	t.Run("creates a did with the requested method", func(t *testing.T) {
+		o := newOperation(t)
+		o.vdr = &mockvdr.MockVDRegistry{
+			CreateFunc: func(method string, _ ...vdrapi.DocOpts) (*did.Doc, error) {
+				require.Equal(t, "trustbloc", method)
+
+				return &did.Doc{Context: []string{did.Context}, ID: "did:trustbloc:123"}, nil
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.createDIDHandler(w, httptest.NewRequest(http.MethodPost, didsPath,
+			bytes.NewReader(marshal(t, &CreateDIDRequest{Method: "trustbloc"}))))
+		require.Equal(t, http.StatusCreated, w.Code)
+	})
+
+	t.Run("bad request if body is invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newOperation(t).createDIDHandler(w, httptest.NewRequest(http.MethodPost, didsPath,
+			bytes.NewReader([]byte("{"))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("internal server error if did cannot be created", func(t *testing.T) {
+		o := newOperation(t)
+		o.vdr = &mockvdr.MockVDRegistry{CreateErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.createDIDHandler(w, httptest.NewRequest(http.MethodPost, didsPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to create did")
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o := newOperation(t)
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		w := httptest.NewRecorder()
+		o.createDIDHandler(w, httptest.NewRequest(http.MethodPost, didsPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("bad request if cookies cannot be opened", func(t *testing.T) {
+		o := newOperation(t)
+		o.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.createDIDHandler(w, httptest.NewRequest(http.MethodPost, didsPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestOperation_AcceptInvitation(t *testing.T) {
+	invitation := &outofband.Invitation{ID: uuid.New().String(), Type: outofband.InvitationMsgType}
+
+	t.Run("accepts the invitation", func(t *testing.T) {
+		connectionID := uuid.New().String()
+		o := newOperation(t)
+		o.oob = &mockOOBClient{
+			acceptFunc: func(i *outofband.Invitation, label string) (string, error) {
+				require.Equal(t, invitation.ID, i.ID)
+				require.Equal(t, "wallet", label)
+
+				return connectionID, nil
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.acceptInvitationHandler(w, httptest.NewRequest(http.MethodPost, invitationsPath,
+			bytes.NewReader(marshal(t, &AcceptInvitationRequest{Invitation: invitation, Label: "wallet"}))))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		response := &AcceptInvitationResponse{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(response))
+		require.Equal(t, connectionID, response.ConnectionID)
+	})
+
+	t.Run("uses the default label", func(t *testing.T) {
+		o := newOperation(t)
+		o.oob = &mockOOBClient{
+			acceptFunc: func(_ *outofband.Invitation, label string) (string, error) {
+				require.Equal(t, defaultLabel, label)
+
+				return uuid.New().String(), nil
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.acceptInvitationHandler(w, httptest.NewRequest(http.MethodPost, invitationsPath,
+			bytes.NewReader(marshal(t, &AcceptInvitationRequest{Invitation: invitation}))))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("bad request if body is invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newOperation(t).acceptInvitationHandler(w, httptest.NewRequest(http.MethodPost, invitationsPath,
+			bytes.NewReader([]byte("{"))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("bad request if invitation is missing", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newOperation(t).acceptInvitationHandler(w, httptest.NewRequest(http.MethodPost, invitationsPath,
+			bytes.NewReader([]byte("{}"))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing invitation")
+	})
+
+	t.Run("internal server error if invitation cannot be accepted", func(t *testing.T) {
+		o := newOperation(t)
+		o.oob = &mockOOBClient{
+			acceptFunc: func(*outofband.Invitation, string) (string, error) {
+				return "", errors.New("test")
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.acceptInvitationHandler(w, httptest.NewRequest(http.MethodPost, invitationsPath,
+			bytes.NewReader(marshal(t, &AcceptInvitationRequest{Invitation: invitation}))))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o := newOperation(t)
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		w := httptest.NewRecorder()
+		o.acceptInvitationHandler(w, httptest.NewRequest(http.MethodPost, invitationsPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestOperation_Connections(t *testing.T) {
+	t.Run("lists connections", func(t *testing.T) {
+		o := newOperation(t)
+		o.connections = &mockConnectionClient{
+			queryFunc: func(params *didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error) {
+				require.Equal(t, "completed", params.State)
+				require.Equal(t, "did:example:123", params.TheirDID)
+
+				return []*didexchange.Connection{{Record: &connection.Record{ConnectionID: "abc"}}}, nil
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.connectionsHandler(w, httptest.NewRequest(http.MethodGet,
+			connectionsPath+"?state=completed&their_did=did:example:123", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		response := &ConnectionsResponse{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(response))
+		require.Len(t, response.Connections, 1)
+		require.Equal(t, "abc", response.Connections[0].ConnectionID)
+	})
+
+	t.Run("internal server error if connections cannot be queried", func(t *testing.T) {
+		o := newOperation(t)
+		o.connections = &mockConnectionClient{
+			queryFunc: func(*didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error) {
+				return nil, errors.New("test")
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.connectionsHandler(w, httptest.NewRequest(http.MethodGet, connectionsPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o := newOperation(t)
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		w := httptest.NewRecorder()
+		o.connectionsHandler(w, httptest.NewRequest(http.MethodGet, connectionsPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func config() *Config {
+	storeProvider := mockstorage.NewMockStoreProvider()
+
+	return &Config{
+		Aries: &mockprovider.Provider{
+			ServiceMap: map[string]interface{}{
+				protocoloutofband.Name:          &mockOOBService{Event: &mockdidexchange.MockDIDExchangeSvc{}},
+				protocoldidexchange.DIDExchange: &mockdidexchange.MockDIDExchangeSvc{},
+				mediator.Coordination:           &mockroute.MockMediatorSvc{},
+			},
+			StorageProviderValue:              storeProvider,
+			ProtocolStateStorageProviderValue: storeProvider,
+			VDRegistryValue:                   &mockvdr.MockVDRegistry{},
+		},
+		Keys: &KeyConfig{},
+	}
+}
+
+func newOperation(t *testing.T) *Operation {
+	t.Helper()
+
+	o, err := New(config())
+	require.NoError(t, err)
+
+	// a logged in user
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{
+		userSubCookieName: uuid.New().String(),
+	}}}
+
+	return o
+}
+
+func marshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	bits, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	return bits
+}
+
+type mockOOBService struct {
+	service.Event
+}
+
+func (m *mockOOBService) AcceptRequest(*protocoloutofband.Request, string, []string) (string, error) {
+	return "", nil
+}
+
+func (m *mockOOBService) AcceptInvitation(*protocoloutofband.Invitation, string, []string) (string, error) {
+	return "", nil
+}
+
+func (m *mockOOBService) SaveRequest(*protocoloutofband.Request) error {
+	return nil
+}
+
+func (m *mockOOBService) SaveInvitation(*protocoloutofband.Invitation) error {
+	return nil
+}
+
+func (m *mockOOBService) Actions() ([]protocoloutofband.Action, error) {
+	return nil, nil
+}
+
+func (m *mockOOBService) ActionContinue(string, protocoloutofband.Options) error {
+	return nil
+}
+
+func (m *mockOOBService) ActionStop(string, error) error {
+	return nil
+}
+
+type mockOOBClient struct {
+	acceptFunc func(*outofband.Invitation, string) (string, error)
+}
+
+func (m *mockOOBClient) AcceptInvitation(
+	i *outofband.Invitation, l string, _ ...outofband.MessageOption) (string, error) {
+	return m.acceptFunc(i, l)
+}
+
+type mockConnectionClient struct {
+	queryFunc func(*didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error)
+}
+
+func (m *mockConnectionClient) QueryConnections(
+	p *didexchange.QueryConnectionsParams) ([]*didexchange.Connection, error) {
+	return m.queryFunc(p)
+}
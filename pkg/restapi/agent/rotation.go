@@ -0,0 +1,568 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	didRotationPath        = "/dids/rotation"
+	connectionRotationPath = "/connections/rotation"
+	didKeysStoreName       = "edgeagent_did_keys"
+	defaultKeyGracePeriod  = 24 * time.Hour
+	// the schedule looks for the keys due for a rotation or a retirement this often.
+	keyRotationCheckInterval   = time.Hour
+	ed25519VerificationKey2018 = "Ed25519VerificationKey2018"
+)
+
+// KeyRotationConfig enables the rotation of the keys of the DIDs of the users and of the pairwise DIDs of their
+// connections: a new key is created in the ops KMS and added first to the DID document, where it signs from then on.
+// The previous key stays in the document for the grace period, so that the messages and signatures made with it
+// still verify, then it is removed from the document. It is left in the keystore. Requires the Storage of the
+// Config.
+type KeyRotationConfig struct {
+	// Interval is the age of the keys rotated by the schedule. The keys are only rotated on request of the users
+	// if zero.
+	Interval time.Duration
+	// GracePeriod is how long the previous keys stay in the DID documents. Defaults to 24 hours.
+	GracePeriod time.Duration
+}
+
+// DIDKeys are the keys of a DID rotated by the agent.
+type DIDKeys struct {
+	DID string `json:"did"`
+	// KeyID is the ID in the KMS of the key the DID signs with.
+	KeyID     string     `json:"keyID"`
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
+	// Deprecated are the previous keys still in the DID document.
+	Deprecated []*DeprecatedKey `json:"deprecated,omitempty"`
+}
+
+// DeprecatedKey is a previous key of a DID, removed from its document at the end of the grace period.
+type DeprecatedKey struct {
+	KeyID     string    `json:"keyID"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// RotateDIDKeyRequest is the body of a request to rotate the key of a DID of the user.
+type RotateDIDKeyRequest struct {
+	DID string `json:"did"`
+}
+
+// RotateConnectionKeyRequest is the body of a request to rotate the key of the pairwise DID of a connection.
+type RotateConnectionKeyRequest struct {
+	ConnectionID string `json:"connectionID"`
+}
+
+type keyRotations struct {
+	store       storage.Store
+	interval    time.Duration
+	gracePeriod time.Duration
+	// serializes the updates of the DID documents
+	mutex sync.Mutex
+	stop  chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+func newKeyRotations(config *KeyRotationConfig, provider storage.Provider) (*keyRotations, error) {
+	if provider == nil {
+		return nil, errors.New("key rotation requires the storage of the dids")
+	}
+
+	s, err := store.Open(provider, didKeysStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open did keys store: %w", err)
+	}
+
+	r := &keyRotations{
+		store:       s,
+		interval:    config.Interval,
+		gracePeriod: config.GracePeriod,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	if r.gracePeriod <= 0 {
+		r.gracePeriod = defaultKeyGracePeriod
+	}
+
+	return r, nil
+}
+
+// schedule calls check periodically until the rotations are stopped. The schedule runs even if the keys are only
+// rotated on request, to retire the deprecated keys.
+func (r *keyRotations) schedule(check func(now time.Time)) {
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(keyRotationCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				check(now)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the schedule, waiting for the check under way.
+func (r *keyRotations) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+}
+
+func (r *keyRotations) get(didID string) (*DIDKeys, error) {
+	bits, err := r.store.Get(didID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &DIDKeys{}
+
+	err = json.Unmarshal(bits, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal did keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Close stops the rotation of the keys of the DIDs.
+func (o *Operation) Close() {
+	if o.keyRotations != nil {
+		o.keyRotations.Stop()
+	}
+}
+
+func (o *Operation) keyRotationHandlers() []common.Handler {
+	handlers := []common.Handler{
+		common.NewHTTPHandler(didRotationPath, http.MethodPost, o.rotateDIDKeyHandler, &common.OperationSpec{
+			Summary:   "Rotates the key of a DID of the user logged in.",
+			Request:   &RotateDIDKeyRequest{},
+			Responses: map[int]interface{}{http.StatusOK: &DIDKeys{}},
+		}),
+	}
+
+	if o.connectionOwners != nil {
+		handlers = append(handlers, common.NewHTTPHandler(connectionRotationPath, http.MethodPost,
+			o.rotateConnectionKeyHandler, &common.OperationSpec{
+				Summary:   "Rotates the key of the pairwise DID of a connection of the user logged in.",
+				Request:   &RotateConnectionKeyRequest{},
+				Responses: map[int]interface{}{http.StatusOK: &DIDKeys{}},
+			}))
+	}
+
+	return handlers
+}
+
+func (o *Operation) rotateDIDKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling rotate did key request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &RotateDIDKeyRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if !o.owns(w, o.didOwners, request.DID, sub, "did") {
+		return
+	}
+
+	o.writeRotation(w, request.DID)
+}
+
+func (o *Operation) rotateConnectionKeyHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling rotate connection key request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &RotateConnectionKeyRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if !o.owns(w, o.connectionOwners, request.ConnectionID, sub, "connection") {
+		return
+	}
+
+	record, err := o.records.GetConnectionRecord(request.ConnectionID)
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "connection not found")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to fetch connection: %s", err.Error())
+
+		return
+	}
+
+	if record.MyDID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusConflict, "connection has no pairwise did yet")
+
+		return
+	}
+
+	o.writeRotation(w, record.MyDID)
+}
+
+// owns writes a not found response unless the user owns the key of the owners store.
+func (o *Operation) owns(w http.ResponseWriter, owners storage.Store, key, sub, kind string) bool {
+	if key == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing %s", kind)
+
+		return false
+	}
+
+	owner, err := owners.Get(key)
+	if errors.Is(err, storage.ErrValueNotFound) || err == nil && string(owner) != sub {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "%s not found", kind)
+
+		return false
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to fetch owner of %s: %s", kind, err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+func (o *Operation) writeRotation(w http.ResponseWriter, didID string) {
+	keys, err := o.rotateDIDKey(didID, time.Now().UTC())
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, keys)
+}
+
+// rotateDIDKey adds a new key first to the DID document, and deprecates the key it replaces.
+func (o *Operation) rotateDIDKey(didID string, now time.Time) (*DIDKeys, error) {
+	o.keyRotations.mutex.Lock()
+	defer o.keyRotations.mutex.Unlock()
+
+	doc, err := o.vdr.Resolve(didID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve did %s: %w", didID, err)
+	}
+
+	keys, err := o.keyRotations.get(didID)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		keys, err = &DIDKeys{DID: didID}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys of did %s: %w", didID, err)
+	}
+
+	kid, pubKey, err := o.kms.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+
+	if len(doc.VerificationMethod) > 0 {
+		keys.Deprecated = append(keys.Deprecated, &DeprecatedKey{
+			KeyID:     kmsKeyID(doc.VerificationMethod[0].ID),
+			ExpiresAt: now.Add(o.keyRotations.gracePeriod),
+		})
+	}
+
+	addKey(doc, did.NewVerificationMethodFromBytes("#"+kid, ed25519VerificationKey2018, doc.ID, pubKey), now)
+
+	err = o.vdr.Store(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update did document: %w", err)
+	}
+
+	keys.KeyID = kid
+	keys.RotatedAt = &now
+
+	err = store.Save(o.keyRotations.store, didID, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save keys of did %s: %w", didID, err)
+	}
+
+	logger.Infof("rotated key of did %s", didID)
+
+	return keys, nil
+}
+
+// retireKeys removes the deprecated keys whose grace period ended from the DID document.
+func (o *Operation) retireKeys(keys *DIDKeys, now time.Time) error {
+	o.keyRotations.mutex.Lock()
+	defer o.keyRotations.mutex.Unlock()
+
+	var expired, remaining []*DeprecatedKey
+
+	for _, key := range keys.Deprecated {
+		if now.Before(key.ExpiresAt) {
+			remaining = append(remaining, key)
+		} else {
+			expired = append(expired, key)
+		}
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	doc, err := o.vdr.Resolve(keys.DID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve did %s: %w", keys.DID, err)
+	}
+
+	for _, key := range expired {
+		removeKey(doc, key.KeyID)
+	}
+
+	doc.Updated = &now
+
+	err = o.vdr.Store(doc)
+	if err != nil {
+		return fmt.Errorf("failed to update did document: %w", err)
+	}
+
+	keys.Deprecated = remaining
+
+	err = store.Save(o.keyRotations.store, keys.DID, keys)
+	if err != nil {
+		return fmt.Errorf("failed to save keys of did %s: %w", keys.DID, err)
+	}
+
+	return nil
+}
+
+// checkKeys retires the deprecated keys whose grace period ended, then rotates the keys older than the interval.
+func (o *Operation) checkKeys(now time.Time) {
+	all, err := o.keyRotations.store.GetAll()
+	if err != nil {
+		logger.Errorf("failed to list did keys: %s", err.Error())
+
+		return
+	}
+
+	for didID, bits := range all {
+		keys := &DIDKeys{}
+
+		err = json.Unmarshal(bits, keys)
+		if err != nil {
+			logger.Errorf("failed to unmarshal keys of did %s: %s", didID, err.Error())
+
+			continue
+		}
+
+		err = o.retireKeys(keys, now)
+		if err != nil {
+			logger.Errorf("failed to retire keys of did %s: %s", didID, err.Error())
+		}
+	}
+
+	if o.keyRotations.interval <= 0 {
+		return
+	}
+
+	for _, didID := range o.rotatedDIDs() {
+		if !o.keyRotationDue(didID, now) {
+			continue
+		}
+
+		_, err = o.rotateDIDKey(didID, now)
+		if err != nil {
+			logger.Errorf("failed to rotate key of did %s: %s", didID, err.Error())
+		}
+	}
+}
+
+// rotatedDIDs returns the DIDs of the users and the pairwise DIDs of their connections.
+func (o *Operation) rotatedDIDs() []string {
+	owners := map[string][]byte{}
+
+	all, err := o.didOwners.GetAll()
+	if err != nil {
+		logger.Errorf("failed to list dids: %s", err.Error())
+	}
+
+	for didID := range all {
+		owners[didID] = nil
+	}
+
+	if o.connectionOwners != nil {
+		all, err = o.connectionOwners.GetAll()
+		if err != nil {
+			logger.Errorf("failed to list connections: %s", err.Error())
+		}
+
+		for connectionID := range all {
+			record, getErr := o.records.GetConnectionRecord(connectionID)
+			if getErr != nil || record.MyDID == "" {
+				continue
+			}
+
+			owners[record.MyDID] = nil
+		}
+	}
+
+	dids := make([]string, 0, len(owners))
+
+	for didID := range owners {
+		dids = append(dids, didID)
+	}
+
+	return dids
+}
+
+// keyRotationDue reports whether the key of the DID is older than the interval. The key of a DID that was never
+// rotated is as old as its document.
+func (o *Operation) keyRotationDue(didID string, now time.Time) bool {
+	keys, err := o.keyRotations.get(didID)
+	if err == nil {
+		return keys.RotatedAt == nil || now.Sub(*keys.RotatedAt) >= o.keyRotations.interval
+	}
+
+	if !errors.Is(err, storage.ErrValueNotFound) {
+		logger.Errorf("failed to fetch keys of did %s: %s", didID, err.Error())
+
+		return false
+	}
+
+	doc, err := o.vdr.Resolve(didID)
+	if errors.Is(err, vdrapi.ErrNotFound) {
+		return false
+	}
+
+	if err != nil {
+		logger.Errorf("failed to resolve did %s: %s", didID, err.Error())
+
+		return false
+	}
+
+	return doc.Created == nil || now.Sub(*doc.Created) >= o.keyRotations.interval
+}
+
+// addKey adds the key first to the verification methods, authentications, assertion methods and DIDComm services of
+// the document.
+func addKey(doc *did.Doc, vm *did.VerificationMethod, now time.Time) {
+	doc.VerificationMethod = append([]did.VerificationMethod{*vm}, doc.VerificationMethod...)
+	doc.Authentication = append([]did.Verification{{
+		VerificationMethod: *vm,
+		Relationship:       did.Authentication,
+	}}, doc.Authentication...)
+	doc.AssertionMethod = append([]did.Verification{{
+		VerificationMethod: *vm,
+		Relationship:       did.AssertionMethod,
+	}}, doc.AssertionMethod...)
+
+	for i := range doc.Service {
+		if doc.Service[i].Type == vdrapi.DIDCommServiceType {
+			doc.Service[i].RecipientKeys = append([]string{base58.Encode(vm.Value)}, doc.Service[i].RecipientKeys...)
+		}
+	}
+
+	doc.Updated = &now
+}
+
+// removeKey removes the key from the document, unless it is its only key.
+func removeKey(doc *did.Doc, kid string) {
+	var (
+		removed *did.VerificationMethod
+		kept    []did.VerificationMethod
+	)
+
+	for i := range doc.VerificationMethod {
+		if kmsKeyID(doc.VerificationMethod[i].ID) == kid {
+			removed = &doc.VerificationMethod[i]
+		} else {
+			kept = append(kept, doc.VerificationMethod[i])
+		}
+	}
+
+	if removed == nil || len(kept) == 0 {
+		return
+	}
+
+	doc.VerificationMethod = kept
+	doc.Authentication = withoutKey(doc.Authentication, kid)
+	doc.AssertionMethod = withoutKey(doc.AssertionMethod, kid)
+
+	recipientKey := base58.Encode(removed.Value)
+
+	for i := range doc.Service {
+		var recipientKeys []string
+
+		for _, key := range doc.Service[i].RecipientKeys {
+			if key != recipientKey {
+				recipientKeys = append(recipientKeys, key)
+			}
+		}
+
+		doc.Service[i].RecipientKeys = recipientKeys
+	}
+}
+
+func withoutKey(verifications []did.Verification, kid string) []did.Verification {
+	var kept []did.Verification
+
+	for i := range verifications {
+		if kmsKeyID(verifications[i].VerificationMethod.ID) != kid {
+			kept = append(kept, verifications[i])
+		}
+	}
+
+	return kept
+}
+
+// kmsKeyID returns the ID in the KMS of the key of a verification method: the agent names the keys of its DIDs
+// after it.
+func kmsKeyID(vmID string) string {
+	return vmID[strings.LastIndex(vmID, "#")+1:]
+}
@@ -0,0 +1,390 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/store/connection"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNew_KeyRotation(t *testing.T) {
+	t.Run("registers the rotation handlers", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		require.Len(t, o.GetRESTHandlers(), 7)
+		require.Equal(t, defaultKeyGracePeriod, o.keyRotations.gracePeriod)
+	})
+
+	t.Run("error without the storage of the dids", func(t *testing.T) {
+		c := config()
+		c.KeyRotation = &KeyRotationConfig{}
+
+		_, err := New(c)
+		require.EqualError(t, err, "key rotation requires the storage of the dids")
+	})
+
+	t.Run("error if the did keys store cannot be opened", func(t *testing.T) {
+		_, err := newKeyRotations(&KeyRotationConfig{},
+			&mockstore.Provider{ErrCreateStore: errors.New("test")})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open did keys store")
+	})
+}
+
+func TestOperation_RotateDIDKey(t *testing.T) {
+	t.Run("rotates the key of a did of the user and retires the previous one", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{GracePeriod: time.Hour})
+		registry := withDID(t, o, "user", "did:peer:123", "key1")
+		o.kms = &mockkms.KeyManager{CrAndExportPubKeyID: "key2", CrAndExportPubKeyValue: []byte("public key 2")}
+
+		w := rotateDIDKey(t, o, "user", &RotateDIDKeyRequest{DID: "did:peer:123"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		keys := &DIDKeys{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), keys))
+		require.Equal(t, "key2", keys.KeyID)
+		require.Len(t, keys.Deprecated, 1)
+		require.Equal(t, "key1", keys.Deprecated[0].KeyID)
+
+		doc := registry.MemStore["did:peer:123"]
+		require.Len(t, doc.VerificationMethod, 2)
+		require.Equal(t, "#key2", doc.VerificationMethod[0].ID)
+		require.Equal(t, "#key2", doc.Authentication[0].VerificationMethod.ID)
+		require.Equal(t, "#key2", doc.AssertionMethod[0].VerificationMethod.ID)
+		require.Equal(t, []string{base58.Encode([]byte("public key 2")), base58.Encode([]byte("public key key1"))},
+			doc.Service[0].RecipientKeys)
+
+		// the previous key stays for the grace period
+		o.checkKeys(keys.Deprecated[0].ExpiresAt.Add(-time.Minute))
+		require.Len(t, registry.MemStore["did:peer:123"].VerificationMethod, 2)
+
+		o.checkKeys(keys.Deprecated[0].ExpiresAt)
+
+		doc = registry.MemStore["did:peer:123"]
+		require.Len(t, doc.VerificationMethod, 1)
+		require.Equal(t, "#key2", doc.VerificationMethod[0].ID)
+		require.Len(t, doc.Authentication, 1)
+		require.Len(t, doc.AssertionMethod, 1)
+		require.Equal(t, []string{base58.Encode([]byte("public key 2"))}, doc.Service[0].RecipientKeys)
+
+		keys, err := o.keyRotations.get("did:peer:123")
+		require.NoError(t, err)
+		require.Empty(t, keys.Deprecated)
+	})
+
+	t.Run("not found if the did belongs to another user", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		withDID(t, o, "other", "did:peer:123", "key1")
+
+		w := rotateDIDKey(t, o, "user", &RotateDIDKeyRequest{DID: "did:peer:123"})
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = rotateDIDKey(t, o, "user", &RotateDIDKeyRequest{DID: "did:peer:456"})
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("bad request if the did is missing", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+
+		w := rotateDIDKey(t, o, "user", &RotateDIDKeyRequest{})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing did")
+
+		o.cookies = loggedInAs("user")
+		w = httptest.NewRecorder()
+		o.rotateDIDKeyHandler(w, httptest.NewRequest(http.MethodPost, didRotationPath, bytes.NewReader([]byte("{"))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("internal server error if the key cannot be rotated", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		registry := withDID(t, o, "user", "did:peer:123", "key1")
+
+		o.kms = &mockkms.KeyManager{CrAndExportPubKeyErr: errors.New("test")}
+
+		w := rotateDIDKey(t, o, "user", &RotateDIDKeyRequest{DID: "did:peer:123"})
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to create key")
+
+		o.kms = &mockkms.KeyManager{CrAndExportPubKeyID: "key2"}
+		registry.StoreFunc = func(*did.Doc) error {
+			return errors.New("test")
+		}
+
+		w = rotateDIDKey(t, o, "user", &RotateDIDKeyRequest{DID: "did:peer:123"})
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to update did document")
+
+		registry.ResolveErr = errors.New("test")
+		registry.ResolveFunc = nil
+
+		w = rotateDIDKey(t, o, "user", &RotateDIDKeyRequest{DID: "did:peer:123"})
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to resolve did did:peer:123")
+	})
+
+	t.Run("internal server error if the owner cannot be fetched", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		o.didOwners = &mockstore.MockStore{Store: map[string][]byte{"did:peer:123": nil}, ErrGet: errors.New("test")}
+
+		w := rotateDIDKey(t, o, "user", &RotateDIDKeyRequest{DID: "did:peer:123"})
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch owner of did")
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		w := httptest.NewRecorder()
+		o.rotateDIDKeyHandler(w, httptest.NewRequest(http.MethodPost, didRotationPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = httptest.NewRecorder()
+		o.rotateConnectionKeyHandler(w, httptest.NewRequest(http.MethodPost, connectionRotationPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestOperation_RotateConnectionKey(t *testing.T) {
+	t.Run("rotates the key of the pairwise did of the connection", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		sub := acceptInvitation(t, o, "connection")
+		registry := withDID(t, o, "", "did:peer:pairwise", "key1")
+		o.kms = &mockkms.KeyManager{CrAndExportPubKeyID: "key2", CrAndExportPubKeyValue: []byte("public key 2")}
+
+		require.NoError(t, o.records.SaveConnectionRecord(&connection.Record{ConnectionID: "connection",
+			State: connection.StateNameCompleted, MyDID: "did:peer:pairwise", TheirDID: "did:peer:789"}))
+
+		w := rotateConnectionKey(t, o, sub, &RotateConnectionKeyRequest{ConnectionID: "connection"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "#key2", registry.MemStore["did:peer:pairwise"].VerificationMethod[0].ID)
+	})
+
+	t.Run("conflict if the connection has no pairwise did yet", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		sub := acceptInvitation(t, o, "connection")
+
+		require.NoError(t, o.records.SaveConnectionRecord(&connection.Record{ConnectionID: "connection"}))
+
+		w := rotateConnectionKey(t, o, sub, &RotateConnectionKeyRequest{ConnectionID: "connection"})
+		require.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("not found if the connection is unknown", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		sub := acceptInvitation(t, o, "connection")
+
+		w := rotateConnectionKey(t, o, sub, &RotateConnectionKeyRequest{ConnectionID: "connection"})
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = rotateConnectionKey(t, o, "other", &RotateConnectionKeyRequest{ConnectionID: "connection"})
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("bad request if the body is invalid", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		o.cookies = loggedInAs("user")
+
+		w := httptest.NewRecorder()
+		o.rotateConnectionKeyHandler(w, httptest.NewRequest(http.MethodPost, connectionRotationPath,
+			bytes.NewReader([]byte("{"))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestOperation_CheckKeys(t *testing.T) {
+	t.Run("rotates the keys older than the interval", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{Interval: 24 * time.Hour})
+		registry := withDID(t, o, "user", "did:peer:123", "key1")
+		withDID(t, o, "user", "did:peer:456", "key1")
+		o.kms = &mockkms.KeyManager{CrAndExportPubKeyID: "key2", CrAndExportPubKeyValue: []byte("public key 2")}
+
+		created := *registry.MemStore["did:peer:123"].Created
+		fresh := created.Add(12 * time.Hour)
+		registry.MemStore["did:peer:456"].Created = &fresh
+
+		now := created.Add(25 * time.Hour)
+		o.checkKeys(now)
+
+		require.Equal(t, "#key2", registry.MemStore["did:peer:123"].VerificationMethod[0].ID)
+		require.Len(t, registry.MemStore["did:peer:456"].VerificationMethod, 1)
+
+		keys, err := o.keyRotations.get("did:peer:123")
+		require.NoError(t, err)
+		require.Equal(t, now, *keys.RotatedAt)
+
+		// not due again until the interval passes
+		require.False(t, o.keyRotationDue("did:peer:123", now.Add(time.Hour)))
+		require.True(t, o.keyRotationDue("did:peer:123", now.Add(24*time.Hour)))
+		require.False(t, o.keyRotationDue("did:peer:unknown", now))
+	})
+
+	t.Run("rotates the pairwise dids of the connections", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{Interval: time.Hour})
+		acceptInvitation(t, o, "connection")
+		registry := withDID(t, o, "", "did:peer:pairwise", "key1")
+		o.kms = &mockkms.KeyManager{CrAndExportPubKeyID: "key2", CrAndExportPubKeyValue: []byte("public key 2")}
+
+		require.NoError(t, o.records.SaveConnectionRecord(&connection.Record{ConnectionID: "connection",
+			State: connection.StateNameCompleted, MyDID: "did:peer:pairwise", TheirDID: "did:peer:789"}))
+
+		o.checkKeys(time.Now().Add(2 * time.Hour))
+
+		require.Equal(t, "#key2", registry.MemStore["did:peer:pairwise"].VerificationMethod[0].ID)
+	})
+
+	t.Run("only retires the keys if they are rotated on request", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{})
+		registry := withDID(t, o, "user", "did:peer:123", "key1")
+
+		o.checkKeys(time.Now().Add(24 * 365 * time.Hour))
+		require.Equal(t, "#key1", registry.MemStore["did:peer:123"].VerificationMethod[0].ID)
+	})
+
+	t.Run("keeps the deprecated keys if the document cannot be updated", func(t *testing.T) {
+		o := newRotationOperation(t, &KeyRotationConfig{GracePeriod: time.Hour})
+		registry := withDID(t, o, "user", "did:peer:123", "key1")
+		o.kms = &mockkms.KeyManager{CrAndExportPubKeyID: "key2", CrAndExportPubKeyValue: []byte("public key 2")}
+
+		keys, err := o.rotateDIDKey("did:peer:123", time.Now())
+		require.NoError(t, err)
+
+		registry.StoreFunc = func(*did.Doc) error {
+			return errors.New("test")
+		}
+
+		o.checkKeys(keys.Deprecated[0].ExpiresAt)
+
+		keys, err = o.keyRotations.get("did:peer:123")
+		require.NoError(t, err)
+		require.Len(t, keys.Deprecated, 1)
+	})
+}
+
+func TestRemoveKey(t *testing.T) {
+	t.Run("keeps the only key of the document", func(t *testing.T) {
+		doc := peerDoc("did:peer:123", "key1")
+
+		removeKey(doc, "key1")
+		require.Len(t, doc.VerificationMethod, 1)
+
+		removeKey(doc, "unknown")
+		require.Len(t, doc.VerificationMethod, 1)
+	})
+}
+
+// newRotationOperation returns an Operation rotating the keys of the DIDs in an in-memory registry.
+func newRotationOperation(t *testing.T, rotation *KeyRotationConfig) *Operation {
+	t.Helper()
+
+	c := config()
+	c.Events = &EventsConfig{Dispatcher: newMockDispatcher(), Storage: memstore.NewProvider()}
+	c.Storage = memstore.NewProvider()
+	c.KeyRotation = rotation
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	t.Cleanup(o.Close)
+
+	registry := &mockvdr.MockVDRegistry{MemStore: map[string]*did.Doc{}}
+	registry.ResolveFunc = func(didID string, _ ...vdrapi.ResolveOpts) (*did.Doc, error) {
+		doc, ok := registry.MemStore[didID]
+		if !ok {
+			return nil, vdrapi.ErrNotFound
+		}
+
+		return doc, nil
+	}
+
+	o.vdr = registry
+
+	return o
+}
+
+// withDID adds a peer DID with a single key to the registry, owned by the user unless sub is empty.
+func withDID(t *testing.T, o *Operation, sub, didID, kid string) *mockvdr.MockVDRegistry {
+	t.Helper()
+
+	registry, ok := o.vdr.(*mockvdr.MockVDRegistry)
+	require.True(t, ok)
+
+	registry.MemStore[didID] = peerDoc(didID, kid)
+
+	if sub != "" {
+		require.NoError(t, o.didOwners.Put(didID, []byte(sub)))
+	}
+
+	return registry
+}
+
+func peerDoc(didID, kid string) *did.Doc {
+	pubKey := []byte("public key " + kid)
+	vm := did.NewVerificationMethodFromBytes("#"+kid, ed25519VerificationKey2018, "#id", pubKey)
+	created := time.Now().UTC().Add(-time.Hour)
+
+	return &did.Doc{
+		Context:            []string{did.Context},
+		ID:                 didID,
+		VerificationMethod: []did.VerificationMethod{*vm},
+		Authentication:     []did.Verification{{VerificationMethod: *vm, Relationship: did.Authentication}},
+		AssertionMethod:    []did.Verification{{VerificationMethod: *vm, Relationship: did.AssertionMethod}},
+		Service: []did.Service{{
+			ID:            "didcomm",
+			Type:          vdrapi.DIDCommServiceType,
+			RecipientKeys: []string{base58.Encode(pubKey)},
+		}},
+		Created: &created,
+	}
+}
+
+func loggedInAs(sub string) cookie.Store {
+	return &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{
+		userSubCookieName: sub,
+	}}}
+}
+
+func rotateDIDKey(t *testing.T, o *Operation, sub string, request *RotateDIDKeyRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	o.cookies = loggedInAs(sub)
+
+	w := httptest.NewRecorder()
+	o.rotateDIDKeyHandler(w, httptest.NewRequest(http.MethodPost, didRotationPath,
+		bytes.NewReader(marshal(t, request))))
+
+	return w
+}
+
+func rotateConnectionKey(t *testing.T, o *Operation, sub string,
+	request *RotateConnectionKeyRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	o.cookies = loggedInAs(sub)
+
+	w := httptest.NewRecorder()
+	o.rotateConnectionKeyHandler(w, httptest.NewRequest(http.MethodPost, connectionRotationPath,
+		bytes.NewReader(marshal(t, request))))
+
+	return w
+}
@@ -0,0 +1,124 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+const (
+	credentialsContext = "https://www.w3.org/2018/credentials/v1"
+	presentationType   = "VerifiablePresentation"
+	signatureAlgorithm = "EdDSA"
+)
+
+// holderSigner signs with the key of a DID created by the agent. The key never leaves the ops KMS.
+type holderSigner struct {
+	crypto crypto.Crypto
+	kh     interface{}
+	keyID  string
+}
+
+// Sign signs data with the holder's key.
+func (s *holderSigner) Sign(data []byte) ([]byte, error) {
+	return s.crypto.Sign(data, s.kh)
+}
+
+// Headers returns the JWS headers identifying the holder's key.
+func (s *holderSigner) Headers() jose.Headers {
+	return jose.Headers{
+		jose.HeaderAlgorithm: signatureAlgorithm,
+		jose.HeaderKeyID:     s.keyID,
+	}
+}
+
+// presentationClaims are the claims of a presentation JWT bound to a challenge.
+type presentationClaims struct {
+	*verifiable.JWTPresClaims
+	Nonce string `json:"nonce,omitempty"`
+}
+
+func (o *Operation) holderSigner(holder string) (*holderSigner, error) {
+	doc, err := o.vdr.Resolve(holder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve holder did: %w", err)
+	}
+
+	if len(doc.VerificationMethod) == 0 {
+		return nil, fmt.Errorf("holder did %s has no verification method", holder)
+	}
+
+	vm := doc.VerificationMethod[0]
+
+	kh, err := o.kms.Get(kmsKeyID(vm.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get holder key from kms: %w", err)
+	}
+
+	keyID := vm.ID
+	if strings.HasPrefix(keyID, "#") {
+		keyID = holder + keyID
+	}
+
+	return &holderSigner{crypto: o.crypto, kh: kh, keyID: keyID}, nil
+}
+
+// signPresentation returns a presentation of the credentials as a JWT signed by the holder.
+func (o *Operation) signPresentation(holder string, credentials []json.RawMessage, aud, nonce string) (string, error) {
+	signer, err := o.holderSigner(holder)
+	if err != nil {
+		return "", err
+	}
+
+	vp := &verifiable.Presentation{
+		Context: []string{credentialsContext},
+		ID:      "urn:uuid:" + uuid.New().String(),
+		Type:    []string{presentationType},
+		Holder:  holder,
+	}
+
+	creds := make([]interface{}, len(credentials))
+
+	for i := range credentials {
+		creds[i] = []byte(credentials[i])
+	}
+
+	err = vp.SetCredentials(creds...)
+	if err != nil {
+		return "", fmt.Errorf("invalid credential: %w", err)
+	}
+
+	var audience []string
+
+	if aud != "" {
+		audience = []string{aud}
+	}
+
+	claims, err := vp.JWTClaims(audience, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to create presentation claims: %w", err)
+	}
+
+	return signJWT(&presentationClaims{JWTPresClaims: claims, Nonce: nonce}, nil, signer)
+}
+
+func signJWT(claims interface{}, headers jose.Headers, signer jose.Signer) (string, error) {
+	token, err := jwt.NewSigned(claims, headers, signer)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign jwt: %w", err)
+	}
+
+	return token.Serialize(false)
+}
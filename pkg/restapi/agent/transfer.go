@@ -0,0 +1,758 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/client/issuecredential"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/decorator"
+	protocol "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/issuecredential"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	transfersPath        = "/transfers"
+	acceptTransferPath   = "/transfers/accept"
+	declineTransferPath  = "/transfers/decline"
+	transfersStoreName   = "edgeagent_transfers"
+	defaultDeclineReason = "declined by the recipient"
+	// the name of the attributes of the previews of the credentials offered, one per type of credential.
+	credentialTypeAttr = "type"
+)
+
+// Directions of the transfers.
+const (
+	TransferSent     = "sent"
+	TransferReceived = "received"
+)
+
+// Statuses of the transfers.
+const (
+	// TransferOffered is the status of a transfer until the recipient accepts or declines it.
+	TransferOffered  = "offered"
+	TransferAccepted = "accepted"
+	TransferDeclined = "declined"
+	// TransferCompleted is the status of a transfer once the credential is issued to the recipient, and saved to
+	// their vault.
+	TransferCompleted = "completed"
+	TransferFailed    = "failed"
+)
+
+var (
+	errTransferNotFound   = errors.New("transfer not found")
+	errCredentialNotFound = errors.New("credential not found")
+)
+
+// TransferConfig enables the transfer of credentials between the users of edge agents over their DIDComm
+// connections, with the issue-credential protocol: the sender offers a credential, which is only sent to the
+// recipient, and saved to their vault, once they accept the offer. Requires the Events of the Config, which keep
+// track of the owners of the connections.
+type TransferConfig struct {
+	Vault   TransferVault
+	Storage storage.Provider
+}
+
+// TransferVault reads the credentials sent by the users and stores the credentials they receive.
+type TransferVault interface {
+	CredentialReader
+	CredentialVault
+}
+
+// Transfer is a credential sent or received by the user.
+type Transfer struct {
+	// ID of the issue-credential thread of the transfer.
+	ID           string `json:"id"`
+	Direction    string `json:"direction"`
+	Status       string `json:"status"`
+	ConnectionID string `json:"connectionID"`
+	// CredentialID is the ID of the credential in the vault of the user, once received for the recipient.
+	CredentialID string    `json:"credentialID,omitempty"`
+	Types        []string  `json:"types,omitempty"`
+	Comment      string    `json:"comment,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
+}
+
+// SendCredentialRequest is the body of a request to send a credential of the user over a connection.
+type SendCredentialRequest struct {
+	ConnectionID string `json:"connectionID"`
+	CredentialID string `json:"credentialID"`
+	Comment      string `json:"comment,omitempty"`
+}
+
+// TransferRequest is the body of a request to accept a credential offered to the user.
+type TransferRequest struct {
+	ID string `json:"id"`
+}
+
+// DeclineTransferRequest is the body of a request to decline a credential offered to the user.
+type DeclineTransferRequest struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TransfersResponse lists the transfers of the user, latest first.
+type TransfersResponse struct {
+	Transfers []*Transfer `json:"transfers"`
+}
+
+type transferClient interface {
+	SendOffer(offer *issuecredential.OfferCredential, myDID, theirDID string) (string, error)
+	AcceptOffer(piID string) error
+	DeclineOffer(piID, reason string) error
+	AcceptRequest(piID string, msg *issuecredential.IssueCredential) error
+	AcceptCredential(piID string, names ...string) error
+	AcceptProblemReport(piID string) error
+}
+
+// transferRecord is a transfer of a user.
+type transferRecord struct {
+	*Transfer
+	Sub string `json:"sub"`
+}
+
+type transfers struct {
+	client transferClient
+	vault  TransferVault
+	store  storage.Store
+	// serializes the updates of the transfers
+	mutex sync.Mutex
+}
+
+func (o *Operation) watchTransfers(config *TransferConfig, provider issuecredential.Provider) error {
+	if o.events == nil {
+		return errors.New("credential transfers require the events of the agent")
+	}
+
+	client, err := issuecredential.New(provider)
+	if err != nil {
+		return fmt.Errorf("failed to create issue-credential client: %w", err)
+	}
+
+	s, err := store.Open(config.Storage, transfersStoreName)
+	if err != nil {
+		return fmt.Errorf("failed to open transfers store: %w", err)
+	}
+
+	actions := make(chan service.DIDCommAction)
+
+	err = client.RegisterActionEvent(actions)
+	if err != nil {
+		return fmt.Errorf("failed to register for issue-credential actions: %w", err)
+	}
+
+	o.transfers = &transfers{client: client, vault: config.Vault, store: s}
+
+	go func() {
+		for action := range actions {
+			o.handleTransferAction(action)
+		}
+	}()
+
+	return nil
+}
+
+func (o *Operation) transferHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(transfersPath, http.MethodPost, o.sendCredentialHandler, &common.OperationSpec{
+			Summary: "Offers a credential of the user logged in to the other party of a connection. The credential" +
+				" is only sent once they accept the offer.",
+			Request:   &SendCredentialRequest{},
+			Responses: map[int]interface{}{http.StatusCreated: &Transfer{}},
+		}),
+		common.NewHTTPHandler(transfersPath, http.MethodGet, o.transfersHandler, &common.OperationSpec{
+			Summary:   "Lists the credentials sent and received by the user logged in.",
+			Responses: map[int]interface{}{http.StatusOK: &TransfersResponse{}},
+		}),
+		common.NewHTTPHandler(acceptTransferPath, http.MethodPost, o.acceptTransferHandler, &common.OperationSpec{
+			Summary:   "Accepts a credential offered to the user logged in, saved to their vault once received.",
+			Request:   &TransferRequest{},
+			Responses: map[int]interface{}{http.StatusOK: &Transfer{}},
+		}),
+		common.NewHTTPHandler(declineTransferPath, http.MethodPost, o.declineTransferHandler, &common.OperationSpec{
+			Summary:   "Declines a credential offered to the user logged in.",
+			Request:   &DeclineTransferRequest{},
+			Responses: map[int]interface{}{http.StatusOK: &Transfer{}},
+		}),
+	}
+}
+
+func (o *Operation) sendCredentialHandler(w http.ResponseWriter, r *http.Request) { // nolint:funlen // one step each
+	logger.Debugf("handling send credential request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &SendCredentialRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if !o.owns(w, o.connectionOwners, request.ConnectionID, sub, "connection") {
+		return
+	}
+
+	credential, err := o.vaultCredential(sub, request.CredentialID)
+	if errors.Is(err, errCredentialNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "credential not found")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	record, err := o.records.GetConnectionRecord(request.ConnectionID)
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "connection not found")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to fetch connection: %s", err.Error())
+
+		return
+	}
+
+	if record.MyDID == "" || record.TheirDID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusConflict, "connection is not established yet")
+
+		return
+	}
+
+	types := credentialTypes(credential)
+
+	// the offer only previews the credential, which is sent once the recipient accepts it
+	piID, err := o.transfers.client.SendOffer(&issuecredential.OfferCredential{
+		Comment:           request.Comment,
+		CredentialPreview: credentialPreview(types),
+	}, record.MyDID, record.TheirDID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to offer credential: %s",
+			err.Error())
+
+		return
+	}
+
+	now := time.Now().UTC()
+	transfer := &Transfer{
+		ID:           piID,
+		Direction:    TransferSent,
+		Status:       TransferOffered,
+		ConnectionID: request.ConnectionID,
+		CredentialID: request.CredentialID,
+		Types:        types,
+		Comment:      request.Comment,
+		Created:      now,
+		Updated:      now,
+	}
+
+	err = o.saveTransfer(sub, transfer)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.WriteResponse(w, logger, transfer)
+}
+
+func (o *Operation) transfersHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling transfers request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	all, err := o.transfers.store.GetAll()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to list transfers: %s",
+			err.Error())
+
+		return
+	}
+
+	resp := &TransfersResponse{Transfers: []*Transfer{}}
+
+	for key, bits := range all {
+		record := &transferRecord{}
+
+		err = json.Unmarshal(bits, record)
+		if err != nil {
+			logger.Warnf("failed to unmarshal transfer %s: %s", key, err.Error())
+
+			continue
+		}
+
+		if record.Sub == sub {
+			resp.Transfers = append(resp.Transfers, record.Transfer)
+		}
+	}
+
+	sort.Slice(resp.Transfers, func(i, j int) bool {
+		return resp.Transfers[i].Created.After(resp.Transfers[j].Created)
+	})
+
+	common.WriteResponse(w, logger, resp)
+}
+
+func (o *Operation) acceptTransferHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling accept transfer request")
+
+	request := &TransferRequest{}
+
+	o.answerOffer(w, r, request, &request.ID, func(transfer *Transfer) error {
+		err := o.transfers.client.AcceptOffer(transfer.ID)
+		if err != nil {
+			return fmt.Errorf("failed to accept offer: %w", err)
+		}
+
+		transfer.Status = TransferAccepted
+
+		return nil
+	})
+}
+
+func (o *Operation) declineTransferHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling decline transfer request")
+
+	request := &DeclineTransferRequest{}
+
+	o.answerOffer(w, r, request, &request.ID, func(transfer *Transfer) error {
+		reason := request.Reason
+		if reason == "" {
+			reason = defaultDeclineReason
+		}
+
+		err := o.transfers.client.DeclineOffer(transfer.ID, reason)
+		if err != nil {
+			return fmt.Errorf("failed to decline offer: %w", err)
+		}
+
+		transfer.Status = TransferDeclined
+		transfer.Error = reason
+
+		return nil
+	})
+}
+
+// answerOffer decodes the request, and answers the offer of its id received by the user.
+func (o *Operation) answerOffer(w http.ResponseWriter, r *http.Request, request interface{}, id *string,
+	answer func(transfer *Transfer) error) {
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if *id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing transfer")
+
+		return
+	}
+
+	o.transfers.mutex.Lock()
+	defer o.transfers.mutex.Unlock()
+
+	record, err := o.getTransfer(TransferReceived, *id)
+	if errors.Is(err, errTransferNotFound) || err == nil && record.Sub != sub {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "transfer not found")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	if record.Status != TransferOffered {
+		common.WriteErrorResponsef(w, logger, http.StatusConflict, "transfer is %s", record.Status)
+
+		return
+	}
+
+	err = answer(record.Transfer)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	err = o.saveTransfer(sub, record.Transfer)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, record.Transfer)
+}
+
+// handleTransferAction handles the messages of the issue-credential protocol: the offers received are kept until
+// their recipient answers them, the requests of the credentials sent are answered with the credentials, and the
+// credentials received are saved to the vault of their recipient.
+func (o *Operation) handleTransferAction(action service.DIDCommAction) {
+	properties := action.Properties.All()
+
+	piID, _ := properties["piid"].(string)         // nolint:errcheck // empty if missing
+	myDID, _ := properties["myDID"].(string)       // nolint:errcheck // empty if missing
+	theirDID, _ := properties["theirDID"].(string) // nolint:errcheck // empty if missing
+
+	if piID == "" {
+		action.Stop(errors.New("missing thread"))
+
+		return
+	}
+
+	o.transfers.mutex.Lock()
+	defer o.transfers.mutex.Unlock()
+
+	var err error
+
+	switch action.Message.Type() {
+	case protocol.OfferCredentialMsgType:
+		err = o.receiveOffer(action.Message, piID, myDID, theirDID)
+	case protocol.RequestCredentialMsgType:
+		err = o.sendCredential(piID)
+	case protocol.IssueCredentialMsgType:
+		err = o.receiveCredential(action.Message, piID)
+	case protocol.ProblemReportMsgType:
+		err = o.receiveProblemReport(piID)
+	default:
+		err = fmt.Errorf("unsupported message %s", action.Message.Type())
+	}
+
+	if err != nil {
+		logger.Warnf("failed to handle issue-credential message of thread %s: %s", piID, err.Error())
+
+		action.Stop(err)
+	}
+}
+
+// receiveOffer keeps the offer for the owner of the connection to answer it.
+func (o *Operation) receiveOffer(msg service.DIDCommMsg, piID, myDID, theirDID string) error {
+	connectionID, err := o.records.GetConnectionIDByDIDs(myDID, theirDID)
+	if err != nil {
+		return fmt.Errorf("failed to find connection: %w", err)
+	}
+
+	sub, err := o.connectionOwners.Get(connectionID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch owner of connection %s: %w", connectionID, err)
+	}
+
+	offer := &protocol.OfferCredential{}
+
+	err = msg.Decode(offer)
+	if err != nil {
+		return fmt.Errorf("failed to decode offer: %w", err)
+	}
+
+	now := time.Now().UTC()
+
+	return o.saveTransfer(string(sub), &Transfer{
+		ID:           piID,
+		Direction:    TransferReceived,
+		Status:       TransferOffered,
+		ConnectionID: connectionID,
+		Types:        previewTypes(offer.CredentialPreview),
+		Comment:      offer.Comment,
+		Created:      now,
+		Updated:      now,
+	})
+}
+
+// sendCredential answers the request of the recipient of a credential offered with the credential.
+func (o *Operation) sendCredential(piID string) error {
+	record, err := o.getTransfer(TransferSent, piID)
+	if err != nil {
+		return err
+	}
+
+	if record.Status != TransferOffered {
+		return fmt.Errorf("transfer is %s", record.Status)
+	}
+
+	credential, err := o.vaultCredential(record.Sub, record.CredentialID)
+	if err != nil {
+		o.failTransfer(record, err)
+
+		return err
+	}
+
+	err = o.transfers.client.AcceptRequest(piID, &issuecredential.IssueCredential{
+		Comment: record.Comment,
+		Formats: []protocol.Format{{AttachID: record.CredentialID, Format: credential.format}},
+		CredentialsAttach: []decorator.Attachment{{
+			ID:       record.CredentialID,
+			MimeType: "application/json",
+			Data:     decorator.AttachmentData{JSON: credential.raw},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send credential: %w", err)
+	}
+
+	record.Status = TransferCompleted
+
+	return o.saveTransfer(record.Sub, record.Transfer)
+}
+
+// receiveCredential saves the credential of an offer accepted to the vault of its recipient.
+func (o *Operation) receiveCredential(msg service.DIDCommMsg, piID string) error {
+	record, err := o.getTransfer(TransferReceived, piID)
+	if err != nil {
+		return err
+	}
+
+	if record.Status != TransferAccepted {
+		return fmt.Errorf("transfer is %s", record.Status)
+	}
+
+	credential, err := issuedCredential(msg)
+	if err != nil {
+		o.failTransfer(record, err)
+
+		return err
+	}
+
+	id := uuid.New().URN()
+
+	err = o.transfers.vault.SaveCredential(record.Sub, id, credential.raw)
+	if err != nil {
+		err = fmt.Errorf("failed to save credential to vault: %w", err)
+
+		o.failTransfer(record, err)
+
+		return err
+	}
+
+	err = o.transfers.client.AcceptCredential(piID, id)
+	if err != nil {
+		return fmt.Errorf("failed to accept credential: %w", err)
+	}
+
+	record.Status = TransferCompleted
+	record.CredentialID = id
+
+	o.notify(TopicCredentialReceived, record.Sub, &credentialReceivedPayload{ID: id, Format: credential.format})
+
+	return o.saveTransfer(record.Sub, record.Transfer)
+}
+
+// receiveProblemReport records the offers declined by their recipient, and the transfers abandoned by their sender.
+func (o *Operation) receiveProblemReport(piID string) error {
+	for _, direction := range []string{TransferSent, TransferReceived} {
+		record, err := o.getTransfer(direction, piID)
+		if errors.Is(err, errTransferNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = o.transfers.client.AcceptProblemReport(piID)
+		if err != nil {
+			return fmt.Errorf("failed to accept problem report: %w", err)
+		}
+
+		switch {
+		case direction == TransferReceived:
+			record.Status = TransferFailed
+			record.Error = "abandoned by the sender"
+		case record.Status == TransferOffered:
+			record.Status = TransferDeclined
+		default:
+			record.Status = TransferFailed
+			record.Error = "rejected by the recipient"
+		}
+
+		return o.saveTransfer(record.Sub, record.Transfer)
+	}
+
+	return errTransferNotFound
+}
+
+func (o *Operation) failTransfer(record *transferRecord, cause error) {
+	record.Status = TransferFailed
+	record.Error = cause.Error()
+
+	err := o.saveTransfer(record.Sub, record.Transfer)
+	if err != nil {
+		logger.Errorf("failed to save transfer %s: %s", record.ID, err.Error())
+	}
+}
+
+func (o *Operation) getTransfer(direction, id string) (*transferRecord, error) {
+	bits, err := o.transfers.store.Get(transferKey(direction, id))
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return nil, errTransferNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transfer %s: %w", id, err)
+	}
+
+	record := &transferRecord{}
+
+	err = json.Unmarshal(bits, record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal transfer %s: %w", id, err)
+	}
+
+	return record, nil
+}
+
+// saveTransfer saves the transfer of the user, and records it in their activity.
+func (o *Operation) saveTransfer(sub string, transfer *Transfer) error {
+	transfer.Updated = time.Now().UTC()
+
+	err := store.Save(o.transfers.store, transferKey(transfer.Direction, transfer.ID),
+		&transferRecord{Transfer: transfer, Sub: sub})
+	if err != nil {
+		return fmt.Errorf("failed to save transfer %s: %w", transfer.ID, err)
+	}
+
+	o.notify(TopicCredentialTransfer, sub, &credentialTransferPayload{
+		ID:           transfer.ID,
+		Direction:    transfer.Direction,
+		Status:       transfer.Status,
+		ConnectionID: transfer.ConnectionID,
+		CredentialID: transfer.CredentialID,
+	})
+
+	return nil
+}
+
+// vaultCredential returns a credential of the vault of the user.
+func (o *Operation) vaultCredential(sub, id string) (*storedCredential, error) {
+	if id == "" {
+		return nil, errCredentialNotFound
+	}
+
+	credentials, err := o.transfers.vault.Credentials(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	raw, ok := credentials[id]
+	if !ok {
+		return nil, errCredentialNotFound
+	}
+
+	return decodeStoredCredential(id, raw)
+}
+
+// issuedCredential returns the credential attached to an issue-credential message.
+func issuedCredential(msg service.DIDCommMsg) (*storedCredential, error) {
+	issued := &protocol.IssueCredential{}
+
+	err := msg.Decode(issued)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode credential: %w", err)
+	}
+
+	if len(issued.CredentialsAttach) != 1 {
+		return nil, fmt.Errorf("expected one credential, got %d", len(issued.CredentialsAttach))
+	}
+
+	raw, err := issued.CredentialsAttach[0].Data.Fetch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch credential: %w", err)
+	}
+
+	return decodeStoredCredential(issued.CredentialsAttach[0].ID, raw)
+}
+
+// credentialTypes returns the types of a credential, for its recipient to know what they are offered.
+func credentialTypes(credential *storedCredential) []string {
+	// the vc claim of a JWT credential comes last
+	doc, ok := credential.docs[len(credential.docs)-1].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	switch t := doc["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+
+		return types
+	default:
+		return nil
+	}
+}
+
+func credentialPreview(types []string) protocol.PreviewCredential {
+	preview := protocol.PreviewCredential{Type: protocol.CredentialPreviewMsgType}
+
+	for _, t := range types {
+		preview.Attributes = append(preview.Attributes, protocol.Attribute{Name: credentialTypeAttr, Value: t})
+	}
+
+	return preview
+}
+
+func previewTypes(preview protocol.PreviewCredential) []string {
+	var types []string
+
+	for _, attr := range preview.Attributes {
+		if attr.Name == credentialTypeAttr {
+			types = append(types, attr.Value)
+		}
+	}
+
+	return types
+}
+
+// transferKey keys the transfers by direction, since the sender and the recipient share the id of the thread, and
+// may be users of the same agent.
+func transferKey(direction, id string) string {
+	return direction + "_" + id
+}
@@ -0,0 +1,473 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/client/issuecredential"
+	"github.com/hyperledger/aries-framework-go/pkg/didcomm/common/service"
+	protocol "github.com/hyperledger/aries-framework-go/pkg/didcomm/protocol/issuecredential"
+	mockprovider "github.com/hyperledger/aries-framework-go/pkg/mock/provider"
+	mockstorage "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/hyperledger/aries-framework-go/pkg/store/connection"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNew_Transfers(t *testing.T) {
+	t.Run("registers the transfer handlers", func(t *testing.T) {
+		o, _, _ := newTransferOperation(t)
+		require.Len(t, o.GetRESTHandlers(), 9)
+	})
+
+	t.Run("error without the events of the agent", func(t *testing.T) {
+		c := transferConfig(t)
+		c.Events = nil
+
+		_, err := New(c)
+		require.EqualError(t, err, "credential transfers require the events of the agent")
+	})
+
+	t.Run("error without the issue-credential service", func(t *testing.T) {
+		c := transferConfig(t)
+		c.Aries.(*mockprovider.Provider).ServiceMap[protocol.Name] = nil
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create issue-credential client")
+	})
+
+	t.Run("error if the transfers store cannot be opened", func(t *testing.T) {
+		c := transferConfig(t)
+		c.Transfers.Storage = &mockstore.Provider{ErrCreateStore: errors.New("test")}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open transfers store")
+	})
+}
+
+func TestOperation_Transfer(t *testing.T) {
+	t.Run("transfers a credential once the recipient accepts it", func(t *testing.T) {
+		o, client, dispatcher := newTransferOperation(t)
+		alice, bob := connectUsers(t, o)
+
+		w := sendCredential(t, o, alice, &SendCredentialRequest{
+			ConnectionID: "alice-bob", CredentialID: "urn:uuid:degree", Comment: "your degree",
+		})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		sent := &Transfer{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), sent))
+		require.Equal(t, "thread", sent.ID)
+		require.Equal(t, TransferSent, sent.Direction)
+		require.Equal(t, TransferOffered, sent.Status)
+		require.Equal(t, []string{"VerifiableCredential", "UniversityDegreeCredential"}, sent.Types)
+
+		// the offer only previews the credential
+		require.Empty(t, client.offer.OffersAttach)
+		require.Equal(t, []string{"did:peer:alice", "did:peer:bob"}, client.offerDIDs)
+
+		o.handleTransferAction(transferAction(t, client.offer, protocol.OfferCredentialMsgType,
+			"did:peer:bob", "did:peer:alice"))
+
+		received := listTransfers(t, o, bob)
+		require.Len(t, received, 1)
+		require.Equal(t, TransferReceived, received[0].Direction)
+		require.Equal(t, TransferOffered, received[0].Status)
+		require.Equal(t, "bob-alice", received[0].ConnectionID)
+		require.Equal(t, sent.Types, received[0].Types)
+		require.Equal(t, "your degree", received[0].Comment)
+
+		w = answerTransfer(t, o, bob, acceptTransferPath, &TransferRequest{ID: "thread"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, []string{"thread"}, client.accepted)
+		require.Equal(t, TransferAccepted, listTransfers(t, o, bob)[0].Status)
+
+		o.handleTransferAction(transferAction(t, &protocol.RequestCredential{}, protocol.RequestCredentialMsgType,
+			"did:peer:alice", "did:peer:bob"))
+
+		require.Len(t, client.issued.CredentialsAttach, 1)
+		require.Equal(t, TransferCompleted, listTransfers(t, o, alice)[0].Status)
+
+		o.handleTransferAction(transferAction(t, client.issued, protocol.IssueCredentialMsgType,
+			"did:peer:bob", "did:peer:alice"))
+
+		completed := listTransfers(t, o, bob)[0]
+		require.Equal(t, TransferCompleted, completed.Status)
+		require.JSONEq(t, degreeCredential, string(o.transfers.vault.(*mockVault).saved[completed.CredentialID]))
+		require.Equal(t, bob, o.transfers.vault.(*mockVault).sub)
+		require.Equal(t, []string{completed.CredentialID}, client.names)
+
+		topics := map[string]int{}
+
+		for i := 0; i < 6; i++ {
+			e := dispatcher.next(t)
+			topics[e.Topic+" "+e.Subject]++
+		}
+
+		require.Equal(t, map[string]int{
+			TopicCredentialTransfer + " " + alice: 2,
+			TopicCredentialTransfer + " " + bob:   3,
+			TopicCredentialReceived + " " + bob:   1,
+		}, topics)
+	})
+
+	t.Run("records the offers declined by the recipient", func(t *testing.T) {
+		o, client, _ := newTransferOperation(t)
+		alice, bob := connectUsers(t, o)
+
+		w := sendCredential(t, o, alice, &SendCredentialRequest{ConnectionID: "alice-bob", CredentialID: "urn:uuid:jwt"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		o.handleTransferAction(transferAction(t, client.offer, protocol.OfferCredentialMsgType,
+			"did:peer:bob", "did:peer:alice"))
+
+		w = answerTransfer(t, o, bob, declineTransferPath, &DeclineTransferRequest{ID: "thread"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, []string{defaultDeclineReason}, client.declined)
+		require.Equal(t, TransferDeclined, listTransfers(t, o, bob)[0].Status)
+
+		w = answerTransfer(t, o, bob, acceptTransferPath, &TransferRequest{ID: "thread"})
+		require.Equal(t, http.StatusConflict, w.Code)
+
+		o.handleTransferAction(transferAction(t, &protocol.RequestCredential{}, protocol.ProblemReportMsgType,
+			"did:peer:alice", "did:peer:bob"))
+
+		require.Equal(t, TransferDeclined, listTransfers(t, o, alice)[0].Status)
+		require.Equal(t, 1, client.problemReports)
+	})
+
+	t.Run("stops the offers of the connections without owner", func(t *testing.T) {
+		o, _, _ := newTransferOperation(t)
+
+		action := transferAction(t, &protocol.OfferCredential{}, protocol.OfferCredentialMsgType,
+			"did:peer:bob", "did:peer:alice")
+
+		var stopped error
+
+		action.Stop = func(err error) { stopped = err }
+
+		o.handleTransferAction(action)
+		require.Error(t, stopped)
+		require.Contains(t, stopped.Error(), "failed to find connection")
+	})
+
+	t.Run("stops the credentials of the offers not accepted", func(t *testing.T) {
+		o, client, _ := newTransferOperation(t)
+		alice, _ := connectUsers(t, o)
+
+		w := sendCredential(t, o, alice, &SendCredentialRequest{ConnectionID: "alice-bob", CredentialID: "urn:uuid:degree"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		o.handleTransferAction(transferAction(t, client.offer, protocol.OfferCredentialMsgType,
+			"did:peer:bob", "did:peer:alice"))
+
+		action := transferAction(t, &protocol.IssueCredential{}, protocol.IssueCredentialMsgType,
+			"did:peer:bob", "did:peer:alice")
+
+		var stopped error
+
+		action.Stop = func(err error) { stopped = err }
+
+		o.handleTransferAction(action)
+		require.EqualError(t, stopped, "transfer is offered")
+		require.Nil(t, client.names)
+	})
+
+	t.Run("fails the transfer if the credential left the vault of the sender", func(t *testing.T) {
+		o, client, _ := newTransferOperation(t)
+		alice, _ := connectUsers(t, o)
+
+		w := sendCredential(t, o, alice, &SendCredentialRequest{ConnectionID: "alice-bob", CredentialID: "urn:uuid:degree"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		delete(o.transfers.vault.(*mockVault).saved, "urn:uuid:degree")
+
+		action := transferAction(t, &protocol.RequestCredential{}, protocol.RequestCredentialMsgType,
+			"did:peer:alice", "did:peer:bob")
+
+		var stopped error
+
+		action.Stop = func(err error) { stopped = err }
+
+		o.handleTransferAction(action)
+		require.Equal(t, errCredentialNotFound, stopped)
+
+		sent := listTransfers(t, o, alice)[0]
+		require.Equal(t, TransferFailed, sent.Status)
+		require.Equal(t, errCredentialNotFound.Error(), sent.Error)
+		require.Nil(t, client.issued)
+	})
+}
+
+func TestOperation_SendCredential(t *testing.T) {
+	t.Run("not found unless the user owns the connection and the credential", func(t *testing.T) {
+		o, _, _ := newTransferOperation(t)
+		alice, bob := connectUsers(t, o)
+
+		w := sendCredential(t, o, bob, &SendCredentialRequest{ConnectionID: "alice-bob", CredentialID: "urn:uuid:degree"})
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "connection not found")
+
+		w = sendCredential(t, o, alice, &SendCredentialRequest{ConnectionID: "alice-bob", CredentialID: "urn:uuid:x"})
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "credential not found")
+	})
+
+	t.Run("conflict if the connection is not established", func(t *testing.T) {
+		o, _, _ := newTransferOperation(t)
+		sub := acceptInvitation(t, o, "pending")
+
+		require.NoError(t, o.records.SaveConnectionRecord(&connection.Record{ConnectionID: "pending"}))
+
+		w := sendCredential(t, o, sub, &SendCredentialRequest{ConnectionID: "pending", CredentialID: "urn:uuid:degree"})
+		require.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("error if the offer cannot be sent", func(t *testing.T) {
+		o, client, _ := newTransferOperation(t)
+		alice, _ := connectUsers(t, o)
+		client.err = errors.New("test")
+
+		w := sendCredential(t, o, alice, &SendCredentialRequest{ConnectionID: "alice-bob", CredentialID: "urn:uuid:degree"})
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to offer credential")
+		require.Empty(t, listTransfers(t, o, alice))
+	})
+
+	t.Run("bad request if the request is malformed", func(t *testing.T) {
+		o, _, _ := newTransferOperation(t)
+		loginAs(o, "sub")
+
+		w := httptest.NewRecorder()
+		o.sendCredentialHandler(w, httptest.NewRequest(http.MethodPost, transfersPath, bytes.NewReader([]byte("{"))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestOperation_AnswerTransfer(t *testing.T) {
+	t.Run("not found unless the user received the offer", func(t *testing.T) {
+		o, client, _ := newTransferOperation(t)
+		alice, _ := connectUsers(t, o)
+
+		w := sendCredential(t, o, alice, &SendCredentialRequest{ConnectionID: "alice-bob", CredentialID: "urn:uuid:degree"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		// the sender cannot accept their own offer
+		w = answerTransfer(t, o, alice, acceptTransferPath, &TransferRequest{ID: "thread"})
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Nil(t, client.accepted)
+
+		w = answerTransfer(t, o, alice, declineTransferPath, &DeclineTransferRequest{})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("error if the offer cannot be accepted", func(t *testing.T) {
+		o, client, _ := newTransferOperation(t)
+		alice, bob := connectUsers(t, o)
+
+		w := sendCredential(t, o, alice, &SendCredentialRequest{ConnectionID: "alice-bob", CredentialID: "urn:uuid:degree"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		o.handleTransferAction(transferAction(t, client.offer, protocol.OfferCredentialMsgType,
+			"did:peer:bob", "did:peer:alice"))
+
+		client.err = errors.New("test")
+
+		w = answerTransfer(t, o, bob, acceptTransferPath, &TransferRequest{ID: "thread"})
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Equal(t, TransferOffered, listTransfers(t, o, bob)[0].Status)
+	})
+}
+
+func transferConfig(t *testing.T) *Config {
+	t.Helper()
+
+	svc, err := protocol.New(&issueCredentialProvider{storage: mockstorage.NewMockStoreProvider()})
+	require.NoError(t, err)
+
+	c := config()
+	c.Aries.(*mockprovider.Provider).ServiceMap[protocol.Name] = svc
+	c.Events = &EventsConfig{Dispatcher: newMockDispatcher(), Storage: memstore.NewProvider()}
+	c.Transfers = &TransferConfig{
+		Vault: &mockVault{saved: map[string][]byte{
+			"urn:uuid:degree": []byte(degreeCredential),
+			"urn:uuid:jwt":    jwtCredential(t),
+		}},
+		Storage: memstore.NewProvider(),
+	}
+
+	return c
+}
+
+func newTransferOperation(t *testing.T) (*Operation, *mockTransferClient, *mockDispatcher) {
+	t.Helper()
+
+	c := transferConfig(t)
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	client := &mockTransferClient{}
+	o.transfers.client = client
+
+	return o, client, c.Events.Dispatcher.(*mockDispatcher)
+}
+
+// connectUsers connects two users of the agent, and returns their subs.
+func connectUsers(t *testing.T, o *Operation) (string, string) {
+	t.Helper()
+
+	alice := acceptInvitation(t, o, "alice-bob")
+	bob := acceptInvitation(t, o, "bob-alice")
+
+	require.NoError(t, o.records.SaveConnectionRecord(&connection.Record{ConnectionID: "alice-bob",
+		State: connection.StateNameCompleted, MyDID: "did:peer:alice", TheirDID: "did:peer:bob"}))
+	require.NoError(t, o.records.SaveConnectionRecord(&connection.Record{ConnectionID: "bob-alice",
+		State: connection.StateNameCompleted, MyDID: "did:peer:bob", TheirDID: "did:peer:alice"}))
+
+	return alice, bob
+}
+
+func sendCredential(t *testing.T, o *Operation, sub string, request *SendCredentialRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	loginAs(o, sub)
+
+	w := httptest.NewRecorder()
+	o.sendCredentialHandler(w, httptest.NewRequest(http.MethodPost, transfersPath, bytes.NewReader(marshal(t, request))))
+
+	return w
+}
+
+func answerTransfer(t *testing.T, o *Operation, sub, path string, request interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	loginAs(o, sub)
+
+	handler := o.acceptTransferHandler
+	if path == declineTransferPath {
+		handler = o.declineTransferHandler
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(marshal(t, request))))
+
+	return w
+}
+
+func listTransfers(t *testing.T, o *Operation, sub string) []*Transfer {
+	t.Helper()
+
+	loginAs(o, sub)
+
+	w := httptest.NewRecorder()
+	o.transfersHandler(w, httptest.NewRequest(http.MethodGet, transfersPath, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	resp := &TransfersResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+
+	return resp.Transfers
+}
+
+// transferAction is the action of a message of the thread received by the agent of myDID.
+func transferAction(t *testing.T, msg interface{}, msgType, myDID, theirDID string) service.DIDCommAction {
+	t.Helper()
+
+	m := service.NewDIDCommMsgMap(msg)
+	m["@type"] = msgType
+
+	return service.DIDCommAction{
+		ProtocolName: protocol.Name,
+		Message:      m,
+		Continue:     func(interface{}) {},
+		Stop: func(err error) {
+			require.FailNow(t, "unexpected stop", err.Error())
+		},
+		Properties: mockProperties{"piid": "thread", "myDID": myDID, "theirDID": theirDID},
+	}
+}
+
+type mockTransferClient struct {
+	err            error
+	offer          *issuecredential.OfferCredential
+	offerDIDs      []string
+	accepted       []string
+	declined       []string
+	issued         *issuecredential.IssueCredential
+	names          []string
+	problemReports int
+}
+
+func (m *mockTransferClient) SendOffer(offer *issuecredential.OfferCredential, myDID, theirDID string) (string,
+	error) {
+	if m.err != nil {
+		return "", m.err
+	}
+
+	m.offer = offer
+	m.offerDIDs = []string{myDID, theirDID}
+
+	return "thread", nil
+}
+
+func (m *mockTransferClient) AcceptOffer(piID string) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.accepted = append(m.accepted, piID)
+
+	return nil
+}
+
+func (m *mockTransferClient) DeclineOffer(_, reason string) error {
+	m.declined = append(m.declined, reason)
+
+	return m.err
+}
+
+func (m *mockTransferClient) AcceptRequest(_ string, msg *issuecredential.IssueCredential) error {
+	m.issued = msg
+
+	return m.err
+}
+
+func (m *mockTransferClient) AcceptCredential(_ string, names ...string) error {
+	m.names = names
+
+	return m.err
+}
+
+func (m *mockTransferClient) AcceptProblemReport(string) error {
+	m.problemReports++
+
+	return m.err
+}
+
+// issueCredentialProvider creates an issue-credential service that sends no message.
+type issueCredentialProvider struct {
+	storage ariesstorage.Provider
+}
+
+func (p *issueCredentialProvider) Messenger() service.Messenger {
+	return nil
+}
+
+func (p *issueCredentialProvider) StorageProvider() ariesstorage.Provider {
+	return p.storage
+}
@@ -0,0 +1,233 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// waciPayload is scanned by the wallet UI from the QR code of an issuer or verifier.
+type waciPayload struct {
+	ChallengeTokenURL string `json:"challengeTokenUrl,omitempty"`
+	ChallengeToken    string `json:"challengeToken,omitempty"`
+}
+
+// waciChallenge are the claims of a WACI challenge token.
+type waciChallenge struct {
+	ID          string          `json:"jti"`
+	Issuer      string          `json:"iss"`
+	Purpose     string          `json:"purpose"`
+	CallbackURL string          `json:"callbackUrl"`
+	Definition  json.RawMessage `json:"presentation_definition,omitempty"`
+	Manifest    json.RawMessage `json:"credential_manifest,omitempty"`
+}
+
+// waciResponse are the claims of the response token sent back to the callback.
+type waciResponse struct {
+	ID           string `json:"jti"`
+	Issuer       string `json:"iss"`
+	Audience     string `json:"aud,omitempty"`
+	Challenge    string `json:"challenge"`
+	IssuedAt     int64  `json:"iat"`
+	Presentation string `json:"verifiable_presentation,omitempty"`
+}
+
+type waciResponseBody struct {
+	ResponseToken string `json:"responseToken"`
+}
+
+// resolveWACI reads the challenge token. Its signature is not checked: the token is only shown to the user,
+// and the issuer or verifier authenticates the wallet through the signed response token.
+func (o *Operation) resolveWACI(payload json.RawMessage) (*Interaction, error) {
+	p := &waciPayload{}
+
+	err := json.Unmarshal(payload, p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid waci payload: %s", errInvalidInteraction, err.Error())
+	}
+
+	token := p.ChallengeToken
+
+	if token == "" {
+		if p.ChallengeTokenURL == "" {
+			return nil, fmt.Errorf("%w: waci payload has no challenge token", errInvalidInteraction)
+		}
+
+		token, err = o.fetchChallengeToken(p.ChallengeTokenURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	challenge := &waciChallenge{}
+
+	err = decodeJWTClaims(token, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid challenge token: %s", errInvalidInteraction, err.Error())
+	}
+
+	if challenge.CallbackURL == "" {
+		return nil, fmt.Errorf("%w: challenge token has no callback url", errInvalidInteraction)
+	}
+
+	interaction := &Interaction{
+		Type:        InteractionWACI,
+		Purpose:     challenge.Purpose,
+		From:        challenge.Issuer,
+		Challenge:   challenge.ID,
+		callbackURL: challenge.CallbackURL,
+	}
+
+	switch challenge.Purpose {
+	case PurposeRequest:
+		interaction.Query = challenge.Definition
+	case PurposeOffer:
+		interaction.Query = challenge.Manifest
+	default:
+		return nil, fmt.Errorf("%w: unsupported waci purpose '%s'", errInvalidInteraction, challenge.Purpose)
+	}
+
+	return interaction, nil
+}
+
+// respondWACI posts a response token signed by the holder to the callback and returns the reply.
+func (o *Operation) respondWACI(interaction *Interaction, holder, presentation string) (json.RawMessage, error) {
+	if holder == "" {
+		return nil, fmt.Errorf("%w: a holder is required to respond to waci", errInvalidInteraction)
+	}
+
+	signer, err := o.holderSigner(holder)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := signJWT(&waciResponse{
+		ID:           uuid.New().String(),
+		Issuer:       holder,
+		Audience:     interaction.From,
+		Challenge:    interaction.Challenge,
+		IssuedAt:     time.Now().Unix(),
+		Presentation: presentation,
+	}, nil, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(&waciResponseBody{ResponseToken: token})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal waci response: %w", err)
+	}
+
+	reply, err := o.sendHTTPRequest(http.MethodPost, interaction.callbackURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to post waci response: %w", err)
+	}
+
+	if !json.Valid(reply) {
+		reply, err = json.Marshal(string(reply))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal waci reply: %w", err)
+		}
+	}
+
+	return reply, nil
+}
+
+func (o *Operation) fetchChallengeToken(url string) (string, error) {
+	reply, err := o.sendHTTPRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch challenge token: %w", err)
+	}
+
+	p := &waciPayload{}
+
+	err = json.Unmarshal(reply, p)
+	if err != nil || p.ChallengeToken == "" {
+		return "", fmt.Errorf("%w: invalid challenge token response", errInvalidInteraction)
+	}
+
+	return p.ChallengeToken, nil
+}
+
+func (o *Operation) sendHTTPRequest(method, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(context.Background(), method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return o.doHTTPRequest(req)
+}
+
+func (o *Operation) postForm(endpoint string, form url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(context.Background(),
+		http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return o.doHTTPRequest(req)
+}
+
+// doHTTPRequest sends the request and returns the body of a successful response.
+func (o *Operation) doHTTPRequest(req *http.Request) ([]byte, error) {
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Errorf("failed to close response body: %s", errClose)
+		}
+	}()
+
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("%s %s returned status %d: %s", req.Method, req.URL, resp.StatusCode, string(reply))
+	}
+
+	return reply, nil
+}
+
+// decodeJWTClaims decodes the claims of a compact JWT without verifying it.
+func decodeJWTClaims(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+
+	const jwtParts = 3
+
+	if len(parts) != jwtParts {
+		return fmt.Errorf("not a compact jwt")
+	}
+
+	bits, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("failed to decode jwt claims: %w", err)
+	}
+
+	return json.Unmarshal(bits, claims)
+}
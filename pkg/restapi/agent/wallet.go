@@ -0,0 +1,170 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/hyperledger/aries-framework-go/pkg/store/connection"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	didsStoreName = "edgeagent_dids"
+	// the name of the metadata of the agent in the wallet backups.
+	walletSourceName = "agent"
+)
+
+type connectionRecorder interface {
+	GetConnectionRecord(connectionID string) (*connection.Record, error)
+	SaveConnectionRecord(record *connection.Record) error
+	GetConnectionIDByDIDs(myDID, theirDID string) (string, error)
+}
+
+// walletMetadata is the metadata of the wallet of a user kept by the agent.
+type walletMetadata struct {
+	DIDs        []string             `json:"dids"`
+	Connections []*connection.Record `json:"connections"`
+}
+
+// WalletMetadata backs up the DIDs and the DIDComm connections of the users with the rest of their wallets.
+type WalletMetadata struct {
+	o *Operation
+}
+
+// WalletMetadata returns the metadata of the wallets kept by the agent.
+func (o *Operation) WalletMetadata() *WalletMetadata {
+	return &WalletMetadata{o: o}
+}
+
+// Name of the metadata of the agent in the wallet backups.
+func (m *WalletMetadata) Name() string {
+	return walletSourceName
+}
+
+// Backup returns the DIDs and the connections of the user. The DIDs are only known if the agent keeps track of
+// them, and the connections if its events are enabled.
+func (m *WalletMetadata) Backup(sub string) (json.RawMessage, error) {
+	metadata := &walletMetadata{DIDs: []string{}, Connections: []*connection.Record{}}
+
+	var err error
+
+	if m.o.didOwners != nil {
+		metadata.DIDs, err = ownedBy(m.o.didOwners, sub)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list dids: %w", err)
+		}
+	}
+
+	if m.o.connectionOwners != nil {
+		ids, listErr := ownedBy(m.o.connectionOwners, sub)
+		if listErr != nil {
+			return nil, fmt.Errorf("failed to list connections: %w", listErr)
+		}
+
+		for _, id := range ids {
+			record, getErr := m.o.records.GetConnectionRecord(id)
+			if errors.Is(getErr, ariesstorage.ErrDataNotFound) {
+				logger.Warnf("connection %s of user %s was not found", id, sub)
+
+				continue
+			}
+
+			if getErr != nil {
+				return nil, fmt.Errorf("failed to fetch connection %s: %w", id, getErr)
+			}
+
+			metadata.Connections = append(metadata.Connections, record)
+		}
+	}
+
+	return json.Marshal(metadata)
+}
+
+// Restore gives the user back their DIDs and connections, and saves the connections the agent lost. The DIDs and
+// connections owned by other users are left to them.
+func (m *WalletMetadata) Restore(sub string, raw json.RawMessage) error {
+	metadata := &walletMetadata{}
+
+	err := json.Unmarshal(raw, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal wallet metadata: %w", err)
+	}
+
+	if m.o.didOwners != nil {
+		for _, did := range metadata.DIDs {
+			err = restoreOwner(m.o.didOwners, did, sub)
+			if err != nil {
+				return fmt.Errorf("failed to restore did %s: %w", did, err)
+			}
+		}
+	}
+
+	for _, record := range metadata.Connections {
+		if m.o.connectionOwners != nil {
+			err = restoreOwner(m.o.connectionOwners, record.ConnectionID, sub)
+			if err != nil {
+				return fmt.Errorf("failed to restore connection %s: %w", record.ConnectionID, err)
+			}
+		}
+
+		_, err = m.o.records.GetConnectionRecord(record.ConnectionID)
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, ariesstorage.ErrDataNotFound) {
+			return fmt.Errorf("failed to fetch connection %s: %w", record.ConnectionID, err)
+		}
+
+		err = m.o.records.SaveConnectionRecord(record)
+		if err != nil {
+			return fmt.Errorf("failed to save connection %s: %w", record.ConnectionID, err)
+		}
+	}
+
+	return nil
+}
+
+// ownedBy returns the keys of the store owned by the user, sorted.
+func ownedBy(owners storage.Store, sub string) ([]string, error) {
+	all, err := owners.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+
+	for key, owner := range all {
+		if string(owner) == sub {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func restoreOwner(owners storage.Store, key, sub string) error {
+	owner, err := owners.Get(key)
+	if err == nil && string(owner) != sub {
+		logger.Warnf("not restoring %s to user %s: owned by another user", key, sub)
+
+		return nil
+	}
+
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return err
+	}
+
+	return owners.Put(key, []byte(sub))
+}
@@ -0,0 +1,194 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package agent // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	mockvdr "github.com/hyperledger/aries-framework-go/pkg/mock/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/store/connection"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestWalletMetadata(t *testing.T) {
+	t.Run("backs up the dids and connections of the user and restores them", func(t *testing.T) {
+		o := newWalletOperation(t)
+		sub := acceptInvitation(t, o, "connection")
+		createDID(t, o, sub, "did:peer:123")
+		createDID(t, o, uuid.New().String(), "did:peer:456")
+
+		record := &connection.Record{ConnectionID: "connection", State: connection.StateNameCompleted,
+			MyDID: "did:peer:123", TheirDID: "did:peer:789"}
+		require.NoError(t, o.records.SaveConnectionRecord(record))
+
+		metadata := o.WalletMetadata()
+		require.Equal(t, walletSourceName, metadata.Name())
+
+		raw, err := metadata.Backup(sub)
+		require.NoError(t, err)
+
+		backup := &walletMetadata{}
+		require.NoError(t, json.Unmarshal(raw, backup))
+		require.Equal(t, []string{"did:peer:123"}, backup.DIDs)
+		require.Len(t, backup.Connections, 1)
+		require.Equal(t, "did:peer:789", backup.Connections[0].TheirDID)
+
+		// a fresh agent
+		fresh := newWalletOperation(t)
+		require.NoError(t, fresh.WalletMetadata().Restore(sub, raw))
+
+		owner, err := fresh.didOwners.Get("did:peer:123")
+		require.NoError(t, err)
+		require.Equal(t, sub, string(owner))
+
+		owner, err = fresh.connectionOwners.Get("connection")
+		require.NoError(t, err)
+		require.Equal(t, sub, string(owner))
+
+		restored, err := fresh.records.GetConnectionRecord("connection")
+		require.NoError(t, err)
+		require.Equal(t, "did:peer:789", restored.TheirDID)
+
+		// restoring again changes nothing
+		require.NoError(t, fresh.WalletMetadata().Restore(sub, raw))
+	})
+
+	t.Run("leaves the dids of other users to them", func(t *testing.T) {
+		o := newWalletOperation(t)
+		createDID(t, o, "other", "did:peer:123")
+
+		err := o.WalletMetadata().Restore("user", json.RawMessage(`{"dids":["did:peer:123"]}`))
+		require.NoError(t, err)
+
+		owner, err := o.didOwners.Get("did:peer:123")
+		require.NoError(t, err)
+		require.Equal(t, "other", string(owner))
+	})
+
+	t.Run("nothing to back up if the agent does not track the user", func(t *testing.T) {
+		raw, err := newOperation(t).WalletMetadata().Backup("user")
+		require.NoError(t, err)
+		require.JSONEq(t, `{"dids":[],"connections":[]}`, string(raw))
+	})
+
+	t.Run("skips the connections the agent lost", func(t *testing.T) {
+		o := newWalletOperation(t)
+		sub := acceptInvitation(t, o, "connection")
+
+		raw, err := o.WalletMetadata().Backup(sub)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"dids":[],"connections":[]}`, string(raw))
+	})
+
+	t.Run("error if the owners cannot be listed", func(t *testing.T) {
+		o := newWalletOperation(t)
+		o.didOwners = &mockstore.MockStore{Store: map[string][]byte{}, ErrGetAll: errors.New("test")}
+
+		_, err := o.WalletMetadata().Backup("user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to list dids")
+
+		o = newWalletOperation(t)
+		o.connectionOwners = &mockstore.MockStore{Store: map[string][]byte{}, ErrGetAll: errors.New("test")}
+
+		_, err = o.WalletMetadata().Backup("user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to list connections")
+	})
+
+	t.Run("error if the metadata is invalid", func(t *testing.T) {
+		err := newWalletOperation(t).WalletMetadata().Restore("user", json.RawMessage(`{`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal wallet metadata")
+	})
+
+	t.Run("error if the owners cannot be restored", func(t *testing.T) {
+		o := newWalletOperation(t)
+		o.didOwners = &mockstore.MockStore{Store: map[string][]byte{}, ErrPut: errors.New("test")}
+
+		err := o.WalletMetadata().Restore("user", json.RawMessage(`{"dids":["did:peer:123"]}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to restore did did:peer:123")
+
+		o.connectionOwners = &mockstore.MockStore{Store: map[string][]byte{}, ErrPut: errors.New("test")}
+
+		err = o.WalletMetadata().Restore("user", json.RawMessage(`{"connections":[{"ConnectionID":"connection"}]}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to restore connection connection")
+	})
+}
+
+func TestOperation_CreateDID_Owner(t *testing.T) {
+	t.Run("error if the dids store cannot be opened", func(t *testing.T) {
+		c := config()
+		c.Storage = &mockstore.Provider{ErrCreateStore: errors.New("test")}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open dids store")
+	})
+
+	t.Run("internal server error if the owner cannot be saved", func(t *testing.T) {
+		o := newWalletOperation(t)
+		o.didOwners = &mockstore.MockStore{Store: map[string][]byte{}, ErrPut: errors.New("test")}
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{
+			userSubCookieName: "user",
+		}}}
+		o.vdr = &mockvdr.MockVDRegistry{
+			CreateFunc: func(string, ...vdrapi.DocOpts) (*did.Doc, error) {
+				return &did.Doc{Context: []string{did.Context}, ID: "did:peer:123"}, nil
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.createDIDHandler(w, httptest.NewRequest(http.MethodPost, didsPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save owner of did did:peer:123")
+	})
+}
+
+// newWalletOperation returns an Operation keeping track of the DIDs and connections of the users.
+func newWalletOperation(t *testing.T) *Operation {
+	t.Helper()
+
+	c := config()
+	c.Events = &EventsConfig{Dispatcher: newMockDispatcher(), Storage: memstore.NewProvider()}
+	c.Storage = memstore.NewProvider()
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	return o
+}
+
+// createDID creates a DID as the user.
+func createDID(t *testing.T, o *Operation, sub, id string) {
+	t.Helper()
+
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{
+		userSubCookieName: sub,
+	}}}
+	o.vdr = &mockvdr.MockVDRegistry{
+		CreateFunc: func(string, ...vdrapi.DocOpts) (*did.Doc, error) {
+			return &did.Doc{Context: []string{did.Context}, ID: id}, nil
+		},
+	}
+
+	w := httptest.NewRecorder()
+	o.createDIDHandler(w, httptest.NewRequest(http.MethodPost, didsPath, nil))
+	require.Equal(t, http.StatusCreated, w.Code)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Admin endpoints.
+const (
+	auditPath       = "/audit"
+	auditVerifyPath = "/audit/verify"
+)
+
+const (
+	// the format of the entries exported for SIEM ingestion: one JSON entry per line.
+	jsonLinesFormat      = "jsonl"
+	jsonLinesContentType = "application/x-ndjson"
+)
+
+var logger = log.New("edge-agent/audit")
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Audit *audit.Store
+}
+
+// VerifyResponse is the result of the verification of the audit chain.
+type VerifyResponse struct {
+	Valid   bool   `json:"valid"`
+	Entries uint64 `json:"entries"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Operation serves the audit log through the administrative API.
+type Operation struct {
+	audit *audit.Store
+}
+
+// New returns a new Operation.
+func New(config *Config) (*Operation, error) {
+	if config.Audit == nil {
+		return nil, errors.New("missing audit store")
+	}
+
+	return &Operation{audit: config.Audit}, nil
+}
+
+// GetRESTHandlers returns the administrative handlers. They must be mounted behind admin authentication.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(auditPath, http.MethodGet, o.listHandler, &common.OperationSpec{
+			Summary: "Lists the audit entries about a user, or all entries.",
+			Params: []common.Param{
+				common.QueryParam("user", "Sub of the user."),
+				common.QueryParam("format", "Set to jsonl for JSON lines."),
+			},
+			Responses: map[int]interface{}{http.StatusOK: []*audit.Entry{}},
+		}),
+		common.NewHTTPHandler(auditVerifyPath, http.MethodGet, o.verifyHandler, &common.OperationSpec{
+			Summary: "Verifies the hash chain of the audit log.",
+			Responses: map[int]interface{}{
+				http.StatusOK:       &VerifyResponse{},
+				http.StatusConflict: &VerifyResponse{},
+			},
+		}),
+	}
+}
+
+// listHandler returns the entries about a user, or all entries, as a JSON array or as JSON lines
+// with format=jsonl.
+func (o *Operation) listHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := o.audit.List(r.URL.Query().Get("user"))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	if r.URL.Query().Get("format") != jsonLinesFormat {
+		common.WriteResponse(w, logger, entries)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonLinesContentType)
+
+	// the encoder terminates each entry with a newline
+	encoder := json.NewEncoder(w)
+
+	for _, e := range entries {
+		err = encoder.Encode(e)
+		if err != nil {
+			logger.Errorf("failed to write audit entry %d: %s", e.Seq, err.Error())
+
+			return
+		}
+	}
+}
+
+func (o *Operation) verifyHandler(w http.ResponseWriter, _ *http.Request) {
+	entries, err := o.audit.Verify()
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		common.WriteResponse(w, logger, &VerifyResponse{Error: err.Error()})
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &VerifyResponse{Valid: true, Entries: entries})
+}
+
+// Middleware records the administrative requests in the audit log, along with their response status. Applied in
+// front of the admin authentication, it also records the rejected attempts.
+func Middleware(s *audit.Store) common.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(recorder, r)
+
+			err := s.Record(audit.ActionAdmin, "", audit.ActorAdmin, &adminRequest{
+				Method: r.Method,
+				Path:   r.URL.Path,
+				Query:  r.URL.RawQuery,
+				Status: recorder.status,
+			})
+			if err != nil {
+				logger.Errorf("failed to audit admin request %s %s: %s", r.Method, r.URL.Path, err.Error())
+			}
+		})
+	}
+}
+
+type adminRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Status int    `json:"status"`
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
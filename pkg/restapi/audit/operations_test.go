@@ -0,0 +1,176 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package audit // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("returns an instance", func(t *testing.T) {
+		o, _ := newOperation(t)
+		require.Len(t, o.GetRESTHandlers(), 2)
+	})
+
+	t.Run("error if audit store is missing", func(t *testing.T) {
+		_, err := New(&Config{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing audit store")
+	})
+}
+
+func TestOperation_ListHandler(t *testing.T) {
+	t.Run("lists the entries about a user", func(t *testing.T) {
+		o, s := newOperation(t)
+		require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+		require.NoError(t, s.Record(audit.ActionLogin, "bob", "bob", nil))
+		require.NoError(t, s.Record(audit.ActionLogout, "alice", "alice", nil))
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, "/audit?user=alice", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var entries []*audit.Entry
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+		require.Len(t, entries, 2)
+		require.Equal(t, audit.ActionLogout, entries[1].Action)
+
+		w = httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, "/audit", nil))
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+		require.Len(t, entries, 3)
+	})
+
+	t.Run("exports the entries as json lines", func(t *testing.T) {
+		o, s := newOperation(t)
+		require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+		require.NoError(t, s.Record(audit.ActionLogout, "alice", "alice", nil))
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, "/audit?format=jsonl", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, jsonLinesContentType, w.Header().Get("Content-Type"))
+
+		var lines []*audit.Entry
+
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			e := &audit.Entry{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), e))
+
+			lines = append(lines, e)
+		}
+
+		require.Len(t, lines, 2)
+		require.Equal(t, uint64(2), lines[1].Seq)
+		require.Equal(t, lines[0].Hash, lines[1].PrevHash)
+	})
+
+	t.Run("error if the entries cannot be listed", func(t *testing.T) {
+		s, err := audit.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:     map[string][]byte{},
+			ErrGetAll: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		o, err := New(&Config{Audit: s})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, "/audit", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestOperation_VerifyHandler(t *testing.T) {
+	t.Run("verifies the chain", func(t *testing.T) {
+		o, s := newOperation(t)
+		require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+
+		w := httptest.NewRecorder()
+		o.verifyHandler(w, httptest.NewRequest(http.MethodGet, "/audit/verify", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		result := &VerifyResponse{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+		require.True(t, result.Valid)
+		require.Equal(t, uint64(1), result.Entries)
+	})
+
+	t.Run("error conflict if the chain is broken", func(t *testing.T) {
+		p := memstore.NewProvider()
+
+		s, err := audit.NewStore(p)
+		require.NoError(t, err)
+		require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+
+		raw, err := p.OpenStore(audit.StoreName)
+		require.NoError(t, err)
+		require.NoError(t, raw.Put("00000000000000000001", []byte(`{"seq": 1, "action": "user.login"}`)))
+
+		o, err := New(&Config{Audit: s})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.verifyHandler(w, httptest.NewRequest(http.MethodGet, "/audit/verify", nil))
+		require.Equal(t, http.StatusConflict, w.Code)
+
+		result := &VerifyResponse{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+		require.False(t, result.Valid)
+		require.Contains(t, result.Error, "audit entry 1 was tampered with")
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	_, s := newOperation(t)
+
+	handler := Middleware(s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/users/check?sub=alice", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/admin/webhooks?id=1", nil))
+
+	entries, err := s.List("")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	for _, e := range entries {
+		require.Equal(t, audit.ActionAdmin, e.Action)
+		require.Equal(t, audit.ActorAdmin, e.Actor)
+	}
+
+	require.JSONEq(t, `{"method": "GET", "path": "/admin/users/check", "query": "sub=alice", "status": 200}`,
+		string(entries[0].Details))
+	require.JSONEq(t, `{"method": "DELETE", "path": "/admin/webhooks", "query": "id=1", "status": 204}`,
+		string(entries[1].Details))
+}
+
+func newOperation(t *testing.T) (*Operation, *audit.Store) {
+	t.Helper()
+
+	s, err := audit.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	o, err := New(&Config{Audit: s})
+	require.NoError(t, err)
+
+	return o, s
+}
@@ -0,0 +1,138 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package authz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+var logger = log.New("edge-agent/authz")
+
+// HTTPClient sends HTTP requests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// OPA is an Engine delegating the decisions to an Open Policy Agent, with its data API: the input is posted to the
+// URL of a rule, e.g. http://opa:8181/v1/data/edgeagent/authz, whose result is either a boolean allowing the
+// request, or an object such as {"allow": false, "step_up": true, "reason": "...", "acr_values": ["..."]}. The
+// requests are denied if the rule is undefined for the input.
+type OPA struct {
+	url        string
+	httpClient HTTPClient
+}
+
+// NewOPA returns a new OPA engine for the rule at the URL.
+func NewOPA(ruleURL string, httpClient HTTPClient) *OPA {
+	return &OPA{url: ruleURL, httpClient: httpClient}
+}
+
+type opaRequest struct {
+	Input *Input `json:"input"`
+}
+
+type opaResponse struct {
+	Result json.RawMessage `json:"result"`
+}
+
+type opaDecision struct {
+	Allow     bool     `json:"allow"`
+	StepUp    bool     `json:"step_up"`
+	Reason    string   `json:"reason"`
+	ACRValues []string `json:"acr_values"`
+}
+
+// Authorize the request with the decision of the agent.
+func (o *OPA) Authorize(ctx context.Context, input *Input) (*Decision, error) {
+	body, err := json.Marshal(&opaRequest{Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal policy input: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create policy request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query policy agent: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Errorf("failed to close response body: %s", errClose.Error())
+		}
+	}()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy decision: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("policy agent answered with status %d: %s", resp.StatusCode, respBody)
+	}
+
+	result := &opaResponse{}
+
+	err = json.Unmarshal(respBody, result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy decision: %w", err)
+	}
+
+	return parseOPAResult(result.Result)
+}
+
+func parseOPAResult(result json.RawMessage) (*Decision, error) {
+	if len(result) == 0 || string(result) == "null" {
+		return &Decision{Effect: Deny, Reason: "no policy decision"}, nil
+	}
+
+	var allow bool
+
+	if json.Unmarshal(result, &allow) == nil {
+		if allow {
+			return &Decision{Effect: Allow}, nil
+		}
+
+		return &Decision{Effect: Deny, Reason: "denied by policy"}, nil
+	}
+
+	d := &opaDecision{}
+
+	err := json.Unmarshal(result, d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy decision: %w", err)
+	}
+
+	switch {
+	case d.Allow:
+		return &Decision{Effect: Allow}, nil
+	case d.StepUp:
+		if d.Reason == "" {
+			d.Reason = "step-up authentication required"
+		}
+
+		return &Decision{Effect: StepUp, Reason: d.Reason, ACRValues: d.ACRValues}, nil
+	default:
+		if d.Reason == "" {
+			d.Reason = "denied by policy"
+		}
+
+		return &Decision{Effect: Deny, Reason: d.Reason}, nil
+	}
+}
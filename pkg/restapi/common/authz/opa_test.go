@@ -0,0 +1,113 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package authz // nolint:testpackage // shares the mocks of the rules tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errTest = errors.New("test")
+
+func TestOPA_Authorize(t *testing.T) {
+	input := &Input{Method: http.MethodDelete, Path: "/oidc/wallet", Subject: &Subject{Sub: "alice"}}
+
+	agent := func(t *testing.T, status int, result string) *OPA {
+		t.Helper()
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Equal(t, "/v1/data/edgeagent/authz", r.URL.Path)
+
+			request := &struct {
+				Input *Input `json:"input"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(request))
+			require.Equal(t, input, request.Input)
+
+			w.WriteHeader(status)
+			fmt.Fprint(w, result)
+		}))
+		t.Cleanup(server.Close)
+
+		return NewOPA(server.URL+"/v1/data/edgeagent/authz", server.Client())
+	}
+
+	for _, tc := range []struct {
+		name   string
+		result string
+		effect Effect
+		reason string
+		acr    []string
+	}{
+		{name: "allowed", result: `{"result": true}`, effect: Allow},
+		{name: "denied", result: `{"result": false}`, effect: Deny, reason: "denied by policy"},
+		{name: "undefined", result: `{}`, effect: Deny, reason: "no policy decision"},
+		{name: "allowed object", result: `{"result": {"allow": true}}`, effect: Allow},
+		{
+			name:   "denied object",
+			result: `{"result": {"allow": false, "reason": "not an admin"}}`,
+			effect: Deny,
+			reason: "not an admin",
+		},
+		{
+			name:   "step-up object",
+			result: `{"result": {"step_up": true, "acr_values": ["loa3"]}}`,
+			effect: StepUp,
+			reason: "step-up authentication required",
+			acr:    []string{"loa3"},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			decision, err := agent(t, http.StatusOK, tc.result).Authorize(context.Background(), input)
+			require.NoError(t, err)
+			require.Equal(t, &Decision{Effect: tc.effect, Reason: tc.reason, ACRValues: tc.acr}, decision)
+		})
+	}
+
+	t.Run("error if the agent fails", func(t *testing.T) {
+		_, err := agent(t, http.StatusInternalServerError, "boom").Authorize(context.Background(), input)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "policy agent answered with status 500")
+	})
+
+	t.Run("error if the decision is invalid", func(t *testing.T) {
+		for _, result := range []string{`not json`, `{"result": "allow"}`} {
+			_, err := agent(t, http.StatusOK, result).Authorize(context.Background(), input)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "failed to parse policy decision")
+		}
+	})
+
+	t.Run("error if the agent cannot be reached", func(t *testing.T) {
+		_, err := NewOPA("http://opa.invalid", &mockHTTPClient{err: errTest}).Authorize(context.Background(), input)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to query policy agent")
+	})
+
+	t.Run("error if the URL is invalid", func(t *testing.T) {
+		_, err := NewOPA(":", http.DefaultClient).Authorize(context.Background(), input)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create policy request")
+	})
+}
+
+type mockHTTPClient struct {
+	err error
+}
+
+func (m *mockHTTPClient) Do(*http.Request) (*http.Response, error) {
+	return nil, m.err
+}
@@ -0,0 +1,86 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package authz
+
+import (
+	"context"
+)
+
+// Effect of a Decision.
+type Effect string
+
+const (
+	// Allow lets the request through.
+	Allow Effect = "allow"
+	// Deny turns the request away with 403.
+	Deny Effect = "deny"
+	// StepUp turns the request away with 401 until the user authenticates again, recently enough and at a high
+	// enough assurance level.
+	StepUp Effect = "step-up"
+)
+
+// Input is what the policies decide on: the endpoint called and the user calling it.
+type Input struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Subject is the logged in user, nil for the requests without session or bearer token.
+	Subject *Subject `json:"subject,omitempty"`
+}
+
+// Subject is the user calling an endpoint.
+type Subject struct {
+	Sub string `json:"sub"`
+	// Attributes of the user, mapped from the claims of the OIDC provider, e.g. groups.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// ACR, AMR and AuthTime are the authentication of the session, as told by the id_token of its login. The
+	// bearer tokens carry none.
+	ACR      string   `json:"acr,omitempty"`
+	AMR      []string `json:"amr,omitempty"`
+	AuthTime int64    `json:"auth_time,omitempty"`
+	// Bearer tells whether the user called with a bearer token rather than from a browser session.
+	Bearer bool `json:"bearer,omitempty"`
+}
+
+// Decision of a policy on a request.
+type Decision struct {
+	Effect Effect
+	// Reason of the denial, sent to the client.
+	Reason string
+	// ACRValues are the authentication context classes accepted on step-up, sent to the client in the challenge.
+	ACRValues []string
+}
+
+// Engine decides whether the users may call the endpoints.
+type Engine interface {
+	Authorize(ctx context.Context, input *Input) (*Decision, error)
+}
+
+// All returns an Engine letting a request through only if every engine does. The first denial wins.
+func All(engines ...Engine) Engine {
+	if len(engines) == 1 {
+		return engines[0]
+	}
+
+	return all(engines)
+}
+
+type all []Engine
+
+func (a all) Authorize(ctx context.Context, input *Input) (*Decision, error) {
+	for _, engine := range a {
+		decision, err := engine.Authorize(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		if decision.Effect != Allow {
+			return decision, nil
+		}
+	}
+
+	return &Decision{Effect: Allow}, nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule requires the users calling the endpoints of a path prefix to meet its conditions.
+type Rule struct {
+	// Prefix of the paths of the endpoints, e.g. /admin/ or /oidc/wallet/export.
+	Prefix string `yaml:"prefix"`
+	// Methods of the endpoints, e.g. DELETE. The rule applies to every method if empty.
+	Methods []string `yaml:"methods"`
+	// Authenticated requires a logged in user.
+	Authenticated bool `yaml:"authenticated"`
+	// Attributes require the user to have, for each attribute, one of the values. The values of the list
+	// attributes, such as groups, match if any of them does. Requires a logged in user.
+	Attributes map[string][]string `yaml:"attributes"`
+	// StepUp requires a recent authentication of a high assurance level. Requires a logged in user.
+	StepUp *StepUpRule `yaml:"step-up"`
+}
+
+// StepUpRule is the authentication the users must have stepped up to.
+type StepUpRule struct {
+	// ACRValues are the authentication context classes accepted. Any class is accepted if empty.
+	ACRValues []string `yaml:"acr-values"`
+	// AMRValues are the authentication methods accepted, e.g. mfa: the user must have authenticated with one of
+	// them. Any method is accepted if empty.
+	AMRValues []string `yaml:"amr-values"`
+	// MaxAge is how long an authentication is recent enough for, e.g. 5m.
+	MaxAge time.Duration `yaml:"max-age"`
+}
+
+// Rules is the built-in Engine: each request must meet every rule matching its path and method. The requests
+// matching no rule are let through.
+type Rules struct {
+	Rules []*Rule `yaml:"rules"`
+	// now is the time the authentications are compared to. Defaults to time.Now.
+	now func() time.Time
+}
+
+// Load the rules of a YAML or JSON file.
+func Load(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path) // nolint:gosec // the path is configured by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorization policy file: %w", err)
+	}
+
+	rules := &Rules{}
+
+	// YAML is a superset of JSON
+	err = yaml.UnmarshalStrict(data, rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse authorization policy file: %w", err)
+	}
+
+	err = rules.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Validate the rules.
+func (r *Rules) Validate() error {
+	for i, rule := range r.Rules {
+		err := rule.validate()
+		if err != nil {
+			return fmt.Errorf("invalid authorization rule %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if !strings.HasPrefix(r.Prefix, "/") {
+		return fmt.Errorf("prefix '%s' must be a path", r.Prefix)
+	}
+
+	for _, method := range r.Methods {
+		if method != strings.ToUpper(method) || method == "" {
+			return fmt.Errorf("invalid method '%s'", method)
+		}
+	}
+
+	if !r.Authenticated && len(r.Attributes) == 0 && r.StepUp == nil {
+		return errors.New("no condition")
+	}
+
+	if r.StepUp != nil && r.StepUp.MaxAge < 0 {
+		return errors.New("step-up max-age must not be negative")
+	}
+
+	return nil
+}
+
+// Authorize the request against the rules it matches.
+func (r *Rules) Authorize(_ context.Context, input *Input) (*Decision, error) {
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+
+	var stepUp *Decision
+
+	for _, rule := range r.Rules {
+		if !rule.matches(input) {
+			continue
+		}
+
+		decision := rule.check(input.Subject, now())
+
+		switch decision.Effect {
+		case Deny:
+			return decision, nil
+		case StepUp:
+			// a rule the user cannot meet by stepping up wins
+			if stepUp == nil {
+				stepUp = decision
+			}
+		case Allow:
+		}
+	}
+
+	if stepUp != nil {
+		return stepUp, nil
+	}
+
+	return &Decision{Effect: Allow}, nil
+}
+
+func (r *Rule) matches(input *Input) bool {
+	if !strings.HasPrefix(input.Path, r.Prefix) {
+		return false
+	}
+
+	if len(r.Methods) == 0 {
+		return true
+	}
+
+	for _, method := range r.Methods {
+		if method == input.Method || (method == http.MethodGet && input.Method == http.MethodHead) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) check(s *Subject, now time.Time) *Decision {
+	if s == nil {
+		return &Decision{Effect: Deny, Reason: "not logged in"}
+	}
+
+	for name, values := range r.Attributes {
+		if !hasAttribute(s.Attributes[name], values) {
+			return &Decision{Effect: Deny, Reason: fmt.Sprintf("the %s of the user are not allowed", name)}
+		}
+	}
+
+	if r.StepUp != nil && !r.StepUp.satisfied(s, now) {
+		return &Decision{
+			Effect:    StepUp,
+			Reason:    "step-up authentication required",
+			ACRValues: r.StepUp.ACRValues,
+		}
+	}
+
+	return &Decision{Effect: Allow}
+}
+
+// satisfied tells whether the authentication of the user meets the rule.
+func (r *StepUpRule) satisfied(s *Subject, now time.Time) bool {
+	if s.AuthTime == 0 {
+		return false
+	}
+
+	if r.MaxAge > 0 && now.Sub(time.Unix(s.AuthTime, 0)) > r.MaxAge {
+		return false
+	}
+
+	if len(r.ACRValues) > 0 && !includes(r.ACRValues, s.ACR) {
+		return false
+	}
+
+	if len(r.AMRValues) == 0 {
+		return true
+	}
+
+	for _, method := range s.AMR {
+		if includes(r.AMRValues, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasAttribute tells whether the attribute, or any of its values if a list, is one of the values.
+func hasAttribute(attribute interface{}, values []string) bool {
+	switch v := attribute.(type) {
+	case string:
+		return includes(values, v)
+	case []string:
+		for _, s := range v {
+			if includes(values, s) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if s, ok := e.(string); ok && includes(values, s) {
+				return true
+			}
+		}
+	case bool, float64, int:
+		return includes(values, fmt.Sprint(v))
+	}
+
+	return false
+}
+
+func includes(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,164 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package authz // nolint:testpackage // the clock of the rules is internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("loads the rules of a YAML file", func(t *testing.T) {
+		rules, err := Load(writeFile(t, "rules:\n"+
+			"  - prefix: /admin/\n    attributes:\n      groups: [wallet-admins]\n"+
+			"  - prefix: /oidc/wallet/export\n    step-up:\n      amr-values: [mfa]\n      max-age: 5m\n"))
+		require.NoError(t, err)
+		require.Len(t, rules.Rules, 2)
+		require.Equal(t, []string{"wallet-admins"}, rules.Rules[0].Attributes["groups"])
+		require.Equal(t, 5*time.Minute, rules.Rules[1].StepUp.MaxAge)
+	})
+
+	t.Run("loads the rules of a JSON file", func(t *testing.T) {
+		rules, err := Load(writeFile(t, `{"rules": [{"prefix": "/", "authenticated": true}]}`))
+		require.NoError(t, err)
+		require.True(t, rules.Rules[0].Authenticated)
+	})
+
+	t.Run("error if a rule is invalid", func(t *testing.T) {
+		for _, rule := range []string{
+			"{prefix: admin, authenticated: true}",
+			"{prefix: /admin, methods: [delete], authenticated: true}",
+			"{prefix: /admin}",
+			"{prefix: /admin, step-up: {max-age: -1s}}",
+			"{prefix: /admin, unknown: true}",
+		} {
+			_, err := Load(writeFile(t, "rules:\n  - "+rule+"\n"))
+			require.Error(t, err, rule)
+		}
+	})
+
+	t.Run("error if the file is missing", func(t *testing.T) {
+		_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read authorization policy file")
+	})
+}
+
+func TestRules_Authorize(t *testing.T) {
+	now := time.Now()
+
+	rules := &Rules{
+		Rules: []*Rule{
+			{Prefix: "/admin/", Attributes: map[string][]string{"groups": {"wallet-admins"}}},
+			{Prefix: "/oidc/wallet", Methods: []string{http.MethodGet}, Authenticated: true},
+			{
+				Prefix: "/oidc/wallet/export",
+				StepUp: &StepUpRule{ACRValues: []string{"loa3"}, AMRValues: []string{"mfa"}, MaxAge: 5 * time.Minute},
+			},
+		},
+		now: func() time.Time { return now },
+	}
+
+	authorize := func(method, path string, s *Subject) *Decision {
+		decision, err := rules.Authorize(context.Background(), &Input{Method: method, Path: path, Subject: s})
+		require.NoError(t, err)
+
+		return decision
+	}
+
+	admin := &Subject{Sub: "alice", Attributes: map[string]interface{}{"groups": []interface{}{"users", "wallet-admins"}}}
+	user := &Subject{Sub: "bob", Attributes: map[string]interface{}{"groups": "users"}}
+	stepped := &Subject{Sub: "bob", ACR: "loa3", AMR: []string{"pwd", "mfa"}, AuthTime: now.Add(-time.Minute).Unix()}
+
+	t.Run("allows the users meeting the rules", func(t *testing.T) {
+		require.Equal(t, Allow, authorize(http.MethodGet, "/admin/users", admin).Effect)
+		require.Equal(t, Allow, authorize(http.MethodHead, "/oidc/wallet/profile", user).Effect)
+		require.Equal(t, Allow, authorize(http.MethodPost, "/oidc/wallet/export", stepped).Effect)
+	})
+
+	t.Run("allows the requests matching no rule", func(t *testing.T) {
+		require.Equal(t, Allow, authorize(http.MethodGet, "/healthcheck", nil).Effect)
+		require.Equal(t, Allow, authorize(http.MethodPost, "/oidc/wallet/profile", nil).Effect)
+	})
+
+	t.Run("denies the users not meeting the rules", func(t *testing.T) {
+		decision := authorize(http.MethodGet, "/admin/users", user)
+		require.Equal(t, Deny, decision.Effect)
+		require.Contains(t, decision.Reason, "groups")
+
+		require.Equal(t, Deny, authorize(http.MethodGet, "/admin/users", nil).Effect)
+		require.Equal(t, Deny, authorize(http.MethodGet, "/oidc/wallet/profile", nil).Effect)
+	})
+
+	t.Run("requires the users to step up", func(t *testing.T) {
+		for _, s := range []*Subject{
+			user,
+			{Sub: "bob", ACR: "loa3", AMR: []string{"mfa"}, AuthTime: now.Add(-time.Hour).Unix()},
+			{Sub: "bob", ACR: "loa2", AMR: []string{"mfa"}, AuthTime: now.Unix()},
+			{Sub: "bob", ACR: "loa3", AMR: []string{"pwd"}, AuthTime: now.Unix()},
+		} {
+			decision := authorize(http.MethodPost, "/oidc/wallet/export", s)
+			require.Equal(t, StepUp, decision.Effect)
+			require.Equal(t, []string{"loa3"}, decision.ACRValues)
+		}
+	})
+
+	t.Run("denies rather than requiring a step-up the users who cannot meet the other rules", func(t *testing.T) {
+		strict := &Rules{Rules: []*Rule{
+			{Prefix: "/oidc/wallet/export", StepUp: &StepUpRule{}},
+			{Prefix: "/oidc/wallet/export", Attributes: map[string][]string{"verified": {"true"}}},
+		}}
+
+		decision, err := strict.Authorize(context.Background(),
+			&Input{Method: http.MethodPost, Path: "/oidc/wallet/export", Subject: user})
+		require.NoError(t, err)
+		require.Equal(t, Deny, decision.Effect)
+
+		decision, err = strict.Authorize(context.Background(), &Input{
+			Method:  http.MethodPost,
+			Path:    "/oidc/wallet/export",
+			Subject: &Subject{Sub: "bob", Attributes: map[string]interface{}{"verified": true}},
+		})
+		require.NoError(t, err)
+		require.Equal(t, StepUp, decision.Effect)
+	})
+}
+
+func TestAll(t *testing.T) {
+	allowAll := &Rules{}
+	denyAll := &Rules{Rules: []*Rule{{Prefix: "/", Authenticated: true}}}
+	input := &Input{Method: http.MethodGet, Path: "/oidc/wallet"}
+
+	require.Equal(t, allowAll, All(allowAll))
+
+	decision, err := All(allowAll, allowAll).Authorize(context.Background(), input)
+	require.NoError(t, err)
+	require.Equal(t, Allow, decision.Effect)
+
+	decision, err = All(allowAll, denyAll).Authorize(context.Background(), input)
+	require.NoError(t, err)
+	require.Equal(t, Deny, decision.Effect)
+
+	_, err = All(allowAll, NewOPA("http://opa.invalid", &mockHTTPClient{err: errTest})).
+		Authorize(context.Background(), input)
+	require.Error(t, err)
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+	return file
+}
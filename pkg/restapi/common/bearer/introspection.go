@@ -0,0 +1,127 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package bearer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// IntrospectionConfig configures a TokenIntrospector.
+type IntrospectionConfig struct {
+	// Endpoint is the token introspection endpoint of the OP.
+	Endpoint string
+	// ClientID and ClientSecret authenticate the agent to the introspection endpoint.
+	ClientID     string
+	ClientSecret string
+	HTTPClient   *http.Client
+}
+
+// TokenIntrospector validates opaque access tokens with the introspection endpoint of the OP:
+// https://tools.ietf.org/html/rfc7662.
+type TokenIntrospector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	secretMutex  sync.RWMutex
+	httpClient   *http.Client
+}
+
+type introspectionResponse struct {
+	Active bool   `json:"active"`
+	Sub    string `json:"sub"`
+}
+
+// NewTokenIntrospector returns a new TokenIntrospector.
+func NewTokenIntrospector(config *IntrospectionConfig) *TokenIntrospector {
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &TokenIntrospector{
+		endpoint:     config.Endpoint,
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		httpClient:   httpClient,
+	}
+}
+
+// SetClientSecret replaces the client secret, e.g. once it is rotated at the OP.
+func (i *TokenIntrospector) SetClientSecret(secret string) {
+	i.secretMutex.Lock()
+	defer i.secretMutex.Unlock()
+
+	i.clientSecret = secret
+}
+
+func (i *TokenIntrospector) secret() string {
+	i.secretMutex.RLock()
+	defer i.secretMutex.RUnlock()
+
+	return i.clientSecret
+}
+
+// Introspect asks the OP whether the token is active and returns the sub of its user.
+func (i *TokenIntrospector) Introspect(ctx context.Context, token string) (string, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create introspection request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client credentials are form-encoded before being used as basic auth:
+	// https://tools.ietf.org/html/rfc6749#section-2.3.1
+	req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.secret()))
+
+	resp, err := i.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to introspect token: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Errorf("failed to close introspection response body: %s", errClose)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read introspection response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("introspection endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	introspection := &introspectionResponse{}
+
+	err = json.Unmarshal(body, introspection)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal introspection response: %w", err)
+	}
+
+	if !introspection.Active {
+		return "", errors.New("token is not active")
+	}
+
+	if introspection.Sub == "" {
+		return "", errors.New("token has no sub")
+	}
+
+	return introspection.Sub, nil
+}
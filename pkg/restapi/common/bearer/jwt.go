@@ -0,0 +1,43 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package bearer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/coreos/go-oidc"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+)
+
+// JWTValidator validates access tokens issued as JWTs locally, with the signing keys of the OP.
+type JWTValidator struct {
+	verifier oidc2.Verifier
+}
+
+// NewJWTValidator returns a JWTValidator of the tokens issued by the provider for the audience. The tokens are
+// always rejected if the audience is empty, rather than those the provider issued to any client accepted.
+func NewJWTValidator(provider oidc2.Provider, audience string) *JWTValidator {
+	return &JWTValidator{
+		verifier: provider.Verifier(&oidc.Config{ClientID: audience}),
+	}
+}
+
+// Introspect verifies the signature, issuer, audience and expiry of the token and returns its sub.
+func (v *JWTValidator) Introspect(ctx context.Context, token string) (string, error) {
+	jwt, err := v.verifier.Verify(ctx, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify token: %w", err)
+	}
+
+	if jwt.Subject == "" {
+		return "", errors.New("token has no sub")
+	}
+
+	return jwt.Subject, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package bearer
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	authorizationHeader = "Authorization"
+	bearerScheme        = "bearer "
+	// the cookie in which the handlers find the sub of a logged in user.
+	userSubCookieName = "user_sub"
+)
+
+var logger = log.New("edge-agent/bearer")
+
+// Introspector validates an OAuth2 access token and returns the sub of the user it was issued to.
+type Introspector interface {
+	Introspect(ctx context.Context, token string) (string, error)
+}
+
+// Middleware lets non-browser clients call the API with an OAuth2 access token in place of the session cookie.
+// The sub of the user of a valid "Authorization: Bearer" token is served to the handlers like the user sub
+// cookie of a browser session. Requests without a bearer token are passed on as is.
+func Middleware(introspector Introspector) common.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, found := bearerToken(r)
+			if !found {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			sub, err := introspector.Introspect(r.Context(), token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				common.WriteErrorResponsef(w, logger, http.StatusUnauthorized, "invalid bearer token: %s", err.Error())
+
+				return
+			}
+
+			jar := cookie.NewRequestJar(map[interface{}]interface{}{userSubCookieName: sub})
+
+			next.ServeHTTP(w, r.WithContext(cookie.WithJar(r.Context(), jar)))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	value := r.Header.Get(authorizationHeader)
+
+	// the authentication scheme is case-insensitive: https://tools.ietf.org/html/rfc7235#section-2.1
+	if len(value) <= len(bearerScheme) || !strings.EqualFold(value[:len(bearerScheme)], bearerScheme) {
+		return "", false
+	}
+
+	return strings.TrimSpace(value[len(bearerScheme):]), true
+}
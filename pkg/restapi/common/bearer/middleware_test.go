@@ -0,0 +1,254 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package bearer_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreos/go-oidc"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/bearer"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"golang.org/x/oauth2"
+)
+
+func TestMiddleware(t *testing.T) {
+	cookies := cookie.NewStore(key(t), key(t))
+
+	// the handler reads the user sub like the REST handlers do
+	handler := bearer.Middleware(&mockIntrospector{subs: map[string]string{"token": "user"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			jar, err := cookies.Open(r)
+			require.NoError(t, err)
+
+			sub, found := jar.Get("user_sub")
+			if !found {
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			require.NoError(t, jar.Save(r, w))
+
+			_, err = w.Write([]byte(sub.(string)))
+			require.NoError(t, err)
+		}))
+
+	t.Run("serves the user of a valid token like a logged in user", func(t *testing.T) {
+		for _, scheme := range []string{"Bearer", "bearer"} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", scheme+" token")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, "user", w.Body.String())
+			require.Empty(t, w.Header().Get("Set-Cookie"))
+		}
+	})
+
+	t.Run("passes on the requests without bearer token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("error unauthorized if the token is invalid", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer invalid")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+		require.Contains(t, w.Body.String(), "invalid bearer token: unknown token")
+	})
+}
+
+func TestTokenIntrospector(t *testing.T) {
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "access_token", r.PostForm.Get("token_type_hint"))
+
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "agent" || secret != "s%3Acret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		response := map[string]interface{}{"active": false}
+
+		switch r.PostForm.Get("token") {
+		case "active":
+			response = map[string]interface{}{"active": true, "sub": "user"}
+		case "anonymous":
+			response = map[string]interface{}{"active": true}
+		case "garbled":
+			_, err := w.Write([]byte("{"))
+			require.NoError(t, err)
+
+			return
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+	defer op.Close()
+
+	introspector := bearer.NewTokenIntrospector(&bearer.IntrospectionConfig{
+		Endpoint:     op.URL,
+		ClientID:     "agent",
+		ClientSecret: "s:cret",
+	})
+
+	t.Run("returns the sub of an active token", func(t *testing.T) {
+		sub, err := introspector.Introspect(context.Background(), "active")
+		require.NoError(t, err)
+		require.Equal(t, "user", sub)
+	})
+
+	t.Run("error if the token is not active", func(t *testing.T) {
+		_, err := introspector.Introspect(context.Background(), "expired")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "token is not active")
+	})
+
+	t.Run("error if the token has no sub", func(t *testing.T) {
+		_, err := introspector.Introspect(context.Background(), "anonymous")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "token has no sub")
+	})
+
+	t.Run("error if the response cannot be parsed", func(t *testing.T) {
+		_, err := introspector.Introspect(context.Background(), "garbled")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal introspection response")
+	})
+
+	t.Run("error if the agent is not authorized", func(t *testing.T) {
+		_, err := bearer.NewTokenIntrospector(&bearer.IntrospectionConfig{Endpoint: op.URL, ClientID: "other"}).
+			Introspect(context.Background(), "active")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "introspection endpoint returned status 401")
+	})
+
+	t.Run("authenticates with the rotated client secret", func(t *testing.T) {
+		rotated := bearer.NewTokenIntrospector(&bearer.IntrospectionConfig{
+			Endpoint:     op.URL,
+			ClientID:     "agent",
+			ClientSecret: "previous",
+		})
+		rotated.SetClientSecret("s:cret")
+
+		sub, err := rotated.Introspect(context.Background(), "active")
+		require.NoError(t, err)
+		require.Equal(t, "user", sub)
+	})
+
+	t.Run("error if the endpoint cannot be reached", func(t *testing.T) {
+		_, err := bearer.NewTokenIntrospector(&bearer.IntrospectionConfig{Endpoint: "http://localhost:0"}).
+			Introspect(context.Background(), "active")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to introspect token")
+	})
+}
+
+func TestJWTValidator(t *testing.T) {
+	t.Run("returns the sub of a valid token issued for the audience", func(t *testing.T) {
+		provider := &mockProvider{token: &oidc.IDToken{Subject: "user"}}
+
+		sub, err := bearer.NewJWTValidator(provider, "wallet").Introspect(context.Background(), "jwt")
+		require.NoError(t, err)
+		require.Equal(t, "user", sub)
+		require.False(t, provider.config.SkipClientIDCheck)
+		require.Equal(t, "wallet", provider.config.ClientID)
+	})
+
+	t.Run("checks the audience even if empty", func(t *testing.T) {
+		provider := &mockProvider{token: &oidc.IDToken{Subject: "user"}}
+
+		bearer.NewJWTValidator(provider, "")
+		require.False(t, provider.config.SkipClientIDCheck)
+	})
+
+	t.Run("error if the token cannot be verified", func(t *testing.T) {
+		_, err := bearer.NewJWTValidator(&mockProvider{err: errors.New("expired")}, "wallet").
+			Introspect(context.Background(), "jwt")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to verify token: expired")
+	})
+
+	t.Run("error if the token has no sub", func(t *testing.T) {
+		_, err := bearer.NewJWTValidator(&mockProvider{token: &oidc.IDToken{}}, "wallet").
+			Introspect(context.Background(), "jwt")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "token has no sub")
+	})
+}
+
+type mockIntrospector struct {
+	subs map[string]string
+}
+
+func (m *mockIntrospector) Introspect(_ context.Context, token string) (string, error) {
+	sub, found := m.subs[token]
+	if !found {
+		return "", errors.New("unknown token")
+	}
+
+	return sub, nil
+}
+
+type mockProvider struct {
+	config *oidc.Config
+	token  *oidc.IDToken
+	err    error
+}
+
+func (m *mockProvider) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{}
+}
+
+func (m *mockProvider) Verifier(config *oidc.Config) oidc2.Verifier {
+	m.config = config
+
+	return m
+}
+
+func (m *mockProvider) Verify(context.Context, string) (*oidc.IDToken, error) {
+	return m.token, m.err
+}
+
+func (m *mockProvider) UserInfo(context.Context, oauth2.TokenSource) (*oidc.UserInfo, error) {
+	return nil, errors.New("not implemented")
+}
+
+func key(t *testing.T) []byte {
+	t.Helper()
+
+	k := make([]byte, 32)
+
+	_, err := rand.Read(k)
+	require.NoError(t, err)
+
+	return k
+}
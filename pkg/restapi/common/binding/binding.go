@@ -0,0 +1,280 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package binding binds the sessions of the users to a fingerprint of their clients, so that stolen session
+// cookies cannot be replayed from another client.
+package binding
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/netpolicy"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// TopicSessionMismatch is published when a session is used by a client other than the one it is bound to.
+const TopicSessionMismatch = "session.mismatch"
+
+const (
+	// the cookie holding the fingerprint of the client of the session.
+	fingerprintCookieName = "session_fingerprint"
+	ipv4Bits              = 32
+	ipv6Bits              = 128
+)
+
+// nolint:gochecknoglobals // the cookies of the logged in users and of the users consenting to their login
+var sessionCookieNames = []string{"user_sub", "consent_sub"}
+
+var logger = log.New("edge-agent/binding")
+
+// ErrNoAttribute is returned by New for a Config binding the sessions to no attribute of the clients.
+var ErrNoAttribute = errors.New("the sessions must be bound to at least one attribute of the clients")
+
+// Config selects the attributes of the clients the sessions are bound to.
+type Config struct {
+	// UserAgent binds the sessions to the User-Agent header of the clients.
+	UserAgent bool
+	// IPv4PrefixLength binds the sessions to the network of the clients with an IPv4 address, e.g. 24, rather
+	// than to their address, which changes as often as the clients roam. The addresses are not bound if zero.
+	IPv4PrefixLength int
+	// IPv6PrefixLength is the IPv4PrefixLength of the clients with an IPv6 address, e.g. 64.
+	IPv6PrefixLength int
+	// ClientCertificate binds the sessions to the TLS client certificate of the clients, for deployments
+	// requiring mutual TLS. The clients without a certificate are bound to having none.
+	ClientCertificate bool
+	// TrustForwardedFor takes the client IP address from the X-Forwarded-For header added by a reverse proxy.
+	TrustForwardedFor bool
+	// ReportOnly keeps the sessions used by other clients, which are only reported. Enforcing the binding ends
+	// the sessions, so that the users authenticate again.
+	ReportOnly bool
+	// Audit records the mismatches. They are only logged if nil.
+	Audit *audit.Store
+	// Events publishes the mismatches as TopicSessionMismatch events. No events are published if nil.
+	Events outbox.Dispatcher
+}
+
+// Binder binds the sessions to the clients.
+type Binder struct {
+	config *Config
+	ipv4   net.IPMask
+	ipv6   net.IPMask
+}
+
+// New returns a new Binder.
+func New(config *Config) (*Binder, error) {
+	if !config.UserAgent && config.IPv4PrefixLength == 0 && config.IPv6PrefixLength == 0 &&
+		!config.ClientCertificate {
+		return nil, ErrNoAttribute
+	}
+
+	if config.IPv4PrefixLength < 0 || config.IPv4PrefixLength > ipv4Bits {
+		return nil, fmt.Errorf("invalid IPv4 prefix length %d: must be between 0 and %d",
+			config.IPv4PrefixLength, ipv4Bits)
+	}
+
+	if config.IPv6PrefixLength < 0 || config.IPv6PrefixLength > ipv6Bits {
+		return nil, fmt.Errorf("invalid IPv6 prefix length %d: must be between 0 and %d",
+			config.IPv6PrefixLength, ipv6Bits)
+	}
+
+	return &Binder{
+		config: config,
+		ipv4:   net.CIDRMask(config.IPv4PrefixLength, ipv4Bits),
+		ipv6:   net.CIDRMask(config.IPv6PrefixLength, ipv6Bits),
+	}, nil
+}
+
+// Bind binds the session to the client of the request, once the user logged in. The session must be saved
+// afterwards. A nil Binder binds nothing.
+func (b *Binder) Bind(jar cookie.Jar, r *http.Request) {
+	if b == nil {
+		return
+	}
+
+	jar.Set(fingerprintCookieName, b.fingerprint(r))
+}
+
+type mismatch struct {
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent,omitempty"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Enforced  bool   `json:"enforced"`
+}
+
+// Middleware checks that the sessions are used by the clients they are bound to. The sessions used by other
+// clients are reported and, unless the binding is report-only, logged out ahead of the handlers. The sessions
+// that were not bound at login, like those of the users remembered since, are bound to their first client.
+func (b *Binder) Middleware(cookies *cookie.Jars) common.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			jar, err := cookies.Open(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			sub, loggedIn := sessionSub(jar)
+			if !loggedIn {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			fingerprint := b.fingerprint(r)
+
+			bound, found := jar.Get(fingerprintCookieName)
+			if boundFingerprint, ok := bound.(string); found && ok &&
+				subtle.ConstantTimeCompare([]byte(boundFingerprint), []byte(fingerprint)) == 1 {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			if found {
+				b.report(r, sub)
+
+				if b.config.ReportOnly {
+					next.ServeHTTP(w, r)
+
+					return
+				}
+
+				for _, name := range sessionCookieNames {
+					jar.Delete(name)
+				}
+
+				jar.Delete(fingerprintCookieName)
+			} else {
+				jar.Set(fingerprintCookieName, fingerprint)
+			}
+
+			err = jar.Save(r, w)
+			if err != nil {
+				logger.Errorf("failed to save the session binding: %s", err.Error())
+			}
+
+			next.ServeHTTP(w, r.WithContext(cookie.WithJar(r.Context(), jar)))
+		})
+	}
+}
+
+// fingerprint hashes the bound attributes of the client.
+func (b *Binder) fingerprint(r *http.Request) string {
+	h := sha256.New()
+
+	write := func(attribute, value string) {
+		// the lengths keep the attributes apart
+		_, _ = fmt.Fprintf(h, "%s:%d:%s;", attribute, len(value), value)
+	}
+
+	if b.config.UserAgent {
+		write("user-agent", r.UserAgent())
+	}
+
+	if b.config.IPv4PrefixLength > 0 || b.config.IPv6PrefixLength > 0 {
+		write("network", b.network(r))
+	}
+
+	if b.config.ClientCertificate {
+		certificate := ""
+
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			hash := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+			certificate = hex.EncodeToString(hash[:])
+		}
+
+		write("client-certificate", certificate)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// network returns the bound prefix of the IP address of the client, or the address if it cannot be parsed.
+func (b *Binder) network(r *http.Request) string {
+	address := netpolicy.ClientIP(r, b.config.TrustForwardedFor)
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return address
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		if b.config.IPv4PrefixLength == 0 {
+			return ""
+		}
+
+		return ipv4.Mask(b.ipv4).String()
+	}
+
+	if b.config.IPv6PrefixLength == 0 {
+		return ""
+	}
+
+	return ip.Mask(b.ipv6).String()
+}
+
+func (b *Binder) report(r *http.Request, sub string) {
+	m := &mismatch{
+		IP:        netpolicy.ClientIP(r, b.config.TrustForwardedFor),
+		UserAgent: r.UserAgent(),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Enforced:  !b.config.ReportOnly,
+	}
+
+	logger.Warnf("session of user %s used by another client from %s (enforced: %t)", sub, m.IP, m.Enforced)
+
+	if b.config.Audit != nil {
+		err := b.config.Audit.Record(audit.ActionSessionMismatch, sub, "", m)
+		if err != nil {
+			logger.Errorf("failed to audit session mismatch: %s", err.Error())
+		}
+	}
+
+	if b.config.Events == nil {
+		return
+	}
+
+	e, err := outbox.NewEvent(TopicSessionMismatch, sub, m)
+	if err != nil {
+		logger.Errorf("failed to create session mismatch event: %s", err.Error())
+
+		return
+	}
+
+	// the webhooks are not waited for
+	go func() {
+		dispatchErr := b.config.Events.Dispatch(e)
+		if dispatchErr != nil {
+			logger.Errorf("failed to publish session mismatch event: %s", dispatchErr.Error())
+		}
+	}()
+}
+
+// sessionSub returns the sub of the user logged in, or consenting to their login, with the session.
+func sessionSub(jar cookie.Jar) (string, bool) {
+	for _, name := range sessionCookieNames {
+		if v, found := jar.Get(name); found {
+			sub, _ := v.(string)
+
+			return sub, true
+		}
+	}
+
+	return "", false
+}
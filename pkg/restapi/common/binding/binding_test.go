@@ -0,0 +1,307 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package binding_test
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/binding"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+)
+
+// client is the fingerprint of a client.
+type client struct {
+	userAgent    string
+	address      string
+	forwardedFor string
+	certificate  []byte
+}
+
+// nolint:gochecknoglobals // test clients
+var (
+	browser = &client{userAgent: "Firefox/82.0", address: "192.0.2.10:1234"}
+	roaming = &client{userAgent: "Firefox/82.0", address: "192.0.2.200:4321"}
+	thief   = &client{userAgent: "curl/7.68.0", address: "198.51.100.7:1234"}
+)
+
+func TestBinder_Middleware(t *testing.T) {
+	cookies := cookie.NewStore(key(t), key(t))
+
+	t.Run("ends the sessions used by other clients", func(t *testing.T) {
+		auditLog, err := audit.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		events := &mockDispatcher{events: make(chan *outbox.Event, 1)}
+
+		binder := newBinder(t, &binding.Config{UserAgent: true, Audit: auditLog, Events: events})
+		handler := binder.Middleware(cookies)(userHandler(t, cookies))
+
+		session := login(t, cookies, binder, browser, "user")
+
+		w := serve(handler, browser, session)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "user", w.Body.String())
+
+		w = serve(handler, thief, session)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.NotNil(t, responseCookie(w, cookie.StoreName))
+
+		// the session of the thief was ended, not the one of the user
+		require.Equal(t, http.StatusOK, serve(handler, browser, session).Code)
+
+		entries, err := auditLog.List("user")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, audit.ActionSessionMismatch, entries[0].Action)
+
+		select {
+		case e := <-events.events:
+			require.Equal(t, binding.TopicSessionMismatch, e.Topic)
+			require.Equal(t, "user", e.Subject)
+
+			payload := map[string]interface{}{}
+			require.NoError(t, json.Unmarshal(e.Payload, &payload))
+			require.Equal(t, "198.51.100.7", payload["ip"])
+			require.Equal(t, "curl/7.68.0", payload["userAgent"])
+			require.Equal(t, true, payload["enforced"])
+		case <-time.After(time.Second):
+			require.Fail(t, "no session mismatch event")
+		}
+	})
+
+	t.Run("only reports the mismatches if report-only", func(t *testing.T) {
+		auditLog, err := audit.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		events := &mockDispatcher{events: make(chan *outbox.Event, 1), err: errors.New("test")}
+
+		binder := newBinder(t, &binding.Config{UserAgent: true, ReportOnly: true, Audit: auditLog, Events: events})
+		handler := binder.Middleware(cookies)(userHandler(t, cookies))
+
+		w := serve(handler, thief, login(t, cookies, binder, browser, "user"))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "user", w.Body.String())
+
+		entries, err := auditLog.List("user")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+
+		e := <-events.events
+		require.Contains(t, string(e.Payload), `"enforced":false`)
+	})
+
+	t.Run("binds the sessions to the network of the clients", func(t *testing.T) {
+		binder := newBinder(t, &binding.Config{IPv4PrefixLength: 24, IPv6PrefixLength: 64})
+		handler := binder.Middleware(cookies)(userHandler(t, cookies))
+
+		session := login(t, cookies, binder, browser, "user")
+		require.Equal(t, http.StatusOK, serve(handler, roaming, session).Code)
+		require.Equal(t, http.StatusForbidden, serve(handler, thief, session).Code)
+
+		session = login(t, cookies, binder, &client{address: "[2001:db8:0:1::1]:1234"}, "user")
+		require.Equal(t, http.StatusOK, serve(handler, &client{address: "[2001:db8:0:1::2]:1234"}, session).Code)
+		require.Equal(t, http.StatusForbidden, serve(handler, &client{address: "[2001:db8:0:2::1]:1234"}, session).Code)
+		require.Equal(t, http.StatusForbidden, serve(handler, browser, session).Code)
+	})
+
+	t.Run("binds the sessions to the addresses of a family only", func(t *testing.T) {
+		binder := newBinder(t, &binding.Config{IPv6PrefixLength: 64})
+		handler := binder.Middleware(cookies)(userHandler(t, cookies))
+
+		require.Equal(t, http.StatusOK, serve(handler, thief, login(t, cookies, binder, browser, "user")).Code)
+	})
+
+	t.Run("binds the sessions to the forwarded addresses of the clients", func(t *testing.T) {
+		binder := newBinder(t, &binding.Config{IPv4PrefixLength: 32, TrustForwardedFor: true})
+		handler := binder.Middleware(cookies)(userHandler(t, cookies))
+
+		proxied := func(address string) *client {
+			return &client{address: "10.0.0.1:1234", forwardedFor: address}
+		}
+
+		session := login(t, cookies, binder, proxied("192.0.2.10"), "user")
+		require.Equal(t, http.StatusOK, serve(handler, proxied("192.0.2.10"), session).Code)
+		require.Equal(t, http.StatusForbidden, serve(handler, proxied("192.0.2.11"), session).Code)
+	})
+
+	t.Run("binds the sessions to the client certificates", func(t *testing.T) {
+		binder := newBinder(t, &binding.Config{ClientCertificate: true})
+		handler := binder.Middleware(cookies)(userHandler(t, cookies))
+
+		alice := &client{certificate: []byte("alice")}
+
+		session := login(t, cookies, binder, alice, "user")
+		require.Equal(t, http.StatusOK, serve(handler, alice, session).Code)
+		require.Equal(t, http.StatusForbidden, serve(handler, &client{certificate: []byte("bob")}, session).Code)
+		require.Equal(t, http.StatusForbidden, serve(handler, browser, session).Code)
+	})
+
+	t.Run("binds the sessions to their first client if not bound at login", func(t *testing.T) {
+		binder := newBinder(t, &binding.Config{UserAgent: true})
+		handler := binder.Middleware(cookies)(userHandler(t, cookies))
+
+		w := serve(handler, browser, login(t, cookies, nil, browser, "user"))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		session := responseCookie(w, cookie.StoreName)
+		require.NotNil(t, session)
+		require.Equal(t, http.StatusOK, serve(handler, browser, session).Code)
+		require.Equal(t, http.StatusForbidden, serve(handler, thief, session).Code)
+	})
+
+	t.Run("passes the requests without a session as is", func(t *testing.T) {
+		binder := newBinder(t, &binding.Config{UserAgent: true})
+		handler := binder.Middleware(cookies)(userHandler(t, cookies))
+
+		w := serve(handler, browser)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Empty(t, w.Header().Get("Set-Cookie"))
+
+		stale := login(t, cookie.NewStore(key(t), key(t)), binder, browser, "user")
+		require.Equal(t, http.StatusBadRequest, serve(handler, browser, stale).Code)
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("error if no attribute is bound", func(t *testing.T) {
+		_, err := binding.New(&binding.Config{TrustForwardedFor: true})
+		require.True(t, errors.Is(err, binding.ErrNoAttribute))
+	})
+
+	t.Run("error if a prefix length is invalid", func(t *testing.T) {
+		_, err := binding.New(&binding.Config{IPv4PrefixLength: 33})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid IPv4 prefix length 33")
+
+		_, err = binding.New(&binding.Config{IPv6PrefixLength: -1})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid IPv6 prefix length -1")
+	})
+}
+
+type mockDispatcher struct {
+	events chan *outbox.Event
+	err    error
+}
+
+func (m *mockDispatcher) Dispatch(e *outbox.Event) error {
+	m.events <- e
+
+	return m.err
+}
+
+func newBinder(t *testing.T, config *binding.Config) *binding.Binder {
+	t.Helper()
+
+	b, err := binding.New(config)
+	require.NoError(t, err)
+
+	return b
+}
+
+// userHandler reads the user sub like the REST handlers do.
+func userHandler(t *testing.T, cookies cookie.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jar, err := cookies.Open(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		sub, found := jar.Get("user_sub")
+		if !found {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, err = w.Write([]byte(sub.(string)))
+		require.NoError(t, err)
+	})
+}
+
+// login returns the session cookie of the user logged in with the client, bound by the binder if not nil.
+func login(t *testing.T, cookies *cookie.Jars, binder *binding.Binder, c *client, sub string) *http.Cookie {
+	t.Helper()
+
+	r := c.request()
+
+	jar := cookies.Reset()
+	jar.Set("user_sub", sub)
+	binder.Bind(jar, r)
+
+	w := httptest.NewRecorder()
+	require.NoError(t, jar.Save(r, w))
+
+	return responseCookie(w, cookie.StoreName)
+}
+
+func serve(handler http.Handler, c *client, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := c.request()
+
+	for _, ck := range cookies {
+		r.AddCookie(ck)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func (c *client) request() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("User-Agent", c.userAgent)
+
+	if c.address != "" {
+		r.RemoteAddr = c.address
+	}
+
+	if c.forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", c.forwardedFor)
+	}
+
+	if c.certificate != nil {
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: c.certificate}}}
+	}
+
+	return r
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() { // nolint:bodyclose // recorded response
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func key(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return key
+}
@@ -0,0 +1,54 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package claims
+
+// Filter selects and renames the claims of the OIDC provider exposed to the wallet UI, so that internal claims
+// such as employee IDs or group DNs do not reach the browser.
+type Filter struct {
+	// Allow lists the only claims exposed. All claims are exposed if empty.
+	Allow []string
+	// Deny lists the claims never exposed, even if allowed.
+	Deny []string
+	// Rename maps the names of the exposed claims to the names the wallet UI sees them under.
+	Rename map[string]string
+}
+
+// Apply returns the claims exposed by the filter. The claims are returned unchanged if the filter is nil.
+// Allow and Deny match the claim names of the provider, before they are renamed.
+func (f *Filter) Apply(claims map[string]interface{}) map[string]interface{} {
+	if f == nil {
+		return claims
+	}
+
+	allowed := toSet(f.Allow)
+	denied := toSet(f.Deny)
+	exposed := make(map[string]interface{}, len(claims))
+
+	for name, value := range claims {
+		if len(allowed) > 0 && !allowed[name] || denied[name] {
+			continue
+		}
+
+		if renamed, ok := f.Rename[name]; ok {
+			name = renamed
+		}
+
+		exposed[name] = value
+	}
+
+	return exposed
+}
+
+func toSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		set[name] = true
+	}
+
+	return set
+}
@@ -0,0 +1,54 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package claims_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/claims"
+)
+
+func TestFilter_Apply(t *testing.T) {
+	raw := map[string]interface{}{
+		"sub":         "sub",
+		"name":        "John Doe",
+		"email":       "john@example.com",
+		"employee_id": "1234",
+		"groups":      []interface{}{"cn=admins,dc=example,dc=com"},
+	}
+
+	t.Run("exposes all claims if the filter is nil", func(t *testing.T) {
+		var f *claims.Filter
+		require.Equal(t, raw, f.Apply(raw))
+	})
+
+	t.Run("exposes the allowed claims only", func(t *testing.T) {
+		f := &claims.Filter{Allow: []string{"sub", "name", "missing"}}
+		require.Equal(t, map[string]interface{}{"sub": "sub", "name": "John Doe"}, f.Apply(raw))
+	})
+
+	t.Run("redacts the denied claims", func(t *testing.T) {
+		f := &claims.Filter{Allow: []string{"sub", "employee_id"}, Deny: []string{"employee_id", "groups"}}
+		require.Equal(t, map[string]interface{}{"sub": "sub"}, f.Apply(raw))
+
+		f = &claims.Filter{Deny: []string{"employee_id", "groups"}}
+		require.Equal(t, map[string]interface{}{
+			"sub":   "sub",
+			"name":  "John Doe",
+			"email": "john@example.com",
+		}, f.Apply(raw))
+	})
+
+	t.Run("renames the exposed claims", func(t *testing.T) {
+		f := &claims.Filter{
+			Allow:  []string{"email", "employee_id"},
+			Rename: map[string]string{"email": "mail", "groups": "roles"},
+		}
+		require.Equal(t, map[string]interface{}{"mail": "john@example.com", "employee_id": "1234"}, f.Apply(raw))
+	})
+}
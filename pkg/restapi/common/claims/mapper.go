@@ -0,0 +1,136 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package claims
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+// Mapper maps claims received from the OIDC provider onto the canonical user profile.
+type Mapper interface {
+	Map(claims map[string]interface{}, profile *user.User) error
+}
+
+// MapperFunc adapts a function into a Mapper.
+type MapperFunc func(claims map[string]interface{}, profile *user.User) error
+
+// Map the claims onto the profile.
+func (f MapperFunc) Map(claims map[string]interface{}, profile *user.User) error {
+	return f(claims, profile)
+}
+
+// Pipeline runs a sequence of Mappers. Later mappers see the changes made by earlier ones.
+type Pipeline struct {
+	mappers []Mapper
+}
+
+// NewPipeline returns a new Pipeline with the given mappers.
+func NewPipeline(mappers ...Mapper) *Pipeline {
+	return &Pipeline{mappers: mappers}
+}
+
+// Register appends a custom Mapper to the pipeline.
+func (p *Pipeline) Register(m Mapper) {
+	p.mappers = append(p.mappers, m)
+}
+
+// Map runs all mappers in order.
+func (p *Pipeline) Map(claims map[string]interface{}, profile *user.User) error {
+	for i, m := range p.mappers {
+		err := m.Map(claims, profile)
+		if err != nil {
+			return fmt.Errorf("claims mapper %d failed: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Standard maps the standard OIDC profile claims:
+// https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims.
+func Standard() Mapper {
+	return MapperFunc(func(claims map[string]interface{}, profile *user.User) error {
+		setString(claims, "name", &profile.Name)
+		setString(claims, "given_name", &profile.GivenName)
+		setString(claims, "family_name", &profile.FamilyName)
+		setString(claims, "email", &profile.Email)
+		setString(claims, "picture", &profile.Picture)
+
+		return nil
+	})
+}
+
+// Attributes copies custom claims into the profile attributes. The keys of 'mapping' are claim names
+// and the values are the attribute names they are stored under.
+func Attributes(mapping map[string]string) Mapper {
+	return MapperFunc(func(claims map[string]interface{}, profile *user.User) error {
+		for claim, attribute := range mapping {
+			v, found := claims[claim]
+			if !found {
+				continue
+			}
+
+			if profile.Attributes == nil {
+				profile.Attributes = make(map[string]interface{})
+			}
+
+			profile.Attributes[attribute] = v
+		}
+
+		return nil
+	})
+}
+
+// ParseAttributeMapping parses 'claim=attribute' pairs. A pair without '=' keeps the claim name.
+func ParseAttributeMapping(pairs []string) (map[string]string, error) {
+	mapping := make(map[string]string)
+
+	for _, pair := range pairs {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2) // nolint:gomnd // claim and attribute
+
+		claim := strings.TrimSpace(parts[0])
+		if claim == "" {
+			return nil, fmt.Errorf("invalid claim mapping '%s'", pair)
+		}
+
+		attribute := claim
+
+		if len(parts) == 2 { // nolint:gomnd // claim and attribute
+			attribute = strings.TrimSpace(parts[1])
+		}
+
+		if attribute == "" {
+			return nil, fmt.Errorf("invalid claim mapping '%s'", pair)
+		}
+
+		mapping[claim] = attribute
+	}
+
+	return mapping, nil
+}
+
+// FromToken extracts the raw claims from an id_token or userinfo response.
+func FromToken(t oidc.Claimer) (map[string]interface{}, error) {
+	claims := make(map[string]interface{})
+
+	err := t.Claims(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract claims: %w", err)
+	}
+
+	return claims, nil
+}
+
+func setString(claims map[string]interface{}, name string, field *string) {
+	if v, ok := claims[name].(string); ok && v != "" {
+		*field = v
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package claims_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/claims"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestPipeline_Map(t *testing.T) {
+	t.Run("maps standard and custom claims", func(t *testing.T) {
+		raw := map[string]interface{}{
+			"name":        "John Doe",
+			"email":       "john@example.com",
+			"picture":     "https://example.com/john.png",
+			"employee_id": "1234",
+			"groups":      []interface{}{"a", "b"},
+		}
+		p := claims.NewPipeline(claims.Standard(), claims.Attributes(map[string]string{
+			"employee_id": "employeeID",
+			"groups":      "groups",
+			"missing":     "missing",
+		}))
+
+		profile := &user.User{Sub: "sub"}
+		require.NoError(t, p.Map(raw, profile))
+		require.Equal(t, "John Doe", profile.Name)
+		require.Equal(t, "john@example.com", profile.Email)
+		require.Equal(t, "https://example.com/john.png", profile.Picture)
+		require.Equal(t, "1234", profile.Attributes["employeeID"])
+		require.Equal(t, []interface{}{"a", "b"}, profile.Attributes["groups"])
+		require.NotContains(t, profile.Attributes, "missing")
+	})
+
+	t.Run("runs registered mappers in order", func(t *testing.T) {
+		p := claims.NewPipeline(claims.Standard())
+		p.Register(claims.MapperFunc(func(_ map[string]interface{}, profile *user.User) error {
+			profile.Name = "override: " + profile.Name
+
+			return nil
+		}))
+
+		profile := &user.User{}
+		require.NoError(t, p.Map(map[string]interface{}{"name": "test"}, profile))
+		require.Equal(t, "override: test", profile.Name)
+	})
+
+	t.Run("error if a mapper fails", func(t *testing.T) {
+		expected := errors.New("test")
+		p := claims.NewPipeline(claims.MapperFunc(func(map[string]interface{}, *user.User) error {
+			return expected
+		}))
+
+		err := p.Map(nil, &user.User{})
+		require.True(t, errors.Is(err, expected))
+	})
+}
+
+func TestParseAttributeMapping(t *testing.T) {
+	t.Run("parses pairs", func(t *testing.T) {
+		mapping, err := claims.ParseAttributeMapping([]string{"employee_id=employeeID", " groups "})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"employee_id": "employeeID", "groups": "groups"}, mapping)
+	})
+
+	t.Run("error on empty claim", func(t *testing.T) {
+		_, err := claims.ParseAttributeMapping([]string{"=employeeID"})
+		require.Error(t, err)
+	})
+
+	t.Run("error on empty attribute", func(t *testing.T) {
+		_, err := claims.ParseAttributeMapping([]string{"employee_id="})
+		require.Error(t, err)
+	})
+}
+
+func TestFromToken(t *testing.T) {
+	t.Run("extracts claims", func(t *testing.T) {
+		raw, err := claims.FromToken(&oidc.MockClaimer{ClaimsFunc: func(i interface{}) error {
+			return json.Unmarshal([]byte(`{"sub":"123"}`), i)
+		}})
+		require.NoError(t, err)
+		require.Equal(t, "123", raw["sub"])
+	})
+
+	t.Run("error if cannot extract claims", func(t *testing.T) {
+		expected := errors.New("test")
+		_, err := claims.FromToken(&oidc.MockClaimer{ClaimsErr: expected})
+		require.True(t, errors.Is(err, expected))
+	})
+}
@@ -18,6 +18,10 @@ type Handler interface {
 	Path() string
 	Method() string
 	Handle() http.HandlerFunc
+	Spec() *OperationSpec
+	// Versions returns the versions of the API the handler serves, all of them if empty. The handle func reads the
+	// version of a request with APIVersion.
+	Versions() []int
 }
 
 type logger interface {
@@ -0,0 +1,86 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package didresolver
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+)
+
+// cache keeps the documents resolved last until they expire.
+type cache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]*list.Element
+	// the entries, from the most recently used.
+	lru *list.List
+}
+
+type entry struct {
+	did     string
+	doc     *did.Doc
+	expires time.Time
+}
+
+func newCache(size int, ttl time.Duration) *cache {
+	return &cache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *cache) get(didID string) (*did.Doc, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[didID]
+	if !ok {
+		return nil, false
+	}
+
+	if !c.now().Before(e.Value.(*entry).expires) {
+		c.remove(e)
+
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*entry).doc, true
+}
+
+func (c *cache) put(didID string, doc *did.Doc) {
+	if c.size < 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[didID]; ok {
+		c.remove(e)
+	}
+
+	c.entries[didID] = c.lru.PushFront(&entry{did: didID, doc: doc, expires: c.now().Add(c.ttl)})
+
+	if c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *cache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*entry).did)
+}
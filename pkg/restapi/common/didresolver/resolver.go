@@ -0,0 +1,146 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package didresolver resolves the DIDs of the users, issuers and verifiers with the driver of their method, e.g.
+// did:key or did:web, falling back to a universal resolver for the other methods, and caches the documents.
+package didresolver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+)
+
+const (
+	// DefaultCacheSize is the number of documents cached by default.
+	DefaultCacheSize = 100
+	// DefaultCacheTTL is how long the documents are cached by default.
+	DefaultCacheTTL = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidDID is returned for the strings which are not DIDs.
+	ErrInvalidDID = errors.New("invalid did")
+	// ErrMethodNotSupported is returned for the DIDs of a method no driver accepts.
+	ErrMethodNotSupported = errors.New("did method not supported")
+)
+
+// Driver resolves the DIDs of the methods it accepts. The VDRs of the framework are drivers.
+type Driver interface {
+	Accept(method string) bool
+	Read(did string, opts ...vdrapi.ResolveOpts) (*did.Doc, error)
+}
+
+// Config configures a Resolver.
+type Config struct {
+	// Drivers resolve the DIDs of their methods, the first accepting the method of a DID resolving it.
+	Drivers []Driver
+	// Fallback resolves the DIDs of the methods no driver accepts, e.g. with a universal resolver. Optional.
+	Fallback Driver
+	// CacheSize is the number of documents cached. Defaults to DefaultCacheSize, and a negative size disables the
+	// cache.
+	CacheSize int
+	// CacheTTL is how long the documents are cached. Defaults to DefaultCacheTTL.
+	CacheTTL time.Duration
+}
+
+// Resolver resolves DIDs with the driver of their method. The documents resolved without options are cached, the
+// least recently used being evicted once the cache is full.
+type Resolver struct {
+	mutex    sync.RWMutex
+	drivers  []Driver
+	fallback Driver
+	cache    *cache
+}
+
+// New returns a new Resolver.
+func New(config *Config) *Resolver {
+	size := config.CacheSize
+	if size == 0 {
+		size = DefaultCacheSize
+	}
+
+	ttl := config.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	return &Resolver{
+		drivers:  append([]Driver(nil), config.Drivers...),
+		fallback: config.Fallback,
+		cache:    newCache(size, ttl),
+	}
+}
+
+// Register adds a driver, which resolves the DIDs of the methods it accepts unless a driver registered earlier
+// accepts them too.
+func (r *Resolver) Register(driver Driver) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.drivers = append(r.drivers, driver)
+}
+
+// Resolve returns the document of the DID. The options, e.g. the version of the document, are passed to the
+// driver, bypassing the cache.
+func (r *Resolver) Resolve(didID string, opts ...vdrapi.ResolveOpts) (*did.Doc, error) {
+	if len(opts) == 0 {
+		if doc, ok := r.cache.get(didID); ok {
+			return doc, nil
+		}
+	}
+
+	method, _, err := parse(didID)
+	if err != nil {
+		return nil, err
+	}
+
+	driver := r.driver(method)
+	if driver == nil {
+		return nil, fmt.Errorf("%w: %s", ErrMethodNotSupported, method)
+	}
+
+	doc, err := driver.Read(didID, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", didID, err)
+	}
+
+	if len(opts) == 0 {
+		r.cache.put(didID, doc)
+	}
+
+	return doc, nil
+}
+
+func (r *Resolver) driver(method string) Driver {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, d := range r.drivers {
+		if d.Accept(method) {
+			return d
+		}
+	}
+
+	return r.fallback
+}
+
+// parse returns the method and the method-specific id of the DID. Unlike did.Parse, it accepts the percent-encoded
+// characters of the method-specific ids, e.g. the ports of the did:web DIDs.
+func parse(didID string) (string, string, error) {
+	parts := strings.SplitN(didID, ":", 3) // nolint:gomnd // did:method:id
+
+	if len(parts) != 3 || parts[0] != "did" || parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidDID, didID)
+	}
+
+	return parts[1], parts[2], nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package didresolver // nolint:testpackage // changing to different package requires exposing internal functions
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	vdrkey "github.com/hyperledger/aries-framework-go/pkg/vdr/key"
+	"github.com/stretchr/testify/require"
+)
+
+const keyDID = "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+func TestResolver_Resolve(t *testing.T) {
+	t.Run("resolves with the driver of the method", func(t *testing.T) {
+		example := &mockDriver{method: "example"}
+		r := New(&Config{Drivers: []Driver{vdrkey.New(), example}})
+
+		doc, err := r.Resolve(keyDID)
+		require.NoError(t, err)
+		require.Equal(t, keyDID, doc.ID)
+
+		doc, err = r.Resolve("did:example:123")
+		require.NoError(t, err)
+		require.Equal(t, "did:example:123", doc.ID)
+		require.Equal(t, 1, example.reads)
+	})
+
+	t.Run("resolves with the first driver registered accepting the method", func(t *testing.T) {
+		first, second := &mockDriver{method: "example"}, &mockDriver{method: "example"}
+
+		r := New(&Config{})
+		r.Register(first)
+		r.Register(second)
+
+		_, err := r.Resolve("did:example:123")
+		require.NoError(t, err)
+		require.Equal(t, 1, first.reads)
+		require.Zero(t, second.reads)
+	})
+
+	t.Run("falls back for the methods no driver accepts", func(t *testing.T) {
+		fallback := &mockDriver{}
+		r := New(&Config{Drivers: []Driver{&mockDriver{method: "example"}}, Fallback: fallback})
+
+		doc, err := r.Resolve("did:orb:123")
+		require.NoError(t, err)
+		require.Equal(t, "did:orb:123", doc.ID)
+		require.Equal(t, 1, fallback.reads)
+	})
+
+	t.Run("caches the documents until they expire", func(t *testing.T) {
+		example := &mockDriver{method: "example"}
+		r := New(&Config{Drivers: []Driver{example}, CacheTTL: time.Minute})
+
+		now := time.Now()
+		r.cache.now = func() time.Time { return now }
+
+		for i := 0; i < 2; i++ {
+			_, err := r.Resolve("did:example:123")
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 1, example.reads)
+
+		// the options bypass the cache
+		_, err := r.Resolve("did:example:123", vdrapi.WithVersionID("1"))
+		require.NoError(t, err)
+		require.Equal(t, 2, example.reads)
+
+		now = now.Add(time.Minute)
+
+		_, err = r.Resolve("did:example:123")
+		require.NoError(t, err)
+		require.Equal(t, 3, example.reads)
+	})
+
+	t.Run("evicts the least recently used documents", func(t *testing.T) {
+		example := &mockDriver{method: "example"}
+		r := New(&Config{Drivers: []Driver{example}, CacheSize: 2})
+
+		for _, id := range []string{"1", "2", "1", "3", "1", "2"} {
+			_, err := r.Resolve("did:example:" + id)
+			require.NoError(t, err)
+		}
+
+		// 2 was evicted by 3, then 3 by 2
+		require.Equal(t, 4, example.reads)
+		require.Equal(t, 2, r.cache.lru.Len())
+	})
+
+	t.Run("does not cache if the cache is disabled", func(t *testing.T) {
+		example := &mockDriver{method: "example"}
+		r := New(&Config{Drivers: []Driver{example}, CacheSize: -1})
+
+		for i := 0; i < 2; i++ {
+			_, err := r.Resolve("did:example:123")
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, 2, example.reads)
+	})
+
+	t.Run("error if the did is invalid", func(t *testing.T) {
+		_, err := New(&Config{}).Resolve("invalid")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid did")
+	})
+
+	t.Run("error if no driver accepts the method", func(t *testing.T) {
+		_, err := New(&Config{Drivers: []Driver{vdrkey.New()}}).Resolve("did:example:123")
+		require.True(t, errors.Is(err, ErrMethodNotSupported))
+		require.Contains(t, err.Error(), "did method not supported: example")
+	})
+
+	t.Run("error if the driver fails", func(t *testing.T) {
+		example := &mockDriver{method: "example", err: vdrapi.ErrNotFound}
+		r := New(&Config{Drivers: []Driver{example}})
+
+		for i := 0; i < 2; i++ {
+			_, err := r.Resolve("did:example:123")
+			require.True(t, errors.Is(err, vdrapi.ErrNotFound))
+			require.Contains(t, err.Error(), "failed to resolve did:example:123")
+		}
+
+		// the failures are not cached
+		require.Equal(t, 2, example.reads)
+	})
+}
+
+// mockDriver resolves the DIDs of its method, or of all methods if it has none, to empty documents.
+type mockDriver struct {
+	method string
+	err    error
+	reads  int
+}
+
+func (m *mockDriver) Accept(method string) bool {
+	return m.method == "" || m.method == method
+}
+
+func (m *mockDriver) Read(didID string, _ ...vdrapi.ResolveOpts) (*did.Doc, error) {
+	m.reads++
+
+	if m.err != nil {
+		return nil, fmt.Errorf("test: %w", m.err)
+	}
+
+	return &did.Doc{ID: didID}, nil
+}
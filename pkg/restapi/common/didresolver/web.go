@@ -0,0 +1,126 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package didresolver
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	webMethod = "web"
+	// the documents are small: a larger response is not a document.
+	maxDocumentSize = 1 << 20
+)
+
+var logger = log.New("edge-agent/didresolver")
+
+// HTTPClient sends the requests of the drivers.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// WebDriver resolves did:web DIDs by fetching their document from the web server of their domain.
+type WebDriver struct {
+	client HTTPClient
+}
+
+// NewWebDriver returns a new WebDriver fetching the documents over HTTPS.
+func NewWebDriver(client HTTPClient) *WebDriver {
+	return &WebDriver{client: client}
+}
+
+// Accept accepts the web method.
+func (w *WebDriver) Accept(method string) bool {
+	return method == webMethod
+}
+
+// Read fetches the document of the DID, e.g. https://example.com/.well-known/did.json for did:web:example.com and
+// https://example.com/users/alice/did.json for did:web:example.com:users:alice.
+func (w *WebDriver) Read(didID string, _ ...vdrapi.ResolveOpts) (*did.Doc, error) {
+	documentURL, err := webDocumentURL(didID)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, documentURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch did document: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Warnf("failed to close response body: %s", errClose.Error())
+		}
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", vdrapi.ErrNotFound, didID)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered with status %d", documentURL, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDocumentSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read did document: %w", err)
+	}
+
+	doc, err := did.ParseDocument(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse did document: %w", err)
+	}
+
+	// the web server must not answer with the document of another DID
+	if doc.ID != didID {
+		return nil, fmt.Errorf("did document of %s has id %s", didID, doc.ID)
+	}
+
+	return doc, nil
+}
+
+func webDocumentURL(didID string) (string, error) {
+	method, id, err := parse(didID)
+	if err != nil {
+		return "", err
+	}
+
+	if method != webMethod {
+		return "", fmt.Errorf("did method %s is not web", method)
+	}
+
+	segments := strings.Split(id, ":")
+
+	for i := range segments {
+		segments[i], err = url.PathUnescape(segments[i])
+		if err != nil || segments[i] == "" || segments[i] == "." || segments[i] == ".." ||
+			strings.ContainsAny(segments[i], "/?#@") {
+			return "", fmt.Errorf("invalid did:web %s", didID)
+		}
+	}
+
+	path := "/.well-known"
+	if len(segments) > 1 {
+		path = "/" + strings.Join(segments[1:], "/")
+	}
+
+	return "https://" + segments[0] + path + "/did.json", nil
+}
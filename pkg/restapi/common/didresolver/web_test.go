@@ -0,0 +1,135 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package didresolver // nolint:testpackage // changing to different package requires exposing internal functions
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebDriver(t *testing.T) {
+	t.Run("fetches the document from the domain of the did", func(t *testing.T) {
+		server, domain := newWebServer(t)
+		driver := NewWebDriver(server.Client())
+
+		require.True(t, driver.Accept("web"))
+		require.False(t, driver.Accept("key"))
+
+		for _, didID := range []string{"did:web:" + domain, "did:web:" + domain + ":users:alice"} {
+			doc, err := driver.Read(didID)
+			require.NoError(t, err)
+			require.Equal(t, didID, doc.ID)
+		}
+	})
+
+	t.Run("maps the did to the url of its document", func(t *testing.T) {
+		for didID, expected := range map[string]string{
+			"did:web:example.com":                  "https://example.com/.well-known/did.json",
+			"did:web:example.com%3A8443":           "https://example.com:8443/.well-known/did.json",
+			"did:web:example.com:users:alice":      "https://example.com/users/alice/did.json",
+			"did:web:example.com:users:alice%20bo": "https://example.com/users/alice bo/did.json",
+		} {
+			documentURL, err := webDocumentURL(didID)
+			require.NoError(t, err)
+			require.Equal(t, expected, documentURL)
+		}
+
+		for _, didID := range []string{
+			"did:key:123",
+			"did:web:example.com::alice",
+			"did:web:example.com:..:admin",
+			"did:web:example.com%2Fadmin",
+			"did:web:user%40example.com",
+			"did:web:example.com:%zz",
+			"web:example.com",
+		} {
+			_, err := webDocumentURL(didID)
+			require.Error(t, err, didID)
+		}
+	})
+
+	t.Run("error if the document is not found", func(t *testing.T) {
+		server, domain := newWebServer(t)
+
+		_, err := NewWebDriver(server.Client()).Read("did:web:" + domain + ":unknown")
+		require.True(t, errors.Is(err, vdrapi.ErrNotFound))
+	})
+
+	t.Run("error if the document is of another did", func(t *testing.T) {
+		server, domain := newWebServer(t)
+
+		_, err := NewWebDriver(server.Client()).Read("did:web:" + domain + ":other")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "has id did:web:example.com")
+	})
+
+	t.Run("error if the document is invalid", func(t *testing.T) {
+		server, domain := newWebServer(t)
+
+		_, err := NewWebDriver(server.Client()).Read("did:web:" + domain + ":invalid")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse did document")
+	})
+
+	t.Run("error if the web server fails", func(t *testing.T) {
+		server, domain := newWebServer(t)
+
+		_, err := NewWebDriver(server.Client()).Read("did:web:" + domain + ":error")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "answered with status 500")
+
+		_, err = NewWebDriver(http.DefaultClient).Read("did:web:" + domain)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch did document")
+	})
+}
+
+// newWebServer serves the documents of the did:web DIDs of its domain, which it returns.
+func newWebServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+
+	var domain string
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		didID := "did:web:" + domain
+
+		switch r.URL.Path {
+		case "/.well-known/did.json":
+		case "/users/alice/did.json":
+			didID += ":users:alice"
+		case "/other/did.json":
+			didID = "did:web:example.com"
+		case "/invalid/did.json":
+			_, _ = w.Write([]byte("{"))
+
+			return
+		case "/error/did.json":
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, `{"@context":["https://www.w3.org/ns/did/v1"],"id":"%s"}`, didID)
+	}))
+
+	t.Cleanup(server.Close)
+
+	domain = strings.Replace(strings.TrimPrefix(server.URL, "https://"), ":", "%3A", 1)
+
+	return server, domain
+}
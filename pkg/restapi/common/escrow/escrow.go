@@ -0,0 +1,290 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package escrow
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/sss"
+	"github.com/trustbloc/edge-core/pkg/sss/base"
+	"golang.org/x/sync/errgroup"
+)
+
+var logger = log.New("edge-agent/escrow")
+
+// ErrNotFound is returned by the custodians holding no share of the user.
+var ErrNotFound = errors.New("no share of the user in escrow")
+
+// ErrUnrecoverable is returned by Recover when fewer escrows than the threshold returned their share.
+var ErrUnrecoverable = errors.New("not enough shares in escrow to recover the secret")
+
+// Custodian keeps the shares of the users at an escrow service.
+type Custodian interface {
+	// Deposit the share of the user, replacing the share the escrow holds.
+	Deposit(ctx context.Context, userID string, share []byte) error
+	// Withdraw returns the share of the user. The error wraps ErrNotFound if the escrow holds none.
+	Withdraw(ctx context.Context, userID string) ([]byte, error)
+	// Delete the share of the user, if any.
+	Delete(ctx context.Context, userID string) error
+}
+
+// Escrow is an escrow service holding a share of the secrets of the users.
+type Escrow struct {
+	// ID identifies the escrow in the records of the users. It must not change while the escrow holds shares.
+	ID        string
+	Custodian Custodian
+	// Retired escrows receive no new shares. The secrets they hold shares of are re-issued to the other escrows,
+	// and their shares deleted.
+	Retired bool
+}
+
+// Config configures the escrow of the secrets of the users.
+type Config struct {
+	Escrows []*Escrow
+	// Threshold is the number of shares recovering a secret. Defaults to a majority of the escrows not retired.
+	// Each escrow holds a copy of the secret if 1.
+	Threshold int
+	// Splitter splits the secrets into shares. Defaults to the Shamir splitter of edge-core.
+	Splitter sss.SecretSplitter
+}
+
+// Record tells which escrows hold the shares of a secret, and how many of them recover it.
+type Record struct {
+	Escrows   []string `json:"escrows"`
+	Threshold int      `json:"threshold"`
+	// Digest is the base64 SHA-256 digest of the secret, which the recovered secrets are checked against.
+	Digest   string    `json:"digest"`
+	IssuedAt time.Time `json:"issuedAt"`
+}
+
+// Service splits the secrets of the users into shares held by the escrows, so that a secret is recovered from the
+// shares of Threshold escrows, and no fewer escrows learn anything about it.
+type Service struct {
+	escrows   map[string]*Escrow
+	active    []*Escrow
+	threshold int
+	splitter  sss.SecretSplitter
+}
+
+// New returns a new Service for the escrows of the config.
+func New(config *Config) (*Service, error) {
+	s := &Service{
+		escrows:   map[string]*Escrow{},
+		threshold: config.Threshold,
+		splitter:  config.Splitter,
+	}
+
+	for _, e := range config.Escrows {
+		if e.ID == "" {
+			return nil, errors.New("escrow without id")
+		}
+
+		if _, ok := s.escrows[e.ID]; ok {
+			return nil, fmt.Errorf("duplicate escrow id %s", e.ID)
+		}
+
+		s.escrows[e.ID] = e
+
+		if !e.Retired {
+			s.active = append(s.active, e)
+		}
+	}
+
+	if len(s.active) == 0 {
+		return nil, errors.New("no escrow receiving the shares")
+	}
+
+	if s.threshold == 0 {
+		s.threshold = len(s.active)/2 + 1
+	}
+
+	if s.threshold < 1 || s.threshold > len(s.active) {
+		return nil, fmt.Errorf("invalid threshold %d: must be between 1 and the %d escrows receiving the shares",
+			s.threshold, len(s.active))
+	}
+
+	if s.splitter == nil {
+		s.splitter = &base.Splitter{}
+	}
+
+	return s, nil
+}
+
+// Deposit splits the secret of the user into one share for each escrow not retired, and deposits the shares.
+func (s *Service) Deposit(ctx context.Context, userID string, secret []byte) (*Record, error) {
+	shares, err := s.split(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &Record{Threshold: s.threshold, Digest: digest(secret), IssuedAt: time.Now().UTC()}
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	for i, e := range s.active {
+		e, share := e, shares[i]
+
+		record.Escrows = append(record.Escrows, e.ID)
+
+		g.Go(func() error {
+			err := e.Custodian.Deposit(gctx, userID, share)
+			if err != nil {
+				return fmt.Errorf("failed to deposit share with escrow %s: %w", e.ID, err)
+			}
+
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (s *Service) split(secret []byte) ([][]byte, error) {
+	if s.threshold == 1 {
+		shares := make([][]byte, len(s.active))
+		for i := range shares {
+			shares[i] = secret
+		}
+
+		return shares, nil
+	}
+
+	shares, err := s.splitter.Split(secret, len(s.active), s.threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split secret: %w", err)
+	}
+
+	return shares, nil
+}
+
+// Recover withdraws the shares of the user's secret from the escrows of the record and combines them. The error
+// wraps ErrUnrecoverable if fewer escrows than the threshold of the record returned their share.
+func (s *Service) Recover(ctx context.Context, userID string, record *Record) ([]byte, error) {
+	var (
+		mutex  sync.Mutex
+		shares [][]byte
+		errs   []string
+		wg     sync.WaitGroup
+	)
+
+	for _, id := range record.Escrows {
+		e, ok := s.escrows[id]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("escrow %s: not configured", id))
+
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			share, err := e.Custodian.Withdraw(ctx, userID)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("escrow %s: %s", e.ID, err.Error()))
+
+				return
+			}
+
+			shares = append(shares, share)
+		}()
+	}
+
+	wg.Wait()
+
+	if len(shares) < record.Threshold || len(shares) == 0 {
+		return nil, fmt.Errorf("%w: %d of %d shares withdrawn: %v", ErrUnrecoverable, len(shares),
+			record.Threshold, errs)
+	}
+
+	secret := shares[0]
+
+	if record.Threshold > 1 {
+		var err error
+
+		secret, err = s.splitter.Combine(shares[:record.Threshold])
+		if err != nil {
+			return nil, fmt.Errorf("failed to combine shares: %w", err)
+		}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(digest(secret)), []byte(record.Digest)) != 1 {
+		return nil, fmt.Errorf("%w: the shares do not combine into the secret", ErrUnrecoverable)
+	}
+
+	return secret, nil
+}
+
+// Stale tells whether the shares of the record must be re-issued: an escrow holding a share is retired or no
+// longer configured, an escrow receives no share, or the threshold changed.
+func (s *Service) Stale(record *Record) bool {
+	if record.Threshold != s.threshold || len(record.Escrows) != len(s.active) {
+		return true
+	}
+
+	for i, id := range record.Escrows {
+		if s.active[i].ID != id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reissue splits the secret of the user anew for the escrows not retired, and deletes the shares of the record
+// held by the retired escrows. The shares of the record are unusable with the new ones.
+func (s *Service) Reissue(ctx context.Context, userID string, secret []byte, record *Record) (*Record, error) {
+	reissued, err := s.Deposit(ctx, userID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range record.Escrows {
+		e, ok := s.escrows[id]
+		if !ok {
+			logger.Warnf("share of user %s left with escrow %s, which is no longer configured", userID, id)
+
+			continue
+		}
+
+		if !e.Retired {
+			continue
+		}
+
+		err = e.Custodian.Delete(ctx, userID)
+		if err != nil {
+			// the share is unusable without the shares of the same split held by the other escrows
+			logger.Warnf("failed to delete share of user %s from retired escrow %s: %s", userID, id, err.Error())
+		}
+	}
+
+	return reissued, nil
+}
+
+func digest(secret []byte) string {
+	sum := sha256.Sum256(secret)
+
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,177 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package escrow_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/escrow"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("defaults to a majority of the escrows", func(t *testing.T) {
+		s, custodians := newService(t, 0, "a", "b", "c")
+
+		record, err := s.Deposit(context.Background(), "user", secret(t))
+		require.NoError(t, err)
+		require.Equal(t, 2, record.Threshold)
+		require.Equal(t, []string{"a", "b", "c"}, record.Escrows)
+
+		for _, c := range custodians {
+			require.Len(t, c.Shares, 1)
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		for name, config := range map[string]*escrow.Config{
+			"no id":     {Escrows: []*escrow.Escrow{{Custodian: escrow.NewMockCustodian()}}},
+			"duplicate": {Escrows: []*escrow.Escrow{{ID: "a"}, {ID: "a"}}},
+			"no escrow": {Escrows: []*escrow.Escrow{{ID: "a", Retired: true}}},
+			"threshold": {Escrows: []*escrow.Escrow{{ID: "a"}, {ID: "b"}}, Threshold: 3},
+		} {
+			_, err := escrow.New(config)
+			require.Error(t, err, name)
+		}
+	})
+}
+
+func TestService_Recover(t *testing.T) {
+	t.Run("recovers the secret from the threshold of the escrows", func(t *testing.T) {
+		s, custodians := newService(t, 2, "a", "b", "c")
+		sec := secret(t)
+
+		record, err := s.Deposit(context.Background(), "user", sec)
+		require.NoError(t, err)
+		require.NotEqual(t, sec, custodians[0].Shares["user"])
+
+		custodians[1].WithdrawErr = errors.New("unreachable")
+
+		recovered, err := s.Recover(context.Background(), "user", record)
+		require.NoError(t, err)
+		require.Equal(t, sec, recovered)
+	})
+
+	t.Run("copies the secret to each escrow with a threshold of 1", func(t *testing.T) {
+		s, custodians := newService(t, 1, "a", "b")
+		sec := secret(t)
+
+		record, err := s.Deposit(context.Background(), "user", sec)
+		require.NoError(t, err)
+		require.Equal(t, sec, custodians[1].Shares["user"])
+
+		delete(custodians[0].Shares, "user")
+
+		recovered, err := s.Recover(context.Background(), "user", record)
+		require.NoError(t, err)
+		require.Equal(t, sec, recovered)
+	})
+
+	t.Run("fails below the threshold", func(t *testing.T) {
+		s, custodians := newService(t, 2, "a", "b", "c")
+
+		record, err := s.Deposit(context.Background(), "user", secret(t))
+		require.NoError(t, err)
+
+		delete(custodians[0].Shares, "user")
+		custodians[2].WithdrawErr = errors.New("unreachable")
+
+		_, err = s.Recover(context.Background(), "user", record)
+		require.True(t, errors.Is(err, escrow.ErrUnrecoverable))
+		require.Contains(t, err.Error(), "escrow c: unreachable")
+	})
+
+	t.Run("fails with shares of another secret", func(t *testing.T) {
+		s, custodians := newService(t, 2, "a", "b")
+
+		record, err := s.Deposit(context.Background(), "user", secret(t))
+		require.NoError(t, err)
+
+		_, err = s.Deposit(context.Background(), "other", secret(t))
+		require.NoError(t, err)
+
+		custodians[1].Shares["user"] = custodians[1].Shares["other"]
+
+		_, err = s.Recover(context.Background(), "user", record)
+		require.True(t, errors.Is(err, escrow.ErrUnrecoverable))
+	})
+
+	t.Run("fails to deposit", func(t *testing.T) {
+		s, custodians := newService(t, 2, "a", "b")
+		custodians[1].DepositErr = errors.New("unreachable")
+
+		_, err := s.Deposit(context.Background(), "user", secret(t))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to deposit share with escrow b")
+	})
+}
+
+func TestService_Reissue(t *testing.T) {
+	a, b, c := escrow.NewMockCustodian(), escrow.NewMockCustodian(), escrow.NewMockCustodian()
+
+	s, err := escrow.New(&escrow.Config{Escrows: []*escrow.Escrow{
+		{ID: "a", Custodian: a}, {ID: "b", Custodian: b},
+	}})
+	require.NoError(t, err)
+
+	sec := secret(t)
+
+	record, err := s.Deposit(context.Background(), "user", sec)
+	require.NoError(t, err)
+	require.False(t, s.Stale(record))
+
+	// b is rotated out for c
+	s, err = escrow.New(&escrow.Config{Escrows: []*escrow.Escrow{
+		{ID: "a", Custodian: a}, {ID: "b", Custodian: b, Retired: true}, {ID: "c", Custodian: c},
+	}})
+	require.NoError(t, err)
+	require.True(t, s.Stale(record))
+
+	record, err = s.Reissue(context.Background(), "user", sec, record)
+	require.NoError(t, err)
+	require.False(t, s.Stale(record))
+	require.Equal(t, []string{"a", "c"}, record.Escrows)
+	require.Empty(t, b.Shares)
+
+	recovered, err := s.Recover(context.Background(), "user", record)
+	require.NoError(t, err)
+	require.Equal(t, sec, recovered)
+}
+
+func newService(t *testing.T, threshold int, ids ...string) (*escrow.Service, []*escrow.MockCustodian) {
+	t.Helper()
+
+	config := &escrow.Config{Threshold: threshold}
+
+	var custodians []*escrow.MockCustodian
+
+	for _, id := range ids {
+		c := escrow.NewMockCustodian()
+		custodians = append(custodians, c)
+		config.Escrows = append(config.Escrows, &escrow.Escrow{ID: id, Custodian: c})
+	}
+
+	s, err := escrow.New(config)
+	require.NoError(t, err)
+
+	return s, custodians
+}
+
+func secret(t *testing.T) []byte {
+	t.Helper()
+
+	b := make([]byte, 33)
+
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+
+	return b
+}
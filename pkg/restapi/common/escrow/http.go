@@ -0,0 +1,137 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package escrow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPClient sends HTTP requests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type shareDocument struct {
+	Share []byte `json:"share"`
+}
+
+// HTTPCustodian keeps the shares at an escrow service with a REST API, under {URL}/shares/{user ID}: PUT deposits
+// a share, GET withdraws it and DELETE deletes it. The agent authenticates with the client certificate of its HTTP
+// client, and with a bearer token if any.
+type HTTPCustodian struct {
+	url        string
+	token      string
+	httpClient HTTPClient
+}
+
+// NewHTTPCustodian returns a new HTTPCustodian for the escrow service at the URL.
+func NewHTTPCustodian(escrowURL, token string, httpClient HTTPClient) *HTTPCustodian {
+	return &HTTPCustodian{url: strings.TrimSuffix(escrowURL, "/"), token: token, httpClient: httpClient}
+}
+
+// Deposit the share of the user.
+func (c *HTTPCustodian) Deposit(ctx context.Context, userID string, share []byte) error {
+	body, err := json.Marshal(&shareDocument{Share: share})
+	if err != nil {
+		return fmt.Errorf("failed to marshal share: %w", err)
+	}
+
+	status, _, err := c.do(ctx, http.MethodPut, userID, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK && status != http.StatusCreated && status != http.StatusNoContent {
+		return fmt.Errorf("escrow answered with status %d", status)
+	}
+
+	return nil
+}
+
+// Withdraw returns the share of the user. The error wraps ErrNotFound if the escrow holds none.
+func (c *HTTPCustodian) Withdraw(ctx context.Context, userID string) ([]byte, error) {
+	status, body, err := c.do(ctx, http.MethodGet, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("escrow answered with status %d", status)
+	}
+
+	doc := &shareDocument{}
+
+	err = json.Unmarshal(body, doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal share: %w", err)
+	}
+
+	if len(doc.Share) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return doc.Share, nil
+}
+
+// Delete the share of the user, if any.
+func (c *HTTPCustodian) Delete(ctx context.Context, userID string) error {
+	status, _, err := c.do(ctx, http.MethodDelete, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK && status != http.StatusNoContent && status != http.StatusNotFound {
+		return fmt.Errorf("escrow answered with status %d", status)
+	}
+
+	return nil
+}
+
+func (c *HTTPCustodian) do(ctx context.Context, method, userID string, body io.Reader) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+"/shares/"+url.PathEscape(userID), body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create escrow request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send escrow request: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Warnf("failed to close response body: %s", errClose.Error())
+		}
+	}()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read escrow response: %w", err)
+	}
+
+	return resp.StatusCode, respBody, nil
+}
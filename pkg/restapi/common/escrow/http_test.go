@@ -0,0 +1,109 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package escrow_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/escrow"
+)
+
+func TestHTTPCustodian(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		shares = map[string][]byte{}
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			shares[r.URL.Path] = body
+
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			body, ok := shares[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			_, err := w.Write(body)
+			require.NoError(t, err)
+		case http.MethodDelete:
+			delete(shares, r.URL.Path)
+
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	c := escrow.NewHTTPCustodian(server.URL+"/", "token", http.DefaultClient)
+
+	t.Run("deposits, withdraws and deletes the shares", func(t *testing.T) {
+		require.NoError(t, c.Deposit(context.Background(), "user/1", []byte("share")))
+		require.Contains(t, shares, "/shares/user/1")
+
+		doc := struct {
+			Share []byte `json:"share"`
+		}{}
+		require.NoError(t, json.Unmarshal(shares["/shares/user/1"], &doc))
+		require.Equal(t, []byte("share"), doc.Share)
+
+		share, err := c.Withdraw(context.Background(), "user/1")
+		require.NoError(t, err)
+		require.Equal(t, []byte("share"), share)
+
+		require.NoError(t, c.Delete(context.Background(), "user/1"))
+		require.NoError(t, c.Delete(context.Background(), "user/1"))
+
+		_, err = c.Withdraw(context.Background(), "user/1")
+		require.True(t, errors.Is(err, escrow.ErrNotFound))
+	})
+
+	t.Run("fails without the token of the escrow", func(t *testing.T) {
+		c := escrow.NewHTTPCustodian(server.URL, "", http.DefaultClient)
+
+		err := c.Deposit(context.Background(), "user", []byte("share"))
+		require.EqualError(t, err, "escrow answered with status 401")
+
+		_, err = c.Withdraw(context.Background(), "user")
+		require.EqualError(t, err, "escrow answered with status 401")
+
+		err = c.Delete(context.Background(), "user")
+		require.EqualError(t, err, "escrow answered with status 401")
+	})
+
+	t.Run("fails to reach the escrow", func(t *testing.T) {
+		c := escrow.NewHTTPCustodian("http://[::1", "token", http.DefaultClient)
+
+		err := c.Deposit(context.Background(), "user", []byte("share"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create escrow request")
+	})
+}
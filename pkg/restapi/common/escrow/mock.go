@@ -0,0 +1,71 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package escrow
+
+import (
+	"context"
+	"sync"
+)
+
+// MockCustodian keeps the shares in memory, for tests.
+type MockCustodian struct {
+	Shares      map[string][]byte
+	DepositErr  error
+	WithdrawErr error
+	DeleteErr   error
+	mutex       sync.Mutex
+}
+
+// NewMockCustodian returns a new MockCustodian holding no share.
+func NewMockCustodian() *MockCustodian {
+	return &MockCustodian{Shares: map[string][]byte{}}
+}
+
+// Deposit the share of the user.
+func (m *MockCustodian) Deposit(_ context.Context, userID string, share []byte) error {
+	if m.DepositErr != nil {
+		return m.DepositErr
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Shares[userID] = share
+
+	return nil
+}
+
+// Withdraw returns the share of the user.
+func (m *MockCustodian) Withdraw(_ context.Context, userID string) ([]byte, error) {
+	if m.WithdrawErr != nil {
+		return nil, m.WithdrawErr
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	share, ok := m.Shares[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return share, nil
+}
+
+// Delete the share of the user.
+func (m *MockCustodian) Delete(_ context.Context, userID string) error {
+	if m.DeleteErr != nil {
+		return m.DeleteErr
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.Shares, userID)
+
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// RevalidateCacheControl is the Cache-Control of the reads of the data of a user: it may only be cached by the
+// browser, which revalidates it with its ETag on each use.
+const RevalidateCacheControl = "private, no-cache"
+
+// WriteConditionalResponse writes the JSON of the value with a strong ETag computed from it, or 304 Not Modified
+// without body if the request's If-None-Match matches the ETag, so that the clients polling the value only
+// download its changes.
+func WriteConditionalResponse(rw http.ResponseWriter, r *http.Request, l logger, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		WriteErrorResponsef(rw, l, http.StatusInternalServerError, "failed to marshal response: %s", err.Error())
+
+		return
+	}
+
+	// as written by WriteResponse
+	body = append(body, '\n')
+
+	etag := ETag(body)
+
+	rw.Header().Set("ETag", etag)
+	rw.Header().Set("Cache-Control", RevalidateCacheControl)
+
+	if NotModified(r, etag) {
+		rw.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	_, err = rw.Write(body)
+	if err != nil {
+		l.Errorf("Unable to send response, %s", err.Error())
+	}
+}
+
+// ETag returns the strong ETag of the representation.
+func ETag(representation []byte) string {
+	sum := sha256.Sum256(representation)
+
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+}
+
+// NotModified tells whether the If-None-Match header of the request matches the ETag, i.e. whether the client has
+// the representation already. The ETags are compared weakly, as per RFC 7232.
+func NotModified(r *http.Request, etag string) bool {
+	for _, header := range r.Header.Values("If-None-Match") {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-core/pkg/log/mocklogger"
+)
+
+func TestWriteConditionalResponse(t *testing.T) {
+	value := map[string]string{"theme": "dark"}
+
+	t.Run("writes the value with its etag", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		common.WriteConditionalResponse(w, httptest.NewRequest(http.MethodGet, "/", nil), &mocklogger.MockLogger{}, value)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"theme": "dark"}`, w.Body.String())
+		require.Equal(t, common.RevalidateCacheControl, w.Header().Get("Cache-Control"))
+		require.Equal(t, common.ETag(w.Body.Bytes()), w.Header().Get("ETag"))
+
+		other := httptest.NewRecorder()
+		common.WriteConditionalResponse(other, httptest.NewRequest(http.MethodGet, "/", nil), &mocklogger.MockLogger{},
+			map[string]string{"theme": "light"})
+		require.NotEqual(t, w.Header().Get("ETag"), other.Header().Get("ETag"))
+	})
+
+	t.Run("answers not modified if the client has the value", func(t *testing.T) {
+		etag := etagOf(t, value)
+
+		for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", header)
+
+			w := httptest.NewRecorder()
+			common.WriteConditionalResponse(w, r, &mocklogger.MockLogger{}, value)
+			require.Equal(t, http.StatusNotModified, w.Code, header)
+			require.Empty(t, w.Body.String())
+			require.Equal(t, etag, w.Header().Get("ETag"))
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("If-None-Match", `"other"`)
+
+		w := httptest.NewRecorder()
+		common.WriteConditionalResponse(w, r, &mocklogger.MockLogger{}, value)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("error if the value cannot be marshalled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		common.WriteConditionalResponse(w, httptest.NewRequest(http.MethodGet, "/", nil), &mocklogger.MockLogger{},
+			make(chan int))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("logs error when writer fails", func(t *testing.T) {
+		logger := &mocklogger.MockLogger{}
+		common.WriteConditionalResponse(&mockHTTPResponseWriter{writeErr: errors.New("test")},
+			httptest.NewRequest(http.MethodGet, "/", nil), logger, value)
+		require.Contains(t, logger.ErrorLogContents, "Unable to send response")
+	})
+}
+
+func etagOf(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	common.WriteConditionalResponse(w, httptest.NewRequest(http.MethodGet, "/", nil), &mocklogger.MockLogger{}, v)
+
+	return w.Header().Get("ETag")
+}
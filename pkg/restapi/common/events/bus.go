@@ -0,0 +1,227 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	defaultAttempts = 3
+	defaultBackoff  = 500 * time.Millisecond
+	defaultTimeout  = 10 * time.Second
+)
+
+var logger = log.New("edge-agent/events")
+
+// Handler consumes events published on the Bus.
+type Handler func(e *outbox.Event)
+
+// HTTPClient sends HTTP requests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// BusConfig holds the configuration for a Bus.
+type BusConfig struct {
+	// Webhooks is the registry of webhooks. Events are only published to subscribers if nil.
+	Webhooks   *WebhookStore
+	HTTPClient HTTPClient
+	// Attempts is the number of deliveries attempted to a webhook per event. Defaults to 3.
+	Attempts int
+	// Backoff is the delay before the second attempt, doubled after each attempt. Defaults to 500ms.
+	Backoff time.Duration
+}
+
+// Bus publishes events to in-process subscribers and to webhooks. It is an outbox.Dispatcher: the outbox
+// Relay dispatches the same event again if a webhook fails all its attempts, so subscribers and webhooks
+// must deduplicate on Event.ID.
+type Bus struct {
+	webhooks   *WebhookStore
+	httpClient HTTPClient
+	attempts   int
+	backoff    time.Duration
+	mutex      sync.RWMutex
+	subs       map[int]*subscription
+	next       int
+}
+
+type subscription struct {
+	handler Handler
+	topics  []string
+}
+
+// NewBus returns a new Bus.
+func NewBus(config *BusConfig) *Bus {
+	b := &Bus{
+		webhooks:   config.Webhooks,
+		httpClient: config.HTTPClient,
+		attempts:   config.Attempts,
+		backoff:    config.Backoff,
+		subs:       make(map[int]*subscription),
+	}
+
+	if b.httpClient == nil {
+		b.httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+
+	if b.attempts <= 0 {
+		b.attempts = defaultAttempts
+	}
+
+	if b.backoff <= 0 {
+		b.backoff = defaultBackoff
+	}
+
+	return b
+}
+
+// Subscribe the handler to the given topics, or to every topic if none is given. Handlers are called
+// synchronously by Dispatch and should hand long-running work off to a goroutine. The returned function
+// cancels the subscription.
+func (b *Bus) Subscribe(handler Handler, topics ...string) func() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	id := b.next
+	b.next++
+
+	b.subs[id] = &subscription{handler: handler, topics: topics}
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		delete(b.subs, id)
+	}
+}
+
+// Dispatch publishes the event to the subscribers and webhooks. It fails if a webhook could not be reached.
+func (b *Bus) Dispatch(e *outbox.Event) error {
+	b.notify(e)
+
+	if b.webhooks == nil {
+		return nil
+	}
+
+	webhooks, err := b.webhooks.List()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	var failed []string
+
+	for _, w := range webhooks {
+		if !w.Accepts(e) {
+			continue
+		}
+
+		err = b.deliver(w, e, body)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("webhook %s: %s", w.ID, err.Error()))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to deliver event %s: %s", e.ID, strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func (b *Bus) notify(e *outbox.Event) {
+	b.mutex.RLock()
+
+	handlers := make([]Handler, 0, len(b.subs))
+
+	for _, s := range b.subs {
+		if len(s.topics) == 0 || contains(s.topics, e.Topic) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+
+	b.mutex.RUnlock()
+
+	for _, h := range handlers {
+		h(e)
+	}
+}
+
+// deliver posts the event to the webhook, retrying with exponential backoff.
+func (b *Bus) deliver(w *Webhook, e *outbox.Event, body []byte) error {
+	delay := b.backoff
+
+	var err error
+
+	for attempt := 1; attempt <= b.attempts; attempt++ {
+		err = b.post(w, e, body)
+		if err == nil {
+			return nil
+		}
+
+		logger.Debugf("attempt %d to deliver event %s to webhook %s failed: %s", attempt, e.ID, w.ID, err.Error())
+
+		if attempt < b.attempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	return err
+}
+
+func (b *Bus) post(w *Webhook, e *outbox.Event, body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, e.Topic)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(w.Secret, timestamp, body))
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Warnf("failed to close response body: %s", errClose.Error())
+		}
+	}()
+
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
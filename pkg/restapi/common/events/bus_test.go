@@ -0,0 +1,222 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package events_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestBus_Subscribe(t *testing.T) {
+	t.Run("publishes events to the subscribers of their topic", func(t *testing.T) {
+		bus := events.NewBus(&events.BusConfig{})
+
+		var all, logins []string
+
+		bus.Subscribe(func(e *outbox.Event) { all = append(all, e.Topic) })
+		cancel := bus.Subscribe(func(e *outbox.Event) { logins = append(logins, e.Topic) }, "user.login")
+
+		require.NoError(t, bus.Dispatch(newEvent(t, "user.login", "")))
+		require.NoError(t, bus.Dispatch(newEvent(t, "user.created", "")))
+
+		cancel()
+
+		require.NoError(t, bus.Dispatch(newEvent(t, "user.login", "")))
+
+		require.Equal(t, []string{"user.login", "user.created", "user.login"}, all)
+		require.Equal(t, []string{"user.login"}, logins)
+	})
+}
+
+func TestBus_Webhooks(t *testing.T) {
+	t.Run("delivers signed events to the webhooks of the tenant", func(t *testing.T) {
+		acme := newMockWebhook(t, http.StatusOK)
+		defer acme.Close()
+
+		other := newMockWebhook(t, http.StatusOK)
+		defer other.Close()
+
+		bus, webhooks := newBus(t, nil)
+		require.NoError(t, webhooks.Save(&events.Webhook{ID: "acme", Tenant: "acme", URL: acme.URL, Secret: "s1"}))
+		require.NoError(t, webhooks.Save(&events.Webhook{
+			ID: "other", Tenant: "other", URL: other.URL, Secret: "s2", Topics: []string{"user.login"},
+		}))
+
+		e := newEvent(t, "user.created", "acme")
+		require.NoError(t, bus.Dispatch(e))
+
+		deliveries := acme.received()
+		require.Len(t, deliveries, 1)
+		require.Empty(t, other.received())
+
+		d := deliveries[0]
+		require.Equal(t, "user.created", d.header.Get(events.EventHeader))
+		require.Equal(t,
+			events.Sign("s1", d.header.Get(events.TimestampHeader), d.body), d.header.Get(events.SignatureHeader))
+		require.NotEqual(t,
+			events.Sign("s2", d.header.Get(events.TimestampHeader), d.body), d.header.Get(events.SignatureHeader))
+
+		delivered := &outbox.Event{}
+		require.NoError(t, json.Unmarshal(d.body, delivered))
+		require.Equal(t, e.ID, delivered.ID)
+		require.Equal(t, "acme", delivered.Tenant)
+	})
+
+	t.Run("retries failed deliveries", func(t *testing.T) {
+		webhook := newMockWebhook(t, http.StatusServiceUnavailable, http.StatusOK)
+		defer webhook.Close()
+
+		bus, webhooks := newBus(t, nil)
+		require.NoError(t, webhooks.Save(&events.Webhook{ID: "1", URL: webhook.URL, Secret: "s"}))
+
+		require.NoError(t, bus.Dispatch(newEvent(t, "user.login", "")))
+		require.Len(t, webhook.received(), 2)
+	})
+
+	t.Run("error if a webhook fails every attempt", func(t *testing.T) {
+		webhook := newMockWebhook(t, http.StatusInternalServerError)
+		defer webhook.Close()
+
+		bus, webhooks := newBus(t, nil)
+		require.NoError(t, webhooks.Save(&events.Webhook{ID: "1", URL: webhook.URL, Secret: "s"}))
+		require.NoError(t, webhooks.Save(&events.Webhook{ID: "2", URL: "http://localhost:-1", Secret: "s"}))
+
+		err := bus.Dispatch(newEvent(t, "user.login", ""))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "webhook 1: webhook returned status 500")
+		require.Contains(t, err.Error(), "webhook 2: failed to create request")
+		require.Len(t, webhook.received(), 2)
+	})
+
+	t.Run("error if webhooks cannot be listed", func(t *testing.T) {
+		bus, _ := newBus(t, &mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{}, ErrGetAll: errors.New("test"),
+		}})
+
+		err := bus.Dispatch(newEvent(t, "user.login", ""))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to list webhooks")
+	})
+
+	t.Run("error if request cannot be sent", func(t *testing.T) {
+		webhooks, err := events.NewWebhookStore(memstore.NewProvider())
+		require.NoError(t, err)
+		require.NoError(t, webhooks.Save(&events.Webhook{ID: "1", URL: "http://example.com", Secret: "s"}))
+
+		bus := events.NewBus(&events.BusConfig{
+			Webhooks: webhooks,
+			HTTPClient: &mockHTTPClient{do: func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("test")
+			}},
+			Attempts: 1,
+		})
+
+		err = bus.Dispatch(newEvent(t, "user.login", ""))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to send request")
+	})
+}
+
+func newBus(t *testing.T, p *mockstore.Provider) (*events.Bus, *events.WebhookStore) {
+	t.Helper()
+
+	var (
+		webhooks *events.WebhookStore
+		err      error
+	)
+
+	if p != nil {
+		webhooks, err = events.NewWebhookStore(p)
+	} else {
+		webhooks, err = events.NewWebhookStore(memstore.NewProvider())
+	}
+
+	require.NoError(t, err)
+
+	return events.NewBus(&events.BusConfig{
+		Webhooks: webhooks,
+		Attempts: 2,
+		Backoff:  time.Millisecond,
+	}), webhooks
+}
+
+func newEvent(t *testing.T, topic, tenant string) *outbox.Event {
+	t.Helper()
+
+	e, err := outbox.NewEvent(topic, uuid.New().String(), map[string]string{"test": "value"})
+	require.NoError(t, err)
+
+	e.Tenant = tenant
+
+	return e
+}
+
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// mockWebhook answers the deliveries with the given statuses in turn, repeating the last one.
+type mockWebhook struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	statuses   []int
+	deliveries []*delivery
+}
+
+func newMockWebhook(t *testing.T, statuses ...int) *mockWebhook {
+	t.Helper()
+
+	m := &mockWebhook{statuses: statuses}
+
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.deliveries = append(m.deliveries, &delivery{header: r.Header, body: body})
+
+		status := m.statuses[0]
+		if len(m.statuses) > 1 {
+			m.statuses = m.statuses[1:]
+		}
+
+		w.WriteHeader(status)
+	}))
+
+	return m
+}
+
+func (m *mockWebhook) received() []*delivery {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.deliveries
+}
+
+type mockHTTPClient struct {
+	do func(*http.Request) (*http.Response, error)
+}
+
+func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return m.do(req)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// WebhookStoreName is the name of the webhook store.
+	WebhookStoreName = "edgeagent_webhooks"
+)
+
+// Webhook headers.
+const (
+	// EventHeader holds the topic of the delivered event.
+	EventHeader = "X-Event-Topic"
+	// TimestampHeader holds the unix time at which the delivery was signed.
+	TimestampHeader = "X-Event-Timestamp"
+	// SignatureHeader holds "sha256=" followed by the hex encoded HMAC of the delivery. See Sign.
+	SignatureHeader = "X-Event-Signature"
+)
+
+// Webhook is an HTTP endpoint subscribed to events.
+type Webhook struct {
+	ID string `json:"id"`
+	// Tenant restricts the webhook to the events of the tenant. The webhook receives every event if empty.
+	Tenant string `json:"tenant,omitempty"`
+	URL    string `json:"url"`
+	// Secret is the HMAC key of the deliveries.
+	Secret string `json:"secret"`
+	// Topics restricts the webhook to the given topics. The webhook receives every topic if empty.
+	Topics []string `json:"topics,omitempty"`
+}
+
+// Accepts reports whether the event should be delivered to the webhook.
+func (w *Webhook) Accepts(e *outbox.Event) bool {
+	if w.Tenant != "" && w.Tenant != e.Tenant {
+		return false
+	}
+
+	return len(w.Topics) == 0 || contains(w.Topics, e.Topic)
+}
+
+// Sign returns the value of the SignatureHeader for a delivery: the HMAC-SHA256 of the timestamp, a '.' and
+// the body, keyed with the webhook's secret. Receivers recompute it to authenticate the delivery, and check
+// the timestamp to reject replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+
+	// writes to a hash never fail
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookStore returns a new WebhookStore.
+func NewWebhookStore(p storage.Provider) (*WebhookStore, error) {
+	s, err := store.Open(p, WebhookStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhook store: %w", err)
+	}
+
+	return &WebhookStore{s: s}, nil
+}
+
+// WebhookStore persists the webhooks.
+type WebhookStore struct {
+	s   storage.Store
+	mux sync.Mutex
+}
+
+// Save the webhook.
+func (s *WebhookStore) Save(w *Webhook) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	err := store.Save(s.s, w.ID, w)
+	if err != nil {
+		return fmt.Errorf("failed to save webhook: %w", err)
+	}
+
+	return nil
+}
+
+// Get the webhook with the given id.
+func (s *WebhookStore) Get(id string) (*Webhook, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	bits, err := s.s.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhook: %w", err)
+	}
+
+	w := &Webhook{}
+
+	err = json.Unmarshal(bits, w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook: %w", err)
+	}
+
+	return w, nil
+}
+
+// List returns all webhooks.
+func (s *WebhookStore) List() ([]*Webhook, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	all, err := s.s.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	webhooks := make([]*Webhook, 0, len(all))
+
+	for _, raw := range all {
+		w := &Webhook{}
+
+		err = json.Unmarshal(raw, w)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal webhook: %w", err)
+		}
+
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, nil
+}
+
+// Delete the webhook with the given id.
+func (s *WebhookStore) Delete(id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	err := s.s.Delete(id)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to delete webhook %s: %w", id, err)
+	}
+
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,89 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package events_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestWebhookStore(t *testing.T) {
+	t.Run("saves, lists and deletes webhooks", func(t *testing.T) {
+		s, err := events.NewWebhookStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		expected := &events.Webhook{ID: "1", Tenant: "acme", URL: "https://acme.example.com", Secret: "s"}
+		require.NoError(t, s.Save(expected))
+
+		result, err := s.Get("1")
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+
+		all, err := s.List()
+		require.NoError(t, err)
+		require.Equal(t, []*events.Webhook{expected}, all)
+
+		require.NoError(t, s.Delete("1"))
+		require.NoError(t, s.Delete("1"))
+
+		_, err = s.Get("1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch webhook")
+	})
+
+	t.Run("error if store cannot be opened", func(t *testing.T) {
+		_, err := events.NewWebhookStore(&mockstore.Provider{ErrCreateStore: errors.New("test")})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open webhook store")
+	})
+
+	t.Run("error if store fails", func(t *testing.T) {
+		s, err := events.NewWebhookStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:     map[string][]byte{"1": []byte("{")},
+			ErrPut:    errors.New("test"),
+			ErrDelete: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		err = s.Save(&events.Webhook{ID: "2"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save webhook")
+
+		_, err = s.Get("1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal webhook")
+
+		_, err = s.List()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal webhook")
+
+		err = s.Delete("1")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to delete webhook 1")
+	})
+}
+
+func TestWebhook_Accepts(t *testing.T) {
+	e := newEvent(t, "user.login", "acme")
+
+	require.True(t, (&events.Webhook{}).Accepts(e))
+	require.True(t, (&events.Webhook{Tenant: "acme", Topics: []string{"user.login"}}).Accepts(e))
+	require.False(t, (&events.Webhook{Tenant: "other"}).Accepts(e))
+	require.False(t, (&events.Webhook{Topics: []string{"user.created"}}).Accepts(e))
+}
+
+func TestSign(t *testing.T) {
+	signature := events.Sign("secret", "1600000000", []byte(`{"id":"1"}`))
+	require.Regexp(t, "^sha256=[0-9a-f]{64}$", signature)
+	require.Equal(t, signature, events.Sign("secret", "1600000000", []byte(`{"id":"1"}`)))
+	require.NotEqual(t, signature, events.Sign("secret", "1600000001", []byte(`{"id":"1"}`)))
+}
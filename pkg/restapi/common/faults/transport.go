@@ -0,0 +1,224 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package faults
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultErrorStatus = http.StatusServiceUnavailable
+
+var logger = log.New("edge-agent/faults")
+
+// Rule injects faults into the requests to an endpoint, each at its own rate between 0 and 1.
+type Rule struct {
+	// Endpoint is the base URL of the requests the rule applies to. The rule applies to every request if empty.
+	Endpoint string `yaml:"endpoint"`
+	// Latency delays the requests by this duration, at LatencyRate.
+	Latency     time.Duration `yaml:"latency"`
+	LatencyRate float64       `yaml:"latency-rate"`
+	// ErrorRate is the rate of the requests answered with ErrorStatus, a 5xx status defaulting to 503, without
+	// reaching the endpoint.
+	ErrorRate   float64 `yaml:"error-rate"`
+	ErrorStatus int     `yaml:"error-status"`
+	// ResetRate is the rate of the requests failing with a connection reset, without reaching the endpoint.
+	ResetRate float64 `yaml:"reset-rate"`
+}
+
+// Config holds the rules of the faults injected into the requests.
+type Config struct {
+	Rules []*Rule `yaml:"rules"`
+	// Random returns the numbers in [0, 1) the rates are drawn against. Defaults to math/rand.
+	Random func() float64 `yaml:"-"`
+}
+
+// Load the config of a YAML or JSON file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path) // nolint:gosec // the path is configured by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fault injection file: %w", err)
+	}
+
+	config := &Config{}
+
+	// YAML is a superset of JSON
+	err = yaml.UnmarshalStrict(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fault injection file: %w", err)
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate the rules of the config.
+func (c *Config) Validate() error {
+	for i, rule := range c.Rules {
+		err := rule.validate()
+		if err != nil {
+			return fmt.Errorf("invalid fault injection rule %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if r == nil {
+		return fmt.Errorf("empty rule")
+	}
+
+	if r.Endpoint != "" {
+		if _, err := url.ParseRequestURI(r.Endpoint); err != nil {
+			return fmt.Errorf("invalid endpoint: %w", err)
+		}
+	}
+
+	if r.Latency < 0 {
+		return fmt.Errorf("negative latency %s", r.Latency)
+	}
+
+	for name, rate := range map[string]float64{
+		"latency-rate": r.LatencyRate,
+		"error-rate":   r.ErrorRate,
+		"reset-rate":   r.ResetRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s %g not between 0 and 1", name, rate)
+		}
+	}
+
+	if r.ErrorStatus != 0 && (r.ErrorStatus < 500 || r.ErrorStatus > 599) {
+		return fmt.Errorf("error-status %d not a 5xx status", r.ErrorStatus)
+	}
+
+	return nil
+}
+
+// Transport is an http.RoundTripper injecting latency, 5xx responses and connection resets into the requests,
+// to test the resilience of the agent to the failures of its dependencies. A request is subject to the rule with
+// the longest endpoint prefixing its URL, and sent as is if none applies.
+type Transport struct {
+	base   http.RoundTripper
+	rules  []*Rule
+	random func() float64
+}
+
+// NewTransport returns a Transport sending the requests through base, or through http.DefaultTransport if nil.
+func NewTransport(base http.RoundTripper, config *Config) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	random := config.Random
+	if random == nil {
+		random = rand.Float64 // nolint:gosec // the faults are not security sensitive
+	}
+
+	return &Transport{base: base, rules: config.Rules, random: random}
+}
+
+// RoundTrip injects the faults of the rule of the request, and sends it unless it fails.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rule := t.match(r)
+	if rule == nil {
+		return t.base.RoundTrip(r)
+	}
+
+	if rule.Latency > 0 && t.draw(rule.LatencyRate) {
+		logger.Debugf("injecting %s of latency into %s %s", rule.Latency, r.Method, r.URL.String())
+
+		timer := time.NewTimer(rule.Latency)
+
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			closeBody(r)
+
+			return nil, r.Context().Err()
+		}
+	}
+
+	if t.draw(rule.ResetRate) {
+		logger.Debugf("injecting a connection reset into %s %s", r.Method, r.URL.String())
+		closeBody(r)
+
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+
+	if t.draw(rule.ErrorRate) {
+		status := rule.ErrorStatus
+		if status == 0 {
+			status = defaultErrorStatus
+		}
+
+		logger.Debugf("injecting a %d response into %s %s", status, r.Method, r.URL.String())
+		closeBody(r)
+
+		return errorResponse(r, status), nil
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+// match returns the rule with the longest endpoint prefixing the request URL.
+func (t *Transport) match(r *http.Request) *Rule {
+	target := r.URL.String()
+
+	var matched *Rule
+
+	for _, rule := range t.rules {
+		if strings.HasPrefix(target, rule.Endpoint) && (matched == nil || len(rule.Endpoint) > len(matched.Endpoint)) {
+			matched = rule
+		}
+	}
+
+	return matched
+}
+
+func (t *Transport) draw(rate float64) bool {
+	return rate > 0 && t.random() < rate
+}
+
+func errorResponse(r *http.Request, status int) *http.Response {
+	body := "fault injected: " + http.StatusText(status)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
+
+// closeBody closes the body of a request that is not sent, as a round tripper must.
+func closeBody(r *http.Request) {
+	if r.Body != nil {
+		_ = r.Body.Close() // nolint:errcheck // the request failed either way
+	}
+}
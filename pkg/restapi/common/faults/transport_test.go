@@ -0,0 +1,171 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package faults_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/faults"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("loads the rules of a YAML file", func(t *testing.T) {
+		file := writeFile(t, "rules:\n"+
+			"  - endpoint: https://edv.example.com/\n    latency: 2s\n    latency-rate: 0.5\n"+
+			"  - error-rate: 0.1\n    error-status: 502\n    reset-rate: 0.05\n")
+
+		config, err := faults.Load(file)
+		require.NoError(t, err)
+		require.Equal(t, []*faults.Rule{
+			{Endpoint: "https://edv.example.com/", Latency: 2 * time.Second, LatencyRate: 0.5},
+			{ErrorRate: 0.1, ErrorStatus: http.StatusBadGateway, ResetRate: 0.05},
+		}, config.Rules)
+	})
+
+	t.Run("loads the rules of a JSON file", func(t *testing.T) {
+		config, err := faults.Load(writeFile(t, `{"rules": [{"error-rate": 1}]}`))
+		require.NoError(t, err)
+		require.Equal(t, []*faults.Rule{{ErrorRate: 1}}, config.Rules)
+	})
+
+	t.Run("error if a rule is invalid", func(t *testing.T) {
+		for rule, msg := range map[string]string{
+			"- null":                  "empty rule",
+			"- endpoint: edv":         "invalid endpoint",
+			"- latency: -1s":          "negative latency",
+			"- latency-rate: 2":       "latency-rate 2 not between 0 and 1",
+			"- error-rate: -0.1":      "error-rate -0.1 not between 0 and 1",
+			"- reset-rate: 1.5":       "reset-rate 1.5 not between 0 and 1",
+			"- error-status: 404":     "error-status 404 not a 5xx status",
+			"- unknown-field: anyway": "failed to parse fault injection file",
+		} {
+			_, err := faults.Load(writeFile(t, "rules:\n  "+rule+"\n"))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), msg)
+		}
+	})
+
+	t.Run("error if the file cannot be read", func(t *testing.T) {
+		_, err := faults.Load(filepath.Join(t.TempDir(), "missing.yaml"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read fault injection file")
+	})
+}
+
+func TestTransport(t *testing.T) {
+	var requests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	t.Run("sends the requests no fault is injected into", func(t *testing.T) {
+		requests = 0
+
+		client := newClient(&faults.Config{
+			Rules:  []*faults.Rule{{Endpoint: server.URL + "/kms", ErrorRate: 1}, {ErrorRate: 0.5}},
+			Random: func() float64 { return 0.5 },
+		})
+
+		resp := get(t, client, server.URL+"/edv")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, 1, requests)
+
+		client = newClient(&faults.Config{Rules: []*faults.Rule{{Endpoint: "https://other.example.com", ErrorRate: 1}}})
+
+		resp = get(t, client, server.URL)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, 2, requests)
+	})
+
+	t.Run("injects 5xx responses", func(t *testing.T) {
+		requests = 0
+
+		client := newClient(&faults.Config{Rules: []*faults.Rule{
+			{ErrorRate: 1},
+			{Endpoint: server.URL + "/kms", ErrorRate: 1, ErrorStatus: http.StatusGatewayTimeout},
+		}})
+
+		resp := get(t, client, server.URL+"/edv")
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+		resp = get(t, client, server.URL+"/kms/keystores")
+		require.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+		require.Zero(t, requests)
+	})
+
+	t.Run("injects connection resets", func(t *testing.T) {
+		requests = 0
+
+		client := newClient(&faults.Config{Rules: []*faults.Rule{{ResetRate: 1, ErrorRate: 1}}})
+
+		_, err := client.Post(server.URL, "text/plain", strings.NewReader("body")) // nolint:bodyclose // no response
+		require.Error(t, err)
+		require.True(t, errors.Is(err, syscall.ECONNRESET))
+		require.Zero(t, requests)
+	})
+
+	t.Run("injects latency", func(t *testing.T) {
+		requests = 0
+
+		client := newClient(&faults.Config{Rules: []*faults.Rule{{Latency: 50 * time.Millisecond, LatencyRate: 1}}})
+
+		start := time.Now()
+		resp := get(t, client, server.URL)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+		require.Equal(t, 1, requests)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		client = newClient(&faults.Config{Rules: []*faults.Rule{{Latency: time.Minute, LatencyRate: 1}}})
+
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+
+		_, err = client.Do(r) // nolint:bodyclose // no response
+		require.Error(t, err)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Equal(t, 1, requests)
+	})
+}
+
+func newClient(config *faults.Config) *http.Client {
+	return &http.Client{Transport: faults.NewTransport(nil, config)}
+}
+
+func get(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+
+	resp, err := client.Get(url) // nolint:noctx // test request
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return resp
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "faults.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+
+	return file
+}
@@ -0,0 +1,296 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package features
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Features gated by flags.
+const (
+	// OIDC4VCI is the redemption of credential offers with OpenID for Verifiable Credential Issuance.
+	OIDC4VCI = "oidc4vci"
+	// DeviceLogin is the login of the devices without a browser with the device authorization grant.
+	DeviceLogin = "device-login"
+	// OIDCShadow is the comparison of the results of the candidate OIDC client with those of the current one.
+	OIDCShadow = "oidc-shadow"
+)
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	loadTimeout            = 10 * time.Second
+)
+
+var logger = log.New("edge-agent/features")
+
+// Flag enables a feature for everyone, or for the users it targets.
+type Flag struct {
+	Description string `json:"description,omitempty" yaml:"description"`
+	// Enabled enables the feature for everyone.
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Percentage of the users the feature is enabled for, from 0 to 100. The users are bucketed by their sub, so
+	// that each user keeps the same buckets as the percentage grows.
+	Percentage int `json:"percentage,omitempty" yaml:"percentage"`
+	// Tenants the feature is enabled for.
+	Tenants []string `json:"tenants,omitempty" yaml:"tenants"`
+	// Users the feature is enabled for, by sub.
+	Users []string `json:"users,omitempty" yaml:"users"`
+}
+
+// Subject is the user a flag is evaluated for.
+type Subject struct {
+	Sub    string
+	Tenant string
+}
+
+// Source loads the flags by the names of their features.
+type Source interface {
+	Load(ctx context.Context) (map[string]*Flag, error)
+}
+
+// Config holds the configuration of a Service.
+type Config struct {
+	Source Source
+	// Tenant resolves the tenant of a user, for the flags targeting tenants. The users have no tenant if nil.
+	Tenant func(sub string) (string, error)
+	// Interval between the reloads of the flags. Defaults to 30s.
+	Interval time.Duration
+}
+
+// Service evaluates the feature flags of the users. The features without flag are enabled, so that the flags only
+// restrict the features they name. A nil Service enables every feature.
+type Service struct {
+	source   Source
+	tenant   func(sub string) (string, error)
+	interval time.Duration
+	mutex    sync.RWMutex
+	flags    map[string]*Flag
+	once     sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New returns a Service with the flags loaded from the source.
+func New(config *Config) (*Service, error) {
+	if config.Source == nil {
+		return nil, errors.New("missing feature flag source")
+	}
+
+	s := &Service{
+		source:   config.Source,
+		tenant:   config.Tenant,
+		interval: config.Interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if s.interval <= 0 {
+		s.interval = defaultRefreshInterval
+	}
+
+	flags, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	s.flags = flags
+
+	return s, nil
+}
+
+// Start reloading the flags in the background, so that their changes apply without restart.
+func (s *Service) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Refresh()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop reloading the flags.
+func (s *Service) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
+
+// Refresh reloads the flags. The current flags are kept if they cannot be loaded.
+func (s *Service) Refresh() {
+	flags, err := s.load()
+	if err != nil {
+		logger.Warnf("keeping the current feature flags: %s", err.Error())
+
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.flags = flags
+}
+
+// Enabled tells whether the feature is enabled for the user with the sub, whose tenant is resolved if the flag of
+// the feature targets tenants. The anonymous users, with an empty sub, only get the features enabled for everyone.
+func (s *Service) Enabled(feature, sub string) bool {
+	if s == nil {
+		return true
+	}
+
+	flag, found := s.flag(feature)
+	if !found {
+		return true
+	}
+
+	subject := &Subject{Sub: sub}
+
+	if len(flag.Tenants) > 0 {
+		subject.Tenant = s.tenantOf(sub)
+	}
+
+	return flag.enabledFor(feature, subject)
+}
+
+// EnabledFor tells whether the feature is enabled for the subject, e.g. a user logging in, whose tenant is not
+// recorded yet.
+func (s *Service) EnabledFor(feature string, subject *Subject) bool {
+	if s == nil {
+		return true
+	}
+
+	flag, found := s.flag(feature)
+	if !found {
+		return true
+	}
+
+	return flag.enabledFor(feature, subject)
+}
+
+// Features returns the flagged features, and whether they are enabled for the user with the sub.
+func (s *Service) Features(sub string) map[string]bool {
+	features := make(map[string]bool)
+
+	if s == nil {
+		return features
+	}
+
+	s.mutex.RLock()
+	flags := s.flags
+	s.mutex.RUnlock()
+
+	subject := &Subject{Sub: sub}
+	resolved := false
+
+	for feature, flag := range flags {
+		if len(flag.Tenants) > 0 && !resolved {
+			subject.Tenant = s.tenantOf(sub)
+			resolved = true
+		}
+
+		features[feature] = flag.enabledFor(feature, subject)
+	}
+
+	return features
+}
+
+func (s *Service) flag(feature string) (*Flag, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	flag, found := s.flags[feature]
+
+	return flag, found
+}
+
+func (s *Service) tenantOf(sub string) string {
+	if sub == "" || s.tenant == nil {
+		return ""
+	}
+
+	tenant, err := s.tenant(sub)
+	if err != nil {
+		logger.Warnf("failed to resolve the tenant of user %s: %s", sub, err.Error())
+
+		return ""
+	}
+
+	return tenant
+}
+
+func (s *Service) load() (map[string]*Flag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+
+	flags, err := s.source.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load feature flags: %w", err)
+	}
+
+	for feature, flag := range flags {
+		if flag == nil {
+			return nil, fmt.Errorf("invalid flag of feature %s: empty flag", feature)
+		}
+
+		if flag.Percentage < 0 || flag.Percentage > 100 {
+			return nil, fmt.Errorf("invalid flag of feature %s: percentage %d not between 0 and 100",
+				feature, flag.Percentage)
+		}
+	}
+
+	return flags, nil
+}
+
+func (f *Flag) enabledFor(feature string, subject *Subject) bool {
+	if f.Enabled {
+		return true
+	}
+
+	if subject == nil || subject.Sub == "" {
+		return false
+	}
+
+	if contains(f.Users, subject.Sub) || (subject.Tenant != "" && contains(f.Tenants, subject.Tenant)) {
+		return true
+	}
+
+	return f.Percentage > 0 && bucket(feature, subject.Sub) < f.Percentage
+}
+
+// bucket places the user in one of 100 buckets, independently for each feature.
+func bucket(feature, sub string) int {
+	sum := sha256.Sum256([]byte(feature + "\x00" + sub))
+
+	return int(binary.BigEndian.Uint32(sum[:4]) % 100) // nolint:gomnd // 100 buckets of 1%
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,163 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package features_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("error if the source is missing", func(t *testing.T) {
+		_, err := features.New(&features.Config{})
+		require.EqualError(t, err, "missing feature flag source")
+	})
+
+	t.Run("error if the flags cannot be loaded", func(t *testing.T) {
+		_, err := features.New(&features.Config{Source: &features.MockSource{LoadErr: errors.New("test")}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to load feature flags")
+	})
+
+	t.Run("error if a flag is invalid", func(t *testing.T) {
+		for _, flag := range []*features.Flag{nil, {Percentage: -1}, {Percentage: 101}} {
+			_, err := features.New(&features.Config{Source: &features.MockSource{
+				Flags: map[string]*features.Flag{features.OIDC4VCI: flag},
+			}})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid flag of feature oidc4vci")
+		}
+	})
+}
+
+func TestService_Enabled(t *testing.T) {
+	tenants := map[string]string{"alice": "a", "bob": "b"}
+
+	s, err := features.New(&features.Config{
+		Source: &features.MockSource{Flags: map[string]*features.Flag{
+			"everyone": {Enabled: true},
+			"nobody":   {},
+			"tenant-b": {Tenants: []string{"b"}},
+			"alice":    {Users: []string{"alice"}},
+			"half":     {Percentage: 50},
+		}},
+		Tenant: func(sub string) (string, error) {
+			tenant, found := tenants[sub]
+			if !found {
+				return "", errors.New("test")
+			}
+
+			return tenant, nil
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("enables the features for the users they target", func(t *testing.T) {
+		require.True(t, s.Enabled("everyone", "alice"))
+		require.True(t, s.Enabled("everyone", ""))
+		require.False(t, s.Enabled("nobody", "alice"))
+		require.True(t, s.Enabled("tenant-b", "bob"))
+		require.False(t, s.Enabled("tenant-b", "alice"))
+		require.False(t, s.Enabled("tenant-b", "carol"))
+		require.True(t, s.Enabled("alice", "alice"))
+		require.False(t, s.Enabled("alice", "bob"))
+		require.False(t, s.Enabled("alice", ""))
+	})
+
+	t.Run("enables the features without flag", func(t *testing.T) {
+		require.True(t, s.Enabled("unknown", "alice"))
+		require.True(t, s.Enabled("unknown", ""))
+	})
+
+	t.Run("enables the features for a stable percentage of the users", func(t *testing.T) {
+		enabled := 0
+
+		for i := 0; i < 1000; i++ {
+			sub := fmt.Sprintf("user-%d", i)
+
+			if s.Enabled("half", sub) {
+				require.True(t, s.Enabled("half", sub))
+
+				enabled++
+			}
+		}
+
+		require.InDelta(t, 500, enabled, 75)
+	})
+
+	t.Run("evaluates the flags for a subject", func(t *testing.T) {
+		require.True(t, s.EnabledFor("tenant-b", &features.Subject{Sub: "carol", Tenant: "b"}))
+		require.False(t, s.EnabledFor("tenant-b", &features.Subject{Sub: "carol", Tenant: "a"}))
+		require.False(t, s.EnabledFor("tenant-b", nil))
+		require.True(t, s.EnabledFor("unknown", nil))
+	})
+
+	t.Run("lists the flags of the user", func(t *testing.T) {
+		flags := s.Features("bob")
+		require.Len(t, flags, 5)
+		require.True(t, flags["everyone"])
+		require.False(t, flags["nobody"])
+		require.True(t, flags["tenant-b"])
+		require.False(t, flags["alice"])
+
+		flags = s.Features("")
+		require.True(t, flags["everyone"])
+		require.False(t, flags["tenant-b"])
+	})
+
+	t.Run("enables every feature without service", func(t *testing.T) {
+		var nilService *features.Service
+
+		require.True(t, nilService.Enabled("nobody", "alice"))
+		require.True(t, nilService.EnabledFor("nobody", nil))
+		require.Empty(t, nilService.Features("alice"))
+	})
+}
+
+func TestService_Refresh(t *testing.T) {
+	t.Run("applies the changes of the flags", func(t *testing.T) {
+		source := &features.MockSource{Flags: map[string]*features.Flag{features.DeviceLogin: {}}}
+
+		s, err := features.New(&features.Config{Source: source})
+		require.NoError(t, err)
+		require.False(t, s.Enabled(features.DeviceLogin, "alice"))
+
+		source.Flags = map[string]*features.Flag{features.DeviceLogin: {Users: []string{"alice"}}}
+
+		s.Refresh()
+		require.True(t, s.Enabled(features.DeviceLogin, "alice"))
+	})
+
+	t.Run("keeps the flags that cannot be reloaded", func(t *testing.T) {
+		source := &features.MockSource{Flags: map[string]*features.Flag{features.DeviceLogin: {}}}
+
+		s, err := features.New(&features.Config{Source: source})
+		require.NoError(t, err)
+
+		source.LoadErr = errors.New("test")
+
+		s.Refresh()
+		require.False(t, s.Enabled(features.DeviceLogin, "alice"))
+	})
+
+	t.Run("reloads the flags in the background", func(t *testing.T) {
+		source := &features.MockSource{Flags: map[string]*features.Flag{features.DeviceLogin: {Enabled: true}}}
+
+		s, err := features.New(&features.Config{Source: source, Interval: time.Millisecond})
+		require.NoError(t, err)
+
+		s.Start()
+		s.Stop()
+		s.Stop()
+	})
+}
@@ -0,0 +1,20 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package features
+
+import "context"
+
+// MockSource is a mock Source.
+type MockSource struct {
+	Flags   map[string]*Flag
+	LoadErr error
+}
+
+// Load returns the flags.
+func (m *MockSource) Load(context.Context) (map[string]*Flag, error) {
+	return m.Flags, m.LoadErr
+}
@@ -0,0 +1,91 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package features
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// NewFileSource returns a Source reading the flags from a YAML or JSON file mapping the features to their flags,
+// e.g. 'oidc4vci: {percentage: 10}'.
+func NewFileSource(path string) Source {
+	return &fileSource{path: path}
+}
+
+type fileSource struct {
+	path string
+}
+
+func (f *fileSource) Load(context.Context) (map[string]*Flag, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(f.path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feature flag file: %w", err)
+	}
+
+	return parse(data)
+}
+
+// NewRemoteSource returns a Source fetching the flags from a URL serving them in the format of the files.
+func NewRemoteSource(url string, httpClient *http.Client) Source {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &remoteSource{url: url, httpClient: httpClient}
+}
+
+type remoteSource struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (r *remoteSource) Load(ctx context.Context) (map[string]*Flag, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create feature flag request: %w", err)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feature flags: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Warnf("failed to close feature flag response body: %s", errClose.Error())
+		}
+	}()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feature flags: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feature flag request failed with status %d: %s", resp.StatusCode, string(data))
+	}
+
+	return parse(data)
+}
+
+// parse parses the flags in YAML, JSON documents being YAML documents too.
+func parse(data []byte) (map[string]*Flag, error) {
+	flags := make(map[string]*Flag)
+
+	err := yaml.UnmarshalStrict(data, &flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse feature flags: %w", err)
+	}
+
+	return flags, nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package features_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+)
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "features")
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, os.RemoveAll(dir)) }()
+
+	t.Run("loads the flags of a YAML file", func(t *testing.T) {
+		path := filepath.Join(dir, "features.yaml")
+		require.NoError(t, ioutil.WriteFile(path, []byte(
+			"oidc4vci:\n  percentage: 10\ndevice-login:\n  tenants: [b]\n  description: Device login\n"), 0600))
+
+		flags, err := features.NewFileSource(path).Load(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, map[string]*features.Flag{
+			features.OIDC4VCI:    {Percentage: 10},
+			features.DeviceLogin: {Tenants: []string{"b"}, Description: "Device login"},
+		}, flags)
+	})
+
+	t.Run("loads the flags of a JSON file", func(t *testing.T) {
+		path := filepath.Join(dir, "features.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(`{"oidc4vci": {"enabled": true}}`), 0600))
+
+		flags, err := features.NewFileSource(path).Load(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, map[string]*features.Flag{features.OIDC4VCI: {Enabled: true}}, flags)
+	})
+
+	t.Run("error if a flag has an unknown field", func(t *testing.T) {
+		path := filepath.Join(dir, "unknown.yaml")
+		require.NoError(t, ioutil.WriteFile(path, []byte("oidc4vci:\n  percent: 10\n"), 0600))
+
+		_, err := features.NewFileSource(path).Load(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse feature flags")
+	})
+
+	t.Run("error if the file cannot be read", func(t *testing.T) {
+		_, err := features.NewFileSource(filepath.Join(dir, "missing.yaml")).Load(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read feature flag file")
+	})
+}
+
+func TestRemoteSource(t *testing.T) {
+	t.Run("fetches the flags", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte(`{"device-login": {"users": ["alice"]}}`))
+			require.NoError(t, err)
+		}))
+		defer srv.Close()
+
+		flags, err := features.NewRemoteSource(srv.URL, nil).Load(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, map[string]*features.Flag{features.DeviceLogin: {Users: []string{"alice"}}}, flags)
+	})
+
+	t.Run("error if the flags cannot be fetched", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		_, err := features.NewRemoteSource(srv.URL, http.DefaultClient).Load(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "feature flag request failed with status 503")
+
+		_, err = features.NewRemoteSource("http://localhost:-1", nil).Load(context.Background())
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,161 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package headers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+)
+
+// Security headers of the responses.
+const (
+	ContentSecurityPolicy           = "Content-Security-Policy"
+	ContentSecurityPolicyReportOnly = "Content-Security-Policy-Report-Only"
+	StrictTransportSecurity         = "Strict-Transport-Security"
+	ContentTypeOptions              = "X-Content-Type-Options"
+	ReferrerPolicy                  = "Referrer-Policy"
+)
+
+// Headers are the security headers of the responses of a group of routes. The empty headers are not set.
+type Headers struct {
+	// ContentSecurityPolicy of the documents served, e.g. default-src 'self'.
+	ContentSecurityPolicy string
+	// ReportOnly sends the policy in the Content-Security-Policy-Report-Only header: the browsers report its
+	// violations instead of blocking them, e.g. to try a policy out.
+	ReportOnly bool
+	// ReportURI the browsers report the violations of the policy to, unless the policy has its own report-uri.
+	ReportURI string
+	// StrictTransportSecurity tells the browsers to only reach the agent over HTTPS, e.g. max-age=63072000.
+	StrictTransportSecurity string
+	// ContentTypeOptions is nosniff to keep the browsers from guessing the types of the responses.
+	ContentTypeOptions string
+	// ReferrerPolicy of the requests sent from the documents served, e.g. no-referrer.
+	ReferrerPolicy string
+	// FrameAncestors are the origins allowed to embed the documents served, e.g. 'none'. They are enforced even in
+	// report-only mode, which the browsers ignore the frame-ancestors of.
+	FrameAncestors string
+}
+
+// Route is a group of routes, by path prefix, with its own headers.
+type Route struct {
+	Prefix string
+	// Headers of the routes in place of the default ones. No header is set if nil.
+	Headers *Headers
+}
+
+// Config of the security headers of the routes.
+type Config struct {
+	// Default headers of the routes matching no prefix. No header is set if nil.
+	Default *Headers
+	// Routes with their own headers. The longest prefix matching the path of a request wins.
+	Routes []*Route
+}
+
+// Middleware sets the security headers on every response, ahead of the handler: the handlers setting a header
+// themselves override it.
+func Middleware(config *Config) common.Middleware {
+	routes := make([]*Route, len(config.Routes))
+	copy(routes, config.Routes)
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+
+	// the values of the headers are computed once
+	values := map[*Headers]http.Header{}
+	for _, h := range append([]*Headers{config.Default}, headersOf(routes)...) {
+		values[h] = h.values()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := config.Default
+
+			for _, route := range routes {
+				if strings.HasPrefix(r.URL.Path, route.Prefix) {
+					h = route.Headers
+
+					break
+				}
+			}
+
+			for name := range values[h] {
+				w.Header().Set(name, values[h].Get(name))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func headersOf(routes []*Route) []*Headers {
+	h := make([]*Headers, 0, len(routes))
+
+	for _, route := range routes {
+		h = append(h, route.Headers)
+	}
+
+	return h
+}
+
+// values returns the headers to set, by canonical name.
+func (h *Headers) values() http.Header {
+	values := http.Header{}
+
+	if h == nil {
+		return values
+	}
+
+	set := func(name, value string) {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+
+	set(StrictTransportSecurity, h.StrictTransportSecurity)
+	set(ContentTypeOptions, h.ContentTypeOptions)
+	set(ReferrerPolicy, h.ReferrerPolicy)
+
+	var frameAncestors string
+
+	if h.FrameAncestors != "" {
+		frameAncestors = "frame-ancestors " + h.FrameAncestors
+	}
+
+	policy := h.ContentSecurityPolicy
+	if policy != "" && h.ReportURI != "" && !strings.Contains(policy, "report-uri") {
+		policy = directives(policy, "report-uri "+h.ReportURI)
+	}
+
+	if !h.ReportOnly {
+		set(ContentSecurityPolicy, directives(policy, frameAncestors))
+
+		return values
+	}
+
+	set(ContentSecurityPolicyReportOnly, directives(policy))
+	set(ContentSecurityPolicy, frameAncestors)
+
+	return values
+}
+
+// directives joins the non-empty directives of a policy.
+func directives(policies ...string) string {
+	var joined []string
+
+	for _, p := range policies {
+		p = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(p), ";"))
+		if p != "" {
+			joined = append(joined, p)
+		}
+	}
+
+	return strings.Join(joined, "; ")
+}
@@ -0,0 +1,108 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package headers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/headers"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Run("sets the security headers of the responses", func(t *testing.T) {
+		h := serve(&headers.Config{Default: &headers.Headers{
+			ContentSecurityPolicy:   "default-src 'self';",
+			ReportURI:               "/csp-report",
+			StrictTransportSecurity: "max-age=63072000",
+			ContentTypeOptions:      "nosniff",
+			ReferrerPolicy:          "no-referrer",
+			FrameAncestors:          "'none'",
+		}}, "/oidc/login", nil)
+
+		require.Equal(t, "default-src 'self'; report-uri /csp-report; frame-ancestors 'none'",
+			h.Get(headers.ContentSecurityPolicy))
+		require.Empty(t, h.Get(headers.ContentSecurityPolicyReportOnly))
+		require.Equal(t, "max-age=63072000", h.Get(headers.StrictTransportSecurity))
+		require.Equal(t, "nosniff", h.Get(headers.ContentTypeOptions))
+		require.Equal(t, "no-referrer", h.Get(headers.ReferrerPolicy))
+	})
+
+	t.Run("reports the violations of the policy without enforcing it", func(t *testing.T) {
+		h := serve(&headers.Config{Default: &headers.Headers{
+			ContentSecurityPolicy: "default-src 'self'",
+			ReportOnly:            true,
+			ReportURI:             "/csp-report",
+			FrameAncestors:        "'self'",
+		}}, "/", nil)
+
+		require.Equal(t, "default-src 'self'; report-uri /csp-report", h.Get(headers.ContentSecurityPolicyReportOnly))
+		// ignored by the browsers in report-only policies
+		require.Equal(t, "frame-ancestors 'self'", h.Get(headers.ContentSecurityPolicy))
+	})
+
+	t.Run("keeps the report-uri of the policy", func(t *testing.T) {
+		h := serve(&headers.Config{Default: &headers.Headers{
+			ContentSecurityPolicy: "default-src 'self'; report-uri https://reports.example.com",
+			ReportOnly:            true,
+			ReportURI:             "/csp-report",
+		}}, "/", nil)
+
+		require.Equal(t, "default-src 'self'; report-uri https://reports.example.com",
+			h.Get(headers.ContentSecurityPolicyReportOnly))
+		require.Empty(t, h.Get(headers.ContentSecurityPolicy))
+	})
+
+	t.Run("sets the headers of the longest prefix matching the route", func(t *testing.T) {
+		config := &headers.Config{
+			Default: &headers.Headers{ContentSecurityPolicy: "default-src 'self'", ContentTypeOptions: "nosniff"},
+			Routes: []*headers.Route{
+				{Prefix: "/oidc", Headers: &headers.Headers{ContentTypeOptions: "nosniff"}},
+				{Prefix: "/oidc/wallet/attachments", Headers: &headers.Headers{ContentSecurityPolicy: "sandbox"}},
+				{Prefix: "/healthcheck"},
+			},
+		}
+
+		h := serve(config, "/oidc/wallet/attachments/123", nil)
+		require.Equal(t, "sandbox", h.Get(headers.ContentSecurityPolicy))
+		require.Empty(t, h.Get(headers.ContentTypeOptions))
+
+		h = serve(config, "/oidc/userinfo", nil)
+		require.Empty(t, h.Get(headers.ContentSecurityPolicy))
+		require.Equal(t, "nosniff", h.Get(headers.ContentTypeOptions))
+
+		h = serve(config, "/agent", nil)
+		require.Equal(t, "default-src 'self'", h.Get(headers.ContentSecurityPolicy))
+
+		require.Empty(t, serve(config, "/healthcheck", nil))
+	})
+
+	t.Run("lets the handlers override the headers", func(t *testing.T) {
+		h := serve(&headers.Config{Default: &headers.Headers{ReferrerPolicy: "no-referrer"}}, "/",
+			func(w http.ResponseWriter) {
+				w.Header().Set(headers.ReferrerPolicy, "origin")
+			})
+
+		require.Equal(t, "origin", h.Get(headers.ReferrerPolicy))
+	})
+}
+
+func serve(config *headers.Config, path string, handle func(w http.ResponseWriter)) http.Header {
+	handler := headers.Middleware(config)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if handle != nil {
+			handle(w)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return w.Header()
+}
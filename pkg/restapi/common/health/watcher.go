@@ -0,0 +1,275 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultTimeout  = 5 * time.Second
+
+	// CheckPath is the health endpoint of hub-auth, hub-kms and the EDV server.
+	CheckPath = "/healthcheck"
+)
+
+var logger = log.New("edge-agent/health")
+
+// Probe checks a dependency, and returns an error if it is down.
+type Probe func(ctx context.Context) error
+
+// HTTPClient sends the requests of the HTTP probes.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// HTTPProbe returns a probe of the URL. The dependency is down if it cannot be reached or answers with a 5xx
+// status.
+func HTTPProbe(client HTTPClient, url string) Probe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if errClose := resp.Body.Close(); errClose != nil {
+				logger.Warnf("failed to close response body: %s", errClose.Error())
+			}
+		}()
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%s answered with status %d", url, resp.StatusCode)
+		}
+
+		return nil
+	}
+}
+
+// CheckURL returns the health endpoint of the server of the URL, e.g. of the EDV server of the URL of its vaults.
+func CheckURL(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return strings.TrimSuffix(serverURL, "/") + CheckPath
+	}
+
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: CheckPath}).String()
+}
+
+// Config holds the configuration of a Watcher.
+type Config struct {
+	// Probes of the dependencies, by name.
+	Probes map[string]Probe
+	// Interval between the checks. Defaults to 30s.
+	Interval time.Duration
+	// Timeout of each probe. Defaults to 5s.
+	Timeout time.Duration
+}
+
+// Status is the health of a dependency.
+type Status struct {
+	Up bool `json:"up"`
+	// Since is when the dependency went up or down.
+	Since time.Time `json:"since"`
+	// Checked is when the dependency was last probed.
+	Checked time.Time `json:"checked"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Watcher probes the dependencies periodically, and tells their consumers when they go down or up again. The
+// dependencies are up until a probe fails.
+type Watcher struct {
+	probes   map[string]Probe
+	interval time.Duration
+	timeout  time.Duration
+	mutex    sync.RWMutex
+	statuses map[string]*Status
+	// serializes the checks, so that the consumers are told of the changes in order
+	checkMutex sync.Mutex
+	consumers  []func(name string, up bool)
+	once       sync.Once
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewWatcher returns a new Watcher.
+func NewWatcher(config *Config) *Watcher {
+	w := &Watcher{
+		probes:   config.Probes,
+		interval: config.Interval,
+		timeout:  config.Timeout,
+		statuses: make(map[string]*Status, len(config.Probes)),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if w.interval <= 0 {
+		w.interval = defaultInterval
+	}
+
+	if w.timeout <= 0 {
+		w.timeout = defaultTimeout
+	}
+
+	now := time.Now()
+
+	for name := range w.probes {
+		w.statuses[name] = &Status{Up: true, Since: now}
+	}
+
+	return w
+}
+
+// OnChange calls the consumer whenever a dependency goes down or up again, once its status is updated.
+func (w *Watcher) OnChange(consumer func(name string, up bool)) {
+	w.checkMutex.Lock()
+	defer w.checkMutex.Unlock()
+
+	w.consumers = append(w.consumers, consumer)
+}
+
+// Start checking the dependencies in the background, starting right away.
+func (w *Watcher) Start() {
+	go func() {
+		defer close(w.done)
+
+		w.Check()
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.Check()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the watcher and wait for the current check to finish.
+func (w *Watcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+}
+
+// Check probes all the dependencies at once, and tells the consumers of the changes.
+func (w *Watcher) Check() {
+	w.checkMutex.Lock()
+	defer w.checkMutex.Unlock()
+
+	names := make([]string, 0, len(w.probes))
+	for name := range w.probes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	errs := make([]error, len(names))
+
+	var wg sync.WaitGroup
+
+	for i, name := range names {
+		wg.Add(1)
+
+		go func(i int, probe Probe) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+			defer cancel()
+
+			errs[i] = probe(ctx)
+		}(i, w.probes[name])
+	}
+
+	wg.Wait()
+
+	for i, name := range names {
+		if w.record(name, errs[i]) {
+			w.changed(name, errs[i])
+		}
+	}
+}
+
+// record updates the status of the dependency, and returns whether it went down or up.
+func (w *Watcher) record(name string, err error) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := time.Now()
+	status := w.statuses[name]
+	changed := status.Up != (err == nil)
+
+	updated := &Status{Up: err == nil, Since: status.Since, Checked: now}
+	if changed {
+		updated.Since = now
+	}
+
+	if err != nil {
+		updated.Error = err.Error()
+	}
+
+	w.statuses[name] = updated
+
+	return changed
+}
+
+func (w *Watcher) changed(name string, err error) {
+	if err != nil {
+		logger.Warnf("dependency %s is down: %s", name, err.Error())
+	} else {
+		logger.Infof("dependency %s is up again", name)
+	}
+
+	for _, consumer := range w.consumers {
+		consumer(name, err == nil)
+	}
+}
+
+// Up tells whether the dependency is up. Unknown dependencies are up.
+func (w *Watcher) Up(name string) bool {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	status, ok := w.statuses[name]
+
+	return !ok || status.Up
+}
+
+// Statuses returns the statuses of the dependencies, by name.
+func (w *Watcher) Statuses() map[string]*Status {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	statuses := make(map[string]*Status, len(w.statuses))
+
+	for name, status := range w.statuses {
+		s := *status
+		statuses[name] = &s
+	}
+
+	return statuses
+}
@@ -0,0 +1,164 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/health"
+)
+
+func TestWatcher(t *testing.T) {
+	t.Run("tells the consumers when a dependency goes down and up again", func(t *testing.T) {
+		kms := &mockProbe{}
+		w := health.NewWatcher(&health.Config{Probes: map[string]health.Probe{
+			"kms": kms.probe,
+			"edv": (&mockProbe{}).probe,
+		}})
+
+		var changes []string
+
+		w.OnChange(func(name string, up bool) {
+			require.Equal(t, up, w.Up(name))
+
+			if up {
+				changes = append(changes, name+" up")
+			} else {
+				changes = append(changes, name+" down")
+			}
+		})
+
+		require.True(t, w.Up("kms"))
+
+		w.Check()
+		require.Empty(t, changes)
+
+		kms.fail(errors.New("connection refused"))
+		w.Check()
+		require.Equal(t, []string{"kms down"}, changes)
+		require.False(t, w.Up("kms"))
+		require.True(t, w.Up("edv"))
+
+		statuses := w.Statuses()
+		require.False(t, statuses["kms"].Up)
+		require.Equal(t, "connection refused", statuses["kms"].Error)
+		require.True(t, statuses["edv"].Up)
+
+		w.Check()
+		require.Len(t, changes, 1)
+		require.Equal(t, statuses["kms"].Since, w.Statuses()["kms"].Since)
+
+		kms.fail(nil)
+		w.Check()
+		require.Equal(t, []string{"kms down", "kms up"}, changes)
+		require.Empty(t, w.Statuses()["kms"].Error)
+	})
+
+	t.Run("unknown dependencies are up", func(t *testing.T) {
+		w := health.NewWatcher(&health.Config{})
+		require.True(t, w.Up("op"))
+		require.Empty(t, w.Statuses())
+	})
+
+	t.Run("probes in the background until stopped", func(t *testing.T) {
+		kms := &mockProbe{err: errors.New("test")}
+		w := health.NewWatcher(&health.Config{
+			Probes:   map[string]health.Probe{"kms": kms.probe},
+			Interval: time.Millisecond,
+		})
+
+		down := make(chan struct{})
+		once := sync.Once{}
+
+		w.OnChange(func(string, bool) {
+			once.Do(func() { close(down) })
+		})
+
+		w.Start()
+
+		select {
+		case <-down:
+		case <-time.After(time.Second):
+			require.Fail(t, "the dependency was not probed")
+		}
+
+		w.Stop()
+		w.Stop()
+		require.False(t, w.Up("kms"))
+	})
+
+	t.Run("bounds the probes by the timeout", func(t *testing.T) {
+		w := health.NewWatcher(&health.Config{
+			Probes: map[string]health.Probe{"op": func(ctx context.Context) error {
+				<-ctx.Done()
+
+				return ctx.Err()
+			}},
+			Timeout: time.Millisecond,
+		})
+
+		w.Check()
+		require.False(t, w.Up("op"))
+	})
+}
+
+func TestCheckURL(t *testing.T) {
+	require.Equal(t, "https://edv.example.com:4455/healthcheck",
+		health.CheckURL("https://edv.example.com:4455/encrypted-data-vaults"))
+	require.Equal(t, "%/healthcheck", health.CheckURL("%"))
+}
+
+func TestHTTPProbe(t *testing.T) {
+	status := http.StatusOK
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/healthcheck", r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	probe := health.HTTPProbe(http.DefaultClient, server.URL+"/healthcheck")
+	require.NoError(t, probe(context.Background()))
+
+	// the dependency answers, if not with its health
+	status = http.StatusNotFound
+	require.NoError(t, probe(context.Background()))
+
+	status = http.StatusServiceUnavailable
+	err := probe(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "status 503")
+
+	require.Error(t, health.HTTPProbe(http.DefaultClient, "http://localhost:0")(context.Background()))
+	require.Error(t, health.HTTPProbe(http.DefaultClient, "%")(context.Background()))
+}
+
+type mockProbe struct {
+	mutex sync.Mutex
+	err   error
+}
+
+func (p *mockProbe) fail(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.err = err
+}
+
+func (p *mockProbe) probe(context.Context) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.err
+}
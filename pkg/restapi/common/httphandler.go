@@ -10,16 +10,25 @@ import (
 	"net/http"
 )
 
-// NewHTTPHandler returns instance of HTTPHandler which can be used to handle http requests.
-func NewHTTPHandler(path, method string, handle http.HandlerFunc) *HTTPHandler {
-	return &HTTPHandler{path: path, method: method, handle: handle}
+// NewHTTPHandler returns instance of HTTPHandler which can be used to handle http requests. The optional spec
+// describes the handler in the OpenAPI document of the REST API.
+func NewHTTPHandler(path, method string, handle http.HandlerFunc, spec ...*OperationSpec) *HTTPHandler {
+	h := &HTTPHandler{path: path, method: method, handle: handle}
+
+	if len(spec) > 0 {
+		h.spec = spec[0]
+	}
+
+	return h
 }
 
 // HTTPHandler contains REST API handling details which can be used to build routers for the given path.
 type HTTPHandler struct {
-	path   string
-	method string
-	handle http.HandlerFunc
+	path     string
+	method   string
+	handle   http.HandlerFunc
+	spec     *OperationSpec
+	versions []int
 }
 
 // Path returns http request path.
@@ -36,3 +45,21 @@ func (h *HTTPHandler) Method() string {
 func (h *HTTPHandler) Handle() http.HandlerFunc {
 	return h.handle
 }
+
+// Spec returns the description of the operation, nil if not described.
+func (h *HTTPHandler) Spec() *OperationSpec {
+	return h.spec
+}
+
+// Versions returns the versions of the API the handler serves, all of them if empty.
+func (h *HTTPHandler) Versions() []int {
+	return h.versions
+}
+
+// WithVersions restricts the handler to the versions of the API, e.g. when a later version changes its contract
+// and is served by another handler of the same path.
+func (h *HTTPHandler) WithVersions(versions ...int) *HTTPHandler {
+	h.versions = versions
+
+	return h
+}
@@ -28,6 +28,7 @@ func TestNewHTTPHandler(t *testing.T) {
 	require.Equal(t, path, handler.Path())
 	require.Equal(t, method, handler.Method())
 	require.NotNil(t, handler.Handle())
+	require.Nil(t, handler.Spec())
 
 	go handler.Handle()(nil, nil)
 
@@ -38,3 +39,14 @@ func TestNewHTTPHandler(t *testing.T) {
 		t.Fatal("handler function timed out")
 	}
 }
+
+func TestNewHTTPHandler_Spec(t *testing.T) {
+	spec := &common.OperationSpec{
+		Summary: "sample",
+		Params:  []common.Param{common.QueryParam("id", "sample id")},
+	}
+
+	handler := common.NewHTTPHandler("/sample-path", http.MethodGet, nil, spec)
+	require.Equal(t, spec, handler.Spec())
+	require.Equal(t, common.Param{Name: "id", In: common.InQuery, Description: "sample id"}, spec.Params[0])
+}
@@ -0,0 +1,159 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package jobs
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	defaultWorkers  = 4
+	defaultCapacity = 100
+)
+
+var logger = log.New("edge-agent/jobs")
+
+// ErrQueueFull is returned when a job is enqueued while the queue is at capacity.
+var ErrQueueFull = errors.New("job queue is full")
+
+// ErrQueueStopped is returned when a job is enqueued after the queue was stopped.
+var ErrQueueStopped = errors.New("job queue is stopped")
+
+// Job is a unit of background work.
+type Job func()
+
+// Config holds the configuration for a Queue.
+type Config struct {
+	// Workers is the number of jobs run at once. Defaults to 4.
+	Workers int
+	// Capacity is the number of jobs that may wait for a worker. Defaults to 100.
+	Capacity int
+}
+
+// Queue runs jobs in the background on a bounded pool of workers.
+type Queue struct {
+	jobs    chan Job
+	workers int
+	mutex   sync.RWMutex
+	stopped bool
+	// paused is closed once the queue resumes, nil while it runs
+	paused chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewQueue returns a new Queue.
+func NewQueue(config *Config) *Queue {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	capacity := config.Capacity
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+
+	return &Queue{
+		jobs:    make(chan Job, capacity),
+		workers: workers,
+	}
+}
+
+// Start the workers.
+func (q *Queue) Start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+
+		go func() {
+			defer q.wg.Done()
+
+			for job := range q.jobs {
+				q.waitResumed()
+				run(job)
+			}
+		}()
+	}
+}
+
+// Enqueue schedules the job. It does not block: ErrQueueFull is returned if every worker is busy
+// and the queue is at capacity.
+func (q *Queue) Enqueue(job Job) error {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	if q.stopped {
+		return ErrQueueStopped
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Pause the workers: the jobs enqueued wait until the queue resumes, and the jobs already running complete. It is
+// a no-op once the queue is stopped.
+func (q *Queue) Pause() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// the stopped queue drains the jobs enqueued
+	if q.paused == nil && !q.stopped {
+		q.paused = make(chan struct{})
+	}
+}
+
+// Resume the workers paused.
+func (q *Queue) Resume() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.paused != nil {
+		close(q.paused)
+		q.paused = nil
+	}
+}
+
+// Stop accepting jobs and wait for the workers to finish the jobs already enqueued, resuming the queue if paused.
+func (q *Queue) Stop() {
+	q.once.Do(func() {
+		q.mutex.Lock()
+		q.stopped = true
+		close(q.jobs)
+		q.mutex.Unlock()
+
+		q.Resume()
+		q.wg.Wait()
+	})
+}
+
+func (q *Queue) waitResumed() {
+	q.mutex.RLock()
+	paused := q.paused
+	q.mutex.RUnlock()
+
+	if paused != nil {
+		<-paused
+	}
+}
+
+// run keeps a panicking job from taking its worker down with it.
+func run(job Job) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	job()
+}
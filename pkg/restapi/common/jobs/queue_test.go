@@ -0,0 +1,144 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package jobs_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/jobs"
+)
+
+func TestQueue(t *testing.T) {
+	t.Run("runs every job with bounded concurrency", func(t *testing.T) {
+		q := jobs.NewQueue(&jobs.Config{Workers: 2, Capacity: 10})
+		q.Start()
+
+		var running, peak, done int32
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+
+			require.NoError(t, q.Enqueue(func() {
+				defer wg.Done()
+
+				n := atomic.AddInt32(&running, 1)
+
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+
+				atomic.AddInt32(&done, 1)
+				atomic.AddInt32(&running, -1)
+			}))
+		}
+
+		wg.Wait()
+		q.Stop()
+
+		require.Equal(t, int32(10), atomic.LoadInt32(&done))
+		require.LessOrEqual(t, atomic.LoadInt32(&peak), int32(2))
+	})
+
+	t.Run("error if the queue is full", func(t *testing.T) {
+		q := jobs.NewQueue(&jobs.Config{Workers: 1, Capacity: 1})
+
+		require.NoError(t, q.Enqueue(func() {}))
+
+		err := q.Enqueue(func() {})
+		require.True(t, errors.Is(err, jobs.ErrQueueFull))
+
+		q.Start()
+		q.Stop()
+	})
+
+	t.Run("stop finishes the enqueued jobs", func(t *testing.T) {
+		q := jobs.NewQueue(&jobs.Config{})
+
+		var done int32
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, q.Enqueue(func() {
+				atomic.AddInt32(&done, 1)
+			}))
+		}
+
+		q.Start()
+		q.Stop()
+		q.Stop()
+
+		require.Equal(t, int32(5), atomic.LoadInt32(&done))
+
+		err := q.Enqueue(func() {})
+		require.True(t, errors.Is(err, jobs.ErrQueueStopped))
+	})
+
+	t.Run("pauses the jobs until resumed", func(t *testing.T) {
+		q := jobs.NewQueue(&jobs.Config{Workers: 2})
+		q.Pause()
+		q.Pause()
+		q.Start()
+
+		var done int32
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, q.Enqueue(func() {
+				atomic.AddInt32(&done, 1)
+			}))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		require.Zero(t, atomic.LoadInt32(&done))
+
+		q.Resume()
+		q.Resume()
+
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt32(&done) == 3
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("stop resumes the queue and finishes the enqueued jobs", func(t *testing.T) {
+		q := jobs.NewQueue(&jobs.Config{Workers: 1})
+		q.Pause()
+		q.Start()
+
+		var done int32
+
+		require.NoError(t, q.Enqueue(func() {
+			atomic.AddInt32(&done, 1)
+		}))
+
+		q.Stop()
+		require.Equal(t, int32(1), atomic.LoadInt32(&done))
+
+		q.Pause()
+		q.Resume()
+	})
+
+	t.Run("survives a panicking job", func(t *testing.T) {
+		q := jobs.NewQueue(&jobs.Config{Workers: 1})
+		q.Start()
+
+		ran := make(chan struct{})
+
+		require.NoError(t, q.Enqueue(func() { panic("test") }))
+		require.NoError(t, q.Enqueue(func() { close(ran) }))
+
+		<-ran
+		q.Stop()
+	})
+}
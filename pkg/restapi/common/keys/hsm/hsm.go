@@ -0,0 +1,32 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package hsm provides the ciphers of the keys held in an HSM, through its PKCS#11 library, so that the keys never
+// exist in the memory of the agent. The agent must be built with the pkcs11 build tag, which requires cgo.
+package hsm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupported is returned by Open if the agent is built without the pkcs11 build tag.
+var ErrUnsupported = errors.New("the agent is built without PKCS#11 support: build it with the pkcs11 build tag")
+
+// Config selects the token of the HSM holding the keys.
+type Config struct {
+	// Library is the path to the PKCS#11 library of the HSM.
+	Library string
+	// TokenLabel selects the token.
+	TokenLabel string
+	// PIN logs in to the token.
+	PIN string
+}
+
+// keyID identifies the key of the token with the label, after RFC 7512.
+func keyID(tokenLabel, label string) string {
+	return fmt.Sprintf("pkcs11:token=%s;object=%s;type=secret-key", tokenLabel, label)
+}
@@ -0,0 +1,32 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package hsm
+
+import (
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+)
+
+// HSM is a PKCS#11 token.
+type HSM struct{}
+
+// Open returns ErrUnsupported: the agent is built without the pkcs11 build tag.
+func Open(*Config) (*HSM, error) {
+	return nil, ErrUnsupported
+}
+
+// Cipher returns ErrUnsupported.
+func (h *HSM) Cipher(string) (keys.Cipher, error) {
+	return nil, ErrUnsupported
+}
+
+// Close is a no-op.
+func (h *HSM) Close() error {
+	return nil
+}
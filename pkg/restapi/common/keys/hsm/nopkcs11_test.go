@@ -0,0 +1,28 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package hsm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys/hsm"
+)
+
+func TestOpen(t *testing.T) {
+	_, err := hsm.Open(&hsm.Config{Library: "/usr/lib/softhsm/libsofthsm2.so", TokenLabel: "agent"})
+	require.True(t, errors.Is(err, hsm.ErrUnsupported))
+
+	_, err = (&hsm.HSM{}).Cipher("cookies")
+	require.True(t, errors.Is(err, hsm.ErrUnsupported))
+	require.NoError(t, (&hsm.HSM{}).Close())
+}
@@ -0,0 +1,105 @@
+//go:build pkcs11
+// +build pkcs11
+
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package hsm
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+)
+
+// HSM is a PKCS#11 token.
+type HSM struct {
+	ctx        *crypto11.Context
+	tokenLabel string
+}
+
+// Open logs in to the token of the HSM.
+func Open(config *Config) (*HSM, error) {
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       config.Library,
+		TokenLabel: config.TokenLabel,
+		Pin:        config.PIN,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hsm token %s: %w", config.TokenLabel, err)
+	}
+
+	return &HSM{ctx: ctx, tokenLabel: config.TokenLabel}, nil
+}
+
+// Cipher returns the AES-GCM cipher of the AES key of the token with the label. The sealed data is prefixed with
+// its random nonce, as with keys.NewAESGCM.
+func (h *HSM) Cipher(label string) (keys.Cipher, error) {
+	key, err := h.ctx.FindKey(nil, []byte(label))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find hsm key %s: %w", label, err)
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("hsm key %s not found", label)
+	}
+
+	aead, err := key.NewGCM()
+	if err != nil {
+		return nil, fmt.Errorf("failed to init aes-gcm with hsm key %s: %w", label, err)
+	}
+
+	return &gcm{aead: aead, id: keyID(h.tokenLabel, label)}, nil
+}
+
+// Close logs out of the token.
+func (h *HSM) Close() error {
+	return h.ctx.Close()
+}
+
+type gcm struct {
+	aead cipher.AEAD
+	id   string
+}
+
+func (c *gcm) Seal(plaintext, additionalData []byte) (sealed []byte, err error) {
+	nonce := make([]byte, c.aead.NonceSize())
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// crypto11 panics if the hsm fails to encrypt
+	defer func() {
+		if r := recover(); r != nil {
+			sealed, err = nil, fmt.Errorf("failed to encrypt with hsm key %s: %v", c.id, r)
+		}
+	}()
+
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *gcm) Open(sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, keys.ErrInvalidSealed
+	}
+
+	plaintext, err := c.aead.Open(nil, sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with hsm key %s: %w", c.id, err)
+	}
+
+	return plaintext, nil
+}
+
+func (c *gcm) ID() string {
+	return c.id
+}
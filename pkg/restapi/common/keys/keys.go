@@ -0,0 +1,101 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package keys abstracts the secret keys protecting the session cookies and the records of the agent at rest, so
+// that they are held either in the memory of the agent or in an HSM, which never reveals them.
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidSealed is returned when opening data too short to have been sealed.
+var ErrInvalidSealed = errors.New("invalid sealed data")
+
+// Cipher encrypts and authenticates data under a secret key.
+type Cipher interface {
+	// Seal encrypts and authenticates the plaintext, and authenticates the additional data.
+	Seal(plaintext, additionalData []byte) ([]byte, error)
+	// Open decrypts the sealed plaintext, and authenticates it with the additional data.
+	Open(sealed, additionalData []byte) ([]byte, error)
+	// ID identifies the key without revealing it.
+	ID() string
+}
+
+// Provider provides the ciphers of the keys it holds, by label.
+type Provider interface {
+	Cipher(label string) (Cipher, error)
+}
+
+// Software provides the ciphers of keys held in memory, by label.
+type Software map[string][]byte
+
+// Cipher returns the AES-GCM cipher of the key with the label.
+func (s Software) Cipher(label string) (Cipher, error) {
+	key, found := s[label]
+	if !found {
+		return nil, fmt.Errorf("key %s not found", label)
+	}
+
+	return NewAESGCM(key)
+}
+
+// NewAESGCM returns the AES-GCM cipher of the key held in memory. The key must be 16, 24 or 32 bytes long. The
+// sealed data is prefixed with its random nonce.
+func NewAESGCM(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init aes-gcm: %w", err)
+	}
+
+	sum := sha256.Sum256(append([]byte("edge-agent aes-gcm key\x00"), key...))
+
+	return &aesGCM{aead: aead, id: hex.EncodeToString(sum[:16])}, nil
+}
+
+type aesGCM struct {
+	aead cipher.AEAD
+	id   string
+}
+
+func (c *aesGCM) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *aesGCM) Open(sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, ErrInvalidSealed
+	}
+
+	plaintext, err := c.aead.Open(nil, sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+func (c *aesGCM) ID() string {
+	return c.id
+}
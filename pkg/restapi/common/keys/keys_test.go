@@ -0,0 +1,93 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package keys_test
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+)
+
+func TestNewAESGCM(t *testing.T) {
+	t.Run("seals and opens the data", func(t *testing.T) {
+		k := key(t)
+
+		cipher, err := keys.NewAESGCM(k)
+		require.NoError(t, err)
+
+		sealed, err := cipher.Seal([]byte("data"), []byte("ad"))
+		require.NoError(t, err)
+		require.NotContains(t, string(sealed), "data")
+
+		again, err := cipher.Seal([]byte("data"), []byte("ad"))
+		require.NoError(t, err)
+		require.NotEqual(t, sealed, again)
+
+		data, err := cipher.Open(sealed, []byte("ad"))
+		require.NoError(t, err)
+		require.Equal(t, "data", string(data))
+
+		same, err := keys.NewAESGCM(k)
+		require.NoError(t, err)
+		require.Equal(t, cipher.ID(), same.ID())
+		require.NotContains(t, cipher.ID(), string(k))
+	})
+
+	t.Run("error if the sealed data is not authentic", func(t *testing.T) {
+		cipher, err := keys.NewAESGCM(key(t))
+		require.NoError(t, err)
+
+		sealed, err := cipher.Seal([]byte("data"), []byte("ad"))
+		require.NoError(t, err)
+
+		_, err = cipher.Open(sealed, []byte("other"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to decrypt")
+
+		other, err := keys.NewAESGCM(key(t))
+		require.NoError(t, err)
+		require.NotEqual(t, cipher.ID(), other.ID())
+
+		_, err = other.Open(sealed, []byte("ad"))
+		require.Error(t, err)
+
+		_, err = cipher.Open([]byte("short"), nil)
+		require.True(t, errors.Is(err, keys.ErrInvalidSealed))
+	})
+
+	t.Run("error if the key is invalid", func(t *testing.T) {
+		_, err := keys.NewAESGCM([]byte("short"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid encryption key")
+	})
+}
+
+func TestSoftware_Cipher(t *testing.T) {
+	software := keys.Software{"cookies": key(t)}
+
+	cipher, err := software.Cipher("cookies")
+	require.NoError(t, err)
+	require.NotEmpty(t, cipher.ID())
+
+	_, err = software.Cipher("records")
+	require.EqualError(t, err, "key records not found")
+}
+
+func key(t *testing.T) []byte {
+	t.Helper()
+
+	k := make([]byte, 32)
+
+	_, err := rand.Read(k)
+	require.NoError(t, err)
+
+	return k
+}
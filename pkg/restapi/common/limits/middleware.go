@@ -0,0 +1,277 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+var logger = log.New("edge-agent/limits")
+
+var (
+	// ErrBodyTooLarge is returned by the reads of the request bodies exceeding their limit.
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrReadTimeout is returned by the reads of the request bodies sent slower than their read timeout.
+	ErrReadTimeout = errors.New("request body read timeout")
+)
+
+// Limits bound the requests of a group of routes. The zero values are unlimited.
+type Limits struct {
+	// MaxBodyBytes is the size of the largest request body, answered with 413 beyond.
+	MaxBodyBytes int64
+	// MaxHeaderBytes is the size of the largest request line and headers, answered with 431 beyond.
+	MaxHeaderBytes int
+	// ReadTimeout is the time the clients have to send the request bodies, answered with 408 beyond.
+	ReadTimeout time.Duration
+	// WriteTimeout is the deadline of the contexts of the requests, which the handlers and their calls to the
+	// other services are bound by.
+	WriteTimeout time.Duration
+}
+
+// Route is a group of routes, by path prefix, with its own limits.
+type Route struct {
+	Prefix string
+	// Limits of the routes in place of the default ones. The routes are unlimited if nil.
+	Limits *Limits
+}
+
+// Config of the limits of the routes.
+type Config struct {
+	// Default limits of the routes matching no prefix. The routes are unlimited if nil.
+	Default *Limits
+	// Routes with their own limits. The longest prefix matching the path of a request wins.
+	Routes []*Route
+}
+
+// Middleware bounds the size of the requests and the time the clients take to send them, answering the requests
+// beyond their limits with the error response of the handlers. The handlers failing to read a request body
+// beyond its limit have their response replaced with the error of the limit.
+func Middleware(config *Config) common.Middleware {
+	routes := make([]*Route, len(config.Routes))
+	copy(routes, config.Routes)
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limits := config.Default
+
+			for _, route := range routes {
+				if strings.HasPrefix(r.URL.Path, route.Prefix) {
+					limits = route.Limits
+
+					break
+				}
+			}
+
+			if limits == nil {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			serve(limits, next, w, r)
+		})
+	}
+}
+
+func serve(limits *Limits, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if limits.MaxHeaderBytes > 0 && headerSize(r) > limits.MaxHeaderBytes {
+		reject(w, r, http.StatusRequestHeaderFieldsTooLarge,
+			"request headers exceed %d bytes", limits.MaxHeaderBytes)
+
+		return
+	}
+
+	if limits.MaxBodyBytes > 0 && r.ContentLength > limits.MaxBodyBytes {
+		reject(w, r, http.StatusRequestEntityTooLarge, "%s: exceeds %d bytes", ErrBodyTooLarge, limits.MaxBodyBytes)
+
+		return
+	}
+
+	body := &limitedBody{body: r.Body, max: limits.MaxBodyBytes}
+	if limits.ReadTimeout > 0 {
+		body.deadline = time.Now().Add(limits.ReadTimeout)
+	}
+
+	r.Body = body
+
+	if limits.WriteTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), limits.WriteTimeout)
+		defer cancel()
+
+		r = r.WithContext(ctx)
+	}
+
+	lw := &limitedWriter{ResponseWriter: w, request: r, body: body, limits: limits}
+
+	next.ServeHTTP(lw, r)
+
+	// the handler gave up on the body without answering
+	if !lw.wroteHeader && lw.rejected() {
+		lw.WriteHeader(http.StatusOK)
+	}
+}
+
+// headerSize is the size of the request line and headers, as sent by the client.
+func headerSize(r *http.Request) int {
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto)
+
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value)
+		}
+	}
+
+	return size
+}
+
+// reject answers with the error of the limit, and closes the connection rather than reading the rest of the
+// request.
+func reject(w http.ResponseWriter, r *http.Request, status int, msg string, args ...interface{}) {
+	logger.Warnf("rejected request %s %s: %s", r.Method, r.URL.Path, fmt.Sprintf(msg, args...))
+
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Content-Type", "application/json")
+	common.WriteErrorResponsef(w, logger, status, msg, args...)
+}
+
+// limitedBody fails the reads beyond the size limit or the read deadline. A read blocked on a client that sends
+// nothing is left to the timeouts of the server.
+type limitedBody struct {
+	body io.ReadCloser
+	// max is the size limit of the body, unlimited if zero
+	max      int64
+	read     int64
+	deadline time.Time
+	mutex    sync.Mutex
+	err      error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if b.expired() {
+		b.err = ErrReadTimeout
+
+		return 0, b.err
+	}
+
+	// reads one byte past the limit, to tell a body of the size of the limit from a larger one
+	if b.max > 0 && int64(len(p)) > b.max-b.read+1 {
+		p = p[:b.max-b.read+1]
+	}
+
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+
+	if b.max > 0 && b.read > b.max {
+		b.err = ErrBodyTooLarge
+
+		return n - int(b.read-b.max), b.err
+	}
+
+	if err == nil && b.expired() {
+		b.err = ErrReadTimeout
+
+		return n, b.err
+	}
+
+	return n, err
+}
+
+func (b *limitedBody) expired() bool {
+	return !b.deadline.IsZero() && time.Now().After(b.deadline)
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// failure returns the limit the reads of the body ran into, if any.
+func (b *limitedBody) failure() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.err
+}
+
+// limitedWriter replaces the response of the handler with the error of the limit its request ran into.
+type limitedWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	body        *limitedBody
+	limits      *Limits
+	wroteHeader bool
+	discard     bool
+}
+
+func (w *limitedWriter) rejected() bool {
+	return w.body.failure() != nil
+}
+
+func (w *limitedWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+
+	switch err := w.body.failure(); {
+	case errors.Is(err, ErrBodyTooLarge):
+		w.discard = true
+		reject(w.ResponseWriter, w.request, http.StatusRequestEntityTooLarge,
+			"%s: exceeds %d bytes", ErrBodyTooLarge, w.limits.MaxBodyBytes)
+	case errors.Is(err, ErrReadTimeout):
+		w.discard = true
+		reject(w.ResponseWriter, w.request, http.StatusRequestTimeout,
+			"%s: the body was not received within %s", ErrReadTimeout, w.limits.ReadTimeout)
+	default:
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.discard {
+		return len(b), nil
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps the streams of the handlers flowing.
+func (w *limitedWriter) Flush() {
+	if w.discard {
+		return
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package limits_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/limits"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Run("lets the requests within their limits through", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{
+			Default: &limits.Limits{MaxBodyBytes: 5, MaxHeaderBytes: 1024, ReadTimeout: time.Minute},
+		})(echo())
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345")))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "12345", w.Body.String())
+	})
+
+	t.Run("413 if the declared body is too large", func(t *testing.T) {
+		called := false
+		handler := limits.Middleware(&limits.Config{Default: &limits.Limits{MaxBodyBytes: 5}})(
+			http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true }))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123456")))
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		require.Equal(t, "close", w.Header().Get("Connection"))
+		require.Contains(t, errorMessage(t, w), "request body too large")
+		require.False(t, called)
+	})
+
+	t.Run("413 in place of the response of the handler reading a body too large", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{Default: &limits.Limits{MaxBodyBytes: 5}})(echo())
+
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123456"))
+		r.ContentLength = -1
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		require.Contains(t, errorMessage(t, w), "exceeds 5 bytes")
+	})
+
+	t.Run("413 if the handler answers nothing once the body is too large", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{Default: &limits.Limits{MaxBodyBytes: 2}})(
+			http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				_, err := ioutil.ReadAll(r.Body)
+				require.True(t, errors.Is(err, limits.ErrBodyTooLarge))
+			}))
+
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123"))
+		r.ContentLength = -1
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("408 if the client sends the body too slowly", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{Default: &limits.Limits{ReadTimeout: 10 * time.Millisecond}})(
+			echo())
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", &slowReader{
+			chunks: []string{"1", "2", "3"},
+			delay:  20 * time.Millisecond,
+		}))
+		require.Equal(t, http.StatusRequestTimeout, w.Code)
+		require.Contains(t, errorMessage(t, w), "request body read timeout")
+	})
+
+	t.Run("431 if the headers are too large", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{Default: &limits.Limits{MaxHeaderBytes: 64}})(echo())
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Large", strings.Repeat("a", 64))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusRequestHeaderFieldsTooLarge, w.Code)
+	})
+
+	t.Run("bounds the handlers by the write timeout", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{Default: &limits.Limits{WriteTimeout: time.Millisecond}})(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				common.WriteErrorResponsef(w, &noopLogger{}, http.StatusGatewayTimeout, "%s", r.Context().Err())
+			}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("applies the limits of the longest matching prefix", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{
+			Default: &limits.Limits{MaxBodyBytes: 1},
+			Routes: []*limits.Route{
+				{Prefix: "/oidc", Limits: &limits.Limits{MaxBodyBytes: 2}},
+				{Prefix: "/oidc/wallet/attachments", Limits: &limits.Limits{MaxBodyBytes: 10}},
+				{Prefix: "/notifications"},
+			},
+		})(echo())
+
+		for path, status := range map[string]int{
+			"/device":                      http.StatusRequestEntityTooLarge,
+			"/oidc/userinfo":               http.StatusRequestEntityTooLarge,
+			"/oidc/wallet/attachments/abc": http.StatusOK,
+			"/notifications":               http.StatusOK,
+		} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader("12345")))
+			require.Equal(t, status, w.Code, path)
+		}
+	})
+
+	t.Run("unlimited without limits", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{})(echo())
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345")))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("keeps the streams flowing", func(t *testing.T) {
+		handler := limits.Middleware(&limits.Config{Default: &limits.Limits{MaxBodyBytes: 1}})(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, ok := w.(http.Flusher)
+				require.True(t, ok)
+
+				_, err := w.Write([]byte("data: 1\n\n"))
+				require.NoError(t, err)
+				f.Flush()
+			}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/notifications", nil))
+		require.True(t, w.Flushed)
+		require.Equal(t, "data: 1\n\n", w.Body.String())
+	})
+}
+
+func TestNewServer(t *testing.T) {
+	srv := limits.NewServer(":8080", http.NotFoundHandler(), &limits.ServerConfig{
+		ReadHeaderTimeout: time.Second,
+		IdleTimeout:       time.Minute,
+		MaxHeaderBytes:    4096,
+	})
+	require.Equal(t, ":8080", srv.Addr)
+	require.Equal(t, time.Second, srv.ReadHeaderTimeout)
+	require.Equal(t, time.Minute, srv.IdleTimeout)
+	require.Equal(t, 4096, srv.MaxHeaderBytes)
+
+	srv = limits.NewServer(":8080", http.NotFoundHandler(), nil)
+	require.Zero(t, srv.ReadHeaderTimeout)
+}
+
+// echo answers with the body of the request, or with 400 if it cannot be read.
+func echo() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			common.WriteErrorResponsef(w, &noopLogger{}, http.StatusBadRequest, "invalid request: %s", err)
+
+			return
+		}
+
+		_, err = w.Write(body)
+		if err != nil {
+			panic(err)
+		}
+	})
+}
+
+func errorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	resp := &common.ErrorResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+
+	return resp.Message
+}
+
+// slowReader sends its chunks after a delay each.
+type slowReader struct {
+	chunks []string
+	delay  time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+
+	time.Sleep(r.delay)
+
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+
+	return n, nil
+}
+
+type noopLogger struct{}
+
+func (l *noopLogger) Errorf(string, ...interface{}) {}
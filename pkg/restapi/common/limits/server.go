@@ -0,0 +1,36 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package limits
+
+import (
+	"net/http"
+	"time"
+)
+
+// ServerConfig bounds the connections of the clients, protecting the server from the clients holding them open
+// with slow requests. The zero values are unlimited.
+type ServerConfig struct {
+	// ReadHeaderTimeout is the time the clients have to send the request line and headers.
+	ReadHeaderTimeout time.Duration
+	// IdleTimeout is the time the idle keep-alive connections are kept open for.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes is the size of the largest request headers the server reads. Defaults to 1 MB.
+	MaxHeaderBytes int
+}
+
+// NewServer returns a server of the handler bounded by the config.
+func NewServer(addr string, handler http.Handler, config *ServerConfig) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: handler}
+
+	if config != nil {
+		srv.ReadHeaderTimeout = config.ReadHeaderTimeout
+		srv.IdleTimeout = config.IdleTimeout
+		srv.MaxHeaderBytes = config.MaxHeaderBytes
+	}
+
+	return srv
+}
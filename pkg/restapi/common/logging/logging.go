@@ -0,0 +1,221 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package logging writes the log lines of the edge-core loggers as text or JSON, with the tokens, secrets and,
+// in debug output, the user subs redacted.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Formats of the log lines.
+const (
+	// FormatText is the format of the default edge-core logger.
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+const (
+	timeFormat = "2006/01/02 15:04:05"
+	// the frames of the loggers are skipped to find the caller of a logger.
+	maxCallerFrames = 10
+)
+
+// nolint:gochecknoglobals // the packages of the loggers
+var loggerPackages = []string{
+	"github.com/trustbloc/edge-core/pkg/log.",
+	"github.com/trustbloc/edge-core/pkg/internal/logging/",
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/logging.",
+}
+
+// Config configures the log lines.
+type Config struct {
+	// Format is FormatText or FormatJSON. Defaults to FormatText.
+	Format string
+	// Output of the log lines. Defaults to the standard output.
+	Output io.Writer
+	// Sampling drops the repetitions of the same messages. Every line is logged if nil.
+	Sampling *SamplingConfig
+}
+
+// Provider is an edge-core logger provider writing redacted log lines, at the levels set with log.SetLevel.
+type Provider struct {
+	json    bool
+	out     io.Writer
+	sampler *sampler
+	// serializes the writes of the lines
+	mutex sync.Mutex
+	now   func() time.Time
+}
+
+// NewProvider returns a new Provider.
+func NewProvider(config *Config) (*Provider, error) {
+	p := &Provider{out: config.Output, now: time.Now}
+
+	switch config.Format {
+	case "", FormatText:
+	case FormatJSON:
+		p.json = true
+	default:
+		return nil, fmt.Errorf("invalid log format '%s': must be %s or %s", config.Format, FormatText, FormatJSON)
+	}
+
+	if p.out == nil {
+		p.out = os.Stdout
+	}
+
+	if config.Sampling != nil {
+		p.sampler = newSampler(config.Sampling)
+	}
+
+	return p, nil
+}
+
+// Initialize makes the edge-core loggers write their lines with a Provider. It must be called before the first
+// line is logged, as the loggers keep the provider of their first line.
+func Initialize(config *Config) error {
+	p, err := NewProvider(config)
+	if err != nil {
+		return err
+	}
+
+	log.Initialize(p)
+
+	return nil
+}
+
+// GetLogger returns the logger of the module.
+func (p *Provider) GetLogger(module string) log.Logger {
+	return &logger{provider: p, module: module}
+}
+
+type line struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Module  string `json:"module"`
+	Caller  string `json:"caller,omitempty"`
+	Message string `json:"msg"`
+}
+
+func (p *Provider) write(level log.Level, module, format string, args []interface{}) {
+	if !log.IsEnabledFor(module, level) || !p.sampler.sample(level, module, format, p.now()) {
+		return
+	}
+
+	msg := Redact(fmt.Sprintf(format, args...))
+	if level == log.DEBUG {
+		msg = RedactSubs(msg)
+	}
+
+	l := &line{
+		Time:    p.now().UTC().Format(timeFormat),
+		Level:   log.ParseString(level),
+		Module:  module,
+		Message: msg,
+	}
+
+	if log.IsCallerInfoEnabled(module, level) {
+		l.Caller = caller()
+	}
+
+	var out []byte
+
+	if p.json {
+		// a line of strings always marshals
+		out, _ = json.Marshal(l)
+	} else {
+		at := ""
+		if l.Caller != "" {
+			at = "- " + l.Caller + " "
+		}
+
+		// the format of the default edge-core logger
+		out = []byte(fmt.Sprintf(" [%s] %s UTC %s-> %s %s", l.Module, l.Time, at, l.Level, l.Message))
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, err := p.out.Write(append(out, '\n'))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write log line: %s\n", err.Error())
+	}
+}
+
+// caller returns the function calling the logger.
+func caller() string {
+	pcs := make([]uintptr, maxCallerFrames)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+
+	for {
+		frame, more := frames.Next()
+		if !inLogger(frame.Function) {
+			return filepath.Base(frame.Function)
+		}
+
+		if !more {
+			return ""
+		}
+	}
+}
+
+func inLogger(function string) bool {
+	for _, pkg := range loggerPackages {
+		if strings.HasPrefix(function, pkg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type logger struct {
+	provider *Provider
+	module   string
+}
+
+// Fatalf logs a CRITICAL line and exits.
+func (l *logger) Fatalf(msg string, args ...interface{}) {
+	l.provider.write(log.CRITICAL, l.module, msg, args)
+	os.Exit(1)
+}
+
+// Panicf logs a CRITICAL line and panics.
+func (l *logger) Panicf(msg string, args ...interface{}) {
+	l.provider.write(log.CRITICAL, l.module, msg, args)
+	panic(Redact(fmt.Sprintf(msg, args...)))
+}
+
+// Debugf logs a DEBUG line, with the user subs redacted.
+func (l *logger) Debugf(msg string, args ...interface{}) {
+	l.provider.write(log.DEBUG, l.module, msg, args)
+}
+
+// Infof logs an INFO line.
+func (l *logger) Infof(msg string, args ...interface{}) {
+	l.provider.write(log.INFO, l.module, msg, args)
+}
+
+// Warnf logs a WARNING line.
+func (l *logger) Warnf(msg string, args ...interface{}) {
+	l.provider.write(log.WARNING, l.module, msg, args)
+}
+
+// Errorf logs an ERROR line.
+func (l *logger) Errorf(msg string, args ...interface{}) {
+	l.provider.write(log.ERROR, l.module, msg, args)
+}
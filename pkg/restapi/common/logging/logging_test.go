@@ -0,0 +1,103 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/logging"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+func TestProvider(t *testing.T) {
+	log.SetLevel("logging-test", log.DEBUG)
+	defer log.SetLevel("logging-test", log.INFO)
+
+	t.Run("writes redacted lines in the edge-core format", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		logger := provider(t, &logging.Config{Output: out}).GetLogger("logging-test")
+
+		logger.Infof("sent %s to user %s", "Bearer b3BhcXVl", "alice-1")
+
+		require.Regexp(t, `^ \[logging-test\] \d{4}/\d\d/\d\d \d\d:\d\d:\d\d UTC `+
+			`- logging_test.TestProvider.func1 -> INFO sent Bearer \[REDACTED\] to user alice-1\n$`, out.String())
+	})
+
+	t.Run("writes redacted lines in JSON", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		logger := provider(t, &logging.Config{Format: logging.FormatJSON, Output: out}).GetLogger("logging-test")
+
+		logger.Debugf("refreshed tokens of user %s: refresh_token=%s", "alice-1", "xyz")
+
+		line := map[string]string{}
+		require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+		require.Equal(t, "DEBUG", line["level"])
+		require.Equal(t, "logging-test", line["module"])
+		require.Equal(t, "logging_test.TestProvider.func2", line["caller"])
+		require.Regexp(t, `^refreshed tokens of user sub-[0-9a-f]{8}: refresh_token=\[REDACTED\]$`, line["msg"])
+
+		_, err := time.Parse("2006/01/02 15:04:05", line["time"])
+		require.NoError(t, err)
+	})
+
+	t.Run("writes the lines of the enabled levels only", func(t *testing.T) {
+		log.SetLevel("logging-test", log.WARNING)
+		defer log.SetLevel("logging-test", log.DEBUG)
+
+		out := &bytes.Buffer{}
+		logger := provider(t, &logging.Config{Output: out}).GetLogger("logging-test")
+
+		logger.Infof("hidden")
+		logger.Warnf("shown")
+		logger.Errorf("shown")
+
+		require.Equal(t, 2, strings.Count(out.String(), "shown"))
+		require.NotContains(t, out.String(), "hidden")
+	})
+
+	t.Run("samples the repeated messages", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		logger := provider(t, &logging.Config{
+			Output:   out,
+			Sampling: &logging.SamplingConfig{Initial: 2, Thereafter: 3, Period: time.Hour},
+		}).GetLogger("logging-test")
+
+		for i := 1; i <= 10; i++ {
+			logger.Infof("line %d", i)
+			logger.Errorf("error %d", i)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Len(t, lines, 4+10, "lines 1, 2, 5 and 8, and every error")
+
+		for _, i := range []string{"line 1\n", "line 2\n", "line 5\n", "line 8\n"} {
+			require.Contains(t, out.String(), i)
+		}
+	})
+
+	t.Run("error if the format is unknown", func(t *testing.T) {
+		_, err := logging.NewProvider(&logging.Config{Format: "xml"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid log format 'xml'")
+
+		require.Error(t, logging.Initialize(&logging.Config{Format: "xml"}))
+	})
+}
+
+func provider(t *testing.T, config *logging.Config) *logging.Provider {
+	t.Helper()
+
+	p, err := logging.NewProvider(config)
+	require.NoError(t, err)
+
+	return p
+}
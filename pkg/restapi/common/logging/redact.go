@@ -0,0 +1,105 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+const redacted = "[REDACTED]"
+
+type rule struct {
+	pattern *regexp.Regexp
+	replace func(match []string) string
+}
+
+// nolint:gochecknoglobals // compiled once
+var (
+	secretRules = []*rule{
+		// the bearer tokens of the Authorization headers
+		{
+			pattern: regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`),
+			replace: func(m []string) string { return m[1] + redacted },
+		},
+		// JWTs, like the id_tokens
+		{
+			pattern: regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+			replace: func([]string) string { return redacted },
+		},
+		// the tokens and secrets of JSON documents, forms and headers
+		{
+			pattern: regexp.MustCompile(`(?i)((?:access|refresh|id)_?token|secret_?share|client_?secret|` +
+				`hub-kms-secret|password|code_verifier)("?\s*[:=]\s*"?)([^\s"&,;}]+)`),
+			replace: func(m []string) string { return m[1] + m[2] + redacted },
+		},
+	}
+
+	subRules = []*rule{
+		// sub=<sub>, sub: <sub> and "sub":"<sub>", and the login hints identifying the users likewise
+		{
+			pattern: regexp.MustCompile(`(?i)\b((?:user_?)?sub|login_hint)("?\s*[:=]\s*"?)([^\s"&,;}]+)`),
+			replace: func(m []string) string { return m[1] + m[2] + pseudonym(m[3]) },
+		},
+		// "user <sub>", for the subs looking like identifiers rather than words
+		{
+			pattern: regexp.MustCompile(`(?i)\b((?:user_?)?sub|user)(\s+)([^\s",;:]*[0-9|@][^\s",;:]*)`),
+			replace: func(m []string) string { return m[1] + m[2] + pseudonym(m[3]) },
+		},
+	}
+
+	// the pseudonyms are keyed for the lifetime of the process, so that they cannot be reversed by hashing the
+	// subs of known users
+	pseudonymKey = randomKey()
+)
+
+// Redact replaces the access, refresh and id tokens, the secret shares and the other secrets of the message
+// with [REDACTED].
+func Redact(msg string) string {
+	return apply(secretRules, msg)
+}
+
+// RedactSubs replaces the user subs of the message with pseudonyms, which are the same for the same sub until
+// the agent restarts, so that the lines of a user can still be told apart.
+func RedactSubs(msg string) string {
+	return apply(subRules, msg)
+}
+
+func apply(rules []*rule, msg string) string {
+	for _, r := range rules {
+		msg = r.pattern.ReplaceAllStringFunc(msg, func(match string) string {
+			return r.replace(r.pattern.FindStringSubmatch(match))
+		})
+	}
+
+	return msg
+}
+
+func pseudonym(sub string) string {
+	mac := hmac.New(sha256.New, pseudonymKey)
+	_, _ = mac.Write([]byte(sub))
+
+	const length = 8
+
+	return "sub-" + hex.EncodeToString(mac.Sum(nil))[:length]
+}
+
+func randomKey() []byte {
+	const size = 32
+
+	key := make([]byte, size)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return key
+}
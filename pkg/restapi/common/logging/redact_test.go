@@ -0,0 +1,56 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/logging"
+)
+
+func TestRedact(t *testing.T) {
+	for msg, expected := range map[string]string{
+		"authorization: Bearer b3BhcXVl":                        "authorization: Bearer [REDACTED]",
+		"Authorization=bearer opaque.token-1~":                  "Authorization=bearer [REDACTED]",
+		"id_token eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJhYmMifQ.c2ln": "id_token [REDACTED]",
+		`{"access_token":"abc","refresh_token":"def"}`:          `{"access_token":"[REDACTED]","refresh_token":"[REDACTED]"}`,
+		`{"walletSecretShare": "c2hhcmU="}`:                     `{"walletSecretShare": "[REDACTED]"}`,
+		"grant_type=refresh_token&refresh_token=xyz&client_secret=s3cr3t": "grant_type=refresh_token&" +
+			"refresh_token=[REDACTED]&client_secret=[REDACTED]",
+		"Hub-Kms-Secret: c2hhcmU=":               "Hub-Kms-Secret: [REDACTED]",
+		"failed to fetch user tokens: not found": "failed to fetch user tokens: not found",
+		"rotated vault keys of user 123 in 5ms":  "rotated vault keys of user 123 in 5ms",
+	} {
+		require.Equal(t, expected, logging.Redact(msg), msg)
+	}
+}
+
+func TestRedactSubs(t *testing.T) {
+	pseudonym := regexp.MustCompile(`sub-[0-9a-f]{8}`)
+
+	for msg, expected := range map[string]string{
+		"onboarded user 6f1c2d3e-4b5a-6978-8a9b-0c1d2e3f4a5b in 2s": "onboarded user # in 2s",
+		"login of user auth0|5f7c8ec7c33c6c004bbafe82":              "login of user #",
+		"/login?login_hint=alice%40example.com&prompt=login":        "/login?login_hint=#&prompt=login",
+		"unlocked sub=alice":             "unlocked sub=#",
+		`{"sub":"alice","name":"Alice"}`: `{"sub":"#","name":"Alice"}`,
+		"userSub: 42":                    "userSub: #",
+		"failed to fetch user data":      "failed to fetch user data",
+		"sub of the user":                "sub of the user",
+	} {
+		require.Equal(t, expected, pseudonym.ReplaceAllString(logging.RedactSubs(msg), "#"), msg)
+	}
+
+	t.Run("pseudonymizes the same sub the same way", func(t *testing.T) {
+		first := logging.RedactSubs("user alice@example.com")
+		require.Equal(t, first, logging.RedactSubs("user alice@example.com"))
+		require.NotEqual(t, first, logging.RedactSubs("user bob@example.com"))
+		require.NotContains(t, first, "alice")
+	})
+}
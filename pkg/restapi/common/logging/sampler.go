@@ -0,0 +1,81 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const defaultSamplingPeriod = time.Second
+
+// SamplingConfig logs the first Initial lines of each message of a module and level in each period, then every
+// Thereafter-th line. The errors are never sampled.
+type SamplingConfig struct {
+	Initial int
+	// Thereafter is the sampling rate past the initial lines. The lines past the initial lines are dropped if zero.
+	Thereafter int
+	// Period defaults to 1s.
+	Period time.Duration
+}
+
+type sampler struct {
+	initial    int
+	thereafter int
+	period     time.Duration
+	mutex      sync.Mutex
+	// the messages are the formats of the log calls, so there are as many counters as log calls at most
+	counters map[string]*counter
+}
+
+type counter struct {
+	since time.Time
+	lines int
+}
+
+func newSampler(config *SamplingConfig) *sampler {
+	s := &sampler{
+		initial:    config.Initial,
+		thereafter: config.Thereafter,
+		period:     config.Period,
+		counters:   make(map[string]*counter),
+	}
+
+	if s.period <= 0 {
+		s.period = defaultSamplingPeriod
+	}
+
+	return s
+}
+
+// sample reports whether the line is logged. Every line is logged by a nil sampler.
+func (s *sampler) sample(level log.Level, module, format string, now time.Time) bool {
+	if s == nil || level <= log.ERROR {
+		return true
+	}
+
+	key := log.ParseString(level) + " " + module + " " + format
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, found := s.counters[key]
+	if !found || now.Sub(c.since) >= s.period {
+		c = &counter{since: now}
+		s.counters[key] = c
+	}
+
+	c.lines++
+
+	if c.lines <= s.initial {
+		return true
+	}
+
+	return s.thereafter > 0 && (c.lines-s.initial)%s.thereafter == 0
+}
@@ -0,0 +1,339 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package maintenance switches the agent to maintenance mode at runtime, so that its dependencies are upgraded
+// without killing the sessions of the users: the logins are refused with a 503 page, the sessions keep working
+// unless configured otherwise, and the onboarding jobs pause until the maintenance ends.
+package maintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// StoreName is the name of the store of the mode, shared by the instances of the agent.
+	StoreName = "edgeagent_maintenance"
+	modeKey   = "mode"
+
+	defaultRetryAfter      = 5 * time.Minute
+	defaultRefreshInterval = 10 * time.Second
+	defaultMessage         = "The wallet is under maintenance. Please try again later."
+)
+
+var logger = log.New("edge-agent/maintenance")
+
+// nolint:gochecknoglobals // constant
+var defaultPage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Maintenance</title></head>
+<body><h1>Maintenance</h1><p>{{.Message}}</p></body>
+</html>
+`))
+
+// Mode is the maintenance mode of the agent.
+type Mode struct {
+	Enabled bool `json:"enabled"`
+	// Message tells the users why the agent is under maintenance. Defaults to a generic message.
+	Message string `json:"message,omitempty"`
+	// RetryAfter is the number of seconds the clients are told to retry after, in the Retry-After header of the 503
+	// responses. Defaults to the RetryAfter of the Config.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+// Config holds the configuration of a Service.
+type Config struct {
+	// Storage keeps the mode, so that it applies to the instances of the agent sharing it.
+	Storage storage.Provider
+	// BlockSessions also refuses the requests of the users logged in, not only the logins.
+	BlockSessions bool
+	// RetryAfter is the delay the clients are told to retry after, unless the mode sets it. Defaults to 5m.
+	RetryAfter time.Duration
+	// Page is the html/template of the 503 page served to the browsers, executed with the Mode. Defaults to a
+	// page showing the message of the mode.
+	Page string
+	// Interval between the reloads of the mode set by the other instances. Defaults to 10s.
+	Interval time.Duration
+}
+
+// Service holds the maintenance mode of the agent. A nil Service is never under maintenance.
+type Service struct {
+	store         storage.Store
+	blockSessions bool
+	retryAfter    time.Duration
+	page          *template.Template
+	interval      time.Duration
+	mutex         sync.RWMutex
+	mode          Mode
+	listeners     []func(enabled bool)
+	// serializes the changes of mode, so that the listeners are called in order
+	changes sync.Mutex
+	once    sync.Once
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New returns a Service with the mode loaded from the storage.
+func New(config *Config) (*Service, error) {
+	s := &Service{
+		blockSessions: config.BlockSessions,
+		retryAfter:    config.RetryAfter,
+		page:          defaultPage,
+		interval:      config.Interval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	if s.retryAfter <= 0 {
+		s.retryAfter = defaultRetryAfter
+	}
+
+	if s.interval <= 0 {
+		s.interval = defaultRefreshInterval
+	}
+
+	if config.Page != "" {
+		page, err := template.New("maintenance").Parse(config.Page)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance page: %w", err)
+		}
+
+		s.page = page
+	}
+
+	var err error
+
+	s.store, err = store.Open(config.Storage, StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open maintenance store: %w", err)
+	}
+
+	mode, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mode = *mode
+
+	return s, nil
+}
+
+// Start reloading the mode in the background, so that the mode set on another instance applies to this one.
+func (s *Service) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Refresh()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop reloading the mode.
+func (s *Service) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
+
+// Refresh reloads the mode. The current mode is kept if it cannot be loaded.
+func (s *Service) Refresh() {
+	mode, err := s.load()
+	if err != nil {
+		logger.Warnf("keeping the current maintenance mode: %s", err.Error())
+
+		return
+	}
+
+	s.apply(mode)
+}
+
+// Mode returns the current mode.
+func (s *Service) Mode() *Mode {
+	if s == nil {
+		return &Mode{}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	mode := s.mode
+
+	return &mode
+}
+
+// Enabled tells whether the agent is under maintenance.
+func (s *Service) Enabled() bool {
+	return s.Mode().Enabled
+}
+
+// Set the mode of every instance of the agent sharing the storage.
+func (s *Service) Set(mode *Mode) error {
+	if mode.RetryAfter < 0 {
+		return errors.New("negative retry-after")
+	}
+
+	err := store.Save(s.store, modeKey, mode)
+	if err != nil {
+		return fmt.Errorf("failed to save maintenance mode: %w", err)
+	}
+
+	s.apply(mode)
+
+	return nil
+}
+
+// OnChange calls the listener with whether the agent is under maintenance, now and whenever it enters or leaves
+// the maintenance.
+func (s *Service) OnChange(listener func(enabled bool)) {
+	if s == nil {
+		listener(false)
+
+		return
+	}
+
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	s.mutex.Lock()
+	s.listeners = append(s.listeners, listener)
+	enabled := s.mode.Enabled
+	s.mutex.Unlock()
+
+	listener(enabled)
+}
+
+// Refuse writes the 503 response of the maintenance: the page to the browsers, and an error to the API clients.
+func (s *Service) Refuse(w http.ResponseWriter, r *http.Request) {
+	mode := s.Mode()
+
+	if mode.Message == "" {
+		mode.Message = defaultMessage
+	}
+
+	retryAfter := mode.RetryAfter
+	if retryAfter == 0 {
+		retryAfter = int(s.retryAfter.Seconds())
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		common.WriteErrorResponsef(w, logger, http.StatusServiceUnavailable, "%s", mode.Message)
+
+		return
+	}
+
+	var page bytes.Buffer
+
+	err := s.page.Execute(&page, mode)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusServiceUnavailable, "%s", mode.Message)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	_, err = w.Write(page.Bytes())
+	if err != nil {
+		logger.Errorf("failed to write maintenance page: %s", err.Error())
+	}
+}
+
+// Middleware refuses the requests under maintenance if the sessions are blocked, except the requests to the
+// exempt path prefixes, e.g. those of the health check and of the administration of the agent.
+func (s *Service) Middleware(exempt ...string) common.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s == nil || !s.blockSessions || !s.Enabled() || hasPrefix(r.URL.Path, exempt) {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			s.Refuse(w, r)
+		})
+	}
+}
+
+func (s *Service) apply(mode *Mode) {
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	s.mutex.Lock()
+	changed := s.mode.Enabled != mode.Enabled
+	s.mode = *mode
+	listeners := append([]func(bool){}, s.listeners...)
+	s.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if mode.Enabled {
+		logger.Warnf("entered maintenance mode")
+	} else {
+		logger.Infof("left maintenance mode")
+	}
+
+	for _, listener := range listeners {
+		listener(mode.Enabled)
+	}
+}
+
+func (s *Service) load() (*Mode, error) {
+	bits, err := s.store.Get(modeKey)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return &Mode{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch maintenance mode: %w", err)
+	}
+
+	mode := &Mode{}
+
+	err = json.Unmarshal(bits, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal maintenance mode: %w", err)
+	}
+
+	return mode, nil
+}
+
+func hasPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,219 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package maintenance_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/maintenance"
+)
+
+func TestService(t *testing.T) {
+	t.Run("applies the mode to the instances sharing the storage", func(t *testing.T) {
+		provider := memstore.NewProvider()
+
+		first, err := maintenance.New(&maintenance.Config{Storage: provider})
+		require.NoError(t, err)
+		require.False(t, first.Enabled())
+
+		second, err := maintenance.New(&maintenance.Config{Storage: provider})
+		require.NoError(t, err)
+
+		var changes []bool
+
+		second.OnChange(func(enabled bool) {
+			changes = append(changes, enabled)
+		})
+
+		require.NoError(t, first.Set(&maintenance.Mode{Enabled: true, Message: "upgrading the KMS"}))
+		require.True(t, first.Enabled())
+		require.False(t, second.Enabled())
+
+		second.Refresh()
+		require.True(t, second.Enabled())
+		require.Equal(t, "upgrading the KMS", second.Mode().Message)
+
+		second.Refresh()
+		require.NoError(t, first.Set(&maintenance.Mode{}))
+		second.Refresh()
+		require.Equal(t, []bool{false, true, false}, changes)
+
+		third, err := maintenance.New(&maintenance.Config{Storage: provider})
+		require.NoError(t, err)
+		require.False(t, third.Enabled())
+	})
+
+	t.Run("reloads the mode in the background", func(t *testing.T) {
+		provider := memstore.NewProvider()
+
+		first, err := maintenance.New(&maintenance.Config{Storage: provider})
+		require.NoError(t, err)
+
+		second, err := maintenance.New(&maintenance.Config{Storage: provider, Interval: time.Millisecond})
+		require.NoError(t, err)
+
+		second.Start()
+		defer second.Stop()
+
+		require.NoError(t, first.Set(&maintenance.Mode{Enabled: true}))
+		require.Eventually(t, second.Enabled, time.Second, time.Millisecond)
+
+		second.Stop()
+	})
+
+	t.Run("a nil service is never under maintenance", func(t *testing.T) {
+		var s *maintenance.Service
+
+		require.False(t, s.Enabled())
+
+		called := false
+
+		s.OnChange(func(enabled bool) {
+			require.False(t, enabled)
+
+			called = true
+		})
+		require.True(t, called)
+
+		w := httptest.NewRecorder()
+		s.Middleware()(http.HandlerFunc(ok)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("keeps the current mode if it cannot be reloaded", func(t *testing.T) {
+		store := &mockstore.MockStore{Store: map[string][]byte{}}
+
+		s, err := maintenance.New(&maintenance.Config{Storage: &mockstore.Provider{Store: store}})
+		require.NoError(t, err)
+		require.NoError(t, s.Set(&maintenance.Mode{Enabled: true}))
+
+		store.Store["mode"] = []byte("{")
+
+		s.Refresh()
+		require.True(t, s.Enabled())
+	})
+
+	t.Run("error if the mode cannot be loaded or saved", func(t *testing.T) {
+		_, err := maintenance.New(&maintenance.Config{Storage: &mockstore.Provider{ErrCreateStore: errors.New("test")}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open maintenance store")
+
+		_, err = maintenance.New(&maintenance.Config{Storage: &mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{"mode": []byte("{}")},
+			ErrGet: errors.New("test"),
+		}}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch maintenance mode")
+
+		_, err = maintenance.New(&maintenance.Config{Storage: &mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{"mode": []byte("{")},
+		}}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal maintenance mode")
+
+		s, err := maintenance.New(&maintenance.Config{Storage: &mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{},
+			ErrPut: errors.New("test"),
+		}}})
+		require.NoError(t, err)
+
+		err = s.Set(&maintenance.Mode{Enabled: true})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save maintenance mode")
+		require.False(t, s.Enabled())
+
+		require.EqualError(t, s.Set(&maintenance.Mode{RetryAfter: -1}), "negative retry-after")
+	})
+
+	t.Run("error if the page is invalid", func(t *testing.T) {
+		_, err := maintenance.New(&maintenance.Config{Storage: memstore.NewProvider(), Page: "{{.Message"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid maintenance page")
+	})
+}
+
+func TestService_Refuse(t *testing.T) {
+	t.Run("serves the page to the browsers", func(t *testing.T) {
+		s, err := maintenance.New(&maintenance.Config{
+			Storage:    memstore.NewProvider(),
+			RetryAfter: time.Hour,
+			Page:       "<p>Back soon: {{.Message}}</p>",
+		})
+		require.NoError(t, err)
+		require.NoError(t, s.Set(&maintenance.Mode{Enabled: true, Message: "<upgrading>"}))
+
+		r := httptest.NewRequest(http.MethodGet, "/login", nil)
+		r.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+		w := httptest.NewRecorder()
+		s.Refuse(w, r)
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, "3600", w.Header().Get("Retry-After"))
+		require.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		require.Equal(t, "<p>Back soon: &lt;upgrading&gt;</p>", w.Body.String())
+	})
+
+	t.Run("serves an error to the API clients", func(t *testing.T) {
+		s, err := maintenance.New(&maintenance.Config{Storage: memstore.NewProvider()})
+		require.NoError(t, err)
+		require.NoError(t, s.Set(&maintenance.Mode{Enabled: true, RetryAfter: 120}))
+
+		w := httptest.NewRecorder()
+		s.Refuse(w, httptest.NewRequest(http.MethodGet, "/login", nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, "120", w.Header().Get("Retry-After"))
+		require.Contains(t, w.Body.String(), "The wallet is under maintenance")
+	})
+}
+
+func TestService_Middleware(t *testing.T) {
+	t.Run("refuses the sessions under maintenance if blocked", func(t *testing.T) {
+		s, err := maintenance.New(&maintenance.Config{Storage: memstore.NewProvider(), BlockSessions: true})
+		require.NoError(t, err)
+
+		handler := s.Middleware("/admin/", "/healthcheck")(http.HandlerFunc(ok))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		require.NoError(t, s.Set(&maintenance.Mode{Enabled: true}))
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, "300", w.Header().Get("Retry-After"))
+
+		for _, path := range []string{"/admin/maintenance", "/healthcheck"} {
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("keeps the sessions working under maintenance by default", func(t *testing.T) {
+		s, err := maintenance.New(&maintenance.Config{Storage: memstore.NewProvider()})
+		require.NoError(t, err)
+		require.NoError(t, s.Set(&maintenance.Mode{Enabled: true}))
+
+		w := httptest.NewRecorder()
+		s.Middleware()(http.HandlerFunc(ok)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func ok(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
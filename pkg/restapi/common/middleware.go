@@ -0,0 +1,43 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common
+
+import (
+	"net/http"
+)
+
+// Middleware decorates a handler with a cross-cutting concern such as authentication, logging or rate limiting.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes the middleware into one. The first middleware is the outermost: it sees the request first.
+func Chain(middleware ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+
+		return next
+	}
+}
+
+// Wrap returns the handlers with their handle func decorated by the middleware, so that the same chain can be
+// applied to every handler set.
+func Wrap(handlers []Handler, middleware ...Middleware) []Handler {
+	if len(middleware) == 0 {
+		return handlers
+	}
+
+	chain := Chain(middleware...)
+	wrapped := make([]Handler, len(handlers))
+
+	for i, h := range handlers {
+		wrapped[i] = NewHTTPHandler(h.Path(), h.Method(), chain(h.Handle()).ServeHTTP, h.Spec()).
+			WithVersions(h.Versions()...)
+	}
+
+	return wrapped
+}
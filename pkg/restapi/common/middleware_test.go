@@ -0,0 +1,82 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+)
+
+func TestWrap(t *testing.T) {
+	t.Run("applies the middleware in order", func(t *testing.T) {
+		var calls []string
+
+		handlers := common.Wrap([]common.Handler{
+			common.NewHTTPHandler("/a", http.MethodGet, func(http.ResponseWriter, *http.Request) {
+				calls = append(calls, "a")
+			}),
+			common.NewHTTPHandler("/b", http.MethodPost, func(http.ResponseWriter, *http.Request) {
+				calls = append(calls, "b")
+			}),
+		}, record("first", &calls), record("second", &calls))
+
+		require.Len(t, handlers, 2)
+		require.Equal(t, "/a", handlers[0].Path())
+		require.Equal(t, http.MethodGet, handlers[0].Method())
+		require.Equal(t, "/b", handlers[1].Path())
+		require.Equal(t, http.MethodPost, handlers[1].Method())
+
+		handlers[0].Handle()(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+		handlers[1].Handle()(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/b", nil))
+
+		require.Equal(t, []string{"first", "second", "a", "first", "second", "b"}, calls)
+	})
+
+	t.Run("middleware can short-circuit the request", func(t *testing.T) {
+		handlers := common.Wrap([]common.Handler{
+			common.NewHTTPHandler("/a", http.MethodGet, func(http.ResponseWriter, *http.Request) {
+				require.FailNow(t, "handler should not be called")
+			}),
+		}, func(http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+		})
+
+		w := httptest.NewRecorder()
+		handlers[0].Handle()(w, httptest.NewRequest(http.MethodGet, "/a", nil))
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("keeps the spec of the handlers", func(t *testing.T) {
+		spec := &common.OperationSpec{Summary: "a"}
+		handlers := common.Wrap([]common.Handler{
+			common.NewHTTPHandler("/a", http.MethodGet, nil, spec),
+		}, record("first", &[]string{}))
+
+		require.Equal(t, spec, handlers[0].Spec())
+	})
+
+	t.Run("returns the handlers as is without middleware", func(t *testing.T) {
+		handlers := []common.Handler{common.NewHTTPHandler("/a", http.MethodGet, nil)}
+		require.Equal(t, handlers, common.Wrap(handlers))
+	})
+}
+
+func record(name string, calls *[]string) common.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
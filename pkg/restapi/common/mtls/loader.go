@@ -0,0 +1,165 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mtls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+var logger = log.New("edge-agent/mtls")
+
+// ClientCertificateFunc returns the client certificate presented in a TLS handshake.
+type ClientCertificateFunc func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+// WithClientCertificate returns a copy of the TLS configuration presenting the certificate returned by getCert
+// to servers requiring mutual TLS.
+func WithClientCertificate(config *tls.Config, getCert ClientCertificateFunc) *tls.Config {
+	if config == nil {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	config = config.Clone()
+	config.GetClientCertificate = getCert
+
+	return config
+}
+
+// CertLoader serves a client certificate from a pair of PEM files. The files are checked on each handshake and
+// the certificate is reloaded once they are rotated, without restarting the agent.
+type CertLoader struct {
+	certFile string
+	keyFile  string
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+}
+
+// NewCertLoader returns a CertLoader of the certificate and key files.
+func NewCertLoader(certFile, keyFile string) (*CertLoader, error) {
+	l := &CertLoader{certFile: certFile, keyFile: keyFile}
+
+	certMod, keyMod, err := l.modTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.load(certMod, keyMod)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// GetClientCertificate returns the current certificate, reloading it first if its files have changed.
+// The previous certificate is kept if the new files cannot be loaded, e.g. while they are being replaced.
+func (l *CertLoader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	certMod, keyMod, err := l.modTimes()
+	if err != nil {
+		logger.Warnf("keeping the current client certificate: %s", err.Error())
+
+		return l.current(), nil
+	}
+
+	l.mutex.RLock()
+	changed := !certMod.Equal(l.certMod) || !keyMod.Equal(l.keyMod)
+	l.mutex.RUnlock()
+
+	if changed {
+		err = l.load(certMod, keyMod)
+		if err != nil {
+			logger.Warnf("keeping the current client certificate: %s", err.Error())
+		}
+	}
+
+	return l.current(), nil
+}
+
+func (l *CertLoader) current() *tls.Certificate {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.cert
+}
+
+func (l *CertLoader) load(certMod, keyMod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.cert = &cert
+	l.certMod = certMod
+	l.keyMod = keyMod
+
+	return nil
+}
+
+func (l *CertLoader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(l.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to stat client certificate: %w", err)
+	}
+
+	keyInfo, err := os.Stat(l.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to stat client key: %w", err)
+	}
+
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// CertHolder serves a client certificate handed over at runtime, e.g. by a secrets manager.
+type CertHolder struct {
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+}
+
+// NewCertHolder returns a CertHolder of the PEM-encoded certificate and key.
+func NewCertHolder(certPEM, keyPEM []byte) (*CertHolder, error) {
+	h := &CertHolder{}
+
+	err := h.Update(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Update replaces the certificate. The current certificate is kept if the new one cannot be parsed.
+func (h *CertHolder) Update(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.cert = &cert
+
+	return nil
+}
+
+// GetClientCertificate returns the current certificate.
+func (h *CertHolder) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.cert, nil
+}
@@ -0,0 +1,209 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mtls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/mtls"
+)
+
+func TestCertLoader(t *testing.T) {
+	t.Run("reloads the certificate once it is rotated", func(t *testing.T) {
+		dir := tempDir(t)
+		certFile, keyFile := writeKeyPair(t, dir, "first", time.Now().Add(-time.Hour))
+
+		l, err := mtls.NewCertLoader(certFile, keyFile)
+		require.NoError(t, err)
+		require.Equal(t, "first", commonName(t, l.GetClientCertificate))
+
+		writeKeyPair(t, dir, "second", time.Now())
+		require.Equal(t, "second", commonName(t, l.GetClientCertificate))
+	})
+
+	t.Run("keeps the current certificate if the new files are invalid", func(t *testing.T) {
+		dir := tempDir(t)
+		certFile, keyFile := writeKeyPair(t, dir, "first", time.Now().Add(-time.Hour))
+
+		l, err := mtls.NewCertLoader(certFile, keyFile)
+		require.NoError(t, err)
+
+		require.NoError(t, ioutil.WriteFile(certFile, []byte("invalid"), 0600))
+		require.Equal(t, "first", commonName(t, l.GetClientCertificate))
+
+		require.NoError(t, os.Remove(keyFile))
+		require.Equal(t, "first", commonName(t, l.GetClientCertificate))
+	})
+
+	t.Run("error if the files cannot be loaded", func(t *testing.T) {
+		dir := tempDir(t)
+
+		_, err := mtls.NewCertLoader(filepath.Join(dir, "missing.pem"), filepath.Join(dir, "missing.key"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to stat client certificate")
+
+		certFile, _ := writeKeyPair(t, dir, "first", time.Now())
+
+		_, err = mtls.NewCertLoader(certFile, filepath.Join(dir, "missing.key"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to stat client key")
+
+		_, err = mtls.NewCertLoader(certFile, certFile)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to load client certificate")
+	})
+}
+
+func TestCertHolder(t *testing.T) {
+	certPEM, keyPEM := keyPair(t, "first")
+
+	h, err := mtls.NewCertHolder(certPEM, keyPEM)
+	require.NoError(t, err)
+	require.Equal(t, "first", commonName(t, h.GetClientCertificate))
+
+	t.Run("serves the updated certificate", func(t *testing.T) {
+		require.NoError(t, h.Update(keyPair(t, "second")))
+		require.Equal(t, "second", commonName(t, h.GetClientCertificate))
+	})
+
+	t.Run("keeps the current certificate if the new one is invalid", func(t *testing.T) {
+		err := h.Update([]byte("invalid"), keyPEM)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse client certificate")
+		require.Equal(t, "second", commonName(t, h.GetClientCertificate))
+	})
+
+	t.Run("error if the certificate is invalid", func(t *testing.T) {
+		_, err := mtls.NewCertHolder(certPEM, []byte("invalid"))
+		require.Error(t, err)
+	})
+}
+
+func TestWithClientCertificate(t *testing.T) {
+	t.Run("presents the client certificate to the server", func(t *testing.T) {
+		certFile, keyFile := writeKeyPair(t, tempDir(t), "agent", time.Now())
+
+		l, err := mtls.NewCertLoader(certFile, keyFile)
+		require.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, errWrite := w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+			require.NoError(t, errWrite)
+		}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+		server.StartTLS()
+
+		defer server.Close()
+
+		base := server.Client().Transport.(*http.Transport).TLSClientConfig
+		config := mtls.WithClientCertificate(base, l.GetClientCertificate)
+		require.Nil(t, base.GetClientCertificate)
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+
+		response, err := client.Get(server.URL) // nolint:noctx // test
+		require.NoError(t, err)
+
+		defer response.Body.Close() // nolint:errcheck // test
+
+		body, err := ioutil.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.Equal(t, "agent", string(body))
+	})
+
+	t.Run("defaults the tls configuration", func(t *testing.T) {
+		config := mtls.WithClientCertificate(nil, func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{}, nil
+		})
+		require.NotNil(t, config.GetClientCertificate)
+		require.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	})
+}
+
+func commonName(t *testing.T, getCert mtls.ClientCertificateFunc) string {
+	t.Helper()
+
+	cert, err := getCert(&tls.CertificateRequestInfo{})
+	require.NoError(t, err)
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	return parsed.Subject.CommonName
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "mtls")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, os.RemoveAll(dir))
+	})
+
+	return dir
+}
+
+// writeKeyPair writes a self-signed certificate and its key to dir, with the given modification time.
+func writeKeyPair(t *testing.T, dir, cn string, modTime time.Time) (string, string) {
+	t.Helper()
+
+	certPEM, keyPEM := keyPair(t, cn)
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+
+	return certFile, keyFile
+}
+
+// keyPair returns a PEM-encoded self-signed certificate and its key.
+func keyPair(t *testing.T, cn string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
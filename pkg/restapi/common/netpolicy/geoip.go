@@ -0,0 +1,120 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package netpolicy
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// CountryTable is a Locator backed by a table of the networks of each country.
+type CountryTable struct {
+	// the countries of the networks, by prefix length and network address.
+	v4 map[int]map[string]string
+	v6 map[int]map[string]string
+}
+
+// LoadCountries reads a CountryTable from a CSV file of "network,country" records, e.g. "192.0.2.0/24,CA", as
+// exported from a GeoIP database. Empty lines and lines starting with '#' are skipped, as well as a leading header
+// record. The most specific network of an address gives its country.
+func LoadCountries(path string) (*CountryTable, error) {
+	f, err := os.Open(path) // nolint:gosec // path of the GeoIP database set by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			logger.Warnf("failed to close GeoIP database: %s", closeErr.Error())
+		}
+	}()
+
+	return ReadCountries(f)
+}
+
+// ReadCountries reads a CountryTable from "network,country" CSV records, like LoadCountries.
+func ReadCountries(r io.Reader) (*CountryTable, error) {
+	t := &CountryTable{v4: make(map[int]map[string]string), v6: make(map[int]map[string]string)}
+
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GeoIP database: %w", err)
+		}
+
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "network") {
+			continue
+		}
+
+		if len(record) < 2 { // nolint:gomnd // network and country
+			return nil, fmt.Errorf("invalid GeoIP record %d: want network,country", line)
+		}
+
+		networks, err := ParseNetworks(record[:1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid GeoIP record %d: %w", line, err)
+		}
+
+		countries, err := ParseCountries(record[1:2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid GeoIP record %d: %w", line, err)
+		}
+
+		t.add(networks[0], countries[0])
+	}
+
+	return t, nil
+}
+
+// Country returns the country of the most specific network of the table holding the IP address.
+func (t *CountryTable) Country(ip net.IP) (string, bool) {
+	table, bits := t.v6, 8*net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, table, bits = ip4, t.v4, 8*net.IPv4len
+	}
+
+	for ones := bits; ones >= 0; ones-- {
+		networks, found := table[ones]
+		if !found {
+			continue
+		}
+
+		if country, found := networks[ip.Mask(net.CIDRMask(ones, bits)).String()]; found {
+			return country, true
+		}
+	}
+
+	return "", false
+}
+
+func (t *CountryTable) add(network *net.IPNet, country string) {
+	table := t.v6
+	if network.IP.To4() != nil {
+		table = t.v4
+	}
+
+	ones, _ := network.Mask.Size()
+
+	if table[ones] == nil {
+		table[ones] = make(map[string]string)
+	}
+
+	table[ones][network.IP.String()] = country
+}
@@ -0,0 +1,96 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package netpolicy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+var logger = log.New("edge-agent/netpolicy")
+
+// ErrNoLocator is returned by Middleware for a policy restricting countries without a Locator.
+var ErrNoLocator = errors.New("the country restrictions require a GeoIP database")
+
+// Config of the network policy of a group of endpoints.
+type Config struct {
+	// Group names the endpoints in the audit log, e.g. admin.
+	Group  string
+	Policy *Policy
+	// Locator resolves the countries of the clients. Required if the policy restricts countries.
+	Locator Locator
+	// TrustForwardedFor takes the client IP address from the X-Forwarded-For header added by a reverse proxy.
+	TrustForwardedFor bool
+	// Audit records the requests turned away. They are only logged if nil.
+	Audit *audit.Store
+}
+
+type deniedRequest struct {
+	Group   string `json:"group"`
+	IP      string `json:"ip"`
+	Country string `json:"country,omitempty"`
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Reason  string `json:"reason"`
+}
+
+// Middleware turns away with 403 the requests of the clients the policy does not let through, ahead of the
+// handlers, and records them in the audit log.
+func Middleware(config *Config) (common.Middleware, error) {
+	if config.Policy.geofenced() && config.Locator == nil {
+		return nil, ErrNoLocator
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			address := ClientIP(r, config.TrustForwardedFor)
+			ip := net.ParseIP(address)
+
+			var (
+				country string
+				located bool
+			)
+
+			if ip != nil && config.Policy.geofenced() {
+				country, located = config.Locator.Country(ip)
+			}
+
+			reason := config.Policy.check(ip, country, located)
+			if reason == "" {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			denied := &deniedRequest{
+				Group:   config.Group,
+				IP:      address,
+				Country: country,
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Reason:  reason,
+			}
+
+			logger.Warnf("denied %s request %s %s from %s: %s", denied.Group, denied.Method, denied.Path,
+				denied.IP, denied.Reason)
+
+			if config.Audit != nil {
+				err := config.Audit.Record(audit.ActionNetworkDenied, "", "", denied)
+				if err != nil {
+					logger.Errorf("failed to audit denied request from %s: %s", address, err.Error())
+				}
+			}
+
+			common.WriteErrorResponsef(w, logger, http.StatusForbidden, "access denied from this network")
+		})
+	}, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package netpolicy_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/netpolicy"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+)
+
+func TestMiddleware(t *testing.T) {
+	countries, err := netpolicy.ReadCountries(strings.NewReader("192.0.2.0/24,CA\n198.51.100.0/24,US\n"))
+	require.NoError(t, err)
+
+	t.Run("lets through the allowed clients only", func(t *testing.T) {
+		policy := &netpolicy.Policy{
+			Allow: networks(t, "192.0.2.0/24", "198.51.100.0/24", "2001:db8::/32"),
+			Deny:  networks(t, "192.0.2.66"),
+		}
+
+		handler := middleware(t, &netpolicy.Config{Group: "admin", Policy: policy})
+
+		for addr, status := range map[string]int{
+			"192.0.2.1:1234":     http.StatusOK,
+			"[2001:db8::1]:1234": http.StatusOK,
+			"192.0.2.66:1234":    http.StatusForbidden,
+			"203.0.113.1:1234":   http.StatusForbidden,
+			"pipe":               http.StatusForbidden,
+		} {
+			require.Equal(t, status, serve(handler, addr, ""), addr)
+		}
+	})
+
+	t.Run("lets through the clients of the allowed countries only", func(t *testing.T) {
+		handler := middleware(t, &netpolicy.Config{
+			Policy:  &netpolicy.Policy{AllowCountries: []string{"CA"}},
+			Locator: countries,
+		})
+
+		require.Equal(t, http.StatusOK, serve(handler, "192.0.2.1:1234", ""))
+		require.Equal(t, http.StatusForbidden, serve(handler, "198.51.100.1:1234", ""))
+		require.Equal(t, http.StatusForbidden, serve(handler, "203.0.113.1:1234", ""), "unknown country")
+	})
+
+	t.Run("turns away the clients of the denied countries", func(t *testing.T) {
+		handler := middleware(t, &netpolicy.Config{
+			Policy:  &netpolicy.Policy{DenyCountries: []string{"US"}},
+			Locator: countries,
+		})
+
+		require.Equal(t, http.StatusOK, serve(handler, "192.0.2.1:1234", ""))
+		require.Equal(t, http.StatusForbidden, serve(handler, "198.51.100.1:1234", ""))
+		require.Equal(t, http.StatusOK, serve(handler, "203.0.113.1:1234", ""), "unknown country")
+	})
+
+	t.Run("takes the client address from the proxy if trusted", func(t *testing.T) {
+		policy := &netpolicy.Policy{Allow: networks(t, "192.0.2.0/24")}
+
+		handler := middleware(t, &netpolicy.Config{Policy: policy, TrustForwardedFor: true})
+		require.Equal(t, http.StatusOK, serve(handler, "10.0.0.1:1234", "192.0.2.1"))
+		require.Equal(t, http.StatusForbidden, serve(handler, "192.0.2.1:1234", "10.0.0.1"))
+
+		handler = middleware(t, &netpolicy.Config{Policy: policy})
+		require.Equal(t, http.StatusForbidden, serve(handler, "10.0.0.1:1234", "192.0.2.1"))
+	})
+
+	t.Run("audits the requests turned away", func(t *testing.T) {
+		log, err := audit.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		handler := middleware(t, &netpolicy.Config{
+			Group:   "public",
+			Policy:  &netpolicy.Policy{DenyCountries: []string{"US"}},
+			Locator: countries,
+			Audit:   log,
+		})
+
+		require.Equal(t, http.StatusOK, serve(handler, "192.0.2.1:1234", ""))
+		require.Equal(t, http.StatusForbidden, serve(handler, "198.51.100.1:1234", ""))
+
+		entries, err := log.List("")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, audit.ActionNetworkDenied, entries[0].Action)
+
+		details := map[string]string{}
+		require.NoError(t, json.Unmarshal(entries[0].Details, &details))
+		require.Equal(t, map[string]string{
+			"group":   "public",
+			"ip":      "198.51.100.1",
+			"country": "US",
+			"method":  http.MethodGet,
+			"path":    "/oidc/login",
+			"reason":  "denied country",
+		}, details)
+	})
+
+	t.Run("error if the countries cannot be located", func(t *testing.T) {
+		_, err := netpolicy.Middleware(&netpolicy.Config{Policy: &netpolicy.Policy{AllowCountries: []string{"CA"}}})
+		require.True(t, errors.Is(err, netpolicy.ErrNoLocator))
+	})
+}
+
+func middleware(t *testing.T, config *netpolicy.Config) http.Handler {
+	t.Helper()
+
+	m, err := netpolicy.Middleware(config)
+	require.NoError(t, err)
+
+	return m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func serve(handler http.Handler, remoteAddr, forwardedFor string) int {
+	r := httptest.NewRequest(http.MethodGet, "/oidc/login", nil)
+	r.RemoteAddr = remoteAddr
+
+	if forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w.Code
+}
+
+func networks(t *testing.T, values ...string) []*net.IPNet {
+	t.Helper()
+
+	n, err := netpolicy.ParseNetworks(values)
+	require.NoError(t, err)
+
+	return n
+}
@@ -0,0 +1,152 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package netpolicy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Policy restricts the client IP addresses allowed to call a group of endpoints.
+type Policy struct {
+	// Allow lets only the clients of these networks through, if not empty.
+	Allow []*net.IPNet
+	// Deny turns the clients of these networks away, even if allowed.
+	Deny []*net.IPNet
+	// AllowCountries lets only the clients located in these countries through, if not empty. The clients
+	// whose country is unknown are turned away.
+	AllowCountries []string
+	// DenyCountries turns the clients located in these countries away.
+	DenyCountries []string
+}
+
+// Locator resolves the country of IP addresses, as an ISO 3166-1 alpha-2 code.
+type Locator interface {
+	Country(ip net.IP) (string, bool)
+}
+
+// geofenced tells whether the policy restricts the countries of the clients.
+func (p *Policy) geofenced() bool {
+	return len(p.AllowCountries) > 0 || len(p.DenyCountries) > 0
+}
+
+// check returns why the client is turned away, or an empty string if the client is let through.
+func (p *Policy) check(ip net.IP, country string, located bool) string {
+	if ip == nil {
+		return "unknown client address"
+	}
+
+	if contains(p.Deny, ip) {
+		return "denied network"
+	}
+
+	if len(p.Allow) > 0 && !contains(p.Allow, ip) {
+		return "network not allowed"
+	}
+
+	if located && includes(p.DenyCountries, country) {
+		return "denied country"
+	}
+
+	if len(p.AllowCountries) > 0 && (!located || !includes(p.AllowCountries, country)) {
+		return "country not allowed"
+	}
+
+	return ""
+}
+
+// ParseNetworks parses CIDR networks, e.g. 10.0.0.0/8. Single IP addresses are parsed as networks of one address.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network '%s'", v)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s': %w", v, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// ParseCountries parses ISO 3166-1 alpha-2 country codes, e.g. CA.
+func ParseCountries(values []string) ([]string, error) {
+	countries := make([]string, len(values))
+
+	for i, v := range values {
+		code := strings.ToUpper(strings.TrimSpace(v))
+		if len(code) != 2 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return nil, fmt.Errorf("invalid country code '%s'", v)
+		}
+
+		countries[i] = code
+	}
+
+	return countries, nil
+}
+
+// ClientIP returns the IP address of the client of the request. The address is taken from the last entry of the
+// X-Forwarded-For header if trustForwardedFor is true, as added by a reverse proxy in front of the server.
+func ClientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func includes(countries []string, country string) bool {
+	for _, c := range countries {
+		if c == country {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package netpolicy_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/netpolicy"
+)
+
+func TestParseNetworks(t *testing.T) {
+	t.Run("parses networks and addresses", func(t *testing.T) {
+		networks, err := netpolicy.ParseNetworks([]string{"10.0.0.0/8", " 192.0.2.1", "2001:db8::/32", "2001:db8::1"})
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32", "2001:db8::1/128",
+		}, networkStrings(networks))
+	})
+
+	t.Run("error if a network is invalid", func(t *testing.T) {
+		for _, v := range []string{"10.0.0.0/33", "localhost", ""} {
+			_, err := netpolicy.ParseNetworks([]string{v})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid network '"+v+"'")
+		}
+	})
+}
+
+func TestParseCountries(t *testing.T) {
+	countries, err := netpolicy.ParseCountries([]string{"ca", " US "})
+	require.NoError(t, err)
+	require.Equal(t, []string{"CA", "US"}, countries)
+
+	for _, v := range []string{"CAN", "C1", ""} {
+		_, err = netpolicy.ParseCountries([]string{v})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid country code '"+v+"'")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:4321"
+	r.Header.Add("X-Forwarded-For", "198.51.100.1, 198.51.100.2")
+
+	require.Equal(t, "192.0.2.1", netpolicy.ClientIP(r, false))
+	require.Equal(t, "198.51.100.2", netpolicy.ClientIP(r, true))
+
+	r.Header.Add("X-Forwarded-For", "198.51.100.3")
+	require.Equal(t, "198.51.100.3", netpolicy.ClientIP(r, true))
+
+	r.Header.Del("X-Forwarded-For")
+	require.Equal(t, "192.0.2.1", netpolicy.ClientIP(r, true))
+
+	r.RemoteAddr = "pipe"
+	require.Equal(t, "pipe", netpolicy.ClientIP(r, false))
+}
+
+func TestCountryTable(t *testing.T) {
+	t.Run("locates the addresses in their most specific network", func(t *testing.T) {
+		table, err := netpolicy.ReadCountries(strings.NewReader(`network,country_code
+# test networks
+192.0.2.0/24,CA
+192.0.2.128/25,us
+
+2001:db8::/32,FR
+198.51.100.7,DE
+`))
+		require.NoError(t, err)
+
+		for ip, country := range map[string]string{
+			"192.0.2.1":        "CA",
+			"192.0.2.200":      "US",
+			"2001:db8::1":      "FR",
+			"198.51.100.7":     "DE",
+			"::ffff:192.0.2.1": "CA",
+		} {
+			located, found := table.Country(net.ParseIP(ip))
+			require.True(t, found, ip)
+			require.Equal(t, country, located, ip)
+		}
+
+		_, found := table.Country(net.ParseIP("203.0.113.1"))
+		require.False(t, found)
+	})
+
+	t.Run("loads the table from a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "geoip.csv")
+		require.NoError(t, ioutil.WriteFile(path, []byte("192.0.2.0/24,CA\n"), 0600))
+
+		table, err := netpolicy.LoadCountries(path)
+		require.NoError(t, err)
+
+		country, found := table.Country(net.ParseIP("192.0.2.1"))
+		require.True(t, found)
+		require.Equal(t, "CA", country)
+
+		_, err = netpolicy.LoadCountries(filepath.Join(t.TempDir(), "missing.csv"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open GeoIP database")
+	})
+
+	t.Run("error if a record is invalid", func(t *testing.T) {
+		for csv, msg := range map[string]string{
+			"192.0.2.0/24\n":          "invalid GeoIP record 1: want network,country",
+			"192.0.2.0/24,CA\nnet,CA": "invalid GeoIP record 2: invalid network 'net'",
+			"192.0.2.0/24,Canada\n":   "invalid GeoIP record 1: invalid country code 'Canada'",
+			"192.0.2.0/24,\"CA\n":     "failed to read GeoIP database",
+			"network,country\nnet,CA": "invalid GeoIP record 2: invalid network 'net'",
+		} {
+			_, err := netpolicy.ReadCountries(strings.NewReader(csv))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), msg)
+		}
+	})
+}
+
+func networkStrings(networks []*net.IPNet) []string {
+	result := make([]string, len(networks))
+
+	for i, n := range networks {
+		result[i] = n.String()
+	}
+
+	return result
+}
@@ -13,12 +13,17 @@ import (
 )
 
 // Client is capable of formatting authorization requests, exchanging the token grant for an access_token
-// and id_token, and verifying id_tokens.
+// and id_token, and verifying id_tokens. Devices without a browser are authorized with the device
+// authorization grant. Validate checks the client against its provider.
 type Client interface {
-	FormatRequest(state string) string
+	FormatRequest(state string, opts ...oauth2.AuthCodeOption) string
 	Exchange(c context.Context, code string) (*oauth2.Token, error)
+	AuthorizeDevice(c context.Context) (*DeviceAuthorization, error)
+	ExchangeDeviceCode(c context.Context, deviceCode string) (*oauth2.Token, error)
 	VerifyIDToken(c context.Context, oauthToken OAuth2Token) (Claimer, error)
 	UserInfo(ctx context.Context, token *oauth2.Token) (Claimer, error)
+	RefreshToken(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error)
+	Validate(ctx context.Context) error
 }
 
 // OAuth2Token is the oauth2.Token.
@@ -9,11 +9,19 @@ package oidc
 import (
 	"context"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 
 	"github.com/coreos/go-oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/proxy"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
 // Provider provides discovery of OIDC provider endpoints and also verifies id_tokens.
@@ -27,6 +35,19 @@ type Provider interface {
 type ProviderAdapter struct {
 	OP        *oidc.Provider
 	TLSConfig *tls.Config
+	Proxy     proxy.Func
+	// Issuers verify the id_tokens in place of OP, by their issuer, e.g. to refresh the keys of the provider in the
+	// background, or to accept the id_tokens of its previous issuer while it migrates. OP verifies them if empty.
+	Issuers []*TrustedIssuer
+}
+
+// TrustedIssuer is an issuer of the id_tokens accepted, and its signing keys.
+type TrustedIssuer struct {
+	URL  string
+	Keys oidc.KeySet
+	// Algorithms the id_tokens of the issuer are signed with, e.g. those advertised by its discovery document.
+	// Defaults to RS256.
+	Algorithms []string
 }
 
 // Endpoint returns the OIDC endpoints.
@@ -36,7 +57,23 @@ func (o *ProviderAdapter) Endpoint() oauth2.Endpoint {
 
 // Verifier returns an OIDC verifier.
 func (o *ProviderAdapter) Verifier(config *oidc.Config) Verifier {
-	return &verifierAdapter{v: o.OP.Verifier(config)}
+	if len(o.Issuers) == 0 {
+		return &verifierAdapter{v: o.OP.Verifier(config)}
+	}
+
+	v := &issuersVerifier{verifiers: make(map[string]*oidc.IDTokenVerifier, len(o.Issuers))}
+
+	for _, issuer := range o.Issuers {
+		issuerConfig := *config
+
+		if len(issuerConfig.SupportedSigningAlgs) == 0 {
+			issuerConfig.SupportedSigningAlgs = issuer.Algorithms
+		}
+
+		v.verifiers[issuer.URL] = oidc.NewVerifier(issuer.URL, issuer.Keys, &issuerConfig)
+	}
+
+	return v
 }
 
 // UserInfo returns the user's info.
@@ -45,7 +82,7 @@ func (o *ProviderAdapter) UserInfo(ctx context.Context, ts oauth2.TokenSource) (
 		context.WithValue(
 			ctx,
 			oauth2.HTTPClient,
-			&http.Client{Transport: &http.Transport{TLSClientConfig: o.TLSConfig}},
+			&http.Client{Transport: &http.Transport{TLSClientConfig: o.TLSConfig, Proxy: o.Proxy}},
 		),
 		ts,
 	)
@@ -64,6 +101,49 @@ func (v *verifierAdapter) Verify(ctx context.Context, token string) (*oidc.IDTok
 	return v.v.Verify(ctx, token)
 }
 
+// issuersVerifier verifies the id_tokens with the verifier of their issuer.
+type issuersVerifier struct {
+	verifiers map[string]*oidc.IDTokenVerifier
+}
+
+func (v *issuersVerifier) Verify(ctx context.Context, token string) (*oidc.IDToken, error) {
+	issuer, err := unverifiedIssuer(token)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, found := v.verifiers[issuer]
+	if !found {
+		return nil, fmt.Errorf("id_token issued by untrusted issuer %s", issuer)
+	}
+
+	return verifier.Verify(ctx, token)
+}
+
+// unverifiedIssuer returns the issuer claimed by the JWT, which is checked by the verifier of the issuer.
+func unverifiedIssuer(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 { // nolint:gomnd // header, payload and signature
+		return "", errors.New("malformed jwt: expected three parts")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed jwt payload: %w", err)
+	}
+
+	claims := struct {
+		Issuer string `json:"iss"`
+	}{}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal jwt claims: %w", err)
+	}
+
+	return claims.Issuer, nil
+}
+
 type oauth2Config interface {
 	AuthCodeURL(string, ...oauth2.AuthCodeOption) string
 	Exchange(context.Context, string, ...oauth2.AuthCodeOption) (*oauth2.Token, error)
@@ -87,40 +167,79 @@ type BasicClient struct {
 	provider             Provider
 	oauth2ConfigSupplier func() oauth2Config
 	clientID             string
+	clientSecret         string
+	secretMutex          sync.RWMutex
+	scopes               []string
+	deviceAuthURL        string
+	metadata             *Metadata
 	tlsConfig            *tls.Config
+	proxy                proxy.Func
 }
 
 // Config defines configuration for oidc client.
 type Config struct {
-	TLSConfig    *tls.Config
+	TLSConfig *tls.Config
+	// Proxy routes the requests to the provider through an outbound proxy. They connect directly if not set.
+	Proxy        proxy.Func
 	Provider     Provider
 	CallbackURL  string
 	ClientID     string
 	ClientSecret string
 	Scopes       []string
+	// DeviceAuthorizationURL is the device authorization endpoint of the provider. Devices without a browser
+	// cannot log in if not set.
+	DeviceAuthorizationURL string
+	// Metadata is the discovery document of the provider, which Validate checks the client against. Only the
+	// credentials of the client are validated if nil.
+	Metadata *Metadata
 }
 
 // NewClient returns new BasicClient instance.
 func NewClient(config *Config) *BasicClient {
-	return &BasicClient{
-		provider: config.Provider,
-		oauth2ConfigSupplier: func() oauth2Config {
-			return &oauth2ConfigImpl{oc: &oauth2.Config{
-				ClientID:     config.ClientID,
-				ClientSecret: config.ClientSecret,
-				Endpoint:     config.Provider.Endpoint(),
-				RedirectURL:  config.CallbackURL,
-				Scopes:       config.Scopes,
-			}}
-		},
-		clientID:  config.ClientID,
-		tlsConfig: config.TLSConfig,
+	c := &BasicClient{
+		provider:      config.Provider,
+		clientID:      config.ClientID,
+		clientSecret:  config.ClientSecret,
+		scopes:        config.Scopes,
+		deviceAuthURL: config.DeviceAuthorizationURL,
+		metadata:      config.Metadata,
+		tlsConfig:     config.TLSConfig,
+		proxy:         config.Proxy,
+	}
+
+	c.oauth2ConfigSupplier = func() oauth2Config {
+		return &oauth2ConfigImpl{oc: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: c.secret(),
+			Endpoint:     config.Provider.Endpoint(),
+			RedirectURL:  config.CallbackURL,
+			Scopes:       config.Scopes,
+		}}
 	}
+
+	return c
 }
 
-// FormatRequest returns a correctly-formatted OIDC request.
-func (c *BasicClient) FormatRequest(state string) string {
-	return c.oauth2ConfigSupplier().AuthCodeURL(state)
+// SetClientSecret replaces the client secret, e.g. once it is rotated at the provider. The requests already sent
+// keep the previous secret.
+func (c *BasicClient) SetClientSecret(secret string) {
+	c.secretMutex.Lock()
+	defer c.secretMutex.Unlock()
+
+	c.clientSecret = secret
+}
+
+func (c *BasicClient) secret() string {
+	c.secretMutex.RLock()
+	defer c.secretMutex.RUnlock()
+
+	return c.clientSecret
+}
+
+// FormatRequest returns a correctly-formatted OIDC request, with the additional parameters of the options, e.g.
+// the acr_values and max_age of a step-up authentication.
+func (c *BasicClient) FormatRequest(state string, opts ...oauth2.AuthCodeOption) string {
+	return c.oauth2ConfigSupplier().AuthCodeURL(state, opts...)
 }
 
 // Exchange the auth code for the OAuth2 token.
@@ -129,7 +248,7 @@ func (c *BasicClient) Exchange(ctx context.Context, code string) (*oauth2.Token,
 		context.WithValue(
 			ctx,
 			oauth2.HTTPClient,
-			&http.Client{Transport: &http.Transport{TLSClientConfig: c.tlsConfig}},
+			c.httpClient(),
 		),
 		code,
 	)
@@ -144,6 +263,77 @@ func (c *BasicClient) Exchange(ctx context.Context, code string) (*oauth2.Token,
 	return token, nil
 }
 
+// ClientCredentialsToken returns a token of the client itself, obtained with the client credentials grant, e.g. to
+// provision resources ahead of the login of the users.
+func (c *BasicClient) ClientCredentialsToken(ctx context.Context) (*oauth2.Token, error) {
+	config := &clientcredentials.Config{
+		ClientID:     c.clientID,
+		ClientSecret: c.secret(),
+		TokenURL:     c.provider.Endpoint().TokenURL,
+		Scopes:       c.scopes,
+	}
+
+	token, err := config.Token(context.WithValue(ctx, oauth2.HTTPClient, c.httpClient()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch client credentials token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Token exchange grant (RFC 8693).
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+	jwtTokenType           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// ExchangeToken trades the access token for a JWT of the audience with the token exchange grant (RFC 8693), e.g.
+// for the services that cannot validate the opaque access tokens of the provider.
+func (c *BasicClient) ExchangeToken(ctx context.Context, subjectToken, audience string) (*oauth2.Token, error) {
+	config := &clientcredentials.Config{
+		ClientID:     c.clientID,
+		ClientSecret: c.secret(),
+		TokenURL:     c.provider.Endpoint().TokenURL,
+		// the auto-detection would repeat each rejected exchange with the credentials in the body
+		AuthStyle: oauth2.AuthStyleInHeader,
+		EndpointParams: url.Values{
+			"grant_type":           {tokenExchangeGrantType},
+			"subject_token":        {subjectToken},
+			"subject_token_type":   {accessTokenType},
+			"requested_token_type": {jwtTokenType},
+			"audience":             {audience},
+		},
+	}
+
+	token, err := config.Token(context.WithValue(ctx, oauth2.HTTPClient, c.httpClient()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange token for audience %s: %w", audience, err)
+	}
+
+	return token, nil
+}
+
+// RefreshToken exchanges the refresh token of the token for a new token.
+func (c *BasicClient) RefreshToken(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
+	config := &oauth2.Config{
+		ClientID:     c.clientID,
+		ClientSecret: c.secret(),
+		Endpoint:     c.provider.Endpoint(),
+		Scopes:       c.scopes,
+	}
+
+	// the token source only refreshes expired tokens
+	expired := &oauth2.Token{RefreshToken: token.RefreshToken}
+
+	refreshed, err := config.TokenSource(context.WithValue(ctx, oauth2.HTTPClient, c.httpClient()), expired).Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	return refreshed, nil
+}
+
 // VerifyIDToken parses the id_token within the OAuth2 token and verifies it.
 func (c *BasicClient) VerifyIDToken(ctx context.Context, oauthToken OAuth2Token) (Claimer, error) {
 	rawIDToken, found := oauthToken.Extra("id_token").(string)
@@ -8,7 +8,10 @@ package oidc // nolint:testpackage // changing to different package requires exp
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -42,6 +45,33 @@ func TestClient_FormatRequest(t *testing.T) {
 		}).FormatRequest(state)
 		require.Equal(t, expected, result)
 	})
+
+	t.Run("formats request with additional parameters", func(t *testing.T) {
+		result := NewClient(&Config{
+			Provider: &mockOIDCProvider{endpoint: oauth2.Endpoint{AuthURL: "http://test.com/oauth2/authorize"}},
+			ClientID: "client",
+		}).FormatRequest("state", oauth2.SetAuthURLParam("max_age", "0"))
+		require.Contains(t, result, "max_age=0")
+		require.Contains(t, result, "state=state")
+	})
+}
+
+func TestClient_SetClientSecret(t *testing.T) {
+	c := NewClient(&Config{
+		Provider:     &mockOIDCProvider{},
+		ClientID:     "client",
+		ClientSecret: "secret",
+	})
+
+	config, ok := c.oauth2ConfigSupplier().(*oauth2ConfigImpl)
+	require.True(t, ok)
+	require.Equal(t, "secret", config.oc.ClientSecret)
+
+	c.SetClientSecret("rotated")
+
+	config, ok = c.oauth2ConfigSupplier().(*oauth2ConfigImpl)
+	require.True(t, ok)
+	require.Equal(t, "rotated", config.oc.ClientSecret)
 }
 
 func TestClient_Exchange(t *testing.T) {
@@ -101,6 +131,147 @@ func TestClient_Exchange(t *testing.T) {
 	})
 }
 
+func TestClient_ClientCredentialsToken(t *testing.T) {
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "agent" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, "provisioning", r.PostForm.Get("scope"))
+
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+		}))
+	}))
+	defer op.Close()
+
+	newClient := func(secret string) *BasicClient {
+		return NewClient(&Config{
+			Provider:     &mockOIDCProvider{endpoint: oauth2.Endpoint{TokenURL: op.URL + "/token"}},
+			ClientID:     "agent",
+			ClientSecret: secret,
+			Scopes:       []string{"provisioning"},
+		})
+	}
+
+	t.Run("returns the token of the client", func(t *testing.T) {
+		token, err := newClient("secret").ClientCredentialsToken(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "access", token.AccessToken)
+	})
+
+	t.Run("error if the client is not authorized", func(t *testing.T) {
+		_, err := newClient("other").ClientCredentialsToken(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch client credentials token")
+	})
+}
+
+func TestClient_ExchangeToken(t *testing.T) {
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+
+		id, secret, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "agent", id)
+		require.Equal(t, "secret", secret)
+
+		require.Equal(t, tokenExchangeGrantType, r.PostForm.Get("grant_type"))
+		require.Equal(t, accessTokenType, r.PostForm.Get("subject_token_type"))
+		require.Equal(t, jwtTokenType, r.PostForm.Get("requested_token_type"))
+
+		if r.PostForm.Get("subject_token") != "opaque" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, err := w.Write([]byte(`{"error":"invalid_grant"}`))
+			require.NoError(t, err)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":      "jwt-for-" + r.PostForm.Get("audience"),
+			"issued_token_type": jwtTokenType,
+			"token_type":        "Bearer",
+			"expires_in":        300,
+		}))
+	}))
+	defer op.Close()
+
+	client := NewClient(&Config{
+		Provider:     &mockOIDCProvider{endpoint: oauth2.Endpoint{TokenURL: op.URL + "/token"}},
+		ClientID:     "agent",
+		ClientSecret: "secret",
+	})
+
+	t.Run("returns a token of the audience", func(t *testing.T) {
+		token, err := client.ExchangeToken(context.Background(), "opaque", "hub-kms")
+		require.NoError(t, err)
+		require.Equal(t, "jwt-for-hub-kms", token.AccessToken)
+		require.True(t, token.Valid())
+	})
+
+	t.Run("error if the provider rejects the token", func(t *testing.T) {
+		_, err := client.ExchangeToken(context.Background(), "revoked", "hub-kms")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to exchange token for audience hub-kms")
+	})
+}
+
+func TestClient_RefreshToken(t *testing.T) {
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+
+		if r.PostForm.Get("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access",
+			"refresh_token": "next",
+			"token_type":    "Bearer",
+			"expires_in":    300,
+		}))
+	}))
+	defer op.Close()
+
+	c := NewClient(&Config{
+		Provider:     &mockOIDCProvider{endpoint: oauth2.Endpoint{TokenURL: op.URL + "/token"}},
+		ClientID:     "agent",
+		ClientSecret: "secret",
+	})
+
+	t.Run("refreshes the token", func(t *testing.T) {
+		token, err := c.RefreshToken(context.Background(), &oauth2.Token{
+			AccessToken:  "still valid",
+			RefreshToken: "refresh",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "access", token.AccessToken)
+		require.Equal(t, "next", token.RefreshToken)
+	})
+
+	t.Run("error if the refresh token is rejected", func(t *testing.T) {
+		_, err := c.RefreshToken(context.Background(), &oauth2.Token{RefreshToken: "revoked"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to refresh token")
+	})
+}
+
 func TestClient_VerifyIDToken(t *testing.T) {
 	t.Run("verifies token", func(t *testing.T) {
 		expected := &oidc.IDToken{
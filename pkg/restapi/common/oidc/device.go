@@ -0,0 +1,162 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+var logger = log.New("edge-agent/oidc")
+
+// Errors of the device access token requests: https://tools.ietf.org/html/rfc8628#section-3.5.
+var (
+	// ErrAuthorizationPending is returned while the user has not completed the authorization yet.
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown is returned when the device code is polled too often. The polling interval must be
+	// increased by 5 seconds.
+	ErrSlowDown = errors.New("slow_down")
+	// ErrAccessDenied is returned when the user denied the authorization.
+	ErrAccessDenied = errors.New("access_denied")
+	// ErrExpiredToken is returned when the device code expired before the user completed the authorization.
+	ErrExpiredToken = errors.New("expired_token")
+	// ErrDeviceFlowUnsupported is returned when the OIDC provider has no device authorization endpoint.
+	ErrDeviceFlowUnsupported = errors.New("the oidc provider does not support the device authorization grant")
+)
+
+// DeviceAuthorization is the response of the device authorization endpoint:
+// https://tools.ietf.org/html/rfc8628#section-3.2.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// AuthorizeDevice starts the device authorization grant of a user logging in from a device without
+// a browser (RFC 8628).
+func (c *BasicClient) AuthorizeDevice(ctx context.Context) (*DeviceAuthorization, error) {
+	if c.deviceAuthURL == "" {
+		return nil, ErrDeviceFlowUnsupported
+	}
+
+	form := url.Values{"client_id": {c.clientID}}
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx,
+		http.MethodPost, c.deviceAuthURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device authorization request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if secret := c.secret(); secret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(secret))
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request device authorization: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Warnf("failed to close response body: %s", errClose)
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device authorization response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("device authorization endpoint returned status %d: %s", resp.StatusCode, body)
+	}
+
+	authorization := &DeviceAuthorization{}
+
+	err = json.Unmarshal(body, authorization)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device authorization response: %w", err)
+	}
+
+	if authorization.DeviceCode == "" || authorization.UserCode == "" || authorization.VerificationURI == "" {
+		return nil, errors.New("invalid device authorization response")
+	}
+
+	return authorization, nil
+}
+
+// ExchangeDeviceCode polls the token endpoint for the token of an authorized device. ErrAuthorizationPending
+// and ErrSlowDown are returned until the user completes the authorization.
+func (c *BasicClient) ExchangeDeviceCode(ctx context.Context, deviceCode string) (*oauth2.Token, error) {
+	config := &clientcredentials.Config{
+		ClientID:     c.clientID,
+		ClientSecret: c.secret(),
+		TokenURL:     c.provider.Endpoint().TokenURL,
+		// the auto-detection would repeat each pending request with the credentials in the body
+		AuthStyle: oauth2.AuthStyleInHeader,
+		EndpointParams: url.Values{
+			"grant_type":  {deviceCodeGrantType},
+			"device_code": {deviceCode},
+		},
+	}
+
+	token, err := config.Token(context.WithValue(ctx, oauth2.HTTPClient, c.httpClient()))
+	if err != nil {
+		return nil, deviceTokenError(err)
+	}
+
+	if !token.Valid() {
+		return nil, fmt.Errorf("server returned an invalid token")
+	}
+
+	return token, nil
+}
+
+func (c *BasicClient) httpClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: c.tlsConfig, Proxy: c.proxy}}
+}
+
+// deviceTokenError maps the error codes of the token endpoint to the errors of the device access token requests.
+func deviceTokenError(err error) error {
+	var retrieveErr *oauth2.RetrieveError
+
+	if errors.As(err, &retrieveErr) {
+		response := struct {
+			Error string `json:"error"`
+		}{}
+
+		if json.Unmarshal(retrieveErr.Body, &response) == nil {
+			for _, known := range []error{ErrAuthorizationPending, ErrSlowDown, ErrAccessDenied, ErrExpiredToken} {
+				if response.Error == known.Error() {
+					return known
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("failed to exchange device code for token: %w", err)
+}
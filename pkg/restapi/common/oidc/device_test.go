@@ -0,0 +1,179 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // uses the internal mock provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestClient_AuthorizeDevice(t *testing.T) {
+	t.Run("returns the device authorization", func(t *testing.T) {
+		op := newMockDeviceOP(t)
+
+		authorization, err := op.client(t).AuthorizeDevice(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "device", authorization.DeviceCode)
+		require.Equal(t, "WDJB-MJHT", authorization.UserCode)
+		require.Equal(t, "https://op.example.com/device", authorization.VerificationURI)
+		require.Equal(t, 5, authorization.Interval)
+		require.Equal(t, "openid profile", op.scope)
+	})
+
+	t.Run("error if the provider does not support the device flow", func(t *testing.T) {
+		_, err := NewClient(&Config{Provider: &mockOIDCProvider{}}).AuthorizeDevice(context.Background())
+		require.True(t, errors.Is(err, ErrDeviceFlowUnsupported))
+	})
+
+	t.Run("error if the client is not authorized", func(t *testing.T) {
+		op := newMockDeviceOP(t)
+
+		_, err := NewClient(&Config{
+			Provider:               &mockOIDCProvider{},
+			ClientID:               "other",
+			DeviceAuthorizationURL: op.URL + "/device_authorization",
+		}).AuthorizeDevice(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "device authorization endpoint returned status 401")
+	})
+
+	t.Run("error if the response is invalid", func(t *testing.T) {
+		op := newMockDeviceOP(t)
+		op.authorization = map[string]interface{}{"device_code": "device"}
+
+		_, err := op.client(t).AuthorizeDevice(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid device authorization response")
+	})
+
+	t.Run("error if the endpoint cannot be reached", func(t *testing.T) {
+		_, err := NewClient(&Config{
+			Provider:               &mockOIDCProvider{},
+			DeviceAuthorizationURL: "http://localhost:0",
+		}).AuthorizeDevice(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to request device authorization")
+	})
+}
+
+func TestClient_ExchangeDeviceCode(t *testing.T) {
+	t.Run("exchanges the device code for a token", func(t *testing.T) {
+		op := newMockDeviceOP(t)
+
+		token, err := op.client(t).ExchangeDeviceCode(context.Background(), "device")
+		require.NoError(t, err)
+		require.Equal(t, "access", token.AccessToken)
+		require.Equal(t, "refresh", token.RefreshToken)
+		require.Equal(t, "idtoken", token.Extra("id_token"))
+	})
+
+	t.Run("errors of the pending authorizations", func(t *testing.T) {
+		op := newMockDeviceOP(t)
+		client := op.client(t)
+
+		for _, expected := range []error{ErrAuthorizationPending, ErrSlowDown, ErrAccessDenied, ErrExpiredToken} {
+			op.tokenError = expected.Error()
+
+			_, err := client.ExchangeDeviceCode(context.Background(), "device")
+			require.True(t, errors.Is(err, expected))
+		}
+	})
+
+	t.Run("error if the token endpoint fails", func(t *testing.T) {
+		op := newMockDeviceOP(t)
+		op.tokenError = "invalid_grant"
+
+		_, err := op.client(t).ExchangeDeviceCode(context.Background(), "device")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to exchange device code for token")
+		require.Contains(t, err.Error(), "invalid_grant")
+	})
+}
+
+// mockDeviceOP is an OIDC provider with the device authorization and token endpoints.
+type mockDeviceOP struct {
+	*httptest.Server
+	authorization map[string]interface{}
+	tokenError    string
+	scope         string
+}
+
+func newMockDeviceOP(t *testing.T) *mockDeviceOP {
+	t.Helper()
+
+	op := &mockDeviceOP{
+		authorization: map[string]interface{}{
+			"device_code":      "device",
+			"user_code":        "WDJB-MJHT",
+			"verification_uri": "https://op.example.com/device",
+			"expires_in":       1800,
+			"interval":         5,
+		},
+	}
+
+	op.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "agent" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/device_authorization":
+			op.scope = r.PostForm.Get("scope")
+			require.NoError(t, json.NewEncoder(w).Encode(op.authorization))
+		case "/token":
+			require.Equal(t, deviceCodeGrantType, r.PostForm.Get("grant_type"))
+			require.Equal(t, "device", r.PostForm.Get("device_code"))
+
+			if op.tokenError != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				require.NoError(t, json.NewEncoder(w).Encode(map[string]string{"error": op.tokenError}))
+
+				return
+			}
+
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "access",
+				"token_type":    "Bearer",
+				"refresh_token": "refresh",
+				"expires_in":    300,
+				"id_token":      "idtoken",
+			}))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(op.Close)
+
+	return op
+}
+
+func (m *mockDeviceOP) client(t *testing.T) *BasicClient {
+	t.Helper()
+
+	return NewClient(&Config{
+		Provider:               &mockOIDCProvider{endpoint: oauth2.Endpoint{TokenURL: m.URL + "/token"}},
+		ClientID:               "agent",
+		ClientSecret:           "secret",
+		Scopes:                 []string{"openid", "profile"},
+		DeviceAuthorizationURL: m.URL + "/device_authorization",
+	})
+}
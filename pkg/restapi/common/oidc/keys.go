@@ -0,0 +1,321 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	defaultKeysRefreshInterval = 15 * time.Minute
+	defaultKeysMaxStale        = 24 * time.Hour
+	// the id_tokens signed with unknown keys refresh the keys at most this often, so that forged tokens cannot
+	// flood the issuer with requests.
+	minKeysRefreshInterval = 30 * time.Second
+)
+
+var errUnknownKey = errors.New("no key of the id_token issuer verifies the signature")
+
+// KeySetConfig holds the configuration for a KeySet.
+type KeySetConfig struct {
+	// URLs of the JWKS documents of the issuer, e.g. its jwks_uri and the document its next keys are published at
+	// ahead of a rotation.
+	URLs       []string
+	HTTPClient *http.Client
+	// RefreshInterval between the refreshes of the keys in the background. Defaults to 15m.
+	RefreshInterval time.Duration
+	// MaxStale is how long the keys of a document keep verifying signatures once its refreshes fail. Defaults to 24h.
+	MaxStale time.Duration
+}
+
+// KeySetStats are the totals of the refreshes of the keys since the agent started.
+type KeySetStats struct {
+	Sources         []*KeySourceStats `json:"sources"`
+	Refreshes       uint64            `json:"refreshes"`
+	RefreshFailures uint64            `json:"refreshFailures"`
+	// StaleVerifications counts the signatures verified with the keys of a document whose last refresh failed.
+	StaleVerifications uint64 `json:"staleVerifications"`
+}
+
+// KeySourceStats is the state of the keys of a JWKS document.
+type KeySourceStats struct {
+	URL       string     `json:"url"`
+	Keys      int        `json:"keys"`
+	Fetched   *time.Time `json:"fetched,omitempty"`
+	Stale     bool       `json:"stale"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// KeySet caches the signing keys of an issuer of id_tokens and refreshes them in the background, and as soon as a
+// token is signed with an unknown key, so that the keys rotated by the issuer are picked up without restart. The
+// keys of the documents that fail to refresh keep verifying signatures for MaxStale.
+type KeySet struct {
+	sources    []*keySource
+	httpClient *http.Client
+	interval   time.Duration
+	maxStale   time.Duration
+	now        func() time.Time
+	// guards the keys and stats
+	mutex sync.RWMutex
+	stats KeySetStats
+	// serializes the refreshes
+	refreshMutex sync.Mutex
+	refreshed    time.Time
+	stop         chan struct{}
+	once         sync.Once
+	done         chan struct{}
+}
+
+type keySource struct {
+	url     string
+	keys    []jose.JSONWebKey
+	fetched time.Time
+	// err is the failure of the last refresh, nil if it succeeded
+	err error
+}
+
+// NewKeySet returns a new KeySet. The keys are fetched by the first refresh.
+func NewKeySet(config *KeySetConfig) (*KeySet, error) {
+	if len(config.URLs) == 0 {
+		return nil, errors.New("missing jwks url")
+	}
+
+	k := &KeySet{
+		httpClient: config.HTTPClient,
+		interval:   config.RefreshInterval,
+		maxStale:   config.MaxStale,
+		now:        time.Now,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	for _, u := range config.URLs {
+		k.sources = append(k.sources, &keySource{url: u})
+	}
+
+	if k.httpClient == nil {
+		k.httpClient = http.DefaultClient
+	}
+
+	if k.interval <= 0 {
+		k.interval = defaultKeysRefreshInterval
+	}
+
+	if k.maxStale <= 0 {
+		k.maxStale = defaultKeysMaxStale
+	}
+
+	return k, nil
+}
+
+// Start refreshing the keys in the background, starting now.
+func (k *KeySet) Start() {
+	go func() {
+		defer close(k.done)
+
+		ticker := time.NewTicker(k.interval)
+		defer ticker.Stop()
+
+		k.Refresh(context.Background())
+
+		for {
+			select {
+			case <-ticker.C:
+				k.Refresh(context.Background())
+			case <-k.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the refreshes, waiting for the refresh under way.
+func (k *KeySet) Stop() {
+	k.once.Do(func() {
+		close(k.stop)
+		<-k.done
+	})
+}
+
+// Refresh fetches the JWKS documents. The documents that fail to be fetched keep their current keys.
+func (k *KeySet) Refresh(ctx context.Context) {
+	k.refreshMutex.Lock()
+	defer k.refreshMutex.Unlock()
+
+	k.refresh(ctx)
+}
+
+func (k *KeySet) refresh(ctx context.Context) {
+	k.refreshed = k.now()
+
+	for _, source := range k.sources {
+		keys, err := k.fetch(ctx, source.url)
+
+		k.mutex.Lock()
+
+		k.stats.Refreshes++
+		source.err = err
+
+		if err != nil {
+			k.stats.RefreshFailures++
+
+			logger.Warnf("keeping the current keys of %s: %s", source.url, err.Error())
+		} else {
+			source.keys = keys
+			source.fetched = k.now()
+		}
+
+		k.mutex.Unlock()
+	}
+}
+
+func (k *KeySet) fetch(ctx context.Context, u string) ([]jose.JSONWebKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			logger.Warnf("failed to close response body: %s", closeErr.Error())
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks document returned %d", resp.StatusCode)
+	}
+
+	set := &jose.JSONWebKeySet{}
+
+	err = json.NewDecoder(resp.Body).Decode(set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode jwks document: %w", err)
+	}
+
+	// an empty document is rather a fault of the issuer than the revocation of all its keys
+	if len(set.Keys) == 0 {
+		return nil, errors.New("jwks document has no keys")
+	}
+
+	return set.Keys, nil
+}
+
+// VerifySignature verifies the signature of the JWT with the keys of the issuer and returns its payload. The keys
+// are refreshed if none of them signed the JWT, in case the issuer rotated them.
+func (k *KeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt: %w", err)
+	}
+
+	payload, err := k.verify(jws)
+	if !errors.Is(err, errUnknownKey) {
+		return payload, err
+	}
+
+	k.refreshMutex.Lock()
+
+	// the keys may have been refreshed since by another verification
+	if k.now().Sub(k.refreshed) >= minKeysRefreshInterval {
+		k.refresh(ctx)
+	}
+
+	k.refreshMutex.Unlock()
+
+	return k.verify(jws)
+}
+
+func (k *KeySet) verify(jws *jose.JSONWebSignature) ([]byte, error) {
+	keyID := ""
+
+	if len(jws.Signatures) > 0 {
+		keyID = jws.Signatures[0].Header.KeyID
+	}
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	for _, source := range k.sources {
+		if k.now().Sub(source.fetched) > k.maxStale {
+			continue
+		}
+
+		for i := range source.keys {
+			key := &source.keys[i]
+
+			if (keyID != "" && key.KeyID != keyID) || key.Use == "enc" {
+				continue
+			}
+
+			payload, err := jws.Verify(key)
+			if err != nil {
+				continue
+			}
+
+			if source.err != nil {
+				k.staleVerification(source)
+			}
+
+			return payload, nil
+		}
+	}
+
+	return nil, errUnknownKey
+}
+
+// staleVerification counts a signature verified with the keys of a document whose last refresh failed. It is
+// called under the read lock: the counter is updated atomically.
+func (k *KeySet) staleVerification(source *keySource) {
+	atomic.AddUint64(&k.stats.StaleVerifications, 1)
+
+	logger.Warnf("verified id_token with the stale keys of %s, fetched at %s: %s", source.url,
+		source.fetched.Format(time.RFC3339), source.err.Error())
+}
+
+// Stats returns the totals of the refreshes and the state of the keys of each document.
+func (k *KeySet) Stats() *KeySetStats {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	stats := &KeySetStats{
+		Refreshes:          k.stats.Refreshes,
+		RefreshFailures:    k.stats.RefreshFailures,
+		StaleVerifications: atomic.LoadUint64(&k.stats.StaleVerifications),
+	}
+
+	for _, source := range k.sources {
+		s := &KeySourceStats{URL: source.url, Keys: len(source.keys), Stale: source.err != nil}
+
+		if !source.fetched.IsZero() {
+			fetched := source.fetched
+			s.Fetched = &fetched
+		}
+
+		if source.err != nil {
+			s.LastError = source.err.Error()
+		}
+
+		stats.Sources = append(stats.Sources, s)
+	}
+
+	return stats
+}
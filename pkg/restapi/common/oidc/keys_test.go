@@ -0,0 +1,344 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal interfaces
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestKeySet_VerifySignature(t *testing.T) {
+	t.Run("verifies the signatures with the keys of the issuer", func(t *testing.T) {
+		key := newSigningKey(t, "key-1")
+		jwks := newMockJWKS(t, key)
+
+		keys := newKeySet(t, jwks.URL)
+
+		payload, err := keys.VerifySignature(context.Background(), key.sign(t, map[string]interface{}{"sub": "alice"}))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"sub":"alice"}`, string(payload))
+		require.Equal(t, 1, jwks.requests())
+
+		// served from the cache
+		_, err = keys.VerifySignature(context.Background(), key.sign(t, map[string]interface{}{"sub": "bob"}))
+		require.NoError(t, err)
+		require.Equal(t, 1, jwks.requests())
+	})
+
+	t.Run("refreshes the keys rotated by the issuer", func(t *testing.T) {
+		current := newSigningKey(t, "key-1")
+		jwks := newMockJWKS(t, current)
+
+		keys := newKeySet(t, jwks.URL)
+		keys.Refresh(context.Background())
+
+		next := newSigningKey(t, "key-2")
+		jwks.serve(current, next)
+
+		// the keys were just refreshed
+		_, err := keys.VerifySignature(context.Background(), next.sign(t, map[string]interface{}{}))
+		require.Error(t, err)
+		require.Equal(t, 1, jwks.requests())
+
+		keys.now = func() time.Time { return time.Now().Add(minKeysRefreshInterval) }
+
+		_, err = keys.VerifySignature(context.Background(), next.sign(t, map[string]interface{}{}))
+		require.NoError(t, err)
+		require.Equal(t, 2, jwks.requests())
+	})
+
+	t.Run("verifies the signatures with the keys of any of the documents", func(t *testing.T) {
+		current, next := newSigningKey(t, "key-1"), newSigningKey(t, "key-2")
+
+		keys := newKeySet(t, newMockJWKS(t, current).URL, newMockJWKS(t, next).URL)
+
+		_, err := keys.VerifySignature(context.Background(), current.sign(t, map[string]interface{}{}))
+		require.NoError(t, err)
+
+		_, err = keys.VerifySignature(context.Background(), next.sign(t, map[string]interface{}{}))
+		require.NoError(t, err)
+	})
+
+	t.Run("falls back to the stale keys while the refreshes fail", func(t *testing.T) {
+		key := newSigningKey(t, "key-1")
+		jwks := newMockJWKS(t, key)
+
+		keys := newKeySet(t, jwks.URL)
+		keys.Refresh(context.Background())
+
+		jwks.fail(http.StatusServiceUnavailable)
+		keys.Refresh(context.Background())
+
+		_, err := keys.VerifySignature(context.Background(), key.sign(t, map[string]interface{}{}))
+		require.NoError(t, err)
+
+		stats := keys.Stats()
+		require.Equal(t, uint64(2), stats.Refreshes)
+		require.Equal(t, uint64(1), stats.RefreshFailures)
+		require.Equal(t, uint64(1), stats.StaleVerifications)
+		require.Equal(t, 1, stats.Sources[0].Keys)
+		require.True(t, stats.Sources[0].Stale)
+		require.NotNil(t, stats.Sources[0].Fetched)
+		require.Contains(t, stats.Sources[0].LastError, "jwks document returned 503")
+
+		// past the max staleness
+		keys.now = func() time.Time { return time.Now().Add(defaultKeysMaxStale + time.Minute) }
+
+		_, err = keys.VerifySignature(context.Background(), key.sign(t, map[string]interface{}{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no key of the id_token issuer verifies the signature")
+
+		jwks.fail(0)
+		keys.Refresh(context.Background())
+
+		_, err = keys.VerifySignature(context.Background(), key.sign(t, map[string]interface{}{}))
+		require.NoError(t, err)
+		require.False(t, keys.Stats().Sources[0].Stale)
+	})
+
+	t.Run("error if the signature does not verify", func(t *testing.T) {
+		key := newSigningKey(t, "key-1")
+		forged := newSigningKey(t, "key-1")
+
+		_, err := newKeySet(t, newMockJWKS(t, key).URL).VerifySignature(context.Background(),
+			forged.sign(t, map[string]interface{}{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no key of the id_token issuer verifies the signature")
+	})
+
+	t.Run("error if the jwt is malformed", func(t *testing.T) {
+		_, err := newKeySet(t, "https://idp.example.com/jwks").VerifySignature(context.Background(), "jwt")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "malformed jwt")
+	})
+}
+
+func TestKeySet_Refresh(t *testing.T) {
+	t.Run("keeps the current keys if the document is invalid", func(t *testing.T) {
+		for body, msg := range map[string]string{
+			`{`:           "failed to decode jwks document",
+			`{"keys":[]}`: "jwks document has no keys",
+		} {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, err := w.Write([]byte(body))
+				require.NoError(t, err)
+			}))
+
+			keys := newKeySet(t, server.URL)
+			keys.Refresh(context.Background())
+			require.Contains(t, keys.Stats().Sources[0].LastError, msg)
+
+			server.Close()
+		}
+	})
+
+	t.Run("keeps the current keys if the issuer is unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		keys := newKeySet(t, server.URL, "://jwks")
+		keys.Refresh(context.Background())
+
+		stats := keys.Stats()
+		require.Contains(t, stats.Sources[0].LastError, "failed to fetch keys")
+		require.Contains(t, stats.Sources[1].LastError, "failed to create request")
+		require.Nil(t, stats.Sources[0].Fetched)
+	})
+
+	t.Run("refreshes the keys in the background", func(t *testing.T) {
+		jwks := newMockJWKS(t, newSigningKey(t, "key-1"))
+
+		keys, err := NewKeySet(&KeySetConfig{URLs: []string{jwks.URL}, RefreshInterval: time.Millisecond})
+		require.NoError(t, err)
+
+		keys.Start()
+
+		require.Eventually(t, func() bool { return jwks.requests() > 1 }, time.Second, time.Millisecond)
+
+		keys.Stop()
+		keys.Stop()
+	})
+
+	t.Run("error without document", func(t *testing.T) {
+		_, err := NewKeySet(&KeySetConfig{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing jwks url")
+	})
+}
+
+func TestProviderAdapter_Verifier(t *testing.T) {
+	current, previous := newSigningKey(t, "key-1"), newSigningKey(t, "key-2")
+
+	adapter := &ProviderAdapter{Issuers: []*TrustedIssuer{
+		{URL: "https://new.example.com", Keys: newKeySet(t, newMockJWKS(t, current).URL), Algorithms: []string{"ES256"}},
+		{URL: "https://old.example.com", Keys: newKeySet(t, newMockJWKS(t, previous).URL), Algorithms: []string{"ES256"}},
+	}}
+
+	verifier := adapter.Verifier(&oidc.Config{ClientID: "client"})
+
+	idToken := func(key *signingKey, issuer string) string {
+		return key.sign(t, map[string]interface{}{
+			"iss": issuer, "aud": "client", "sub": "alice", "exp": time.Now().Add(time.Hour).Unix(),
+		})
+	}
+
+	t.Run("verifies the id_tokens of the trusted issuers", func(t *testing.T) {
+		token, err := verifier.Verify(context.Background(), idToken(current, "https://new.example.com"))
+		require.NoError(t, err)
+		require.Equal(t, "alice", token.Subject)
+
+		token, err = verifier.Verify(context.Background(), idToken(previous, "https://old.example.com"))
+		require.NoError(t, err)
+		require.Equal(t, "https://old.example.com", token.Issuer)
+	})
+
+	t.Run("error if the id_token is signed with the keys of another issuer", func(t *testing.T) {
+		_, err := verifier.Verify(context.Background(), idToken(previous, "https://new.example.com"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to verify signature")
+	})
+
+	t.Run("error if the issuer is not trusted", func(t *testing.T) {
+		_, err := verifier.Verify(context.Background(), idToken(current, "https://other.example.com"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "id_token issued by untrusted issuer https://other.example.com")
+	})
+
+	t.Run("error if the id_token is signed with an algorithm the issuer does not use", func(t *testing.T) {
+		_, err := adapter.Verifier(&oidc.Config{ClientID: "client", SupportedSigningAlgs: []string{"RS256"}}).Verify(
+			context.Background(), idToken(current, "https://new.example.com"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "id token signed with unsupported algorithm")
+	})
+
+	t.Run("error if the id_token is malformed", func(t *testing.T) {
+		for token, msg := range map[string]string{
+			"jwt":         "malformed jwt: expected three parts",
+			"a.***.c":     "malformed jwt payload",
+			"a.bnVsbA.c":  "id_token issued by untrusted issuer",
+			"a.W10.c":     "failed to unmarshal jwt claims",
+			"a.e30.c.d.e": "malformed jwt: expected three parts",
+		} {
+			_, err := verifier.Verify(context.Background(), token)
+			require.Error(t, err, token)
+			require.Contains(t, err.Error(), msg, token)
+		}
+	})
+}
+
+func newKeySet(t *testing.T, urls ...string) *KeySet {
+	t.Helper()
+
+	keys, err := NewKeySet(&KeySetConfig{URLs: urls})
+	require.NoError(t, err)
+
+	return keys
+}
+
+type signingKey struct {
+	jwk jose.JSONWebKey
+}
+
+func newSigningKey(t *testing.T, kid string) *signingKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return &signingKey{jwk: jose.JSONWebKey{Key: key, KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"}}
+}
+
+func (k *signingKey) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: k.jwk}, nil)
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	jws, err := signer.Sign(payload)
+	require.NoError(t, err)
+
+	jwt, err := jws.CompactSerialize()
+	require.NoError(t, err)
+
+	return jwt
+}
+
+// mockJWKS serves the public keys of a JWKS document, and counts the requests.
+type mockJWKS struct {
+	*httptest.Server
+	mutex  sync.Mutex
+	keys   []jose.JSONWebKey
+	status int
+	count  int
+}
+
+func newMockJWKS(t *testing.T, keys ...*signingKey) *mockJWKS {
+	t.Helper()
+
+	m := &mockJWKS{}
+	m.serve(keys...)
+
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.count++
+
+		if m.status != 0 {
+			w.WriteHeader(m.status)
+
+			return
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(&jose.JSONWebKeySet{Keys: m.keys}))
+	}))
+
+	t.Cleanup(m.Server.Close)
+
+	return m
+}
+
+func (m *mockJWKS) serve(keys ...*signingKey) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.keys = nil
+
+	for _, k := range keys {
+		m.keys = append(m.keys, k.jwk.Public())
+	}
+}
+
+// fail the requests with the status, or serve the keys again if 0.
+func (m *mockJWKS) fail(status int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.status = status
+}
+
+func (m *mockJWKS) requests() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.count
+}
@@ -14,18 +14,30 @@ import (
 
 // MockClient is a mock OIDC client.
 type MockClient struct {
-	AuthRequest string
-	OAuthToken  *oauth2.Token
-	OAuthErr    error
-	IDToken     Claimer
-	IDTokenErr  error
-	UserInfoVal Claimer
-	UserInfoErr error
+	AuthRequest    string
+	OAuthToken     *oauth2.Token
+	OAuthErr       error
+	DeviceAuth     *DeviceAuthorization
+	DeviceAuthErr  error
+	DeviceToken    *oauth2.Token
+	DeviceTokenErr error
+	IDToken        Claimer
+	IDTokenErr     error
+	UserInfoVal    Claimer
+	UserInfoErr    error
+	Refreshed      *oauth2.Token
+	RefreshErr     error
+	ValidateErr    error
 }
 
-// FormatRequest formats the OIDC authorization request.
-func (m *MockClient) FormatRequest(_ string) string {
-	return m.AuthRequest
+// FormatRequest formats the OIDC authorization request. The parameters of the options, if any, are added to the
+// query of AuthRequest.
+func (m *MockClient) FormatRequest(state string, opts ...oauth2.AuthCodeOption) string {
+	if len(opts) == 0 {
+		return m.AuthRequest
+	}
+
+	return (&oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: m.AuthRequest}}).AuthCodeURL(state, opts...)
 }
 
 // Exchange exchanges the code for an oauth token.
@@ -33,6 +45,16 @@ func (m *MockClient) Exchange(_ context.Context, _ string) (*oauth2.Token, error
 	return m.OAuthToken, m.OAuthErr
 }
 
+// AuthorizeDevice starts the device authorization grant.
+func (m *MockClient) AuthorizeDevice(_ context.Context) (*DeviceAuthorization, error) {
+	return m.DeviceAuth, m.DeviceAuthErr
+}
+
+// ExchangeDeviceCode exchanges the device code for an oauth token.
+func (m *MockClient) ExchangeDeviceCode(_ context.Context, _ string) (*oauth2.Token, error) {
+	return m.DeviceToken, m.DeviceTokenErr
+}
+
 // VerifyIDToken verifies the id_token inside the OAuth2 token.
 func (m *MockClient) VerifyIDToken(_ context.Context, _ OAuth2Token) (Claimer, error) {
 	return m.IDToken, m.IDTokenErr
@@ -43,6 +65,16 @@ func (m *MockClient) UserInfo(_ context.Context, _ *oauth2.Token) (Claimer, erro
 	return m.UserInfoVal, m.UserInfoErr
 }
 
+// RefreshToken refreshes the oauth token.
+func (m *MockClient) RefreshToken(_ context.Context, _ *oauth2.Token) (*oauth2.Token, error) {
+	return m.Refreshed, m.RefreshErr
+}
+
+// Validate checks the client against its provider.
+func (m *MockClient) Validate(_ context.Context) error {
+	return m.ValidateErr
+}
+
 // MockClaimer can be a mock id_token or a mock UserInfo.
 type MockClaimer struct {
 	ClaimsErr  error
@@ -23,6 +23,12 @@ func TestMockClient_FormatRequest(t *testing.T) {
 		m := &oidc.MockClient{AuthRequest: expected}
 		require.Equal(t, expected, m.FormatRequest(""))
 	})
+
+	t.Run("adds the parameters of the options", func(t *testing.T) {
+		m := &oidc.MockClient{AuthRequest: "https://op.example.com/authorize"}
+		require.Equal(t, "https://op.example.com/authorize?client_id=&max_age=0&response_type=code&state=state",
+			m.FormatRequest("state", oauth2.SetAuthURLParam("max_age", "0")))
+	})
 }
 
 func TestMockClient_Exchange(t *testing.T) {
@@ -62,6 +68,23 @@ func TestMockClient_VerifyIDToken(t *testing.T) {
 	})
 }
 
+func TestMockClient_RefreshToken(t *testing.T) {
+	t.Run("returns token", func(t *testing.T) {
+		expected := &oauth2.Token{AccessToken: uuid.New().String()}
+		m := &oidc.MockClient{Refreshed: expected}
+		result, err := m.RefreshToken(context.TODO(), nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	})
+
+	t.Run("returns error", func(t *testing.T) {
+		expected := errors.New("test")
+		m := &oidc.MockClient{RefreshErr: expected}
+		_, err := m.RefreshToken(context.TODO(), nil)
+		require.Equal(t, expected, err)
+	})
+}
+
 func TestMockClient_UserInfo(t *testing.T) {
 	t.Run("returns userinfo", func(t *testing.T) {
 		expected := &oidc.MockClaimer{
@@ -102,3 +125,31 @@ func TestMockClaimer_Claims(t *testing.T) {
 		require.Equal(t, expected, result)
 	})
 }
+
+func TestMockClient_Device(t *testing.T) {
+	t.Run("returns device authorization and token", func(t *testing.T) {
+		expected := &oauth2.Token{AccessToken: uuid.New().String()}
+		m := &oidc.MockClient{
+			DeviceAuth:  &oidc.DeviceAuthorization{DeviceCode: "device"},
+			DeviceToken: expected,
+		}
+
+		authorization, err := m.AuthorizeDevice(context.TODO())
+		require.NoError(t, err)
+		require.Equal(t, "device", authorization.DeviceCode)
+
+		result, err := m.ExchangeDeviceCode(context.TODO(), "device")
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	})
+
+	t.Run("returns errors", func(t *testing.T) {
+		m := &oidc.MockClient{DeviceAuthErr: errors.New("test"), DeviceTokenErr: oidc.ErrAuthorizationPending}
+
+		_, err := m.AuthorizeDevice(context.TODO())
+		require.Equal(t, m.DeviceAuthErr, err)
+
+		_, err = m.ExchangeDeviceCode(context.TODO(), "device")
+		require.Equal(t, oidc.ErrAuthorizationPending, err)
+	})
+}
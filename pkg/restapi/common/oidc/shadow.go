@@ -0,0 +1,266 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultShadowTimeout       = 5 * time.Second
+	defaultShadowMaxConcurrent = 10
+)
+
+// Operations of the clients compared by a Shadow.
+const (
+	ShadowVerifyIDToken = "verify-id-token"
+	ShadowUserInfo      = "userinfo"
+)
+
+// ShadowConfig holds the configuration of a Shadow.
+type ShadowConfig struct {
+	// Primary serves the requests.
+	Primary Client
+	// Candidate is the implementation being migrated to, whose results are only compared to those of Primary.
+	Candidate Client
+	// Features enables the comparisons for the users the features.OIDCShadow flag targets, by sub, tenant or
+	// percentage. The comparisons are made for every user if nil or if the feature has no flag.
+	Features *features.Service
+	// IgnoredClaims are the claims that may differ between the results, e.g. those the candidate does not support.
+	IgnoredClaims []string
+	// Timeout of the calls of Candidate. Defaults to 5s.
+	Timeout time.Duration
+	// MaxConcurrent is the number of calls of Candidate under way, beyond which the comparisons are skipped, so that
+	// a slow candidate does not pile up. Defaults to 10.
+	MaxConcurrent int
+}
+
+// ShadowStats are the totals of the comparisons since the agent started.
+type ShadowStats struct {
+	Comparisons uint64 `json:"comparisons"`
+	Divergences uint64 `json:"divergences"`
+	// CandidateErrors counts the divergences where only the candidate failed.
+	CandidateErrors uint64 `json:"candidateErrors"`
+	// Skipped counts the comparisons skipped while MaxConcurrent calls of the candidate were under way.
+	Skipped        uint64 `json:"skipped"`
+	LastDivergence string `json:"lastDivergence,omitempty"`
+}
+
+// Shadow is a Client serving the requests with its primary client, which runs the verification-only operations,
+// VerifyIDToken and UserInfo, with a candidate client too, in the background, so that a new implementation is
+// verified against the current one with the live traffic before it serves it. The results that differ are logged
+// and counted, without their values since they are the personal data of the users.
+type Shadow struct {
+	Client
+	candidate Client
+	features  *features.Service
+	ignored   map[string]bool
+	timeout   time.Duration
+	// bounds the calls of the candidate under way
+	slots chan struct{}
+	mutex sync.Mutex
+	stats ShadowStats
+	// waits for the comparisons under way
+	wg sync.WaitGroup
+}
+
+// NewShadow returns a new Shadow.
+func NewShadow(config *ShadowConfig) (*Shadow, error) {
+	if config.Primary == nil || config.Candidate == nil {
+		return nil, errors.New("missing primary or candidate oidc client")
+	}
+
+	s := &Shadow{
+		Client:    config.Primary,
+		candidate: config.Candidate,
+		features:  config.Features,
+		ignored:   make(map[string]bool),
+		timeout:   config.Timeout,
+	}
+
+	for _, claim := range config.IgnoredClaims {
+		s.ignored[claim] = true
+	}
+
+	if s.timeout <= 0 {
+		s.timeout = defaultShadowTimeout
+	}
+
+	maxConcurrent := config.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultShadowMaxConcurrent
+	}
+
+	s.slots = make(chan struct{}, maxConcurrent)
+
+	return s, nil
+}
+
+// VerifyIDToken verifies the id_token with the primary client, and compares the result of the candidate.
+func (s *Shadow) VerifyIDToken(ctx context.Context, oauthToken OAuth2Token) (Claimer, error) {
+	claims, err := s.Client.VerifyIDToken(ctx, oauthToken)
+
+	s.compare(ShadowVerifyIDToken, claims, err, func(ctx context.Context) (Claimer, error) {
+		return s.candidate.VerifyIDToken(ctx, oauthToken)
+	})
+
+	return claims, err
+}
+
+// UserInfo fetches the user info with the primary client, and compares the result of the candidate.
+func (s *Shadow) UserInfo(ctx context.Context, token *oauth2.Token) (Claimer, error) {
+	claims, err := s.Client.UserInfo(ctx, token)
+
+	s.compare(ShadowUserInfo, claims, err, func(ctx context.Context) (Claimer, error) {
+		return s.candidate.UserInfo(ctx, token)
+	})
+
+	return claims, err
+}
+
+// Stats returns the totals of the comparisons.
+func (s *Shadow) Stats() ShadowStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.stats
+}
+
+// Wait for the comparisons under way, e.g. before the agent stops.
+func (s *Shadow) Wait() {
+	s.wg.Wait()
+}
+
+// compare the result of the primary client with that of the candidate in the background, for the users the
+// comparisons are enabled for. The users the primary client rejects are unknown, so that their results are only
+// compared if the comparisons are enabled for everyone.
+func (s *Shadow) compare(operation string, primary Claimer, primaryErr error,
+	candidate func(ctx context.Context) (Claimer, error)) {
+	expected, expectedErr := claimsOf(primary, primaryErr)
+
+	// no sub if the primary client failed
+	sub, _ := expected["sub"].(string)
+
+	if !s.features.Enabled(features.OIDCShadow, sub) {
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		s.mutex.Lock()
+		s.stats.Skipped++
+		s.mutex.Unlock()
+
+		return
+	}
+
+	s.wg.Add(1)
+
+	go func() {
+		defer func() {
+			<-s.slots
+			s.wg.Done()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		actual, actualErr := claimsOf(candidate(ctx))
+		divergence := s.diff(expected, expectedErr, actual, actualErr)
+
+		s.record(operation, sub, divergence, expectedErr == nil && actualErr != nil)
+	}()
+}
+
+func (s *Shadow) record(operation, sub, divergence string, candidateFailed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stats.Comparisons++
+
+	if divergence == "" {
+		return
+	}
+
+	divergence = fmt.Sprintf("%s: %s", operation, divergence)
+
+	s.stats.Divergences++
+	s.stats.LastDivergence = divergence
+
+	if candidateFailed {
+		s.stats.CandidateErrors++
+	}
+
+	logger.Warnf("oidc shadow divergence for user %s: %s", sub, divergence)
+}
+
+// diff describes how the result of the candidate differs from that of the primary client, empty if they match.
+// The clients agree if they both fail, whatever their errors.
+func (s *Shadow) diff(expected map[string]interface{}, expectedErr error,
+	actual map[string]interface{}, actualErr error) string {
+	switch {
+	case expectedErr != nil && actualErr != nil:
+		return ""
+	case expectedErr != nil:
+		return fmt.Sprintf("candidate succeeded, primary failed: %s", expectedErr.Error())
+	case actualErr != nil:
+		return fmt.Sprintf("candidate failed: %s", actualErr.Error())
+	}
+
+	var differing []string
+
+	for claim, value := range expected {
+		if !s.ignored[claim] && !reflect.DeepEqual(value, actual[claim]) {
+			differing = append(differing, claim)
+		}
+	}
+
+	for claim := range actual {
+		if _, found := expected[claim]; !found && !s.ignored[claim] {
+			differing = append(differing, claim)
+		}
+	}
+
+	if len(differing) == 0 {
+		return ""
+	}
+
+	sort.Strings(differing)
+
+	return fmt.Sprintf("claims differ: %s", strings.Join(differing, ", "))
+}
+
+// claimsOf returns the claims of the result of a client, decoded alike for both clients.
+func claimsOf(claimer Claimer, err error) (map[string]interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if claimer == nil {
+		return nil, errors.New("no claims")
+	}
+
+	claims := make(map[string]interface{})
+
+	err = claimer.Claims(&claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %w", err)
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,233 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal interfaces
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"golang.org/x/oauth2"
+)
+
+func TestNewShadow(t *testing.T) {
+	_, err := NewShadow(&ShadowConfig{Primary: &MockClient{}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing primary or candidate oidc client")
+}
+
+func TestShadow_VerifyIDToken(t *testing.T) {
+	t.Run("serves the result of the primary client and compares that of the candidate", func(t *testing.T) {
+		primary := claimer(t, map[string]interface{}{"sub": "alice", "email": "alice@example.com"})
+		s := newShadow(t, &MockClient{IDToken: primary}, &MockClient{
+			IDToken: claimer(t, map[string]interface{}{"sub": "alice", "email": "alice@example.com"}),
+		})
+
+		claims, err := s.VerifyIDToken(context.Background(), &oauth2.Token{})
+		require.NoError(t, err)
+		require.Equal(t, primary, claims)
+
+		s.Wait()
+		require.Equal(t, ShadowStats{Comparisons: 1}, s.Stats())
+	})
+
+	t.Run("counts the claims that differ", func(t *testing.T) {
+		s := newShadow(t, &MockClient{
+			IDToken: claimer(t, map[string]interface{}{"sub": "alice", "email": "alice@example.com", "acr": "1"}),
+		}, &MockClient{
+			IDToken: claimer(t, map[string]interface{}{"sub": "alice", "email": "alice@other.com", "amr": []string{}}),
+		})
+
+		_, err := s.VerifyIDToken(context.Background(), &oauth2.Token{})
+		require.NoError(t, err)
+
+		s.Wait()
+
+		stats := s.Stats()
+		require.Equal(t, uint64(1), stats.Divergences)
+		require.Zero(t, stats.CandidateErrors)
+		require.Equal(t, "verify-id-token: claims differ: acr, amr, email", stats.LastDivergence)
+	})
+
+	t.Run("ignores the claims", func(t *testing.T) {
+		s, err := NewShadow(&ShadowConfig{
+			Primary:       &MockClient{IDToken: claimer(t, map[string]interface{}{"sub": "alice", "at_hash": "a"})},
+			Candidate:     &MockClient{IDToken: claimer(t, map[string]interface{}{"sub": "alice"})},
+			IgnoredClaims: []string{"at_hash"},
+		})
+		require.NoError(t, err)
+
+		_, err = s.VerifyIDToken(context.Background(), &oauth2.Token{})
+		require.NoError(t, err)
+
+		s.Wait()
+		require.Zero(t, s.Stats().Divergences)
+	})
+
+	t.Run("counts the errors of the candidate", func(t *testing.T) {
+		s := newShadow(t, &MockClient{IDToken: claimer(t, map[string]interface{}{"sub": "alice"})},
+			&MockClient{IDTokenErr: errors.New("invalid signature")})
+
+		_, err := s.VerifyIDToken(context.Background(), &oauth2.Token{})
+		require.NoError(t, err)
+
+		s.Wait()
+
+		stats := s.Stats()
+		require.Equal(t, uint64(1), stats.Divergences)
+		require.Equal(t, uint64(1), stats.CandidateErrors)
+		require.Equal(t, "verify-id-token: candidate failed: invalid signature", stats.LastDivergence)
+	})
+
+	t.Run("the clients agree if they both reject the token", func(t *testing.T) {
+		s := newShadow(t, &MockClient{IDTokenErr: errors.New("expired")}, &MockClient{IDTokenErr: errors.New("exp")})
+
+		_, err := s.VerifyIDToken(context.Background(), &oauth2.Token{})
+		require.EqualError(t, err, "expired")
+
+		s.Wait()
+		require.Equal(t, ShadowStats{Comparisons: 1}, s.Stats())
+	})
+
+	t.Run("counts the tokens only the candidate accepts", func(t *testing.T) {
+		s := newShadow(t, &MockClient{IDTokenErr: errors.New("expired")},
+			&MockClient{IDToken: claimer(t, map[string]interface{}{"sub": "alice"})})
+
+		_, err := s.VerifyIDToken(context.Background(), &oauth2.Token{})
+		require.Error(t, err)
+
+		s.Wait()
+		require.Equal(t, "verify-id-token: candidate succeeded, primary failed: expired", s.Stats().LastDivergence)
+	})
+}
+
+func TestShadow_UserInfo(t *testing.T) {
+	s := newShadow(t, &MockClient{UserInfoVal: claimer(t, map[string]interface{}{"sub": "alice", "name": "A"})},
+		&MockClient{UserInfoVal: claimer(t, map[string]interface{}{"sub": "alice", "name": "B"})})
+
+	_, err := s.UserInfo(context.Background(), &oauth2.Token{})
+	require.NoError(t, err)
+
+	s.Wait()
+	require.Equal(t, "userinfo: claims differ: name", s.Stats().LastDivergence)
+
+	t.Run("the claims that cannot be decoded fail", func(t *testing.T) {
+		s := newShadow(t, &MockClient{UserInfoVal: claimer(t, map[string]interface{}{"sub": "alice"})},
+			&MockClient{UserInfoVal: &MockClaimer{ClaimsErr: errors.New("malformed")}})
+
+		_, err := s.UserInfo(context.Background(), &oauth2.Token{})
+		require.NoError(t, err)
+
+		s.Wait()
+		require.Equal(t, uint64(1), s.Stats().CandidateErrors)
+
+		s = newShadow(t, &MockClient{UserInfoVal: claimer(t, map[string]interface{}{"sub": "alice"})}, &MockClient{})
+
+		_, err = s.UserInfo(context.Background(), &oauth2.Token{})
+		require.NoError(t, err)
+
+		s.Wait()
+		require.Equal(t, "userinfo: candidate failed: no claims", s.Stats().LastDivergence)
+	})
+}
+
+func TestShadow_Features(t *testing.T) {
+	flags, err := features.New(&features.Config{
+		Source: &features.MockSource{Flags: map[string]*features.Flag{
+			features.OIDCShadow: {Tenants: []string{"acme"}},
+		}},
+		Tenant: func(sub string) (string, error) {
+			if sub == "alice" {
+				return "acme", nil
+			}
+
+			return "other", nil
+		},
+	})
+	require.NoError(t, err)
+
+	candidate := &MockClient{IDTokenErr: errors.New("test")}
+
+	s, err := NewShadow(&ShadowConfig{
+		Primary:   &MockClient{IDToken: claimer(t, map[string]interface{}{"sub": "alice"})},
+		Candidate: candidate,
+		Features:  flags,
+	})
+	require.NoError(t, err)
+
+	_, err = s.VerifyIDToken(context.Background(), &oauth2.Token{})
+	require.NoError(t, err)
+
+	s.Wait()
+	require.Equal(t, uint64(1), s.Stats().Comparisons)
+
+	// the tokens of the users of other tenants, and those the primary client rejects, are not compared
+	s.Client = &MockClient{IDToken: claimer(t, map[string]interface{}{"sub": "bob"})}
+
+	_, err = s.VerifyIDToken(context.Background(), &oauth2.Token{})
+	require.NoError(t, err)
+
+	s.Client = &MockClient{IDTokenErr: errors.New("expired")}
+
+	_, err = s.VerifyIDToken(context.Background(), &oauth2.Token{})
+	require.Error(t, err)
+
+	s.Wait()
+	require.Equal(t, uint64(1), s.Stats().Comparisons)
+}
+
+func TestShadow_MaxConcurrent(t *testing.T) {
+	release := make(chan struct{})
+
+	s, err := NewShadow(&ShadowConfig{
+		Primary: &MockClient{UserInfoVal: claimer(t, map[string]interface{}{"sub": "alice"})},
+		Candidate: &MockClient{UserInfoVal: &MockClaimer{ClaimsFunc: func(interface{}) error {
+			<-release
+
+			return nil
+		}}},
+		MaxConcurrent: 1,
+	})
+	require.NoError(t, err)
+
+	_, err = s.UserInfo(context.Background(), &oauth2.Token{})
+	require.NoError(t, err)
+
+	_, err = s.UserInfo(context.Background(), &oauth2.Token{})
+	require.NoError(t, err)
+
+	close(release)
+	s.Wait()
+
+	stats := s.Stats()
+	require.Equal(t, uint64(1), stats.Comparisons)
+	require.Equal(t, uint64(1), stats.Skipped)
+}
+
+func newShadow(t *testing.T, primary, candidate Client) *Shadow {
+	t.Helper()
+
+	s, err := NewShadow(&ShadowConfig{Primary: primary, Candidate: candidate})
+	require.NoError(t, err)
+
+	return s
+}
+
+// claimer returns a Claimer decoding the claims from JSON, as the clients do.
+func claimer(t *testing.T, claims map[string]interface{}) *MockClaimer {
+	t.Helper()
+
+	raw, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	return &MockClaimer{ClaimsFunc: func(v interface{}) error {
+		return json.Unmarshal(raw, v)
+	}}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrInvalidClient is returned by Validate when the provider rejects the ID or secret of the client.
+var ErrInvalidClient = errors.New("the oidc provider rejected the client id or secret")
+
+// scopes defined by OpenID Connect, which the providers advertising their scopes advertise.
+// nolint:gochecknoglobals // constant
+var standardScopes = map[string]bool{
+	"openid": true, "profile": true, "email": true, "address": true, "phone": true, "offline_access": true,
+}
+
+// Metadata is the part of the discovery document of the provider the client depends on:
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata.
+type Metadata struct {
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Validate checks the authorization code flow and the scopes of the client against the discovery document of the
+// provider, and its credentials against the token endpoint. The credentials are checked with the client
+// credentials grant, which the providers refusing it to the client still authenticate the client for.
+func (c *BasicClient) Validate(ctx context.Context) error {
+	err := c.validateMetadata()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.ClientCredentialsToken(ctx)
+	if err == nil {
+		return nil
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return fmt.Errorf("failed to request a token from the oidc provider: %w", err)
+	}
+
+	if rejectedClient(retrieveErr) {
+		return fmt.Errorf("%w: %s", ErrInvalidClient, strings.TrimSpace(string(retrieveErr.Body)))
+	}
+
+	// e.g. unauthorized_client: the client authenticated, but is not allowed the grant
+	return nil
+}
+
+func (c *BasicClient) validateMetadata() error {
+	if c.metadata == nil {
+		return nil
+	}
+
+	if !contains(c.metadata.ResponseTypesSupported, "code", true) {
+		return errors.New("the oidc provider does not support the authorization code flow")
+	}
+
+	if !contains(c.metadata.GrantTypesSupported, "authorization_code", true) {
+		return errors.New("the oidc provider does not support the authorization_code grant")
+	}
+
+	for _, scope := range c.scopes {
+		if standardScopes[scope] && !contains(c.metadata.ScopesSupported, scope, true) {
+			return fmt.Errorf("the oidc provider does not support the %s scope", scope)
+		}
+	}
+
+	if c.secret() != "" && !contains(c.metadata.TokenEndpointAuthMethodsSupported, "client_secret_basic", true) &&
+		!contains(c.metadata.TokenEndpointAuthMethodsSupported, "client_secret_post", false) {
+		return errors.New("the oidc provider does not authenticate the clients with their secret")
+	}
+
+	return nil
+}
+
+// contains tells whether the values advertised by the provider contain the value. Unadvertised values default to
+// the value if byDefault.
+func contains(values []string, value string, byDefault bool) bool {
+	if len(values) == 0 {
+		return byDefault
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rejectedClient tells whether the token endpoint rejected the client itself rather than the grant:
+// https://tools.ietf.org/html/rfc6749#section-5.2.
+func rejectedClient(err *oauth2.RetrieveError) bool {
+	if err.Response != nil && err.Response.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+
+	body := struct {
+		Error string `json:"error"`
+	}{}
+
+	return json.Unmarshal(err.Body, &body) == nil && body.Error == "invalid_client"
+}
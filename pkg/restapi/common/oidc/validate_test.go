@@ -0,0 +1,95 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal interfaces
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestClient_Validate(t *testing.T) {
+	op := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+
+		switch {
+		case id == "unknown":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`)) // nolint:errcheck // test server
+		case id == "agent" && secret == "secret":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"access","token_type":"Bearer"}`)) // nolint:errcheck // test server
+		case id == "agent":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"unauthorized_client"}`)) // nolint:errcheck // test server
+		}
+	}))
+	defer op.Close()
+
+	newClient := func(id, secret string, metadata *Metadata) *BasicClient {
+		return NewClient(&Config{
+			Provider:     &mockOIDCProvider{endpoint: oauth2.Endpoint{TokenURL: op.URL + "/token"}},
+			ClientID:     id,
+			ClientSecret: secret,
+			Scopes:       []string{"openid", "profile", "wallet"},
+			Metadata:     metadata,
+		})
+	}
+
+	t.Run("valid client", func(t *testing.T) {
+		require.NoError(t, newClient("agent", "secret", &Metadata{
+			ResponseTypesSupported:            []string{"code", "id_token"},
+			ScopesSupported:                   []string{"openid", "profile", "email"},
+			TokenEndpointAuthMethodsSupported: []string{"client_secret_post"},
+		}).Validate(context.Background()))
+		require.NoError(t, newClient("agent", "secret", nil).Validate(context.Background()))
+	})
+
+	t.Run("valid client not allowed the client credentials grant", func(t *testing.T) {
+		require.NoError(t, newClient("wallet", "secret", nil).Validate(context.Background()))
+	})
+
+	t.Run("error if the provider rejects the client", func(t *testing.T) {
+		err := newClient("agent", "other", nil).Validate(context.Background())
+		require.True(t, errors.Is(err, ErrInvalidClient))
+
+		err = newClient("unknown", "secret", nil).Validate(context.Background())
+		require.True(t, errors.Is(err, ErrInvalidClient))
+		require.Contains(t, err.Error(), "invalid_client")
+	})
+
+	t.Run("error if the provider does not support the client", func(t *testing.T) {
+		for expected, metadata := range map[string]*Metadata{
+			"authorization code flow":          {ResponseTypesSupported: []string{"id_token"}},
+			"authorization_code grant":         {GrantTypesSupported: []string{"implicit"}},
+			"profile scope":                    {ScopesSupported: []string{"openid", "email"}},
+			"authenticate the clients with th": {TokenEndpointAuthMethodsSupported: []string{"private_key_jwt"}},
+		} {
+			err := newClient("agent", "secret", metadata).Validate(context.Background())
+			require.Error(t, err)
+			require.Contains(t, err.Error(), expected)
+		}
+	})
+
+	t.Run("error if the token endpoint is unreachable", func(t *testing.T) {
+		c := NewClient(&Config{
+			Provider: &mockOIDCProvider{endpoint: oauth2.Endpoint{TokenURL: "http://127.0.0.1:1/token"}},
+			ClientID: "agent",
+		})
+
+		err := c.Validate(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to request a token")
+	})
+}
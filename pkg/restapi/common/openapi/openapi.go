@@ -0,0 +1,251 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+var logger = log.New("edge-agent/openapi")
+
+// nolint:gochecknoglobals // compiled once
+var versionSegment = regexp.MustCompile(`^v[1-9][0-9]*$`)
+
+const (
+	version         = "3.0.3"
+	jsonContentType = "application/json"
+)
+
+// Document is an OpenAPI 3 document describing the handlers of the REST API.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+	mutex      sync.RWMutex
+	// the qualified names of the types of the schemas, to tell apart the types of the same name
+	types map[string]string
+}
+
+// Info is the title and version of the API.
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem are the operations of a path by lower-case HTTP method.
+type PathItem map[string]*Operation
+
+// Operation describes a handler.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter of an operation.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the JSON body of a request.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response of an operation.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components are the schemas of the named types, referred to by the operations.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// New returns an empty document.
+func New(title, apiVersion string) *Document {
+	return &Document{
+		OpenAPI:    version,
+		Info:       Info{Title: title, Version: apiVersion},
+		Paths:      make(map[string]PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+		types:      make(map[string]string),
+	}
+}
+
+// Add describes the handler mounted at the path template of the router, e.g. /agent/connections/{id}.
+func (d *Document) Add(path, method string, spec *common.OperationSpec) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if spec == nil {
+		spec = &common.OperationSpec{}
+	}
+
+	op := &Operation{
+		OperationID: operationID(path, method),
+		Summary:     spec.Summary,
+		Tags:        tags(path),
+		Responses:   make(map[string]*Response),
+	}
+
+	for _, p := range spec.Params {
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required || p.In == common.InPath,
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+
+	op.Parameters = append(op.Parameters, pathParams(path, spec.Params)...)
+
+	if spec.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{jsonContentType: {Schema: d.schemaOf(spec.Request)}},
+		}
+	}
+
+	for status, body := range spec.Responses {
+		response := &Response{Description: http.StatusText(status)}
+
+		if body != nil {
+			response.Content = map[string]*MediaType{jsonContentType: {Schema: d.schemaOf(body)}}
+		}
+
+		op.Responses[strconv.Itoa(status)] = response
+	}
+
+	if len(op.Responses) == 0 {
+		op.Responses[strconv.Itoa(http.StatusOK)] = &Response{Description: http.StatusText(http.StatusOK)}
+	}
+
+	op.Responses["default"] = &Response{
+		Description: "Error",
+		Content:     map[string]*MediaType{jsonContentType: {Schema: d.schemaOf(common.ErrorResponse{})}},
+	}
+
+	item, ok := d.Paths[path]
+	if !ok {
+		item = make(PathItem)
+		d.Paths[path] = item
+	}
+
+	item[strings.ToLower(method)] = op
+}
+
+// Handler serves the document as JSON.
+func (d *Document) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		d.mutex.RLock()
+		bits, err := json.Marshal(d)
+		d.mutex.RUnlock()
+
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to marshal OpenAPI document: %s", err.Error())
+
+			return
+		}
+
+		w.Header().Set("Content-Type", jsonContentType)
+
+		_, err = w.Write(bits)
+		if err != nil {
+			logger.Errorf("failed to write OpenAPI document: %s", err.Error())
+		}
+	}
+}
+
+// pathParams returns the parameters of the path template that are not described by the spec.
+func pathParams(path string, described []common.Param) []*Parameter {
+	var params []*Parameter
+
+	for _, segment := range strings.Split(path, "/") {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+
+		// mux templates may carry a pattern, e.g. {id:[0-9]+}
+		name := strings.SplitN(strings.Trim(segment, "{}"), ":", 2)[0]
+
+		if isDescribed(name, described) {
+			continue
+		}
+
+		params = append(params, &Parameter{
+			Name: name, In: common.InPath, Required: true, Schema: &Schema{Type: "string"},
+		})
+	}
+
+	return params
+}
+
+func isDescribed(name string, params []common.Param) bool {
+	for _, p := range params {
+		if p.In == common.InPath && p.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// operationID derives a unique id from the method and the path, e.g. postOidcLoginDevice.
+func operationID(path, method string) string {
+	id := strings.ToLower(method)
+
+	// the IDs of the first version of the API are the ones of its routes before it was versioned
+	path = strings.TrimPrefix(path, common.VersionPrefix(1)+"/")
+
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '.' || r == '-' || r == '_' || r == ':'
+	}) {
+		id += strings.ToUpper(segment[:1]) + segment[1:]
+	}
+
+	return id
+}
+
+// tags groups the operations by the first segment of their path after the version prefix, e.g. oidc or admin.
+func tags(path string) []string {
+	segments := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+	if len(segments) > 1 && versionSegment.MatchString(segments[0]) {
+		segments = segments[1:]
+	}
+
+	if len(segments) == 0 {
+		return nil
+	}
+
+	return []string{segments[0]}
+}
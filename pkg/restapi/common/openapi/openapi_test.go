@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/openapi"
+)
+
+type node struct {
+	Name     string          `json:"name"`
+	Children []*node         `json:"children,omitempty"`
+	Created  time.Time       `json:"created"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Secret   []byte          `json:"secret,omitempty"`
+	Ignored  string          `json:"-"`
+	Count    int64           `json:"count,string"`
+	internal string
+}
+
+type page struct {
+	node
+	Name  int               `json:"name"`
+	Attrs map[string]string `json:"attrs"`
+}
+
+func TestDocument(t *testing.T) {
+	t.Run("describes the operations", func(t *testing.T) {
+		doc := openapi.New("wallet server", "1.0.0")
+		doc.Add("/agent/connections/{id}", http.MethodPost, &common.OperationSpec{
+			Summary: "Updates a connection",
+			Params:  []common.Param{common.QueryParam("state", "State of the connection")},
+			Request: &node{},
+			Responses: map[int]interface{}{
+				http.StatusCreated: &page{},
+				http.StatusFound:   nil,
+			},
+		})
+		doc.Add("/healthcheck", http.MethodGet, nil)
+
+		op := doc.Paths["/agent/connections/{id}"]["post"]
+		require.NotNil(t, op)
+		require.Equal(t, "postAgentConnectionsId", op.OperationID)
+		require.Equal(t, "Updates a connection", op.Summary)
+		require.Equal(t, []string{"agent"}, op.Tags)
+		require.Len(t, op.Parameters, 2)
+		require.Equal(t, &openapi.Parameter{
+			Name: "state", In: common.InQuery, Description: "State of the connection",
+			Schema: &openapi.Schema{Type: "string"},
+		}, op.Parameters[0])
+		require.Equal(t, &openapi.Parameter{
+			Name: "id", In: common.InPath, Required: true, Schema: &openapi.Schema{Type: "string"},
+		}, op.Parameters[1])
+		require.Equal(t, "#/components/schemas/openapi_test.node",
+			op.RequestBody.Content["application/json"].Schema.Ref)
+		require.Equal(t, "#/components/schemas/openapi_test.page",
+			op.Responses["201"].Content["application/json"].Schema.Ref)
+		require.Equal(t, "Found", op.Responses["302"].Description)
+		require.Nil(t, op.Responses["302"].Content)
+		require.Equal(t, "#/components/schemas/common.ErrorResponse",
+			op.Responses["default"].Content["application/json"].Schema.Ref)
+
+		health := doc.Paths["/healthcheck"]["get"]
+		require.Equal(t, "getHealthcheck", health.OperationID)
+		require.Equal(t, "OK", health.Responses["200"].Description)
+	})
+
+	t.Run("describes the versions of the operations", func(t *testing.T) {
+		doc := openapi.New("wallet server", "1.0.0")
+		doc.Add("/v1/oidc/login", http.MethodGet, nil)
+		doc.Add("/v2/oidc/login", http.MethodGet, nil)
+
+		v1 := doc.Paths["/v1/oidc/login"]["get"]
+		require.Equal(t, "getOidcLogin", v1.OperationID)
+		require.Equal(t, []string{"oidc"}, v1.Tags)
+
+		v2 := doc.Paths["/v2/oidc/login"]["get"]
+		require.Equal(t, "getV2OidcLogin", v2.OperationID)
+		require.Equal(t, []string{"oidc"}, v2.Tags)
+	})
+
+	t.Run("generates the schemas of the types", func(t *testing.T) {
+		doc := openapi.New("wallet server", "1.0.0")
+		doc.Add("/pages", http.MethodGet, &common.OperationSpec{Responses: map[int]interface{}{http.StatusOK: []page{}}})
+
+		require.Equal(t, &openapi.Schema{
+			Type:  "array",
+			Items: &openapi.Schema{Ref: "#/components/schemas/openapi_test.page"},
+		}, doc.Paths["/pages"]["get"].Responses["200"].Content["application/json"].Schema)
+
+		require.Equal(t, &openapi.Schema{
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"name":     {Type: "string"},
+				"children": {Type: "array", Items: &openapi.Schema{Ref: "#/components/schemas/openapi_test.node"}},
+				"created":  {Type: "string", Format: "date-time"},
+				"data":     {},
+				"secret":   {Type: "string", Format: "byte"},
+				"count":    {Type: "string"},
+			},
+		}, doc.Components.Schemas["openapi_test.node"])
+
+		// the fields of the struct take precedence over the promoted fields
+		require.Equal(t, &openapi.Schema{
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"name":     {Type: "integer", Format: "int32"},
+				"children": {Type: "array", Items: &openapi.Schema{Ref: "#/components/schemas/openapi_test.node"}},
+				"created":  {Type: "string", Format: "date-time"},
+				"data":     {},
+				"secret":   {Type: "string", Format: "byte"},
+				"count":    {Type: "string"},
+				"attrs":    {Type: "object", AdditionalProperties: &openapi.Schema{Type: "string"}},
+			},
+		}, doc.Components.Schemas["openapi_test.page"])
+	})
+
+	t.Run("serves the document", func(t *testing.T) {
+		doc := openapi.New("wallet server", "1.0.0")
+		doc.Add("/oidc/login", http.MethodGet, &common.OperationSpec{
+			Responses: map[int]interface{}{http.StatusFound: nil},
+		})
+
+		w := httptest.NewRecorder()
+		doc.Handler()(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		served := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &served))
+		require.Equal(t, "3.0.3", served["openapi"])
+		require.Equal(t, map[string]interface{}{"title": "wallet server", "version": "1.0.0"}, served["info"])
+		require.Contains(t, served["paths"], "/oidc/login")
+		require.Contains(t, served["components"].(map[string]interface{})["schemas"], "common.ErrorResponse")
+	})
+}
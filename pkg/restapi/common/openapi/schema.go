@@ -0,0 +1,187 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const componentsRef = "#/components/schemas/"
+
+// Schema of a JSON value. The empty schema accepts any value.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// nolint:gochecknoglobals // types compared by reflection
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaOf returns the schema of the JSON encoding of the value. The schemas of the named structs are added to
+// the components and referred to.
+func (d *Document) schemaOf(v interface{}) *Schema {
+	return d.schema(reflect.TypeOf(v))
+}
+
+func (d *Document) schema(t reflect.Type) *Schema { // nolint:gocyclo // one case per kind
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	case reflect.PtrTo(t).Implements(textMarshalerType) && !reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return &Schema{Type: "string"}
+	case reflect.PtrTo(t).Implements(jsonMarshalerType):
+		// the encoding is up to the type
+		return &Schema{}
+	}
+
+	switch t.Kind() { // nolint:exhaustive // the other kinds are not encoded
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case reflect.Struct:
+		return d.structSchema(t)
+	default:
+		return &Schema{}
+	}
+}
+
+// structSchema adds the schema of a named struct to the components, and returns the inline schema of the
+// anonymous structs.
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return d.objectSchema(t)
+	}
+
+	name := d.componentName(t)
+
+	if _, ok := d.Components.Schemas[name]; !ok {
+		// registered ahead of the properties, as the struct may refer to itself
+		d.Components.Schemas[name] = &Schema{Type: "object"}
+		*d.Components.Schemas[name] = *d.objectSchema(t)
+	}
+
+	return &Schema{Ref: componentsRef + name}
+}
+
+// componentName names the schema of the type after its package and name, e.g. oidc.ConsentStatus, adding a
+// suffix to the names of the types of different packages of the same name.
+func (d *Document) componentName(t reflect.Type) string {
+	qualified := t.PkgPath() + "." + t.Name()
+	base := path.Base(t.PkgPath()) + "." + t.Name()
+	name := base
+
+	for i := 2; ; i++ {
+		registered, ok := d.types[name]
+		if !ok {
+			d.types[name] = qualified
+
+			return name
+		}
+
+		if registered == qualified {
+			return name
+		}
+
+		name = base + strconv.Itoa(i)
+	}
+}
+
+// objectSchema returns the schema of the JSON object of the struct, following the encoding/json rules.
+func (d *Document) objectSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := parseTag(tag)
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		// the fields of the untagged embedded structs are promoted
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for k, v := range d.objectSchema(ft).Properties {
+				if _, ok := s.Properties[k]; !ok {
+					s.Properties[k] = v
+				}
+			}
+
+			continue
+		}
+
+		if f.PkgPath != "" || ft.Kind() == reflect.Func || ft.Kind() == reflect.Chan {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		if strings.Contains(opts, "string") {
+			s.Properties[name] = &Schema{Type: "string"}
+
+			continue
+		}
+
+		s.Properties[name] = d.schema(f.Type)
+	}
+
+	return s
+}
+
+func parseTag(tag string) (string, string) {
+	parts := strings.SplitN(tag, ",", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
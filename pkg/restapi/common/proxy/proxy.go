@@ -0,0 +1,146 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Func returns the URL of the proxy to send the request through, or nil to connect directly.
+// It is the type of http.Transport.Proxy.
+type Func func(*http.Request) (*url.URL, error)
+
+// Config holds the outbound proxy configuration.
+type Config struct {
+	// URL of the proxy, e.g. http://proxy.example.com:3128.
+	URL string
+	// Username and Password authenticate the agent to the proxy, if it requires it.
+	Username string
+	Password string
+	// NoProxy lists the destinations reached directly: host names, domains starting with '.', IP addresses and
+	// CIDR ranges, optionally followed by a port, or URLs whose host and port are reached directly.
+	NoProxy []string
+}
+
+type destination struct {
+	host   string
+	domain string
+	ipNet  *net.IPNet
+	port   string
+}
+
+// New returns the Func sending the requests through the proxy, except the ones to the destinations of NoProxy.
+func New(config *Config) (Func, error) {
+	proxyURL, err := url.Parse(config.URL)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL '%s'", config.URL)
+	}
+
+	if config.Username != "" {
+		// the transport authenticates to the proxy with the credentials of its URL
+		proxyURL.User = url.UserPassword(config.Username, config.Password)
+	}
+
+	destinations := make([]*destination, 0, len(config.NoProxy))
+
+	for _, entry := range config.NoProxy {
+		d, err := parseDestination(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		destinations = append(destinations, d)
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		host, port := req.URL.Hostname(), req.URL.Port()
+		if port == "" {
+			port = defaultPort(req.URL.Scheme)
+		}
+
+		for _, d := range destinations {
+			if d.matches(strings.ToLower(host), port) {
+				return nil, nil
+			}
+		}
+
+		return proxyURL, nil
+	}, nil
+}
+
+func parseDestination(entry string) (*destination, error) {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+
+	if strings.Contains(entry, "://") {
+		u, err := url.Parse(entry)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid no-proxy URL '%s'", entry)
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = defaultPort(u.Scheme)
+		}
+
+		return &destination{host: u.Hostname(), port: port}, nil
+	}
+
+	d := &destination{}
+
+	if host, port, err := net.SplitHostPort(entry); err == nil {
+		entry, d.port = host, port
+	}
+
+	if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+		d.ipNet = ipNet
+
+		return d, nil
+	}
+
+	if strings.HasPrefix(entry, ".") {
+		d.domain = entry
+
+		return d, nil
+	}
+
+	if entry == "" {
+		return nil, fmt.Errorf("empty no-proxy destination")
+	}
+
+	d.host = strings.Trim(entry, "[]")
+
+	return d, nil
+}
+
+func (d *destination) matches(host, port string) bool {
+	if d.port != "" && d.port != port {
+		return false
+	}
+
+	switch {
+	case d.ipNet != nil:
+		ip := net.ParseIP(host)
+
+		return ip != nil && d.ipNet.Contains(ip)
+	case d.domain != "":
+		return strings.HasSuffix(host, d.domain) || host == d.domain[1:]
+	default:
+		return host == d.host
+	}
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "http" {
+		return "80"
+	}
+
+	return "443"
+}
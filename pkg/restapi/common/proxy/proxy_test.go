@@ -0,0 +1,97 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package proxy_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/proxy"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("routes the requests through the proxy except to the no-proxy destinations", func(t *testing.T) {
+		proxyFunc, err := proxy.New(&proxy.Config{
+			URL: "http://proxy.example.com:3128",
+			NoProxy: []string{
+				"kms.internal",
+				".cluster.local",
+				"10.0.0.0/8",
+				"edv.example.com:8443",
+				"https://hub.example.com",
+			},
+		})
+		require.NoError(t, err)
+
+		tests := []struct {
+			url     string
+			proxied bool
+		}{
+			{url: "https://op.example.com/token", proxied: true},
+			{url: "https://kms.internal/kms", proxied: false},
+			{url: "https://KMS.internal:9443/kms", proxied: false},
+			{url: "http://edv.edge.svc.cluster.local/vaults", proxied: false},
+			{url: "http://cluster.local/vaults", proxied: false},
+			{url: "https://10.1.2.3/keystores", proxied: false},
+			{url: "https://11.1.2.3/keystores", proxied: true},
+			{url: "https://edv.example.com:8443/vaults", proxied: false},
+			{url: "https://edv.example.com/vaults", proxied: true},
+			{url: "https://hub.example.com:443/gnap", proxied: false},
+			{url: "http://hub.example.com/gnap", proxied: true},
+		}
+
+		for _, test := range tests {
+			proxyURL, err := proxyFunc(httptest.NewRequest(http.MethodGet, test.url, nil))
+			require.NoError(t, err)
+
+			if test.proxied {
+				require.NotNil(t, proxyURL, test.url)
+				require.Equal(t, "proxy.example.com:3128", proxyURL.Host)
+			} else {
+				require.Nil(t, proxyURL, test.url)
+			}
+		}
+	})
+
+	t.Run("authenticates to the proxy", func(t *testing.T) {
+		var auth string
+
+		proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Proxy-Authorization")
+		}))
+		defer proxyServer.Close()
+
+		proxyFunc, err := proxy.New(&proxy.Config{URL: proxyServer.URL, Username: "agent", Password: "secret"})
+		require.NoError(t, err)
+
+		client := &http.Client{Transport: &http.Transport{Proxy: proxyFunc}}
+
+		resp, err := client.Get("http://op.example.com/userinfo") // nolint:noctx // test
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("agent:secret")), auth)
+	})
+
+	t.Run("error if the proxy URL is invalid", func(t *testing.T) {
+		_, err := proxy.New(&proxy.Config{URL: "proxy"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid proxy URL 'proxy'")
+	})
+
+	t.Run("error if a no-proxy destination is invalid", func(t *testing.T) {
+		_, err := proxy.New(&proxy.Config{URL: "http://proxy.example.com", NoProxy: []string{"https://"}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid no-proxy URL")
+
+		_, err = proxy.New(&proxy.Config{URL: "http://proxy.example.com", NoProxy: []string{" "}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "empty no-proxy destination")
+	})
+}
@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// APNsProductionURL is the URL of the production environment of APNs.
+	APNsProductionURL = "https://api.push.apple.com"
+	// APNsDevelopmentURL is the URL of the development environment of APNs.
+	APNsDevelopmentURL = "https://api.sandbox.push.apple.com"
+	// APNs rejects the provider tokens older than an hour, and the ones renewed more than once every 20 minutes.
+	apnsTokenLifetime = 45 * time.Minute
+)
+
+// the reasons of the APNs errors telling that the device token is no longer valid. The errors of a misconfigured
+// topic or key must not unregister the devices.
+// nolint:gochecknoglobals // constant lookup table
+var apnsUnregistered = map[string]bool{"BadDeviceToken": true, "Unregistered": true}
+
+// APNsConfig holds the configuration of the APNs provider.
+type APNsConfig struct {
+	// Key is the token signing key of the Apple developer account, identified by KeyID.
+	Key    *ecdsa.PrivateKey
+	KeyID  string
+	TeamID string
+	// Topic is the bundle id of the app.
+	Topic      string
+	HTTPClient HTTPClient
+	// URL of APNs. Defaults to APNsProductionURL.
+	URL string
+}
+
+// APNs sends notifications with the Apple Push Notification service, authenticated with provider tokens.
+type APNs struct {
+	topic      string
+	tokens     *tokenCache
+	httpClient HTTPClient
+	url        string
+}
+
+type apsDictionary struct {
+	Alert *apsAlert `json:"alert"`
+	Sound string    `json:"sound,omitempty"`
+}
+
+type apsAlert struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type apnsError struct {
+	Reason string `json:"reason"`
+}
+
+// NewAPNs returns a new APNs provider.
+func NewAPNs(config *APNsConfig) (*APNs, error) {
+	if config.Key == nil || config.KeyID == "" || config.TeamID == "" || config.Topic == "" {
+		return nil, errors.New("missing APNs key, key id, team id or topic")
+	}
+
+	a := &APNs{
+		topic:      config.Topic,
+		httpClient: config.HTTPClient,
+		url:        strings.TrimSuffix(config.URL, "/"),
+		tokens: &tokenCache{
+			lifetime: apnsTokenLifetime,
+			sign: func(now time.Time) (string, error) {
+				return signES256(config.Key,
+					map[string]interface{}{"kid": config.KeyID},
+					map[string]interface{}{"iss": config.TeamID, "iat": now.Unix()})
+			},
+		},
+	}
+
+	if a.httpClient == nil {
+		a.httpClient = &http.Client{}
+	}
+
+	if a.url == "" {
+		a.url = APNsProductionURL
+	}
+
+	return a, nil
+}
+
+// Send sends the notification to the device token of the device.
+func (a *APNs) Send(ctx context.Context, device *Device, n *Notification) error {
+	// the data of the notification are custom keys of the payload, next to the aps dictionary
+	payload := make(map[string]interface{}, len(n.Data)+1)
+
+	for k, v := range n.Data {
+		payload[k] = v
+	}
+
+	payload["aps"] = &apsDictionary{Alert: &apsAlert{Title: n.Title, Body: n.Body}, Sound: "default"}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal APNs payload: %w", err)
+	}
+
+	token, err := a.tokens.get()
+	if err != nil {
+		return fmt.Errorf("failed to sign APNs provider token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+"/3/device/"+device.Token, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create APNs request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", a.topic)
+	req.Header.Set("apns-push-type", "alert")
+
+	status, reply, err := do(a.httpClient, req)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusOK {
+		return nil
+	}
+
+	e := &apnsError{}
+	if status == http.StatusGone || json.Unmarshal([]byte(reply), e) == nil && apnsUnregistered[e.Reason] {
+		return fmt.Errorf("APNs: %w", ErrUnregistered)
+	}
+
+	return fmt.Errorf("APNs returned status %d: %s", status, reply)
+}
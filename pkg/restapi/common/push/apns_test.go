@@ -0,0 +1,231 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/push"
+)
+
+func TestAPNs(t *testing.T) {
+	t.Run("sends notifications with a provider token", func(t *testing.T) {
+		key := newECKey(t)
+
+		var (
+			mutex    sync.Mutex
+			payloads []map[string]interface{}
+			tokens   []string
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/3/device/device-token", r.URL.Path)
+			require.Equal(t, "com.example.wallet", r.Header.Get("apns-topic"))
+			require.Equal(t, "alert", r.Header.Get("apns-push-type"))
+
+			payload := make(map[string]interface{})
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			payloads = append(payloads, payload)
+			tokens = append(tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "bearer "))
+		}))
+		defer server.Close()
+
+		apns, err := push.NewAPNs(&push.APNsConfig{
+			Key:    key,
+			KeyID:  "key",
+			TeamID: "team",
+			Topic:  "com.example.wallet",
+			URL:    server.URL,
+		})
+		require.NoError(t, err)
+
+		device := &push.Device{ID: "1", Platform: push.PlatformAPNs, Token: "device-token"}
+
+		require.NoError(t, apns.Send(context.Background(), device, notification()))
+		require.NoError(t, apns.Send(context.Background(), device, notification()))
+
+		require.Len(t, payloads, 2)
+		require.Equal(t, "event", payloads[0]["id"])
+		require.Equal(t, map[string]interface{}{"title": "title", "body": "body"},
+			payloads[0]["aps"].(map[string]interface{})["alert"])
+
+		// the provider token is reused
+		require.Equal(t, tokens[0], tokens[1])
+
+		header, claims := verifyES256(t, &key.PublicKey, tokens[0])
+		require.Equal(t, "key", header["kid"])
+		require.Equal(t, "team", claims["iss"])
+		require.NotEmpty(t, claims["iat"])
+	})
+
+	t.Run("tells the unregistered devices", func(t *testing.T) {
+		for status, reply := range map[int]string{
+			http.StatusGone:       `{"reason":"Unregistered"}`,
+			http.StatusBadRequest: `{"reason":"BadDeviceToken"}`,
+		} {
+			apns := newAPNs(t, status, reply)
+
+			err := apns.Send(context.Background(), &push.Device{Token: "device-token"}, notification())
+			require.True(t, errors.Is(err, push.ErrUnregistered))
+		}
+	})
+
+	t.Run("error if APNs fails", func(t *testing.T) {
+		apns := newAPNs(t, http.StatusBadRequest, `{"reason":"DeviceTokenNotForTopic"}`)
+
+		err := apns.Send(context.Background(), &push.Device{Token: "device-token"}, notification())
+		require.Error(t, err)
+		require.False(t, errors.Is(err, push.ErrUnregistered))
+		require.Contains(t, err.Error(), "APNs returned status 400")
+	})
+
+	t.Run("error if APNs cannot be reached", func(t *testing.T) {
+		apns, err := push.NewAPNs(&push.APNsConfig{
+			Key:        newECKey(t),
+			KeyID:      "key",
+			TeamID:     "team",
+			Topic:      "com.example.wallet",
+			HTTPClient: &mockHTTPClient{err: errors.New("test")},
+		})
+		require.NoError(t, err)
+
+		err = apns.Send(context.Background(), &push.Device{Token: "device-token"}, notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to send notification")
+	})
+
+	t.Run("error if config is incomplete", func(t *testing.T) {
+		_, err := push.NewAPNs(&push.APNsConfig{Key: newECKey(t), KeyID: "key", TeamID: "team"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing APNs key, key id, team id or topic")
+	})
+}
+
+func TestParseECKey(t *testing.T) {
+	key := newECKey(t)
+
+	t.Run("parses PKCS #8 keys", func(t *testing.T) {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+
+		parsed, err := push.ParseECKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		require.NoError(t, err)
+		require.True(t, key.Equal(parsed))
+	})
+
+	t.Run("parses SEC 1 keys", func(t *testing.T) {
+		der, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+
+		parsed, err := push.ParseECKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+		require.NoError(t, err)
+		require.True(t, key.Equal(parsed))
+	})
+
+	t.Run("error if not a P-256 key", func(t *testing.T) {
+		other, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+
+		der, err := x509.MarshalPKCS8PrivateKey(other)
+		require.NoError(t, err)
+
+		_, err = push.ParseECKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not a P-256 private key")
+	})
+
+	t.Run("error if not a private key", func(t *testing.T) {
+		_, err := push.ParseECKey([]byte("key"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no PEM block found")
+
+		_, err = push.ParseECKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse private key")
+	})
+}
+
+func newAPNs(t *testing.T, status int, reply string) *push.APNs {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(reply))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	apns, err := push.NewAPNs(&push.APNsConfig{
+		Key:    newECKey(t),
+		KeyID:  "key",
+		TeamID: "team",
+		Topic:  "com.example.wallet",
+		URL:    server.URL,
+	})
+	require.NoError(t, err)
+
+	return apns
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return key
+}
+
+// verifyES256 verifies the signature of the JWT, and returns its header and claims.
+func verifyES256(t *testing.T, key *ecdsa.PublicKey, token string) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	require.Len(t, signature, 64)
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.True(t, ecdsa.Verify(key, digest[:],
+		new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])))
+
+	header := make(map[string]interface{})
+	claims := make(map[string]interface{})
+
+	for i, v := range []map[string]interface{}{header, claims} {
+		bits, err := base64.RawURLEncoding.DecodeString(parts[i])
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(bits, &v))
+	}
+
+	require.Equal(t, "ES256", header["alg"])
+
+	return header, claims
+}
@@ -0,0 +1,166 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// DeviceStoreName is the name of the device store.
+	DeviceStoreName = "edgeagent_push_devices"
+)
+
+// Device is a device of a user receiving push notifications.
+type Device struct {
+	ID       string `json:"id"`
+	Platform string `json:"platform"`
+	// Token is the registration token of an FCM device or the device token of an APNs device.
+	Token string `json:"token,omitempty"`
+	// Subscription is the push subscription of a WebPush device.
+	Subscription *Subscription `json:"subscription,omitempty"`
+	Registered   time.Time     `json:"registered"`
+}
+
+// Subscription is the PushSubscription of a browser, as serialized by PushSubscription.toJSON().
+type Subscription struct {
+	Endpoint string           `json:"endpoint"`
+	Keys     SubscriptionKeys `json:"keys"`
+}
+
+// SubscriptionKeys are the base64url encoded keys of a push subscription.
+type SubscriptionKeys struct {
+	// P256DH is the public key of the browser the notifications are encrypted for.
+	P256DH string `json:"p256dh"`
+	// Auth is the authentication secret of the encryption.
+	Auth string `json:"auth"`
+}
+
+// address is where the push service delivers the notifications of the device.
+func (d *Device) address() string {
+	if d.Subscription != nil {
+		return d.Subscription.Endpoint
+	}
+
+	return d.Token
+}
+
+// the devices of a user.
+type devices struct {
+	Devices []*Device `json:"devices"`
+}
+
+// NewDeviceStore returns a new DeviceStore.
+func NewDeviceStore(p storage.Provider) (*DeviceStore, error) {
+	s, err := store.Open(p, DeviceStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device store: %w", err)
+	}
+
+	return &DeviceStore{s: s}, nil
+}
+
+// DeviceStore keeps the devices of each user in a single document, updated under a lock: the devices of a user must
+// only be updated by a single DeviceStore.
+type DeviceStore struct {
+	s   storage.Store
+	mux sync.Mutex
+}
+
+// Save registers the device of the user. A device registered already with the same token or subscription endpoint
+// is replaced.
+func (s *DeviceStore) Save(sub string, d *Device) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	all, err := s.get(sub)
+	if err != nil {
+		return err
+	}
+
+	registered := make([]*Device, 0, len(all.Devices)+1)
+
+	for _, existing := range all.Devices {
+		if existing.Platform != d.Platform || existing.address() != d.address() {
+			registered = append(registered, existing)
+		}
+	}
+
+	all.Devices = append(registered, d)
+
+	return s.put(sub, all)
+}
+
+// List returns the devices of the user.
+func (s *DeviceStore) List(sub string) ([]*Device, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	all, err := s.get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return all.Devices, nil
+}
+
+// Delete unregisters the device of the user. It returns storage.ErrValueNotFound if the user has no such device.
+func (s *DeviceStore) Delete(sub, id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	all, err := s.get(sub)
+	if err != nil {
+		return err
+	}
+
+	for i, d := range all.Devices {
+		if d.ID == id {
+			all.Devices = append(all.Devices[:i], all.Devices[i+1:]...)
+
+			return s.put(sub, all)
+		}
+	}
+
+	return fmt.Errorf("device %s: %w", id, storage.ErrValueNotFound)
+}
+
+func (s *DeviceStore) get(sub string) (*devices, error) {
+	all := &devices{}
+
+	raw, err := s.s.Get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return all, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+
+	err = json.Unmarshal(raw, all)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal devices: %w", err)
+	}
+
+	return all, nil
+}
+
+func (s *DeviceStore) put(sub string, all *devices) error {
+	err := store.Save(s.s, sub, all)
+	if err != nil {
+		return fmt.Errorf("failed to save devices: %w", err)
+	}
+
+	return nil
+}
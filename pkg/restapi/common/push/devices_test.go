@@ -0,0 +1,135 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/push"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestDeviceStore(t *testing.T) {
+	t.Run("keeps the devices of each user", func(t *testing.T) {
+		s := newDeviceStore(t)
+
+		require.NoError(t, s.Save("alice", fcmDevice("1", "token-1")))
+		require.NoError(t, s.Save("bob", fcmDevice("2", "token-2")))
+		require.NoError(t, s.Save("alice", webPushDevice("3", "https://push.example.com/1")))
+
+		require.Equal(t, []string{"1", "3"}, deviceIDs(t, s, "alice"))
+		require.Equal(t, []string{"2"}, deviceIDs(t, s, "bob"))
+		require.Empty(t, deviceIDs(t, s, "carol"))
+	})
+
+	t.Run("replaces the devices registered again", func(t *testing.T) {
+		s := newDeviceStore(t)
+
+		require.NoError(t, s.Save("alice", fcmDevice("1", "token-1")))
+		require.NoError(t, s.Save("alice", webPushDevice("2", "https://push.example.com/1")))
+		require.NoError(t, s.Save("alice", fcmDevice("3", "token-1")))
+		require.NoError(t, s.Save("alice", webPushDevice("4", "https://push.example.com/1")))
+		require.NoError(t, s.Save("alice", &push.Device{ID: "5", Platform: push.PlatformAPNs, Token: "token-1"}))
+
+		require.Equal(t, []string{"3", "4", "5"}, deviceIDs(t, s, "alice"))
+	})
+
+	t.Run("deletes devices", func(t *testing.T) {
+		s := newDeviceStore(t)
+
+		require.NoError(t, s.Save("alice", fcmDevice("1", "token-1")))
+		require.NoError(t, s.Save("alice", fcmDevice("2", "token-2")))
+		require.NoError(t, s.Delete("alice", "1"))
+		require.Equal(t, []string{"2"}, deviceIDs(t, s, "alice"))
+
+		err := s.Delete("alice", "1")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		err = s.Delete("bob", "2")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if store cannot be opened", func(t *testing.T) {
+		_, err := push.NewDeviceStore(&mockstore.Provider{ErrOpenStoreHandle: errors.New("test")})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open device store")
+	})
+
+	t.Run("error if devices cannot be fetched", func(t *testing.T) {
+		s, err := push.NewDeviceStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{"alice": []byte(`{"devices":[]}`)},
+			ErrGet: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		require.Error(t, s.Save("alice", fcmDevice("1", "token-1")))
+		require.Error(t, s.Delete("alice", "1"))
+
+		_, err = s.List("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch devices")
+	})
+
+	t.Run("error if devices are corrupted", func(t *testing.T) {
+		s, err := push.NewDeviceStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{"alice": []byte("{")},
+		}})
+		require.NoError(t, err)
+
+		_, err = s.List("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal devices")
+	})
+
+	t.Run("error if devices cannot be saved", func(t *testing.T) {
+		s, err := push.NewDeviceStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  make(map[string][]byte),
+			ErrPut: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		err = s.Save("alice", fcmDevice("1", "token-1"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save devices")
+	})
+}
+
+func newDeviceStore(t *testing.T) *push.DeviceStore {
+	t.Helper()
+
+	s, err := push.NewDeviceStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	return s
+}
+
+func fcmDevice(id, token string) *push.Device {
+	return &push.Device{ID: id, Platform: push.PlatformFCM, Token: token}
+}
+
+func webPushDevice(id, endpoint string) *push.Device {
+	return &push.Device{ID: id, Platform: push.PlatformWebPush, Subscription: &push.Subscription{Endpoint: endpoint}}
+}
+
+func deviceIDs(t *testing.T, s *push.DeviceStore, sub string) []string {
+	t.Helper()
+
+	devices, err := s.List(sub)
+	require.NoError(t, err)
+
+	result := make([]string, 0, len(devices))
+
+	for _, d := range devices {
+		result = append(result, d.ID)
+	}
+
+	return result
+}
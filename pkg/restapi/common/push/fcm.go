@@ -0,0 +1,188 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	defaultFCMURL      = "https://fcm.googleapis.com"
+	defaultFCMTokenURL = "https://oauth2.googleapis.com/token"
+	fcmScope           = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmUnregistered    = "UNREGISTERED"
+)
+
+// FCMConfig holds the configuration of the FCM provider.
+type FCMConfig struct {
+	ProjectID string
+	// TokenSource returns the access tokens of the service account sending the notifications. See FCMCredentials.
+	TokenSource oauth2.TokenSource
+	HTTPClient  HTTPClient
+	// URL of the FCM API. Defaults to https://fcm.googleapis.com.
+	URL string
+}
+
+// FCM sends notifications with the HTTP v1 API of Firebase Cloud Messaging.
+type FCM struct {
+	projectID  string
+	tokens     oauth2.TokenSource
+	httpClient HTTPClient
+	url        string
+}
+
+type serviceAccount struct {
+	ProjectID    string `json:"project_id"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type fcmRequest struct {
+	Message *fcmMessage `json:"message"`
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification *fcmNotification  `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type fcmError struct {
+	Error struct {
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// FCMCredentials returns the project and the token source of the JSON key of a Firebase service account. The
+// access tokens are requested with the HTTP client of the context, if any, and reused until they expire.
+func FCMCredentials(ctx context.Context, key []byte) (string, oauth2.TokenSource, error) {
+	account := &serviceAccount{}
+
+	err := json.Unmarshal(key, account)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+
+	if account.ProjectID == "" || account.ClientEmail == "" || account.PrivateKey == "" {
+		return "", nil, errors.New("service account key must have a project_id, client_email and private_key")
+	}
+
+	tokenURL := account.TokenURI
+	if tokenURL == "" {
+		tokenURL = defaultFCMTokenURL
+	}
+
+	config := &jwt.Config{
+		Email:        account.ClientEmail,
+		PrivateKey:   []byte(account.PrivateKey),
+		PrivateKeyID: account.PrivateKeyID,
+		Scopes:       []string{fcmScope},
+		TokenURL:     tokenURL,
+	}
+
+	return account.ProjectID, config.TokenSource(ctx), nil
+}
+
+// NewFCM returns a new FCM provider.
+func NewFCM(config *FCMConfig) (*FCM, error) {
+	if config.ProjectID == "" || config.TokenSource == nil {
+		return nil, errors.New("missing FCM project or credentials")
+	}
+
+	f := &FCM{
+		projectID:  config.ProjectID,
+		tokens:     config.TokenSource,
+		httpClient: config.HTTPClient,
+		url:        strings.TrimSuffix(config.URL, "/"),
+	}
+
+	if f.httpClient == nil {
+		f.httpClient = &http.Client{}
+	}
+
+	if f.url == "" {
+		f.url = defaultFCMURL
+	}
+
+	return f, nil
+}
+
+// Send sends the notification to the registration token of the device.
+func (f *FCM) Send(ctx context.Context, device *Device, n *Notification) error {
+	body, err := json.Marshal(&fcmRequest{Message: &fcmMessage{
+		Token:        device.Token,
+		Notification: &fcmNotification{Title: n.Title, Body: n.Body},
+		Data:         n.Data,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal FCM message: %w", err)
+	}
+
+	token, err := f.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get FCM access token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/v1/projects/%s/messages:send", f.url, f.projectID), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create FCM request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	token.SetAuthHeader(req)
+
+	status, reply, err := do(f.httpClient, req)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusOK {
+		return nil
+	}
+
+	// the 404 responses of a misconfigured project must not unregister the devices
+	if isUnregistered(reply) {
+		return fmt.Errorf("FCM: %w", ErrUnregistered)
+	}
+
+	return fmt.Errorf("FCM returned status %d: %s", status, reply)
+}
+
+func isUnregistered(reply string) bool {
+	e := &fcmError{}
+
+	if json.Unmarshal([]byte(reply), e) != nil {
+		return false
+	}
+
+	for _, detail := range e.Error.Details {
+		if detail.ErrorCode == fcmUnregistered {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,189 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/push"
+)
+
+func TestFCM(t *testing.T) {
+	t.Run("sends notifications with the access token of the service account", func(t *testing.T) {
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+			require.NotEmpty(t, r.PostForm.Get("assertion"))
+
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(`{"access_token":"access-token","token_type":"Bearer","expires_in":3600}`))
+			require.NoError(t, err)
+		}))
+		defer tokenServer.Close()
+
+		var message map[string]map[string]interface{}
+
+		fcmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/v1/projects/project/messages:send", r.URL.Path)
+			require.Equal(t, "Bearer access-token", r.Header.Get("Authorization"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+		}))
+		defer fcmServer.Close()
+
+		projectID, tokens, err := push.FCMCredentials(context.Background(), serviceAccountKey(t, tokenServer.URL))
+		require.NoError(t, err)
+		require.Equal(t, "project", projectID)
+
+		fcm, err := push.NewFCM(&push.FCMConfig{ProjectID: projectID, TokenSource: tokens, URL: fcmServer.URL + "/"})
+		require.NoError(t, err)
+
+		err = fcm.Send(context.Background(), fcmDevice("1", "registration-token"), notification())
+		require.NoError(t, err)
+
+		require.Equal(t, "registration-token", message["message"]["token"])
+		require.Equal(t, map[string]interface{}{"title": "title", "body": "body"}, message["message"]["notification"])
+		require.Equal(t, map[string]interface{}{"id": "event"}, message["message"]["data"])
+	})
+
+	t.Run("tells the unregistered devices", func(t *testing.T) {
+		fcm := newFCM(t, http.StatusNotFound,
+			`{"error":{"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`)
+
+		err := fcm.Send(context.Background(), fcmDevice("1", "registration-token"), notification())
+		require.True(t, errors.Is(err, push.ErrUnregistered))
+	})
+
+	t.Run("error if FCM fails", func(t *testing.T) {
+		fcm := newFCM(t, http.StatusNotFound, `{"error":{"status":"NOT_FOUND"}}`)
+
+		err := fcm.Send(context.Background(), fcmDevice("1", "registration-token"), notification())
+		require.Error(t, err)
+		require.False(t, errors.Is(err, push.ErrUnregistered))
+		require.Contains(t, err.Error(), "FCM returned status 404")
+
+		fcm = newFCM(t, http.StatusInternalServerError, "{")
+
+		err = fcm.Send(context.Background(), fcmDevice("1", "registration-token"), notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "FCM returned status 500")
+	})
+
+	t.Run("error if access token cannot be fetched", func(t *testing.T) {
+		fcm, err := push.NewFCM(&push.FCMConfig{
+			ProjectID:   "project",
+			TokenSource: &mockTokenSource{err: errors.New("test")},
+		})
+		require.NoError(t, err)
+
+		err = fcm.Send(context.Background(), fcmDevice("1", "registration-token"), notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get FCM access token")
+	})
+
+	t.Run("error if FCM cannot be reached", func(t *testing.T) {
+		fcm, err := push.NewFCM(&push.FCMConfig{
+			ProjectID:   "project",
+			TokenSource: &mockTokenSource{},
+			HTTPClient:  &mockHTTPClient{err: errors.New("test")},
+		})
+		require.NoError(t, err)
+
+		err = fcm.Send(context.Background(), fcmDevice("1", "registration-token"), notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to send notification")
+	})
+
+	t.Run("error if config is incomplete", func(t *testing.T) {
+		_, err := push.NewFCM(&push.FCMConfig{ProjectID: "project"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing FCM project or credentials")
+	})
+
+	t.Run("error if service account key is invalid", func(t *testing.T) {
+		_, _, err := push.FCMCredentials(context.Background(), []byte("{"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse service account key")
+
+		_, _, err = push.FCMCredentials(context.Background(), []byte(`{"project_id":"project"}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "must have a project_id, client_email and private_key")
+	})
+}
+
+func newFCM(t *testing.T, status int, reply string) *push.FCM {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, err := w.Write([]byte(reply))
+		require.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	fcm, err := push.NewFCM(&push.FCMConfig{ProjectID: "project", TokenSource: &mockTokenSource{}, URL: server.URL})
+	require.NoError(t, err)
+
+	return fcm
+}
+
+func serviceAccountKey(t *testing.T, tokenURL string) []byte {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	bits, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "project",
+		"private_key_id": "key",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"client_email":   "push@project.iam.gserviceaccount.com",
+		"token_uri":      tokenURL,
+	})
+	require.NoError(t, err)
+
+	return bits
+}
+
+func notification() *push.Notification {
+	return &push.Notification{Title: "title", Body: "body", Data: map[string]string{"id": "event"}}
+}
+
+type mockTokenSource struct {
+	err error
+}
+
+func (m *mockTokenSource) Token() (*oauth2.Token, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return &oauth2.Token{AccessToken: "access-token", TokenType: "Bearer"}, nil
+}
+
+type mockHTTPClient struct {
+	err error
+}
+
+func (m *mockHTTPClient) Do(*http.Request) (*http.Response, error) {
+	return nil, m.err
+}
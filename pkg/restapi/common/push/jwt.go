@@ -0,0 +1,126 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// the size of the coordinates and scalars of P-256.
+const p256Size = 32
+
+// ParseECKey parses a PEM encoded P-256 private key, in PKCS #8 (e.g. an APNs .p8 key) or SEC 1 form.
+func ParseECKey(pemBytes []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve != elliptic.P256() {
+		return nil, errors.New("not a P-256 private key")
+	}
+
+	return ecKey, nil
+}
+
+// signES256 returns the compact serialization of the JWT of the claims, signed with ES256.
+func signES256(key *ecdsa.PrivateKey, header, claims map[string]interface{}) (string, error) {
+	header["alg"] = "ES256"
+
+	encodedHeader, err := encodeSegment(header)
+	if err != nil {
+		return "", err
+	}
+
+	encodedClaims, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodedHeader + "." + encodedClaims
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign jwt: %w", err)
+	}
+
+	// JWS signatures are the fixed size big-endian R and S
+	signature := make([]byte, 2*p256Size)
+	r.FillBytes(signature[:p256Size])
+	s.FillBytes(signature[p256Size:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	bits, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal jwt: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bits), nil
+}
+
+// tokenCache reuses a signed token until it is about to expire, as push services throttle the callers that sign a
+// token per request.
+type tokenCache struct {
+	lifetime time.Duration
+	sign     func(now time.Time) (string, error)
+	mutex    sync.Mutex
+	token    string
+	expiry   time.Time
+}
+
+func (c *tokenCache) get() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	if c.token != "" && now.Before(c.expiry) {
+		return c.token, nil
+	}
+
+	token, err := c.sign(now)
+	if err != nil {
+		return "", err
+	}
+
+	c.token = token
+	c.expiry = now.Add(c.lifetime)
+
+	return token, nil
+}
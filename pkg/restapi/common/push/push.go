@@ -0,0 +1,80 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Platforms of the devices.
+const (
+	// PlatformFCM devices are Android and iOS apps registered with Firebase Cloud Messaging.
+	PlatformFCM = "fcm"
+	// PlatformAPNs devices are iOS apps registered with the Apple Push Notification service.
+	PlatformAPNs = "apns"
+	// PlatformWebPush devices are browsers subscribed with the Push API.
+	PlatformWebPush = "webpush"
+)
+
+// maximum size of the error responses read from the push services.
+const maxErrorSize = 4096
+
+var logger = log.New("edge-agent/push")
+
+// ErrUnregistered is returned when the push service no longer knows the device, e.g. the app was uninstalled.
+// The device should be removed.
+var ErrUnregistered = errors.New("device is unregistered")
+
+// Notification is shown by the device to the user.
+type Notification struct {
+	Title string
+	Body  string
+	// Data is passed to the app along with the notification.
+	Data map[string]string
+}
+
+// Provider sends notifications to the devices of a platform.
+type Provider interface {
+	Send(ctx context.Context, device *Device, n *Notification) error
+}
+
+// HTTPClient sends HTTP requests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// do sends the request, and returns the response status and the body of the error responses.
+func do(httpClient HTTPClient, req *http.Request) (int, string, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to send notification: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Errorf("failed to close response body: %s", errClose)
+		}
+	}()
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return resp.StatusCode, "", nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+	if err != nil {
+		logger.Debugf("failed to read error response: %s", err)
+	}
+
+	return resp.StatusCode, string(body), nil
+}
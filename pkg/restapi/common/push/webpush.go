@@ -0,0 +1,278 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	defaultWebPushTTL = 24 * time.Hour
+	// the VAPID tokens are valid for 12 hours, and renewed an hour ahead of their expiry.
+	vapidTokenExpiry   = 12 * time.Hour
+	vapidTokenLifetime = 11 * time.Hour
+	// RFC 8291 parameters.
+	authSecretSize = 16
+	saltSize       = 16
+	keySize        = 16
+	nonceSize      = 12
+	recordSize     = 4096
+	// the delimiter of the last record, followed by no padding.
+	lastRecordDelimiter = 0x02
+)
+
+// WebPushConfig holds the configuration of the WebPush provider.
+type WebPushConfig struct {
+	// Key is the VAPID key identifying the server to the push services. The browsers subscribe with its public key,
+	// see WebPush.PublicKey.
+	Key *ecdsa.PrivateKey
+	// Subject is a mailto: or https: URL the push services contact the operator of the server at.
+	Subject string
+	// TTL is how long the push services keep the notifications of the offline browsers. Defaults to 24h.
+	TTL        time.Duration
+	HTTPClient HTTPClient
+}
+
+// WebPush sends notifications to browsers with the Web Push protocol (RFC 8030), encrypted as per RFC 8291 and
+// authenticated with VAPID (RFC 8292).
+type WebPush struct {
+	key        *ecdsa.PrivateKey
+	publicKey  string
+	subject    string
+	ttl        time.Duration
+	httpClient HTTPClient
+	mutex      sync.Mutex
+	// the VAPID tokens by push service origin
+	tokens map[string]*tokenCache
+}
+
+type webPushPayload struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// NewWebPush returns a new WebPush provider.
+func NewWebPush(config *WebPushConfig) (*WebPush, error) {
+	if config.Key == nil || config.Key.Curve != elliptic.P256() {
+		return nil, errors.New("missing P-256 VAPID key")
+	}
+
+	if !strings.HasPrefix(config.Subject, "mailto:") && !strings.HasPrefix(config.Subject, "https:") {
+		return nil, errors.New("VAPID subject must be a mailto: or https: URL")
+	}
+
+	w := &WebPush{
+		key:        config.Key,
+		publicKey:  base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), config.Key.X, config.Key.Y)),
+		subject:    config.Subject,
+		ttl:        config.TTL,
+		httpClient: config.HTTPClient,
+		tokens:     make(map[string]*tokenCache),
+	}
+
+	if w.ttl <= 0 {
+		w.ttl = defaultWebPushTTL
+	}
+
+	if w.httpClient == nil {
+		w.httpClient = &http.Client{}
+	}
+
+	return w, nil
+}
+
+// PublicKey returns the base64url encoded VAPID public key, the applicationServerKey of the subscriptions.
+func (w *WebPush) PublicKey() string {
+	return w.publicKey
+}
+
+// Send encrypts the notification for the subscription of the device, and sends it to the push service of the
+// browser.
+func (w *WebPush) Send(ctx context.Context, device *Device, n *Notification) error {
+	if device.Subscription == nil {
+		return errors.New("missing push subscription")
+	}
+
+	endpoint, err := url.Parse(device.Subscription.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid push subscription endpoint: %w", err)
+	}
+
+	payload, err := json.Marshal(&webPushPayload{Title: n.Title, Body: n.Body, Data: n.Data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal WebPush payload: %w", err)
+	}
+
+	body, err := encrypt(&device.Subscription.Keys, payload)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt WebPush payload: %w", err)
+	}
+
+	token, err := w.token(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return fmt.Errorf("failed to sign VAPID token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create WebPush request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(w.ttl.Seconds())))
+	req.Header.Set("Authorization", fmt.Sprintf("vapid t=%s, k=%s", token, w.publicKey))
+
+	status, reply, err := do(w.httpClient, req)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status >= http.StatusOK && status < http.StatusMultipleChoices:
+		return nil
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return fmt.Errorf("WebPush: %w", ErrUnregistered)
+	default:
+		return fmt.Errorf("WebPush returned status %d: %s", status, reply)
+	}
+}
+
+// token returns the VAPID token of the push service.
+func (w *WebPush) token(audience string) (string, error) {
+	w.mutex.Lock()
+
+	cache, ok := w.tokens[audience]
+	if !ok {
+		cache = &tokenCache{
+			lifetime: vapidTokenLifetime,
+			sign: func(now time.Time) (string, error) {
+				return signES256(w.key,
+					map[string]interface{}{"typ": "JWT"},
+					map[string]interface{}{
+						"aud": audience,
+						"exp": now.Add(vapidTokenExpiry).Unix(),
+						"sub": w.subject,
+					})
+			},
+		}
+		w.tokens[audience] = cache
+	}
+
+	w.mutex.Unlock()
+
+	return cache.get()
+}
+
+// encrypt encrypts the payload for the browser as a single aes128gcm record (RFC 8291 and RFC 8188).
+func encrypt(keys *SubscriptionKeys, payload []byte) ([]byte, error) {
+	uaPublic, err := decodeBase64URL(keys.P256DH)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+
+	uaX, uaY := elliptic.Unmarshal(elliptic.P256(), uaPublic)
+	if uaX == nil {
+		return nil, errors.New("invalid p256dh key: not a P-256 point")
+	}
+
+	authSecret, err := decodeBase64URL(keys.Auth)
+	if err != nil || len(authSecret) != authSecretSize {
+		return nil, errors.New("invalid auth secret")
+	}
+
+	if len(payload)+1+aes.BlockSize > recordSize {
+		return nil, errors.New("payload is too large")
+	}
+
+	// the ephemeral key of the application server
+	asPrivate, asX, asY, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	asPublic := elliptic.Marshal(elliptic.P256(), asX, asY)
+
+	sharedX, _ := elliptic.P256().ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := make([]byte, p256Size)
+	sharedX.FillBytes(ecdhSecret)
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+
+	ikm := make([]byte, p256Size)
+
+	_, err = io.ReadFull(hkdf.New(sha256.New, ecdhSecret, authSecret, keyInfo), ikm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive input keying material: %w", err)
+	}
+
+	salt := make([]byte, saltSize)
+
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	cek := make([]byte, keySize)
+	nonce := make([]byte, nonceSize)
+
+	_, err = io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive content encryption key: %w", err)
+	}
+
+	_, err = io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive nonce: %w", err)
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	// the header: salt, record size, key id length and key id, the public key of the application server
+	header := make([]byte, saltSize+4+1, saltSize+4+1+len(asPublic))
+	copy(header, salt)
+	binary.BigEndian.PutUint32(header[saltSize:], recordSize)
+	header[saltSize+4] = byte(len(asPublic))
+	header = append(header, asPublic...)
+
+	return gcm.Seal(header, nonce, append(payload, lastRecordDelimiter), nil), nil
+}
+
+// decodeBase64URL decodes the keys of the subscriptions, with or without padding.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,251 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/push"
+)
+
+func TestWebPush(t *testing.T) {
+	t.Run("sends encrypted notifications with a VAPID token", func(t *testing.T) {
+		key := newECKey(t)
+		browser := newBrowser(t)
+
+		var (
+			body          []byte
+			authorization string
+		)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/push/1", r.URL.Path)
+			require.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+			require.Equal(t, "3600", r.Header.Get("TTL"))
+
+			authorization = r.Header.Get("Authorization")
+
+			var err error
+
+			body, err = ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		webPush, err := push.NewWebPush(&push.WebPushConfig{
+			Key:        key,
+			Subject:    "mailto:admin@example.com",
+			TTL:        time.Hour,
+			HTTPClient: server.Client(),
+		})
+		require.NoError(t, err)
+
+		publicKey, err := base64.RawURLEncoding.DecodeString(webPush.PublicKey())
+		require.NoError(t, err)
+		require.Equal(t, elliptic.Marshal(elliptic.P256(), key.X, key.Y), publicKey)
+
+		err = webPush.Send(context.Background(), browser.device(server.URL+"/push/1"), notification())
+		require.NoError(t, err)
+
+		payload := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(browser.decrypt(t, body), &payload))
+		require.Equal(t, "title", payload["title"])
+		require.Equal(t, "body", payload["body"])
+		require.Equal(t, map[string]interface{}{"id": "event"}, payload["data"])
+
+		require.True(t, strings.HasPrefix(authorization, "vapid t="))
+		require.True(t, strings.HasSuffix(authorization, ", k="+webPush.PublicKey()))
+
+		token := strings.TrimSuffix(strings.TrimPrefix(authorization, "vapid t="), ", k="+webPush.PublicKey())
+
+		_, claims := verifyES256(t, &key.PublicKey, token)
+		require.Equal(t, server.URL, claims["aud"])
+		require.Equal(t, "mailto:admin@example.com", claims["sub"])
+		require.NotEmpty(t, claims["exp"])
+	})
+
+	t.Run("tells the unregistered devices", func(t *testing.T) {
+		for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+			webPush, endpoint := newWebPush(t, status)
+
+			err := webPush.Send(context.Background(), newBrowser(t).device(endpoint), notification())
+			require.True(t, errors.Is(err, push.ErrUnregistered))
+		}
+	})
+
+	t.Run("error if the push service fails", func(t *testing.T) {
+		webPush, endpoint := newWebPush(t, http.StatusBadRequest)
+
+		err := webPush.Send(context.Background(), newBrowser(t).device(endpoint), notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "WebPush returned status 400")
+	})
+
+	t.Run("error if the subscription is invalid", func(t *testing.T) {
+		webPush, endpoint := newWebPush(t, http.StatusCreated)
+
+		err := webPush.Send(context.Background(), &push.Device{}, notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing push subscription")
+
+		device := newBrowser(t).device(endpoint)
+		device.Subscription.Endpoint = "%"
+
+		err = webPush.Send(context.Background(), device, notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid push subscription endpoint")
+
+		device = newBrowser(t).device(endpoint)
+		device.Subscription.Keys.P256DH = base64.RawURLEncoding.EncodeToString([]byte("key"))
+
+		err = webPush.Send(context.Background(), device, notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid p256dh key")
+
+		device = newBrowser(t).device(endpoint)
+		device.Subscription.Keys.Auth = "secret"
+
+		err = webPush.Send(context.Background(), device, notification())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid auth secret")
+	})
+
+	t.Run("error if the payload is too large", func(t *testing.T) {
+		webPush, endpoint := newWebPush(t, http.StatusCreated)
+
+		err := webPush.Send(context.Background(), newBrowser(t).device(endpoint),
+			&push.Notification{Body: strings.Repeat("a", 4096)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "payload is too large")
+	})
+
+	t.Run("error if config is invalid", func(t *testing.T) {
+		_, err := push.NewWebPush(&push.WebPushConfig{Subject: "mailto:admin@example.com"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing P-256 VAPID key")
+
+		_, err = push.NewWebPush(&push.WebPushConfig{Key: newECKey(t), Subject: "admin@example.com"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "VAPID subject must be a mailto: or https: URL")
+	})
+}
+
+func newWebPush(t *testing.T, status int) (*push.WebPush, string) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	webPush, err := push.NewWebPush(&push.WebPushConfig{Key: newECKey(t), Subject: "https://wallet.example.com"})
+	require.NoError(t, err)
+
+	return webPush, server.URL + "/push/1"
+}
+
+// browser is the user agent of a push subscription.
+type browser struct {
+	private    []byte
+	public     []byte
+	authSecret []byte
+}
+
+func newBrowser(t *testing.T) *browser {
+	t.Helper()
+
+	private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	authSecret := make([]byte, 16)
+
+	_, err = rand.Read(authSecret)
+	require.NoError(t, err)
+
+	return &browser{private: private, public: elliptic.Marshal(elliptic.P256(), x, y), authSecret: authSecret}
+}
+
+func (b *browser) device(endpoint string) *push.Device {
+	return &push.Device{ID: "1", Platform: push.PlatformWebPush, Subscription: &push.Subscription{
+		Endpoint: endpoint,
+		Keys: push.SubscriptionKeys{
+			P256DH: base64.RawURLEncoding.EncodeToString(b.public),
+			// the subscriptions of some browsers are padded
+			Auth: base64.URLEncoding.EncodeToString(b.authSecret),
+		},
+	}}
+}
+
+// decrypt decrypts the aes128gcm content as per RFC 8291.
+func (b *browser) decrypt(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	require.Greater(t, len(content), 21)
+
+	salt := content[:16]
+	require.Equal(t, uint32(4096), binary.BigEndian.Uint32(content[16:20]))
+
+	keyIDSize := int(content[20])
+	serverPublic := content[21 : 21+keyIDSize]
+	ciphertext := content[21+keyIDSize:]
+
+	x, y := elliptic.Unmarshal(elliptic.P256(), serverPublic)
+	require.NotNil(t, x)
+
+	sharedX, _ := elliptic.P256().ScalarMult(x, y, b.private)
+	secret := make([]byte, 32)
+	sharedX.FillBytes(secret)
+
+	ikm := make([]byte, 32)
+	keyInfo := append(append([]byte("WebPush: info\x00"), b.public...), serverPublic...)
+
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, b.authSecret, keyInfo), ikm)
+	require.NoError(t, err)
+
+	cek := make([]byte, 16)
+	_, err = io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	require.NoError(t, err)
+
+	nonce := make([]byte, 12)
+	_, err = io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(cek)
+	require.NoError(t, err)
+
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	require.NoError(t, err)
+
+	// the last record ends with the 0x02 delimiter
+	require.Equal(t, byte(2), plaintext[len(plaintext)-1])
+
+	return plaintext[:len(plaintext)-1]
+}
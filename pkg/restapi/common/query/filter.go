@@ -0,0 +1,232 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operator of a Condition.
+type Operator string
+
+// Operators, longest first for the parser.
+const (
+	Equal          Operator = "=="
+	NotEqual       Operator = "!="
+	LessOrEqual    Operator = "<="
+	GreaterOrEqual Operator = ">="
+	Less           Operator = "<"
+	Greater        Operator = ">"
+)
+
+var operators = []Operator{Equal, NotEqual, LessOrEqual, GreaterOrEqual, Less, Greater}
+
+// Condition on a field of the items.
+type Condition struct {
+	Field    string
+	Operator Operator
+	// Values are string, time.Time, float64 or bool, as the type of the field. Only the == and != conditions have
+	// several.
+	Values []interface{}
+}
+
+// Filter holds the conditions the items match.
+type Filter []*Condition
+
+// Fields returns the value of a field of an item: a string, []string, time.Time, *time.Time, an integer, float64
+// or bool, or nil if the item has none.
+type Fields func(field string) interface{}
+
+// Matches tells whether the item matches all the conditions. The stores filtering the items themselves translate
+// the conditions instead.
+func (f Filter) Matches(fields Fields) bool {
+	for _, c := range f {
+		if !c.matches(fields(c.Field)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *Condition) matches(value interface{}) bool {
+	if values, ok := value.([]string); ok {
+		// the lists match if any of their elements does, and none does for !=
+		if c.Operator == NotEqual {
+			for _, v := range values {
+				if !c.matches(v) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+		for _, v := range values {
+			if c.matches(v) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	switch c.Operator {
+	case Equal, NotEqual:
+		found := false
+
+		for _, v := range c.Values {
+			if cmp, ok := compare(value, v); ok && cmp == 0 {
+				found = true
+
+				break
+			}
+		}
+
+		return found == (c.Operator == Equal)
+	default:
+		cmp, ok := compare(value, c.Values[0])
+		if !ok {
+			return false
+		}
+
+		switch c.Operator { // nolint:exhaustive // the equality operators are matched above
+		case Less:
+			return cmp < 0
+		case LessOrEqual:
+			return cmp <= 0
+		case Greater:
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	}
+}
+
+func parseCondition(expr string, fields map[string]FieldType) (*Condition, error) {
+	end := strings.IndexAny(expr, "=!<>")
+	if end <= 0 {
+		return nil, fmt.Errorf("'%s' is not a condition", expr)
+	}
+
+	c := &Condition{Field: expr[:end]}
+
+	for _, op := range operators {
+		if strings.HasPrefix(expr[end:], string(op)) {
+			c.Operator = op
+
+			break
+		}
+	}
+
+	if c.Operator == "" {
+		return nil, fmt.Errorf("'%s' has no operator", expr)
+	}
+
+	fieldType, ok := fields[c.Field]
+	if !ok {
+		return nil, fmt.Errorf("cannot filter by %s", c.Field)
+	}
+
+	values := []string{expr[end+len(c.Operator):]}
+
+	switch c.Operator {
+	case Equal, NotEqual:
+		values = strings.Split(values[0], ",")
+	default:
+		if fieldType == Bool {
+			return nil, fmt.Errorf("%s can only be compared with == and !=", c.Field)
+		}
+	}
+
+	for _, value := range values {
+		v, err := parseValue(value, fieldType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %w", c.Field, err)
+		}
+
+		c.Values = append(c.Values, v)
+	}
+
+	return c, nil
+}
+
+func parseValue(value string, fieldType FieldType) (interface{}, error) {
+	switch fieldType {
+	case Time:
+		return time.Parse(time.RFC3339, value)
+	case Number:
+		return strconv.ParseFloat(value, 64)
+	case Bool:
+		return strconv.ParseBool(value)
+	case String:
+	}
+
+	return value, nil
+}
+
+// compare the value of a field to the value of a condition, parsed as the type of the field. The values of other
+// types do not compare.
+func compare(value, to interface{}) (int, bool) { // nolint:gocyclo // a case per type
+	switch v := value.(type) {
+	case *time.Time:
+		if v == nil {
+			return 0, false
+		}
+
+		return compare(*v, to)
+	case int:
+		return compare(float64(v), to)
+	case int64:
+		return compare(float64(v), to)
+	}
+
+	switch t := to.(type) {
+	case string:
+		if v, ok := value.(string); ok {
+			return strings.Compare(v, t), true
+		}
+	case time.Time:
+		if v, ok := value.(time.Time); ok {
+			switch {
+			case v.Before(t):
+				return -1, true
+			case v.After(t):
+				return 1, true
+			}
+
+			return 0, true
+		}
+	case float64:
+		if v, ok := value.(float64); ok {
+			switch {
+			case v < t:
+				return -1, true
+			case v > t:
+				return 1, true
+			}
+
+			return 0, true
+		}
+	case bool:
+		if v, ok := value.(bool); ok {
+			switch {
+			case v == t:
+				return 0, true
+			case t:
+				return -1, true
+			}
+
+			return 1, true
+		}
+	}
+
+	return 0, false
+}
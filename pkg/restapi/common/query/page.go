@@ -0,0 +1,80 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package query
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+)
+
+// EncodeCursor returns the cursor of the pages following the item of the key. The cursors are those of the pages
+// of store.Page, so that the stores paging their records themselves return the same cursors.
+func EncodeCursor(key string) string {
+	return store.EncodeCursor(key)
+}
+
+// DecodeCursor returns the key of the item the pages of the cursor follow.
+func DecodeCursor(cursor string) (string, error) {
+	return store.DecodeCursor(cursor)
+}
+
+// Page returns the bounds of the page of n items, filtered and sorted, following the item of the cursor, and the
+// cursor of the next page, empty on the last page. key returns the unique key of the i-th item. The items added or
+// removed while the pages are read do not shift the pages, but the cursors of the removed items are invalid.
+func (p *Params) Page(n int, key func(i int) string) (int, int, string, error) {
+	start := 0
+
+	if p.Cursor != "" {
+		after, err := DecodeCursor(p.Cursor)
+		if err != nil {
+			return 0, 0, "", &ParamError{Param: CursorParam, Err: err}
+		}
+
+		start = -1
+
+		for i := 0; i < n; i++ {
+			if key(i) == after {
+				start = i + 1
+
+				break
+			}
+		}
+
+		if start < 0 {
+			return 0, 0, "", &ParamError{
+				Param:  CursorParam,
+				Reason: "unknown or expired cursor",
+				Err:    ErrInvalidCursor,
+			}
+		}
+	}
+
+	end := start + p.Limit
+	if p.Limit <= 0 || end >= n {
+		return start, n, "", nil
+	}
+
+	return start, end, EncodeCursor(key(end - 1)), nil
+}
+
+// SetNextLink links the next page of the list in the Link header of the response, as per RFC 8288, with the query
+// parameters of the request, on every page but the last.
+func SetNextLink(w http.ResponseWriter, r *http.Request, next string) {
+	if next == "" {
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set(CursorParam, next)
+
+	link := &url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, link.String()))
+}
@@ -0,0 +1,213 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package query parses the query parameters of the list endpoints, so that they page, filter and sort their items
+// alike:
+//
+//	GET /users?limit=50&cursor=dXNlcjQy&filter=deactivated==true&filter=iss==https://idp.example.com&sort=-created
+//
+// limit is the number of items of a page, and cursor resumes the list past the last item of the previous page.
+// The pages link the next page with a Link header, and their cursors are opaque to the clients.
+//
+// filter holds a condition on a field of the items: a field, an operator among ==, !=, <, <=, > and >=, and a
+// value. The == and != conditions may hold values separated with ',', the items matching any or none of them.
+// filter may be repeated, the items matching all the conditions.
+//
+// sort holds the fields the items are sorted by, separated with ','. The fields prefixed with '-' are sorted in
+// descending order.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+)
+
+// Query parameters.
+const (
+	CursorParam = "cursor"
+	LimitParam  = "limit"
+	FilterParam = "filter"
+	SortParam   = "sort"
+)
+
+const (
+	// DefaultLimit is the number of items of the pages of the lists without default.
+	DefaultLimit = 100
+	// MaxLimit is the maximum number of items of the pages of the lists without maximum.
+	MaxLimit = 1000
+)
+
+var logger = log.New("edge-agent/query")
+
+// ErrInvalidCursor is returned for the cursors that were not returned by a page of the list, or whose item is gone.
+var ErrInvalidCursor = store.ErrInvalidCursor
+
+// FieldType is the type of the values of a field, which the values of its conditions are parsed as.
+type FieldType int
+
+const (
+	// String values are compared in lexical order. The list values match if any of their elements does.
+	String FieldType = iota
+	// Time values are in RFC 3339.
+	Time
+	// Number values are integer or decimal.
+	Number
+	// Bool values are true or false, and only compared for equality.
+	Bool
+)
+
+// Options are the parameters a list endpoint supports.
+type Options struct {
+	// DefaultLimit is the limit of the pages if not set. Defaults to DefaultLimit.
+	DefaultLimit int
+	// MaxLimit is the maximum limit of the pages. Defaults to MaxLimit.
+	MaxLimit int
+	// Fields are the fields the items may be filtered by, and their types. The items cannot be filtered if empty.
+	Fields map[string]FieldType
+	// Sorts are the fields the items may be sorted by. The items cannot be sorted if empty.
+	Sorts []string
+	// DefaultSort is the order of the items if not set, e.g. -created.
+	DefaultSort string
+}
+
+// Params are the parsed query parameters of a list endpoint.
+type Params struct {
+	// Cursor resumes the list, empty for the first page.
+	Cursor string
+	Limit  int
+	Filter Filter
+	Sort   Sort
+}
+
+// ParamError is the error of an invalid query parameter.
+type ParamError struct {
+	Param  string
+	Reason string
+	Err    error
+}
+
+func (e *ParamError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("invalid %s parameter", e.Param)
+	}
+
+	return fmt.Sprintf("invalid %s parameter: %s", e.Param, e.Reason)
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// Parse the query parameters of a list endpoint. The error is a *ParamError.
+func Parse(values url.Values, opts *Options) (*Params, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	p := &Params{Cursor: values.Get(CursorParam), Limit: opts.defaultLimit()}
+
+	if _, err := store.DecodeCursor(p.Cursor); err != nil {
+		return nil, &ParamError{Param: CursorParam, Err: ErrInvalidCursor}
+	}
+
+	if value := values.Get(LimitParam); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > opts.maxLimit() {
+			return nil, &ParamError{
+				Param:  LimitParam,
+				Reason: fmt.Sprintf("must be an integer between 1 and %d", opts.maxLimit()),
+			}
+		}
+
+		p.Limit = limit
+	}
+
+	for _, value := range values[FilterParam] {
+		c, err := parseCondition(value, opts.Fields)
+		if err != nil {
+			return nil, &ParamError{Param: FilterParam, Reason: err.Error()}
+		}
+
+		p.Filter = append(p.Filter, c)
+	}
+
+	sort := values.Get(SortParam)
+	if sort == "" {
+		sort = opts.DefaultSort
+	}
+
+	if sort != "" {
+		var err error
+
+		p.Sort, err = parseSort(sort, opts.Sorts)
+		if err != nil {
+			return nil, &ParamError{Param: SortParam, Reason: err.Error()}
+		}
+	}
+
+	return p, nil
+}
+
+// Spec returns the documentation of the query parameters the endpoint supports, for its OpenAPI operation.
+func (o *Options) Spec(items string) []common.Param {
+	params := []common.Param{
+		common.QueryParam(CursorParam, "Cursor of the page, linked by the previous page. Lists the first page if"+
+			" not set."),
+		common.QueryParam(LimitParam, fmt.Sprintf("Number of %s of the page. Defaults to %d, at most %d.",
+			items, o.defaultLimit(), o.maxLimit())),
+	}
+
+	if len(o.Fields) > 0 {
+		params = append(params, common.QueryParam(FilterParam, fmt.Sprintf("Condition the %s match, e.g."+
+			" field==a,b or field>=1. The operators are ==, !=, <, <=, > and >=. May be repeated.", items)))
+	}
+
+	if len(o.Sorts) > 0 {
+		params = append(params, common.QueryParam(SortParam, fmt.Sprintf("Fields the %s are sorted by, separated"+
+			" with ',', prefixed with '-' for descending order.", items)))
+	}
+
+	return params
+}
+
+func (o *Options) defaultLimit() int {
+	if o.DefaultLimit > 0 {
+		return o.DefaultLimit
+	}
+
+	return DefaultLimit
+}
+
+func (o *Options) maxLimit() int {
+	if o.MaxLimit > 0 {
+		return o.MaxLimit
+	}
+
+	return MaxLimit
+}
+
+// WriteError writes the error of a list endpoint: 400 for the invalid query parameters and cursors, 500 otherwise.
+func WriteError(w http.ResponseWriter, err error) {
+	var paramErr *ParamError
+
+	switch {
+	case errors.As(err, &paramErr):
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "%s", paramErr.Error())
+	case errors.Is(err, ErrInvalidCursor):
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "%s",
+			(&ParamError{Param: CursorParam}).Error())
+	default:
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package query_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/query"
+)
+
+type item struct {
+	id      string
+	groups  []string
+	created *time.Time
+	size    int
+	active  bool
+}
+
+func (i *item) fields(field string) interface{} {
+	switch field {
+	case "id":
+		return i.id
+	case "groups":
+		return i.groups
+	case "created":
+		return i.created
+	case "size":
+		return i.size
+	case "active":
+		return i.active
+	}
+
+	return nil
+}
+
+var options = &query.Options{
+	DefaultLimit: 2,
+	MaxLimit:     10,
+	Fields: map[string]query.FieldType{
+		"id":      query.String,
+		"groups":  query.String,
+		"created": query.Time,
+		"size":    query.Number,
+		"active":  query.Bool,
+	},
+	Sorts:       []string{"id", "created", "size", "active"},
+	DefaultSort: "id",
+}
+
+func TestParse(t *testing.T) {
+	t.Run("parses the query parameters", func(t *testing.T) {
+		p, err := query.Parse(url.Values{
+			query.CursorParam: {query.EncodeCursor("b")},
+			query.LimitParam:  {"5"},
+			query.FilterParam: {"groups==admins,users", "size>=2", "created<2021-01-01T00:00:00Z"},
+			query.SortParam:   {"-created,id"},
+		}, options)
+		require.NoError(t, err)
+		require.Equal(t, query.EncodeCursor("b"), p.Cursor)
+		require.Equal(t, 5, p.Limit)
+		require.Equal(t, query.Sort{{Field: "created", Descending: true}, {Field: "id"}}, p.Sort)
+		require.Equal(t, query.Filter{
+			{Field: "groups", Operator: query.Equal, Values: []interface{}{"admins", "users"}},
+			{Field: "size", Operator: query.GreaterOrEqual, Values: []interface{}{2.0}},
+			{
+				Field:    "created",
+				Operator: query.Less,
+				Values:   []interface{}{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		}, p.Filter)
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		p, err := query.Parse(url.Values{}, options)
+		require.NoError(t, err)
+		require.Equal(t, 2, p.Limit)
+		require.Equal(t, query.Sort{{Field: "id"}}, p.Sort)
+		require.Empty(t, p.Filter)
+
+		p, err = query.Parse(url.Values{}, nil)
+		require.NoError(t, err)
+		require.Equal(t, query.DefaultLimit, p.Limit)
+		require.Empty(t, p.Sort)
+	})
+
+	t.Run("error if a parameter is invalid", func(t *testing.T) {
+		for raw, message := range map[string]string{
+			"limit=0":                    "invalid limit parameter: must be an integer between 1 and 10",
+			"limit=11":                   "invalid limit parameter: must be an integer between 1 and 10",
+			"limit=ten":                  "invalid limit parameter",
+			"cursor=%21":                 "invalid cursor parameter",
+			"filter=id":                  "'id' is not a condition",
+			"filter=%3D%3Dx":             "'==x' is not a condition",
+			"filter=id%3Dx":              "'id=x' has no operator",
+			"filter=name%3D%3Dx":         "cannot filter by name",
+			"filter=size%3E%3Dtwo":       "invalid value of size",
+			"filter=created%3Eyesterday": "invalid value of created",
+			"filter=active%3Ctrue":       "active can only be compared with == and !=",
+			"sort=-groups":               "invalid sort parameter: cannot sort by groups",
+		} {
+			values, err := url.ParseQuery(raw)
+			require.NoError(t, err)
+
+			_, err = parse(values)
+			require.Error(t, err, raw)
+			require.Contains(t, err.Error(), message, raw)
+
+			var paramErr *query.ParamError
+			require.True(t, errors.As(err, &paramErr), raw)
+		}
+	})
+}
+
+func parse(values url.Values) (*query.Params, error) {
+	return query.Parse(values, options)
+}
+
+func TestFilter_Matches(t *testing.T) {
+	created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	i := &item{id: "b", groups: []string{"users", "admins"}, created: &created, size: 3, active: true}
+
+	for filter, matches := range map[string]bool{
+		"id==b":                         true,
+		"id==a,b":                       true,
+		"id!=a,c":                       true,
+		"id!=b":                         false,
+		"id>a":                          true,
+		"id<b":                          false,
+		"groups==admins":                true,
+		"groups==owners":                false,
+		"groups!=owners":                true,
+		"groups!=admins":                false,
+		"created>=2021-06-01T00:00:00Z": true,
+		"created>2021-06-01T00:00:00Z":  false,
+		"created<=2021-01-01T00:00:00Z": false,
+		"size>2.5":                      true,
+		"size<=3":                       true,
+		"size==4":                       false,
+		"active==true":                  true,
+		"active!=true":                  false,
+	} {
+		p, err := parse(url.Values{query.FilterParam: {filter}})
+		require.NoError(t, err, filter)
+		require.Equal(t, matches, p.Filter.Matches(i.fields), filter)
+	}
+
+	t.Run("the items without a field do not match its conditions", func(t *testing.T) {
+		p, err := parse(url.Values{query.FilterParam: {"created>2000-01-01T00:00:00Z"}})
+		require.NoError(t, err)
+		require.False(t, p.Filter.Matches((&item{}).fields))
+	})
+}
+
+func TestSort_Less(t *testing.T) {
+	day := func(d int) *time.Time {
+		t := time.Date(2021, 6, d, 0, 0, 0, 0, time.UTC)
+
+		return &t
+	}
+
+	items := []*item{
+		{id: "a", created: day(2), size: 1},
+		{id: "b", size: 2, active: true},
+		{id: "c", created: day(1), size: 2},
+		{id: "d", created: day(2), size: 3, active: true},
+	}
+
+	ids := func(sortParam string) []string {
+		p, err := parse(url.Values{query.SortParam: {sortParam}})
+		require.NoError(t, err)
+
+		sorted := append([]*item{}, items...)
+		sort.SliceStable(sorted, func(i, j int) bool { return p.Sort.Less(sorted[i].fields, sorted[j].fields) })
+
+		var result []string
+		for _, i := range sorted {
+			result = append(result, i.id)
+		}
+
+		return result
+	}
+
+	require.Equal(t, []string{"d", "c", "b", "a"}, ids("-id"))
+	require.Equal(t, []string{"c", "a", "d", "b"}, ids("created"))
+	require.Equal(t, []string{"d", "a", "c", "b"}, ids("-created,-size"))
+	require.Equal(t, []string{"d", "c", "b", "a"}, ids("-size,created"))
+	require.Equal(t, []string{"a", "c", "b", "d"}, ids("active"))
+}
+
+func TestParams_Page(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e"}
+	key := func(i int) string { return keys[i] }
+
+	p, err := parse(url.Values{})
+	require.NoError(t, err)
+
+	var pages [][]string
+
+	for {
+		start, end, next, err := p.Page(len(keys), key)
+		require.NoError(t, err)
+
+		pages = append(pages, keys[start:end])
+
+		if next == "" {
+			break
+		}
+
+		p.Cursor = next
+	}
+
+	require.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, pages)
+
+	t.Run("lists all the items without limit", func(t *testing.T) {
+		start, end, next, err := (&query.Params{}).Page(len(keys), key)
+		require.NoError(t, err)
+		require.Equal(t, 0, start)
+		require.Equal(t, 5, end)
+		require.Empty(t, next)
+	})
+
+	t.Run("error if the item of the cursor is gone", func(t *testing.T) {
+		_, _, _, err := (&query.Params{Cursor: query.EncodeCursor("z"), Limit: 2}).Page(len(keys), key)
+		require.Error(t, err)
+		require.True(t, errors.Is(err, query.ErrInvalidCursor))
+		require.Contains(t, err.Error(), "unknown or expired cursor")
+	})
+
+	t.Run("error if the cursor is invalid", func(t *testing.T) {
+		_, _, _, err := (&query.Params{Cursor: "!"}).Page(len(keys), key)
+		require.True(t, errors.Is(err, query.ErrInvalidCursor))
+	})
+}
+
+func TestSetNextLink(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/admin/users?limit=2&cursor=YQ&filter=id%3E%3Da", nil)
+
+	w := httptest.NewRecorder()
+	query.SetNextLink(w, r, query.EncodeCursor("b"))
+	require.Equal(t, `</admin/users?cursor=Yg&filter=id%3E%3Da&limit=2>; rel="next"`, w.Header().Get("Link"))
+
+	w = httptest.NewRecorder()
+	query.SetNextLink(w, r, "")
+	require.Empty(t, w.Header().Get("Link"))
+}
+
+func TestWriteError(t *testing.T) {
+	for err, status := range map[error]int{
+		&query.ParamError{Param: query.LimitParam, Reason: "too large"}: http.StatusBadRequest,
+		query.ErrInvalidCursor:          http.StatusBadRequest,
+		errors.New("store unavailable"): http.StatusInternalServerError,
+	} {
+		w := httptest.NewRecorder()
+		query.WriteError(w, err)
+		require.Equal(t, status, w.Code, err.Error())
+	}
+}
+
+func TestOptions_Spec(t *testing.T) {
+	require.Len(t, options.Spec("items"), 4)
+	require.Len(t, (&query.Options{}).Spec("items"), 2)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SortKey is a field the items are sorted by.
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// Sort holds the fields the items are sorted by, the first one first.
+type Sort []*SortKey
+
+// Less tells whether the item of a sorts before the item of b. The items without a field sort after those with
+// it, in either order, and the items of equal fields keep their order with a stable sort.
+func (s Sort) Less(a, b Fields) bool {
+	for _, key := range s {
+		va, vb := a(key.Field), b(key.Field)
+
+		missingA, missingB := missing(va), missing(vb)
+
+		switch {
+		case missingA && missingB:
+			continue
+		case missingA:
+			return false
+		case missingB:
+			return true
+		}
+
+		// the values of the items are compared as those of the conditions
+		switch v := vb.(type) {
+		case *time.Time:
+			vb = *v
+		case int:
+			vb = float64(v)
+		case int64:
+			vb = float64(v)
+		}
+
+		cmp, ok := compare(va, vb)
+		if !ok || cmp == 0 {
+			continue
+		}
+
+		if key.Descending {
+			return cmp > 0
+		}
+
+		return cmp < 0
+	}
+
+	return false
+}
+
+func missing(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case *time.Time:
+		return v == nil || v.IsZero()
+	case time.Time:
+		return v.IsZero()
+	}
+
+	return false
+}
+
+func parseSort(value string, fields []string) (Sort, error) {
+	var sort Sort
+
+	for _, field := range strings.Split(value, ",") {
+		key := &SortKey{Field: strings.TrimPrefix(field, "-"), Descending: strings.HasPrefix(field, "-")}
+
+		if !includes(fields, key.Field) {
+			return nil, fmt.Errorf("cannot sort by %s", key.Field)
+		}
+
+		sort = append(sort, key)
+	}
+
+	return sort, nil
+}
+
+func includes(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
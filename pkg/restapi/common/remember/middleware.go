@@ -0,0 +1,121 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package remember
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	// CookieName is the name of the cookie holding the remember-me token of the browser.
+	CookieName = "edgeagent_remember"
+	// the cookie in which the handlers find the sub of a logged in user.
+	userSubCookieName = "user_sub"
+)
+
+var logger = log.New("edge-agent/remember")
+
+// Middleware logs the remembered users in again once their session expired: the remember-me token of the request
+// is rotated, and the handlers are served a new session of its user. The new session carries no authentication
+// context, so the sensitive operations require the users to authenticate again. The chain of a reused token is
+// revoked and its cookie deleted. Requests without a remember-me token, or of a live session, are passed on as is.
+func Middleware(tokens *Store, cookies *cookie.Jars) common.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value, found := FromRequest(r)
+			if !found {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			jar, err := cookies.Open(r)
+			if err != nil {
+				// the session cookies of the browser expired, or cannot be read since the keys rotated
+				jar = cookies.Reset()
+			} else if _, loggedIn := jar.Get(userSubCookieName); loggedIn {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			token, err := tokens.Rotate(value)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrTokenReused):
+					logger.Warnf("revoked a remember-me token chain: %s", err.Error())
+					ClearCookie(w)
+				case errors.Is(err, ErrInvalidToken):
+					ClearCookie(w)
+				default:
+					logger.Errorf("failed to rotate remember-me token: %s", err.Error())
+				}
+
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			// the rotated token is sent before anything else may fail, lest its next use be taken for a reuse
+			SetCookie(w, token)
+
+			jar.Set(userSubCookieName, token.Sub)
+
+			err = jar.Save(r, w)
+			if err != nil {
+				logger.Errorf("failed to save the session of a remembered user: %s", err.Error())
+			}
+
+			next.ServeHTTP(w, r.WithContext(cookie.WithJar(r.Context(), jar)))
+		})
+	}
+}
+
+// FromRequest returns the remember-me token of the request, if any.
+func FromRequest(r *http.Request) (string, bool) {
+	c, err := r.Cookie(CookieName)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+
+	return c.Value, true
+}
+
+// SetCookie sends the token to the browser, unless the token was not rotated.
+func SetCookie(w http.ResponseWriter, token *Token) {
+	if token.Value == "" {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token.Value,
+		Path:     "/",
+		MaxAge:   int(time.Until(token.Expires).Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+// ClearCookie deletes the remember-me cookie of the browser.
+func ClearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
@@ -0,0 +1,208 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package remember_test
+
+import (
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestMiddleware(t *testing.T) {
+	cookies := cookie.NewStore(key(t), key(t))
+	tokens := newStore(t, time.Hour)
+	handler := remember.Middleware(tokens, cookies)(userHandler(t, cookies))
+
+	t.Run("logs the remembered users in again once their session expired", func(t *testing.T) {
+		issued, err := tokens.Issue("user")
+		require.NoError(t, err)
+
+		w := serve(handler, rememberCookie(issued.Value))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "user", w.Body.String())
+
+		rotated := responseCookie(w, remember.CookieName)
+		require.NotNil(t, rotated)
+		require.NotEqual(t, issued.Value, rotated.Value)
+		require.True(t, rotated.HttpOnly)
+		require.True(t, rotated.Secure)
+		require.Greater(t, rotated.MaxAge, 0)
+
+		session := responseCookie(w, cookie.StoreName)
+		require.NotNil(t, session)
+
+		w = serve(handler, session)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "user", w.Body.String())
+
+		w = serve(handler, rotated)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotNil(t, responseCookie(w, remember.CookieName))
+	})
+
+	t.Run("replaces the session cookies that cannot be read", func(t *testing.T) {
+		issued, err := tokens.Issue("user")
+		require.NoError(t, err)
+
+		stale := sessionCookie(t, cookie.NewStore(key(t), key(t)), "other")
+
+		w := serve(handler, stale, rememberCookie(issued.Value))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "user", w.Body.String())
+		require.NotNil(t, responseCookie(w, cookie.StoreName))
+	})
+
+	t.Run("passes the requests of a live session as is", func(t *testing.T) {
+		issued, err := tokens.Issue("user")
+		require.NoError(t, err)
+
+		w := serve(handler, sessionCookie(t, cookies, "other"), rememberCookie(issued.Value))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "other", w.Body.String())
+		require.Nil(t, responseCookie(w, remember.CookieName))
+
+		// the token was not used
+		_, err = tokens.Rotate(issued.Value)
+		require.NoError(t, err)
+	})
+
+	t.Run("passes the requests without a remember-me token as is", func(t *testing.T) {
+		w := serve(handler)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Empty(t, w.Header().Get("Set-Cookie"))
+	})
+
+	t.Run("logs the concurrent requests of a browser in", func(t *testing.T) {
+		issued, err := tokens.Issue("user")
+		require.NoError(t, err)
+
+		require.NotNil(t, responseCookie(serve(handler, rememberCookie(issued.Value)), remember.CookieName))
+
+		w := serve(handler, rememberCookie(issued.Value))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Nil(t, responseCookie(w, remember.CookieName))
+	})
+
+	t.Run("revokes the chain of a reused token", func(t *testing.T) {
+		issued, err := tokens.Issue("user")
+		require.NoError(t, err)
+
+		rotated := responseCookie(serve(handler, rememberCookie(issued.Value)), remember.CookieName)
+		latest := responseCookie(serve(handler, rotated), remember.CookieName)
+
+		w := serve(handler, rememberCookie(issued.Value))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Equal(t, -1, responseCookie(w, remember.CookieName).MaxAge)
+
+		w = serve(handler, latest)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Equal(t, -1, responseCookie(w, remember.CookieName).MaxAge)
+	})
+
+	t.Run("deletes the cookies of invalid tokens", func(t *testing.T) {
+		w := serve(handler, rememberCookie("invalid"))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Equal(t, -1, responseCookie(w, remember.CookieName).MaxAge)
+	})
+
+	t.Run("keeps the cookie if the store fails", func(t *testing.T) {
+		p := mockstore.NewMockStoreProvider()
+
+		failing, err := remember.NewStore(p, time.Hour)
+		require.NoError(t, err)
+
+		issued, err := failing.Issue("user")
+		require.NoError(t, err)
+
+		p.Store.ErrGet = errors.New("test")
+
+		w := serve(remember.Middleware(failing, cookies)(userHandler(t, cookies)), rememberCookie(issued.Value))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Nil(t, responseCookie(w, remember.CookieName))
+	})
+}
+
+// userHandler reads the user sub like the REST handlers do.
+func userHandler(t *testing.T, cookies cookie.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jar, err := cookies.Open(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		sub, found := jar.Get("user_sub")
+		if !found {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		_, err = w.Write([]byte(sub.(string)))
+		require.NoError(t, err)
+	})
+}
+
+func serve(handler http.Handler, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() { // nolint:bodyclose // recorded response
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func rememberCookie(value string) *http.Cookie {
+	return &http.Cookie{Name: remember.CookieName, Value: value}
+}
+
+// sessionCookie returns a session cookie of the user.
+func sessionCookie(t *testing.T, cookies *cookie.Jars, sub string) *http.Cookie {
+	t.Helper()
+
+	jar := cookies.Reset()
+	jar.Set("user_sub", sub)
+
+	w := httptest.NewRecorder()
+	require.NoError(t, jar.Save(httptest.NewRequest(http.MethodGet, "/", nil), w))
+
+	return responseCookie(w, cookie.StoreName)
+}
+
+func key(t *testing.T) []byte {
+	t.Helper()
+
+	k := make([]byte, 32)
+
+	_, err := rand.Read(k)
+	require.NoError(t, err)
+
+	return k
+}
@@ -0,0 +1,251 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package remember
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// StoreName is the name of the remember-me token store.
+	StoreName = "edgeagent_remember"
+	// DefaultTTL is how long the users are remembered for by default.
+	DefaultTTL = 30 * 24 * time.Hour
+	// the previous token of a chain is accepted for this long after a rotation, for the concurrent requests of the
+	// browser that presented it.
+	rotationGrace = 30 * time.Second
+	secretSize    = 32
+)
+
+var (
+	// ErrInvalidToken is returned for the tokens that are malformed, unknown, revoked or expired.
+	ErrInvalidToken = errors.New("invalid remember-me token")
+	// ErrTokenReused is returned when a token that was rotated already is presented again, e.g. by a thief of the
+	// token or by its legitimate user once a thief rotated it. The chain of the token is revoked.
+	ErrTokenReused = errors.New("remember-me token reused")
+)
+
+// Token is a remember-me token of a user.
+type Token struct {
+	Sub string
+	// Value is the value of the cookie of the token, empty if the token was not rotated.
+	Value   string
+	Expires time.Time
+}
+
+// chain is the series of tokens issued to a browser from a login. Only the hashes of the tokens are stored.
+type chain struct {
+	Sub       string    `json:"sub"`
+	Current   string    `json:"current"`
+	Previous  string    `json:"previous,omitempty"`
+	RotatedAt time.Time `json:"rotatedAt"`
+	Expires   time.Time `json:"expires"`
+}
+
+// NewStore returns a new remember-me token Store. The users are remembered for ttl from their login, or for
+// DefaultTTL if ttl is not positive.
+func NewStore(p storage.Provider, ttl time.Duration) (*Store, error) {
+	s, err := store.Open(p, StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open remember-me store: %w", err)
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Store{s: s, ttl: ttl}, nil
+}
+
+// Store holds the chains of single-use remember-me tokens. Each use of a token rotates it: the token is replaced
+// by a new one in its chain.
+//
+// Tokens are rotated under a lock, so the chains must only be rotated by a single Store.
+type Store struct {
+	s     storage.Store
+	ttl   time.Duration
+	mutex sync.Mutex
+}
+
+// Issue starts a chain of tokens for the user.
+func (s *Store) Issue(sub string) (*Token, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	c := &chain{Sub: sub, Current: hash(secret), RotatedAt: time.Now(), Expires: time.Now().Add(s.ttl)}
+
+	err = store.Save(s.s, id, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save remember-me token: %w", err)
+	}
+
+	return &Token{Sub: sub, Value: id + "." + secret, Expires: c.Expires}, nil
+}
+
+// Rotate replaces the token with the next one of its chain. The previous token is accepted again, without
+// rotation, for a short while after the rotation. Any other reuse of a token revokes its chain.
+func (s *Store) Rotate(value string) (*Token, error) {
+	id, secret, ok := parse(value)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	if now.After(c.Expires) {
+		s.revoke(id)
+
+		return nil, ErrInvalidToken
+	}
+
+	presented := hash(secret)
+
+	switch {
+	case equal(presented, c.Current):
+		next, err := newSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		c.Previous, c.Current, c.RotatedAt = c.Current, hash(next), now
+
+		err = store.Save(s.s, id, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save remember-me token: %w", err)
+		}
+
+		return &Token{Sub: c.Sub, Value: id + "." + next, Expires: c.Expires}, nil
+	case equal(presented, c.Previous) && now.Sub(c.RotatedAt) < rotationGrace:
+		return &Token{Sub: c.Sub, Expires: c.Expires}, nil
+	default:
+		s.revoke(id)
+
+		return nil, ErrTokenReused
+	}
+}
+
+// Revoke the chain of the token, e.g. on logout.
+func (s *Store) Revoke(value string) error {
+	id, _, ok := parse(value)
+	if !ok {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.s.Delete(id)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to revoke remember-me token: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeUser revokes all the chains of the user, e.g. once deactivated.
+func (s *Store) RevokeUser(sub string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	all, err := s.s.GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to list remember-me tokens: %w", err)
+	}
+
+	for id, raw := range all {
+		c := &chain{}
+
+		err = json.Unmarshal(raw, c)
+		if err != nil || c.Sub != sub {
+			continue
+		}
+
+		err = s.s.Delete(id)
+		if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+			return fmt.Errorf("failed to revoke remember-me token: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) get(id string) (*chain, error) {
+	raw, err := s.s.Get(id)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return nil, ErrInvalidToken
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remember-me token: %w", err)
+	}
+
+	c := &chain{}
+
+	return c, json.Unmarshal(raw, c)
+}
+
+func (s *Store) revoke(id string) {
+	err := s.s.Delete(id)
+	if err != nil {
+		logger.Errorf("failed to revoke remember-me token: %s", err.Error())
+	}
+}
+
+func parse(value string) (id, secret string, ok bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, secretSize)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate remember-me token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func hash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(h[:])
+}
+
+func equal(a, b string) bool {
+	return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,209 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package remember_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestStore(t *testing.T) {
+	t.Run("rotates the tokens of a chain", func(t *testing.T) {
+		s := newStore(t, time.Hour)
+
+		issued, err := s.Issue("user")
+		require.NoError(t, err)
+		require.Equal(t, "user", issued.Sub)
+		require.WithinDuration(t, time.Now().Add(time.Hour), issued.Expires, time.Minute)
+
+		rotated, err := s.Rotate(issued.Value)
+		require.NoError(t, err)
+		require.Equal(t, "user", rotated.Sub)
+		require.Equal(t, issued.Expires.Unix(), rotated.Expires.Unix())
+		require.NotEqual(t, issued.Value, rotated.Value)
+		require.Equal(t, chainID(issued), chainID(rotated))
+
+		rotated, err = s.Rotate(rotated.Value)
+		require.NoError(t, err)
+		require.NotEmpty(t, rotated.Value)
+	})
+
+	t.Run("accepts the previous token for a while after a rotation", func(t *testing.T) {
+		s := newStore(t, time.Hour)
+
+		issued, err := s.Issue("user")
+		require.NoError(t, err)
+
+		rotated, err := s.Rotate(issued.Value)
+		require.NoError(t, err)
+
+		again, err := s.Rotate(issued.Value)
+		require.NoError(t, err)
+		require.Equal(t, "user", again.Sub)
+		require.Empty(t, again.Value)
+
+		_, err = s.Rotate(rotated.Value)
+		require.NoError(t, err)
+	})
+
+	t.Run("revokes the chain of a reused token", func(t *testing.T) {
+		s := newStore(t, time.Hour)
+
+		issued, err := s.Issue("user")
+		require.NoError(t, err)
+
+		rotated, err := s.Rotate(issued.Value)
+		require.NoError(t, err)
+
+		latest, err := s.Rotate(rotated.Value)
+		require.NoError(t, err)
+
+		_, err = s.Rotate(issued.Value)
+		require.True(t, errors.Is(err, remember.ErrTokenReused))
+
+		_, err = s.Rotate(latest.Value)
+		require.True(t, errors.Is(err, remember.ErrInvalidToken))
+	})
+
+	t.Run("revokes the chain of a forged token", func(t *testing.T) {
+		s := newStore(t, time.Hour)
+
+		issued, err := s.Issue("user")
+		require.NoError(t, err)
+
+		_, err = s.Rotate(chainID(issued) + ".forged")
+		require.True(t, errors.Is(err, remember.ErrTokenReused))
+
+		_, err = s.Rotate(issued.Value)
+		require.True(t, errors.Is(err, remember.ErrInvalidToken))
+	})
+
+	t.Run("forgets the users once the chain expires", func(t *testing.T) {
+		s := newStore(t, time.Millisecond)
+
+		issued, err := s.Issue("user")
+		require.NoError(t, err)
+
+		time.Sleep(2 * time.Millisecond)
+
+		_, err = s.Rotate(issued.Value)
+		require.True(t, errors.Is(err, remember.ErrInvalidToken))
+	})
+
+	t.Run("remembers the users for 30 days by default", func(t *testing.T) {
+		issued, err := newStore(t, 0).Issue("user")
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(remember.DefaultTTL), issued.Expires, time.Minute)
+	})
+
+	t.Run("revokes the chains of the tokens", func(t *testing.T) {
+		s := newStore(t, time.Hour)
+
+		issued, err := s.Issue("user")
+		require.NoError(t, err)
+
+		require.NoError(t, s.Revoke(issued.Value))
+		require.NoError(t, s.Revoke(issued.Value))
+		require.NoError(t, s.Revoke("invalid"))
+
+		_, err = s.Rotate(issued.Value)
+		require.True(t, errors.Is(err, remember.ErrInvalidToken))
+	})
+
+	t.Run("revokes the chains of the user", func(t *testing.T) {
+		s := newStore(t, time.Hour)
+
+		first, err := s.Issue("user")
+		require.NoError(t, err)
+
+		second, err := s.Issue("user")
+		require.NoError(t, err)
+
+		other, err := s.Issue("other")
+		require.NoError(t, err)
+
+		require.NoError(t, s.RevokeUser("user"))
+
+		for _, token := range []*remember.Token{first, second} {
+			_, err = s.Rotate(token.Value)
+			require.True(t, errors.Is(err, remember.ErrInvalidToken))
+		}
+
+		_, err = s.Rotate(other.Value)
+		require.NoError(t, err)
+	})
+
+	t.Run("error if the token is invalid", func(t *testing.T) {
+		s := newStore(t, time.Hour)
+
+		for _, value := range []string{"", "token", ".secret", "id.", "a.b.c", "unknown.secret"} {
+			_, err := s.Rotate(value)
+			require.True(t, errors.Is(err, remember.ErrInvalidToken))
+		}
+	})
+
+	t.Run("error if the store fails", func(t *testing.T) {
+		_, err := remember.NewStore(&mockstore.Provider{ErrCreateStore: errors.New("test")}, time.Hour)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open remember-me store")
+
+		p := mockstore.NewMockStoreProvider()
+
+		s, err := remember.NewStore(p, time.Hour)
+		require.NoError(t, err)
+
+		issued, err := s.Issue("user")
+		require.NoError(t, err)
+
+		p.Store.ErrGet = errors.New("test")
+
+		_, err = s.Rotate(issued.Value)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch remember-me token")
+
+		p.Store.ErrGet = nil
+		p.Store.ErrPut = errors.New("test")
+
+		_, err = s.Rotate(issued.Value)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save remember-me token")
+
+		_, err = s.Issue("user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save remember-me token")
+
+		p.Store.ErrDelete = errors.New("test")
+
+		err = s.Revoke(issued.Value)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to revoke remember-me token")
+
+		err = s.RevokeUser("user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to revoke remember-me token")
+	})
+}
+
+func newStore(t *testing.T, ttl time.Duration) *remember.Store {
+	t.Helper()
+
+	s, err := remember.NewStore(memstore.NewProvider(), ttl)
+	require.NoError(t, err)
+
+	return s
+}
+
+func chainID(token *remember.Token) string {
+	return strings.Split(token.Value, ".")[0]
+}
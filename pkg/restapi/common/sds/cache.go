@@ -0,0 +1,99 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sds
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache keeps the most recently used vault configurations in memory. When full, adding an entry
+// evicts the least recently used one. It is safe for concurrent use.
+type Cache struct {
+	mutex   sync.Mutex
+	size    int
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key   string
+	value interface{}
+}
+
+// NewCache returns a Cache of at most size entries.
+func NewCache(size int) *Cache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Cache{size: size, entries: list.New(), index: make(map[string]*list.Element)}
+}
+
+// Get returns the value cached under key.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, found := c.index[key]
+	if !found {
+		return nil, false
+	}
+
+	c.entries.MoveToFront(e)
+
+	return entryOf(e).value, true
+}
+
+// Add caches the value under key, replacing any previous value.
+func (c *Cache) Add(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, found := c.index[key]; found {
+		entryOf(e).value = value
+		c.entries.MoveToFront(e)
+
+		return
+	}
+
+	c.index[key] = c.entries.PushFront(&cacheEntry{key: key, value: value})
+
+	if c.entries.Len() > c.size {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.index, entryOf(oldest).key)
+	}
+}
+
+// Remove drops the value cached under key.
+func (c *Cache) Remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, found := c.index[key]; found {
+		c.entries.Remove(e)
+		delete(c.index, key)
+	}
+}
+
+func entryOf(e *list.Element) *cacheEntry {
+	entry, ok := e.Value.(*cacheEntry)
+	if !ok {
+		panic("sds: cache list holds a foreign value")
+	}
+
+	return entry
+}
+
+// Len returns the number of cached values.
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.entries.Len()
+}
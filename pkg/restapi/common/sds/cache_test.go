@@ -0,0 +1,55 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sds_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("evicts the least recently used entry", func(t *testing.T) {
+		cache := sds.NewCache(2)
+
+		cache.Add("a", 1)
+		cache.Add("b", 2)
+
+		v, found := cache.Get("a")
+		require.True(t, found)
+		require.Equal(t, 1, v)
+
+		cache.Add("c", 3)
+		require.Equal(t, 2, cache.Len())
+
+		_, found = cache.Get("b")
+		require.False(t, found)
+
+		_, found = cache.Get("a")
+		require.True(t, found)
+	})
+
+	t.Run("replaces and removes entries", func(t *testing.T) {
+		cache := sds.NewCache(0)
+
+		cache.Add("a", 1)
+		cache.Add("a", 2)
+
+		v, found := cache.Get("a")
+		require.True(t, found)
+		require.Equal(t, 2, v)
+		require.Equal(t, 1, cache.Len())
+
+		cache.Remove("a")
+		cache.Remove("unknown")
+
+		_, found = cache.Get("a")
+		require.False(t, found)
+		require.Equal(t, 0, cache.Len())
+	})
+}
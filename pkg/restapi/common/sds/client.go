@@ -0,0 +1,353 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sds
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/proxy"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edv/pkg/restapi/models"
+)
+
+const (
+	defaultBatchConcurrency = 8
+	maxIdleConnsPerHost     = 16
+	idleConnTimeout         = 90 * time.Second
+	tlsHandshakeTimeout     = 10 * time.Second
+)
+
+var logger = log.New("edge-agent/sds")
+
+type addHeaders func(req *http.Request) (*http.Header, error)
+
+// HTTPClient sends HTTP requests.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client is a client of the EDV REST API of a secure data storage server.
+// All requests share the connections of one HTTP client, over HTTP/2 when the server supports it,
+// so that a sequence of small calls does not pay for a TLS handshake each.
+type Client struct {
+	serverURL   string
+	httpClient  HTTPClient
+	headers     addHeaders
+	concurrency int
+}
+
+// Option configures the client.
+type Option func(c *Client)
+
+// WithTLSConfig sets the TLS configuration of the client's connections.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Client) {
+		c.httpClient = NewHTTPClient(tlsConfig, nil)
+	}
+}
+
+// WithHTTPClient shares an HTTP client, and its connection pool, with the rest of the application.
+func WithHTTPClient(httpClient HTTPClient) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithHeaders sets headers on every request of the client.
+func WithHeaders(addHeadersFunc func(req *http.Request) (*http.Header, error)) Option {
+	return func(c *Client) {
+		c.headers = addHeadersFunc
+	}
+}
+
+// WithBatchConcurrency sets how many operations of a batch are in flight at once. Defaults to 8.
+func WithBatchConcurrency(concurrency int) Option {
+	return func(c *Client) {
+		c.concurrency = concurrency
+	}
+}
+
+type reqOpts struct {
+	headers addHeaders
+	ctx     context.Context
+}
+
+// ReqOption configures a request.
+type ReqOption func(opts *reqOpts)
+
+// WithRequestHeader sets headers on a request, in place of the headers of the client.
+func WithRequestHeader(addHeadersFunc func(req *http.Request) (*http.Header, error)) ReqOption {
+	return func(opts *reqOpts) {
+		opts.headers = addHeadersFunc
+	}
+}
+
+// WithContext sends the request with the context, e.g. one carrying the zcap invocations signing it.
+func WithContext(ctx context.Context) ReqOption {
+	return func(opts *reqOpts) {
+		opts.ctx = ctx
+	}
+}
+
+// NewHTTPClient returns an HTTP client that keeps its connections alive and negotiates HTTP/2.
+// Go only attempts HTTP/2 on transports with a custom TLS configuration when asked to.
+// The requests go through the proxy, or the one of the environment if nil.
+func NewHTTPClient(tlsConfig *tls.Config, proxyFunc proxy.Func) *http.Client {
+	if proxyFunc == nil {
+		proxyFunc = http.ProxyFromEnvironment
+	}
+
+	return &http.Client{Transport: &http.Transport{
+		Proxy:               proxyFunc,
+		TLSClientConfig:     tlsConfig,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+	}}
+}
+
+// New returns a new Client of the EDV server at serverURL.
+func New(serverURL string, opts ...Option) *Client {
+	c := &Client{
+		serverURL:   strings.TrimSuffix(serverURL, "/"),
+		concurrency: defaultBatchConcurrency,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = NewHTTPClient(nil, nil)
+	}
+
+	if c.concurrency < 1 {
+		c.concurrency = 1
+	}
+
+	return c
+}
+
+// CreateDataVault creates a data vault. It returns the location of the vault and the body of the
+// response, which holds the capability of the vault when the server issues one.
+func (c *Client) CreateDataVault(config *models.DataVaultConfiguration, opts ...ReqOption) (string, []byte, error) {
+	body, err := json.Marshal(config)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal data vault configuration: %w", err)
+	}
+
+	header, reply, err := c.send(http.MethodPost, c.serverURL, body, http.StatusCreated, opts)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create data vault: %w", err)
+	}
+
+	return header.Get("Location"), reply, nil
+}
+
+// CreateDocument stores the document in the vault and returns its location.
+func (c *Client) CreateDocument(vaultID string, document *models.EncryptedDocument,
+	opts ...ReqOption) (string, error) {
+	body, err := json.Marshal(document)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	header, _, err := c.send(http.MethodPost, c.documentsURL(vaultID), body, http.StatusCreated, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create document: %w", err)
+	}
+
+	return header.Get("Location"), nil
+}
+
+// UpdateDocument replaces the document with the given id.
+func (c *Client) UpdateDocument(vaultID, docID string, document *models.EncryptedDocument, opts ...ReqOption) error {
+	body, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("failed to marshal document: %w", err)
+	}
+
+	_, _, err = c.send(http.MethodPost, c.documentURL(vaultID, docID), body, http.StatusOK, opts)
+	if err != nil {
+		return fmt.Errorf("failed to update document %s: %w", docID, err)
+	}
+
+	return nil
+}
+
+// ReadDocument reads the document with the given id.
+func (c *Client) ReadDocument(vaultID, docID string, opts ...ReqOption) (*models.EncryptedDocument, error) {
+	_, reply, err := c.send(http.MethodGet, c.documentURL(vaultID, docID), nil, http.StatusOK, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document %s: %w", docID, err)
+	}
+
+	document := &models.EncryptedDocument{}
+
+	err = json.Unmarshal(reply, document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal document %s: %w", docID, err)
+	}
+
+	return document, nil
+}
+
+// QueryVault returns the locations of the documents of the vault with an indexed attribute of the given name and
+// value. Both are MACs of the plain attribute, so that the EDV server never learns what the documents hold.
+func (c *Client) QueryVault(vaultID, name, value string, opts ...ReqOption) ([]string, error) {
+	body, err := json.Marshal(&models.Query{Name: name, Value: value})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	_, reply, err := c.send(http.MethodPost, fmt.Sprintf("%s/%s/query", c.serverURL, url.PathEscape(vaultID)),
+		body, http.StatusOK, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query vault: %w", err)
+	}
+
+	var locations []string
+
+	err = json.Unmarshal(reply, &locations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal query result: %w", err)
+	}
+
+	return locations, nil
+}
+
+// BatchOperation creates a document, or updates it if DocumentID is set.
+type BatchOperation struct {
+	DocumentID string
+	Document   *models.EncryptedDocument
+}
+
+// Batch runs the operations on the vault and returns the location of each document, in order.
+// The EDV server has no batch endpoint: the operations are sent concurrently over the client's
+// connections instead of one round trip after the other. Every operation is attempted; the error
+// reports those that failed.
+func (c *Client) Batch(vaultID string, ops []*BatchOperation, opts ...ReqOption) ([]string, error) {
+	locations := make([]string, len(ops))
+	errs := make([]error, len(ops))
+
+	var wg sync.WaitGroup
+
+	slots := make(chan struct{}, c.concurrency)
+
+	for i := range ops {
+		wg.Add(1)
+
+		slots <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			op := ops[i]
+
+			if op.DocumentID == "" {
+				locations[i], errs[i] = c.CreateDocument(vaultID, op.Document, opts...)
+
+				return
+			}
+
+			errs[i] = c.UpdateDocument(vaultID, op.DocumentID, op.Document, opts...)
+			if errs[i] == nil {
+				locations[i] = c.documentURL(vaultID, op.DocumentID)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	var failed []string
+
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("operation %d: %s", i, err.Error()))
+		}
+	}
+
+	if len(failed) > 0 {
+		return locations, fmt.Errorf("%d of %d batch operations failed: %s",
+			len(failed), len(ops), strings.Join(failed, "; "))
+	}
+
+	return locations, nil
+}
+
+func (c *Client) documentsURL(vaultID string) string {
+	return fmt.Sprintf("%s/%s/documents", c.serverURL, url.PathEscape(vaultID))
+}
+
+func (c *Client) documentURL(vaultID, docID string) string {
+	return fmt.Sprintf("%s/%s", c.documentsURL(vaultID), url.PathEscape(docID))
+}
+
+func (c *Client) send(method, endpoint string, body []byte, status int,
+	opts []ReqOption) (http.Header, []byte, error) {
+	options := &reqOpts{headers: c.headers, ctx: context.Background()}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	req, err := http.NewRequestWithContext(options.ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if options.headers != nil {
+		headers, errHeaders := options.headers(req)
+		if errHeaders != nil {
+			return nil, nil, fmt.Errorf("failed to add request headers: %w", errHeaders)
+		}
+
+		if headers != nil {
+			req.Header = headers.Clone()
+		}
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Errorf("failed to close response body: %s", errClose)
+		}
+	}()
+
+	reply, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != status {
+		return nil, nil, fmt.Errorf("%s %s returned status %d: %s", method, endpoint, resp.StatusCode, string(reply))
+	}
+
+	return resp.Header, reply, nil
+}
@@ -0,0 +1,364 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sds_test
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+	"github.com/trustbloc/edv/pkg/restapi/models"
+)
+
+func TestClient_CreateDataVault(t *testing.T) {
+	t.Run("creates vaults over a single http/2 connection", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		client := sds.New(server.URL, sds.WithHTTPClient(server.Client()))
+
+		for i := 0; i < 3; i++ {
+			location, capability, err := client.CreateDataVault(&models.DataVaultConfiguration{ReferenceID: "ref"},
+				sds.WithRequestHeader(func(req *http.Request) (*http.Header, error) {
+					req.Header.Set("Authorization", "Bearer token")
+
+					return &req.Header, nil
+				}))
+			require.NoError(t, err)
+			require.Equal(t, server.URL+"/vault1", location)
+			require.Equal(t, `{"capability": "zcap"}`, string(capability))
+		}
+
+		require.Equal(t, 1, server.connections())
+		require.Equal(t, []string{"HTTP/2.0"}, server.protocols())
+		require.Equal(t, "Bearer token", server.lastAuthorization())
+	})
+
+	t.Run("error if server rejects the vault", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		server.fail = "/"
+
+		_, _, err := sds.New(server.URL, sds.WithHTTPClient(server.Client())).
+			CreateDataVault(&models.DataVaultConfiguration{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create data vault")
+		require.Contains(t, err.Error(), "returned status 500")
+	})
+
+	t.Run("error if headers cannot be added", func(t *testing.T) {
+		_, _, err := sds.New("http://edv.example.com",
+			sds.WithHeaders(func(*http.Request) (*http.Header, error) { return nil, errors.New("test") }),
+		).CreateDataVault(&models.DataVaultConfiguration{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to add request headers")
+	})
+
+	t.Run("sends the request with the given context", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, _, err := sds.New(server.URL, sds.WithHTTPClient(server.Client())).
+			CreateDataVault(&models.DataVaultConfiguration{}, sds.WithContext(ctx))
+		require.Error(t, err)
+		require.True(t, errors.Is(err, context.Canceled))
+	})
+
+	t.Run("error if server cannot be reached", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		_, _, err := sds.New(server.URL, sds.WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12})).
+			CreateDataVault(&models.DataVaultConfiguration{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to send request")
+	})
+}
+
+func TestClient_Documents(t *testing.T) {
+	t.Run("creates, updates and reads documents", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		client := sds.New(server.URL+"/", sds.WithHTTPClient(server.Client()),
+			sds.WithHeaders(func(req *http.Request) (*http.Header, error) {
+				req.Header.Set("Authorization", "Bearer client")
+
+				return &req.Header, nil
+			}))
+
+		location, err := client.CreateDocument("vault1", &models.EncryptedDocument{ID: "doc1", Sequence: 0})
+		require.NoError(t, err)
+		require.Equal(t, server.URL+"/vault1/documents/doc1", location)
+		require.Equal(t, "Bearer client", server.lastAuthorization())
+
+		require.NoError(t, client.UpdateDocument("vault1", "doc1", &models.EncryptedDocument{ID: "doc1", Sequence: 1}))
+
+		document, err := client.ReadDocument("vault1", "doc1")
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), document.Sequence)
+	})
+
+	t.Run("error if the document cannot be found", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		client := sds.New(server.URL, sds.WithHTTPClient(server.Client()))
+
+		_, err := client.ReadDocument("vault1", "unknown")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read document unknown")
+
+		err = client.UpdateDocument("vault1", "unknown", &models.EncryptedDocument{ID: "unknown"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to update document unknown")
+
+		server.fail = "/vault1/documents"
+
+		_, err = client.CreateDocument("vault1", &models.EncryptedDocument{ID: "doc1"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create document")
+	})
+}
+
+func TestClient_QueryVault(t *testing.T) {
+	t.Run("returns the locations of the documents with the indexed attribute", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		client := sds.New(server.URL, sds.WithHTTPClient(server.Client()))
+
+		for id, value := range map[string]string{"doc1": "a", "doc2": "b"} {
+			_, err := client.CreateDocument("vault1", &models.EncryptedDocument{ID: id,
+				IndexedAttributeCollections: []models.IndexedAttributeCollection{{
+					IndexedAttributes: []models.IndexedAttribute{{Name: "name", Value: value}},
+				}},
+			})
+			require.NoError(t, err)
+		}
+
+		locations, err := client.QueryVault("vault1", "name", "a")
+		require.NoError(t, err)
+		require.Equal(t, []string{server.URL + "/vault1/documents/doc1"}, locations)
+
+		locations, err = client.QueryVault("vault1", "name", "c")
+		require.NoError(t, err)
+		require.Empty(t, locations)
+	})
+
+	t.Run("error if the vault cannot be queried", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		server.fail = "/vault1/query"
+
+		_, err := sds.New(server.URL, sds.WithHTTPClient(server.Client())).QueryVault("vault1", "name", "a")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to query vault")
+	})
+}
+
+func TestClient_Batch(t *testing.T) {
+	t.Run("runs the operations concurrently over a single connection", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		client := sds.New(server.URL, sds.WithHTTPClient(server.Client()), sds.WithBatchConcurrency(4))
+
+		_, err := client.CreateDocument("vault1", &models.EncryptedDocument{ID: "existing"})
+		require.NoError(t, err)
+
+		ops := []*sds.BatchOperation{{DocumentID: "existing", Document: &models.EncryptedDocument{ID: "existing"}}}
+
+		for i := 0; i < 20; i++ {
+			ops = append(ops, &sds.BatchOperation{Document: &models.EncryptedDocument{ID: fmt.Sprintf("doc%d", i)}})
+		}
+
+		locations, err := client.Batch("vault1", ops)
+		require.NoError(t, err)
+		require.Len(t, locations, len(ops))
+		require.Equal(t, server.URL+"/vault1/documents/existing", locations[0])
+		require.Equal(t, server.URL+"/vault1/documents/doc19", locations[20])
+		require.Equal(t, 21, server.documentCount())
+		require.Equal(t, 1, server.connections())
+	})
+
+	t.Run("reports the operations that failed", func(t *testing.T) {
+		server := newMockEDV(t)
+		defer server.Close()
+
+		client := sds.New(server.URL, sds.WithHTTPClient(server.Client()), sds.WithBatchConcurrency(0))
+
+		locations, err := client.Batch("vault1", []*sds.BatchOperation{
+			{Document: &models.EncryptedDocument{ID: "doc1"}},
+			{DocumentID: "unknown", Document: &models.EncryptedDocument{ID: "unknown"}},
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "1 of 2 batch operations failed: operation 1")
+		require.Equal(t, server.URL+"/vault1/documents/doc1", locations[0])
+		require.Empty(t, locations[1])
+	})
+}
+
+// mockEDV is an in-memory EDV server over TLS with HTTP/2 enabled.
+type mockEDV struct {
+	*httptest.Server
+	t     *testing.T
+	mutex sync.Mutex
+	fail  string
+	docs  map[string][]byte
+	auth  string
+	conns map[net.Conn]bool
+	proto map[string]bool
+}
+
+func newMockEDV(t *testing.T) *mockEDV {
+	t.Helper()
+
+	m := &mockEDV{t: t, docs: map[string][]byte{}, conns: map[net.Conn]bool{}, proto: map[string]bool{}}
+
+	m.Server = httptest.NewUnstartedServer(http.HandlerFunc(m.serveHTTP))
+	m.Server.EnableHTTP2 = true
+	m.Server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			m.mutex.Lock()
+			m.conns[conn] = true
+			m.mutex.Unlock()
+		}
+	}
+	m.Server.StartTLS()
+
+	return m
+}
+
+func (m *mockEDV) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.auth = r.Header.Get("Authorization")
+	m.proto[r.Proto] = true
+
+	if m.fail != "" && r.URL.Path == m.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodPost:
+		w.Header().Set("Location", m.URL+"/vault1")
+		w.WriteHeader(http.StatusCreated)
+		m.write(w, `{"capability": "zcap"}`)
+	case len(parts) == 2 && parts[1] == "query":
+		m.query(w, r)
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		doc := &models.EncryptedDocument{}
+		require.NoError(m.t, json.NewDecoder(r.Body).Decode(doc))
+
+		m.docs[doc.ID] = marshal(m.t, doc)
+		w.Header().Set("Location", m.URL+r.URL.Path+"/"+doc.ID)
+		w.WriteHeader(http.StatusCreated)
+	case len(parts) == 3 && m.docs[parts[2]] == nil:
+		w.WriteHeader(http.StatusNotFound)
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		doc := &models.EncryptedDocument{}
+		require.NoError(m.t, json.NewDecoder(r.Body).Decode(doc))
+
+		m.docs[parts[2]] = marshal(m.t, doc)
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		m.write(w, string(m.docs[parts[2]]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// query returns the locations of the documents with an indexed attribute matching the query.
+func (m *mockEDV) query(w http.ResponseWriter, r *http.Request) {
+	query := &models.Query{}
+	require.NoError(m.t, json.NewDecoder(r.Body).Decode(query))
+
+	locations := []string{}
+
+	for id, raw := range m.docs {
+		doc := &models.EncryptedDocument{}
+		require.NoError(m.t, json.Unmarshal(raw, doc))
+
+		for _, collection := range doc.IndexedAttributeCollections {
+			for _, attribute := range collection.IndexedAttributes {
+				if attribute.Name == query.Name && attribute.Value == query.Value {
+					locations = append(locations, m.URL+strings.TrimSuffix(r.URL.Path, "/query")+"/documents/"+id)
+				}
+			}
+		}
+	}
+
+	m.write(w, string(marshal(m.t, locations)))
+}
+
+func (m *mockEDV) write(w http.ResponseWriter, body string) {
+	_, err := w.Write([]byte(body))
+	require.NoError(m.t, err)
+}
+
+func (m *mockEDV) connections() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.conns)
+}
+
+func (m *mockEDV) protocols() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var protocols []string
+
+	for p := range m.proto {
+		protocols = append(protocols, p)
+	}
+
+	return protocols
+}
+
+func (m *mockEDV) lastAuthorization() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.auth
+}
+
+func (m *mockEDV) documentCount() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return len(m.docs)
+}
+
+func marshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	bits, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	return bits
+}
@@ -0,0 +1,201 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	awsService           = "secretsmanager"
+	awsGetSecretValue    = "secretsmanager.GetSecretValue"
+	awsContentType       = "application/x-amz-json-1.1"
+	awsSigningAlgorithm  = "AWS4-HMAC-SHA256"
+	awsNotFoundErrorType = "ResourceNotFoundException"
+	awsDateFormat        = "20060102"
+	awsTimeFormat        = "20060102T150405Z"
+)
+
+// AWSConfig holds the configuration of an AWS Secrets Manager provider.
+type AWSConfig struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is the token of temporary credentials, if any.
+	SessionToken string
+	// Endpoint overrides the regional endpoint of the service, e.g. for a VPC endpoint.
+	Endpoint  string
+	TLSConfig *tls.Config
+}
+
+// AWS fetches secrets from AWS Secrets Manager.
+type AWS struct {
+	config   *AWSConfig
+	endpoint string
+	client   *http.Client
+	now      func() time.Time
+}
+
+type awsSecretValue struct {
+	SecretString *string `json:"SecretString"`
+	SecretBinary []byte  `json:"SecretBinary"`
+}
+
+type awsError struct {
+	Type string `json:"__type"`
+}
+
+// NewAWS returns a new AWS Secrets Manager provider.
+func NewAWS(config *AWSConfig) *AWS {
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", awsService, config.Region)
+	}
+
+	return &AWS{
+		config:   config,
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/",
+		client: &http.Client{
+			Timeout:   fetchTimeout,
+			Transport: &http.Transport{TLSClientConfig: config.TLSConfig},
+		},
+		now: time.Now,
+	}
+}
+
+// Fetch returns the current version of the secret. The name is the name or the ARN of the secret.
+func (a *AWS) Fetch(ctx context.Context, name string) ([]byte, error) {
+	payload, err := json.Marshal(map[string]string{"SecretId": name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal aws request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aws request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", awsContentType)
+	req.Header.Set("X-Amz-Target", awsGetSecretValue)
+
+	a.sign(req, payload)
+
+	body, status, err := do(a.client, req)
+	if status == http.StatusBadRequest && isNotFound(body) {
+		return nil, fmt.Errorf("aws secret %s: %w", name, ErrNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch aws secret %s: %w", name, err)
+	}
+
+	value := &awsSecretValue{}
+
+	err = json.Unmarshal(body, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal aws secret %s: %w", name, err)
+	}
+
+	if value.SecretString != nil {
+		return []byte(*value.SecretString), nil
+	}
+
+	return value.SecretBinary, nil
+}
+
+// sign signs the request with AWS Signature Version 4.
+func (a *AWS) sign(req *http.Request, payload []byte) {
+	now := a.now().UTC()
+	date := now.Format(awsDateFormat)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", now.Format(awsTimeFormat))
+
+	if a.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.config.SessionToken)
+	}
+
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, canonicalQuery(req.URL.Query()), canonicalHeaders.String(), signedHeaders, hashHex(payload),
+	}, "\n")
+
+	scope := strings.Join([]string{date, a.config.Region, awsService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		awsSigningAlgorithm, now.Format(awsTimeFormat), scope, hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey(a.config.SecretAccessKey, date, a.config.Region,
+		awsService), []byte(stringToSign)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgorithm, a.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// signingKey derives the key signing the requests of the day to the service in the region.
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func canonicalQuery(values url.Values) string {
+	// url.Values.Encode sorts by key and escapes spaces as '+', which AWS expects as '%20'
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data) // nolint:errcheck,gosec // hash writes never fail
+
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func isNotFound(body []byte) bool {
+	e := &awsError{}
+
+	return json.Unmarshal(body, e) == nil && strings.HasSuffix(e.Type, awsNotFoundErrorType)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets // nolint:testpackage // testing the request signature
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAWS_Fetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, awsGetSecretValue, r.Header.Get("X-Amz-Target"))
+		require.Equal(t, "20201201T120000Z", r.Header.Get("X-Amz-Date"))
+		require.Equal(t, "session", r.Header.Get("X-Amz-Security-Token"))
+		require.Regexp(t, `^AWS4-HMAC-SHA256 Credential=AKID/20201201/us-east-1/secretsmanager/aws4_request, `+
+			`SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature=[0-9a-f]{64}$`,
+			r.Header.Get("Authorization"))
+
+		request := map[string]string{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		switch request["SecretId"] {
+		case "string":
+			fmt.Fprint(w, `{"Name": "string", "SecretString": "client-secret"}`)
+		case "binary":
+			fmt.Fprint(w, `{"Name": "binary", "SecretBinary": "AQID"}`)
+		case "invalid":
+			fmt.Fprint(w, `{`)
+		case "denied":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "AccessDeniedException"}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "ResourceNotFoundException", "message": "not found"}`)
+		}
+	}))
+	defer srv.Close()
+
+	a := NewAWS(&AWSConfig{
+		Region:          "us-east-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		SessionToken:    "session",
+		Endpoint:        srv.URL,
+	})
+	a.now = func() time.Time { return time.Date(2020, 12, 1, 12, 0, 0, 0, time.UTC) }
+
+	t.Run("fetches a string secret", func(t *testing.T) {
+		value, err := a.Fetch(context.Background(), "string")
+		require.NoError(t, err)
+		require.Equal(t, "client-secret", string(value))
+	})
+
+	t.Run("fetches a binary secret", func(t *testing.T) {
+		value, err := a.Fetch(context.Background(), "binary")
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2, 3}, value)
+	})
+
+	t.Run("error if the secret does not exist", func(t *testing.T) {
+		_, err := a.Fetch(context.Background(), "missing")
+		require.True(t, errors.Is(err, ErrNotFound))
+	})
+
+	t.Run("error if the request is rejected", func(t *testing.T) {
+		_, err := a.Fetch(context.Background(), "denied")
+		require.Error(t, err)
+		require.False(t, errors.Is(err, ErrNotFound))
+		require.Contains(t, err.Error(), "AccessDeniedException")
+	})
+
+	t.Run("error if the response cannot be parsed", func(t *testing.T) {
+		_, err := a.Fetch(context.Background(), "invalid")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal aws secret")
+	})
+}
+
+func TestNewAWS(t *testing.T) {
+	require.Equal(t, "https://secretsmanager.eu-west-1.amazonaws.com/", NewAWS(&AWSConfig{Region: "eu-west-1"}).endpoint)
+}
+
+func TestSigningKey(t *testing.T) {
+	// example of the AWS Signature Version 4 documentation
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	require.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+}
@@ -0,0 +1,56 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const fetchTimeout = 10 * time.Second
+
+var logger = log.New("edge-agent/secrets")
+
+// ErrNotFound is returned when the secret does not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider fetches secrets from a secrets manager.
+type Provider interface {
+	Fetch(ctx context.Context, name string) ([]byte, error)
+}
+
+// do sends the request and returns the body of the response, or an error if its status is not 200.
+func do(client *http.Client, req *http.Request) ([]byte, int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			logger.Warnf("failed to close response body: %s", closeErr.Error())
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return body, resp.StatusCode, fmt.Errorf("unexpected response status %d: %s", resp.StatusCode, body)
+	}
+
+	return body, resp.StatusCode, nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultVaultMount = "secret"
+	defaultVaultField = "value"
+	vaultTokenHeader  = "X-Vault-Token"
+)
+
+// VaultConfig holds the configuration of a Vault provider.
+type VaultConfig struct {
+	// Address of the Vault server, e.g. https://vault.example.com:8200.
+	Address string
+	Token   string
+	// Mount is the path of the KV version 2 secrets engine. Defaults to "secret".
+	Mount     string
+	TLSConfig *tls.Config
+}
+
+// Vault fetches secrets from the KV version 2 secrets engine of a HashiCorp Vault server.
+type Vault struct {
+	address string
+	token   string
+	mount   string
+	client  *http.Client
+}
+
+type vaultResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// NewVault returns a new Vault provider.
+func NewVault(config *VaultConfig) *Vault {
+	mount := config.Mount
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+
+	return &Vault{
+		address: strings.TrimSuffix(config.Address, "/"),
+		token:   config.Token,
+		mount:   strings.Trim(mount, "/"),
+		client: &http.Client{
+			Timeout:   fetchTimeout,
+			Transport: &http.Transport{TLSClientConfig: config.TLSConfig},
+		},
+	}
+}
+
+// Fetch returns the latest version of the secret. The name is the path of the secret, optionally followed by
+// '#' and the field holding the value, e.g. "edge-agent/cookies#auth". The field defaults to "value".
+func (v *Vault) Fetch(ctx context.Context, name string) ([]byte, error) {
+	path, field := name, defaultVaultField
+
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		path, field = name[:i], name[i+1:]
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/v1/%s/data/%s", v.address, v.mount, strings.TrimPrefix(path, "/")), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault request: %w", err)
+	}
+
+	req.Header.Set(vaultTokenHeader, v.token)
+
+	body, status, err := do(v.client, req)
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("vault secret %s: %w", path, ErrNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch vault secret %s: %w", path, err)
+	}
+
+	resp := &vaultResponse{}
+
+	err = json.Unmarshal(body, resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vault secret %s: %w", path, err)
+	}
+
+	value, found := resp.Data.Data[field]
+	if !found {
+		return nil, fmt.Errorf("vault secret %s has no field %s: %w", path, field, ErrNotFound)
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("field %s of vault secret %s is not a string", field, path)
+	}
+
+	return []byte(s), nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/secrets"
+)
+
+func TestVault_Fetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/kv/data/edge-agent/cookies":
+			fmt.Fprint(w, `{"data": {"data": {"value": "default", "auth": "auth-key", "count": 1}}}`)
+		case "/v1/kv/data/edge-agent/invalid":
+			fmt.Fprint(w, `{`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	v := secrets.NewVault(&secrets.VaultConfig{Address: srv.URL + "/", Token: "token", Mount: "kv"})
+
+	t.Run("fetches the value field of the secret", func(t *testing.T) {
+		value, err := v.Fetch(context.Background(), "edge-agent/cookies")
+		require.NoError(t, err)
+		require.Equal(t, "default", string(value))
+	})
+
+	t.Run("fetches a field of the secret", func(t *testing.T) {
+		value, err := v.Fetch(context.Background(), "edge-agent/cookies#auth")
+		require.NoError(t, err)
+		require.Equal(t, "auth-key", string(value))
+	})
+
+	t.Run("error if the secret or its field does not exist", func(t *testing.T) {
+		_, err := v.Fetch(context.Background(), "edge-agent/missing")
+		require.True(t, errors.Is(err, secrets.ErrNotFound))
+
+		_, err = v.Fetch(context.Background(), "edge-agent/cookies#enc")
+		require.True(t, errors.Is(err, secrets.ErrNotFound))
+	})
+
+	t.Run("error if the field is not a string", func(t *testing.T) {
+		_, err := v.Fetch(context.Background(), "edge-agent/cookies#count")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "is not a string")
+	})
+
+	t.Run("error if the response cannot be parsed", func(t *testing.T) {
+		_, err := v.Fetch(context.Background(), "edge-agent/invalid")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal vault secret")
+	})
+
+	t.Run("error if the request is rejected", func(t *testing.T) {
+		_, err := secrets.NewVault(&secrets.VaultConfig{Address: srv.URL, Mount: "kv"}).
+			Fetch(context.Background(), "edge-agent/cookies")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unexpected response status 403")
+	})
+}
@@ -0,0 +1,166 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRefreshInterval = 5 * time.Minute
+
+// WatcherConfig holds the configuration for a Watcher.
+type WatcherConfig struct {
+	Provider Provider
+	// Interval between refreshes. Defaults to 5m.
+	Interval time.Duration
+}
+
+// Watcher re-fetches secrets periodically and hands the rotated values to their consumers.
+type Watcher struct {
+	provider Provider
+	interval time.Duration
+	mutex    sync.Mutex
+	watches  []*watch
+	once     sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type watch struct {
+	names    []string
+	values   [][]byte
+	onChange func(values [][]byte) error
+}
+
+// NewWatcher returns a new Watcher.
+func NewWatcher(config *WatcherConfig) *Watcher {
+	w := &Watcher{
+		provider: config.Provider,
+		interval: config.Interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if w.interval <= 0 {
+		w.interval = defaultRefreshInterval
+	}
+
+	return w
+}
+
+// Watch fetches the secrets and hands their values to onChange, in the order of their names. onChange is called
+// again with all the values whenever one of them is rotated. Values that onChange rejects are retried on the next
+// refresh, so that the consumer keeps its current values in the meantime.
+func (w *Watcher) Watch(onChange func(values [][]byte) error, names ...string) error {
+	values, err := w.fetch(names)
+	if err != nil {
+		return err
+	}
+
+	err = onChange(values)
+	if err != nil {
+		return fmt.Errorf("failed to apply secrets %s: %w", strings.Join(names, ", "), err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.watches = append(w.watches, &watch{names: names, values: values, onChange: onChange})
+
+	return nil
+}
+
+// Start refreshing the secrets in the background.
+func (w *Watcher) Start() {
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.Refresh()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the watcher and wait for the current refresh to finish.
+func (w *Watcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+}
+
+// Refresh runs a single pass over the watched secrets. Failures are logged, and the consumers keep their current
+// values until the next pass.
+func (w *Watcher) Refresh() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, watch := range w.watches {
+		values, err := w.fetch(watch.names)
+		if err != nil {
+			logger.Warnf("keeping the current secrets: %s", err.Error())
+
+			continue
+		}
+
+		if equal(values, watch.values) {
+			continue
+		}
+
+		err = watch.onChange(values)
+		if err != nil {
+			logger.Errorf("failed to apply rotated secrets %s: %s", strings.Join(watch.names, ", "), err.Error())
+
+			continue
+		}
+
+		logger.Infof("rotated secrets %s", strings.Join(watch.names, ", "))
+
+		watch.values = values
+	}
+}
+
+func (w *Watcher) fetch(names []string) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	values := make([][]byte, len(names))
+
+	for i, name := range names {
+		value, err := w.provider.Fetch(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch secret %s: %w", name, err)
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+func equal(a, b [][]byte) bool {
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
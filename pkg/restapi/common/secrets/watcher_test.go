@@ -0,0 +1,148 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/secrets"
+)
+
+func TestWatcher(t *testing.T) {
+	t.Run("hands the rotated secrets to their consumer", func(t *testing.T) {
+		p := &mockProvider{secrets: map[string]string{"auth": "a1", "enc": "e1"}}
+		w := secrets.NewWatcher(&secrets.WatcherConfig{Provider: p})
+
+		var applied [][]string
+
+		require.NoError(t, w.Watch(func(values [][]byte) error {
+			applied = append(applied, []string{string(values[0]), string(values[1])})
+
+			return nil
+		}, "auth", "enc"))
+		require.Equal(t, [][]string{{"a1", "e1"}}, applied)
+
+		w.Refresh()
+		require.Len(t, applied, 1)
+
+		p.set("enc", "e2")
+		w.Refresh()
+		require.Equal(t, [][]string{{"a1", "e1"}, {"a1", "e2"}}, applied)
+	})
+
+	t.Run("keeps the current secrets if they cannot be fetched or applied", func(t *testing.T) {
+		p := &mockProvider{secrets: map[string]string{"secret": "s1"}}
+		w := secrets.NewWatcher(&secrets.WatcherConfig{Provider: p})
+
+		var (
+			current string
+			reject  bool
+		)
+
+		require.NoError(t, w.Watch(func(values [][]byte) error {
+			if reject {
+				return errors.New("test")
+			}
+
+			current = string(values[0])
+
+			return nil
+		}, "secret"))
+
+		p.set("secret", "s2")
+		reject = true
+		w.Refresh()
+		require.Equal(t, "s1", current)
+
+		p.err = errors.New("test")
+		reject = false
+		w.Refresh()
+		require.Equal(t, "s1", current)
+
+		p.err = nil
+		w.Refresh()
+		require.Equal(t, "s2", current)
+	})
+
+	t.Run("error if the secrets cannot be fetched or applied initially", func(t *testing.T) {
+		w := secrets.NewWatcher(&secrets.WatcherConfig{Provider: &mockProvider{}})
+
+		err := w.Watch(func([][]byte) error { return nil }, "missing")
+		require.True(t, errors.Is(err, secrets.ErrNotFound))
+
+		w = secrets.NewWatcher(&secrets.WatcherConfig{
+			Provider: &mockProvider{secrets: map[string]string{"secret": "s1"}},
+		})
+
+		err = w.Watch(func([][]byte) error { return errors.New("test") }, "secret")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to apply secrets secret")
+	})
+
+	t.Run("refreshes the secrets in the background", func(t *testing.T) {
+		p := &mockProvider{secrets: map[string]string{"secret": "s1"}}
+		w := secrets.NewWatcher(&secrets.WatcherConfig{Provider: p, Interval: time.Millisecond})
+
+		rotated := make(chan string, 1)
+
+		require.NoError(t, w.Watch(func(values [][]byte) error {
+			select {
+			case rotated <- string(values[0]):
+			default:
+			}
+
+			return nil
+		}, "secret"))
+		require.Equal(t, "s1", <-rotated)
+
+		w.Start()
+		defer w.Stop()
+
+		p.set("secret", "s2")
+
+		select {
+		case value := <-rotated:
+			require.Equal(t, "s2", value)
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for the rotation")
+		}
+	})
+}
+
+type mockProvider struct {
+	mutex   sync.Mutex
+	secrets map[string]string
+	err     error
+}
+
+func (m *mockProvider) Fetch(_ context.Context, name string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	value, found := m.secrets[name]
+	if !found {
+		return nil, secrets.ErrNotFound
+	}
+
+	return []byte(value), nil
+}
+
+func (m *mockProvider) set(name, value string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.secrets[name] = value
+}
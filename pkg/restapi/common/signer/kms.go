@@ -0,0 +1,116 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package signer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// KeyIDKey is the key of the ID of the signing key in the store of a KMSSigner.
+const KeyIDKey = "signing_key_id"
+
+// KMSSigner signs remotely with an Ed25519 key of a keystore of the KMS. The key is created when the first instance
+// of the agent starts, and its ID kept in storage so that the instances share it.
+type KMSSigner struct {
+	crypto crypto.Crypto
+	kid    string
+	kh     interface{}
+}
+
+// NewKMSSigner returns a signer of the key whose ID is kept in the store, creating the key if there is none.
+func NewKMSSigner(km kms.KeyManager, c crypto.Crypto, s storage.Store) (*KMSSigner, error) {
+	kid, err := keyID(km, s)
+	if err != nil {
+		return nil, err
+	}
+
+	kh, err := km.Get(kid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing key: %w", err)
+	}
+
+	return &KMSSigner{crypto: c, kid: kid, kh: kh}, nil
+}
+
+func keyID(km kms.KeyManager, s storage.Store) (string, error) {
+	kid, err := s.Get(KeyIDKey)
+	if err == nil {
+		return string(kid), nil
+	}
+
+	if !errors.Is(err, storage.ErrValueNotFound) {
+		return "", fmt.Errorf("failed to read signing key id: %w", err)
+	}
+
+	created, _, err := km.Create(kms.ED25519Type)
+	if err != nil {
+		return "", fmt.Errorf("failed to create signing key: %w", err)
+	}
+
+	err = s.Put(KeyIDKey, []byte(created))
+	if err != nil {
+		return "", fmt.Errorf("failed to save signing key id: %w", err)
+	}
+
+	// the instances starting concurrently use the key saved last
+	kid, err = s.Get(KeyIDKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read signing key id: %w", err)
+	}
+
+	return string(kid), nil
+}
+
+// KeyID returns the ID of the signing key.
+func (s *KMSSigner) KeyID() (string, error) {
+	return s.kid, nil
+}
+
+// Sign signs the data with the key, which must be the signing key.
+func (s *KMSSigner) Sign(data []byte, kid string) ([]byte, error) {
+	err := s.check(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := s.crypto.Sign(data, s.kh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	return signature, nil
+}
+
+// Verify returns an error if the signature of the data is not one of the signing key.
+func (s *KMSSigner) Verify(signature, data []byte, kid string) error {
+	err := s.check(kid)
+	if err != nil {
+		return err
+	}
+
+	err = s.crypto.Verify(signature, data, s.kh)
+	if err != nil {
+		return fmt.Errorf("failed to verify signature: %w", err)
+	}
+
+	return nil
+}
+
+// check only accepts the signing key, so that the kid read from a signature never points the requests to the KMS
+// elsewhere.
+func (s *KMSSigner) check(kid string) error {
+	if kid != s.kid {
+		return fmt.Errorf("key %s is not the signing key of the agent", kid)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package signer_test
+
+import (
+	"errors"
+	"testing"
+
+	mockcrypto "github.com/hyperledger/aries-framework-go/pkg/mock/crypto"
+	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/signer"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestKMSSigner(t *testing.T) {
+	t.Run("creates the key on start and signs with it remotely", func(t *testing.T) {
+		s := newStore(t)
+		c := &mockcrypto.Crypto{SignValue: []byte("signature")}
+
+		kms := &mockkms.KeyManager{CreateKeyID: "key"}
+
+		ks, err := signer.NewKMSSigner(kms, c, s)
+		require.NoError(t, err)
+
+		kid, err := ks.KeyID()
+		require.NoError(t, err)
+		require.Equal(t, "key", kid)
+
+		signature, err := ks.Sign([]byte("data"), kid)
+		require.NoError(t, err)
+		require.Equal(t, []byte("signature"), signature)
+		require.NoError(t, ks.Verify(signature, []byte("data"), kid))
+
+		// the other instances of the agent share the key
+		other, err := signer.NewKMSSigner(&mockkms.KeyManager{CreateKeyErr: errors.New("test")}, c, s)
+		require.NoError(t, err)
+
+		kid, err = other.KeyID()
+		require.NoError(t, err)
+		require.Equal(t, "key", kid)
+	})
+
+	t.Run("only uses the signing key", func(t *testing.T) {
+		ks, err := signer.NewKMSSigner(&mockkms.KeyManager{CreateKeyID: "key"}, &mockcrypto.Crypto{}, newStore(t))
+		require.NoError(t, err)
+
+		_, err = ks.Sign([]byte("data"), "../other")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "is not the signing key of the agent")
+
+		err = ks.Verify([]byte("signature"), []byte("data"), "other")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "is not the signing key of the agent")
+	})
+
+	t.Run("error if the kms fails to sign or verify", func(t *testing.T) {
+		ks, err := signer.NewKMSSigner(&mockkms.KeyManager{CreateKeyID: "key"}, &mockcrypto.Crypto{
+			SignErr:   errors.New("test"),
+			VerifyErr: errors.New("test"),
+		}, newStore(t))
+		require.NoError(t, err)
+
+		_, err = ks.Sign([]byte("data"), "key")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to sign")
+
+		err = ks.Verify([]byte("signature"), []byte("data"), "key")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to verify signature")
+	})
+
+	t.Run("error if the signing key cannot be created", func(t *testing.T) {
+		_, err := signer.NewKMSSigner(&mockkms.KeyManager{CreateKeyErr: errors.New("test")}, nil, newStore(t))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to create signing key")
+
+		_, err = signer.NewKMSSigner(&mockkms.KeyManager{GetKeyErr: errors.New("test")}, nil, newStore(t))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get signing key")
+	})
+
+	t.Run("error if the signing key id cannot be read or saved", func(t *testing.T) {
+		_, err := signer.NewKMSSigner(&mockkms.KeyManager{}, nil, &mockstore.MockStore{
+			Store:  map[string][]byte{signer.KeyIDKey: []byte("key")},
+			ErrGet: errors.New("test"),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read signing key id")
+
+		_, err = signer.NewKMSSigner(&mockkms.KeyManager{}, nil, &mockstore.MockStore{
+			Store:  map[string][]byte{},
+			ErrPut: errors.New("test"),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save signing key id")
+	})
+}
+
+func newStore(t *testing.T) *mockstore.MockStore {
+	t.Helper()
+
+	return &mockstore.MockStore{Store: map[string][]byte{}}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package signer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// LocalSigner signs with an Ed25519 key held in memory, for development only: the key is lost when the agent
+// stops, and is not shared by its instances.
+type LocalSigner struct {
+	key ed25519.PrivateKey
+	kid string
+}
+
+// NewLocalSigner returns a signer of a new key.
+func NewLocalSigner() (*LocalSigner, error) {
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	fingerprint := sha256.Sum256(public)
+
+	return &LocalSigner{key: key, kid: "local-" + base64.RawURLEncoding.EncodeToString(fingerprint[:])}, nil
+}
+
+// KeyID returns the ID of the signing key, derived from its public key.
+func (s *LocalSigner) KeyID() (string, error) {
+	return s.kid, nil
+}
+
+// Sign signs the data with the key, which must be the signing key.
+func (s *LocalSigner) Sign(data []byte, kid string) ([]byte, error) {
+	if kid != s.kid {
+		return nil, fmt.Errorf("key %s is not the signing key of the agent", kid)
+	}
+
+	return ed25519.Sign(s.key, data), nil
+}
+
+// Verify returns an error if the signature of the data is not one of the signing key.
+func (s *LocalSigner) Verify(signature, data []byte, kid string) error {
+	if kid != s.kid {
+		return fmt.Errorf("key %s is not the signing key of the agent", kid)
+	}
+
+	if !ed25519.Verify(s.key.Public().(ed25519.PublicKey), data, signature) {
+		return errors.New("failed to verify signature: invalid signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package signer_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/signer"
+)
+
+func TestLocalSigner(t *testing.T) {
+	ls, err := signer.NewLocalSigner()
+	require.NoError(t, err)
+
+	kid, err := ls.KeyID()
+	require.NoError(t, err)
+	require.Contains(t, kid, "local-")
+
+	signature, err := ls.Sign([]byte("data"), kid)
+	require.NoError(t, err)
+	require.NoError(t, ls.Verify(signature, []byte("data"), kid))
+
+	err = ls.Verify(signature, []byte("tampered"), kid)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to verify signature")
+
+	_, err = ls.Sign([]byte("data"), "other")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not the signing key of the agent")
+
+	err = ls.Verify(signature, []byte("data"), "other")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is not the signing key of the agent")
+
+	// the keys are not shared
+	other, err := signer.NewLocalSigner()
+	require.NoError(t, err)
+
+	otherKID, err := other.KeyID()
+	require.NoError(t, err)
+	require.NotEqual(t, kid, otherKID)
+}
@@ -0,0 +1,22 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package signer signs on behalf of the agent, e.g. the bootstrap data of the users, with an Ed25519 key held by
+// the ops KMS, or by the agent itself in development.
+package signer
+
+// Algorithm is the JWS algorithm of the signatures.
+const Algorithm = "EdDSA"
+
+// Signer signs with a key of the agent.
+type Signer interface {
+	// KeyID returns the ID of the key the data is signed with.
+	KeyID() (string, error)
+	// Sign returns the signature of the data by the key.
+	Sign(data []byte, kid string) ([]byte, error)
+	// Verify returns an error if the signature of the data is not one of the key.
+	Verify(signature, data []byte, kid string) error
+}
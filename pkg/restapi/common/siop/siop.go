@@ -0,0 +1,244 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package siop implements the relying party of the Self-Issued OpenID Provider v2 (SIOPv2), with which the users
+// log in with a DID of their wallet instead of an account of a hosted identity provider.
+package siop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/verifier"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+)
+
+const (
+	// RequestScheme is the scheme of the requests, which the operating system hands to the wallet of the user.
+	RequestScheme = "openid://"
+	// the id_tokens may be issued by a wallet whose clock is slightly off.
+	clockSkew = time.Minute
+	// the lifetime of the id_tokens is bounded, as they cannot be revoked.
+	maxTokenAge = 10 * time.Minute
+)
+
+// ErrInvalidIDToken is returned for the id_tokens which are not valid self-issued id_tokens of the request.
+var ErrInvalidIDToken = errors.New("invalid self-issued id_token")
+
+// Resolver resolves the DIDs of the users.
+type Resolver interface {
+	Resolve(did string, opts ...vdrapi.ResolveOpts) (*did.Doc, error)
+}
+
+// VDRResolver resolves the DIDs with the first of its VDRs accepting their method.
+type VDRResolver []vdrapi.VDR
+
+// Resolve resolves the DID.
+func (r VDRResolver) Resolve(didID string, opts ...vdrapi.ResolveOpts) (*did.Doc, error) {
+	parsed, err := did.Parse(didID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid did: %w", err)
+	}
+
+	for _, v := range r {
+		if v.Accept(parsed.Method) {
+			return v.Read(didID, opts...)
+		}
+	}
+
+	return nil, fmt.Errorf("did method %s not supported", parsed.Method)
+}
+
+// Config configures a RelyingParty.
+type Config struct {
+	// ClientID identifies the agent to the wallets. It is the redirect URI the wallets send the id_tokens to.
+	ClientID string
+	// DIDMethods are the methods of the DIDs of the users, e.g. key. They are offered to the wallets in the
+	// requests.
+	DIDMethods []string
+	Resolver   Resolver
+}
+
+// RelyingParty builds the SIOP requests, and verifies the id_tokens the wallets respond with.
+type RelyingParty struct {
+	clientID   string
+	didMethods []string
+	resolver   Resolver
+	now        func() time.Time
+}
+
+// New returns a new RelyingParty.
+func New(config *Config) *RelyingParty {
+	return &RelyingParty{
+		clientID:   config.ClientID,
+		didMethods: config.DIDMethods,
+		resolver:   config.Resolver,
+		now:        time.Now,
+	}
+}
+
+type registration struct {
+	SubjectSyntaxTypes []string `json:"subject_syntax_types_supported"`
+	SigningAlgs        []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// FormatRequest formats the SIOP request of an id_token bound to the state and nonce, sent back to the client ID in
+// the query of a redirect.
+func (p *RelyingParty) FormatRequest(state, nonce string) string {
+	syntaxTypes := make([]string, len(p.didMethods))
+
+	for i, method := range p.didMethods {
+		syntaxTypes[i] = "did:" + method
+	}
+
+	// the registration of the agent is static, so it cannot fail to marshal
+	reg, _ := json.Marshal(&registration{
+		SubjectSyntaxTypes: syntaxTypes,
+		SigningAlgs:        []string{"EdDSA", "RS256"},
+	})
+
+	return RequestScheme + "?" + url.Values{
+		"response_type": {"id_token"},
+		"response_mode": {"query"},
+		"scope":         {"openid"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.clientID},
+		"state":         {state},
+		"nonce":         {nonce},
+		"registration":  {string(reg)},
+	}.Encode()
+}
+
+// Claims are the verified claims of a self-issued id_token. Their sub is the DID of the user.
+type Claims map[string]interface{}
+
+// Claims scans the claims into 'i', like the claims of the id_tokens of the hosted providers.
+func (c Claims) Claims(i interface{}) error {
+	bits, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal claims: %w", err)
+	}
+
+	return json.Unmarshal(bits, i)
+}
+
+type idTokenClaims struct {
+	jwt.Claims
+	Nonce string `json:"nonce"`
+}
+
+// VerifyIDToken verifies that the id_token is signed with an authentication key of the DID of its sub, which issued
+// it to the agent in response to the request of the nonce.
+func (p *RelyingParty) VerifyIDToken(idToken, nonce string) (Claims, error) {
+	token, err := jwt.Parse(idToken, jwt.WithSignatureVerifier(jwt.NewVerifier(jwt.KeyResolverFunc(p.publicKey))))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err.Error())
+	}
+
+	claims := &idTokenClaims{}
+
+	err = token.DecodeClaims(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err.Error())
+	}
+
+	err = p.validate(claims, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err.Error())
+	}
+
+	all := Claims{}
+
+	err = token.DecodeClaims(&all)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err.Error())
+	}
+
+	return all, nil
+}
+
+func (p *RelyingParty) validate(claims *idTokenClaims, nonce string) error {
+	// self-issued id_tokens of DIDs are issued by the DIDs they are about
+	if claims.Issuer != claims.Subject {
+		return errors.New("the issuer is not the subject")
+	}
+
+	if !claims.Audience.Contains(p.clientID) {
+		return errors.New("the id_token was not issued to the agent")
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return errors.New("invalid nonce")
+	}
+
+	if claims.Expiry == nil || claims.IssuedAt == nil {
+		return errors.New("the id_token has no expiry or issuance time")
+	}
+
+	now := p.now()
+
+	if now.After(claims.Expiry.Time().Add(clockSkew)) {
+		return errors.New("the id_token expired")
+	}
+
+	if claims.IssuedAt.Time().After(now.Add(clockSkew)) || now.Sub(claims.IssuedAt.Time()) > maxTokenAge {
+		return errors.New("the id_token was not issued for this login")
+	}
+
+	return nil
+}
+
+// publicKey resolves the key of the kid among the authentication keys of the DID of the issuer.
+func (p *RelyingParty) publicKey(issuer, kid string) (*verifier.PublicKey, error) {
+	if !IsDID(issuer) {
+		return nil, fmt.Errorf("the issuer %s is not a did", issuer)
+	}
+
+	if kid == "" {
+		return nil, errors.New("missing kid")
+	}
+
+	if strings.HasPrefix(kid, "#") {
+		kid = issuer + kid
+	}
+
+	if !strings.HasPrefix(kid, issuer+"#") {
+		return nil, fmt.Errorf("kid %s is not a key of %s", kid, issuer)
+	}
+
+	doc, err := p.resolver.Resolve(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", issuer, err)
+	}
+
+	for _, auth := range doc.Authentication {
+		vm := auth.VerificationMethod
+
+		id := vm.ID
+		if strings.HasPrefix(id, "#") {
+			id = doc.ID + id
+		}
+
+		if id == kid {
+			return &verifier.PublicKey{Type: vm.Type, Value: vm.Value, JWK: vm.JSONWebKey()}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not an authentication key of %s", kid, issuer)
+}
+
+// IsDID tells whether the sub of a user is a DID, which the user logged in with.
+func IsDID(sub string) bool {
+	_, err := did.Parse(sub)
+
+	return err == nil
+}
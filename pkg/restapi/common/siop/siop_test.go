@@ -0,0 +1,288 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package siop_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/key"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/siop"
+)
+
+const clientID = "https://agent.example.com/siop/callback"
+
+func TestRelyingParty_FormatRequest(t *testing.T) {
+	rp := siop.New(&siop.Config{ClientID: clientID, DIDMethods: []string{"key", "trustbloc"}})
+
+	request := rp.FormatRequest("state", "nonce")
+	require.True(t, strings.HasPrefix(request, siop.RequestScheme+"?"))
+
+	u, err := url.Parse(request)
+	require.NoError(t, err)
+
+	query := u.Query()
+	require.Equal(t, "id_token", query.Get("response_type"))
+	require.Equal(t, "openid", query.Get("scope"))
+	require.Equal(t, clientID, query.Get("client_id"))
+	require.Equal(t, clientID, query.Get("redirect_uri"))
+	require.Equal(t, "state", query.Get("state"))
+	require.Equal(t, "nonce", query.Get("nonce"))
+
+	registration := &struct {
+		SubjectSyntaxTypes []string `json:"subject_syntax_types_supported"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(query.Get("registration")), registration))
+	require.Equal(t, []string{"did:key", "did:trustbloc"}, registration.SubjectSyntaxTypes)
+}
+
+func TestRelyingParty_VerifyIDToken(t *testing.T) {
+	t.Run("verifies the id_token of a did", func(t *testing.T) {
+		w := newWallet(t)
+
+		claims, err := newRelyingParty().VerifyIDToken(w.idToken(t, w.claims("nonce"), w.keyID), "nonce")
+		require.NoError(t, err)
+		require.Equal(t, w.did, claims["sub"])
+
+		user := &struct {
+			Sub string `json:"sub"`
+		}{}
+		require.NoError(t, claims.Claims(user))
+		require.Equal(t, w.did, user.Sub)
+	})
+
+	t.Run("verifies the id_token of a relative kid", func(t *testing.T) {
+		w := newWallet(t)
+
+		_, err := newRelyingParty().VerifyIDToken(
+			w.idToken(t, w.claims("nonce"), w.keyID[strings.Index(w.keyID, "#"):]), "nonce")
+		require.NoError(t, err)
+	})
+
+	t.Run("rejects the invalid id_tokens", func(t *testing.T) {
+		w := newWallet(t)
+		other := newWallet(t)
+
+		tests := []struct {
+			name   string
+			claims func(map[string]interface{})
+			kid    string
+			err    string
+		}{{
+			name:   "wrong nonce",
+			claims: func(c map[string]interface{}) { c["nonce"] = "other" },
+			err:    "invalid nonce",
+		}, {
+			name:   "wrong audience",
+			claims: func(c map[string]interface{}) { c["aud"] = "https://other.example.com" },
+			err:    "not issued to the agent",
+		}, {
+			name:   "issuer is not the subject",
+			claims: func(c map[string]interface{}) { c["sub"] = other.did },
+			err:    "the issuer is not the subject",
+		}, {
+			name: "issuer is not a did",
+			claims: func(c map[string]interface{}) {
+				c["iss"] = "alice"
+				c["sub"] = "alice"
+			},
+			err: "is not a did",
+		}, {
+			name:   "expired",
+			claims: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			err:    "the id_token expired",
+		}, {
+			name:   "issued long ago",
+			claims: func(c map[string]interface{}) { c["iat"] = time.Now().Add(-time.Hour).Unix() },
+			err:    "not issued for this login",
+		}, {
+			name:   "no expiry",
+			claims: func(c map[string]interface{}) { delete(c, "exp") },
+			err:    "no expiry",
+		}, {
+			name: "kid of another did",
+			kid:  other.keyID,
+			err:  "is not a key of",
+		}, {
+			name: "unknown kid",
+			kid:  w.did + "#other",
+			err:  "is not an authentication key",
+		}, {
+			name: "no kid",
+			kid:  "none",
+			err:  "missing kid",
+		}}
+
+		for _, test := range tests {
+			claims := w.claims("nonce")
+			if test.claims != nil {
+				test.claims(claims)
+			}
+
+			kid := w.keyID
+
+			switch test.kid {
+			case "none":
+				kid = ""
+			case "":
+			default:
+				kid = test.kid
+			}
+
+			_, err := newRelyingParty().VerifyIDToken(w.idToken(t, claims, kid), "nonce")
+			require.Error(t, err, test.name)
+			require.True(t, errors.Is(err, siop.ErrInvalidIDToken), test.name)
+			require.Contains(t, err.Error(), test.err, test.name)
+		}
+	})
+
+	t.Run("rejects the id_tokens signed by another key", func(t *testing.T) {
+		w := newWallet(t)
+		other := newWallet(t)
+		w.privateKey = other.privateKey
+
+		_, err := newRelyingParty().VerifyIDToken(w.idToken(t, w.claims("nonce"), w.keyID), "nonce")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "signature doesn't match")
+	})
+
+	t.Run("rejects the id_tokens of an empty nonce", func(t *testing.T) {
+		w := newWallet(t)
+
+		_, err := newRelyingParty().VerifyIDToken(w.idToken(t, w.claims(""), w.keyID), "")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid nonce")
+	})
+
+	t.Run("rejects the malformed id_tokens", func(t *testing.T) {
+		_, err := newRelyingParty().VerifyIDToken("invalid", "nonce")
+		require.Error(t, err)
+		require.True(t, errors.Is(err, siop.ErrInvalidIDToken))
+	})
+
+	t.Run("error if the did cannot be resolved", func(t *testing.T) {
+		w := newWallet(t)
+
+		rp := siop.New(&siop.Config{ClientID: clientID, Resolver: siop.VDRResolver{}})
+
+		_, err := rp.VerifyIDToken(w.idToken(t, w.claims("nonce"), w.keyID), "nonce")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "did method key not supported")
+	})
+}
+
+func TestVDRResolver_Resolve(t *testing.T) {
+	w := newWallet(t)
+
+	doc, err := siop.VDRResolver{key.New()}.Resolve(w.did)
+	require.NoError(t, err)
+	require.Equal(t, w.did, doc.ID)
+
+	_, err = siop.VDRResolver{key.New()}.Resolve("invalid")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid did")
+
+	_, err = siop.VDRResolver{&mockVDR{}}.Resolve(w.did)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not supported")
+}
+
+func TestIsDID(t *testing.T) {
+	require.True(t, siop.IsDID("did:key:z6MkpTHR8VNsBxYAAWHut2Geadd9jSwuBV8xRoAnwWsdvktH"))
+	require.False(t, siop.IsDID("alice"))
+}
+
+func newRelyingParty() *siop.RelyingParty {
+	return siop.New(&siop.Config{
+		ClientID:   clientID,
+		DIDMethods: []string{"key"},
+		Resolver:   siop.VDRResolver{key.New()},
+	})
+}
+
+// wallet is the wallet of a user, with the ed25519 key of a did:key.
+type wallet struct {
+	did        string
+	keyID      string
+	privateKey ed25519.PrivateKey
+}
+
+func newWallet(t *testing.T) *wallet {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	didKey, keyID := fingerprint.CreateDIDKey(pub)
+
+	return &wallet{did: didKey, keyID: keyID, privateKey: priv}
+}
+
+func (w *wallet) claims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   w.did,
+		"sub":   w.did,
+		"aud":   clientID,
+		"nonce": nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func (w *wallet) idToken(t *testing.T, claims map[string]interface{}, kid string) string {
+	t.Helper()
+
+	headers := jose.Headers{}
+	if kid != "" {
+		headers[jose.HeaderKeyID] = kid
+	}
+
+	token, err := jwt.NewSigned(claims, headers, &signer{privateKey: w.privateKey})
+	require.NoError(t, err)
+
+	serialized, err := token.Serialize(false)
+	require.NoError(t, err)
+
+	return serialized
+}
+
+type signer struct {
+	privateKey ed25519.PrivateKey
+}
+
+func (s *signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.privateKey, data), nil
+}
+
+func (s *signer) Headers() jose.Headers {
+	return jose.Headers{jose.HeaderAlgorithm: "EdDSA"}
+}
+
+type mockVDR struct {
+	vdrapi.VDR
+}
+
+func (v *mockVDR) Accept(string) bool {
+	return false
+}
+
+func (v *mockVDR) Read(string, ...vdrapi.ResolveOpts) (*did.Doc, error) {
+	return nil, errors.New("test")
+}
@@ -0,0 +1,44 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common
+
+// Locations of the parameters of an operation.
+const (
+	InQuery  = "query"
+	InPath   = "path"
+	InHeader = "header"
+)
+
+// OperationSpec describes the parameters, request and responses of a handler, from which the OpenAPI document
+// of the REST API is generated.
+type OperationSpec struct {
+	Summary string
+	Params  []Param
+	// Request is a value of the type of the JSON body of the request, nil if the request has no body.
+	Request interface{}
+	// Responses are values of the types of the JSON bodies of the responses by status code, nil values standing
+	// for the responses without a JSON body such as redirects.
+	Responses map[int]interface{}
+}
+
+// Param is a string parameter of an operation.
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+}
+
+// QueryParam returns an optional query parameter.
+func QueryParam(name, description string) Param {
+	return Param{Name: name, In: InQuery, Description: description}
+}
+
+// HeaderParam returns an optional header parameter.
+func HeaderParam(name, description string) Param {
+	return Param{Name: name, In: InHeader, Description: description}
+}
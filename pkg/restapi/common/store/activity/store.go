@@ -0,0 +1,137 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package activity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// StoreName is the name of the activity store.
+	StoreName = "edgeagent_activity"
+	// DefaultCapacity is the number of activities kept per user by default.
+	DefaultCapacity = 500
+)
+
+// Activity is something that happened in the wallet of a user, e.g. a credential saved to their vault.
+type Activity struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Details  json.RawMessage `json:"details,omitempty"`
+	Occurred time.Time       `json:"occurred"`
+}
+
+// the activities of a user, from the most recent.
+type timeline struct {
+	Activities []*Activity `json:"activities"`
+}
+
+// NewStore returns a new activity Store keeping up to capacity activities per user, or DefaultCapacity if not
+// positive.
+func NewStore(p storage.Provider, capacity int) (*Store, error) {
+	s, err := store.Open(p, StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open activity store: %w", err)
+	}
+
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	return &Store{s: s, capacity: capacity}, nil
+}
+
+// Store keeps the timeline of each user in a single document, updated under a lock: the timeline of a user must
+// only be updated by a single Store.
+type Store struct {
+	s        storage.Store
+	capacity int
+	mux      sync.Mutex
+}
+
+// Add records the activity in the timeline of the user, in the order of occurrence. An activity already in the
+// timeline is ignored. Once the timeline is full, the oldest activity is dropped.
+func (s *Store) Add(sub string, a *Activity) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	t, err := s.get(sub)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range t.Activities {
+		if existing.ID == a.ID {
+			return nil
+		}
+	}
+
+	// the events may be dispatched out of order
+	i := sort.Search(len(t.Activities), func(i int) bool {
+		return !t.Activities[i].Occurred.After(a.Occurred)
+	})
+
+	t.Activities = append(t.Activities, nil)
+	copy(t.Activities[i+1:], t.Activities[i:])
+	t.Activities[i] = a
+
+	if len(t.Activities) > s.capacity {
+		t.Activities = t.Activities[:s.capacity]
+	}
+
+	return s.put(sub, t)
+}
+
+// List returns the activities of the user, from the most recent.
+func (s *Store) List(sub string) ([]*Activity, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	t, err := s.get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Activities, nil
+}
+
+func (s *Store) get(sub string) (*timeline, error) {
+	t := &timeline{}
+
+	raw, err := s.s.Get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return t, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch activities: %w", err)
+	}
+
+	err = json.Unmarshal(raw, t)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal activities: %w", err)
+	}
+
+	return t, nil
+}
+
+func (s *Store) put(sub string, t *timeline) error {
+	err := store.Save(s.s, sub, t)
+	if err != nil {
+		return fmt.Errorf("failed to save activities: %w", err)
+	}
+
+	return nil
+}
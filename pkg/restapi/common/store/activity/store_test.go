@@ -0,0 +1,120 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package activity_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/activity"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestStore(t *testing.T) {
+	t.Run("keeps the activities of each user from the most recent", func(t *testing.T) {
+		s := newStore(t, 0)
+		now := time.Now()
+
+		require.NoError(t, s.Add("alice", newActivity("1", now)))
+		require.NoError(t, s.Add("bob", newActivity("2", now)))
+		require.NoError(t, s.Add("alice", newActivity("3", now.Add(time.Second))))
+		// the bus may dispatch an event more than once, and out of order
+		require.NoError(t, s.Add("alice", newActivity("1", now)))
+		require.NoError(t, s.Add("alice", newActivity("4", now.Add(-time.Second))))
+
+		require.Equal(t, []string{"3", "1", "4"}, ids(t, s, "alice"))
+		require.Equal(t, []string{"2"}, ids(t, s, "bob"))
+		require.Empty(t, ids(t, s, "carol"))
+	})
+
+	t.Run("drops the oldest activity once full", func(t *testing.T) {
+		s := newStore(t, 2)
+		now := time.Now()
+
+		require.NoError(t, s.Add("alice", newActivity("1", now)))
+		require.NoError(t, s.Add("alice", newActivity("2", now.Add(time.Second))))
+		require.NoError(t, s.Add("alice", newActivity("3", now.Add(2*time.Second))))
+		require.Equal(t, []string{"3", "2"}, ids(t, s, "alice"))
+
+		require.NoError(t, s.Add("alice", newActivity("0", now.Add(-time.Second))))
+		require.Equal(t, []string{"3", "2"}, ids(t, s, "alice"))
+	})
+
+	t.Run("error if store cannot be opened", func(t *testing.T) {
+		_, err := activity.NewStore(&mockstore.Provider{ErrOpenStoreHandle: errors.New("test")}, 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open activity store")
+	})
+
+	t.Run("error if activities cannot be fetched", func(t *testing.T) {
+		s, err := activity.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{"alice": []byte(`{"activities":[]}`)},
+			ErrGet: errors.New("test"),
+		}}, 0)
+		require.NoError(t, err)
+
+		require.Error(t, s.Add("alice", newActivity("1", time.Now())))
+
+		_, err = s.List("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch activities")
+	})
+
+	t.Run("error if activities are corrupted", func(t *testing.T) {
+		s, err := activity.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{"alice": []byte("{")},
+		}}, 0)
+		require.NoError(t, err)
+
+		_, err = s.List("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal activities")
+	})
+
+	t.Run("error if activities cannot be saved", func(t *testing.T) {
+		s, err := activity.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  make(map[string][]byte),
+			ErrPut: errors.New("test"),
+		}}, 0)
+		require.NoError(t, err)
+
+		err = s.Add("alice", newActivity("1", time.Now()))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save activities")
+	})
+}
+
+func newStore(t *testing.T, capacity int) *activity.Store {
+	t.Helper()
+
+	s, err := activity.NewStore(memstore.NewProvider(), capacity)
+	require.NoError(t, err)
+
+	return s
+}
+
+func newActivity(id string, occurred time.Time) *activity.Activity {
+	return &activity.Activity{ID: id, Type: "credential.received", Occurred: occurred}
+}
+
+func ids(t *testing.T, s *activity.Store, sub string) []string {
+	t.Helper()
+
+	activities, err := s.List(sub)
+	require.NoError(t, err)
+
+	result := make([]string, 0, len(activities))
+
+	for _, a := range activities {
+		result = append(result, a.ID)
+	}
+
+	return result
+}
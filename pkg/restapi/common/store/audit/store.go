@@ -0,0 +1,355 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/lock"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// StoreName is the name of the audit store.
+	StoreName = "edgeagent_audit"
+	// the key of the last entry of the chain.
+	headKey = "head"
+	// the store of the lock of the chain shared by the instances of the agent.
+	lockStoreName = "edgeagent_audit_lock"
+	// how long an append waits for the lock of a shared chain.
+	lockTimeout = 10 * time.Second
+)
+
+// Actions.
+const (
+	ActionLogin               = "user.login"
+	ActionLogout              = "user.logout"
+	ActionTokensRefreshed     = "tokens.refreshed"
+	ActionTokensRevoked       = "tokens.revoked"
+	ActionOnboardingStarted   = "onboarding.started"
+	ActionOnboardingCompleted = "onboarding.completed"
+	ActionOnboardingFailed    = "onboarding.failed"
+	ActionAdmin               = "admin.request"
+	ActionDataDeleted         = "data.deleted"
+	ActionDataExported        = "data.exported"
+	ActionLoginFailed         = "login.failed"
+	ActionLoginLockout        = "login.lockout"
+	ActionLoginUnlocked       = "login.unlocked"
+	ActionNetworkDenied       = "network.denied"
+	ActionVaultRotated        = "vault.rotated"
+	ActionVaultRotationFailed = "vault.rotation_failed"
+	ActionSessionMismatch     = "session.mismatch"
+	ActionWalletBackedUp      = "wallet.backed_up"
+	ActionWalletRestored      = "wallet.restored"
+	ActionAccountDeleted      = "account.deleted"
+	ActionUserDeactivated     = "user.deactivated"
+	ActionUserActivated       = "user.activated"
+	ActionShareRecovered      = "share.recovered"
+	ActionShareReissued       = "share.reissued"
+)
+
+// ActorAdmin is the actor of the administrative requests.
+const ActorAdmin = "admin"
+
+// Entry is a security-relevant event. Entries are chained: the hash of an entry covers the hash of the
+// previous entry, so that altering, inserting or removing an entry breaks the chain.
+type Entry struct {
+	Seq      uint64          `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Action   string          `json:"action"`
+	User     string          `json:"user,omitempty"`
+	Actor    string          `json:"actor,omitempty"`
+	Details  json.RawMessage `json:"details,omitempty"`
+	PrevHash string          `json:"prevHash"`
+	Hash     string          `json:"hash"`
+}
+
+type head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// NewStore returns a new audit Store.
+func NewStore(p storage.Provider) (*Store, error) {
+	s, err := store.Open(p, StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit store: %w", err)
+	}
+
+	return &Store{s: s}, nil
+}
+
+// NewSharedStore returns a new audit Store whose chain is appended to by the other instances of the agent sharing
+// the storage provider, under a lock kept in the provider.
+func NewSharedStore(p storage.Provider, config *lock.Config) (*Store, error) {
+	s, err := NewStore(p)
+	if err != nil {
+		return nil, err
+	}
+
+	locks, err := store.Open(p, lockStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit lock store: %w", err)
+	}
+
+	s.lock = lock.New(locks, config)
+
+	return s, nil
+}
+
+// Store is an append-only log of Entries.
+//
+// Entries are appended under a lock, so a chain returned by NewStore must only be appended to by a single Store.
+type Store struct {
+	s   storage.Store
+	mux sync.Mutex
+	// locks the chain against the appends of the other instances, nil if the chain is not shared
+	lock *lock.Locker
+}
+
+// Record appends an entry for the action on the user's data, with 'details' marshalled as JSON.
+func (s *Store) Record(action, user, actor string, details interface{}) error {
+	e := &Entry{Action: action, User: user, Actor: actor}
+
+	if details != nil {
+		raw, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit details: %w", err)
+		}
+
+		e.Details = raw
+	}
+
+	return s.Append(e)
+}
+
+// Append chains the entry to the last entry of the log and persists it.
+func (s *Store) Append(e *Entry) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.lock != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+		defer cancel()
+
+		release, err := s.lock.Lock(ctx, headKey)
+		if err != nil {
+			return fmt.Errorf("failed to lock audit chain: %w", err)
+		}
+
+		defer release()
+	}
+
+	last, err := s.tip()
+	if err != nil {
+		return err
+	}
+
+	e.Seq = last.Seq + 1
+	e.Time = time.Now().UTC()
+	e.PrevHash = last.Hash
+
+	e.Hash, err = hash(e)
+	if err != nil {
+		return err
+	}
+
+	err = store.Save(s.s, key(e.Seq), e)
+	if err != nil {
+		return fmt.Errorf("failed to save audit entry: %w", err)
+	}
+
+	err = store.Save(s.s, headKey, &head{Seq: e.Seq, Hash: e.Hash})
+	if err != nil {
+		return fmt.Errorf("failed to save audit head: %w", err)
+	}
+
+	// the lock does not guarantee mutual exclusion, so that an append of another instance under way is detected
+	// rather than forking the chain unnoticed
+	written, err := s.head()
+	if err != nil {
+		return err
+	}
+
+	if written.Hash != e.Hash {
+		return fmt.Errorf("audit entry %d was appended concurrently by another instance", e.Seq)
+	}
+
+	return nil
+}
+
+// List returns the entries about the user in the order they were appended, or all entries if user is empty.
+func (s *Store) List(user string) ([]*Entry, error) {
+	entries, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+
+	if user == "" {
+		return entries, nil
+	}
+
+	filtered := make([]*Entry, 0, len(entries))
+
+	for _, e := range entries {
+		if e.User == user {
+			filtered = append(filtered, e)
+		}
+	}
+
+	return filtered, nil
+}
+
+// Verify walks the chain and returns the number of entries, or an error at the first entry that was tampered with.
+func (s *Store) Verify() (uint64, error) {
+	s.mux.Lock()
+	last, err := s.head()
+	s.mux.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := s.all()
+	if err != nil {
+		return 0, err
+	}
+
+	prev := ""
+
+	for i, e := range entries {
+		if e.Seq != uint64(i)+1 {
+			return 0, fmt.Errorf("audit entry %d is missing", i+1)
+		}
+
+		expected, err := hash(e)
+		if err != nil {
+			return 0, err
+		}
+
+		if e.PrevHash != prev || e.Hash != expected {
+			return 0, fmt.Errorf("audit entry %d was tampered with", e.Seq)
+		}
+
+		prev = e.Hash
+	}
+
+	// entries appended after the head was read are verified on the next walk
+	if uint64(len(entries)) < last.Seq {
+		return 0, fmt.Errorf("audit entry %d is missing", len(entries)+1)
+	}
+
+	return uint64(len(entries)), nil
+}
+
+func (s *Store) head() (*head, error) {
+	raw, err := s.s.Get(headKey)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return &head{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch audit head: %w", err)
+	}
+
+	h := &head{}
+
+	err = json.Unmarshal(raw, h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal audit head: %w", err)
+	}
+
+	return h, nil
+}
+
+// tip returns the last entry of the chain, past the head if an append was interrupted before saving the head.
+func (s *Store) tip() (*head, error) {
+	h, err := s.head()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		raw, err := s.s.Get(key(h.Seq + 1))
+		if errors.Is(err, storage.ErrValueNotFound) {
+			return h, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch audit entry: %w", err)
+		}
+
+		e := &Entry{}
+
+		err = json.Unmarshal(raw, e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit entry: %w", err)
+		}
+
+		if e.PrevHash != h.Hash {
+			return nil, fmt.Errorf("audit entry %d does not chain to the head", e.Seq)
+		}
+
+		h = &head{Seq: e.Seq, Hash: e.Hash}
+	}
+}
+
+func (s *Store) all() ([]*Entry, error) {
+	all, err := s.s.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+
+	entries := make([]*Entry, 0, len(all))
+
+	for k, raw := range all {
+		if k == headKey {
+			continue
+		}
+
+		e := &Entry{}
+
+		err = json.Unmarshal(raw, e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit entry: %w", err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+
+	return entries, nil
+}
+
+// hash is the SHA-256 digest of the entry without its hash.
+func hash(e *Entry) (string, error) {
+	unhashed := *e
+	unhashed.Hash = ""
+
+	raw, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	sum := sha256.Sum256(raw)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func key(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package audit_test
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/lock"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestStore(t *testing.T) {
+	t.Run("chains the entries in the order they were appended", func(t *testing.T) {
+		s, _ := newStore(t)
+
+		require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+		require.NoError(t, s.Record(audit.ActionLogin, "bob", "bob", nil))
+		require.NoError(t, s.Record(audit.ActionLogout, "alice", "alice", map[string]string{"reason": "test"}))
+
+		entries, err := s.List("")
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		require.Empty(t, entries[0].PrevHash)
+
+		for i, e := range entries {
+			require.Equal(t, uint64(i+1), e.Seq)
+			require.NotEmpty(t, e.Hash)
+			require.False(t, e.Time.IsZero())
+
+			if i > 0 {
+				require.Equal(t, entries[i-1].Hash, e.PrevHash)
+			}
+		}
+
+		require.JSONEq(t, `{"reason": "test"}`, string(entries[2].Details))
+
+		count, err := s.Verify()
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), count)
+	})
+
+	t.Run("lists the entries about a user", func(t *testing.T) {
+		s, _ := newStore(t)
+
+		require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+		require.NoError(t, s.Record(audit.ActionLogin, "bob", "bob", nil))
+		require.NoError(t, s.Record(audit.ActionLogout, "alice", "alice", nil))
+
+		entries, err := s.List("alice")
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, audit.ActionLogin, entries[0].Action)
+		require.Equal(t, audit.ActionLogout, entries[1].Action)
+	})
+
+	t.Run("detects altered entries", func(t *testing.T) {
+		s, raw := newStore(t)
+
+		require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+		require.NoError(t, s.Record(audit.ActionAdmin, "", audit.ActorAdmin, nil))
+
+		e := &audit.Entry{}
+		require.NoError(t, json.Unmarshal(get(t, raw, "00000000000000000001"), e))
+
+		e.User = "mallory"
+		require.NoError(t, raw.Put("00000000000000000001", marshal(t, e)))
+
+		_, err := s.Verify()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "audit entry 1 was tampered with")
+	})
+
+	t.Run("detects removed entries", func(t *testing.T) {
+		s, raw := newStore(t)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+		}
+
+		require.NoError(t, raw.Delete("00000000000000000002"))
+
+		_, err := s.Verify()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "audit entry 2 is missing")
+
+		s, raw = newStore(t)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+		}
+
+		require.NoError(t, raw.Delete("00000000000000000003"))
+
+		_, err = s.Verify()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "audit entry 3 is missing")
+	})
+
+	t.Run("the stores sharing a provider append to the same chain", func(t *testing.T) {
+		p := memstore.NewProvider()
+		stores := make([]*audit.Store, 2)
+
+		for i := range stores {
+			s, err := audit.NewSharedStore(p, &lock.Config{Interval: 10 * time.Millisecond})
+			require.NoError(t, err)
+
+			stores[i] = s
+		}
+
+		const appends = 5
+
+		errs := make(chan error, len(stores)*appends)
+
+		var wg sync.WaitGroup
+
+		for _, s := range stores {
+			wg.Add(1)
+
+			go func(s *audit.Store) {
+				defer wg.Done()
+
+				for i := 0; i < appends; i++ {
+					errs <- s.Record(audit.ActionLogin, "alice", "alice", nil)
+				}
+			}(s)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		count, err := stores[1].Verify()
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(stores)*appends), count)
+	})
+
+	t.Run("resumes an append interrupted before the head was saved", func(t *testing.T) {
+		s, raw := newStore(t)
+
+		require.NoError(t, s.Record(audit.ActionLogin, "alice", "alice", nil))
+		first := get(t, raw, "head")
+		require.NoError(t, s.Record(audit.ActionLogin, "bob", "bob", nil))
+		require.NoError(t, raw.Put("head", first))
+
+		require.NoError(t, s.Record(audit.ActionLogout, "alice", "alice", nil))
+
+		count, err := s.Verify()
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), count)
+	})
+
+	t.Run("error if the entry cannot be saved", func(t *testing.T) {
+		s, err := audit.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{},
+			ErrPut: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		err = s.Record(audit.ActionLogin, "alice", "alice", nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save audit entry")
+	})
+
+	t.Run("error if the store cannot be opened", func(t *testing.T) {
+		_, err := audit.NewStore(&mockstore.Provider{ErrCreateStore: errors.New("test")})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open audit store")
+	})
+
+	t.Run("error if the lock store cannot be opened", func(t *testing.T) {
+		_, err := audit.NewSharedStore(&mockstore.Provider{
+			Store:         &mockstore.MockStore{Store: map[string][]byte{}},
+			FailNameSpace: "edgeagent_audit_lock",
+		}, &lock.Config{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open audit lock store")
+	})
+}
+
+func newStore(t *testing.T) (*audit.Store, storage.Store) {
+	t.Helper()
+
+	p := memstore.NewProvider()
+
+	s, err := audit.NewStore(p)
+	require.NoError(t, err)
+
+	raw, err := p.OpenStore(audit.StoreName)
+	require.NoError(t, err)
+
+	return s, raw
+}
+
+func get(t *testing.T, s storage.Store, k string) []byte {
+	t.Helper()
+
+	v, err := s.Get(k)
+	require.NoError(t, err)
+
+	return v
+}
+
+func marshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	raw, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	return raw
+}
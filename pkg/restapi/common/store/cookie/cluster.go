@@ -0,0 +1,201 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	clusterStoreName = "edgeagent_cookie_keys"
+	clusterRecordKey = "leases"
+	defaultLeaseTTL  = 2 * time.Minute
+	// the leases are renewed three times per TTL, so that a renewal can fail without the lease expiring.
+	leaseRenewals = 3
+)
+
+var logger = log.New("edge-agent/cookie")
+
+// ErrKeyMismatch is returned when another running instance of the agent issues the session cookies under other
+// keys.
+var ErrKeyMismatch = errors.New("session cookie keys differ from the keys of another running instance of the agent")
+
+// ClusterConfig holds the configuration of a Cluster.
+type ClusterConfig struct {
+	// Storage is shared by the instances of the agent.
+	Storage storage.Provider
+	Ring    *KeyRing
+	// LeaseTTL is how long the keys of an instance are leased for after its last renewal, so that the instances
+	// restarted with new keys join the cluster once the instances under the previous keys are stopped. Defaults
+	// to 2m.
+	LeaseTTL time.Duration
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// Cluster checks that the instances of the agent sharing a storage provider issue and read the session cookies
+// under the same keys, so that a session opened on any instance is read by all of them without sticky sessions.
+// Each instance leases the fingerprint of its keys in the storage, and renews the lease while it runs.
+//
+// The storage has no compare-and-set: two instances under different keys joining at once may both join. The
+// renewals then report the mismatch.
+type Cluster struct {
+	store       storage.Store
+	fingerprint string
+	ttl         time.Duration
+	now         func() time.Time
+	mutex       sync.Mutex
+	once        sync.Once
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// leases of the fingerprints of the keys, by fingerprint, until their expiry.
+type leases map[string]time.Time
+
+// JoinCluster leases the keys of the ring. The error wraps ErrKeyMismatch if another running instance of the agent
+// leases other keys.
+func JoinCluster(config *ClusterConfig) (*Cluster, error) {
+	s, err := store.Open(config.Storage, clusterStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cookie keys store: %w", err)
+	}
+
+	c := &Cluster{
+		store:       s,
+		fingerprint: config.Ring.fingerprint(),
+		ttl:         config.LeaseTTL,
+		now:         config.Now,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	if c.ttl <= 0 {
+		c.ttl = defaultLeaseTTL
+	}
+
+	if c.now == nil {
+		c.now = time.Now
+	}
+
+	// an instance under mismatched keys does not lease them, so that it does not hold the others out
+	err = c.renew(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Start renewing the lease of the keys in the background.
+func (c *Cluster) Start() {
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.ttl / leaseRenewals)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				err := c.Renew()
+				if err != nil {
+					logger.Errorf("failed to renew the lease of the session cookie keys: %s", err.Error())
+				}
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop renewing the lease of the keys, which expires with its TTL.
+func (c *Cluster) Stop() {
+	c.once.Do(func() {
+		close(c.stop)
+		<-c.done
+	})
+}
+
+// Renew the lease of the keys. The error wraps ErrKeyMismatch if another running instance leases other keys, and
+// the lease is renewed either way.
+func (c *Cluster) Renew() error {
+	return c.renew(true)
+}
+
+func (c *Cluster) renew(leaseMismatched bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	current, err := c.leases()
+	if err != nil {
+		return err
+	}
+
+	now := c.now()
+	renewed := leases{c.fingerprint: now.Add(c.ttl)}
+
+	var mismatch bool
+
+	for fingerprint, expiry := range current {
+		if fingerprint != c.fingerprint && expiry.After(now) {
+			renewed[fingerprint] = expiry
+			mismatch = true
+		}
+	}
+
+	if mismatch && !leaseMismatched {
+		return ErrKeyMismatch
+	}
+
+	err = store.Save(c.store, clusterRecordKey, renewed)
+	if err != nil {
+		return fmt.Errorf("failed to save cookie keys lease: %w", err)
+	}
+
+	if mismatch {
+		return ErrKeyMismatch
+	}
+
+	return nil
+}
+
+func (c *Cluster) leases() (leases, error) {
+	bits, err := c.store.Get(clusterRecordKey)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return leases{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch cookie keys leases: %w", err)
+	}
+
+	current := leases{}
+
+	err = json.Unmarshal(bits, &current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cookie keys leases: %w", err)
+	}
+
+	return current, nil
+}
+
+// fingerprint identifies the latest keys of the ring without revealing them.
+func (k *KeyRing) fingerprint() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return k.fp
+}
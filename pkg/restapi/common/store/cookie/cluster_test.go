@@ -0,0 +1,183 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestJoinCluster(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	t.Run("joins the instances under the same keys", func(t *testing.T) {
+		provider := memstore.NewProvider()
+		authKey, encKey := key(t), key(t)
+
+		first, err := cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: provider,
+			Ring:    cookie.NewKeyRing(authKey, encKey),
+			Now:     clock,
+		})
+		require.NoError(t, err)
+
+		_, err = cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: provider,
+			Ring:    cookie.NewKeyRing(authKey, encKey),
+			Now:     clock,
+		})
+		require.NoError(t, err)
+		require.NoError(t, first.Renew())
+	})
+
+	t.Run("joins the instances sealing the cookies under the same cipher", func(t *testing.T) {
+		provider := memstore.NewProvider()
+		k := key(t)
+
+		for i := 0; i < 2; i++ {
+			cipher, err := keys.NewAESGCM(k)
+			require.NoError(t, err)
+
+			_, err = cookie.JoinCluster(&cookie.ClusterConfig{
+				Storage: provider,
+				Ring:    cookie.NewCipherKeyRing(cipher),
+				Now:     clock,
+			})
+			require.NoError(t, err)
+		}
+
+		_, err := cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: provider,
+			Ring:    cookie.NewKeyRing(k, key(t)),
+			Now:     clock,
+		})
+		require.True(t, errors.Is(err, cookie.ErrKeyMismatch))
+	})
+
+	t.Run("error if a running instance is under other keys", func(t *testing.T) {
+		provider := memstore.NewProvider()
+
+		first, err := cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: provider,
+			Ring:    cookie.NewKeyRing(key(t), key(t)),
+			Now:     clock,
+		})
+		require.NoError(t, err)
+
+		_, err = cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: provider,
+			Ring:    cookie.NewKeyRing(key(t), key(t)),
+			Now:     clock,
+		})
+		require.True(t, errors.Is(err, cookie.ErrKeyMismatch))
+
+		// the instance refused does not hold the running instance out
+		require.NoError(t, first.Renew())
+	})
+
+	t.Run("joins under new keys once the instances under the previous keys are stopped", func(t *testing.T) {
+		provider := memstore.NewProvider()
+
+		_, err := cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage:  provider,
+			Ring:     cookie.NewKeyRing(key(t), key(t)),
+			LeaseTTL: time.Minute,
+			Now:      clock,
+		})
+		require.NoError(t, err)
+
+		later := func() time.Time { return now.Add(time.Minute + time.Second) }
+
+		_, err = cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: provider,
+			Ring:    cookie.NewKeyRing(key(t), key(t)),
+			Now:     later,
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("reports the instances joined at once under other keys", func(t *testing.T) {
+		provider := memstore.NewProvider()
+
+		c, err := cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: provider,
+			Ring:    cookie.NewKeyRing(key(t), key(t)),
+			Now:     clock,
+		})
+		require.NoError(t, err)
+
+		// another instance joined under other keys before the lease was saved
+		s, err := provider.OpenStore("edgeagent_cookie_keys")
+		require.NoError(t, err)
+
+		bits, err := json.Marshal(map[string]time.Time{"other": now.Add(time.Hour)})
+		require.NoError(t, err)
+		require.NoError(t, s.Put("leases", bits))
+
+		require.True(t, errors.Is(c.Renew(), cookie.ErrKeyMismatch))
+		require.True(t, errors.Is(c.Renew(), cookie.ErrKeyMismatch))
+	})
+
+	t.Run("renews the lease in the background", func(t *testing.T) {
+		c, err := cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage:  memstore.NewProvider(),
+			Ring:     cookie.NewKeyRing(key(t), key(t)),
+			LeaseTTL: 3 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		c.Start()
+		time.Sleep(5 * time.Millisecond)
+		c.Stop()
+		c.Stop()
+	})
+
+	t.Run("error if the leases cannot be read or saved", func(t *testing.T) {
+		_, err := cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: &mockstore.Provider{ErrCreateStore: errors.New("test")},
+			Ring:    cookie.NewKeyRing(key(t), key(t)),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open cookie keys store")
+
+		_, err = cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: &mockstore.Provider{Store: &mockstore.MockStore{
+				Store:  map[string][]byte{"leases": []byte("{}")},
+				ErrGet: errors.New("test"),
+			}},
+			Ring: cookie.NewKeyRing(key(t), key(t)),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch cookie keys leases")
+
+		_, err = cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: &mockstore.Provider{Store: &mockstore.MockStore{Store: map[string][]byte{"leases": []byte("{")}}},
+			Ring:    cookie.NewKeyRing(key(t), key(t)),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal cookie keys leases")
+
+		_, err = cookie.JoinCluster(&cookie.ClusterConfig{
+			Storage: &mockstore.Provider{Store: &mockstore.MockStore{
+				Store:  map[string][]byte{},
+				ErrPut: errors.New("test"),
+			}},
+			Ring: cookie.NewKeyRing(key(t), key(t)),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save cookie keys lease")
+	})
+}
@@ -0,0 +1,78 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/securecookie"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+)
+
+// issuedAtSize is the size of the issuance time prefixing the sealed values.
+const issuedAtSize = 8
+
+// cipherCodec seals the values of the cookies with a cipher, which authenticates their names and issuance times.
+type cipherCodec struct {
+	cipher keys.Cipher
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+func newCipherCodec(cipher keys.Cipher) *cipherCodec {
+	return &cipherCodec{cipher: cipher, maxAge: storeMaxAge * time.Second, now: time.Now}
+}
+
+func (c *cipherCodec) Encode(name string, value interface{}) (string, error) {
+	bits, err := securecookie.GobEncoder{}.Serialize(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize cookie %s: %w", name, err)
+	}
+
+	plaintext := make([]byte, issuedAtSize, issuedAtSize+len(bits))
+	binary.BigEndian.PutUint64(plaintext, uint64(c.now().Unix()))
+
+	sealed, err := c.cipher.Seal(append(plaintext, bits...), []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to seal cookie %s: %w", name, err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *cipherCodec) Decode(name, value string, dst interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("failed to decode cookie %s: %w", name, err)
+	}
+
+	plaintext, err := c.cipher.Open(sealed, []byte(name))
+	if err != nil {
+		return fmt.Errorf("failed to open cookie %s: %w", name, err)
+	}
+
+	if len(plaintext) < issuedAtSize {
+		return fmt.Errorf("invalid cookie %s", name)
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint64(plaintext[:issuedAtSize])), 0)
+	if c.now().Sub(issued) > c.maxAge {
+		return errors.New("expired cookie " + name)
+	}
+
+	err = securecookie.GobEncoder{}.Deserialize(plaintext[issuedAtSize:], dst)
+	if err != nil {
+		return fmt.Errorf("failed to deserialize cookie %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+)
+
+const (
+	// the HMAC keys of the cookies are 32 or 64 bytes long
+	authKeyLen = 32
+	// issued and read back by Validate
+	validationValue = "validation"
+)
+
+// KeyRing holds the keys of the session cookies. Jars opened on the ring follow its rotations: cookies are
+// issued under the latest keys, and the cookies issued under the previous keys remain valid until they expire.
+type KeyRing struct {
+	mutex   sync.RWMutex
+	authKey []byte
+	encKey  []byte
+	// fp identifies the latest keys without revealing them
+	fp    string
+	codec securecookie.Codec
+	cs    *sessions.CookieStore
+}
+
+// NewKeyRing returns a KeyRing holding the keys.
+func NewKeyRing(authKey, encKey []byte) *KeyRing {
+	codec := newSecureCookie(authKey, encKey)
+
+	return &KeyRing{
+		authKey: authKey,
+		encKey:  encKey,
+		fp:      fingerprint(append(append([]byte{}, authKey...), encKey...)),
+		codec:   codec,
+		cs:      newCookieStore(codec),
+	}
+}
+
+// NewCipherKeyRing returns a KeyRing sealing the cookies with the cipher, e.g. of a key held in an HSM, in place
+// of keys held in memory.
+func NewCipherKeyRing(cipher keys.Cipher) *KeyRing {
+	codec := newCipherCodec(cipher)
+
+	return &KeyRing{
+		fp:    fingerprint([]byte("cipher\x00" + cipher.ID())),
+		codec: codec,
+		cs:    newCookieStore(codec),
+	}
+}
+
+// Rotate replaces the keys of the ring. It is a no-op if the keys have not changed.
+func (k *KeyRing) Rotate(authKey, encKey []byte) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if bytes.Equal(authKey, k.authKey) && bytes.Equal(encKey, k.encKey) {
+		return
+	}
+
+	codec := newSecureCookie(authKey, encKey)
+
+	k.cs = newCookieStore(codec, k.codec)
+	k.codec = codec
+	k.authKey = authKey
+	k.encKey = encKey
+	k.fp = fingerprint(append(append([]byte{}, authKey...), encKey...))
+}
+
+// Validate checks the lengths of the latest keys of the ring, and that the cookies issued under them are read back.
+func (k *KeyRing) Validate() error {
+	k.mutex.RLock()
+	authKey, encKey, codec := k.authKey, k.encKey, k.codec
+	k.mutex.RUnlock()
+
+	if _, sealed := codec.(*cipherCodec); !sealed {
+		if len(authKey) != authKeyLen && len(authKey) != 2*authKeyLen {
+			return fmt.Errorf("invalid cookie auth key: need %d or %d bytes but got %d",
+				authKeyLen, 2*authKeyLen, len(authKey))
+		}
+
+		_, err := aes.NewCipher(encKey)
+		if err != nil {
+			return fmt.Errorf("invalid cookie enc key: %w", err)
+		}
+	}
+
+	encoded, err := codec.Encode(StoreName, validationValue)
+	if err != nil {
+		return fmt.Errorf("failed to issue cookie: %w", err)
+	}
+
+	var decoded string
+
+	err = codec.Decode(StoreName, encoded, &decoded)
+	if err != nil {
+		return fmt.Errorf("failed to read back cookie: %w", err)
+	}
+
+	if decoded != validationValue {
+		return errors.New("failed to read back cookie: value changed")
+	}
+
+	return nil
+}
+
+func (k *KeyRing) store() *sessions.CookieStore {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	return k.cs
+}
+
+func newSecureCookie(authKey, encKey []byte) *securecookie.SecureCookie {
+	codec := securecookie.New(authKey, encKey)
+	codec.MaxAge(storeMaxAge)
+
+	return codec
+}
+
+func newCookieStore(codecs ...securecookie.Codec) *sessions.CookieStore {
+	return &sessions.CookieStore{
+		Codecs:  codecs,
+		Options: &sessions.Options{Path: "/", MaxAge: storeMaxAge},
+	}
+}
+
+func fingerprint(material []byte) string {
+	sum := sha256.Sum256(append([]byte("edge-agent cookie keys\x00"), material...))
+
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,164 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie_test
+
+import (
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestKeyRing(t *testing.T) {
+	authKey, encKey := key(t), key(t)
+	ring := cookie.NewKeyRing(authKey, encKey)
+	jars := cookie.NewStore(nil, nil, cookie.WithKeyRing(ring))
+
+	issued := issue(t, jars, "before")
+
+	ring.Rotate(key(t), key(t))
+
+	t.Run("reads the cookies issued under the previous keys", func(t *testing.T) {
+		require.Equal(t, "before", read(t, jars, issued))
+	})
+
+	t.Run("issues the cookies under the latest keys", func(t *testing.T) {
+		rotated := issue(t, jars, "after")
+		require.Equal(t, "after", read(t, jars, rotated))
+
+		_, err := cookie.NewStore(authKey, encKey).Open(request(rotated))
+		require.Error(t, err)
+	})
+
+	t.Run("forgets the keys after two rotations", func(t *testing.T) {
+		ring.Rotate(key(t), key(t))
+
+		_, err := jars.Open(request(issued))
+		require.Error(t, err)
+	})
+}
+
+func TestNewCipherKeyRing(t *testing.T) {
+	cipher, err := keys.NewAESGCM(key(t))
+	require.NoError(t, err)
+
+	ring := cookie.NewCipherKeyRing(cipher)
+	jars := cookie.NewStore(nil, nil, cookie.WithKeyRing(ring))
+
+	issued := issue(t, jars, "sealed")
+
+	t.Run("seals the cookies with the cipher", func(t *testing.T) {
+		require.Equal(t, "sealed", read(t, jars, issued))
+
+		other, err := keys.NewAESGCM(key(t))
+		require.NoError(t, err)
+
+		_, err = cookie.NewStore(nil, nil, cookie.WithKeyRing(cookie.NewCipherKeyRing(other))).Open(request(issued))
+		require.Error(t, err)
+	})
+
+	t.Run("error if the cookie is tampered with", func(t *testing.T) {
+		tampered := *issued
+		tampered.Value = "A" + issued.Value[1:]
+
+		if tampered.Value == issued.Value {
+			tampered.Value = "B" + issued.Value[1:]
+		}
+
+		_, err := jars.Open(request(&tampered))
+		require.Error(t, err)
+	})
+
+	t.Run("reads the sealed cookies once rotated to keys held in memory", func(t *testing.T) {
+		ring.Rotate(key(t), key(t))
+		require.Equal(t, "sealed", read(t, jars, issued))
+		require.Equal(t, "rotated", read(t, jars, issue(t, jars, "rotated")))
+	})
+}
+
+func TestKeyRing_Validate(t *testing.T) {
+	t.Run("valid keys", func(t *testing.T) {
+		require.NoError(t, cookie.NewKeyRing(key(t), key(t)).Validate())
+		require.NoError(t, cookie.NewKeyRing(append(key(t), key(t)...), key(t)[:16]).Validate())
+
+		cipher, err := keys.NewAESGCM(key(t))
+		require.NoError(t, err)
+		require.NoError(t, cookie.NewCipherKeyRing(cipher).Validate())
+	})
+
+	t.Run("error if the auth key is too short", func(t *testing.T) {
+		err := cookie.NewKeyRing(key(t)[:16], key(t)).Validate()
+		require.EqualError(t, err, "invalid cookie auth key: need 32 or 64 bytes but got 16")
+	})
+
+	t.Run("error if the enc key is invalid", func(t *testing.T) {
+		err := cookie.NewKeyRing(key(t), key(t)[:20]).Validate()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid cookie enc key")
+	})
+
+	t.Run("validates the latest keys", func(t *testing.T) {
+		ring := cookie.NewKeyRing(key(t), key(t))
+		ring.Rotate(key(t), nil)
+		require.Error(t, ring.Validate())
+	})
+}
+
+func issue(t *testing.T, jars *cookie.Jars, value string) *http.Cookie {
+	t.Helper()
+
+	jar, err := jars.Open(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+
+	jar.Set("k", value)
+
+	w := httptest.NewRecorder()
+	require.NoError(t, jar.Save(httptest.NewRequest(http.MethodGet, "/", nil), w))
+
+	cookies := w.Result().Cookies() // nolint:bodyclose // recorded response
+	require.Len(t, cookies, 1)
+
+	return cookies[0]
+}
+
+func read(t *testing.T, jars *cookie.Jars, c *http.Cookie) string {
+	t.Helper()
+
+	jar, err := jars.Open(request(c))
+	require.NoError(t, err)
+
+	value, found := jar.Get("k")
+	require.True(t, found)
+
+	s, ok := value.(string)
+	require.True(t, ok)
+
+	return s
+}
+
+func request(c *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(c)
+
+	return r
+}
+
+func key(t *testing.T) []byte {
+	t.Helper()
+
+	k := make([]byte, 32)
+
+	_, err := rand.Read(k)
+	require.NoError(t, err)
+
+	return k
+}
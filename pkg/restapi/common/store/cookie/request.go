@@ -0,0 +1,52 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie
+
+import (
+	"context"
+	"net/http"
+)
+
+type jarKey struct{}
+
+// WithJar returns a copy of ctx carrying the jar. Jars open the jar of a request carrying one in place of its
+// session cookie, so that requests authenticated by other means are served like those of a browser session.
+func WithJar(ctx context.Context, jar Jar) context.Context {
+	return context.WithValue(ctx, jarKey{}, jar)
+}
+
+// NewRequestJar returns a Jar holding the cookies for the duration of a request.
+func NewRequestJar(cookies map[interface{}]interface{}) *RequestJar {
+	return &RequestJar{cookies: cookies}
+}
+
+// RequestJar is a Jar that lives as long as its request: it is never sent back to the client.
+type RequestJar struct {
+	cookies map[interface{}]interface{}
+}
+
+// Set the cookie.
+func (j *RequestJar) Set(k, v interface{}) {
+	j.cookies[k] = v
+}
+
+// Get the cookie.
+func (j *RequestJar) Get(k interface{}) (interface{}, bool) {
+	v, found := j.cookies[k]
+
+	return v, found
+}
+
+// Delete the cookie.
+func (j *RequestJar) Delete(k interface{}) {
+	delete(j.cookies, k)
+}
+
+// Save does nothing: the cookies of the jar are not kept between requests.
+func (j *RequestJar) Save(*http.Request, http.ResponseWriter) error {
+	return nil
+}
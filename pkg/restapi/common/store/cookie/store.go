@@ -20,22 +20,45 @@ const (
 	storeMaxAge = 900 // 15 mins
 )
 
+// Option configures a CookieStore.
+type Option func(*Jars)
+
+// WithKeyRing makes the store follow the rotations of the ring, in place of its static keys. A nil ring is ignored.
+func WithKeyRing(ring *KeyRing) Option {
+	return func(cs *Jars) {
+		if ring != nil {
+			cs.ring = ring
+		}
+	}
+}
+
 // NewStore returns a new CookieStore.
-func NewStore(authKey, encKey []byte) *Jars {
-	cs := sessions.NewCookieStore(authKey, encKey)
-	cs.MaxAge(storeMaxAge)
+func NewStore(authKey, encKey []byte, opts ...Option) *Jars {
+	cs := &Jars{}
+
+	for _, opt := range opts {
+		opt(cs)
+	}
+
+	if cs.ring == nil {
+		cs.ring = NewKeyRing(authKey, encKey)
+	}
 
-	return &Jars{cs: cs}
+	return cs
 }
 
 // Jars is a collection of cookie Jars.
 type Jars struct {
-	cs *sessions.CookieStore
+	ring *KeyRing
 }
 
-// Open the Jar.
+// Open the Jar of the request: the one in its context, if any, otherwise its session cookies.
 func (cs *Jars) Open(r *http.Request) (Jar, error) {
-	s, err := cs.cs.Get(r, StoreName)
+	if jar, ok := r.Context().Value(jarKey{}).(Jar); ok {
+		return jar, nil
+	}
+
+	s, err := cs.ring.store().Get(r, StoreName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch session cookies %s: %w", StoreName, err)
 	}
@@ -43,6 +66,15 @@ func (cs *Jars) Open(r *http.Request) (Jar, error) {
 	return &Session{s: s}, nil
 }
 
+// Reset returns an empty Jar in place of the session cookies of the request, e.g. those that expired. The session
+// cookies are replaced once the Jar is saved.
+func (cs *Jars) Reset() *Session {
+	s := sessions.NewSession(cs.ring.store(), StoreName)
+	s.IsNew = true
+
+	return &Session{s: s}
+}
+
 // Session is a Jar holding cookies.
 type Session struct {
 	s *sessions.Session
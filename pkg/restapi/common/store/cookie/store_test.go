@@ -0,0 +1,57 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cookie_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestJars_Reset(t *testing.T) {
+	t.Run("replaces the session cookies that cannot be read", func(t *testing.T) {
+		jars := cookie.NewStore(key(t), key(t))
+		stale := issue(t, cookie.NewStore(key(t), key(t)), "stale")
+
+		_, err := jars.Open(request(stale))
+		require.Error(t, err)
+
+		jar := jars.Reset()
+		_, found := jar.Get("k")
+		require.False(t, found)
+
+		jar.Set("k", "fresh")
+
+		w := httptest.NewRecorder()
+		require.NoError(t, jar.Save(request(stale), w))
+
+		cookies := w.Result().Cookies() // nolint:bodyclose // recorded response
+		require.Len(t, cookies, 1)
+		require.Equal(t, cookie.StoreName, cookies[0].Name)
+		require.Equal(t, "fresh", read(t, jars, cookies[0]))
+	})
+
+	t.Run("follows the rotations of the key ring", func(t *testing.T) {
+		ring := cookie.NewKeyRing(key(t), key(t))
+		jars := cookie.NewStore(nil, nil, cookie.WithKeyRing(ring))
+
+		ring.Rotate(key(t), key(t))
+
+		jar := jars.Reset()
+		jar.Set("k", "rotated")
+
+		w := httptest.NewRecorder()
+		require.NoError(t, jar.Save(httptest.NewRequest(http.MethodGet, "/", nil), w))
+
+		cookies := w.Result().Cookies() // nolint:bodyclose // recorded response
+		require.Len(t, cookies, 1)
+		require.Equal(t, "rotated", read(t, jars, cookies[0]))
+	})
+}
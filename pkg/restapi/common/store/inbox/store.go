@@ -0,0 +1,192 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package inbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// StoreName is the name of the inbox store.
+	StoreName = "edgeagent_inbox"
+	// DefaultCapacity is the number of messages kept per user by default.
+	DefaultCapacity = 100
+)
+
+// Message is a message received for a user, kept until the user deletes it.
+type Message struct {
+	ID           string          `json:"id"`
+	Topic        string          `json:"topic"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+	Received     time.Time       `json:"received"`
+	Acknowledged bool            `json:"acknowledged"`
+}
+
+// the messages of a user, in the order they were received.
+type inbox struct {
+	Messages []*Message `json:"messages"`
+}
+
+// NewStore returns a new inbox Store keeping up to capacity messages per user, or DefaultCapacity if not positive.
+func NewStore(p storage.Provider, capacity int) (*Store, error) {
+	s, err := store.Open(p, StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open inbox store: %w", err)
+	}
+
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	return &Store{s: s, capacity: capacity}, nil
+}
+
+// Store keeps the messages of each user in a single document, updated under a lock: the inbox of a user must only
+// be updated by a single Store.
+type Store struct {
+	s        storage.Store
+	capacity int
+	mux      sync.Mutex
+}
+
+// Add appends the message to the inbox of the user. A message already in the inbox is ignored. Once the inbox is
+// full, the oldest acknowledged message is dropped, or the oldest message if none was acknowledged.
+func (s *Store) Add(sub string, m *Message) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	box, err := s.get(sub)
+	if err != nil {
+		return err
+	}
+
+	if box.index(m.ID) >= 0 {
+		return nil
+	}
+
+	box.Messages = append(box.Messages, m)
+
+	if len(box.Messages) > s.capacity {
+		box.drop(box.oldest())
+	}
+
+	return s.put(sub, box)
+}
+
+// List returns the messages of the user in the order they were received.
+func (s *Store) List(sub string) ([]*Message, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	box, err := s.get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return box.Messages, nil
+}
+
+// Acknowledge marks the messages of the user as seen. The ids of messages that are not in the inbox are ignored.
+func (s *Store) Acknowledge(sub string, ids ...string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	box, err := s.get(sub)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if i := box.index(id); i >= 0 {
+			box.Messages[i].Acknowledged = true
+		}
+	}
+
+	return s.put(sub, box)
+}
+
+// Delete removes the message from the inbox of the user. It returns storage.ErrValueNotFound if the message is
+// not in the inbox.
+func (s *Store) Delete(sub, id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	box, err := s.get(sub)
+	if err != nil {
+		return err
+	}
+
+	i := box.index(id)
+	if i < 0 {
+		return fmt.Errorf("message %s: %w", id, storage.ErrValueNotFound)
+	}
+
+	box.drop(i)
+
+	return s.put(sub, box)
+}
+
+func (s *Store) get(sub string) (*inbox, error) {
+	box := &inbox{}
+
+	raw, err := s.s.Get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return box, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch inbox: %w", err)
+	}
+
+	err = json.Unmarshal(raw, box)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal inbox: %w", err)
+	}
+
+	return box, nil
+}
+
+func (s *Store) put(sub string, box *inbox) error {
+	err := store.Save(s.s, sub, box)
+	if err != nil {
+		return fmt.Errorf("failed to save inbox: %w", err)
+	}
+
+	return nil
+}
+
+func (b *inbox) index(id string) int {
+	for i, m := range b.Messages {
+		if m.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// oldest returns the index of the oldest acknowledged message, or of the oldest message if none was acknowledged.
+func (b *inbox) oldest() int {
+	for i, m := range b.Messages {
+		if m.Acknowledged {
+			return i
+		}
+	}
+
+	return 0
+}
+
+func (b *inbox) drop(i int) {
+	b.Messages = append(b.Messages[:i], b.Messages[i+1:]...)
+}
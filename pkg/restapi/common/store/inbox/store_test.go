@@ -0,0 +1,150 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package inbox_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/inbox"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestStore(t *testing.T) {
+	t.Run("keeps the messages of each user in the order they were received", func(t *testing.T) {
+		s := newStore(t, 0)
+
+		require.NoError(t, s.Add("alice", message("1")))
+		require.NoError(t, s.Add("bob", message("2")))
+		require.NoError(t, s.Add("alice", message("3")))
+		// the bus may dispatch an event more than once
+		require.NoError(t, s.Add("alice", message("1")))
+
+		require.Equal(t, []string{"1", "3"}, ids(t, s, "alice"))
+		require.Equal(t, []string{"2"}, ids(t, s, "bob"))
+		require.Empty(t, ids(t, s, "carol"))
+	})
+
+	t.Run("acknowledges messages", func(t *testing.T) {
+		s := newStore(t, 0)
+
+		require.NoError(t, s.Add("alice", message("1")))
+		require.NoError(t, s.Add("alice", message("2")))
+		require.NoError(t, s.Acknowledge("alice", "2", "unknown"))
+
+		messages, err := s.List("alice")
+		require.NoError(t, err)
+		require.False(t, messages[0].Acknowledged)
+		require.True(t, messages[1].Acknowledged)
+	})
+
+	t.Run("deletes messages", func(t *testing.T) {
+		s := newStore(t, 0)
+
+		require.NoError(t, s.Add("alice", message("1")))
+		require.NoError(t, s.Add("alice", message("2")))
+		require.NoError(t, s.Delete("alice", "1"))
+		require.Equal(t, []string{"2"}, ids(t, s, "alice"))
+
+		err := s.Delete("alice", "1")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		err = s.Delete("bob", "2")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("drops the oldest acknowledged message once full", func(t *testing.T) {
+		s := newStore(t, 3)
+
+		require.NoError(t, s.Add("alice", message("1")))
+		require.NoError(t, s.Add("alice", message("2")))
+		require.NoError(t, s.Add("alice", message("3")))
+		require.NoError(t, s.Acknowledge("alice", "2"))
+		require.NoError(t, s.Add("alice", message("4")))
+		require.Equal(t, []string{"1", "3", "4"}, ids(t, s, "alice"))
+
+		require.NoError(t, s.Add("alice", message("5")))
+		require.Equal(t, []string{"3", "4", "5"}, ids(t, s, "alice"))
+	})
+
+	t.Run("error if store cannot be opened", func(t *testing.T) {
+		_, err := inbox.NewStore(&mockstore.Provider{ErrOpenStoreHandle: errors.New("test")}, 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open inbox store")
+	})
+
+	t.Run("error if inbox cannot be fetched", func(t *testing.T) {
+		s, err := inbox.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{"alice": []byte(`{"messages":[]}`)},
+			ErrGet: errors.New("test"),
+		}}, 0)
+		require.NoError(t, err)
+
+		require.Error(t, s.Add("alice", message("1")))
+		require.Error(t, s.Acknowledge("alice", "1"))
+		require.Error(t, s.Delete("alice", "1"))
+
+		_, err = s.List("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch inbox")
+	})
+
+	t.Run("error if inbox is corrupted", func(t *testing.T) {
+		s, err := inbox.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{"alice": []byte("{")},
+		}}, 0)
+		require.NoError(t, err)
+
+		_, err = s.List("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal inbox")
+	})
+
+	t.Run("error if inbox cannot be saved", func(t *testing.T) {
+		s, err := inbox.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  make(map[string][]byte),
+			ErrPut: errors.New("test"),
+		}}, 0)
+		require.NoError(t, err)
+
+		err = s.Add("alice", message("1"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save inbox")
+	})
+}
+
+func newStore(t *testing.T, capacity int) *inbox.Store {
+	t.Helper()
+
+	s, err := inbox.NewStore(memstore.NewProvider(), capacity)
+	require.NoError(t, err)
+
+	return s
+}
+
+func message(id string) *inbox.Message {
+	return &inbox.Message{ID: id, Topic: "didcomm.message", Received: time.Now()}
+}
+
+func ids(t *testing.T, s *inbox.Store, sub string) []string {
+	t.Helper()
+
+	messages, err := s.List(sub)
+	require.NoError(t, err)
+
+	result := make([]string, 0, len(messages))
+
+	for _, m := range messages {
+		result = append(result, m.ID)
+	}
+
+	return result
+}
@@ -0,0 +1,214 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	keyPrefix       = "lock_"
+	defaultTTL      = 5 * time.Minute
+	defaultInterval = 100 * time.Millisecond
+)
+
+var logger = log.New("edge-agent/lock")
+
+// Config of the locks.
+type Config struct {
+	// TTL is how long a lock is held for at most, by an instance that crashed while holding it. Defaults to 5m.
+	TTL time.Duration
+	// Interval between the checks of a lock held by another instance. A lock written to the store is read back
+	// after the interval, to detect the concurrent acquisitions of the other instances. Defaults to 100ms.
+	Interval time.Duration
+}
+
+// Locker hands out the exclusive locks of keys, shared with the other instances of the agent through a store.
+//
+// The store has no compare-and-set: two instances writing the lock of a key at once both read it back after the
+// interval, and only the instance that wrote it last holds it. An instance writing the lock later than the
+// interval after the other read it free holds it too, so the locks guard against the duplicate work of concurrent
+// requests rather than guarantee mutual exclusion.
+type Locker struct {
+	store    storage.Store
+	ttl      time.Duration
+	interval time.Duration
+	// the keys locked by this instance, whose channel is closed on release
+	mutex sync.Mutex
+	held  map[string]chan struct{}
+}
+
+// record of a lock in the store, whose expiry is shared with the other transient records.
+type record struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// New returns a Locker keeping the locks in the store.
+func New(s storage.Store, config *Config) *Locker {
+	l := &Locker{
+		store:    s,
+		ttl:      config.TTL,
+		interval: config.Interval,
+		held:     make(map[string]chan struct{}),
+	}
+
+	if l.ttl <= 0 {
+		l.ttl = defaultTTL
+	}
+
+	if l.interval <= 0 {
+		l.interval = defaultInterval
+	}
+
+	return l
+}
+
+// Lock acquires the lock of the key, waiting until it is released or expires, or until the context is done. The
+// lock is released by the function returned.
+func (l *Locker) Lock(ctx context.Context, key string) (func(), error) {
+	err := l.lockLocal(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("wait for the lock of %s: %w", key, err)
+	}
+
+	owner := uuid.New().String()
+
+	for {
+		acquired, err := l.tryLock(ctx, key, owner)
+		if err != nil {
+			l.unlockLocal(key)
+
+			return nil, fmt.Errorf("acquire the lock of %s: %w", key, err)
+		}
+
+		if acquired {
+			return func() { l.unlock(key, owner) }, nil
+		}
+
+		select {
+		case <-time.After(l.interval):
+		case <-ctx.Done():
+			l.unlockLocal(key)
+
+			return nil, fmt.Errorf("wait for the lock of %s: %w", key, ctx.Err())
+		}
+	}
+}
+
+// lockLocal serializes the goroutines of this instance locking the key, for them not to race in the store.
+func (l *Locker) lockLocal(ctx context.Context, key string) error {
+	for {
+		l.mutex.Lock()
+
+		released, held := l.held[key]
+		if !held {
+			l.held[key] = make(chan struct{})
+			l.mutex.Unlock()
+
+			return nil
+		}
+
+		l.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *Locker) unlockLocal(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	close(l.held[key])
+	delete(l.held, key)
+}
+
+// tryLock writes the lock of the key unless another instance holds it, and reports whether the lock is held by
+// the owner once read back. The lock written is released if the context is done before it is read back.
+func (l *Locker) tryLock(ctx context.Context, key, owner string) (bool, error) {
+	current, err := l.get(key)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return false, err
+	}
+
+	if err == nil && current.Expires.After(time.Now()) {
+		return false, nil
+	}
+
+	err = store.Save(l.store, keyPrefix+key, &record{Owner: owner, Expires: time.Now().Add(l.ttl)})
+	if err != nil {
+		return false, fmt.Errorf("failed to save lock: %w", err)
+	}
+
+	select {
+	case <-time.After(l.interval):
+	case <-ctx.Done():
+		l.release(key, owner)
+
+		return false, ctx.Err()
+	}
+
+	current, err = l.get(key)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return current.Owner == owner, nil
+}
+
+// unlock deletes the lock of the key unless it expired and another instance acquired it since.
+func (l *Locker) unlock(key, owner string) {
+	defer l.unlockLocal(key)
+
+	l.release(key, owner)
+}
+
+// release deletes the lock of the key in the store if the owner holds it.
+func (l *Locker) release(key, owner string) {
+	current, err := l.get(key)
+	if err == nil && current.Owner == owner {
+		err = l.store.Delete(keyPrefix + key)
+	}
+
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		logger.Warnf("failed to release the lock of %s, held until it expires: %s", key, err.Error())
+	}
+}
+
+func (l *Locker) get(key string) (*record, error) {
+	bits, err := l.store.Get(keyPrefix + key)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &record{}
+
+	err = json.Unmarshal(bits, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lock: %w", err)
+	}
+
+	return r, nil
+}
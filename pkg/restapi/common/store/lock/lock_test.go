@@ -0,0 +1,155 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package lock_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/lock"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestLocker(t *testing.T) {
+	t.Run("serializes the holders of a key across instances", func(t *testing.T) {
+		s := newStore(t)
+		instances := []*lock.Locker{
+			lock.New(s, &lock.Config{Interval: time.Millisecond}),
+			lock.New(s, &lock.Config{Interval: time.Millisecond}),
+		}
+
+		var (
+			wg      sync.WaitGroup
+			holders int32
+			maxHeld int32
+		)
+
+		for i := 0; i < 6; i++ {
+			l := instances[i%2]
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				release, err := l.Lock(context.Background(), "user")
+				require.NoError(t, err)
+
+				held := atomic.AddInt32(&holders, 1)
+				if held > atomic.LoadInt32(&maxHeld) {
+					atomic.StoreInt32(&maxHeld, held)
+				}
+
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&holders, -1)
+				release()
+			}()
+		}
+
+		wg.Wait()
+		require.Equal(t, int32(1), atomic.LoadInt32(&maxHeld))
+
+		_, err := s.Get("lock_user")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("locks the keys independently", func(t *testing.T) {
+		l := lock.New(newStore(t), &lock.Config{Interval: time.Millisecond})
+
+		release, err := l.Lock(context.Background(), "a")
+		require.NoError(t, err)
+		defer release()
+
+		other, err := l.Lock(context.Background(), "b")
+		require.NoError(t, err)
+		other()
+	})
+
+	t.Run("takes over the lock of a crashed instance once it expires", func(t *testing.T) {
+		s := newStore(t)
+
+		_, err := lock.New(s, &lock.Config{TTL: 10 * time.Millisecond, Interval: time.Millisecond}).
+			Lock(context.Background(), "user")
+		require.NoError(t, err)
+
+		release, err := lock.New(s, &lock.Config{Interval: time.Millisecond}).Lock(context.Background(), "user")
+		require.NoError(t, err)
+		release()
+	})
+
+	t.Run("gives up waiting when the context is done", func(t *testing.T) {
+		s := newStore(t)
+		l := lock.New(s, &lock.Config{Interval: time.Millisecond})
+
+		release, err := l.Lock(context.Background(), "user")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err = l.Lock(ctx, "user")
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+		_, err = lock.New(s, &lock.Config{Interval: time.Millisecond}).Lock(ctx, "user")
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+		release()
+
+		release, err = l.Lock(context.Background(), "user")
+		require.NoError(t, err)
+		release()
+	})
+
+	t.Run("gives up reading back the lock when the context is done", func(t *testing.T) {
+		s := newStore(t)
+		l := lock.New(s, &lock.Config{Interval: time.Hour})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+
+		_, err := l.Lock(ctx, "user")
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Less(t, int64(time.Since(start)), int64(time.Second))
+
+		// the lock written is released
+		_, err = s.Get("lock_user")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if the store fails", func(t *testing.T) {
+		l := lock.New(&mockstore.MockStore{Store: map[string][]byte{}, ErrPut: errors.New("test")}, &lock.Config{})
+
+		_, err := l.Lock(context.Background(), "user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save lock")
+
+		l = lock.New(&mockstore.MockStore{Store: map[string][]byte{}, ErrGet: errors.New("test")}, &lock.Config{})
+
+		_, err = l.Lock(context.Background(), "user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "acquire the lock of user")
+	})
+}
+
+func newStore(t *testing.T) storage.Store {
+	t.Helper()
+
+	s, err := store.Open(memstore.NewProvider(), "locks")
+	require.NoError(t, err)
+
+	return store.Locked(s)
+}
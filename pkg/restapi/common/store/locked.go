@@ -0,0 +1,78 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package store
+
+import (
+	"sync"
+
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Locked serializes the accesses to the store, for stores such as the memstore whose GetAll returns their
+// live map. GetAll returns a copy of the key-value pairs, so that they can be iterated over while the store
+// is written to.
+func Locked(s storage.Store) storage.Store {
+	return &lockedStore{s: s}
+}
+
+type lockedStore struct {
+	s     storage.Store
+	mutex sync.RWMutex
+}
+
+func (l *lockedStore) Put(k string, v []byte) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.s.Put(k, v)
+}
+
+func (l *lockedStore) GetAll() (map[string][]byte, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	all, err := l.s.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	copied := make(map[string][]byte, len(all))
+
+	for k, v := range all {
+		copied[k] = v
+	}
+
+	return copied, nil
+}
+
+func (l *lockedStore) Get(k string) ([]byte, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.s.Get(k)
+}
+
+func (l *lockedStore) CreateIndex(createIndexRequest storage.CreateIndexRequest) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.s.CreateIndex(createIndexRequest)
+}
+
+func (l *lockedStore) Query(query string) (storage.ResultsIterator, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.s.Query(query)
+}
+
+func (l *lockedStore) Delete(k string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.s.Delete(k)
+}
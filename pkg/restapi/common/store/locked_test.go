@@ -0,0 +1,77 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package store_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestLocked(t *testing.T) {
+	t.Run("lists the store while it is written to", func(t *testing.T) {
+		s, err := store.Open(memstore.NewProvider(), "test")
+		require.NoError(t, err)
+
+		locked := store.Locked(s)
+
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < 100; i++ {
+				require.NoError(t, locked.Put(fmt.Sprintf("key%d", i), []byte("value")))
+			}
+		}()
+
+		for i := 0; i < 100; i++ {
+			all, err := locked.GetAll()
+			require.NoError(t, err)
+
+			for k := range all {
+				require.NotEmpty(t, k)
+			}
+		}
+
+		wg.Wait()
+
+		all, err := locked.GetAll()
+		require.NoError(t, err)
+		require.Len(t, all, 100)
+
+		require.NoError(t, locked.Delete("key0"))
+
+		_, err = locked.Get("key0")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		value, err := locked.Get("key1")
+		require.NoError(t, err)
+		require.Equal(t, "value", string(value))
+
+		require.Error(t, locked.CreateIndex(storage.CreateIndexRequest{}))
+
+		_, err = locked.Query("")
+		require.Error(t, err)
+	})
+
+	t.Run("error if the store cannot be listed", func(t *testing.T) {
+		locked := store.Locked(&mockstore.MockStore{Store: map[string][]byte{}, ErrGetAll: errors.New("test")})
+
+		_, err := locked.GetAll()
+		require.EqualError(t, err, "test")
+	})
+}
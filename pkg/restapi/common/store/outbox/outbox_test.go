@@ -0,0 +1,410 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package outbox_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestStore_Tx(t *testing.T) {
+	t.Run("commit makes events deliverable", func(t *testing.T) {
+		s := newStore(t)
+		tx, err := s.Begin(newEvent(t, "sub1"))
+		require.NoError(t, err)
+
+		events, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, outbox.StatusPending, events[0].Status)
+
+		require.NoError(t, tx.Commit())
+
+		events, err = s.List()
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, outbox.StatusReady, events[0].Status)
+	})
+
+	t.Run("rollback discards events", func(t *testing.T) {
+		s := newStore(t)
+		tx, err := s.Begin(newEvent(t, "sub1"), newEvent(t, "sub1"))
+		require.NoError(t, err)
+		require.NoError(t, tx.Rollback())
+
+		events, err := s.List()
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("nil tx is a no-op", func(t *testing.T) {
+		var tx *outbox.Tx
+		require.NoError(t, tx.Commit())
+		require.NoError(t, tx.Rollback())
+	})
+
+	t.Run("error if cannot record events", func(t *testing.T) {
+		expected := errors.New("test")
+		s, err := outbox.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  make(map[string][]byte),
+			ErrPut: expected,
+		}})
+		require.NoError(t, err)
+		_, err = s.Begin(newEvent(t, "sub1"))
+		require.True(t, errors.Is(err, expected))
+	})
+
+	t.Run("error if cannot open store", func(t *testing.T) {
+		expected := errors.New("test")
+		_, err := outbox.NewStore(&mockstore.Provider{ErrCreateStore: expected})
+		require.True(t, errors.Is(err, expected))
+	})
+}
+
+func TestRelay_Flush(t *testing.T) {
+	t.Run("delivers committed events in order", func(t *testing.T) {
+		s := newStore(t)
+		first := newEvent(t, "sub1")
+		second := newEvent(t, "sub1")
+		second.Created = first.Created.Add(time.Second)
+		commit(t, s, second)
+		commit(t, s, first)
+
+		d := &mockDispatcher{}
+		r := outbox.NewRelay(&outbox.RelayConfig{Store: s, Dispatcher: d})
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{first.ID, second.ID}, d.delivered)
+
+		events, err := s.List()
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("failed delivery is retried and holds back later events of the subject", func(t *testing.T) {
+		s := newStore(t)
+		first := newEvent(t, "sub1")
+		second := newEvent(t, "sub1")
+		second.Created = first.Created.Add(time.Second)
+		other := newEvent(t, "sub2")
+		commit(t, s, first, second, other)
+
+		d := &mockDispatcher{fail: map[string]bool{first.ID: true}}
+		r := outbox.NewRelay(&outbox.RelayConfig{Store: s, Dispatcher: d, MaxBackoff: time.Nanosecond})
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{other.ID}, d.delivered)
+
+		events, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		for _, e := range events {
+			if e.ID == first.ID {
+				require.Equal(t, 1, e.Attempts)
+			}
+		}
+
+		d.fail = nil
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{other.ID, first.ID, second.ID}, d.delivered)
+	})
+
+	t.Run("failed delivery is not retried before its backoff", func(t *testing.T) {
+		s := newStore(t)
+		first := newEvent(t, "sub1")
+		second := newEvent(t, "sub1")
+		second.Created = first.Created.Add(time.Second)
+		commit(t, s, first, second)
+
+		d := &mockDispatcher{fail: map[string]bool{first.ID: true}}
+		r := outbox.NewRelay(&outbox.RelayConfig{Store: s, Dispatcher: d, Interval: time.Minute})
+		require.NoError(t, r.Flush())
+
+		d.fail = nil
+		require.NoError(t, r.Flush())
+		require.Empty(t, d.delivered)
+
+		e, err := s.Get(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, 1, e.Attempts)
+		require.Equal(t, "test", e.LastError)
+		require.True(t, e.NextAttempt.After(time.Now().Add(59*time.Second)))
+	})
+
+	t.Run("event is dead after max attempts and no longer holds back its subject", func(t *testing.T) {
+		s := newStore(t)
+		first := newEvent(t, "sub1")
+		second := newEvent(t, "sub1")
+		second.Created = first.Created.Add(time.Second)
+		commit(t, s, first, second)
+
+		d := &mockDispatcher{fail: map[string]bool{first.ID: true}}
+		r := outbox.NewRelay(&outbox.RelayConfig{
+			Store:       s,
+			Dispatcher:  d,
+			MaxAttempts: 2,
+			MaxBackoff:  time.Nanosecond,
+		})
+		require.NoError(t, r.Flush())
+		require.Empty(t, d.delivered)
+
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{second.ID}, d.delivered)
+
+		dead, err := s.Dead()
+		require.NoError(t, err)
+		require.Len(t, dead, 1)
+		require.Equal(t, first.ID, dead[0].ID)
+		require.Equal(t, outbox.StatusDead, dead[0].Status)
+		require.Equal(t, 2, dead[0].Attempts)
+
+		// the dead events are not delivered until requeued
+		d.fail = nil
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{second.ID}, d.delivered)
+
+		requeued, err := s.Requeue(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, outbox.StatusReady, requeued.Status)
+		require.Zero(t, requeued.Attempts)
+
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{second.ID, first.ID}, d.delivered)
+
+		events, err := s.List()
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("pending event holds back later events of the subject", func(t *testing.T) {
+		s := newStore(t)
+		pending := newEvent(t, "sub1")
+		later := newEvent(t, "sub1")
+		later.Created = pending.Created.Add(time.Second)
+		other := newEvent(t, "sub2")
+
+		tx, err := s.Begin(pending)
+		require.NoError(t, err)
+		commit(t, s, later, other)
+
+		d := &mockDispatcher{}
+		r := outbox.NewRelay(&outbox.RelayConfig{Store: s, Dispatcher: d, PendingTimeout: time.Hour})
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{other.ID}, d.delivered)
+
+		require.NoError(t, tx.Commit())
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{other.ID, pending.ID, later.ID}, d.delivered)
+	})
+
+	t.Run("resolves stale pending events", func(t *testing.T) {
+		s := newStore(t)
+		committed := newEvent(t, "sub1")
+		committed.Created = time.Now().Add(-time.Hour)
+		phantom := newEvent(t, "sub2")
+		phantom.Created = time.Now().Add(-time.Hour)
+		fresh := newEvent(t, "sub3")
+		_, err := s.Begin(committed, phantom, fresh)
+		require.NoError(t, err)
+
+		d := &mockDispatcher{}
+		r := outbox.NewRelay(&outbox.RelayConfig{
+			Store:      s,
+			Dispatcher: d,
+			Confirm: func(e *outbox.Event) (bool, error) {
+				return e.Subject == "sub1", nil
+			},
+			PendingTimeout: time.Minute,
+		})
+		require.NoError(t, r.Flush())
+		require.Equal(t, []string{committed.ID}, d.delivered)
+
+		events, err := s.List()
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, fresh.ID, events[0].ID)
+	})
+
+	t.Run("error if cannot confirm pending event", func(t *testing.T) {
+		expected := errors.New("test")
+		s := newStore(t)
+		e := newEvent(t, "sub1")
+		e.Created = time.Now().Add(-time.Hour)
+		_, err := s.Begin(e)
+		require.NoError(t, err)
+
+		r := outbox.NewRelay(&outbox.RelayConfig{
+			Store:      s,
+			Dispatcher: &mockDispatcher{},
+			Confirm: func(*outbox.Event) (bool, error) {
+				return false, expected
+			},
+		})
+		err = r.Flush()
+		require.True(t, errors.Is(err, expected))
+	})
+
+	t.Run("the errors of an event do not stop the pass", func(t *testing.T) {
+		expected := errors.New("test")
+		s := newStore(t)
+
+		stale := newEvent(t, "sub1")
+		stale.Created = time.Now().Add(-time.Hour)
+		later := newEvent(t, "sub1")
+		other := newEvent(t, "sub2")
+		otherStale := newEvent(t, "sub3")
+		otherStale.Created = time.Now().Add(-time.Hour)
+
+		_, err := s.Begin(stale, otherStale)
+		require.NoError(t, err)
+		commit(t, s, later, other)
+
+		d := &mockDispatcher{}
+		r := outbox.NewRelay(&outbox.RelayConfig{
+			Store:      s,
+			Dispatcher: d,
+			Confirm: func(*outbox.Event) (bool, error) {
+				return false, expected
+			},
+		})
+		err = r.Flush()
+		require.True(t, errors.Is(err, expected))
+		require.Contains(t, err.Error(), "failed to relay 2 outbox events")
+
+		// the events of the subjects of the failed events are held back
+		require.Equal(t, []string{other.ID}, d.delivered)
+	})
+}
+
+func TestStore_Enqueue(t *testing.T) {
+	t.Run("enqueues events ready for delivery", func(t *testing.T) {
+		s := newStore(t)
+		e := newEvent(t, "sub1")
+		require.NoError(t, s.Dispatch(e))
+
+		saved, err := s.Get(e.ID)
+		require.NoError(t, err)
+		require.Equal(t, outbox.StatusReady, saved.Status)
+	})
+
+	t.Run("error if cannot enqueue events", func(t *testing.T) {
+		expected := errors.New("test")
+		s, err := outbox.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  make(map[string][]byte),
+			ErrPut: expected,
+		}})
+		require.NoError(t, err)
+
+		err = s.Enqueue(newEvent(t, "sub1"))
+		require.True(t, errors.Is(err, expected))
+	})
+}
+
+func TestStore_Requeue(t *testing.T) {
+	s := newStore(t)
+	e := newEvent(t, "sub1")
+	commit(t, s, e)
+
+	_, err := s.Requeue(e.ID)
+	require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+	_, err = s.Requeue("unknown")
+	require.True(t, errors.Is(err, storage.ErrValueNotFound))
+}
+
+func TestRelay_StartStop(t *testing.T) {
+	s := newStore(t)
+	e := newEvent(t, "sub1")
+	commit(t, s, e)
+
+	done := make(chan struct{})
+	d := &mockDispatcher{done: done}
+	r := outbox.NewRelay(&outbox.RelayConfig{Store: s, Dispatcher: d, Interval: time.Millisecond})
+	r.Start()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for delivery")
+	}
+
+	r.Stop()
+	r.Stop()
+
+	t.Run("delivers the events as soon as they are enqueued", func(t *testing.T) {
+		s := newStore(t)
+
+		done := make(chan struct{})
+		d := &mockDispatcher{done: done}
+		r := outbox.NewRelay(&outbox.RelayConfig{Store: s, Dispatcher: d, Interval: time.Hour})
+		r.Start()
+
+		defer r.Stop()
+
+		require.NoError(t, s.Enqueue(newEvent(t, "sub1")))
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for delivery")
+		}
+	})
+}
+
+func newStore(t *testing.T) *outbox.Store {
+	t.Helper()
+
+	s, err := outbox.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	return s
+}
+
+func newEvent(t *testing.T, subject string) *outbox.Event {
+	t.Helper()
+
+	e, err := outbox.NewEvent("test.topic", subject, map[string]string{"id": uuid.New().String()})
+	require.NoError(t, err)
+
+	return e
+}
+
+func commit(t *testing.T, s *outbox.Store, events ...*outbox.Event) {
+	t.Helper()
+
+	tx, err := s.Begin(events...)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+}
+
+type mockDispatcher struct {
+	delivered []string
+	fail      map[string]bool
+	done      chan struct{}
+}
+
+func (m *mockDispatcher) Dispatch(e *outbox.Event) error {
+	if m.fail[e.ID] {
+		return errors.New("test")
+	}
+
+	m.delivered = append(m.delivered, e.ID)
+
+	if m.done != nil {
+		close(m.done)
+		m.done = nil
+	}
+
+	return nil
+}
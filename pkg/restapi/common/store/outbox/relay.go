@@ -0,0 +1,293 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package outbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+const (
+	defaultRelayInterval  = 5 * time.Second
+	defaultPendingTimeout = time.Minute
+	defaultMaxAttempts    = 12
+	defaultMaxBackoff     = time.Hour
+)
+
+var logger = log.New("edge-agent/outbox")
+
+// Dispatcher delivers events to their consumers.
+type Dispatcher interface {
+	Dispatch(e *Event) error
+}
+
+// Confirmer reports whether the state change that produced a pending event was committed.
+type Confirmer func(e *Event) (bool, error)
+
+// RelayConfig holds the configuration for a Relay.
+type RelayConfig struct {
+	Store      *Store
+	Dispatcher Dispatcher
+	Confirm    Confirmer
+	// Interval between delivery passes. Defaults to 5s.
+	Interval time.Duration
+	// PendingTimeout is how long an event may stay pending before the Relay resolves it with Confirm.
+	// Defaults to 1m.
+	PendingTimeout time.Duration
+	// MaxAttempts is the number of failed deliveries after which an event is dead, so that it no longer holds
+	// back the later events of its subject. Defaults to 12.
+	MaxAttempts int
+	// MaxBackoff caps the delay before the next delivery of a failed event, which doubles from Interval with
+	// every attempt. Defaults to 1h.
+	MaxBackoff time.Duration
+}
+
+// Relay delivers committed events from the outbox with at-least-once semantics. Consumers should
+// deduplicate on Event.ID.
+type Relay struct {
+	store          *Store
+	dispatcher     Dispatcher
+	confirm        Confirmer
+	interval       time.Duration
+	pendingTimeout time.Duration
+	maxAttempts    int
+	maxBackoff     time.Duration
+	now            func() time.Time
+	once           sync.Once
+	stop           chan struct{}
+	done           chan struct{}
+}
+
+// NewRelay returns a new Relay.
+func NewRelay(config *RelayConfig) *Relay {
+	r := &Relay{
+		store:          config.Store,
+		dispatcher:     config.Dispatcher,
+		confirm:        config.Confirm,
+		interval:       config.Interval,
+		pendingTimeout: config.PendingTimeout,
+		maxAttempts:    config.MaxAttempts,
+		maxBackoff:     config.MaxBackoff,
+		now:            time.Now,
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	if r.interval <= 0 {
+		r.interval = defaultRelayInterval
+	}
+
+	if r.pendingTimeout <= 0 {
+		r.pendingTimeout = defaultPendingTimeout
+	}
+
+	if r.maxAttempts <= 0 {
+		r.maxAttempts = defaultMaxAttempts
+	}
+
+	if r.maxBackoff <= 0 {
+		r.maxBackoff = defaultMaxBackoff
+	}
+
+	return r
+}
+
+// Start delivering events in the background, on every interval and as soon as events are ready.
+func (r *Relay) Start() {
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.flush()
+			case <-r.store.ready:
+				r.flush()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the relay and wait for the current pass to finish.
+func (r *Relay) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+}
+
+func (r *Relay) flush() {
+	err := r.Flush()
+	if err != nil {
+		logger.Errorf("outbox relay pass failed: %s", err.Error())
+	}
+}
+
+// Flush runs a single delivery pass. Events are delivered in creation order; a failed delivery holds back
+// the later events of the same subject until its next attempt, with an exponential backoff, or until the event
+// is dead, and a pending event holds them back until its state change is committed or it is resolved. An event
+// that cannot be resolved or updated in the store holds back its subject until the next pass, without stopping
+// the pass, whose errors are returned together.
+func (r *Relay) Flush() error {
+	events, err := r.store.List()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Created.Before(events[j].Created)
+	})
+
+	blocked := make(map[string]bool)
+
+	var errs []error
+
+	for _, e := range events {
+		if blocked[e.Subject] || e.Status == StatusDead {
+			continue
+		}
+
+		if r.now().Before(e.NextAttempt) {
+			blocked[e.Subject] = true
+
+			continue
+		}
+
+		err = r.relay(e, blocked)
+		if err != nil {
+			logger.Errorf("outbox relay: %s", err.Error())
+
+			blocked[e.Subject] = true
+			errs = append(errs, err)
+		}
+	}
+
+	return relayError(errs)
+}
+
+// relay delivers the event if it is deliverable, and records the result in the store.
+func (r *Relay) relay(e *Event, blocked map[string]bool) error {
+	deliver, err := r.resolve(e, blocked)
+	if err != nil {
+		return err
+	}
+
+	if !deliver {
+		return nil
+	}
+
+	err = r.dispatcher.Dispatch(e)
+	if err != nil {
+		blocked[e.Subject] = r.fail(e, err)
+
+		err = r.store.Update(e)
+		if err != nil {
+			return fmt.Errorf("failed to update outbox event %s: %w", e.ID, err)
+		}
+
+		return nil
+	}
+
+	return r.store.Delete(e.ID)
+}
+
+// relayError returns the errors of the events of a pass as one, wrapping the first.
+func relayError(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	others := make([]string, 0, len(errs)-1)
+
+	for _, err := range errs[1:] {
+		others = append(others, err.Error())
+	}
+
+	return fmt.Errorf("failed to relay %d outbox events: %w; %s", len(errs), errs[0], strings.Join(others, "; "))
+}
+
+// fail records the failed delivery of the event, and reports whether it holds back the later events of its
+// subject.
+func (r *Relay) fail(e *Event, err error) bool {
+	e.Attempts++
+	e.LastError = err.Error()
+
+	if e.Attempts >= r.maxAttempts {
+		logger.Errorf("event %s (%s) is dead after %d attempts: %s", e.ID, e.Topic, e.Attempts, err.Error())
+
+		e.Status = StatusDead
+
+		return false
+	}
+
+	logger.Warnf("failed to dispatch event %s (%s): %s", e.ID, e.Topic, err.Error())
+
+	e.NextAttempt = r.now().Add(r.backoff(e.Attempts))
+
+	return true
+}
+
+// backoff returns the delay before the next delivery of an event that failed the attempts.
+func (r *Relay) backoff(attempts int) time.Duration {
+	backoff := r.interval
+
+	for i := 1; i < attempts && backoff < r.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > r.maxBackoff {
+		return r.maxBackoff
+	}
+
+	return backoff
+}
+
+// resolve reports whether the event is deliverable, settling stale pending events along the way. The events left
+// pending hold back the later events of their subject, unless they are stale without a Confirmer to resolve them.
+func (r *Relay) resolve(e *Event, blocked map[string]bool) (bool, error) {
+	if e.Status == StatusReady {
+		return true, nil
+	}
+
+	if r.now().Sub(e.Created) < r.pendingTimeout {
+		blocked[e.Subject] = true
+
+		return false, nil
+	}
+
+	if r.confirm == nil {
+		return false, nil
+	}
+
+	committed, err := r.confirm(e)
+	if err != nil {
+		return false, fmt.Errorf("failed to confirm pending event %s: %w", e.ID, err)
+	}
+
+	if !committed {
+		logger.Infof("discarding event %s (%s): state change was never committed", e.ID, e.Topic)
+
+		return false, r.store.Delete(e.ID)
+	}
+
+	e.Status = StatusReady
+
+	return true, r.store.Update(e)
+}
@@ -0,0 +1,293 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// StoreName is the name of the outbox store.
+	StoreName = "edgeagent_outbox"
+)
+
+// Status of an Event in the outbox.
+const (
+	// StatusPending events were recorded ahead of their state change and are not yet deliverable.
+	StatusPending = "pending"
+	// StatusReady events belong to a committed state change and are waiting for delivery.
+	StatusReady = "ready"
+	// StatusDead events failed every delivery attempt. They are kept for the admins to requeue or discard.
+	StatusDead = "dead"
+)
+
+// Event is a lifecycle event produced by a state change.
+type Event struct {
+	ID       string          `json:"id"`
+	Topic    string          `json:"topic"`
+	Subject  string          `json:"subject,omitempty"`
+	Tenant   string          `json:"tenant,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Created  time.Time       `json:"created"`
+	Status   string          `json:"status"`
+	Attempts int             `json:"attempts"`
+	// NextAttempt is the time before which a failed event is not delivered again.
+	NextAttempt time.Time `json:"nextAttempt,omitempty"`
+	// LastError is the error of the last failed delivery.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// NewEvent returns a new Event for the given topic and subject with 'payload' marshalled as JSON.
+func NewEvent(topic, subject string, payload interface{}) (*Event, error) {
+	e := &Event{
+		ID:      uuid.New().String(),
+		Topic:   topic,
+		Subject: subject,
+		Created: time.Now(),
+	}
+
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event payload: %w", err)
+		}
+
+		e.Payload = raw
+	}
+
+	return e, nil
+}
+
+// NewStore returns a new outbox Store.
+func NewStore(p storage.Provider) (*Store, error) {
+	s, err := store.Open(p, StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox store: %w", err)
+	}
+
+	return &Store{s: s, ready: make(chan struct{}, 1)}, nil
+}
+
+// Store persists Events until they are delivered.
+//
+// The underlying storage.Store has no multi-key transactions, so events are written ahead of the state
+// change that produces them (StatusPending) and flipped to StatusReady once the state change is committed.
+// Pending events orphaned by a crash are resolved by the Relay.
+type Store struct {
+	s   storage.Store
+	mux sync.Mutex
+	// signals the Relay that events are ready
+	ready chan struct{}
+}
+
+// Begin records the events as pending and returns the Tx that must be committed or rolled back once the
+// state change that produced them has been attempted.
+func (s *Store) Begin(events ...*Event) (*Tx, error) {
+	for _, e := range events {
+		e.Status = StatusPending
+
+		err := s.put(e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record pending event %s: %w", e.Topic, err)
+		}
+	}
+
+	return &Tx{s: s, events: events}, nil
+}
+
+// Enqueue records events that are not produced by a state change, such as notifications, ready for delivery.
+func (s *Store) Enqueue(events ...*Event) error {
+	for _, e := range events {
+		e.Status = StatusReady
+
+		err := s.put(e)
+		if err != nil {
+			return fmt.Errorf("failed to enqueue event %s: %w", e.Topic, err)
+		}
+	}
+
+	s.signal()
+
+	return nil
+}
+
+// Dispatch enqueues the event, so that the handlers dispatching their events without waiting for the delivery
+// have them delivered by the Relay, with retries.
+func (s *Store) Dispatch(e *Event) error {
+	return s.Enqueue(e)
+}
+
+// Get returns the event of the id, or an error wrapping storage.ErrValueNotFound.
+func (s *Store) Get(id string) (*Event, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	raw, err := s.s.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch outbox event %s: %w", id, err)
+	}
+
+	e := &Event{}
+
+	err = json.Unmarshal(raw, e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal outbox event: %w", err)
+	}
+
+	return e, nil
+}
+
+// Dead returns the events that failed every delivery attempt.
+func (s *Store) Dead() ([]*Event, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var dead []*Event
+
+	for _, e := range all {
+		if e.Status == StatusDead {
+			dead = append(dead, e)
+		}
+	}
+
+	return dead, nil
+}
+
+// Requeue makes a dead event ready for delivery again, with all its attempts. The error wraps
+// storage.ErrValueNotFound if there is no dead event of the id.
+func (s *Store) Requeue(id string) (*Event, error) {
+	e, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Status != StatusDead {
+		return nil, fmt.Errorf("outbox event %s is not dead: %w", id, storage.ErrValueNotFound)
+	}
+
+	e.Status = StatusReady
+	e.Attempts = 0
+	e.NextAttempt = time.Time{}
+
+	err = s.Enqueue(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// List returns all events currently in the outbox.
+func (s *Store) List() ([]*Event, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	all, err := s.s.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox events: %w", err)
+	}
+
+	events := make([]*Event, 0, len(all))
+
+	for _, raw := range all {
+		e := &Event{}
+
+		err = json.Unmarshal(raw, e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal outbox event: %w", err)
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// Update persists changes to the event.
+func (s *Store) Update(e *Event) error {
+	return s.put(e)
+}
+
+// Delete removes the event from the outbox.
+func (s *Store) Delete(id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	err := s.s.Delete(id)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to delete outbox event %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// signal the Relay without waiting, the signals of the events it has yet to list being merged.
+func (s *Store) signal() {
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Store) put(e *Event) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return store.Save(s.s, e.ID, e)
+}
+
+// Tx tracks the events recorded for a single state change. A nil *Tx is a valid no-op transaction.
+type Tx struct {
+	s      *Store
+	events []*Event
+}
+
+// Commit marks the events as ready for delivery.
+func (t *Tx) Commit() error {
+	if t == nil {
+		return nil
+	}
+
+	for _, e := range t.events {
+		e.Status = StatusReady
+
+		err := t.s.put(e)
+		if err != nil {
+			return fmt.Errorf("failed to commit event %s: %w", e.Topic, err)
+		}
+	}
+
+	t.s.signal()
+
+	return nil
+}
+
+// Rollback discards the events because their state change did not happen.
+func (t *Tx) Rollback() error {
+	if t == nil {
+		return nil
+	}
+
+	for _, e := range t.events {
+		err := t.s.Delete(e.ID)
+		if err != nil {
+			return fmt.Errorf("failed to rollback event %s: %w", e.Topic, err)
+		}
+	}
+
+	return nil
+}
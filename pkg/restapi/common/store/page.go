@@ -0,0 +1,90 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// ErrInvalidCursor is returned for the cursors that were not returned by a page.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Record is a key-value pair of a store.
+type Record struct {
+	Key   string
+	Value []byte
+}
+
+// Page returns up to limit records of the store, in the order of their keys, following the record of the cursor,
+// and the cursor of the next page. The first page follows an empty cursor, and the last page has an empty next
+// cursor. The records saved or deleted while the pages are read are listed if they follow the last page read.
+//
+// The storage providers only list all the records at once: the pages bound the records their callers hold, not
+// those read from the store.
+func Page(s storage.Store, cursor string, limit int) ([]*Record, string, error) {
+	after, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	all, err := s.GetAll()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list records: %w", err)
+	}
+
+	keys := make([]string, 0, len(all))
+
+	for k := range all {
+		if cursor == "" || k > after {
+			keys = append(keys, k)
+		}
+	}
+
+	keys, next := PageKeys(keys, limit)
+
+	page := make([]*Record, len(keys))
+
+	for i, k := range keys {
+		page[i] = &Record{Key: k, Value: all[k]}
+	}
+
+	return page, next, nil
+}
+
+// PageKeys sorts the keys following a cursor and returns up to limit of them, with the cursor of the next page.
+// All keys are returned if limit is not positive.
+func PageKeys(keys []string, limit int) ([]string, string) {
+	sort.Strings(keys)
+
+	if limit <= 0 || len(keys) <= limit {
+		return keys, ""
+	}
+
+	keys = keys[:limit]
+
+	return keys, EncodeCursor(keys[limit-1])
+}
+
+// EncodeCursor returns the cursor of the pages following the key.
+func EncodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodeCursor returns the key the pages of the cursor follow.
+func DecodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	return string(key), nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package store_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestPage(t *testing.T) {
+	t.Run("lists the records a page at a time", func(t *testing.T) {
+		s, err := store.Open(memstore.NewProvider(), "test")
+		require.NoError(t, err)
+
+		for _, k := range []string{"c", "a", "d", "b"} {
+			require.NoError(t, s.Put(k, []byte(k)))
+		}
+
+		page, next, err := store.Page(s, "", 3)
+		require.NoError(t, err)
+		require.Equal(t, []*store.Record{
+			{Key: "a", Value: []byte("a")}, {Key: "b", Value: []byte("b")}, {Key: "c", Value: []byte("c")},
+		}, page)
+		require.NotEmpty(t, next)
+
+		// the records saved while the pages are read are listed if they follow the last page read
+		require.NoError(t, s.Put("e", []byte("e")))
+
+		page, next, err = store.Page(s, next, 3)
+		require.NoError(t, err)
+		require.Equal(t, []*store.Record{{Key: "d", Value: []byte("d")}, {Key: "e", Value: []byte("e")}}, page)
+		require.Empty(t, next)
+
+		page, next, err = store.Page(s, "", 0)
+		require.NoError(t, err)
+		require.Len(t, page, 5)
+		require.Empty(t, next)
+	})
+
+	t.Run("error if the cursor is invalid", func(t *testing.T) {
+		_, _, err := store.Page(&mockstore.MockStore{}, "***", 1)
+		require.True(t, errors.Is(err, store.ErrInvalidCursor))
+	})
+
+	t.Run("error if the store fails", func(t *testing.T) {
+		_, _, err := store.Page(&mockstore.MockStore{ErrGetAll: errors.New("test")}, "", 1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to list records")
+	})
+}
@@ -0,0 +1,140 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package pseudonym derives pseudonymous IDs of the users from their IdP subs, so that the stores of the agent
+// and the KMS resources of the users do not reveal who they belong to. The subs are only kept encrypted, in a
+// lookup table of the IDs.
+package pseudonym
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	// StoreName is the name of the lookup table of the subs of the IDs.
+	StoreName = "edgeagent_user_ids"
+	// MinKeyLength is the minimum length of the HMAC key.
+	MinKeyLength = 32
+)
+
+// Config holds the keys of the pseudonyms.
+type Config struct {
+	// Storage keeps the lookup table.
+	Storage storage.Provider
+	// HMACKey derives the IDs from the subs. The IDs of all the users change with it.
+	HMACKey []byte
+	// EncryptionKey encrypts the subs in the lookup table with AES-GCM. It must be 16, 24 or 32 bytes long.
+	EncryptionKey []byte
+	// Cipher encrypts the subs in the lookup table in place of EncryptionKey, e.g. with a key held in an HSM.
+	Cipher keys.Cipher
+}
+
+// Mapper maps the subs of the users to their IDs and back. A nil Mapper uses the subs as the IDs.
+type Mapper struct {
+	key    []byte
+	cipher keys.Cipher
+	store  storage.Store
+}
+
+// New returns a new Mapper.
+func New(config *Config) (*Mapper, error) {
+	if len(config.HMACKey) < MinKeyLength {
+		return nil, fmt.Errorf("the HMAC key must be at least %d bytes long", MinKeyLength)
+	}
+
+	cipher := config.Cipher
+
+	if cipher == nil {
+		var err error
+
+		cipher, err = keys.NewAESGCM(config.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := store.Open(config.Storage, StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user ids store: %w", err)
+	}
+
+	return &Mapper{key: config.HMACKey, cipher: cipher, store: s}, nil
+}
+
+// ID derives the ID of the user with the sub.
+func (m *Mapper) ID(sub string) string {
+	if m == nil {
+		return sub
+	}
+
+	mac := hmac.New(sha256.New, m.key)
+	_, _ = mac.Write([]byte(sub))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Register derives the ID of the user with the sub, and records the sub in the lookup table so that Sub finds it.
+func (m *Mapper) Register(sub string) (string, error) {
+	id := m.ID(sub)
+
+	if m == nil {
+		return id, nil
+	}
+
+	_, err := m.store.Get(id)
+	if err == nil {
+		return id, nil
+	}
+
+	if !errors.Is(err, storage.ErrValueNotFound) {
+		return "", fmt.Errorf("failed to query user id: %w", err)
+	}
+
+	// the id is authenticated so that the encrypted subs cannot be swapped between the ids
+	sealed, err := m.cipher.Seal([]byte(sub), []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt sub: %w", err)
+	}
+
+	err = m.store.Put(id, sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to save user id: %w", err)
+	}
+
+	return id, nil
+}
+
+// Sub returns the sub of the user with the registered ID. The error wraps storage.ErrValueNotFound if the ID was
+// never registered.
+func (m *Mapper) Sub(id string) (string, error) {
+	if m == nil {
+		return id, nil
+	}
+
+	sealed, err := m.store.Get(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch user id: %w", err)
+	}
+
+	sub, err := m.cipher.Open(sealed, []byte(id))
+	if errors.Is(err, keys.ErrInvalidSealed) {
+		return "", errors.New("invalid encrypted sub")
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt sub: %w", err)
+	}
+
+	return string(sub), nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package pseudonym_test
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/keys"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestMapper(t *testing.T) {
+	t.Run("maps the subs to their ids and back", func(t *testing.T) {
+		provider := memstore.NewProvider()
+		ids := newMapper(t, &pseudonym.Config{Storage: provider, HMACKey: key(t), EncryptionKey: key(t)})
+
+		id, err := ids.Register("user")
+		require.NoError(t, err)
+		require.Equal(t, ids.ID("user"), id)
+		require.NotEqual(t, ids.ID("user"), ids.ID("other"))
+
+		sub, err := ids.Sub(id)
+		require.NoError(t, err)
+		require.Equal(t, "user", sub)
+
+		again, err := ids.Register("user")
+		require.NoError(t, err)
+		require.Equal(t, id, again)
+
+		s, err := provider.OpenStore(pseudonym.StoreName)
+		require.NoError(t, err)
+
+		raw, err := s.Get(id)
+		require.NoError(t, err)
+		require.NotContains(t, string(raw), "user")
+	})
+
+	t.Run("derives other ids with another key", func(t *testing.T) {
+		encryptionKey := key(t)
+
+		a := newMapper(t, &pseudonym.Config{Storage: memstore.NewProvider(), HMACKey: key(t), EncryptionKey: encryptionKey})
+		b := newMapper(t, &pseudonym.Config{Storage: memstore.NewProvider(), HMACKey: key(t), EncryptionKey: encryptionKey})
+
+		require.NotEqual(t, a.ID("user"), b.ID("user"))
+	})
+
+	t.Run("error if the id was never registered", func(t *testing.T) {
+		ids := newMapper(t, &pseudonym.Config{Storage: memstore.NewProvider(), HMACKey: key(t), EncryptionKey: key(t)})
+
+		_, err := ids.Sub(ids.ID("user"))
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if the encrypted subs are swapped", func(t *testing.T) {
+		provider := memstore.NewProvider()
+		ids := newMapper(t, &pseudonym.Config{Storage: provider, HMACKey: key(t), EncryptionKey: key(t)})
+
+		alice, err := ids.Register("alice")
+		require.NoError(t, err)
+
+		bob, err := ids.Register("bob")
+		require.NoError(t, err)
+
+		s, err := provider.OpenStore(pseudonym.StoreName)
+		require.NoError(t, err)
+
+		raw, err := s.Get(alice)
+		require.NoError(t, err)
+		require.NoError(t, s.Put(bob, raw))
+
+		_, err = ids.Sub(bob)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to decrypt sub")
+
+		require.NoError(t, s.Put(bob, []byte("short")))
+
+		_, err = ids.Sub(bob)
+		require.EqualError(t, err, "invalid encrypted sub")
+	})
+
+	t.Run("encrypts the subs with the cipher", func(t *testing.T) {
+		provider := memstore.NewProvider()
+		k := key(t)
+
+		cipher, err := keys.Software{"subs": k}.Cipher("subs")
+		require.NoError(t, err)
+
+		ids := newMapper(t, &pseudonym.Config{Storage: provider, HMACKey: key(t), Cipher: cipher})
+
+		id, err := ids.Register("user")
+		require.NoError(t, err)
+
+		s, err := provider.OpenStore(pseudonym.StoreName)
+		require.NoError(t, err)
+
+		sealed, err := s.Get(id)
+		require.NoError(t, err)
+
+		sub, err := cipher.Open(sealed, []byte(id))
+		require.NoError(t, err)
+		require.Equal(t, "user", string(sub))
+
+		decrypted, err := ids.Sub(id)
+		require.NoError(t, err)
+		require.Equal(t, "user", decrypted)
+	})
+
+	t.Run("error if the lookup table fails", func(t *testing.T) {
+		provider := &mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{},
+			ErrGet: errors.New("test"),
+		}}
+		ids := newMapper(t, &pseudonym.Config{Storage: provider, HMACKey: key(t), EncryptionKey: key(t)})
+		provider.Store.Store[ids.ID("user")] = []byte("sealed")
+
+		_, err := ids.Register("user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to query user id")
+
+		delete(provider.Store.Store, ids.ID("user"))
+		provider.Store.ErrGet = nil
+		provider.Store.ErrPut = errors.New("test")
+
+		_, err = ids.Register("user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to save user id")
+	})
+
+	t.Run("uses the subs as the ids if nil", func(t *testing.T) {
+		var ids *pseudonym.Mapper
+
+		require.Equal(t, "user", ids.ID("user"))
+
+		id, err := ids.Register("user")
+		require.NoError(t, err)
+		require.Equal(t, "user", id)
+
+		sub, err := ids.Sub("user")
+		require.NoError(t, err)
+		require.Equal(t, "user", sub)
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("error if the hmac key is too short", func(t *testing.T) {
+		_, err := pseudonym.New(&pseudonym.Config{Storage: memstore.NewProvider(), HMACKey: []byte("short"),
+			EncryptionKey: key(t)})
+		require.EqualError(t, err, "the HMAC key must be at least 32 bytes long")
+	})
+
+	t.Run("error if the encryption key is invalid", func(t *testing.T) {
+		_, err := pseudonym.New(&pseudonym.Config{Storage: memstore.NewProvider(), HMACKey: key(t),
+			EncryptionKey: []byte("short")})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid encryption key")
+	})
+
+	t.Run("error if the store cannot be opened", func(t *testing.T) {
+		_, err := pseudonym.New(&pseudonym.Config{
+			Storage:       &mockstore.Provider{ErrOpenStoreHandle: errors.New("test")},
+			HMACKey:       key(t),
+			EncryptionKey: key(t),
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open user ids store")
+	})
+}
+
+func newMapper(t *testing.T, config *pseudonym.Config) *pseudonym.Mapper {
+	t.Helper()
+
+	ids, err := pseudonym.New(config)
+	require.NoError(t, err)
+
+	return ids
+}
+
+func key(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return key
+}
@@ -9,8 +9,10 @@ package tokens
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
 	"github.com/trustbloc/edge-core/pkg/storage"
 )
 
@@ -24,30 +26,45 @@ type UserTokens struct {
 	UserSub string
 	Access  string
 	Refresh string
+	// Issuer is the issuer of the ID token of the login, i.e. the OIDC provider or the wallet of a self-issued
+	// login, and IssuedAt the time of the login. They are empty for the tokens saved before they were recorded.
+	Issuer   string     `json:",omitempty"`
+	IssuedAt *time.Time `json:",omitempty"`
 }
 
-// NewStore returns a new token Store.
-func NewStore(p storage.Provider) (*Store, error) {
+// Store holds UserTokens.
+type Store interface {
+	// Save the UserTokens to the store.
+	Save(ut *UserTokens) error
+	// Get fetches the UserTokens of the user. The error wraps storage.ErrValueNotFound if the user has none.
+	Get(sub string) (*UserTokens, error)
+	// List all UserTokens in the store.
+	List() ([]*UserTokens, error)
+	// Page returns up to limit UserTokens following the cursor, and the cursor of the next page. See store.Page.
+	Page(cursor string, limit int) ([]*UserTokens, string, error)
+	// Delete the UserTokens of the user.
+	Delete(sub string) error
+}
+
+// NewStore returns a new token Store keeping the UserTokens in the storage provider.
+func NewStore(p storage.Provider) (Store, error) {
 	s, err := store.Open(p, StoreName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open tokens store: %w", err)
 	}
 
-	return &Store{s: s}, nil
+	return &providerStore{s: s}, nil
 }
 
-// Store holds UserTokens.
-type Store struct {
+type providerStore struct {
 	s storage.Store
 }
 
-// Save the UserTokens to the store.
-func (s *Store) Save(ut *UserTokens) error {
+func (s *providerStore) Save(ut *UserTokens) error {
 	return store.Save(s.s, ut.UserSub, ut)
 }
 
-// Get fetches a UserTokens from the underlying storage.
-func (s *Store) Get(sub string) (*UserTokens, error) {
+func (s *providerStore) Get(sub string) (*UserTokens, error) {
 	raw, err := s.s.Get(sub)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch user tokens from store: %w", err)
@@ -57,3 +74,123 @@ func (s *Store) Get(sub string) (*UserTokens, error) {
 
 	return tokens, json.Unmarshal(raw, tokens)
 }
+
+func (s *providerStore) List() ([]*UserTokens, error) {
+	all, err := s.s.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user tokens: %w", err)
+	}
+
+	list := make([]*UserTokens, 0, len(all))
+
+	for _, raw := range all {
+		tokens := &UserTokens{}
+
+		err = json.Unmarshal(raw, tokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal user tokens: %w", err)
+		}
+
+		list = append(list, tokens)
+	}
+
+	return list, nil
+}
+
+func (s *providerStore) Page(cursor string, limit int) ([]*UserTokens, string, error) {
+	records, next, err := store.Page(s.s, cursor, limit)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list user tokens: %w", err)
+	}
+
+	page := make([]*UserTokens, len(records))
+
+	for i, r := range records {
+		page[i] = &UserTokens{}
+
+		err = json.Unmarshal(r.Value, page[i])
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to unmarshal user tokens: %w", err)
+		}
+	}
+
+	return page, next, nil
+}
+
+func (s *providerStore) Delete(sub string) error {
+	return s.s.Delete(sub)
+}
+
+// WithPseudonyms returns a Store keeping the UserTokens in the store under the pseudonymous IDs of the users
+// rather than their subs. The store is returned as is if ids is nil.
+func WithPseudonyms(s Store, ids *pseudonym.Mapper) Store {
+	if ids == nil {
+		return s
+	}
+
+	return &pseudonymousStore{s: s, ids: ids}
+}
+
+type pseudonymousStore struct {
+	s   Store
+	ids *pseudonym.Mapper
+}
+
+func (s *pseudonymousStore) Save(ut *UserTokens) error {
+	id, err := s.ids.Register(ut.UserSub)
+	if err != nil {
+		return fmt.Errorf("failed to register user id: %w", err)
+	}
+
+	blind := *ut
+	blind.UserSub = id
+
+	return s.s.Save(&blind)
+}
+
+func (s *pseudonymousStore) Get(sub string) (*UserTokens, error) {
+	ut, err := s.s.Get(s.ids.ID(sub))
+	if err != nil {
+		return nil, err
+	}
+
+	ut.UserSub = sub
+
+	return ut, nil
+}
+
+func (s *pseudonymousStore) List() ([]*UserTokens, error) {
+	list, err := s.s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ut := range list {
+		ut.UserSub, err = s.ids.Sub(ut.UserSub)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up user sub: %w", err)
+		}
+	}
+
+	return list, nil
+}
+
+func (s *pseudonymousStore) Page(cursor string, limit int) ([]*UserTokens, string, error) {
+	page, next, err := s.s.Page(cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, ut := range page {
+		ut.UserSub, err = s.ids.Sub(ut.UserSub)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to look up user sub: %w", err)
+		}
+	}
+
+	return page, next, nil
+}
+
+func (s *pseudonymousStore) Delete(sub string) error {
+	return s.s.Delete(s.ids.ID(sub))
+}
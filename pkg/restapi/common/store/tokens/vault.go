@@ -0,0 +1,299 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tokens
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+var logger = log.New("edge-agent/tokens")
+
+const (
+	defaultVaultMount = "secret"
+	defaultVaultPath  = "edgeagent/tokens"
+	vaultTokenHeader  = "X-Vault-Token"
+)
+
+// VaultConfig configures a Store keeping the UserTokens in the KV version 2 secrets engine of a HashiCorp Vault.
+type VaultConfig struct {
+	// URL of the Vault server, e.g. https://vault.example.com:8200.
+	URL string
+	// Token authenticates the agent to Vault. It must be allowed to create, read, list and delete the secrets
+	// under Path.
+	Token string
+	// Mount is the path of the KV secrets engine. Defaults to "secret".
+	Mount string
+	// Path of the secrets of the users in the engine. Defaults to "edgeagent/tokens".
+	Path       string
+	HTTPClient *http.Client
+}
+
+// NewVaultStore returns a new token Store keeping the UserTokens in Vault, one secret per user.
+func NewVaultStore(config *VaultConfig) (Store, error) {
+	base, err := url.Parse(config.URL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid vault URL '%s'", config.URL)
+	}
+
+	if config.Token == "" {
+		return nil, errors.New("missing vault token")
+	}
+
+	s := &vaultStore{
+		url:        strings.TrimSuffix(config.URL, "/") + "/v1/",
+		token:      config.Token,
+		mount:      strings.Trim(config.Mount, "/"),
+		path:       strings.Trim(config.Path, "/"),
+		httpClient: config.HTTPClient,
+	}
+
+	if s.mount == "" {
+		s.mount = defaultVaultMount
+	}
+
+	if s.path == "" {
+		s.path = defaultVaultPath
+	}
+
+	if s.httpClient == nil {
+		s.httpClient = http.DefaultClient
+	}
+
+	return s, nil
+}
+
+type vaultStore struct {
+	url        string
+	token      string
+	mount      string
+	path       string
+	httpClient *http.Client
+}
+
+type vaultSecret struct {
+	Data *UserTokens `json:"data"`
+}
+
+func (s *vaultStore) Save(ut *UserTokens) error {
+	body, err := json.Marshal(&vaultSecret{Data: ut})
+	if err != nil {
+		return fmt.Errorf("failed to marshal user tokens: %w", err)
+	}
+
+	_, err = s.do(http.MethodPost, s.secretURL("data", ut.UserSub), body)
+	if err != nil {
+		return fmt.Errorf("failed to save user tokens to vault: %w", err)
+	}
+
+	return nil
+}
+
+func (s *vaultStore) Get(sub string) (*UserTokens, error) {
+	body, err := s.do(http.MethodGet, s.secretURL("data", sub), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user tokens from vault: %w", err)
+	}
+
+	resp := &struct {
+		Data *vaultSecret `json:"data"`
+	}{}
+
+	err = json.Unmarshal(body, resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user tokens: %w", err)
+	}
+
+	// the latest version of a secret has no data once deleted
+	if resp.Data == nil || resp.Data.Data == nil {
+		return nil, fmt.Errorf("failed to fetch user tokens from vault: %w", storage.ErrValueNotFound)
+	}
+
+	return resp.Data.Data, nil
+}
+
+func (s *vaultStore) List() ([]*UserTokens, error) {
+	subs, err := s.subs()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getAll(subs)
+}
+
+// Page lists the secrets of the users, and reads those of the page only.
+func (s *vaultStore) Page(cursor string, limit int) ([]*UserTokens, string, error) {
+	after, err := store.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	subs, err := s.subs()
+	if err != nil {
+		return nil, "", err
+	}
+
+	following := make([]string, 0, len(subs))
+
+	for _, sub := range subs {
+		if cursor == "" || sub > after {
+			following = append(following, sub)
+		}
+	}
+
+	following, next := store.PageKeys(following, limit)
+
+	page, err := s.getAll(following)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return page, next, nil
+}
+
+// subs returns the subs of the users with a secret.
+func (s *vaultStore) subs() ([]string, error) {
+	body, err := s.do("LIST", s.url+s.mount+"/metadata/"+s.path, nil)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		// vault has no folder without secrets
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user tokens: %w", err)
+	}
+
+	resp := &struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}{}
+
+	err = json.Unmarshal(body, resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user tokens list: %w", err)
+	}
+
+	subs := make([]string, 0, len(resp.Data.Keys))
+
+	for _, key := range resp.Data.Keys {
+		sub, decodeErr := base64.RawURLEncoding.DecodeString(key)
+		if decodeErr != nil {
+			// not a secret of this store
+			continue
+		}
+
+		subs = append(subs, string(sub))
+	}
+
+	return subs, nil
+}
+
+// getAll returns the UserTokens of the users, but of those deleted since they were listed.
+func (s *vaultStore) getAll(subs []string) ([]*UserTokens, error) {
+	list := make([]*UserTokens, 0, len(subs))
+
+	for _, sub := range subs {
+		tokens, err := s.Get(sub)
+		if errors.Is(err, storage.ErrValueNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to list user tokens: %w", err)
+		}
+
+		list = append(list, tokens)
+	}
+
+	return list, nil
+}
+
+// Delete deletes all the versions of the secret of the user.
+func (s *vaultStore) Delete(sub string) error {
+	_, err := s.do(http.MethodDelete, s.secretURL("metadata", sub), nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete user tokens from vault: %w", err)
+	}
+
+	return nil
+}
+
+// secretURL returns the URL of the secret of the user. The subs are encoded, as they may contain slashes.
+func (s *vaultStore) secretURL(api, sub string) string {
+	return s.url + s.mount + "/" + api + "/" + s.path + "/" + base64.RawURLEncoding.EncodeToString([]byte(sub))
+}
+
+// do sends the request to vault. The error wraps storage.ErrValueNotFound if vault returns 404.
+func (s *vaultStore) do(method, u string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, u, reader) // nolint:noctx // the store API has no context
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set(vaultTokenHeader, s.token)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			logger.Warnf("failed to close response body: %s", closeErr.Error())
+		}
+	}()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, storage.ErrValueNotFound
+	case resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices:
+		return nil, fmt.Errorf("vault returned %d: %s", resp.StatusCode, vaultErrors(respBody))
+	}
+
+	return respBody, nil
+}
+
+// vaultErrors returns the errors of a vault response, which are safe to log unlike the rest of the body.
+func vaultErrors(body []byte) string {
+	resp := &struct {
+		Errors []string `json:"errors"`
+	}{}
+
+	if json.Unmarshal(body, resp) != nil || len(resp.Errors) == 0 {
+		return "unknown error"
+	}
+
+	return strings.Join(resp.Errors, "; ")
+}
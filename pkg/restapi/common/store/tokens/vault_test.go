@@ -0,0 +1,229 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tokens_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+func TestVaultStore(t *testing.T) {
+	t.Run("saves, lists and deletes the tokens of the users", func(t *testing.T) {
+		vault := newMockVault(t, "kv", "agent/tokens")
+		s := vaultStore(t, &tokens.VaultConfig{URL: vault.URL + "/", Token: "token", Mount: "/kv/", Path: "agent/tokens"})
+
+		list, err := s.List()
+		require.NoError(t, err)
+		require.Empty(t, list)
+
+		for _, sub := range []string{"auth0|alice", "bob/1"} {
+			require.NoError(t, s.Save(&tokens.UserTokens{UserSub: sub, Access: "access-" + sub, Refresh: "refresh"}))
+		}
+
+		require.NoError(t, s.Save(&tokens.UserTokens{UserSub: "bob/1", Access: "refreshed"}))
+
+		ut, err := s.Get("bob/1")
+		require.NoError(t, err)
+		require.Equal(t, &tokens.UserTokens{UserSub: "bob/1", Access: "refreshed"}, ut)
+
+		list, err = s.List()
+		require.NoError(t, err)
+		sort.Slice(list, func(i, j int) bool { return list[i].UserSub < list[j].UserSub })
+		require.Equal(t, []*tokens.UserTokens{
+			{UserSub: "auth0|alice", Access: "access-auth0|alice", Refresh: "refresh"},
+			{UserSub: "bob/1", Access: "refreshed"},
+		}, list)
+
+		require.NoError(t, s.Delete("auth0|alice"))
+
+		_, err = s.Get("auth0|alice")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		list, err = s.List()
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+	})
+
+	t.Run("lists the tokens of the users a page at a time", func(t *testing.T) {
+		vault := newMockVault(t, "secret", "edgeagent/tokens")
+		s := vaultStore(t, &tokens.VaultConfig{URL: vault.URL, Token: "token"})
+
+		for _, sub := range []string{"carol", "alice", "bob"} {
+			require.NoError(t, s.Save(&tokens.UserTokens{UserSub: sub}))
+		}
+
+		page, next, err := s.Page("", 2)
+		require.NoError(t, err)
+		require.Equal(t, []*tokens.UserTokens{{UserSub: "alice"}, {UserSub: "bob"}}, page)
+
+		page, next, err = s.Page(next, 2)
+		require.NoError(t, err)
+		require.Equal(t, []*tokens.UserTokens{{UserSub: "carol"}}, page)
+		require.Empty(t, next)
+
+		_, _, err = s.Page("***", 2)
+		require.True(t, errors.Is(err, store.ErrInvalidCursor))
+	})
+
+	t.Run("uses the default mount and path", func(t *testing.T) {
+		vault := newMockVault(t, "secret", "edgeagent/tokens")
+		s := vaultStore(t, &tokens.VaultConfig{URL: vault.URL, Token: "token"})
+
+		require.NoError(t, s.Save(&tokens.UserTokens{UserSub: "alice"}))
+
+		_, err := s.Get("alice")
+		require.NoError(t, err)
+	})
+
+	t.Run("not found if the latest version of the secret is deleted", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte(`{"data":{"data":null,"metadata":{"deletion_time":"2026-10-15T00:00:00Z"}}}`))
+			require.NoError(t, err)
+		}))
+		t.Cleanup(server.Close)
+
+		_, err := vaultStore(t, &tokens.VaultConfig{URL: server.URL, Token: "token"}).Get("alice")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if vault fails", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+
+			_, err := w.Write([]byte(`{"errors":["permission denied"]}`))
+			require.NoError(t, err)
+		}))
+		t.Cleanup(server.Close)
+
+		s := vaultStore(t, &tokens.VaultConfig{URL: server.URL, Token: "token"})
+
+		err := s.Save(&tokens.UserTokens{UserSub: "alice"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "vault returned 403: permission denied")
+
+		_, err = s.Get("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch user tokens from vault")
+
+		_, err = s.List()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to list user tokens")
+
+		err = s.Delete("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to delete user tokens from vault")
+	})
+
+	t.Run("error if vault is unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+
+		_, err := vaultStore(t, &tokens.VaultConfig{URL: server.URL, Token: "token"}).Get("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to send request")
+		require.False(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if the config is invalid", func(t *testing.T) {
+		_, err := tokens.NewVaultStore(&tokens.VaultConfig{URL: "vault:8200", Token: "token"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid vault URL 'vault:8200'")
+
+		_, err = tokens.NewVaultStore(&tokens.VaultConfig{URL: "https://vault:8200"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing vault token")
+	})
+}
+
+func vaultStore(t *testing.T, config *tokens.VaultConfig) tokens.Store {
+	t.Helper()
+
+	s, err := tokens.NewVaultStore(config)
+	require.NoError(t, err)
+
+	return s
+}
+
+// mockVault serves the secrets of a path of a KV version 2 secrets engine.
+type mockVault struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	secrets map[string]json.RawMessage
+}
+
+func newMockVault(t *testing.T, mount, path string) *mockVault {
+	t.Helper()
+
+	v := &mockVault{secrets: make(map[string]json.RawMessage)}
+
+	data := "/v1/" + mount + "/data/" + path + "/"
+	metadata := "/v1/" + mount + "/metadata/" + path
+
+	v.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "token", r.Header.Get("X-Vault-Token"))
+
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+
+		switch {
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, data):
+			secret := &struct {
+				Data json.RawMessage `json:"data"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(secret))
+
+			v.secrets[strings.TrimPrefix(r.URL.Path, data)] = secret.Data
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, data):
+			secret, found := v.secrets[strings.TrimPrefix(r.URL.Path, data)]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"data": secret},
+			}))
+		case r.Method == "LIST" && r.URL.Path == metadata:
+			if len(v.secrets) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			keys := []string{"folder/"}
+			for key := range v.secrets {
+				keys = append(keys, key)
+			}
+
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"keys": keys},
+			}))
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, metadata+"/"):
+			delete(v.secrets, strings.TrimPrefix(r.URL.Path, metadata+"/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+
+	t.Cleanup(v.Server.Close)
+
+	return v
+}
@@ -0,0 +1,145 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package user
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+var logger = log.New("edge-agent/user")
+
+const defaultProfileTTL = time.Hour
+
+// ErrNotInDirectory is returned by a Directory without an entry for the user.
+var ErrNotInDirectory = errors.New("user not in directory")
+
+// Directory is the directory of the users of an enterprise, e.g. an LDAP server or a SCIM endpoint, sourcing
+// their profiles.
+type Directory interface {
+	// Profile returns the profile attributes of the user with the given 'sub': Name, GivenName, FamilyName,
+	// Email, Picture and Attributes. The error wraps ErrNotInDirectory if the directory has no entry for the user.
+	Profile(sub string) (*User, error)
+}
+
+// ReadThroughConfig configures a Store resolving the profiles of the users from a Directory.
+type ReadThroughConfig struct {
+	Directory Directory
+	// TTL is how long the profiles are served from the store before the directory is looked up again.
+	// Defaults to 1h.
+	TTL time.Duration
+	// Now reads the time, time.Now if nil.
+	Now func() time.Time
+}
+
+// NewReadThroughStore returns a Store resolving the profile attributes of the Users from the directory when they
+// are first saved, on their first login, and again once the directory was looked up more than the TTL ago. The
+// profiles are cached in the store, along with the fields managed by the agent, which are only ever written to
+// the store. The profiles of the users the directory has no entry for come from the claims of the OIDC
+// provider, and the cached profiles are served while the directory is unavailable.
+func NewReadThroughStore(s Store, config *ReadThroughConfig) Store {
+	r := &readThroughStore{s: s, dir: config.Directory, ttl: config.TTL, now: config.Now}
+
+	if r.ttl <= 0 {
+		r.ttl = defaultProfileTTL
+	}
+
+	if r.now == nil {
+		r.now = time.Now
+	}
+
+	return r
+}
+
+type readThroughStore struct {
+	s   Store
+	dir Directory
+	ttl time.Duration
+	now func() time.Time
+}
+
+// Save keeps the profile of the user resolved from the directory over the one of the OIDC provider merged by the
+// logins, and resolves it if it is stale.
+func (r *readThroughStore) Save(u *User) error {
+	if r.fresh(u) {
+		cached, err := r.s.Get(u.Sub)
+		if err == nil && cached.InDirectory {
+			u.MergeProfile(cached)
+		}
+
+		return r.s.Save(u)
+	}
+
+	err := r.resolve(u)
+	if err != nil {
+		logger.Warnf("failed to resolve the profile of user %s from the directory: %s", u.Sub, err.Error())
+	}
+
+	return r.s.Save(u)
+}
+
+// Get resolves the profile of the user again if it is stale.
+func (r *readThroughStore) Get(sub string) (*User, error) {
+	u, err := r.s.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.fresh(u) {
+		return u, nil
+	}
+
+	err = r.resolve(u)
+	if err != nil {
+		logger.Warnf("failed to resolve the profile of user %s from the directory: %s", sub, err.Error())
+
+		return u, nil
+	}
+
+	err = r.s.Save(u)
+	if err != nil {
+		logger.Warnf("failed to cache the profile of user %s: %s", sub, err.Error())
+	}
+
+	return u, nil
+}
+
+func (r *readThroughStore) Delete(sub string) error {
+	return r.s.Delete(sub)
+}
+
+// Page lists the cached profiles of the users, which are not resolved again.
+func (r *readThroughStore) Page(cursor string, limit int) ([]*User, string, error) {
+	return r.s.Page(cursor, limit)
+}
+
+func (r *readThroughStore) fresh(u *User) bool {
+	return u.ProfileSyncedAt != nil && r.now().Before(u.ProfileSyncedAt.Add(r.ttl))
+}
+
+// resolve merges the profile of the directory into the user, and leaves the profile as is if the directory has
+// no entry for the user.
+func (r *readThroughStore) resolve(u *User) error {
+	profile, err := r.dir.Profile(u.Sub)
+	if err != nil && !errors.Is(err, ErrNotInDirectory) {
+		return fmt.Errorf("failed to get user profile: %w", err)
+	}
+
+	now := r.now().UTC()
+
+	u.InDirectory = err == nil
+	u.ProfileSyncedAt = &now
+
+	if u.InDirectory {
+		u.MergeProfile(profile)
+	}
+
+	return nil
+}
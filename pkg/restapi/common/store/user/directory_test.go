@@ -0,0 +1,159 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package user_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestReadThroughStore(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	setup := func(t *testing.T) (user.Store, user.Store, *mockDirectory) {
+		t.Helper()
+
+		local, err := user.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		dir := &mockDirectory{profiles: map[string]*user.User{
+			"alice": {Name: "Alice Smith", Email: "alice@example.com", Attributes: map[string]interface{}{"title": "CFO"}},
+		}}
+
+		return user.NewReadThroughStore(local, &user.ReadThroughConfig{
+			Directory: dir,
+			TTL:       time.Hour,
+			Now:       func() time.Time { return now },
+		}), local, dir
+	}
+
+	t.Run("resolves the profile on the first save and keeps the agent fields local", func(t *testing.T) {
+		s, local, dir := setup(t)
+
+		require.NoError(t, s.Save(&user.User{Sub: "alice", Name: "alice", SecretShare: "share"}))
+		require.Equal(t, 1, dir.calls)
+
+		saved, err := local.Get("alice")
+		require.NoError(t, err)
+		require.Equal(t, "Alice Smith", saved.Name)
+		require.Equal(t, "alice@example.com", saved.Email)
+		require.Equal(t, "CFO", saved.Attributes["title"])
+		require.Equal(t, "share", saved.SecretShare)
+		require.True(t, saved.InDirectory)
+		require.Equal(t, now, *saved.ProfileSyncedAt)
+
+		// the claims of the OIDC provider merged by a later login do not override the directory
+		saved.Name = "alice"
+		saved.Consent = &user.Consent{Version: "v1"}
+		require.NoError(t, s.Save(saved))
+		require.Equal(t, 1, dir.calls)
+
+		got, err := s.Get("alice")
+		require.NoError(t, err)
+		require.Equal(t, "Alice Smith", got.Name)
+		require.Equal(t, "v1", got.Consent.Version)
+		require.Equal(t, 1, dir.calls)
+	})
+
+	t.Run("resolves the profile again once stale", func(t *testing.T) {
+		s, local, dir := setup(t)
+
+		require.NoError(t, s.Save(&user.User{Sub: "alice"}))
+
+		now = now.Add(2 * time.Hour)
+		dir.profiles["alice"] = &user.User{Name: "Alice Jones"}
+
+		got, err := s.Get("alice")
+		require.NoError(t, err)
+		require.Equal(t, "Alice Jones", got.Name)
+		require.Equal(t, 2, dir.calls)
+
+		cached, err := local.Get("alice")
+		require.NoError(t, err)
+		require.Equal(t, "Alice Jones", cached.Name)
+		require.Equal(t, now, *cached.ProfileSyncedAt)
+	})
+
+	t.Run("serves the cached profile while the directory is unavailable", func(t *testing.T) {
+		s, _, dir := setup(t)
+
+		require.NoError(t, s.Save(&user.User{Sub: "alice"}))
+
+		now = now.Add(2 * time.Hour)
+		dir.err = errors.New("unavailable")
+
+		got, err := s.Get("alice")
+		require.NoError(t, err)
+		require.Equal(t, "Alice Smith", got.Name)
+
+		require.NoError(t, s.Save(&user.User{Sub: "bob", Name: "Bob"}))
+
+		got, err = s.Get("bob")
+		require.NoError(t, err)
+		require.Equal(t, "Bob", got.Name)
+		require.Nil(t, got.ProfileSyncedAt)
+	})
+
+	t.Run("keeps the claims of the users not in the directory", func(t *testing.T) {
+		s, _, dir := setup(t)
+
+		require.NoError(t, s.Save(&user.User{Sub: "bob", Name: "Bob"}))
+
+		usr, err := s.Get("bob")
+		require.NoError(t, err)
+		require.Equal(t, "Bob", usr.Name)
+		require.False(t, usr.InDirectory)
+		require.NotNil(t, usr.ProfileSyncedAt)
+
+		usr.Name = "Robert"
+		require.NoError(t, s.Save(usr))
+
+		usr, err = s.Get("bob")
+		require.NoError(t, err)
+		require.Equal(t, "Robert", usr.Name)
+		require.Equal(t, 1, dir.calls)
+	})
+
+	t.Run("deletes the users", func(t *testing.T) {
+		s, _, _ := setup(t)
+
+		require.NoError(t, s.Save(&user.User{Sub: "alice"}))
+		require.NoError(t, s.Delete("alice"))
+
+		_, err := s.Get("alice")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+}
+
+type mockDirectory struct {
+	profiles map[string]*user.User
+	err      error
+	calls    int
+}
+
+func (m *mockDirectory) Profile(sub string) (*user.User, error) {
+	m.calls++
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	profile, ok := m.profiles[sub]
+	if !ok {
+		return nil, fmt.Errorf("no entry: %w", user.ErrNotInDirectory)
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package user
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultLDAPFilter  = "(uid=%s)"
+	defaultLDAPTimeout = 10 * time.Second
+)
+
+// LDAPConfig configures a Directory looking the users up in an LDAP server.
+type LDAPConfig struct {
+	// URL of the server, e.g. ldaps://ldap.example.com:636.
+	URL string
+	// BindDN and BindPassword authenticate the agent to the server. The agent binds anonymously if BindDN is empty.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the base of the search of the users, e.g. ou=people,dc=example,dc=com.
+	BaseDN string
+	// Filter finds the entry of the user, with %s replaced with the escaped 'sub' of the user. Defaults to
+	// (uid=%s).
+	Filter string
+	// Attributes maps the claims of the profile to the LDAP attributes they are read from. Defaults to cn, givenName,
+	// sn and mail for the name, given_name, family_name and email claims. The claims other than the standard ones
+	// go to the Attributes of the User.
+	Attributes map[string]string
+	TLSConfig  *tls.Config
+	// Timeout of each lookup. Defaults to 10s.
+	Timeout time.Duration
+}
+
+// NewLDAPDirectory returns a Directory looking the users up in the LDAP server, connecting to it on each lookup.
+func NewLDAPDirectory(config *LDAPConfig) (Directory, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("invalid LDAP URL '%s'", config.URL)
+	}
+
+	if config.BaseDN == "" {
+		return nil, errors.New("missing LDAP base DN")
+	}
+
+	d := &ldapDirectory{
+		config:     *config,
+		attributes: map[string]string{"name": "cn", "given_name": "givenName", "family_name": "sn", "email": "mail"},
+	}
+
+	if d.config.Filter == "" {
+		d.config.Filter = defaultLDAPFilter
+	}
+
+	if strings.Count(d.config.Filter, "%s") != 1 {
+		return nil, fmt.Errorf("invalid LDAP filter '%s': must hold one %%s", d.config.Filter)
+	}
+
+	if d.config.Timeout <= 0 {
+		d.config.Timeout = defaultLDAPTimeout
+	}
+
+	for claim, attribute := range config.Attributes {
+		d.attributes[claim] = attribute
+	}
+
+	d.dial = func() (ldapConn, error) {
+		return ldap.DialURL(d.config.URL,
+			ldap.DialWithDialer(&net.Dialer{Timeout: d.config.Timeout}),
+			ldap.DialWithTLSConfig(d.config.TLSConfig))
+	}
+
+	return d, nil
+}
+
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	SetTimeout(timeout time.Duration)
+	Close()
+}
+
+type ldapDirectory struct {
+	config     LDAPConfig
+	attributes map[string]string
+	dial       func() (ldapConn, error)
+}
+
+func (d *ldapDirectory) Profile(sub string) (*User, error) {
+	conn, err := d.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+
+	defer conn.Close()
+
+	conn.SetTimeout(d.config.Timeout)
+
+	if d.config.BindDN != "" {
+		err = conn.Bind(d.config.BindDN, d.config.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind to LDAP server: %w", err)
+		}
+	}
+
+	attributes := make([]string, 0, len(d.attributes))
+
+	for _, attribute := range d.attributes {
+		attributes = append(attributes, attribute)
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(d.config.Timeout/time.Second), false,
+		fmt.Sprintf(d.config.Filter, ldap.EscapeFilter(sub)),
+		attributes, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("more than one LDAP entry for user %s", sub)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to search LDAP server: %w", err)
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, fmt.Errorf("no LDAP entry for user %s: %w", sub, ErrNotInDirectory)
+	case 1:
+	default:
+		return nil, fmt.Errorf("more than one LDAP entry for user %s", sub)
+	}
+
+	claims := map[string]interface{}{}
+
+	for claim, attribute := range d.attributes {
+		if value := result.Entries[0].GetAttributeValue(attribute); value != "" {
+			claims[claim] = value
+		}
+	}
+
+	return profileOf(sub, claims), nil
+}
+
+// profileOf returns the profile of the user with the claims, the claims other than the standard ones going to its
+// Attributes.
+func profileOf(sub string, claims map[string]interface{}) *User {
+	profile := &User{Sub: sub}
+
+	fields := map[string]*string{
+		"name":        &profile.Name,
+		"given_name":  &profile.GivenName,
+		"family_name": &profile.FamilyName,
+		"email":       &profile.Email,
+		"picture":     &profile.Picture,
+	}
+
+	for claim, value := range claims {
+		if field, ok := fields[claim]; ok {
+			*field, _ = value.(string)
+
+			continue
+		}
+
+		if profile.Attributes == nil {
+			profile.Attributes = map[string]interface{}{}
+		}
+
+		profile.Attributes[claim] = value
+	}
+
+	return profile
+}
@@ -0,0 +1,137 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package user // nolint:testpackage // the LDAP connection is faked
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLDAPDirectory(t *testing.T) {
+	alice := ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+		"cn":        {"Alice Smith"},
+		"givenName": {"Alice"},
+		"sn":        {"Smith"},
+		"mail":      {"alice@example.com"},
+		"title":     {"CFO"},
+	})
+
+	t.Run("resolves the profile of the user", func(t *testing.T) {
+		conn := &mockLDAPConn{entries: []*ldap.Entry{alice}}
+		dir := ldapDirectoryOf(t, &LDAPConfig{BindDN: "cn=agent", BindPassword: "secret",
+			Attributes: map[string]string{"job": "title"}}, conn)
+
+		profile, err := dir.Profile("alice*")
+		require.NoError(t, err)
+		require.Equal(t, &User{
+			Sub:        "alice*",
+			Name:       "Alice Smith",
+			GivenName:  "Alice",
+			FamilyName: "Smith",
+			Email:      "alice@example.com",
+			Attributes: map[string]interface{}{"job": "CFO"},
+		}, profile)
+
+		require.Equal(t, []string{"cn=agent", "secret"}, conn.bound)
+		require.Equal(t, `(uid=alice\2a)`, conn.request.Filter)
+		require.Equal(t, "ou=people,dc=example,dc=com", conn.request.BaseDN)
+		require.ElementsMatch(t, []string{"cn", "givenName", "sn", "mail", "title"}, conn.request.Attributes)
+		require.True(t, conn.closed)
+	})
+
+	t.Run("error if the user is not in the directory", func(t *testing.T) {
+		_, err := ldapDirectoryOf(t, &LDAPConfig{}, &mockLDAPConn{}).Profile("alice")
+		require.True(t, errors.Is(err, ErrNotInDirectory))
+	})
+
+	t.Run("error if the directory fails", func(t *testing.T) {
+		for _, conn := range []*mockLDAPConn{
+			{entries: []*ldap.Entry{alice, alice}},
+			{searchErr: ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("size limit"))},
+			{searchErr: errors.New("test")},
+			{bindErr: errors.New("test")},
+		} {
+			_, err := ldapDirectoryOf(t, &LDAPConfig{BindDN: "cn=agent"}, conn).Profile("alice")
+			require.Error(t, err)
+			require.False(t, errors.Is(err, ErrNotInDirectory))
+		}
+
+		dir := ldapDirectoryOf(t, &LDAPConfig{}, nil)
+		dir.dial = func() (ldapConn, error) { return nil, errors.New("test") }
+
+		_, err := dir.Profile("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to connect to LDAP server")
+	})
+
+	t.Run("error if the server is unreachable", func(t *testing.T) {
+		dir, err := NewLDAPDirectory(&LDAPConfig{URL: "ldap://127.0.0.1:1", BaseDN: "dc=example", Timeout: time.Second})
+		require.NoError(t, err)
+
+		_, err = dir.Profile("alice")
+		require.Error(t, err)
+	})
+
+	t.Run("error if the config is invalid", func(t *testing.T) {
+		for _, config := range []*LDAPConfig{
+			{URL: "https://ldap.example.com", BaseDN: "dc=example"},
+			{URL: "ldap://ldap.example.com"},
+			{URL: "ldap://ldap.example.com", BaseDN: "dc=example", Filter: "(uid=alice)"},
+		} {
+			_, err := NewLDAPDirectory(config)
+			require.Error(t, err)
+		}
+	})
+}
+
+func ldapDirectoryOf(t *testing.T, config *LDAPConfig, conn *mockLDAPConn) *ldapDirectory {
+	t.Helper()
+
+	config.URL = "ldaps://ldap.example.com"
+	config.BaseDN = "ou=people,dc=example,dc=com"
+
+	dir, err := NewLDAPDirectory(config)
+	require.NoError(t, err)
+
+	d, ok := dir.(*ldapDirectory)
+	require.True(t, ok)
+
+	d.dial = func() (ldapConn, error) { return conn, nil }
+
+	return d
+}
+
+type mockLDAPConn struct {
+	entries   []*ldap.Entry
+	bindErr   error
+	searchErr error
+	bound     []string
+	request   *ldap.SearchRequest
+	closed    bool
+}
+
+func (m *mockLDAPConn) Bind(username, password string) error {
+	m.bound = []string{username, password}
+
+	return m.bindErr
+}
+
+func (m *mockLDAPConn) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	m.request = request
+
+	return &ldap.SearchResult{Entries: m.entries}, m.searchErr
+}
+
+func (m *mockLDAPConn) SetTimeout(time.Duration) {}
+
+func (m *mockLDAPConn) Close() {
+	m.closed = true
+}
@@ -0,0 +1,205 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	defaultSCIMFilter = "userName eq %s"
+	scimContentType   = "application/scim+json"
+)
+
+// SCIMConfig configures a Directory looking the users up in a SCIM 2.0 service provider (RFC 7644).
+type SCIMConfig struct {
+	// URL of the service provider, e.g. https://scim.example.com/scim/v2. The users are looked up at its /Users
+	// endpoint.
+	URL string
+	// Token is the bearer token authenticating the agent to the service provider.
+	Token string
+	// Filter finds the resource of the user, with %s replaced with the quoted 'sub' of the user. Defaults to
+	// userName eq %s.
+	Filter string
+	// Attributes maps claims to the attributes of the SCIM users they are read from, in addition to the standard
+	// name, given_name, family_name, email and picture claims, e.g. enterprise to
+	// urn:ietf:params:scim:schemas:extension:enterprise:2.0:User. They go to the Attributes of the Users.
+	Attributes map[string]string
+	HTTPClient *http.Client
+}
+
+// NewSCIMDirectory returns a Directory looking the users up in the SCIM service provider.
+func NewSCIMDirectory(config *SCIMConfig) (Directory, error) {
+	base, err := url.Parse(config.URL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid SCIM URL '%s'", config.URL)
+	}
+
+	d := &scimDirectory{
+		usersURL:   strings.TrimSuffix(config.URL, "/") + "/Users",
+		token:      config.Token,
+		filter:     config.Filter,
+		attributes: config.Attributes,
+		httpClient: config.HTTPClient,
+	}
+
+	if d.filter == "" {
+		d.filter = defaultSCIMFilter
+	}
+
+	if strings.Count(d.filter, "%s") != 1 {
+		return nil, fmt.Errorf("invalid SCIM filter '%s': must hold one %%s", d.filter)
+	}
+
+	if d.httpClient == nil {
+		d.httpClient = http.DefaultClient
+	}
+
+	return d, nil
+}
+
+type scimDirectory struct {
+	usersURL   string
+	token      string
+	filter     string
+	attributes map[string]string
+	httpClient *http.Client
+}
+
+type scimListResponse struct {
+	TotalResults int               `json:"totalResults"`
+	Resources    []json.RawMessage `json:"Resources"`
+}
+
+type scimUser struct {
+	DisplayName string `json:"displayName"`
+	Name        struct {
+		Formatted  string `json:"formatted"`
+		GivenName  string `json:"givenName"`
+		FamilyName string `json:"familyName"`
+	} `json:"name"`
+	Emails []scimValue `json:"emails"`
+	Photos []scimValue `json:"photos"`
+}
+
+type scimValue struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary"`
+}
+
+func (d *scimDirectory) Profile(sub string) (*User, error) {
+	quoted, err := json.Marshal(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to quote user sub: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet,
+		d.usersURL+"?filter="+url.QueryEscape(fmt.Sprintf(d.filter, quoted)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SCIM request: %w", err)
+	}
+
+	req.Header.Set("Accept", scimContentType)
+
+	if d.token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send SCIM request: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Warnf("failed to close SCIM response body: %s", errClose.Error())
+		}
+	}()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SCIM response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("SCIM request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	list := &scimListResponse{}
+
+	err = json.Unmarshal(body, list)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SCIM response: %w", err)
+	}
+
+	switch len(list.Resources) {
+	case 0:
+		return nil, fmt.Errorf("no SCIM resource for user %s: %w", sub, ErrNotInDirectory)
+	case 1:
+	default:
+		return nil, errors.New("more than one SCIM resource for user " + sub)
+	}
+
+	return d.profileOf(sub, list.Resources[0])
+}
+
+func (d *scimDirectory) profileOf(sub string, resource json.RawMessage) (*User, error) {
+	u := &scimUser{}
+
+	err := json.Unmarshal(resource, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SCIM user: %w", err)
+	}
+
+	all := map[string]interface{}{}
+
+	err = json.Unmarshal(resource, &all)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SCIM user: %w", err)
+	}
+
+	claims := map[string]interface{}{
+		"name":        u.Name.Formatted,
+		"given_name":  u.Name.GivenName,
+		"family_name": u.Name.FamilyName,
+		"email":       primary(u.Emails),
+		"picture":     primary(u.Photos),
+	}
+
+	if u.Name.Formatted == "" {
+		claims["name"] = u.DisplayName
+	}
+
+	for claim, attribute := range d.attributes {
+		if value, ok := all[attribute]; ok {
+			claims[claim] = value
+		}
+	}
+
+	return profileOf(sub, claims), nil
+}
+
+// primary returns the primary value of the multi-valued attribute, its first value if none is primary.
+func primary(values []scimValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+
+	if len(values) > 0 {
+		return values[0].Value
+	}
+
+	return ""
+}
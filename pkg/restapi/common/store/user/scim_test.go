@@ -0,0 +1,112 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package user_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+const scimAlice = `{
+	"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+	"userName": "alice",
+	"displayName": "Alice",
+	"name": {"formatted": "Alice Smith", "givenName": "Alice", "familyName": "Smith"},
+	"emails": [{"value": "alice@home.example.com"}, {"value": "alice@example.com", "primary": true}],
+	"photos": [{"value": "https://example.com/alice.png"}],
+	"title": "CFO"
+}`
+
+func TestSCIMDirectory(t *testing.T) {
+	t.Run("resolves the profile of the user", func(t *testing.T) {
+		var filter, auth string
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/scim/v2/Users", r.URL.Path)
+			filter = r.URL.Query().Get("filter")
+			auth = r.Header.Get("Authorization")
+
+			fmt.Fprintf(w, `{"totalResults": 1, "Resources": [%s]}`, scimAlice)
+		}))
+		defer srv.Close()
+
+		dir, err := user.NewSCIMDirectory(&user.SCIMConfig{
+			URL:        srv.URL + "/scim/v2/",
+			Token:      "token",
+			Attributes: map[string]string{"job": "title"},
+		})
+		require.NoError(t, err)
+
+		profile, err := dir.Profile(`alice"`)
+		require.NoError(t, err)
+		require.Equal(t, `userName eq "alice\""`, filter)
+		require.Equal(t, "Bearer token", auth)
+		require.Equal(t, &user.User{
+			Sub:        `alice"`,
+			Name:       "Alice Smith",
+			GivenName:  "Alice",
+			FamilyName: "Smith",
+			Email:      "alice@example.com",
+			Picture:    "https://example.com/alice.png",
+			Attributes: map[string]interface{}{"job": "CFO"},
+		}, profile)
+	})
+
+	t.Run("error if the user is not in the directory", func(t *testing.T) {
+		dir := scimDirectory(t, http.StatusOK, `{"totalResults": 0, "Resources": []}`)
+
+		_, err := dir.Profile("alice")
+		require.True(t, errors.Is(err, user.ErrNotInDirectory))
+	})
+
+	t.Run("error if the directory fails", func(t *testing.T) {
+		for status, body := range map[int]string{
+			http.StatusUnauthorized: `{"detail": "unauthorized"}`,
+			http.StatusOK:           `{"Resources": [{}, {}]}`,
+		} {
+			_, err := scimDirectory(t, status, body).Profile("alice")
+			require.Error(t, err)
+			require.False(t, errors.Is(err, user.ErrNotInDirectory))
+		}
+
+		_, err := scimDirectory(t, http.StatusOK, `{`).Profile("alice")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse SCIM response")
+	})
+
+	t.Run("error if the config is invalid", func(t *testing.T) {
+		_, err := user.NewSCIMDirectory(&user.SCIMConfig{URL: "scim"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid SCIM URL")
+
+		_, err = user.NewSCIMDirectory(&user.SCIMConfig{URL: "https://scim.example.com", Filter: "userName eq alice"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid SCIM filter")
+	})
+}
+
+func scimDirectory(t *testing.T, status int, body string) user.Directory {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	dir, err := user.NewSCIMDirectory(&user.SCIMConfig{URL: srv.URL})
+	require.NoError(t, err)
+
+	return dir
+}
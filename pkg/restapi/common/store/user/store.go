@@ -8,10 +8,15 @@ package user
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/escrow"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
 	"github.com/trustbloc/edge-core/pkg/storage"
 )
 
@@ -24,12 +29,47 @@ const (
 // The user attributes are based on standard OIDC claims:
 // https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims.
 type User struct {
-	Sub         string `json:"sub"`
-	Name        string `json:"name"`
-	GivenName   string `json:"given_name"`
-	FamilyName  string `json:"family_name"`
-	Email       string `json:"email"`
-	SecretShare string `json:"secretShare"`
+	Sub        string `json:"sub"`
+	Name       string `json:"name"`
+	GivenName  string `json:"given_name"`
+	FamilyName string `json:"family_name"`
+	Email      string `json:"email"`
+	Picture    string `json:"picture,omitempty"`
+	// Issuer of the ID token the user last logged in with.
+	Issuer      string                 `json:"iss,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	SecretShare string                 `json:"secretShare"`
+	Consent     *Consent               `json:"consent,omitempty"`
+	// Escrow tells which escrows hold the shares of the secret share, if it is escrowed.
+	Escrow *escrow.Record `json:"escrow,omitempty"`
+	// Deactivated users are logged out and cannot log in again until they are activated, e.g. by the
+	// provisioning of their enterprise.
+	Deactivated bool `json:"deactivated,omitempty"`
+	// InDirectory tells whether the profile attributes come from the directory of the users rather than from the
+	// claims of the OIDC provider, and ProfileSyncedAt when the directory was last looked up. See
+	// NewReadThroughStore.
+	InDirectory     bool       `json:"inDirectory,omitempty"`
+	ProfileSyncedAt *time.Time `json:"profileSyncedAt,omitempty"`
+	// Revision of the user, advanced by each save. A user read at an older revision than the stored one cannot be
+	// saved, so that the instances of the agent do not overwrite each other's updates.
+	Revision int `json:"revision,omitempty"`
+}
+
+// Consent is the user's acceptance of the terms of service and privacy policy.
+type Consent struct {
+	Version    string    `json:"version"`
+	AcceptedAt time.Time `json:"acceptedAt"`
+}
+
+// MergeProfile copies the profile attributes of 'p' into this user. Fields managed by the agent (such as
+// the secret share and the consent) are left untouched.
+func (u *User) MergeProfile(p *User) {
+	u.Name = p.Name
+	u.GivenName = p.GivenName
+	u.FamilyName = p.FamilyName
+	u.Email = p.Email
+	u.Picture = p.Picture
+	u.Attributes = p.Attributes
 }
 
 // ParseIDToken parses a User from an IDToken.
@@ -58,34 +98,174 @@ func evaluateClaims(u *User) error {
 	return nil
 }
 
-// NewStore returns a new user Store.
-func NewStore(p storage.Provider) (*Store, error) {
+// ErrConflict is returned when saving a user that was saved since it was read. The user is to be read again and
+// updated anew.
+var ErrConflict = errors.New("user saved concurrently")
+
+// Store stores Users.
+type Store interface {
+	// Save the user with the user's 'sub' as the key, and advance its revision. The error wraps ErrConflict if the
+	// user was saved since it was read.
+	Save(u *User) error
+	// Get the User with the given 'sub'. The error wraps storage.ErrValueNotFound if there is none.
+	Get(sub string) (*User, error)
+	// Delete the User with the given 'sub'.
+	Delete(sub string) error
+	// Page returns up to limit Users following the cursor, and the cursor of the next page. See store.Page.
+	Page(cursor string, limit int) ([]*User, string, error)
+}
+
+// Option configures the Store returned by NewStore.
+type Option func(*providerStore)
+
+// WithPseudonyms keys the users with their pseudonymous IDs rather than their subs, which are not saved.
+func WithPseudonyms(ids *pseudonym.Mapper) Option {
+	return func(s *providerStore) {
+		s.ids = ids
+	}
+}
+
+// NewStore returns a new user Store keeping the Users in the storage provider.
+func NewStore(p storage.Provider, opts ...Option) (Store, error) {
 	s, err := store.Open(p, StoreName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open users store: %w", err)
 	}
 
-	return &Store{s: s}, nil
+	users := &providerStore{s: s}
+
+	for _, opt := range opts {
+		opt(users)
+	}
+
+	return users, nil
 }
 
-// Store stores Users.
-type Store struct {
-	s storage.Store
+type providerStore struct {
+	s   storage.Store
+	ids *pseudonym.Mapper
+	// serializes the saves of this instance. The store has no compare-and-set: the instances saving a user at once
+	// may both pass the revision check, which only narrows the window of the lost updates across instances.
+	mutex sync.Mutex
+}
+
+// Save this user with the user's 'sub', or its pseudonymous ID, as the key, unless it was saved since it was read.
+func (s *providerStore) Save(u *User) error {
+	key := u.Sub
+
+	if s.ids != nil {
+		var err error
+
+		key, err = s.ids.Register(u.Sub)
+		if err != nil {
+			return fmt.Errorf("failed to register user id: %w", err)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	revision, err := s.revision(key)
+	if err != nil {
+		return err
+	}
+
+	if revision != u.Revision {
+		return fmt.Errorf("user at revision %d, saved at revision %d: %w", u.Revision, revision, ErrConflict)
+	}
+
+	saved := *u
+	saved.Revision++
+
+	if s.ids != nil {
+		saved.Sub = ""
+	}
+
+	err = store.Save(s.s, key, &saved)
+	if err != nil {
+		return err
+	}
+
+	u.Revision = saved.Revision
+
+	return nil
 }
 
-// Save this user with the user's 'sub' as the key.
-func (s *Store) Save(u *User) error {
-	return store.Save(s.s, u.Sub, u)
+// revision returns the revision of the stored user, 0 if there is none.
+func (s *providerStore) revision(key string) (int, error) {
+	bits, err := s.s.Get(key)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch user from store: %w", err)
+	}
+
+	stored := &struct {
+		Revision int `json:"revision"`
+	}{}
+
+	err = json.Unmarshal(bits, stored)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+
+	return stored.Revision, nil
 }
 
 // Get the User with the given 'sub'.
-func (s *Store) Get(sub string) (*User, error) {
-	bits, err := s.s.Get(sub)
+func (s *providerStore) Get(sub string) (*User, error) {
+	bits, err := s.s.Get(s.ids.ID(sub))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch user from store: %w", err)
 	}
 
 	user := &User{}
 
-	return user, json.Unmarshal(bits, user)
+	err = json.Unmarshal(bits, user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Sub = sub
+
+	return user, nil
+}
+
+// Delete the User with the given 'sub'.
+func (s *providerStore) Delete(sub string) error {
+	err := s.s.Delete(s.ids.ID(sub))
+	if err != nil {
+		return fmt.Errorf("failed to delete user from store: %w", err)
+	}
+
+	return nil
+}
+
+// Page returns the Users following the cursor, in the order of their keys.
+func (s *providerStore) Page(cursor string, limit int) ([]*User, string, error) {
+	records, next, err := store.Page(s.s, cursor, limit)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list users: %w", err)
+	}
+
+	page := make([]*User, len(records))
+
+	for i, r := range records {
+		page[i] = &User{}
+
+		err = json.Unmarshal(r.Value, page[i])
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to unmarshal user: %w", err)
+		}
+
+		// the users keyed with their pseudonymous IDs are saved without sub
+		page[i].Sub, err = s.ids.Sub(r.Key)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to look up user sub: %w", err)
+		}
+	}
+
+	return page, next, nil
 }
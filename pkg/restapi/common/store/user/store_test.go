@@ -0,0 +1,208 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package user_test
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestStore_Save(t *testing.T) {
+	t.Run("advances the revision of the user", func(t *testing.T) {
+		s, err := user.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		usr := &user.User{Sub: "alice", Name: "Alice"}
+		require.NoError(t, s.Save(usr))
+		require.Equal(t, 1, usr.Revision)
+
+		usr.Name = "Alice Smith"
+		require.NoError(t, s.Save(usr))
+		require.Equal(t, 2, usr.Revision)
+
+		saved, err := s.Get("alice")
+		require.NoError(t, err)
+		require.Equal(t, "Alice Smith", saved.Name)
+		require.Equal(t, 2, saved.Revision)
+	})
+
+	t.Run("error if the user was saved since it was read", func(t *testing.T) {
+		s, err := user.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		require.NoError(t, s.Save(&user.User{Sub: "alice"}))
+
+		first, err := s.Get("alice")
+		require.NoError(t, err)
+
+		second, err := s.Get("alice")
+		require.NoError(t, err)
+
+		first.Name = "Alice"
+		require.NoError(t, s.Save(first))
+
+		second.Email = "alice@example.com"
+		err = s.Save(second)
+		require.True(t, errors.Is(err, user.ErrConflict))
+		require.Equal(t, 1, second.Revision)
+
+		saved, err := s.Get("alice")
+		require.NoError(t, err)
+		require.Equal(t, "Alice", saved.Name)
+		require.Empty(t, saved.Email)
+
+		// a new user does not overwrite the user onboarded by another instance
+		err = s.Save(&user.User{Sub: "alice"})
+		require.True(t, errors.Is(err, user.ErrConflict))
+	})
+
+	t.Run("saves the users saved without revision", func(t *testing.T) {
+		provider := memstore.NewProvider()
+
+		s, err := user.NewStore(provider)
+		require.NoError(t, err)
+
+		store, err := provider.OpenStore(user.StoreName)
+		require.NoError(t, err)
+		require.NoError(t, store.Put("alice", []byte(`{"sub":"alice","name":"Alice"}`)))
+
+		usr, err := s.Get("alice")
+		require.NoError(t, err)
+		require.Zero(t, usr.Revision)
+		require.NoError(t, s.Save(usr))
+		require.Equal(t, 1, usr.Revision)
+	})
+
+	t.Run("checks the revision of the users keyed by pseudonymous ID", func(t *testing.T) {
+		provider := memstore.NewProvider()
+
+		ids, err := pseudonym.New(&pseudonym.Config{Storage: provider, HMACKey: key(t), EncryptionKey: key(t)})
+		require.NoError(t, err)
+
+		s, err := user.NewStore(provider, user.WithPseudonyms(ids))
+		require.NoError(t, err)
+
+		usr := &user.User{Sub: "alice"}
+		require.NoError(t, s.Save(usr))
+		require.Equal(t, "alice", usr.Sub)
+
+		err = s.Save(&user.User{Sub: "alice"})
+		require.True(t, errors.Is(err, user.ErrConflict))
+
+		saved, err := s.Get("alice")
+		require.NoError(t, err)
+		require.Equal(t, 1, saved.Revision)
+	})
+
+	t.Run("error if the stored user cannot be read", func(t *testing.T) {
+		s, err := user.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{"alice": []byte("{}")},
+			ErrGet: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		err = s.Save(&user.User{Sub: "alice"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch user from store")
+
+		s, err = user.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{"alice": []byte("{")},
+		}})
+		require.NoError(t, err)
+
+		err = s.Save(&user.User{Sub: "alice"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal user")
+
+		s, err = user.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{},
+			ErrPut: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		usr := &user.User{Sub: "alice"}
+		require.Error(t, s.Save(usr))
+		require.Zero(t, usr.Revision)
+	})
+}
+
+func TestStore_Page(t *testing.T) {
+	t.Run("lists the users a page at a time", func(t *testing.T) {
+		s, err := user.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		for _, sub := range []string{"bob", "alice", "carol"} {
+			require.NoError(t, s.Save(&user.User{Sub: sub}))
+		}
+
+		page, next, err := s.Page("", 2)
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		require.Equal(t, "alice", page[0].Sub)
+		require.Equal(t, "bob", page[1].Sub)
+
+		page, next, err = s.Page(next, 2)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, "carol", page[0].Sub)
+		require.Empty(t, next)
+	})
+
+	t.Run("lists the subs of the users keyed by pseudonymous ID", func(t *testing.T) {
+		provider := memstore.NewProvider()
+
+		ids, err := pseudonym.New(&pseudonym.Config{Storage: provider, HMACKey: key(t), EncryptionKey: key(t)})
+		require.NoError(t, err)
+
+		s, err := user.NewStore(provider, user.WithPseudonyms(ids))
+		require.NoError(t, err)
+
+		require.NoError(t, s.Save(&user.User{Sub: "alice"}))
+
+		page, _, err := s.Page("", 10)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, "alice", page[0].Sub)
+	})
+
+	t.Run("error if the stored user cannot be read", func(t *testing.T) {
+		s, err := user.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{"alice": []byte("{")},
+		}})
+		require.NoError(t, err)
+
+		_, _, err = s.Page("", 10)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to unmarshal user")
+
+		s, err = user.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{ErrGetAll: errors.New("test")}})
+		require.NoError(t, err)
+
+		_, _, err = s.Page("", 10)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to list users")
+	})
+}
+
+func key(t *testing.T) []byte {
+	t.Helper()
+
+	k := make([]byte, 32)
+
+	_, err := rand.Read(k)
+	require.NoError(t, err)
+
+	return k
+}
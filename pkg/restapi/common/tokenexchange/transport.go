@@ -0,0 +1,176 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tokenexchange
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	bearerScheme = "Bearer "
+	// exchanged tokens are renewed ahead of their expiry, so that they do not expire on their way to the service.
+	expiryMargin = 30 * time.Second
+	// the cache is swept of the expired tokens once it holds so many tokens.
+	sweepThreshold = 1000
+)
+
+// Exchanger trades a token for a token of the audience with the token exchange grant (RFC 8693).
+type Exchanger interface {
+	ExchangeToken(ctx context.Context, subjectToken, audience string) (*oauth2.Token, error)
+}
+
+// Service is the audience of the tokens of a service.
+type Service struct {
+	Audience string
+	// Encoded tells that the service takes the bearer tokens base64-encoded, like hub-auth and hub-kms.
+	Encoded bool
+}
+
+// Services are the services taking exchanged tokens, keyed by their base URL.
+type Services map[string]*Service
+
+// match returns the service with the longest base URL prefixing the request URL.
+func (s Services) match(r *http.Request) *Service {
+	target := r.URL.String()
+
+	var (
+		matched *Service
+		longest int
+	)
+
+	for baseURL, service := range s {
+		if len(baseURL) > longest && strings.HasPrefix(target, baseURL) {
+			matched = service
+			longest = len(baseURL)
+		}
+	}
+
+	return matched
+}
+
+// Transport is an http.RoundTripper replacing the bearer token of the requests to the services with a token of
+// their audience, for providers issuing opaque access tokens the services cannot validate. The exchanged tokens
+// are cached until they expire. The other requests are sent as is.
+type Transport struct {
+	base      http.RoundTripper
+	exchanger Exchanger
+	services  Services
+	mutex     sync.Mutex
+	cache     map[string]*oauth2.Token
+}
+
+// NewTransport returns a Transport sending the requests through base, or through http.DefaultTransport if nil.
+func NewTransport(base http.RoundTripper, exchanger Exchanger, services Services) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{base: base, exchanger: exchanger, services: services, cache: make(map[string]*oauth2.Token)}
+}
+
+// RoundTrip exchanges the bearer token of the request and sends it.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	service := t.services.match(r)
+	if service == nil {
+		return t.base.RoundTrip(r)
+	}
+
+	token, found := bearerToken(r, service.Encoded)
+	if !found {
+		return t.base.RoundTrip(r)
+	}
+
+	exchanged, err := t.token(r.Context(), token, service.Audience)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange for %s: %w", r.URL.String(), err)
+	}
+
+	if service.Encoded {
+		exchanged = base64.StdEncoding.EncodeToString([]byte(exchanged))
+	}
+
+	// a round tripper must not modify the request it is given
+	authorized := r.Clone(r.Context())
+	authorized.Header.Set("Authorization", bearerScheme+exchanged)
+
+	return t.base.RoundTrip(authorized)
+}
+
+// token returns the cached token of the audience for the token, or exchanges the token.
+func (t *Transport) token(ctx context.Context, token, audience string) (string, error) {
+	hash := sha256.Sum256([]byte(token))
+	key := audience + " " + base64.RawURLEncoding.EncodeToString(hash[:])
+
+	t.mutex.Lock()
+	cached, found := t.cache[key]
+	t.mutex.Unlock()
+
+	if found && fresh(cached) {
+		return cached.AccessToken, nil
+	}
+
+	exchanged, err := t.exchanger.ExchangeToken(ctx, token, audience)
+	if err != nil {
+		return "", err
+	}
+
+	// the tokens without an expiry are exchanged for each request
+	if fresh(exchanged) {
+		t.mutex.Lock()
+		t.cache[key] = exchanged
+
+		if len(t.cache) >= sweepThreshold {
+			t.sweep()
+		}
+
+		t.mutex.Unlock()
+	}
+
+	return exchanged.AccessToken, nil
+}
+
+func (t *Transport) sweep() {
+	for key, token := range t.cache {
+		if !fresh(token) {
+			delete(t.cache, key)
+		}
+	}
+}
+
+func fresh(token *oauth2.Token) bool {
+	return !token.Expiry.IsZero() && time.Until(token.Expiry) > expiryMargin
+}
+
+func bearerToken(r *http.Request, encoded bool) (string, bool) {
+	value := r.Header.Get("Authorization")
+
+	// the authentication scheme is case-insensitive: https://tools.ietf.org/html/rfc7235#section-2.1
+	if len(value) <= len(bearerScheme) || !strings.EqualFold(value[:len(bearerScheme)], bearerScheme) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(value[len(bearerScheme):])
+	if !encoded {
+		return token, true
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return "", false
+	}
+
+	return string(decoded), true
+}
@@ -0,0 +1,170 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tokenexchange_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/tokenexchange"
+	"golang.org/x/oauth2"
+)
+
+func TestTransport(t *testing.T) {
+	t.Run("exchanges the tokens of the requests to the services", func(t *testing.T) {
+		hubAuth, kms, other := newService(t), newService(t), newService(t)
+		exchanger := &mockExchanger{ttl: time.Hour}
+
+		client := &http.Client{Transport: tokenexchange.NewTransport(nil, exchanger, tokenexchange.Services{
+			hubAuth.URL: {Audience: "hub-auth", Encoded: true},
+			kms.URL:     {Audience: "hub-kms"},
+		})}
+
+		send(t, client, hubAuth.URL+"/bootstrap", "Bearer "+encode("opaque"))
+		require.Equal(t, "Bearer "+encode("opaque@hub-auth"), hubAuth.authorization)
+
+		send(t, client, kms.URL+"/kms/keystores", "bearer opaque")
+		require.Equal(t, "Bearer opaque@hub-kms", kms.authorization)
+
+		send(t, client, other.URL+"/", "Bearer opaque")
+		require.Equal(t, "Bearer opaque", other.authorization)
+
+		send(t, client, kms.URL+"/healthcheck", "")
+		require.Empty(t, kms.authorization)
+
+		send(t, client, hubAuth.URL+"/bootstrap", "Bearer not base64")
+		require.Equal(t, "Bearer not base64", hubAuth.authorization)
+
+		require.Equal(t, 2, exchanger.count())
+	})
+
+	t.Run("caches the exchanged tokens until they expire", func(t *testing.T) {
+		kms := newService(t)
+		exchanger := &mockExchanger{ttl: time.Hour}
+
+		client := &http.Client{Transport: tokenexchange.NewTransport(nil, exchanger, tokenexchange.Services{
+			kms.URL: {Audience: "hub-kms"},
+		})}
+
+		send(t, client, kms.URL+"/", "Bearer a")
+		send(t, client, kms.URL+"/", "Bearer a")
+		require.Equal(t, 1, exchanger.count())
+
+		send(t, client, kms.URL+"/", "Bearer b")
+		require.Equal(t, "Bearer b@hub-kms", kms.authorization)
+		require.Equal(t, 2, exchanger.count())
+
+		exchanger.ttl = 0
+
+		send(t, client, kms.URL+"/", "Bearer c")
+		send(t, client, kms.URL+"/", "Bearer c")
+		require.Equal(t, 4, exchanger.count(), "tokens without an expiry are not cached")
+
+		exchanger.ttl = 10 * time.Second
+
+		send(t, client, kms.URL+"/", "Bearer d")
+		send(t, client, kms.URL+"/", "Bearer d")
+		require.Equal(t, 6, exchanger.count(), "tokens about to expire are renewed")
+	})
+
+	t.Run("fails the request if the token cannot be exchanged", func(t *testing.T) {
+		kms := newService(t)
+
+		client := &http.Client{Transport: tokenexchange.NewTransport(nil,
+			&mockExchanger{err: errors.New("invalid_grant")}, tokenexchange.Services{kms.URL: {Audience: "hub-kms"}})}
+
+		r, err := http.NewRequestWithContext(context.Background(), http.MethodGet, kms.URL+"/", nil)
+		require.NoError(t, err)
+		r.Header.Set("Authorization", "Bearer opaque")
+
+		resp, err := client.Do(r) // nolint:bodyclose // no response
+		require.Error(t, err)
+		require.Nil(t, resp)
+		require.Contains(t, err.Error(), "token exchange for "+kms.URL+"/: invalid_grant")
+	})
+}
+
+type service struct {
+	*httptest.Server
+	authorization string
+}
+
+func newService(t *testing.T) *service {
+	t.Helper()
+
+	s := &service{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.authorization = r.Header.Get("Authorization")
+	}))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func send(t *testing.T, client *http.Client, url, authorization string) {
+	t.Helper()
+
+	r, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	require.NoError(t, err)
+
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := client.Do(r)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	if authorization != "" {
+		require.Equal(t, authorization, r.Header.Get("Authorization"), "the request is not modified")
+	}
+}
+
+func encode(token string) string {
+	return base64.StdEncoding.EncodeToString([]byte(token))
+}
+
+type mockExchanger struct {
+	ttl       time.Duration
+	err       error
+	mutex     sync.Mutex
+	exchanges int
+}
+
+func (m *mockExchanger) ExchangeToken(_ context.Context, token, audience string) (*oauth2.Token, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.exchanges++
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	exchanged := &oauth2.Token{AccessToken: token + "@" + audience}
+	if m.ttl > 0 {
+		exchanged.Expiry = time.Now().Add(m.ttl)
+	}
+
+	return exchanged, nil
+}
+
+func (m *mockExchanger) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.exchanges
+}
@@ -0,0 +1,142 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LatestAPIVersion is the latest version of the REST API. The handlers are served under the prefix of each
+	// version they support, e.g. /v1/oidc/login.
+	LatestAPIVersion = 1
+	// APIVersionHeader is the header of the version of the API the request is served with. The clients of the
+	// legacy routes, without version prefix, may ask for a version with it.
+	APIVersionHeader = "API-Version"
+)
+
+type apiVersionKey struct{}
+
+// nolint:gochecknoglobals // compiled once
+var versionPrefix = regexp.MustCompile(`^/v([1-9][0-9]{0,3})/`)
+
+// VersionPrefix returns the path prefix of the version of the API, e.g. /v1.
+func VersionPrefix(version int) string {
+	return "/v" + strconv.Itoa(version)
+}
+
+// APIVersion returns the version of the API the request is served with, the first one if it was not negotiated.
+func APIVersion(r *http.Request) int {
+	version, ok := r.Context().Value(apiVersionKey{}).(int)
+	if !ok {
+		return 1
+	}
+
+	return version
+}
+
+// SupportsVersion tells whether the handler serves the version of the API.
+func SupportsVersion(h Handler, version int) bool {
+	versions := h.Versions()
+	if len(versions) == 0 {
+		return true
+	}
+
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VersioningConfig configures the negotiation of the version of the API.
+type VersioningConfig struct {
+	// Legacy serves the routes without version prefix too, with the version asked for in the API-Version header,
+	// the first one by default. Their responses tell the clients to move to the prefixed routes.
+	Legacy bool
+	// Unversioned are the paths served without version prefix only, e.g. the healthcheck.
+	Unversioned []string
+}
+
+// Versioning negotiates the version of the API the requests are served with, which the handlers read with
+// APIVersion, and strips the version prefix from their path, so that the routes are registered once for all the
+// versions.
+func Versioning(next http.Handler, config *VersioningConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range config.Unversioned {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+		}
+
+		match := versionPrefix.FindStringSubmatch(r.URL.Path)
+		if match != nil {
+			version, err := strconv.Atoi(match[1])
+			if err != nil || version > LatestAPIVersion {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			serveVersion(next, w, r, version, VersionPrefix(version))
+
+			return
+		}
+
+		if !config.Legacy {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		version := 1
+
+		if asked := r.Header.Get(APIVersionHeader); asked != "" {
+			v, err := strconv.Atoi(asked)
+			if err != nil || v < 1 || v > LatestAPIVersion {
+				http.Error(w, fmt.Sprintf("unsupported %s %s", APIVersionHeader, asked), http.StatusBadRequest)
+
+				return
+			}
+
+			version = v
+		}
+
+		successor := VersionPrefix(version) + r.URL.Path
+
+		// as per the drafts of the IETF on the Deprecation header and RFC 7234
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		w.Header().Set("Warning", fmt.Sprintf(`299 - "Deprecated API: use %s"`, successor))
+
+		serveVersion(next, w, r, version, "")
+	})
+}
+
+func serveVersion(next http.Handler, w http.ResponseWriter, r *http.Request, version int, prefix string) {
+	w.Header().Set(APIVersionHeader, strconv.Itoa(version))
+
+	versioned := r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version))
+
+	if prefix != "" {
+		u := *r.URL
+		u.Path = strings.TrimPrefix(u.Path, prefix)
+		u.RawPath = strings.TrimPrefix(u.RawPath, prefix)
+		versioned.URL = &u
+	}
+
+	next.ServeHTTP(w, versioned)
+}
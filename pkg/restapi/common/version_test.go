@@ -0,0 +1,115 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package common_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+)
+
+func TestVersioning(t *testing.T) {
+	var served *http.Request
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = r
+		w.Header().Set("X-Version", strconv.Itoa(common.APIVersion(r)))
+	})
+
+	serve := func(config *common.VersioningConfig, r *http.Request) *httptest.ResponseRecorder {
+		served = nil
+		w := httptest.NewRecorder()
+		common.Versioning(next, config).ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("serves the prefixed routes with their version", func(t *testing.T) {
+		w := serve(&common.VersioningConfig{}, httptest.NewRequest(http.MethodGet, "/v1/oidc/login?prompt=login", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "/oidc/login", served.URL.Path)
+		require.Equal(t, "prompt=login", served.URL.RawQuery)
+		require.Equal(t, "1", w.Header().Get("X-Version"))
+		require.Equal(t, "1", w.Header().Get(common.APIVersionHeader))
+		require.Empty(t, w.Header().Get("Deprecation"))
+	})
+
+	t.Run("serves the legacy routes with deprecation headers", func(t *testing.T) {
+		w := serve(&common.VersioningConfig{Legacy: true}, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "/oidc/login", served.URL.Path)
+		require.Equal(t, "1", w.Header().Get("X-Version"))
+		require.Equal(t, "true", w.Header().Get("Deprecation"))
+		require.Equal(t, `</v1/oidc/login>; rel="successor-version"`, w.Header().Get("Link"))
+		require.Contains(t, w.Header().Get("Warning"), "299")
+
+		r := httptest.NewRequest(http.MethodGet, "/oidc/login", nil)
+		r.Header.Set(common.APIVersionHeader, "1")
+
+		w = serve(&common.VersioningConfig{Legacy: true}, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "1", w.Header().Get(common.APIVersionHeader))
+	})
+
+	t.Run("does not serve the legacy routes if configured so", func(t *testing.T) {
+		w := serve(&common.VersioningConfig{}, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Nil(t, served)
+	})
+
+	t.Run("serves the unversioned paths as is", func(t *testing.T) {
+		config := &common.VersioningConfig{Unversioned: []string{"/healthcheck"}}
+
+		w := serve(config, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, w.Header().Get(common.APIVersionHeader))
+
+		w = serve(config, httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "/healthcheck", served.URL.Path)
+	})
+
+	t.Run("rejects the unknown versions", func(t *testing.T) {
+		config := &common.VersioningConfig{Legacy: true}
+
+		w := serve(config, httptest.NewRequest(http.MethodGet, "/v2/oidc/login", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Nil(t, served)
+
+		for _, version := range []string{"0", "2", "latest"} {
+			r := httptest.NewRequest(http.MethodGet, "/oidc/login", nil)
+			r.Header.Set(common.APIVersionHeader, version)
+
+			w = serve(config, r)
+			require.Equal(t, http.StatusBadRequest, w.Code, version)
+			require.Nil(t, served)
+		}
+	})
+}
+
+func TestSupportsVersion(t *testing.T) {
+	handler := common.NewHTTPHandler("/path", http.MethodGet, nil)
+	require.Empty(t, handler.Versions())
+	require.True(t, common.SupportsVersion(handler, 1))
+	require.True(t, common.SupportsVersion(handler, 2))
+
+	handler = handler.WithVersions(2)
+	require.False(t, common.SupportsVersion(handler, 1))
+	require.True(t, common.SupportsVersion(handler, 2))
+
+	wrapped := common.Wrap([]common.Handler{handler}, func(next http.Handler) http.Handler { return next })
+	require.Equal(t, []int{2}, wrapped[0].Versions())
+}
+
+func TestAPIVersion(t *testing.T) {
+	require.Equal(t, 1, common.APIVersion(httptest.NewRequest(http.MethodGet, "/oidc/login", nil)))
+	require.Equal(t, "/v1", common.VersionPrefix(1))
+}
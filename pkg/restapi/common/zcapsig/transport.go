@@ -0,0 +1,197 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package zcapsig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	httpsig "github.com/igor-pavlenko/httpsignatures-go"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+)
+
+const (
+	readAction  = "read"
+	writeAction = "write"
+)
+
+// signatureHeaders are the parts of a request covered by its HTTP signature: the signature commits to the
+// request target and to the capability invoked on it.
+var signatureHeaders = []string{"(request-target)", "(created)", zcapld.CapabilityInvocationHTTPHeader}
+
+// Signer signs data with the key of the invoker of a capability.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+// Invocation is a capability invoked on a service together with the key proving the invoker's identity.
+type Invocation struct {
+	// Capability is the zcap invoked, in JSON.
+	Capability []byte
+	// Action is the action invoked. Defaults to "read" for GET and HEAD requests and to "write" otherwise.
+	Action string
+	// KeyID is the verification method of the invoker's key, a did:key URL.
+	KeyID  string
+	Signer Signer
+}
+
+// Invocations are the capabilities invoked on each service, keyed by the base URL of the service.
+type Invocations map[string]*Invocation
+
+type (
+	invocationsKey struct{}
+	actionKey      struct{}
+)
+
+// NewContext returns a copy of ctx carrying the invocations. Requests sent with the context through a Transport
+// are signed with the invocation of the service they target.
+func NewContext(ctx context.Context, invocations Invocations) context.Context {
+	return context.WithValue(ctx, invocationsKey{}, invocations)
+}
+
+// WithAction returns a copy of ctx invoking the action in place of the action of the invocation,
+// for services exposing one action per operation.
+func WithAction(ctx context.Context, action string) context.Context {
+	return context.WithValue(ctx, actionKey{}, action)
+}
+
+// match returns the invocation of the service with the longest base URL prefixing the request URL.
+func (i Invocations) match(r *http.Request) *Invocation {
+	target := r.URL.String()
+
+	var (
+		matched *Invocation
+		longest int
+	)
+
+	for baseURL, invocation := range i {
+		if len(baseURL) > longest && strings.HasPrefix(target, baseURL) {
+			matched = invocation
+			longest = len(baseURL)
+		}
+	}
+
+	return matched
+}
+
+// Sign sets the capability-invocation header of the request and signs it with the invoker's key.
+func Sign(r *http.Request, invocation *Invocation) error {
+	capability, err := compress(invocation.Capability)
+	if err != nil {
+		return err
+	}
+
+	action := invocation.Action
+	if action == "" {
+		action = writeAction
+
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			action = readAction
+		}
+	}
+
+	r.Header.Set(zcapld.CapabilityInvocationHTTPHeader, fmt.Sprintf(`zcap capability="%s",action="%s"`,
+		capability, action))
+
+	hs := httpsig.NewHTTPSignatures(&zcapld.AriesDIDKeySecrets{})
+	hs.SetDefaultSignatureHeaders(signatureHeaders)
+	hs.SetSignatureHashAlgorithm(&signatureAlgorithm{signer: invocation.Signer})
+
+	err = hs.Sign(invocation.KeyID, r)
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	return nil
+}
+
+// Transport is an http.RoundTripper signing the requests that carry an invocation of their target service
+// in their context, and sending the other requests as is.
+type Transport struct {
+	base http.RoundTripper
+}
+
+// NewTransport returns a Transport sending the requests through base, or through http.DefaultTransport if nil.
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{base: base}
+}
+
+// RoundTrip signs the request and sends it.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	invocations, ok := r.Context().Value(invocationsKey{}).(Invocations)
+	if !ok {
+		return t.base.RoundTrip(r)
+	}
+
+	invocation := invocations.match(r)
+	if invocation == nil {
+		return t.base.RoundTrip(r)
+	}
+
+	if action, ok := r.Context().Value(actionKey{}).(string); ok {
+		invoked := *invocation
+		invoked.Action = action
+		invocation = &invoked
+	}
+
+	// a round tripper must not modify the request it is given
+	signed := r.Clone(r.Context())
+
+	err := Sign(signed, invocation)
+	if err != nil {
+		return nil, fmt.Errorf("zcap invocation of %s: %w", r.URL.String(), err)
+	}
+
+	return t.base.RoundTrip(signed)
+}
+
+// signatureAlgorithm signs with the invoker's Signer under the algorithm name of did:key secrets,
+// which the zcap-ld verifier of the hub-kms and EDV servers expects.
+type signatureAlgorithm struct {
+	signer Signer
+}
+
+func (a *signatureAlgorithm) Algorithm() string {
+	return (&zcapld.AriesDIDKeySignatureHashAlgorithm{}).Algorithm()
+}
+
+func (a *signatureAlgorithm) Create(_ httpsig.Secret, data []byte) ([]byte, error) {
+	return a.signer.Sign(data)
+}
+
+func (a *signatureAlgorithm) Verify(httpsig.Secret, []byte, []byte) error {
+	return errors.New("verification is not supported")
+}
+
+// compress encodes the capability like the zcap-ld verifier decodes it: gzipped, then base64URL-encoded.
+func compress(capability []byte) (string, error) {
+	var compressed bytes.Buffer
+
+	w := gzip.NewWriter(&compressed)
+
+	_, err := w.Write(capability)
+	if err != nil {
+		return "", fmt.Errorf("failed to compress capability: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to compress capability: %w", err)
+	}
+
+	return base64.URLEncoding.EncodeToString(compressed.Bytes()), nil
+}
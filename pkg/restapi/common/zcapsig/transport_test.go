@@ -0,0 +1,221 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package zcapsig_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	httpsig "github.com/igor-pavlenko/httpsignatures-go"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/zcapsig"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+)
+
+const keyID = "did:key:z6MkiTBz1ymuepAQ4HEHYSF1H8quG5GLVVQR3djdX3mDooWp#key-1"
+
+var invocationHeader = regexp.MustCompile(`^zcap capability="([^"]+)",action="([^"]+)"$`)
+
+func TestTransport(t *testing.T) {
+	t.Run("signs the requests to the services with an invocation", func(t *testing.T) {
+		kms, edv := newService(t), newService(t)
+		key := newKey(t)
+
+		client := &http.Client{Transport: zcapsig.NewTransport(nil)}
+		ctx := zcapsig.NewContext(context.Background(), zcapsig.Invocations{
+			kms.URL: {Capability: []byte(`{"id":"kms"}`), Action: "createKey", KeyID: keyID, Signer: key},
+			edv.URL: {Capability: []byte(`{"id":"edv"}`), KeyID: keyID, Signer: key},
+		})
+
+		send(ctx, t, client, http.MethodPost, kms.URL+"/keystores/123/keys")
+		require.Equal(t, `{"id":"kms"}`, kms.capability)
+		require.Equal(t, "createKey", kms.action)
+		require.NoError(t, key.verify(kms.request))
+
+		send(ctx, t, client, http.MethodGet, edv.URL+"/vault/documents/1")
+		require.Equal(t, `{"id":"edv"}`, edv.capability)
+		require.Equal(t, "read", edv.action)
+		require.NoError(t, key.verify(edv.request))
+
+		send(ctx, t, client, http.MethodPost, edv.URL+"/vault/documents")
+		require.Equal(t, "write", edv.action)
+		require.NoError(t, key.verify(edv.request))
+
+		send(zcapsig.WithAction(ctx, "export"), t, client, http.MethodGet, kms.URL+"/keystores/123/keys/1/export")
+		require.Equal(t, `{"id":"kms"}`, kms.capability)
+		require.Equal(t, "export", kms.action)
+		require.NoError(t, key.verify(kms.request))
+	})
+
+	t.Run("the signature covers the request target", func(t *testing.T) {
+		s := newService(t)
+		key := newKey(t)
+
+		client := &http.Client{Transport: zcapsig.NewTransport(nil)}
+		ctx := zcapsig.NewContext(context.Background(), zcapsig.Invocations{
+			s.URL: {Capability: []byte(`{}`), KeyID: keyID, Signer: key},
+		})
+
+		send(ctx, t, client, http.MethodGet, s.URL+"/a")
+
+		s.request.URL.Path = "/b"
+		require.Error(t, key.verify(s.request))
+	})
+
+	t.Run("sends the other requests as is", func(t *testing.T) {
+		s, other := newService(t), newService(t)
+		key := newKey(t)
+
+		client := &http.Client{Transport: zcapsig.NewTransport(nil)}
+
+		send(context.Background(), t, client, http.MethodGet, s.URL)
+		require.Empty(t, s.request.Header.Get(zcapld.CapabilityInvocationHTTPHeader))
+		require.Empty(t, s.request.Header.Get("Signature"))
+
+		ctx := zcapsig.NewContext(context.Background(), zcapsig.Invocations{
+			other.URL: {Capability: []byte(`{}`), KeyID: keyID, Signer: key},
+		})
+
+		send(ctx, t, client, http.MethodGet, s.URL)
+		require.Empty(t, s.request.Header.Get(zcapld.CapabilityInvocationHTTPHeader))
+		require.Empty(t, s.request.Header.Get("Signature"))
+	})
+
+	t.Run("error if the request cannot be signed", func(t *testing.T) {
+		s := newService(t)
+
+		client := &http.Client{Transport: zcapsig.NewTransport(nil)}
+		ctx := zcapsig.NewContext(context.Background(), zcapsig.Invocations{
+			s.URL: {Capability: []byte(`{}`), KeyID: keyID, Signer: &failingSigner{}},
+		})
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+		require.NoError(t, err)
+
+		_, err = client.Do(req) // nolint:bodyclose // no response
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to sign request")
+		require.Nil(t, s.request)
+	})
+}
+
+type service struct {
+	*httptest.Server
+	request    *http.Request
+	capability string
+	action     string
+}
+
+func newService(t *testing.T) *service {
+	t.Helper()
+
+	s := &service{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.request = r
+		s.capability, s.action = "", ""
+
+		match := invocationHeader.FindStringSubmatch(r.Header.Get(zcapld.CapabilityInvocationHTTPHeader))
+		if match != nil {
+			s.capability = decompress(t, match[1])
+			s.action = match[2]
+		}
+	}))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func send(ctx context.Context, t *testing.T, client *http.Client, method, url string) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func decompress(t *testing.T, capability string) string {
+	t.Helper()
+
+	compressed, err := base64.URLEncoding.DecodeString(capability)
+	require.NoError(t, err)
+
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+
+	decompressed, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+
+	return string(decompressed)
+}
+
+type key struct {
+	public  ed25519.PublicKey
+	private ed25519.PrivateKey
+}
+
+func newKey(t *testing.T) *key {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return &key{public: public, private: private}
+}
+
+func (k *key) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(k.private, data), nil
+}
+
+// verify checks the HTTP signature of the request like the zcap-ld verifier does, with the key in place of
+// the did:key resolution.
+func (k *key) verify(r *http.Request) error {
+	hs := httpsig.NewHTTPSignatures(&zcapld.AriesDIDKeySecrets{})
+	hs.SetSignatureHashAlgorithm(&verifier{public: k.public})
+
+	return hs.Verify(r)
+}
+
+type verifier struct {
+	public ed25519.PublicKey
+}
+
+func (v *verifier) Algorithm() string {
+	return (&zcapld.AriesDIDKeySignatureHashAlgorithm{}).Algorithm()
+}
+
+func (v *verifier) Create(httpsig.Secret, []byte) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (v *verifier) Verify(_ httpsig.Secret, data, signature []byte) error {
+	if !ed25519.Verify(v.public, data, signature) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+type failingSigner struct{}
+
+func (s *failingSigner) Sign([]byte) ([]byte, error) {
+	return nil, errors.New("sign error")
+}
@@ -0,0 +1,208 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package csp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Endpoints.
+const (
+	// ReportPath is where the browsers report the violations of the content security policy.
+	ReportPath = "/csp-report"
+	// Admin endpoint.
+	reportsPath = "/csp-reports"
+)
+
+const (
+	defaultMaxReports = 100
+	// the browsers send a report per violation, of a few KB
+	maxReportSize = 64 * 1024
+)
+
+var logger = log.New("edge-agent/csp")
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	// MaxReports is the number of the latest reports kept. Defaults to 100.
+	MaxReports int
+	// Now is the clock the reports are received on. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Report is a violation of the content security policy reported by a browser.
+type Report struct {
+	Received  time.Time `json:"received"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	// Body is the report as sent by the browser, e.g. its document-uri, violated-directive and blocked-uri.
+	Body json.RawMessage `json:"body"`
+}
+
+// ReportsResponse lists the latest reports, with the total received since the agent started.
+type ReportsResponse struct {
+	Total   uint64    `json:"total"`
+	Reports []*Report `json:"reports"`
+}
+
+// Operation collects the violations of the content security policy the browsers report, e.g. to tune a policy
+// served in report-only mode before enforcing it. The latest reports are kept in memory.
+type Operation struct {
+	max   int
+	now   func() time.Time
+	mutex sync.Mutex
+	// the latest reports, oldest first
+	reports []*Report
+	total   uint64
+}
+
+// New returns a new Operation.
+func New(config *Config) *Operation {
+	o := &Operation{max: config.MaxReports, now: config.Now}
+
+	if o.max <= 0 {
+		o.max = defaultMaxReports
+	}
+
+	if o.now == nil {
+		o.now = time.Now
+	}
+
+	return o
+}
+
+// GetRESTHandlers returns the handler the browsers report to. It must be mounted without authentication.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(ReportPath, http.MethodPost, o.reportHandler, &common.OperationSpec{
+			Summary: "Collects the violations of the content security policy reported by the browsers, as" +
+				" application/csp-report or application/reports+json.",
+			Responses: map[int]interface{}{
+				http.StatusNoContent:  nil,
+				http.StatusBadRequest: nil,
+			},
+		}),
+	}
+}
+
+// GetAdminRESTHandlers returns the administrative handlers. They must be mounted behind admin authentication.
+func (o *Operation) GetAdminRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(reportsPath, http.MethodGet, o.reportsHandler, &common.OperationSpec{
+			Summary:   "Lists the latest violations of the content security policy reported by the browsers.",
+			Responses: map[int]interface{}{http.StatusOK: &ReportsResponse{}},
+		}),
+	}
+}
+
+func (o *Operation) reportHandler(w http.ResponseWriter, r *http.Request) {
+	raw, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReportSize+1))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to read report: %s", err.Error())
+
+		return
+	}
+
+	if len(raw) > maxReportSize {
+		common.WriteErrorResponsef(w, logger, http.StatusRequestEntityTooLarge, "report exceeds %d bytes",
+			maxReportSize)
+
+		return
+	}
+
+	bodies, err := reportBodies(r.Header.Get("Content-Type"), raw)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode report: %s", err.Error())
+
+		return
+	}
+
+	for _, body := range bodies {
+		o.add(&Report{Received: o.now().UTC(), UserAgent: r.UserAgent(), Body: body})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reportBodies returns the bodies of the reports of the request: the csp-report of the application/csp-report
+// requests of the report-uri directive, or the bodies of the csp-violation reports of the Reporting API.
+func reportBodies(contentType string, raw []byte) ([]json.RawMessage, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	if mediaType == "application/reports+json" {
+		var reports []struct {
+			Type string          `json:"type"`
+			Body json.RawMessage `json:"body"`
+		}
+
+		err = json.Unmarshal(raw, &reports)
+		if err != nil {
+			return nil, err
+		}
+
+		bodies := make([]json.RawMessage, 0, len(reports))
+
+		for _, report := range reports {
+			if report.Type == "csp-violation" && len(report.Body) > 0 {
+				bodies = append(bodies, report.Body)
+			}
+		}
+
+		return bodies, nil
+	}
+
+	report := struct {
+		Body json.RawMessage `json:"csp-report"`
+	}{}
+
+	err = json.Unmarshal(raw, &report)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(report.Body) == 0 {
+		return nil, nil
+	}
+
+	return []json.RawMessage{report.Body}, nil
+}
+
+func (o *Operation) add(report *Report) {
+	logger.Warnf("content security policy violation reported by %s: %s", report.UserAgent, string(report.Body))
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.total++
+	o.reports = append(o.reports, report)
+
+	if len(o.reports) > o.max {
+		o.reports = o.reports[len(o.reports)-o.max:]
+	}
+}
+
+func (o *Operation) reportsHandler(w http.ResponseWriter, _ *http.Request) {
+	o.mutex.Lock()
+
+	resp := &ReportsResponse{Total: o.total, Reports: make([]*Report, len(o.reports))}
+	copy(resp.Reports, o.reports)
+
+	o.mutex.Unlock()
+
+	common.WriteResponse(w, logger, resp)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package csp // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	o := New(&Config{})
+	require.Len(t, o.GetRESTHandlers(), 1)
+	require.Len(t, o.GetAdminRESTHandlers(), 1)
+	require.Equal(t, defaultMaxReports, o.max)
+}
+
+func TestOperation_ReportHandler(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("collects the reports of the report-uri directive", func(t *testing.T) {
+		o := New(&Config{Now: func() time.Time { return now }})
+
+		w := report(o, "application/csp-report",
+			`{"csp-report":{"document-uri":"https://agent.example.com/","blocked-uri":"inline"}}`)
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		resp := reports(t, o)
+		require.Equal(t, uint64(1), resp.Total)
+		require.Len(t, resp.Reports, 1)
+		require.Equal(t, now, resp.Reports[0].Received)
+		require.Equal(t, "Mozilla/5.0", resp.Reports[0].UserAgent)
+		require.JSONEq(t, `{"document-uri":"https://agent.example.com/","blocked-uri":"inline"}`,
+			string(resp.Reports[0].Body))
+	})
+
+	t.Run("collects the csp violations of the reporting API", func(t *testing.T) {
+		o := New(&Config{})
+
+		w := report(o, "application/reports+json; charset=utf-8", `[
+			{"type":"csp-violation","body":{"blockedURL":"inline"}},
+			{"type":"deprecation","body":{"id":"feature"}},
+			{"type":"csp-violation","body":{"blockedURL":"eval"}}
+		]`)
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		resp := reports(t, o)
+		require.Equal(t, uint64(2), resp.Total)
+		require.JSONEq(t, `{"blockedURL":"eval"}`, string(resp.Reports[1].Body))
+	})
+
+	t.Run("keeps the latest reports", func(t *testing.T) {
+		o := New(&Config{MaxReports: 2})
+
+		for _, uri := range []string{"a", "b", "c"} {
+			report(o, "application/csp-report", `{"csp-report":{"blocked-uri":"`+uri+`"}}`)
+		}
+
+		resp := reports(t, o)
+		require.Equal(t, uint64(3), resp.Total)
+		require.Len(t, resp.Reports, 2)
+		require.JSONEq(t, `{"blocked-uri":"b"}`, string(resp.Reports[0].Body))
+	})
+
+	t.Run("ignores the requests without report", func(t *testing.T) {
+		o := New(&Config{})
+
+		w := report(o, "", `{}`)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		require.Zero(t, reports(t, o).Total)
+	})
+
+	t.Run("bad request if the report is malformed", func(t *testing.T) {
+		for contentType, body := range map[string]string{
+			"application/csp-report":   `{"csp-report":`,
+			"application/reports+json": `{}`,
+		} {
+			w := report(New(&Config{}), contentType, body)
+			require.Equal(t, http.StatusBadRequest, w.Code, contentType)
+			require.Contains(t, w.Body.String(), "failed to decode report", contentType)
+		}
+	})
+
+	t.Run("error if the report is too large", func(t *testing.T) {
+		w := report(New(&Config{}), "application/csp-report", strings.Repeat(" ", maxReportSize+1))
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
+
+func report(o *Operation, contentType, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, ReportPath, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+
+	w := httptest.NewRecorder()
+	o.reportHandler(w, r)
+
+	return w
+}
+
+func reports(t *testing.T, o *Operation) *ReportsResponse {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.reportsHandler(w, httptest.NewRequest(http.MethodGet, reportsPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	resp := &ReportsResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+
+	return resp
+}
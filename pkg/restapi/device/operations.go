@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/duo-labs/webauthn.io/session"
 	"github.com/duo-labs/webauthn/protocol"
@@ -18,6 +19,7 @@ import (
 	"github.com/trustbloc/edge-agent/pkg/restapi/common"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"github.com/trustbloc/edge-core/pkg/storage"
@@ -47,12 +49,16 @@ type Config struct {
 	TLSConfig       *tls.Config
 	Keys            *KeyConfig
 	Webauthn        *webauthn.WebAuthn
+	// Pseudonyms are the pseudonymous IDs of the users in the users store. The subs are used as is if nil.
+	Pseudonyms *pseudonym.Mapper
 }
 
 // KeyConfig holds configuration for cryptographic keys.
 type KeyConfig struct {
 	Auth []byte
 	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
 }
 
 // StorageConfig holds storage config.
@@ -62,7 +68,7 @@ type StorageConfig struct {
 }
 
 type stores struct {
-	users   *user.Store
+	users   user.Store
 	cookies cookie.Store
 	storage storage.Store
 	session *session.Store
@@ -72,6 +78,7 @@ type stores struct {
 type Operation struct {
 	store           *stores
 	walletDashboard string
+	dashboardMutex  sync.RWMutex
 	tlsConfig       *tls.Config
 	webauthn        *webauthn.WebAuthn
 }
@@ -80,7 +87,7 @@ type Operation struct {
 func New(config *Config) (*Operation, error) {
 	op := &Operation{
 		store: &stores{
-			cookies: cookie.NewStore(config.Keys.Auth, config.Keys.Enc),
+			cookies: cookie.NewStore(config.Keys.Auth, config.Keys.Enc, cookie.WithKeyRing(config.Keys.Ring)),
 		},
 		tlsConfig:       config.TLSConfig,
 		webauthn:        config.Webauthn,
@@ -99,7 +106,7 @@ func New(config *Config) (*Operation, error) {
 		return nil, fmt.Errorf("failed to create web auth protocol session store: %w", err)
 	}
 
-	op.store.users, err = user.NewStore(config.Storage.Storage)
+	op.store.users, err = user.NewStore(config.Storage.Storage, user.WithPseudonyms(config.Pseudonyms))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open users store: %w", err)
 	}
@@ -110,13 +117,42 @@ func New(config *Config) (*Operation, error) {
 // GetRESTHandlers get all controller API handler available for this service.
 func (o *Operation) GetRESTHandlers() []common.Handler {
 	return []common.Handler{
-		common.NewHTTPHandler(registerBeginPath, http.MethodGet, o.beginRegistration),
-		common.NewHTTPHandler(registerFinishPath, http.MethodPost, o.finishRegistration),
-		common.NewHTTPHandler(loginBeginPath, http.MethodGet, o.beginLogin),
-		common.NewHTTPHandler(loginFinishPath, http.MethodPost, o.finishLogin),
+		common.NewHTTPHandler(registerBeginPath, http.MethodGet, o.beginRegistration, &common.OperationSpec{
+			Summary:   "Starts the WebAuthn registration of a device of the user logged in.",
+			Responses: map[int]interface{}{http.StatusOK: &protocol.CredentialCreation{}},
+		}),
+		common.NewHTTPHandler(registerFinishPath, http.MethodPost, o.finishRegistration, &common.OperationSpec{
+			Summary:   "Completes the WebAuthn registration of a device.",
+			Request:   &protocol.CredentialCreationResponse{},
+			Responses: map[int]interface{}{http.StatusOK: &webauthn.Credential{}},
+		}),
+		common.NewHTTPHandler(loginBeginPath, http.MethodGet, o.beginLogin, &common.OperationSpec{
+			Summary:   "Starts the WebAuthn login with a registered device.",
+			Responses: map[int]interface{}{http.StatusOK: &protocol.CredentialAssertion{}},
+		}),
+		common.NewHTTPHandler(loginFinishPath, http.MethodPost, o.finishLogin, &common.OperationSpec{
+			Summary:   "Completes the WebAuthn login and redirects to the wallet.",
+			Request:   &protocol.CredentialAssertionResponse{},
+			Responses: map[int]interface{}{http.StatusFound: nil},
+		}),
 	}
 }
 
+// SetWalletDashboard changes the page users land on once logged in.
+func (o *Operation) SetWalletDashboard(url string) {
+	o.dashboardMutex.Lock()
+	defer o.dashboardMutex.Unlock()
+
+	o.walletDashboard = url
+}
+
+func (o *Operation) dashboard() string {
+	o.dashboardMutex.RLock()
+	defer o.dashboardMutex.RUnlock()
+
+	return o.walletDashboard
+}
+
 func (o *Operation) beginRegistration(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("handling device registration: %s", r.URL.String())
 
@@ -282,7 +318,7 @@ func (o *Operation) finishLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// handle successful login
-	http.Redirect(w, r, o.walletDashboard, http.StatusFound)
+	http.Redirect(w, r, o.dashboard(), http.StatusFound)
 	logger.Debugf("Login finish success")
 }
 
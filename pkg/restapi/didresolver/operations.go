@@ -0,0 +1,96 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package didresolver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/didresolver"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Endpoints.
+const (
+	resolvePath = "/resolve"
+)
+
+const didParam = "did"
+
+var logger = log.New("edge-agent/didresolver")
+
+// Resolver resolves DIDs.
+type Resolver interface {
+	Resolve(did string, opts ...vdrapi.ResolveOpts) (*did.Doc, error)
+}
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Resolver Resolver
+}
+
+// Operation lets the operators check how the agent resolves a DID, e.g. whether a driver accepts its method.
+type Operation struct {
+	resolver Resolver
+}
+
+// New returns a new Operation.
+func New(config *Config) *Operation {
+	return &Operation{resolver: config.Resolver}
+}
+
+// GetRESTHandlers get all controller API handler available for this service.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(resolvePath, http.MethodGet, o.resolveHandler, &common.OperationSpec{
+			Summary: "Resolves a DID as the agent does.",
+			Params: []common.Param{
+				{Name: didParam, In: common.InQuery, Description: "The DID, e.g. did:web:example.com.", Required: true},
+			},
+			Responses: map[int]interface{}{
+				http.StatusOK:         map[string]interface{}{},
+				http.StatusBadRequest: nil,
+				http.StatusNotFound:   nil,
+				http.StatusBadGateway: nil,
+			},
+		}),
+	}
+}
+
+func (o *Operation) resolveHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling resolve did request")
+
+	didID := r.URL.Query().Get(didParam)
+	if didID == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing did parameter")
+
+		return
+	}
+
+	doc, err := o.resolver.Resolve(didID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, status(err), "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, doc)
+}
+
+func status(err error) int {
+	switch {
+	case errors.Is(err, didresolver.ErrMethodNotSupported), errors.Is(err, didresolver.ErrInvalidDID):
+		return http.StatusBadRequest
+	case errors.Is(err, vdrapi.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadGateway
+	}
+}
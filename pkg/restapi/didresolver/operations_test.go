@@ -0,0 +1,85 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package didresolver // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	vdrkey "github.com/hyperledger/aries-framework-go/pkg/vdr/key"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/didresolver"
+)
+
+const keyDID = "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+func TestOperation_ResolveHandler(t *testing.T) {
+	o := New(&Config{Resolver: didresolver.New(&didresolver.Config{
+		Drivers: []didresolver.Driver{vdrkey.New(), &failingDriver{}},
+	})})
+	require.Len(t, o.GetRESTHandlers(), 1)
+
+	t.Run("returns the did document", func(t *testing.T) {
+		w := resolve(o, keyDID)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		doc, err := did.ParseDocument(w.Body.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, keyDID, doc.ID)
+	})
+
+	t.Run("err badrequest if the did is missing or invalid", func(t *testing.T) {
+		for didID, msg := range map[string]string{
+			"":                "missing did parameter",
+			"invalid":         "invalid did",
+			"did:example:123": "did method not supported",
+		} {
+			w := resolve(o, didID)
+			require.Equal(t, http.StatusBadRequest, w.Code, didID)
+			require.Contains(t, w.Body.String(), msg, didID)
+		}
+	})
+
+	t.Run("err notfound if the did does not exist", func(t *testing.T) {
+		w := resolve(o, "did:fail:notfound")
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "DID not found")
+	})
+
+	t.Run("err badgateway if the did cannot be resolved", func(t *testing.T) {
+		w := resolve(o, "did:fail:123")
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Contains(t, w.Body.String(), "failed to resolve did:fail:123")
+	})
+}
+
+func resolve(o *Operation, didID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	o.resolveHandler(w, httptest.NewRequest(http.MethodGet, resolvePath+"?did="+didID, nil))
+
+	return w
+}
+
+// failingDriver fails to resolve the DIDs of the fail method, as if did:fail:notfound did not exist.
+type failingDriver struct{}
+
+func (f *failingDriver) Accept(method string) bool {
+	return method == "fail"
+}
+
+func (f *failingDriver) Read(didID string, _ ...vdrapi.ResolveOpts) (*did.Doc, error) {
+	if didID == "did:fail:notfound" {
+		return nil, fmt.Errorf("test: %w", vdrapi.ErrNotFound)
+	}
+
+	return nil, errors.New("test")
+}
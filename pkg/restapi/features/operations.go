@@ -0,0 +1,90 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package features
+
+import (
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Endpoints.
+const (
+	featuresPath = "/features"
+)
+
+const userSubCookieName = "user_sub"
+
+var logger = log.New("edge-agent/features")
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	// Features evaluates the feature flags. Every feature is enabled if nil.
+	Features *features.Service
+	Keys     *KeyConfig
+}
+
+// KeyConfig holds configuration for cryptographic keys.
+type KeyConfig struct {
+	Auth []byte
+	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
+}
+
+// FeaturesResponse lists the flagged features, and whether they are enabled for the user. The features it does not
+// list are enabled.
+type FeaturesResponse struct {
+	Features map[string]bool `json:"features"`
+}
+
+// Operation exposes the feature flags to the UI.
+type Operation struct {
+	features *features.Service
+	cookies  cookie.Store
+}
+
+// New returns a new Operation.
+func New(config *Config) *Operation {
+	return &Operation{
+		features: config.Features,
+		cookies:  cookie.NewStore(config.Keys.Auth, config.Keys.Enc, cookie.WithKeyRing(config.Keys.Ring)),
+	}
+}
+
+// GetRESTHandlers get all controller API handler available for this service.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(featuresPath, http.MethodGet, o.featuresHandler, &common.OperationSpec{
+			Summary: "Lists the flagged features enabled for the user logged in, or for everyone before the login.",
+			Responses: map[int]interface{}{
+				http.StatusOK: &FeaturesResponse{},
+			},
+		}),
+	}
+}
+
+func (o *Operation) featuresHandler(w http.ResponseWriter, r *http.Request) {
+	common.WriteResponse(w, logger, &FeaturesResponse{Features: o.features.Features(o.userSub(r))})
+}
+
+// userSub returns the sub of the user logged in, or an empty sub before the login.
+func (o *Operation) userSub(r *http.Request) string {
+	jar, err := o.cookies.Open(r)
+	if err != nil {
+		return ""
+	}
+
+	sub, _ := jar.Get(userSubCookieName)
+
+	s, _ := sub.(string) // nolint:errcheck // anonymous if the cookie is not a sub
+
+	return s
+}
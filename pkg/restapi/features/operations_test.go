@@ -0,0 +1,77 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package features // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestNew(t *testing.T) {
+	o := New(config(t))
+	require.Len(t, o.GetRESTHandlers(), 1)
+}
+
+func TestOperation_FeaturesHandler(t *testing.T) {
+	t.Run("lists the features of the user", func(t *testing.T) {
+		o := New(config(t))
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: "alice"},
+		}}
+
+		require.Equal(t, map[string]bool{features.OIDC4VCI: false, features.DeviceLogin: true}, list(t, o))
+	})
+
+	t.Run("lists the features of everyone before the login", func(t *testing.T) {
+		o := New(config(t))
+		o.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		require.Equal(t, map[string]bool{features.OIDC4VCI: false, features.DeviceLogin: false}, list(t, o))
+	})
+
+	t.Run("lists no feature if the features are not flagged", func(t *testing.T) {
+		o := New(&Config{Keys: &KeyConfig{}})
+		o.cookies = &cookie.MockStore{}
+
+		require.Empty(t, list(t, o))
+	})
+}
+
+func config(t *testing.T) *Config {
+	t.Helper()
+
+	flags, err := features.New(&features.Config{Source: &features.MockSource{Flags: map[string]*features.Flag{
+		features.OIDC4VCI:    {},
+		features.DeviceLogin: {Users: []string{"alice"}},
+	}}})
+	require.NoError(t, err)
+
+	return &Config{
+		Features: flags,
+		Keys:     &KeyConfig{},
+	}
+}
+
+func list(t *testing.T, o *Operation) map[string]bool {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.featuresHandler(w, httptest.NewRequest(http.MethodGet, featuresPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	result := &FeaturesResponse{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+
+	return result.Features
+}
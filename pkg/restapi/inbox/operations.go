@@ -0,0 +1,264 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package inbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/inbox"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Endpoints.
+const (
+	messagesPath    = "/messages"
+	acknowledgePath = "/messages/ack"
+)
+
+const (
+	userSubCookieName   = "user_sub"
+	unacknowledgedParam = "unacknowledged"
+)
+
+var logger = log.New("edge-agent/inbox")
+
+// Subscriber subscribes to the events of the bus.
+type Subscriber interface {
+	Subscribe(handler events.Handler, topics ...string) func()
+}
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Events  Subscriber
+	Storage storage.Provider
+	Keys    *KeyConfig
+	// Topics of the events kept in the inbox of their user, e.g. the DIDComm messages received by the agent.
+	Topics []string
+	// Capacity is the number of messages kept per user. Defaults to inbox.DefaultCapacity.
+	Capacity int
+}
+
+// KeyConfig holds configuration for cryptographic keys.
+type KeyConfig struct {
+	Auth []byte
+	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
+}
+
+// AcknowledgeRequest is the body of an acknowledge messages request.
+type AcknowledgeRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// Operation keeps the events of each user in an inbox, so that the wallet UI picks up the messages received while
+// it was closed, e.g. pending connection requests.
+type Operation struct {
+	messages    *inbox.Store
+	cookies     cookie.Store
+	unsubscribe func()
+}
+
+// New returns a new Operation subscribed to the events of the topics.
+func New(config *Config) (*Operation, error) {
+	if config.Events == nil {
+		return nil, errors.New("missing event bus")
+	}
+
+	if len(config.Topics) == 0 {
+		return nil, errors.New("missing inbox topics")
+	}
+
+	messages, err := inbox.NewStore(config.Storage, config.Capacity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init inbox store: %w", err)
+	}
+
+	o := &Operation{
+		messages: messages,
+		cookies:  cookie.NewStore(config.Keys.Auth, config.Keys.Enc, cookie.WithKeyRing(config.Keys.Ring)),
+	}
+
+	o.unsubscribe = config.Events.Subscribe(o.receive, config.Topics...)
+
+	return o, nil
+}
+
+// Close stops feeding the inbox.
+func (o *Operation) Close() {
+	o.unsubscribe()
+}
+
+// GetRESTHandlers get all controller API handler available for this service.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(messagesPath, http.MethodGet, o.listHandler, &common.OperationSpec{
+			Summary: "Lists the messages of the inbox of the user logged in, in the order they were received.",
+			Params: []common.Param{
+				common.QueryParam(unacknowledgedParam, "Set to true to list the unacknowledged messages only."),
+				common.HeaderParam("If-None-Match", "ETag of the messages the client has."),
+			},
+			Responses: map[int]interface{}{
+				http.StatusOK:          []*inbox.Message{},
+				http.StatusNotModified: nil,
+			},
+		}),
+		common.NewHTTPHandler(acknowledgePath, http.MethodPost, o.acknowledgeHandler, &common.OperationSpec{
+			Summary:   "Marks messages of the inbox as seen.",
+			Request:   &AcknowledgeRequest{},
+			Responses: map[int]interface{}{http.StatusNoContent: nil},
+		}),
+		common.NewHTTPHandler(messagesPath, http.MethodDelete, o.deleteHandler, &common.OperationSpec{
+			Summary:   "Deletes a message of the inbox.",
+			Params:    []common.Param{{Name: "id", In: common.InQuery, Description: "Id of the message.", Required: true}},
+			Responses: map[int]interface{}{http.StatusNoContent: nil},
+		}),
+	}
+}
+
+// receive keeps the event in the inbox of its user. The bus may dispatch an event more than once: the inbox
+// ignores the messages it holds already.
+func (o *Operation) receive(e *outbox.Event) {
+	if e.Subject == "" {
+		return
+	}
+
+	err := o.messages.Add(e.Subject, &inbox.Message{
+		ID:       e.ID,
+		Topic:    e.Topic,
+		Payload:  e.Payload,
+		Received: e.Created,
+	})
+	if err != nil {
+		logger.Warnf("failed to keep event %s in inbox: %s", e.ID, err.Error())
+	}
+}
+
+func (o *Operation) listHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling list messages request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	all, err := o.messages.List(sub)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	messages := make([]*inbox.Message, 0, len(all))
+
+	for _, m := range all {
+		if m.Acknowledged && r.URL.Query().Get(unacknowledgedParam) == "true" {
+			continue
+		}
+
+		messages = append(messages, m)
+	}
+
+	common.WriteConditionalResponse(w, r, logger, messages)
+}
+
+func (o *Operation) acknowledgeHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling acknowledge messages request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &AcknowledgeRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if len(request.IDs) == 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing ids")
+
+		return
+	}
+
+	err = o.messages.Acknowledge(sub, request.IDs...)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Operation) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling delete message request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing id parameter")
+
+		return
+	}
+
+	err := o.messages.Delete(sub, id)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "message %s not found", id)
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Operation) userSub(w http.ResponseWriter, r *http.Request) (string, bool) {
+	jar, err := o.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return "", false
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return "", false
+	}
+
+	sub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return "", false
+	}
+
+	return sub, true
+}
@@ -0,0 +1,292 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package inbox // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/inbox"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("returns an instance", func(t *testing.T) {
+		o, err := New(config())
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 3)
+	})
+
+	t.Run("error if event bus is missing", func(t *testing.T) {
+		c := config()
+		c.Events = nil
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing event bus")
+	})
+
+	t.Run("error if topics are missing", func(t *testing.T) {
+		c := config()
+		c.Topics = nil
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing inbox topics")
+	})
+
+	t.Run("error if store cannot be opened", func(t *testing.T) {
+		c := config()
+		c.Storage = &mockstore.Provider{ErrOpenStoreHandle: errors.New("test")}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init inbox store")
+	})
+}
+
+func TestOperation_Receive(t *testing.T) {
+	bus := events.NewBus(&events.BusConfig{})
+	c := config()
+	c.Events = bus
+
+	o := newOperation(t, c, "sub")
+
+	expected := newEvent(t, "didcomm.message", "sub")
+	require.NoError(t, bus.Dispatch(expected))
+	require.NoError(t, bus.Dispatch(expected))
+	require.NoError(t, bus.Dispatch(newEvent(t, "user.login", "sub")))
+	require.NoError(t, bus.Dispatch(newEvent(t, "didcomm.message", "")))
+
+	messages := list(t, o, "")
+	require.Len(t, messages, 1)
+	require.Equal(t, expected.ID, messages[0].ID)
+	require.Equal(t, "didcomm.message", messages[0].Topic)
+	require.JSONEq(t, `{"test":"value"}`, string(messages[0].Payload))
+	require.False(t, messages[0].Acknowledged)
+
+	o.Close()
+
+	require.NoError(t, bus.Dispatch(newEvent(t, "didcomm.message", "sub")))
+	require.Len(t, list(t, o, ""), 1)
+}
+
+func TestOperation_ListHandler(t *testing.T) {
+	t.Run("lists the unacknowledged messages", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+
+		first, second := newEvent(t, "didcomm.message", "sub"), newEvent(t, "didcomm.message", "sub")
+		o.receive(first)
+		o.receive(second)
+		o.receive(newEvent(t, "didcomm.message", "other"))
+		require.NoError(t, o.messages.Acknowledge("sub", first.ID))
+
+		require.Len(t, list(t, o, ""), 2)
+
+		messages := list(t, o, "?unacknowledged=true")
+		require.Len(t, messages, 1)
+		require.Equal(t, second.ID, messages[0].ID)
+	})
+
+	t.Run("err internalservererror if inbox cannot be fetched", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+		o.messages = failingStore(t)
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, messagesPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch inbox")
+	})
+
+	t.Run("err forbidden if user is not logged in", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{}
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, messagesPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "not logged in")
+	})
+}
+
+func TestOperation_AcknowledgeHandler(t *testing.T) {
+	t.Run("acknowledges messages", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+
+		e := newEvent(t, "didcomm.message", "sub")
+		o.receive(e)
+
+		w := acknowledge(t, o, &AcknowledgeRequest{IDs: []string{e.ID}})
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		messages := list(t, o, "")
+		require.Len(t, messages, 1)
+		require.True(t, messages[0].Acknowledged)
+	})
+
+	t.Run("err badrequest if request is invalid", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+
+		w := httptest.NewRecorder()
+		o.acknowledgeHandler(w, httptest.NewRequest(http.MethodPost, acknowledgePath, bytes.NewBufferString("{")))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "failed to decode request")
+
+		w = acknowledge(t, o, &AcknowledgeRequest{})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing ids")
+	})
+
+	t.Run("err internalservererror if inbox cannot be fetched", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+		o.messages = failingStore(t)
+
+		w := acknowledge(t, o, &AcknowledgeRequest{IDs: []string{"id"}})
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch inbox")
+	})
+
+	t.Run("err badrequest if cookies cannot be opened", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := acknowledge(t, o, &AcknowledgeRequest{IDs: []string{"id"}})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "cannot open cookies")
+	})
+}
+
+func TestOperation_DeleteHandler(t *testing.T) {
+	t.Run("deletes a message", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+
+		e := newEvent(t, "didcomm.message", "sub")
+		o.receive(e)
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, messagesPath+"?id="+e.ID, nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+		require.Empty(t, list(t, o, ""))
+
+		w = httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, messagesPath+"?id="+e.ID, nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "not found")
+	})
+
+	t.Run("err badrequest if id is missing", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, messagesPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing id parameter")
+	})
+
+	t.Run("err internalservererror if inbox cannot be fetched", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+		o.messages = failingStore(t)
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, messagesPath+"?id=id", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch inbox")
+	})
+
+	t.Run("err internalservererror if user sub cookie is invalid", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: 1},
+		}}
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, messagesPath+"?id=id", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "invalid user sub cookie format")
+	})
+}
+
+func config() *Config {
+	return &Config{
+		Events:  events.NewBus(&events.BusConfig{}),
+		Storage: memstore.NewProvider(),
+		Keys: &KeyConfig{
+			Auth: []byte(uuid.New().String()),
+			Enc:  []byte(uuid.New().String())[:32],
+		},
+		Topics: []string{"didcomm.message"},
+	}
+}
+
+func newOperation(t *testing.T, c *Config, sub string) *Operation {
+	t.Helper()
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+		Cookies: map[interface{}]interface{}{userSubCookieName: sub},
+	}}
+
+	return o
+}
+
+func newEvent(t *testing.T, topic, sub string) *outbox.Event {
+	t.Helper()
+
+	e, err := outbox.NewEvent(topic, sub, map[string]string{"test": "value"})
+	require.NoError(t, err)
+
+	return e
+}
+
+func failingStore(t *testing.T) *inbox.Store {
+	t.Helper()
+
+	s, err := inbox.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+		Store:  map[string][]byte{"sub": []byte(`{"messages":[]}`)},
+		ErrGet: errors.New("test"),
+	}}, 0)
+	require.NoError(t, err)
+
+	return s
+}
+
+func list(t *testing.T, o *Operation, query string) []*inbox.Message {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.listHandler(w, httptest.NewRequest(http.MethodGet, messagesPath+query, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var messages []*inbox.Message
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &messages))
+
+	return messages
+}
+
+func acknowledge(t *testing.T, o *Operation, request *AcknowledgeRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	o.acknowledgeHandler(w, httptest.NewRequest(http.MethodPost, acknowledgePath, bytes.NewBuffer(body)))
+
+	return w
+}
@@ -0,0 +1,92 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/maintenance"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Admin endpoints.
+const (
+	maintenancePath = "/maintenance"
+)
+
+var logger = log.New("edge-agent/maintenance")
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Maintenance *maintenance.Service
+}
+
+// Operation switches the agent to maintenance mode through the administrative API, e.g. to drain the logins
+// ahead of the upgrade of its dependencies.
+type Operation struct {
+	maintenance *maintenance.Service
+}
+
+// New returns a new Operation.
+func New(config *Config) (*Operation, error) {
+	if config.Maintenance == nil {
+		return nil, errors.New("missing maintenance service")
+	}
+
+	return &Operation{maintenance: config.Maintenance}, nil
+}
+
+// GetRESTHandlers returns the administrative handlers. They must be mounted behind admin authentication.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(maintenancePath, http.MethodGet, o.getHandler, &common.OperationSpec{
+			Summary:   "Returns the maintenance mode of the agent.",
+			Responses: map[int]interface{}{http.StatusOK: &maintenance.Mode{}},
+		}),
+		common.NewHTTPHandler(maintenancePath, http.MethodPut, o.setHandler, &common.OperationSpec{
+			Summary: "Enters or leaves the maintenance mode on every instance of the agent sharing its database.",
+			Request: &maintenance.Mode{},
+			Responses: map[int]interface{}{
+				http.StatusOK:         &maintenance.Mode{},
+				http.StatusBadRequest: nil,
+			},
+		}),
+	}
+}
+
+func (o *Operation) getHandler(w http.ResponseWriter, _ *http.Request) {
+	common.WriteResponse(w, logger, o.maintenance.Mode())
+}
+
+func (o *Operation) setHandler(w http.ResponseWriter, r *http.Request) {
+	mode := &maintenance.Mode{}
+
+	err := json.NewDecoder(r.Body).Decode(mode)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if mode.RetryAfter < 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid retryAfter: must not be negative")
+
+		return
+	}
+
+	err = o.maintenance.Set(mode)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, o.maintenance.Mode())
+}
@@ -0,0 +1,112 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package maintenance // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/maintenance"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 2)
+	})
+
+	t.Run("error if the maintenance service is missing", func(t *testing.T) {
+		_, err := New(&Config{})
+		require.EqualError(t, err, "missing maintenance service")
+	})
+}
+
+func TestOperation_SetHandler(t *testing.T) {
+	t.Run("enters and leaves the maintenance mode", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.setHandler(w, request(t, `{"enabled":true,"message":"upgrading the KMS","retryAfter":60}`))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, &maintenance.Mode{Enabled: true, Message: "upgrading the KMS", RetryAfter: 60}, get(t, o))
+
+		w = httptest.NewRecorder()
+		o.setHandler(w, request(t, `{"enabled":false}`))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, &maintenance.Mode{}, get(t, o))
+	})
+
+	t.Run("bad request if the mode is invalid", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.setHandler(w, request(t, "{"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "failed to decode request")
+
+		w = httptest.NewRecorder()
+		o.setHandler(w, request(t, `{"enabled":true,"retryAfter":-1}`))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "invalid retryAfter")
+		require.False(t, get(t, o).Enabled)
+	})
+
+	t.Run("internal server error if the mode cannot be saved", func(t *testing.T) {
+		s, err := maintenance.New(&maintenance.Config{Storage: &mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{},
+			ErrPut: errors.New("test"),
+		}}})
+		require.NoError(t, err)
+
+		o, err := New(&Config{Maintenance: s})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.setHandler(w, request(t, `{"enabled":true}`))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save maintenance mode")
+	})
+}
+
+func config(t *testing.T) *Config {
+	t.Helper()
+
+	s, err := maintenance.New(&maintenance.Config{Storage: memstore.NewProvider()})
+	require.NoError(t, err)
+
+	return &Config{Maintenance: s}
+}
+
+func request(t *testing.T, body string) *http.Request {
+	t.Helper()
+
+	return httptest.NewRequest(http.MethodPut, maintenancePath, bytes.NewBufferString(body))
+}
+
+func get(t *testing.T, o *Operation) *maintenance.Mode {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.getHandler(w, httptest.NewRequest(http.MethodGet, maintenancePath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	mode := &maintenance.Mode{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(mode))
+
+	return mode
+}
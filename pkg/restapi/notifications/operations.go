@@ -0,0 +1,234 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Endpoints.
+const (
+	notificationsPath = "/notifications"
+)
+
+const (
+	userSubCookieName        = "user_sub"
+	defaultBufferSize        = 16
+	defaultKeepAliveInterval = 30 * time.Second
+)
+
+var logger = log.New("edge-agent/notifications")
+
+// Subscriber subscribes to the events of the bus.
+type Subscriber interface {
+	Subscribe(handler events.Handler, topics ...string) func()
+}
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Events Subscriber
+	Keys   *KeyConfig
+	// Topics streamed to the users. Every topic is streamed if empty.
+	Topics []string
+	// BufferSize is the number of events held for a slow client before they are dropped. Defaults to 16.
+	BufferSize int
+	// KeepAliveInterval is the period of the comments keeping idle streams open. Defaults to 30s.
+	KeepAliveInterval time.Duration
+}
+
+// KeyConfig holds configuration for cryptographic keys.
+type KeyConfig struct {
+	Auth []byte
+	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
+}
+
+// Operation streams the events of the logged in user to the browser as Server-Sent Events.
+type Operation struct {
+	events     Subscriber
+	cookies    cookie.Store
+	topics     []string
+	bufferSize int
+	keepAlive  time.Duration
+	// the number of open notification streams by user
+	streams map[string]int
+	mutex   sync.Mutex
+}
+
+// New returns a new Operation.
+func New(config *Config) (*Operation, error) {
+	if config.Events == nil {
+		return nil, errors.New("missing event bus")
+	}
+
+	o := &Operation{
+		events:     config.Events,
+		cookies:    cookie.NewStore(config.Keys.Auth, config.Keys.Enc, cookie.WithKeyRing(config.Keys.Ring)),
+		topics:     config.Topics,
+		bufferSize: config.BufferSize,
+		keepAlive:  config.KeepAliveInterval,
+		streams:    make(map[string]int),
+	}
+
+	if o.bufferSize <= 0 {
+		o.bufferSize = defaultBufferSize
+	}
+
+	if o.keepAlive <= 0 {
+		o.keepAlive = defaultKeepAliveInterval
+	}
+
+	return o, nil
+}
+
+// GetRESTHandlers get all controller API handler available for this service.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(notificationsPath, http.MethodGet, o.notificationsHandler, &common.OperationSpec{
+			Summary:   "Streams the events of the user logged in as server-sent events.",
+			Responses: map[int]interface{}{http.StatusOK: nil},
+		}),
+	}
+}
+
+func (o *Operation) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling notifications request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "streaming is not supported")
+
+		return
+	}
+
+	pending := make(chan *outbox.Event, o.bufferSize)
+
+	cancel := o.events.Subscribe(func(e *outbox.Event) {
+		if e.Subject != sub {
+			return
+		}
+
+		select {
+		case pending <- e:
+		default:
+			logger.Warnf("dropping event %s: the notification stream of the user is full", e.ID)
+		}
+	}, o.topics...)
+	defer cancel()
+
+	o.connect(sub)
+	defer o.disconnect(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(o.keepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-pending:
+			err = writeEvent(w, e)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+
+		if err != nil {
+			logger.Debugf("closing the notification stream: %s", err.Error())
+
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// Online tells whether the user has a notification stream open, i.e. is notified of the events by the wallet UI.
+func (o *Operation) Online(sub string) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.streams[sub] > 0
+}
+
+func (o *Operation) connect(sub string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.streams[sub]++
+}
+
+func (o *Operation) disconnect(sub string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.streams[sub]--
+
+	if o.streams[sub] <= 0 {
+		delete(o.streams, sub)
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e *outbox.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event %s: %w", e.ID, err)
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Topic, data)
+
+	return err
+}
+
+func (o *Operation) userSub(w http.ResponseWriter, r *http.Request) (string, bool) {
+	jar, err := o.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return "", false
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return "", false
+	}
+
+	sub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return "", false
+	}
+
+	return sub, true
+}
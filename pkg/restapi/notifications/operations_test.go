@@ -0,0 +1,297 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package notifications // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("returns an instance", func(t *testing.T) {
+		o, err := New(config())
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 1)
+		require.Equal(t, defaultBufferSize, o.bufferSize)
+		require.Equal(t, defaultKeepAliveInterval, o.keepAlive)
+	})
+
+	t.Run("error if event bus is missing", func(t *testing.T) {
+		c := config()
+		c.Events = nil
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing event bus")
+	})
+}
+
+func TestOperation_NotificationsHandler(t *testing.T) {
+	t.Run("streams the events of the user", func(t *testing.T) {
+		bus := events.NewBus(&events.BusConfig{})
+		c := config()
+		c.Events = bus
+		c.Topics = []string{"user.onboarded", "credential.received"}
+
+		o := newOperation(t, c, "sub")
+
+		server := httptest.NewServer(http.HandlerFunc(o.notificationsHandler))
+		defer server.Close()
+
+		response, err := http.Get(server.URL) // nolint:noctx // test
+		require.NoError(t, err)
+
+		defer response.Body.Close() // nolint:errcheck // test
+
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+		require.NoError(t, bus.Dispatch(newEvent(t, "user.onboarded", "other")))
+		require.NoError(t, bus.Dispatch(newEvent(t, "user.login", "sub")))
+
+		expected := newEvent(t, "credential.received", "sub")
+		require.NoError(t, bus.Dispatch(expected))
+
+		lines := readEvent(t, bufio.NewReader(response.Body))
+		require.Len(t, lines, 3)
+		require.Equal(t, "id: "+expected.ID, lines[0])
+		require.Equal(t, "event: credential.received", lines[1])
+
+		result := &outbox.Event{}
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), result))
+		require.Equal(t, expected.ID, result.ID)
+		require.Equal(t, "sub", result.Subject)
+	})
+
+	t.Run("keeps idle streams open", func(t *testing.T) {
+		c := config()
+		c.KeepAliveInterval = time.Millisecond
+
+		o := newOperation(t, c, "sub")
+
+		server := httptest.NewServer(http.HandlerFunc(o.notificationsHandler))
+		defer server.Close()
+
+		response, err := http.Get(server.URL) // nolint:noctx // test
+		require.NoError(t, err)
+
+		defer response.Body.Close() // nolint:errcheck // test
+
+		require.Equal(t, []string{": keep-alive"}, readEvent(t, bufio.NewReader(response.Body)))
+	})
+
+	t.Run("unsubscribes when the client disconnects", func(t *testing.T) {
+		bus := &mockSubscriber{}
+		c := config()
+		c.Events = bus
+
+		o := newOperation(t, c, "sub")
+
+		server := httptest.NewServer(http.HandlerFunc(o.notificationsHandler))
+		defer server.Close()
+
+		response, err := http.Get(server.URL) // nolint:noctx // test
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		require.Eventually(t, bus.cancelled, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("tells the users with an open stream online", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+		require.False(t, o.Online("sub"))
+
+		server := httptest.NewServer(http.HandlerFunc(o.notificationsHandler))
+		defer server.Close()
+
+		response, err := http.Get(server.URL) // nolint:noctx // test
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool { return o.Online("sub") }, time.Second, 10*time.Millisecond)
+		require.False(t, o.Online("other"))
+
+		require.NoError(t, response.Body.Close())
+		require.Eventually(t, func() bool { return !o.Online("sub") }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("drops events of slow clients", func(t *testing.T) {
+		bus := &mockSubscriber{}
+		c := config()
+		c.Events = bus
+		c.BufferSize = 1
+
+		o := newOperation(t, c, "sub")
+
+		server := httptest.NewServer(http.HandlerFunc(o.notificationsHandler))
+		defer server.Close()
+
+		response, err := http.Get(server.URL) // nolint:noctx // test
+		require.NoError(t, err)
+
+		defer response.Body.Close() // nolint:errcheck // test
+
+		for i := 0; i < 100; i++ {
+			bus.handler(newEvent(t, "user.login", "sub"))
+		}
+	})
+
+	t.Run("err badrequest if cookies cannot be opened", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.notificationsHandler(w, httptest.NewRequest(http.MethodGet, notificationsPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "cannot open cookies")
+	})
+
+	t.Run("err forbidden if user is not logged in", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{}
+
+		w := httptest.NewRecorder()
+		o.notificationsHandler(w, httptest.NewRequest(http.MethodGet, notificationsPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "not logged in")
+	})
+
+	t.Run("err internalservererror if user sub cookie is invalid", func(t *testing.T) {
+		o := newOperation(t, config(), "")
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: 1},
+		}}
+
+		w := httptest.NewRecorder()
+		o.notificationsHandler(w, httptest.NewRequest(http.MethodGet, notificationsPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "invalid user sub cookie format")
+	})
+
+	t.Run("err internalservererror if streaming is not supported", func(t *testing.T) {
+		o := newOperation(t, config(), "sub")
+
+		w := httptest.NewRecorder()
+		o.notificationsHandler(&unflushableWriter{w: w}, httptest.NewRequest(http.MethodGet, notificationsPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "streaming is not supported")
+	})
+}
+
+func config() *Config {
+	return &Config{
+		Events: events.NewBus(&events.BusConfig{}),
+		Keys: &KeyConfig{
+			Auth: []byte(uuid.New().String()),
+			Enc:  []byte(uuid.New().String())[:32],
+		},
+	}
+}
+
+func newOperation(t *testing.T, c *Config, sub string) *Operation {
+	t.Helper()
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+		Cookies: map[interface{}]interface{}{userSubCookieName: sub},
+	}}
+
+	return o
+}
+
+func newEvent(t *testing.T, topic, sub string) *outbox.Event {
+	t.Helper()
+
+	e, err := outbox.NewEvent(topic, sub, map[string]string{"test": "value"})
+	require.NoError(t, err)
+
+	return e
+}
+
+// readEvent reads the lines of the next event of the stream.
+func readEvent(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+
+	var lines []string
+
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+
+		lines = append(lines, line)
+	}
+}
+
+type mockSubscriber struct {
+	mutex  sync.Mutex
+	sub    events.Handler
+	closed bool
+}
+
+func (m *mockSubscriber) Subscribe(handler events.Handler, _ ...string) func() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sub = handler
+
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.closed = true
+	}
+}
+
+func (m *mockSubscriber) handler(e *outbox.Event) {
+	m.mutex.Lock()
+	handler := m.sub
+	m.mutex.Unlock()
+
+	handler(e)
+}
+
+func (m *mockSubscriber) cancelled() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.closed
+}
+
+type unflushableWriter struct {
+	w http.ResponseWriter
+}
+
+func (u *unflushableWriter) Header() http.Header {
+	return u.w.Header()
+}
+
+func (u *unflushableWriter) Write(b []byte) (int, error) {
+	return u.w.Write(b)
+}
+
+func (u *unflushableWriter) WriteHeader(statusCode int) {
+	u.w.WriteHeader(statusCode)
+}
@@ -0,0 +1,290 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	accountPath        = "/account"
+	accountDryRunParam = "dryRun"
+)
+
+// Statuses of the steps of an account deletion.
+const (
+	// DeletionPlanned is the status of the steps of a dry run.
+	DeletionPlanned = "planned"
+	// DeletionDeleted is the status of the resources deleted, or found deleted already.
+	DeletionDeleted = "deleted"
+	// DeletionTombstoned is the status of the vaults of the EDV servers which do not delete vaults: the agent
+	// forgets them, and they are left for the operators of the servers to purge.
+	DeletionTombstoned = "tombstoned"
+	// DeletionSkipped is the status of the resources the user does not have.
+	DeletionSkipped = "skipped"
+	// DeletionFailed is the status of the resources which could not be deleted.
+	DeletionFailed = "failed"
+)
+
+// ErrDeletionIncomplete is returned when some of the keystores and vaults of a user could not be deleted. The
+// records of the user are kept, so that the deletion can be retried.
+var ErrDeletionIncomplete = errors.New("account deletion incomplete")
+
+// AccountDeletion reports the erasure of the account of a user.
+type AccountDeletion struct {
+	Sub    string          `json:"sub"`
+	DryRun bool            `json:"dryRun"`
+	Steps  []*DeletionStep `json:"steps"`
+}
+
+// DeletionStep is the deletion of a resource of the user.
+type DeletionStep struct {
+	Resource string `json:"resource"`
+	URL      string `json:"url,omitempty"`
+	// Status is one of the deletion statuses.
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (d *AccountDeletion) add(resource, url, status, detail string) {
+	d.Steps = append(d.Steps, &DeletionStep{Resource: resource, URL: url, Status: status, Detail: detail})
+}
+
+func (d *AccountDeletion) failed() bool {
+	for _, step := range d.Steps {
+		if step.Status == DeletionFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *Operation) accountHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(accountPath, http.MethodDelete, o.requireStepUp(o.deleteAccountHandler),
+			&common.OperationSpec{
+				Summary: "Deletes the account of the user logged in, with the keystores and vaults of the user, and " +
+					"logs the user out.",
+				Params: []common.Param{
+					common.QueryParam(accountDryRunParam, "Set to true to list what would be deleted."),
+				},
+				Responses: map[int]interface{}{
+					http.StatusOK:           &AccountDeletion{},
+					http.StatusBadGateway:   &AccountDeletion{},
+					http.StatusUnauthorized: nil,
+				},
+			}),
+	}
+}
+
+// deleteAccountHandler deletes the account of the user logged in, and logs the user out. With dryRun=true, it
+// reports what would be deleted.
+func (o *Operation) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling account deletion request")
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusForbidden, "not logged in")
+
+		return
+	}
+
+	sub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return
+	}
+
+	dryRun := r.URL.Query().Get(accountDryRunParam) == "true"
+
+	deletion, err := o.DeleteAccount(r.Context(), sub, dryRun)
+
+	switch {
+	case errors.Is(err, ErrDeletionIncomplete):
+		w.WriteHeader(http.StatusBadGateway)
+		common.WriteResponse(w, logger, deletion)
+
+		return
+	case errors.Is(err, storage.ErrValueNotFound):
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "%s", err.Error())
+
+		return
+	case err != nil:
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	if !dryRun {
+		o.forgetUser(w, r)
+		jar.Delete(userSubCookieName)
+		forgetAuthContext(jar)
+
+		err = jar.Save(r, w)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+				"failed to delete user sub cookie: %s", err.Error())
+
+			return
+		}
+	}
+
+	common.WriteResponse(w, logger, deletion)
+	logger.Debugf("finished handling account deletion request")
+}
+
+// DeleteAccount deletes the vaults and keystores of the user, the bootstrap data held by hub-auth, and the tokens
+// and record of the user, and records the erasure in the audit log. Nothing is deleted in a dry run. The error
+// wraps storage.ErrValueNotFound if the user is not onboarded, and is ErrDeletionIncomplete, with the report, if
+// some of the vaults and keystores could not be deleted: the records of the user are kept then.
+func (o *Operation) DeleteAccount(ctx context.Context, sub string, dryRun bool) (*AccountDeletion, error) {
+	usr, err := o.store.users.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user tokens: %w", err)
+	}
+
+	bootstrap, err := o.fetchBootstrapData(ctx, tokns.Access)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bootstrap data: %w", err)
+	}
+
+	data := bootstrap.Data
+	if data == nil {
+		data = &BootstrapData{}
+	}
+
+	deletion := &AccountDeletion{Sub: sub, DryRun: dryRun}
+	h := &hubKMSHeader{userSub: o.pseudonyms.ID(sub), accessToken: tokns.Access, secretShare: usr.SecretShare}
+	token := &hubKMSHeader{accessToken: tokns.Access}
+
+	// the vaults go first, then the keystores of the keys of their capabilities
+	o.deleteResource(ctx, deletion, "user edv vault", data.UserEDVVaultURL, token, true)
+	o.deleteResource(ctx, deletion, "ops edv vault", data.OpsEDVVaultURL, token, true)
+	o.deleteResource(ctx, deletion, "ops keystore", data.OpsKeyStoreURL, token, false)
+	o.deleteResource(ctx, deletion, "authz keystore", data.AuthzKeyStoreURL, h, false)
+
+	if dryRun {
+		deletion.add("bootstrap", o.hubAuthURL+hubAuthBootstrapDataPath, DeletionPlanned, "")
+		deletion.add("tokens", "", DeletionPlanned, "")
+		deletion.add("user record", "", DeletionPlanned, "")
+
+		return deletion, nil
+	}
+
+	if deletion.failed() {
+		return deletion, ErrDeletionIncomplete
+	}
+
+	err = o.postBootstrapData(ctx, tokns.Access, &BootstrapData{})
+	if err != nil {
+		deletion.add("bootstrap", o.hubAuthURL+hubAuthBootstrapDataPath, DeletionFailed, err.Error())
+
+		return deletion, ErrDeletionIncomplete
+	}
+
+	deletion.add("bootstrap", o.hubAuthURL+hubAuthBootstrapDataPath, DeletionDeleted, "")
+
+	err = o.store.tokens.Delete(sub)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return deletion, fmt.Errorf("failed to delete user tokens: %w", err)
+	}
+
+	deletion.add("tokens", "", DeletionDeleted, "")
+
+	err = o.store.users.Delete(sub)
+	if err != nil {
+		return deletion, err
+	}
+
+	deletion.add("user record", "", DeletionDeleted, "")
+
+	err = o.usage.delete(sub)
+	if err != nil {
+		return deletion, err
+	}
+
+	o.vaults.Remove(sub)
+	o.invalidateUserInfo(sub)
+	o.invalidateKeystores(o.pseudonyms.ID(sub))
+	o.audit(audit.ActionAccountDeleted, sub, deletion)
+
+	return deletion, nil
+}
+
+// deleteResource deletes a keystore or a vault of the user, unless in a dry run. The vaults of the EDV servers
+// answering that they do not delete vaults are tombstoned.
+func (o *Operation) deleteResource(ctx context.Context, deletion *AccountDeletion, name, url string, h *hubKMSHeader,
+	vault bool) {
+	if url == "" {
+		deletion.add(name, url, DeletionSkipped, "not provisioned")
+
+		return
+	}
+
+	if deletion.DryRun {
+		deletion.add(name, url, DeletionPlanned, "")
+
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+	if err != nil {
+		deletion.add(name, url, DeletionFailed, err.Error())
+
+		return
+	}
+
+	addAuthZKMSHeaders(req, h)
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		deletion.add(name, url, DeletionFailed, err.Error())
+
+		return
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Errorf("failed to close response body")
+		}
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent:
+		deletion.add(name, url, DeletionDeleted, "")
+	case resp.StatusCode == http.StatusNotFound:
+		deletion.add(name, url, DeletionDeleted, "not found, deleted already")
+	case vault && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented):
+		deletion.add(name, url, DeletionTombstoned, "the EDV server does not delete vaults")
+	default:
+		deletion.add(name, url, DeletionFailed, fmt.Sprintf("status %d", resp.StatusCode))
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+const (
+	accountUserVault = "https://edv.example.com/encrypted-data-vaults/user"
+	accountOpsVault  = "https://edv.example.com/encrypted-data-vaults/ops"
+	accountOpsKMS    = "https://kms.example.com/kms/keystores/ops"
+	accountAuthzKMS  = "https://kms.example.com/kms/keystores/authz"
+)
+
+func TestOperation_DeleteAccount(t *testing.T) {
+	t.Run("deletes the keystores, vaults and records of the user", func(t *testing.T) {
+		o, hub := setupAccountTest(t, nil)
+
+		deletion, err := o.DeleteAccount(context.Background(), "alice", false)
+		require.NoError(t, err)
+		require.False(t, deletion.DryRun)
+
+		for _, step := range deletion.Steps {
+			require.Equal(t, DeletionDeleted, step.Status, step.Resource)
+		}
+
+		require.ElementsMatch(t, []string{accountUserVault, accountOpsVault, accountOpsKMS, accountAuthzKMS},
+			hub.deleted())
+		require.True(t, hub.cleared)
+
+		_, err = o.store.users.Get("alice")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		_, err = o.store.tokens.Get("alice")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		entries, err := o.auditLog.List("alice")
+		require.NoError(t, err)
+		require.Equal(t, audit.ActionAccountDeleted, entries[len(entries)-1].Action)
+	})
+
+	t.Run("deletes nothing in a dry run", func(t *testing.T) {
+		o, hub := setupAccountTest(t, nil)
+
+		deletion, err := o.DeleteAccount(context.Background(), "alice", true)
+		require.NoError(t, err)
+		require.True(t, deletion.DryRun)
+		require.Len(t, deletion.Steps, 7)
+
+		for _, step := range deletion.Steps {
+			require.Equal(t, DeletionPlanned, step.Status, step.Resource)
+		}
+
+		require.Empty(t, hub.deleted())
+		require.False(t, hub.cleared)
+
+		_, err = o.store.users.Get("alice")
+		require.NoError(t, err)
+	})
+
+	t.Run("tombstones the vaults the EDV server does not delete", func(t *testing.T) {
+		o, _ := setupAccountTest(t, map[string]int{
+			accountUserVault: http.StatusMethodNotAllowed,
+			accountOpsVault:  http.StatusNotFound,
+		})
+
+		deletion, err := o.DeleteAccount(context.Background(), "alice", false)
+		require.NoError(t, err)
+		require.Equal(t, DeletionTombstoned, deletion.Steps[0].Status)
+		require.Equal(t, DeletionDeleted, deletion.Steps[1].Status)
+	})
+
+	t.Run("keeps the records of the user if a keystore cannot be deleted", func(t *testing.T) {
+		o, hub := setupAccountTest(t, map[string]int{accountAuthzKMS: http.StatusInternalServerError})
+
+		deletion, err := o.DeleteAccount(context.Background(), "alice", false)
+		require.True(t, errors.Is(err, ErrDeletionIncomplete))
+		require.Equal(t, DeletionFailed, deletion.Steps[3].Status)
+		require.False(t, hub.cleared)
+
+		_, err = o.store.users.Get("alice")
+		require.NoError(t, err)
+	})
+
+	t.Run("skips the resources the user does not have", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader([]byte("{}")))}, nil
+		}}
+
+		deletion, err := o.DeleteAccount(context.Background(), "alice", false)
+		require.NoError(t, err)
+		require.Equal(t, DeletionSkipped, deletion.Steps[0].Status)
+	})
+
+	t.Run("error if the user is not onboarded", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+
+		_, err := o.DeleteAccount(context.Background(), "bob", false)
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if the bootstrap data cannot be fetched", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("test")
+		}}
+
+		_, err := o.DeleteAccount(context.Background(), "alice", false)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch bootstrap data")
+	})
+}
+
+func TestOperation_DeleteAccountHandler(t *testing.T) {
+	t.Run("deletes the account and logs the user out", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+		jar := &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: "alice"}}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+
+		w := httptest.NewRecorder()
+		o.deleteAccountHandler(w, httptest.NewRequest(http.MethodDelete, accountPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotContains(t, jar.Cookies, userSubCookieName)
+
+		deletion := &AccountDeletion{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), deletion))
+		require.Equal(t, "alice", deletion.Sub)
+	})
+
+	t.Run("keeps the user logged in in a dry run", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+		jar := &cookie.MockJar{Cookies: map[interface{}]interface{}{userSubCookieName: "alice"}}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+
+		w := httptest.NewRecorder()
+		o.deleteAccountHandler(w, httptest.NewRequest(http.MethodDelete, accountPath+"?dryRun=true", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "alice", jar.Cookies[userSubCookieName])
+	})
+
+	t.Run("bad gateway with the report if the deletion is incomplete", func(t *testing.T) {
+		o, _ := setupAccountTest(t, map[string]int{accountOpsKMS: http.StatusBadGateway})
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: "alice"},
+		}}
+
+		w := httptest.NewRecorder()
+		o.deleteAccountHandler(w, httptest.NewRequest(http.MethodDelete, accountPath, nil))
+		require.Equal(t, http.StatusBadGateway, w.Code)
+
+		deletion := &AccountDeletion{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), deletion))
+		require.Equal(t, DeletionFailed, deletion.Steps[2].Status)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+
+		o.store.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+		w := httptest.NewRecorder()
+		o.deleteAccountHandler(w, httptest.NewRequest(http.MethodDelete, accountPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+		w = httptest.NewRecorder()
+		o.deleteAccountHandler(w, httptest.NewRequest(http.MethodDelete, accountPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: "bob"},
+		}}
+		w = httptest.NewRecorder()
+		o.deleteAccountHandler(w, httptest.NewRequest(http.MethodDelete, accountPath, nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: "alice"},
+			SaveErr: errors.New("test"),
+		}}
+		w = httptest.NewRecorder()
+		o.deleteAccountHandler(w, httptest.NewRequest(http.MethodDelete, accountPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+// mockAccountHub fakes hub-auth, the KMS and EDV servers of the account of a user.
+type mockAccountHub struct {
+	mutex    sync.Mutex
+	statuses map[string]int
+	deletes  []string
+	cleared  bool
+}
+
+func (m *mockAccountHub) Do(req *http.Request) (*http.Response, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	body := []byte("{}")
+	status := http.StatusOK
+
+	switch {
+	case req.URL.Path == hubAuthBootstrapDataPath && req.Method == http.MethodGet:
+		body, _ = json.Marshal(&userBootstrapData{Data: &BootstrapData{ // nolint:errcheck // static
+			UserEDVVaultURL:  accountUserVault,
+			OpsEDVVaultURL:   accountOpsVault,
+			OpsKeyStoreURL:   accountOpsKMS,
+			AuthzKeyStoreURL: accountAuthzKMS,
+		}})
+	case req.URL.Path == hubAuthBootstrapDataPath:
+		m.cleared = true
+	case req.Method == http.MethodDelete:
+		if s, ok := m.statuses[req.URL.String()]; ok {
+			status = s
+		}
+
+		if status == http.StatusOK {
+			m.deletes = append(m.deletes, req.URL.String())
+		}
+	}
+
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (m *mockAccountHub) deleted() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]string(nil), m.deletes...)
+}
+
+// setupAccountTest returns an Operation with the onboarded user alice, whose resources answer the DELETE requests
+// with the statuses, or 200.
+func setupAccountTest(t *testing.T, statuses map[string]int) (*Operation, *mockAccountHub) {
+	t.Helper()
+
+	o, err := New(config(t))
+	require.NoError(t, err)
+
+	o.auditLog, err = audit.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	require.NoError(t, o.store.users.Save(&user.User{Sub: "alice", SecretShare: "share"}))
+	require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: "alice", Access: "access"}))
+
+	hub := &mockAccountHub{statuses: statuses}
+	o.httpClient = hub
+
+	return o, hub
+}
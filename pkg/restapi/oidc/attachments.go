@@ -0,0 +1,418 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+)
+
+const (
+	walletAttachmentsPath = "/wallet/attachments"
+	attachmentIDParam     = "id"
+	// the attachments are kept in a store of the user's vault of their own, apart from the credentials.
+	attachmentsStoreName = "attachments"
+	// the chunks stay well below the document size limit of the EDV servers once encrypted.
+	defaultAttachmentChunkSize = 512 * 1024
+	maxAttachmentSize          = 64 * 1024 * 1024
+	defaultAttachmentType      = "application/octet-stream"
+)
+
+var errAttachmentTooLarge = fmt.Errorf("attachment exceeds %d bytes", maxAttachmentSize)
+
+// AttachmentManifest links the chunks an attachment of the user's vault is split into. The manifest and each chunk
+// are documents of the vault of their own, encrypted with the user's keys.
+type AttachmentManifest struct {
+	ID          string    `json:"id"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	ChunkSize   int       `json:"chunkSize"`
+	Chunks      []string  `json:"chunks"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func (o *Operation) walletAttachmentsHandlers() []common.Handler {
+	idParam := common.QueryParam(attachmentIDParam, "Id of the attachment.")
+
+	return []common.Handler{
+		common.NewHTTPHandler(walletAttachmentsPath, http.MethodPost, o.uploadAttachmentHandler,
+			&common.OperationSpec{
+				Summary: "Streams the body of the request to the vault of the user logged in, split in encrypted" +
+					" chunks, e.g. the images or PDFs embedded in credentials.",
+				Responses: map[int]interface{}{
+					http.StatusCreated:               &AttachmentManifest{},
+					http.StatusRequestEntityTooLarge: nil,
+					http.StatusInsufficientStorage:   nil,
+				},
+			}),
+		common.NewHTTPHandler(walletAttachmentsPath, http.MethodGet, o.downloadAttachmentHandler,
+			&common.OperationSpec{
+				Summary: "Streams an attachment from the vault of the user logged in. A single byte range" +
+					" may be requested with the Range header.",
+				Params: []common.Param{idParam},
+				Responses: map[int]interface{}{
+					http.StatusOK:                           nil,
+					http.StatusPartialContent:               nil,
+					http.StatusNotFound:                     nil,
+					http.StatusRequestedRangeNotSatisfiable: nil,
+				},
+			}),
+		common.NewHTTPHandler(walletAttachmentsPath, http.MethodDelete, o.deleteAttachmentHandler,
+			&common.OperationSpec{
+				Summary:   "Deletes an attachment from the vault of the user logged in.",
+				Params:    []common.Param{idParam},
+				Responses: map[int]interface{}{http.StatusOK: nil, http.StatusNotFound: nil},
+			}),
+	}
+}
+
+func (o *Operation) uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling attachment upload request")
+
+	sub, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	attachments, err := o.openVaultStore(sub, attachmentsStoreName)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to open vault: %s", err.Error())
+
+		return
+	}
+
+	manifest, err := o.saveAttachment(attachments, r.Body, r.Header.Get("Content-Type"))
+	if errors.Is(err, errAttachmentTooLarge) {
+		common.WriteErrorResponsef(w, logger, http.StatusRequestEntityTooLarge, "%s", err.Error())
+
+		return
+	}
+
+	if err != nil {
+		writeVaultErrorResponse(w, err, "failed to save attachment")
+
+		return
+	}
+
+	w.Header().Set("Location", walletAttachmentsPath+"?"+attachmentIDParam+"="+manifest.ID)
+	w.WriteHeader(http.StatusCreated)
+	common.WriteResponse(w, logger, manifest)
+}
+
+func (o *Operation) downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling attachment download request: %s", r.URL.String())
+
+	attachments, manifest, ok := o.attachment(w, r)
+	if !ok {
+		return
+	}
+
+	start, end, partial, err := parseByteRange(r.Header.Get("Range"), manifest.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", manifest.Size))
+		common.WriteErrorResponsef(w, logger, http.StatusRequestedRangeNotSatisfiable, "%s", err.Error())
+
+		return
+	}
+
+	status := http.StatusOK
+
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	if partial {
+		status = http.StatusPartialContent
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, manifest.Size))
+	}
+
+	written := false
+
+	// the chunks are read one at a time, so that the agent never holds the whole attachment
+	for offset := start; offset <= end; {
+		i := int(offset / int64(manifest.ChunkSize))
+
+		chunk, errChunk := readChunk(attachments, manifest, i)
+		if errChunk != nil && !written {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to read attachment: %s", errChunk.Error())
+
+			return
+		}
+
+		if errChunk != nil {
+			// the client sees the response end before its length
+			logger.Errorf("failed to stream attachment %s: %s", manifest.ID, errChunk.Error())
+
+			return
+		}
+
+		if !written {
+			w.Header().Set("Content-Type", manifest.ContentType)
+			w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+			w.WriteHeader(status)
+
+			written = true
+		}
+
+		from := offset - int64(i)*int64(manifest.ChunkSize)
+		to := int64(len(chunk))
+
+		if last := end - int64(i)*int64(manifest.ChunkSize) + 1; last < to {
+			to = last
+		}
+
+		_, errChunk = w.Write(chunk[from:to])
+		if errChunk != nil {
+			logger.Errorf("failed to stream attachment %s: %s", manifest.ID, errChunk.Error())
+
+			return
+		}
+
+		offset += to - from
+	}
+
+	if !written {
+		w.Header().Set("Content-Type", manifest.ContentType)
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(status)
+	}
+}
+
+func (o *Operation) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling attachment delete request: %s", r.URL.String())
+
+	attachments, manifest, ok := o.attachment(w, r)
+	if !ok {
+		return
+	}
+
+	// the manifest goes first, so that an attachment is never served with missing chunks
+	err := attachments.Delete(manifest.ID)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to delete attachment: %s", err.Error())
+
+		return
+	}
+
+	deleteChunks(attachments, manifest.Chunks)
+}
+
+// attachment returns the manifest of the attachment requested, or writes the error response.
+func (o *Operation) attachment(w http.ResponseWriter,
+	r *http.Request) (ariesstorage.Store, *AttachmentManifest, bool) {
+	sub, ok := o.loggedInUser(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	id := r.URL.Query().Get(attachmentIDParam)
+	if id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing attachment id")
+
+		return nil, nil, false
+	}
+
+	attachments, err := o.openVaultStore(sub, attachmentsStoreName)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to open vault: %s", err.Error())
+
+		return nil, nil, false
+	}
+
+	raw, err := attachments.Get(id)
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "attachment %s not found", id)
+
+		return nil, nil, false
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to read attachment: %s", err.Error())
+
+		return nil, nil, false
+	}
+
+	manifest := &AttachmentManifest{}
+
+	err = json.Unmarshal(raw, manifest)
+	if err != nil || manifest.ChunkSize <= 0 {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid attachment manifest %s", id)
+
+		return nil, nil, false
+	}
+
+	return attachments, manifest, true
+}
+
+// saveAttachment splits the attachment in chunks as it is read, and saves its manifest once every chunk is saved.
+// The chunks saved are deleted if the attachment cannot be saved whole.
+func (o *Operation) saveAttachment(attachments ariesstorage.Store, body io.Reader,
+	contentType string) (*AttachmentManifest, error) {
+	if contentType == "" {
+		contentType = defaultAttachmentType
+	}
+
+	manifest := &AttachmentManifest{
+		ID:          o.newID().URN(),
+		ContentType: contentType,
+		ChunkSize:   o.chunkSize,
+		Chunks:      []string{},
+		CreatedAt:   o.now().UTC(),
+	}
+
+	chunk := make([]byte, manifest.ChunkSize)
+
+	for {
+		n, err := io.ReadFull(body, chunk)
+		if n > 0 {
+			manifest.Size += int64(n)
+
+			if manifest.Size > maxAttachmentSize {
+				deleteChunks(attachments, manifest.Chunks)
+
+				return nil, errAttachmentTooLarge
+			}
+
+			key := fmt.Sprintf("%s#%d", manifest.ID, len(manifest.Chunks))
+
+			// the vault keeps the values as strings
+			errPut := attachments.Put(key, []byte(base64.StdEncoding.EncodeToString(chunk[:n])))
+			if errPut != nil {
+				deleteChunks(attachments, manifest.Chunks)
+
+				return nil, fmt.Errorf("failed to save chunk %d: %w", len(manifest.Chunks), errPut)
+			}
+
+			manifest.Chunks = append(manifest.Chunks, key)
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+
+		if err != nil {
+			deleteChunks(attachments, manifest.Chunks)
+
+			return nil, fmt.Errorf("failed to read attachment: %w", err)
+		}
+	}
+
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	err = attachments.Put(manifest.ID, raw)
+	if err != nil {
+		deleteChunks(attachments, manifest.Chunks)
+
+		return nil, fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+func readChunk(attachments ariesstorage.Store, manifest *AttachmentManifest, i int) ([]byte, error) {
+	if i >= len(manifest.Chunks) {
+		return nil, fmt.Errorf("chunk %d is missing from the manifest", i)
+	}
+
+	raw, err := attachments.Get(manifest.Chunks[i])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %d: %w", i, err)
+	}
+
+	chunk, err := base64.StdEncoding.DecodeString(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode chunk %d: %w", i, err)
+	}
+
+	return chunk, nil
+}
+
+func deleteChunks(attachments ariesstorage.Store, keys []string) {
+	for _, key := range keys {
+		if err := attachments.Delete(key); err != nil {
+			logger.Warnf("failed to delete attachment chunk %s: %s", key, err.Error())
+		}
+	}
+}
+
+// parseByteRange returns the first and the last byte of the single range of the Range header, and whether a part of
+// the content was requested. The whole content is returned for the headers with several ranges.
+func parseByteRange(header string, size int64) (int64, int64, bool, error) {
+	const (
+		unit   = "bytes="
+		bounds = 2
+	)
+
+	if !strings.HasPrefix(header, unit) || strings.Contains(header, ",") {
+		return 0, size - 1, false, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(header, unit), "-", bounds)
+	if len(parts) != bounds {
+		return 0, 0, false, fmt.Errorf("invalid range %s", header)
+	}
+
+	first, last := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+	var start, end int64
+
+	var err error
+
+	switch {
+	case first == "":
+		// the last bytes of the content
+		suffix, errSuffix := strconv.ParseInt(last, 10, 64)
+		if errSuffix != nil || suffix <= 0 {
+			return 0, 0, false, fmt.Errorf("invalid range %s", header)
+		}
+
+		if suffix > size {
+			suffix = size
+		}
+
+		start, end = size-suffix, size-1
+	default:
+		start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return 0, 0, false, fmt.Errorf("invalid range %s", header)
+		}
+
+		end = size - 1
+
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return 0, 0, false, fmt.Errorf("invalid range %s", header)
+			}
+
+			if end > size-1 {
+				end = size - 1
+			}
+		}
+	}
+
+	if start >= size {
+		return 0, 0, false, fmt.Errorf("range %s is beyond the %d bytes of the attachment", header, size)
+	}
+
+	return start, end, true, nil
+}
@@ -0,0 +1,314 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestOperation_WalletAttachments(t *testing.T) {
+	t.Run("streams the attachment in chunks to the vault and back", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+		o.chunkSize = 4
+
+		manifest := uploadAttachment(t, o, "0123456789", "image/png")
+		require.Equal(t, int64(10), manifest.Size)
+		require.Equal(t, "image/png", manifest.ContentType)
+		require.Len(t, manifest.Chunks, 3)
+
+		attachments, err := vault.OpenStore(attachmentsStoreName)
+		require.NoError(t, err)
+
+		for _, key := range manifest.Chunks {
+			_, err = attachments.Get(key)
+			require.NoError(t, err)
+		}
+
+		w := downloadAttachment(o, manifest.ID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "0123456789", w.Body.String())
+		require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		require.Equal(t, "10", w.Header().Get("Content-Length"))
+		require.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+
+		for header, expected := range map[string]string{
+			"bytes=3-6":  "3456",
+			"bytes=4-7":  "4567",
+			"bytes=8-":   "89",
+			"bytes=-3":   "789",
+			"bytes=9-20": "9",
+		} {
+			w = downloadAttachment(o, manifest.ID, header)
+			require.Equal(t, http.StatusPartialContent, w.Code, header)
+			require.Equal(t, expected, w.Body.String(), header)
+		}
+
+		w = downloadAttachment(o, manifest.ID, "bytes=3-6")
+		require.Equal(t, "bytes 3-6/10", w.Header().Get("Content-Range"))
+		require.Equal(t, "4", w.Header().Get("Content-Length"))
+
+		// several ranges get the whole attachment
+		w = downloadAttachment(o, manifest.ID, "bytes=0-1,4-5")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "0123456789", w.Body.String())
+
+		w = downloadAttachment(o, manifest.ID, "bytes=10-")
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+		require.Equal(t, "bytes */10", w.Header().Get("Content-Range"))
+	})
+
+	t.Run("deletes the attachment with its chunks", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+		o.chunkSize = 4
+
+		manifest := uploadAttachment(t, o, "0123456789", "")
+		require.Equal(t, defaultAttachmentType, manifest.ContentType)
+
+		w := httptest.NewRecorder()
+		o.deleteAttachmentHandler(w, httptest.NewRequest(http.MethodDelete,
+			walletAttachmentsPath+"?id="+manifest.ID, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		require.Equal(t, http.StatusNotFound, downloadAttachment(o, manifest.ID, "").Code)
+
+		attachments, err := vault.OpenStore(attachmentsStoreName)
+		require.NoError(t, err)
+
+		for _, key := range manifest.Chunks {
+			_, err = attachments.Get(key)
+			require.True(t, errors.Is(err, ariesstorage.ErrDataNotFound))
+		}
+	})
+
+	t.Run("streams empty attachments", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+
+		manifest := uploadAttachment(t, o, "", "text/plain")
+		require.Empty(t, manifest.Chunks)
+
+		w := downloadAttachment(o, manifest.ID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, w.Body.String())
+		require.Equal(t, "0", w.Header().Get("Content-Length"))
+
+		require.Equal(t, http.StatusRequestedRangeNotSatisfiable, downloadAttachment(o, manifest.ID, "bytes=0-").Code)
+	})
+
+	t.Run("request entity too large if the attachment exceeds the limit", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+		o.chunkSize = maxAttachmentSize / 4
+
+		w := httptest.NewRecorder()
+		o.uploadAttachmentHandler(w, httptest.NewRequest(http.MethodPost, walletAttachmentsPath,
+			io.LimitReader(zeros{}, maxAttachmentSize+1)))
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		requireNoAttachments(t, vault)
+	})
+
+	t.Run("deletes the chunks if the attachment cannot be saved", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+		o.chunkSize = 4
+
+		o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+			return &failingManifestVault{Provider: vault}, nil
+		}
+
+		w := httptest.NewRecorder()
+		o.uploadAttachmentHandler(w, httptest.NewRequest(http.MethodPost, walletAttachmentsPath,
+			strings.NewReader("0123456789")))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save manifest")
+
+		requireNoAttachments(t, vault)
+
+		w = httptest.NewRecorder()
+		o.uploadAttachmentHandler(w, httptest.NewRequest(http.MethodPost, walletAttachmentsPath,
+			io.MultiReader(strings.NewReader("0123456789"), &failingReader{})))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to read attachment")
+
+		requireNoAttachments(t, vault)
+	})
+
+	t.Run("internal server error if a chunk is missing", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+		o.chunkSize = 4
+
+		manifest := uploadAttachment(t, o, "0123456789", "")
+
+		attachments, err := vault.OpenStore(attachmentsStoreName)
+		require.NoError(t, err)
+		require.NoError(t, attachments.Delete(manifest.Chunks[0]))
+
+		w := downloadAttachment(o, manifest.ID, "")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to read chunk 0")
+
+		// the response is cut short once streamed
+		require.NoError(t, attachments.Delete(manifest.Chunks[2]))
+
+		w = downloadAttachment(o, manifest.ID, "bytes=4-")
+		require.Equal(t, http.StatusPartialContent, w.Code)
+		require.Equal(t, "4567", w.Body.String())
+	})
+
+	t.Run("errors reading the attachment", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+
+		require.Equal(t, http.StatusNotFound, downloadAttachment(o, "urn:uuid:unknown", "").Code)
+		require.Equal(t, http.StatusBadRequest, downloadAttachment(o, "", "").Code)
+
+		o, _, _ = setupBackupTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "")
+
+		w := downloadAttachment(o, "urn:uuid:unknown", "")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "user has no edv vault")
+
+		w = httptest.NewRecorder()
+		o.uploadAttachmentHandler(w, httptest.NewRequest(http.MethodPost, walletAttachmentsPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "user has no edv vault")
+	})
+
+	t.Run("internal server error if the manifest is invalid", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+
+		attachments, err := vault.OpenStore(attachmentsStoreName)
+		require.NoError(t, err)
+		require.NoError(t, attachments.Put("urn:uuid:1", []byte(`{}`)))
+
+		w := downloadAttachment(o, "urn:uuid:1", "")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "invalid attachment manifest urn:uuid:1")
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		for _, handler := range []http.HandlerFunc{
+			o.uploadAttachmentHandler, o.downloadAttachmentHandler, o.deleteAttachmentHandler,
+		} {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodPost, walletAttachmentsPath+"?id=urn:uuid:1", nil))
+			require.Equal(t, http.StatusForbidden, w.Code)
+		}
+	})
+}
+
+func TestParseByteRange(t *testing.T) {
+	for _, header := range []string{
+		"bytes=a-1", "bytes=-0", "bytes=-a", "bytes=5-1", "bytes=1-a", "bytes=5", "bytes=10-",
+	} {
+		_, _, _, err := parseByteRange(header, 10)
+		require.Error(t, err, header)
+	}
+
+	start, end, partial, err := parseByteRange("", 10)
+	require.NoError(t, err)
+	require.False(t, partial)
+	require.Equal(t, []int64{0, 9}, []int64{start, end})
+
+	start, end, partial, err = parseByteRange("bytes=-20", 10)
+	require.NoError(t, err)
+	require.True(t, partial)
+	require.Equal(t, []int64{0, 9}, []int64{start, end})
+}
+
+func uploadAttachment(t *testing.T, o *Operation, content, contentType string) *AttachmentManifest {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, walletAttachmentsPath, strings.NewReader(content))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+
+	w := httptest.NewRecorder()
+	o.uploadAttachmentHandler(w, r)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	manifest := &AttachmentManifest{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), manifest))
+	require.Equal(t, walletAttachmentsPath+"?id="+manifest.ID, w.Header().Get("Location"))
+
+	return manifest
+}
+
+func downloadAttachment(o *Operation, id, byteRange string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, walletAttachmentsPath+"?id="+id, nil)
+	if byteRange != "" {
+		r.Header.Set("Range", byteRange)
+	}
+
+	w := httptest.NewRecorder()
+	o.downloadAttachmentHandler(w, r)
+
+	return w
+}
+
+func requireNoAttachments(t *testing.T, vault ariesstorage.Provider) {
+	t.Helper()
+
+	attachments, err := vault.OpenStore(attachmentsStoreName)
+	require.NoError(t, err)
+
+	iter := attachments.Iterator("", ariesstorage.EndKeySuffix)
+	defer iter.Release()
+
+	require.False(t, iter.Next())
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+
+	return len(p), nil
+}
+
+type failingReader struct{}
+
+func (*failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("test")
+}
+
+// failingManifestVault fails to save the manifests of the attachments, but not their chunks.
+type failingManifestVault struct {
+	ariesstorage.Provider
+}
+
+func (v *failingManifestVault) OpenStore(name string) (ariesstorage.Store, error) {
+	store, err := v.Provider.OpenStore(name)
+
+	return &failingManifestStore{Store: store}, err
+}
+
+type failingManifestStore struct {
+	ariesstorage.Store
+}
+
+func (s *failingManifestStore) Put(k string, v []byte) error {
+	if !strings.Contains(k, "#") {
+		return errors.New("test")
+	}
+
+	return s.Store.Put(k, v)
+}
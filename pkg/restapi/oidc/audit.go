@@ -0,0 +1,45 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+)
+
+type onboardingAuditDetails struct {
+	AuthzKeyStoreURL string `json:"authzKeyStoreURL,omitempty"`
+	OpsKeyStoreURL   string `json:"opsKeyStoreURL,omitempty"`
+	OpsEDVVaultURL   string `json:"opsEDVVaultURL,omitempty"`
+	UserEDVVaultURL  string `json:"userEDVVaultURL,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// audit records an action of the user in the audit log. It is a no-op if auditing is disabled. Failures are
+// logged rather than failing the user's request.
+func (o *Operation) audit(action, sub string, details interface{}) {
+	if o.auditLog == nil {
+		return
+	}
+
+	err := o.auditLog.Record(action, sub, sub, details)
+	if err != nil {
+		logger.Errorf("failed to audit %s of user %s: %s", action, sub, err.Error())
+	}
+}
+
+func (o *Operation) auditOnboardingFailed(sub string, cause error) {
+	o.audit(audit.ActionOnboardingFailed, sub, &onboardingAuditDetails{Error: cause.Error()})
+}
+
+func (o *Operation) auditOnboardingCompleted(sub string, data *BootstrapData) {
+	o.audit(audit.ActionOnboardingCompleted, sub, &onboardingAuditDetails{
+		AuthzKeyStoreURL: data.AuthzKeyStoreURL,
+		OpsKeyStoreURL:   data.OpsKeyStoreURL,
+		OpsEDVVaultURL:   data.OpsEDVVaultURL,
+		UserEDVVaultURL:  data.UserEDVVaultURL,
+	})
+}
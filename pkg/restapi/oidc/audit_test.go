@@ -0,0 +1,126 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestOperation_Audit(t *testing.T) {
+	t.Run("audits the onboarding and the logins of a user", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupAuditTest(t, state)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{stateCookieName: state},
+		}}
+
+		w = httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		entries, err := o.auditLog.List("")
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			audit.ActionOnboardingStarted, audit.ActionOnboardingCompleted, audit.ActionLogin,
+			audit.ActionLogin, audit.ActionTokensRefreshed,
+		}, actions(entries))
+
+		sub := entries[0].User
+		for _, e := range entries {
+			require.Equal(t, sub, e.User)
+			require.Equal(t, sub, e.Actor)
+		}
+
+		require.Contains(t, string(entries[1].Details), "opsEDVVaultURL")
+	})
+
+	t.Run("audits a failed onboarding", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupAuditTest(t, state)
+		o.keyEDVClient = &mockEDVClient{CreateErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		entries, err := o.auditLog.List("")
+		require.NoError(t, err)
+		require.Equal(t, []string{audit.ActionOnboardingStarted, audit.ActionOnboardingFailed}, actions(entries))
+		require.Contains(t, string(entries[1].Details), "failed to onboard the user")
+	})
+
+	t.Run("audits the logout of a user", func(t *testing.T) {
+		o := setupAuditTest(t, uuid.New().String())
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: "alice"},
+		}}
+
+		w := httptest.NewRecorder()
+		o.userLogoutHandler(w, newUserLogoutRequest())
+		require.Equal(t, http.StatusOK, w.Code)
+
+		entries, err := o.auditLog.List("alice")
+		require.NoError(t, err)
+		require.Equal(t, []string{audit.ActionLogout}, actions(entries))
+	})
+
+	t.Run("does not fail the login if the audit log cannot be written", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupAuditTest(t, state)
+
+		var err error
+
+		o.auditLog, err = audit.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{}, ErrPut: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusFound, w.Code)
+	})
+}
+
+func setupAuditTest(t *testing.T, state string) *Operation {
+	t.Helper()
+
+	o := setupEventsTest(t, state)
+	o.store.outbox = nil
+	o.httpClient = mockKMSHTTPClient()
+
+	var err error
+
+	o.auditLog, err = audit.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	return o
+}
+
+func actions(entries []*audit.Entry) []string {
+	result := make([]string, len(entries))
+
+	for i, e := range entries {
+		result[i] = e.Action
+	}
+
+	return result
+}
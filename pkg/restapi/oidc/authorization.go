@@ -0,0 +1,99 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/trustbloc/edge-core/pkg/storage"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/authz"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+// Authorization lets through the requests the engine authorizes, deciding on the endpoint called, and the
+// attributes and authentication of the user of the session or bearer token. The requests the engine denies are
+// answered with 403, and those requiring a step-up with 401 and the challenge of RFC 9470, for the wallet UI to
+// start the step-up at the step-up endpoint.
+func Authorization(engine authz.Engine, users user.Store, cookies *cookie.Jars) common.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			subject, err := authzSubject(r, users, cookies)
+			if err != nil {
+				common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+					"failed to authorize request: %s", err.Error())
+
+				return
+			}
+
+			decision, err := engine.Authorize(r.Context(), &authz.Input{
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Subject: subject,
+			})
+			if err != nil {
+				common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+					"failed to authorize request: %s", err.Error())
+
+				return
+			}
+
+			switch decision.Effect {
+			case authz.Allow:
+				next.ServeHTTP(w, r)
+			case authz.StepUp:
+				w.Header().Set("WWW-Authenticate", (&StepUpConfig{ACRValues: decision.ACRValues}).challenge())
+				common.WriteErrorResponsef(w, logger, http.StatusUnauthorized, "%s", decision.Reason)
+			default:
+				common.WriteErrorResponsef(w, logger, http.StatusForbidden, "access denied: %s", decision.Reason)
+			}
+		})
+	}
+}
+
+// authzSubject returns the logged in user of the request, or nil.
+func authzSubject(r *http.Request, users user.Store, cookies *cookie.Jars) (*authz.Subject, error) {
+	jar, err := cookies.Open(r)
+	if err != nil {
+		return nil, nil
+	}
+
+	v, found := jar.Get(userSubCookieName)
+	if !found {
+		return nil, nil
+	}
+
+	sub, ok := v.(string)
+	if !ok || sub == "" {
+		return nil, nil
+	}
+
+	subject := &authz.Subject{Sub: sub}
+
+	usr, err := users.Get(sub)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return nil, err
+	}
+
+	if usr != nil {
+		subject.Attributes = usr.Attributes
+	}
+
+	if _, bearer := jar.(*cookie.RequestJar); bearer {
+		subject.Bearer = true
+
+		return subject, nil
+	}
+
+	auth := sessionAuthContext(jar)
+	subject.ACR, subject.AMR, subject.AuthTime = auth.ACR, auth.AMR, auth.AuthTime
+
+	return subject, nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/authz"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestAuthorization(t *testing.T) {
+	users, err := user.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	require.NoError(t, users.Save(&user.User{
+		Sub:        "alice",
+		Attributes: map[string]interface{}{"groups": []interface{}{"wallet-admins"}},
+	}))
+
+	cookies := cookie.NewStore(bytes.Repeat([]byte("a"), 32), bytes.Repeat([]byte("b"), 32))
+
+	// serve returns the response, the engine records the input it decided on
+	serve := func(engine *mockEngine, jar cookie.Jar) *httptest.ResponseRecorder {
+		handler := Authorization(engine, users, cookies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		r := httptest.NewRequest(http.MethodDelete, "/oidc/wallet", nil)
+		if jar != nil {
+			r = r.WithContext(cookie.WithJar(r.Context(), jar))
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("lets through the requests the engine allows", func(t *testing.T) {
+		session := cookies.Reset()
+		session.Set(userSubCookieName, "alice")
+		session.Set(authTimeCookieName, int64(1000))
+		session.Set(authACRCookieName, "loa3")
+		session.Set(authAMRCookieName, []string{"mfa"})
+
+		engine := &mockEngine{decision: &authz.Decision{Effect: authz.Allow}}
+
+		w := serve(engine, session)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		require.Equal(t, &authz.Input{
+			Method: http.MethodDelete,
+			Path:   "/oidc/wallet",
+			Subject: &authz.Subject{
+				Sub:        "alice",
+				Attributes: map[string]interface{}{"groups": []interface{}{"wallet-admins"}},
+				ACR:        "loa3",
+				AMR:        []string{"mfa"},
+				AuthTime:   1000,
+			},
+		}, engine.input)
+	})
+
+	t.Run("tells the engine about the bearer tokens and the users not onboarded", func(t *testing.T) {
+		engine := &mockEngine{decision: &authz.Decision{Effect: authz.Allow}}
+
+		serve(engine, cookie.NewRequestJar(map[interface{}]interface{}{userSubCookieName: "bob"}))
+		require.Equal(t, &authz.Subject{Sub: "bob", Bearer: true}, engine.input.Subject)
+	})
+
+	t.Run("tells the engine about the requests without user", func(t *testing.T) {
+		engine := &mockEngine{decision: &authz.Decision{Effect: authz.Allow}}
+
+		serve(engine, nil)
+		require.Nil(t, engine.input.Subject)
+	})
+
+	t.Run("denies the requests the engine denies", func(t *testing.T) {
+		w := serve(&mockEngine{decision: &authz.Decision{Effect: authz.Deny, Reason: "not an admin"}}, nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "access denied: not an admin")
+	})
+
+	t.Run("challenges the requests requiring a step-up", func(t *testing.T) {
+		w := serve(&mockEngine{decision: &authz.Decision{
+			Effect:    authz.StepUp,
+			Reason:    "step-up authentication required",
+			ACRValues: []string{"loa3"},
+		}}, nil)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Header().Get("WWW-Authenticate"), `error="insufficient_user_authentication"`)
+		require.Contains(t, w.Header().Get("WWW-Authenticate"), `acr_values="loa3"`)
+	})
+
+	t.Run("error if the engine fails", func(t *testing.T) {
+		w := serve(&mockEngine{err: errors.New("test")}, nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to authorize request")
+	})
+
+	t.Run("error if the user cannot be fetched", func(t *testing.T) {
+		handler := Authorization(&mockEngine{decision: &authz.Decision{Effect: authz.Allow}},
+			&mockUserStore{err: errors.New("test")}, cookies)(http.NotFoundHandler())
+
+		r := httptest.NewRequest(http.MethodGet, "/oidc/wallet", nil)
+		r = r.WithContext(cookie.WithJar(r.Context(),
+			cookie.NewRequestJar(map[interface{}]interface{}{userSubCookieName: "alice"})))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+type mockEngine struct {
+	decision *authz.Decision
+	err      error
+	input    *authz.Input
+}
+
+func (m *mockEngine) Authorize(_ context.Context, input *authz.Input) (*authz.Decision, error) {
+	m.input = input
+
+	return m.decision, m.err
+}
+
+type mockUserStore struct {
+	user.Store
+	err error
+}
+
+func (m *mockUserStore) Get(string) (*user.User, error) {
+	return nil, m.err
+}
@@ -0,0 +1,451 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	webcrypto "github.com/hyperledger/aries-framework-go/pkg/crypto/webkms"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	walletBackupPath  = "/wallet/backup"
+	walletRestorePath = "/wallet/restore"
+	// the backup is kept in a store of the user's vault of its own, apart from the credentials.
+	backupStoreName = "backups"
+	backupID        = "wallet"
+	backupSaltSize  = 32
+	backupKeySize   = 32
+)
+
+// the context of the backup keys, so that they differ from any other use of the MACs of the EDV HMAC key.
+var backupKeyInfo = []byte("edge-agent wallet backup") // nolint:gochecknoglobals // constant
+
+// WalletSource is a part of the wallet metadata kept by the agent outside the user's vault, like the DIDs and the
+// connections of the users, which is backed up with the index of their credentials.
+type WalletSource interface {
+	// Name of the part of the backups holding the metadata of the source.
+	Name() string
+	// Backup returns the metadata of the user.
+	Backup(sub string) (json.RawMessage, error)
+	// Restore restores the backed up metadata of the user.
+	Restore(sub string, metadata json.RawMessage) error
+}
+
+// backupKeyDeriver derives the key a backup is encrypted with from the salt of the backup, with the user's keys.
+type backupKeyDeriver func(keyURL string, salt []byte, data *BootstrapData, h *hubKMSHeader) ([]byte, error)
+
+// walletBackup is the wallet metadata of a user.
+type walletBackup struct {
+	CreatedAt time.Time `json:"createdAt"`
+	// Credentials are the ids of the credentials in the user's vault.
+	Credentials []string                   `json:"credentials"`
+	Sources     map[string]json.RawMessage `json:"sources,omitempty"`
+}
+
+// backupEnvelope is a walletBackup encrypted with AES-GCM. The key is derived from the user's secret, through a
+// MAC of the salt computed with the EDV HMAC key in the user's ops keystore, which cannot be used without the
+// secret. The key URL is kept so that the backups outlive the rotations of the EDV keys.
+type backupEnvelope struct {
+	CreatedAt  time.Time `json:"createdAt"`
+	KeyURL     string    `json:"keyURL"`
+	Salt       []byte    `json:"salt"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// WalletBackupInfo describes the backup of the wallet of a user.
+type WalletBackupInfo struct {
+	CreatedAt   time.Time `json:"createdAt"`
+	Credentials int       `json:"credentials"`
+	Sources     []string  `json:"sources"`
+}
+
+// WalletRestoreResult is the outcome of the restore of the backup of the wallet of a user.
+type WalletRestoreResult struct {
+	WalletBackupInfo
+	// MissingCredentials are the credentials of the backup that are no longer in the user's vault.
+	MissingCredentials []string `json:"missingCredentials"`
+}
+
+// AddWalletSource backs up the metadata of the source with the wallets of the users. The sources are added before
+// the handlers serve the requests.
+func (o *Operation) AddWalletSource(source WalletSource) {
+	o.walletSources = append(o.walletSources, source)
+}
+
+func (o *Operation) walletBackupHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(walletBackupPath, http.MethodPost, o.backupWalletHandler, &common.OperationSpec{
+			Summary: "Backs the wallet metadata of the user logged in up to their vault, encrypted with a key" +
+				" derived from their secret. The backup replaces the previous one.",
+			Responses: map[int]interface{}{
+				http.StatusCreated:             &WalletBackupInfo{},
+				http.StatusInsufficientStorage: nil,
+			},
+		}),
+		common.NewHTTPHandler(walletRestorePath, http.MethodPost, o.requireStepUp(o.restoreWalletHandler),
+			&common.OperationSpec{
+				Summary: "Restores the wallet metadata of the user logged in from the backup in their vault.",
+				Responses: map[int]interface{}{
+					http.StatusOK:           &WalletRestoreResult{},
+					http.StatusNotFound:     nil,
+					http.StatusUnauthorized: nil,
+				},
+			}),
+	}
+}
+
+func (o *Operation) backupWalletHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling wallet backup request")
+
+	sub, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := o.backupWallet(r.Context(), sub)
+	if err != nil {
+		writeVaultErrorResponse(w, err, "failed to back up wallet")
+
+		return
+	}
+
+	o.audit(audit.ActionWalletBackedUp, sub, info)
+
+	w.WriteHeader(http.StatusCreated)
+	common.WriteResponse(w, logger, info)
+}
+
+func (o *Operation) restoreWalletHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling wallet restore request")
+
+	sub, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := o.restoreWallet(r.Context(), sub)
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "wallet was never backed up")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to restore wallet: %s", err.Error())
+
+		return
+	}
+
+	o.audit(audit.ActionWalletRestored, sub, result)
+
+	common.WriteResponse(w, logger, result)
+}
+
+// loggedInUser returns the sub of the user logged in, or writes the error response.
+func (o *Operation) loggedInUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return "", false
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return "", false
+	}
+
+	sub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return "", false
+	}
+
+	return sub, true
+}
+
+func (o *Operation) backupWallet(ctx context.Context, sub string) (*WalletBackupInfo, error) {
+	backup := &walletBackup{CreatedAt: o.now().UTC(), Sources: map[string]json.RawMessage{}}
+
+	var err error
+
+	backup.Credentials, err = o.edvDocumentIDs(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, source := range o.walletSources {
+		backup.Sources[source.Name()], err = source.Backup(sub)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s metadata: %w", source.Name(), err)
+		}
+	}
+
+	plaintext, err := json.Marshal(backup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup: %w", err)
+	}
+
+	keys, err := o.userKeys(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &backupEnvelope{
+		CreatedAt: backup.CreatedAt,
+		KeyURL:    keys.data.EDVHMACKIDURL,
+		Salt:      make([]byte, backupSaltSize),
+	}
+
+	_, err = io.ReadFull(o.random, envelope.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := o.backupCipher(envelope, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope.Nonce = make([]byte, aead.NonceSize())
+
+	_, err = io.ReadFull(o.random, envelope.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	envelope.Ciphertext = aead.Seal(nil, envelope.Nonce, plaintext, []byte(sub))
+
+	backups, err := o.openBackupStore(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	bits, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup envelope: %w", err)
+	}
+
+	err = backups.Put(backupID, bits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save backup: %w", err)
+	}
+
+	return backup.info(), nil
+}
+
+// restoreWallet restores the metadata of the sources from the backup of the user, and checks that the user's
+// vault still holds the backed up credentials. The error wraps ariesstorage.ErrDataNotFound if the user has no
+// backup.
+func (o *Operation) restoreWallet(ctx context.Context, sub string) (*WalletRestoreResult, error) {
+	keys, err := o.userKeys(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := o.openBackupStore(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	bits, err := backups.Get(backupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch backup: %w", err)
+	}
+
+	envelope := &backupEnvelope{}
+
+	err = json.Unmarshal(bits, envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal backup envelope: %w", err)
+	}
+
+	aead, err := o.backupCipher(envelope, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, []byte(sub))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+
+	backup := &walletBackup{}
+
+	err = json.Unmarshal(plaintext, backup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal backup: %w", err)
+	}
+
+	for _, source := range o.walletSources {
+		metadata, found := backup.Sources[source.Name()]
+		if !found {
+			continue
+		}
+
+		err = source.Restore(sub, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s metadata: %w", source.Name(), err)
+		}
+	}
+
+	credentials, err := o.edvDocumentIDs(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WalletRestoreResult{
+		WalletBackupInfo:   *backup.info(),
+		MissingCredentials: missing(backup.Credentials, credentials),
+	}, nil
+}
+
+// userKeys are what the agent needs to use the user's keys.
+type userKeys struct {
+	sub    string
+	access string
+	data   *BootstrapData
+	header *hubKMSHeader
+}
+
+func (o *Operation) userKeys(ctx context.Context, sub string) (*userKeys, error) {
+	usr, err := o.store.users.Get(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user data: %w", err)
+	}
+
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user tokens: %w", err)
+	}
+
+	userID := o.pseudonyms.ID(sub)
+
+	data, err := o.keystores(ctx, userID, tokns.Access)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bootstrap data: %w", err)
+	}
+
+	if data == nil || data.UserEDVVaultURL == "" {
+		return nil, errors.New("user has no edv vault")
+	}
+
+	return &userKeys{
+		sub:    sub,
+		access: tokns.Access,
+		data:   data,
+		header: &hubKMSHeader{
+			userSub:     userID,
+			secretShare: usr.SecretShare,
+			accessToken: tokns.Access,
+		},
+	}, nil
+}
+
+func (o *Operation) openBackupStore(keys *userKeys) (ariesstorage.Store, error) {
+	vault, err := o.userVault(keys.sub, keys.access)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := vault.OpenStore(backupStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backups store: %w", err)
+	}
+
+	return &quotaStore{Store: backups, sub: keys.sub, usage: o.usage}, nil
+}
+
+func (o *Operation) backupCipher(envelope *backupEnvelope, keys *userKeys) (cipher.AEAD, error) {
+	key, err := o.deriveBackupKey(envelope.KeyURL, envelope.Salt, keys.data, keys.header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive backup key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init aes: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init aes-gcm: %w", err)
+	}
+
+	return aead, nil
+}
+
+// deriveUserBackupKey expands the MAC of the salt computed by the user's ops keystore into a key.
+func (o *Operation) deriveUserBackupKey(keyURL string, salt []byte, data *BootstrapData,
+	h *hubKMSHeader) ([]byte, error) {
+	crypto := webcrypto.New(data.OpsKeyStoreURL, o.kmsClient(h), opsKMSHeaders(h))
+
+	mac, err := crypto.ComputeMAC(salt, keyURL)
+	if err != nil {
+		return nil, fmt.Errorf("compute mac of backup salt: %w", err)
+	}
+
+	key := make([]byte, backupKeySize)
+
+	_, err = io.ReadFull(hkdf.New(sha256.New, mac, salt, backupKeyInfo), key)
+	if err != nil {
+		return nil, fmt.Errorf("expand backup key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (b *walletBackup) info() *WalletBackupInfo {
+	info := &WalletBackupInfo{CreatedAt: b.CreatedAt, Credentials: len(b.Credentials), Sources: []string{}}
+
+	for name := range b.Sources {
+		info.Sources = append(info.Sources, name)
+	}
+
+	sort.Strings(info.Sources)
+
+	return info
+}
+
+// missing returns the ids of 'backedUp' that are not in 'current'.
+func missing(backedUp, current []string) []string {
+	found := make(map[string]bool, len(current))
+
+	for _, id := range current {
+		found[id] = true
+	}
+
+	ids := []string{}
+
+	for _, id := range backedUp {
+		if !found[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,239 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	ariesmem "github.com/hyperledger/aries-framework-go/pkg/storage/mem"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+)
+
+func TestOperation_WalletBackup(t *testing.T) {
+	t.Run("backs the wallet up and restores it", func(t *testing.T) {
+		o, sub, vault := setupBackupTest(t)
+
+		auditLog, err := audit.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		o.auditLog = auditLog
+
+		source := &mockWalletSource{metadata: json.RawMessage(`{"dids":["did:peer:123"]}`)}
+		o.AddWalletSource(source)
+
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte(`{}`)))
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:2", []byte(`{}`)))
+
+		w := httptest.NewRecorder()
+		o.backupWalletHandler(w, httptest.NewRequest(http.MethodPost, walletBackupPath, nil))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		info := &WalletBackupInfo{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), info))
+		require.Equal(t, 2, info.Credentials)
+		require.Equal(t, []string{"mock"}, info.Sources)
+
+		// the backup is encrypted in the user vault
+		backups, err := vault.OpenStore(backupStoreName)
+		require.NoError(t, err)
+
+		raw, err := backups.Get(backupID)
+		require.NoError(t, err)
+		require.NotContains(t, string(raw), "did:peer:123")
+		require.NotContains(t, string(raw), "urn:uuid:1")
+
+		credentials, err := vault.OpenStore(credentialsStoreName)
+		require.NoError(t, err)
+		require.NoError(t, credentials.Delete("urn:uuid:2"))
+
+		w = httptest.NewRecorder()
+		o.restoreWalletHandler(w, httptest.NewRequest(http.MethodPost, walletRestorePath, nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		result := &WalletRestoreResult{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+		require.Equal(t, 2, result.Credentials)
+		require.Equal(t, []string{"urn:uuid:2"}, result.MissingCredentials)
+		require.JSONEq(t, `{"dids":["did:peer:123"]}`, string(source.restored[sub]))
+
+		entries, err := auditLog.List(sub)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, audit.ActionWalletBackedUp, entries[0].Action)
+		require.Equal(t, audit.ActionWalletRestored, entries[1].Action)
+	})
+
+	t.Run("not found if the wallet was never backed up", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+
+		w := httptest.NewRecorder()
+		o.restoreWalletHandler(w, httptest.NewRequest(http.MethodPost, walletRestorePath, nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("error if the backup key changed", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+
+		w := httptest.NewRecorder()
+		o.backupWalletHandler(w, httptest.NewRequest(http.MethodPost, walletBackupPath, nil))
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		o.deriveBackupKey = func(string, []byte, *BootstrapData, *hubKMSHeader) ([]byte, error) {
+			return make([]byte, backupKeySize), nil
+		}
+
+		w = httptest.NewRecorder()
+		o.restoreWalletHandler(w, httptest.NewRequest(http.MethodPost, walletRestorePath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to decrypt backup")
+	})
+
+	t.Run("error if a source fails", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+
+		source := &mockWalletSource{}
+		o.AddWalletSource(source)
+
+		w := httptest.NewRecorder()
+		o.backupWalletHandler(w, httptest.NewRequest(http.MethodPost, walletBackupPath, nil))
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		source.err = errors.New("test")
+
+		w = httptest.NewRecorder()
+		o.restoreWalletHandler(w, httptest.NewRequest(http.MethodPost, walletRestorePath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to restore mock metadata")
+
+		w = httptest.NewRecorder()
+		o.backupWalletHandler(w, httptest.NewRequest(http.MethodPost, walletBackupPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to back up mock metadata")
+	})
+
+	t.Run("error if the backup key cannot be derived", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+		o.deriveBackupKey = func(string, []byte, *BootstrapData, *hubKMSHeader) ([]byte, error) {
+			return nil, errors.New("test")
+		}
+
+		w := httptest.NewRecorder()
+		o.backupWalletHandler(w, httptest.NewRequest(http.MethodPost, walletBackupPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to derive backup key")
+	})
+
+	t.Run("error if the user has no vault", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "")
+
+		for _, handler := range []http.HandlerFunc{o.backupWalletHandler, o.restoreWalletHandler} {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodPost, walletBackupPath, nil))
+			require.Equal(t, http.StatusInternalServerError, w.Code)
+			require.Contains(t, w.Body.String(), "user has no edv vault")
+		}
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		for _, handler := range []http.HandlerFunc{o.backupWalletHandler, o.restoreWalletHandler} {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodPost, walletBackupPath, nil))
+			require.Equal(t, http.StatusForbidden, w.Code)
+		}
+	})
+}
+
+func TestOperation_DeriveUserBackupKey(t *testing.T) {
+	kms := mockOpsKMS(t, []byte("{}"))
+	defer kms.Close()
+
+	o, err := New(config(t))
+	require.NoError(t, err)
+
+	data := vaultBootstrapData(kms.URL)
+
+	key, err := o.deriveUserBackupKey(data.EDVHMACKIDURL, []byte("salt"), data, &hubKMSHeader{})
+	require.NoError(t, err)
+	require.Len(t, key, backupKeySize)
+
+	other, err := o.deriveUserBackupKey(data.EDVHMACKIDURL, []byte("other salt"), data, &hubKMSHeader{})
+	require.NoError(t, err)
+	require.NotEqual(t, key, other)
+
+	_, err = o.deriveUserBackupKey(kms.URL+"/kms/keystores/456/keys/missing", []byte("salt"), data, &hubKMSHeader{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "compute mac of backup salt")
+}
+
+// setupBackupTest returns an Operation with a logged in user whose vault is in memory, and whose backup keys
+// are derived from the salt alone.
+func setupBackupTest(t *testing.T) (*Operation, string, ariesstorage.Provider) {
+	t.Helper()
+
+	o, sub := setupCheckTest(t)
+	o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+	o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{
+		userSubCookieName: sub,
+	}}}
+
+	vault := ariesmem.NewProvider()
+
+	o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+		return vault, nil
+	}
+
+	o.deriveBackupKey = func(_ string, salt []byte, _ *BootstrapData, h *hubKMSHeader) ([]byte, error) {
+		require.Equal(t, "share", h.secretShare)
+
+		key := sha256.Sum256(salt)
+
+		return key[:], nil
+	}
+
+	return o, sub, vault
+}
+
+type mockWalletSource struct {
+	metadata json.RawMessage
+	restored map[string]json.RawMessage
+	err      error
+}
+
+func (m *mockWalletSource) Name() string {
+	return "mock"
+}
+
+func (m *mockWalletSource) Backup(string) (json.RawMessage, error) {
+	if m.metadata == nil {
+		return json.RawMessage(`{}`), m.err
+	}
+
+	return m.metadata, m.err
+}
+
+func (m *mockWalletSource) Restore(sub string, metadata json.RawMessage) error {
+	if m.restored == nil {
+		m.restored = map[string]json.RawMessage{}
+	}
+
+	m.restored[sub] = metadata
+
+	return m.err
+}
@@ -0,0 +1,170 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	agentsigner "github.com/trustbloc/edge-agent/pkg/restapi/common/signer"
+)
+
+const (
+	// BootstrapDataVersion is the version of the schema of the bootstrap data posted by the agent. The data posted
+	// before it was versioned has version 0.
+	BootstrapDataVersion = 1
+	// the bootstrap data is signed with an Ed25519 key.
+	bootstrapSignatureAlgorithm = agentsigner.Algorithm
+)
+
+// BootstrapSigningConfig configures the signature of the bootstrap data of the users. The browser fetches the
+// bootstrap data from hub-auth, so the agent signs it and verifies the signature when it reads it back, for the
+// data tampered with or partially written to be detected.
+type BootstrapSigningConfig struct {
+	Signer agentsigner.Signer
+	// Require rejects the bootstrap data posted before it was signed. It is accepted with a warning otherwise, and
+	// signed on its next update.
+	Require bool
+}
+
+type bootstrapHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// postBootstrapData versions and signs the bootstrap data, then hands it to hub-auth.
+func (o *Operation) postBootstrapData(ctx context.Context, accessToken string, data *BootstrapData) error {
+	signed, err := o.signBootstrapData(data)
+	if err != nil {
+		return err
+	}
+
+	return postUserBootstrapData(ctx, o.hubAuthURL, accessToken, signed, o.httpClient)
+}
+
+// signBootstrapData returns a copy of the data with the current version and, if the bootstrap data is signed,
+// its detached JWS (RFC 7515, Appendix F).
+func (o *Operation) signBootstrapData(data *BootstrapData) (*BootstrapData, error) {
+	signed := *data
+	signed.Version = BootstrapDataVersion
+	signed.JWS = ""
+
+	if o.bootstrapSigning == nil {
+		return &signed, nil
+	}
+
+	payload, err := json.Marshal(&signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal bootstrap data: %w", err)
+	}
+
+	kid, err := o.bootstrapSigning.Signer.KeyID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bootstrap data signing key: %w", err)
+	}
+
+	header, err := encodeBootstrapHeader(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := o.bootstrapSigning.Signer.Sign(bootstrapSigningInput(header, payload), kid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign bootstrap data: %w", err)
+	}
+
+	signed.JWS = header + ".." + base64.RawURLEncoding.EncodeToString(signature)
+
+	return &signed, nil
+}
+
+// verifyBootstrapData verifies the version and the signature of the bootstrap data read back from hub-auth.
+func (o *Operation) verifyBootstrapData(data *BootstrapData) error {
+	if data == nil {
+		return nil
+	}
+
+	if data.Version > BootstrapDataVersion {
+		return fmt.Errorf("unsupported bootstrap data version %d", data.Version)
+	}
+
+	if o.bootstrapSigning == nil {
+		return nil
+	}
+
+	if data.JWS == "" {
+		if o.bootstrapSigning.Require {
+			return errors.New("bootstrap data is not signed")
+		}
+
+		logger.Warnf("bootstrap data of version %d is not signed", data.Version)
+
+		return nil
+	}
+
+	parts := strings.Split(data.JWS, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return errors.New("bootstrap data signature is not a detached JWS")
+	}
+
+	header := &bootstrapHeader{}
+
+	err := decodeSegment(parts[0], header)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap data signature header: %w", err)
+	}
+
+	if header.Algorithm != bootstrapSignatureAlgorithm {
+		return fmt.Errorf("unsupported bootstrap data signature algorithm %s", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap data signature: %w", err)
+	}
+
+	unsigned := *data
+	unsigned.JWS = ""
+
+	payload, err := json.Marshal(&unsigned)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bootstrap data: %w", err)
+	}
+
+	err = o.bootstrapSigning.Signer.Verify(signature, bootstrapSigningInput(parts[0], payload), header.KeyID)
+	if err != nil {
+		return fmt.Errorf("invalid bootstrap data signature: %w", err)
+	}
+
+	return nil
+}
+
+func encodeBootstrapHeader(kid string) (string, error) {
+	bits, err := json.Marshal(&bootstrapHeader{Algorithm: bootstrapSignatureAlgorithm, KeyID: kid})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal bootstrap data signature header: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bits), nil
+}
+
+func bootstrapSigningInput(header string, payload []byte) []byte {
+	return []byte(header + "." + base64.RawURLEncoding.EncodeToString(payload))
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	bits, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bits, v)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperation_BootstrapSigning(t *testing.T) {
+	t.Run("signs the bootstrap data posted and verifies it when read back", func(t *testing.T) {
+		o, hubAuth := setupBootstrapSigningTest(t)
+
+		data := &BootstrapData{UserEDVVaultURL: "https://edv.example.com/vaults/1", OpsKeyStoreURL: "ops"}
+		require.NoError(t, o.postBootstrapData(context.Background(), "token", data))
+		require.Empty(t, data.JWS)
+
+		posted := hubAuth.data()
+		require.Equal(t, BootstrapDataVersion, posted.Version)
+		require.Equal(t, data.UserEDVVaultURL, posted.UserEDVVaultURL)
+
+		parts := strings.Split(posted.JWS, ".")
+		require.Len(t, parts, 3)
+		require.Empty(t, parts[1])
+
+		header := &bootstrapHeader{}
+		require.NoError(t, decodeSegment(parts[0], header))
+		require.Equal(t, &bootstrapHeader{Algorithm: "EdDSA", KeyID: "agent-key"}, header)
+
+		fetched, err := o.fetchBootstrapData(context.Background(), "token")
+		require.NoError(t, err)
+		require.Equal(t, posted, fetched.Data)
+	})
+
+	t.Run("detects the bootstrap data tampered with or partially written", func(t *testing.T) {
+		o, hubAuth := setupBootstrapSigningTest(t)
+		require.NoError(t, o.postBootstrapData(context.Background(), "token", &BootstrapData{OpsKeyStoreURL: "ops"}))
+
+		signed := *hubAuth.data()
+
+		for name, tamper := range map[string]func(d *BootstrapData){
+			"field changed":       func(d *BootstrapData) { d.OpsKeyStoreURL = "https://attacker.example.com" },
+			"field added":         func(d *BootstrapData) { d.UserEDVCapability = "{}" },
+			"version downgraded":  func(d *BootstrapData) { d.Version = 0 },
+			"signature truncated": func(d *BootstrapData) { d.JWS = d.JWS[:len(d.JWS)-4] },
+			"payload attached":    func(d *BootstrapData) { d.JWS = strings.Replace(d.JWS, "..", ".e30.", 1) },
+			"other key": func(d *BootstrapData) {
+				d.JWS = encodeHeader(t, "EdDSA", "other-key") + d.JWS[strings.Index(d.JWS, "."):]
+			},
+			"other algorithm": func(d *BootstrapData) {
+				d.JWS = encodeHeader(t, "none", "agent-key") + d.JWS[strings.Index(d.JWS, "."):]
+			},
+		} {
+			tampered := signed
+			tamper(&tampered)
+			hubAuth.bootstrap = &tampered
+
+			_, err := o.fetchBootstrapData(context.Background(), "token")
+			require.Error(t, err, name)
+			require.Contains(t, err.Error(), "verify bootstrap data", name)
+		}
+	})
+
+	t.Run("accepts the bootstrap data posted before it was signed unless required", func(t *testing.T) {
+		o, hubAuth := setupBootstrapSigningTest(t)
+		hubAuth.bootstrap = &BootstrapData{OpsKeyStoreURL: "ops"}
+
+		fetched, err := o.fetchBootstrapData(context.Background(), "token")
+		require.NoError(t, err)
+		require.Equal(t, "ops", fetched.Data.OpsKeyStoreURL)
+
+		o.bootstrapSigning.Require = true
+
+		_, err = o.fetchBootstrapData(context.Background(), "token")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "bootstrap data is not signed")
+
+		// the users who never onboarded have no bootstrap data
+		hubAuth.bootstrap = nil
+
+		fetched, err = o.fetchBootstrapData(context.Background(), "token")
+		require.NoError(t, err)
+		require.Nil(t, fetched.Data)
+	})
+
+	t.Run("only versions the bootstrap data if it is not signed", func(t *testing.T) {
+		o, hubAuth := setupBootstrapSigningTest(t)
+		o.bootstrapSigning = nil
+
+		require.NoError(t, o.postBootstrapData(context.Background(), "token", &BootstrapData{OpsKeyStoreURL: "ops"}))
+		require.Equal(t, &BootstrapData{OpsKeyStoreURL: "ops", Version: BootstrapDataVersion}, hubAuth.data())
+
+		_, err := o.fetchBootstrapData(context.Background(), "token")
+		require.NoError(t, err)
+	})
+
+	t.Run("error if the bootstrap data is of a later version", func(t *testing.T) {
+		o, hubAuth := setupBootstrapSigningTest(t)
+		o.bootstrapSigning = nil
+		hubAuth.bootstrap = &BootstrapData{Version: BootstrapDataVersion + 1}
+
+		_, err := o.fetchBootstrapData(context.Background(), "token")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported bootstrap data version 2")
+	})
+
+	t.Run("error if the bootstrap data cannot be signed", func(t *testing.T) {
+		o, _ := setupBootstrapSigningTest(t)
+		o.bootstrapSigning.Signer = &ed25519Signer{err: errors.New("test")}
+
+		err := o.postBootstrapData(context.Background(), "token", &BootstrapData{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to get bootstrap data signing key")
+
+		signer := newED25519Signer(t)
+		signer.signErr = errors.New("test")
+		o.bootstrapSigning.Signer = signer
+
+		err = o.postBootstrapData(context.Background(), "token", &BootstrapData{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to sign bootstrap data")
+	})
+}
+
+func setupBootstrapSigningTest(t *testing.T) (*Operation, *mockHubAuth) {
+	t.Helper()
+
+	config := config(t)
+	config.BootstrapSigning = &BootstrapSigningConfig{Signer: newED25519Signer(t)}
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	hubAuth := &mockHubAuth{}
+	o.httpClient = hubAuth
+
+	return o, hubAuth
+}
+
+type ed25519Signer struct {
+	key     ed25519.PrivateKey
+	err     error
+	signErr error
+}
+
+func newED25519Signer(t *testing.T) *ed25519Signer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return &ed25519Signer{key: key}
+}
+
+func (s *ed25519Signer) KeyID() (string, error) {
+	return "agent-key", s.err
+}
+
+func (s *ed25519Signer) Sign(data []byte, _ string) ([]byte, error) {
+	if s.signErr != nil {
+		return nil, s.signErr
+	}
+
+	return ed25519.Sign(s.key, data), nil
+}
+
+func (s *ed25519Signer) Verify(signature, data []byte, kid string) error {
+	if kid != "agent-key" {
+		return errors.New("unknown key")
+	}
+
+	if !ed25519.Verify(s.key.Public().(ed25519.PublicKey), data, signature) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+func encodeHeader(t *testing.T, alg, kid string) string {
+	t.Helper()
+
+	bits, err := json.Marshal(&bootstrapHeader{Algorithm: alg, KeyID: kid})
+	require.NoError(t, err)
+
+	return base64.RawURLEncoding.EncodeToString(bits)
+}
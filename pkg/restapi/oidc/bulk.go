@@ -0,0 +1,388 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/query"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Admin endpoints.
+const (
+	usersPath        = "/users"
+	userTokensPath   = usersPath + "/tokens"
+	revokeTokensPath = userTokensPath + "/revoke"
+	reonboardPath    = usersPath + "/onboard"
+)
+
+const (
+	idpParam      = "idp"
+	fromParam     = "from"
+	toParam       = "to"
+	ndjsonContent = "application/x-ndjson"
+)
+
+// the pages of the admin lists hold query.DefaultLimit items by default.
+var pageOptions = &query.Options{}
+
+// UsersPage is a page of the users, without the agent's half of their secrets.
+type UsersPage struct {
+	Users []*user.User `json:"users"`
+	// Next is the cursor of the next page, empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// TokensPage is a page of the logins whose tokens the agent holds, without the tokens.
+type TokensPage struct {
+	Tokens []*TokensInfo `json:"tokens"`
+	// Next is the cursor of the next page, empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// TokensInfo tells who issued the tokens of a user, and when.
+type TokensInfo struct {
+	Sub      string     `json:"sub"`
+	Issuer   string     `json:"issuer,omitempty"`
+	IssuedAt *time.Time `json:"issuedAt,omitempty"`
+}
+
+// BulkProgress is written as a JSON line once each batch of a bulk operation is done. The counts add up over the
+// batches.
+type BulkProgress struct {
+	// Processed counts the users listed, Applied those the operation was applied to, and Failed those it failed for.
+	Processed int `json:"processed"`
+	Applied   int `json:"applied"`
+	Failed    int `json:"failed"`
+	// Errors are the failures of the batch.
+	Errors []*BulkError `json:"errors,omitempty"`
+	// Cursor resumes the operation past the batch.
+	Cursor string `json:"cursor,omitempty"`
+	Done   bool   `json:"done"`
+	// Error aborted the operation, which resumes at Cursor.
+	Error string `json:"error,omitempty"`
+}
+
+// BulkError is the failure of a bulk operation for a user.
+type BulkError struct {
+	Sub   string `json:"sub"`
+	Error string `json:"error"`
+}
+
+// tokensFilter selects the tokens issued by an issuer and at a time, any if empty.
+type tokensFilter struct {
+	issuer string
+	// from is inclusive and to exclusive
+	from, to *time.Time
+}
+
+type revokedTokensAuditDetails struct {
+	Issuer string `json:"issuer,omitempty"`
+}
+
+func (f *tokensFilter) empty() bool {
+	return f.issuer == "" && f.from == nil && f.to == nil
+}
+
+// matches tells whether the tokens are selected. The tokens saved without their time of issue are only selected
+// if there are no bounds on the time.
+func (f *tokensFilter) matches(t *tokens.UserTokens) bool {
+	if f.issuer != "" && t.Issuer != f.issuer {
+		return false
+	}
+
+	if f.from == nil && f.to == nil {
+		return true
+	}
+
+	return t.IssuedAt != nil && (f.from == nil || !t.IssuedAt.Before(*f.from)) &&
+		(f.to == nil || t.IssuedAt.Before(*f.to))
+}
+
+// RevokeTokens deletes the tokens the agent holds for the user and the user's remember-me tokens, so that the
+// agent no longer acts on behalf of the user until the user logs in again.
+func (o *Operation) RevokeTokens(sub string) error {
+	tokns, err := o.store.tokens.Get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to fetch user tokens: %w", err)
+	}
+
+	err = o.store.tokens.Delete(sub)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to delete user tokens: %w", err)
+	}
+
+	if o.remember != nil {
+		err = o.remember.RevokeUser(sub)
+		if err != nil {
+			return err
+		}
+	}
+
+	o.vaults.Remove(sub)
+	o.invalidateUserInfo(sub)
+	o.invalidateKeystores(o.pseudonyms.ID(sub))
+	o.audit(audit.ActionTokensRevoked, sub, &revokedTokensAuditDetails{Issuer: tokns.Issuer})
+
+	return nil
+}
+
+func (o *Operation) bulkHandlers() []common.Handler {
+	page := pageOptions.Spec("users")
+	filter := []common.Param{
+		common.QueryParam(idpParam, "Issuer of the ID tokens of the logins, e.g. the URL of the OIDC provider."),
+		common.QueryParam(fromParam, "Logins at or after the time, in RFC 3339."),
+		common.QueryParam(toParam, "Logins before the time, in RFC 3339."),
+	}
+
+	return []common.Handler{
+		common.NewHTTPHandler(usersPath, http.MethodGet, o.usersHandler, &common.OperationSpec{
+			Summary:   "Lists a page of the users.",
+			Params:    page,
+			Responses: map[int]interface{}{http.StatusOK: &UsersPage{}},
+		}),
+		common.NewHTTPHandler(userTokensPath, http.MethodGet, o.userTokensHandler, &common.OperationSpec{
+			Summary: "Lists a page of the logins whose tokens the agent holds. The pages hold fewer logins than the" +
+				" limit if they are filtered.",
+			Params:    append(append([]common.Param{}, page...), filter...),
+			Responses: map[int]interface{}{http.StatusOK: &TokensPage{}},
+		}),
+		common.NewHTTPHandler(revokeTokensPath, http.MethodPost, o.revokeTokensHandler, &common.OperationSpec{
+			Summary: "Revokes the tokens of the logins with an identity provider or in a time range, in batches of" +
+				" the limit. The progress is streamed as JSON lines.",
+			Params:    append(append([]common.Param{}, page...), filter...),
+			Responses: map[int]interface{}{http.StatusOK: &BulkProgress{}},
+		}),
+		common.NewHTTPHandler(reonboardPath, http.MethodPost, o.reonboardHandler, &common.OperationSpec{
+			Summary: "Onboards again the users whose onboarding failed, in batches of the limit. The progress is" +
+				" streamed as JSON lines.",
+			Params:    append(append([]common.Param{}, page...), filter...),
+			Responses: map[int]interface{}{http.StatusOK: &BulkProgress{}},
+		}),
+	}
+}
+
+func (o *Operation) usersHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	users, next, err := o.store.users.Page(cursor, limit)
+	if err != nil {
+		query.WriteError(w, err)
+
+		return
+	}
+
+	for _, usr := range users {
+		usr.SecretShare = ""
+	}
+
+	query.SetNextLink(w, r, next)
+	common.WriteResponse(w, logger, &UsersPage{Users: users, Next: next})
+}
+
+func (o *Operation) userTokensHandler(w http.ResponseWriter, r *http.Request) {
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	filter, ok := filterParams(w, r)
+	if !ok {
+		return
+	}
+
+	list, next, err := o.store.tokens.Page(cursor, limit)
+	if err != nil {
+		query.WriteError(w, err)
+
+		return
+	}
+
+	page := &TokensPage{Tokens: []*TokensInfo{}, Next: next}
+
+	for _, t := range list {
+		if filter.matches(t) {
+			page.Tokens = append(page.Tokens, &TokensInfo{Sub: t.UserSub, Issuer: t.Issuer, IssuedAt: t.IssuedAt})
+		}
+	}
+
+	query.SetNextLink(w, r, next)
+	common.WriteResponse(w, logger, page)
+}
+
+func (o *Operation) revokeTokensHandler(w http.ResponseWriter, r *http.Request) {
+	filter, ok := filterParams(w, r)
+	if !ok {
+		return
+	}
+
+	if filter.empty() {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing %s, %s or %s parameter", idpParam,
+			fromParam, toParam)
+
+		return
+	}
+
+	o.bulk(w, r, filter, func(_ context.Context, t *tokens.UserTokens) (bool, error) {
+		return true, o.RevokeTokens(t.UserSub)
+	})
+}
+
+// reonboardHandler onboards the users with tokens but no user record, but those being onboarded.
+func (o *Operation) reonboardHandler(w http.ResponseWriter, r *http.Request) {
+	filter, ok := filterParams(w, r)
+	if !ok {
+		return
+	}
+
+	o.bulk(w, r, filter, func(ctx context.Context, t *tokens.UserTokens) (bool, error) {
+		_, err := o.store.users.Get(t.UserSub)
+		if err == nil {
+			return false, nil
+		}
+
+		if !errors.Is(err, storage.ErrValueNotFound) {
+			return false, fmt.Errorf("failed to query user data: %w", err)
+		}
+
+		if o.onboardingUnderWay(t.UserSub) {
+			return false, nil
+		}
+
+		_, err = o.Onboard(ctx, t.UserSub)
+
+		return true, err
+	})
+}
+
+// bulk applies the operation to the tokens selected by the filter, a page at a time, and streams the progress of
+// each page. The operation tells whether it applied to the user of the tokens.
+func (o *Operation) bulk(w http.ResponseWriter, r *http.Request, filter *tokensFilter,
+	apply func(ctx context.Context, t *tokens.UserTokens) (bool, error)) {
+	cursor, limit, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	// fails early on the invalid cursors, ahead of the stream
+	list, next, err := o.store.tokens.Page(cursor, limit)
+	if err != nil {
+		query.WriteError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", ndjsonContent)
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher) // nolint:errcheck // the progress is written at once without flusher
+	progress := &BulkProgress{Cursor: cursor}
+
+	for {
+		progress.Errors = nil
+
+		for _, t := range list {
+			if !filter.matches(t) {
+				continue
+			}
+
+			progress.Processed++
+
+			applied, applyErr := apply(r.Context(), t)
+
+			switch {
+			case applyErr != nil:
+				progress.Failed++
+				progress.Errors = append(progress.Errors, &BulkError{Sub: t.UserSub, Error: applyErr.Error()})
+			case applied:
+				progress.Applied++
+			}
+		}
+
+		progress.Cursor = next
+		progress.Done = next == ""
+
+		if r.Context().Err() != nil {
+			progress.Error = r.Context().Err().Error()
+		}
+
+		err = encoder.Encode(progress)
+		if err != nil {
+			logger.Errorf("failed to write bulk progress: %s", err.Error())
+
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if progress.Done || progress.Error != "" {
+			return
+		}
+
+		list, next, err = o.store.tokens.Page(next, limit)
+		if err != nil {
+			progress.Errors = nil
+			progress.Error = err.Error()
+
+			_ = encoder.Encode(progress) // nolint:errcheck // the stream is over either way
+
+			return
+		}
+	}
+}
+
+func pageParams(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	p, err := query.Parse(r.URL.Query(), pageOptions)
+	if err != nil {
+		query.WriteError(w, err)
+
+		return "", 0, false
+	}
+
+	return p.Cursor, p.Limit, true
+}
+
+func filterParams(w http.ResponseWriter, r *http.Request) (*tokensFilter, bool) {
+	filter := &tokensFilter{issuer: r.URL.Query().Get(idpParam)}
+
+	for name, bound := range map[string]**time.Time{fromParam: &filter.from, toParam: &filter.to} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid %s parameter: %s", name, err.Error())
+
+			return nil, false
+		}
+
+		*bound = &t
+	}
+
+	return filter, true
+}
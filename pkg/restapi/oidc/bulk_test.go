@@ -0,0 +1,283 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage"
+
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestOperation_UsersHandler(t *testing.T) {
+	o, err := New(config(t))
+	require.NoError(t, err)
+
+	for _, sub := range []string{"carol", "alice", "bob"} {
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub, SecretShare: "share"}))
+	}
+
+	t.Run("lists the users a page at a time", func(t *testing.T) {
+		page := &UsersPage{}
+		requireBulk(t, o.usersHandler, http.MethodGet, usersPath+"?limit=2", http.StatusOK, page)
+		require.Len(t, page.Users, 2)
+		require.Equal(t, "alice", page.Users[0].Sub)
+		require.Empty(t, page.Users[0].SecretShare)
+		require.NotEmpty(t, page.Next)
+
+		next := page.Next
+		page = &UsersPage{}
+		requireBulk(t, o.usersHandler, http.MethodGet, usersPath+"?limit=2&cursor="+next, http.StatusOK, page)
+		require.Len(t, page.Users, 1)
+		require.Equal(t, "carol", page.Users[0].Sub)
+		require.Empty(t, page.Next)
+	})
+
+	t.Run("links the next page", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		o.usersHandler(w, httptest.NewRequest(http.MethodGet, usersPath+"?limit=1", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		page := &UsersPage{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(page))
+		require.Equal(t, fmt.Sprintf(`<%s?cursor=%s&limit=1>; rel="next"`, usersPath, page.Next), w.Header().Get("Link"))
+	})
+
+	t.Run("bad request if the cursor is invalid", func(t *testing.T) {
+		requireBulk(t, o.usersHandler, http.MethodGet, usersPath+"?cursor=***", http.StatusBadRequest, nil)
+	})
+
+	t.Run("bad request if the limit is invalid", func(t *testing.T) {
+		requireBulk(t, o.usersHandler, http.MethodGet, usersPath+"?limit=0", http.StatusBadRequest, nil)
+		requireBulk(t, o.usersHandler, http.MethodGet, usersPath+"?limit=1001", http.StatusBadRequest, nil)
+	})
+}
+
+func TestOperation_UserTokensHandler(t *testing.T) {
+	o := setupBulkTest(t)
+
+	t.Run("lists the logins of an identity provider", func(t *testing.T) {
+		page := &TokensPage{}
+		requireBulk(t, o.userTokensHandler, http.MethodGet, userTokensPath+"?idp=https://idp-a.example.com",
+			http.StatusOK, page)
+		require.Len(t, page.Tokens, 2)
+		require.Equal(t, "alice", page.Tokens[0].Sub)
+		require.Equal(t, "https://idp-a.example.com", page.Tokens[0].Issuer)
+		require.NotNil(t, page.Tokens[0].IssuedAt)
+		require.Empty(t, page.Next)
+	})
+
+	t.Run("bad request if the time is invalid", func(t *testing.T) {
+		requireBulk(t, o.userTokensHandler, http.MethodGet, userTokensPath+"?from=yesterday", http.StatusBadRequest,
+			nil)
+	})
+}
+
+func TestOperation_RevokeTokensHandler(t *testing.T) {
+	t.Run("revokes the tokens of the logins of an identity provider", func(t *testing.T) {
+		o := setupBulkTest(t)
+
+		progress := requireProgress(t, o.revokeTokensHandler,
+			revokeTokensPath+"?limit=1&idp=https://idp-a.example.com")
+		require.Len(t, progress, 4)
+		require.Equal(t, 1, progress[0].Processed)
+		require.NotEmpty(t, progress[0].Cursor)
+		require.False(t, progress[0].Done)
+
+		last := progress[len(progress)-1]
+		require.True(t, last.Done)
+		require.Equal(t, 2, last.Processed)
+		require.Equal(t, 2, last.Applied)
+		require.Zero(t, last.Failed)
+
+		requireTokens(t, o, "bob", "dave")
+	})
+
+	t.Run("revokes the tokens of the logins in a time range", func(t *testing.T) {
+		o := setupBulkTest(t)
+
+		progress := requireProgress(t, o.revokeTokensHandler,
+			revokeTokensPath+"?from=2020-01-02T00:00:00Z&to=2020-01-03T00:00:00Z")
+		require.Len(t, progress, 1)
+		require.Equal(t, 1, progress[0].Applied)
+
+		requireTokens(t, o, "alice", "carol", "dave")
+	})
+
+	t.Run("resumes at the cursor", func(t *testing.T) {
+		o := setupBulkTest(t)
+
+		progress := requireProgress(t, o.revokeTokensHandler, revokeTokensPath+"?limit=2&idp=https://idp-b.example.com")
+		require.Len(t, progress, 2)
+
+		progress = requireProgress(t, o.revokeTokensHandler,
+			revokeTokensPath+"?idp=https://idp-a.example.com&cursor="+progress[0].Cursor)
+		require.Len(t, progress, 1)
+		require.Equal(t, 1, progress[0].Applied)
+
+		requireTokens(t, o, "alice", "dave")
+	})
+
+	t.Run("reports the tokens it fails to revoke", func(t *testing.T) {
+		o := setupBulkTest(t)
+		o.store.tokens = &undeletableTokens{Store: o.store.tokens, DeleteErr: errors.New("test")}
+
+		progress := requireProgress(t, o.revokeTokensHandler, revokeTokensPath+"?idp=https://idp-b.example.com")
+		require.Len(t, progress, 1)
+		require.Equal(t, 1, progress[0].Failed)
+		require.Equal(t, "bob", progress[0].Errors[0].Sub)
+		require.Contains(t, progress[0].Errors[0].Error, "failed to delete user tokens")
+	})
+
+	t.Run("bad request without filter", func(t *testing.T) {
+		requireBulk(t, setupBulkTest(t).revokeTokensHandler, http.MethodPost, revokeTokensPath,
+			http.StatusBadRequest, nil)
+	})
+}
+
+func TestOperation_ReonboardHandler(t *testing.T) {
+	o := setupBulkTest(t)
+	o.httpClient = mockKMSHTTPClient()
+	o.keyEDVClient = &mockEDVClient{NoCapability: true}
+	o.userEDVClient = &mockEDVClient{NoCapability: true}
+	o.oidcClient = &oidc2.MockClient{UserInfoVal: &oidc2.MockClaimer{
+		ClaimsFunc: func(v interface{}) error {
+			v.(*user.User).Sub = "alice"
+
+			return nil
+		},
+	}}
+
+	require.NoError(t, o.store.users.Save(&user.User{Sub: "bob"}))
+
+	progress := requireProgress(t, o.reonboardHandler, reonboardPath+"?to=2020-01-04T00:00:00Z")
+	require.Len(t, progress, 1)
+	// bob is onboarded already, and dave logged in before the times of the logins were recorded
+	require.Equal(t, 3, progress[0].Processed)
+	require.Equal(t, 1, progress[0].Applied)
+	require.Equal(t, 1, progress[0].Failed)
+	// the user info of alice is returned for carol
+	require.Equal(t, "carol", progress[0].Errors[0].Sub)
+
+	_, err := o.store.users.Get("alice")
+	require.NoError(t, err)
+}
+
+// setupBulkTest saves the tokens of alice and carol, of idp-a, of bob, of idp-b, and of dave, saved before the
+// issuers of the logins were recorded.
+func setupBulkTest(t *testing.T) *Operation {
+	t.Helper()
+
+	o, err := New(config(t))
+	require.NoError(t, err)
+
+	for i, login := range []struct{ sub, issuer string }{
+		{"alice", "https://idp-a.example.com"},
+		{"bob", "https://idp-b.example.com"},
+		{"carol", "https://idp-a.example.com"},
+		{"dave", ""},
+	} {
+		issued := time.Date(2020, 1, i+1, 0, 0, 0, 0, time.UTC)
+		saved := &tokens.UserTokens{UserSub: login.sub, Access: "access", Issuer: login.issuer, IssuedAt: &issued}
+
+		if login.issuer == "" {
+			saved.IssuedAt = nil
+		}
+
+		require.NoError(t, o.store.tokens.Save(saved))
+	}
+
+	return o
+}
+
+type undeletableTokens struct {
+	tokens.Store
+	DeleteErr error
+}
+
+func (f *undeletableTokens) Delete(string) error {
+	return f.DeleteErr
+}
+
+func requireTokens(t *testing.T, o *Operation, subs ...string) {
+	t.Helper()
+
+	list, err := o.store.tokens.List()
+	require.NoError(t, err)
+
+	kept := []string{}
+
+	for _, sub := range subs {
+		_, err = o.store.tokens.Get(sub)
+		require.NoError(t, err, sub)
+
+		kept = append(kept, sub)
+	}
+
+	require.Len(t, list, len(kept))
+
+	for _, ut := range list {
+		require.Contains(t, kept, ut.UserSub)
+	}
+}
+
+func requireProgress(t *testing.T, handler http.HandlerFunc, target string) []*BulkProgress {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, target, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, ndjsonContent, w.Header().Get("Content-Type"))
+
+	var progress []*BulkProgress
+
+	scanner := bufio.NewScanner(w.Body)
+
+	for scanner.Scan() {
+		line := &BulkProgress{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), line))
+
+		progress = append(progress, line)
+	}
+
+	require.True(t, progress[len(progress)-1].Done)
+
+	return progress
+}
+
+func requireBulk(t *testing.T, handler http.HandlerFunc, method, target string, status int, result interface{}) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, target, nil))
+	require.Equal(t, status, w.Code, w.Body.String())
+
+	if result != nil {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+	}
+}
+
+func TestOperation_RevokeTokens(t *testing.T) {
+	o := setupBulkTest(t)
+
+	require.NoError(t, o.RevokeTokens("alice"))
+	require.NoError(t, o.RevokeTokens("alice"))
+
+	_, err := o.store.tokens.Get("alice")
+	require.True(t, errors.Is(err, storage.ErrValueNotFound))
+}
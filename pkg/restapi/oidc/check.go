@@ -0,0 +1,231 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+// Admin endpoints.
+const (
+	userCheckPath = "/users/check"
+)
+
+// Check statuses.
+const (
+	CheckOK      = "ok"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+// CheckReport is the result of cross-checking the wallet data of a user.
+type CheckReport struct {
+	Sub     string         `json:"sub"`
+	Healthy bool           `json:"healthy"`
+	Checks  []*CheckResult `json:"checks"`
+}
+
+// CheckResult is the outcome of a single consistency check.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Repair string `json:"repair,omitempty"`
+}
+
+func (r *CheckReport) add(name, status, detail, repair string) {
+	r.Checks = append(r.Checks, &CheckResult{Name: name, Status: status, Detail: detail, Repair: repair})
+
+	if status == CheckFailed {
+		r.Healthy = false
+	}
+}
+
+// GetAdminRESTHandlers returns the administrative handlers. They must be mounted behind admin authentication.
+func (o *Operation) GetAdminRESTHandlers() []common.Handler {
+	handlers := []common.Handler{
+		common.NewHTTPHandler(userCheckPath, http.MethodGet, o.userCheckHandler, &common.OperationSpec{
+			Summary:   "Cross-checks the wallet data of a user.",
+			Params:    []common.Param{{Name: "sub", In: common.InQuery, Description: "Sub of the user.", Required: true}},
+			Responses: map[int]interface{}{http.StatusOK: &CheckReport{}},
+		}),
+		common.NewHTTPHandler(usersUsagePath, http.MethodGet, o.usersUsageHandler, &common.OperationSpec{
+			Summary: "Returns the documents and keys of all the users, with the users having the most documents.",
+			Params: []common.Param{
+				common.QueryParam(usageTopParam, "Number of users listed. Defaults to 10."),
+			},
+			Responses: map[int]interface{}{http.StatusOK: &UsageSummary{}},
+		}),
+	}
+
+	handlers = append(handlers, o.bulkHandlers()...)
+
+	if o.janitor != nil {
+		handlers = append(handlers,
+			common.NewHTTPHandler(janitorStatsPath, http.MethodGet, o.janitorStatsHandler, &common.OperationSpec{
+				Summary:   "Returns the totals of the cleanups of the expired records.",
+				Responses: map[int]interface{}{http.StatusOK: &JanitorStats{}},
+			}),
+		)
+	}
+
+	if o.lockouts != nil {
+		handlers = append(handlers, o.lockoutHandlers()...)
+	}
+
+	if o.rotations != nil {
+		handlers = append(handlers, o.vaultRotationHandlers()...)
+	}
+
+	if o.escrow != nil {
+		handlers = append(handlers, o.shareEscrowHandlers()...)
+	}
+
+	if o.issuerKeys != nil {
+		handlers = append(handlers, o.issuerKeysHandlers()...)
+	}
+
+	return append(handlers, o.validationHandler())
+}
+
+func (o *Operation) userCheckHandler(w http.ResponseWriter, r *http.Request) {
+	sub := r.URL.Query().Get("sub")
+	if sub == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing sub parameter")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, o.CheckUser(r.Context(), sub))
+}
+
+// CheckUser cross-checks the user record, tokens, bootstrap data, vaults and keystores of the user.
+func (o *Operation) CheckUser(ctx context.Context, sub string) *CheckReport {
+	report := &CheckReport{Sub: sub, Healthy: true}
+
+	usr := o.checkUserRecord(report, sub)
+	tokns := o.checkTokens(report, sub)
+
+	if usr == nil || tokns == nil {
+		report.add("bootstrap", CheckSkipped, "requires the user record and tokens", "")
+
+		return report
+	}
+
+	data, err := o.fetchBootstrapData(ctx, tokns.Access)
+	if err != nil || data.Data == nil {
+		detail := "no bootstrap data"
+		if err != nil {
+			detail = err.Error()
+		}
+
+		report.add("bootstrap", CheckFailed, detail,
+			"ask the user to log in again to refresh tokens; if the data is missing, delete the user record to re-onboard")
+
+		return report
+	}
+
+	report.add("bootstrap", CheckOK, "", "")
+
+	h := &hubKMSHeader{userSub: o.pseudonyms.ID(sub), accessToken: tokns.Access, secretShare: usr.SecretShare}
+
+	o.checkResource(ctx, report, "authz keystore", data.Data.AuthzKeyStoreURL, h)
+	o.checkResource(ctx, report, "ops keystore", data.Data.OpsKeyStoreURL, &hubKMSHeader{accessToken: tokns.Access})
+	o.checkResource(ctx, report, "ops edv vault", data.Data.OpsEDVVaultURL, &hubKMSHeader{accessToken: tokns.Access})
+	o.checkResource(ctx, report, "user edv vault", data.Data.UserEDVVaultURL, &hubKMSHeader{accessToken: tokns.Access})
+
+	return report
+}
+
+func (o *Operation) checkUserRecord(report *CheckReport, sub string) *user.User {
+	usr, err := o.store.users.Get(sub)
+	if err != nil {
+		report.add("user record", CheckFailed, err.Error(), "the user must log in to be onboarded")
+
+		return nil
+	}
+
+	if usr.SecretShare == "" {
+		report.add("user record", CheckFailed, "missing wallet secret share",
+			"delete the user record so the user is onboarded again on next login")
+
+		return usr
+	}
+
+	report.add("user record", CheckOK, "", "")
+
+	return usr
+}
+
+func (o *Operation) checkTokens(report *CheckReport, sub string) *tokens.UserTokens {
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		report.add("tokens", CheckFailed, err.Error(), "ask the user to log in again")
+
+		return nil
+	}
+
+	if tokns.Access == "" {
+		report.add("tokens", CheckFailed, "missing access token", "ask the user to log in again")
+
+		return nil
+	}
+
+	report.add("tokens", CheckOK, "", "")
+
+	return tokns
+}
+
+// checkResource probes a keystore or vault URL. Any answer other than 404 or a server error means the
+// resource is known to its server.
+func (o *Operation) checkResource(ctx context.Context, report *CheckReport, name, url string, h *hubKMSHeader) {
+	if url == "" {
+		report.add(name, CheckSkipped, "not provisioned", "")
+
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		report.add(name, CheckFailed, err.Error(), "fix the URL in the user's bootstrap data")
+
+		return
+	}
+
+	addAuthZKMSHeaders(req, h)
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		report.add(name, CheckFailed, fmt.Sprintf("unreachable: %s", err.Error()),
+			"check connectivity and TLS configuration towards "+url)
+
+		return
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Errorf("failed to close response body")
+		}
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		report.add(name, CheckFailed, "not found at "+url,
+			"delete the user record so the user is onboarded again on next login")
+	case resp.StatusCode >= http.StatusInternalServerError:
+		report.add(name, CheckFailed, fmt.Sprintf("server error %d at %s", resp.StatusCode, url),
+			"check the health of the server hosting "+url)
+	default:
+		report.add(name, CheckOK, "", "")
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestOperation_CheckUser(t *testing.T) {
+	t.Run("healthy user", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockBootstrapHTTPClient(t, http.StatusOK)
+
+		report := o.CheckUser(context.Background(), sub)
+		require.True(t, report.Healthy)
+		require.Len(t, report.Checks, 7)
+
+		for _, c := range report.Checks {
+			require.NotEqual(t, CheckFailed, c.Status, c.Name)
+		}
+	})
+
+	t.Run("missing user and tokens", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		report := o.CheckUser(context.Background(), uuid.New().String())
+		require.False(t, report.Healthy)
+		require.Equal(t, CheckFailed, report.Checks[0].Status)
+		require.Equal(t, CheckFailed, report.Checks[1].Status)
+		require.Equal(t, CheckSkipped, report.Checks[2].Status)
+		require.NotEmpty(t, report.Checks[0].Repair)
+	})
+
+	t.Run("missing secret share and access token", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		sub := uuid.New().String()
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub}))
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub}))
+
+		report := o.CheckUser(context.Background(), sub)
+		require.False(t, report.Healthy)
+		require.Contains(t, report.Checks[0].Detail, "secret share")
+		require.Contains(t, report.Checks[1].Detail, "access token")
+	})
+
+	t.Run("bootstrap data not available", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = &mockHTTPClient{DoFunc: func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("test")
+		}}
+
+		report := o.CheckUser(context.Background(), sub)
+		require.False(t, report.Healthy)
+		require.Equal(t, "bootstrap", report.Checks[2].Name)
+		require.Equal(t, CheckFailed, report.Checks[2].Status)
+	})
+
+	t.Run("resources missing or failing", func(t *testing.T) {
+		for _, status := range []int{http.StatusNotFound, http.StatusBadGateway} {
+			o, sub := setupCheckTest(t)
+			o.httpClient = mockBootstrapHTTPClient(t, status)
+
+			report := o.CheckUser(context.Background(), sub)
+			require.False(t, report.Healthy)
+			require.Equal(t, CheckFailed, report.Checks[3].Status)
+		}
+	})
+
+	t.Run("resource unreachable", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		bootstrap := mockBootstrapHTTPClient(t, http.StatusOK)
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			if strings.HasSuffix(req.URL.Path, hubAuthBootstrapDataPath) {
+				return bootstrap.Do(req)
+			}
+
+			return nil, errors.New("test")
+		}}
+
+		report := o.CheckUser(context.Background(), sub)
+		require.False(t, report.Healthy)
+		require.Contains(t, report.Checks[3].Detail, "unreachable")
+	})
+}
+
+func TestOperation_UserCheckHandler(t *testing.T) {
+	t.Run("returns report", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockBootstrapHTTPClient(t, http.StatusOK)
+
+		w := httptest.NewRecorder()
+		o.userCheckHandler(w, httptest.NewRequest(http.MethodGet, userCheckPath+"?sub="+sub, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		report := &CheckReport{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(report))
+		require.Equal(t, sub, report.Sub)
+		require.True(t, report.Healthy)
+	})
+
+	t.Run("bad request if sub is missing", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		require.NotEmpty(t, o.GetAdminRESTHandlers())
+
+		w := httptest.NewRecorder()
+		o.userCheckHandler(w, httptest.NewRequest(http.MethodGet, userCheckPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func setupCheckTest(t *testing.T) (*Operation, string) {
+	t.Helper()
+
+	o, err := New(config(t))
+	require.NoError(t, err)
+
+	sub := uuid.New().String()
+	require.NoError(t, o.store.users.Save(&user.User{Sub: sub, SecretShare: "share"}))
+	require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: "token"}))
+
+	return o, sub
+}
+
+// mockBootstrapHTTPClient serves bootstrap data from hub-auth and answers all resource probes with 'status'.
+func mockBootstrapHTTPClient(t *testing.T, status int) *mockHTTPClient {
+	t.Helper()
+
+	data := marshal(t, &userBootstrapData{Data: &BootstrapData{
+		AuthzKeyStoreURL: "https://authz.kms.example.com/kms/keystores/123",
+		OpsKeyStoreURL:   "https://ops.kms.example.com/kms/keystores/456",
+		OpsEDVVaultURL:   "https://edv.example.com/encrypted-data-vaults/789",
+	}})
+
+	return &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, hubAuthBootstrapDataPath) {
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+		}
+
+		return &http.Response{StatusCode: status, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}}
+}
@@ -0,0 +1,284 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"golang.org/x/oauth2"
+)
+
+const (
+	consentPath = "/consent"
+	// the cookie of a new user who logged in but has not accepted the terms yet.
+	consentSubCookieName = "consent_sub"
+	consentKeyPrefix     = "consent_"
+)
+
+// ConsentConfig makes new users accept the terms of service and privacy policy before they are onboarded.
+type ConsentConfig struct {
+	// Version of the terms of service and privacy policy the users must accept.
+	Version string
+	// PageURL is the page of the wallet UI asking the users for their consent.
+	PageURL string
+}
+
+// ConsentRequest accepts a version of the terms of service and privacy policy.
+type ConsentRequest struct {
+	Version string `json:"version"`
+}
+
+// ConsentStatus tells whether the user must be prompted to accept the required version of the terms.
+type ConsentStatus struct {
+	RequiredVersion string     `json:"requiredVersion"`
+	AcceptedVersion string     `json:"acceptedVersion,omitempty"`
+	AcceptedAt      *time.Time `json:"acceptedAt,omitempty"`
+	Prompt          bool       `json:"prompt"`
+}
+
+// pendingLogin is the login of a new user, completed once the user accepts the terms.
+type pendingLogin struct {
+	User    *user.User    `json:"user"`
+	Token   *oauth2.Token `json:"token"`
+	Expires time.Time     `json:"expires"`
+}
+
+// promptConsent returns true if the user has not accepted the required version of the terms.
+func (o *Operation) promptConsent(usr *user.User) bool {
+	return o.consent != nil && (usr.Consent == nil || usr.Consent.Version != o.consent.Version)
+}
+
+// deferLogin holds the login of a new user until the user accepts the terms.
+func (o *Operation) deferLogin(w http.ResponseWriter, r *http.Request, usr *user.User, token *oauth2.Token) bool {
+	err := store.Save(o.store.transient, consentKeyPrefix+usr.Sub, &pendingLogin{
+		User:    usr,
+		Token:   token,
+		Expires: o.transientExpiry(),
+	})
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to save pending login: %s", err.Error())
+
+		return false
+	}
+
+	session, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to create or decode session cookie: %s", err.Error())
+
+		return false
+	}
+
+	session.Set(consentSubCookieName, usr.Sub)
+
+	err = session.Save(r, w)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to save consent sub cookie: %s", err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+func (o *Operation) consentStatusHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling consent status request")
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return
+	}
+
+	usr, found := o.consentUser(w, jar)
+	if !found {
+		return
+	}
+
+	common.WriteResponse(w, logger, o.consentStatus(usr))
+}
+
+// consentHandler records the user's acceptance of the terms. The login of a new user is then completed
+// and the user onboarded.
+func (o *Operation) consentHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling consent request")
+
+	request := &ConsentRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if request.Version != o.consent.Version {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadRequest, "consent to version %s is required", o.consent.Version)
+
+		return
+	}
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return
+	}
+
+	usr, found := o.consentUser(w, jar)
+	if !found {
+		return
+	}
+
+	usr.Consent = &user.Consent{Version: request.Version, AcceptedAt: o.now().UTC()}
+
+	if _, loggedIn := jar.Get(userSubCookieName); !loggedIn {
+		if !o.completeLogin(w, r, jar, usr) {
+			return
+		}
+	} else {
+		usr, err = o.updateUser(usr.Sub, func(stored *user.User) bool {
+			stored.Consent = usr.Consent
+
+			return true
+		})
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to save user consent: %s", err.Error())
+
+			return
+		}
+	}
+
+	common.WriteResponse(w, logger, o.consentStatus(usr))
+}
+
+// consentUser returns the logged in user, or the new user whose login is pending.
+func (o *Operation) consentUser(w http.ResponseWriter, jar cookie.Jar) (*user.User, bool) {
+	if sub, found := jar.Get(userSubCookieName); found {
+		usr, err := o.store.users.Get(fmt.Sprintf("%v", sub))
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to query user data: %s", err.Error())
+
+			return nil, false
+		}
+
+		return usr, true
+	}
+
+	if sub, found := jar.Get(consentSubCookieName); found {
+		pending, err := o.pendingLogin(fmt.Sprintf("%v", sub))
+		if errors.Is(err, storage.ErrValueNotFound) {
+			common.WriteErrorResponsef(w, logger, http.StatusForbidden, "login expired")
+
+			return nil, false
+		}
+
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to query pending login: %s", err.Error())
+
+			return nil, false
+		}
+
+		return pending.User, true
+	}
+
+	common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+	return nil, false
+}
+
+// completeLogin onboards a new user who accepted the terms and opens the user's session.
+func (o *Operation) completeLogin(w http.ResponseWriter, r *http.Request, jar cookie.Jar, usr *user.User) bool {
+	pending, err := o.pendingLogin(usr.Sub)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to query pending login: %s", err.Error())
+
+		return false
+	}
+
+	// the tokens are saved ahead of the user, so that no user is left without tokens
+	login, err := o.beginLogin(usr, pending.Token, false)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return false
+	}
+
+	if !o.createUser(w, r, usr, pending.Token.AccessToken) {
+		o.abortLogin(login, errors.New("failed to create user"))
+
+		return false
+	}
+
+	o.commitLogin(login)
+
+	err = o.store.transient.Delete(consentKeyPrefix + usr.Sub)
+	if err != nil {
+		logger.Warnf("failed to delete pending login: %s", err)
+	}
+
+	jar.Delete(consentSubCookieName)
+	jar.Set(userSubCookieName, usr.Sub)
+	o.sessionBinding.Bind(jar, r)
+	o.rememberUser(w, jar, usr.Sub)
+
+	err = jar.Save(r, w)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to save user sub cookie: %s", err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+func (o *Operation) pendingLogin(sub string) (*pendingLogin, error) {
+	bits, err := o.store.transient.Get(consentKeyPrefix + sub)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := &pendingLogin{}
+
+	err = json.Unmarshal(bits, pending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pending login: %w", err)
+	}
+
+	return pending, nil
+}
+
+func (o *Operation) consentStatus(usr *user.User) *ConsentStatus {
+	status := &ConsentStatus{RequiredVersion: o.consent.Version, Prompt: o.promptConsent(usr)}
+
+	if usr.Consent != nil {
+		status.AcceptedVersion = usr.Consent.Version
+		status.AcceptedAt = &usr.Consent.AcceptedAt
+	}
+
+	return status
+}
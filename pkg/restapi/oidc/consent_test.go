@@ -0,0 +1,220 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+const consentPage = "http://test.com/consent"
+
+func TestOperation_Consent(t *testing.T) {
+	t.Run("onboards new users once they accept the terms", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupConsentTest(t, state)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, consentPage, w.Header().Get("Location"))
+
+		jar := o.store.cookies.(*cookie.MockStore).Jar
+		_, loggedIn := jar.Get(userSubCookieName)
+		require.False(t, loggedIn)
+
+		sub, pending := jar.Get(consentSubCookieName)
+		require.True(t, pending)
+
+		_, err := o.store.users.Get(sub.(string))
+		require.Error(t, err)
+
+		status := consentStatus(t, o)
+		require.True(t, status.Prompt)
+		require.Equal(t, "v2", status.RequiredVersion)
+		require.Empty(t, status.AcceptedVersion)
+
+		w = httptest.NewRecorder()
+		o.consentHandler(w, newConsentRequest(`{"version": "v1"}`))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "consent to version v2 is required")
+
+		w = httptest.NewRecorder()
+		o.consentHandler(w, newConsentRequest(`{"version": "v2"}`))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		_, pending = jar.Get(consentSubCookieName)
+		require.False(t, pending)
+
+		loggedInSub, loggedIn := jar.Get(userSubCookieName)
+		require.True(t, loggedIn)
+		require.Equal(t, sub, loggedInSub)
+
+		usr, err := o.store.users.Get(sub.(string))
+		require.NoError(t, err)
+		require.NotEmpty(t, usr.SecretShare)
+		require.Equal(t, "v2", usr.Consent.Version)
+		require.WithinDuration(t, time.Now(), usr.Consent.AcceptedAt, time.Minute)
+
+		_, err = o.store.tokens.Get(sub.(string))
+		require.NoError(t, err)
+
+		status = consentStatus(t, o)
+		require.False(t, status.Prompt)
+		require.Equal(t, "v2", status.AcceptedVersion)
+	})
+
+	t.Run("re-prompts returning users when the required version changes", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupConsentTest(t, state)
+
+		require.NoError(t, o.store.users.Save(&user.User{
+			Sub:     "returning",
+			Consent: &user.Consent{Version: "v1", AcceptedAt: time.Now().Add(-time.Hour)},
+		}))
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, consentPage, w.Header().Get("Location"))
+
+		_, loggedIn := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.True(t, loggedIn)
+
+		status := consentStatus(t, o)
+		require.True(t, status.Prompt)
+		require.Equal(t, "v1", status.AcceptedVersion)
+
+		w = httptest.NewRecorder()
+		o.consentHandler(w, newConsentRequest(`{"version": "v2"}`))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		usr, err := o.store.users.Get("returning")
+		require.NoError(t, err)
+		require.Equal(t, "v2", usr.Consent.Version)
+
+		state = uuid.New().String()
+		o.store.cookies.(*cookie.MockStore).Jar.Set(stateCookieName, state)
+
+		w = httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "http://test.com/dashboard", w.Header().Get("Location"))
+	})
+
+	t.Run("error forbidden if not logged in", func(t *testing.T) {
+		o := setupConsentTest(t, "")
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		w := httptest.NewRecorder()
+		o.consentStatusHandler(w, httptest.NewRequest(http.MethodGet, consentPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "not logged in")
+
+		w = httptest.NewRecorder()
+		o.consentHandler(w, newConsentRequest(`{"version": "v2"}`))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("error forbidden if the pending login expired", func(t *testing.T) {
+		o := setupConsentTest(t, "")
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{consentSubCookieName: "unknown"},
+		}}
+
+		w := httptest.NewRecorder()
+		o.consentHandler(w, newConsentRequest(`{"version": "v2"}`))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "login expired")
+	})
+
+	t.Run("error bad request if the request is invalid", func(t *testing.T) {
+		o := setupConsentTest(t, "")
+
+		w := httptest.NewRecorder()
+		o.consentHandler(w, newConsentRequest(`{`))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "failed to decode request")
+	})
+
+	t.Run("serves the consent endpoints only if consent is required", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		for _, h := range o.GetRESTHandlers() {
+			require.NotEqual(t, consentPath, h.Path())
+		}
+
+		o = setupConsentTest(t, "")
+
+		paths := map[string]bool{}
+		for _, h := range o.GetRESTHandlers() {
+			paths[h.Path()+" "+h.Method()] = true
+		}
+
+		require.True(t, paths[consentPath+" GET"])
+		require.True(t, paths[consentPath+" POST"])
+	})
+}
+
+// setupConsentTest returns an Operation requiring consent to version v2 of the terms, whose OIDC
+// provider logs in the 'returning' user if that user is stored, or a new user otherwise.
+func setupConsentTest(t *testing.T, state string) *Operation {
+	t.Helper()
+
+	o := setupOnboardingTest(t, state)
+	o.consent = &ConsentConfig{Version: "v2", PageURL: consentPage}
+	o.httpClient = mockKMSHTTPClient()
+	o.keyEDVClient = &mockEDVClient{NoCapability: true}
+	o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+	newSub := uuid.New().String()
+
+	o.oidcClient.(*oidc2.MockClient).IDToken = &oidc2.MockClaimer{
+		ClaimsFunc: func(i interface{}) error {
+			usr, ok := i.(*user.User)
+			require.True(t, ok)
+
+			usr.Sub = newSub
+
+			if _, err := o.store.users.Get("returning"); err == nil {
+				usr.Sub = "returning"
+			}
+
+			return nil
+		},
+	}
+
+	return o
+}
+
+func newConsentRequest(body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, consentPath, strings.NewReader(body))
+}
+
+func consentStatus(t *testing.T, o *Operation) *ConsentStatus {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.consentStatusHandler(w, httptest.NewRequest(http.MethodGet, consentPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	status := &ConsentStatus{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(status))
+
+	return status
+}
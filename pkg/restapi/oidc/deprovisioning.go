@@ -0,0 +1,179 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/bearer"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+// ErrUserDeactivated is returned for the deactivated users, whose tokens the agent no longer uses on their behalf.
+var ErrUserDeactivated = errors.New("user deactivated")
+
+// DeactivateUser logs the user out of all the user's sessions, revokes the user's remember-me tokens and refuses
+// the logins of the user until the user is activated again. The OAuth tokens of the user are only kept for
+// DeprovisionUser to delete the keystores and vaults of the user. The error wraps storage.ErrValueNotFound if the
+// user is not onboarded.
+func (o *Operation) DeactivateUser(sub string) error {
+	deactivated := false
+
+	_, err := o.updateUser(sub, func(usr *user.User) bool {
+		deactivated = !usr.Deactivated
+		usr.Deactivated = true
+
+		return deactivated
+	})
+	if err != nil {
+		return err
+	}
+
+	if deactivated {
+		o.audit(audit.ActionUserDeactivated, sub, nil)
+	}
+
+	// the sessions are ended by the middleware of the agent, but the remember-me tokens would log the user in again
+	if o.remember != nil {
+		err = o.remember.RevokeUser(sub)
+		if err != nil {
+			return err
+		}
+	}
+
+	o.vaults.Remove(sub)
+	o.invalidateUserInfo(sub)
+	o.invalidateKeystores(o.pseudonyms.ID(sub))
+
+	return nil
+}
+
+// ActivateUser lets the deactivated user log in again. The error wraps storage.ErrValueNotFound if the user is
+// not onboarded.
+func (o *Operation) ActivateUser(sub string) error {
+	activated := false
+
+	_, err := o.updateUser(sub, func(usr *user.User) bool {
+		activated = usr.Deactivated
+		usr.Deactivated = false
+
+		return activated
+	})
+	if err != nil {
+		return err
+	}
+
+	if activated {
+		o.audit(audit.ActionUserActivated, sub, nil)
+	}
+
+	return nil
+}
+
+// DeprovisionUser deactivates the user and deletes the account of the user, as DeleteAccount does, once the tokens
+// of the user are refreshed, should the access token have expired since the last use of the wallet. The tokens
+// are used as is if they cannot be refreshed, e.g. if the OIDC provider deprovisioned the user already.
+func (o *Operation) DeprovisionUser(ctx context.Context, sub string) (*AccountDeletion, error) {
+	err := o.DeactivateUser(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = o.refreshTokens(ctx, sub)
+	if err != nil {
+		logger.Warnf("failed to refresh the tokens of user %s ahead of the deprovisioning: %s", sub, err.Error())
+	}
+
+	return o.DeleteAccount(ctx, sub, false)
+}
+
+// userDeactivated refuses the login of the deactivated user.
+func userDeactivated(w http.ResponseWriter, deactivated bool) bool {
+	if deactivated {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "%s", ErrUserDeactivated.Error())
+	}
+
+	return deactivated
+}
+
+// DeactivatedSessions ends the sessions of the deactivated users, whose requests are passed on logged out. The
+// requests without session are passed on as is.
+func DeactivatedSessions(users user.Store, cookies *cookie.Jars) common.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			jar, err := cookies.Open(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			var deactivated bool
+
+			for _, name := range []string{userSubCookieName, consentSubCookieName} {
+				if sub, found := jar.Get(name); found && isDeactivated(users, sub) {
+					jar.Delete(name)
+
+					deactivated = true
+				}
+			}
+
+			if !deactivated {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			err = jar.Save(r, w)
+			if err != nil {
+				logger.Errorf("failed to end the session of a deactivated user: %s", err.Error())
+			}
+
+			next.ServeHTTP(w, r.WithContext(cookie.WithJar(r.Context(), jar)))
+		})
+	}
+}
+
+// DeactivatedBearers rejects the bearer tokens of the deactivated users, validated by the introspector otherwise,
+// for bearer.Middleware to answer 401 to their requests.
+func DeactivatedBearers(introspector bearer.Introspector, users user.Store) bearer.Introspector {
+	return &activeIntrospector{introspector: introspector, users: users}
+}
+
+type activeIntrospector struct {
+	introspector bearer.Introspector
+	users        user.Store
+}
+
+func (a *activeIntrospector) Introspect(ctx context.Context, token string) (string, error) {
+	sub, err := a.introspector.Introspect(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	if isDeactivated(a.users, sub) {
+		return "", ErrUserDeactivated
+	}
+
+	return sub, nil
+}
+
+func isDeactivated(users user.Store, sub interface{}) bool {
+	s, ok := sub.(string)
+	if !ok {
+		return false
+	}
+
+	usr, err := users.Get(s)
+
+	return err == nil && usr.Deactivated
+}
@@ -0,0 +1,263 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/bearer"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestOperation_DeactivateUser(t *testing.T) {
+	t.Run("deactivates the user and revokes the remember-me tokens of the user", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+		o.remember = rememberStore(t)
+
+		issued, err := o.remember.Issue("alice")
+		require.NoError(t, err)
+
+		require.NoError(t, o.DeactivateUser("alice"))
+
+		usr, err := o.store.users.Get("alice")
+		require.NoError(t, err)
+		require.True(t, usr.Deactivated)
+
+		_, err = o.remember.Rotate(issued.Value)
+		require.True(t, errors.Is(err, remember.ErrInvalidToken))
+
+		entries, err := o.auditLog.List("alice")
+		require.NoError(t, err)
+		require.Equal(t, audit.ActionUserDeactivated, entries[len(entries)-1].Action)
+
+		// the tokens are kept for the deprovisioning
+		_, err = o.store.tokens.Get("alice")
+		require.NoError(t, err)
+	})
+
+	t.Run("refuses the deactivated user the use of the tokens", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+		o.oidcClient = &oidc2.MockClient{}
+
+		require.NoError(t, o.DeactivateUser("alice"))
+
+		_, err := o.RefreshTokens(context.Background(), "alice")
+		require.True(t, errors.Is(err, ErrUserDeactivated))
+
+		_, err = o.Bootstrap(context.Background(), "alice")
+		require.True(t, errors.Is(err, ErrUserDeactivated))
+	})
+
+	t.Run("audits the deactivation once", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+
+		require.NoError(t, o.DeactivateUser("alice"))
+		require.NoError(t, o.DeactivateUser("alice"))
+
+		entries, err := o.auditLog.List("alice")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+	})
+
+	t.Run("error if the user is not onboarded", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+
+		err := o.DeactivateUser("bob")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+}
+
+func TestOperation_ActivateUser(t *testing.T) {
+	t.Run("lets the user use the tokens again", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+
+		require.NoError(t, o.DeactivateUser("alice"))
+		require.NoError(t, o.ActivateUser("alice"))
+
+		usr, err := o.store.users.Get("alice")
+		require.NoError(t, err)
+		require.False(t, usr.Deactivated)
+
+		entries, err := o.auditLog.List("alice")
+		require.NoError(t, err)
+		require.Equal(t, audit.ActionUserActivated, entries[len(entries)-1].Action)
+	})
+
+	t.Run("leaves the active users as is", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+
+		require.NoError(t, o.ActivateUser("alice"))
+
+		entries, err := o.auditLog.List("alice")
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("error if the user is not onboarded", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+
+		err := o.ActivateUser("bob")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+}
+
+func TestOperation_DeprovisionUser(t *testing.T) {
+	t.Run("deletes the keystores, vaults and records of the user", func(t *testing.T) {
+		o, hub := setupAccountTest(t, nil)
+		o.oidcClient = &oidc2.MockClient{RefreshErr: errors.New("test")}
+
+		deletion, err := o.DeprovisionUser(context.Background(), "alice")
+		require.NoError(t, err)
+
+		for _, step := range deletion.Steps {
+			require.Equal(t, DeletionDeleted, step.Status, step.Resource)
+		}
+
+		require.ElementsMatch(t, []string{accountUserVault, accountOpsVault, accountOpsKMS, accountAuthzKMS},
+			hub.deleted())
+
+		_, err = o.store.users.Get("alice")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("leaves the user deactivated if the account cannot be deleted", func(t *testing.T) {
+		o, _ := setupAccountTest(t, map[string]int{accountAuthzKMS: http.StatusInternalServerError})
+		o.oidcClient = &oidc2.MockClient{RefreshErr: errors.New("test")}
+
+		_, err := o.DeprovisionUser(context.Background(), "alice")
+		require.True(t, errors.Is(err, ErrDeletionIncomplete))
+
+		usr, err := o.store.users.Get("alice")
+		require.NoError(t, err)
+		require.True(t, usr.Deactivated)
+	})
+
+	t.Run("error if the user is not onboarded", func(t *testing.T) {
+		o, _ := setupAccountTest(t, nil)
+
+		_, err := o.DeprovisionUser(context.Background(), "bob")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+}
+
+func TestDeactivatedSessions(t *testing.T) {
+	users, err := user.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	require.NoError(t, users.Save(&user.User{Sub: "alice", Deactivated: true}))
+	require.NoError(t, users.Save(&user.User{Sub: "bob"}))
+
+	cookies := cookie.NewStore(bytes.Repeat([]byte("a"), 32), bytes.Repeat([]byte("b"), 32))
+
+	serve := func(sessionCookies map[interface{}]interface{}) cookie.Jar {
+		var served cookie.Jar
+
+		handler := DeactivatedSessions(users, cookies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served, err = cookies.Open(r)
+			require.NoError(t, err)
+		}))
+
+		r := httptest.NewRequest(http.MethodGet, "/wallet", nil)
+		r = r.WithContext(cookie.WithJar(r.Context(), cookie.NewRequestJar(sessionCookies)))
+
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		return served
+	}
+
+	t.Run("ends the sessions of the deactivated users", func(t *testing.T) {
+		jar := serve(map[interface{}]interface{}{userSubCookieName: "alice", consentSubCookieName: "alice"})
+
+		_, found := jar.Get(userSubCookieName)
+		require.False(t, found)
+
+		_, found = jar.Get(consentSubCookieName)
+		require.False(t, found)
+	})
+
+	t.Run("keeps the sessions of the active users", func(t *testing.T) {
+		jar := serve(map[interface{}]interface{}{userSubCookieName: "bob"})
+
+		sub, found := jar.Get(userSubCookieName)
+		require.True(t, found)
+		require.Equal(t, "bob", sub)
+	})
+
+	t.Run("passes the requests without session on", func(t *testing.T) {
+		called := false
+
+		handler := DeactivatedSessions(users, cookies)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			called = true
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wallet", nil))
+		require.True(t, called)
+	})
+}
+
+func TestDeactivatedBearers(t *testing.T) {
+	users, err := user.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	require.NoError(t, users.Save(&user.User{Sub: "alice", Deactivated: true}))
+	require.NoError(t, users.Save(&user.User{Sub: "bob"}))
+
+	handler := bearer.Middleware(DeactivatedBearers(&mockIntrospector{}, users))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/wallet", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("refuses the bearer tokens of the deactivated users", func(t *testing.T) {
+		w := serve("alice")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Body.String(), ErrUserDeactivated.Error())
+	})
+
+	t.Run("accepts the bearer tokens of the active users", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, serve("bob").Code)
+	})
+
+	t.Run("refuses the invalid bearer tokens", func(t *testing.T) {
+		w := serve("invalid")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Body.String(), "invalid bearer token")
+	})
+}
+
+// mockIntrospector takes the sub of the users from their tokens.
+type mockIntrospector struct{}
+
+func (m *mockIntrospector) Introspect(_ context.Context, token string) (string, error) {
+	if token == "invalid" {
+		return "", errors.New("invalid token")
+	}
+
+	return token, nil
+}
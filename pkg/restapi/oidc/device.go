@@ -0,0 +1,173 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+// Endpoints of the device authorization grant, for CLI tools and devices without a browser.
+const (
+	deviceLoginPath = "/login/device"
+	deviceTokenPath = "/login/device/token"
+)
+
+// Device login statuses.
+const (
+	DeviceLoginPending   = "authorization_pending"
+	DeviceLoginSlowDown  = "slow_down"
+	DeviceLoginCompleted = "completed"
+	// DeviceLoginConsentRequired asks the user to accept the terms with a consent request before using the wallet.
+	DeviceLoginConsentRequired = "consent_required"
+)
+
+// DeviceTokenRequest polls the login of a device.
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// DeviceLoginStatus is the progress of the login of a device. The device must wait for the polling interval
+// between its requests while the login is pending, and increase the interval by 5 seconds when told to slow down.
+type DeviceLoginStatus struct {
+	Status string `json:"status"`
+}
+
+// deviceLoginHandler starts the login of a device: the user enters the returned user code at the
+// verification URI with a browser, while the device polls for the completion of the login.
+func (o *Operation) deviceLoginHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling device login request")
+
+	authorization, err := o.oidcClient.AuthorizeDevice(r.Context())
+	if errors.Is(err, oidc.ErrDeviceFlowUnsupported) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotImplemented, "%s", err.Error())
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadGateway, "failed to start device authorization: %s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, authorization)
+}
+
+// deviceTokenHandler completes the login of a device once the user authorized it, with the same user creation
+// and onboarding as the browser login. The device is given the user sub session cookie.
+func (o *Operation) deviceTokenHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling device token request")
+
+	request := &DeviceTokenRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if request.DeviceCode == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing device_code")
+
+		return
+	}
+
+	oauthToken, err := o.oidcClient.ExchangeDeviceCode(r.Context(), request.DeviceCode)
+
+	switch {
+	case errors.Is(err, oidc.ErrAuthorizationPending):
+		writeDeviceLoginStatus(w, http.StatusAccepted, DeviceLoginPending)
+
+		return
+	case errors.Is(err, oidc.ErrSlowDown):
+		writeDeviceLoginStatus(w, http.StatusAccepted, DeviceLoginSlowDown)
+
+		return
+	case errors.Is(err, oidc.ErrAccessDenied):
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "the user denied the device login")
+
+		return
+	case errors.Is(err, oidc.ErrExpiredToken):
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "the device code expired")
+
+		return
+	case err != nil:
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadGateway, "unable to exchange device code for token: %s", err.Error())
+
+		return
+	}
+
+	oidcToken, err := o.oidcClient.VerifyIDToken(r.Context(), oauthToken)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadGateway, "cannot verify id_token: %s", err.Error())
+
+		return
+	}
+
+	if !o.deviceLoginEnabled(w, oidcToken) {
+		return
+	}
+
+	promptConsent, ok := o.loginUser(w, r, oauthToken, oidcToken)
+	if !ok {
+		return
+	}
+
+	if promptConsent {
+		writeDeviceLoginStatus(w, http.StatusOK, DeviceLoginConsentRequired)
+
+		return
+	}
+
+	writeDeviceLoginStatus(w, http.StatusOK, DeviceLoginCompleted)
+}
+
+// deviceLoginEnabled refuses the device login of the users it is not enabled for. Their tenant is read from their
+// claims, since the users logging in for the first time are not recorded yet.
+func (o *Operation) deviceLoginEnabled(w http.ResponseWriter, oidcToken oidc.Claimer) bool {
+	usr, err := user.ParseIDToken(oidcToken)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to parse id_token: %s", err.Error())
+
+		return false
+	}
+
+	err = o.mapClaims(oidcToken, usr)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to map user claims: %s", err.Error())
+
+		return false
+	}
+
+	tenant, _ := usr.Attributes[TenantAttribute].(string) // nolint:errcheck // users without a tenant have none
+
+	if !o.flags.EnabledFor(features.DeviceLogin, &features.Subject{Sub: usr.Sub, Tenant: tenant}) {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "device login is not enabled for the user")
+
+		return false
+	}
+
+	return true
+}
+
+func writeDeviceLoginStatus(w http.ResponseWriter, status int, loginStatus string) {
+	w.WriteHeader(status)
+	common.WriteResponse(w, logger, &DeviceLoginStatus{Status: loginStatus})
+}
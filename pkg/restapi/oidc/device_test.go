@@ -0,0 +1,275 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"golang.org/x/oauth2"
+)
+
+func TestOperation_DeviceLoginHandler(t *testing.T) {
+	t.Run("returns the device authorization", func(t *testing.T) {
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{DeviceAuth: &oidc2.DeviceAuthorization{
+			DeviceCode:      "device",
+			UserCode:        "WDJB-MJHT",
+			VerificationURI: "https://op.example.com/device",
+			ExpiresIn:       1800,
+			Interval:        5,
+		}}
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.deviceLoginHandler(w, httptest.NewRequest(http.MethodPost, "/oidc/login/device", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		authorization := &oidc2.DeviceAuthorization{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(authorization))
+		require.Equal(t, "WDJB-MJHT", authorization.UserCode)
+		require.Equal(t, "https://op.example.com/device", authorization.VerificationURI)
+		require.Equal(t, 5, authorization.Interval)
+	})
+
+	t.Run("error not implemented if the provider does not support the device flow", func(t *testing.T) {
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{DeviceAuthErr: oidc2.ErrDeviceFlowUnsupported}
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.deviceLoginHandler(w, httptest.NewRequest(http.MethodPost, "/oidc/login/device", nil))
+		require.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+
+	t.Run("error bad gateway if the device authorization fails", func(t *testing.T) {
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{DeviceAuthErr: errors.New("test")}
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.deviceLoginHandler(w, httptest.NewRequest(http.MethodPost, "/oidc/login/device", nil))
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Contains(t, w.Body.String(), "failed to start device authorization")
+	})
+}
+
+func TestOperation_DeviceTokenHandler(t *testing.T) {
+	t.Run("logs in the user of an authorized device", func(t *testing.T) {
+		o := setupDeviceTest(t, &oidc2.MockClient{})
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		w := httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, DeviceLoginCompleted, deviceLoginStatus(t, w))
+
+		sub, ok := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.True(t, ok)
+
+		usr, err := o.store.users.Get(sub.(string))
+		require.NoError(t, err)
+		require.NotEmpty(t, usr.SecretShare)
+
+		userTokens, err := o.store.tokens.Get(sub.(string))
+		require.NoError(t, err)
+		require.NotEmpty(t, userTokens.Access)
+	})
+
+	t.Run("tells the device to keep polling while the authorization is pending", func(t *testing.T) {
+		for err, status := range map[error]string{
+			oidc2.ErrAuthorizationPending: DeviceLoginPending,
+			oidc2.ErrSlowDown:             DeviceLoginSlowDown,
+		} {
+			o := setupDeviceTest(t, &oidc2.MockClient{DeviceTokenErr: err})
+
+			w := httptest.NewRecorder()
+			o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+			require.Equal(t, http.StatusAccepted, w.Code)
+			require.Equal(t, status, deviceLoginStatus(t, w))
+
+			_, ok := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+			require.False(t, ok)
+		}
+	})
+
+	t.Run("error if the authorization is denied or expired", func(t *testing.T) {
+		o := setupDeviceTest(t, &oidc2.MockClient{DeviceTokenErr: oidc2.ErrAccessDenied})
+
+		w := httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		o = setupDeviceTest(t, &oidc2.MockClient{DeviceTokenErr: oidc2.ErrExpiredToken})
+
+		w = httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "the device code expired")
+	})
+
+	t.Run("error bad gateway if the device code cannot be exchanged", func(t *testing.T) {
+		o := setupDeviceTest(t, &oidc2.MockClient{DeviceTokenErr: errors.New("test")})
+
+		w := httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Contains(t, w.Body.String(), "unable to exchange device code for token")
+	})
+
+	t.Run("error bad gateway if the id_token cannot be verified", func(t *testing.T) {
+		o := setupDeviceTest(t, &oidc2.MockClient{IDTokenErr: errors.New("test")})
+
+		w := httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+		require.Equal(t, http.StatusBadGateway, w.Code)
+		require.Contains(t, w.Body.String(), "cannot verify id_token")
+	})
+
+	t.Run("error bad request if the request is invalid", func(t *testing.T) {
+		o := setupDeviceTest(t, &oidc2.MockClient{})
+
+		w := httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{`))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "failed to decode request")
+
+		w = httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{}`))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing device_code")
+	})
+
+	t.Run("error if the user cannot be onboarded", func(t *testing.T) {
+		o := setupDeviceTest(t, &oidc2.MockClient{})
+		o.secretSplitter = &mockSplitter{SplitErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		_, ok := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.False(t, ok)
+	})
+}
+
+func TestOperation_DeviceTokenHandlerFeatureFlags(t *testing.T) {
+	flags, err := features.New(&features.Config{Source: &features.MockSource{
+		Flags: map[string]*features.Flag{features.DeviceLogin: {Tenants: []string{"b"}}},
+	}})
+	require.NoError(t, err)
+
+	t.Run("logs in the users of the tenants the device login is enabled for", func(t *testing.T) {
+		o := setupDeviceTenantTest(t, "b")
+		o.flags = flags
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		w := httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, DeviceLoginCompleted, deviceLoginStatus(t, w))
+	})
+
+	t.Run("error forbidden if the device login is not enabled for the user", func(t *testing.T) {
+		o := setupDeviceTenantTest(t, "a")
+		o.flags = flags
+
+		w := httptest.NewRecorder()
+		o.deviceTokenHandler(w, newDeviceTokenRequest(`{"device_code": "device"}`))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "device login is not enabled for the user")
+
+		_, ok := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.False(t, ok)
+	})
+}
+
+// setupDeviceTenantTest returns an Operation whose OIDC client authorizes the device of a new user of the tenant.
+func setupDeviceTenantTest(t *testing.T, tenant string) *Operation {
+	t.Helper()
+
+	client := &oidc2.MockClient{}
+	o := setupDeviceTest(t, client)
+
+	client.IDToken = &oidc2.MockClaimer{
+		ClaimsFunc: func(i interface{}) error {
+			u, ok := i.(*user.User)
+			require.True(t, ok)
+
+			u.Sub = uuid.New().String()
+			u.Attributes = map[string]interface{}{TenantAttribute: tenant}
+
+			return nil
+		},
+	}
+
+	return o
+}
+
+// setupDeviceTest returns an Operation whose OIDC client authorizes the device of a new user, unless
+// the given client fails.
+func setupDeviceTest(t *testing.T, client *oidc2.MockClient) *Operation {
+	t.Helper()
+
+	client.DeviceToken = &oauth2.Token{
+		AccessToken:  uuid.New().String(),
+		RefreshToken: uuid.New().String(),
+		TokenType:    "Bearer",
+	}
+	client.IDToken = &oidc2.MockClaimer{
+		ClaimsFunc: func(i interface{}) error {
+			user, ok := i.(*user.User)
+			require.True(t, ok)
+			user.Sub = uuid.New().String()
+
+			return nil
+		},
+	}
+
+	config := config(t)
+	config.OIDCClient = client
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{}}}
+
+	return o
+}
+
+func newDeviceTokenRequest(body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/oidc/login/device/token", strings.NewReader(body))
+}
+
+func deviceLoginStatus(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	status := &DeviceLoginStatus{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(status))
+
+	return status.Status
+}
@@ -0,0 +1,228 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/escrow"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Admin endpoints.
+const (
+	shareEscrowPath   = "/users/escrow"
+	shareRecoveryPath = shareEscrowPath + "/recovery"
+	shareReissuePath  = shareEscrowPath + "/reissue"
+)
+
+// ShareEscrow is the escrow of the agent's share of a user's secret.
+type ShareEscrow struct {
+	Sub string `json:"sub"`
+	// Escrowed tells whether the share is escrowed, and Stale whether it must be re-issued to the escrows.
+	Escrowed bool           `json:"escrowed"`
+	Stale    bool           `json:"stale"`
+	Record   *escrow.Record `json:"record,omitempty"`
+}
+
+type shareEscrowAuditDetails struct {
+	Escrows []string `json:"escrows"`
+}
+
+// depositShare escrows the agent's share of the secret of a new user.
+func (o *Operation) depositShare(ctx context.Context, sub, share string) (*escrow.Record, error) {
+	stepCtx, cancel := o.onboardStep(ctx)
+	defer cancel()
+
+	record, err := o.escrow.Deposit(stepCtx, o.pseudonyms.ID(sub), []byte(share))
+	if err != nil {
+		return nil, fmt.Errorf("escrow secret share : %w", err)
+	}
+
+	return record, nil
+}
+
+// maintainEscrow keeps the escrow of the agent's share of a returning user's secret current: the share is
+// recovered from the escrows if the agent lost it, escrowed if the user was onboarded before the escrows were
+// configured, and re-issued if an escrow holding a share was rotated out. The login goes on if it fails.
+func (o *Operation) maintainEscrow(ctx context.Context, stored *user.User) {
+	if o.escrow == nil {
+		return
+	}
+
+	var err error
+
+	switch {
+	case stored.SecretShare == "" && stored.Escrow != nil:
+		_, err = o.recoverShare(ctx, stored.Sub)
+	case stored.SecretShare != "" && (stored.Escrow == nil || o.escrow.Stale(stored.Escrow)):
+		_, err = o.reissueShare(ctx, stored.Sub)
+	}
+
+	if err != nil {
+		logger.Errorf("failed to maintain the escrow of the secret share of user %s: %s", stored.Sub, err.Error())
+	}
+}
+
+// recoverShare withdraws the agent's share of the user's secret from the escrows and restores it.
+func (o *Operation) recoverShare(ctx context.Context, sub string) (*user.User, error) {
+	usr, err := o.store.users.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	if usr.Escrow == nil {
+		return nil, fmt.Errorf("%w: the secret share of the user is not escrowed", escrow.ErrUnrecoverable)
+	}
+
+	share, err := o.escrow.Recover(ctx, o.pseudonyms.ID(sub), usr.Escrow)
+	if err != nil {
+		return nil, err
+	}
+
+	usr, err = o.updateUser(sub, func(usr *user.User) bool {
+		if usr.SecretShare == string(share) {
+			return false
+		}
+
+		usr.SecretShare = string(share)
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	o.audit(audit.ActionShareRecovered, sub, &shareEscrowAuditDetails{Escrows: usr.Escrow.Escrows})
+
+	return usr, nil
+}
+
+// reissueShare splits the agent's share of the user's secret anew for the escrows not retired.
+func (o *Operation) reissueShare(ctx context.Context, sub string) (*user.User, error) {
+	usr, err := o.store.users.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	if usr.SecretShare == "" {
+		return nil, errors.New("the agent lost the secret share of the user: recover it first")
+	}
+
+	previous := usr.Escrow
+	if previous == nil {
+		previous = &escrow.Record{}
+	}
+
+	record, err := o.escrow.Reissue(ctx, o.pseudonyms.ID(sub), []byte(usr.SecretShare), previous)
+	if err != nil {
+		return nil, err
+	}
+
+	usr, err = o.updateUser(sub, func(usr *user.User) bool {
+		usr.Escrow = record
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	o.audit(audit.ActionShareReissued, sub, &shareEscrowAuditDetails{Escrows: record.Escrows})
+
+	return usr, nil
+}
+
+func (o *Operation) shareEscrowHandlers() []common.Handler {
+	params := []common.Param{{Name: "sub", In: common.InQuery, Description: "Sub of the user.", Required: true}}
+
+	return []common.Handler{
+		common.NewHTTPHandler(shareEscrowPath, http.MethodGet, o.shareEscrowHandler, &common.OperationSpec{
+			Summary:   "Returns which escrows hold the shares of the agent's share of the secret of a user.",
+			Params:    params,
+			Responses: map[int]interface{}{http.StatusOK: &ShareEscrow{}},
+		}),
+		common.NewHTTPHandler(shareRecoveryPath, http.MethodPost, o.shareRecoveryHandler, &common.OperationSpec{
+			Summary:   "Recovers the agent's share of the secret of a user from the escrows.",
+			Params:    params,
+			Responses: map[int]interface{}{http.StatusOK: &ShareEscrow{}},
+		}),
+		common.NewHTTPHandler(shareReissuePath, http.MethodPost, o.shareReissueHandler, &common.OperationSpec{
+			Summary:   "Re-issues the shares of the agent's share of the secret of a user to the escrows not retired.",
+			Params:    params,
+			Responses: map[int]interface{}{http.StatusOK: &ShareEscrow{}},
+		}),
+	}
+}
+
+func (o *Operation) shareEscrowHandler(w http.ResponseWriter, r *http.Request) {
+	o.handleShareEscrow(w, r, func(usr *user.User) (*user.User, error) {
+		return usr, nil
+	})
+}
+
+func (o *Operation) shareRecoveryHandler(w http.ResponseWriter, r *http.Request) {
+	o.handleShareEscrow(w, r, func(usr *user.User) (*user.User, error) {
+		return o.recoverShare(r.Context(), usr.Sub)
+	})
+}
+
+func (o *Operation) shareReissueHandler(w http.ResponseWriter, r *http.Request) {
+	o.handleShareEscrow(w, r, func(usr *user.User) (*user.User, error) {
+		return o.reissueShare(r.Context(), usr.Sub)
+	})
+}
+
+func (o *Operation) handleShareEscrow(w http.ResponseWriter, r *http.Request,
+	apply func(usr *user.User) (*user.User, error)) {
+	sub := r.URL.Query().Get("sub")
+	if sub == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing sub parameter")
+
+		return
+	}
+
+	usr, err := o.store.users.Get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "user not found")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to fetch user data: %s", err.Error())
+
+		return
+	}
+
+	usr, err = apply(usr)
+	if errors.Is(err, escrow.ErrUnrecoverable) {
+		common.WriteErrorResponsef(w, logger, http.StatusConflict, "%s", err.Error())
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, &ShareEscrow{
+		Sub:      sub,
+		Escrowed: usr.Escrow != nil,
+		Stale:    usr.Escrow == nil || o.escrow.Stale(usr.Escrow),
+		Record:   usr.Escrow,
+	})
+}
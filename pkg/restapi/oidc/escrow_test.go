@@ -0,0 +1,262 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"golang.org/x/oauth2"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/escrow"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestOperation_ShareEscrow(t *testing.T) {
+	t.Run("escrows the secret share of new users", func(t *testing.T) {
+		o := setupOnboardingTest(t, uuid.New().String())
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+		custodians := withEscrows(t, o, "a", "b")
+
+		sub := uuid.New().String()
+		require.NoError(t, o.provisionUser(context.Background(), &user.User{Sub: sub}, uuid.New().String()))
+
+		stored, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.NotNil(t, stored.Escrow)
+		require.Equal(t, []string{"a", "b"}, stored.Escrow.Escrows)
+		require.Contains(t, custodians[0].Shares, sub)
+
+		share, err := o.escrow.Recover(context.Background(), sub, stored.Escrow)
+		require.NoError(t, err)
+		require.Equal(t, stored.SecretShare, string(share))
+	})
+
+	t.Run("fails the onboarding if an escrow fails", func(t *testing.T) {
+		o := setupOnboardingTest(t, uuid.New().String())
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+		custodians := withEscrows(t, o, "a", "b")
+		custodians[1].DepositErr = errors.New("unreachable")
+
+		sub := uuid.New().String()
+		err := o.provisionUser(context.Background(), &user.User{Sub: sub}, uuid.New().String())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "escrow secret share")
+
+		_, err = o.store.users.Get(sub)
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("recovers the secret share lost by the agent at login", func(t *testing.T) {
+		o, sub := setupEscrowLogin(t)
+		withEscrows(t, o, "a", "b")
+
+		record, err := o.escrow.Deposit(context.Background(), sub, []byte("share"))
+		require.NoError(t, err)
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub, Escrow: record}))
+
+		loginReturning(t, o)
+
+		stored, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, "share", stored.SecretShare)
+	})
+
+	t.Run("re-issues the shares at login when an escrow is rotated out", func(t *testing.T) {
+		o, sub := setupEscrowLogin(t)
+		custodians := withEscrows(t, o, "a", "b")
+
+		record, err := o.escrow.Deposit(context.Background(), sub, []byte("share"))
+		require.NoError(t, err)
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub, SecretShare: "share", Escrow: record}))
+
+		c := escrow.NewMockCustodian()
+		o.escrow, err = escrow.New(&escrow.Config{Escrows: []*escrow.Escrow{
+			{ID: "a", Custodian: custodians[0]},
+			{ID: "b", Custodian: custodians[1], Retired: true},
+			{ID: "c", Custodian: c},
+		}})
+		require.NoError(t, err)
+
+		loginReturning(t, o)
+
+		stored, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "c"}, stored.Escrow.Escrows)
+		require.Empty(t, custodians[1].Shares)
+		require.Contains(t, c.Shares, sub)
+	})
+
+	t.Run("escrows the secret share of the users onboarded before the escrows at login", func(t *testing.T) {
+		o, sub := setupEscrowLogin(t)
+		withEscrows(t, o, "a")
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub, SecretShare: "share"}))
+
+		loginReturning(t, o)
+
+		stored, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, []string{"a"}, stored.Escrow.Escrows)
+	})
+
+	t.Run("logs the user in if the secret share is unrecoverable", func(t *testing.T) {
+		o, sub := setupEscrowLogin(t)
+		custodians := withEscrows(t, o, "a", "b")
+
+		record, err := o.escrow.Deposit(context.Background(), sub, []byte("share"))
+		require.NoError(t, err)
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub, Escrow: record}))
+
+		custodians[0].WithdrawErr = errors.New("unreachable")
+
+		loginReturning(t, o)
+
+		stored, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Empty(t, stored.SecretShare)
+	})
+}
+
+func TestOperation_ShareEscrowHandlers(t *testing.T) {
+	o, err := New(config(t))
+	require.NoError(t, err)
+
+	custodians := withEscrows(t, o, "a", "b")
+
+	handlers := o.GetAdminRESTHandlers()
+	require.Equal(t, shareEscrowPath, handlers[len(handlers)-4].Path())
+	require.Equal(t, shareReissuePath, handlers[len(handlers)-2].Path())
+
+	sub := uuid.New().String()
+	require.NoError(t, o.store.users.Save(&user.User{Sub: sub, SecretShare: "share"}))
+
+	t.Run("returns the escrow of the secret share", func(t *testing.T) {
+		result := &ShareEscrow{}
+		requireShareEscrow(t, o.shareEscrowHandler, http.MethodGet, shareEscrowPath+"?sub="+sub, http.StatusOK,
+			result)
+		require.False(t, result.Escrowed)
+		require.True(t, result.Stale)
+	})
+
+	t.Run("re-issues the shares", func(t *testing.T) {
+		result := &ShareEscrow{}
+		requireShareEscrow(t, o.shareReissueHandler, http.MethodPost, shareReissuePath+"?sub="+sub, http.StatusOK,
+			result)
+		require.True(t, result.Escrowed)
+		require.False(t, result.Stale)
+		require.Equal(t, []string{"a", "b"}, result.Record.Escrows)
+	})
+
+	t.Run("recovers the secret share", func(t *testing.T) {
+		_, err := o.updateUser(sub, func(usr *user.User) bool {
+			usr.SecretShare = ""
+
+			return true
+		})
+		require.NoError(t, err)
+
+		requireShareEscrow(t, o.shareRecoveryHandler, http.MethodPost, shareRecoveryPath+"?sub="+sub,
+			http.StatusOK, &ShareEscrow{})
+
+		stored, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, "share", stored.SecretShare)
+	})
+
+	t.Run("conflict if the secret share is unrecoverable", func(t *testing.T) {
+		delete(custodians[0].Shares, sub)
+
+		requireShareEscrow(t, o.shareRecoveryHandler, http.MethodPost, shareRecoveryPath+"?sub="+sub,
+			http.StatusConflict, nil)
+	})
+
+	t.Run("internal server error if an escrow fails", func(t *testing.T) {
+		custodians[1].DepositErr = errors.New("unreachable")
+		defer func() { custodians[1].DepositErr = nil }()
+
+		requireShareEscrow(t, o.shareReissueHandler, http.MethodPost, shareReissuePath+"?sub="+sub,
+			http.StatusInternalServerError, nil)
+	})
+
+	t.Run("bad request without sub", func(t *testing.T) {
+		requireShareEscrow(t, o.shareEscrowHandler, http.MethodGet, shareEscrowPath, http.StatusBadRequest, nil)
+	})
+
+	t.Run("user not found", func(t *testing.T) {
+		requireShareEscrow(t, o.shareEscrowHandler, http.MethodGet, shareEscrowPath+"?sub=unknown",
+			http.StatusNotFound, nil)
+	})
+}
+
+// withEscrows escrows the secret shares of the users with mock escrows of the IDs, with a majority threshold.
+func withEscrows(t *testing.T, o *Operation, ids ...string) []*escrow.MockCustodian {
+	t.Helper()
+
+	config := &escrow.Config{}
+
+	var custodians []*escrow.MockCustodian
+
+	for _, id := range ids {
+		c := escrow.NewMockCustodian()
+		custodians = append(custodians, c)
+		config.Escrows = append(config.Escrows, &escrow.Escrow{ID: id, Custodian: c})
+	}
+
+	var err error
+
+	o.escrow, err = escrow.New(config)
+	require.NoError(t, err)
+
+	return custodians
+}
+
+func setupEscrowLogin(t *testing.T) (*Operation, string) {
+	t.Helper()
+
+	sub := uuid.New().String()
+	o := setupOnboardingTest(t, "state")
+	o.oidcClient = &oidc2.MockClient{
+		OAuthToken: &oauth2.Token{AccessToken: uuid.New().String()},
+		IDToken:    idToken(t, map[string]interface{}{"sub": sub}),
+	}
+
+	return o, sub
+}
+
+// loginReturning logs the user of setupEscrowLogin in.
+func loginReturning(t *testing.T, o *Operation) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", "state"))
+	require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+}
+
+func requireShareEscrow(t *testing.T, handler http.HandlerFunc, method, target string, status int,
+	result *ShareEscrow) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, target, nil))
+	require.Equal(t, status, w.Code, w.Body.String())
+
+	if result != nil {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+	}
+}
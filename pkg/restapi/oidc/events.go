@@ -0,0 +1,173 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Event topics.
+const (
+	// TopicUserCreated is published once a new user has been onboarded and persisted.
+	TopicUserCreated = "user.created"
+	// TopicUserOnboarded is published along with user.created and lists the keystores provisioned for the user.
+	TopicUserOnboarded = "user.onboarded"
+	// TopicVaultCreated is published for each EDV vault created while onboarding a user.
+	TopicVaultCreated = "vault.created"
+	// TopicUserLogin is published on every login.
+	TopicUserLogin = "user.login"
+	// TopicTokensRefreshed is published when the login of a returning user replaces their stored tokens.
+	TopicTokensRefreshed = "tokens.refreshed"
+	// TopicLoginLockout is published when a client IP address or a user is locked out after repeated failed logins.
+	TopicLoginLockout = "login.lockout"
+)
+
+// TenantAttribute is the user attribute holding the tenant of the user's events. It is set by the claims
+// mapper, from a claim of the ID token.
+const TenantAttribute = "tenant"
+
+// Vault purposes.
+const (
+	opsVaultPurpose  = "operational"
+	userVaultPurpose = "user"
+)
+
+type userPayload struct {
+	Sub string `json:"sub"`
+}
+
+type userOnboardedPayload struct {
+	Sub              string `json:"sub"`
+	AuthzKeyStoreURL string `json:"authzKeyStoreURL"`
+	OpsKeyStoreURL   string `json:"opsKeyStoreURL"`
+}
+
+type vaultCreatedPayload struct {
+	Sub      string `json:"sub"`
+	VaultURL string `json:"vaultURL"`
+	Purpose  string `json:"purpose"`
+}
+
+// beginEvents records the events ahead of their state change. Returns a no-op Tx if events are disabled.
+func (o *Operation) beginEvents(events ...*outbox.Event) (*outbox.Tx, error) {
+	if o.store.outbox == nil {
+		return nil, nil
+	}
+
+	return o.store.outbox.Begin(events...)
+}
+
+func (o *Operation) beginUserCreated(usr *user.User, data *BootstrapData) (*outbox.Tx, error) {
+	if o.store.outbox == nil {
+		return nil, nil
+	}
+
+	events := []*eventSpec{
+		{topic: TopicUserCreated, payload: &userPayload{Sub: usr.Sub}},
+		{topic: TopicUserOnboarded, payload: &userOnboardedPayload{
+			Sub:              usr.Sub,
+			AuthzKeyStoreURL: data.AuthzKeyStoreURL,
+			OpsKeyStoreURL:   data.OpsKeyStoreURL,
+		}},
+		{topic: TopicVaultCreated, payload: &vaultCreatedPayload{
+			Sub: usr.Sub, VaultURL: data.OpsEDVVaultURL, Purpose: opsVaultPurpose,
+		}},
+	}
+
+	if data.UserEDVVaultURL != "" {
+		events = append(events, &eventSpec{topic: TopicVaultCreated, payload: &vaultCreatedPayload{
+			Sub: usr.Sub, VaultURL: data.UserEDVVaultURL, Purpose: userVaultPurpose,
+		}})
+	}
+
+	return o.beginUserEvents(usr, events...)
+}
+
+func (o *Operation) beginLoginEvents(usr *user.User, returning bool) (*outbox.Tx, error) {
+	if o.store.outbox == nil {
+		return nil, nil
+	}
+
+	events := []*eventSpec{{topic: TopicUserLogin, payload: &userPayload{Sub: usr.Sub}}}
+
+	if returning {
+		events = append(events, &eventSpec{topic: TopicTokensRefreshed, payload: &userPayload{Sub: usr.Sub}})
+	}
+
+	return o.beginUserEvents(usr, events...)
+}
+
+type eventSpec struct {
+	topic   string
+	payload interface{}
+}
+
+// beginUserEvents records events about the user, under the user's tenant.
+func (o *Operation) beginUserEvents(usr *user.User, specs ...*eventSpec) (*outbox.Tx, error) {
+	tenant, _ := usr.Attributes[TenantAttribute].(string) // nolint:errcheck // users without a tenant have none
+
+	events := make([]*outbox.Event, len(specs))
+
+	for i, spec := range specs {
+		e, err := outbox.NewEvent(spec.topic, usr.Sub, spec.payload)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Tenant = tenant
+		events[i] = e
+	}
+
+	return o.beginEvents(events...)
+}
+
+// confirmEvent checks whether the state change behind a pending event was committed.
+func (o *Operation) confirmEvent(e *outbox.Event) (bool, error) {
+	var err error
+
+	switch e.Topic {
+	case TopicUserLogin, TopicTokensRefreshed:
+		_, err = o.store.tokens.Get(e.Subject)
+	case TopicUserCreated, TopicUserOnboarded, TopicVaultCreated:
+		_, err = o.store.users.Get(e.Subject)
+	case TopicLoginLockout:
+		// lockouts are not persisted, the event is the record of the lockout
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported event topic: %s", e.Topic)
+	}
+
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func rollbackEvents(tx *outbox.Tx) {
+	err := tx.Rollback()
+	if err != nil {
+		logger.Errorf("failed to rollback events: %s", err.Error())
+	}
+}
+
+func commitEvents(tx *outbox.Tx) {
+	err := tx.Commit()
+	if err != nil {
+		// the relay confirms and delivers events left pending
+		logger.Warnf("failed to commit events: %s", err.Error())
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+	"golang.org/x/oauth2"
+)
+
+func TestNew_Events(t *testing.T) {
+	config := config(t)
+	config.Events = &EventsConfig{Dispatcher: &mockDispatcher{}}
+
+	o, err := New(config)
+	require.NoError(t, err)
+	require.NotNil(t, o.store.outbox)
+	require.NotNil(t, o.relay)
+	o.Close()
+
+	t.Run("shares the outbox of the config", func(t *testing.T) {
+		shared, err := outbox.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		config.Events = &EventsConfig{Dispatcher: &mockDispatcher{}, Outbox: shared, MaxAttempts: 3}
+
+		o, err := New(config)
+		require.NoError(t, err)
+		require.Equal(t, shared, o.store.outbox)
+		o.Close()
+	})
+}
+
+func TestOperation_UserCreatedEvent(t *testing.T) {
+	t.Run("records the user events once the user is onboarded", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupEventsTest(t, state)
+		o.httpClient = mockKMSHTTPClient()
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		events, err := o.store.outbox.List()
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{
+			TopicUserCreated, TopicUserOnboarded, TopicVaultCreated, TopicVaultCreated, TopicUserLogin,
+		}, topics(events))
+
+		for _, e := range events {
+			require.Equal(t, outbox.StatusReady, e.Status)
+			require.Equal(t, "acme", e.Tenant)
+
+			confirmed, err := o.confirmEvent(e)
+			require.NoError(t, err)
+			require.True(t, confirmed)
+		}
+	})
+
+	t.Run("records tokens.refreshed when a returning user logs in", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupEventsTest(t, state)
+		o.httpClient = mockKMSHTTPClient()
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		require.NoError(t, o.relay.Flush())
+
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{stateCookieName: state},
+		}}
+
+		w = httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		events, err := o.store.outbox.List()
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{TopicUserLogin, TopicTokensRefreshed}, topics(events))
+	})
+
+	t.Run("discards the login events if tokens cannot be saved", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupEventsTest(t, state)
+		o.httpClient = mockKMSHTTPClient()
+
+		var err error
+
+		o.store.tokens, err = tokens.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{}, ErrPut: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to persist user tokens")
+
+		events, err := o.store.outbox.List()
+		require.NoError(t, err)
+		require.NotContains(t, topics(events), TopicUserLogin)
+	})
+
+	t.Run("discards user.created if onboarding fails", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupEventsTest(t, state)
+		o.httpClient = &mockHTTPClient{
+			DoFunc: func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("test")
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		events, err := o.store.outbox.List()
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+}
+
+func TestOperation_ConfirmEvent(t *testing.T) {
+	o := setupEventsTest(t, "")
+
+	t.Run("not committed if user does not exist", func(t *testing.T) {
+		confirmed, err := o.confirmEvent(&outbox.Event{Topic: TopicUserCreated, Subject: uuid.New().String()})
+		require.NoError(t, err)
+		require.False(t, confirmed)
+	})
+
+	t.Run("committed if user exists", func(t *testing.T) {
+		sub := uuid.New().String()
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub}))
+		confirmed, err := o.confirmEvent(&outbox.Event{Topic: TopicUserCreated, Subject: sub})
+		require.NoError(t, err)
+		require.True(t, confirmed)
+	})
+
+	t.Run("login committed if tokens exist", func(t *testing.T) {
+		sub := uuid.New().String()
+		confirmed, err := o.confirmEvent(&outbox.Event{Topic: TopicUserLogin, Subject: sub})
+		require.NoError(t, err)
+		require.False(t, confirmed)
+
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub}))
+		confirmed, err = o.confirmEvent(&outbox.Event{Topic: TopicTokensRefreshed, Subject: sub})
+		require.NoError(t, err)
+		require.True(t, confirmed)
+	})
+
+	t.Run("error if store fails", func(t *testing.T) {
+		o := setupEventsTest(t, "")
+
+		var err error
+
+		o.store.users, err = user.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{"sub": []byte("{}")}, ErrGet: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		_, err = o.confirmEvent(&outbox.Event{Topic: TopicVaultCreated, Subject: "sub"})
+		require.Error(t, err)
+	})
+
+	t.Run("error on unsupported topic", func(t *testing.T) {
+		_, err := o.confirmEvent(&outbox.Event{Topic: "unknown"})
+		require.Error(t, err)
+	})
+}
+
+func setupEventsTest(t *testing.T, state string) *Operation {
+	t.Helper()
+
+	o := setupOnboardingTest(t, state)
+
+	sub := uuid.New().String()
+
+	o.oidcClient = &oidc2.MockClient{
+		OAuthToken: &oauth2.Token{AccessToken: uuid.New().String(), TokenType: "Bearer"},
+		IDToken: &oidc2.MockClaimer{
+			ClaimsFunc: func(i interface{}) error {
+				u, ok := i.(*user.User)
+				require.True(t, ok)
+
+				u.Sub = sub
+				u.Attributes = map[string]interface{}{TenantAttribute: "acme"}
+
+				return nil
+			},
+		},
+	}
+
+	var err error
+
+	o.store.outbox, err = outbox.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	o.relay = outbox.NewRelay(&outbox.RelayConfig{
+		Store:      o.store.outbox,
+		Dispatcher: &mockDispatcher{},
+		Confirm:    o.confirmEvent,
+		Interval:   time.Hour,
+	})
+	o.keyEDVClient = &mockEDVClient{NoCapability: true}
+	o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+	return o
+}
+
+func topics(events []*outbox.Event) []string {
+	result := make([]string, len(events))
+
+	for i, e := range events {
+		result[i] = e.Topic
+	}
+
+	return result
+}
+
+type mockDispatcher struct {
+	events []*outbox.Event
+}
+
+func (m *mockDispatcher) Dispatch(e *outbox.Event) error {
+	m.events = append(m.events, e)
+
+	return nil
+}
@@ -0,0 +1,423 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/jobs"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	exportPath      = "/userinfo/export"
+	exportKeyPrefix = "export_"
+	// a single worker keeps large exports from competing with the logins of the users.
+	exportWorkers = 1
+)
+
+// the query parameters of the export requests.
+const (
+	exportFormatParam = "format"
+	exportEDVParam    = "edv"
+	exportAsyncParam  = "async"
+	exportJobParam    = "job"
+)
+
+// Export formats.
+const (
+	ExportFormatJSON = "json"
+	ExportFormatZIP  = "zip"
+)
+
+// UserDataExport is the data the agent holds about a user, for the user to take to another service.
+type UserDataExport struct {
+	ExportedAt time.Time        `json:"exportedAt"`
+	Profile    *ExportedProfile `json:"profile"`
+	Tokens     *ExportedTokens  `json:"tokens"`
+	Bootstrap  *BootstrapData   `json:"bootstrap,omitempty"`
+	Audit      []*audit.Entry   `json:"audit"`
+	// EDVDocuments are the ids of the documents in the user's EDV vault, if requested.
+	EDVDocuments []string `json:"edvDocuments,omitempty"`
+}
+
+// ExportedProfile is the profile of the user, without the agent's half of the user's secret.
+type ExportedProfile struct {
+	*user.User
+	SecretShare string `json:"secretShare,omitempty"`
+}
+
+// ExportedTokens describes the tokens the agent holds for the user. The tokens themselves are not exported.
+type ExportedTokens struct {
+	AccessToken  bool `json:"accessToken"`
+	RefreshToken bool `json:"refreshToken"`
+}
+
+// ExportJob is the progress of an asynchronous export.
+type ExportJob struct {
+	ID string `json:"id"`
+	// Status is one of the onboarding statuses.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// exportRecord is an asynchronous export kept until the user downloads it.
+type exportRecord struct {
+	ExportJob
+	Sub     string    `json:"sub"`
+	Format  string    `json:"format"`
+	Archive []byte    `json:"archive,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+func newExportQueue() *jobs.Queue {
+	queue := jobs.NewQueue(&jobs.Config{Workers: exportWorkers})
+	queue.Start()
+
+	return queue
+}
+
+// exportHandler sends the user their data as a JSON document or a ZIP archive. With async=true, the export is
+// built in the background and downloaded with the job parameter once completed.
+func (o *Operation) exportHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling user data export request")
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusForbidden, "not logged in")
+
+		return
+	}
+
+	sub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return
+	}
+
+	if id := r.URL.Query().Get(exportJobParam); id != "" {
+		o.downloadExport(w, sub, id)
+
+		return
+	}
+
+	format := r.URL.Query().Get(exportFormatParam)
+	if format == "" {
+		format = ExportFormatJSON
+	}
+
+	if format != ExportFormatJSON && format != ExportFormatZIP {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadRequest, "invalid export format '%s': must be %s or %s",
+			format, ExportFormatJSON, ExportFormatZIP)
+
+		return
+	}
+
+	withEDV := r.URL.Query().Get(exportEDVParam) == "true"
+
+	if r.URL.Query().Get(exportAsyncParam) == "true" {
+		o.enqueueExport(w, sub, format, withEDV)
+
+		return
+	}
+
+	archive, err := o.exportUserData(r.Context(), sub, format, withEDV)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to export user data: %s", err.Error())
+
+		return
+	}
+
+	writeExport(w, format, archive)
+	logger.Debugf("finished handling user data export request")
+}
+
+func (o *Operation) enqueueExport(w http.ResponseWriter, sub, format string, withEDV bool) {
+	record := &exportRecord{
+		ExportJob: ExportJob{ID: o.newID().String(), Status: OnboardingPending},
+		Sub:       sub,
+		Format:    format,
+		Expires:   o.transientExpiry(),
+	}
+
+	err := store.Save(o.store.transient, exportKeyPrefix+record.ID, record)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to save export job: %s", err.Error())
+
+		return
+	}
+
+	// the worker updates the record as the export progresses
+	job := record.ExportJob
+
+	err = o.exports.Enqueue(func() {
+		o.runExport(record, withEDV)
+	})
+	if err != nil {
+		o.deleteExport(job.ID)
+		common.WriteErrorResponsef(w, logger,
+			http.StatusServiceUnavailable, "failed to schedule export: %s", err.Error())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	common.WriteResponse(w, logger, &job)
+}
+
+func (o *Operation) runExport(record *exportRecord, withEDV bool) {
+	record.Status = OnboardingRunning
+	o.saveExport(record)
+
+	// the export outlives the request of the user
+	archive, err := o.exportUserData(context.Background(), record.Sub, record.Format, withEDV)
+	if err != nil {
+		logger.Errorf("failed to export data of user %s: %s", record.Sub, err.Error())
+
+		record.Status = OnboardingFailed
+		record.Error = err.Error()
+		o.saveExport(record)
+
+		return
+	}
+
+	record.Status = OnboardingCompleted
+	record.Archive = archive
+	o.saveExport(record)
+}
+
+// downloadExport sends the archive of a completed export, which is then deleted, or the status of the export.
+func (o *Operation) downloadExport(w http.ResponseWriter, sub, id string) {
+	bits, err := o.store.transient.Get(exportKeyPrefix + id)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to fetch export job: %s", err.Error())
+
+		return
+	}
+
+	record := &exportRecord{}
+
+	if err == nil {
+		err = json.Unmarshal(bits, record)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to unmarshal export job: %s", err.Error())
+
+			return
+		}
+	}
+
+	// the exports of other users are not disclosed
+	if record.Sub != sub {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusNotFound, "export job not found")
+
+		return
+	}
+
+	switch record.Status {
+	case OnboardingCompleted:
+		o.deleteExport(id)
+		writeExport(w, record.Format, record.Archive)
+	case OnboardingFailed:
+		o.deleteExport(id)
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to export user data: %s", record.Error)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+		common.WriteResponse(w, logger, &record.ExportJob)
+	}
+}
+
+func (o *Operation) saveExport(record *exportRecord) {
+	err := store.Save(o.store.transient, exportKeyPrefix+record.ID, record)
+	if err != nil {
+		logger.Errorf("failed to save export job %s: %s", record.ID, err.Error())
+	}
+}
+
+func (o *Operation) deleteExport(id string) {
+	err := o.store.transient.Delete(exportKeyPrefix + id)
+	if err != nil {
+		logger.Warnf("failed to delete export job %s: %s", id, err.Error())
+	}
+}
+
+// exportUserData collects the user's data and packs it in the given format.
+func (o *Operation) exportUserData(ctx context.Context, sub, format string, withEDV bool) ([]byte, error) {
+	export, err := o.collectUserData(ctx, sub, withEDV)
+	if err != nil {
+		return nil, err
+	}
+
+	// the export is audited once collected, so that it does not list itself
+	o.audit(audit.ActionDataExported, sub, nil)
+
+	if format == ExportFormatZIP {
+		return zipExport(export)
+	}
+
+	return json.Marshal(export)
+}
+
+func (o *Operation) collectUserData(ctx context.Context, sub string, withEDV bool) (*UserDataExport, error) {
+	usr, err := o.store.users.Get(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user data: %w", err)
+	}
+
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user tokens: %w", err)
+	}
+
+	bootstrap, err := o.fetchBootstrapData(ctx, tokns.Access)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bootstrap data: %w", err)
+	}
+
+	export := &UserDataExport{
+		ExportedAt: o.now().UTC(),
+		Profile:    &ExportedProfile{User: usr},
+		Tokens: &ExportedTokens{
+			AccessToken:  tokns.Access != "",
+			RefreshToken: tokns.Refresh != "",
+		},
+		Bootstrap: bootstrap.Data,
+		Audit:     []*audit.Entry{},
+	}
+
+	if o.auditLog != nil {
+		export.Audit, err = o.auditLog.List(sub)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list audit entries: %w", err)
+		}
+	}
+
+	if withEDV {
+		export.EDVDocuments, err = o.edvDocumentIDs(sub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return export, nil
+}
+
+// edvDocumentIDs lists the ids of the documents in the user's EDV vault.
+func (o *Operation) edvDocumentIDs(sub string) ([]string, error) {
+	credentials, err := o.openCredentialsStore(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the EDV store ignores the key range and returns every document of the store
+	iter := credentials.Iterator("", ariesstorage.EndKeySuffix)
+	defer iter.Release()
+
+	ids := []string{}
+
+	for iter.Next() {
+		ids = append(ids, string(iter.Key()))
+	}
+
+	if err = iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read edv documents: %w", err)
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+type exportFile struct {
+	name    string
+	content interface{}
+}
+
+// zipExport packs each part of the export in a JSON file of its own.
+func zipExport(export *UserDataExport) ([]byte, error) {
+	files := []exportFile{
+		{"profile.json", export.Profile},
+		{"tokens.json", export.Tokens},
+		{"bootstrap.json", export.Bootstrap},
+		{"audit.json", export.Audit},
+	}
+
+	if export.EDVDocuments != nil {
+		files = append(files, exportFile{"edv_documents.json", export.EDVDocuments})
+	}
+
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s to export archive: %w", file.name, err)
+		}
+
+		err = json.NewEncoder(f).Encode(file.content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s to export archive: %w", file.name, err)
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to close export archive: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeExport(w http.ResponseWriter, format string, archive []byte) {
+	contentType := "application/json"
+	if format == ExportFormatZIP {
+		contentType = "application/zip"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="wallet-export.%s"`, format))
+
+	_, err := w.Write(archive)
+	if err != nil {
+		logger.Errorf("failed to write user data export: %s", err.Error())
+	}
+}
@@ -0,0 +1,227 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	ariesmem "github.com/hyperledger/aries-framework-go/pkg/storage/mem"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+)
+
+func TestOperation_Export(t *testing.T) {
+	t.Run("exports the user data as JSON", func(t *testing.T) {
+		o, sub := setupExportTest(t)
+		o.audit(audit.ActionLogin, sub, nil)
+
+		w := export(o, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		require.Contains(t, w.Header().Get("Content-Disposition"), "wallet-export.json")
+
+		exported := &UserDataExport{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), exported))
+		require.Equal(t, sub, exported.Profile.Sub)
+		require.Equal(t, "https://edv.example.com/encrypted-data-vaults/123", exported.Bootstrap.UserEDVVaultURL)
+		require.Equal(t, &ExportedTokens{AccessToken: true}, exported.Tokens)
+		require.Len(t, exported.Audit, 1)
+		require.Equal(t, audit.ActionLogin, exported.Audit[0].Action)
+		require.Nil(t, exported.EDVDocuments)
+
+		// neither the tokens nor the agent's half of the user's secret leave the agent
+		require.NotContains(t, w.Body.String(), "share")
+		require.NotContains(t, w.Body.String(), `"token"`)
+
+		entries, err := o.auditLog.List(sub)
+		require.NoError(t, err)
+		require.Equal(t, audit.ActionDataExported, entries[len(entries)-1].Action)
+	})
+
+	t.Run("exports the ids of the documents in the user vault", func(t *testing.T) {
+		o, sub := setupExportTest(t)
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:2", []byte(`{}`)))
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte(`{}`)))
+
+		w := export(o, "/oidc/userinfo/export?edv=true")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		exported := &UserDataExport{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), exported))
+		require.Equal(t, []string{"urn:uuid:1", "urn:uuid:2"}, exported.EDVDocuments)
+	})
+
+	t.Run("exports the user data as a ZIP archive", func(t *testing.T) {
+		o, _ := setupExportTest(t)
+
+		w := export(o, "/oidc/userinfo/export?format=zip&edv=true")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+		require.Equal(t, []string{
+			"profile.json", "tokens.json", "bootstrap.json", "audit.json", "edv_documents.json",
+		}, zipFiles(t, w.Body.Bytes()))
+	})
+
+	t.Run("exports the user data in the background", func(t *testing.T) {
+		o, sub := setupExportTest(t)
+
+		w := export(o, "/oidc/userinfo/export?async=true&format=zip")
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		job := &ExportJob{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), job))
+		require.NotEmpty(t, job.ID)
+
+		o.exports.Stop()
+
+		// the exports of other users are not disclosed
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: "other"},
+		}}
+		require.Equal(t, http.StatusNotFound, export(o, "/oidc/userinfo/export?job="+job.ID).Code)
+
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: sub},
+		}}
+
+		w = export(o, "/oidc/userinfo/export?job="+job.ID)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+		require.Contains(t, zipFiles(t, w.Body.Bytes()), "profile.json")
+
+		// the export is deleted once downloaded
+		require.Equal(t, http.StatusNotFound, export(o, "/oidc/userinfo/export?job="+job.ID).Code)
+	})
+
+	t.Run("reports the progress of a background export", func(t *testing.T) {
+		o, sub := setupExportTest(t)
+		record := &exportRecord{ExportJob: ExportJob{ID: "123", Status: OnboardingRunning}, Sub: sub}
+		o.saveExport(record)
+
+		w := export(o, "/oidc/userinfo/export?job=123")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.JSONEq(t, `{"id": "123", "status": "running"}`, w.Body.String())
+
+		record.Status = OnboardingFailed
+		record.Error = "test"
+		o.saveExport(record)
+
+		w = export(o, "/oidc/userinfo/export?job=123")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to export user data: test")
+	})
+
+	t.Run("error if a background export cannot be scheduled", func(t *testing.T) {
+		o, _ := setupExportTest(t)
+		o.exports.Stop()
+
+		w := export(o, "/oidc/userinfo/export?async=true")
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Contains(t, w.Body.String(), "failed to schedule export")
+	})
+
+	t.Run("error if the format is invalid", func(t *testing.T) {
+		o, _ := setupExportTest(t)
+
+		w := export(o, "/oidc/userinfo/export?format=xml")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "invalid export format 'xml'")
+	})
+
+	t.Run("error if not logged in", func(t *testing.T) {
+		o, _ := setupExportTest(t)
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{}}}
+
+		require.Equal(t, http.StatusForbidden, export(o, "/oidc/userinfo/export").Code)
+	})
+
+	t.Run("error if the bootstrap data cannot be fetched", func(t *testing.T) {
+		o, _ := setupExportTest(t)
+		o.httpClient = &mockHTTPClient{DoFunc: func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("test")
+		}}
+
+		w := export(o, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch bootstrap data")
+	})
+
+	t.Run("error if the user vault cannot be opened", func(t *testing.T) {
+		o, _ := setupExportTest(t)
+		o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+			return nil, errors.New("test")
+		}
+
+		w := export(o, "/oidc/userinfo/export?edv=true")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to open user vault")
+	})
+}
+
+func setupExportTest(t *testing.T) (*Operation, string) {
+	t.Helper()
+
+	o, sub := setupCheckTest(t)
+	t.Cleanup(o.Close)
+
+	o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+	o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+		Cookies: map[interface{}]interface{}{userSubCookieName: sub},
+	}}
+
+	vault := ariesmem.NewProvider()
+	o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+		return vault, nil
+	}
+
+	var err error
+
+	o.auditLog, err = audit.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	return o, sub
+}
+
+func export(o *Operation, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	o.exportHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return w
+}
+
+func zipFiles(t *testing.T, archive []byte) []string {
+	t.Helper()
+
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(r.File))
+
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+
+		content, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		require.True(t, json.Valid(content))
+
+		names = append(names, f.Name)
+	}
+
+	return names
+}
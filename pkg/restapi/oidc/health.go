@@ -0,0 +1,150 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/health"
+)
+
+const statusPath = "/status"
+
+// Dependencies of the agent, as named by the probes of the health watcher.
+const (
+	DependencyOIDCProvider = "op"
+	DependencyKMS          = "hub-kms"
+	DependencyEDV          = "edv"
+)
+
+// Availabilities of the features.
+const (
+	FeatureAvailable   = "available"
+	FeatureDeferred    = "deferred"
+	FeatureCached      = "cached"
+	FeatureUnavailable = "unavailable"
+)
+
+// Features tells how the features of the agent are served, given the health of its dependencies.
+type Features struct {
+	// Login is unavailable while the OIDC provider is down, unless the users can log in with a DID, and under
+	// maintenance.
+	Login string `json:"login"`
+	// Onboarding of the new users is deferred while the KMS or EDV servers are down and under maintenance, or
+	// unavailable if the users are onboarded synchronously.
+	Onboarding string `json:"onboarding"`
+	// UserInfo is served from the cache while the OIDC provider is down, or unavailable if it is not cached.
+	UserInfo string `json:"userInfo"`
+}
+
+// AgentStatus is the health of the dependencies of the agent, and the features it serves.
+type AgentStatus struct {
+	Dependencies map[string]*health.Status `json:"dependencies"`
+	Features     *Features                 `json:"features"`
+}
+
+// GetStatusRESTHandlers returns the handler of the status of the agent, if its dependencies are watched. It is
+// meant to be mounted at the root of the server, for the wallet UI to query ahead of the login.
+func (o *Operation) GetStatusRESTHandlers() []common.Handler {
+	if o.health == nil {
+		return nil
+	}
+
+	return []common.Handler{
+		common.NewHTTPHandler(statusPath, http.MethodGet, o.statusHandler, &common.OperationSpec{
+			Summary:   "Returns the health of the dependencies of the agent, and the features it serves.",
+			Responses: map[int]interface{}{http.StatusOK: &AgentStatus{}},
+		}),
+	}
+}
+
+func (o *Operation) statusHandler(w http.ResponseWriter, _ *http.Request) {
+	common.WriteResponse(w, logger, &AgentStatus{
+		Dependencies: o.health.Statuses(),
+		Features:     o.features(),
+	})
+}
+
+func (o *Operation) features() *Features {
+	features := &Features{
+		Login:      FeatureAvailable,
+		Onboarding: FeatureAvailable,
+		UserInfo:   FeatureAvailable,
+	}
+
+	if o.down(DependencyOIDCProvider) {
+		if o.siop == nil {
+			features.Login = FeatureUnavailable
+		}
+
+		features.UserInfo = FeatureUnavailable
+
+		if o.userInfoCache != nil {
+			features.UserInfo = FeatureCached
+		}
+	}
+
+	if o.downOnboardingDependency() != "" {
+		features.Onboarding = FeatureUnavailable
+
+		if o.onboarding != nil {
+			features.Onboarding = FeatureDeferred
+		}
+	}
+
+	// the logins are refused under maintenance, and the onboardings enqueued wait for its end
+	if o.maintenance.Enabled() {
+		features.Login = FeatureUnavailable
+		features.Onboarding = FeatureUnavailable
+
+		if o.onboarding != nil {
+			features.Onboarding = FeatureDeferred
+		}
+	}
+
+	return features
+}
+
+// down tells whether the dependency is down.
+func (o *Operation) down(dependency string) bool {
+	return o.health != nil && !o.health.Up(dependency)
+}
+
+// downOnboardingDependency returns the KMS or EDV dependency which is down, if any.
+func (o *Operation) downOnboardingDependency() string {
+	for _, dependency := range []string{DependencyKMS, DependencyEDV} {
+		if o.down(dependency) {
+			return dependency
+		}
+	}
+
+	return ""
+}
+
+// dependencyChanged schedules the deferred onboardings once the KMS and EDV servers are up again.
+func (o *Operation) dependencyChanged(_ string, up bool) {
+	if !up || o.onboarding == nil || o.downOnboardingDependency() != "" {
+		return
+	}
+
+	o.onboarding.mutex.Lock()
+	defer o.onboarding.mutex.Unlock()
+
+	for sub, job := range o.onboarding.deferred {
+		delete(o.onboarding.deferred, sub)
+
+		err := o.onboarding.queue.Enqueue(job)
+		if err != nil {
+			o.recordOnboarding(sub, OnboardingFailed, err)
+
+			continue
+		}
+
+		logger.Infof("resumed the deferred onboarding of user %s", sub)
+	}
+}
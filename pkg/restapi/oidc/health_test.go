@@ -0,0 +1,239 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/health"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/maintenance"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+)
+
+func TestOperation_StatusHandler(t *testing.T) {
+	t.Run("no status if the dependencies are not watched", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		require.Empty(t, o.GetStatusRESTHandlers())
+	})
+
+	t.Run("reports the features available given the health of the dependencies", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		deps := watchDependencies(o)
+		require.Len(t, o.GetStatusRESTHandlers(), 1)
+
+		status := agentStatus(t, o)
+		require.True(t, status.Dependencies[DependencyKMS].Up)
+		require.Equal(t, &Features{
+			Login:      FeatureAvailable,
+			Onboarding: FeatureAvailable,
+			UserInfo:   FeatureAvailable,
+		}, status.Features)
+
+		deps.fail(DependencyOIDCProvider, errors.New("connection refused"))
+		deps.fail(DependencyEDV, errors.New("connection refused"))
+
+		status = agentStatus(t, o)
+		require.False(t, status.Dependencies[DependencyOIDCProvider].Up)
+		require.Equal(t, "connection refused", status.Dependencies[DependencyOIDCProvider].Error)
+		require.Equal(t, &Features{
+			Login:      FeatureUnavailable,
+			Onboarding: FeatureUnavailable,
+			UserInfo:   FeatureUnavailable,
+		}, status.Features)
+	})
+
+	t.Run("reports the degraded features served in place", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+		o.userInfoCache = &UserInfoCacheConfig{TTL: time.Hour}
+		o.onboarding = &onboarding{deferred: map[string]func(){}}
+
+		deps := watchDependencies(o)
+		deps.fail(DependencyOIDCProvider, errors.New("test"))
+		deps.fail(DependencyKMS, errors.New("test"))
+
+		require.Equal(t, &Features{
+			Login:      FeatureAvailable,
+			Onboarding: FeatureDeferred,
+			UserInfo:   FeatureCached,
+		}, agentStatus(t, o).Features)
+	})
+	t.Run("reports the login unavailable and the onboarding deferred under maintenance", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		o.maintenance, err = maintenance.New(&maintenance.Config{Storage: memstore.NewProvider()})
+		require.NoError(t, err)
+		require.NoError(t, o.maintenance.Set(&maintenance.Mode{Enabled: true}))
+
+		watchDependencies(o)
+		require.Equal(t, &Features{
+			Login:      FeatureUnavailable,
+			Onboarding: FeatureUnavailable,
+			UserInfo:   FeatureAvailable,
+		}, agentStatus(t, o).Features)
+
+		o.onboarding = &onboarding{deferred: map[string]func(){}}
+		require.Equal(t, FeatureDeferred, agentStatus(t, o).Features.Onboarding)
+	})
+}
+
+func TestOperation_DeferredOnboarding(t *testing.T) {
+	t.Run("defers the onboarding of new users until the KMS is up again", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupAsyncOnboardingTest(t, state)
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		deps := watchDependencies(o)
+		deps.fail(DependencyKMS, errors.New("test"))
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		sub, ok := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.True(t, ok)
+
+		status, err := o.onboardingStatus(sub.(string))
+		require.NoError(t, err)
+		require.Equal(t, OnboardingPending, status.Status)
+		require.Contains(t, o.onboarding.deferred, sub)
+
+		// the EDV going down and up again does not resume the onboarding while the KMS is down
+		deps.fail(DependencyEDV, errors.New("test"))
+		deps.fail(DependencyEDV, nil)
+		require.Contains(t, o.onboarding.deferred, sub)
+
+		deps.fail(DependencyKMS, nil)
+		require.Empty(t, o.onboarding.deferred)
+		require.Equal(t, OnboardingCompleted, waitForOnboarding(t, o).Status)
+	})
+
+	t.Run("fails the deferred onboardings which cannot be scheduled", func(t *testing.T) {
+		o := setupAsyncOnboardingTest(t, uuid.New().String())
+
+		deps := watchDependencies(o)
+		deps.fail(DependencyEDV, errors.New("test"))
+
+		sub := uuid.New().String()
+		o.onboarding.deferred[sub] = func() {}
+		require.NoError(t, o.saveOnboardingStatus(sub, OnboardingPending, nil))
+
+		o.onboarding.queue.Stop()
+		deps.fail(DependencyEDV, nil)
+
+		status, err := o.onboardingStatus(sub)
+		require.NoError(t, err)
+		require.Equal(t, OnboardingFailed, status.Status)
+	})
+
+	t.Run("service unavailable if the users are onboarded synchronously", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupOnboardingTest(t, state)
+
+		deps := watchDependencies(o)
+		deps.fail(DependencyEDV, errors.New("test"))
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Contains(t, w.Body.String(), "onboarding unavailable: edv is down")
+	})
+}
+
+func TestOperation_UserInfoWhileProviderDown(t *testing.T) {
+	t.Run("serves the expired claims while the provider is down", func(t *testing.T) {
+		o, _, fetches := setupUserInfoTest(t, &UserInfoCacheConfig{TTL: time.Nanosecond})
+		deps := watchDependencies(o)
+
+		require.Equal(t, "1", userProfile(t, o, "/oidc/userinfo")["fetch"])
+		time.Sleep(time.Millisecond)
+
+		deps.fail(DependencyOIDCProvider, errors.New("test"))
+		require.Equal(t, "1", userProfile(t, o, "/oidc/userinfo?refresh=true")["fetch"])
+		require.Equal(t, 1, *fetches)
+
+		deps.fail(DependencyOIDCProvider, nil)
+		require.Equal(t, "2", userProfile(t, o, "/oidc/userinfo")["fetch"])
+	})
+
+	t.Run("calls the provider if the claims are not cached", func(t *testing.T) {
+		o, _, fetches := setupUserInfoTest(t, nil)
+		deps := watchDependencies(o)
+		deps.fail(DependencyOIDCProvider, errors.New("test"))
+
+		userProfile(t, o, "/oidc/userinfo")
+		require.Equal(t, 1, *fetches)
+	})
+}
+
+// mockDependencies are the dependencies of the agent, whose probes fail on demand.
+type mockDependencies struct {
+	mutex   sync.Mutex
+	errs    map[string]error
+	watcher *health.Watcher
+}
+
+// watchDependencies has the Operation watch dependencies which are up until they fail.
+func watchDependencies(o *Operation) *mockDependencies {
+	deps := &mockDependencies{errs: map[string]error{}}
+	probes := map[string]health.Probe{}
+
+	for _, name := range []string{DependencyOIDCProvider, DependencyKMS, DependencyEDV} {
+		name := name
+
+		probes[name] = func(context.Context) error {
+			deps.mutex.Lock()
+			defer deps.mutex.Unlock()
+
+			return deps.errs[name]
+		}
+	}
+
+	deps.watcher = health.NewWatcher(&health.Config{Probes: probes})
+	o.health = deps.watcher
+	o.health.OnChange(o.dependencyChanged)
+
+	return deps
+}
+
+// fail has the dependency fail with the error, or recover if nil, and checks the dependencies.
+func (d *mockDependencies) fail(name string, err error) {
+	d.mutex.Lock()
+	d.errs[name] = err
+	d.mutex.Unlock()
+
+	d.watcher.Check()
+}
+
+func agentStatus(t *testing.T, o *Operation) *AgentStatus {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.statusHandler(w, httptest.NewRequest(http.MethodGet, statusPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	status := &AgentStatus{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), status))
+
+	return status
+}
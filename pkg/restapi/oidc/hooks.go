@@ -0,0 +1,59 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+// OnboardingHook provisions the deployment-specific resources of new users, like mailboxes or billing records,
+// around their onboarding. A hook returning an error fails the onboarding, and a hook returning a
+// *OnboardingVetoError refuses it: the user is turned away with a 403.
+type OnboardingHook interface {
+	// PreOnboard is called before the keystores and vaults of the user are provisioned.
+	PreOnboard(ctx context.Context, usr *user.User) error
+	// PostOnboard is called once the keystores and vaults of the user are provisioned, before the user is
+	// persisted. The user is not persisted if it fails.
+	PostOnboard(ctx context.Context, usr *user.User, data *BootstrapData) error
+}
+
+// OnboardingVetoError is returned by an OnboardingHook refusing the onboarding of a user.
+type OnboardingVetoError struct {
+	// Reason is sent to the user.
+	Reason string
+}
+
+func (e *OnboardingVetoError) Error() string {
+	return "onboarding vetoed: " + e.Reason
+}
+
+// preOnboard calls the PreOnboard hooks in order, stopping at the first error.
+func (o *Operation) preOnboard(ctx context.Context, usr *user.User) error {
+	for _, hook := range o.onboardingHooks {
+		err := hook.PreOnboard(ctx, usr)
+		if err != nil {
+			return fmt.Errorf("pre-onboarding hook: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// postOnboard calls the PostOnboard hooks in order, stopping at the first error.
+func (o *Operation) postOnboard(ctx context.Context, usr *user.User, data *BootstrapData) error {
+	for _, hook := range o.onboardingHooks {
+		err := hook.PostOnboard(ctx, usr, data)
+		if err != nil {
+			return fmt.Errorf("post-onboarding hook: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+func TestOperation_OnboardingHooks(t *testing.T) {
+	t.Run("calls the hooks around the onboarding of new users", func(t *testing.T) {
+		hook := &mockOnboardingHook{}
+		o := setupHooksTest(t, hook)
+
+		sub := login(t, o, http.StatusFound)
+		require.Equal(t, []string{"pre " + sub, "post " + sub}, hook.calls)
+		require.NotNil(t, hook.data)
+
+		_, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+	})
+
+	t.Run("turns away the users vetoed before onboarding", func(t *testing.T) {
+		hook := &mockOnboardingHook{preErr: &OnboardingVetoError{Reason: "no seats left"}}
+		o := setupHooksTest(t, hook, &mockOnboardingHook{})
+		o.httpClient = &mockHTTPClient{DoFunc: func(*http.Request) (*http.Response, error) {
+			require.Fail(t, "the user must not be onboarded")
+
+			return nil, errors.New("unexpected")
+		}}
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), stateOf(o)))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "onboarding vetoed: no seats left")
+	})
+
+	t.Run("does not persist the users vetoed after onboarding", func(t *testing.T) {
+		hook := &mockOnboardingHook{postErr: &OnboardingVetoError{Reason: "billing declined"}}
+		o := setupHooksTest(t, hook)
+
+		sub := login(t, o, http.StatusForbidden)
+
+		_, err := o.store.users.Get(sub)
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if a hook fails", func(t *testing.T) {
+		o := setupHooksTest(t, &mockOnboardingHook{preErr: errors.New("mailbox server down")})
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), stateOf(o)))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "pre-onboarding hook: mailbox server down")
+	})
+}
+
+func setupHooksTest(t *testing.T, hooks ...OnboardingHook) *Operation {
+	t.Helper()
+
+	o := setupOnboardingTest(t, uuid.New().String())
+	o.onboardingHooks = hooks
+	o.httpClient = mockKMSHTTPClient()
+	o.keyEDVClient = &mockEDVClient{NoCapability: true}
+	o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+	return o
+}
+
+// login logs a new user in and returns the sub of the user.
+func login(t *testing.T, o *Operation, status int) string {
+	t.Helper()
+
+	var sub string
+
+	o.onboardingHooks = append([]OnboardingHook{&mockOnboardingHook{
+		pre: func(usr *user.User) { sub = usr.Sub },
+	}}, o.onboardingHooks...)
+
+	w := httptest.NewRecorder()
+	o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), stateOf(o)))
+	require.Equal(t, status, w.Code, w.Body.String())
+	require.NotEmpty(t, sub)
+
+	return sub
+}
+
+func stateOf(o *Operation) string {
+	state, _ := o.store.cookies.(*cookie.MockStore).Jar.Get(stateCookieName)
+
+	return state.(string)
+}
+
+type mockOnboardingHook struct {
+	pre     func(*user.User)
+	preErr  error
+	postErr error
+	calls   []string
+	data    *BootstrapData
+}
+
+func (m *mockOnboardingHook) PreOnboard(_ context.Context, usr *user.User) error {
+	if m.pre != nil {
+		m.pre(usr)
+	}
+
+	m.calls = append(m.calls, "pre "+usr.Sub)
+
+	return m.preErr
+}
+
+func (m *mockOnboardingHook) PostOnboard(_ context.Context, usr *user.User, data *BootstrapData) error {
+	m.calls = append(m.calls, "post "+usr.Sub)
+	m.data = data
+
+	return m.postErr
+}
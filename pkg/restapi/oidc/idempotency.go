@@ -0,0 +1,407 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-core/pkg/storage"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+const (
+	// IdempotencyKeyHeader is the header of the key of a request the clients may retry.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on the responses replayed for the retries of a request.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// DefaultIdempotencyTTL is how long the responses are replayed for by default.
+	DefaultIdempotencyTTL = 24 * time.Hour
+	idempotencyKeyPrefix  = "idempotency_"
+	maxIdempotencyKeySize = 255
+	// the responses larger than this are not recorded, so their retries are served again.
+	maxRecordedResponseSize = 1 << 20
+	// a request whose record is pending for longer is taken for abandoned by a crashed instance.
+	idempotencyPendingTTL = 5 * time.Minute
+	// a request is claimed so many times at most while the other instances save records of the same key.
+	maxIdempotencyClaims = 3
+)
+
+var errIdempotencyConflict = errors.New("idempotency record saved concurrently by another instance")
+
+// idempotencyRecord is the record of a request with an idempotency key, whose expiry is shared with the other
+// transient records.
+type idempotencyRecord struct {
+	// Request is the hash of the method, path and body of the request, for the key not to be reused for others.
+	Request string              `json:"request"`
+	Pending bool                `json:"pending,omitempty"`
+	Status  int                 `json:"status,omitempty"`
+	Header  map[string][]string `json:"header,omitempty"`
+	Body    []byte              `json:"body,omitempty"`
+	Expires time.Time           `json:"expires"`
+	// Revision of the record, advanced by each save of the key, for the instances to claim the request in turn.
+	Revision int `json:"revision"`
+	// Claim tells apart the pending records of the instances claiming the request at once.
+	Claim string `json:"claim,omitempty"`
+}
+
+type idempotency struct {
+	store   storage.Store
+	cookies *cookie.Jars
+	ttl     time.Duration
+	now     func() time.Time
+	// serializes the claims of this instance
+	mutex sync.Mutex
+}
+
+// Idempotency replays the responses of the mutating requests of the logged in users retried with the same
+// Idempotency-Key header, e.g. by mobile clients over flaky networks, instead of serving them again. The key
+// is scoped to the user and must be reused for the same method, path and body only. The retries of a request still
+// being served are answered with 409, and the responses of the requests that failed with a 5xx, 408 or 429 are not
+// recorded, for their retries to be served again. The responses are replayed for ttl, or DefaultIdempotencyTTL if
+// not positive, and are kept in the transient store, whose expired records the janitor deletes.
+func Idempotency(provider storage.Provider, cookies *cookie.Jars, ttl time.Duration) (common.Middleware, error) {
+	s, err := store.Open(provider, transientStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open idempotency store: %w", err)
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+
+	i := &idempotency{store: s, cookies: cookies, ttl: ttl, now: time.Now}
+
+	return i.middleware, nil
+}
+
+func (i *idempotency) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if len(key) > maxIdempotencyKeySize {
+			common.WriteErrorResponsef(w, logger, http.StatusBadRequest,
+				"invalid %s: must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeySize)
+
+			return
+		}
+
+		sub, ok := i.userSub(r)
+		if !ok {
+			// the keys of anonymous requests cannot be told apart from those of the other clients
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		i.serve(next, w, r, recordKey(sub, key))
+	})
+}
+
+func (i *idempotency) serve(next http.Handler, w http.ResponseWriter, r *http.Request, id string) {
+	body := &hashingBody{body: r.Body, hash: sha256.New()}
+	body.hash.Write([]byte(r.Method + " " + r.URL.Path + "\n")) // nolint:errcheck,gosec // hashes never fail
+
+	// the request is recorded pending before its body is read, so that its body is only read once
+	record, claimed, err := i.claim(id)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to check idempotency key: %s", err.Error())
+
+		return
+	}
+
+	if !claimed {
+		i.replay(w, r, body, record)
+
+		return
+	}
+
+	r.Body = body
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+
+	next.ServeHTTP(rec, r)
+
+	request, err := body.sum()
+	if err != nil || !rec.recordable() {
+		i.delete(id)
+
+		return
+	}
+
+	header := rec.Header().Clone()
+	// the sessions are not replayed
+	header.Del("Set-Cookie")
+
+	err = i.save(id, &idempotencyRecord{
+		Request:  request,
+		Status:   rec.status,
+		Header:   header,
+		Body:     rec.body.Bytes(),
+		Expires:  i.now().Add(i.ttl),
+		Revision: record.Revision + 1,
+	})
+	if err != nil {
+		logger.Errorf("failed to record the response of an idempotency key: %s", err.Error())
+		i.delete(id)
+	}
+}
+
+// replay the response recorded for the key, unless the request is still served, or the key was used for another
+// request.
+func (i *idempotency) replay(w http.ResponseWriter, r *http.Request, body *hashingBody, record *idempotencyRecord) {
+	if record.Pending {
+		common.WriteErrorResponsef(w, logger, http.StatusConflict,
+			"a request with the same %s is being served", IdempotencyKeyHeader)
+
+		return
+	}
+
+	request, err := body.sum()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to read request: %s", err.Error())
+
+		return
+	}
+
+	if request != record.Request {
+		common.WriteErrorResponsef(w, logger, http.StatusUnprocessableEntity,
+			"the %s was used for another request", IdempotencyKeyHeader)
+
+		return
+	}
+
+	for k, v := range record.Header {
+		w.Header()[k] = v
+	}
+
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(record.Status)
+
+	_, err = w.Write(record.Body)
+	if err != nil {
+		logger.Errorf("Unable to send response, %s", err.Error())
+	}
+
+	logger.Debugf("replayed the response of an idempotency key of %s %s", r.Method, r.URL.Path)
+}
+
+// userSub returns the sub of the logged in user of the request, from its session or its bearer token.
+func (i *idempotency) userSub(r *http.Request) (string, bool) {
+	jar, err := i.cookies.Open(r)
+	if err != nil {
+		return "", false
+	}
+
+	sub, found := jar.Get(userSubCookieName)
+	if !found {
+		return "", false
+	}
+
+	s, ok := sub.(string)
+
+	return s, ok && s != ""
+}
+
+// claim records the request of the key pending, and reports whether this instance serves it, unless the key has a
+// record that did not expire, which is returned. The store has no compare-and-set: the request is claimed unless
+// the record of the key was saved since it was read, and the record is read back, so that only the instance whose
+// record survives serves the request, but for a narrow window. The requests that could not be claimed are taken
+// for served by the other instances.
+func (i *idempotency) claim(id string) (*idempotencyRecord, bool, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for attempt := 0; attempt < maxIdempotencyClaims; attempt++ {
+		stored, err := i.get(id)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !i.now().After(stored.Expires) {
+			return stored, false, nil
+		}
+
+		record, err := i.tryClaim(id, stored.Revision)
+		if errors.Is(err, errIdempotencyConflict) {
+			continue
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		return record, true, nil
+	}
+
+	return &idempotencyRecord{Pending: true}, false, nil
+}
+
+// tryClaim saves the pending record of the request unless the record of the key was saved since it was read at
+// 'revision', and reads it back.
+func (i *idempotency) tryClaim(id string, revision int) (*idempotencyRecord, error) {
+	stored, err := i.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.Revision != revision {
+		return nil, errIdempotencyConflict
+	}
+
+	record := &idempotencyRecord{
+		Pending:  true,
+		Expires:  i.now().Add(idempotencyPendingTTL),
+		Revision: revision + 1,
+		Claim:    uuid.New().String(),
+	}
+
+	bits, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = i.store.Put(id, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	saved, err := i.store.Get(id)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return nil, err
+	}
+
+	if !bytes.Equal(saved, bits) {
+		return nil, errIdempotencyConflict
+	}
+
+	return record, nil
+}
+
+// get returns the record of the key, an expired record at revision 0 if there is none.
+func (i *idempotency) get(id string) (*idempotencyRecord, error) {
+	record := &idempotencyRecord{}
+
+	raw, err := i.store.Get(id)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return record, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(raw, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (i *idempotency) save(id string, record *idempotencyRecord) error {
+	return store.Save(i.store, id, record)
+}
+
+func (i *idempotency) delete(id string) {
+	err := i.store.Delete(id)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		logger.Errorf("failed to delete the record of an idempotency key: %s", err.Error())
+	}
+}
+
+// recordKey hashes the key of the user, for the keys of the clients to never be used as keys of the store.
+func recordKey(sub, key string) string {
+	h := sha256.Sum256([]byte(sub + "\n" + key))
+
+	return idempotencyKeyPrefix + hex.EncodeToString(h[:])
+}
+
+// hashingBody hashes the body of a request as the handler reads it, so that the body is never buffered.
+type hashingBody struct {
+	body io.ReadCloser
+	hash hash.Hash
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.hash.Write(p[:n]) // nolint:errcheck,gosec // hashes never fail
+
+	return n, err
+}
+
+func (b *hashingBody) Close() error {
+	return b.body.Close()
+}
+
+// sum returns the hash of the request, once the rest of the body the handler did not read is read.
+func (b *hashingBody) sum() (string, error) {
+	_, err := io.Copy(ioutil.Discard, b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b.hash.Sum(nil)), nil
+}
+
+// recorder records the response of a handler while writing it.
+type recorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.overflow && r.body.Len()+len(b) <= maxRecordedResponseSize {
+		r.body.Write(b)
+	} else {
+		r.overflow = true
+		r.body.Reset()
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush keeps the response writer a http.Flusher.
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// recordable tells whether the response is replayed for the retries of the request. The retries of the requests
+// that failed transiently are served again.
+func (r *recorder) recordable() bool {
+	return !r.overflow && r.status < http.StatusInternalServerError &&
+		r.status != http.StatusRequestTimeout && r.status != http.StatusTooManyRequests
+}
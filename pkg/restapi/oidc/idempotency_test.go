@@ -0,0 +1,266 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	mockstore "github.com/trustbloc/edge-core/pkg/storage/mockstore"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestIdempotency(t *testing.T) {
+	cookies := cookie.NewStore(bytes.Repeat([]byte("a"), 32), bytes.Repeat([]byte("b"), 32))
+
+	newMiddleware := func(t *testing.T) common.Middleware {
+		t.Helper()
+
+		middleware, err := Idempotency(memstore.NewProvider(), cookies, 0)
+		require.NoError(t, err)
+
+		return middleware
+	}
+
+	// handler counts the requests it serves, answering each with its count
+	handler := func(status int) (http.Handler, *int) {
+		served := 0
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			served++
+
+			w.Header().Set("Location", "/devices/"+string(body))
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "rotated"})
+			w.WriteHeader(status)
+			_, err = w.Write([]byte(strings.Repeat("x", served)))
+			require.NoError(t, err)
+		}), &served
+	}
+
+	send := func(h http.Handler, sub, method, path, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+
+		if sub != "" {
+			r = r.WithContext(cookie.WithJar(r.Context(),
+				cookie.NewRequestJar(map[interface{}]interface{}{userSubCookieName: sub})))
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w
+	}
+
+	t.Run("replays the response of a retried request", func(t *testing.T) {
+		middleware := newMiddleware(t)
+		next, served := handler(http.StatusCreated)
+		h := middleware(next)
+
+		w := send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Empty(t, w.Header().Get(IdempotentReplayedHeader))
+		require.NotEmpty(t, w.Header().Get("Set-Cookie"))
+
+		w = send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Equal(t, "true", w.Header().Get(IdempotentReplayedHeader))
+		require.Equal(t, "/devices/phone", w.Header().Get("Location"))
+		require.Empty(t, w.Header().Get("Set-Cookie"))
+		require.Equal(t, "x", w.Body.String())
+		require.Equal(t, 1, *served)
+	})
+
+	t.Run("scopes the keys to the users", func(t *testing.T) {
+		middleware := newMiddleware(t)
+		next, served := handler(http.StatusOK)
+		h := middleware(next)
+
+		send(h, "alice", http.MethodDelete, "/account", "key", "")
+		w := send(h, "bob", http.MethodDelete, "/account", "key", "")
+		require.Empty(t, w.Header().Get(IdempotentReplayedHeader))
+		require.Equal(t, 2, *served)
+	})
+
+	t.Run("serves the requests without key, user or side effects", func(t *testing.T) {
+		middleware := newMiddleware(t)
+		next, served := handler(http.StatusOK)
+		h := middleware(next)
+
+		for i := 0; i < 2; i++ {
+			send(h, "alice", http.MethodPost, "/devices", "", "phone")
+			send(h, "", http.MethodPost, "/devices", "key", "phone")
+			send(h, "alice", http.MethodGet, "/devices", "key", "")
+		}
+
+		require.Equal(t, 6, *served)
+	})
+
+	t.Run("error if the key is reused for another request", func(t *testing.T) {
+		middleware := newMiddleware(t)
+		next, served := handler(http.StatusCreated)
+		h := middleware(next)
+
+		send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+
+		w := send(h, "alice", http.MethodPost, "/devices", "key", "tablet")
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		w = send(h, "alice", http.MethodPut, "/devices", "key", "phone")
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		require.Equal(t, 1, *served)
+	})
+
+	t.Run("error if the request is still served", func(t *testing.T) {
+		middleware := newMiddleware(t)
+
+		var retry *httptest.ResponseRecorder
+
+		var h http.Handler
+
+		h = middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if retry == nil {
+				retry = send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+			}
+		}))
+
+		send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+		require.Equal(t, http.StatusConflict, retry.Code)
+	})
+
+	t.Run("only one of the instances sharing the store serves a request", func(t *testing.T) {
+		next, served := handler(http.StatusCreated)
+		shared := transientStore()
+		other := &idempotency{store: shared, cookies: cookies, ttl: time.Hour, now: time.Now}
+		racing := &concurrentStore{MockStore: shared}
+		i := &idempotency{store: racing, cookies: cookies, ttl: time.Hour, now: time.Now}
+
+		// the other instance serves the request between the read and the claim of this one
+		var first *httptest.ResponseRecorder
+
+		racing.afterGet = func() {
+			first = send(other.middleware(next), "alice", http.MethodPost, "/devices", "key", "phone")
+		}
+
+		w := send(i.middleware(next), "alice", http.MethodPost, "/devices", "key", "phone")
+		require.Equal(t, http.StatusCreated, first.Code)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Equal(t, "true", w.Header().Get(IdempotentReplayedHeader))
+		require.Equal(t, 1, *served)
+
+		// the other instance claims the request right after this one, whose claim does not survive
+		racing.afterPut = func(k string) {
+			require.NoError(t, shared.Put(k, []byte(`{"pending":true,"expires":"2100-01-01T00:00:00Z"}`)))
+		}
+
+		w = send(i.middleware(next), "alice", http.MethodPost, "/devices", "other", "tablet")
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.Equal(t, 1, *served)
+	})
+
+	t.Run("serves again the retries of the requests that failed transiently", func(t *testing.T) {
+		for _, status := range []int{http.StatusInternalServerError, http.StatusRequestTimeout,
+			http.StatusTooManyRequests} {
+			middleware := newMiddleware(t)
+			next, served := handler(status)
+			h := middleware(next)
+
+			send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+			send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+			require.Equal(t, 2, *served, status)
+		}
+	})
+
+	t.Run("serves again the retries of the requests with large responses", func(t *testing.T) {
+		middleware := newMiddleware(t)
+		served := 0
+		h := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served++
+
+			_, err := w.Write(make([]byte, maxRecordedResponseSize+1))
+			require.NoError(t, err)
+		}))
+
+		send(h, "alice", http.MethodPost, "/export", "key", "")
+		send(h, "alice", http.MethodPost, "/export", "key", "")
+		require.Equal(t, 2, served)
+	})
+
+	t.Run("replays the responses until they expire", func(t *testing.T) {
+		now := time.Now()
+		next, served := handler(http.StatusOK)
+
+		s, err := store.Open(memstore.NewProvider(), transientStoreName)
+		require.NoError(t, err)
+
+		i := &idempotency{store: s, cookies: cookies, ttl: time.Hour, now: func() time.Time { return now }}
+		h := i.middleware(next)
+
+		send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+		send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+		require.Equal(t, 1, *served)
+
+		now = now.Add(2 * time.Hour)
+
+		send(h, "alice", http.MethodPost, "/devices", "key", "phone")
+		require.Equal(t, 2, *served)
+	})
+
+	t.Run("error if the key is too long", func(t *testing.T) {
+		middleware := newMiddleware(t)
+		next, served := handler(http.StatusOK)
+
+		w := send(middleware(next), "alice", http.MethodPost, "/devices", strings.Repeat("k", 256), "phone")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Zero(t, *served)
+	})
+
+	t.Run("error if the requests cannot be recorded", func(t *testing.T) {
+		next, served := handler(http.StatusOK)
+
+		i := &idempotency{
+			store:   &mockstore.MockStore{Store: map[string][]byte{}, ErrPut: errors.New("test")},
+			cookies: cookies,
+			ttl:     time.Hour,
+			now:     time.Now,
+		}
+
+		w := send(i.middleware(next), "alice", http.MethodPost, "/devices", "key", "phone")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to check idempotency key")
+		require.Zero(t, *served)
+	})
+
+	t.Run("error if the store cannot be opened", func(t *testing.T) {
+		_, err := Idempotency(&mockstore.Provider{ErrCreateStore: errors.New("test")}, cookies, time.Hour)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open idempotency store")
+	})
+
+	t.Run("keeps the response writer a flusher", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		(&recorder{ResponseWriter: w}).Flush()
+		require.True(t, w.Flushed)
+	})
+}
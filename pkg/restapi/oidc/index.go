@@ -0,0 +1,450 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	webcrypto "github.com/hyperledger/aries-framework-go/pkg/crypto/webkms"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/webkms"
+	"github.com/hyperledger/aries-framework-go/pkg/storage/edv"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+	"github.com/trustbloc/edv/pkg/restapi/models"
+)
+
+const (
+	walletCredentialsPath = "/wallet/credentials"
+	credentialIssuer      = "issuer"
+	credentialType        = "type"
+	credentialSubject     = "subject"
+	// the collection of the credential attributes is told apart from the index of the aries EDV store by its HMAC.
+	credentialIndexHMACType = "Sha256HmacKey2019"
+	// the name and the value of the index the aries EDV store finds the documents by their key with.
+	storeAndKeyIndexName  = "StoreName-FriendlyKeyName"
+	storeAndKeyIndexValue = "%s-%s"
+)
+
+// the attributes of the credentials the user's vault is queried by.
+var credentialIndexes = []string{credentialIssuer, credentialType, credentialSubject} // nolint:gochecknoglobals
+
+// vaultIndex indexes the credentials of a user's vault with attributes only the user's keys can compute, so that the
+// vault can be queried without downloading and decrypting every document.
+type vaultIndex interface {
+	// Index replaces the indexed attributes of the credential.
+	Index(id string, attributes map[string][]string) error
+	// Query returns the credentials having every given attribute, by id.
+	Query(attributes map[string]string) (map[string][]byte, error)
+}
+
+// vaultIndexOpener opens the index of the user's EDV vault with the keys listed in the bootstrap data.
+type vaultIndexOpener func(data *BootstrapData, h *hubKMSHeader) (vaultIndex, error)
+
+type vaultDocuments interface {
+	ReadDocument(vaultID, docID string, opts ...sds.ReqOption) (*models.EncryptedDocument, error)
+	UpdateDocument(vaultID, docID string, document *models.EncryptedDocument, opts ...sds.ReqOption) error
+	QueryVault(vaultID, name, value string, opts ...sds.ReqOption) ([]string, error)
+}
+
+type pairParser interface {
+	ParsePair(encryptedDocumentBytes []byte) (string, []byte, error)
+}
+
+// WalletCredentials are the credentials of the user's vault matching a query, by id.
+type WalletCredentials struct {
+	Credentials map[string]json.RawMessage `json:"credentials"`
+}
+
+func (o *Operation) walletCredentialsHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(walletCredentialsPath, http.MethodGet, o.walletCredentialsHandler, &common.OperationSpec{
+			Summary: "Returns the credentials in the vault of the user logged in matching every given attribute." +
+				" The vault is queried by the encrypted indexes of the credentials.",
+			Params: []common.Param{
+				common.QueryParam(credentialIssuer, "Id of the issuer of the credentials."),
+				common.QueryParam(credentialType, "Type of the credentials."),
+				common.QueryParam(credentialSubject, "Id of the subject of the credentials."),
+				common.HeaderParam("If-None-Match", "ETag of the credentials the client has."),
+			},
+			Responses: map[int]interface{}{
+				http.StatusOK:          &WalletCredentials{},
+				http.StatusNotModified: nil,
+			},
+		}),
+	}
+}
+
+func (o *Operation) walletCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling wallet credentials request: %s", r.URL.String())
+
+	sub, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	query := make(map[string]string)
+
+	for _, name := range credentialIndexes {
+		if value := r.URL.Query().Get(name); value != "" {
+			query[name] = value
+		}
+	}
+
+	credentials, err := o.QueryCredentials(sub, query)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
+			"failed to query credentials: %s", err.Error())
+
+		return
+	}
+
+	result := &WalletCredentials{Credentials: make(map[string]json.RawMessage, len(credentials))}
+
+	for id, credential := range credentials {
+		result.Credentials[id] = credential
+	}
+
+	common.WriteConditionalResponse(w, r, logger, result)
+}
+
+// QueryCredentials returns the credentials in the user's vault having every given attribute, by id. The vault is
+// queried by the encrypted indexes written with the credentials; every credential is returned if no attribute is given.
+func (o *Operation) QueryCredentials(sub string, attributes map[string]string) (map[string][]byte, error) {
+	if len(attributes) == 0 {
+		return o.Credentials(sub)
+	}
+
+	index, err := o.openCredentialsIndex(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := index.Query(attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query vault: %w", err)
+	}
+
+	return credentials, nil
+}
+
+func (o *Operation) indexCredential(sub, id string, credential []byte) error {
+	attributes := credentialAttributes(credential)
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	index, err := o.openCredentialsIndex(sub)
+	if err != nil {
+		return err
+	}
+
+	err = index.Index(id, attributes)
+	if err != nil {
+		return fmt.Errorf("failed to index credential: %w", err)
+	}
+
+	return nil
+}
+
+func (o *Operation) openCredentialsIndex(sub string) (vaultIndex, error) {
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user tokens: %w", err)
+	}
+
+	return o.userVaultIndex(sub, tokns.Access)
+}
+
+// userVaultIndex returns the index of the user's vault from the cache, or opens it like userVault does the vault.
+func (o *Operation) userVaultIndex(sub, accessToken string) (vaultIndex, error) {
+	cached := o.cachedVault(sub, accessToken)
+	if cached.index != nil {
+		return cached.index, nil
+	}
+
+	data, h, err := o.vaultKeys(sub, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := o.openIndex(data, h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user vault index: %w", err)
+	}
+
+	o.vaults.Add(sub, &openedVault{accessToken: accessToken, provider: cached.provider, index: index})
+
+	return index, nil
+}
+
+func (o *Operation) openUserVaultIndex(data *BootstrapData, h *hubKMSHeader) (vaultIndex, error) {
+	kmsHeaders := opsKMSHeaders(h)
+	kmsClient := o.kmsClient(h)
+
+	keyManager := webkms.New(data.OpsKeyStoreURL, kmsClient, kmsHeaders)
+	crypto := webcrypto.New(data.OpsKeyStoreURL, kmsClient, kmsHeaders)
+
+	// the EDV REST API addresses documents as <server>/<vault id>/documents
+	client := sds.New(data.UserEDVVaultURL[:strings.LastIndex(data.UserEDVVaultURL, "/")],
+		sds.WithHTTPClient(o.kmsHTTPClient))
+	// the index only decrypts the documents it finds, which the user vault encrypts
+	parser := edv.NewEncryptedFormatter(nil, jose.NewJWEDecrypt(nil, crypto, keyManager))
+
+	return &edvIndex{
+		client:  client,
+		vaultID: getVaultID(data.UserEDVVaultURL),
+		hmac:    models.IDTypePair{ID: data.EDVHMACKIDURL, Type: credentialIndexHMACType},
+		mac:     edv.NewMACCrypto(data.EDVHMACKIDURL, crypto),
+		parser:  parser,
+		headers: sds.WithRequestHeader(func(req *http.Request) (*http.Header, error) {
+			req.Header.Set("Authorization", "Bearer "+h.accessToken)
+
+			return &req.Header, nil
+		}),
+	}, nil
+}
+
+// edvIndex adds a collection of indexed attributes to the documents the aries EDV store writes to the user's vault.
+// The names and the values of the attributes are MACs computed with the EDV HMAC key in the user's ops keystore.
+type edvIndex struct {
+	client  vaultDocuments
+	vaultID string
+	hmac    models.IDTypePair
+	mac     *edv.MACCrypto
+	parser  pairParser
+	headers sds.ReqOption
+}
+
+func (x *edvIndex) Index(id string, attributes map[string][]string) error {
+	docID, err := x.documentID(id)
+	if err != nil {
+		return err
+	}
+
+	doc, err := x.client.ReadDocument(x.vaultID, docID, x.headers)
+	if err != nil {
+		return err
+	}
+
+	collection := models.IndexedAttributeCollection{HMAC: x.hmac, IndexedAttributes: []models.IndexedAttribute{}}
+
+	names := make([]string, 0, len(attributes))
+
+	for name := range attributes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range attributes[name] {
+			attribute, errMAC := x.attribute(name, value)
+			if errMAC != nil {
+				return errMAC
+			}
+
+			collection.IndexedAttributes = append(collection.IndexedAttributes, *attribute)
+		}
+	}
+
+	collections := []models.IndexedAttributeCollection{collection}
+
+	for _, c := range doc.IndexedAttributeCollections {
+		if c.HMAC.Type != credentialIndexHMACType {
+			collections = append(collections, c)
+		}
+	}
+
+	doc.IndexedAttributeCollections = collections
+
+	return x.client.UpdateDocument(x.vaultID, docID, doc, x.headers)
+}
+
+func (x *edvIndex) Query(attributes map[string]string) (map[string][]byte, error) {
+	var matches map[string]bool
+
+	for name, value := range attributes {
+		attribute, err := x.attribute(name, value)
+		if err != nil {
+			return nil, err
+		}
+
+		locations, err := x.client.QueryVault(x.vaultID, attribute.Name, attribute.Value, x.headers)
+		if err != nil {
+			return nil, err
+		}
+
+		found := make(map[string]bool, len(locations))
+
+		for _, location := range locations {
+			if matches == nil || matches[location] {
+				found[location] = true
+			}
+		}
+
+		matches = found
+	}
+
+	credentials := make(map[string][]byte, len(matches))
+
+	for location := range matches {
+		doc, err := x.client.ReadDocument(x.vaultID, lastPathSegment(location), x.headers)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal document: %w", err)
+		}
+
+		id, credential, err := x.parser.ParsePair(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt document: %w", err)
+		}
+
+		credentials[id] = credential
+	}
+
+	return credentials, nil
+}
+
+// documentID finds the document of the credential by the index of the aries EDV store.
+func (x *edvIndex) documentID(id string) (string, error) {
+	name, err := x.computeMAC(storeAndKeyIndexName)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := x.computeMAC(fmt.Sprintf(storeAndKeyIndexValue, credentialsStoreName, id))
+	if err != nil {
+		return "", err
+	}
+
+	locations, err := x.client.QueryVault(x.vaultID, name, value, x.headers)
+	if err != nil {
+		return "", err
+	}
+
+	if len(locations) != 1 {
+		return "", fmt.Errorf("expected one document for credential %s, found %d", id, len(locations))
+	}
+
+	return lastPathSegment(locations[0]), nil
+}
+
+func (x *edvIndex) attribute(name, value string) (*models.IndexedAttribute, error) {
+	nameMAC, err := x.computeMAC("credential." + name)
+	if err != nil {
+		return nil, err
+	}
+
+	valueMAC, err := x.computeMAC(name + "-" + value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.IndexedAttribute{Name: nameMAC, Value: valueMAC}, nil
+}
+
+func (x *edvIndex) computeMAC(data string) (string, error) {
+	mac, err := x.mac.ComputeMAC(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute index mac: %w", err)
+	}
+
+	return base64.URLEncoding.EncodeToString([]byte(mac)), nil
+}
+
+// credentialAttributes returns the issuer, the types and the subjects of a JSON-LD or JWT credential.
+func credentialAttributes(raw []byte) map[string][]string {
+	var doc interface{}
+
+	if json.Unmarshal(raw, &doc) != nil {
+		return nil
+	}
+
+	attributes := make(map[string][]string)
+
+	if jwt, ok := doc.(string); ok {
+		claims, err := jwtClaims(jwt)
+		if err != nil {
+			return nil
+		}
+
+		attributes[credentialIssuer] = ids(claims["iss"])
+		attributes[credentialSubject] = ids(claims["sub"])
+		doc = claims["vc"]
+	}
+
+	vc, ok := doc.(map[string]interface{})
+	if ok {
+		if len(attributes[credentialIssuer]) == 0 {
+			attributes[credentialIssuer] = ids(vc["issuer"])
+		}
+
+		if len(attributes[credentialSubject]) == 0 {
+			attributes[credentialSubject] = ids(vc["credentialSubject"])
+		}
+
+		attributes[credentialType] = ids(vc["type"])
+	}
+
+	for name, values := range attributes {
+		if len(values) == 0 {
+			delete(attributes, name)
+		}
+	}
+
+	return attributes
+}
+
+// ids returns the strings, or the ids of the objects, of a JSON-LD value.
+func ids(v interface{}) []string {
+	switch value := v.(type) {
+	case string:
+		return []string{value}
+	case map[string]interface{}:
+		return ids(value["id"])
+	case []interface{}:
+		var all []string
+
+		for _, item := range value {
+			all = append(all, ids(item)...)
+		}
+
+		return all
+	default:
+		return nil
+	}
+}
+
+func jwtClaims(jwt string) (map[string]interface{}, error) {
+	parts := strings.Split(jwt, ".")
+
+	const jwtParts = 3
+
+	if len(parts) != jwtParts {
+		return nil, errors.New("not a compact jwt")
+	}
+
+	bits, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+
+	return claims, json.Unmarshal(bits, &claims)
+}
@@ -0,0 +1,452 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/hyperledger/aries-framework-go/pkg/storage/edv"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edv/pkg/restapi/models"
+)
+
+const (
+	ldCredential = `{
+		"id": "http://example.edu/credentials/1872",
+		"type": ["VerifiableCredential", "UniversityDegreeCredential"],
+		"issuer": {"id": "did:example:issuer"},
+		"credentialSubject": {"id": "did:example:subject"}
+	}`
+	// {"iss": "did:example:issuer", "sub": "did:example:subject", "vc": {"type": ["VerifiableCredential"]}}
+	jwtCredential = `"eyJhbGciOiJub25lIn0.eyJpc3MiOiJkaWQ6ZXhhbXBsZTppc3N1ZXIiLCJzdWIiOiJkaWQ6ZXhhbXBsZTpzdWJqZWN0Ii` +
+		`widmMiOnsidHlwZSI6WyJWZXJpZmlhYmxlQ3JlZGVudGlhbCJdfX0.c2ln"`
+)
+
+func TestOperation_WalletCredentials(t *testing.T) {
+	t.Run("indexes the credentials and queries the vault by their attributes", func(t *testing.T) {
+		o, sub, vault := setupBackupTest(t)
+		index := &mockVaultIndex{vault: vault}
+
+		opened := 0
+
+		o.openIndex = func(data *BootstrapData, h *hubKMSHeader) (vaultIndex, error) {
+			require.Equal(t, "https://edv.example.com/encrypted-data-vaults/123", data.UserEDVVaultURL)
+			require.Equal(t, "share", h.secretShare)
+
+			opened++
+
+			return index, nil
+		}
+
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte(ldCredential)))
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:2", []byte(jwtCredential)))
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:3", []byte(`{}`)))
+		require.Equal(t, 1, opened)
+
+		require.Equal(t, map[string][]string{
+			credentialIssuer:  {"did:example:issuer"},
+			credentialType:    {"VerifiableCredential", "UniversityDegreeCredential"},
+			credentialSubject: {"did:example:subject"},
+		}, index.attributes["urn:uuid:1"])
+		require.NotContains(t, index.attributes, "urn:uuid:3")
+
+		result := queryWalletCredentials(t, o, "?type=UniversityDegreeCredential&issuer=did:example:issuer")
+		require.Len(t, result.Credentials, 1)
+		require.JSONEq(t, ldCredential, string(result.Credentials["urn:uuid:1"]))
+
+		result = queryWalletCredentials(t, o, "?type=VerifiableCredential")
+		require.Len(t, result.Credentials, 2)
+
+		result = queryWalletCredentials(t, o, "?subject=did:example:other")
+		require.Empty(t, result.Credentials)
+	})
+
+	t.Run("returns every credential without a query", func(t *testing.T) {
+		o, sub, _ := setupBackupTest(t)
+		o.openIndex = func(*BootstrapData, *hubKMSHeader) (vaultIndex, error) {
+			return nil, errors.New("not opened without a query")
+		}
+
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte(`{}`)))
+
+		result := queryWalletCredentials(t, o, "")
+		require.Len(t, result.Credentials, 1)
+	})
+
+	t.Run("error if the credential cannot be indexed", func(t *testing.T) {
+		o, sub, _ := setupBackupTest(t)
+		o.openIndex = func(*BootstrapData, *hubKMSHeader) (vaultIndex, error) {
+			return &mockVaultIndex{err: errors.New("test")}, nil
+		}
+
+		err := o.SaveCredential(sub, "urn:uuid:1", []byte(ldCredential))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to index credential")
+	})
+
+	t.Run("internal server error if the vault cannot be queried", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+		o.openIndex = func(*BootstrapData, *hubKMSHeader) (vaultIndex, error) {
+			return nil, errors.New("test")
+		}
+
+		w := httptest.NewRecorder()
+		o.walletCredentialsHandler(w, httptest.NewRequest(http.MethodGet, walletCredentialsPath+"?type=T", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to open user vault index")
+
+		o.openIndex = func(*BootstrapData, *hubKMSHeader) (vaultIndex, error) {
+			return &mockVaultIndex{err: errors.New("test")}, nil
+		}
+
+		w = httptest.NewRecorder()
+		o.walletCredentialsHandler(w, httptest.NewRequest(http.MethodGet, walletCredentialsPath+"?type=T", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to query vault")
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+
+		w := httptest.NewRecorder()
+		o.walletCredentialsHandler(w, httptest.NewRequest(http.MethodGet, walletCredentialsPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestEDVIndex(t *testing.T) {
+	t.Run("adds the attributes to the document of the credential and queries them", func(t *testing.T) {
+		server := newMockIndexEDV(t)
+		defer server.Close()
+
+		index := server.index(t)
+		server.save(t, "doc1", "urn:uuid:1", `{"id": "first"}`)
+		server.save(t, "doc2", "urn:uuid:2", `{"id": "second"}`)
+
+		require.NoError(t, index.Index("urn:uuid:1", map[string][]string{
+			credentialIssuer: {"did:example:issuer"},
+			credentialType:   {"VerifiableCredential", "UniversityDegreeCredential"},
+		}))
+		require.NoError(t, index.Index("urn:uuid:2", map[string][]string{
+			credentialIssuer: {"did:example:issuer"},
+			credentialType:   {"VerifiableCredential"},
+		}))
+
+		// indexing again replaces the attributes, and leaves the index of the aries store alone
+		require.NoError(t, index.Index("urn:uuid:1", map[string][]string{
+			credentialIssuer: {"did:example:issuer"},
+			credentialType:   {"VerifiableCredential", "UniversityDegreeCredential"},
+		}))
+
+		doc := server.document(t, "doc1")
+		require.Len(t, doc.IndexedAttributeCollections, 2)
+		require.Equal(t, credentialIndexHMACType, doc.IndexedAttributeCollections[0].HMAC.Type)
+		require.Len(t, doc.IndexedAttributeCollections[0].IndexedAttributes, 3)
+		require.NotContains(t, string(marshal(t, doc)), "did:example:issuer")
+
+		credentials, err := index.Query(map[string]string{
+			credentialIssuer: "did:example:issuer",
+			credentialType:   "UniversityDegreeCredential",
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string][]byte{"urn:uuid:1": []byte(`{"id": "first"}`)}, credentials)
+
+		credentials, err = index.Query(map[string]string{credentialType: "VerifiableCredential"})
+		require.NoError(t, err)
+		require.Len(t, credentials, 2)
+
+		credentials, err = index.Query(map[string]string{credentialIssuer: "did:example:other"})
+		require.NoError(t, err)
+		require.Empty(t, credentials)
+	})
+
+	t.Run("error if the credential has no document", func(t *testing.T) {
+		server := newMockIndexEDV(t)
+		defer server.Close()
+
+		err := server.index(t).Index("urn:uuid:1", map[string][]string{credentialType: {"VerifiableCredential"}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "expected one document for credential urn:uuid:1, found 0")
+	})
+
+	t.Run("error if the macs cannot be computed", func(t *testing.T) {
+		server := newMockIndexEDV(t)
+		defer server.Close()
+
+		index := server.index(t)
+		index.mac = edv.NewMACCrypto(nil, &mockMACDigester{err: errors.New("test")})
+
+		err := index.Index("urn:uuid:1", map[string][]string{credentialType: {"VerifiableCredential"}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to compute index mac")
+
+		_, err = index.Query(map[string]string{credentialType: "VerifiableCredential"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to compute index mac")
+	})
+
+	t.Run("error if the vault cannot be queried", func(t *testing.T) {
+		server := newMockIndexEDV(t)
+		server.Close()
+
+		_, err := server.index(t).Query(map[string]string{credentialType: "VerifiableCredential"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to query vault")
+	})
+}
+
+func TestOperation_OpenUserVaultIndex(t *testing.T) {
+	o, err := New(config(t))
+	require.NoError(t, err)
+
+	index, err := o.openUserVaultIndex(vaultBootstrapData("https://kms.example.com"), &hubKMSHeader{})
+	require.NoError(t, err)
+
+	edvIndex, ok := index.(*edvIndex)
+	require.True(t, ok)
+	require.Equal(t, "123", edvIndex.vaultID)
+	require.Equal(t, "https://kms.example.com/kms/keystores/456/keys/hmac", edvIndex.hmac.ID)
+}
+
+func TestCredentialAttributes(t *testing.T) {
+	require.Equal(t, map[string][]string{
+		credentialIssuer:  {"did:example:issuer"},
+		credentialType:    {"VerifiableCredential", "UniversityDegreeCredential"},
+		credentialSubject: {"did:example:subject"},
+	}, credentialAttributes([]byte(ldCredential)))
+
+	require.Equal(t, map[string][]string{
+		credentialIssuer:  {"did:example:issuer"},
+		credentialType:    {"VerifiableCredential"},
+		credentialSubject: {"did:example:subject"},
+	}, credentialAttributes([]byte(jwtCredential)))
+
+	require.Equal(t, map[string][]string{
+		credentialIssuer:  {"did:example:issuer"},
+		credentialType:    {"VerifiableCredential"},
+		credentialSubject: {"did:example:a", "did:example:b"},
+	}, credentialAttributes([]byte(`{"issuer": "did:example:issuer", "type": "VerifiableCredential",
+		"credentialSubject": [{"id": "did:example:a"}, {"id": "did:example:b"}]}`)))
+
+	require.Empty(t, credentialAttributes([]byte(`{}`)))
+	require.Empty(t, credentialAttributes([]byte(`"not a jwt"`)))
+	require.Empty(t, credentialAttributes([]byte(`"a.!.c"`)))
+	require.Empty(t, credentialAttributes([]byte(`invalid`)))
+}
+
+func queryWalletCredentials(t *testing.T, o *Operation, query string) *WalletCredentials {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.walletCredentialsHandler(w, httptest.NewRequest(http.MethodGet, walletCredentialsPath+query, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	result := &WalletCredentials{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+
+	return result
+}
+
+// mockVaultIndex keeps the attributes of the credentials in memory, and reads the credentials matching a query from
+// the vault.
+type mockVaultIndex struct {
+	vault      ariesstorage.Provider
+	attributes map[string]map[string][]string
+	err        error
+}
+
+func (m *mockVaultIndex) Index(id string, attributes map[string][]string) error {
+	if m.attributes == nil {
+		m.attributes = map[string]map[string][]string{}
+	}
+
+	m.attributes[id] = attributes
+
+	return m.err
+}
+
+func (m *mockVaultIndex) Query(query map[string]string) (map[string][]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	credentials, err := m.vault.OpenStore(credentialsStoreName)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := map[string][]byte{}
+
+	for id, attributes := range m.attributes {
+		if hasAttributes(attributes, query) {
+			matches[id], err = credentials.Get(id)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+func hasAttributes(attributes map[string][]string, query map[string]string) bool {
+	for name, value := range query {
+		found := false
+
+		for _, v := range attributes[name] {
+			found = found || v == value
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mockIndexEDV is an in-memory EDV server holding the documents of the credentials of a vault, which are indexed by
+// the aries EDV store and only hold their key and value.
+type mockIndexEDV struct {
+	*httptest.Server
+	mutex sync.Mutex
+	docs  map[string]*models.EncryptedDocument
+}
+
+func newMockIndexEDV(t *testing.T) *mockIndexEDV {
+	t.Helper()
+
+	m := &mockIndexEDV{docs: map[string]*models.EncryptedDocument{}}
+
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+		switch {
+		case len(parts) == 2 && parts[1] == "query":
+			query := &models.Query{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(query))
+
+			locations := []string{}
+
+			for id, doc := range m.docs {
+				for _, collection := range doc.IndexedAttributeCollections {
+					for _, attribute := range collection.IndexedAttributes {
+						if attribute.Name == query.Name && attribute.Value == query.Value {
+							locations = append(locations, m.URL+"/vault/documents/"+id)
+						}
+					}
+				}
+			}
+
+			_, err := w.Write(marshal(t, locations))
+			require.NoError(t, err)
+		case len(parts) == 3 && m.docs[parts[2]] == nil:
+			w.WriteHeader(http.StatusNotFound)
+		case len(parts) == 3 && r.Method == http.MethodPost:
+			doc := &models.EncryptedDocument{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(doc))
+
+			m.docs[parts[2]] = doc
+		case len(parts) == 3 && r.Method == http.MethodGet:
+			_, err := w.Write(marshal(t, m.docs[parts[2]]))
+			require.NoError(t, err)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+
+	return m
+}
+
+// save adds the document of the credential, with the index of the aries EDV store.
+func (m *mockIndexEDV) save(t *testing.T, docID, id, credential string) {
+	t.Helper()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.docs[docID] = &models.EncryptedDocument{
+		ID: docID,
+		IndexedAttributeCollections: []models.IndexedAttributeCollection{{
+			IndexedAttributes: []models.IndexedAttribute{{
+				Name:  mockMAC(storeAndKeyIndexName),
+				Value: mockMAC(credentialsStoreName + "-" + id),
+			}},
+		}},
+		JWE: marshal(t, &mockPair{Key: id, Value: credential}),
+	}
+}
+
+func (m *mockIndexEDV) document(t *testing.T, docID string) *models.EncryptedDocument {
+	t.Helper()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.docs[docID]
+}
+
+func (m *mockIndexEDV) index(t *testing.T) *edvIndex {
+	t.Helper()
+
+	return &edvIndex{
+		client:  sds.New(m.URL, sds.WithHTTPClient(m.Client())),
+		vaultID: "vault",
+		hmac:    models.IDTypePair{ID: "hmac", Type: credentialIndexHMACType},
+		mac:     edv.NewMACCrypto(nil, &mockMACDigester{}),
+		parser:  &mockPairParser{t: t},
+		headers: sds.WithRequestHeader(func(req *http.Request) (*http.Header, error) {
+			return &req.Header, nil
+		}),
+	}
+}
+
+type mockMACDigester struct {
+	err error
+}
+
+func (m *mockMACDigester) ComputeMAC(data []byte, _ interface{}) ([]byte, error) {
+	return []byte("mac(" + string(data) + ")"), m.err
+}
+
+func mockMAC(data string) string {
+	return base64.URLEncoding.EncodeToString([]byte("mac(" + data + ")"))
+}
+
+// mockPair is the content of the documents of mockIndexEDV, in place of their JWE.
+type mockPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type mockPairParser struct {
+	t *testing.T
+}
+
+func (m *mockPairParser) ParsePair(raw []byte) (string, []byte, error) {
+	doc := &models.EncryptedDocument{}
+	require.NoError(m.t, json.Unmarshal(raw, doc))
+
+	pair := &mockPair{}
+	require.NoError(m.t, json.Unmarshal(doc.JWE, pair))
+
+	return pair.Key, []byte(pair.Value), nil
+}
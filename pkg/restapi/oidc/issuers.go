@@ -0,0 +1,59 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+)
+
+const (
+	issuerKeysPath        = "/issuers/keys"
+	issuerKeysRefreshPath = issuerKeysPath + "/refresh"
+)
+
+// IssuerKeys are the state of the signing keys of the trusted issuers, by issuer.
+type IssuerKeys map[string]*oidc.KeySetStats
+
+func (o *Operation) issuerKeysHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(issuerKeysPath, http.MethodGet, o.issuerKeysHandler, &common.OperationSpec{
+			Summary: "Returns the signing keys of the trusted issuers of the id_tokens, with the totals of their" +
+				" refreshes and of the id_tokens verified with stale keys.",
+			Responses: map[int]interface{}{http.StatusOK: IssuerKeys{}},
+		}),
+		common.NewHTTPHandler(issuerKeysRefreshPath, http.MethodPost, o.issuerKeysRefreshHandler,
+			&common.OperationSpec{
+				Summary:   "Refreshes the signing keys of the trusted issuers now, e.g. after a rotation.",
+				Responses: map[int]interface{}{http.StatusOK: IssuerKeys{}},
+			}),
+	}
+}
+
+func (o *Operation) issuerKeysHandler(w http.ResponseWriter, _ *http.Request) {
+	common.WriteResponse(w, logger, o.issuerKeysStats())
+}
+
+func (o *Operation) issuerKeysRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	for _, keys := range o.issuerKeys {
+		keys.Refresh(r.Context())
+	}
+
+	common.WriteResponse(w, logger, o.issuerKeysStats())
+}
+
+func (o *Operation) issuerKeysStats() IssuerKeys {
+	stats := make(IssuerKeys, len(o.issuerKeys))
+
+	for issuer, keys := range o.issuerKeys {
+		stats[issuer] = keys.Stats()
+	}
+
+	return stats
+}
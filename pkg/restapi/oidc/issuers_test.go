@@ -0,0 +1,61 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+)
+
+func TestOperation_IssuerKeysHandlers(t *testing.T) {
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(jwks.Close)
+
+	keys, err := oidc2.NewKeySet(&oidc2.KeySetConfig{URLs: []string{jwks.URL}})
+	require.NoError(t, err)
+
+	c := config(t)
+	c.IssuerKeys = map[string]*oidc2.KeySet{"https://idp.example.com": keys}
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	handlers := o.GetAdminRESTHandlers()
+	require.Len(t, handlers, 9)
+	require.Equal(t, issuerKeysPath, handlers[6].Path())
+	require.Equal(t, issuerKeysRefreshPath, handlers[7].Path())
+
+	t.Run("returns the keys of the issuers", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handlers[6].Handle()(w, httptest.NewRequest(http.MethodGet, issuerKeysPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stats := IssuerKeys{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		require.Zero(t, stats["https://idp.example.com"].Refreshes)
+		require.Equal(t, jwks.URL, stats["https://idp.example.com"].Sources[0].URL)
+	})
+
+	t.Run("refreshes the keys of the issuers", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handlers[7].Handle()(w, httptest.NewRequest(http.MethodPost, issuerKeysRefreshPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stats := IssuerKeys{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		require.Equal(t, uint64(1), stats["https://idp.example.com"].RefreshFailures)
+		require.Contains(t, stats["https://idp.example.com"].Sources[0].LastError, "jwks document returned 503")
+	})
+}
@@ -0,0 +1,260 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	janitorStatsPath = "/janitor/stats"
+	// the transient records of pending logins and exports expire after a day by default.
+	defaultTransientTTL     = 24 * time.Hour
+	defaultJanitorBatchSize = 100
+	// the tokens are saved ahead of the user by the logins, which may take long to onboard the user on any instance,
+	// so that the tokens without user are only orphaned once older than this.
+	orphanedTokensGrace = time.Hour
+)
+
+// JanitorConfig enables the periodic cleanup of the expired transient records, such as abandoned logins, cached
+// user info and undownloaded exports, and of the tokens of users that no longer exist.
+type JanitorConfig struct {
+	// Interval between the cleanups.
+	Interval time.Duration
+	// BatchSize is the maximum number of records of each kind deleted by a cleanup. The rest is deleted by the
+	// next cleanups. Defaults to 100.
+	BatchSize int
+	// TransientTTL is how long pending logins and exports are kept. Defaults to 24h.
+	TransientTTL time.Duration
+}
+
+// JanitorStats are the totals of the cleanups since the agent started.
+type JanitorStats struct {
+	Runs           uint64     `json:"runs"`
+	ExpiredRecords uint64     `json:"expiredRecords"`
+	OrphanedTokens uint64     `json:"orphanedTokens"`
+	LastRun        *time.Time `json:"lastRun,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+}
+
+// expiring is the expiry shared by the transient records.
+type expiring struct {
+	Expires time.Time `json:"expires"`
+}
+
+type janitor struct {
+	transient storage.Store
+	tokens    tokens.Store
+	users     user.Store
+	// onboarding reports whether the user is being onboarded, whose tokens are saved ahead of the user
+	onboarding func(sub string) bool
+	interval   time.Duration
+	batchSize  int
+	now        func() time.Time
+	// guards the stats read by the stats endpoint
+	mutex sync.Mutex
+	stats JanitorStats
+	stop  chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+func newJanitor(config *JanitorConfig, s *stores, onboarding func(sub string) bool, now func() time.Time) *janitor {
+	j := &janitor{
+		transient:  s.transient,
+		tokens:     s.tokens,
+		users:      s.users,
+		onboarding: onboarding,
+		interval:   config.Interval,
+		batchSize:  config.BatchSize,
+		now:        now,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	if j.batchSize <= 0 {
+		j.batchSize = defaultJanitorBatchSize
+	}
+
+	return j
+}
+
+// Start cleaning up in the background.
+func (j *janitor) Start() {
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				j.run()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the janitor, waiting for the cleanup under way.
+func (j *janitor) Stop() {
+	j.once.Do(func() {
+		close(j.stop)
+		<-j.done
+	})
+}
+
+// run cleans up once and records the outcome in the stats.
+func (j *janitor) run() {
+	expired, orphaned, err := j.cleanup(j.now())
+	j.record(expired, orphaned, err)
+
+	if err != nil {
+		logger.Errorf("janitor: %s", err.Error())
+
+		return
+	}
+
+	if expired > 0 || orphaned > 0 {
+		logger.Infof("janitor: deleted %d expired transient records and %d orphaned tokens", expired, orphaned)
+	}
+}
+
+func (j *janitor) cleanup(now time.Time) (int, int, error) {
+	expired, err := j.deleteExpiredRecords(now)
+	if err != nil {
+		return expired, 0, err
+	}
+
+	orphaned, err := j.deleteOrphanedTokens()
+
+	return expired, orphaned, err
+}
+
+func (j *janitor) record(expired, orphaned int, err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := j.now().UTC()
+
+	j.stats.Runs++
+	j.stats.ExpiredRecords += uint64(expired)
+	j.stats.OrphanedTokens += uint64(orphaned)
+	j.stats.LastRun = &now
+	j.stats.LastError = ""
+
+	if err != nil {
+		j.stats.LastError = err.Error()
+	}
+}
+
+// Stats returns the totals of the cleanups.
+func (j *janitor) Stats() JanitorStats {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.stats
+}
+
+// deleteExpiredRecords deletes the transient records that expired before 'now'.
+func (j *janitor) deleteExpiredRecords(now time.Time) (int, error) {
+	all, err := j.transient.GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list transient records: %w", err)
+	}
+
+	deleted := 0
+
+	for k, bits := range all {
+		if deleted == j.batchSize {
+			break
+		}
+
+		record := &expiring{}
+
+		// records without an expiry are left to the handlers that created them
+		if json.Unmarshal(bits, record) != nil || record.Expires.IsZero() || record.Expires.After(now) {
+			continue
+		}
+
+		err = j.transient.Delete(k)
+		if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+			return deleted, fmt.Errorf("failed to delete transient record: %w", err)
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// deleteOrphanedTokens deletes the tokens of the users that no longer exist, sparing those of the logins under way.
+func (j *janitor) deleteOrphanedTokens() (int, error) {
+	list, err := j.tokens.List()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+
+	for _, t := range list {
+		if deleted == j.batchSize {
+			break
+		}
+
+		if t.IssuedAt != nil && j.now().Sub(*t.IssuedAt) < orphanedTokensGrace {
+			continue
+		}
+
+		_, err = j.users.Get(t.UserSub)
+		if err == nil || (errors.Is(err, storage.ErrValueNotFound) && j.onboarding(t.UserSub)) {
+			continue
+		}
+
+		if !errors.Is(err, storage.ErrValueNotFound) {
+			return deleted, err
+		}
+
+		err = j.tokens.Delete(t.UserSub)
+		if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+			return deleted, fmt.Errorf("failed to delete user tokens: %w", err)
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (o *Operation) janitorStatsHandler(w http.ResponseWriter, _ *http.Request) {
+	stats := o.janitor.Stats()
+	common.WriteResponse(w, logger, &stats)
+}
+
+// onboardingUnderWay reports whether the user is being onboarded asynchronously.
+func (o *Operation) onboardingUnderWay(sub string) bool {
+	status, err := o.onboardingStatus(sub)
+
+	return err == nil && (status.Status == OnboardingPending || status.Status == OnboardingRunning)
+}
+
+// transientExpiry is the expiry of the transient records of pending logins and exports created now.
+func (o *Operation) transientExpiry() time.Time {
+	return o.now().Add(o.transientTTL)
+}
@@ -0,0 +1,232 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+	"golang.org/x/oauth2"
+)
+
+func TestOperation_Janitor(t *testing.T) {
+	t.Run("deletes the expired transient records", func(t *testing.T) {
+		o := setupJanitorTest(t, &JanitorConfig{Interval: time.Hour})
+
+		past := time.Now().Add(-time.Minute)
+		require.NoError(t, store.Save(o.store.transient, consentKeyPrefix+"expired", &pendingLogin{Expires: past}))
+		require.NoError(t, store.Save(o.store.transient, userInfoKeyPrefix+"expired", &cachedUserInfo{Expires: past}))
+		require.NoError(t, store.Save(o.store.transient, exportKeyPrefix+"pending", &exportRecord{
+			Expires: o.transientExpiry(),
+		}))
+		require.NoError(t, o.store.transient.Put("unknown", []byte(`{}`)))
+
+		o.janitor.run()
+
+		for _, k := range []string{consentKeyPrefix + "expired", userInfoKeyPrefix + "expired"} {
+			_, err := o.store.transient.Get(k)
+			require.True(t, errors.Is(err, storage.ErrValueNotFound))
+		}
+
+		for _, k := range []string{exportKeyPrefix + "pending", "unknown"} {
+			_, err := o.store.transient.Get(k)
+			require.NoError(t, err)
+		}
+
+		stats := o.janitor.Stats()
+		require.Equal(t, uint64(1), stats.Runs)
+		require.Equal(t, uint64(2), stats.ExpiredRecords)
+		require.NotNil(t, stats.LastRun)
+		require.Empty(t, stats.LastError)
+	})
+
+	t.Run("deletes the tokens of the users that no longer exist", func(t *testing.T) {
+		o := setupJanitorTest(t, &JanitorConfig{Interval: time.Hour})
+
+		var err error
+
+		o.onboarding, err = newOnboarding(&OnboardingConfig{}, memstore.NewProvider())
+		require.NoError(t, err)
+
+		require.NoError(t, o.store.users.Save(&user.User{Sub: "user"}))
+
+		for _, sub := range []string{"user", "deleted", "onboarding"} {
+			require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub}))
+		}
+
+		require.NoError(t, o.saveOnboardingStatus("onboarding", OnboardingRunning, nil))
+
+		o.janitor.run()
+
+		_, err = o.store.tokens.Get("deleted")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		for _, sub := range []string{"user", "onboarding"} {
+			_, err = o.store.tokens.Get(sub)
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, uint64(1), o.janitor.Stats().OrphanedTokens)
+	})
+
+	t.Run("spares the tokens of the logins under way", func(t *testing.T) {
+		o := setupJanitorTest(t, &JanitorConfig{Interval: time.Hour})
+		usr := &user.User{Sub: "new"}
+
+		login, err := o.beginLogin(usr, &oauth2.Token{AccessToken: "access"}, false)
+		require.NoError(t, err)
+
+		o.janitor.run()
+
+		require.NoError(t, o.store.users.Save(usr))
+		o.commitLogin(login)
+
+		saved, err := o.store.tokens.Get(usr.Sub)
+		require.NoError(t, err)
+		require.Equal(t, "access", saved.Access)
+		require.Zero(t, o.janitor.Stats().OrphanedTokens)
+
+		// the tokens of a login abandoned before its user was saved are orphaned once past the grace period
+		_, err = o.beginLogin(&user.User{Sub: "abandoned"}, &oauth2.Token{AccessToken: "access"}, false)
+		require.NoError(t, err)
+
+		o.janitor.now = func() time.Time { return time.Now().Add(orphanedTokensGrace + time.Minute) }
+		o.janitor.run()
+
+		_, err = o.store.tokens.Get("abandoned")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		_, err = o.store.tokens.Get(usr.Sub)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), o.janitor.Stats().OrphanedTokens)
+	})
+
+	t.Run("deletes a batch of records per run", func(t *testing.T) {
+		o := setupJanitorTest(t, &JanitorConfig{Interval: time.Hour, BatchSize: 2})
+
+		for _, sub := range []string{"1", "2", "3"} {
+			require.NoError(t, store.Save(o.store.transient, userInfoKeyPrefix+sub, &cachedUserInfo{
+				Expires: time.Now().Add(-time.Minute),
+			}))
+			require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub}))
+		}
+
+		o.janitor.run()
+		require.Equal(t, JanitorStats{Runs: 1, ExpiredRecords: 2, OrphanedTokens: 2}, withoutLastRun(o.janitor.Stats()))
+
+		o.janitor.run()
+		require.Equal(t, JanitorStats{Runs: 2, ExpiredRecords: 3, OrphanedTokens: 3}, withoutLastRun(o.janitor.Stats()))
+	})
+
+	t.Run("cleans up in the background", func(t *testing.T) {
+		o := setupJanitorTest(t, &JanitorConfig{Interval: time.Millisecond})
+
+		require.Eventually(t, func() bool {
+			return o.janitor.Stats().Runs > 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("expires the pending logins and exports after the transient TTL", func(t *testing.T) {
+		o := setupJanitorTest(t, &JanitorConfig{Interval: time.Hour, TransientTTL: time.Minute})
+
+		require.WithinDuration(t, time.Now().Add(time.Minute), o.transientExpiry(), time.Second)
+	})
+
+	t.Run("reports the cleanup stats", func(t *testing.T) {
+		o := setupJanitorTest(t, &JanitorConfig{Interval: time.Hour})
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: "deleted"}))
+		o.janitor.run()
+
+		handlers := o.GetAdminRESTHandlers()
+		require.Len(t, handlers, 8)
+		require.Equal(t, janitorStatsPath, handlers[6].Path())
+
+		w := httptest.NewRecorder()
+		handlers[6].Handle()(w, httptest.NewRequest(http.MethodGet, "/admin/oidc/janitor/stats", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stats := &JanitorStats{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), stats))
+		require.Equal(t, uint64(1), stats.Runs)
+		require.Equal(t, uint64(1), stats.OrphanedTokens)
+	})
+
+	t.Run("records the errors of the cleanups", func(t *testing.T) {
+		o := setupJanitorTest(t, &JanitorConfig{Interval: time.Hour})
+		o.janitor.transient = &mockstore.MockStore{Store: map[string][]byte{}, ErrGetAll: errors.New("test")}
+
+		o.janitor.run()
+		require.Contains(t, o.janitor.Stats().LastError, "failed to list transient records: test")
+
+		o.janitor.transient = &mockstore.MockStore{
+			Store: map[string][]byte{
+				"expired": marshal(t, &cachedUserInfo{Expires: time.Now().Add(-time.Minute)}),
+			},
+			ErrDelete: errors.New("test"),
+		}
+
+		o.janitor.run()
+		require.Contains(t, o.janitor.Stats().LastError, "failed to delete transient record: test")
+
+		o.janitor.transient = &mockstore.MockStore{Store: map[string][]byte{}}
+		o.janitor.tokens, _ = tokens.NewStore(&mockstore.Provider{
+			Store: &mockstore.MockStore{Store: map[string][]byte{}, ErrGetAll: errors.New("test")},
+		})
+
+		o.janitor.run()
+		require.Contains(t, o.janitor.Stats().LastError, "failed to list user tokens: test")
+
+		o.janitor.tokens, _ = tokens.NewStore(&mockstore.Provider{
+			Store: &mockstore.MockStore{
+				Store:     map[string][]byte{"deleted": marshal(t, &tokens.UserTokens{UserSub: "deleted"})},
+				ErrDelete: errors.New("test"),
+			},
+		})
+
+		o.janitor.run()
+		require.Contains(t, o.janitor.Stats().LastError, "failed to delete user tokens: test")
+		require.Equal(t, uint64(4), o.janitor.Stats().Runs)
+	})
+
+	t.Run("keeps the records if disabled", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		require.Nil(t, o.janitor)
+		require.Len(t, o.GetAdminRESTHandlers(), 7)
+		require.WithinDuration(t, time.Now().Add(defaultTransientTTL), o.transientExpiry(), time.Second)
+	})
+}
+
+func setupJanitorTest(t *testing.T, janitor *JanitorConfig) *Operation {
+	t.Helper()
+
+	config := config(t)
+	config.Janitor = janitor
+
+	o, err := New(config)
+	require.NoError(t, err)
+	t.Cleanup(o.Close)
+
+	return o
+}
+
+func withoutLastRun(stats JanitorStats) JanitorStats {
+	stats.LastRun = nil
+
+	return stats
+}
@@ -0,0 +1,163 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	keystoresKeyPrefix = "keystores_"
+	// keystoreCacheSize is the number of users whose keystore metadata is also kept in memory.
+	keystoreCacheSize = 1000
+)
+
+// KeystoreCacheConfig caches the keystore metadata of the users, the URLs of their keystores and keys and the
+// capabilities of their vaults, so that the agent does not fetch it from hub-auth before each of its KMS calls.
+type KeystoreCacheConfig struct {
+	// TTL of the cached metadata. The metadata of a user is fetched again sooner if the KMS denies a request of
+	// the user with a 401 or 403.
+	TTL time.Duration
+}
+
+type cachedKeystores struct {
+	Data    *BootstrapData `json:"data"`
+	Expires time.Time      `json:"expires"`
+}
+
+// keystores returns the keystore metadata of the user of the ID, from the cache if it has not expired. It is nil
+// if the user has no bootstrap data.
+func (o *Operation) keystores(ctx context.Context, userID, accessToken string) (*BootstrapData, error) {
+	if o.keystoreCache == nil {
+		return o.fetchKeystores(ctx, accessToken)
+	}
+
+	if cached, found := o.keystoreMemory.Get(userID); found {
+		if c, ok := cached.(*cachedKeystores); ok && o.now().Before(c.Expires) {
+			return c.Data, nil
+		}
+	}
+
+	cached, found := o.storedKeystores(userID)
+	if found {
+		o.keystoreMemory.Add(userID, cached)
+
+		return cached.Data, nil
+	}
+
+	data, err := o.fetchKeystores(ctx, accessToken)
+	if err != nil || data == nil {
+		return data, err
+	}
+
+	cached = &cachedKeystores{Data: data, Expires: o.now().Add(o.keystoreCache.TTL)}
+	o.keystoreMemory.Add(userID, cached)
+
+	// failures are logged, as the metadata is fetched again next time
+	err = store.Save(o.store.transient, keystoresKeyPrefix+userID, cached)
+	if err != nil {
+		logger.Warnf("failed to cache keystores: %s", err.Error())
+	}
+
+	return data, nil
+}
+
+func (o *Operation) fetchKeystores(ctx context.Context, accessToken string) (*BootstrapData, error) {
+	bootstrap, err := o.fetchBootstrapData(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return bootstrap.Data, nil
+}
+
+// storedKeystores returns the keystore metadata of the cache store, if it has not expired.
+func (o *Operation) storedKeystores(userID string) (*cachedKeystores, bool) {
+	bits, err := o.store.transient.Get(keystoresKeyPrefix + userID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrValueNotFound) {
+			logger.Warnf("failed to read cached keystores: %s", err.Error())
+		}
+
+		return nil, false
+	}
+
+	cached := &cachedKeystores{}
+
+	err = json.Unmarshal(bits, cached)
+	if err != nil {
+		logger.Warnf("failed to unmarshal cached keystores: %s", err.Error())
+
+		return nil, false
+	}
+
+	if o.now().After(cached.Expires) {
+		return nil, false
+	}
+
+	return cached, true
+}
+
+// invalidateKeystores drops the cached keystore metadata of the user of the ID once it changes, or once the KMS
+// denies a request of the user.
+func (o *Operation) invalidateKeystores(userID string) {
+	if o.keystoreCache == nil {
+		return
+	}
+
+	o.keystoreMemory.Remove(userID)
+
+	err := o.store.transient.Delete(keystoresKeyPrefix + userID)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		logger.Warnf("failed to invalidate cached keystores: %s", err.Error())
+	}
+}
+
+// kmsClient returns the client of the requests of the user to the ops keystore, which invalidates the cached
+// keystore metadata of the user if the KMS denies one of them: the metadata may be stale.
+func (o *Operation) kmsClient(h *hubKMSHeader) *http.Client {
+	if o.keystoreCache == nil {
+		return o.kmsHTTPClient
+	}
+
+	client := *o.kmsHTTPClient
+	client.Transport = &deniedTransport{
+		base: o.kmsHTTPClient.Transport,
+		denied: func() {
+			o.invalidateKeystores(h.userSub)
+		},
+	}
+
+	return &client
+}
+
+// deniedTransport calls denied on the 401 and 403 responses of its base transport.
+type deniedTransport struct {
+	base   http.RoundTripper
+	denied func()
+}
+
+func (t *deniedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err == nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		t.denied()
+	}
+
+	return resp, err
+}
@@ -0,0 +1,169 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperation_KeystoreCache(t *testing.T) {
+	t.Run("serves the cached keystores until they are invalidated", func(t *testing.T) {
+		o, fetches := setupKeystoreCacheTest(t, &KeystoreCacheConfig{TTL: time.Hour})
+
+		for i := 0; i < 2; i++ {
+			data, err := o.keystores(context.Background(), "user", "token")
+			require.NoError(t, err)
+			require.Equal(t, "https://kms.example.com/kms/keystores/123", data.OpsKeyStoreURL)
+		}
+
+		require.Equal(t, 1, *fetches)
+
+		o.invalidateKeystores("user")
+
+		_, err := o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+		require.Equal(t, 2, *fetches)
+	})
+
+	t.Run("serves the keystores cached in the store", func(t *testing.T) {
+		o, fetches := setupKeystoreCacheTest(t, &KeystoreCacheConfig{TTL: time.Hour})
+
+		_, err := o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+
+		// another instance of the agent, sharing the store
+		other, otherFetches := setupKeystoreCacheTest(t, &KeystoreCacheConfig{TTL: time.Hour})
+		other.store.transient = o.store.transient
+
+		data, err := other.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+		require.Equal(t, "https://kms.example.com/kms/keystores/123", data.OpsKeyStoreURL)
+		require.Equal(t, 1, *fetches)
+		require.Zero(t, *otherFetches)
+	})
+
+	t.Run("fetches the keystores once they expired", func(t *testing.T) {
+		o, fetches := setupKeystoreCacheTest(t, &KeystoreCacheConfig{TTL: time.Nanosecond})
+
+		_, err := o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		_, err = o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+		require.Equal(t, 2, *fetches)
+	})
+
+	t.Run("fetches the keystores before each call if not cached", func(t *testing.T) {
+		o, fetches := setupKeystoreCacheTest(t, nil)
+
+		_, err := o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+		_, err = o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+		require.Equal(t, 2, *fetches)
+		require.Equal(t, o.kmsHTTPClient, o.kmsClient(&hubKMSHeader{userSub: "user"}))
+	})
+
+	t.Run("invalidates the cached keystores if the kms denies a request", func(t *testing.T) {
+		for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+			o, fetches := setupKeystoreCacheTest(t, &KeystoreCacheConfig{TTL: time.Hour})
+
+			kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}))
+			defer kms.Close()
+
+			_, err := o.keystores(context.Background(), "user", "token")
+			require.NoError(t, err)
+
+			resp, err := o.kmsClient(&hubKMSHeader{userSub: "user"}).Get(kms.URL)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			_, err = o.keystores(context.Background(), "user", "token")
+			require.NoError(t, err)
+			require.Equal(t, 2, *fetches)
+		}
+	})
+
+	t.Run("keeps the cached keystores if the kms accepts a request", func(t *testing.T) {
+		o, fetches := setupKeystoreCacheTest(t, &KeystoreCacheConfig{TTL: time.Hour})
+
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer kms.Close()
+
+		_, err := o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+
+		resp, err := o.kmsClient(&hubKMSHeader{userSub: "user"}).Get(kms.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		_, err = o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+		require.Equal(t, 1, *fetches)
+	})
+
+	t.Run("does not cache users without bootstrap data", func(t *testing.T) {
+		o, _ := setupKeystoreCacheTest(t, &KeystoreCacheConfig{TTL: time.Hour})
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader([]byte("{}")))}, nil
+		}}
+
+		data, err := o.keystores(context.Background(), "user", "token")
+		require.NoError(t, err)
+		require.Nil(t, data)
+
+		_, found := o.storedKeystores("user")
+		require.False(t, found)
+	})
+
+	t.Run("error if the keystores cannot be fetched", func(t *testing.T) {
+		o, _ := setupKeystoreCacheTest(t, &KeystoreCacheConfig{TTL: time.Hour})
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("test")
+		}}
+
+		_, err := o.keystores(context.Background(), "user", "token")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "get bootstrap data")
+	})
+}
+
+// setupKeystoreCacheTest returns an Operation fetching the bootstrap data from hub-auth, and the number of fetches.
+func setupKeystoreCacheTest(t *testing.T, cache *KeystoreCacheConfig) (*Operation, *int) {
+	t.Helper()
+
+	config := config(t)
+	config.KeystoreCache = cache
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	data := marshal(t, &userBootstrapData{Data: &BootstrapData{
+		UserEDVVaultURL: "https://edv.example.com/encrypted-data-vaults/123",
+		OpsKeyStoreURL:  "https://kms.example.com/kms/keystores/123",
+	}})
+	fetches := 0
+
+	o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+		fetches++
+
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+	}}
+
+	return o, &fetches
+}
@@ -0,0 +1,610 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/netpolicy"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	lockoutsPath = "/lockouts"
+	// the prefix of the keys of the lockouts in the transient store.
+	lockoutKeyPrefix = "lockout_"
+	// a failed login is saved so many times at most while the other instances save failed logins of the same
+	// client or user.
+	maxLockoutSaves = 3
+)
+
+var errLockoutConflict = errors.New("lockout saved concurrently by another instance")
+
+// Lockout defaults.
+const (
+	defaultLockoutMaxFailures = 5
+	defaultLockoutWindow      = 15 * time.Minute
+	defaultLockoutDuration    = time.Minute
+	defaultLockoutMaxDuration = time.Hour
+)
+
+// Lockout kinds.
+const (
+	LockoutIP   = "ip"
+	LockoutUser = "user"
+)
+
+// Failed login reasons.
+const (
+	reasonMissingStateCookie = "missing state cookie"
+	reasonMissingState       = "missing state parameter"
+	reasonInvalidState       = "invalid state parameter"
+	reasonMissingCode        = "missing code parameter"
+	reasonExchangeFailed     = "code exchange failed"
+	reasonInvalidIDToken     = "invalid id_token"
+	reasonMissingIDToken     = "missing id_token parameter"
+	reasonStepUpFailed       = "step-up requirements not met"
+)
+
+// LockoutConfig locks the client IP addresses and the users with repeated failed logins out of the login, e.g.
+// after invalid states or authorization codes at the callback. The failed logins are counted in the transient
+// store, across the instances of the agent sharing it, and deleted by the janitor once forgotten.
+type LockoutConfig struct {
+	// MaxFailures is the number of failed logins that locks a client or a user out. Defaults to 5.
+	MaxFailures int
+	// Window is how long the failed logins are remembered for after the last one. Defaults to 15m.
+	Window time.Duration
+	// Duration of the first lockout, doubled by each further lockout of the same client or user until a
+	// successful login. Defaults to 1m.
+	Duration time.Duration
+	// MaxDuration caps the duration of the lockouts. Defaults to 1h.
+	MaxDuration time.Duration
+	// TrustForwardedFor takes the client IP address of the requests from the last address of their
+	// X-Forwarded-For header, as added by the reverse proxy of the agent. Only enable behind such a proxy.
+	TrustForwardedFor bool
+}
+
+// Lockout is the failed login status of a client IP address or of a user.
+type Lockout struct {
+	Kind string `json:"kind"`
+	// Key is the IP address of the client or the sub of the user.
+	Key string `json:"key"`
+	// Failures is the number of failed logins since the last lockout.
+	Failures int `json:"failures"`
+	// Lockouts is the number of consecutive lockouts.
+	Lockouts    int        `json:"lockouts"`
+	LastFailure time.Time  `json:"lastFailure"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+}
+
+type loginFailedAuditDetails struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+}
+
+// lockoutRecord is a Lockout in the transient store, shared by the instances of the agent.
+type lockoutRecord struct {
+	Lockout
+	// Revision of the record, advanced by each save. The store has no compare-and-set: the record is saved unless
+	// saved since it was read, and read back, so that the failed logins of the instances at once are retried rather
+	// than lost, but for a narrow window.
+	Revision int `json:"revision"`
+	// Expires once the failures and lockouts are over, for the janitor to delete the record.
+	Expires time.Time `json:"expires"`
+}
+
+type lockouts struct {
+	config LockoutConfig
+	store  storage.Store
+	// serializes the saves of this instance
+	mutex sync.Mutex
+	now   func() time.Time
+}
+
+func newLockouts(config *LockoutConfig, s storage.Store) *lockouts {
+	l := &lockouts{config: *config, store: s, now: time.Now}
+
+	if l.config.MaxFailures <= 0 {
+		l.config.MaxFailures = defaultLockoutMaxFailures
+	}
+
+	if l.config.Window <= 0 {
+		l.config.Window = defaultLockoutWindow
+	}
+
+	if l.config.Duration <= 0 {
+		l.config.Duration = defaultLockoutDuration
+	}
+
+	if l.config.MaxDuration <= 0 {
+		l.config.MaxDuration = defaultLockoutMaxDuration
+	}
+
+	if l.config.MaxDuration < l.config.Duration {
+		l.config.MaxDuration = l.config.Duration
+	}
+
+	return l
+}
+
+// fail records a failed login, and returns the lockout if the failure locks the client or user out.
+func (l *lockouts) fail(kind, key string) *Lockout {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for attempt := 0; attempt < maxLockoutSaves; attempt++ {
+		lockout, err := l.tryFail(kind, key)
+		if errors.Is(err, errLockoutConflict) {
+			continue
+		}
+
+		if err != nil {
+			logger.Errorf("failed to record failed login of %s %s: %s", kind, key, err.Error())
+		}
+
+		return lockout
+	}
+
+	logger.Errorf("failed to record failed login of %s %s: %s", kind, key, errLockoutConflict.Error())
+
+	return nil
+}
+
+func (l *lockouts) tryFail(kind, key string) (*Lockout, error) {
+	now := l.now()
+
+	r, err := l.get(kind, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.forgotten(&r.Lockout, now) {
+		r.Lockout = Lockout{Kind: kind, Key: key}
+	}
+
+	if r.LockedUntil != nil && now.Before(*r.LockedUntil) {
+		return nil, nil
+	}
+
+	r.Failures++
+	r.LastFailure = now
+
+	var lockout *Lockout
+
+	if r.Failures >= l.config.MaxFailures {
+		r.Lockouts++
+		r.Failures = 0
+
+		until := now.Add(l.duration(r.Lockouts))
+		r.LockedUntil = &until
+
+		lockout = copyLockout(&r.Lockout)
+	}
+
+	err = l.save(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return lockout, nil
+}
+
+// lockedOut returns how long the client or user is locked out for. The clients and users are let in if their
+// lockouts cannot be read.
+func (l *lockouts) lockedOut(kind, key string) (time.Duration, bool) {
+	r, err := l.get(kind, key)
+	if err != nil {
+		logger.Errorf("failed to read lockout of %s %s: %s", kind, key, err.Error())
+
+		return 0, false
+	}
+
+	if r.LockedUntil == nil {
+		return 0, false
+	}
+
+	remaining := r.LockedUntil.Sub(l.now())
+
+	return remaining, remaining > 0
+}
+
+// succeed forgets the failed logins of the client or user.
+func (l *lockouts) succeed(kind, key string) {
+	_, err := l.unlock(kind, key)
+	if err != nil {
+		logger.Errorf("failed to forget failed logins of %s %s: %s", kind, key, err.Error())
+	}
+}
+
+// unlock forgets the failed logins and lockouts of the client or user, and returns false if there were none.
+func (l *lockouts) unlock(kind, key string) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, err := l.store.Get(lockoutKey(kind, key))
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch lockout: %w", err)
+	}
+
+	err = l.store.Delete(lockoutKey(kind, key))
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return false, fmt.Errorf("failed to delete lockout: %w", err)
+	}
+
+	return true, nil
+}
+
+// list returns the clients and users with failed logins or lockouts, the locked out ones first.
+func (l *lockouts) list(kind, key string) ([]*Lockout, error) {
+	all, err := l.store.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lockouts: %w", err)
+	}
+
+	now := l.now()
+	list := make([]*Lockout, 0)
+
+	for k, bits := range all {
+		if !strings.HasPrefix(k, lockoutKeyPrefix) {
+			continue
+		}
+
+		r := &lockoutRecord{}
+
+		err = json.Unmarshal(bits, r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal lockout: %w", err)
+		}
+
+		if l.forgotten(&r.Lockout, now) || (kind != "" && (r.Kind != kind || r.Key != key)) {
+			continue
+		}
+
+		list = append(list, copyLockout(&r.Lockout))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		iLocked, jLocked := list[i].LockedUntil != nil, list[j].LockedUntil != nil
+		if iLocked != jLocked {
+			return iLocked
+		}
+
+		return list[i].LastFailure.After(list[j].LastFailure)
+	})
+
+	return list, nil
+}
+
+// get returns the record of the client or user, an empty record at revision 0 if there is none.
+func (l *lockouts) get(kind, key string) (*lockoutRecord, error) {
+	r := &lockoutRecord{Lockout: Lockout{Kind: kind, Key: key}}
+
+	bits, err := l.store.Get(lockoutKey(kind, key))
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return r, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch lockout: %w", err)
+	}
+
+	err = json.Unmarshal(bits, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lockout: %w", err)
+	}
+
+	return r, nil
+}
+
+// save the record unless it was saved since it was read, and read it back.
+func (l *lockouts) save(r *lockoutRecord) error {
+	stored, err := l.get(r.Kind, r.Key)
+	if err != nil {
+		return err
+	}
+
+	if stored.Revision != r.Revision {
+		return errLockoutConflict
+	}
+
+	r.Revision++
+	r.Expires = l.forgetAt(&r.Lockout)
+
+	bits, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lockout: %w", err)
+	}
+
+	err = l.store.Put(lockoutKey(r.Kind, r.Key), bits)
+	if err != nil {
+		return fmt.Errorf("failed to save lockout: %w", err)
+	}
+
+	saved, err := l.store.Get(lockoutKey(r.Kind, r.Key))
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to read back lockout: %w", err)
+	}
+
+	if !bytes.Equal(saved, bits) {
+		return errLockoutConflict
+	}
+
+	return nil
+}
+
+func (l *lockouts) duration(lockouts int) time.Duration {
+	factor := math.Pow(2, float64(lockouts-1)) // nolint:gomnd // doubles each lockout
+
+	if float64(l.config.Duration)*factor >= float64(l.config.MaxDuration) {
+		return l.config.MaxDuration
+	}
+
+	return time.Duration(float64(l.config.Duration) * factor)
+}
+
+// forgotten tells whether the failures and lockouts of the entry are over.
+func (l *lockouts) forgotten(e *Lockout, now time.Time) bool {
+	return now.After(l.forgetAt(e))
+}
+
+// forgetAt returns when the failures and lockouts of the entry are over.
+func (l *lockouts) forgetAt(e *Lockout) time.Time {
+	last := e.LastFailure
+	if e.LockedUntil != nil && e.LockedUntil.After(last) {
+		last = *e.LockedUntil
+	}
+
+	return last.Add(l.config.Window)
+}
+
+func lockoutKey(kind, key string) string {
+	return lockoutKeyPrefix + kind + ":" + key
+}
+
+// clientIP returns the IP address of the client of the request.
+func (l *lockouts) clientIP(r *http.Request) string {
+	return netpolicy.ClientIP(r, l.config.TrustForwardedFor)
+}
+
+func copyLockout(e *Lockout) *Lockout {
+	c := *e
+
+	if e.LockedUntil != nil {
+		until := *e.LockedUntil
+		c.LockedUntil = &until
+	}
+
+	return &c
+}
+
+// clientLockedOut answers 429 to the clients locked out.
+func (o *Operation) clientLockedOut(w http.ResponseWriter, r *http.Request) bool {
+	if o.lockouts == nil {
+		return false
+	}
+
+	return writeLockedOut(w, o.lockouts, LockoutIP, o.lockouts.clientIP(r))
+}
+
+// userLockedOut answers 429 to the users locked out.
+func (o *Operation) userLockedOut(w http.ResponseWriter, sub string) bool {
+	if o.lockouts == nil {
+		return false
+	}
+
+	return writeLockedOut(w, o.lockouts, LockoutUser, sub)
+}
+
+func writeLockedOut(w http.ResponseWriter, l *lockouts, kind, key string) bool {
+	remaining, locked := l.lockedOut(kind, key)
+	if !locked {
+		return false
+	}
+
+	seconds := int(math.Ceil(remaining.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	common.WriteErrorResponsef(w, logger, http.StatusTooManyRequests,
+		"too many failed logins: retry in %d seconds", seconds)
+
+	return true
+}
+
+// loginFailed records a failed login of the client, and of the user of the session if any.
+func (o *Operation) loginFailed(r *http.Request, jar cookie.Jar, reason string) {
+	if o.lockouts == nil {
+		return
+	}
+
+	ip := o.lockouts.clientIP(r)
+	sub := sessionUser(jar)
+
+	o.audit(audit.ActionLoginFailed, sub, &loginFailedAuditDetails{IP: ip, Reason: reason})
+	o.lockOut(o.lockouts.fail(LockoutIP, ip))
+
+	if sub != "" {
+		o.lockOut(o.lockouts.fail(LockoutUser, sub))
+	}
+}
+
+// loginSucceeded forgets the failed logins of the client and of the user logged in.
+func (o *Operation) loginSucceeded(r *http.Request) {
+	if o.lockouts == nil {
+		return
+	}
+
+	o.lockouts.succeed(LockoutIP, o.lockouts.clientIP(r))
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		logger.Warnf("failed to read session cookie: %s", err.Error())
+
+		return
+	}
+
+	if sub := sessionUser(jar); sub != "" {
+		o.lockouts.succeed(LockoutUser, sub)
+	}
+}
+
+func (o *Operation) lockOut(lockout *Lockout) {
+	if lockout == nil {
+		return
+	}
+
+	logger.Warnf("locked %s %s out until %s after repeated failed logins",
+		lockout.Kind, lockout.Key, lockout.LockedUntil.Format(time.RFC3339))
+
+	sub := ""
+	if lockout.Kind == LockoutUser {
+		sub = lockout.Key
+	}
+
+	o.audit(audit.ActionLoginLockout, sub, lockout)
+
+	e, err := outbox.NewEvent(TopicLoginLockout, sub, lockout)
+	if err != nil {
+		logger.Errorf("failed to create lockout event: %s", err.Error())
+
+		return
+	}
+
+	tx, err := o.beginEvents(e)
+	if err != nil {
+		logger.Errorf("failed to record lockout event: %s", err.Error())
+
+		return
+	}
+
+	commitEvents(tx)
+}
+
+func (o *Operation) lockoutHandlers() []common.Handler {
+	params := []common.Param{
+		common.QueryParam("ip", "IP address of the client."),
+		common.QueryParam("sub", "Sub of the user."),
+	}
+
+	return []common.Handler{
+		common.NewHTTPHandler(lockoutsPath, http.MethodGet, o.lockoutsHandler, &common.OperationSpec{
+			Summary:   "Lists the clients and users with recent failed logins, the locked out ones first.",
+			Params:    params,
+			Responses: map[int]interface{}{http.StatusOK: []*Lockout{}},
+		}),
+		common.NewHTTPHandler(lockoutsPath, http.MethodDelete, o.unlockHandler, &common.OperationSpec{
+			Summary:   "Unlocks a client or a user, and forgets their failed logins.",
+			Params:    params,
+			Responses: map[int]interface{}{http.StatusNoContent: nil},
+		}),
+	}
+}
+
+func (o *Operation) lockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	kind, key, ok := lockoutQuery(w, r, false)
+	if !ok {
+		return
+	}
+
+	list, err := o.lockouts.list(kind, key)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to list lockouts: %s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, list)
+}
+
+func (o *Operation) unlockHandler(w http.ResponseWriter, r *http.Request) {
+	kind, key, ok := lockoutQuery(w, r, true)
+	if !ok {
+		return
+	}
+
+	found, err := o.lockouts.unlock(kind, key)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to unlock %s %s: %s",
+			kind, key, err.Error())
+
+		return
+	}
+
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "no failed logins of %s %s", kind, key)
+
+		return
+	}
+
+	if o.auditLog != nil {
+		sub := ""
+		if kind == LockoutUser {
+			sub = key
+		}
+
+		err := o.auditLog.Record(audit.ActionLoginUnlocked, sub, audit.ActorAdmin, &Lockout{Kind: kind, Key: key})
+		if err != nil {
+			logger.Errorf("failed to audit unlock of %s %s: %s", kind, key, err.Error())
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockoutQuery returns the client or user of the request, from its ip or sub parameter.
+func lockoutQuery(w http.ResponseWriter, r *http.Request, required bool) (kind, key string, ok bool) {
+	ip, sub := r.URL.Query().Get("ip"), r.URL.Query().Get("sub")
+
+	switch {
+	case ip != "" && sub != "":
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "either the ip or the sub parameter is allowed")
+
+		return "", "", false
+	case ip != "":
+		return LockoutIP, ip, true
+	case sub != "":
+		return LockoutUser, sub, true
+	case required:
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing ip or sub parameter")
+
+		return "", "", false
+	default:
+		return "", "", true
+	}
+}
+
+// sessionUser returns the sub of the user of the session, or of the user whose login is pending, if any.
+func sessionUser(jar cookie.Jar) string {
+	if jar == nil {
+		return ""
+	}
+
+	for _, name := range []string{userSubCookieName, consentSubCookieName} {
+		if sub, found := jar.Get(name); found {
+			return fmt.Sprintf("%v", sub)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,451 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+const (
+	clientAddr      = "192.0.2.1:4321"
+	otherClientAddr = "192.0.2.2:4321"
+)
+
+func TestOperation_Lockout(t *testing.T) {
+	t.Run("locks the client out after repeated invalid states", func(t *testing.T) {
+		o, _ := setupLockoutTest(t)
+
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			o.oidcCallbackHandler(w, callbackFrom(clientAddr, uuid.New().String()))
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		}
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, callbackFrom(clientAddr, "state"))
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		require.NoError(t, err)
+		require.InDelta(t, 60, retryAfter, 1)
+
+		r := httptest.NewRequest(http.MethodGet, "/oidc/login", nil)
+		r.RemoteAddr = clientAddr
+
+		w = httptest.NewRecorder()
+		o.oidcLoginHandler(w, r)
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		w = httptest.NewRecorder()
+		o.oidcCallbackHandler(w, callbackFrom(otherClientAddr, "state"))
+		require.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("locks the user out after repeated failed exchanges", func(t *testing.T) {
+		o, sub := setupLockoutTest(t)
+		o.store.cookies.(*cookie.MockStore).Jar.Set(userSubCookieName, sub)
+
+		client := o.oidcClient.(*oidc2.MockClient)
+		client.OAuthErr = errors.New("test")
+
+		for _, addr := range []string{clientAddr, otherClientAddr} {
+			o.store.cookies.(*cookie.MockStore).Jar.Set(stateCookieName, "state")
+
+			w := httptest.NewRecorder()
+			o.oidcCallbackHandler(w, callbackFrom(addr, "state"))
+			require.Equal(t, http.StatusBadGateway, w.Code)
+		}
+
+		client.OAuthErr = nil
+		o.store.cookies.(*cookie.MockStore).Jar.Set(stateCookieName, "state")
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, callbackFrom("192.0.2.3:4321", "state"))
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		entries, err := o.auditLog.List(sub)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			audit.ActionLoginFailed, audit.ActionLoginFailed, audit.ActionLoginLockout,
+		}, actions(entries))
+
+		events, err := o.store.outbox.List()
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, TopicLoginLockout, events[0].Topic)
+		require.Equal(t, sub, events[0].Subject)
+		require.Equal(t, outbox.StatusReady, events[0].Status)
+
+		confirmed, err := o.confirmEvent(events[0])
+		require.NoError(t, err)
+		require.True(t, confirmed)
+	})
+
+	t.Run("forgets the failed logins once the user logs in", func(t *testing.T) {
+		o, sub := setupLockoutTest(t)
+		o.store.cookies.(*cookie.MockStore).Jar.Set(userSubCookieName, sub)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, callbackFrom(clientAddr, uuid.New().String()))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Len(t, listLockouts(t, o.lockouts), 2)
+
+		w = httptest.NewRecorder()
+		o.oidcCallbackHandler(w, callbackFrom(clientAddr, "state"))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Empty(t, listLockouts(t, o.lockouts))
+	})
+
+	t.Run("does not track failed logins if disabled", func(t *testing.T) {
+		o, _ := setupLockoutTest(t)
+		o.lockouts = nil
+
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			o.oidcCallbackHandler(w, callbackFrom(clientAddr, uuid.New().String()))
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		}
+
+		require.Len(t, o.GetAdminRESTHandlers(), 7)
+	})
+}
+
+func TestOperation_LockoutHandlers(t *testing.T) {
+	t.Run("lists and unlocks the clients and users", func(t *testing.T) {
+		o, sub := setupLockoutTest(t)
+		require.Len(t, o.GetAdminRESTHandlers(), 9)
+
+		o.lockouts.fail(LockoutIP, "192.0.2.1")
+		o.lockOut(o.lockouts.fail(LockoutUser, sub))
+		o.lockOut(o.lockouts.fail(LockoutUser, sub))
+
+		w := httptest.NewRecorder()
+		o.lockoutsHandler(w, httptest.NewRequest(http.MethodGet, lockoutsPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var list []*Lockout
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+		require.Len(t, list, 2)
+		require.Equal(t, LockoutUser, list[0].Kind)
+		require.NotNil(t, list[0].LockedUntil)
+		require.Equal(t, 1, list[0].Lockouts)
+		require.Equal(t, LockoutIP, list[1].Kind)
+		require.Equal(t, 1, list[1].Failures)
+		require.Nil(t, list[1].LockedUntil)
+
+		w = httptest.NewRecorder()
+		o.lockoutsHandler(w, httptest.NewRequest(http.MethodGet, lockoutsPath+"?ip=192.0.2.1", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+		require.Len(t, list, 1)
+
+		w = httptest.NewRecorder()
+		o.unlockHandler(w, httptest.NewRequest(http.MethodDelete, lockoutsPath+"?sub="+sub, nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		_, locked := o.lockouts.lockedOut(LockoutUser, sub)
+		require.False(t, locked)
+
+		entries, err := o.auditLog.List(sub)
+		require.NoError(t, err)
+		require.Equal(t, audit.ActionLoginUnlocked, entries[len(entries)-1].Action)
+		require.Equal(t, audit.ActorAdmin, entries[len(entries)-1].Actor)
+
+		w = httptest.NewRecorder()
+		o.unlockHandler(w, httptest.NewRequest(http.MethodDelete, lockoutsPath+"?sub="+sub, nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("internal server error if the lockouts cannot be read", func(t *testing.T) {
+		o, _ := setupLockoutTest(t)
+		o.lockouts.store = &mockstore.MockStore{
+			Store:     map[string][]byte{lockoutKey(LockoutIP, "192.0.2.1"): []byte("{}")},
+			ErrGetAll: errors.New("test"),
+			ErrGet:    errors.New("test"),
+		}
+
+		w := httptest.NewRecorder()
+		o.lockoutsHandler(w, httptest.NewRequest(http.MethodGet, lockoutsPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to list lockouts")
+
+		w = httptest.NewRecorder()
+		o.unlockHandler(w, httptest.NewRequest(http.MethodDelete, lockoutsPath+"?ip=192.0.2.1", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to unlock ip 192.0.2.1")
+	})
+
+	t.Run("bad request if the client or user is missing or ambiguous", func(t *testing.T) {
+		o, _ := setupLockoutTest(t)
+
+		w := httptest.NewRecorder()
+		o.unlockHandler(w, httptest.NewRequest(http.MethodDelete, lockoutsPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = httptest.NewRecorder()
+		o.lockoutsHandler(w, httptest.NewRequest(http.MethodGet, lockoutsPath+"?ip=192.0.2.1&sub=sub", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestLockouts(t *testing.T) {
+	t.Run("applies defaults", func(t *testing.T) {
+		l := newLockouts(&LockoutConfig{}, transientStore())
+		require.Equal(t, LockoutConfig{
+			MaxFailures: defaultLockoutMaxFailures,
+			Window:      defaultLockoutWindow,
+			Duration:    defaultLockoutDuration,
+			MaxDuration: defaultLockoutMaxDuration,
+		}, l.config)
+
+		l = newLockouts(&LockoutConfig{Duration: 2 * time.Hour, MaxDuration: time.Minute}, transientStore())
+		require.Equal(t, 2*time.Hour, l.config.MaxDuration)
+	})
+
+	t.Run("doubles the lockouts up to the maximum", func(t *testing.T) {
+		l := newLockouts(&LockoutConfig{MaxFailures: 1, Duration: time.Minute, MaxDuration: 5 * time.Minute},
+			transientStore())
+		require.Equal(t, time.Minute, l.duration(1))
+		require.Equal(t, 2*time.Minute, l.duration(2))
+		require.Equal(t, 4*time.Minute, l.duration(3))
+		require.Equal(t, 5*time.Minute, l.duration(4))
+		require.Equal(t, 5*time.Minute, l.duration(100))
+
+		require.NotNil(t, l.fail(LockoutIP, "192.0.2.1"))
+		require.Nil(t, l.fail(LockoutIP, "192.0.2.1"), "failures are ignored while locked out")
+
+		remaining, locked := l.lockedOut(LockoutIP, "192.0.2.1")
+		require.True(t, locked)
+		require.InDelta(t, time.Minute, remaining, float64(time.Second))
+
+		rewindLockout(t, l, LockoutIP, "192.0.2.1", time.Minute+time.Second)
+
+		lockout := l.fail(LockoutIP, "192.0.2.1")
+		require.NotNil(t, lockout)
+		require.Equal(t, 2, lockout.Lockouts)
+		require.InDelta(t, 2*time.Minute, time.Until(*lockout.LockedUntil), float64(time.Second))
+	})
+
+	t.Run("forgets the failures after the window", func(t *testing.T) {
+		l := newLockouts(&LockoutConfig{MaxFailures: 2, Window: time.Minute}, transientStore())
+		require.Nil(t, l.fail(LockoutUser, "sub"))
+
+		rewindLockout(t, l, LockoutUser, "sub", 2*time.Minute)
+		require.Empty(t, listLockouts(t, l))
+		require.Nil(t, l.fail(LockoutUser, "sub"))
+		require.Equal(t, 1, listLockouts(t, l)[0].Failures)
+
+		// the janitor deletes the forgotten records
+		rewindLockout(t, l, LockoutUser, "sub", 2*time.Minute)
+
+		r, err := l.get(LockoutUser, "sub")
+		require.NoError(t, err)
+		require.True(t, r.Expires.Before(time.Now()))
+	})
+
+	t.Run("times the lockouts with the clock", func(t *testing.T) {
+		now := time.Now().Add(-time.Hour)
+
+		l := newLockouts(&LockoutConfig{MaxFailures: 1, Duration: time.Minute}, transientStore())
+		l.now = func() time.Time { return now }
+
+		require.NotNil(t, l.fail(LockoutUser, "sub"))
+
+		now = now.Add(20 * time.Second)
+
+		remaining, locked := l.lockedOut(LockoutUser, "sub")
+		require.True(t, locked)
+		require.Equal(t, 40*time.Second, remaining)
+
+		now = now.Add(time.Minute)
+
+		_, locked = l.lockedOut(LockoutUser, "sub")
+		require.False(t, locked)
+	})
+
+	t.Run("counts the failed logins of the instances sharing the store", func(t *testing.T) {
+		s := transientStore()
+		instances := []*lockouts{
+			newLockouts(&LockoutConfig{MaxFailures: 3}, s),
+			newLockouts(&LockoutConfig{MaxFailures: 3}, s),
+		}
+
+		require.Nil(t, instances[0].fail(LockoutIP, "192.0.2.1"))
+		require.Nil(t, instances[1].fail(LockoutIP, "192.0.2.1"))
+		require.NotNil(t, instances[0].fail(LockoutIP, "192.0.2.1"))
+
+		_, locked := instances[1].lockedOut(LockoutIP, "192.0.2.1")
+		require.True(t, locked)
+
+		found, err := instances[1].unlock(LockoutIP, "192.0.2.1")
+		require.NoError(t, err)
+		require.True(t, found)
+
+		_, locked = instances[0].lockedOut(LockoutIP, "192.0.2.1")
+		require.False(t, locked)
+	})
+
+	t.Run("retries the failed logins saved concurrently by another instance", func(t *testing.T) {
+		s := &concurrentStore{MockStore: transientStore()}
+		l := newLockouts(&LockoutConfig{MaxFailures: 2}, s)
+		other, err := json.Marshal(&lockoutRecord{
+			Lockout:  Lockout{Kind: LockoutIP, Key: "192.0.2.1", Failures: 1, LastFailure: time.Now()},
+			Revision: 1,
+		})
+		require.NoError(t, err)
+
+		// another instance saves a failed login right after this one, which is counted on the next save
+		s.afterPut = func(k string) {
+			s.afterPut = nil
+
+			require.NoError(t, s.MockStore.Put(k, other))
+		}
+
+		require.NotNil(t, l.fail(LockoutIP, "192.0.2.1"))
+
+		s.afterPut = func(k string) { require.NoError(t, s.MockStore.Put(k, []byte("{}"))) }
+
+		require.Nil(t, l.fail(LockoutUser, "sub"), "gives up after the saves of the other instances")
+	})
+
+	t.Run("lets the clients in if the lockouts cannot be read", func(t *testing.T) {
+		l := newLockouts(&LockoutConfig{MaxFailures: 1}, &mockstore.MockStore{
+			Store:  map[string][]byte{lockoutKey(LockoutIP, "192.0.2.1"): []byte("{}")},
+			ErrGet: errors.New("test"),
+		})
+
+		require.Nil(t, l.fail(LockoutIP, "192.0.2.1"))
+
+		_, locked := l.lockedOut(LockoutIP, "192.0.2.1")
+		require.False(t, locked)
+
+		l.store = &mockstore.MockStore{Store: map[string][]byte{}, ErrDelete: errors.New("test")}
+		l.succeed(LockoutIP, "192.0.2.1")
+	})
+
+	t.Run("takes the client IP from the proxy if trusted", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/oidc/callback", nil)
+		r.RemoteAddr = clientAddr
+		r.Header.Add("X-Forwarded-For", "198.51.100.1, 198.51.100.2")
+
+		require.Equal(t, "192.0.2.1", newLockouts(&LockoutConfig{}, nil).clientIP(r))
+		require.Equal(t, "198.51.100.2", newLockouts(&LockoutConfig{TrustForwardedFor: true}, nil).clientIP(r))
+
+		r.Header.Del("X-Forwarded-For")
+		require.Equal(t, "192.0.2.1", newLockouts(&LockoutConfig{TrustForwardedFor: true}, nil).clientIP(r))
+
+		r.RemoteAddr = "pipe"
+		require.Equal(t, "pipe", newLockouts(&LockoutConfig{}, nil).clientIP(r))
+	})
+}
+
+// setupLockoutTest returns an Operation locking clients and users out after 2 failed logins, with a login
+// pending for the "state" state, and the sub of a stored user the OIDC provider logs in.
+func setupLockoutTest(t *testing.T) (*Operation, string) {
+	t.Helper()
+
+	o, sub := setupRememberTest(t)
+	o.remember = nil
+	o.lockouts = newLockouts(&LockoutConfig{MaxFailures: 2, Duration: time.Minute}, transientStore())
+	o.store.cookies.(*cookie.MockStore).Jar.Set(stateCookieName, "state")
+
+	var err error
+
+	o.auditLog, err = audit.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	o.store.outbox, err = outbox.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	return o, sub
+}
+
+func transientStore() *mockstore.MockStore {
+	return &mockstore.MockStore{Store: map[string][]byte{}}
+}
+
+func listLockouts(t *testing.T, l *lockouts) []*Lockout {
+	t.Helper()
+
+	list, err := l.list("", "")
+	require.NoError(t, err)
+
+	return list
+}
+
+// rewindLockout moves the failed logins and the lockout of the client or user back in time.
+func rewindLockout(t *testing.T, l *lockouts, kind, key string, by time.Duration) {
+	t.Helper()
+
+	r, err := l.get(kind, key)
+	require.NoError(t, err)
+
+	r.LastFailure = r.LastFailure.Add(-by)
+	r.Expires = r.Expires.Add(-by)
+
+	if r.LockedUntil != nil {
+		until := r.LockedUntil.Add(-by)
+		r.LockedUntil = &until
+	}
+
+	bits, err := json.Marshal(r)
+	require.NoError(t, err)
+	require.NoError(t, l.store.Put(lockoutKey(kind, key), bits))
+}
+
+// concurrentStore runs the requests of another instance sharing the store after its first read, and after its
+// puts.
+type concurrentStore struct {
+	*mockstore.MockStore
+	afterGet func()
+	afterPut func(k string)
+}
+
+func (s *concurrentStore) Get(k string) ([]byte, error) {
+	v, err := s.MockStore.Get(k)
+
+	if s.afterGet != nil {
+		after := s.afterGet
+		s.afterGet = nil
+
+		after()
+	}
+
+	return v, err
+}
+
+func (s *concurrentStore) Put(k string, v []byte) error {
+	err := s.MockStore.Put(k, v)
+
+	if s.afterPut != nil {
+		s.afterPut(k)
+	}
+
+	return err
+}
+
+func callbackFrom(addr, state string) *http.Request {
+	r := newOIDCCallbackRequest(uuid.New().String(), state)
+	r.RemoteAddr = addr
+
+	return r
+}
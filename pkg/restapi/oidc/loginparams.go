@@ -0,0 +1,146 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/oauth2"
+)
+
+// Parameters of the authentication requests the wallet UI may pass to /login, as per OpenID Connect Core 1.0.
+const (
+	LoginHintParam = "login_hint"
+	PromptParam    = "prompt"
+	UILocalesParam = "ui_locales"
+	MaxAgeParam    = "max_age"
+
+	maxLoginHintLength = 256
+	maxUILocales       = 10
+)
+
+var (
+	loginParamValidators = map[string]func(string) error{ // nolint:gochecknoglobals // read only
+		LoginHintParam: validateLoginHint,
+		PromptParam:    validatePrompt,
+		UILocalesParam: validateUILocales,
+		MaxAgeParam:    validateMaxAge,
+	}
+	promptValues = []string{"none", "login", "consent", "select_account"} // nolint:gochecknoglobals // read only
+	// a language tag of BCP 47, loosely.
+	languageTag = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+)
+
+// loginParams are the parameters of the authentication requests passed by the wallet UI to /login, among the
+// ones allowed.
+type loginParams []string
+
+func newLoginParams(allowed []string) (loginParams, error) {
+	for _, name := range allowed {
+		if _, supported := loginParamValidators[name]; !supported {
+			return nil, fmt.Errorf("unsupported login parameter %s", name)
+		}
+	}
+
+	return allowed, nil
+}
+
+// options validates the login parameters of the query and returns them as options of the authentication request.
+func (p loginParams) options(query url.Values) ([]oauth2.AuthCodeOption, error) {
+	var opts []oauth2.AuthCodeOption
+
+	for name, validate := range loginParamValidators {
+		if _, set := query[name]; !set {
+			continue
+		}
+
+		if !contains(p, name) {
+			return nil, fmt.Errorf("login parameter %s is not allowed", name)
+		}
+
+		value := query.Get(name)
+
+		err := validate(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+
+		opts = append(opts, oauth2.SetAuthURLParam(name, value))
+	}
+
+	return opts, nil
+}
+
+// forcesLogin tells whether the user must authenticate again with the OIDC provider, even if logged in already.
+func forcesLogin(query url.Values) bool {
+	_, maxAge := query[MaxAgeParam]
+
+	return maxAge || contains(strings.Fields(query.Get(PromptParam)), "login")
+}
+
+func validateLoginHint(hint string) error {
+	if hint == "" || len(hint) > maxLoginHintLength {
+		return fmt.Errorf("must have 1 to %d characters", maxLoginHintLength)
+	}
+
+	for _, c := range hint {
+		if !unicode.IsPrint(c) {
+			return errors.New("must only have printable characters")
+		}
+	}
+
+	return nil
+}
+
+func validatePrompt(prompt string) error {
+	values := strings.Fields(prompt)
+	if len(values) == 0 {
+		return errors.New("must not be empty")
+	}
+
+	for _, value := range values {
+		if !contains(promptValues, value) {
+			return fmt.Errorf("unknown value %s", value)
+		}
+	}
+
+	if contains(values, "none") && len(values) > 1 {
+		return errors.New("none must not be combined with other values")
+	}
+
+	return nil
+}
+
+func validateUILocales(locales string) error {
+	tags := strings.Fields(locales)
+	if len(tags) == 0 || len(tags) > maxUILocales {
+		return fmt.Errorf("must have 1 to %d language tags", maxUILocales)
+	}
+
+	for _, tag := range tags {
+		if !languageTag.MatchString(tag) {
+			return fmt.Errorf("%s is not a language tag", tag)
+		}
+	}
+
+	return nil
+}
+
+func validateMaxAge(maxAge string) error {
+	_, err := strconv.ParseUint(maxAge, 10, 32)
+	if err != nil {
+		return fmt.Errorf("must be a number of seconds: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestOperation_LoginParams(t *testing.T) {
+	t.Run("forwards the allowed parameters to the OIDC provider", func(t *testing.T) {
+		o := setupLoginParamsTest(t, LoginHintParam, PromptParam, UILocalesParam, MaxAgeParam)
+
+		w := loginWithParams(o,
+			"/oidc/login?login_hint=alice%40example.com&prompt=login+consent&ui_locales=fr-CA+en&max_age=60")
+		require.Equal(t, http.StatusFound, w.Code)
+
+		query := redirectQuery(t, w)
+		require.Equal(t, "alice@example.com", query.Get(LoginHintParam))
+		require.Equal(t, "login consent", query.Get(PromptParam))
+		require.Equal(t, "fr-CA en", query.Get(UILocalesParam))
+		require.Equal(t, "60", query.Get(MaxAgeParam))
+		require.NotEmpty(t, query.Get("state"))
+	})
+
+	t.Run("sends the users logged in already to log in again", func(t *testing.T) {
+		o := setupLoginParamsTest(t, PromptParam, MaxAgeParam)
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: uuid.New().String()},
+		}}
+
+		for _, query := range []string{"prompt=login", "max_age=0"} {
+			w := loginWithParams(o, "/oidc/login?"+query)
+			require.Equal(t, http.StatusFound, w.Code)
+			require.Contains(t, w.Header().Get("Location"), authorizeURL)
+		}
+
+		w := loginWithParams(o, "/oidc/login?prompt=consent")
+		require.Equal(t, http.StatusMovedPermanently, w.Code)
+	})
+
+	t.Run("bad request if a parameter is not allowed", func(t *testing.T) {
+		o := setupLoginParamsTest(t, PromptParam)
+
+		w := loginWithParams(o, "/oidc/login?login_hint=alice")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "login parameter login_hint is not allowed")
+
+		o = setupLoginParamsTest(t)
+
+		w = loginWithParams(o, "/oidc/login?prompt=login")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("bad request if a parameter is invalid", func(t *testing.T) {
+		o := setupLoginParamsTest(t, LoginHintParam, PromptParam, UILocalesParam, MaxAgeParam)
+
+		for _, query := range []string{
+			"login_hint=",
+			"login_hint=" + url.QueryEscape("alice\n"),
+			"prompt=",
+			"prompt=always",
+			"prompt=none+login",
+			"ui_locales=" + url.QueryEscape("fr;q=1"),
+			"max_age=-1",
+			"max_age=soon",
+		} {
+			w := loginWithParams(o, "/oidc/login?"+query)
+			require.Equal(t, http.StatusBadRequest, w.Code, query)
+			require.Contains(t, w.Body.String(), "invalid login request", query)
+		}
+	})
+
+	t.Run("does not forward any parameter by default", func(t *testing.T) {
+		o := setupLoginParamsTest(t)
+
+		w := loginWithParams(o, "/oidc/login")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, authorizeURL, w.Header().Get("Location"))
+	})
+
+	t.Run("error if an allowed parameter is not supported", func(t *testing.T) {
+		config := config(t)
+		config.LoginParams = []string{PromptParam, "redirect_uri"}
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unsupported login parameter redirect_uri")
+	})
+}
+
+func setupLoginParamsTest(t *testing.T, allowed ...string) *Operation {
+	t.Helper()
+
+	config := config(t)
+	config.OIDCClient = &oidc2.MockClient{AuthRequest: authorizeURL}
+	config.LoginParams = allowed
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{}}}
+
+	return o
+}
+
+func loginWithParams(o *Operation, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	o.oidcLoginHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return w
+}
+
+func redirectQuery(t *testing.T, w *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+
+	return location.Query()
+}
@@ -12,6 +12,10 @@ type createKeystoreReq struct {
 	VaultID    string `json:"vaultID,omitempty"`
 }
 
+type updateControllerReq struct {
+	Controller string `json:"controller,omitempty"`
+}
+
 type createKeyReq struct {
 	KeyType string `json:"keyType,omitempty"`
 }
@@ -41,6 +45,10 @@ type BootstrapData struct {
 	EDVOpsKIDURL      string `json:"edvOpsKIDURL,omitempty"`
 	EDVHMACKIDURL     string `json:"edvHMACKIDURL,omitempty"`
 	UserEDVCapability string `json:"edvCapability,omitempty"`
+	// Version of the schema of the bootstrap data. See BootstrapDataVersion.
+	Version int `json:"version,omitempty"`
+	// JWS is the detached signature of the other fields by the agent, if it signs the bootstrap data.
+	JWS string `json:"jws,omitempty"`
 }
 
 type userBootstrapData struct {
@@ -53,6 +61,7 @@ type secretRequest struct {
 
 type hubKMSHeader struct {
 	secretShare string
+	// userSub is the pseudonymous ID of the user if the Operation has pseudonyms.
 	userSub     string
 	accessToken string
 }
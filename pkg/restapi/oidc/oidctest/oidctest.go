@@ -0,0 +1,277 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package oidctest provides fakes of the services the oidc Operation depends on, the OIDC provider, hub-auth, the
+// KMS and the EDV servers, so that the projects embedding the Operation can unit test it.
+package oidctest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edv/pkg/restapi/models"
+	"golang.org/x/oauth2"
+
+	oidcclient "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+)
+
+const (
+	// AuthURL is the authorization endpoint of the fake OIDC provider.
+	AuthURL = "https://op.example.com/authorize"
+	// HubAuthURL is the URL of the fake hub-auth.
+	HubAuthURL = "https://hub-auth.example.com"
+	// KMSURL is the URL of the fake KMS servers, both for the authz and the ops keystores.
+	KMSURL = "https://kms.example.com"
+	// EDVURL is the URL of the fake EDV servers, both for the key and the user vaults.
+	EDVURL = "https://edv.example.com/encrypted-data-vaults"
+
+	loginPath    = "/login"
+	callbackPath = "/callback"
+	keySize      = 32
+)
+
+// OIDCClient is a fake OIDC client. It logs the user of its claims in.
+type OIDCClient struct {
+	oidcclient.MockClient
+}
+
+// NewOIDCClient returns a fake OIDC client logging the user of the sub in, with an email of example.com.
+func NewOIDCClient(sub string) *OIDCClient {
+	claims := Claims(map[string]interface{}{
+		"sub":   sub,
+		"email": sub + "@example.com",
+	})
+
+	return &OIDCClient{MockClient: oidcclient.MockClient{
+		AuthRequest: AuthURL,
+		OAuthToken: &oauth2.Token{
+			AccessToken:  uuid.New().String(),
+			RefreshToken: uuid.New().String(),
+			TokenType:    "Bearer",
+		},
+		IDToken:     claims,
+		UserInfoVal: claims,
+		Refreshed: &oauth2.Token{
+			AccessToken:  uuid.New().String(),
+			RefreshToken: uuid.New().String(),
+			TokenType:    "Bearer",
+		},
+	}}
+}
+
+// FormatRequest formats the OIDC authorization request, with the state, so that the callbacks can be forged from
+// the redirects of the logins.
+func (c *OIDCClient) FormatRequest(state string, opts ...oauth2.AuthCodeOption) string {
+	return (&oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: c.AuthRequest}}).AuthCodeURL(state, opts...)
+}
+
+// Claims returns an id_token or user info with the claims.
+func Claims(claims map[string]interface{}) *oidcclient.MockClaimer {
+	return &oidcclient.MockClaimer{ClaimsFunc: func(i interface{}) error {
+		bits, err := json.Marshal(claims)
+		if err != nil {
+			return fmt.Errorf("failed to marshal the claims: %w", err)
+		}
+
+		return json.Unmarshal(bits, i)
+	}}
+}
+
+// HTTPClient is a fake of hub-auth and the KMS servers. It accepts the requests of the onboarding of the users and
+// records them.
+type HTTPClient struct {
+	// DoFunc answers the requests in place of the fake if set, e.g. to fail some of them.
+	DoFunc   func(req *http.Request) (*http.Response, error)
+	mutex    sync.Mutex
+	requests []*http.Request
+}
+
+// Do records the request and answers it.
+func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.mutex.Lock()
+	c.requests = append(c.requests, req)
+	c.mutex.Unlock()
+
+	if c.DoFunc != nil {
+		return c.DoFunc(req)
+	}
+
+	return Respond(req), nil
+}
+
+// Requests returns the requests sent so far, in order.
+func (c *HTTPClient) Requests() []*http.Request {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]*http.Request(nil), c.requests...)
+}
+
+// Respond is the answer of the fake HTTPClient to the request: the keystores and keys are created with a random
+// ID, and the other requests succeed with an empty object. DoFunc can fall back to it.
+func Respond(req *http.Request) *http.Response {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte("{}"))),
+	}
+
+	if req.Method == http.MethodPost &&
+		(strings.HasSuffix(req.URL.Path, "/keystores") || strings.HasSuffix(req.URL.Path, "/keys")) {
+		resp.StatusCode = http.StatusCreated
+		resp.Header.Set("Location", req.URL.String()+"/"+uuid.New().String())
+	}
+
+	return resp
+}
+
+// EDVClient is a fake EDV client. It creates the vaults with a random ID and records their configurations.
+type EDVClient struct {
+	// CreateErr fails the creation of the vaults if set.
+	CreateErr error
+	mutex     sync.Mutex
+	vaults    []*models.DataVaultConfiguration
+}
+
+// CreateDataVault creates a vault without a capability.
+func (c *EDVClient) CreateDataVault(config *models.DataVaultConfiguration, _ ...sds.ReqOption) (string, []byte,
+	error) {
+	if c.CreateErr != nil {
+		return "", nil, c.CreateErr
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.vaults = append(c.vaults, config)
+
+	return EDVURL + "/" + uuid.New().String(), nil, nil
+}
+
+// Vaults returns the configurations of the vaults created so far, in order.
+func (c *EDVClient) Vaults() []*models.DataVaultConfiguration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]*models.DataVaultConfiguration(nil), c.vaults...)
+}
+
+// NewConfig returns the config of an Operation with its stores in memory and random cookie keys, logging the user
+// of the sub in. The Operation is built with the fakes of its services with the options of Options.
+func NewConfig(sub string) (*oidc.Config, error) {
+	auth, err := randomKey()
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := randomKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidc.Config{
+		OIDCClient: NewOIDCClient(sub),
+		Storage: &oidc.StorageConfig{
+			Storage:          memstore.NewProvider(),
+			TransientStorage: memstore.NewProvider(),
+		},
+		Keys: &oidc.KeyConfig{
+			Auth: auth,
+			Enc:  enc,
+		},
+		KeyServer: &oidc.KeyServerConfig{
+			AuthzKMSURL: KMSURL,
+			OpsKMSURL:   KMSURL,
+			KeyEDVURL:   EDVURL,
+		},
+		UserEDVURL: EDVURL,
+		HubAuthURL: HubAuthURL,
+	}, nil
+}
+
+// Options returns the options of an Operation sending its requests to the fakes of hub-auth, the KMS and EDV
+// servers.
+func Options() []oidc.Option {
+	return []oidc.Option{
+		oidc.WithHTTPClient(&HTTPClient{}),
+		oidc.WithEDVClientFactory(func(string, *http.Client) oidc.EDVClient {
+			return &EDVClient{}
+		}),
+	}
+}
+
+// NewOperation returns an Operation with the config of NewConfig and the fakes of Options, customized by the
+// options.
+func NewOperation(sub string, opts ...oidc.Option) (*oidc.Operation, error) {
+	config, err := NewConfig(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return oidc.New(config, append(Options(), opts...)...)
+}
+
+// Login logs the user of the fake OIDC client of the Operation in, through its login and callback handlers, and
+// returns the cookies of the session of the user.
+func Login(op *oidc.Operation) ([]*http.Cookie, error) {
+	router := mux.NewRouter()
+
+	for _, handler := range op.GetRESTHandlers() {
+		router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+	}
+
+	login := httptest.NewRecorder()
+	router.ServeHTTP(login, httptest.NewRequest(http.MethodGet, loginPath, nil))
+
+	if login.Code != http.StatusFound {
+		return nil, fmt.Errorf("login failed with status %d: %s", login.Code, login.Body.String())
+	}
+
+	redirect, err := login.Result().Location()
+	if err != nil {
+		return nil, fmt.Errorf("invalid login redirect: %w", err)
+	}
+
+	callback := httptest.NewRequest(http.MethodGet,
+		callbackPath+"?code=code&state="+url.QueryEscape(redirect.Query().Get("state")), nil)
+
+	for _, c := range login.Result().Cookies() {
+		callback.AddCookie(c)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, callback)
+
+	if w.Code != http.StatusFound {
+		return nil, fmt.Errorf("login callback failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	return w.Result().Cookies(), nil
+}
+
+func randomKey() ([]byte, error) {
+	key := make([]byte, keySize)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a cookie key: %w", err)
+	}
+
+	return key, nil
+}
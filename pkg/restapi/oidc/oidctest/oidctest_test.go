@@ -0,0 +1,127 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidctest_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edv/pkg/restapi/models"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc/oidctest"
+)
+
+func TestNewOperation(t *testing.T) {
+	t.Run("onboards the user through the fakes", func(t *testing.T) {
+		httpClient := &oidctest.HTTPClient{}
+		userEDV := &oidctest.EDVClient{}
+
+		op, err := oidctest.NewOperation("alice",
+			oidc.WithHTTPClient(httpClient),
+			oidc.WithEDVClientFactory(func(url string, _ *http.Client) oidc.EDVClient {
+				require.Equal(t, oidctest.EDVURL, url)
+
+				return userEDV
+			}),
+		)
+		require.NoError(t, err)
+
+		cookies, err := oidctest.Login(op)
+		require.NoError(t, err)
+		require.NotEmpty(t, cookies)
+
+		require.NotEmpty(t, httpClient.Requests())
+		// the vaults of the user on the key and user EDV servers
+		require.Len(t, userEDV.Vaults(), 2)
+
+		hubAuth := 0
+
+		for _, r := range httpClient.Requests() {
+			if strings.HasPrefix(r.URL.String(), oidctest.HubAuthURL) {
+				hubAuth++
+			}
+		}
+
+		require.NotZero(t, hubAuth)
+	})
+
+	t.Run("fails the login if the onboarding fails", func(t *testing.T) {
+		op, err := oidctest.NewOperation("alice",
+			oidc.WithEDVClientFactory(func(string, *http.Client) oidc.EDVClient {
+				return &oidctest.EDVClient{CreateErr: errors.New("test")}
+			}),
+		)
+		require.NoError(t, err)
+
+		_, err = oidctest.Login(op)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "login callback failed with status 500")
+	})
+}
+
+func TestHTTPClient(t *testing.T) {
+	t.Run("creates the keystores and keys", func(t *testing.T) {
+		c := &oidctest.HTTPClient{}
+
+		resp, err := c.Do(httptest.NewRequest(http.MethodPost, oidctest.KMSURL+"/kms/keystores", nil))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.True(t, strings.HasPrefix(resp.Header.Get("Location"), oidctest.KMSURL+"/kms/keystores/"))
+
+		resp, err = c.Do(httptest.NewRequest(http.MethodGet, oidctest.HubAuthURL+"/bootstrap", nil))
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		require.Len(t, c.Requests(), 2)
+	})
+
+	t.Run("answers with DoFunc", func(t *testing.T) {
+		c := &oidctest.HTTPClient{DoFunc: func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("test")
+		}}
+
+		_, err := c.Do(httptest.NewRequest(http.MethodGet, oidctest.HubAuthURL+"/bootstrap", nil))
+		require.Error(t, err)
+		require.Len(t, c.Requests(), 1)
+	})
+}
+
+func TestEDVClient(t *testing.T) {
+	c := &oidctest.EDVClient{}
+
+	vaultURL, capability, err := c.CreateDataVault(&models.DataVaultConfiguration{ReferenceID: "ref"})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(vaultURL, oidctest.EDVURL+"/"))
+	require.Empty(t, capability)
+	require.Equal(t, "ref", c.Vaults()[0].ReferenceID)
+
+	c.CreateErr = errors.New("test")
+
+	_, _, err = c.CreateDataVault(&models.DataVaultConfiguration{})
+	require.Error(t, err)
+	require.Len(t, c.Vaults(), 1)
+}
+
+func TestOIDCClient(t *testing.T) {
+	c := oidctest.NewOIDCClient("alice")
+	require.Contains(t, c.FormatRequest("123"), "state=123")
+
+	claims := &struct {
+		Sub   string `json:"sub"`
+		Email string `json:"email"`
+	}{}
+	require.NoError(t, c.IDToken.Claims(claims))
+	require.Equal(t, "alice", claims.Sub)
+	require.Equal(t, "alice@example.com", claims.Email)
+}
@@ -0,0 +1,254 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/jobs"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	onboardingStatusPath = "/onboarding/status"
+	onboardingStoreName  = "edgeagent_onboarding"
+)
+
+// Onboarding statuses.
+const (
+	OnboardingPending   = "pending"
+	OnboardingRunning   = "running"
+	OnboardingCompleted = "completed"
+	OnboardingFailed    = "failed"
+)
+
+// OnboardingConfig enables asynchronous onboarding: new users are redirected to the dashboard as soon as they
+// log in while their keystores and vaults are provisioned in the background.
+type OnboardingConfig struct {
+	// Workers is the number of users onboarded at once. Defaults to 4.
+	Workers int
+	// QueueSize is the number of users that may wait for a worker. Defaults to 100.
+	QueueSize int
+}
+
+// OnboardingStatus is the progress of a user's onboarding.
+type OnboardingStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type onboarding struct {
+	queue *jobs.Queue
+	store storage.Store
+	// serializes the check-and-enqueue of concurrent logins of the same user
+	mutex sync.Mutex
+	// the onboardings deferred while the KMS or EDV servers are down, by sub
+	deferred map[string]func()
+}
+
+func newOnboarding(config *OnboardingConfig, transient storage.Provider) (*onboarding, error) {
+	s, err := store.Open(transient, onboardingStoreName)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := jobs.NewQueue(&jobs.Config{
+		Workers:  config.Workers,
+		Capacity: config.QueueSize,
+	})
+	queue.Start()
+
+	return &onboarding{queue: queue, store: s, deferred: make(map[string]func())}, nil
+}
+
+// pause the onboarding jobs, or resume them.
+func (o *onboarding) pause(paused bool) {
+	if paused {
+		o.queue.Pause()
+	} else {
+		o.queue.Resume()
+	}
+}
+
+// enqueueOnboarding schedules the onboarding of a new user, unless it is already under way.
+func (o *Operation) enqueueOnboarding(w http.ResponseWriter, usr *user.User, accessToken string) bool {
+	err := o.scheduleOnboarding(usr, accessToken)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, jobs.ErrQueueFull) || errors.Is(err, jobs.ErrQueueStopped) {
+			status = http.StatusServiceUnavailable
+		}
+
+		common.WriteErrorResponsef(w, logger, status, "%s", err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+func (o *Operation) scheduleOnboarding(usr *user.User, accessToken string) error {
+	o.onboarding.mutex.Lock()
+	defer o.onboarding.mutex.Unlock()
+
+	status, err := o.onboardingStatus(usr.Sub)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to query onboarding status: %w", err)
+	}
+
+	if status != nil && (status.Status == OnboardingPending || status.Status == OnboardingRunning) {
+		return nil
+	}
+
+	err = o.saveOnboardingStatus(usr.Sub, OnboardingPending, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save onboarding status: %w", err)
+	}
+
+	job := func() {
+		o.runOnboarding(usr, accessToken)
+	}
+
+	if down := o.downOnboardingDependency(); down != "" {
+		logger.Infof("deferred the onboarding of user %s until %s is up again", usr.Sub, down)
+		o.onboarding.deferred[usr.Sub] = job
+
+		return nil
+	}
+
+	err = o.onboarding.queue.Enqueue(job)
+	if err != nil {
+		o.recordOnboarding(usr.Sub, OnboardingFailed, err)
+
+		return fmt.Errorf("failed to schedule onboarding: %w", err)
+	}
+
+	return nil
+}
+
+func (o *Operation) runOnboarding(usr *user.User, accessToken string) {
+	o.recordOnboarding(usr.Sub, OnboardingRunning, nil)
+
+	start := time.Now()
+
+	// the onboarding outlives the login request of the user
+	err := o.provisionUser(context.Background(), usr, accessToken)
+	if err != nil {
+		logger.Errorf("failed to onboard user %s: %s", usr.Sub, err.Error())
+		o.recordOnboarding(usr.Sub, OnboardingFailed, err)
+
+		return
+	}
+
+	logger.Infof("onboarded user %s in %s", usr.Sub, time.Since(start))
+	o.recordOnboarding(usr.Sub, OnboardingCompleted, nil)
+}
+
+func (o *Operation) onboardingStatusHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling onboarding status request")
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusForbidden, "not logged in")
+
+		return
+	}
+
+	userSub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return
+	}
+
+	status, err := o.onboardingStatus(userSub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		// users onboarded synchronously, or before a restart, have no status record
+		status, err = o.onboardedStatus(userSub)
+	}
+
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusNotFound, "user is not onboarded")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to query onboarding status: %s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, status)
+}
+
+func (o *Operation) onboardingStatus(sub string) (*OnboardingStatus, error) {
+	if o.onboarding == nil {
+		return nil, storage.ErrValueNotFound
+	}
+
+	bits, err := o.onboarding.store.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &OnboardingStatus{}
+
+	err = json.Unmarshal(bits, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal onboarding status: %w", err)
+	}
+
+	return status, nil
+}
+
+func (o *Operation) onboardedStatus(sub string) (*OnboardingStatus, error) {
+	_, err := o.store.users.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OnboardingStatus{Status: OnboardingCompleted}, nil
+}
+
+func (o *Operation) saveOnboardingStatus(sub, status string, cause error) error {
+	s := &OnboardingStatus{Status: status}
+
+	if cause != nil {
+		s.Error = cause.Error()
+	}
+
+	return store.Save(o.onboarding.store, sub, s)
+}
+
+func (o *Operation) recordOnboarding(sub, status string, cause error) {
+	err := o.saveOnboardingStatus(sub, status, cause)
+	if err != nil {
+		logger.Errorf("failed to save onboarding status of user %s: %s", sub, err.Error())
+	}
+}
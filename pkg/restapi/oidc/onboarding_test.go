@@ -0,0 +1,431 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/maintenance"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestOperation_AsyncOnboarding(t *testing.T) {
+	t.Run("redirects new users and onboards them in the background", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupAsyncOnboardingTest(t, state)
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "http://test.com/dashboard", w.Header().Get("Location"))
+
+		status := waitForOnboarding(t, o)
+		require.Equal(t, OnboardingCompleted, status.Status)
+		require.Empty(t, status.Error)
+
+		sub, ok := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.True(t, ok)
+
+		usr, err := o.store.users.Get(sub.(string))
+		require.NoError(t, err)
+		require.NotEmpty(t, usr.SecretShare)
+	})
+
+	t.Run("pauses the onboarding under maintenance", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupAsyncOnboardingTest(t, state)
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		mode, err := maintenance.New(&maintenance.Config{Storage: memstore.NewProvider()})
+		require.NoError(t, err)
+		require.NoError(t, mode.Set(&maintenance.Mode{Enabled: true}))
+
+		mode.OnChange(o.onboarding.pause)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		sub, ok := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.True(t, ok)
+
+		time.Sleep(50 * time.Millisecond)
+
+		status, err := o.onboardingStatus(sub.(string))
+		require.NoError(t, err)
+		require.Equal(t, OnboardingPending, status.Status)
+
+		require.NoError(t, mode.Set(&maintenance.Mode{}))
+		require.Equal(t, OnboardingCompleted, waitForOnboarding(t, o).Status)
+	})
+
+	t.Run("reports failed onboarding", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupAsyncOnboardingTest(t, state)
+		o.secretSplitter = &mockSplitter{SplitErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		status := waitForOnboarding(t, o)
+		require.Equal(t, OnboardingFailed, status.Status)
+		require.Contains(t, status.Error, "split user secret key")
+	})
+
+	t.Run("does not onboard the user twice", func(t *testing.T) {
+		o := setupAsyncOnboardingTest(t, uuid.New().String())
+		o.onboarding.queue.Stop()
+
+		usr := &user.User{Sub: uuid.New().String()}
+		require.NoError(t, o.saveOnboardingStatus(usr.Sub, OnboardingRunning, nil))
+
+		w := httptest.NewRecorder()
+		require.True(t, o.enqueueOnboarding(w, usr, "token"))
+
+		status, err := o.onboardingStatus(usr.Sub)
+		require.NoError(t, err)
+		require.Equal(t, OnboardingRunning, status.Status)
+	})
+
+	t.Run("error if onboarding cannot be scheduled", func(t *testing.T) {
+		o := setupAsyncOnboardingTest(t, uuid.New().String())
+		o.onboarding.queue.Stop()
+
+		usr := &user.User{Sub: uuid.New().String()}
+
+		w := httptest.NewRecorder()
+		require.False(t, o.enqueueOnboarding(w, usr, "token"))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Contains(t, w.Body.String(), "failed to schedule onboarding")
+
+		status, err := o.onboardingStatus(usr.Sub)
+		require.NoError(t, err)
+		require.Equal(t, OnboardingFailed, status.Status)
+	})
+
+	t.Run("error if onboarding status cannot be saved", func(t *testing.T) {
+		o := setupAsyncOnboardingTest(t, uuid.New().String())
+		o.onboarding.store = &mockstore.MockStore{Store: map[string][]byte{}, ErrPut: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		require.False(t, o.enqueueOnboarding(w, &user.User{Sub: uuid.New().String()}, "token"))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save onboarding status")
+	})
+
+	t.Run("error if onboarding status cannot be queried", func(t *testing.T) {
+		o := setupAsyncOnboardingTest(t, uuid.New().String())
+		sub := uuid.New().String()
+		o.onboarding.store = &mockstore.MockStore{
+			Store:  map[string][]byte{sub: []byte("{}")},
+			ErrGet: errors.New("test"),
+		}
+
+		w := httptest.NewRecorder()
+		require.False(t, o.enqueueOnboarding(w, &user.User{Sub: sub}, "token"))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to query onboarding status")
+	})
+
+	t.Run("error if onboarding store cannot be opened", func(t *testing.T) {
+		config := config(t)
+		config.Onboarding = &OnboardingConfig{}
+		config.Storage.TransientStorage = &mockstore.Provider{
+			Store:         &mockstore.MockStore{Store: map[string][]byte{}},
+			FailNameSpace: onboardingStoreName,
+		}
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open onboarding store")
+	})
+}
+
+func TestOperation_OnboardingTimeout(t *testing.T) {
+	// hangs until the onboarding step is cancelled
+	hanging := &mockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+
+			return nil, req.Context().Err()
+		},
+	}
+
+	t.Run("onboards new users within the step timeout", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupOnboardingTest(t, state)
+		o.onboardingStep = time.Minute
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+	})
+
+	t.Run("error if an onboarding step times out", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupOnboardingTest(t, state)
+		o.onboardingStep = 10 * time.Millisecond
+		o.httpClient = hanging
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusGatewayTimeout, w.Code)
+		require.Contains(t, w.Body.String(), context.DeadlineExceeded.Error())
+	})
+
+	t.Run("aborts the onboarding once the client disconnects", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupOnboardingTest(t, state)
+		o.httpClient = hanging
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state).WithContext(ctx))
+		require.Empty(t, w.Body.String())
+
+		_, found := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.False(t, found)
+	})
+}
+
+func TestOperation_ConcurrentOnboarding(t *testing.T) {
+	t.Run("onboards the user of concurrent logins once", func(t *testing.T) {
+		o := setupOnboardingTest(t, uuid.New().String())
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		var keystores int32
+
+		kms := mockKMSHTTPClient()
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodPost && req.URL.Path == hubKMSCreateKeyStorePath {
+				atomic.AddInt32(&keystores, 1)
+			}
+
+			return kms.Do(req)
+		}}
+
+		sub := uuid.New().String()
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				require.NoError(t, o.provisionUser(context.Background(), &user.User{Sub: sub}, uuid.New().String()))
+			}()
+		}
+
+		wg.Wait()
+
+		// the authz and ops keystores of a single onboarding
+		require.Equal(t, int32(2), atomic.LoadInt32(&keystores))
+
+		_, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+	})
+
+	t.Run("onboards the user once the concurrent login failed", func(t *testing.T) {
+		o := setupOnboardingTest(t, uuid.New().String())
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		sub := uuid.New().String()
+
+		release, err := o.onboardingLock.Lock(context.Background(), o.pseudonyms.ID(sub))
+		require.NoError(t, err)
+
+		done := make(chan error)
+
+		go func() {
+			done <- o.provisionUser(context.Background(), &user.User{Sub: sub}, uuid.New().String())
+		}()
+
+		select {
+		case <-done:
+			require.Fail(t, "onboarded the user while the concurrent login held the lock")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		release()
+		require.NoError(t, <-done)
+
+		_, err = o.store.users.Get(sub)
+		require.NoError(t, err)
+	})
+
+	t.Run("error if the concurrent login does not finish before the request", func(t *testing.T) {
+		o := setupOnboardingTest(t, uuid.New().String())
+		sub := uuid.New().String()
+
+		release, err := o.onboardingLock.Lock(context.Background(), o.pseudonyms.ID(sub))
+		require.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err = o.provisionUser(ctx, &user.User{Sub: sub}, uuid.New().String())
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		require.Contains(t, err.Error(), "failed to lock the onboarding of the user")
+	})
+}
+
+func TestOperation_OnboardingStatusHandler(t *testing.T) {
+	t.Run("returns the status of onboarding users", func(t *testing.T) {
+		o := setupAsyncOnboardingTest(t, uuid.New().String())
+		sub := loginAs(o, uuid.New().String())
+
+		require.NoError(t, o.saveOnboardingStatus(sub, OnboardingFailed, errors.New("test")))
+
+		w := httptest.NewRecorder()
+		o.onboardingStatusHandler(w, newOnboardingStatusRequest())
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"status": "failed", "error": "test"}`, w.Body.String())
+	})
+
+	t.Run("reports onboarded users as completed", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		sub := loginAs(o, uuid.New().String())
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub}))
+
+		w := httptest.NewRecorder()
+		o.onboardingStatusHandler(w, newOnboardingStatusRequest())
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"status": "completed"}`, w.Body.String())
+	})
+
+	t.Run("err notfound if user is not onboarded", func(t *testing.T) {
+		o := setupAsyncOnboardingTest(t, uuid.New().String())
+		loginAs(o, uuid.New().String())
+
+		w := httptest.NewRecorder()
+		o.onboardingStatusHandler(w, newOnboardingStatusRequest())
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "user is not onboarded")
+	})
+
+	t.Run("err internalservererror if status cannot be queried", func(t *testing.T) {
+		o := setupAsyncOnboardingTest(t, uuid.New().String())
+		sub := loginAs(o, uuid.New().String())
+		o.onboarding.store = &mockstore.MockStore{Store: map[string][]byte{sub: []byte("{")}}
+
+		w := httptest.NewRecorder()
+		o.onboardingStatusHandler(w, newOnboardingStatusRequest())
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to query onboarding status")
+	})
+
+	t.Run("err badrequest if cannot open cookies", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		o.store.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.onboardingStatusHandler(w, newOnboardingStatusRequest())
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "cannot open cookies")
+	})
+
+	t.Run("err forbidden if user cookie is not set", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.onboardingStatusHandler(w, newOnboardingStatusRequest())
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "not logged in")
+	})
+
+	t.Run("err internalservererror if cookie is not a string", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: 78},
+		}}
+
+		w := httptest.NewRecorder()
+		o.onboardingStatusHandler(w, newOnboardingStatusRequest())
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "invalid user sub cookie format")
+	})
+}
+
+func setupAsyncOnboardingTest(t *testing.T, state string) *Operation {
+	t.Helper()
+
+	o := setupOnboardingTest(t, state)
+
+	var err error
+
+	o.onboarding, err = newOnboarding(&OnboardingConfig{Workers: 1}, memstore.NewProvider())
+	require.NoError(t, err)
+
+	t.Cleanup(o.Close)
+
+	return o
+}
+
+func loginAs(o *Operation, sub string) string {
+	o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+		Cookies: map[interface{}]interface{}{userSubCookieName: sub},
+	}}
+
+	return sub
+}
+
+// waitForOnboarding polls the status endpoint until the onboarding of the logged in user is over.
+func waitForOnboarding(t *testing.T, o *Operation) *OnboardingStatus {
+	t.Helper()
+
+	status := &OnboardingStatus{}
+
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		o.onboardingStatusHandler(w, newOnboardingStatusRequest())
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(status))
+
+		return status.Status == OnboardingCompleted || status.Status == OnboardingFailed
+	}, time.Second, 10*time.Millisecond)
+
+	return status
+}
+
+func newOnboardingStatusRequest() *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/oidc/onboarding/status", nil)
+}
@@ -15,29 +15,49 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite/ed25519signature2018"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
-	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/binding"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/claims"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/escrow"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/faults"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/features"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/health"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/jobs"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/maintenance"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/mtls"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/proxy"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/lock"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/tokenexchange"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/zcapsig"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"github.com/trustbloc/edge-core/pkg/sss"
 	"github.com/trustbloc/edge-core/pkg/sss/base"
 	"github.com/trustbloc/edge-core/pkg/storage"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
-	"github.com/trustbloc/edv/pkg/client"
 	"github.com/trustbloc/edv/pkg/restapi/models"
+	"github.com/xeipuuv/gojsonschema"
 	"golang.org/x/oauth2"
+	"golang.org/x/sync/errgroup"
 )
 
 // Endpoints.
@@ -66,28 +86,146 @@ const (
 	signEndpoint             = "/kms/keystores/%s/keys/%s/sign"
 )
 
+// hub-kms zcap invocations.
+const (
+	// kmsCapabilityHeader holds the base64URL-encoded root capability of a keystore secured with zcaps.
+	kmsCapabilityHeader       = "X-Rootcapability"
+	createKeyAction           = "createKey"
+	updateEDVCapabilityAction = "updateEDVCapability"
+)
+
 const (
 	edvResource = "urn:edv:vault"
+	// number of users whose opened vault is kept in memory between wallet operations.
+	vaultCacheSize = 1000
 )
 
 var logger = log.New("hub-auth/oidc")
 
 // Config holds all configuration for an Operation.
 type Config struct {
-	OIDCClient      oidc.Client
-	Storage         *StorageConfig
+	OIDCClient oidc.Client
+	Storage    *StorageConfig
+	// Tokens keeps the access and refresh tokens of the users, in a dedicated secrets service. They are kept in
+	// the Storage provider if nil.
+	Tokens          tokens.Store
 	WalletDashboard string
 	TLSConfig       *tls.Config
-	Keys            *KeyConfig
-	KeyServer       *KeyServerConfig
-	UserEDVURL      string
-	HubAuthURL      string
+	// ClientCertificate is presented to the hub-auth, KMS and EDV servers requiring mutual TLS.
+	// It is called on each handshake, so that a rotated certificate is picked up without a restart.
+	ClientCertificate mtls.ClientCertificateFunc
+	// Proxy routes the requests to the OIDC provider, hub-auth, KMS and EDV servers through an outbound proxy.
+	// The requests to the hub-auth, KMS and EDV servers go through the proxy of the environment if not set.
+	Proxy        proxy.Func
+	Keys         *KeyConfig
+	KeyServer    *KeyServerConfig
+	UserEDVURL   string
+	HubAuthURL   string
+	Events       *EventsConfig
+	ClaimsMapper claims.Mapper
+	// ClaimsFilter selects and renames the user info claims sent to the wallet UI. All claims are sent if nil.
+	ClaimsFilter *claims.Filter
+	Onboarding   *OnboardingConfig
+	Consent      *ConsentConfig
+	// Audit records the logins, logouts and onboarding steps of the users. Auditing is disabled if nil.
+	Audit *audit.Store
+	// UserInfoCache caches the user info claims of the users. They are fetched from the provider on each request
+	// if nil.
+	UserInfoCache *UserInfoCacheConfig
+	// KeystoreCache caches the keystore metadata of the users. It is fetched from hub-auth before each of the KMS
+	// calls of the agent if nil.
+	KeystoreCache *KeystoreCacheConfig
+	// OnboardingStepTimeout bounds each call of the onboarding of a new user to the hub-auth, KMS and EDV servers.
+	// The calls are only bounded by the login request of the user if zero.
+	OnboardingStepTimeout time.Duration
+	// OnboardingLockTTL is how long the onboarding of a user is locked for at most by an instance of the agent
+	// that crashed while onboarding the user. The logins of a new user waiting for the lock reuse the user
+	// onboarded by the login holding it. Defaults to 5m.
+	OnboardingLockTTL time.Duration
+	// ProvisioningPool provisions the keystores and vaults of new users ahead of their first login. Users are
+	// provisioned on demand if nil.
+	ProvisioningPool *ProvisioningPoolConfig
+	// OnboardingTemplates choose the resources provisioned for new users, per tenant. The users are provisioned
+	// with the resources of the deployment if nil.
+	OnboardingTemplates *OnboardingTemplates
+	// Janitor deletes the expired transient records and the tokens of deleted users. Nothing is deleted if nil.
+	Janitor *JanitorConfig
+	// StepUp makes the sensitive operations require a recent authentication of a high assurance level. Any
+	// session is accepted if nil.
+	StepUp *StepUpConfig
+	// LoginParams are the parameters of the authentication requests, among login_hint, prompt, ui_locales and
+	// max_age, the wallet UI may pass to /login for the agent to forward them to the OIDC provider. The requests
+	// passing the others are rejected.
+	LoginParams []string
+	// Remember keeps the users who ask for it at login logged in past the expiry of their session, with rotating
+	// remember-me tokens. Users are not remembered if nil.
+	Remember *remember.Store
+	// Lockout locks the clients and users with repeated failed logins out of the login. Failed logins are not
+	// tracked if nil.
+	Lockout *LockoutConfig
+	// TokenExchange trades the access tokens sent to hub-auth and hub-kms for tokens of their audience, for
+	// providers issuing opaque access tokens. The access tokens are sent as is if nil.
+	TokenExchange *TokenExchangeConfig
+	// KeyRotation rotates the keys of the users' EDV vaults on request of the admins, and on a schedule. The keys
+	// are never rotated if nil.
+	KeyRotation *KeyRotationConfig
+	// OnboardingHooks provision the deployment-specific resources of new users. They are called in order.
+	OnboardingHooks []OnboardingHook
+	// SessionBinding binds the sessions of the users to their client when they log in. The sessions are not
+	// bound if nil.
+	SessionBinding *binding.Binder
+	// Pseudonyms replace the subs of the users with pseudonymous IDs in the stores of the agent and in their
+	// keystores, which are controlled by the IDs. The subs are used as is if nil.
+	Pseudonyms *pseudonym.Mapper
+	// SIOP lets the users log in with a DID of their wallet as well as with the OIDC provider. The users only log
+	// in with the provider if nil.
+	SIOP *SIOPConfig
+	// Health tells which of the dependencies of the agent are down, for the agent to degrade gracefully: the
+	// onboarding of the new users is deferred and the user info is served from the cache until they are up again.
+	// The dependencies are assumed up if nil.
+	Health *health.Watcher
+	// Quotas bound the documents and keys the agent creates for each user, whose usage is tracked either way. The
+	// users are unbounded if nil.
+	Quotas *QuotaConfig
+	// BootstrapSigning signs the bootstrap data of the users and verifies it when it is read back from hub-auth.
+	// The bootstrap data is only versioned if nil.
+	BootstrapSigning *BootstrapSigningConfig
+	// UserDirectory resolves the profiles of the users from the directory of an enterprise, e.g. an LDAP server
+	// or a SCIM endpoint, and caches them in the users store. The profiles come from the claims of the OIDC
+	// provider if nil.
+	UserDirectory *user.ReadThroughConfig
+	// Features gates the device login with the DeviceLogin flag, evaluated for the tenant of the claims of the
+	// users. The device login is enabled if nil.
+	Features *features.Service
+	// Faults injects latency, 5xx responses and connection resets into the requests to the hub-auth, KMS and EDV
+	// servers, to test the resilience of the onboarding in staging. No fault is injected if nil.
+	Faults *faults.Config
+	// Maintenance refuses the logins while the agent is under maintenance, and pauses the onboarding of the new
+	// users and the refills of the provisioning pool. The agent is never under maintenance if nil.
+	Maintenance *maintenance.Service
+	// Escrow deposits shares of the agent's share of the secrets of the users with escrow services, for the agent
+	// to recover it if lost. The shares are re-issued when the escrows change. The share is not escrowed if nil.
+	Escrow *escrow.Service
+	// IssuerKeys are the signing keys of the trusted issuers of the id_tokens, by issuer, which the admin API
+	// serves the state of and refreshes on request. They are not administered if nil.
+	IssuerKeys map[string]*oidc.KeySet
+}
+
+// TokenExchangeConfig configures the token exchange (RFC 8693) of the access tokens sent to hub-auth and hub-kms.
+type TokenExchangeConfig struct {
+	Exchanger tokenexchange.Exchanger
+	// HubAuthAudience is the audience of the tokens of hub-auth. The tokens are sent as is if empty.
+	HubAuthAudience string
+	// KMSAudience is the audience of the tokens of the authz and ops KMS. The tokens are sent as is if empty.
+	KMSAudience string
 }
 
 // KeyConfig holds configuration for cryptographic keys.
 type KeyConfig struct {
 	Auth []byte
 	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
 }
 
 // StorageConfig holds storage config.
@@ -96,6 +234,17 @@ type StorageConfig struct {
 	TransientStorage storage.Provider
 }
 
+// EventsConfig holds configuration for the delivery of lifecycle events.
+type EventsConfig struct {
+	Dispatcher    outbox.Dispatcher
+	RelayInterval time.Duration
+	// Outbox holds the events until they are delivered, shared with the handlers of other packages enqueuing their
+	// events. It is opened in the storage if nil.
+	Outbox *outbox.Store
+	// MaxAttempts is the number of failed deliveries after which an event is dead. Defaults to that of the relay.
+	MaxAttempts int
+}
+
 // KeyServerConfig holds configuration for key management server.
 type KeyServerConfig struct {
 	AuthzKMSURL string
@@ -103,53 +252,172 @@ type KeyServerConfig struct {
 	KeyEDVURL   string
 }
 
-type httpClient interface {
+// HTTPClient sends the requests of the Operation to hub-auth and the KMS servers.
+type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-type edvClient interface {
-	CreateDataVault(config *models.DataVaultConfiguration, opts ...client.ReqOption) (string, []byte, error)
+// EDVClient creates the EDV vaults of the users.
+type EDVClient interface {
+	CreateDataVault(config *models.DataVaultConfiguration, opts ...sds.ReqOption) (string, []byte, error)
 }
 
 type stores struct {
-	users     *user.Store
-	tokens    *tokens.Store
+	users     user.Store
+	tokens    tokens.Store
 	transient storage.Store
 	cookies   cookie.Store
+	outbox    *outbox.Store
+	// round-trips the records of the validation of the storage
+	validation storage.Store
 }
 
 // Operation implements OIDC operations.
 type Operation struct {
 	store           *stores
+	cookieKeys      *cookie.KeyRing
 	oidcClient      oidc.Client
 	walletDashboard string
+	dashboardMutex  sync.RWMutex
 	tlsConfig       *tls.Config
+	proxy           proxy.Func
 	secretSplitter  sss.SecretSplitter
-	httpClient      httpClient
-	keyEDVClient    edvClient
+	httpClient      HTTPClient
+	kmsHTTPClient   *http.Client
+	keyEDVClient    EDVClient
 	keyServer       *KeyServerConfig
-	userEDVClient   edvClient
+	userEDVURL      string
+	userEDVClient   EDVClient
 	hubAuthURL      string
+	relay           *outbox.Relay
+	claimsMapper    claims.Mapper
+	claimsFilter    *claims.Filter
+	openVault       vaultOpener
+	openIndex       vaultIndexOpener
+	chunkSize       int
+	vaults          *sds.Cache
+	onboarding      *onboarding
+	onboardingStep  time.Duration
+	onboardingLock  *lock.Locker
+	pool            *provisioningPool
+	onboardingPlan  *onboardingPlan
+	tenantPlans     map[string]*onboardingPlan
+	consent         *ConsentConfig
+	auditLog        *audit.Store
+	userInfoCache   *UserInfoCacheConfig
+	keystoreCache   *KeystoreCacheConfig
+	keystoreMemory  *sds.Cache
+	exports         *jobs.Queue
+	janitor         *janitor
+	transientTTL    time.Duration
+	stepUp          *StepUpConfig
+	remember        *remember.Store
+	lockouts        *lockouts
+	onboardingHooks []OnboardingHook
+	sessionBinding  *binding.Binder
+	pseudonyms      *pseudonym.Mapper
+	rotations       *rotations
+	createVaultKeys vaultKeyCreator
+	deriveBackupKey backupKeyDeriver
+	walletSources   []WalletSource
+	siop            *SIOPConfig
+	health          *health.Watcher
+	// validates the preferences of the users
+	preferencesSchema *gojsonschema.Schema
+	usage             *usage
+	bootstrapSigning  *BootstrapSigningConfig
+	loginParams       loginParams
+	newEDVClient      EDVClientFactory
+	now               func() time.Time
+	flags             *features.Service
+	maintenance       *maintenance.Service
+	escrow            *escrow.Service
+	issuerKeys        map[string]*oidc.KeySet
+	random            io.Reader
 }
 
-// New returns a new Operation.
-func New(config *Config) (*Operation, error) {
+// New returns a new Operation, customized by the options.
+func New(config *Config, opts ...Option) (*Operation, error) {
+	serverTLSConfig := config.TLSConfig
+	if config.ClientCertificate != nil {
+		serverTLSConfig = mtls.WithClientCertificate(config.TLSConfig, config.ClientCertificate)
+	}
+
+	// one connection pool for the hub-auth, KMS and EDV servers, so that onboarding does not
+	// pay for a TLS handshake on each of its calls
+	sharedHTTPClient := sds.NewHTTPClient(serverTLSConfig, config.Proxy)
+
+	if config.Faults != nil {
+		// the faults are injected on the wire, past the signing and token exchange of the requests
+		sharedHTTPClient.Transport = faults.NewTransport(sharedHTTPClient.Transport, config.Faults)
+	}
+
+	// the requests carrying zcap invocations of the KMS and EDV servers are signed on their way out
+	sharedHTTPClient.Transport = zcapsig.NewTransport(sharedHTTPClient.Transport)
+
+	if config.TokenExchange != nil {
+		sharedHTTPClient.Transport = tokenexchange.NewTransport(sharedHTTPClient.Transport,
+			config.TokenExchange.Exchanger, exchangedServices(config))
+	}
+
+	cookieKeys := config.Keys.Ring
+	if cookieKeys == nil {
+		cookieKeys = cookie.NewKeyRing(config.Keys.Auth, config.Keys.Enc)
+	}
+
 	op := &Operation{
 		oidcClient: config.OIDCClient,
 		store: &stores{
-			cookies: cookie.NewStore(config.Keys.Auth, config.Keys.Enc),
+			cookies: cookie.NewStore(nil, nil, cookie.WithKeyRing(cookieKeys)),
 		},
+		cookieKeys:      cookieKeys,
+		userEDVURL:      config.UserEDVURL,
 		walletDashboard: config.WalletDashboard,
 		tlsConfig:       config.TLSConfig,
+		proxy:           config.Proxy,
 		secretSplitter:  &base.Splitter{},
-		httpClient:      &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLSConfig}},
-		keyEDVClient: client.New(
-			config.KeyServer.KeyEDVURL,
-			client.WithTLSConfig(config.TLSConfig),
-		),
-		keyServer:  config.KeyServer,
-		hubAuthURL: config.HubAuthURL,
-	}
+		httpClient:      sharedHTTPClient,
+		kmsHTTPClient:   sharedHTTPClient,
+		keyServer:       config.KeyServer,
+		hubAuthURL:      config.HubAuthURL,
+		claimsMapper:    config.ClaimsMapper,
+		claimsFilter:    config.ClaimsFilter,
+		vaults:          sds.NewCache(vaultCacheSize),
+		consent:         config.Consent,
+		auditLog:        config.Audit,
+		userInfoCache:   config.UserInfoCache,
+		keystoreCache:   config.KeystoreCache,
+		keystoreMemory:  sds.NewCache(keystoreCacheSize),
+		onboardingStep:  config.OnboardingStepTimeout,
+		exports:         newExportQueue(),
+		transientTTL:    defaultTransientTTL,
+		stepUp:          config.StepUp,
+		remember:        config.Remember,
+		onboardingHooks: config.OnboardingHooks,
+		sessionBinding:  config.SessionBinding,
+		pseudonyms:      config.Pseudonyms,
+		maintenance:     config.Maintenance,
+		escrow:          config.Escrow,
+		issuerKeys:      config.IssuerKeys,
+		chunkSize:       defaultAttachmentChunkSize,
+		siop:            config.SIOP,
+		flags:           config.Features,
+		health:          config.Health,
+		newEDVClient:    newEDVClient,
+		now:             time.Now,
+		random:          rand.Reader,
+	}
+
+	for _, opt := range opts {
+		opt(op)
+	}
+
+	op.keyEDVClient = op.newEDVClient(config.KeyServer.KeyEDVURL, sharedHTTPClient)
+
+	op.openVault = op.openUserVault
+	op.openIndex = op.openUserVaultIndex
+	op.createVaultKeys = op.createUserVaultKeys
+	op.deriveBackupKey = op.deriveUserBackupKey
 
 	var err error
 
@@ -158,39 +426,332 @@ func New(config *Config) (*Operation, error) {
 		return nil, fmt.Errorf("failed to open transient store: %w", err)
 	}
 
-	op.store.users, err = user.NewStore(config.Storage.Storage)
+	op.store.validation, err = store.Open(config.Storage.Storage, validationStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open validation store: %w", err)
+	}
+
+	// the janitor lists the transient records while the handlers write them
+	op.store.transient = store.Locked(op.store.transient)
+	// the locks expire with the other transient records, deleted by the janitor
+	op.onboardingLock = lock.New(op.store.transient, &lock.Config{TTL: config.OnboardingLockTTL})
+
+	op.preferencesSchema, err = gojsonschema.NewSchema(gojsonschema.NewStringLoader(preferencesSchema))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile preferences schema: %w", err)
+	}
+
+	op.store.users, err = user.NewStore(config.Storage.Storage, user.WithPseudonyms(config.Pseudonyms))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open users store: %w", err)
 	}
 
-	op.store.tokens, err = tokens.NewStore(config.Storage.Storage)
+	if config.UserDirectory != nil {
+		directory := *config.UserDirectory
+		if directory.Now == nil {
+			directory.Now = op.now
+		}
+
+		op.store.users = user.NewReadThroughStore(op.store.users, &directory)
+	}
+
+	op.store.tokens = config.Tokens
+
+	if op.store.tokens == nil {
+		op.store.tokens, err = tokens.NewStore(config.Storage.Storage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open tokens store: %w", err)
+		}
+	}
+
+	op.store.tokens = tokens.WithPseudonyms(op.store.tokens, config.Pseudonyms)
+
+	op.bootstrapSigning = config.BootstrapSigning
+
+	op.loginParams, err = newLoginParams(config.LoginParams)
+	if err != nil {
+		return nil, err
+	}
+
+	op.usage, err = newUsage(config.Quotas, config.Storage.Storage, config.Pseudonyms)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open tokens store: %w", err)
+		return nil, fmt.Errorf("failed to open usage store: %w", err)
 	}
 
 	if config.UserEDVURL != "" {
-		op.userEDVClient = client.New(
-			config.UserEDVURL,
-			client.WithTLSConfig(config.TLSConfig),
-		)
+		op.userEDVClient = op.newEDVClient(config.UserEDVURL, sharedHTTPClient)
+	}
+
+	err = op.newOnboardingPlans(config.OnboardingTemplates)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Onboarding != nil {
+		op.onboarding, err = newOnboarding(config.Onboarding, config.Storage.TransientStorage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open onboarding store: %w", err)
+		}
+
+		// the users onboarded under maintenance wait for its end
+		config.Maintenance.OnChange(op.onboarding.pause)
+	}
+
+	if config.Events != nil {
+		op.store.outbox = config.Events.Outbox
+
+		if op.store.outbox == nil {
+			op.store.outbox, err = outbox.NewStore(config.Storage.Storage)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open outbox store: %w", err)
+			}
+		}
+
+		op.relay = outbox.NewRelay(&outbox.RelayConfig{
+			Store:       op.store.outbox,
+			Dispatcher:  config.Events.Dispatcher,
+			Confirm:     op.confirmEvent,
+			Interval:    config.Events.RelayInterval,
+			MaxAttempts: config.Events.MaxAttempts,
+		})
+		op.relay.Start()
+	}
+
+	if config.ProvisioningPool != nil {
+		op.pool, err = newProvisioningPool(config.ProvisioningPool, config.Storage.Storage, op.provisionSet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open provisioning pool store: %w", err)
+		}
+
+		op.pool.paused = config.Maintenance.Enabled
+
+		op.pool.Start()
+	}
+
+	if config.Janitor != nil {
+		if config.Janitor.TransientTTL > 0 {
+			op.transientTTL = config.Janitor.TransientTTL
+		}
+
+		op.janitor = newJanitor(config.Janitor, op.store, op.onboardingUnderWay, op.now)
+		op.janitor.Start()
+	}
+
+	if config.KeyRotation != nil {
+		op.rotations, err = newRotations(config.KeyRotation, config.Storage.Storage, config.Pseudonyms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open vault rotations store: %w", err)
+		}
+
+		op.rotations.schedule(op.rotateDueVaults)
+	}
+
+	if config.Lockout != nil {
+		op.lockouts = newLockouts(config.Lockout, op.store.transient)
+		op.lockouts.now = op.now
+	}
+
+	if config.Health != nil {
+		config.Health.OnChange(op.dependencyChanged)
 	}
 
 	return op, nil
 }
 
+// exchangedServices returns the services whose access tokens are exchanged for tokens of their audience. Both
+// hub-auth and hub-kms take the tokens base64-encoded.
+func exchangedServices(config *Config) tokenexchange.Services {
+	services := tokenexchange.Services{}
+
+	for baseURL, audience := range map[string]string{
+		config.HubAuthURL:            config.TokenExchange.HubAuthAudience,
+		config.KeyServer.AuthzKMSURL: config.TokenExchange.KMSAudience,
+		config.KeyServer.OpsKMSURL:   config.TokenExchange.KMSAudience,
+	} {
+		if baseURL != "" && audience != "" {
+			services[baseURL] = &tokenexchange.Service{Audience: audience, Encoded: true}
+		}
+	}
+
+	return services
+}
+
+// Close stops the Operation's background workers.
+func (o *Operation) Close() {
+	o.exports.Stop()
+
+	if o.onboarding != nil {
+		o.onboarding.queue.Stop()
+	}
+
+	if o.relay != nil {
+		o.relay.Stop()
+	}
+
+	if o.pool != nil {
+		o.pool.Stop()
+	}
+
+	if o.janitor != nil {
+		o.janitor.Stop()
+	}
+
+	if o.rotations != nil {
+		o.rotations.Stop()
+	}
+}
+
 // GetRESTHandlers get all controller API handler available for this service.
-func (o *Operation) GetRESTHandlers() []common.Handler {
-	return []common.Handler{
-		common.NewHTTPHandler(oidcLoginPath, http.MethodGet, o.oidcLoginHandler),
-		common.NewHTTPHandler(oidcCallbackPath, http.MethodGet, o.oidcCallbackHandler),
-		common.NewHTTPHandler(oidcUserInfoPath, http.MethodGet, o.userProfileHandler),
-		common.NewHTTPHandler(logoutPath, http.MethodGet, o.userLogoutHandler),
+func (o *Operation) GetRESTHandlers() []common.Handler { // nolint:funlen // one spec per handler
+	handlers := []common.Handler{
+		common.NewHTTPHandler(oidcLoginPath, http.MethodGet, o.oidcLoginHandler, &common.OperationSpec{
+			Summary: "Redirects the browser to the OIDC provider to log in, or to the wallet if logged in already.",
+			Params: []common.Param{
+				common.QueryParam(rememberParam, "Set to true to keep the user logged in past the session."),
+				common.QueryParam(LoginHintParam, "Hint of the identifier of the user, e.g. the email, if allowed."),
+				common.QueryParam(PromptParam, "Space separated prompts of the OIDC provider, if allowed."+
+					" The users logged in already log in again with login."),
+				common.QueryParam(UILocalesParam, "Space separated preferred languages of the user, if allowed."),
+				common.QueryParam(MaxAgeParam, "Seconds since the user last authenticated, if allowed."+
+					" The users logged in already log in again."),
+			},
+			Responses: map[int]interface{}{
+				http.StatusFound:            nil,
+				http.StatusMovedPermanently: nil,
+				http.StatusBadRequest:       nil,
+			},
+		}),
+		common.NewHTTPHandler(oidcCallbackPath, http.MethodGet, o.oidcCallbackHandler, &common.OperationSpec{
+			Summary: "Completes the login with the authorization code of the OIDC provider and redirects to the wallet.",
+			Params: []common.Param{
+				common.QueryParam("state", "State of the login request."),
+				common.QueryParam("code", "Authorization code."),
+			},
+			Responses: map[int]interface{}{http.StatusFound: nil},
+		}),
+		common.NewHTTPHandler(deviceLoginPath, http.MethodPost, o.deviceLoginHandler, &common.OperationSpec{
+			Summary:   "Starts the login of a device without a browser.",
+			Responses: map[int]interface{}{http.StatusOK: &oidc.DeviceAuthorization{}},
+		}),
+		common.NewHTTPHandler(deviceTokenPath, http.MethodPost, o.deviceTokenHandler, &common.OperationSpec{
+			Summary: "Polls the login of a device.",
+			Request: &DeviceTokenRequest{},
+			Responses: map[int]interface{}{
+				http.StatusOK:       &DeviceLoginStatus{},
+				http.StatusAccepted: &DeviceLoginStatus{},
+			},
+		}),
+		common.NewHTTPHandler(oidcUserInfoPath, http.MethodGet, o.userProfileHandler, &common.OperationSpec{
+			Summary: "Returns the claims of the user logged in.",
+			Params: []common.Param{
+				common.QueryParam(refreshParam, "Set to true to bypass the cached claims."),
+				common.HeaderParam("If-None-Match", "ETag of the claims the client has."),
+			},
+			Responses: map[int]interface{}{
+				http.StatusOK:          map[string]interface{}{},
+				http.StatusNotModified: nil,
+			},
+		}),
+		common.NewHTTPHandler(logoutPath, http.MethodGet, o.userLogoutHandler, &common.OperationSpec{
+			Summary: "Logs the user out.",
+		}),
+		common.NewHTTPHandler(onboardingStatusPath, http.MethodGet, o.onboardingStatusHandler, &common.OperationSpec{
+			Summary:   "Returns the progress of the onboarding of the user logged in.",
+			Responses: map[int]interface{}{http.StatusOK: &OnboardingStatus{}},
+		}),
+		common.NewHTTPHandler(exportPath, http.MethodGet, o.requireStepUp(o.exportHandler), &common.OperationSpec{
+			Summary: "Exports the data of the user logged in, or downloads a background export.",
+			Params: []common.Param{
+				common.QueryParam(exportFormatParam, "Format of the export: json (default) or zip."),
+				common.QueryParam(exportEDVParam, "Set to true to export the ids of the documents of the user vault."),
+				common.QueryParam(exportAsyncParam, "Set to true to export in the background."),
+				common.QueryParam(exportJobParam, "Id of the background export to download."),
+			},
+			Responses: map[int]interface{}{
+				http.StatusOK:           &UserDataExport{},
+				http.StatusAccepted:     &ExportJob{},
+				http.StatusUnauthorized: nil,
+			},
+		}),
+	}
+
+	handlers = append(handlers, o.walletBackupHandlers()...)
+	handlers = append(handlers, o.walletCredentialsHandlers()...)
+	handlers = append(handlers, o.walletAttachmentsHandlers()...)
+	handlers = append(handlers, o.siopHandlers()...)
+	handlers = append(handlers, o.accountHandlers()...)
+	handlers = append(handlers, o.preferencesHandlers()...)
+	handlers = append(handlers,
+		common.NewHTTPHandler(usagePath, http.MethodGet, o.usageHandler, &common.OperationSpec{
+			Summary:   "Returns the documents and keys of the user logged in, and the quotas of the user.",
+			Responses: map[int]interface{}{http.StatusOK: &Usage{}},
+		}),
+	)
+
+	if o.stepUp != nil {
+		handlers = append(handlers,
+			common.NewHTTPHandler(stepUpPath, http.MethodGet, o.stepUpHandler, &common.OperationSpec{
+				Summary: "Authenticates the user logged in again, for the operations requiring a recent authentication.",
+				Params: []common.Param{
+					common.QueryParam(stepUpRedirectParam, "Path the user returns to. Defaults to the dashboard."),
+				},
+				Responses: map[int]interface{}{http.StatusFound: nil},
+			}),
+		)
+	}
+
+	if o.consent != nil {
+		handlers = append(handlers,
+			common.NewHTTPHandler(consentPath, http.MethodGet, o.consentStatusHandler, &common.OperationSpec{
+				Summary:   "Tells whether the user must accept the terms.",
+				Responses: map[int]interface{}{http.StatusOK: &ConsentStatus{}},
+			}),
+			common.NewHTTPHandler(consentPath, http.MethodPost, o.consentHandler, &common.OperationSpec{
+				Summary:   "Accepts the terms and completes the login of a new user.",
+				Request:   &ConsentRequest{},
+				Responses: map[int]interface{}{http.StatusOK: &ConsentStatus{}},
+			}),
+		)
 	}
+
+	return handlers
+}
+
+// SetWalletDashboard changes the page users land on once logged in.
+func (o *Operation) SetWalletDashboard(url string) {
+	o.dashboardMutex.Lock()
+	defer o.dashboardMutex.Unlock()
+
+	o.walletDashboard = url
+}
+
+func (o *Operation) dashboard() string {
+	o.dashboardMutex.RLock()
+	defer o.dashboardMutex.RUnlock()
+
+	return o.walletDashboard
 }
 
 func (o *Operation) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("handling login request: %s", r.URL.String())
 
+	if o.maintenance.Enabled() {
+		o.maintenance.Refuse(w, r)
+
+		return
+	}
+
+	if o.clientLockedOut(w, r) {
+		return
+	}
+
+	opts, err := o.loginParams.options(r.URL.Query())
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid login request: %s", err.Error())
+
+		return
+	}
+
 	session, err := o.store.cookies.Open(r)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
@@ -200,15 +761,16 @@ func (o *Operation) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, found := session.Get(userSubCookieName)
-	if found {
-		http.Redirect(w, r, o.walletDashboard, http.StatusMovedPermanently)
+	if found && !forcesLogin(r.URL.Query()) {
+		http.Redirect(w, r, o.dashboard(), http.StatusMovedPermanently)
 
 		return
 	}
 
-	state := uuid.New().String()
+	state := o.newID().String()
 	session.Set(stateCookieName, state)
-	redirectURL := o.oidcClient.FormatRequest(state)
+	o.askToRemember(r, session)
+	redirectURL := o.oidcClient.FormatRequest(state, opts...)
 
 	err = session.Save(r, w)
 	if err != nil {
@@ -222,83 +784,264 @@ func (o *Operation) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("redirected to login url: %s", redirectURL)
 }
 
-// TODO encrypt data before storing: https://github.com/trustbloc/edge-agent/issues/380
-func (o *Operation) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) { // nolint:funlen,gocyclo,lll // cannot reduce
+func (o *Operation) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("handling oidc callback: %s", r.URL.String())
 
+	if o.clientLockedOut(w, r) {
+		return
+	}
+
 	oauthToken, oidcToken, canProceed := o.fetchTokens(w, r)
 	if !canProceed {
 		return
 	}
 
+	promptConsent, ok := o.loginUser(w, r, oauthToken, oidcToken)
+	if !ok {
+		return
+	}
+
+	o.redirectLoggedIn(w, r, promptConsent)
+}
+
+// redirectLoggedIn redirects the user logged in to the terms, to the page of the step-up, or to the dashboard.
+func (o *Operation) redirectLoggedIn(w http.ResponseWriter, r *http.Request, promptConsent bool) {
+	redirectURL := o.dashboard()
+	if promptConsent {
+		redirectURL = o.consent.PageURL
+	} else {
+		stepUpRedirect, stepUpOK := o.completeStepUp(w, r)
+		if !stepUpOK {
+			return
+		}
+
+		if stepUpRedirect != "" {
+			redirectURL = stepUpRedirect
+		}
+	}
+
+	o.loginSucceeded(r)
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	logger.Debugf("redirected user to: %s", redirectURL)
+}
+
+// loginUser creates or refreshes the user of the tokens and opens the user's session. The login of a new user
+// is held until the user accepts the terms when consent is required, and promptConsent is returned true
+// if the user must be asked for consent.
+// TODO encrypt data before storing: https://github.com/trustbloc/edge-agent/issues/380
+func (o *Operation) loginUser(w http.ResponseWriter, r *http.Request, // nolint:funlen,gocyclo // cannot reduce
+	oauthToken *oauth2.Token, oidcToken oidc.Claimer) (promptConsent, ok bool) {
 	usr, err := user.ParseIDToken(oidcToken)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
 			http.StatusInternalServerError, "failed to parse id_token: %s", err.Error())
 
-		return
+		return false, false
 	}
 
-	_, err = o.store.users.Get(usr.Sub)
+	if o.userLockedOut(w, usr.Sub) {
+		return false, false
+	}
+
+	err = o.mapClaims(oidcToken, usr)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to map user claims: %s", err.Error())
+
+		return false, false
+	}
+
+	stored, err := o.store.users.Get(usr.Sub)
 	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
 		common.WriteErrorResponsef(w, logger,
 			http.StatusInternalServerError, "failed to query user data: %s", err.Error())
 
-		return
+		return false, false
 	}
 
-	if errors.Is(err, storage.ErrValueNotFound) {
-		walletSecretShare, onboardErr := o.onboardUser(usr.Sub, oauthToken.AccessToken)
-		if onboardErr != nil {
-			common.WriteErrorResponsef(w, logger,
-				http.StatusInternalServerError, "failed to onboard the user: %s", onboardErr.Error())
+	returning := err == nil
 
-			return
-		}
+	if returning && userDeactivated(w, stored.Deactivated) {
+		return false, false
+	}
 
-		usr.SecretShare = walletSecretShare
+	if !returning && o.consent != nil {
+		return true, o.deferLogin(w, r, usr, oauthToken)
+	}
 
-		err = o.store.users.Save(usr)
-		if err != nil {
-			common.WriteErrorResponsef(w, logger,
-				http.StatusInternalServerError, "failed to persist user data: %s", err.Error())
+	// the tokens are saved ahead of the user, so that no user is left without tokens
+	login, err := o.beginLogin(usr, oauthToken, returning)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
 
-			return
-		}
+		return false, false
 	}
 
-	err = o.store.tokens.Save(&tokens.UserTokens{
-		UserSub: usr.Sub,
-		Access:  oauthToken.AccessToken,
-		Refresh: oauthToken.RefreshToken,
-	})
-	if err != nil {
-		common.WriteErrorResponsef(w, logger,
-			http.StatusInternalServerError, "failed to persist user tokens: %s", err.Error())
+	if !returning {
+		created := o.createUser(w, r, usr, oauthToken.AccessToken)
+		if !created {
+			o.abortLogin(login, errors.New("failed to create user"))
 
-		return
+			return false, false
+		}
+	} else {
+		err = o.refreshProfile(stored, usr)
+		if err != nil {
+			err = o.abortLogin(login, fmt.Errorf("failed to update user profile: %w", err))
+			common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+			return false, false
+		}
+
+		o.maintainEscrow(r.Context(), stored)
 	}
 
+	o.commitLogin(login)
+
 	session, err := o.store.cookies.Open(r)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
 			http.StatusInternalServerError, "failed to create or decode user sub session cookie: %s", err.Error())
 
-		return
+		return false, false
 	}
 
 	session.Set(userSubCookieName, usr.Sub)
+	o.sessionBinding.Bind(session, r)
+	o.rememberUser(w, session, usr.Sub)
+
+	if o.stepUp != nil {
+		recordAuthContext(session, oidcToken)
+	}
 
 	err = session.Save(r, w)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger,
 			http.StatusInternalServerError, "failed to save user sub cookie: %s", err.Error())
 
-		return
+		return false, false
+	}
+
+	return returning && o.promptConsent(stored), true
+}
+
+func (o *Operation) createUser(w http.ResponseWriter, r *http.Request, usr *user.User, accessToken string) bool {
+	if o.onboarding != nil {
+		return o.enqueueOnboarding(w, usr, accessToken)
+	}
+
+	if down := o.downOnboardingDependency(); down != "" {
+		common.WriteErrorResponsef(w, logger, http.StatusServiceUnavailable,
+			"onboarding unavailable: %s is down", down)
+
+		return false
+	}
+
+	// the onboarding is aborted if the user's browser disconnects
+	err := o.provisionUser(r.Context(), usr, accessToken)
+	if err != nil {
+		if r.Context().Err() != nil {
+			logger.Infof("aborted the onboarding of user %s: %s", usr.Sub, r.Context().Err().Error())
+
+			return false
+		}
+
+		var veto *OnboardingVetoError
+		if errors.As(err, &veto) {
+			common.WriteErrorResponsef(w, logger, http.StatusForbidden, "%s", veto.Error())
+
+			return false
+		}
+
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+
+		common.WriteErrorResponsef(w, logger, status, "%s", err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+// provisionUser onboards the user with the key and EDV servers and persists the user, auditing the outcome. The
+// concurrent logins of a new user, e.g. a double-click or a retried redirect, onboard the user once: they wait for
+// the login onboarding the user and reuse the user it persisted.
+func (o *Operation) provisionUser(ctx context.Context, usr *user.User, accessToken string) error {
+	release, err := o.onboardingLock.Lock(ctx, o.pseudonyms.ID(usr.Sub))
+	if err != nil {
+		return fmt.Errorf("failed to lock the onboarding of the user: %w", err)
+	}
+
+	defer release()
+
+	_, err = o.store.users.Get(usr.Sub)
+	if err == nil {
+		logger.Infof("user %s was onboarded by a concurrent login", usr.Sub)
+
+		return nil
+	}
+
+	if !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to query user data: %w", err)
+	}
+
+	o.audit(audit.ActionOnboardingStarted, usr.Sub, nil)
+
+	err = o.persistUser(ctx, usr, accessToken)
+	if err != nil {
+		o.auditOnboardingFailed(usr.Sub, err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (o *Operation) persistUser(ctx context.Context, usr *user.User, accessToken string) error {
+	err := o.preOnboard(ctx, usr)
+	if err != nil {
+		return err
+	}
+
+	walletSecretShare, data, err := o.onboardUser(ctx, usr, accessToken)
+	if err != nil {
+		return fmt.Errorf("failed to onboard the user: %w", err)
+	}
+
+	err = o.postOnboard(ctx, usr, data)
+	if err != nil {
+		return err
+	}
+
+	if o.escrow != nil {
+		usr.Escrow, err = o.depositShare(ctx, usr.Sub, walletSecretShare)
+		if err != nil {
+			return fmt.Errorf("failed to onboard the user: %w", err)
+		}
+	}
+
+	tx, err := o.beginUserCreated(usr, data)
+	if err != nil {
+		return fmt.Errorf("failed to record user events: %w", err)
+	}
+
+	usr.SecretShare = walletSecretShare
+
+	err = o.store.users.Save(usr)
+	if err != nil {
+		rollbackEvents(tx)
+
+		return fmt.Errorf("failed to persist user data: %w", err)
 	}
 
-	http.Redirect(w, r, o.walletDashboard, http.StatusFound)
-	logger.Debugf("redirected user to: %s", o.walletDashboard)
+	commitEvents(tx)
+	o.usage.record(usr.Sub, 0, onboardingKeys)
+	o.auditOnboardingCompleted(usr.Sub, data)
+
+	return nil
 }
 
 func (o *Operation) fetchTokens(
@@ -312,6 +1055,7 @@ func (o *Operation) fetchTokens(
 
 	code := r.URL.Query().Get("code")
 	if code == "" {
+		o.loginFailed(r, session, reasonMissingCode)
 		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing code parameter")
 
 		return nil, nil, false
@@ -321,11 +1065,12 @@ func (o *Operation) fetchTokens(
 		context.WithValue(
 			r.Context(),
 			oauth2.HTTPClient,
-			&http.Client{Transport: &http.Transport{TLSClientConfig: o.tlsConfig}},
+			&http.Client{Transport: &http.Transport{TLSClientConfig: o.tlsConfig, Proxy: o.proxy}},
 		),
 		code,
 	)
 	if err != nil {
+		o.loginFailed(r, session, reasonExchangeFailed)
 		common.WriteErrorResponsef(w, logger,
 			http.StatusBadGateway, "unable to exchange code for token: %s", err.Error())
 
@@ -334,6 +1079,7 @@ func (o *Operation) fetchTokens(
 
 	oidcToken, err = o.oidcClient.VerifyIDToken(r.Context(), oauthToken)
 	if err != nil {
+		o.loginFailed(r, session, reasonInvalidIDToken)
 		common.WriteErrorResponsef(w, logger,
 			http.StatusBadGateway, "cannot verify id_token: %s", err.Error())
 
@@ -362,6 +1108,7 @@ func (o *Operation) getAndVerifyUserSession(w http.ResponseWriter, r *http.Reque
 
 	stateCookie, found := session.Get(stateCookieName)
 	if !found {
+		o.loginFailed(r, session, reasonMissingStateCookie)
 		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing state session cookie")
 
 		return nil, false
@@ -369,12 +1116,14 @@ func (o *Operation) getAndVerifyUserSession(w http.ResponseWriter, r *http.Reque
 
 	state := r.URL.Query().Get("state")
 	if state == "" {
+		o.loginFailed(r, session, reasonMissingState)
 		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing state parameter")
 
 		return nil, false
 	}
 
 	if state != stateCookie {
+		o.loginFailed(r, session, reasonInvalidState)
 		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid state parameter")
 
 		return nil, false
@@ -415,7 +1164,7 @@ func (o *Operation) userProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	common.WriteResponse(w, logger, data)
+	common.WriteConditionalResponse(w, r, logger, o.exposedUserData(data))
 	logger.Debugf("finished handling userprofile request")
 }
 
@@ -428,26 +1177,34 @@ func (o *Operation) fetchUserData(w http.ResponseWriter, r *http.Request, sub st
 		return nil, false
 	}
 
-	userInfo, err := o.oidcClient.UserInfo(r.Context(), &oauth2.Token{
-		AccessToken:  tokns.Access,
-		TokenType:    "Bearer",
-		RefreshToken: tokns.Refresh,
-	})
-	if err != nil {
-		common.WriteErrorResponsef(w, logger,
-			http.StatusBadGateway, "failed to fetch user info: %s", err.Error())
+	data, cached := o.cachedUserInfo(sub)
 
-		return nil, false
+	// the claims are served from the cache, however old, while the provider is down
+	providerDown := o.down(DependencyOIDCProvider) && !o.didUser(sub)
+	if providerDown {
+		data, cached = o.staleUserInfo(sub)
 	}
 
-	data := make(map[string]interface{})
+	if !cached || (r.URL.Query().Get(refreshParam) == "true" && !providerDown) {
+		userInfo, err := o.userInfo(r.Context(), sub, tokns)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusBadGateway, "failed to fetch user info: %s", err.Error())
+
+			return nil, false
+		}
 
-	err = userInfo.Claims(&data)
-	if err != nil {
-		common.WriteErrorResponsef(w, logger,
-			http.StatusInternalServerError, "failed to extract claims from user info: %s", err.Error())
+		data = make(map[string]interface{})
 
-		return nil, false
+		err = userInfo.Claims(&data)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to extract claims from user info: %s", err.Error())
+
+			return nil, false
+		}
+
+		o.cacheUserInfo(sub, data)
 	}
 
 	walletUserData, err := o.store.users.Get(sub)
@@ -458,7 +1215,7 @@ func (o *Operation) fetchUserData(w http.ResponseWriter, r *http.Request, sub st
 		return nil, false
 	}
 
-	userBootStrapData, err := o.fetchBootstrapData(tokns.Access)
+	userBootStrapData, err := o.fetchBootstrapData(r.Context(), tokns.Access)
 	if err != nil {
 		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError,
 			"failed to fetch bootstrap data: %s", err.Error())
@@ -466,8 +1223,8 @@ func (o *Operation) fetchUserData(w http.ResponseWriter, r *http.Request, sub st
 		return nil, false
 	}
 
-	data["bootstrap"] = userBootStrapData.Data
-	data["userConfig"] = &userConfig{
+	data[bootstrapDataKey] = userBootStrapData.Data
+	data[userConfigKey] = &userConfig{
 		Sub:         sub,
 		SecretShare: walletUserData.SecretShare,
 	}
@@ -475,8 +1232,8 @@ func (o *Operation) fetchUserData(w http.ResponseWriter, r *http.Request, sub st
 	return data, true
 }
 
-func (o *Operation) fetchBootstrapData(accessToken string) (*userBootstrapData, error) {
-	req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet, o.hubAuthURL+hubAuthBootstrapDataPath, nil)
+func (o *Operation) fetchBootstrapData(ctx context.Context, accessToken string) (*userBootstrapData, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.hubAuthURL+hubAuthBootstrapDataPath, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -490,7 +1247,17 @@ func (o *Operation) fetchBootstrapData(accessToken string) (*userBootstrapData,
 
 	bootstrapData := &userBootstrapData{}
 
-	return bootstrapData, json.Unmarshal(data, bootstrapData)
+	err = json.Unmarshal(data, bootstrapData)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal bootstrap data : %w", err)
+	}
+
+	err = o.verifyBootstrapData(bootstrapData.Data)
+	if err != nil {
+		return nil, fmt.Errorf("verify bootstrap data : %w", err)
+	}
+
+	return bootstrapData, nil
 }
 
 func (o *Operation) userLogoutHandler(w http.ResponseWriter, r *http.Request) {
@@ -504,14 +1271,23 @@ func (o *Operation) userLogoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, found := jar.Get(userSubCookieName)
+	o.forgetUser(w, r)
+
+	sub, found := jar.Get(userSubCookieName)
 	if !found {
 		logger.Infof("missing user cookie - this is a no-op")
 
 		return
 	}
 
+	if userSub, ok := sub.(string); ok {
+		o.vaults.Remove(userSub)
+		o.invalidateUserInfo(userSub)
+		o.audit(audit.ActionLogout, userSub, nil)
+	}
+
 	jar.Delete(userSubCookieName)
+	forgetAuthContext(jar)
 
 	err = jar.Save(r, w)
 	if err != nil {
@@ -522,119 +1298,174 @@ func (o *Operation) userLogoutHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("finished handling logout request")
 }
 
-func (o *Operation) onboardUser(sub, accessToken string) (string, error) { // nolint:funlen,gocyclo // not much logic
-	b := make([]byte, 32)
+// stepFunc bounds a step of the onboarding.
+type stepFunc func(ctx context.Context) (context.Context, context.CancelFunc)
 
-	_, err := rand.Read(b)
-	if err != nil {
-		return "", fmt.Errorf("create user secret key : %w", err)
+// onboardStep bounds a step of the onboarding by the per-step timeout.
+func (o *Operation) onboardStep(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.onboardingStep <= 0 {
+		return context.WithCancel(ctx)
 	}
 
-	secrets, err := o.secretSplitter.Split(b, 2, 2)
-	if err != nil {
-		return "", fmt.Errorf("split user secret key : %w", err)
-	}
+	return context.WithTimeout(ctx, o.onboardingStep)
+}
 
-	walletSecretShare := base64.StdEncoding.EncodeToString(secrets[0])
-	hubAuthSecretShare := secrets[1]
+// onboardUser binds a set of the provisioning pool to a new user, or provisions the user on demand, and hands the
+// bootstrap data of the user to hub-auth. The users of a tenant with an onboarding template of its own are
+// provisioned with it.
+func (o *Operation) onboardUser(ctx context.Context, usr *user.User, accessToken string) (string, *BootstrapData,
+	error) {
+	sub := usr.Sub
 
-	err = postSecret(o.hubAuthURL, accessToken, hubAuthSecretShare, o.httpClient)
-	if err != nil {
-		return "", fmt.Errorf("post half secret to hub-auth : %w", err)
+	var set *provisionedSet
+
+	tenant, _ := usr.Attributes[TenantAttribute].(string) // nolint:errcheck // users without a tenant have none
+
+	plan, ok := o.tenantPlans[tenant]
+	if !ok {
+		plan = o.onboardingPlan
+		set = o.claimProvisionedSet(ctx, sub, accessToken)
 	}
 
-	h := &hubKMSHeader{
-		userSub:     sub,
-		accessToken: accessToken,
-		secretShare: walletSecretShare,
+	if set == nil {
+		var err error
+
+		set, err = o.provisionPlan(ctx, plan, sub, accessToken)
+		if err != nil {
+			return "", nil, err
+		}
 	}
 
-	authzKeyStoreURL, _, err := createKeyStore(o.keyServer.AuthzKMSURL, sub, "", h, o.httpClient)
+	stepCtx, cancel := o.onboardStep(ctx)
+	err := o.postBootstrapData(stepCtx, accessToken, set.Data)
+	cancel()
+
 	if err != nil {
-		return "", fmt.Errorf("create authz keystore : %w", err)
+		return "", nil, fmt.Errorf("update user bootstrap data : %w", err)
 	}
 
-	authzKeyStoreID := getKeystoreID(authzKeyStoreURL)
+	// the keystores of a user onboarded again replace those of the failed onboarding
+	o.invalidateKeystores(o.pseudonyms.ID(sub))
+
+	return set.WalletSecretShare, set.Data, nil
+}
+
+// provisionSet provisions the resources of the default onboarding template for a user, or for a set of the
+// provisioning pool. The resources of the built-in template are provisioned concurrently where independent:
+//
+//	hub-auth secret share --+
+//	authz keystore ---------+--> authz key --+--> ops EDV vault --> ops keystore --+--> EDV operational key
+//	                                         |                                     +--> EDV HMAC key
+//	                                         +--> user EDV vault
+func (o *Operation) provisionSet(ctx context.Context, sub, accessToken string) (*provisionedSet, error) {
+	return o.provisionPlan(ctx, o.onboardingPlan, sub, accessToken)
+}
+
+// provisionPlan provisions the resources of an onboarding plan for a user.
+func (o *Operation) provisionPlan(ctx context.Context, plan *onboardingPlan, sub,
+	accessToken string) (*provisionedSet, error) {
+	b := make([]byte, 32)
 
-	keyID, err := createKey(o.keyServer.AuthzKMSURL, authzKeyStoreID, kms.ED25519, h, o.httpClient)
+	_, err := io.ReadFull(o.random, b)
 	if err != nil {
-		return "", fmt.Errorf("failed create authz key : %w", err)
+		return nil, fmt.Errorf("create user secret key : %w", err)
 	}
 
-	pkBytes, err := exportPublicKey(o.keyServer.AuthzKMSURL, authzKeyStoreID, keyID, h, o.httpClient)
+	secrets, err := o.secretSplitter.Split(b, 2, 2)
 	if err != nil {
-		return "", fmt.Errorf("failed export public key: %w", err)
+		return nil, fmt.Errorf("split user secret key : %w", err)
 	}
 
-	_, controller := fingerprint.CreateDIDKey(pkBytes)
+	walletSecretShare := base64.StdEncoding.EncodeToString(secrets[0])
 
-	opsEDVVaultURL, opsEDVCapability, err := createEDVDataVault(o.keyEDVClient, controller, accessToken)
-	if err != nil {
-		return "", fmt.Errorf("create edv vault : %w", err)
+	p := &provisioning{
+		h: &hubKMSHeader{
+			userSub:     o.pseudonyms.ID(sub),
+			accessToken: accessToken,
+			secretShare: walletSecretShare,
+		},
+		hubAuthSecretShare: secrets[1],
+		data:               &BootstrapData{},
 	}
 
-	opsEDVVaultID := getVaultID(opsEDVVaultURL)
-
-	opsKeyStoreURL, opsKeyStoreEDVDIDKey, err := createKeyStore(o.keyServer.OpsKMSURL, controller,
-		opsEDVVaultID, &hubKMSHeader{accessToken: accessToken}, o.httpClient)
+	err = o.runPlan(ctx, plan, p)
 	if err != nil {
-		return "", fmt.Errorf("create operational keystore : %w", err)
+		return nil, err
 	}
 
-	if len(opsEDVCapability) != 0 {
-		if errUpdate := updateEDVCapabilityInKeyStore(o.keyServer.OpsKMSURL, getKeystoreID(opsKeyStoreURL), controller,
-			opsEDVVaultID, opsEDVCapability, opsKeyStoreEDVDIDKey, newKMSSigner(o.keyServer.AuthzKMSURL,
-				authzKeyStoreID, keyID, h, o.httpClient), o.httpClient); errUpdate != nil {
-			return "", errUpdate
+	return &provisionedSet{
+		WalletSecretShare:  walletSecretShare,
+		HubAuthSecretShare: secrets[1],
+		Data:               p.data,
+	}, nil
+}
+
+// createAuthzKey posts the hub-auth half of the user secret while it creates the authz keystore, then creates the
+// authz key of the user.
+func (o *Operation) createAuthzKey(ctx context.Context, h *hubKMSHeader, hubAuthSecretShare []byte,
+	step stepFunc) (string, string, error) {
+	var authzKeyStore *keystore
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		stepCtx, cancel := step(gctx)
+		defer cancel()
+
+		err := postSecret(stepCtx, o.hubAuthURL, h.accessToken, hubAuthSecretShare, o.httpClient)
+		if err != nil {
+			return fmt.Errorf("post half secret to hub-auth : %w", err)
 		}
-	}
 
-	var userEDVVaultURL string
+		return nil
+	})
+
+	g.Go(func() error {
+		stepCtx, cancel := step(gctx)
+		defer cancel()
 
-	var userEDVCapability []byte
+		var err error
 
-	if o.userEDVClient != nil {
-		userEDVVaultURL, userEDVCapability, err = createEDVDataVault(o.userEDVClient, controller, accessToken)
+		authzKeyStore, err = createKeyStore(stepCtx, o.keyServer.AuthzKMSURL, h.userSub, "", h, o.httpClient)
 		if err != nil {
-			return "", fmt.Errorf("create user edv vault : %w", err)
+			return fmt.Errorf("create authz keystore : %w", err)
 		}
-	}
 
-	edvOpsKID, err := createKey(o.keyServer.OpsKMSURL, getKeystoreID(opsKeyStoreURL), kms.ECDH256KWAES256GCM, h,
-		o.httpClient)
+		return nil
+	})
+
+	err := g.Wait()
 	if err != nil {
-		return "", fmt.Errorf("create edv operational key : %w", err)
+		return "", "", err
 	}
 
-	edvOpsKIDURL := fmt.Sprintf("%s/keys/%s", opsKeyStoreURL, edvOpsKID)
+	stepCtx, cancel := step(ctx)
+	defer cancel()
 
-	hmacEDVKID, err := createKey(o.keyServer.OpsKMSURL, getKeystoreID(opsKeyStoreURL), kms.HMACSHA256Tag256, h,
+	keyID, err := createKey(stepCtx, o.keyServer.AuthzKMSURL, getKeystoreID(authzKeyStore.url), kms.ED25519, h,
 		o.httpClient)
 	if err != nil {
-		return "", fmt.Errorf("create edv hmac key : %w", err)
+		return "", "", fmt.Errorf("failed create authz key : %w", err)
 	}
 
-	hmacEDVKIDURL := fmt.Sprintf("%s/keys/%s", opsKeyStoreURL, hmacEDVKID)
+	return authzKeyStore.url, keyID, nil
+}
 
-	data := &BootstrapData{
-		UserEDVVaultURL:   userEDVVaultURL,
-		OpsEDVVaultURL:    opsEDVVaultURL,
-		AuthzKeyStoreURL:  authzKeyStoreURL,
-		OpsKeyStoreURL:    opsKeyStoreURL,
-		EDVOpsKIDURL:      edvOpsKIDURL,
-		EDVHMACKIDURL:     hmacEDVKIDURL,
-		UserEDVCapability: string(userEDVCapability),
-	}
+func (o *Operation) exportAuthzKey(ctx context.Context, authzKeyStoreURL, keyID string,
+	h *hubKMSHeader, step stepFunc) ([]byte, error) {
+	stepCtx, cancel := step(ctx)
+	defer cancel()
 
-	err = postUserBootstrapData(o.hubAuthURL, accessToken, data, o.httpClient)
+	pkBytes, err := exportPublicKey(stepCtx, o.keyServer.AuthzKMSURL, getKeystoreID(authzKeyStoreURL), keyID, h,
+		o.httpClient)
 	if err != nil {
-		return "", fmt.Errorf("update user bootstrap data : %w", err)
+		return nil, fmt.Errorf("failed export public key: %w", err)
 	}
 
-	return walletSecretShare, nil
+	return pkBytes, nil
 }
 
-func postSecret(baseURL, accessToken string, secret []byte, httpClient httpClient) error {
+func postSecret(ctx context.Context, baseURL, accessToken string, secret []byte, httpClient HTTPClient) error {
 	reqBytes, err := json.Marshal(secretRequest{
 		Secret: secret,
 	})
@@ -642,7 +1473,7 @@ func postSecret(baseURL, accessToken string, secret []byte, httpClient httpClien
 		return fmt.Errorf("marshal secret req : %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.TODO(),
+	req, err := http.NewRequestWithContext(ctx,
 		http.MethodPost, baseURL+hubAuthSecretPath, bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return err
@@ -658,7 +1489,8 @@ func postSecret(baseURL, accessToken string, secret []byte, httpClient httpClien
 	return nil
 }
 
-func postUserBootstrapData(baseURL, accessToken string, data *BootstrapData, httpClient httpClient) error {
+func postUserBootstrapData(ctx context.Context, baseURL, accessToken string, data *BootstrapData,
+	httpClient HTTPClient) error {
 	reqBytes, err := json.Marshal(userBootstrapData{
 		Data: data,
 	})
@@ -666,7 +1498,7 @@ func postUserBootstrapData(baseURL, accessToken string, data *BootstrapData, htt
 		return fmt.Errorf("marshal boostrap data : %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.TODO(),
+	req, err := http.NewRequestWithContext(ctx,
 		http.MethodPost, baseURL+hubAuthBootstrapDataPath, bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return err
@@ -682,37 +1514,51 @@ func postUserBootstrapData(baseURL, accessToken string, data *BootstrapData, htt
 	return nil
 }
 
-func createKeyStore(baseURL, controller, vaultID string, h *hubKMSHeader,
-	httpClient httpClient) (string, string, error) {
+// keystore is a keystore created in a hub-kms.
+type keystore struct {
+	url       string
+	edvDIDKey string
+	// capability is the root capability of the keystore, when the KMS secures it with zcaps.
+	capability []byte
+}
+
+func createKeyStore(ctx context.Context, baseURL, controller, vaultID string, h *hubKMSHeader,
+	httpClient HTTPClient) (*keystore, error) {
 	reqBytes, err := json.Marshal(createKeystoreReq{
 		Controller: controller,
 		VaultID:    vaultID,
 	})
 	if err != nil {
-		return "", "", fmt.Errorf("marshal create keystore req : %w", err)
+		return nil, fmt.Errorf("marshal create keystore req : %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.TODO(),
+	req, err := http.NewRequestWithContext(ctx,
 		http.MethodPost, baseURL+hubKMSCreateKeyStorePath, bytes.NewBuffer(reqBytes))
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	addAuthZKMSHeaders(req, h)
 
 	_, headers, err := sendHTTPRequest(req, httpClient, http.StatusCreated)
 	if err != nil {
-		return "", "", fmt.Errorf("create authz keystore : %w", err)
+		return nil, fmt.Errorf("create authz keystore : %w", err)
 	}
 
-	keystoreURL := headers.Get("Location")
-	edvDIDKey := headers.Get("Edvdidkey")
+	capability, err := base64.URLEncoding.DecodeString(headers.Get(kmsCapabilityHeader))
+	if err != nil {
+		return nil, fmt.Errorf("decode keystore capability : %w", err)
+	}
 
-	return keystoreURL, edvDIDKey, nil
+	return &keystore{
+		url:        headers.Get("Location"),
+		edvDIDKey:  headers.Get("Edvdidkey"),
+		capability: capability,
+	}, nil
 }
 
-func updateEDVCapabilityInKeyStore(baseURL, keystoreID, controller, vaultID string, edvCapability []byte,
-	kmsDIDKey string, s signer, httpClient httpClient) error {
+func updateEDVCapabilityInKeyStore(ctx context.Context, baseURL, keystoreID, controller, vaultID string,
+	edvCapability []byte, kmsDIDKey string, s signer, httpClient HTTPClient) error {
 	capability, err := zcapld.ParseCapability(edvCapability)
 	if err != nil {
 		return err
@@ -742,7 +1588,7 @@ func updateEDVCapabilityInKeyStore(baseURL, keystoreID, controller, vaultID stri
 		return fmt.Errorf("marshal create update capability req : %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.TODO(),
+	req, err := http.NewRequestWithContext(ctx,
 		http.MethodPost, baseURL+fmt.Sprintf(capabilityEndpoint, keystoreID), bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return err
@@ -756,7 +1602,8 @@ func updateEDVCapabilityInKeyStore(baseURL, keystoreID, controller, vaultID stri
 	return nil
 }
 
-func createKey(baseURL, keystoreID, keyType string, h *hubKMSHeader, httpClient httpClient) (string, error) {
+func createKey(ctx context.Context, baseURL, keystoreID, keyType string, h *hubKMSHeader,
+	httpClient HTTPClient) (string, error) {
 	reqBytes, err := json.Marshal(createKeyReq{
 		KeyType: keyType,
 	})
@@ -764,7 +1611,7 @@ func createKey(baseURL, keystoreID, keyType string, h *hubKMSHeader, httpClient
 		return "", fmt.Errorf("marshal create key req : %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.TODO(),
+	req, err := http.NewRequestWithContext(ctx,
 		http.MethodPost, baseURL+fmt.Sprintf(keysEndpoint, keystoreID), bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return "", err
@@ -780,8 +1627,9 @@ func createKey(baseURL, keystoreID, keyType string, h *hubKMSHeader, httpClient
 	return getKeyID(headers.Get("Location")), nil
 }
 
-func exportPublicKey(baseURL, keystoreID, keyID string, h *hubKMSHeader, httpClient httpClient) ([]byte, error) {
-	req, err := http.NewRequestWithContext(context.TODO(),
+func exportPublicKey(ctx context.Context, baseURL, keystoreID, keyID string, h *hubKMSHeader,
+	httpClient HTTPClient) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx,
 		http.MethodGet, baseURL+fmt.Sprintf(exportKeyEndpoint, keystoreID, keyID), nil)
 	if err != nil {
 		return nil, err
@@ -808,34 +1656,27 @@ func exportPublicKey(baseURL, keystoreID, keyID string, h *hubKMSHeader, httpCli
 	return pkBytes, nil
 }
 
+// getKeystoreID returns the ID of the keystore of the location, whether the KMS locates it with an absolute URL or
+// with a path.
 func getKeystoreID(location string) string {
-	const (
-		keystoreIDPos = 3
-	)
-
-	s := strings.Split(location, "/")
-
-	keystoreID := ""
-	if len(s) > keystoreIDPos {
-		keystoreID = s[keystoreIDPos]
-	}
-
-	return keystoreID
+	return pathSegmentAfter(location, "keystores")
 }
 
+// getKeyID returns the ID of the key of the location, whether the KMS locates it with an absolute URL or with a path.
 func getKeyID(location string) string {
-	const (
-		keyIDPos = 5
-	)
+	return pathSegmentAfter(location, "keys")
+}
 
+func pathSegmentAfter(location, name string) string {
 	s := strings.Split(location, "/")
 
-	keyID := ""
-	if len(s) > keyIDPos {
-		keyID = s[keyIDPos]
+	for i := 0; i < len(s)-1; i++ {
+		if s[i] == name {
+			return s[i+1]
+		}
 	}
 
-	return keyID
+	return ""
 }
 
 func getVaultID(vaultURL string) string {
@@ -844,21 +1685,23 @@ func getVaultID(vaultURL string) string {
 	return parts[len(parts)-1]
 }
 
-func createEDVDataVault(edvClient edvClient, controller, accessToken string) (string, []byte, error) {
+func (o *Operation) createEDVDataVault(ctx context.Context, edvClient EDVClient,
+	controller, accessToken string) (string, []byte, error) {
 	config := models.DataVaultConfiguration{
 		Sequence:    0,
 		Controller:  controller,
-		ReferenceID: uuid.New().String(),
-		KEK:         models.IDTypePair{ID: uuid.New().URN(), Type: "AesKeyWrappingKey2019"},
-		HMAC:        models.IDTypePair{ID: uuid.New().URN(), Type: "Sha256HmacKey2019"},
+		ReferenceID: o.newID().String(),
+		KEK:         models.IDTypePair{ID: o.newID().URN(), Type: "AesKeyWrappingKey2019"},
+		HMAC:        models.IDTypePair{ID: o.newID().URN(), Type: "Sha256HmacKey2019"},
 	}
 
 	vaultURL, capability, err := edvClient.CreateDataVault(&config,
-		client.WithRequestHeader(func(req *http.Request) (*http.Header, error) {
+		sds.WithRequestHeader(func(req *http.Request) (*http.Header, error) {
 			req.Header.Set("Authorization", "Bearer "+accessToken)
 
 			return &req.Header, nil
-		}))
+		}),
+		sds.WithContext(ctx))
 	if err != nil {
 		return "", nil, fmt.Errorf("create data vault : %w", err)
 	}
@@ -866,7 +1709,7 @@ func createEDVDataVault(edvClient edvClient, controller, accessToken string) (st
 	return vaultURL, capability, nil
 }
 
-func sendHTTPRequest(req *http.Request, httpClient httpClient, status int) ([]byte, http.Header, error) {
+func sendHTTPRequest(req *http.Request, httpClient HTTPClient, status int) ([]byte, http.Header, error) {
 	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, nil, fmt.Errorf("http request : %w", err)
@@ -894,8 +1737,6 @@ func sendHTTPRequest(req *http.Request, httpClient httpClient, status int) ([]by
 func addAuthZKMSHeaders(r *http.Request, h *hubKMSHeader) {
 	r.Header.Add("Hub-Kms-Secret", h.secretShare)
 	r.Header.Add("Hub-Kms-User", h.userSub)
-	logger.Errorf(h.secretShare)
-	logger.Errorf(h.userSub)
 
 	addAccessToken(r, h.accessToken)
 }
@@ -8,7 +8,10 @@ package oidc // nolint:testpackage // changing to different package requires exp
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,21 +20,27 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite/ed25519signature2018"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
 	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/binding"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/faults"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/maintenance"
 	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
 	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/zcapsig"
 	"github.com/trustbloc/edge-core/pkg/storage"
 	"github.com/trustbloc/edge-core/pkg/storage/memstore"
 	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
-	"github.com/trustbloc/edv/pkg/client"
 	"github.com/trustbloc/edv/pkg/restapi/models"
 	"golang.org/x/oauth2"
 )
@@ -43,6 +52,96 @@ func TestNew(t *testing.T) {
 		require.NotNil(t, o)
 	})
 
+	t.Run("presents the client certificate to the key servers", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert, MinVersion: tls.VersionTLS12}
+		server.StartTLS()
+
+		defer server.Close()
+
+		presented := false
+
+		config := config(t)
+		config.TLSConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+		config.ClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			presented = true
+
+			return &tls.Certificate{}, nil
+		}
+
+		o, err := New(config)
+		require.NoError(t, err)
+		require.Equal(t, o.kmsHTTPClient, o.httpClient)
+
+		resp, err := o.kmsHTTPClient.Get(server.URL) // nolint:noctx // test
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.True(t, presented)
+	})
+
+	t.Run("exchanges the access tokens sent to hub-auth and hub-kms", func(t *testing.T) {
+		authorizations := make(map[string]string)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorizations[r.URL.Path] = r.Header.Get("Authorization")
+
+			_, err := w.Write([]byte(`{}`))
+			require.NoError(t, err)
+		}))
+		defer server.Close()
+
+		config := config(t)
+		config.HubAuthURL = server.URL + "/auth"
+		config.KeyServer.AuthzKMSURL = server.URL + "/kms"
+		config.TokenExchange = &TokenExchangeConfig{
+			Exchanger: exchangerFunc(func(_ context.Context, token, audience string) (*oauth2.Token, error) {
+				return &oauth2.Token{AccessToken: token + "@" + audience, Expiry: time.Now().Add(time.Hour)}, nil
+			}),
+			HubAuthAudience: "hub-auth",
+			KMSAudience:     "hub-kms",
+		}
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		_, err = o.fetchBootstrapData(context.Background(), "opaque")
+		require.NoError(t, err)
+
+		r, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+"/kms/keystores", nil)
+		require.NoError(t, err)
+		addAccessToken(r, "opaque")
+
+		resp, err := o.kmsHTTPClient.Do(r)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, map[string]string{
+			"/auth" + hubAuthBootstrapDataPath: "Bearer " + base64.StdEncoding.EncodeToString([]byte("opaque@hub-auth")),
+			"/kms/keystores":                   "Bearer " + base64.StdEncoding.EncodeToString([]byte("opaque@hub-kms")),
+		}, authorizations)
+	})
+
+	t.Run("injects faults into the requests to hub-auth, KMS and EDV servers", func(t *testing.T) {
+		requests := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+		}))
+		defer server.Close()
+
+		config := config(t)
+		config.HubAuthURL = server.URL + "/auth"
+		config.Faults = &faults.Config{Rules: []*faults.Rule{{Endpoint: server.URL + "/auth", ErrorRate: 1}}}
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		_, err = o.fetchBootstrapData(context.Background(), "token")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "503")
+		require.Zero(t, requests)
+	})
+
 	t.Run("can init if transient store already exists", func(t *testing.T) {
 		config := config(t)
 		config.Storage.TransientStorage = &mockstore.Provider{
@@ -92,6 +191,26 @@ func TestNew(t *testing.T) {
 		_, err := New(config)
 		require.Error(t, err)
 	})
+
+	t.Run("keeps the tokens in the configured token store", func(t *testing.T) {
+		tokenStore, err := tokens.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		config := config(t)
+		config.Tokens = tokenStore
+
+		o, err := New(config)
+		require.NoError(t, err)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: "user", Access: "token"}))
+
+		_, err = tokenStore.Get("user")
+		require.NoError(t, err)
+
+		// the storage provider is not used
+		config.Storage.Storage = &mockstore.Provider{FailNameSpace: tokens.StoreName}
+		_, err = New(config)
+		require.NoError(t, err)
+	})
 }
 
 func TestOperation_GetRESTHandlers(t *testing.T) {
@@ -111,6 +230,24 @@ func TestOperation_OIDCLoginHandler(t *testing.T) {
 		require.NotEmpty(t, w.Header().Get("Location"))
 	})
 
+	t.Run("service unavailable under maintenance", func(t *testing.T) {
+		config := config(t)
+
+		var err error
+
+		config.Maintenance, err = maintenance.New(&maintenance.Config{Storage: memstore.NewProvider()})
+		require.NoError(t, err)
+		require.NoError(t, config.Maintenance.Set(&maintenance.Mode{Enabled: true, RetryAfter: 60}))
+
+		o, err := New(config)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		o.oidcLoginHandler(w, newOIDCLoginRequest())
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, "60", w.Header().Get("Retry-After"))
+		require.Empty(t, w.Header().Get("Location"))
+	})
+
 	t.Run("internal server error if cannot fetch session cookie", func(t *testing.T) {
 		config := config(t)
 		o, err := New(config)
@@ -151,11 +288,28 @@ func TestOperation_OIDCLoginHandler(t *testing.T) {
 		o.oidcLoginHandler(result, newOIDCLoginRequest())
 		require.Equal(t, http.StatusMovedPermanently, result.Code)
 	})
+
+	t.Run("redirects logged in user to the changed dashboard", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+		o.SetWalletDashboard("http://wallet.example.com/dashboard")
+		o.store.cookies = &cookie.MockStore{
+			Jar: &cookie.MockJar{
+				Cookies: map[interface{}]interface{}{
+					userSubCookieName: uuid.New().String(),
+				},
+			},
+		}
+		result := httptest.NewRecorder()
+		o.oidcLoginHandler(result, newOIDCLoginRequest())
+		require.Equal(t, http.StatusMovedPermanently, result.Code)
+		require.Equal(t, "http://wallet.example.com/dashboard", result.Header().Get("Location"))
+	})
 }
 
 func TestKmsSigner_Sign(t *testing.T) {
 	t.Run("failed to sign", func(t *testing.T) {
-		_, err := newKMSSigner("", "", "", &hubKMSHeader{},
+		_, err := newKMSSigner(context.Background(), "", "", "", &hubKMSHeader{},
 			&mockHTTPClient{
 				DoFunc: func(req *http.Request) (*http.Response, error) {
 					return &http.Response{
@@ -168,7 +322,7 @@ func TestKmsSigner_Sign(t *testing.T) {
 	})
 
 	t.Run("failed to unmarshal sign resp", func(t *testing.T) {
-		_, err := newKMSSigner("", "", "", &hubKMSHeader{},
+		_, err := newKMSSigner(context.Background(), "", "", "", &hubKMSHeader{},
 			&mockHTTPClient{
 				DoFunc: func(req *http.Request) (*http.Response, error) {
 					return &http.Response{
@@ -181,7 +335,7 @@ func TestKmsSigner_Sign(t *testing.T) {
 	})
 
 	t.Run("failed to unmarshal sign resp", func(t *testing.T) {
-		_, err := newKMSSigner("", "", "", &hubKMSHeader{},
+		_, err := newKMSSigner(context.Background(), "", "", "", &hubKMSHeader{},
 			&mockHTTPClient{
 				DoFunc: func(req *http.Request) (*http.Response, error) {
 					return &http.Response{
@@ -589,8 +743,15 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		ops := setupOnboardingTest(t, state)
 		ops.httpClient = &mockHTTPClient{
 			DoFunc: func(req *http.Request) (*http.Response, error) {
+				// the authz keystore is created while the secret is posted
+				statusCode := http.StatusCreated
+
+				if req.URL.Path == hubAuthSecretPath {
+					statusCode = http.StatusInternalServerError
+				}
+
 				return &http.Response{
-					StatusCode: http.StatusInternalServerError,
+					StatusCode: statusCode,
 					Body:       ioutil.NopCloser(bytes.NewReader([]byte(""))),
 				}, nil
 			},
@@ -675,6 +836,7 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 			},
 		}
 		ops.keyEDVClient = &mockEDVClient{}
+		ops.userEDVClient = &mockEDVClient{NoCapability: true}
 
 		w := httptest.NewRecorder()
 		ops.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
@@ -747,7 +909,7 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 			},
 		}
 		ops.keyEDVClient = &mockEDVClient{}
-		ops.userEDVClient = &mockEDVClient{CreateErr: errors.New("create error")}
+		ops.userEDVClient = &mockEDVClient{NoCapability: true}
 
 		w := httptest.NewRecorder()
 		ops.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
@@ -892,6 +1054,146 @@ func TestOperation_OIDCCallbackHandler(t *testing.T) { //nolint: gocritic,gocogn
 		require.Equal(t, http.StatusInternalServerError, w.Code)
 		require.Contains(t, w.Body.String(), "update user bootstrap data")
 	})
+
+	t.Run("signs the requests to the ops keystore with its capability", func(t *testing.T) {
+		state := uuid.New().String()
+		ops := setupOnboardingTest(t, state)
+		ops.keyServer = &KeyServerConfig{AuthzKMSURL: "http://authz.example.com", OpsKMSURL: "http://ops.example.com"}
+		ops.keyEDVClient = &mockEDVClient{NoCapability: true}
+		ops.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		var invocations []string
+
+		kms := mockKMSHTTPClient()
+		ops.httpClient = &http.Client{Transport: zcapsig.NewTransport(roundTripperFunc(
+			func(req *http.Request) (*http.Response, error) {
+				if req.Host == "ops.example.com" && strings.HasSuffix(req.URL.Path, "/keys") {
+					require.NotEmpty(t, req.Header.Get("Signature"))
+					invocations = append(invocations, req.Header.Get(zcapld.CapabilityInvocationHTTPHeader))
+				} else {
+					require.Empty(t, req.Header.Get(zcapld.CapabilityInvocationHTTPHeader))
+				}
+
+				resp, err := kms.Do(req)
+				if req.Host == "ops.example.com" && req.URL.Path == hubKMSCreateKeyStorePath {
+					resp.Header = http.Header{kmsCapabilityHeader: []string{
+						base64.URLEncoding.EncodeToString([]byte(`{"id":"root"}`)),
+					}}
+				}
+
+				return resp, err
+			}))}
+
+		w := httptest.NewRecorder()
+		ops.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Len(t, invocations, 2)
+
+		for _, invocation := range invocations {
+			require.True(t, strings.HasPrefix(invocation, "zcap capability="))
+			require.True(t, strings.HasSuffix(invocation, `,action="createKey"`))
+		}
+	})
+
+	t.Run("failure to decode the keystore capability", func(t *testing.T) {
+		state := uuid.New().String()
+		ops := setupOnboardingTest(t, state)
+		ops.httpClient = &mockHTTPClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				resp, err := mockKMSHTTPClient().Do(req)
+				resp.Header = http.Header{kmsCapabilityHeader: []string{"%invalid%"}}
+
+				return resp, err
+			},
+		}
+
+		w := httptest.NewRecorder()
+		ops.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "decode keystore capability")
+	})
+}
+
+func TestOperation_SessionBinding(t *testing.T) {
+	t.Run("binds the sessions to the clients of the users at login", func(t *testing.T) {
+		o := setupHooksTest(t)
+
+		var err error
+
+		o.sessionBinding, err = binding.New(&binding.Config{UserAgent: true})
+		require.NoError(t, err)
+
+		login(t, o, http.StatusFound)
+
+		_, bound := o.store.cookies.(*cookie.MockStore).Jar.Get("session_fingerprint")
+		require.True(t, bound)
+	})
+
+	t.Run("does not bind the sessions by default", func(t *testing.T) {
+		o := setupHooksTest(t)
+
+		login(t, o, http.StatusFound)
+
+		_, bound := o.store.cookies.(*cookie.MockStore).Jar.Get("session_fingerprint")
+		require.False(t, bound)
+	})
+}
+
+func TestOperation_Pseudonyms(t *testing.T) {
+	t.Run("keeps the subs of the users out of the stores and the keystores", func(t *testing.T) {
+		o := setupHooksTest(t)
+
+		provider := memstore.NewProvider()
+
+		ids, err := pseudonym.New(&pseudonym.Config{Storage: provider, HMACKey: key(t), EncryptionKey: key(t)})
+		require.NoError(t, err)
+
+		o.pseudonyms = ids
+
+		o.store.users, err = user.NewStore(provider, user.WithPseudonyms(ids))
+		require.NoError(t, err)
+
+		o.store.tokens, err = tokens.NewStore(provider)
+		require.NoError(t, err)
+
+		o.store.tokens = tokens.WithPseudonyms(o.store.tokens, ids)
+
+		kmsUsers := map[string]bool{}
+		kms := mockKMSHTTPClient()
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			if kmsUser := req.Header.Get("Hub-Kms-User"); kmsUser != "" {
+				kmsUsers[kmsUser] = true
+			}
+
+			return kms.DoFunc(req)
+		}}
+
+		sub := login(t, o, http.StatusFound)
+		require.Equal(t, map[string]bool{ids.ID(sub): true}, kmsUsers)
+
+		for _, name := range []string{user.StoreName, tokens.StoreName} {
+			s, openErr := provider.OpenStore(name)
+			require.NoError(t, openErr)
+
+			_, err = s.Get(sub)
+			require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+			raw, getErr := s.Get(ids.ID(sub))
+			require.NoError(t, getErr)
+			require.NotContains(t, string(raw), sub)
+		}
+
+		usr, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, sub, usr.Sub)
+
+		list, err := o.store.tokens.List()
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		require.Equal(t, sub, list[0].UserSub)
+	})
 }
 
 func TestOperation_UserProfileHandler(t *testing.T) {
@@ -1208,6 +1510,21 @@ func TestOperation_UserLogoutHandler(t *testing.T) {
 	})
 }
 
+func TestKeystoreLocations(t *testing.T) {
+	for _, location := range []string{
+		"/kms/keystores/123/keys/456",
+		"kms.example.com/kms/keystores/123/keys/456",
+		"https://kms.example.com/kms/keystores/123/keys/456",
+	} {
+		require.Equal(t, "123", getKeystoreID(location), location)
+		require.Equal(t, "456", getKeyID(location), location)
+	}
+
+	require.Equal(t, "123", getKeystoreID("https://kms.example.com/kms/keystores/123"))
+	require.Empty(t, getKeystoreID("https://kms.example.com/kms/keystores"))
+	require.Empty(t, getKeyID("https://kms.example.com/kms/keystores/123"))
+}
+
 func newOIDCLoginRequest() *http.Request {
 	return httptest.NewRequest(http.MethodGet, "/oidc/login", nil)
 }
@@ -1224,6 +1541,12 @@ func newUserLogoutRequest() *http.Request {
 	return httptest.NewRequest(http.MethodGet, "/oidc/logout", nil)
 }
 
+type exchangerFunc func(ctx context.Context, token, audience string) (*oauth2.Token, error)
+
+func (f exchangerFunc) ExchangeToken(ctx context.Context, token, audience string) (*oauth2.Token, error) {
+	return f(ctx, token, audience)
+}
+
 func config(t *testing.T) *Config {
 	t.Helper()
 
@@ -1301,6 +1624,12 @@ func setupOnboardingTest(t *testing.T, state string) *Operation {
 	return ops
 }
 
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 type mockHTTPClient struct {
 	DoFunc func(req *http.Request) (*http.Response, error)
 }
@@ -1309,6 +1638,26 @@ func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return m.DoFunc(req)
 }
 
+// mockKMSHTTPClient mocks successful responses from hub-auth and the KMS servers.
+func mockKMSHTTPClient() *mockHTTPClient {
+	return &mockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			statusCode := http.StatusCreated
+
+			if req.URL.Path == hubAuthSecretPath || req.URL.Path == hubAuthBootstrapDataPath ||
+				strings.Contains(req.URL.Path, "/export") ||
+				strings.Contains(req.URL.Path, "/sign") ||
+				strings.Contains(req.URL.Path, "/capability") {
+				statusCode = http.StatusOK
+			}
+
+			return &http.Response{
+				StatusCode: statusCode, Body: ioutil.NopCloser(bytes.NewReader([]byte("{}"))),
+			}, nil
+		},
+	}
+}
+
 type mockSplitter struct {
 	SplitErr   error
 	CombineErr error
@@ -1323,15 +1672,23 @@ func (m *mockSplitter) Combine(secretParts [][]byte) ([]byte, error) {
 }
 
 type mockEDVClient struct {
-	CreateErr error
+	CreateErr    error
+	NoCapability bool
+	Created      *models.DataVaultConfiguration
 }
 
-func (m *mockEDVClient) CreateDataVault(_ *models.DataVaultConfiguration,
-	_ ...client.ReqOption) (string, []byte, error) {
+func (m *mockEDVClient) CreateDataVault(config *models.DataVaultConfiguration,
+	_ ...sds.ReqOption) (string, []byte, error) {
+	m.Created = config
+
 	if m.CreateErr != nil {
 		return "", nil, m.CreateErr
 	}
 
+	if m.NoCapability {
+		return "http://edv.example.com/" + uuid.New().String(), nil, nil
+	}
+
 	c, err := zcapld.NewCapability(&zcapld.Signer{
 		SignatureSuite:     ed25519signature2018.New(suite.WithSigner(&mockSigner{})),
 		SuiteType:          ed25519signature2018.SignatureType,
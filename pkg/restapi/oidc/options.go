@@ -0,0 +1,90 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/sds"
+	"github.com/trustbloc/edge-core/pkg/sss"
+)
+
+// Option customizes the Operation built by New, for the servers embedding it and for tests.
+type Option func(o *Operation)
+
+// EDVClientFactory returns the client of the EDV server at the URL, sending its requests with the HTTP client
+// shared with hub-auth and the KMS servers.
+type EDVClientFactory func(url string, httpClient *http.Client) EDVClient
+
+// WithHTTPClient sends the requests to hub-auth and the KMS servers with the client, in place of the one
+// configured with TLSConfig, ClientCertificate and Proxy, e.g. to fake them in tests. See the oidctest package.
+func WithHTTPClient(client HTTPClient) Option {
+	return func(o *Operation) {
+		o.httpClient = client
+	}
+}
+
+// WithSecretSplitter splits the secrets of the new users shared between the agent and hub-auth with the
+// splitter, in place of the Shamir splitter of edge-core.
+func WithSecretSplitter(splitter sss.SecretSplitter) Option {
+	return func(o *Operation) {
+		o.secretSplitter = splitter
+	}
+}
+
+// WithEDVClientFactory creates the clients of KeyServer.KeyEDVURL and UserEDVURL with the factory.
+func WithEDVClientFactory(factory EDVClientFactory) Option {
+	return func(o *Operation) {
+		o.newEDVClient = factory
+	}
+}
+
+// WithClock reads the time from the clock in place of the system clock, to date and expire the records of the
+// Operation.
+func WithClock(now func() time.Time) Option {
+	return func(o *Operation) {
+		o.now = now
+	}
+}
+
+// WithRandomness reads the random bytes of the Operation from the reader in place of crypto/rand: those of the
+// states and nonces of the logins, of the secret keys of the new users and of the IDs of their vaults, exports,
+// attachments and backups, e.g. to replay a flow in tests. The reader is read from concurrently by the onboarding,
+// in no particular order.
+func WithRandomness(random io.Reader) Option {
+	return func(o *Operation) {
+		o.random = &lockedReader{r: random}
+	}
+}
+
+// lockedReader serializes the reads of the readers that are not safe for concurrent use, e.g. math/rand.
+type lockedReader struct {
+	mutex sync.Mutex
+	r     io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.r.Read(p)
+}
+
+// newID returns a random UUID read from the randomness of the Operation. Like uuid.New, it panics if the
+// randomness fails.
+func (o *Operation) newID() uuid.UUID {
+	return uuid.Must(uuid.NewRandomFromReader(o.random))
+}
+
+func newEDVClient(url string, httpClient *http.Client) EDVClient {
+	return sds.New(url, sds.WithHTTPClient(httpClient))
+}
@@ -0,0 +1,132 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_Options(t *testing.T) {
+	t.Run("creates the EDV clients with the factory", func(t *testing.T) {
+		config := config(t)
+		config.KeyServer.KeyEDVURL = "https://key-edv.example.com"
+		config.UserEDVURL = "https://user-edv.example.com"
+
+		clients := map[string]*mockEDVClient{}
+
+		o, err := New(config, WithEDVClientFactory(func(url string, httpClient *http.Client) EDVClient {
+			require.NotNil(t, httpClient)
+
+			clients[url] = &mockEDVClient{}
+
+			return clients[url]
+		}))
+		require.NoError(t, err)
+		require.Len(t, clients, 2)
+		require.Equal(t, clients[config.KeyServer.KeyEDVURL], o.keyEDVClient)
+		require.Equal(t, clients[config.UserEDVURL], o.userEDVClient)
+	})
+
+	t.Run("splits the secrets of the new users with the splitter", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupOnboardingTest(t, state)
+
+		WithSecretSplitter(&mockSplitter{SplitErr: errors.New("test")})(o)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "split user secret key")
+	})
+
+	t.Run("sends the requests with the HTTP client", func(t *testing.T) {
+		client := &mockHTTPClient{}
+
+		o, err := New(config(t), WithHTTPClient(client))
+		require.NoError(t, err)
+		require.Equal(t, client, o.httpClient)
+	})
+
+	t.Run("reads the time from the clock", func(t *testing.T) {
+		now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		config := config(t)
+		config.UserInfoCache = &UserInfoCacheConfig{TTL: time.Minute}
+		config.Lockout = &LockoutConfig{MaxFailures: 1, Duration: time.Minute}
+
+		o, err := New(config, WithClock(func() time.Time { return now }))
+		require.NoError(t, err)
+		require.Equal(t, now.Add(o.transientTTL), o.transientExpiry())
+
+		o.cacheUserInfo("sub", map[string]interface{}{"sub": "sub"})
+		_, ok := o.cachedUserInfo("sub")
+		require.True(t, ok)
+
+		lockout := o.lockouts.fail("user", "sub")
+		require.NotNil(t, lockout)
+		require.Equal(t, now.Add(time.Minute), *lockout.LockedUntil)
+
+		now = now.Add(2 * time.Minute)
+
+		_, ok = o.cachedUserInfo("sub")
+		require.False(t, ok)
+	})
+	t.Run("reads the randomness from the reader", func(t *testing.T) {
+		location := func() string {
+			o, err := New(config(t), WithRandomness(rand.New(rand.NewSource(1)))) // nolint:gosec // test randomness
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			o.oidcLoginHandler(w, newOIDCLoginRequest())
+			require.Equal(t, http.StatusFound, w.Code)
+
+			return w.Header().Get("Location")
+		}
+
+		require.Equal(t, location(), location())
+
+		o, err := New(config(t), WithRandomness(bytes.NewReader(bytes.Repeat([]byte{1}, 48))))
+		require.NoError(t, err)
+
+		edv := &mockEDVClient{NoCapability: true}
+
+		_, _, err = o.createEDVDataVault(context.Background(), edv, "did:example:123", "token")
+		require.NoError(t, err)
+		require.Equal(t, "01010101-0101-4101-8101-010101010101", edv.Created.ReferenceID)
+		require.Equal(t, "urn:uuid:01010101-0101-4101-8101-010101010101", edv.Created.KEK.ID)
+
+		// the reader is exhausted
+		require.Panics(t, func() { o.newID() })
+
+		_, err = o.provisionSet(context.Background(), "sub", "token")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "create user secret key")
+	})
+
+	t.Run("runs the janitor on the clock", func(t *testing.T) {
+		now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		config := config(t)
+		config.Janitor = &JanitorConfig{Interval: time.Hour}
+
+		o, err := New(config, WithClock(func() time.Time { return now }))
+		require.NoError(t, err)
+
+		o.janitor.run()
+		require.Equal(t, now, *o.janitor.Stats().LastRun)
+	})
+}
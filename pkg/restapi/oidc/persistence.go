@@ -0,0 +1,116 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"golang.org/x/oauth2"
+)
+
+// PersistenceError is the error of a login whose user and tokens could not both be persisted. The tokens of the
+// login are written ahead of the user, and restored to the ones of the previous login if the user cannot be, so
+// that no user is left without tokens.
+type PersistenceError struct {
+	Sub string
+	Err error
+	// RollbackErr is the error restoring the tokens, nil if they were. The user then keeps the tokens of the
+	// failed login, as a user whose onboarding failed does, which the next login or an onboarding by the admins
+	// repairs.
+	RollbackErr error
+}
+
+func (e *PersistenceError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("failed to persist login of user %s: %s (tokens not restored: %s)",
+			e.Sub, e.Err.Error(), e.RollbackErr.Error())
+	}
+
+	return fmt.Sprintf("failed to persist login of user %s: %s", e.Sub, e.Err.Error())
+}
+
+func (e *PersistenceError) Unwrap() error {
+	return e.Err
+}
+
+// loginWrites are the writes of a login, committed once its user is persisted.
+type loginWrites struct {
+	usr       *user.User
+	returning bool
+	events    *outbox.Tx
+	// the tokens of the previous login, nil if the user had none
+	previous *tokens.UserTokens
+}
+
+// beginLogin saves the tokens of the user's new session, and records the login events pending the commit of the
+// login.
+func (o *Operation) beginLogin(usr *user.User, token *oauth2.Token, returning bool) (*loginWrites, error) {
+	previous, err := o.store.tokens.Get(usr.Sub)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return nil, &PersistenceError{Sub: usr.Sub, Err: fmt.Errorf("failed to read user tokens: %w", err)}
+	}
+
+	events, err := o.beginLoginEvents(usr, returning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login events: %w", err)
+	}
+
+	now := o.now().UTC()
+
+	err = o.store.tokens.Save(&tokens.UserTokens{
+		UserSub:  usr.Sub,
+		Access:   token.AccessToken,
+		Refresh:  token.RefreshToken,
+		Issuer:   usr.Issuer,
+		IssuedAt: &now,
+	})
+	if err != nil {
+		rollbackEvents(events)
+
+		return nil, &PersistenceError{Sub: usr.Sub, Err: fmt.Errorf("failed to persist user tokens: %w", err)}
+	}
+
+	return &loginWrites{usr: usr, returning: returning, events: events, previous: previous}, nil
+}
+
+// commitLogin completes the login whose user is persisted.
+func (o *Operation) commitLogin(l *loginWrites) {
+	commitEvents(l.events)
+	o.invalidateUserInfo(l.usr.Sub)
+	o.audit(audit.ActionLogin, l.usr.Sub, nil)
+
+	if l.returning {
+		o.audit(audit.ActionTokensRefreshed, l.usr.Sub, nil)
+	}
+}
+
+// abortLogin restores the tokens of the login whose user could not be persisted, and discards its events.
+func (o *Operation) abortLogin(l *loginWrites, cause error) *PersistenceError {
+	rollbackEvents(l.events)
+
+	var err error
+
+	if l.previous != nil {
+		err = o.store.tokens.Save(l.previous)
+	} else {
+		err = o.store.tokens.Delete(l.usr.Sub)
+	}
+
+	persistenceErr := &PersistenceError{Sub: l.usr.Sub, Err: cause, RollbackErr: err}
+
+	if err != nil {
+		logger.Errorf("%s", persistenceErr.Error())
+	}
+
+	return persistenceErr
+}
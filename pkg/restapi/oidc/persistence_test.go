@@ -0,0 +1,133 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+	"golang.org/x/oauth2"
+)
+
+func TestOperation_LoginPersistence(t *testing.T) {
+	t.Run("leaves no tokens if the new user cannot be persisted", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupEventsTest(t, state)
+		o.httpClient = &mockHTTPClient{
+			DoFunc: func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("test")
+			},
+		}
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		all, err := o.store.tokens.List()
+		require.NoError(t, err)
+		require.Empty(t, all)
+
+		events, err := o.store.outbox.List()
+		require.NoError(t, err)
+		require.NotContains(t, topics(events), TopicUserLogin)
+	})
+
+	t.Run("restores the tokens of the previous login", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		usr := &user.User{Sub: uuid.New().String()}
+
+		login, err := o.beginLogin(usr, &oauth2.Token{AccessToken: "first", RefreshToken: "refresh"}, false)
+		require.NoError(t, err)
+		o.commitLogin(login)
+
+		login, err = o.beginLogin(usr, &oauth2.Token{AccessToken: "second"}, true)
+		require.NoError(t, err)
+
+		saved, err := o.store.tokens.Get(usr.Sub)
+		require.NoError(t, err)
+		require.Equal(t, "second", saved.Access)
+
+		cause := errors.New("test")
+
+		persistenceErr := o.abortLogin(login, cause)
+		require.True(t, errors.Is(persistenceErr, cause))
+		require.NoError(t, persistenceErr.RollbackErr)
+		require.Contains(t, persistenceErr.Error(), "failed to persist login of user "+usr.Sub)
+
+		saved, err = o.store.tokens.Get(usr.Sub)
+		require.NoError(t, err)
+		require.Equal(t, "first", saved.Access)
+		require.Equal(t, "refresh", saved.Refresh)
+		require.NotNil(t, saved.IssuedAt)
+
+		login, err = o.beginLogin(&user.User{Sub: "new"}, &oauth2.Token{AccessToken: "access"}, false)
+		require.NoError(t, err)
+		require.NoError(t, o.abortLogin(login, cause).RollbackErr)
+
+		_, err = o.store.tokens.Get("new")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("reports the tokens it cannot restore", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		o.store.tokens, err = tokens.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{}, ErrDelete: errors.New("delete error"),
+		}})
+		require.NoError(t, err)
+
+		login, err := o.beginLogin(&user.User{Sub: "sub"}, &oauth2.Token{AccessToken: "access"}, false)
+		require.NoError(t, err)
+
+		persistenceErr := o.abortLogin(login, errors.New("test"))
+		require.Error(t, persistenceErr.RollbackErr)
+		require.Contains(t, persistenceErr.Error(), "tokens not restored: ")
+	})
+
+	t.Run("error if the tokens cannot be read or saved", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		o.store.tokens, err = tokens.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store: map[string][]byte{}, ErrPut: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		_, err = o.beginLogin(&user.User{Sub: "sub"}, &oauth2.Token{AccessToken: "access"}, false)
+
+		var persistenceErr *PersistenceError
+		require.True(t, errors.As(err, &persistenceErr))
+		require.Equal(t, "sub", persistenceErr.Sub)
+		require.Contains(t, err.Error(), "failed to persist user tokens")
+
+		o.store.tokens = &failingTokens{err: errors.New("test")}
+
+		_, err = o.beginLogin(&user.User{Sub: "sub"}, &oauth2.Token{AccessToken: "access"}, false)
+		require.True(t, errors.As(err, &persistenceErr))
+		require.Contains(t, err.Error(), "failed to read user tokens")
+	})
+}
+
+type failingTokens struct {
+	tokens.Store
+	err error
+}
+
+func (f *failingTokens) Get(string) (*tokens.UserTokens, error) {
+	return nil, f.err
+}
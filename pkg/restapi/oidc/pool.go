@@ -0,0 +1,275 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	provisioningPoolStoreName = "edgeagent_provisioning_pool"
+	defaultPoolInterval       = time.Minute
+	// rebinds a keystore provisioned ahead of the login of its user to the user
+	keystoreControllerEndpoint = "/kms/keystores/%s/controller"
+)
+
+// ProvisioningPoolConfig enables the pre-provisioning of the keystores and vaults of new users: the agent keeps
+// a pool of unassigned sets, so that the first login of a user only binds one of them to the user. Users are
+// provisioned on demand while the pool is empty.
+type ProvisioningPoolConfig struct {
+	// Size is the number of unassigned sets the pool keeps.
+	Size int
+	// Token returns the access token the sets are provisioned with, e.g. one of the client credentials grant of
+	// the agent.
+	Token func(ctx context.Context) (string, error)
+	// Interval between the refills of the pool. Defaults to 1m. The pool is also refilled once a set is claimed.
+	Interval time.Duration
+}
+
+// provisionedSet holds the keystores, keys and vaults of a user.
+type provisionedSet struct {
+	ID                 string         `json:"id"`
+	WalletSecretShare  string         `json:"walletSecretShare"`
+	HubAuthSecretShare []byte         `json:"hubAuthSecretShare"`
+	Data               *BootstrapData `json:"data"`
+}
+
+type provisioningPool struct {
+	store     storage.Store
+	size      int
+	token     func(ctx context.Context) (string, error)
+	interval  time.Duration
+	provision func(ctx context.Context, sub, accessToken string) (*provisionedSet, error)
+	// paused tells whether the refills are paused, e.g. under maintenance
+	paused func() bool
+	// serializes the accesses to the store of the sets
+	mutex  sync.Mutex
+	refill chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	done   chan struct{}
+}
+
+func newProvisioningPool(config *ProvisioningPoolConfig, provider storage.Provider,
+	provision func(ctx context.Context, sub, accessToken string) (*provisionedSet, error)) (*provisioningPool, error) {
+	s, err := store.Open(provider, provisioningPoolStoreName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &provisioningPool{
+		store:     s,
+		size:      config.Size,
+		token:     config.Token,
+		interval:  config.Interval,
+		provision: provision,
+		refill:    make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	if p.interval <= 0 {
+		p.interval = defaultPoolInterval
+	}
+
+	return p, nil
+}
+
+// Start refilling the pool in the background.
+func (p *provisioningPool) Start() {
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			if p.paused == nil || !p.paused() {
+				err := p.fill()
+				if err != nil {
+					logger.Errorf("provisioning pool: %s", err.Error())
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-p.refill:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop the pool, aborting the sets being provisioned.
+func (p *provisioningPool) Stop() {
+	p.once.Do(func() {
+		p.cancel()
+		<-p.done
+	})
+}
+
+// fill provisions sets until the pool is full.
+func (p *provisioningPool) fill() error {
+	p.mutex.Lock()
+	all, err := p.store.GetAll()
+	p.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to list sets: %w", err)
+	}
+
+	for missing := p.size - len(all); missing > 0 && p.ctx.Err() == nil; missing-- {
+		accessToken, err := p.token(p.ctx)
+		if err != nil {
+			return fmt.Errorf("failed to fetch access token: %w", err)
+		}
+
+		// the pool provisions the set as if it was a user of its own, identified by the set
+		id := uuid.New().String()
+
+		set, err := p.provision(p.ctx, id, accessToken)
+		if err != nil {
+			return fmt.Errorf("failed to provision set: %w", err)
+		}
+
+		set.ID = id
+
+		p.mutex.Lock()
+		err = store.Save(p.store, id, set)
+		p.mutex.Unlock()
+
+		if err != nil {
+			return fmt.Errorf("failed to save set: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// claim removes a set from the pool. It returns nil if the pool is empty.
+func (p *provisioningPool) claim() (*provisionedSet, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	all, err := p.store.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sets: %w", err)
+	}
+
+	for id, bits := range all {
+		set := &provisionedSet{}
+
+		err = json.Unmarshal(bits, set)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal set: %w", err)
+		}
+
+		err = p.store.Delete(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete set: %w", err)
+		}
+
+		select {
+		case p.refill <- struct{}{}:
+		default:
+		}
+
+		return set, nil
+	}
+
+	return nil, nil
+}
+
+// claimProvisionedSet binds a set of the pool to the user. It returns nil if the pool is empty or the set cannot
+// be bound, in which case the user is provisioned on demand.
+func (o *Operation) claimProvisionedSet(ctx context.Context, sub, accessToken string) *provisionedSet {
+	if o.pool == nil {
+		return nil
+	}
+
+	set, err := o.pool.claim()
+	if err != nil {
+		logger.Warnf("failed to claim provisioned set: %s", err.Error())
+
+		return nil
+	}
+
+	if set == nil {
+		logger.Infof("provisioning pool is empty: provisioning user %s on demand", sub)
+
+		return nil
+	}
+
+	err = o.bindProvisionedSet(ctx, sub, accessToken, set)
+	if err != nil {
+		logger.Warnf("failed to bind provisioned set %s to user %s: %s", set.ID, sub, err.Error())
+
+		return nil
+	}
+
+	return set
+}
+
+// bindProvisionedSet hands the hub-auth half of the secret of the set to the user and rebinds the authz keystore
+// of the set to the user. The other keystores and vaults are controlled by the authz key.
+func (o *Operation) bindProvisionedSet(ctx context.Context, sub, accessToken string, set *provisionedSet) error {
+	stepCtx, cancel := o.onboardStep(ctx)
+	err := postSecret(stepCtx, o.hubAuthURL, accessToken, set.HubAuthSecretShare, o.httpClient)
+	cancel()
+
+	if err != nil {
+		return fmt.Errorf("post half secret to hub-auth : %w", err)
+	}
+
+	h := &hubKMSHeader{userSub: o.pseudonyms.ID(sub), accessToken: accessToken, secretShare: set.WalletSecretShare}
+
+	stepCtx, cancel = o.onboardStep(ctx)
+	defer cancel()
+
+	return updateKeyStoreController(stepCtx, o.keyServer.AuthzKMSURL, getKeystoreID(set.Data.AuthzKeyStoreURL),
+		h, o.httpClient)
+}
+
+func updateKeyStoreController(ctx context.Context, baseURL, keystoreID string, h *hubKMSHeader,
+	httpClient HTTPClient) error {
+	reqBytes, err := json.Marshal(updateControllerReq{
+		Controller: h.userSub,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal update controller req : %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx,
+		http.MethodPost, baseURL+fmt.Sprintf(keystoreControllerEndpoint, keystoreID), bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return err
+	}
+
+	addAuthZKMSHeaders(req, h)
+
+	_, _, err = sendHTTPRequest(req, httpClient, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("update keystore controller : %w", err)
+	}
+
+	return nil
+}
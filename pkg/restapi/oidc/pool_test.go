@@ -0,0 +1,200 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+)
+
+func TestProvisioningPool(t *testing.T) {
+	provision := func(_ context.Context, sub, accessToken string) (*provisionedSet, error) {
+		return &provisionedSet{
+			WalletSecretShare: sub,
+			Data:              &BootstrapData{AuthzKeyStoreURL: "/kms/keystores/" + sub, OpsEDVVaultURL: accessToken},
+		}, nil
+	}
+	token := func(context.Context) (string, error) {
+		return "pool", nil
+	}
+
+	t.Run("fills the pool and hands out its sets", func(t *testing.T) {
+		p, err := newProvisioningPool(&ProvisioningPoolConfig{Size: 2, Token: token}, memstore.NewProvider(), provision)
+		require.NoError(t, err)
+		require.NoError(t, p.fill())
+
+		first, err := p.claim()
+		require.NoError(t, err)
+		require.NotNil(t, first)
+		require.Equal(t, first.ID, first.WalletSecretShare)
+		require.Equal(t, "pool", first.Data.OpsEDVVaultURL)
+
+		second, err := p.claim()
+		require.NoError(t, err)
+		require.NotNil(t, second)
+		require.NotEqual(t, first.ID, second.ID)
+
+		empty, err := p.claim()
+		require.NoError(t, err)
+		require.Nil(t, empty)
+	})
+
+	t.Run("refills the pool in the background once a set is claimed", func(t *testing.T) {
+		var mutex sync.Mutex
+
+		provisioned := 0
+
+		p, err := newProvisioningPool(&ProvisioningPoolConfig{Size: 1, Token: token, Interval: time.Hour},
+			memstore.NewProvider(), func(ctx context.Context, sub, accessToken string) (*provisionedSet, error) {
+				mutex.Lock()
+				provisioned++
+				mutex.Unlock()
+
+				return provision(ctx, sub, accessToken)
+			})
+		require.NoError(t, err)
+
+		p.Start()
+		defer p.Stop()
+
+		require.Eventually(t, func() bool {
+			set, claimErr := p.claim()
+			require.NoError(t, claimErr)
+
+			return set != nil
+		}, time.Second, 10*time.Millisecond)
+
+		require.Eventually(t, func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return provisioned == 2
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("error if the access token cannot be fetched", func(t *testing.T) {
+		p, err := newProvisioningPool(&ProvisioningPoolConfig{
+			Size: 1,
+			Token: func(context.Context) (string, error) {
+				return "", errors.New("test")
+			},
+		}, memstore.NewProvider(), provision)
+		require.NoError(t, err)
+
+		err = p.fill()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch access token: test")
+	})
+
+	t.Run("error if a set cannot be provisioned", func(t *testing.T) {
+		p, err := newProvisioningPool(&ProvisioningPoolConfig{Size: 1, Token: token}, memstore.NewProvider(),
+			func(context.Context, string, string) (*provisionedSet, error) {
+				return nil, errors.New("test")
+			})
+		require.NoError(t, err)
+
+		err = p.fill()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to provision set: test")
+	})
+}
+
+func TestOperation_OnboardingFromPool(t *testing.T) {
+	setup := func(t *testing.T, state string, controllerStatus int) (*Operation, *[]string) {
+		t.Helper()
+
+		o := setupOnboardingTest(t, state)
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		var mutex sync.Mutex
+
+		var paths []string
+
+		kms := mockKMSHTTPClient()
+		o.httpClient = &mockHTTPClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				mutex.Lock()
+				paths = append(paths, req.URL.Path)
+				mutex.Unlock()
+
+				resp, err := kms.DoFunc(req)
+				if strings.HasSuffix(req.URL.Path, "/controller") {
+					resp.StatusCode = controllerStatus
+				}
+
+				return resp, err
+			},
+		}
+
+		var err error
+
+		o.pool, err = newProvisioningPool(&ProvisioningPoolConfig{
+			Size: 1,
+			Token: func(context.Context) (string, error) {
+				return "pool", nil
+			},
+		}, memstore.NewProvider(), o.provisionSet)
+		require.NoError(t, err)
+		require.NoError(t, o.pool.fill())
+
+		paths = nil
+
+		return o, &paths
+	}
+
+	t.Run("binds a provisioned set to the new user", func(t *testing.T) {
+		state := uuid.New().String()
+		o, paths := setup(t, state, http.StatusOK)
+
+		all, err := o.pool.store.GetAll()
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		// only the calls binding the set to the user are left on the login path
+		require.Len(t, *paths, 3)
+		require.Equal(t, hubAuthSecretPath, (*paths)[0])
+		require.True(t, strings.HasSuffix((*paths)[1], "/controller"))
+		require.Equal(t, hubAuthBootstrapDataPath, (*paths)[2])
+
+		sub, ok := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.True(t, ok)
+
+		usr, err := o.store.users.Get(sub.(string))
+		require.NoError(t, err)
+		require.NotEmpty(t, usr.SecretShare)
+
+		set, err := o.pool.claim()
+		require.NoError(t, err)
+		require.Nil(t, set)
+	})
+
+	t.Run("provisions the new user on demand if the set cannot be bound", func(t *testing.T) {
+		state := uuid.New().String()
+		o, paths := setup(t, state, http.StatusInternalServerError)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Greater(t, len(*paths), 3)
+	})
+}
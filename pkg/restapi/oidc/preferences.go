@@ -0,0 +1,169 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	preferencesPath = "/preferences"
+	// the preferences are a single document of a store of the user's vault of their own.
+	preferencesStoreName = "preferences"
+	preferencesKey       = "preferences"
+	maxPreferencesSize   = 16 * 1024
+)
+
+// preferencesSchema validates the preferences the agent knows of. The other preferences of the wallet UI are kept
+// as they are sent.
+const preferencesSchema = `{
+  "type": "object",
+  "properties": {
+    "locale": {"type": "string", "pattern": "^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$"},
+    "theme": {"type": "string", "enum": ["light", "dark", "system"]},
+    "defaultDID": {"type": "string", "pattern": "^did:[a-z0-9]+:.+$"}
+  }
+}`
+
+// Preferences of a user of the wallet UI, kept in the user's EDV vault so that they follow the user across
+// devices. The wallet UI may add preferences of its own.
+type Preferences struct {
+	// Locale is a BCP 47 language tag, e.g. en-CA.
+	Locale string `json:"locale,omitempty"`
+	// Theme is one of light, dark or system.
+	Theme string `json:"theme,omitempty"`
+	// DefaultDID is the DID the wallet presents with unless the user picks another.
+	DefaultDID string `json:"defaultDID,omitempty"`
+}
+
+func (o *Operation) preferencesHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(preferencesPath, http.MethodGet, o.getPreferencesHandler, &common.OperationSpec{
+			Summary: "Returns the preferences of the user logged in, empty if the user has none yet.",
+			Params: []common.Param{
+				common.HeaderParam("If-None-Match", "ETag of the preferences the client has."),
+			},
+			Responses: map[int]interface{}{
+				http.StatusOK:          &Preferences{},
+				http.StatusNotModified: nil,
+			},
+		}),
+		common.NewHTTPHandler(preferencesPath, http.MethodPut, o.putPreferencesHandler, &common.OperationSpec{
+			Summary: "Replaces the preferences of the user logged in.",
+			Request: &Preferences{},
+			Responses: map[int]interface{}{
+				http.StatusOK:                    &Preferences{},
+				http.StatusBadRequest:            nil,
+				http.StatusRequestEntityTooLarge: nil,
+				http.StatusInsufficientStorage:   nil,
+			},
+		}),
+	}
+}
+
+func (o *Operation) getPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling get preferences request")
+
+	sub, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	preferences, err := o.openVaultStore(sub, preferencesStoreName)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to open vault: %s", err.Error())
+
+		return
+	}
+
+	raw, err := preferences.Get(preferencesKey)
+	if errors.Is(err, ariesstorage.ErrDataNotFound) {
+		raw = []byte("{}")
+	} else if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to read preferences: %s", err.Error())
+
+		return
+	}
+
+	common.WriteConditionalResponse(w, r, logger, json.RawMessage(raw))
+}
+
+func (o *Operation) putPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling put preferences request")
+
+	sub, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPreferencesSize+1))
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to read preferences: %s", err.Error())
+
+		return
+	}
+
+	if len(raw) > maxPreferencesSize {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusRequestEntityTooLarge, "preferences exceed %d bytes", maxPreferencesSize)
+
+		return
+	}
+
+	err = o.validatePreferences(raw)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid preferences: %s", err.Error())
+
+		return
+	}
+
+	preferences, err := o.openVaultStore(sub, preferencesStoreName)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "failed to open vault: %s", err.Error())
+
+		return
+	}
+
+	err = preferences.Put(preferencesKey, raw)
+	if err != nil {
+		writeVaultErrorResponse(w, err, "failed to save preferences")
+
+		return
+	}
+
+	common.WriteResponse(w, logger, json.RawMessage(raw))
+}
+
+// validatePreferences validates the preferences against the schema, listing every violation.
+func (o *Operation) validatePreferences(raw []byte) error {
+	result, err := o.preferencesSchema.Validate(gojsonschema.NewBytesLoader(raw))
+	if err != nil {
+		return err
+	}
+
+	if result.Valid() {
+		return nil
+	}
+
+	violations := make([]string, len(result.Errors()))
+
+	for i, violation := range result.Errors() {
+		violations[i] = violation.String()
+	}
+
+	return errors.New(strings.Join(violations, "; "))
+}
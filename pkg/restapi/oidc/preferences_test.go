@@ -0,0 +1,142 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestOperation_Preferences(t *testing.T) {
+	t.Run("stores the preferences in the vault and returns them", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+
+		w := getPreferences(o)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{}`, w.Body.String())
+
+		preferences := `{"locale": "fr-CA", "theme": "dark", "defaultDID": "did:key:z6Mk", "fontSize": 14}`
+
+		w = putPreferences(o, preferences)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, preferences, w.Body.String())
+
+		w = getPreferences(o)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, preferences, w.Body.String())
+
+		store, err := vault.OpenStore(preferencesStoreName)
+		require.NoError(t, err)
+
+		raw, err := store.Get(preferencesKey)
+		require.NoError(t, err)
+		require.JSONEq(t, preferences, string(raw))
+	})
+
+	t.Run("answers not modified if the client has the preferences", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+
+		w := getPreferences(o)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, common.RevalidateCacheControl, w.Header().Get("Cache-Control"))
+
+		etag := w.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		r := httptest.NewRequest(http.MethodGet, preferencesPath, nil)
+		r.Header.Set("If-None-Match", etag)
+
+		w = httptest.NewRecorder()
+		o.getPreferencesHandler(w, r)
+		require.Equal(t, http.StatusNotModified, w.Code)
+		require.Empty(t, w.Body.String())
+
+		require.Equal(t, http.StatusOK, putPreferences(o, `{"theme": "dark"}`).Code)
+
+		w = httptest.NewRecorder()
+		o.getPreferencesHandler(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotEqual(t, etag, w.Header().Get("ETag"))
+		require.JSONEq(t, `{"theme": "dark"}`, w.Body.String())
+	})
+
+	t.Run("bad request if the preferences do not match the schema", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+
+		for body, violation := range map[string]string{
+			`{"theme": "blue"}`:                  "theme",
+			`{"locale": "english please"}`:       "locale",
+			`{"defaultDID": "z6Mk"}`:             "defaultDID",
+			`["dark"]`:                           "Invalid type",
+			`{"theme": "dark", "locale": false}`: "locale",
+			`{"theme":`:                          "invalid preferences",
+		} {
+			w := putPreferences(o, body)
+			require.Equal(t, http.StatusBadRequest, w.Code, body)
+			require.Contains(t, w.Body.String(), "invalid preferences", body)
+			require.Contains(t, w.Body.String(), violation, body)
+		}
+
+		store, err := vault.OpenStore(preferencesStoreName)
+		require.NoError(t, err)
+
+		_, err = store.Get(preferencesKey)
+		require.True(t, errors.Is(err, ariesstorage.ErrDataNotFound))
+	})
+
+	t.Run("request entity too large if the preferences exceed the limit", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+
+		w := putPreferences(o, `{"note": "`+strings.Repeat("a", maxPreferencesSize)+`"}`)
+		require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("error if the vault cannot be opened", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+		o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+			return nil, errors.New("test")
+		}
+
+		w := getPreferences(o)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to open vault")
+
+		w = putPreferences(o, `{}`)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to open vault")
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o, _, _ := setupBackupTest(t)
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{}}}
+
+		require.Equal(t, http.StatusForbidden, getPreferences(o).Code)
+		require.Equal(t, http.StatusForbidden, putPreferences(o, `{}`).Code)
+	})
+}
+
+func getPreferences(o *Operation) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	o.getPreferencesHandler(w, httptest.NewRequest(http.MethodGet, preferencesPath, nil))
+
+	return w
+}
+
+func putPreferences(o *Operation, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	o.putPreferencesHandler(w, httptest.NewRequest(http.MethodPut, preferencesPath, strings.NewReader(body)))
+
+	return w
+}
@@ -0,0 +1,75 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/claims"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+// the keys of the user data the wallet adds to the user info claims.
+const (
+	bootstrapDataKey = "bootstrap"
+	userConfigKey    = "userConfig"
+)
+
+// mapClaims runs the configured claims mapping pipeline over the raw id_token claims.
+func (o *Operation) mapClaims(t oidc.Claimer, usr *user.User) error {
+	if o.claimsMapper == nil {
+		return nil
+	}
+
+	raw, err := claims.FromToken(t)
+	if err != nil {
+		return err
+	}
+
+	return o.claimsMapper.Map(raw, usr)
+}
+
+// exposedUserData filters the user info claims of the user data sent to the wallet UI. The bootstrap data and the
+// user config are the wallet's own and always sent.
+func (o *Operation) exposedUserData(data map[string]interface{}) map[string]interface{} {
+	exposed := o.claimsFilter.Apply(data)
+
+	for _, key := range []string{bootstrapDataKey, userConfigKey} {
+		if value, ok := data[key]; ok {
+			exposed[key] = value
+		}
+	}
+
+	return exposed
+}
+
+// refreshProfile updates the stored profile of a returning user with the latest claims.
+func (o *Operation) refreshProfile(stored, latest *user.User) error {
+	updated := *stored
+	updated.MergeProfile(latest)
+
+	if reflect.DeepEqual(&updated, stored) {
+		return nil
+	}
+
+	err := o.store.users.Save(&updated)
+	if !errors.Is(err, user.ErrConflict) {
+		return err
+	}
+
+	// another instance saved the user since it was read
+	_, err = o.updateUser(latest.Sub, func(usr *user.User) bool {
+		current := *usr
+		usr.MergeProfile(latest)
+
+		return !reflect.DeepEqual(usr, &current)
+	})
+
+	return err
+}
@@ -0,0 +1,77 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/claims"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"golang.org/x/oauth2"
+)
+
+func TestOperation_ClaimsMapping(t *testing.T) {
+	t.Run("updates the profile of a returning user", func(t *testing.T) {
+		sub := uuid.New().String()
+		state := uuid.New().String()
+		o := setupOnboardingTest(t, state)
+		o.oidcClient = &oidc2.MockClient{
+			OAuthToken: &oauth2.Token{AccessToken: uuid.New().String()},
+			IDToken:    idToken(t, map[string]interface{}{"sub": sub, "name": "new name", "dept": "R&D"}),
+		}
+		o.claimsMapper = claims.NewPipeline(claims.Standard(), claims.Attributes(map[string]string{"dept": "department"}))
+
+		require.NoError(t, o.store.users.Save(&user.User{Sub: sub, Name: "old name", SecretShare: "share"}))
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		stored, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, "new name", stored.Name)
+		require.Equal(t, "R&D", stored.Attributes["department"])
+		require.Equal(t, "share", stored.SecretShare)
+	})
+
+	t.Run("internal server error if claims mapping fails", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupOnboardingTest(t, state)
+		o.oidcClient = &oidc2.MockClient{
+			OAuthToken: &oauth2.Token{AccessToken: uuid.New().String()},
+			IDToken:    idToken(t, map[string]interface{}{"sub": uuid.New().String()}),
+		}
+		o.claimsMapper = claims.MapperFunc(func(map[string]interface{}, *user.User) error {
+			return errors.New("test")
+		})
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest("code", state))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to map user claims")
+	})
+}
+
+// idToken returns a mock id_token carrying the given claims.
+func idToken(t *testing.T, claims map[string]interface{}) *oidc2.MockClaimer {
+	t.Helper()
+
+	raw := marshal(t, claims)
+
+	return &oidc2.MockClaimer{
+		ClaimsFunc: func(i interface{}) error {
+			return json.Unmarshal(raw, i)
+		},
+	}
+}
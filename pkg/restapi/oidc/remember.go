@@ -0,0 +1,69 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+const (
+	rememberParam = "remember"
+	// the user asked to be remembered at login.
+	rememberCookieName = "remember_me"
+)
+
+// askToRemember records in the session whether the user logging in asked to be remembered.
+func (o *Operation) askToRemember(r *http.Request, jar cookie.Jar) {
+	if o.remember != nil && r.URL.Query().Get(rememberParam) == "true" {
+		jar.Set(rememberCookieName, true)
+	} else {
+		jar.Delete(rememberCookieName)
+	}
+}
+
+// rememberUser issues a remember-me token to the user logging in, if the user asked for it. The user is logged in
+// all the same if the token cannot be issued.
+func (o *Operation) rememberUser(w http.ResponseWriter, jar cookie.Jar, sub string) {
+	if _, asked := jar.Get(rememberCookieName); !asked {
+		return
+	}
+
+	jar.Delete(rememberCookieName)
+
+	if o.remember == nil {
+		return
+	}
+
+	token, err := o.remember.Issue(sub)
+	if err != nil {
+		logger.Warnf("failed to remember user: %s", err.Error())
+
+		return
+	}
+
+	remember.SetCookie(w, token)
+}
+
+// forgetUser revokes the remember-me token of the browser logging out.
+func (o *Operation) forgetUser(w http.ResponseWriter, r *http.Request) {
+	value, found := remember.FromRequest(r)
+	if !found {
+		return
+	}
+
+	if o.remember != nil {
+		err := o.remember.Revoke(value)
+		if err != nil {
+			logger.Warnf("failed to revoke remember-me token: %s", err.Error())
+		}
+	}
+
+	remember.ClearCookie(w)
+}
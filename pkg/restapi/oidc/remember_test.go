@@ -0,0 +1,183 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/remember"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+	"golang.org/x/oauth2"
+)
+
+func TestOperation_Remember(t *testing.T) {
+	t.Run("remembers the users who ask for it at login", func(t *testing.T) {
+		o, sub := setupRememberTest(t)
+
+		w := loginAndCallback(t, o, "/oidc/login?remember=true")
+		require.Equal(t, http.StatusFound, w.Code)
+
+		issued := rememberCookie(w)
+		require.NotNil(t, issued)
+		require.Greater(t, issued.MaxAge, 0)
+
+		_, asked := o.store.cookies.(*cookie.MockStore).Jar.Get(rememberCookieName)
+		require.False(t, asked)
+
+		token, err := o.remember.Rotate(issued.Value)
+		require.NoError(t, err)
+		require.Equal(t, sub, token.Sub)
+	})
+
+	t.Run("does not remember the users who do not ask for it", func(t *testing.T) {
+		o, _ := setupRememberTest(t)
+
+		w := loginAndCallback(t, o, "/oidc/login")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Nil(t, rememberCookie(w))
+	})
+
+	t.Run("does not remember the users if disabled", func(t *testing.T) {
+		o, _ := setupRememberTest(t)
+		o.remember = nil
+
+		w := loginAndCallback(t, o, "/oidc/login?remember=true")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Nil(t, rememberCookie(w))
+	})
+
+	t.Run("remembers the new users once they accept the terms", func(t *testing.T) {
+		state := uuid.New().String()
+		o := setupConsentTest(t, state)
+		o.remember = rememberStore(t)
+
+		jar := o.store.cookies.(*cookie.MockStore).Jar
+		jar.Set(rememberCookieName, true)
+
+		w := httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state))
+		require.Equal(t, consentPage, w.Header().Get("Location"))
+		require.Nil(t, rememberCookie(w))
+
+		w = httptest.NewRecorder()
+		o.consentHandler(w, newConsentRequest(`{"version": "v2"}`))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotNil(t, rememberCookie(w))
+	})
+
+	t.Run("logs the users in all the same if they cannot be remembered", func(t *testing.T) {
+		o, _ := setupRememberTest(t)
+
+		p := mockstore.NewMockStoreProvider()
+		p.Store.ErrPut = errors.New("test")
+
+		var err error
+
+		o.remember, err = remember.NewStore(p, time.Hour)
+		require.NoError(t, err)
+
+		w := loginAndCallback(t, o, "/oidc/login?remember=true")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Nil(t, rememberCookie(w))
+
+		_, loggedIn := o.store.cookies.(*cookie.MockStore).Jar.Get(userSubCookieName)
+		require.True(t, loggedIn)
+	})
+
+	t.Run("forgets the users on logout", func(t *testing.T) {
+		o, sub := setupRememberTest(t)
+		o.store.cookies.(*cookie.MockStore).Jar.Set(userSubCookieName, sub)
+
+		issued, err := o.remember.Issue(sub)
+		require.NoError(t, err)
+
+		r := newUserLogoutRequest()
+		r.AddCookie(&http.Cookie{Name: remember.CookieName, Value: issued.Value})
+
+		w := httptest.NewRecorder()
+		o.userLogoutHandler(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, -1, rememberCookie(w).MaxAge)
+
+		_, err = o.remember.Rotate(issued.Value)
+		require.True(t, errors.Is(err, remember.ErrInvalidToken))
+	})
+}
+
+// setupRememberTest returns an Operation remembering the users who ask for it, and the sub of a stored user the
+// OIDC provider logs in.
+func setupRememberTest(t *testing.T) (*Operation, string) {
+	t.Helper()
+
+	o, sub := setupCheckTest(t)
+	o.remember = rememberStore(t)
+	o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{}}
+	o.oidcClient = &oidc2.MockClient{
+		AuthRequest: "https://op.example.com/authorize",
+		OAuthToken:  &oauth2.Token{AccessToken: uuid.New().String(), RefreshToken: uuid.New().String()},
+		IDToken: &oidc2.MockClaimer{
+			ClaimsFunc: func(i interface{}) error {
+				usr, ok := i.(*user.User)
+				require.True(t, ok)
+
+				usr.Sub = sub
+
+				return nil
+			},
+		},
+	}
+
+	return o, sub
+}
+
+func rememberStore(t *testing.T) *remember.Store {
+	t.Helper()
+
+	s, err := remember.NewStore(memstore.NewProvider(), time.Hour)
+	require.NoError(t, err)
+
+	return s
+}
+
+// loginAndCallback logs the user in and returns the response of the callback.
+func loginAndCallback(t *testing.T, o *Operation, login string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.oidcLoginHandler(w, httptest.NewRequest(http.MethodGet, login, nil))
+	require.Equal(t, http.StatusFound, w.Code)
+	require.True(t, strings.HasPrefix(w.Header().Get("Location"), "https://op.example.com/authorize"))
+
+	state, found := o.store.cookies.(*cookie.MockStore).Jar.Get(stateCookieName)
+	require.True(t, found)
+
+	w = httptest.NewRecorder()
+	o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state.(string)))
+
+	return w
+}
+
+func rememberCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() { // nolint:bodyclose // recorded response
+		if c.Name == remember.CookieName {
+			return c
+		}
+	}
+
+	return nil
+}
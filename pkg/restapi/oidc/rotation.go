@@ -0,0 +1,604 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/webkms"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/jobs"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	vaultRotationPath        = "/vaults/rotation"
+	rotationStoreName        = "edgeagent_rotations"
+	defaultRotationBatchSize = 100
+	// the schedule looks for the vaults due for a rotation this often.
+	rotationCheckInterval = time.Hour
+)
+
+// KeyRotationConfig enables the rotation of the keys of the users' EDV vaults: new operational and HMAC keys are
+// created in the user's ops keystore, the documents of the vault are re-encrypted with them and the bootstrap data
+// of the user is updated. The rotations are triggered by the admin API, and by a schedule if Interval is set.
+// The old keys are left in the keystore.
+type KeyRotationConfig struct {
+	// Interval is the age of the keys rotated by the schedule. The keys that were never rotated are rotated at the
+	// first check of the schedule. The keys are only rotated on request of the admins if zero.
+	Interval time.Duration
+	// BatchSize is the number of documents re-encrypted between two saves of the progress. Defaults to 100.
+	BatchSize int
+	// Stores are the stores of the vaults whose documents are re-encrypted. Defaults to the credentials store.
+	Stores []string
+}
+
+// VaultRotation is the progress of the rotation of the keys of a user's vault.
+type VaultRotation struct {
+	Sub string `json:"sub"`
+	// Status is one of the onboarding statuses.
+	Status string `json:"status"`
+	// Migrated is the number of documents re-encrypted with the new keys.
+	Migrated    int        `json:"migrated"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	// Keys are the new keys of the rotation under way, so that a failed rotation resumes with them.
+	Keys *VaultKeys `json:"keys,omitempty"`
+}
+
+// VaultKeys are the keys the documents of a vault are encrypted and indexed with.
+type VaultKeys struct {
+	EDVOpsKIDURL  string `json:"edvOpsKIDURL"`
+	EDVHMACKIDURL string `json:"edvHMACKIDURL"`
+}
+
+// vaultKeyCreator creates new keys for the user's EDV vault in the ops keystore of the bootstrap data.
+type vaultKeyCreator func(data *BootstrapData, h *hubKMSHeader) (*VaultKeys, error)
+
+type rotations struct {
+	store     storage.Store
+	ids       *pseudonym.Mapper
+	queue     *jobs.Queue
+	interval  time.Duration
+	batchSize int
+	stores    []string
+	// serializes the check-and-enqueue of the rotations of the same user
+	mutex sync.Mutex
+	stop  chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+func newRotations(config *KeyRotationConfig, provider storage.Provider, ids *pseudonym.Mapper) (*rotations, error) {
+	s, err := store.Open(provider, rotationStoreName)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rotations{
+		store: s,
+		ids:   ids,
+		// a single worker keeps the rotations from competing with the logins of the users
+		queue:     jobs.NewQueue(&jobs.Config{Workers: 1}),
+		interval:  config.Interval,
+		batchSize: config.BatchSize,
+		stores:    config.Stores,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if r.batchSize <= 0 {
+		r.batchSize = defaultRotationBatchSize
+	}
+
+	if len(r.stores) == 0 {
+		r.stores = []string{credentialsStoreName}
+	}
+
+	r.queue.Start()
+
+	return r, nil
+}
+
+// schedule calls check periodically until the rotations are stopped, if the keys are rotated by a schedule.
+func (r *rotations) schedule(check func(now time.Time)) {
+	if r.interval <= 0 {
+		close(r.done)
+
+		return
+	}
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(rotationCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				check(now)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the schedule and the rotations, waiting for the rotation under way.
+func (r *rotations) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+		r.queue.Stop()
+	})
+}
+
+func (r *rotations) get(sub string) (*VaultRotation, error) {
+	bits, err := r.store.Get(r.ids.ID(sub))
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &VaultRotation{}
+
+	err = json.Unmarshal(bits, rotation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vault rotation: %w", err)
+	}
+
+	rotation.Sub = sub
+
+	return rotation, nil
+}
+
+// put saves the rotation under the ID of the user, without the sub if the IDs are pseudonymous.
+func (r *rotations) put(rotation *VaultRotation) error {
+	if r.ids == nil {
+		return store.Save(r.store, rotation.Sub, rotation)
+	}
+
+	blind := *rotation
+	blind.Sub = ""
+
+	return store.Save(r.store, r.ids.ID(rotation.Sub), &blind)
+}
+
+func (r *rotations) save(rotation *VaultRotation) {
+	err := r.put(rotation)
+	if err != nil {
+		logger.Errorf("failed to save vault rotation of user %s: %s", rotation.Sub, err.Error())
+	}
+}
+
+func (o *Operation) vaultRotationHandlers() []common.Handler {
+	params := []common.Param{{Name: "sub", In: common.InQuery, Description: "Sub of the user.", Required: true}}
+
+	return []common.Handler{
+		common.NewHTTPHandler(vaultRotationPath, http.MethodPost, o.rotateVaultHandler, &common.OperationSpec{
+			Summary: "Rotates the keys of the EDV vault of a user and re-encrypts its documents in the background.",
+			Params:  params,
+			Responses: map[int]interface{}{
+				http.StatusAccepted: &VaultRotation{},
+			},
+		}),
+		common.NewHTTPHandler(vaultRotationPath, http.MethodGet, o.vaultRotationHandler, &common.OperationSpec{
+			Summary:   "Returns the progress of the last rotation of the keys of the EDV vault of a user.",
+			Params:    params,
+			Responses: map[int]interface{}{http.StatusOK: &VaultRotation{}},
+		}),
+	}
+}
+
+func (o *Operation) rotateVaultHandler(w http.ResponseWriter, r *http.Request) {
+	sub := r.URL.Query().Get("sub")
+	if sub == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing sub parameter")
+
+		return
+	}
+
+	_, err := o.store.users.Get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "user not found")
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to fetch user data: %s", err.Error())
+
+		return
+	}
+
+	rotation, err := o.scheduleRotation(sub)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, jobs.ErrQueueFull) || errors.Is(err, jobs.ErrQueueStopped) {
+			status = http.StatusServiceUnavailable
+		}
+
+		common.WriteErrorResponsef(w, logger, status, "%s", err.Error())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	common.WriteResponse(w, logger, rotation)
+}
+
+func (o *Operation) vaultRotationHandler(w http.ResponseWriter, r *http.Request) {
+	sub := r.URL.Query().Get("sub")
+	if sub == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing sub parameter")
+
+		return
+	}
+
+	rotation, err := o.rotations.get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "vault of user %s was never rotated", sub)
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to query vault rotation: %s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, rotation)
+}
+
+// scheduleRotation schedules the rotation of the keys of the user's vault, unless it is already under way. A
+// failed rotation resumes with the keys it created.
+func (o *Operation) scheduleRotation(sub string) (*VaultRotation, error) {
+	o.rotations.mutex.Lock()
+	defer o.rotations.mutex.Unlock()
+
+	rotation, err := o.rotations.get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		rotation, err = &VaultRotation{Sub: sub}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query vault rotation: %w", err)
+	}
+
+	if rotation.Status == OnboardingPending || rotation.Status == OnboardingRunning {
+		return rotation, nil
+	}
+
+	rotation.Status = OnboardingPending
+	rotation.Error = ""
+
+	err = o.rotations.put(rotation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save vault rotation: %w", err)
+	}
+
+	err = o.rotations.queue.Enqueue(func() {
+		o.runRotation(sub)
+	})
+	if err != nil {
+		rotation.Status = OnboardingFailed
+		rotation.Error = err.Error()
+		o.rotations.save(rotation)
+
+		return nil, fmt.Errorf("failed to schedule vault rotation: %w", err)
+	}
+
+	return rotation, nil
+}
+
+// rotateDueVaults schedules the rotation of the vaults of the users whose keys are older than the interval.
+func (o *Operation) rotateDueVaults(now time.Time) {
+	list, err := o.store.tokens.List()
+	if err != nil {
+		logger.Errorf("failed to list users for vault rotation: %s", err.Error())
+
+		return
+	}
+
+	for _, t := range list {
+		if !o.rotationDue(t.UserSub, now) {
+			continue
+		}
+
+		_, err = o.scheduleRotation(t.UserSub)
+		if errors.Is(err, jobs.ErrQueueFull) {
+			// the rest is scheduled by the next checks
+			return
+		}
+
+		if err != nil {
+			logger.Errorf("failed to schedule vault rotation of user %s: %s", t.UserSub, err.Error())
+		}
+	}
+}
+
+// rotationDue reports whether the keys of the user's vault were never rotated, or are older than the interval.
+func (o *Operation) rotationDue(sub string, now time.Time) bool {
+	rotation, err := o.rotations.get(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return true
+	}
+
+	if err != nil {
+		logger.Errorf("failed to query vault rotation of user %s: %s", sub, err.Error())
+
+		return false
+	}
+
+	return rotation.CompletedAt == nil || now.Sub(*rotation.CompletedAt) >= o.rotations.interval
+}
+
+func (o *Operation) runRotation(sub string) {
+	rotation, err := o.rotations.get(sub)
+	if err != nil {
+		logger.Errorf("failed to query vault rotation of user %s: %s", sub, err.Error())
+
+		return
+	}
+
+	started := o.now().UTC()
+	rotation.Status = OnboardingRunning
+	rotation.StartedAt = &started
+	rotation.CompletedAt = nil
+	o.rotations.save(rotation)
+
+	// the rotation outlives the request of the admin
+	err = o.rotateVaultKeys(context.Background(), rotation)
+	if err != nil {
+		logger.Errorf("failed to rotate vault keys of user %s: %s", sub, err.Error())
+
+		rotation.Status = OnboardingFailed
+		rotation.Error = err.Error()
+		o.rotations.save(rotation)
+		o.auditRotation(rotation, nil)
+
+		return
+	}
+
+	logger.Infof("rotated vault keys of user %s in %s: re-encrypted %d documents",
+		sub, time.Since(started), rotation.Migrated)
+
+	keys := rotation.Keys
+	completed := o.now().UTC()
+	rotation.Status = OnboardingCompleted
+	rotation.CompletedAt = &completed
+	rotation.Keys = nil
+	o.rotations.save(rotation)
+	o.auditRotation(rotation, keys)
+}
+
+// rotateVaultKeys creates the new keys of the user's vault, unless resuming, re-encrypts the documents of the vault
+// with them, then updates the user's bootstrap data.
+func (o *Operation) rotateVaultKeys(ctx context.Context, rotation *VaultRotation) error {
+	usr, err := o.store.users.Get(rotation.Sub)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user data: %w", err)
+	}
+
+	tokns, err := o.store.tokens.Get(rotation.Sub)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user tokens: %w", err)
+	}
+
+	bootstrap, err := o.fetchBootstrapData(ctx, tokns.Access)
+	if err != nil {
+		return fmt.Errorf("failed to fetch bootstrap data: %w", err)
+	}
+
+	if bootstrap.Data == nil || bootstrap.Data.UserEDVVaultURL == "" {
+		return errors.New("user has no edv vault")
+	}
+
+	h := &hubKMSHeader{userSub: o.pseudonyms.ID(rotation.Sub), secretShare: usr.SecretShare, accessToken: tokns.Access}
+
+	if rotation.Keys == nil {
+		rotation.Migrated = 0
+
+		err = o.usage.reserve(rotation.Sub, 0, rotationKeys)
+		if err != nil {
+			return fmt.Errorf("failed to create vault keys: %w", err)
+		}
+
+		rotation.Keys, err = o.createVaultKeys(bootstrap.Data, h)
+		if err != nil {
+			o.usage.release(rotation.Sub, 0, rotationKeys)
+
+			return fmt.Errorf("failed to create vault keys: %w", err)
+		}
+
+		o.rotations.save(rotation)
+	}
+
+	// the bootstrap data was updated by the rotation before it could record its completion
+	if bootstrap.Data.EDVOpsKIDURL == rotation.Keys.EDVOpsKIDURL &&
+		bootstrap.Data.EDVHMACKIDURL == rotation.Keys.EDVHMACKIDURL {
+		return nil
+	}
+
+	rotated := *bootstrap.Data
+	rotated.EDVOpsKIDURL = rotation.Keys.EDVOpsKIDURL
+	rotated.EDVHMACKIDURL = rotation.Keys.EDVHMACKIDURL
+
+	err = o.reencryptVault(bootstrap.Data, &rotated, h, rotation)
+	if err != nil {
+		return err
+	}
+
+	err = o.postBootstrapData(ctx, tokns.Access, &rotated)
+	if err != nil {
+		return fmt.Errorf("update user bootstrap data : %w", err)
+	}
+
+	// the cached vault and keystore metadata still point to the old keys
+	o.vaults.Remove(rotation.Sub)
+	o.invalidateKeystores(h.userSub)
+
+	return nil
+}
+
+// reencryptVault moves the documents of the stores of the vault from the old keys to the new keys, in batches.
+// Each document is saved with the new keys before it is deleted with the old ones, so that an interrupted
+// rotation loses no document. The documents saved with the old keys in the meantime are moved by later batches.
+func (o *Operation) reencryptVault(old, rotated *BootstrapData, h *hubKMSHeader, rotation *VaultRotation) error {
+	from, err := o.openVault(old, h)
+	if err != nil {
+		return fmt.Errorf("failed to open vault with the old keys: %w", err)
+	}
+
+	to, err := o.openVault(rotated, h)
+	if err != nil {
+		return fmt.Errorf("failed to open vault with the new keys: %w", err)
+	}
+
+	for _, name := range o.rotations.stores {
+		err = o.reencryptStore(from, to, name, rotation)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *Operation) reencryptStore(from, to ariesstorage.Provider, name string, rotation *VaultRotation) error {
+	src, err := from.OpenStore(name)
+	if err != nil {
+		return fmt.Errorf("failed to open store %s with the old keys: %w", name, err)
+	}
+
+	dst, err := to.OpenStore(name)
+	if err != nil {
+		return fmt.Errorf("failed to open store %s with the new keys: %w", name, err)
+	}
+
+	var batch []*document
+
+	for {
+		batch, err = readBatch(src, o.rotations.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to read store %s: %w", name, err)
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, doc := range batch {
+			err = dst.Put(doc.key, doc.value)
+			if err != nil {
+				return fmt.Errorf("failed to save document of store %s with the new keys: %w", name, err)
+			}
+
+			err = src.Delete(doc.key)
+			if err != nil {
+				return fmt.Errorf("failed to delete document of store %s with the old keys: %w", name, err)
+			}
+
+			rotation.Migrated++
+		}
+
+		o.rotations.save(rotation)
+	}
+}
+
+type document struct {
+	key   string
+	value []byte
+}
+
+// readBatch reads up to size documents of the store. The documents moved by the previous batches are deleted, so
+// each batch starts over.
+func readBatch(s ariesstorage.Store, size int) ([]*document, error) {
+	// the EDV store ignores the key range and returns every document of the store
+	iter := s.Iterator("", ariesstorage.EndKeySuffix)
+	defer iter.Release()
+
+	var batch []*document
+
+	for len(batch) < size && iter.Next() {
+		batch = append(batch, &document{
+			key:   string(iter.Key()),
+			value: append([]byte(nil), iter.Value()...),
+		})
+	}
+
+	return batch, iter.Error()
+}
+
+// createUserVaultKeys creates the operational and HMAC keys of the vault in the user's ops keystore.
+func (o *Operation) createUserVaultKeys(data *BootstrapData, h *hubKMSHeader) (*VaultKeys, error) {
+	keyManager := webkms.New(data.OpsKeyStoreURL, o.kmsHTTPClient, opsKMSHeaders(h))
+
+	opsKID, _, err := keyManager.Create(kms.ECDH256KWAES256GCM)
+	if err != nil {
+		return nil, fmt.Errorf("create edv operational key : %w", err)
+	}
+
+	hmacKID, _, err := keyManager.Create(kms.HMACSHA256Tag256)
+	if err != nil {
+		return nil, fmt.Errorf("create edv hmac key : %w", err)
+	}
+
+	// the key manager takes the key ids from the location of the responses, whatever their status
+	if opsKID == "" || hmacKID == "" {
+		return nil, errors.New("create edv keys : ops keystore returned no key id")
+	}
+
+	return &VaultKeys{
+		EDVOpsKIDURL:  fmt.Sprintf("%s/keys/%s", data.OpsKeyStoreURL, opsKID),
+		EDVHMACKIDURL: fmt.Sprintf("%s/keys/%s", data.OpsKeyStoreURL, hmacKID),
+	}, nil
+}
+
+type rotationAuditDetails struct {
+	Migrated      int    `json:"migrated"`
+	EDVOpsKIDURL  string `json:"edvOpsKIDURL,omitempty"`
+	EDVHMACKIDURL string `json:"edvHMACKIDURL,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// auditRotation records the outcome of a rotation, with the new keys if completed. The rotations are
+// administrative: they are triggered by the admins or by their schedule.
+func (o *Operation) auditRotation(rotation *VaultRotation, keys *VaultKeys) {
+	if o.auditLog == nil {
+		return
+	}
+
+	action := audit.ActionVaultRotationFailed
+	details := &rotationAuditDetails{Migrated: rotation.Migrated, Error: rotation.Error}
+
+	if keys != nil {
+		action = audit.ActionVaultRotated
+		details.EDVOpsKIDURL = keys.EDVOpsKIDURL
+		details.EDVHMACKIDURL = keys.EDVHMACKIDURL
+	}
+
+	err := o.auditLog.Record(action, rotation.Sub, audit.ActorAdmin, details)
+	if err != nil {
+		logger.Errorf("failed to audit %s of user %s: %s", action, rotation.Sub, err.Error())
+	}
+}
@@ -0,0 +1,526 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	ariesmem "github.com/hyperledger/aries-framework-go/pkg/storage/mem"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestOperation_VaultRotation(t *testing.T) {
+	t.Run("re-encrypts the vault with new keys and updates the bootstrap data", func(t *testing.T) {
+		o, sub, hubAuth, vaults := setupRotationTest(t)
+		old := hubAuth.data()
+		vaults.put(t, old, "urn:uuid:1", "urn:uuid:2", "urn:uuid:3")
+
+		w := httptest.NewRecorder()
+		o.rotateVaultHandler(w, httptest.NewRequest(http.MethodPost, vaultRotationPath+"?sub="+sub, nil))
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		rotation := waitForRotation(t, o, sub)
+		require.Equal(t, OnboardingCompleted, rotation.Status, rotation.Error)
+		require.Equal(t, 3, rotation.Migrated)
+		require.NotNil(t, rotation.CompletedAt)
+		require.Nil(t, rotation.Keys)
+
+		rotated := hubAuth.data()
+		require.NotEqual(t, old.EDVOpsKIDURL, rotated.EDVOpsKIDURL)
+		require.NotEqual(t, old.EDVHMACKIDURL, rotated.EDVHMACKIDURL)
+		require.Equal(t, old.UserEDVVaultURL, rotated.UserEDVVaultURL)
+
+		require.Empty(t, vaults.documents(t, old))
+		require.Equal(t, []string{"urn:uuid:1", "urn:uuid:2", "urn:uuid:3"}, vaults.documents(t, rotated))
+
+		entries, err := o.auditLog.List(sub)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, audit.ActionVaultRotated, entries[0].Action)
+		require.Equal(t, audit.ActorAdmin, entries[0].Actor)
+
+		w = httptest.NewRecorder()
+		o.vaultRotationHandler(w, httptest.NewRequest(http.MethodGet, vaultRotationPath+"?sub="+sub, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		status := &VaultRotation{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), status))
+		require.Equal(t, OnboardingCompleted, status.Status)
+	})
+
+	t.Run("resumes a failed rotation with the keys it created", func(t *testing.T) {
+		o, sub, hubAuth, vaults := setupRotationTest(t)
+		old := hubAuth.data()
+		vaults.put(t, old, "urn:uuid:1")
+
+		created := 0
+		createKeys := o.createVaultKeys
+		o.createVaultKeys = func(data *BootstrapData, h *hubKMSHeader) (*VaultKeys, error) {
+			created++
+
+			return createKeys(data, h)
+		}
+
+		vaults.failNew = true
+
+		rotation := rotateNow(t, o, sub)
+		require.Equal(t, OnboardingFailed, rotation.Status)
+		require.Contains(t, rotation.Error, "failed to open vault with the new keys")
+		require.NotNil(t, rotation.Keys)
+		require.Equal(t, old, hubAuth.data(), "the bootstrap data is updated once re-encrypted")
+
+		vaults.failNew = false
+
+		resumed := rotateNow(t, o, sub)
+		require.Equal(t, OnboardingCompleted, resumed.Status, resumed.Error)
+		require.Equal(t, 1, created)
+		require.Equal(t, rotation.Keys.EDVHMACKIDURL, hubAuth.data().EDVHMACKIDURL)
+		require.Equal(t, []string{"urn:uuid:1"}, vaults.documents(t, hubAuth.data()))
+
+		entries, err := o.auditLog.List(sub)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, audit.ActionVaultRotationFailed, entries[0].Action)
+		require.Equal(t, audit.ActionVaultRotated, entries[1].Action)
+	})
+
+	t.Run("does not re-encrypt the documents again once the bootstrap data is updated", func(t *testing.T) {
+		o, sub, hubAuth, vaults := setupRotationTest(t)
+		current := hubAuth.data()
+		vaults.put(t, current, "urn:uuid:1")
+
+		o.rotations.save(&VaultRotation{Sub: sub, Status: OnboardingFailed, Keys: &VaultKeys{
+			EDVOpsKIDURL:  current.EDVOpsKIDURL,
+			EDVHMACKIDURL: current.EDVHMACKIDURL,
+		}})
+
+		rotation := rotateNow(t, o, sub)
+		require.Equal(t, OnboardingCompleted, rotation.Status, rotation.Error)
+		require.Zero(t, rotation.Migrated)
+		require.Equal(t, []string{"urn:uuid:1"}, vaults.documents(t, current))
+	})
+
+	t.Run("moves the documents in batches", func(t *testing.T) {
+		o, sub, hubAuth, vaults := setupRotationTest(t)
+		o.rotations.batchSize = 2
+		vaults.put(t, hubAuth.data(), "urn:uuid:1", "urn:uuid:2", "urn:uuid:3", "urn:uuid:4", "urn:uuid:5")
+
+		rotation := rotateNow(t, o, sub)
+		require.Equal(t, OnboardingCompleted, rotation.Status, rotation.Error)
+		require.Equal(t, 5, rotation.Migrated)
+		require.Len(t, vaults.documents(t, hubAuth.data()), 5)
+	})
+
+	t.Run("error if the rotation fails", func(t *testing.T) {
+		for _, test := range []struct {
+			name  string
+			setup func(o *Operation, hubAuth *mockHubAuth, vaults *mockVaults)
+			msg   string
+		}{
+			{
+				name: "no vault",
+				setup: func(o *Operation, hubAuth *mockHubAuth, _ *mockVaults) {
+					hubAuth.bootstrap.UserEDVVaultURL = ""
+				},
+				msg: "user has no edv vault",
+			},
+			{
+				name: "keys not created",
+				setup: func(o *Operation, _ *mockHubAuth, _ *mockVaults) {
+					o.createVaultKeys = func(*BootstrapData, *hubKMSHeader) (*VaultKeys, error) {
+						return nil, errors.New("test")
+					}
+				},
+				msg: "failed to create vault keys",
+			},
+			{
+				name: "bootstrap data not updated",
+				setup: func(_ *Operation, hubAuth *mockHubAuth, _ *mockVaults) {
+					hubAuth.failPost = true
+				},
+				msg: "update user bootstrap data",
+			},
+			{
+				name: "store not opened",
+				setup: func(o *Operation, _ *mockHubAuth, _ *mockVaults) {
+					o.rotations.stores = []string{"missing"}
+					o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+						return &failingProvider{}, nil
+					}
+				},
+				msg: "failed to open store missing with the old keys",
+			},
+		} {
+			o, sub, hubAuth, vaults := setupRotationTest(t)
+			test.setup(o, hubAuth, vaults)
+
+			rotation := rotateNow(t, o, sub)
+			require.Equal(t, OnboardingFailed, rotation.Status, test.name)
+			require.Contains(t, rotation.Error, test.msg, test.name)
+		}
+	})
+
+	t.Run("error if the user is unknown", func(t *testing.T) {
+		o, _, _, _ := setupRotationTest(t)
+
+		w := httptest.NewRecorder()
+		o.rotateVaultHandler(w, httptest.NewRequest(http.MethodPost, vaultRotationPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = httptest.NewRecorder()
+		o.rotateVaultHandler(w, httptest.NewRequest(http.MethodPost, vaultRotationPath+"?sub=unknown", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		o.vaultRotationHandler(w, httptest.NewRequest(http.MethodGet, vaultRotationPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = httptest.NewRecorder()
+		o.vaultRotationHandler(w, httptest.NewRequest(http.MethodGet, vaultRotationPath+"?sub=unknown", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "was never rotated")
+	})
+
+	t.Run("error if the rotation cannot be scheduled", func(t *testing.T) {
+		o, sub, _, _ := setupRotationTest(t)
+		o.rotations.queue.Stop()
+
+		w := httptest.NewRecorder()
+		o.rotateVaultHandler(w, httptest.NewRequest(http.MethodPost, vaultRotationPath+"?sub="+sub, nil))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Contains(t, w.Body.String(), "failed to schedule vault rotation")
+
+		rotation, err := o.rotations.get(sub)
+		require.NoError(t, err)
+		require.Equal(t, OnboardingFailed, rotation.Status)
+	})
+
+	t.Run("does not rotate the vault twice at once", func(t *testing.T) {
+		o, sub, _, _ := setupRotationTest(t)
+		o.rotations.queue.Stop()
+		o.rotations.save(&VaultRotation{Sub: sub, Status: OnboardingRunning})
+
+		rotation, err := o.scheduleRotation(sub)
+		require.NoError(t, err)
+		require.Equal(t, OnboardingRunning, rotation.Status)
+	})
+}
+
+func TestOperation_RotateDueVaults(t *testing.T) {
+	o, sub, _, _ := setupRotationTest(t)
+	o.rotations.interval = 24 * time.Hour
+	o.rotations.queue.Stop()
+
+	now := time.Now()
+	rotated := now.Add(-time.Hour)
+
+	require.True(t, o.rotationDue(sub, now), "never rotated")
+
+	o.rotations.save(&VaultRotation{Sub: sub, Status: OnboardingCompleted, CompletedAt: &rotated})
+	require.False(t, o.rotationDue(sub, now))
+	require.True(t, o.rotationDue(sub, now.Add(24*time.Hour)))
+
+	o.rotations.save(&VaultRotation{Sub: sub, Status: OnboardingFailed})
+	require.True(t, o.rotationDue(sub, now), "failed")
+
+	// the queue is stopped, so the scheduled rotation fails to start
+	o.rotateDueVaults(now)
+
+	rotation, err := o.rotations.get(sub)
+	require.NoError(t, err)
+	require.Equal(t, OnboardingFailed, rotation.Status)
+	require.Contains(t, rotation.Error, "job queue is stopped")
+}
+
+func TestOperation_CreateUserVaultKeys(t *testing.T) {
+	t.Run("creates the keys in the ops keystore", func(t *testing.T) {
+		var keyTypes []string
+
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/kms/keystores/456/keys", r.URL.Path)
+
+			req := map[string]string{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			keyTypes = append(keyTypes, req["keyType"])
+
+			w.Header().Set("Location", fmt.Sprintf("%s/keys/key%d", r.URL.Path[:len("/kms/keystores/456")],
+				len(keyTypes)))
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer kms.Close()
+
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		keys, err := o.createUserVaultKeys(vaultBootstrapData(kms.URL), &hubKMSHeader{userSub: "sub"})
+		require.NoError(t, err)
+		require.Equal(t, kms.URL+"/kms/keystores/456/keys/key1", keys.EDVOpsKIDURL)
+		require.Equal(t, kms.URL+"/kms/keystores/456/keys/key2", keys.EDVHMACKIDURL)
+		require.Equal(t, []string{"ECDH256KWAES256GCM", "HMACSHA256Tag256"}, keyTypes)
+	})
+
+	t.Run("error if the keys cannot be created", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		_, err = o.createUserVaultKeys(vaultBootstrapData("http://localhost:-1"), &hubKMSHeader{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "create edv operational key")
+	})
+
+	t.Run("error if the keystore returns no key id", func(t *testing.T) {
+		kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer kms.Close()
+
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		_, err = o.createUserVaultKeys(vaultBootstrapData(kms.URL), &hubKMSHeader{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "ops keystore returned no key id")
+	})
+}
+
+func TestNew_KeyRotation(t *testing.T) {
+	t.Run("serves the rotations to the admins", func(t *testing.T) {
+		config := config(t)
+		config.KeyRotation = &KeyRotationConfig{Interval: time.Hour}
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		defer o.Close()
+
+		require.Equal(t, defaultRotationBatchSize, o.rotations.batchSize)
+		require.Equal(t, []string{credentialsStoreName}, o.rotations.stores)
+
+		paths := map[string]bool{}
+		for _, h := range o.GetAdminRESTHandlers() {
+			paths[h.Method()+" "+h.Path()] = true
+		}
+
+		require.True(t, paths[http.MethodPost+" "+vaultRotationPath])
+		require.True(t, paths[http.MethodGet+" "+vaultRotationPath])
+	})
+
+	t.Run("error if the rotations store cannot be opened", func(t *testing.T) {
+		config := config(t)
+		config.KeyRotation = &KeyRotationConfig{}
+		config.Storage.Storage = &mockstore.Provider{
+			Store:         &mockstore.MockStore{Store: map[string][]byte{}},
+			FailNameSpace: rotationStoreName,
+		}
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to open vault rotations store")
+	})
+}
+
+func setupRotationTest(t *testing.T) (*Operation, string, *mockHubAuth, *mockVaults) {
+	t.Helper()
+
+	o, sub := setupCheckTest(t)
+
+	var err error
+
+	o.auditLog, err = audit.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	o.rotations, err = newRotations(&KeyRotationConfig{}, memstore.NewProvider(), nil)
+	require.NoError(t, err)
+
+	o.rotations.schedule(o.rotateDueVaults)
+	t.Cleanup(o.rotations.Stop)
+
+	hubAuth := &mockHubAuth{bootstrap: vaultBootstrapData("https://ops.kms.example.com")}
+	o.httpClient = hubAuth
+
+	vaults := &mockVaults{vaults: map[string]ariesstorage.Provider{}}
+	o.openVault = vaults.open
+	o.createVaultKeys = func(data *BootstrapData, _ *hubKMSHeader) (*VaultKeys, error) {
+		id := uuid.New().String()
+
+		return &VaultKeys{
+			EDVOpsKIDURL:  data.OpsKeyStoreURL + "/keys/ops-" + id,
+			EDVHMACKIDURL: data.OpsKeyStoreURL + "/keys/hmac-" + id,
+		}, nil
+	}
+
+	return o, sub, hubAuth, vaults
+}
+
+// rotateNow runs the rotation of the user's vault in the foreground.
+func rotateNow(t *testing.T, o *Operation, sub string) *VaultRotation {
+	t.Helper()
+
+	rotation, err := o.rotations.get(sub)
+	if err != nil {
+		rotation = &VaultRotation{Sub: sub}
+	}
+
+	rotation.Status = OnboardingPending
+	o.rotations.save(rotation)
+	o.runRotation(sub)
+
+	rotation, err = o.rotations.get(sub)
+	require.NoError(t, err)
+
+	return rotation
+}
+
+func waitForRotation(t *testing.T, o *Operation, sub string) *VaultRotation {
+	t.Helper()
+
+	var rotation *VaultRotation
+
+	require.Eventually(t, func() bool {
+		var err error
+
+		rotation, err = o.rotations.get(sub)
+		require.NoError(t, err)
+
+		return rotation.Status == OnboardingCompleted || rotation.Status == OnboardingFailed
+	}, time.Second, 10*time.Millisecond)
+
+	return rotation
+}
+
+// mockHubAuth serves and updates the bootstrap data of the user.
+type mockHubAuth struct {
+	mutex     sync.Mutex
+	bootstrap *BootstrapData
+	failPost  bool
+}
+
+func (m *mockHubAuth) Do(req *http.Request) (*http.Response, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !strings.HasSuffix(req.URL.Path, hubAuthBootstrapDataPath) {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	if req.Method == http.MethodPost {
+		if m.failPost {
+			return nil, errors.New("test")
+		}
+
+		update := &userBootstrapData{}
+
+		err := json.NewDecoder(req.Body).Decode(update)
+		if err != nil {
+			return nil, err
+		}
+
+		m.bootstrap = update.Data
+
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+	}
+
+	bits, err := json.Marshal(&userBootstrapData{Data: m.bootstrap})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(bits))}, nil
+}
+
+func (m *mockHubAuth) data() *BootstrapData {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data := *m.bootstrap
+
+	return &data
+}
+
+// mockVaults are views of the same vault, one per HMAC key, as the documents are indexed with the HMAC key.
+type mockVaults struct {
+	mutex   sync.Mutex
+	vaults  map[string]ariesstorage.Provider
+	failNew bool
+}
+
+func (m *mockVaults) open(data *BootstrapData, _ *hubKMSHeader) (ariesstorage.Provider, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	vault, found := m.vaults[data.EDVHMACKIDURL]
+	if !found {
+		if m.failNew {
+			return nil, errors.New("test")
+		}
+
+		vault = ariesmem.NewProvider()
+		m.vaults[data.EDVHMACKIDURL] = vault
+	}
+
+	return vault, nil
+}
+
+func (m *mockVaults) put(t *testing.T, data *BootstrapData, ids ...string) {
+	t.Helper()
+
+	vault, err := m.open(data, nil)
+	require.NoError(t, err)
+
+	credentials, err := vault.OpenStore(credentialsStoreName)
+	require.NoError(t, err)
+
+	for _, id := range ids {
+		require.NoError(t, credentials.Put(id, []byte(`{"id": "`+id+`"}`)))
+	}
+}
+
+func (m *mockVaults) documents(t *testing.T, data *BootstrapData) []string {
+	t.Helper()
+
+	vault, err := m.open(data, nil)
+	require.NoError(t, err)
+
+	credentials, err := vault.OpenStore(credentialsStoreName)
+	require.NoError(t, err)
+
+	batch, err := readBatch(credentials, 100)
+	require.NoError(t, err)
+
+	ids := []string{}
+	for _, doc := range batch {
+		ids = append(ids, doc.key)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+type failingProvider struct {
+	ariesstorage.Provider
+}
+
+func (p *failingProvider) OpenStore(string) (ariesstorage.Store, error) {
+	return nil, errors.New("test")
+}
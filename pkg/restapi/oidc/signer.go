@@ -19,15 +19,19 @@ type signer interface {
 }
 
 type kmsSigner struct {
+	// ctx of the requests to the KMS, as the signer interface does not take one
+	ctx        context.Context
 	baseURL    string
-	httpClient httpClient
+	httpClient HTTPClient
 	keystoreID string
 	keyID      string
 	header     *hubKMSHeader
 }
 
-func newKMSSigner(baseURL, keystoreID, keyID string, h *hubKMSHeader, httpClient httpClient) *kmsSigner {
+func newKMSSigner(ctx context.Context, baseURL, keystoreID, keyID string, h *hubKMSHeader,
+	httpClient HTTPClient) *kmsSigner {
 	return &kmsSigner{
+		ctx:        ctx,
 		baseURL:    baseURL,
 		httpClient: httpClient,
 		keystoreID: keystoreID,
@@ -44,7 +48,7 @@ func (a *kmsSigner) Sign(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("marshal create sign req : %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(context.TODO(),
+	req, err := http.NewRequestWithContext(a.ctx,
 		http.MethodPost, a.baseURL+fmt.Sprintf(signEndpoint, a.keystoreID, a.keyID), bytes.NewBuffer(reqBytes))
 	if err != nil {
 		return nil, err
@@ -0,0 +1,201 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/siop"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/tokenexchange"
+	"golang.org/x/oauth2"
+)
+
+const (
+	siopLoginPath    = "/siop/login"
+	siopCallbackPath = "/siop/callback"
+	// the nonce the self-issued id_token of the login must be bound to.
+	siopNonceCookieName = "siop_nonce"
+)
+
+// SIOPConfig lets the users log in with a DID of their wallet, which issues them self-issued id_tokens (SIOPv2),
+// as an alternative to the OIDC provider. The users of a DID are onboarded like the users of the provider, with
+// the DID as their sub.
+type SIOPConfig struct {
+	RelyingParty *siop.RelyingParty
+	// Exchanger trades the self-issued id_tokens for the access tokens sent to hub-auth and the KMS servers, with
+	// the token exchange grant (RFC 8693). The id_tokens are sent as the access tokens if nil.
+	Exchanger tokenexchange.Exchanger
+	// Audience of the exchanged access tokens.
+	Audience string
+}
+
+func (o *Operation) siopHandlers() []common.Handler {
+	if o.siop == nil {
+		return nil
+	}
+
+	return []common.Handler{
+		common.NewHTTPHandler(siopLoginPath, http.MethodGet, o.siopLoginHandler, &common.OperationSpec{
+			Summary: "Redirects the browser to the wallet of the user to log in with a DID, or to the dashboard if " +
+				"logged in already.",
+			Params: []common.Param{
+				common.QueryParam(rememberParam, "Set to true to keep the user logged in past the session."),
+			},
+			Responses: map[int]interface{}{
+				http.StatusFound:            nil,
+				http.StatusMovedPermanently: nil,
+			},
+		}),
+		common.NewHTTPHandler(siopCallbackPath, http.MethodGet, o.siopCallbackHandler, &common.OperationSpec{
+			Summary: "Completes the login with the self-issued id_token of the wallet and redirects to the dashboard.",
+			Params: []common.Param{
+				common.QueryParam("state", "State of the login request."),
+				common.QueryParam("id_token", "Self-issued id_token of the DID of the user."),
+			},
+			Responses: map[int]interface{}{http.StatusFound: nil},
+		}),
+	}
+}
+
+func (o *Operation) siopLoginHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling siop login request: %s", r.URL.String())
+
+	if o.clientLockedOut(w, r) {
+		return
+	}
+
+	session, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to read user session cookie: %s", err.Error())
+
+		return
+	}
+
+	_, found := session.Get(userSubCookieName)
+	if found {
+		http.Redirect(w, r, o.dashboard(), http.StatusMovedPermanently)
+
+		return
+	}
+
+	state := o.newID().String()
+	nonce := o.newID().String()
+	session.Set(stateCookieName, state)
+	session.Set(siopNonceCookieName, nonce)
+	o.askToRemember(r, session)
+	redirectURL := o.siop.RelyingParty.FormatRequest(state, nonce)
+
+	err = session.Save(r, w)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to save session cookie: %s", err.Error())
+
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+	logger.Debugf("redirected to siop request: %s", redirectURL)
+}
+
+func (o *Operation) siopCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling siop callback")
+
+	if o.clientLockedOut(w, r) {
+		return
+	}
+
+	oauthToken, claims, canProceed := o.verifySelfIssuedToken(w, r)
+	if !canProceed {
+		return
+	}
+
+	promptConsent, ok := o.loginUser(w, r, oauthToken, claims)
+	if !ok {
+		return
+	}
+
+	o.redirectLoggedIn(w, r, promptConsent)
+}
+
+// verifySelfIssuedToken verifies the self-issued id_token of the callback against the nonce of the session, and
+// returns the access token of the user.
+func (o *Operation) verifySelfIssuedToken(
+	w http.ResponseWriter, r *http.Request) (oauthToken *oauth2.Token, claims siop.Claims, valid bool) {
+	session, valid := o.getAndVerifyUserSession(w, r)
+	if !valid {
+		return
+	}
+
+	nonce, _ := session.Get(siopNonceCookieName)
+	nonceValue, _ := nonce.(string)
+
+	session.Delete(stateCookieName)
+	session.Delete(siopNonceCookieName)
+
+	idToken := r.URL.Query().Get("id_token")
+	if idToken == "" {
+		o.loginFailed(r, session, reasonMissingIDToken)
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing id_token parameter")
+
+		return nil, nil, false
+	}
+
+	claims, err := o.siop.RelyingParty.VerifyIDToken(idToken, nonceValue)
+	if err != nil {
+		o.loginFailed(r, session, reasonInvalidIDToken)
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot verify id_token: %s", err.Error())
+
+		return nil, nil, false
+	}
+
+	oauthToken = &oauth2.Token{AccessToken: idToken, TokenType: "Bearer"}
+
+	if o.siop.Exchanger != nil {
+		oauthToken, err = o.siop.Exchanger.ExchangeToken(r.Context(), idToken, o.siop.Audience)
+		if err != nil {
+			o.loginFailed(r, session, reasonExchangeFailed)
+			common.WriteErrorResponsef(w, logger,
+				http.StatusBadGateway, "unable to exchange id_token for token: %s", err.Error())
+
+			return nil, nil, false
+		}
+	}
+
+	err = session.Save(r, w)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to save session cookies: %s", err.Error())
+
+		return nil, nil, false
+	}
+
+	return oauthToken, claims, true
+}
+
+// didUser tells whether the user of the sub logged in with a DID, rather than with the OIDC provider.
+func (o *Operation) didUser(sub string) bool {
+	return o.siop != nil && siop.IsDID(sub)
+}
+
+// userInfo returns the user info claims of the user. The provider does not know the users of a DID, whose only
+// claim is their DID.
+func (o *Operation) userInfo(ctx context.Context, sub string, tokns *tokens.UserTokens) (oidc.Claimer, error) {
+	if o.didUser(sub) {
+		return siop.Claims{"sub": sub}, nil
+	}
+
+	return o.oidcClient.UserInfo(ctx, &oauth2.Token{
+		AccessToken:  tokns.Access,
+		TokenType:    "Bearer",
+		RefreshToken: tokns.Refresh,
+	})
+}
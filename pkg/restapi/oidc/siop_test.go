@@ -0,0 +1,284 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	vdrkey "github.com/hyperledger/aries-framework-go/pkg/vdr/key"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/siop"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+const siopClientID = "https://agent.example.com/siop/callback"
+
+func TestOperation_SIOPHandlers(t *testing.T) {
+	o, err := New(config(t))
+	require.NoError(t, err)
+	require.Empty(t, o.siopHandlers())
+
+	o = setupSIOPTest(t, nil)
+	require.Len(t, o.siopHandlers(), 2)
+}
+
+func TestOperation_SIOPLoginHandler(t *testing.T) {
+	t.Run("redirects to the wallet with a request bound to the session", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+		jar := &cookie.MockJar{}
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+
+		w := httptest.NewRecorder()
+		o.siopLoginHandler(w, httptest.NewRequest(http.MethodGet, siopLoginPath, nil))
+		require.Equal(t, http.StatusFound, w.Code)
+
+		location := w.Header().Get("Location")
+		require.True(t, strings.HasPrefix(location, siop.RequestScheme))
+
+		request, err := url.Parse(location)
+		require.NoError(t, err)
+		require.Equal(t, jar.Cookies[stateCookieName], request.Query().Get("state"))
+		require.Equal(t, jar.Cookies[siopNonceCookieName], request.Query().Get("nonce"))
+		require.Equal(t, siopClientID, request.Query().Get("client_id"))
+	})
+
+	t.Run("redirects the users logged in to the dashboard", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+		o.SetWalletDashboard("http://wallet.example.com/dashboard")
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: "did:key:123"},
+		}}
+
+		w := httptest.NewRecorder()
+		o.siopLoginHandler(w, httptest.NewRequest(http.MethodGet, siopLoginPath, nil))
+		require.Equal(t, http.StatusMovedPermanently, w.Code)
+		require.Equal(t, "http://wallet.example.com/dashboard", w.Header().Get("Location"))
+	})
+
+	t.Run("internal server error if the session cannot be opened or saved", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+
+		o.store.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+		w := httptest.NewRecorder()
+		o.siopLoginHandler(w, httptest.NewRequest(http.MethodGet, siopLoginPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{SaveErr: errors.New("test")}}
+		w = httptest.NewRecorder()
+		o.siopLoginHandler(w, httptest.NewRequest(http.MethodGet, siopLoginPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestOperation_SIOPCallbackHandler(t *testing.T) {
+	t.Run("onboards the user of the did and redirects to the dashboard", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+		w := newSIOPWallet(t)
+		jar := siopSession("state", "nonce")
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+
+		idToken := w.idToken(t, "nonce")
+
+		result := httptest.NewRecorder()
+		o.siopCallbackHandler(result, newSIOPCallbackRequest("state", idToken))
+		require.Equal(t, http.StatusFound, result.Code)
+		require.Equal(t, "http://test.com/dashboard", result.Header().Get("Location"))
+		require.Equal(t, w.did, jar.Cookies[userSubCookieName])
+		require.NotContains(t, jar.Cookies, siopNonceCookieName)
+
+		usr, err := o.store.users.Get(w.did)
+		require.NoError(t, err)
+		require.Equal(t, w.did, usr.Sub)
+
+		tokns, err := o.store.tokens.Get(w.did)
+		require.NoError(t, err)
+		require.Equal(t, idToken, tokns.Access)
+
+		// the provider does not know the user
+		info, err := o.userInfo(context.Background(), w.did, tokns)
+		require.NoError(t, err)
+
+		claims := map[string]interface{}{}
+		require.NoError(t, info.Claims(&claims))
+		require.Equal(t, w.did, claims["sub"])
+	})
+
+	t.Run("exchanges the id_token for an access token", func(t *testing.T) {
+		o := setupSIOPTest(t, exchangerFunc(func(_ context.Context, token, audience string) (*oauth2.Token, error) {
+			require.Equal(t, "https://hub-auth.example.com", audience)
+
+			return &oauth2.Token{AccessToken: "exchanged"}, nil
+		}))
+		w := newSIOPWallet(t)
+		o.store.cookies = &cookie.MockStore{Jar: siopSession("state", "nonce")}
+
+		result := httptest.NewRecorder()
+		o.siopCallbackHandler(result, newSIOPCallbackRequest("state", w.idToken(t, "nonce")))
+		require.Equal(t, http.StatusFound, result.Code)
+
+		tokns, err := o.store.tokens.Get(w.did)
+		require.NoError(t, err)
+		require.Equal(t, "exchanged", tokns.Access)
+	})
+
+	t.Run("bad gateway if the id_token cannot be exchanged", func(t *testing.T) {
+		o := setupSIOPTest(t, exchangerFunc(func(context.Context, string, string) (*oauth2.Token, error) {
+			return nil, errors.New("test")
+		}))
+		o.store.cookies = &cookie.MockStore{Jar: siopSession("state", "nonce")}
+
+		result := httptest.NewRecorder()
+		o.siopCallbackHandler(result, newSIOPCallbackRequest("state", newSIOPWallet(t).idToken(t, "nonce")))
+		require.Equal(t, http.StatusBadGateway, result.Code)
+	})
+
+	t.Run("bad request if the id_token is not bound to the nonce of the session", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+		o.store.cookies = &cookie.MockStore{Jar: siopSession("state", "nonce")}
+
+		result := httptest.NewRecorder()
+		o.siopCallbackHandler(result, newSIOPCallbackRequest("state", newSIOPWallet(t).idToken(t, "other")))
+		require.Equal(t, http.StatusBadRequest, result.Code)
+		require.Contains(t, result.Body.String(), "invalid nonce")
+	})
+
+	t.Run("bad request if the id_token is missing", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+		o.store.cookies = &cookie.MockStore{Jar: siopSession("state", "nonce")}
+
+		result := httptest.NewRecorder()
+		o.siopCallbackHandler(result, newSIOPCallbackRequest("state", ""))
+		require.Equal(t, http.StatusBadRequest, result.Code)
+		require.Contains(t, result.Body.String(), "missing id_token")
+	})
+
+	t.Run("bad request if the state is invalid", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+		o.store.cookies = &cookie.MockStore{Jar: siopSession("state", "nonce")}
+
+		result := httptest.NewRecorder()
+		o.siopCallbackHandler(result, newSIOPCallbackRequest("other", newSIOPWallet(t).idToken(t, "nonce")))
+		require.Equal(t, http.StatusBadRequest, result.Code)
+	})
+
+	t.Run("internal server error if the session cannot be saved", func(t *testing.T) {
+		o := setupSIOPTest(t, nil)
+		jar := siopSession("state", "nonce")
+		jar.SaveErr = errors.New("test")
+		o.store.cookies = &cookie.MockStore{Jar: jar}
+
+		result := httptest.NewRecorder()
+		o.siopCallbackHandler(result, newSIOPCallbackRequest("state", newSIOPWallet(t).idToken(t, "nonce")))
+		require.Equal(t, http.StatusInternalServerError, result.Code)
+	})
+}
+
+func setupSIOPTest(t *testing.T, exchanger exchangerFunc) *Operation {
+	t.Helper()
+
+	config := config(t)
+	config.WalletDashboard = "http://test.com/dashboard"
+	config.OIDCClient = &oidc2.MockClient{UserInfoErr: errors.New("unknown user")}
+	config.SIOP = &SIOPConfig{
+		RelyingParty: siop.New(&siop.Config{
+			ClientID:   siopClientID,
+			DIDMethods: []string{"key"},
+			Resolver:   siop.VDRResolver{vdrkey.New()},
+		}),
+		Audience: "https://hub-auth.example.com",
+	}
+
+	if exchanger != nil {
+		config.SIOP.Exchanger = exchanger
+	}
+
+	o, err := New(config,
+		WithHTTPClient(mockKMSHTTPClient()),
+		WithEDVClientFactory(func(string, *http.Client) EDVClient {
+			return &mockEDVClient{NoCapability: true}
+		}),
+	)
+	require.NoError(t, err)
+
+	return o
+}
+
+func siopSession(state, nonce string) *cookie.MockJar {
+	return &cookie.MockJar{Cookies: map[interface{}]interface{}{
+		stateCookieName:     state,
+		siopNonceCookieName: nonce,
+	}}
+}
+
+func newSIOPCallbackRequest(state, idToken string) *http.Request {
+	return httptest.NewRequest(http.MethodGet,
+		siopCallbackPath+"?"+url.Values{"state": {state}, "id_token": {idToken}}.Encode(), nil)
+}
+
+// siopWallet is the wallet of a user, with the ed25519 key of a did:key.
+type siopWallet struct {
+	did        string
+	keyID      string
+	privateKey ed25519.PrivateKey
+}
+
+func newSIOPWallet(t *testing.T) *siopWallet {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	didKey, keyID := fingerprint.CreateDIDKey(pub)
+
+	return &siopWallet{did: didKey, keyID: keyID, privateKey: priv}
+}
+
+func (w *siopWallet) idToken(t *testing.T, nonce string) string {
+	t.Helper()
+
+	token, err := jwt.NewSigned(map[string]interface{}{
+		"iss":   w.did,
+		"sub":   w.did,
+		"aud":   siopClientID,
+		"nonce": nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}, jose.Headers{jose.HeaderKeyID: w.keyID}, &ed25519JWTSigner{privateKey: w.privateKey})
+	require.NoError(t, err)
+
+	serialized, err := token.Serialize(false)
+	require.NoError(t, err)
+
+	return serialized
+}
+
+type ed25519JWTSigner struct {
+	privateKey ed25519.PrivateKey
+}
+
+func (s *ed25519JWTSigner) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.privateKey, data), nil
+}
+
+func (s *ed25519JWTSigner) Headers() jose.Headers {
+	return jose.Headers{jose.HeaderAlgorithm: "EdDSA"}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"golang.org/x/oauth2"
+)
+
+const (
+	stepUpPath          = "/stepup"
+	stepUpRedirectParam = "redirect"
+	defaultStepUpMaxAge = 5 * time.Minute
+	// the authentication of the session, as told by the id_token of its login.
+	authTimeCookieName = "auth_time"
+	authACRCookieName  = "auth_acr"
+	authAMRCookieName  = "auth_amr"
+	// the page the user returns to once stepped up.
+	stepUpRedirectCookieName = "step_up_redirect"
+)
+
+// StepUpConfig makes the sensitive operations, e.g. the export of the user data, require a recent authentication
+// of a high assurance level. The users authenticate again with the OIDC provider when their session falls short.
+type StepUpConfig struct {
+	// ACRValues are the authentication context classes accepted, in order of preference. They are requested with
+	// acr_values on step-up. Any class is accepted if empty.
+	ACRValues []string
+	// AMRValues are the authentication methods accepted, e.g. mfa or hwk: the user must have authenticated with one
+	// of them. Any method is accepted if empty.
+	AMRValues []string
+	// MaxAge is how long an authentication is recent enough for. Defaults to 5 minutes.
+	MaxAge time.Duration
+}
+
+// authContext is the authentication of the user, as told by the claims of an id_token.
+type authContext struct {
+	ACR      string   `json:"acr"`
+	AMR      []string `json:"amr"`
+	AuthTime int64    `json:"auth_time"`
+}
+
+// satisfies tells whether the authentication meets the policy.
+func (c *StepUpConfig) satisfies(auth *authContext) bool {
+	if auth == nil || auth.AuthTime == 0 {
+		return false
+	}
+
+	if time.Since(time.Unix(auth.AuthTime, 0)) > c.maxAge() {
+		return false
+	}
+
+	if len(c.ACRValues) > 0 && !contains(c.ACRValues, auth.ACR) {
+		return false
+	}
+
+	if len(c.AMRValues) == 0 {
+		return true
+	}
+
+	for _, method := range auth.AMR {
+		if contains(c.AMRValues, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *StepUpConfig) maxAge() time.Duration {
+	if c.MaxAge <= 0 {
+		return defaultStepUpMaxAge
+	}
+
+	return c.MaxAge
+}
+
+// options are the parameters of the step-up authentication requests: the users must authenticate again.
+func (c *StepUpConfig) options() []oauth2.AuthCodeOption {
+	opts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("max_age", "0")}
+
+	if len(c.ACRValues) > 0 {
+		opts = append(opts, oauth2.SetAuthURLParam("acr_values", strings.Join(c.ACRValues, " ")))
+	}
+
+	return opts
+}
+
+// challenge is the WWW-Authenticate header of the requests falling short of the policy, as per RFC 9470.
+func (c *StepUpConfig) challenge() string {
+	challenge := `Bearer error="insufficient_user_authentication",` +
+		` error_description="A recent authentication of a higher assurance level is required", max_age=0`
+
+	if len(c.ACRValues) > 0 {
+		challenge += fmt.Sprintf(`, acr_values="%s"`, strings.Join(c.ACRValues, " "))
+	}
+
+	return challenge
+}
+
+// requireStepUp lets through the requests of the sessions meeting the step-up policy. The browsers navigating to
+// the handler are redirected to the OIDC provider, and back to the handler once stepped up. The other requests are
+// answered with 401 and the wallet UI starts the step-up at the step-up endpoint.
+func (o *Operation) requireStepUp(next http.HandlerFunc) http.HandlerFunc {
+	if o.stepUp == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		jar, err := o.store.cookies.Open(r)
+		if err != nil {
+			common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+			return
+		}
+
+		// the handler answers the users not logged in
+		_, found := jar.Get(userSubCookieName)
+		if !found || o.stepUp.satisfies(sessionAuthContext(jar)) {
+			next(w, r)
+
+			return
+		}
+
+		_, bearer := jar.(*cookie.RequestJar)
+		if bearer || r.Method != http.MethodGet {
+			// the bearer tokens carry no authentication context, and other methods cannot be resumed
+			w.Header().Set("WWW-Authenticate", o.stepUp.challenge())
+			common.WriteErrorResponsef(w, logger, http.StatusUnauthorized, "step-up authentication required")
+
+			return
+		}
+
+		o.redirectToStepUp(w, r, jar, r.URL.RequestURI())
+	}
+}
+
+// stepUpHandler authenticates the user again with the OIDC provider, and redirects the user to the page of the
+// wallet UI in the redirect parameter, or to the dashboard.
+func (o *Operation) stepUpHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling step-up request")
+
+	if o.stepUp == nil {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "step-up authentication is disabled")
+
+		return
+	}
+
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return
+	}
+
+	if _, found := jar.Get(userSubCookieName); !found {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return
+	}
+
+	redirect := r.URL.Query().Get(stepUpRedirectParam)
+	if redirect == "" {
+		redirect = o.dashboard()
+	} else if !isLocalPath(redirect) {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "redirect must be a path of this server")
+
+		return
+	}
+
+	o.redirectToStepUp(w, r, jar, redirect)
+}
+
+func (o *Operation) redirectToStepUp(w http.ResponseWriter, r *http.Request, jar cookie.Jar, redirect string) {
+	state := o.newID().String()
+
+	jar.Set(stateCookieName, state)
+	jar.Set(stepUpRedirectCookieName, redirect)
+
+	err := jar.Save(r, w)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to save session cookie: %s", err.Error())
+
+		return
+	}
+
+	http.Redirect(w, r, o.oidcClient.FormatRequest(state, o.stepUp.options()...), http.StatusFound)
+}
+
+// completeStepUp returns the page the user stepped up for, if the user logged in to step up. It fails if the new
+// authentication still falls short of the policy, rather than sending the user round again.
+func (o *Operation) completeStepUp(w http.ResponseWriter, r *http.Request) (string, bool) {
+	jar, err := o.store.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to read session cookie: %s", err.Error())
+
+		return "", false
+	}
+
+	redirect, found := jar.Get(stepUpRedirectCookieName)
+	if !found {
+		return "", true
+	}
+
+	jar.Delete(stepUpRedirectCookieName)
+
+	err = jar.Save(r, w)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger,
+			http.StatusInternalServerError, "failed to save session cookie: %s", err.Error())
+
+		return "", false
+	}
+
+	if o.stepUp == nil || !o.stepUp.satisfies(sessionAuthContext(jar)) {
+		o.loginFailed(r, jar, reasonStepUpFailed)
+		common.WriteErrorResponsef(w, logger,
+			http.StatusForbidden, "the authentication does not meet the step-up requirements")
+
+		return "", false
+	}
+
+	url, ok := redirect.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid step-up redirect cookie format")
+
+		return "", false
+	}
+
+	return url, true
+}
+
+// recordAuthContext keeps the authentication of the id_token in the session of the user.
+func recordAuthContext(jar cookie.Jar, idToken oidc.Claimer) {
+	auth := &authContext{}
+
+	err := idToken.Claims(auth)
+	if err != nil {
+		logger.Warnf("failed to parse the authentication context of the id_token: %s", err.Error())
+	}
+
+	jar.Set(authTimeCookieName, auth.AuthTime)
+	jar.Set(authACRCookieName, auth.ACR)
+	jar.Set(authAMRCookieName, auth.AMR)
+}
+
+func forgetAuthContext(jar cookie.Jar) {
+	jar.Delete(authTimeCookieName)
+	jar.Delete(authACRCookieName)
+	jar.Delete(authAMRCookieName)
+	jar.Delete(stepUpRedirectCookieName)
+}
+
+func sessionAuthContext(jar cookie.Jar) *authContext {
+	auth := &authContext{}
+
+	if v, found := jar.Get(authTimeCookieName); found {
+		auth.AuthTime, _ = v.(int64)
+	}
+
+	if v, found := jar.Get(authACRCookieName); found {
+		auth.ACR, _ = v.(string)
+	}
+
+	if v, found := jar.Get(authAMRCookieName); found {
+		auth.AMR, _ = v.([]string)
+	}
+
+	return auth
+}
+
+// isLocalPath tells whether the redirect stays on this server. The browsers strip the tabs and line breaks of the
+// URLs, and read backslashes as slashes, so that the redirects with control characters or backslashes are rejected
+// rather than turned into the URLs of other hosts, such as "/\t/evil.com".
+func isLocalPath(redirect string) bool {
+	for _, c := range redirect {
+		if c < ' ' || c == 0x7f || c == '\\' {
+			return false
+		}
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return false
+	}
+
+	return strings.HasPrefix(redirect, "/") && !strings.HasPrefix(redirect, "//")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
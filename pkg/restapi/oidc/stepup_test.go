@@ -0,0 +1,337 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"golang.org/x/oauth2"
+)
+
+const authorizeURL = "https://op.example.com/authorize"
+
+func TestOperation_RequireStepUp(t *testing.T) {
+	t.Run("lets through the sessions meeting the policy", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+		setAuthContext(jar, "phr", []string{"pwd", "mfa"}, time.Now())
+
+		w := exportWithStepUp(o, http.MethodGet, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("sends the browsers to authenticate again and back once stepped up", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+		setAuthContext(jar, "phr", []string{"mfa"}, time.Now().Add(-time.Hour))
+
+		w := exportWithStepUp(o, http.MethodGet, "/oidc/userinfo/export?format=zip")
+		require.Equal(t, http.StatusFound, w.Code)
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "0", location.Query().Get("max_age"))
+		require.Equal(t, "phr phrh", location.Query().Get("acr_values"))
+
+		state, found := jar.Get(stateCookieName)
+		require.True(t, found)
+		require.Equal(t, state, location.Query().Get("state"))
+
+		o.oidcClient.(*oidc2.MockClient).IDToken = stepUpIDToken(t, jar, "phrh", []string{"hwk"})
+
+		w = httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state.(string)))
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, "/oidc/userinfo/export?format=zip", w.Header().Get("Location"))
+
+		_, found = jar.Get(stepUpRedirectCookieName)
+		require.False(t, found)
+
+		w = exportWithStepUp(o, http.MethodGet, "/oidc/userinfo/export?format=zip")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("error forbidden if the new authentication still falls short of the policy", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+
+		w := exportWithStepUp(o, http.MethodGet, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusFound, w.Code)
+
+		state, _ := jar.Get(stateCookieName)
+		o.oidcClient.(*oidc2.MockClient).IDToken = stepUpIDToken(t, jar, "phr", []string{"pwd"})
+
+		w = httptest.NewRecorder()
+		o.oidcCallbackHandler(w, newOIDCCallbackRequest(uuid.New().String(), state.(string)))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "the authentication does not meet the step-up requirements")
+
+		// the user is logged in all the same, and can try again
+		_, loggedIn := jar.Get(userSubCookieName)
+		require.True(t, loggedIn)
+
+		_, found := jar.Get(stepUpRedirectCookieName)
+		require.False(t, found)
+	})
+
+	t.Run("asks the API clients to step up", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+		sub, _ := jar.Get(userSubCookieName)
+
+		o.store.cookies = &cookie.MockStore{Jar: cookie.NewRequestJar(map[interface{}]interface{}{
+			userSubCookieName: sub,
+		})}
+
+		w := exportWithStepUp(o, http.MethodGet, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Contains(t, w.Body.String(), "step-up authentication required")
+		require.Equal(t, `Bearer error="insufficient_user_authentication",`+
+			` error_description="A recent authentication of a higher assurance level is required", max_age=0,`+
+			` acr_values="phr phrh"`, w.Header().Get("WWW-Authenticate"))
+
+		o, _ = setupStepUpTest(t)
+
+		w = exportWithStepUp(o, http.MethodPost, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("lets the handler answer the users not logged in", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+		jar.Delete(userSubCookieName)
+
+		w := exportWithStepUp(o, http.MethodGet, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "not logged in")
+	})
+
+	t.Run("requires no step-up if disabled", func(t *testing.T) {
+		o, _ := setupStepUpTest(t)
+		o.stepUp = nil
+
+		w := exportWithStepUp(o, http.MethodGet, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("forgets the authentication of the session on logout", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+		setAuthContext(jar, "phr", []string{"mfa"}, time.Now())
+
+		w := httptest.NewRecorder()
+		o.userLogoutHandler(w, newUserLogoutRequest())
+		require.Equal(t, http.StatusOK, w.Code)
+
+		for _, name := range []string{authTimeCookieName, authACRCookieName, authAMRCookieName} {
+			_, found := jar.Get(name)
+			require.False(t, found)
+		}
+	})
+
+	t.Run("error bad request if cookies cannot be opened", func(t *testing.T) {
+		o, _ := setupStepUpTest(t)
+		o.store.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := exportWithStepUp(o, http.MethodGet, "/oidc/userinfo/export")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "cannot open cookies")
+	})
+}
+
+func TestOperation_StepUpHandler(t *testing.T) {
+	t.Run("sends the user to authenticate again and back to the page", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+
+		w := stepUp(o, "/wallet/settings?tab=export")
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Contains(t, w.Header().Get("Location"), authorizeURL+"?")
+
+		redirect, found := jar.Get(stepUpRedirectCookieName)
+		require.True(t, found)
+		require.Equal(t, "/wallet/settings?tab=export", redirect)
+
+		_, found = jar.Get(stateCookieName)
+		require.True(t, found)
+	})
+
+	t.Run("sends the user back to the dashboard by default", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+
+		w := stepUp(o, "")
+		require.Equal(t, http.StatusFound, w.Code)
+
+		redirect, _ := jar.Get(stepUpRedirectCookieName)
+		require.Equal(t, "http://test.com/dashboard", redirect)
+	})
+
+	t.Run("error bad request if the redirect leaves the server", func(t *testing.T) {
+		for _, redirect := range []string{
+			"https://evil.example.com", "//evil.example.com", `/\evil.example.com`,
+			"/\t/evil.example.com", "/\r\n/evil.example.com", "/\x7f/evil.example.com",
+		} {
+			o, _ := setupStepUpTest(t)
+
+			w := stepUp(o, redirect)
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), "redirect must be a path of this server")
+		}
+	})
+
+	t.Run("error forbidden if not logged in", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+		jar.Delete(userSubCookieName)
+
+		w := stepUp(o, "")
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "not logged in")
+	})
+
+	t.Run("error not found if step-up is disabled", func(t *testing.T) {
+		o, _ := setupStepUpTest(t)
+		o.stepUp = nil
+
+		w := stepUp(o, "")
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("error bad request if cookies cannot be opened", func(t *testing.T) {
+		o, _ := setupStepUpTest(t)
+		o.store.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := stepUp(o, "")
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("error internal server error if cookies cannot be saved", func(t *testing.T) {
+		o, jar := setupStepUpTest(t)
+		jar.SaveErr = errors.New("test")
+
+		w := stepUp(o, "")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save session cookie")
+	})
+
+	t.Run("serves the step-up endpoint only if step-up is enabled", func(t *testing.T) {
+		o, _ := setupStepUpTest(t)
+
+		found := false
+
+		for _, h := range o.GetRESTHandlers() {
+			found = found || h.Path() == stepUpPath
+		}
+
+		require.True(t, found)
+
+		o.stepUp = nil
+
+		for _, h := range o.GetRESTHandlers() {
+			require.NotEqual(t, stepUpPath, h.Path())
+		}
+	})
+}
+
+func TestStepUpConfig(t *testing.T) {
+	t.Run("accepts any class and method if none is set", func(t *testing.T) {
+		c := &StepUpConfig{}
+		require.True(t, c.satisfies(&authContext{AuthTime: time.Now().Add(-time.Minute).Unix()}))
+		require.False(t, c.satisfies(&authContext{AuthTime: time.Now().Add(-time.Hour).Unix()}))
+		require.False(t, c.satisfies(&authContext{}))
+		require.False(t, c.satisfies(nil))
+	})
+
+	t.Run("requires one of the methods", func(t *testing.T) {
+		c := &StepUpConfig{AMRValues: []string{"mfa"}, MaxAge: time.Hour}
+		now := time.Now().Unix()
+
+		require.True(t, c.satisfies(&authContext{AMR: []string{"pwd", "mfa"}, AuthTime: now}))
+		require.False(t, c.satisfies(&authContext{AMR: []string{"pwd"}, AuthTime: now}))
+	})
+
+	t.Run("requests no class if none is set", func(t *testing.T) {
+		require.Len(t, (&StepUpConfig{}).options(), 1)
+		require.NotContains(t, (&StepUpConfig{}).challenge(), "acr_values")
+	})
+}
+
+// setupStepUpTest returns an Operation requiring the 'phr' or 'phrh' class and the 'mfa' or 'hwk' method for the
+// export, and the jar of the session of a logged in user with no recorded authentication.
+func setupStepUpTest(t *testing.T) (*Operation, *cookie.MockJar) {
+	t.Helper()
+
+	o, sub := setupExportTest(t)
+	o.SetWalletDashboard("http://test.com/dashboard")
+	o.oidcClient = &oidc2.MockClient{
+		AuthRequest: authorizeURL,
+		OAuthToken:  &oauth2.Token{AccessToken: uuid.New().String(), RefreshToken: uuid.New().String()},
+	}
+	o.stepUp = &StepUpConfig{
+		ACRValues: []string{"phr", "phrh"},
+		AMRValues: []string{"mfa", "hwk"},
+		MaxAge:    time.Minute,
+	}
+
+	jar := o.store.cookies.(*cookie.MockStore).Jar.(*cookie.MockJar)
+	jar.Set(userSubCookieName, sub)
+
+	return o, jar
+}
+
+func setAuthContext(jar cookie.Jar, acr string, amr []string, authTime time.Time) {
+	jar.Set(authTimeCookieName, authTime.Unix())
+	jar.Set(authACRCookieName, acr)
+	jar.Set(authAMRCookieName, amr)
+}
+
+// stepUpIDToken is the id_token of a fresh authentication of the user logged in the jar.
+func stepUpIDToken(t *testing.T, jar cookie.Jar, acr string, amr []string) *oidc2.MockClaimer {
+	t.Helper()
+
+	sub, _ := jar.Get(userSubCookieName)
+
+	return &oidc2.MockClaimer{
+		ClaimsFunc: func(i interface{}) error {
+			switch claims := i.(type) {
+			case *user.User:
+				claims.Sub = sub.(string)
+			case *authContext:
+				claims.ACR = acr
+				claims.AMR = amr
+				claims.AuthTime = time.Now().Unix()
+			default:
+				require.Fail(t, "unexpected claims type")
+			}
+
+			return nil
+		},
+	}
+}
+
+func exportWithStepUp(o *Operation, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	o.requireStepUp(o.exportHandler)(w, httptest.NewRequest(method, target, nil))
+
+	return w
+}
+
+func stepUp(o *Operation, redirect string) *httptest.ResponseRecorder {
+	target := stepUpPath
+	if redirect != "" {
+		target += "?" + url.Values{stepUpRedirectParam: {redirect}}.Encode()
+	}
+
+	w := httptest.NewRecorder()
+	o.stepUpHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return w
+}
@@ -0,0 +1,537 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/zcapsig"
+)
+
+// Resources the onboarding templates provision.
+const (
+	// ResourceAuthzKeystore is the authz keystore and key of the user, and the hub-auth half of the user secret.
+	// The authz key controls the other resources, so every template provisions it.
+	ResourceAuthzKeystore = "authz-keystore"
+	// ResourceOpsVault is the ops EDV vault, holding the keys of the operational keystore.
+	ResourceOpsVault = "ops-vault"
+	// ResourceOpsKeystore is the operational keystore. Its keys are kept in the ops vault if the resource depends
+	// on it.
+	ResourceOpsKeystore = "ops-keystore"
+	// ResourceOpsKeys are the EDV operational and HMAC keys of the operational keystore.
+	ResourceOpsKeys = "ops-keys"
+	// ResourceUserVault is the user EDV vault, whose documents are encrypted with the EDV keys.
+	ResourceUserVault = "user-vault"
+)
+
+// timeoutParam overrides the OnboardingStepTimeout of the calls provisioning a resource.
+const timeoutParam = "timeout"
+
+// OnboardingResource is a resource an onboarding template provisions once the resources it depends on are.
+type OnboardingResource struct {
+	Name      string            `yaml:"name"`
+	DependsOn []string          `yaml:"depends-on"`
+	Params    map[string]string `yaml:"params"`
+}
+
+// OnboardingTemplate lists the resources provisioned for new users. The resources independent of each other are
+// provisioned concurrently.
+type OnboardingTemplate struct {
+	Resources []*OnboardingResource `yaml:"resources"`
+}
+
+// OnboardingTemplates are the templates the users are onboarded with.
+type OnboardingTemplates struct {
+	// Default is the template of the users of no tenant with a template of its own. Defaults to the resources of
+	// the deployment: the authz keystore, the ops vault, keystore and keys, and the user vault if its EDV is
+	// configured.
+	Default *OnboardingTemplate `yaml:"default"`
+	// Tenants are the templates of the users of the tenants, by tenant. Their users are never onboarded with the
+	// sets of the provisioning pool, which are provisioned with the default template.
+	Tenants map[string]*OnboardingTemplate `yaml:"tenants"`
+}
+
+// LoadOnboardingTemplates loads the templates of a YAML or JSON file.
+func LoadOnboardingTemplates(path string) (*OnboardingTemplates, error) {
+	data, err := ioutil.ReadFile(path) // nolint:gosec // the path is configured by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read onboarding template file: %w", err)
+	}
+
+	templates := &OnboardingTemplates{}
+
+	// YAML is a superset of JSON
+	err = yaml.UnmarshalStrict(data, templates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse onboarding template file: %w", err)
+	}
+
+	err = templates.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// Validate the templates.
+func (t *OnboardingTemplates) Validate() error {
+	if t.Default != nil {
+		_, err := t.Default.plan()
+		if err != nil {
+			return fmt.Errorf("invalid default onboarding template: %w", err)
+		}
+	}
+
+	for tenant, template := range t.Tenants {
+		if template == nil {
+			return fmt.Errorf("invalid onboarding template of tenant %s: no resource", tenant)
+		}
+
+		_, err := template.plan()
+		if err != nil {
+			return fmt.Errorf("invalid onboarding template of tenant %s: %w", tenant, err)
+		}
+	}
+
+	return nil
+}
+
+// resourceKind is a kind of resource of the templates.
+type resourceKind struct {
+	// requires are the resources the resource depends on, directly or not.
+	requires []string
+	// with are the resources the templates provisioning the resource also provision.
+	with      []string
+	provision func(o *Operation, ctx context.Context, p *provisioning, r *plannedResource) error
+}
+
+// nolint:gochecknoglobals // the kinds are immutable
+var resourceKinds = map[string]*resourceKind{
+	ResourceAuthzKeystore: {provision: (*Operation).provisionAuthzKeystore},
+	ResourceOpsVault:      {requires: []string{ResourceAuthzKeystore}, provision: (*Operation).provisionOpsVault},
+	ResourceOpsKeystore:   {requires: []string{ResourceAuthzKeystore}, provision: (*Operation).provisionOpsKeystore},
+	ResourceOpsKeys:       {requires: []string{ResourceOpsKeystore}, provision: (*Operation).provisionOpsKeys},
+	ResourceUserVault: {
+		requires:  []string{ResourceAuthzKeystore},
+		with:      []string{ResourceOpsKeys},
+		provision: (*Operation).provisionUserVault,
+	},
+}
+
+// onboardingPlan is a validated template.
+type onboardingPlan struct {
+	// resources are sorted after the resources they depend on
+	resources []*plannedResource
+}
+
+type plannedResource struct {
+	name string
+	kind *resourceKind
+	deps []string
+	// ancestors are the resources the resource depends on, directly or not
+	ancestors map[string]bool
+	timeout   time.Duration
+}
+
+// defaultOnboardingTemplate provisions the resources of the deployment.
+func defaultOnboardingTemplate(userVault bool) *OnboardingTemplate {
+	t := &OnboardingTemplate{Resources: []*OnboardingResource{
+		{Name: ResourceAuthzKeystore},
+		{Name: ResourceOpsVault, DependsOn: []string{ResourceAuthzKeystore}},
+		{Name: ResourceOpsKeystore, DependsOn: []string{ResourceOpsVault}},
+		{Name: ResourceOpsKeys, DependsOn: []string{ResourceOpsKeystore}},
+	}}
+
+	if userVault {
+		t.Resources = append(t.Resources, &OnboardingResource{
+			Name: ResourceUserVault, DependsOn: []string{ResourceAuthzKeystore},
+		})
+	}
+
+	return t
+}
+
+// plan validates the template, and sorts its resources after the resources they depend on.
+func (t *OnboardingTemplate) plan() (*onboardingPlan, error) { // nolint:gocyclo,funlen // validates each rule
+	byName := map[string]*OnboardingResource{}
+
+	for _, r := range t.Resources {
+		if resourceKinds[r.Name] == nil {
+			return nil, fmt.Errorf("unknown resource '%s'", r.Name)
+		}
+
+		if byName[r.Name] != nil {
+			return nil, fmt.Errorf("duplicate resource %s", r.Name)
+		}
+
+		byName[r.Name] = r
+	}
+
+	if byName[ResourceAuthzKeystore] == nil {
+		return nil, fmt.Errorf("missing resource %s, which controls the other resources", ResourceAuthzKeystore)
+	}
+
+	plan := &onboardingPlan{}
+	planned := map[string]*plannedResource{}
+	visiting := map[string]bool{}
+
+	var visit func(r *OnboardingResource) error
+
+	visit = func(r *OnboardingResource) error {
+		if planned[r.Name] != nil {
+			return nil
+		}
+
+		if visiting[r.Name] {
+			return fmt.Errorf("resource %s depends on itself", r.Name)
+		}
+
+		visiting[r.Name] = true
+
+		pr := &plannedResource{name: r.Name, kind: resourceKinds[r.Name], ancestors: map[string]bool{}}
+
+		for _, dep := range r.DependsOn {
+			if byName[dep] == nil {
+				return fmt.Errorf("resource %s depends on %s, which the template does not provision", r.Name, dep)
+			}
+
+			err := visit(byName[dep])
+			if err != nil {
+				return err
+			}
+
+			pr.deps = append(pr.deps, dep)
+			pr.ancestors[dep] = true
+
+			for ancestor := range planned[dep].ancestors {
+				pr.ancestors[ancestor] = true
+			}
+		}
+
+		for _, required := range pr.kind.requires {
+			if !pr.ancestors[required] {
+				return fmt.Errorf("resource %s must depend on %s", r.Name, required)
+			}
+		}
+
+		for _, with := range pr.kind.with {
+			if byName[with] == nil {
+				return fmt.Errorf("resource %s requires the template to provision %s", r.Name, with)
+			}
+		}
+
+		for key, value := range r.Params {
+			if key != timeoutParam {
+				return fmt.Errorf("unknown parameter '%s' of resource %s", key, r.Name)
+			}
+
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid %s '%s' of resource %s: must be a positive duration", key, value, r.Name)
+			}
+
+			pr.timeout = timeout
+		}
+
+		visiting[r.Name] = false
+		planned[r.Name] = pr
+		plan.resources = append(plan.resources, pr)
+
+		return nil
+	}
+
+	for _, r := range t.Resources {
+		err := visit(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// newOnboardingPlans plans the default template and the templates of the tenants.
+func (o *Operation) newOnboardingPlans(templates *OnboardingTemplates) error {
+	defaultTemplate := defaultOnboardingTemplate(o.userEDVClient != nil)
+
+	if templates != nil && templates.Default != nil {
+		defaultTemplate = templates.Default
+	}
+
+	var err error
+
+	o.onboardingPlan, err = o.newOnboardingPlan(defaultTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid default onboarding template: %w", err)
+	}
+
+	if templates == nil {
+		return nil
+	}
+
+	o.tenantPlans = make(map[string]*onboardingPlan, len(templates.Tenants))
+
+	for tenant, template := range templates.Tenants {
+		if template == nil {
+			return fmt.Errorf("invalid onboarding template of tenant %s: no resource", tenant)
+		}
+
+		o.tenantPlans[tenant], err = o.newOnboardingPlan(template)
+		if err != nil {
+			return fmt.Errorf("invalid onboarding template of tenant %s: %w", tenant, err)
+		}
+	}
+
+	return nil
+}
+
+func (o *Operation) newOnboardingPlan(template *OnboardingTemplate) (*onboardingPlan, error) {
+	plan, err := template.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range plan.resources {
+		if r.name == ResourceUserVault && o.userEDVClient == nil {
+			return nil, fmt.Errorf("resource %s requires the user EDV URL", ResourceUserVault)
+		}
+	}
+
+	return plan, nil
+}
+
+// provisioning holds the resources provisioned so far for a user, or for a set of the provisioning pool. Each
+// resource sets its own fields, read by the resources depending on it once it is provisioned.
+type provisioning struct {
+	h                  *hubKMSHeader
+	hubAuthSecretShare []byte
+	data               *BootstrapData
+	// set by the authz keystore
+	controller  string
+	authzSigner signer
+	// set by the ops vault
+	opsEDVCapability []byte
+	// set by the ops keystore
+	opsKeyStore *keystore
+}
+
+// runPlan provisions the resources of the plan, each once the resources it depends on are provisioned.
+func (o *Operation) runPlan(ctx context.Context, plan *onboardingPlan, p *provisioning) error {
+	done := make(map[string]chan struct{}, len(plan.resources))
+
+	for _, r := range plan.resources {
+		done[r.name] = make(chan struct{})
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	for _, r := range plan.resources {
+		r := r
+
+		g.Go(func() error {
+			for _, dep := range r.deps {
+				select {
+				case <-done[dep]:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+			}
+
+			err := r.kind.provision(o, gctx, p, r)
+			if err != nil {
+				return err
+			}
+
+			close(done[r.name])
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// resourceStep bounds a step of the provisioning of the resource by its timeout, or the per-step timeout.
+func (o *Operation) resourceStep(r *plannedResource) stepFunc {
+	if r.timeout <= 0 {
+		return o.onboardStep
+	}
+
+	return func(ctx context.Context) (context.Context, context.CancelFunc) {
+		return context.WithTimeout(ctx, r.timeout)
+	}
+}
+
+func (o *Operation) provisionAuthzKeystore(ctx context.Context, p *provisioning, r *plannedResource) error {
+	step := o.resourceStep(r)
+
+	authzKeyStoreURL, keyID, err := o.createAuthzKey(ctx, p.h, p.hubAuthSecretShare, step)
+	if err != nil {
+		return err
+	}
+
+	pkBytes, err := o.exportAuthzKey(ctx, authzKeyStoreURL, keyID, p.h, step)
+	if err != nil {
+		return err
+	}
+
+	_, p.controller = fingerprint.CreateDIDKey(pkBytes)
+
+	// the signer calls the authz KMS within the steps calling the ops KMS, so it is only bounded by the onboarding
+	p.authzSigner = newKMSSigner(ctx, o.keyServer.AuthzKMSURL, getKeystoreID(authzKeyStoreURL), keyID, p.h,
+		o.httpClient)
+	p.data.AuthzKeyStoreURL = authzKeyStoreURL
+
+	return nil
+}
+
+func (o *Operation) provisionOpsVault(ctx context.Context, p *provisioning, r *plannedResource) error {
+	stepCtx, cancel := o.resourceStep(r)(ctx)
+	defer cancel()
+
+	opsEDVVaultURL, opsEDVCapability, err := o.createEDVDataVault(stepCtx, o.keyEDVClient, p.controller,
+		p.h.accessToken)
+	if err != nil {
+		return fmt.Errorf("create edv vault : %w", err)
+	}
+
+	p.opsEDVCapability = opsEDVCapability
+	p.data.OpsEDVVaultURL = opsEDVVaultURL
+
+	return nil
+}
+
+// provisionOpsKeystore creates the operational keystore, keeping its keys in the ops vault if the keystore
+// depends on it.
+func (o *Operation) provisionOpsKeystore(ctx context.Context, p *provisioning, r *plannedResource) error {
+	step := o.resourceStep(r)
+
+	var opsEDVVaultID string
+
+	if r.ancestors[ResourceOpsVault] {
+		opsEDVVaultID = getVaultID(p.data.OpsEDVVaultURL)
+	}
+
+	stepCtx, cancel := step(ctx)
+	opsKeyStore, err := createKeyStore(stepCtx, o.keyServer.OpsKMSURL, p.controller,
+		opsEDVVaultID, &hubKMSHeader{accessToken: p.h.accessToken}, o.httpClient)
+	cancel()
+
+	if err != nil {
+		return fmt.Errorf("create operational keystore : %w", err)
+	}
+
+	p.opsKeyStore = opsKeyStore
+
+	if opsEDVVaultID != "" && len(p.opsEDVCapability) != 0 {
+		stepCtx, cancel = step(ctx)
+		err = updateEDVCapabilityInKeyStore(o.opsKMSContext(stepCtx, p, updateEDVCapabilityAction),
+			o.keyServer.OpsKMSURL, getKeystoreID(opsKeyStore.url), p.controller, opsEDVVaultID,
+			p.opsEDVCapability, opsKeyStore.edvDIDKey, p.authzSigner, o.httpClient)
+		cancel()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	p.data.OpsKeyStoreURL = opsKeyStore.url
+
+	return nil
+}
+
+// provisionOpsKeys creates the EDV keys of the operational keystore.
+func (o *Operation) provisionOpsKeys(ctx context.Context, p *provisioning, r *plannedResource) error {
+	step := o.resourceStep(r)
+	opsKeyStoreID := getKeystoreID(p.opsKeyStore.url)
+
+	var edvOpsKID, hmacEDVKID string
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		stepCtx, cancel := step(gctx)
+		defer cancel()
+
+		var err error
+
+		edvOpsKID, err = createKey(o.opsKMSContext(stepCtx, p, createKeyAction), o.keyServer.OpsKMSURL,
+			opsKeyStoreID, kms.ECDH256KWAES256GCM, p.h, o.httpClient)
+		if err != nil {
+			return fmt.Errorf("create edv operational key : %w", err)
+		}
+
+		return nil
+	})
+
+	g.Go(func() error {
+		stepCtx, cancel := step(gctx)
+		defer cancel()
+
+		var err error
+
+		hmacEDVKID, err = createKey(o.opsKMSContext(stepCtx, p, createKeyAction), o.keyServer.OpsKMSURL,
+			opsKeyStoreID, kms.HMACSHA256Tag256, p.h, o.httpClient)
+		if err != nil {
+			return fmt.Errorf("create edv hmac key : %w", err)
+		}
+
+		return nil
+	})
+
+	err := g.Wait()
+	if err != nil {
+		return err
+	}
+
+	p.data.EDVOpsKIDURL = fmt.Sprintf("%s/keys/%s", p.opsKeyStore.url, edvOpsKID)
+	p.data.EDVHMACKIDURL = fmt.Sprintf("%s/keys/%s", p.opsKeyStore.url, hmacEDVKID)
+
+	return nil
+}
+
+func (o *Operation) provisionUserVault(ctx context.Context, p *provisioning, r *plannedResource) error {
+	stepCtx, cancel := o.resourceStep(r)(ctx)
+	defer cancel()
+
+	userEDVVaultURL, userEDVCapability, err := o.createEDVDataVault(stepCtx, o.userEDVClient, p.controller,
+		p.h.accessToken)
+	if err != nil {
+		return fmt.Errorf("create user edv vault : %w", err)
+	}
+
+	p.data.UserEDVVaultURL = userEDVVaultURL
+	p.data.UserEDVCapability = string(userEDVCapability)
+
+	return nil
+}
+
+// opsKMSContext authorizes the requests to the operational keystore: the keystore is controlled by the authz key,
+// so when the ops KMS secures the keystore with zcaps, its requests invoke the root capability of the keystore,
+// signed by the authz key.
+func (o *Operation) opsKMSContext(ctx context.Context, p *provisioning, action string) context.Context {
+	if len(p.opsKeyStore.capability) == 0 {
+		return ctx
+	}
+
+	return zcapsig.WithAction(zcapsig.NewContext(ctx, zcapsig.Invocations{
+		o.keyServer.OpsKMSURL: {
+			Capability: p.opsKeyStore.capability,
+			KeyID:      p.controller,
+			Signer:     p.authzSigner,
+		},
+	}), action)
+}
@@ -0,0 +1,296 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+)
+
+func TestLoadOnboardingTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	require.NoError(t, err)
+
+	defer func() { require.NoError(t, os.RemoveAll(dir)) }()
+
+	file := func(content string) string {
+		f, err := ioutil.TempFile(dir, "*.yaml")
+		require.NoError(t, err)
+
+		_, err = f.WriteString(content)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		return f.Name()
+	}
+
+	t.Run("loads the templates", func(t *testing.T) {
+		templates, err := LoadOnboardingTemplates(file(`
+default:
+  resources:
+    - name: authz-keystore
+    - name: ops-keystore
+      depends-on: [authz-keystore]
+      params:
+        timeout: 5s
+tenants:
+  acme:
+    resources: [{name: authz-keystore}]
+`))
+		require.NoError(t, err)
+		require.Len(t, templates.Default.Resources, 2)
+		require.Equal(t, "5s", templates.Default.Resources[1].Params["timeout"])
+		require.Len(t, templates.Tenants["acme"].Resources, 1)
+	})
+
+	t.Run("loads the templates of a JSON file", func(t *testing.T) {
+		templates, err := LoadOnboardingTemplates(file(`{"default":{"resources":[{"name":"authz-keystore"}]}}`))
+		require.NoError(t, err)
+		require.Len(t, templates.Default.Resources, 1)
+	})
+
+	t.Run("error if the file is missing", func(t *testing.T) {
+		_, err := LoadOnboardingTemplates(filepath.Join(dir, "missing.yaml"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read onboarding template file")
+	})
+
+	t.Run("error if the file is malformed", func(t *testing.T) {
+		_, err := LoadOnboardingTemplates(file(`default: {resources: [{name: authz-keystore, size: 1}]}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to parse onboarding template file")
+	})
+
+	t.Run("error if a template is invalid", func(t *testing.T) {
+		_, err := LoadOnboardingTemplates(file(`tenants: {acme: {resources: [{name: ops-vault}]}}`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid onboarding template of tenant acme")
+
+		_, err = LoadOnboardingTemplates(file(`tenants: {acme: }`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid onboarding template of tenant acme: no resource")
+	})
+}
+
+func TestOnboardingTemplates_Validate(t *testing.T) {
+	authz := &OnboardingResource{Name: ResourceAuthzKeystore}
+
+	for _, tc := range []struct {
+		name      string
+		resources []*OnboardingResource
+		err       string
+	}{{
+		name:      "unknown resource",
+		resources: []*OnboardingResource{authz, {Name: "sms"}},
+		err:       "unknown resource 'sms'",
+	}, {
+		name:      "duplicate resource",
+		resources: []*OnboardingResource{authz, authz},
+		err:       "duplicate resource authz-keystore",
+	}, {
+		name:      "missing authz keystore",
+		resources: []*OnboardingResource{},
+		err:       "missing resource authz-keystore",
+	}, {
+		name: "unknown dependency",
+		resources: []*OnboardingResource{authz,
+			{Name: ResourceOpsKeystore, DependsOn: []string{ResourceOpsVault}}},
+		err: "resource ops-keystore depends on ops-vault, which the template does not provision",
+	}, {
+		name: "cycle",
+		resources: []*OnboardingResource{
+			{Name: ResourceAuthzKeystore, DependsOn: []string{ResourceOpsVault}},
+			{Name: ResourceOpsVault, DependsOn: []string{ResourceAuthzKeystore}},
+		},
+		err: "depends on itself",
+	}, {
+		name: "missing required dependency",
+		resources: []*OnboardingResource{authz, {Name: ResourceOpsKeystore, DependsOn: []string{ResourceAuthzKeystore}},
+			{Name: ResourceOpsKeys, DependsOn: []string{ResourceAuthzKeystore}}},
+		err: "resource ops-keys must depend on ops-keystore",
+	}, {
+		name:      "user vault without the ops keys",
+		resources: []*OnboardingResource{authz, {Name: ResourceUserVault, DependsOn: []string{ResourceAuthzKeystore}}},
+		err:       "resource user-vault requires the template to provision ops-keys",
+	}, {
+		name:      "unknown parameter",
+		resources: []*OnboardingResource{{Name: ResourceAuthzKeystore, Params: map[string]string{"size": "1"}}},
+		err:       "unknown parameter 'size' of resource authz-keystore",
+	}, {
+		name:      "invalid timeout",
+		resources: []*OnboardingResource{{Name: ResourceAuthzKeystore, Params: map[string]string{"timeout": "-1s"}}},
+		err:       "invalid timeout '-1s' of resource authz-keystore",
+	}} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			err := (&OnboardingTemplates{Default: &OnboardingTemplate{Resources: tc.resources}}).Validate()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid default onboarding template")
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	t.Run("the default template is valid", func(t *testing.T) {
+		require.NoError(t, (&OnboardingTemplates{Default: defaultOnboardingTemplate(true)}).Validate())
+	})
+}
+
+func TestOperation_OnboardingTemplates(t *testing.T) {
+	opsKeystoreOnly := &OnboardingTemplate{Resources: []*OnboardingResource{
+		{Name: ResourceAuthzKeystore},
+		{Name: ResourceOpsKeystore, DependsOn: []string{ResourceAuthzKeystore}},
+		{Name: ResourceOpsKeys, DependsOn: []string{ResourceOpsKeystore}, Params: map[string]string{"timeout": "1m"}},
+	}}
+
+	newOperation := func(t *testing.T, templates *OnboardingTemplates) (*Operation, *recordingKMS) {
+		t.Helper()
+
+		config := config(t)
+		config.OnboardingTemplates = templates
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		kms := &recordingKMS{}
+		o.httpClient = kms
+		o.keyServer = &KeyServerConfig{AuthzKMSURL: "http://authz.example.com", OpsKMSURL: "http://ops.example.com"}
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+
+		return o, kms
+	}
+
+	t.Run("provisions the resources of the deployment by default", func(t *testing.T) {
+		o, kms := newOperation(t, nil)
+
+		set, err := o.provisionSet(context.Background(), "sub", "token")
+		require.NoError(t, err)
+		require.NotEmpty(t, set.Data.OpsEDVVaultURL)
+		require.NotEmpty(t, set.Data.EDVHMACKIDURL)
+		require.NotEmpty(t, set.Data.UserEDVVaultURL)
+		require.Equal(t, getVaultID(set.Data.OpsEDVVaultURL), kms.opsKeystoreVaultID())
+	})
+
+	t.Run("provisions the resources of the default template", func(t *testing.T) {
+		o, kms := newOperation(t, &OnboardingTemplates{Default: opsKeystoreOnly})
+
+		set, err := o.provisionSet(context.Background(), "sub", "token")
+		require.NoError(t, err)
+		require.NotEmpty(t, set.Data.EDVOpsKIDURL)
+		require.NotEmpty(t, set.Data.EDVHMACKIDURL)
+		require.Empty(t, set.Data.OpsEDVVaultURL)
+		require.Empty(t, set.Data.UserEDVVaultURL)
+		require.Empty(t, kms.opsKeystoreVaultID())
+		require.Nil(t, o.keyEDVClient.(*mockEDVClient).Created)
+	})
+
+	t.Run("onboards the users of a tenant with its template", func(t *testing.T) {
+		o, _ := newOperation(t, &OnboardingTemplates{Tenants: map[string]*OnboardingTemplate{
+			"acme": opsKeystoreOnly,
+		}})
+
+		_, data, err := o.onboardUser(context.Background(), &user.User{
+			Sub: "sub", Attributes: map[string]interface{}{TenantAttribute: "acme"},
+		}, "token")
+		require.NoError(t, err)
+		require.Empty(t, data.OpsEDVVaultURL)
+		require.Empty(t, data.UserEDVVaultURL)
+
+		_, data, err = o.onboardUser(context.Background(), &user.User{Sub: "other"}, "token")
+		require.NoError(t, err)
+		require.NotEmpty(t, data.OpsEDVVaultURL)
+		require.NotEmpty(t, data.UserEDVVaultURL)
+	})
+
+	t.Run("stops provisioning once a resource fails", func(t *testing.T) {
+		o, kms := newOperation(t, nil)
+		o.keyEDVClient = &mockEDVClient{CreateErr: errors.New("test")}
+
+		_, err := o.provisionSet(context.Background(), "sub", "token")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "create edv vault : create data vault : test")
+		require.Empty(t, kms.opsKeystoreVaultID())
+	})
+
+	t.Run("error if a template provisions the user vault without its EDV", func(t *testing.T) {
+		config := config(t)
+		config.UserEDVURL = ""
+		config.OnboardingTemplates = &OnboardingTemplates{Tenants: map[string]*OnboardingTemplate{
+			"acme": defaultOnboardingTemplate(true),
+		}}
+
+		_, err := New(config)
+		require.Error(t, err)
+		require.Contains(t, err.Error(),
+			"invalid onboarding template of tenant acme: resource user-vault requires the user EDV URL")
+
+		config.OnboardingTemplates = nil
+
+		o, err := New(config)
+		require.NoError(t, err)
+		require.Len(t, o.onboardingPlan.resources, 4)
+	})
+
+	t.Run("bounds the steps of a resource by its timeout", func(t *testing.T) {
+		o, _ := newOperation(t, nil)
+
+		ctx, cancel := o.resourceStep(&plannedResource{timeout: time.Minute})(context.Background())
+		defer cancel()
+
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		require.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+	})
+}
+
+// recordingKMS mocks hub-auth and the KMS servers, recording the keystores created on the ops KMS.
+type recordingKMS struct {
+	mutex     sync.Mutex
+	keystores []*createKeystoreReq
+}
+
+func (k *recordingKMS) Do(req *http.Request) (*http.Response, error) {
+	if req.Host == "ops.example.com" && req.URL.Path == hubKMSCreateKeyStorePath {
+		r := &createKeystoreReq{}
+
+		err := json.NewDecoder(req.Body).Decode(r)
+		if err != nil {
+			return nil, err
+		}
+
+		k.mutex.Lock()
+		k.keystores = append(k.keystores, r)
+		k.mutex.Unlock()
+	}
+
+	return mockKMSHTTPClient().Do(req)
+}
+
+// opsKeystoreVaultID returns the vault ID of the last keystore created on the ops KMS.
+func (k *recordingKMS) opsKeystoreVaultID() string {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if len(k.keystores) == 0 {
+		return ""
+	}
+
+	return k.keystores[len(k.keystores)-1].VaultID
+}
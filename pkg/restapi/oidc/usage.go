@@ -0,0 +1,365 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/pseudonym"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	usagePath      = "/usage"
+	usersUsagePath = "/users/usage"
+	usageTopParam  = "top"
+	usageStoreName = "edgeagent_usage"
+	// the users listed by the aggregate view by default.
+	defaultUsageTop = 10
+	// the authz key, and the operational and HMAC keys of the vault, created by the onboarding of a user.
+	onboardingKeys = 3
+	// the operational and HMAC keys created by the rotation of the keys of a vault.
+	rotationKeys = 2
+)
+
+// Resources counted against the quotas of the users.
+const (
+	ResourceDocuments = "documents"
+	ResourceKeys      = "keys"
+)
+
+// QuotaConfig bounds the resources of each user. Zero is unlimited.
+type QuotaConfig struct {
+	// Documents is the number of documents the agent writes to the vault of a user: credentials, attachment
+	// chunks, backups and preferences. The documents the wallet UI writes to the vault itself are not counted.
+	Documents int `json:"documents,omitempty"`
+	// Keys is the number of keys the agent creates in the keystores of a user. The keys of the onboarding are
+	// counted but always created.
+	Keys int `json:"keys,omitempty"`
+}
+
+// QuotaExceededError is returned by the writes that would take a user over quota.
+type QuotaExceededError struct {
+	// Resource is one of the resources counted against the quotas.
+	Resource string
+	Quota    int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %d exceeded", e.Resource, e.Quota)
+}
+
+// Usage of the resources of a user, counted since the agent started tracking them.
+type Usage struct {
+	Documents int          `json:"documents"`
+	Keys      int          `json:"keys"`
+	UpdatedAt *time.Time   `json:"updatedAt,omitempty"`
+	Quota     *QuotaConfig `json:"quota,omitempty"`
+}
+
+// UserUsage is the usage of a user in the aggregate view.
+type UserUsage struct {
+	Sub string `json:"sub"`
+	*Usage
+}
+
+// UsageSummary is the usage of all the users, with the heaviest users by number of documents.
+type UsageSummary struct {
+	Users     int `json:"users"`
+	Documents int `json:"documents"`
+	Keys      int `json:"keys"`
+	// OverQuota is the number of users who reached one of their quotas.
+	OverQuota int          `json:"overQuota"`
+	Top       []*UserUsage `json:"top"`
+}
+
+type usage struct {
+	store storage.Store
+	ids   *pseudonym.Mapper
+	quota QuotaConfig
+	// serializes the updates of the counters
+	mutex sync.Mutex
+}
+
+func newUsage(config *QuotaConfig, provider storage.Provider, ids *pseudonym.Mapper) (*usage, error) {
+	s, err := store.Open(provider, usageStoreName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the summaries list the usage while the users write it
+	u := &usage{store: store.Locked(s), ids: ids}
+
+	if config != nil {
+		u.quota = *config
+	}
+
+	return u, nil
+}
+
+// get returns the usage of the user, which is zero if nothing was counted yet.
+func (u *usage) get(sub string) (*Usage, error) {
+	bits, err := u.store.Get(u.ids.ID(sub))
+	if errors.Is(err, storage.ErrValueNotFound) {
+		return &Usage{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch usage: %w", err)
+	}
+
+	current := &Usage{}
+
+	err = json.Unmarshal(bits, current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal usage: %w", err)
+	}
+
+	return current, nil
+}
+
+// reserve counts the resources against the quotas of the user before they are created.
+func (u *usage) reserve(sub string, documents, keys int) error {
+	return u.update(sub, documents, keys, true)
+}
+
+// record counts the resources created regardless of the quotas of the user.
+func (u *usage) record(sub string, documents, keys int) {
+	err := u.update(sub, documents, keys, false)
+	if err != nil {
+		logger.Errorf("failed to record usage of user %s: %s", sub, err.Error())
+	}
+}
+
+// release uncounts the resources deleted, or reserved but not created.
+func (u *usage) release(sub string, documents, keys int) {
+	u.record(sub, -documents, -keys)
+}
+
+func (u *usage) update(sub string, documents, keys int, enforce bool) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	current, err := u.get(sub)
+	if err != nil {
+		return err
+	}
+
+	if enforce && documents > 0 && u.quota.Documents > 0 && current.Documents+documents > u.quota.Documents {
+		return &QuotaExceededError{Resource: ResourceDocuments, Quota: u.quota.Documents}
+	}
+
+	if enforce && keys > 0 && u.quota.Keys > 0 && current.Keys+keys > u.quota.Keys {
+		return &QuotaExceededError{Resource: ResourceKeys, Quota: u.quota.Keys}
+	}
+
+	now := time.Now().UTC()
+
+	current.Documents = nonNegative(current.Documents + documents)
+	current.Keys = nonNegative(current.Keys + keys)
+	current.UpdatedAt = &now
+
+	err = store.Save(u.store, u.ids.ID(sub), current)
+	if err != nil {
+		return fmt.Errorf("failed to save usage: %w", err)
+	}
+
+	return nil
+}
+
+// delete forgets the usage of a deleted user.
+func (u *usage) delete(sub string) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	err := u.store.Delete(u.ids.ID(sub))
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to delete usage: %w", err)
+	}
+
+	return nil
+}
+
+// overQuota reports whether the usage reached one of the quotas.
+func (u *usage) overQuota(current *Usage) bool {
+	return (u.quota.Documents > 0 && current.Documents >= u.quota.Documents) ||
+		(u.quota.Keys > 0 && current.Keys >= u.quota.Keys)
+}
+
+// summary aggregates the usage of all the users, listing the top users by number of documents.
+func (u *usage) summary(top int) (*UsageSummary, error) {
+	all, err := u.store.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list usage: %w", err)
+	}
+
+	summary := &UsageSummary{Top: []*UserUsage{}}
+	users := make([]*UserUsage, 0, len(all))
+
+	for id, bits := range all {
+		current := &Usage{}
+
+		err = json.Unmarshal(bits, current)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal usage: %w", err)
+		}
+
+		summary.Users++
+		summary.Documents += current.Documents
+		summary.Keys += current.Keys
+
+		if u.overQuota(current) {
+			summary.OverQuota++
+		}
+
+		users = append(users, &UserUsage{Sub: id, Usage: current})
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Documents != users[j].Documents {
+			return users[i].Documents > users[j].Documents
+		}
+
+		return users[i].Keys > users[j].Keys
+	})
+
+	if len(users) > top {
+		users = users[:top]
+	}
+
+	for _, usr := range users {
+		sub, errSub := u.ids.Sub(usr.Sub)
+		if errSub != nil {
+			logger.Warnf("listed the usage of user id %s, whose sub is unknown: %s", usr.Sub, errSub.Error())
+
+			continue
+		}
+
+		usr.Sub = sub
+	}
+
+	summary.Top = append(summary.Top, users...)
+
+	return summary, nil
+}
+
+func nonNegative(n int) int {
+	if n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// quotaStore counts the documents of a store of the user's vault against the quota of the user.
+type quotaStore struct {
+	ariesstorage.Store
+	sub   string
+	usage *usage
+}
+
+// Put counts the document if it is new. The error is a QuotaExceededError if the user is over quota.
+func (s *quotaStore) Put(k string, v []byte) error {
+	_, err := s.Store.Get(k)
+	if err == nil {
+		return s.Store.Put(k, v)
+	}
+
+	if !errors.Is(err, ariesstorage.ErrDataNotFound) {
+		return err
+	}
+
+	err = s.usage.reserve(s.sub, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	err = s.Store.Put(k, v)
+	if err != nil {
+		s.usage.release(s.sub, 1, 0)
+	}
+
+	return err
+}
+
+// Delete uncounts the document if it existed.
+func (s *quotaStore) Delete(k string) error {
+	_, err := s.Store.Get(k)
+	existed := err == nil
+
+	err = s.Store.Delete(k)
+	if err == nil && existed {
+		s.usage.release(s.sub, 1, 0)
+	}
+
+	return err
+}
+
+func (o *Operation) usageHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := o.loggedInUser(w, r)
+	if !ok {
+		return
+	}
+
+	current, err := o.usage.get(sub)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	current.Quota = &o.usage.quota
+	common.WriteResponse(w, logger, current)
+}
+
+func (o *Operation) usersUsageHandler(w http.ResponseWriter, r *http.Request) {
+	top := defaultUsageTop
+
+	if value := r.URL.Query().Get(usageTopParam); value != "" {
+		var err error
+
+		top, err = strconv.Atoi(value)
+		if err != nil || top < 0 {
+			common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid %s parameter: must be a non-negative integer",
+				usageTopParam)
+
+			return
+		}
+	}
+
+	summary, err := o.usage.summary(top)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, summary)
+}
+
+// writeVaultErrorResponse writes the error of a write to the user's vault, answering 507 if the write would take
+// the user over quota.
+func writeVaultErrorResponse(w http.ResponseWriter, err error, msg string) {
+	status := http.StatusInternalServerError
+
+	var quotaErr *QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		status = http.StatusInsufficientStorage
+	}
+
+	common.WriteErrorResponsef(w, logger, status, "%s: %s", msg, err.Error())
+}
@@ -0,0 +1,208 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestOperation_Usage(t *testing.T) {
+	t.Run("counts the documents the agent writes to the vault of the user", func(t *testing.T) {
+		o, sub, _ := setupBackupTest(t)
+		o.chunkSize = 4
+
+		// 3 chunks and the manifest
+		manifest := uploadAttachment(t, o, "0123456789", "")
+		require.Equal(t, 4, userUsage(t, o).Documents)
+
+		require.Equal(t, http.StatusOK, putPreferences(o, `{"theme": "dark"}`).Code)
+		require.Equal(t, http.StatusOK, putPreferences(o, `{"theme": "light"}`).Code)
+		require.Equal(t, 5, userUsage(t, o).Documents)
+
+		w := httptest.NewRecorder()
+		o.deleteAttachmentHandler(w, httptest.NewRequest(http.MethodDelete,
+			walletAttachmentsPath+"?id="+manifest.ID, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		usage := userUsage(t, o)
+		require.Equal(t, 1, usage.Documents)
+		require.NotNil(t, usage.UpdatedAt)
+		require.Equal(t, &QuotaConfig{}, usage.Quota)
+
+		stored, err := o.usage.get(sub)
+		require.NoError(t, err)
+		require.Equal(t, 1, stored.Documents)
+	})
+
+	t.Run("insufficient storage if the write takes the user over quota", func(t *testing.T) {
+		o, _, vault := setupBackupTest(t)
+		o.chunkSize = 4
+		o.usage.quota = QuotaConfig{Documents: 2}
+
+		w := httptest.NewRecorder()
+		o.uploadAttachmentHandler(w, httptest.NewRequest(http.MethodPost, walletAttachmentsPath,
+			strings.NewReader("0123456789")))
+		require.Equal(t, http.StatusInsufficientStorage, w.Code)
+		require.Contains(t, w.Body.String(), "documents quota of 2 exceeded")
+
+		// the chunks saved are deleted with the attachment
+		requireNoAttachments(t, vault)
+		require.Zero(t, userUsage(t, o).Documents)
+
+		require.Equal(t, http.StatusOK, putPreferences(o, `{}`).Code)
+		require.Equal(t, http.StatusOK, putPreferences(o, `{"theme": "dark"}`).Code)
+
+		usage := userUsage(t, o)
+		require.Equal(t, 1, usage.Documents)
+		require.Equal(t, &QuotaConfig{Documents: 2}, usage.Quota)
+	})
+
+	t.Run("counts the keys of the onboarding and bounds those of the rotations", func(t *testing.T) {
+		o := setupOnboardingTest(t, uuid.New().String())
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+		o.usage.quota = QuotaConfig{Keys: 4}
+
+		sub := uuid.New().String()
+		require.NoError(t, o.provisionUser(context.Background(), &user.User{Sub: sub}, uuid.New().String()))
+
+		usage, err := o.usage.get(sub)
+		require.NoError(t, err)
+		require.Equal(t, onboardingKeys, usage.Keys)
+
+		err = o.usage.reserve(sub, 0, rotationKeys)
+
+		var quotaErr *QuotaExceededError
+		require.True(t, errors.As(err, &quotaErr))
+		require.Equal(t, ResourceKeys, quotaErr.Resource)
+		require.Equal(t, 4, quotaErr.Quota)
+
+		require.NoError(t, o.usage.delete(sub))
+
+		usage, err = o.usage.get(sub)
+		require.NoError(t, err)
+		require.Zero(t, usage.Keys)
+	})
+
+	t.Run("forbidden if not logged in", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{}}}
+
+		w := httptest.NewRecorder()
+		o.usageHandler(w, httptest.NewRequest(http.MethodGet, usagePath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("error if the usage cannot be read", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		sub := loginAs(o, uuid.New().String())
+		o.usage.store = &mockstore.MockStore{
+			Store:  map[string][]byte{o.usage.ids.ID(sub): []byte("{}")},
+			ErrGet: errors.New("test"),
+		}
+
+		w := httptest.NewRecorder()
+		o.usageHandler(w, httptest.NewRequest(http.MethodGet, usagePath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch usage")
+
+		err = o.usage.reserve(sub, 1, 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch usage")
+	})
+}
+
+func TestOperation_UsersUsageHandler(t *testing.T) {
+	t.Run("aggregates the usage of the users", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		o.usage.quota = QuotaConfig{Documents: 10}
+
+		o.usage.record("alice", 10, 3)
+		o.usage.record("bob", 2, 3)
+		o.usage.record("carol", 5, 5)
+
+		summary := usersUsage(t, o, "")
+		require.Equal(t, 3, summary.Users)
+		require.Equal(t, 17, summary.Documents)
+		require.Equal(t, 11, summary.Keys)
+		require.Equal(t, 1, summary.OverQuota)
+		require.Len(t, summary.Top, 3)
+		require.Equal(t, "alice", summary.Top[0].Sub)
+		require.Equal(t, "carol", summary.Top[1].Sub)
+
+		summary = usersUsage(t, o, "?top=1")
+		require.Len(t, summary.Top, 1)
+		require.Equal(t, "alice", summary.Top[0].Sub)
+		require.Equal(t, 10, summary.Top[0].Documents)
+	})
+
+	t.Run("bad request if the number of users listed is invalid", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.usersUsageHandler(w, httptest.NewRequest(http.MethodGet, usersUsagePath+"?top=-1", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("error if the usage cannot be listed", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		o.usage.store = &mockstore.MockStore{Store: map[string][]byte{}, ErrGetAll: errors.New("test")}
+
+		w := httptest.NewRecorder()
+		o.usersUsageHandler(w, httptest.NewRequest(http.MethodGet, usersUsagePath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to list usage")
+	})
+}
+
+func userUsage(t *testing.T, o *Operation) *Usage {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.usageHandler(w, httptest.NewRequest(http.MethodGet, usagePath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	usage := &Usage{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), usage))
+
+	return usage
+}
+
+func usersUsage(t *testing.T, o *Operation, query string) *UsageSummary {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.usersUsageHandler(w, httptest.NewRequest(http.MethodGet, usersUsagePath+query, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	summary := &UsageSummary{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), summary))
+
+	return summary
+}
@@ -0,0 +1,107 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+const (
+	userInfoKeyPrefix = "userinfo_"
+	// the query parameter of the user info requests bypassing the cache.
+	refreshParam = "refresh"
+)
+
+// UserInfoCacheConfig caches the claims of the user info of the OIDC provider, so that the wallet does not call
+// the provider on each of its user profile requests.
+type UserInfoCacheConfig struct {
+	// TTL of the cached claims.
+	TTL time.Duration
+}
+
+type cachedUserInfo struct {
+	Claims  map[string]interface{} `json:"claims"`
+	Expires time.Time              `json:"expires"`
+}
+
+// cachedUserInfo returns the cached claims of the user, if they have not expired.
+func (o *Operation) cachedUserInfo(sub string) (map[string]interface{}, bool) {
+	cached, ok := o.readUserInfo(sub)
+	if !ok || o.now().After(cached.Expires) {
+		return nil, false
+	}
+
+	return cached.Claims, true
+}
+
+// staleUserInfo returns the cached claims of the user, even if they have expired, while the provider is down.
+func (o *Operation) staleUserInfo(sub string) (map[string]interface{}, bool) {
+	cached, ok := o.readUserInfo(sub)
+	if !ok {
+		return nil, false
+	}
+
+	return cached.Claims, true
+}
+
+func (o *Operation) readUserInfo(sub string) (*cachedUserInfo, bool) {
+	if o.userInfoCache == nil {
+		return nil, false
+	}
+
+	bits, err := o.store.transient.Get(userInfoKeyPrefix + sub)
+	if err != nil {
+		if !errors.Is(err, storage.ErrValueNotFound) {
+			logger.Warnf("failed to read cached user info: %s", err.Error())
+		}
+
+		return nil, false
+	}
+
+	cached := &cachedUserInfo{}
+
+	err = json.Unmarshal(bits, cached)
+	if err != nil {
+		logger.Warnf("failed to unmarshal cached user info: %s", err.Error())
+
+		return nil, false
+	}
+
+	return cached, true
+}
+
+// cacheUserInfo caches the claims of the user. Failures are logged, as the claims are fetched again next time.
+func (o *Operation) cacheUserInfo(sub string, claims map[string]interface{}) {
+	if o.userInfoCache == nil {
+		return
+	}
+
+	err := store.Save(o.store.transient, userInfoKeyPrefix+sub, &cachedUserInfo{
+		Claims:  claims,
+		Expires: o.now().Add(o.userInfoCache.TTL),
+	})
+	if err != nil {
+		logger.Warnf("failed to cache user info: %s", err.Error())
+	}
+}
+
+// invalidateUserInfo drops the cached claims of the user once the user logs out or the tokens are refreshed.
+func (o *Operation) invalidateUserInfo(sub string) {
+	if o.userInfoCache == nil {
+		return
+	}
+
+	err := o.store.transient.Delete(userInfoKeyPrefix + sub)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		logger.Warnf("failed to invalidate cached user info: %s", err.Error())
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/claims"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+	"golang.org/x/oauth2"
+)
+
+func TestOperation_UserInfoCache(t *testing.T) {
+	t.Run("serves the cached claims until they are refreshed", func(t *testing.T) {
+		o, sub, fetches := setupUserInfoTest(t, &UserInfoCacheConfig{TTL: time.Hour})
+
+		require.Equal(t, "1", userProfile(t, o, "/oidc/userinfo")["fetch"])
+		require.Equal(t, "1", userProfile(t, o, "/oidc/userinfo")["fetch"])
+		require.Equal(t, 1, *fetches)
+
+		require.Equal(t, "2", userProfile(t, o, "/oidc/userinfo?refresh=true")["fetch"])
+		require.Equal(t, "2", userProfile(t, o, "/oidc/userinfo")["fetch"])
+
+		o.invalidateUserInfo(sub)
+		require.Equal(t, "3", userProfile(t, o, "/oidc/userinfo")["fetch"])
+	})
+
+	t.Run("fetches the claims once they expired", func(t *testing.T) {
+		o, _, fetches := setupUserInfoTest(t, &UserInfoCacheConfig{TTL: time.Nanosecond})
+
+		userProfile(t, o, "/oidc/userinfo")
+		time.Sleep(time.Millisecond)
+		userProfile(t, o, "/oidc/userinfo")
+		require.Equal(t, 2, *fetches)
+	})
+
+	t.Run("fetches the claims on each request if not cached", func(t *testing.T) {
+		o, _, fetches := setupUserInfoTest(t, nil)
+
+		userProfile(t, o, "/oidc/userinfo")
+		userProfile(t, o, "/oidc/userinfo")
+		require.Equal(t, 2, *fetches)
+	})
+
+	t.Run("invalidates the cached claims on logout", func(t *testing.T) {
+		o, sub, _ := setupUserInfoTest(t, &UserInfoCacheConfig{TTL: time.Hour})
+
+		userProfile(t, o, "/oidc/userinfo")
+		_, cached := o.cachedUserInfo(sub)
+		require.True(t, cached)
+
+		o.userLogoutHandler(httptest.NewRecorder(), newUserLogoutRequest())
+		_, cached = o.cachedUserInfo(sub)
+		require.False(t, cached)
+	})
+
+	t.Run("invalidates the cached claims once the tokens are refreshed", func(t *testing.T) {
+		o, sub, fetches := setupUserInfoTest(t, &UserInfoCacheConfig{TTL: time.Hour})
+
+		userProfile(t, o, "/oidc/userinfo")
+		login, err := o.beginLogin(&user.User{Sub: sub}, &oauth2.Token{AccessToken: "access"}, true)
+		require.NoError(t, err)
+		o.commitLogin(login)
+		userProfile(t, o, "/oidc/userinfo")
+		require.Equal(t, 2, *fetches)
+	})
+}
+
+func TestOperation_UserInfoClaimsFilter(t *testing.T) {
+	o, sub, _ := setupUserInfoTest(t, &UserInfoCacheConfig{TTL: time.Hour})
+	o.claimsFilter = &claims.Filter{
+		Deny:   []string{"fetch"},
+		Rename: map[string]string{"sub": "id"},
+	}
+
+	profile := userProfile(t, o, "/oidc/userinfo")
+	require.Equal(t, sub, profile["id"])
+	require.NotContains(t, profile, "sub")
+	require.NotContains(t, profile, "fetch")
+	require.Contains(t, profile, bootstrapDataKey)
+	require.Contains(t, profile, userConfigKey)
+
+	// the cache holds the claims of the provider, so that the filter can change without invalidating it
+	cached, ok := o.cachedUserInfo(sub)
+	require.True(t, ok)
+	require.Equal(t, "1", cached["fetch"])
+}
+
+// setupUserInfoTest returns an Operation whose provider numbers the user info it returns in the 'fetch' claim.
+func setupUserInfoTest(t *testing.T, cache *UserInfoCacheConfig) (*Operation, string, *int) {
+	t.Helper()
+
+	sub := uuid.New().String()
+	fetches := 0
+
+	config := config(t)
+	config.UserInfoCache = cache
+	config.Storage.Storage = &mockstore.Provider{
+		Store: &mockstore.MockStore{
+			Store: map[string][]byte{
+				sub: marshal(t, &tokens.UserTokens{}),
+			},
+		},
+	}
+	config.OIDCClient = &oidc2.MockClient{
+		UserInfoVal: &oidc2.MockClaimer{
+			ClaimsFunc: func(v interface{}) error {
+				fetches++
+
+				m, ok := v.(*map[string]interface{})
+				require.True(t, ok)
+				(*m)["sub"] = sub
+				(*m)["fetch"] = string(rune('0' + fetches))
+
+				return nil
+			},
+		},
+	}
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	o.store.cookies = &cookie.MockStore{
+		Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{
+				userSubCookieName: sub,
+			},
+		},
+	}
+	o.httpClient = &mockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewReader(marshal(t, &userBootstrapData{}))),
+			}, nil
+		},
+	}
+
+	return o, sub, &fetches
+}
+
+func userProfile(t *testing.T, o *Operation, target string) map[string]interface{} {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.userProfileHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	profile := make(map[string]interface{})
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&profile))
+
+	return profile
+}
@@ -0,0 +1,209 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"golang.org/x/oauth2"
+)
+
+// User returns the profile of the user, without the agent's half of the user's secret. The error wraps
+// storage.ErrValueNotFound if the user is not onboarded.
+func (o *Operation) User(sub string) (*user.User, error) {
+	usr, err := o.store.users.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	usr.SecretShare = ""
+
+	return usr, nil
+}
+
+// maxUserUpdates bounds the attempts of an update of a user saved concurrently by other instances of the agent.
+const maxUserUpdates = 3
+
+// updateUser applies the update to the stored user and saves it, reading the user anew and applying the update
+// again if another instance of the agent saved the user in the meantime. The update returns false to leave the
+// user as is. The error wraps storage.ErrValueNotFound if the user is not onboarded.
+func (o *Operation) updateUser(sub string, update func(usr *user.User) bool) (*user.User, error) {
+	for attempt := 1; ; attempt++ {
+		usr, err := o.store.users.Get(sub)
+		if err != nil {
+			return nil, err
+		}
+
+		if !update(usr) {
+			return usr, nil
+		}
+
+		err = o.store.users.Save(usr)
+		if errors.Is(err, user.ErrConflict) && attempt < maxUserUpdates {
+			logger.Debugf("user %s saved concurrently, updating it again: %s", sub, err.Error())
+
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to save user: %w", err)
+		}
+
+		return usr, nil
+	}
+}
+
+// RefreshTokens refreshes the tokens of the user with the OIDC provider and returns the expiry of the new
+// access token. The error is ErrUserDeactivated if the user is deactivated.
+func (o *Operation) RefreshTokens(ctx context.Context, sub string) (time.Time, error) {
+	err := o.checkActive(sub)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return o.refreshTokens(ctx, sub)
+}
+
+func (o *Operation) refreshTokens(ctx context.Context, sub string) (time.Time, error) {
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if tokns.Refresh == "" {
+		return time.Time{}, errors.New("user has no refresh token")
+	}
+
+	refreshed, err := o.oidcClient.RefreshToken(ctx, &oauth2.Token{
+		AccessToken:  tokns.Access,
+		TokenType:    "Bearer",
+		RefreshToken: tokns.Refresh,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// providers that do not rotate the refresh tokens return none
+	refresh := refreshed.RefreshToken
+	if refresh == "" {
+		refresh = tokns.Refresh
+	}
+
+	// the tokens keep the issuer and time of the login they were granted by
+	err = o.store.tokens.Save(&tokens.UserTokens{
+		UserSub:  sub,
+		Access:   refreshed.AccessToken,
+		Refresh:  refresh,
+		Issuer:   tokns.Issuer,
+		IssuedAt: tokns.IssuedAt,
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to persist user tokens: %w", err)
+	}
+
+	o.invalidateUserInfo(sub)
+	o.audit(audit.ActionTokensRefreshed, sub, nil)
+
+	return refreshed.Expiry, nil
+}
+
+// Onboard provisions the keystores and vaults of a user who logged in but whose onboarding failed, with the
+// tokens of the user's last login. It returns the status of the onboarding, which is only pending if the
+// agent onboards users asynchronously.
+func (o *Operation) Onboard(ctx context.Context, sub string) (*OnboardingStatus, error) {
+	status, err := o.onboardedStatus(sub)
+	if err == nil {
+		return status, nil
+	}
+
+	if !errors.Is(err, storage.ErrValueNotFound) {
+		return nil, fmt.Errorf("failed to query user data: %w", err)
+	}
+
+	// the users waiting to accept the terms have no tokens yet
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := o.userInfo(ctx, sub, tokns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user info: %w", err)
+	}
+
+	usr, err := user.ParseIDToken(info)
+	if err != nil {
+		return nil, err
+	}
+
+	if usr.Sub != sub {
+		return nil, fmt.Errorf("user info of user %s returned for user %s", usr.Sub, sub)
+	}
+
+	err = o.mapClaims(info, usr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map user claims: %w", err)
+	}
+
+	if o.onboarding != nil {
+		err = o.scheduleOnboarding(usr, tokns.Access)
+		if err != nil {
+			return nil, err
+		}
+
+		return o.onboardingStatus(sub)
+	}
+
+	err = o.provisionUser(ctx, usr, tokns.Access)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OnboardingStatus{Status: OnboardingCompleted}, nil
+}
+
+// Bootstrap returns the bootstrap data of the user held by hub-auth. The error is ErrUserDeactivated if the user
+// is deactivated.
+func (o *Operation) Bootstrap(ctx context.Context, sub string) (*BootstrapData, error) {
+	err := o.checkActive(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := o.fetchBootstrapData(ctx, tokns.Access)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bootstrap data: %w", err)
+	}
+
+	return data.Data, nil
+}
+
+// checkActive returns ErrUserDeactivated if the user is deactivated. The users not onboarded yet are active.
+func (o *Operation) checkActive(sub string) error {
+	usr, err := o.store.users.Get(sub)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		return fmt.Errorf("failed to query user data: %w", err)
+	}
+
+	if err == nil && usr.Deactivated {
+		return ErrUserDeactivated
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"golang.org/x/oauth2"
+)
+
+func TestOperation_User(t *testing.T) {
+	t.Run("returns the user without the secret share", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+
+		usr, err := o.User(sub)
+		require.NoError(t, err)
+		require.Equal(t, sub, usr.Sub)
+		require.Empty(t, usr.SecretShare)
+	})
+
+	t.Run("error if the user is not onboarded", func(t *testing.T) {
+		o, _ := setupCheckTest(t)
+
+		_, err := o.User("unknown")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+}
+
+func TestOperation_UpdateUser(t *testing.T) {
+	t.Run("updates the user saved concurrently by another instance", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+
+		racing := &racingUsers{Store: o.store.users, races: 1, race: func(usr *user.User) { usr.Name = "Alice" }}
+		o.store.users = racing
+
+		usr, err := o.updateUser(sub, func(usr *user.User) bool {
+			usr.Email = "alice@example.com"
+
+			return true
+		})
+		require.NoError(t, err)
+		require.Equal(t, "alice@example.com", usr.Email)
+
+		saved, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, "Alice", saved.Name)
+		require.Equal(t, "alice@example.com", saved.Email)
+	})
+
+	t.Run("error if the user keeps being saved concurrently", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.store.users = &racingUsers{Store: o.store.users, races: maxUserUpdates, race: func(*user.User) {}}
+
+		_, err := o.updateUser(sub, func(*user.User) bool { return true })
+		require.True(t, errors.Is(err, user.ErrConflict))
+	})
+
+	t.Run("leaves the user as is", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.store.users = &racingUsers{Store: o.store.users, races: maxUserUpdates, race: func(*user.User) {}}
+
+		_, err := o.updateUser(sub, func(*user.User) bool { return false })
+		require.NoError(t, err)
+	})
+
+	t.Run("refreshes the profile of a user saved concurrently", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+
+		stored, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+
+		o.store.users = &racingUsers{Store: o.store.users, races: 1, race: func(usr *user.User) {
+			usr.Consent = &user.Consent{Version: "v1"}
+		}}
+
+		require.NoError(t, o.refreshProfile(stored, &user.User{Sub: sub, Name: "Alice"}))
+
+		saved, err := o.store.users.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, "Alice", saved.Name)
+		require.Equal(t, "v1", saved.Consent.Version)
+	})
+}
+
+func TestOperation_RefreshTokens(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Round(time.Second)
+
+	t.Run("refreshes the tokens of the user", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: "old", Refresh: "refresh"}))
+		o.oidcClient = &oidc2.MockClient{Refreshed: &oauth2.Token{AccessToken: "new", Expiry: expiry}}
+
+		result, err := o.RefreshTokens(context.Background(), sub)
+		require.NoError(t, err)
+		require.Equal(t, expiry, result)
+
+		tokns, err := o.store.tokens.Get(sub)
+		require.NoError(t, err)
+		require.Equal(t, "new", tokns.Access)
+		require.Equal(t, "refresh", tokns.Refresh)
+	})
+
+	t.Run("error if the user has no refresh token", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+
+		_, err := o.RefreshTokens(context.Background(), sub)
+		require.EqualError(t, err, "user has no refresh token")
+	})
+
+	t.Run("error if the user has no tokens", func(t *testing.T) {
+		o, _ := setupCheckTest(t)
+
+		_, err := o.RefreshTokens(context.Background(), "unknown")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if the provider rejects the refresh token", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Refresh: "revoked"}))
+		o.oidcClient = &oidc2.MockClient{RefreshErr: errors.New("test")}
+
+		_, err := o.RefreshTokens(context.Background(), sub)
+		require.EqualError(t, err, "test")
+	})
+}
+
+func TestOperation_Onboard(t *testing.T) {
+	setup := func(t *testing.T, sub string) *Operation {
+		t.Helper()
+
+		o := setupOnboardingTest(t, "")
+		o.httpClient = mockKMSHTTPClient()
+		o.keyEDVClient = &mockEDVClient{NoCapability: true}
+		o.userEDVClient = &mockEDVClient{NoCapability: true}
+		o.oidcClient = &oidc2.MockClient{UserInfoVal: &oidc2.MockClaimer{
+			ClaimsFunc: func(v interface{}) error {
+				v.(*user.User).Sub = sub
+
+				return nil
+			},
+		}}
+
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: "user", Access: "token"}))
+
+		return o
+	}
+
+	t.Run("onboards the user", func(t *testing.T) {
+		o := setup(t, "user")
+
+		status, err := o.Onboard(context.Background(), "user")
+		require.NoError(t, err)
+		require.Equal(t, OnboardingCompleted, status.Status)
+
+		usr, err := o.store.users.Get("user")
+		require.NoError(t, err)
+		require.NotEmpty(t, usr.SecretShare)
+
+		// the user is not onboarded twice
+		status, err = o.Onboard(context.Background(), "user")
+		require.NoError(t, err)
+		require.Equal(t, OnboardingCompleted, status.Status)
+	})
+
+	t.Run("onboards the user in the background", func(t *testing.T) {
+		o := setup(t, "user")
+
+		var err error
+
+		o.onboarding, err = newOnboarding(&OnboardingConfig{}, memstore.NewProvider())
+		require.NoError(t, err)
+		o.onboarding.queue.Stop()
+
+		_, err = o.Onboard(context.Background(), "user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to schedule onboarding")
+
+		require.NoError(t, o.saveOnboardingStatus("user", OnboardingRunning, nil))
+
+		status, err := o.Onboard(context.Background(), "user")
+		require.NoError(t, err)
+		require.Equal(t, OnboardingRunning, status.Status)
+	})
+
+	t.Run("error if the user has not logged in", func(t *testing.T) {
+		o := setup(t, "user")
+
+		_, err := o.Onboard(context.Background(), "unknown")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if the user info is of another user", func(t *testing.T) {
+		o := setup(t, "other")
+
+		_, err := o.Onboard(context.Background(), "user")
+		require.EqualError(t, err, "user info of user other returned for user user")
+	})
+
+	t.Run("error if the user info cannot be fetched", func(t *testing.T) {
+		o := setup(t, "user")
+		o.oidcClient = &oidc2.MockClient{UserInfoErr: errors.New("test")}
+
+		_, err := o.Onboard(context.Background(), "user")
+		require.EqualError(t, err, "failed to fetch user info: test")
+	})
+
+	t.Run("error if the user cannot be provisioned", func(t *testing.T) {
+		o := setup(t, "user")
+		o.secretSplitter = &mockSplitter{SplitErr: errors.New("test")}
+
+		_, err := o.Onboard(context.Background(), "user")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "split user secret key")
+	})
+}
+
+func TestOperation_Bootstrap(t *testing.T) {
+	t.Run("returns the bootstrap data of the user", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+
+		data, err := o.Bootstrap(context.Background(), sub)
+		require.NoError(t, err)
+		require.Equal(t, "https://edv.example.com/encrypted-data-vaults/123", data.UserEDVVaultURL)
+	})
+
+	t.Run("error if the user has no tokens", func(t *testing.T) {
+		o, _ := setupCheckTest(t)
+
+		_, err := o.Bootstrap(context.Background(), "unknown")
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+	})
+
+	t.Run("error if hub-auth fails", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = &mockHTTPClient{DoFunc: func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("test")
+		}}
+
+		_, err := o.Bootstrap(context.Background(), sub)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch bootstrap data")
+	})
+}
+
+// racingUsers saves a concurrent update of each user ahead of its first saves, like another instance of the agent.
+type racingUsers struct {
+	user.Store
+	races int
+	race  func(usr *user.User)
+}
+
+func (r *racingUsers) Save(usr *user.User) error {
+	if r.races > 0 {
+		r.races--
+
+		concurrent, err := r.Store.Get(usr.Sub)
+		if err != nil {
+			return err
+		}
+
+		r.race(concurrent)
+
+		err = r.Store.Save(concurrent)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.Store.Save(usr)
+}
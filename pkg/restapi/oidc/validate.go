@@ -0,0 +1,171 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/health"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Admin endpoints.
+const (
+	validatePath = "/validate"
+)
+
+const (
+	// the store of the records round-tripped by the validation of the storage
+	validationStoreName = "edgeagent_validation"
+	validationKeyPrefix = "validation_"
+)
+
+// ValidationReport is the result of the validation of the configuration of the agent against its live dependencies.
+type ValidationReport struct {
+	Valid  bool           `json:"valid"`
+	Checks []*CheckResult `json:"checks"`
+}
+
+func (r *ValidationReport) add(name string, err error, repair string) {
+	if err != nil {
+		r.Checks = append(r.Checks, &CheckResult{Name: name, Status: CheckFailed, Detail: err.Error(), Repair: repair})
+		r.Valid = false
+
+		return
+	}
+
+	r.Checks = append(r.Checks, &CheckResult{Name: name, Status: CheckOK})
+}
+
+// Err returns the failed checks, with how to repair them, as one error. It is nil if the configuration is valid.
+func (r *ValidationReport) Err() error {
+	var failed []string
+
+	for _, check := range r.Checks {
+		if check.Status == CheckFailed {
+			failed = append(failed, fmt.Sprintf("%s: %s (%s)", check.Name, check.Detail, check.Repair))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(failed, "; "))
+}
+
+func (o *Operation) validationHandler() common.Handler {
+	return common.NewHTTPHandler(validatePath, http.MethodGet, o.validateHandler, &common.OperationSpec{
+		Summary:   "Validates the configuration of the agent against its live dependencies.",
+		Responses: map[int]interface{}{http.StatusOK: &ValidationReport{}},
+	})
+}
+
+func (o *Operation) validateHandler(w http.ResponseWriter, r *http.Request) {
+	common.WriteResponse(w, logger, o.Validate(r.Context()))
+}
+
+// Validate checks the configuration of the agent against its live dependencies: the OIDC client against the
+// discovery document and the token endpoint of the provider, the hub-auth, KMS and EDV servers against the TLS
+// configuration, client certificate and proxy of the agent, the keys of the session cookies, and the stores with
+// round trips of a record. The agent validates its configuration at startup, so that it fails with actionable
+// errors rather than on the first login of a user.
+func (o *Operation) Validate(ctx context.Context) *ValidationReport {
+	report := &ValidationReport{Valid: true}
+
+	report.add("oidc client", o.oidcClient.Validate(ctx),
+		"check the client id and secret, and the grant types and scopes the OIDC provider allows the client")
+
+	for _, server := range []struct{ name, url string }{
+		{name: "hub-auth", url: o.hubAuthURL},
+		{name: "authz kms", url: o.keyServer.AuthzKMSURL},
+		{name: "ops kms", url: o.keyServer.OpsKMSURL},
+		{name: "key edv", url: o.keyServer.KeyEDVURL},
+		{name: "user edv", url: o.userEDVURL},
+	} {
+		if server.url == "" {
+			report.Checks = append(report.Checks, &CheckResult{Name: server.name, Status: CheckSkipped,
+				Detail: "not configured"})
+
+			continue
+		}
+
+		report.add(server.name, o.validateServer(ctx, server.url),
+			"check the URL, and the TLS configuration, client certificate and proxy of the agent towards "+server.url)
+	}
+
+	report.add("session cookie keys", o.cookieKeys.Validate(),
+		"provide cookie auth keys of 32 or 64 bytes, and cookie enc keys of 16, 24 or 32 bytes")
+	report.add("storage", roundTrip(o.store.validation), "check the URL and the credentials of the database")
+	report.add("transient storage", roundTrip(o.store.transient),
+		"check the URL and the credentials of the database")
+
+	return report
+}
+
+// validateServer probes the health endpoint of the server of the URL. The server must answer, and accept the
+// client certificate of the agent if it requires one.
+func (o *Operation) validateServer(ctx context.Context, serverURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, health.CheckURL(serverURL), nil)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unreachable: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Warnf("failed to close response body: %s", errClose.Error())
+		}
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("rejected the agent with status %d", resp.StatusCode)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("answered with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// roundTrip saves a record to the store, reads it back and deletes it.
+func roundTrip(s storage.Store) error {
+	key := validationKeyPrefix + uuid.New().String()
+	value := []byte(uuid.New().String())
+
+	err := s.Put(key, value)
+	if err != nil {
+		return fmt.Errorf("failed to save record: %w", err)
+	}
+
+	read, err := s.Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to read back record: %w", err)
+	}
+
+	if !bytes.Equal(read, value) {
+		return errors.New("failed to read back record: value changed")
+	}
+
+	err = s.Delete(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete record: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+
+	oidc2 "github.com/trustbloc/edge-agent/pkg/restapi/common/oidc"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestOperation_Validate(t *testing.T) {
+	t.Run("valid configuration", func(t *testing.T) {
+		o := setupValidationTest(t)
+
+		var probed []string
+
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			probed = append(probed, req.URL.String())
+
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}}
+
+		report := o.Validate(context.Background())
+		require.True(t, report.Valid)
+		require.NoError(t, report.Err())
+		require.Len(t, report.Checks, 9)
+		require.Equal(t, []string{
+			"https://hub-auth.example.com/healthcheck",
+			"https://authz-kms.example.com/healthcheck",
+			"https://ops-kms.example.com/healthcheck",
+			"https://key-edv.example.com/healthcheck",
+			"http://example.com/healthcheck",
+		}, probed)
+
+		for _, check := range report.Checks {
+			require.Equal(t, CheckOK, check.Status, check.Name)
+		}
+	})
+
+	t.Run("skips the servers not configured", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}}
+
+		report := o.Validate(context.Background())
+		require.True(t, report.Valid)
+		require.Equal(t, CheckSkipped, check(t, report, "authz kms").Status)
+		require.Equal(t, CheckOK, check(t, report, "user edv").Status)
+	})
+
+	t.Run("reports the failed checks with how to repair them", func(t *testing.T) {
+		config := config(t)
+		config.OIDCClient = &oidc2.MockClient{ValidateErr: oidc2.ErrInvalidClient}
+		config.Keys = &KeyConfig{Auth: key(t)[:16], Enc: key(t)}
+		config.Storage.TransientStorage = &mockstore.Provider{Store: &mockstore.MockStore{
+			Store:  map[string][]byte{},
+			ErrPut: errors.New("test"),
+		}}
+
+		o, err := New(config)
+		require.NoError(t, err)
+
+		o.hubAuthURL = "https://hub-auth.example.com"
+		o.keyServer = &KeyServerConfig{
+			AuthzKMSURL: "https://authz-kms.example.com",
+			OpsKMSURL:   "https://ops-kms.example.com",
+		}
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			switch req.URL.Host {
+			case "hub-auth.example.com":
+				return nil, errors.New("x509: certificate signed by unknown authority")
+			case "authz-kms.example.com":
+				return &http.Response{StatusCode: http.StatusForbidden, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+			default:
+				return &http.Response{StatusCode: http.StatusBadGateway, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+			}
+		}}
+
+		report := o.Validate(context.Background())
+		require.False(t, report.Valid)
+
+		for name, detail := range map[string]string{
+			"oidc client":         "rejected the client id or secret",
+			"hub-auth":            "unreachable: x509",
+			"authz kms":           "rejected the agent with status 403",
+			"ops kms":             "answered with status 502",
+			"user edv":            "answered with status 502",
+			"session cookie keys": "invalid cookie auth key",
+			"transient storage":   "failed to save record",
+		} {
+			require.Equal(t, CheckFailed, check(t, report, name).Status, name)
+			require.Contains(t, check(t, report, name).Detail, detail, name)
+			require.NotEmpty(t, check(t, report, name).Repair, name)
+		}
+
+		require.Equal(t, CheckSkipped, check(t, report, "key edv").Status)
+		require.Equal(t, CheckOK, check(t, report, "storage").Status)
+
+		err = report.Err()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "oidc client: the oidc provider rejected the client id or secret (check")
+		require.Contains(t, err.Error(), "; transient storage: failed to save record")
+	})
+
+	t.Run("validates the rotated session cookie keys", func(t *testing.T) {
+		ring := cookie.NewKeyRing(key(t), key(t))
+
+		config := config(t)
+		config.Keys = &KeyConfig{Ring: ring}
+
+		o, err := New(config)
+		require.NoError(t, err)
+		require.Equal(t, CheckOK, check(t, o.Validate(context.Background()), "session cookie keys").Status)
+
+		ring.Rotate(key(t), key(t)[:8])
+		require.Equal(t, CheckFailed, check(t, o.Validate(context.Background()), "session cookie keys").Status)
+	})
+}
+
+func TestOperation_ValidateHandler(t *testing.T) {
+	o := setupValidationTest(t)
+	o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}}
+
+	w := httptest.NewRecorder()
+	o.validateHandler(w, httptest.NewRequest(http.MethodGet, "/admin/oidc/validate", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	report := &ValidationReport{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(report))
+	require.False(t, report.Valid)
+	require.Equal(t, CheckFailed, check(t, report, "authz kms").Status)
+	require.Equal(t, CheckOK, check(t, report, "storage").Status)
+}
+
+func setupValidationTest(t *testing.T) *Operation {
+	t.Helper()
+
+	config := config(t)
+	config.HubAuthURL = "https://hub-auth.example.com"
+	config.KeyServer = &KeyServerConfig{
+		AuthzKMSURL: "https://authz-kms.example.com/kms",
+		OpsKMSURL:   "https://ops-kms.example.com/kms",
+		KeyEDVURL:   "https://key-edv.example.com/encrypted-data-vaults",
+	}
+
+	o, err := New(config)
+	require.NoError(t, err)
+
+	return o
+}
+
+func check(t *testing.T, report *ValidationReport, name string) *CheckResult {
+	t.Helper()
+
+	for _, c := range report.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	require.Failf(t, "missing check", name)
+
+	return nil
+}
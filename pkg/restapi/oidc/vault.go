@@ -0,0 +1,224 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	cryptoapi "github.com/hyperledger/aries-framework-go/pkg/crypto"
+	webcrypto "github.com/hyperledger/aries-framework-go/pkg/crypto/webkms"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/webkms"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	"github.com/hyperledger/aries-framework-go/pkg/storage/edv"
+	"github.com/hyperledger/aries-framework-go/pkg/storage/formattedstore"
+)
+
+const (
+	credentialsStoreName = "credentials"
+	edvDocumentType      = "EDVEncryptedDocument"
+)
+
+// openedVault is a user's vault kept in the cache, valid for as long as the access token it was opened with.
+type openedVault struct {
+	accessToken string
+	provider    ariesstorage.Provider
+	index       vaultIndex
+}
+
+// vaultOpener opens the user's EDV vault with the keys listed in the bootstrap data.
+type vaultOpener func(data *BootstrapData, h *hubKMSHeader) (ariesstorage.Provider, error)
+
+// SaveCredential stores the credential in the user's EDV vault under the given id.
+// The document is encrypted and indexed with the user's keys in the ops KMS, like the wallet UI does, and the
+// issuer, types and subjects of the credential are added to its encrypted indexes.
+func (o *Operation) SaveCredential(sub, id string, credential []byte) error {
+	credentials, err := o.openCredentialsStore(sub)
+	if err != nil {
+		return err
+	}
+
+	err = credentials.Put(id, credential)
+	if err != nil {
+		return fmt.Errorf("failed to save credential: %w", err)
+	}
+
+	return o.indexCredential(sub, id, credential)
+}
+
+// Credentials returns the credentials in the user's EDV vault, by id.
+func (o *Operation) Credentials(sub string) (map[string][]byte, error) {
+	credentials, err := o.openCredentialsStore(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the EDV store ignores the key range and returns every document of the store
+	iter := credentials.Iterator("", ariesstorage.EndKeySuffix)
+	defer iter.Release()
+
+	all := make(map[string][]byte)
+
+	for iter.Next() {
+		all[string(iter.Key())] = append([]byte(nil), iter.Value()...)
+	}
+
+	if err = iter.Error(); err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	return all, nil
+}
+
+func (o *Operation) openCredentialsStore(sub string) (ariesstorage.Store, error) {
+	return o.openVaultStore(sub, credentialsStoreName)
+}
+
+func (o *Operation) openVaultStore(sub, name string) (ariesstorage.Store, error) {
+	tokns, err := o.store.tokens.Get(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user tokens: %w", err)
+	}
+
+	vault, err := o.userVault(sub, tokns.Access)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := vault.OpenStore(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s store: %w", name, err)
+	}
+
+	return &quotaStore{Store: store, sub: sub, usage: o.usage}, nil
+}
+
+// userVault returns the user's vault from the cache, or opens it with the keys of the user's bootstrap data
+// when the user has not used it since their last login.
+func (o *Operation) userVault(sub, accessToken string) (ariesstorage.Provider, error) {
+	cached := o.cachedVault(sub, accessToken)
+	if cached.provider != nil {
+		return cached.provider, nil
+	}
+
+	data, h, err := o.vaultKeys(sub, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	vault, err := o.openVault(data, h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user vault: %w", err)
+	}
+
+	o.vaults.Add(sub, &openedVault{accessToken: accessToken, provider: vault, index: cached.index})
+
+	return vault, nil
+}
+
+// cachedVault returns what the user has opened of their vault with the access token, if anything.
+func (o *Operation) cachedVault(sub, accessToken string) *openedVault {
+	if cached, found := o.vaults.Get(sub); found {
+		if v, ok := cached.(*openedVault); ok && v.accessToken == accessToken {
+			return v
+		}
+	}
+
+	return &openedVault{accessToken: accessToken}
+}
+
+// vaultKeys returns the bootstrap data listing the keys of the user's vault, and the headers to use them with.
+func (o *Operation) vaultKeys(sub, accessToken string) (*BootstrapData, *hubKMSHeader, error) {
+	usr, err := o.store.users.Get(sub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch user data: %w", err)
+	}
+
+	userID := o.pseudonyms.ID(sub)
+
+	data, err := o.keystores(context.TODO(), userID, accessToken)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch bootstrap data: %w", err)
+	}
+
+	if data == nil || data.UserEDVVaultURL == "" {
+		return nil, nil, errors.New("user has no edv vault")
+	}
+
+	return data, &hubKMSHeader{
+		userSub:     userID,
+		secretShare: usr.SecretShare,
+		accessToken: accessToken,
+	}, nil
+}
+
+func (o *Operation) openUserVault(data *BootstrapData, h *hubKMSHeader) (ariesstorage.Provider, error) {
+	kmsHeaders := opsKMSHeaders(h)
+	kmsClient := o.kmsClient(h)
+
+	keyManager := webkms.New(data.OpsKeyStoreURL, kmsClient, kmsHeaders)
+	crypto := webcrypto.New(data.OpsKeyStoreURL, kmsClient, kmsHeaders)
+
+	keyID := lastPathSegment(data.EDVOpsKIDURL)
+
+	pubKeyBytes, err := keyManager.ExportPubKeyBytes(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("export edv operational key : %w", err)
+	}
+
+	pubKey := &cryptoapi.PublicKey{}
+
+	err = json.Unmarshal(pubKeyBytes, pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal edv operational key : %w", err)
+	}
+
+	pubKey.KID = keyID
+
+	encrypter, err := jose.NewJWEEncrypt(jose.A256GCM, edvDocumentType, "", nil,
+		[]*cryptoapi.PublicKey{pubKey}, crypto)
+	if err != nil {
+		return nil, fmt.Errorf("create jwe encrypter : %w", err)
+	}
+
+	// the EDV REST API addresses documents as <server>/<vault id>/documents
+	vaultURL := data.UserEDVVaultURL[:strings.LastIndex(data.UserEDVVaultURL, "/")]
+
+	restProvider, err := edv.NewRESTProvider(vaultURL, getVaultID(data.UserEDVVaultURL),
+		edv.NewMACCrypto(data.EDVHMACKIDURL, crypto),
+		// the requests of the aries provider connect directly, as it cannot be given a proxy
+		edv.WithTLSConfig(o.tlsConfig),
+		edv.WithHeaders(func(req *http.Request) (*http.Header, error) {
+			req.Header.Set("Authorization", "Bearer "+h.accessToken)
+
+			return &req.Header, nil
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("create edv provider : %w", err)
+	}
+
+	return formattedstore.NewFormattedProvider(restProvider,
+		edv.NewEncryptedFormatter(encrypter, jose.NewJWEDecrypt(nil, crypto, keyManager)), true), nil
+}
+
+// opsKMSHeaders authorizes the requests to the user's ops keystore.
+func opsKMSHeaders(h *hubKMSHeader) webkms.HeadersOpt {
+	return webkms.WithHeaders(func(req *http.Request) (*http.Header, error) {
+		addAuthZKMSHeaders(req, h)
+
+		return &req.Header, nil
+	})
+}
+
+func lastPathSegment(u string) string {
+	return u[strings.LastIndex(u, "/")+1:]
+}
@@ -0,0 +1,316 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package oidc // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	cryptoapi "github.com/hyperledger/aries-framework-go/pkg/crypto"
+	mockstorage "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	ariesstorage "github.com/hyperledger/aries-framework-go/pkg/storage"
+	ariesmem "github.com/hyperledger/aries-framework-go/pkg/storage/mem"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/tokens"
+)
+
+func TestOperation_SaveCredential(t *testing.T) {
+	t.Run("saves the credential to the user vault", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+
+		vault := ariesmem.NewProvider()
+
+		o.openVault = func(data *BootstrapData, h *hubKMSHeader) (ariesstorage.Provider, error) {
+			require.Equal(t, "https://edv.example.com/encrypted-data-vaults/123", data.UserEDVVaultURL)
+			require.Equal(t, sub, h.userSub)
+			require.Equal(t, "share", h.secretShare)
+			require.Equal(t, "token", h.accessToken)
+
+			return vault, nil
+		}
+
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte(`{"id": "credential"}`)))
+
+		credentials, err := vault.OpenStore(credentialsStoreName)
+		require.NoError(t, err)
+
+		saved, err := credentials.Get("urn:uuid:1")
+		require.NoError(t, err)
+		require.JSONEq(t, `{"id": "credential"}`, string(saved))
+	})
+
+	t.Run("error if user data is missing", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		err = o.SaveCredential(uuid.New().String(), "urn:uuid:1", []byte("{}"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch user tokens")
+
+		sub := uuid.New().String()
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: "token"}))
+
+		err = o.SaveCredential(sub, "urn:uuid:1", []byte("{}"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch user data")
+	})
+
+	t.Run("error if bootstrap data cannot be fetched", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("test")
+		}}
+
+		err := o.SaveCredential(sub, "urn:uuid:1", []byte("{}"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to fetch bootstrap data")
+	})
+
+	t.Run("error if user has no vault", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "")
+
+		err := o.SaveCredential(sub, "urn:uuid:1", []byte("{}"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "user has no edv vault")
+	})
+
+	t.Run("error if vault fails", func(t *testing.T) {
+		for _, test := range []struct {
+			vault ariesstorage.Provider
+			err   error
+			msg   string
+		}{
+			{err: errors.New("test"), msg: "failed to open user vault"},
+			{
+				vault: &mockstorage.MockStoreProvider{ErrOpenStoreHandle: errors.New("test")},
+				msg:   "failed to open credentials store",
+			},
+			{vault: &mockstorage.MockStoreProvider{Store: &mockstorage.MockStore{
+				Store: map[string][]byte{}, ErrPut: errors.New("test"),
+			}}, msg: "failed to save credential"},
+		} {
+			o, sub := setupCheckTest(t)
+			o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+
+			vault, vaultErr := test.vault, test.err
+			o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+				return vault, vaultErr
+			}
+
+			err := o.SaveCredential(sub, "urn:uuid:1", []byte("{}"))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.msg)
+		}
+	})
+}
+
+func TestOperation_Credentials(t *testing.T) {
+	t.Run("returns the credentials in the user vault", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+
+		vault := ariesmem.NewProvider()
+
+		o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+			return vault, nil
+		}
+
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte(`{"id": "first"}`)))
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:2", []byte(`{"id": "second"}`)))
+
+		credentials, err := o.Credentials(sub)
+		require.NoError(t, err)
+		require.Len(t, credentials, 2)
+		require.JSONEq(t, `{"id": "first"}`, string(credentials["urn:uuid:1"]))
+		require.JSONEq(t, `{"id": "second"}`, string(credentials["urn:uuid:2"]))
+	})
+
+	t.Run("error if user has no vault", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "")
+
+		_, err := o.Credentials(sub)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "user has no edv vault")
+	})
+
+	t.Run("error if credentials cannot be read", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+
+		o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+			return &mockstorage.MockStoreProvider{Store: &mockstorage.MockStore{
+				Store: map[string][]byte{}, ErrItr: errors.New("test"),
+			}}, nil
+		}
+
+		_, err := o.Credentials(sub)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to read credentials")
+	})
+}
+
+func TestOperation_UserVault(t *testing.T) {
+	t.Run("reuses the opened vault until the access token changes", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+
+		opened := 0
+
+		o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+			opened++
+
+			return ariesmem.NewProvider(), nil
+		}
+
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte("{}")))
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:2", []byte("{}")))
+
+		credentials, err := o.Credentials(sub)
+		require.NoError(t, err)
+		require.Len(t, credentials, 2)
+		require.Equal(t, 1, opened)
+
+		require.NoError(t, o.store.tokens.Save(&tokens.UserTokens{UserSub: sub, Access: "refreshed"}))
+
+		credentials, err = o.Credentials(sub)
+		require.NoError(t, err)
+		require.Empty(t, credentials)
+		require.Equal(t, 2, opened)
+	})
+
+	t.Run("opens the vault again after logout", func(t *testing.T) {
+		o, sub := setupCheckTest(t)
+		o.httpClient = mockVaultBootstrapHTTPClient(t, "https://edv.example.com/encrypted-data-vaults/123")
+		o.store.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: sub},
+		}}
+
+		opened := 0
+
+		o.openVault = func(*BootstrapData, *hubKMSHeader) (ariesstorage.Provider, error) {
+			opened++
+
+			return ariesmem.NewProvider(), nil
+		}
+
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte("{}")))
+		require.Equal(t, 1, o.vaults.Len())
+
+		o.userLogoutHandler(httptest.NewRecorder(), newUserLogoutRequest())
+		require.Equal(t, 0, o.vaults.Len())
+
+		require.NoError(t, o.SaveCredential(sub, "urn:uuid:1", []byte("{}")))
+		require.Equal(t, 2, opened)
+	})
+}
+
+func TestOperation_OpenUserVault(t *testing.T) {
+	t.Run("opens the vault with the user's ops keys", func(t *testing.T) {
+		kms := mockOpsKMS(t, marshal(t, edvOpsKey))
+		defer kms.Close()
+
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		vault, err := o.openUserVault(vaultBootstrapData(kms.URL), &hubKMSHeader{userSub: "sub", accessToken: "token"})
+		require.NoError(t, err)
+
+		_, err = vault.OpenStore(credentialsStoreName)
+		require.NoError(t, err)
+	})
+
+	t.Run("error if edv key cannot be exported", func(t *testing.T) {
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		_, err = o.openUserVault(vaultBootstrapData("http://localhost:-1"), &hubKMSHeader{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "export edv operational key")
+	})
+
+	t.Run("error if edv key is invalid", func(t *testing.T) {
+		kms := mockOpsKMS(t, []byte("invalid"))
+		defer kms.Close()
+
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		_, err = o.openUserVault(vaultBootstrapData(kms.URL), &hubKMSHeader{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unmarshal edv operational key")
+	})
+
+	t.Run("error if index mac cannot be computed", func(t *testing.T) {
+		kms := mockOpsKMS(t, marshal(t, edvOpsKey))
+		defer kms.Close()
+
+		o, err := New(config(t))
+		require.NoError(t, err)
+
+		data := vaultBootstrapData(kms.URL)
+		data.EDVHMACKIDURL = "http://localhost:-1/kms/keystores/456/keys/hmac"
+
+		_, err = o.openUserVault(data, &hubKMSHeader{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "create edv provider")
+	})
+}
+
+var edvOpsKey = &cryptoapi.PublicKey{Curve: "P-256", Type: "EC", X: []byte("x"), Y: []byte("y")}
+
+func vaultBootstrapData(kmsURL string) *BootstrapData {
+	return &BootstrapData{
+		UserEDVVaultURL: "https://edv.example.com/encrypted-data-vaults/123",
+		OpsKeyStoreURL:  kmsURL + "/kms/keystores/456",
+		EDVOpsKIDURL:    kmsURL + "/kms/keystores/456/keys/ops",
+		EDVHMACKIDURL:   kmsURL + "/kms/keystores/456/keys/hmac",
+	}
+}
+
+// mockOpsKMS exports 'pubKey' as the EDV operational key and computes MACs with the EDV HMAC key.
+func mockOpsKMS(t *testing.T, pubKey []byte) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/keys/ops/export"):
+			body = []byte(`{"publicKey": "` + base64.URLEncoding.EncodeToString(pubKey) + `"}`)
+		case strings.HasSuffix(r.URL.Path, "/keys/hmac/computemac"):
+			body = []byte(`{"mac": "` + base64.URLEncoding.EncodeToString([]byte("mac")) + `"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_, err := w.Write(body)
+		require.NoError(t, err)
+	}))
+}
+
+func mockVaultBootstrapHTTPClient(t *testing.T, vaultURL string) *mockHTTPClient {
+	t.Helper()
+
+	data := marshal(t, &userBootstrapData{Data: &BootstrapData{UserEDVVaultURL: vaultURL}})
+
+	return &mockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+	}}
+}
@@ -0,0 +1,432 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/push"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Endpoints.
+const (
+	devicesPath = "/push/devices"
+	configPath  = "/push/config"
+)
+
+const (
+	userSubCookieName = "user_sub"
+	defaultTimeout    = 10 * time.Second
+	// the number of events remembered to push each event once, as the bus may dispatch an event more than once.
+	recentEvents = 1024
+)
+
+var logger = log.New("edge-agent/push")
+
+// Subscriber subscribes to the events of the bus.
+type Subscriber interface {
+	Subscribe(handler events.Handler, topics ...string) func()
+}
+
+// Presence tells whether a user is online, e.g. has the wallet open.
+type Presence interface {
+	Online(sub string) bool
+}
+
+// Alert is the notification shown for the events of a topic.
+type Alert struct {
+	Title string
+	Body  string
+}
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Events  Subscriber
+	Storage storage.Provider
+	Keys    *KeyConfig
+	// Providers sends the notifications by platform, see push.PlatformFCM, push.PlatformAPNs and
+	// push.PlatformWebPush. The devices of the other platforms cannot be registered.
+	Providers map[string]push.Provider
+	// VAPIDPublicKey is the key the browsers subscribe with, if the WebPush platform is enabled.
+	VAPIDPublicKey string
+	// Alerts are the notifications pushed by topic. The events of the other topics are not pushed.
+	Alerts map[string]*Alert
+	// Presence tells which users are online: they are notified by the wallet UI and not pushed. Every user is
+	// pushed if nil.
+	Presence Presence
+	// Timeout of the delivery of an event to the devices of a user. Defaults to 10s.
+	Timeout time.Duration
+}
+
+// KeyConfig holds configuration for cryptographic keys.
+type KeyConfig struct {
+	Auth []byte
+	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
+}
+
+// RegisterDeviceRequest is the body of a register device request. FCM and APNs devices are registered with their
+// token, WebPush devices with their push subscription.
+type RegisterDeviceRequest struct {
+	Platform     string             `json:"platform"`
+	Token        string             `json:"token,omitempty"`
+	Subscription *push.Subscription `json:"subscription,omitempty"`
+}
+
+// ConfigResponse tells the devices how to register.
+type ConfigResponse struct {
+	Platforms      []string `json:"platforms"`
+	VAPIDPublicKey string   `json:"vapidPublicKey,omitempty"`
+}
+
+// Operation pushes the events of the users to their devices while they are offline.
+type Operation struct {
+	devices     *push.DeviceStore
+	cookies     cookie.Store
+	providers   map[string]push.Provider
+	vapidKey    string
+	alerts      map[string]*Alert
+	presence    Presence
+	timeout     time.Duration
+	unsubscribe func()
+	recentMutex sync.Mutex
+	recent      map[string]bool
+	recentOrder []string
+}
+
+// New returns a new Operation subscribed to the events of the alerts.
+func New(config *Config) (*Operation, error) {
+	if config.Events == nil {
+		return nil, errors.New("missing event bus")
+	}
+
+	if len(config.Providers) == 0 {
+		return nil, errors.New("missing push providers")
+	}
+
+	devices, err := push.NewDeviceStore(config.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init device store: %w", err)
+	}
+
+	o := &Operation{
+		devices:   devices,
+		cookies:   cookie.NewStore(config.Keys.Auth, config.Keys.Enc, cookie.WithKeyRing(config.Keys.Ring)),
+		providers: config.Providers,
+		vapidKey:  config.VAPIDPublicKey,
+		alerts:    config.Alerts,
+		presence:  config.Presence,
+		timeout:   config.Timeout,
+		recent:    make(map[string]bool),
+	}
+
+	if o.timeout <= 0 {
+		o.timeout = defaultTimeout
+	}
+
+	topics := make([]string, 0, len(config.Alerts))
+
+	for topic := range config.Alerts {
+		topics = append(topics, topic)
+	}
+
+	if len(topics) > 0 {
+		o.unsubscribe = config.Events.Subscribe(o.receive, topics...)
+	}
+
+	return o, nil
+}
+
+// Close stops pushing the events.
+func (o *Operation) Close() {
+	if o.unsubscribe != nil {
+		o.unsubscribe()
+	}
+}
+
+// GetRESTHandlers get all controller API handler available for this service.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(configPath, http.MethodGet, o.configHandler, &common.OperationSpec{
+			Summary:   "Returns the platforms devices can register for, and the VAPID key of the browsers.",
+			Responses: map[int]interface{}{http.StatusOK: &ConfigResponse{}},
+		}),
+		common.NewHTTPHandler(devicesPath, http.MethodPost, o.registerHandler, &common.OperationSpec{
+			Summary:   "Registers a device of the user logged in for push notifications.",
+			Request:   &RegisterDeviceRequest{},
+			Responses: map[int]interface{}{http.StatusCreated: &push.Device{}},
+		}),
+		common.NewHTTPHandler(devicesPath, http.MethodGet, o.listHandler, &common.OperationSpec{
+			Summary:   "Lists the devices of the user logged in.",
+			Responses: map[int]interface{}{http.StatusOK: []*push.Device{}},
+		}),
+		common.NewHTTPHandler(devicesPath, http.MethodDelete, o.deleteHandler, &common.OperationSpec{
+			Summary:   "Unregisters a device of the user logged in.",
+			Params:    []common.Param{{Name: "id", In: common.InQuery, Description: "Id of the device.", Required: true}},
+			Responses: map[int]interface{}{http.StatusNoContent: nil},
+		}),
+	}
+}
+
+// receive pushes the event to the devices of its user in the background, unless the user is online.
+func (o *Operation) receive(e *outbox.Event) {
+	alert, ok := o.alerts[e.Topic]
+	if !ok || e.Subject == "" {
+		return
+	}
+
+	if o.presence != nil && o.presence.Online(e.Subject) {
+		return
+	}
+
+	if !o.firstSeen(e.ID) {
+		return
+	}
+
+	go o.deliver(e, &push.Notification{
+		Title: alert.Title,
+		Body:  alert.Body,
+		Data:  map[string]string{"id": e.ID, "topic": e.Topic},
+	})
+}
+
+// deliver sends the notification to every device of the user, and unregisters the devices the push services no
+// longer know.
+func (o *Operation) deliver(e *outbox.Event, n *push.Notification) {
+	devices, err := o.devices.List(e.Subject)
+	if err != nil {
+		logger.Warnf("failed to push event %s: %s", e.ID, err.Error())
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	for _, d := range devices {
+		provider, ok := o.providers[d.Platform]
+		if !ok {
+			continue
+		}
+
+		err = provider.Send(ctx, d, n)
+		if errors.Is(err, push.ErrUnregistered) {
+			logger.Infof("unregistering device %s: %s", d.ID, err.Error())
+
+			err = o.devices.Delete(e.Subject, d.ID)
+		}
+
+		if err != nil {
+			logger.Warnf("failed to push event %s to device %s: %s", e.ID, d.ID, err.Error())
+		}
+	}
+}
+
+// firstSeen tells whether the event is seen for the first time.
+func (o *Operation) firstSeen(id string) bool {
+	o.recentMutex.Lock()
+	defer o.recentMutex.Unlock()
+
+	if o.recent[id] {
+		return false
+	}
+
+	o.recent[id] = true
+	o.recentOrder = append(o.recentOrder, id)
+
+	if len(o.recentOrder) > recentEvents {
+		delete(o.recent, o.recentOrder[0])
+		o.recentOrder = o.recentOrder[1:]
+	}
+
+	return true
+}
+
+func (o *Operation) configHandler(w http.ResponseWriter, _ *http.Request) {
+	platforms := make([]string, 0, len(o.providers))
+
+	for platform := range o.providers {
+		platforms = append(platforms, platform)
+	}
+
+	sort.Strings(platforms)
+
+	response := &ConfigResponse{Platforms: platforms}
+
+	if _, ok := o.providers[push.PlatformWebPush]; ok {
+		response.VAPIDPublicKey = o.vapidKey
+	}
+
+	common.WriteResponse(w, logger, response)
+}
+
+func (o *Operation) registerHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling register device request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	request := &RegisterDeviceRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	err = o.validate(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid device: %s", err.Error())
+
+		return
+	}
+
+	device := &push.Device{
+		ID:         uuid.New().String(),
+		Platform:   request.Platform,
+		Token:      request.Token,
+		Registered: time.Now().UTC(),
+	}
+
+	if request.Platform == push.PlatformWebPush {
+		device.Token = ""
+		device.Subscription = request.Subscription
+	}
+
+	err = o.devices.Save(sub, device)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	common.WriteResponse(w, logger, device)
+}
+
+func (o *Operation) validate(request *RegisterDeviceRequest) error {
+	if _, ok := o.providers[request.Platform]; !ok {
+		return fmt.Errorf("unsupported platform '%s'", request.Platform)
+	}
+
+	if request.Platform != push.PlatformWebPush {
+		if request.Token == "" {
+			return errors.New("missing token")
+		}
+
+		return nil
+	}
+
+	s := request.Subscription
+	if s == nil || s.Keys.P256DH == "" || s.Keys.Auth == "" {
+		return errors.New("missing push subscription or keys")
+	}
+
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return errors.New("push subscription endpoint must be an https URL")
+	}
+
+	return nil
+}
+
+func (o *Operation) listHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling list devices request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	devices, err := o.devices.List(sub)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	if devices == nil {
+		devices = []*push.Device{}
+	}
+
+	common.WriteResponse(w, logger, devices)
+}
+
+func (o *Operation) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling delete device request")
+
+	sub, ok := o.userSub(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing id parameter")
+
+		return
+	}
+
+	err := o.devices.Delete(sub, id)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "device %s not found", id)
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Operation) userSub(w http.ResponseWriter, r *http.Request) (string, bool) {
+	jar, err := o.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return "", false
+	}
+
+	userSubCookie, found := jar.Get(userSubCookieName)
+	if !found {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return "", false
+	}
+
+	sub, ok := userSubCookie.(string)
+	if !ok {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "invalid user sub cookie format")
+
+		return "", false
+	}
+
+	return sub, true
+}
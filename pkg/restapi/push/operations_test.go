@@ -0,0 +1,420 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package push // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/push"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("returns an instance", func(t *testing.T) {
+		o, err := New(config(&mockProvider{}))
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 4)
+	})
+
+	t.Run("error if event bus is missing", func(t *testing.T) {
+		c := config(&mockProvider{})
+		c.Events = nil
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing event bus")
+	})
+
+	t.Run("error if providers are missing", func(t *testing.T) {
+		c := config(&mockProvider{})
+		c.Providers = nil
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing push providers")
+	})
+
+	t.Run("error if store cannot be opened", func(t *testing.T) {
+		c := config(&mockProvider{})
+		c.Storage = &mockstore.Provider{ErrOpenStoreHandle: errors.New("test")}
+
+		_, err := New(c)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to init device store")
+	})
+}
+
+func TestOperation_Receive(t *testing.T) {
+	t.Run("pushes the events of the offline users to their devices", func(t *testing.T) {
+		bus := events.NewBus(&events.BusConfig{})
+		provider := &mockProvider{}
+		presence := &mockPresence{online: map[string]bool{"online": true}}
+
+		c := config(provider)
+		c.Events = bus
+		c.Presence = presence
+
+		o := newOperation(t, c, "sub")
+		require.Equal(t, http.StatusCreated, register(t, o, fcmRequest("token-1")).Code)
+		require.Equal(t, http.StatusCreated, register(t, o, fcmRequest("token-2")).Code)
+		require.NoError(t, o.devices.Save("online", &push.Device{ID: "3", Platform: push.PlatformFCM, Token: "token-3"}))
+
+		expected := newEvent(t, "didcomm.message", "sub")
+		require.NoError(t, bus.Dispatch(expected))
+		require.NoError(t, bus.Dispatch(expected))
+		require.NoError(t, bus.Dispatch(newEvent(t, "user.login", "sub")))
+		require.NoError(t, bus.Dispatch(newEvent(t, "didcomm.message", "")))
+		require.NoError(t, bus.Dispatch(newEvent(t, "didcomm.message", "online")))
+
+		require.Eventually(t, func() bool { return len(provider.sent()) == 2 }, time.Second, 10*time.Millisecond)
+
+		sent := provider.sent()
+		require.ElementsMatch(t, []string{"token-1", "token-2"}, []string{sent[0].device.Token, sent[1].device.Token})
+		require.Equal(t, "New message", sent[0].notification.Title)
+		require.Equal(t, map[string]string{"id": expected.ID, "topic": "didcomm.message"}, sent[0].notification.Data)
+
+		o.Close()
+
+		require.NoError(t, bus.Dispatch(newEvent(t, "didcomm.message", "sub")))
+		time.Sleep(50 * time.Millisecond)
+		require.Len(t, provider.sent(), 2)
+	})
+
+	t.Run("unregisters the devices unknown to the push services", func(t *testing.T) {
+		provider := &mockProvider{err: map[string]error{
+			"token-1": fmt.Errorf("FCM: %w", push.ErrUnregistered),
+			"token-2": errors.New("test"),
+		}}
+
+		o := newOperation(t, config(provider), "sub")
+		require.Equal(t, http.StatusCreated, register(t, o, fcmRequest("token-1")).Code)
+		require.Equal(t, http.StatusCreated, register(t, o, fcmRequest("token-2")).Code)
+
+		o.receive(newEvent(t, "didcomm.message", "sub"))
+
+		require.Eventually(t, func() bool { return len(list(t, o)) == 1 }, time.Second, 10*time.Millisecond)
+		require.Equal(t, "token-2", list(t, o)[0].Token)
+	})
+
+	t.Run("skips the devices of disabled platforms", func(t *testing.T) {
+		provider := &mockProvider{}
+
+		o := newOperation(t, config(provider), "sub")
+		require.NoError(t, o.devices.Save("sub", &push.Device{ID: "1", Platform: push.PlatformAPNs, Token: "token-1"}))
+		require.Equal(t, http.StatusCreated, register(t, o, fcmRequest("token-2")).Code)
+
+		o.receive(newEvent(t, "didcomm.message", "sub"))
+
+		require.Eventually(t, func() bool { return len(provider.sent()) == 1 }, time.Second, 10*time.Millisecond)
+		require.Equal(t, "token-2", provider.sent()[0].device.Token)
+	})
+}
+
+func TestOperation_ConfigHandler(t *testing.T) {
+	c := config(&mockProvider{})
+	c.Providers[push.PlatformWebPush] = &mockProvider{}
+	c.VAPIDPublicKey = "vapid"
+
+	o := newOperation(t, c, "sub")
+
+	w := httptest.NewRecorder()
+	o.configHandler(w, httptest.NewRequest(http.MethodGet, configPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	response := &ConfigResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), response))
+	require.Equal(t, []string{push.PlatformFCM, push.PlatformWebPush}, response.Platforms)
+	require.Equal(t, "vapid", response.VAPIDPublicKey)
+}
+
+func TestOperation_RegisterHandler(t *testing.T) {
+	t.Run("registers devices", func(t *testing.T) {
+		c := config(&mockProvider{})
+		c.Providers[push.PlatformWebPush] = &mockProvider{}
+
+		o := newOperation(t, c, "sub")
+
+		w := register(t, o, fcmRequest("token-1"))
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		device := &push.Device{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), device))
+		require.NotEmpty(t, device.ID)
+		require.Equal(t, "token-1", device.Token)
+
+		w = register(t, o, webPushRequest("https://push.example.com/1"))
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		devices := list(t, o)
+		require.Len(t, devices, 2)
+		require.Equal(t, device.ID, devices[0].ID)
+		require.Equal(t, "https://push.example.com/1", devices[1].Subscription.Endpoint)
+		require.Empty(t, devices[1].Token)
+	})
+
+	t.Run("err badrequest if request is invalid", func(t *testing.T) {
+		c := config(&mockProvider{})
+		c.Providers[push.PlatformWebPush] = &mockProvider{}
+
+		o := newOperation(t, c, "sub")
+
+		w := httptest.NewRecorder()
+		o.registerHandler(w, httptest.NewRequest(http.MethodPost, devicesPath, bytes.NewBufferString("{")))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "failed to decode request")
+
+		noKeys := &RegisterDeviceRequest{
+			Platform:     push.PlatformWebPush,
+			Subscription: &push.Subscription{Endpoint: "https://push.example.com/1"},
+		}
+
+		for request, msg := range map[*RegisterDeviceRequest]string{
+			{Platform: push.PlatformAPNs, Token: "token"}: "unsupported platform 'apns'",
+			{Platform: push.PlatformFCM}:                  "missing token",
+			{Platform: push.PlatformWebPush}:              "missing push subscription or keys",
+			noKeys:                                        "missing push subscription or keys",
+			webPushRequest("http://push.example.com/1"):   "must be an https URL",
+		} {
+			w = register(t, o, request)
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), msg)
+		}
+	})
+
+	t.Run("err internalservererror if devices cannot be saved", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "sub")
+		o.devices = failingStore(t)
+
+		w := register(t, o, fcmRequest("token-1"))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch devices")
+	})
+
+	t.Run("err badrequest if cookies cannot be opened", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "")
+		o.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		w := register(t, o, fcmRequest("token-1"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "cannot open cookies")
+	})
+}
+
+func TestOperation_ListHandler(t *testing.T) {
+	t.Run("lists no devices", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "sub")
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, devicesPath, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, "[]", w.Body.String())
+	})
+
+	t.Run("err internalservererror if devices cannot be fetched", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "sub")
+		o.devices = failingStore(t)
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, devicesPath, nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch devices")
+	})
+
+	t.Run("err forbidden if user is not logged in", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "")
+		o.cookies = &cookie.MockStore{}
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, devicesPath, nil))
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Contains(t, w.Body.String(), "not logged in")
+	})
+}
+
+func TestOperation_DeleteHandler(t *testing.T) {
+	t.Run("deletes a device", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "sub")
+
+		device := &push.Device{}
+		require.NoError(t, json.Unmarshal(register(t, o, fcmRequest("token-1")).Body.Bytes(), device))
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, devicesPath+"?id="+device.ID, nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+		require.Empty(t, list(t, o))
+
+		w = httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, devicesPath+"?id="+device.ID, nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "not found")
+	})
+
+	t.Run("err badrequest if id is missing", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "sub")
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, devicesPath, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing id parameter")
+	})
+
+	t.Run("err internalservererror if devices cannot be fetched", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "sub")
+		o.devices = failingStore(t)
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, devicesPath+"?id=id", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to fetch devices")
+	})
+
+	t.Run("err internalservererror if user sub cookie is invalid", func(t *testing.T) {
+		o := newOperation(t, config(&mockProvider{}), "")
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+			Cookies: map[interface{}]interface{}{userSubCookieName: 1},
+		}}
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, devicesPath+"?id=id", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "invalid user sub cookie format")
+	})
+}
+
+func config(fcm push.Provider) *Config {
+	return &Config{
+		Events:  events.NewBus(&events.BusConfig{}),
+		Storage: memstore.NewProvider(),
+		Keys: &KeyConfig{
+			Auth: []byte(uuid.New().String()),
+			Enc:  []byte(uuid.New().String())[:32],
+		},
+		Providers: map[string]push.Provider{push.PlatformFCM: fcm},
+		Alerts:    map[string]*Alert{"didcomm.message": {Title: "New message", Body: "You received a new message."}},
+	}
+}
+
+func newOperation(t *testing.T, c *Config, sub string) *Operation {
+	t.Helper()
+
+	o, err := New(c)
+	require.NoError(t, err)
+
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+		Cookies: map[interface{}]interface{}{userSubCookieName: sub},
+	}}
+
+	return o
+}
+
+func newEvent(t *testing.T, topic, sub string) *outbox.Event {
+	t.Helper()
+
+	e, err := outbox.NewEvent(topic, sub, map[string]string{"test": "value"})
+	require.NoError(t, err)
+
+	return e
+}
+
+func failingStore(t *testing.T) *push.DeviceStore {
+	t.Helper()
+
+	s, err := push.NewDeviceStore(&mockstore.Provider{Store: &mockstore.MockStore{
+		Store:  map[string][]byte{"sub": []byte(`{"devices":[]}`)},
+		ErrGet: errors.New("test"),
+	}})
+	require.NoError(t, err)
+
+	return s
+}
+
+func fcmRequest(token string) *RegisterDeviceRequest {
+	return &RegisterDeviceRequest{Platform: push.PlatformFCM, Token: token}
+}
+
+func webPushRequest(endpoint string) *RegisterDeviceRequest {
+	return &RegisterDeviceRequest{Platform: push.PlatformWebPush, Subscription: &push.Subscription{
+		Endpoint: endpoint,
+		Keys:     push.SubscriptionKeys{P256DH: "key", Auth: "secret"},
+	}}
+}
+
+func register(t *testing.T, o *Operation, request *RegisterDeviceRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	o.registerHandler(w, httptest.NewRequest(http.MethodPost, devicesPath, bytes.NewBuffer(body)))
+
+	return w
+}
+
+func list(t *testing.T, o *Operation) []*push.Device {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.listHandler(w, httptest.NewRequest(http.MethodGet, devicesPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var devices []*push.Device
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &devices))
+
+	return devices
+}
+
+type sentNotification struct {
+	device       *push.Device
+	notification *push.Notification
+}
+
+type mockProvider struct {
+	mutex         sync.Mutex
+	notifications []*sentNotification
+	// the errors by device token
+	err map[string]error
+}
+
+func (m *mockProvider) Send(_ context.Context, device *push.Device, n *push.Notification) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.notifications = append(m.notifications, &sentNotification{device: device, notification: n})
+
+	return m.err[device.Token]
+}
+
+func (m *mockProvider) sent() []*sentNotification {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]*sentNotification(nil), m.notifications...)
+}
+
+type mockPresence struct {
+	online map[string]bool
+}
+
+func (m *mockPresence) Online(sub string) bool {
+	return m.online[sub]
+}
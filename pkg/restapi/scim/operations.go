@@ -0,0 +1,438 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// SCIM endpoints, under the base URL of the SCIM service provider, e.g. /scim/v2.
+const (
+	usersPath = "/Users"
+	userPath  = usersPath + "/{id}"
+)
+
+// SCIM schemas (RFC 7643 and RFC 7644).
+const (
+	userSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	listResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	errorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+const contentType = "application/scim+json"
+
+var logger = log.New("edge-agent/scim")
+
+// userNameFilter is the only filter supported, the one the IdPs look their users up with ahead of provisioning them.
+var userNameFilter = regexp.MustCompile(`^userName eq "((?:[^"\\]|\\.)*)"$`)
+
+// Backend deactivates and deprovisions the users of the agent, e.g. the oidc Operation.
+type Backend interface {
+	User(sub string) (*user.User, error)
+	DeactivateUser(sub string) error
+	ActivateUser(sub string) error
+	DeprovisionUser(ctx context.Context, sub string) (*oidc.AccountDeletion, error)
+}
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Users Backend
+}
+
+// User is the SCIM resource of a user of the agent, whose id and userName are the sub of the user.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Name        *Name    `json:"name,omitempty"`
+	Emails      []*Email `json:"emails,omitempty"`
+	Active      bool     `json:"active"`
+	Meta        *Meta    `json:"meta"`
+}
+
+// Name is the name of a SCIM user.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Email is an email address of a SCIM user.
+type Email struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary"`
+}
+
+// Meta is the metadata of a SCIM resource.
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+}
+
+// ListResponse is the response of a query of the SCIM users.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	StartIndex   int      `json:"startIndex"`
+	Resources    []*User  `json:"Resources"`
+}
+
+// PatchRequest modifies a SCIM user. Only the replacement of the active attribute is supported.
+type PatchRequest struct {
+	Schemas    []string          `json:"schemas"`
+	Operations []*PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is an operation of a PatchRequest, either with the active path and a boolean value, or without
+// path and a value holding the active attribute.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ReplaceRequest replaces a SCIM user. Only its active attribute is taken into account, the profiles of the users
+// coming from their OIDC provider or from the user directory.
+type ReplaceRequest struct {
+	Schemas []string `json:"schemas"`
+	Active  *bool    `json:"active"`
+}
+
+// Error is the error response of the SCIM endpoint.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// Operation is a minimal SCIM 2.0 service provider through which the IdPs of the enterprises deactivate and
+// deprovision the users of the agent. The users are provisioned on their first login rather than through SCIM.
+type Operation struct {
+	users Backend
+}
+
+// New returns a new Operation.
+func New(config *Config) (*Operation, error) {
+	if config.Users == nil {
+		return nil, errors.New("missing user backend")
+	}
+
+	return &Operation{users: config.Users}, nil
+}
+
+// GetRESTHandlers returns the SCIM handlers. They must be mounted behind the authentication of the IdP.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	idParam := common.Param{Name: "id", In: common.InPath, Description: "Sub of the user.", Required: true}
+
+	return []common.Handler{
+		common.NewHTTPHandler(usersPath, http.MethodGet, o.listHandler, &common.OperationSpec{
+			Summary:   "Looks a SCIM user up by its userName.",
+			Params:    []common.Param{common.QueryParam("filter", `Filter of the form userName eq "<sub>".`)},
+			Responses: map[int]interface{}{http.StatusOK: &ListResponse{}, http.StatusBadRequest: &Error{}},
+		}),
+		common.NewHTTPHandler(userPath, http.MethodGet, o.getHandler, &common.OperationSpec{
+			Summary:   "Returns a SCIM user.",
+			Params:    []common.Param{idParam},
+			Responses: map[int]interface{}{http.StatusOK: &User{}, http.StatusNotFound: &Error{}},
+		}),
+		common.NewHTTPHandler(userPath, http.MethodPut, o.replaceHandler, &common.OperationSpec{
+			Summary:   "Activates or deactivates a SCIM user.",
+			Params:    []common.Param{idParam},
+			Request:   &ReplaceRequest{},
+			Responses: map[int]interface{}{http.StatusOK: &User{}, http.StatusNotFound: &Error{}},
+		}),
+		common.NewHTTPHandler(userPath, http.MethodPatch, o.patchHandler, &common.OperationSpec{
+			Summary:   "Activates or deactivates a SCIM user.",
+			Params:    []common.Param{idParam},
+			Request:   &PatchRequest{},
+			Responses: map[int]interface{}{http.StatusOK: &User{}, http.StatusNotFound: &Error{}},
+		}),
+		common.NewHTTPHandler(userPath, http.MethodDelete, o.deleteHandler, &common.OperationSpec{
+			Summary:   "Deprovisions a SCIM user, deleting the account of the user.",
+			Params:    []common.Param{idParam},
+			Responses: map[int]interface{}{http.StatusNoContent: nil, http.StatusNotFound: &Error{}},
+		}),
+	}
+}
+
+func (o *Operation) listHandler(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("filter")
+
+	match := userNameFilter.FindStringSubmatch(filter)
+	if match == nil {
+		writeError(w, http.StatusBadRequest, "invalidFilter", "unsupported filter '%s'", filter)
+
+		return
+	}
+
+	sub, err := strconv.Unquote(`"` + match[1] + `"`)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidFilter", "invalid userName in filter '%s'", filter)
+
+		return
+	}
+
+	list := &ListResponse{Schemas: []string{listResponseSchema}, StartIndex: 1, Resources: []*User{}}
+
+	usr, err := o.users.User(sub)
+	if err != nil && !errors.Is(err, storage.ErrValueNotFound) {
+		writeError(w, http.StatusInternalServerError, "", "failed to get user: %s", err.Error())
+
+		return
+	}
+
+	if err == nil {
+		list.Resources = append(list.Resources, resourceOf(usr))
+	}
+
+	list.TotalResults = len(list.Resources)
+	list.ItemsPerPage = len(list.Resources)
+
+	writeResponse(w, list)
+}
+
+func (o *Operation) getHandler(w http.ResponseWriter, r *http.Request) {
+	usr, found := o.user(w, r)
+	if !found {
+		return
+	}
+
+	writeResponse(w, resourceOf(usr))
+}
+
+func (o *Operation) replaceHandler(w http.ResponseWriter, r *http.Request) {
+	request := &ReplaceRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	if request.Active == nil {
+		o.getHandler(w, r)
+
+		return
+	}
+
+	o.setActive(w, r, *request.Active)
+}
+
+func (o *Operation) patchHandler(w http.ResponseWriter, r *http.Request) {
+	request := &PatchRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidSyntax", "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	var active *bool
+
+	for _, op := range request.Operations {
+		value, err := activeOf(op)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalidValue", "%s", err.Error())
+
+			return
+		}
+
+		active = value
+	}
+
+	if active == nil {
+		o.getHandler(w, r)
+
+		return
+	}
+
+	o.setActive(w, r, *active)
+}
+
+func (o *Operation) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	sub := mux.Vars(r)["id"]
+
+	deletion, err := o.users.DeprovisionUser(r.Context(), sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		writeError(w, http.StatusNotFound, "", "user %s not found", sub)
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "", "failed to deprovision user: %s", err.Error())
+
+		return
+	}
+
+	for _, step := range deletion.Steps {
+		if step.Status == oidc.DeletionFailed {
+			logger.Warnf("failed to delete the %s of deprovisioned user %s: %s", step.Resource, sub, step.Detail)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setActive deactivates the user, revoking the sessions and tokens of the user, or activates the user again.
+func (o *Operation) setActive(w http.ResponseWriter, r *http.Request, active bool) {
+	sub := mux.Vars(r)["id"]
+
+	var err error
+
+	if active {
+		err = o.users.ActivateUser(sub)
+	} else {
+		err = o.users.DeactivateUser(sub)
+	}
+
+	if errors.Is(err, storage.ErrValueNotFound) {
+		writeError(w, http.StatusNotFound, "", "user %s not found", sub)
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "", "failed to update user: %s", err.Error())
+
+		return
+	}
+
+	o.getHandler(w, r)
+}
+
+func (o *Operation) user(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	sub := mux.Vars(r)["id"]
+
+	usr, err := o.users.User(sub)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		writeError(w, http.StatusNotFound, "", "user %s not found", sub)
+
+		return nil, false
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "", "failed to get user: %s", err.Error())
+
+		return nil, false
+	}
+
+	return usr, true
+}
+
+// activeOf returns the active attribute replaced by the operation, nil if the operation leaves it as is.
+func activeOf(op *PatchOperation) (*bool, error) {
+	if !strings.EqualFold(op.Op, "replace") && !strings.EqualFold(op.Op, "add") {
+		return nil, fmt.Errorf("unsupported patch operation '%s'", op.Op)
+	}
+
+	value := op.Value
+
+	switch {
+	case strings.EqualFold(op.Path, "active"):
+	case op.Path == "":
+		attributes := map[string]json.RawMessage{}
+
+		err := json.Unmarshal(op.Value, &attributes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid patch value: %w", err)
+		}
+
+		var found bool
+
+		value, found = attributes["active"]
+		if !found {
+			return nil, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported patch path '%s'", op.Path)
+	}
+
+	return parseBool(value)
+}
+
+// parseBool parses a boolean, some IdPs sending them as strings, e.g. "False".
+func parseBool(value json.RawMessage) (*bool, error) {
+	var b bool
+
+	err := json.Unmarshal(value, &b)
+	if err == nil {
+		return &b, nil
+	}
+
+	var s string
+
+	err = json.Unmarshal(value, &s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active value %s", string(value))
+	}
+
+	b, err = strconv.ParseBool(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active value %s", string(value))
+	}
+
+	return &b, nil
+}
+
+func resourceOf(usr *user.User) *User {
+	resource := &User{
+		Schemas:     []string{userSchema},
+		ID:          usr.Sub,
+		UserName:    usr.Sub,
+		DisplayName: usr.Name,
+		Active:      !usr.Deactivated,
+		Meta:        &Meta{ResourceType: "User"},
+	}
+
+	if usr.GivenName != "" || usr.FamilyName != "" || usr.Name != "" {
+		resource.Name = &Name{Formatted: usr.Name, GivenName: usr.GivenName, FamilyName: usr.FamilyName}
+	}
+
+	if usr.Email != "" {
+		resource.Emails = []*Email{{Value: usr.Email, Primary: true}}
+	}
+
+	return resource
+}
+
+func writeResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	common.WriteResponse(w, logger, v)
+}
+
+func writeError(w http.ResponseWriter, status int, scimType, msg string, args ...interface{}) {
+	logger.Errorf(msg, args...)
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	common.WriteResponse(w, logger, &Error{
+		Schemas:  []string{errorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   fmt.Sprintf(msg, args...),
+	})
+}
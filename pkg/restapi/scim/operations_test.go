@@ -0,0 +1,366 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package scim // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/user"
+	"github.com/trustbloc/edge-agent/pkg/restapi/oidc"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("returns an instance", func(t *testing.T) {
+		o, err := New(&Config{Users: newMockBackend()})
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 5)
+	})
+
+	t.Run("error if user backend is missing", func(t *testing.T) {
+		_, err := New(&Config{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing user backend")
+	})
+}
+
+func TestOperation_ListHandler(t *testing.T) {
+	t.Run("looks a user up by its userName", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice", Name: "Alice", Email: "alice@example.com"}
+
+		w := serve(t, backend, http.MethodGet, usersPath+"?filter="+url.QueryEscape(`userName eq "alice"`), nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, contentType, w.Header().Get("Content-Type"))
+
+		list := &ListResponse{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(list))
+		require.Equal(t, 1, list.TotalResults)
+		require.Equal(t, &User{
+			Schemas:     []string{userSchema},
+			ID:          "alice",
+			UserName:    "alice",
+			DisplayName: "Alice",
+			Name:        &Name{Formatted: "Alice"},
+			Emails:      []*Email{{Value: "alice@example.com", Primary: true}},
+			Active:      true,
+			Meta:        &Meta{ResourceType: "User"},
+		}, list.Resources[0])
+	})
+
+	t.Run("returns no user if the user is not onboarded", func(t *testing.T) {
+		w := serve(t, newMockBackend(), http.MethodGet,
+			usersPath+"?filter="+url.QueryEscape(`userName eq "bob"`), nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		list := &ListResponse{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(list))
+		require.Zero(t, list.TotalResults)
+		require.Empty(t, list.Resources)
+	})
+
+	t.Run("err badrequest if filter is not supported", func(t *testing.T) {
+		w := serve(t, newMockBackend(), http.MethodGet,
+			usersPath+"?filter="+url.QueryEscape(`emails co "example.com"`), nil)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		scimErr := &Error{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(scimErr))
+		require.Equal(t, "400", scimErr.Status)
+		require.Equal(t, "invalidFilter", scimErr.ScimType)
+	})
+
+	t.Run("err internalservererror if user cannot be fetched", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.err = errors.New("test")
+
+		w := serve(t, backend, http.MethodGet, usersPath+"?filter="+url.QueryEscape(`userName eq "alice"`), nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestOperation_GetHandler(t *testing.T) {
+	t.Run("returns the user", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice", Deactivated: true}
+
+		w := serve(t, backend, http.MethodGet, usersPath+"/alice", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		result := &User{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+		require.Equal(t, "alice", result.ID)
+		require.False(t, result.Active)
+	})
+
+	t.Run("err notfound if user is not onboarded", func(t *testing.T) {
+		w := serve(t, newMockBackend(), http.MethodGet, usersPath+"/bob", nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		scimErr := &Error{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(scimErr))
+		require.Equal(t, []string{errorSchema}, scimErr.Schemas)
+		require.Equal(t, "404", scimErr.Status)
+	})
+}
+
+func TestOperation_PatchHandler(t *testing.T) {
+	deactivate := json.RawMessage(`false`)
+
+	for name, operations := range map[string][]*PatchOperation{
+		"with the active path": {{Op: "replace", Path: "active", Value: deactivate}},
+		"without path":         {{Op: "Replace", Value: json.RawMessage(`{"active":false}`)}},
+		"with a string value":  {{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)}},
+		"with the add op":      {{Op: "add", Path: "active", Value: deactivate}},
+		"after other modifications": {
+			{Op: "replace", Value: json.RawMessage(`{"displayName":"Alice"}`)},
+			{Op: "replace", Path: "active", Value: deactivate},
+		},
+		"with the last value winning": {
+			{Op: "replace", Path: "active", Value: json.RawMessage(`true`)},
+			{Op: "replace", Path: "active", Value: deactivate},
+		},
+	} {
+		operations := operations
+
+		t.Run("deactivates the user "+name, func(t *testing.T) {
+			backend := newMockBackend()
+			backend.users["alice"] = &user.User{Sub: "alice"}
+
+			w := serve(t, backend, http.MethodPatch, usersPath+"/alice", &PatchRequest{Operations: operations})
+			require.Equal(t, http.StatusOK, w.Code)
+			require.True(t, backend.users["alice"].Deactivated)
+
+			result := &User{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+			require.False(t, result.Active)
+		})
+	}
+
+	t.Run("activates the user", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice", Deactivated: true}
+
+		w := serve(t, backend, http.MethodPatch, usersPath+"/alice", &PatchRequest{Operations: []*PatchOperation{
+			{Op: "replace", Path: "active", Value: json.RawMessage(`true`)},
+		}})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.False(t, backend.users["alice"].Deactivated)
+	})
+
+	t.Run("leaves the user as is without the active attribute", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice"}
+
+		w := serve(t, backend, http.MethodPatch, usersPath+"/alice", &PatchRequest{Operations: []*PatchOperation{
+			{Op: "replace", Value: json.RawMessage(`{"displayName":"Alice"}`)},
+		}})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.False(t, backend.users["alice"].Deactivated)
+	})
+
+	t.Run("err badrequest if patch is not supported", func(t *testing.T) {
+		for _, op := range []*PatchOperation{
+			{Op: "remove", Path: "active"},
+			{Op: "replace", Path: "emails", Value: json.RawMessage(`[]`)},
+			{Op: "replace", Path: "active", Value: json.RawMessage(`"maybe"`)},
+			{Op: "replace", Path: "active", Value: json.RawMessage(`1`)},
+			{Op: "replace", Value: json.RawMessage(`[]`)},
+		} {
+			backend := newMockBackend()
+			backend.users["alice"] = &user.User{Sub: "alice"}
+
+			w := serve(t, backend, http.MethodPatch, usersPath+"/alice", &PatchRequest{Operations: []*PatchOperation{op}})
+			require.Equal(t, http.StatusBadRequest, w.Code, string(op.Value))
+			require.False(t, backend.users["alice"].Deactivated)
+		}
+	})
+
+	t.Run("err badrequest if request is invalid", func(t *testing.T) {
+		w := serve(t, newMockBackend(), http.MethodPatch, usersPath+"/alice", []byte("{"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("err notfound if user is not onboarded", func(t *testing.T) {
+		w := serve(t, newMockBackend(), http.MethodPatch, usersPath+"/bob", &PatchRequest{
+			Operations: []*PatchOperation{{Op: "replace", Path: "active", Value: json.RawMessage(`false`)}},
+		})
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("err internalservererror if user cannot be deactivated", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice"}
+		backend.deactivateErr = errors.New("test")
+
+		w := serve(t, backend, http.MethodPatch, usersPath+"/alice", &PatchRequest{
+			Operations: []*PatchOperation{{Op: "replace", Path: "active", Value: json.RawMessage(`false`)}},
+		})
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestOperation_ReplaceHandler(t *testing.T) {
+	t.Run("deactivates the user", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice"}
+
+		w := serve(t, backend, http.MethodPut, usersPath+"/alice", []byte(`{"userName":"alice","active":false}`))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.True(t, backend.users["alice"].Deactivated)
+	})
+
+	t.Run("leaves the user as is without the active attribute", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice", Deactivated: true}
+
+		w := serve(t, backend, http.MethodPut, usersPath+"/alice", []byte(`{"userName":"alice"}`))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.True(t, backend.users["alice"].Deactivated)
+	})
+
+	t.Run("err badrequest if request is invalid", func(t *testing.T) {
+		w := serve(t, newMockBackend(), http.MethodPut, usersPath+"/alice", []byte("{"))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestOperation_DeleteHandler(t *testing.T) {
+	t.Run("deprovisions the user", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice"}
+
+		w := serve(t, backend, http.MethodDelete, usersPath+"/alice", nil)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		require.Equal(t, []string{"alice"}, backend.deprovisioned)
+	})
+
+	t.Run("deprovisions the user despite the failed deletions", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice"}
+		backend.deletion = &oidc.AccountDeletion{Steps: []*oidc.DeletionStep{
+			{Resource: "keystore", Status: oidc.DeletionFailed, Detail: "test"},
+		}}
+
+		w := serve(t, backend, http.MethodDelete, usersPath+"/alice", nil)
+		require.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("err notfound if user is not onboarded", func(t *testing.T) {
+		w := serve(t, newMockBackend(), http.MethodDelete, usersPath+"/bob", nil)
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("err internalservererror if user cannot be deprovisioned", func(t *testing.T) {
+		backend := newMockBackend()
+		backend.users["alice"] = &user.User{Sub: "alice"}
+		backend.deprovisionErr = errors.New("test")
+
+		w := serve(t, backend, http.MethodDelete, usersPath+"/alice", nil)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func serve(t *testing.T, backend *mockBackend, method, target string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	o, err := New(&Config{Users: backend})
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+
+	for _, handler := range o.GetRESTHandlers() {
+		router.HandleFunc(handler.Path(), handler.Handle()).Methods(handler.Method())
+	}
+
+	raw, ok := body.([]byte)
+	if !ok && body != nil {
+		raw, err = json.Marshal(body)
+		require.NoError(t, err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewReader(raw)))
+
+	return w
+}
+
+type mockBackend struct {
+	users          map[string]*user.User
+	deletion       *oidc.AccountDeletion
+	deprovisioned  []string
+	err            error
+	deactivateErr  error
+	deprovisionErr error
+}
+
+func newMockBackend() *mockBackend {
+	return &mockBackend{users: map[string]*user.User{}, deletion: &oidc.AccountDeletion{}}
+}
+
+func (m *mockBackend) User(sub string) (*user.User, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	usr, found := m.users[sub]
+	if !found {
+		return nil, storage.ErrValueNotFound
+	}
+
+	return usr, nil
+}
+
+func (m *mockBackend) DeactivateUser(sub string) error {
+	if m.deactivateErr != nil {
+		return m.deactivateErr
+	}
+
+	usr, err := m.User(sub)
+	if err != nil {
+		return err
+	}
+
+	usr.Deactivated = true
+
+	return nil
+}
+
+func (m *mockBackend) ActivateUser(sub string) error {
+	usr, err := m.User(sub)
+	if err != nil {
+		return err
+	}
+
+	usr.Deactivated = false
+
+	return nil
+}
+
+func (m *mockBackend) DeprovisionUser(_ context.Context, sub string) (*oidc.AccountDeletion, error) {
+	if m.deprovisionErr != nil {
+		return nil, m.deprovisionErr
+	}
+
+	_, err := m.User(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	m.deprovisioned = append(m.deprovisioned, sub)
+
+	return m.deletion, nil
+}
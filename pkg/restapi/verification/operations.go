@@ -0,0 +1,469 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package verification
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/verifier"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+	"github.com/trustbloc/edge-core/pkg/log"
+)
+
+// Endpoints.
+const (
+	verifyPath = "/verify"
+)
+
+const (
+	userSubCookieName = "user_sub"
+	// the status lists are cached this long by default
+	defaultStatusListTTL = 5 * time.Minute
+)
+
+// Statuses of the checks.
+const (
+	CheckOK      = "ok"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+// Names of the checks.
+const (
+	CheckProof  = "proof"
+	CheckExpiry = "expiry"
+	CheckStatus = "status"
+)
+
+var logger = log.New("edge-agent/verification")
+
+// Resolver resolves the DIDs of the issuers and holders.
+type Resolver interface {
+	Resolve(did string, opts ...vdrapi.ResolveOpts) (*did.Doc, error)
+}
+
+// HTTPClient fetches the status lists of the credentials.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Resolver   Resolver
+	HTTPClient HTTPClient
+	// StatusListTTL is how long the status lists are cached for. Defaults to 5 minutes.
+	StatusListTTL time.Duration
+	Keys          *KeyConfig
+}
+
+// KeyConfig holds configuration for cryptographic keys.
+type KeyConfig struct {
+	Auth []byte
+	Enc  []byte
+	// Ring supplies the keys in place of Auth and Enc, and rotates them at runtime.
+	Ring *cookie.KeyRing
+}
+
+// VerifyRequest holds either a credential or a presentation, as JSON or as a JWT.
+type VerifyRequest struct {
+	Credential   json.RawMessage `json:"credential,omitempty"`
+	Presentation json.RawMessage `json:"presentation,omitempty"`
+}
+
+// VerificationReport is the outcome of the verification of a credential or a presentation. Checks are those of the
+// presentation, if a presentation was verified.
+type VerificationReport struct {
+	Verified    bool                `json:"verified"`
+	Holder      string              `json:"holder,omitempty"`
+	Checks      []*Check            `json:"checks,omitempty"`
+	Credentials []*CredentialReport `json:"credentials"`
+}
+
+// CredentialReport is the outcome of the verification of a credential.
+type CredentialReport struct {
+	ID       string   `json:"id,omitempty"`
+	Issuer   string   `json:"issuer"`
+	Types    []string `json:"types"`
+	Verified bool     `json:"verified"`
+	Checks   []*Check `json:"checks"`
+}
+
+// Check is the outcome of a check of a credential or a presentation, with why it failed or was skipped.
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Operation verifies the credentials and presentations of the users, so that the wallet UI shows their trust
+// indicators without a crypto stack of its own.
+type Operation struct {
+	resolver   Resolver
+	httpClient HTTPClient
+	// load the JSON-LD contexts of the linked data proofs, cached across the verifications
+	jsonldOpt     verifiable.CredentialOpt
+	presJSONLDOpt verifiable.PresentationOpt
+	cookies       cookie.Store
+	statusListTTL time.Duration
+	statusLists   map[string]*statusList
+	mutex         sync.Mutex
+	now           func() time.Time
+}
+
+// New returns a new Operation.
+func New(config *Config) (*Operation, error) {
+	if config.Resolver == nil {
+		return nil, errors.New("missing did resolver")
+	}
+
+	loader := verifiable.CachingJSONLDLoader()
+
+	o := &Operation{
+		resolver:      config.Resolver,
+		httpClient:    config.HTTPClient,
+		cookies:       cookie.NewStore(config.Keys.Auth, config.Keys.Enc, cookie.WithKeyRing(config.Keys.Ring)),
+		statusListTTL: config.StatusListTTL,
+		statusLists:   map[string]*statusList{},
+		jsonldOpt:     verifiable.WithJSONLDDocumentLoader(loader),
+		presJSONLDOpt: verifiable.WithPresJSONLDDocumentLoader(loader),
+		now:           time.Now,
+	}
+
+	if o.httpClient == nil {
+		o.httpClient = http.DefaultClient
+	}
+
+	if o.statusListTTL <= 0 {
+		o.statusListTTL = defaultStatusListTTL
+	}
+
+	return o, nil
+}
+
+// GetRESTHandlers get all controller API handler available for this service.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	return []common.Handler{
+		common.NewHTTPHandler(verifyPath, http.MethodPost, o.verifyHandler, &common.OperationSpec{
+			Summary: "Verifies the proof, status and expiry of a credential, or of a presentation and its credentials.",
+			Request: &VerifyRequest{},
+			Responses: map[int]interface{}{
+				http.StatusOK:         &VerificationReport{},
+				http.StatusBadRequest: nil,
+				http.StatusForbidden:  nil,
+			},
+		}),
+	}
+}
+
+func (o *Operation) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	if !o.loggedIn(w, r) {
+		return
+	}
+
+	request := &VerifyRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid request: %s", err.Error())
+
+		return
+	}
+
+	var report *VerificationReport
+
+	switch {
+	case len(request.Credential) > 0 && len(request.Presentation) > 0:
+		err = errors.New("either a credential or a presentation must be verified, not both")
+	case len(request.Credential) > 0:
+		report, err = o.VerifyCredential(r.Context(), unquote(request.Credential))
+	case len(request.Presentation) > 0:
+		report, err = o.VerifyPresentation(r.Context(), unquote(request.Presentation))
+	default:
+		err = errors.New("missing credential or presentation")
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, report)
+}
+
+func (o *Operation) loggedIn(w http.ResponseWriter, r *http.Request) bool {
+	jar, err := o.cookies.Open(r)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "cannot open cookies: %s", err.Error())
+
+		return false
+	}
+
+	if _, found := jar.Get(userSubCookieName); !found {
+		common.WriteErrorResponsef(w, logger, http.StatusForbidden, "not logged in")
+
+		return false
+	}
+
+	return true
+}
+
+// unquote returns the JWT of a JSON string, and the JSON of the other values.
+func unquote(raw json.RawMessage) []byte {
+	var s string
+
+	if json.Unmarshal(raw, &s) == nil {
+		return []byte(s)
+	}
+
+	return raw
+}
+
+// VerifyCredential verifies the proof, status and expiry of the credential. The error is returned for the
+// credentials that cannot be parsed, and the failed checks are reported.
+func (o *Operation) VerifyCredential(ctx context.Context, vcData []byte) (*VerificationReport, error) {
+	vc, err := o.parseCredential(vcData, false)
+	if err != nil {
+		return nil, err
+	}
+
+	report := o.verifyCredential(ctx, vc, vcData)
+
+	return &VerificationReport{Verified: report.Verified, Credentials: []*CredentialReport{report}}, nil
+}
+
+// VerifyPresentation verifies the proof of the presentation, and the proof, status and expiry of its credentials.
+// The error is returned for the presentations that cannot be parsed, and the failed checks are reported.
+func (o *Operation) VerifyPresentation(ctx context.Context, vpData []byte) (*VerificationReport, error) {
+	vp, err := verifiable.ParsePresentation(vpData, verifiable.WithPresDisabledProofCheck(),
+		verifiable.WithPresPublicKeyFetcher(o.publicKey), o.presJSONLDOpt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid presentation: %w", err)
+	}
+
+	report := &VerificationReport{Holder: vp.Holder, Credentials: []*CredentialReport{}}
+
+	proof := &Check{Name: CheckProof, Status: CheckOK}
+
+	switch {
+	case jwt.IsJWS(string(vpData)):
+		// the credentials are checked on their own
+		_, err = jwt.Parse(string(vpData), jwt.WithSignatureVerifier(jwt.NewVerifier(jwt.KeyResolverFunc(o.publicKey))))
+		if err != nil {
+			proof.fail(err.Error())
+		}
+	case len(vp.Proofs) == 0:
+		proof.fail("the presentation is not signed")
+	default:
+		_, err = verifiable.ParsePresentation(vpData, verifiable.WithPresPublicKeyFetcher(o.publicKey),
+			o.presJSONLDOpt)
+		if err != nil {
+			proof.fail(err.Error())
+		}
+	}
+
+	report.Checks = []*Check{proof}
+	report.Verified = proof.Status != CheckFailed
+
+	credentials, err := presentedCredentials(vpData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid presentation: %w", err)
+	}
+
+	for _, vcData := range credentials {
+		vc, err := o.parseCredential(vcData, false)
+		if err != nil {
+			return nil, err
+		}
+
+		credential := o.verifyCredential(ctx, vc, vcData)
+		report.Credentials = append(report.Credentials, credential)
+		report.Verified = report.Verified && credential.Verified
+	}
+
+	return report, nil
+}
+
+// presentedCredentials returns the credentials of the presentation as they were presented, since the parsed
+// presentations hold the credentials in JWT decoded.
+func presentedCredentials(vpData []byte) ([][]byte, error) {
+	raw := vpData
+
+	if jwt.IsJWS(string(vpData)) {
+		payload, err := base64.RawURLEncoding.DecodeString(strings.Split(string(vpData), ".")[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JWT: %w", err)
+		}
+
+		claims := struct {
+			VP json.RawMessage `json:"vp"`
+		}{}
+
+		err = json.Unmarshal(payload, &claims)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JWT claims: %w", err)
+		}
+
+		raw = claims.VP
+	}
+
+	vp := struct {
+		Credentials json.RawMessage `json:"verifiableCredential"`
+	}{}
+
+	err := json.Unmarshal(raw, &vp)
+	if err != nil || len(vp.Credentials) == 0 {
+		return nil, err
+	}
+
+	var credentials []json.RawMessage
+
+	if json.Unmarshal(vp.Credentials, &credentials) != nil {
+		credentials = []json.RawMessage{vp.Credentials}
+	}
+
+	result := make([][]byte, len(credentials))
+
+	for i := range credentials {
+		// the credentials in JWT are JSON strings
+		result[i] = unquote(credentials[i])
+	}
+
+	return result, nil
+}
+
+// parseCredential parses the credential, checking its proof if checkProof. The schemas of the credentials are not
+// downloaded.
+func (o *Operation) parseCredential(vcData []byte, checkProof bool) (*verifiable.Credential, error) {
+	opts := []verifiable.CredentialOpt{
+		verifiable.WithPublicKeyFetcher(o.publicKey),
+		verifiable.WithNoCustomSchemaCheck(),
+		o.jsonldOpt,
+	}
+
+	if !checkProof {
+		opts = append(opts, verifiable.WithDisabledProofCheck())
+	}
+
+	vc, err := verifiable.ParseCredential(vcData, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential: %w", err)
+	}
+
+	return vc, nil
+}
+
+func (o *Operation) verifyCredential(ctx context.Context, vc *verifiable.Credential,
+	vcData []byte) *CredentialReport {
+	report := &CredentialReport{
+		ID:     vc.ID,
+		Issuer: vc.Issuer.ID,
+		Types:  vc.Types,
+		Checks: []*Check{
+			o.checkProof(vc, vcData),
+			o.checkExpiry(vc),
+			o.checkStatus(ctx, vc),
+		},
+		Verified: true,
+	}
+
+	for _, check := range report.Checks {
+		if check.Status == CheckFailed {
+			report.Verified = false
+		}
+	}
+
+	return report
+}
+
+func (o *Operation) checkProof(vc *verifiable.Credential, vcData []byte) *Check {
+	check := &Check{Name: CheckProof, Status: CheckOK}
+
+	if !jwt.IsJWS(string(vcData)) && len(vc.Proofs) == 0 {
+		return check.fail("the credential is not signed")
+	}
+
+	_, err := o.parseCredential(vcData, true)
+	if err != nil {
+		return check.fail(err.Error())
+	}
+
+	return check
+}
+
+func (o *Operation) checkExpiry(vc *verifiable.Credential) *Check {
+	check := &Check{Name: CheckExpiry, Status: CheckOK}
+	now := o.now()
+
+	if vc.Issued != nil && vc.Issued.Time.After(now) {
+		return check.fail(fmt.Sprintf("not valid before %s", vc.Issued.Time.UTC().Format(time.RFC3339)))
+	}
+
+	if vc.Expired != nil && !vc.Expired.Time.After(now) {
+		return check.fail(fmt.Sprintf("expired at %s", vc.Expired.Time.UTC().Format(time.RFC3339)))
+	}
+
+	return check
+}
+
+func (c *Check) fail(detail string) *Check {
+	c.Status = CheckFailed
+	c.Detail = detail
+
+	return c
+}
+
+func (c *Check) skip(detail string) *Check {
+	c.Status = CheckSkipped
+	c.Detail = detail
+
+	return c
+}
+
+// publicKey resolves the DID of the issuer or holder to the key of the proof.
+func (o *Operation) publicKey(didID, keyID string) (*verifier.PublicKey, error) {
+	doc, err := o.resolver.Resolve(didID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", didID, err)
+	}
+
+	fragment := keyID
+	if i := strings.LastIndex(keyID, "#"); i >= 0 {
+		fragment = keyID[i:]
+	}
+
+	methods := append([]did.VerificationMethod{}, doc.VerificationMethod...)
+
+	for _, verifications := range doc.VerificationMethods() {
+		for _, v := range verifications {
+			methods = append(methods, v.VerificationMethod)
+		}
+	}
+
+	for i := range methods {
+		vm := methods[i]
+
+		if vm.ID == keyID || strings.HasSuffix(vm.ID, fragment) {
+			return &verifier.PublicKey{Type: vm.Type, Value: vm.Value, JWK: vm.JSONWebKey()}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("key %s not found in the did document of %s", keyID, didID)
+}
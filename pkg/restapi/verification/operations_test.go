@@ -0,0 +1,547 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package verification // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/did"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/util"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+	vdrapi "github.com/hyperledger/aries-framework-go/pkg/framework/aries/api/vdr"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/cookie"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		o, err := New(config(&mockResolver{}))
+		require.NoError(t, err)
+		require.Len(t, o.GetRESTHandlers(), 1)
+		require.Equal(t, defaultStatusListTTL, o.statusListTTL)
+	})
+
+	t.Run("error if the did resolver is missing", func(t *testing.T) {
+		_, err := New(&Config{Keys: &KeyConfig{}})
+		require.EqualError(t, err, "missing did resolver")
+	})
+}
+
+func TestOperation_VerifyCredential(t *testing.T) {
+	t.Run("verifies a signed credential", func(t *testing.T) {
+		resolver := &mockResolver{}
+		iss := newIssuer(t, resolver, "did:example:issuer")
+		o := newOperation(t, resolver)
+
+		report, err := o.VerifyCredential(context.Background(), []byte(iss.issue(t, credential(nil))))
+		require.NoError(t, err)
+		require.True(t, report.Verified)
+		require.Len(t, report.Credentials, 1)
+		require.Equal(t, iss.did, report.Credentials[0].Issuer)
+		require.Equal(t, []string{"VerifiableCredential"}, report.Credentials[0].Types)
+		requireChecks(t, report.Credentials[0], CheckOK, CheckOK, CheckSkipped)
+	})
+
+	t.Run("fails a credential signed with another key", func(t *testing.T) {
+		resolver := &mockResolver{}
+		iss := newIssuer(t, resolver, "did:example:issuer")
+		impostor := newIssuer(t, &mockResolver{}, iss.did)
+		o := newOperation(t, resolver)
+
+		report, err := o.VerifyCredential(context.Background(), []byte(impostor.issue(t, credential(nil))))
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		requireChecks(t, report.Credentials[0], CheckFailed, CheckOK, CheckSkipped)
+	})
+
+	t.Run("fails a credential of an issuer not resolved", func(t *testing.T) {
+		iss := newIssuer(t, &mockResolver{}, "did:example:issuer")
+		o := newOperation(t, &mockResolver{ResolveErr: errors.New("not found")})
+
+		report, err := o.VerifyCredential(context.Background(), []byte(iss.issue(t, credential(nil))))
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		require.Contains(t, report.Credentials[0].Checks[0].Detail, "failed to resolve did:example:issuer")
+	})
+
+	t.Run("fails an unsigned credential", func(t *testing.T) {
+		vc := credential(nil)
+		vc.Issuer = verifiable.Issuer{ID: "did:example:issuer"}
+
+		vcData, err := vc.MarshalJSON()
+		require.NoError(t, err)
+
+		report, err := newOperation(t, &mockResolver{}).VerifyCredential(context.Background(), vcData)
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		require.Equal(t, "the credential is not signed", report.Credentials[0].Checks[0].Detail)
+	})
+
+	t.Run("fails an expired credential", func(t *testing.T) {
+		resolver := &mockResolver{}
+		iss := newIssuer(t, resolver, "did:example:issuer")
+		o := newOperation(t, resolver)
+
+		vc := credential(nil)
+		vc.Expired = util.NewTime(time.Now().Add(-time.Minute))
+
+		report, err := o.VerifyCredential(context.Background(), []byte(iss.issue(t, vc)))
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		requireChecks(t, report.Credentials[0], CheckOK, CheckFailed, CheckSkipped)
+		require.Contains(t, report.Credentials[0].Checks[1].Detail, "expired at")
+	})
+
+	t.Run("fails a credential not valid yet", func(t *testing.T) {
+		resolver := &mockResolver{}
+		iss := newIssuer(t, resolver, "did:example:issuer")
+		o := newOperation(t, resolver)
+
+		vc := credential(nil)
+		vc.Issued = util.NewTime(time.Now().Add(time.Hour))
+
+		report, err := o.VerifyCredential(context.Background(), []byte(iss.issue(t, vc)))
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		require.Contains(t, report.Credentials[0].Checks[1].Detail, "not valid before")
+	})
+
+	t.Run("error if the credential is invalid", func(t *testing.T) {
+		_, err := newOperation(t, &mockResolver{}).VerifyCredential(context.Background(), []byte("{}"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid credential")
+	})
+}
+
+func TestOperation_CheckStatus(t *testing.T) {
+	resolver := &mockResolver{}
+	iss := newIssuer(t, resolver, "did:example:issuer")
+	other := newIssuer(t, resolver, "did:example:other")
+
+	var fetched int32
+
+	lists := map[string]string{
+		"/revocation":  iss.issue(t, statusListCredential("revocation", 3)),
+		"/suspension":  iss.issue(t, statusListCredential("suspension", 3)),
+		"/other":       other.issue(t, statusListCredential("revocation")),
+		"/unsigned":    `{"@context":["https://www.w3.org/2018/credentials/v1"]}`,
+		"/not-encoded": iss.issue(t, credential(nil)),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+
+		list, ok := lists[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_, err := w.Write([]byte(list))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	o := newOperation(t, resolver)
+	o.httpClient = server.Client()
+
+	verify := func(t *testing.T, status *verifiable.TypedID) *Check {
+		t.Helper()
+
+		report, err := o.VerifyCredential(context.Background(), []byte(iss.issue(t, credential(status))))
+		require.NoError(t, err)
+		require.Equal(t, CheckStatus, report.Credentials[0].Checks[2].Name)
+
+		return report.Credentials[0].Checks[2]
+	}
+
+	t.Run("passes a credential not revoked", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/revocation", "revocation", "2"))
+		require.Equal(t, CheckOK, check.Status, check.Detail)
+	})
+
+	t.Run("fails a revoked credential", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/revocation", "revocation", "3"))
+		require.Equal(t, "the credential is revoked", check.Detail)
+	})
+
+	t.Run("fails a suspended credential", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/suspension", "suspension", "3"))
+		require.Equal(t, "the credential is suspended", check.Detail)
+	})
+
+	t.Run("fails a credential revoked in a revocation list 2020", func(t *testing.T) {
+		check := verify(t, &verifiable.TypedID{
+			ID:   server.URL + "/revocation#3",
+			Type: revocationList2020Status,
+			CustomFields: verifiable.CustomFields{
+				"revocationListCredential": server.URL + "/revocation",
+				"revocationListIndex":      "3",
+			},
+		})
+		require.Equal(t, "the credential is revoked", check.Detail)
+	})
+
+	t.Run("caches the status lists", func(t *testing.T) {
+		before := atomic.LoadInt32(&fetched)
+
+		verify(t, statusListEntry(server.URL+"/revocation", "revocation", "1"))
+		require.Equal(t, before, atomic.LoadInt32(&fetched))
+
+		o.now = func() time.Time { return time.Now().Add(defaultStatusListTTL) }
+		defer func() { o.now = time.Now }()
+
+		verify(t, statusListEntry(server.URL+"/revocation", "revocation", "1"))
+		require.Equal(t, before+1, atomic.LoadInt32(&fetched))
+	})
+
+	t.Run("fails a status list of another issuer", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/other", "revocation", "1"))
+		require.Equal(t, "the status list is issued by did:example:other, not by the issuer", check.Detail)
+	})
+
+	t.Run("fails an unsigned status list", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/unsigned", "revocation", "1"))
+		require.Equal(t, CheckFailed, check.Status)
+		require.Contains(t, check.Detail, "invalid status list")
+	})
+
+	t.Run("fails a status list without encoded list", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/not-encoded", "revocation", "1"))
+		require.Equal(t, "invalid status list: no encodedList in the subject", check.Detail)
+	})
+
+	t.Run("fails a status list not found", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/unknown", "revocation", "1"))
+		require.Equal(t, "failed to fetch status list: status 404", check.Detail)
+	})
+
+	t.Run("fails an index out of the list", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/revocation", "revocation", "1000000"))
+		require.Equal(t, "status list index 1000000 out of the list", check.Detail)
+	})
+
+	t.Run("fails an invalid index", func(t *testing.T) {
+		check := verify(t, statusListEntry(server.URL+"/revocation", "revocation", "-1"))
+		require.Equal(t, "invalid status list index '-1'", check.Detail)
+	})
+
+	t.Run("skips an unsupported status", func(t *testing.T) {
+		check := verify(t, &verifiable.TypedID{ID: "https://example.com/status/1", Type: "CredentialStatusList2017"})
+		require.Equal(t, CheckSkipped, check.Status)
+		require.Equal(t, "unsupported status type CredentialStatusList2017", check.Detail)
+	})
+}
+
+func TestOperation_VerifyPresentation(t *testing.T) {
+	resolver := &mockResolver{}
+	iss := newIssuer(t, resolver, "did:example:issuer")
+	holder := newIssuer(t, resolver, "did:example:holder")
+	o := newOperation(t, resolver)
+
+	t.Run("verifies a signed presentation and its credentials", func(t *testing.T) {
+		report, err := o.VerifyPresentation(context.Background(),
+			[]byte(holder.present(t, iss.issue(t, credential(nil)))))
+		require.NoError(t, err)
+		require.True(t, report.Verified)
+		require.Equal(t, holder.did, report.Holder)
+		require.Equal(t, CheckOK, report.Checks[0].Status)
+		require.Len(t, report.Credentials, 1)
+		require.True(t, report.Credentials[0].Verified)
+	})
+
+	t.Run("fails a presentation of an expired credential", func(t *testing.T) {
+		vc := credential(nil)
+		vc.Expired = util.NewTime(time.Now().Add(-time.Minute))
+
+		report, err := o.VerifyPresentation(context.Background(), []byte(holder.present(t, iss.issue(t, vc))))
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		require.Equal(t, CheckOK, report.Checks[0].Status)
+		require.False(t, report.Credentials[0].Verified)
+	})
+
+	t.Run("fails a presentation signed with another key", func(t *testing.T) {
+		impostor := newIssuer(t, &mockResolver{}, holder.did)
+
+		report, err := o.VerifyPresentation(context.Background(),
+			[]byte(impostor.present(t, iss.issue(t, credential(nil)))))
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		require.Equal(t, CheckFailed, report.Checks[0].Status)
+		require.True(t, report.Credentials[0].Verified)
+	})
+
+	t.Run("fails an unsigned presentation", func(t *testing.T) {
+		vp := &verifiable.Presentation{
+			Context: []string{"https://www.w3.org/2018/credentials/v1"},
+			Type:    []string{"VerifiablePresentation"},
+			Holder:  holder.did,
+		}
+		require.NoError(t, vp.SetCredentials(iss.issue(t, credential(nil))))
+
+		vpData, err := vp.MarshalJSON()
+		require.NoError(t, err)
+
+		report, err := o.VerifyPresentation(context.Background(), vpData)
+		require.NoError(t, err)
+		require.False(t, report.Verified)
+		require.Equal(t, "the presentation is not signed", report.Checks[0].Detail)
+		require.True(t, report.Credentials[0].Verified)
+	})
+
+	t.Run("error if the presentation is invalid", func(t *testing.T) {
+		_, err := o.VerifyPresentation(context.Background(), []byte("{}"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid presentation")
+	})
+}
+
+func TestOperation_VerifyHandler(t *testing.T) {
+	resolver := &mockResolver{}
+	iss := newIssuer(t, resolver, "did:example:issuer")
+	holder := newIssuer(t, resolver, "did:example:holder")
+	o := newOperation(t, resolver)
+	vc := iss.issue(t, credential(nil))
+
+	t.Run("verifies a credential", func(t *testing.T) {
+		report := &VerificationReport{}
+		requireVerify(t, o, `{"credential":"`+vc+`"}`, http.StatusOK, report)
+		require.True(t, report.Verified)
+	})
+
+	t.Run("verifies a presentation", func(t *testing.T) {
+		report := &VerificationReport{}
+		requireVerify(t, o, `{"presentation":"`+holder.present(t, vc)+`"}`, http.StatusOK, report)
+		require.True(t, report.Verified)
+		require.Len(t, report.Credentials, 1)
+	})
+
+	t.Run("bad request if both a credential and a presentation are sent", func(t *testing.T) {
+		requireVerify(t, o, `{"credential":"`+vc+`","presentation":"`+holder.present(t, vc)+`"}`,
+			http.StatusBadRequest, nil)
+	})
+
+	t.Run("bad request if neither a credential nor a presentation is sent", func(t *testing.T) {
+		requireVerify(t, o, `{}`, http.StatusBadRequest, nil)
+	})
+
+	t.Run("bad request if the request is invalid", func(t *testing.T) {
+		requireVerify(t, o, `[`, http.StatusBadRequest, nil)
+	})
+
+	t.Run("bad request if the credential is invalid", func(t *testing.T) {
+		requireVerify(t, o, `{"credential":{}}`, http.StatusBadRequest, nil)
+	})
+
+	t.Run("forbidden if the user is not logged in", func(t *testing.T) {
+		o := newOperation(t, resolver)
+		o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{Cookies: map[interface{}]interface{}{}}}
+
+		requireVerify(t, o, `{"credential":"`+vc+`"}`, http.StatusForbidden, nil)
+	})
+
+	t.Run("bad request if the cookies cannot be opened", func(t *testing.T) {
+		o := newOperation(t, resolver)
+		o.cookies = &cookie.MockStore{OpenErr: errors.New("test")}
+
+		requireVerify(t, o, `{"credential":"`+vc+`"}`, http.StatusBadRequest, nil)
+	})
+}
+
+type mockResolver struct {
+	Docs       map[string]*did.Doc
+	ResolveErr error
+}
+
+func (r *mockResolver) Resolve(didID string, _ ...vdrapi.ResolveOpts) (*did.Doc, error) {
+	if r.ResolveErr != nil {
+		return nil, r.ResolveErr
+	}
+
+	doc, ok := r.Docs[didID]
+	if !ok {
+		return nil, vdrapi.ErrNotFound
+	}
+
+	return doc, nil
+}
+
+// issuer signs credentials and presentations with the key of its DID.
+type issuer struct {
+	did string
+	key ed25519.PrivateKey
+}
+
+// newIssuer returns an issuer of a new key, resolved by the resolver.
+func newIssuer(t *testing.T, resolver *mockResolver, didID string) *issuer {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	if resolver.Docs == nil {
+		resolver.Docs = map[string]*did.Doc{}
+	}
+
+	resolver.Docs[didID] = &did.Doc{
+		ID: didID,
+		VerificationMethod: []did.VerificationMethod{
+			*did.NewVerificationMethodFromBytes(didID+"#key-1", "Ed25519VerificationKey2018", didID, pub),
+		},
+	}
+
+	return &issuer{did: didID, key: key}
+}
+
+func (i *issuer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(i.key, data), nil
+}
+
+// issue returns the credential issued and signed by the issuer, as a JWT.
+func (i *issuer) issue(t *testing.T, vc *verifiable.Credential) string {
+	t.Helper()
+
+	vc.Issuer = verifiable.Issuer{ID: i.did}
+
+	claims, err := vc.JWTClaims(false)
+	require.NoError(t, err)
+
+	jws, err := claims.MarshalJWS(verifiable.EdDSA, i, i.did+"#key-1")
+	require.NoError(t, err)
+
+	return jws
+}
+
+// present returns the presentation of the credentials signed by the holder, as a JWT.
+func (i *issuer) present(t *testing.T, credentials ...interface{}) string {
+	t.Helper()
+
+	vp := &verifiable.Presentation{
+		Context: []string{"https://www.w3.org/2018/credentials/v1"},
+		Type:    []string{"VerifiablePresentation"},
+		Holder:  i.did,
+	}
+	require.NoError(t, vp.SetCredentials(credentials...))
+
+	claims, err := vp.JWTClaims(nil, false)
+	require.NoError(t, err)
+
+	jws, err := claims.MarshalJWS(verifiable.EdDSA, i, i.did+"#key-1")
+	require.NoError(t, err)
+
+	return jws
+}
+
+func credential(status *verifiable.TypedID) *verifiable.Credential {
+	return &verifiable.Credential{
+		Context: []string{"https://www.w3.org/2018/credentials/v1"},
+		ID:      "http://example.com/credentials/1",
+		Types:   []string{"VerifiableCredential"},
+		Subject: "did:example:subject",
+		Issued:  util.NewTime(time.Now().Add(-time.Hour)),
+		Status:  status,
+	}
+}
+
+func statusListEntry(listURL, purpose, index string) *verifiable.TypedID {
+	return &verifiable.TypedID{
+		ID:   listURL + "#" + index,
+		Type: statusList2021Entry,
+		CustomFields: verifiable.CustomFields{
+			"statusPurpose":        purpose,
+			"statusListIndex":      index,
+			"statusListCredential": listURL,
+		},
+	}
+}
+
+// statusListCredential returns a status list credential of the purpose, of the indexes set.
+func statusListCredential(purpose string, indexes ...int) *verifiable.Credential {
+	bits := make([]byte, 16*1024) // nolint:gomnd // the minimum size of the lists
+
+	for _, i := range indexes {
+		bits[i/8] |= 1 << (7 - uint(i%8))
+	}
+
+	var compressed bytes.Buffer
+
+	w := gzip.NewWriter(&compressed)
+	_, _ = w.Write(bits) // nolint:errcheck // written in memory
+	_ = w.Close()        // nolint:errcheck // written in memory
+
+	vc := credential(nil)
+	vc.Types = []string{"VerifiableCredential", "StatusList2021Credential"}
+	vc.Subject = verifiable.Subject{
+		ID: "https://example.com/status/1#list",
+		CustomFields: verifiable.CustomFields{
+			"type":          "StatusList2021",
+			"statusPurpose": purpose,
+			"encodedList":   base64.RawURLEncoding.EncodeToString(compressed.Bytes()),
+		},
+	}
+
+	return vc
+}
+
+func newOperation(t *testing.T, resolver Resolver) *Operation {
+	t.Helper()
+
+	o, err := New(config(resolver))
+	require.NoError(t, err)
+
+	o.cookies = &cookie.MockStore{Jar: &cookie.MockJar{
+		Cookies: map[interface{}]interface{}{userSubCookieName: "alice"},
+	}}
+
+	return o
+}
+
+func config(resolver Resolver) *Config {
+	return &Config{
+		Resolver: resolver,
+		Keys:     &KeyConfig{},
+	}
+}
+
+func requireChecks(t *testing.T, report *CredentialReport, proof, expiry, status string) {
+	t.Helper()
+
+	require.Len(t, report.Checks, 3)
+	require.Equal(t, []string{CheckProof, CheckExpiry, CheckStatus},
+		[]string{report.Checks[0].Name, report.Checks[1].Name, report.Checks[2].Name})
+	require.Equal(t, []string{proof, expiry, status},
+		[]string{report.Checks[0].Status, report.Checks[1].Status, report.Checks[2].Status},
+		report.Checks[0].Detail+report.Checks[1].Detail+report.Checks[2].Detail)
+}
+
+func requireVerify(t *testing.T, o *Operation, body string, status int, report *VerificationReport) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.verifyHandler(w, httptest.NewRequest(http.MethodPost, verifyPath, strings.NewReader(body)))
+	require.Equal(t, status, w.Code, w.Body.String())
+
+	if report != nil {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(report))
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package verification
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jwt"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
+)
+
+// Types of the statuses of the credentials: https://w3c-ccg.github.io/vc-status-list-2021/ and
+// https://w3c-ccg.github.io/vc-status-rl-2020/.
+const (
+	statusList2021Entry      = "StatusList2021Entry"
+	revocationList2020Status = "RevocationList2020Status"
+)
+
+const (
+	purposeRevocation = "revocation"
+	purposeSuspension = "suspension"
+	// bounds the status list credentials fetched
+	maxStatusListBytes = 1 << 20
+	// bounds the decompressed lists, of 16KB for the lists of the specs
+	maxListBytes = 1 << 20
+)
+
+// statusList is a status list credential fetched and verified.
+type statusList struct {
+	issuer  string
+	bits    []byte
+	expires time.Time
+}
+
+// statusEntry locates the status of a credential in a status list.
+type statusEntry struct {
+	purpose   string
+	listURL   string
+	listIndex string
+}
+
+func (o *Operation) checkStatus(ctx context.Context, vc *verifiable.Credential) *Check {
+	check := &Check{Name: CheckStatus, Status: CheckOK}
+
+	if vc.Status == nil {
+		return check.skip("the credential has no status")
+	}
+
+	entry, err := parseStatusEntry(vc.Status)
+	if err != nil {
+		return check.skip(err.Error())
+	}
+
+	index, err := strconv.Atoi(entry.listIndex)
+	if err != nil || index < 0 {
+		return check.fail(fmt.Sprintf("invalid status list index '%s'", entry.listIndex))
+	}
+
+	list, err := o.statusList(ctx, entry.listURL)
+	if err != nil {
+		return check.fail(err.Error())
+	}
+
+	if list.issuer != vc.Issuer.ID {
+		return check.fail(fmt.Sprintf("the status list is issued by %s, not by the issuer", list.issuer))
+	}
+
+	if index/8 >= len(list.bits) {
+		return check.fail(fmt.Sprintf("status list index %d out of the list", index))
+	}
+
+	// the first index is the left-most bit of the list
+	if list.bits[index/8]&(1<<(7-uint(index%8))) == 0 {
+		return check
+	}
+
+	if entry.purpose == purposeSuspension {
+		return check.fail("the credential is suspended")
+	}
+
+	return check.fail("the credential is revoked")
+}
+
+func parseStatusEntry(status *verifiable.TypedID) (*statusEntry, error) {
+	field := func(name string) string {
+		s, _ := status.CustomFields[name].(string) // nolint:errcheck // empty if not a string
+
+		return s
+	}
+
+	var entry *statusEntry
+
+	switch status.Type {
+	case statusList2021Entry:
+		entry = &statusEntry{
+			purpose:   field("statusPurpose"),
+			listURL:   field("statusListCredential"),
+			listIndex: field("statusListIndex"),
+		}
+	case revocationList2020Status:
+		entry = &statusEntry{
+			purpose:   purposeRevocation,
+			listURL:   field("revocationListCredential"),
+			listIndex: field("revocationListIndex"),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported status type %s", status.Type)
+	}
+
+	if entry.listURL == "" {
+		return nil, fmt.Errorf("the %s has no status list", status.Type)
+	}
+
+	if entry.purpose == "" {
+		entry.purpose = purposeRevocation
+	}
+
+	return entry, nil
+}
+
+// statusList returns the status list credential of the URL, fetched and verified unless it is cached.
+func (o *Operation) statusList(ctx context.Context, listURL string) (*statusList, error) {
+	o.mutex.Lock()
+	list, ok := o.statusLists[listURL]
+	o.mutex.Unlock()
+
+	if ok && o.now().Before(list.expires) {
+		return list, nil
+	}
+
+	list, err := o.fetchStatusList(ctx, listURL)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for u, l := range o.statusLists {
+		if !o.now().Before(l.expires) {
+			delete(o.statusLists, u)
+		}
+	}
+
+	o.statusLists[listURL] = list
+
+	return list, nil
+}
+
+func (o *Operation) fetchStatusList(ctx context.Context, listURL string) (*statusList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, listURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status list url: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch status list: %w", err)
+	}
+
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			logger.Warnf("failed to close response body: %s", errClose.Error())
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch status list: status %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxStatusListBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch status list: %w", err)
+	}
+
+	if len(body) > maxStatusListBytes {
+		return nil, fmt.Errorf("status list exceeds %d bytes", maxStatusListBytes)
+	}
+
+	listData := unquote(body)
+
+	vc, err := o.parseCredential(listData, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status list: %w", err)
+	}
+
+	if !jwt.IsJWS(string(listData)) && len(vc.Proofs) == 0 {
+		return nil, errors.New("invalid status list: the status list is not signed")
+	}
+
+	encoded, err := encodedList(vc.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status list: %w", err)
+	}
+
+	bits, err := decodeList(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status list: %w", err)
+	}
+
+	return &statusList{issuer: vc.Issuer.ID, bits: bits, expires: o.now().Add(o.statusListTTL)}, nil
+}
+
+// encodedList returns the encodedList of the subject of a status list credential.
+func encodedList(subject interface{}) (string, error) {
+	raw, err := json.Marshal(subject)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal subject: %w", err)
+	}
+
+	var subjects []interface{}
+
+	if json.Unmarshal(raw, &subjects) != nil {
+		subjects = make([]interface{}, 1)
+
+		err = json.Unmarshal(raw, &subjects[0])
+		if err != nil {
+			return "", fmt.Errorf("failed to unmarshal subject: %w", err)
+		}
+	}
+
+	// the subjects of an ID only are JSON strings
+	if len(subjects) > 0 {
+		if fields, ok := subjects[0].(map[string]interface{}); ok {
+			if encoded, ok := fields["encodedList"].(string); ok && encoded != "" {
+				return encoded, nil
+			}
+		}
+	}
+
+	return "", errors.New("no encodedList in the subject")
+}
+
+// decodeList decodes the GZIP-compressed bitstring of a status list, encoded in base64 or base64url.
+func decodeList(encoded string) ([]byte, error) {
+	var compressed []byte
+
+	for _, encoding := range []*base64.Encoding{
+		base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding,
+	} {
+		b, err := encoding.DecodeString(encoded)
+		if err == nil {
+			compressed = b
+
+			break
+		}
+	}
+
+	if compressed == nil {
+		return nil, errors.New("encodedList is not base64")
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("encodedList is not gzip: %w", err)
+	}
+
+	bits, err := ioutil.ReadAll(io.LimitReader(r, maxListBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("encodedList is not gzip: %w", err)
+	}
+
+	if len(bits) > maxListBytes {
+		return nil, fmt.Errorf("encodedList exceeds %d bytes", maxListBytes)
+	}
+
+	return bits, nil
+}
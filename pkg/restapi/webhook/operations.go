@@ -0,0 +1,379 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/log"
+	"github.com/trustbloc/edge-core/pkg/storage"
+)
+
+// Admin endpoints.
+const (
+	webhooksPath    = "/webhooks"
+	deadLettersPath = webhooksPath + "/dead-letters"
+)
+
+const secretSize = 32
+
+var logger = log.New("edge-agent/webhook")
+
+// Config holds all configuration for an Operation.
+type Config struct {
+	Webhooks *events.WebhookStore
+	// Audit records the deleted webhooks and discarded events. Auditing is disabled if nil.
+	Audit *audit.Store
+	// Outbox holds the events whose delivery failed every attempt, which the admins requeue or discard. The
+	// dead letters are not administered if nil.
+	Outbox *outbox.Store
+}
+
+// RegisterWebhookRequest registers a webhook for the events of a tenant, or of every tenant if Tenant is empty.
+type RegisterWebhookRequest struct {
+	Tenant string   `json:"tenant,omitempty"`
+	URL    string   `json:"url"`
+	Topics []string `json:"topics,omitempty"`
+	// Secret is the HMAC key of the deliveries. A random secret is generated if empty.
+	Secret string `json:"secret,omitempty"`
+}
+
+// Operation manages the webhooks through the administrative API.
+type Operation struct {
+	webhooks *events.WebhookStore
+	audit    *audit.Store
+	outbox   *outbox.Store
+}
+
+type deletedWebhook struct {
+	Resource string `json:"resource"`
+	ID       string `json:"id"`
+	Tenant   string `json:"tenant,omitempty"`
+	URL      string `json:"url"`
+}
+
+type discardedEvent struct {
+	Resource string `json:"resource"`
+	ID       string `json:"id"`
+	Topic    string `json:"topic"`
+	Tenant   string `json:"tenant,omitempty"`
+}
+
+// New returns a new Operation.
+func New(config *Config) (*Operation, error) {
+	if config.Webhooks == nil {
+		return nil, errors.New("missing webhook store")
+	}
+
+	return &Operation{webhooks: config.Webhooks, audit: config.Audit, outbox: config.Outbox}, nil
+}
+
+// GetRESTHandlers returns the administrative handlers. They must be mounted behind admin authentication.
+func (o *Operation) GetRESTHandlers() []common.Handler {
+	handlers := []common.Handler{
+		common.NewHTTPHandler(webhooksPath, http.MethodPost, o.registerHandler, &common.OperationSpec{
+			Summary:   "Registers a webhook.",
+			Request:   &RegisterWebhookRequest{},
+			Responses: map[int]interface{}{http.StatusCreated: &events.Webhook{}},
+		}),
+		common.NewHTTPHandler(webhooksPath, http.MethodGet, o.listHandler, &common.OperationSpec{
+			Summary:   "Lists the webhooks of a tenant, or all webhooks.",
+			Params:    []common.Param{common.QueryParam("tenant", "Tenant of the webhooks.")},
+			Responses: map[int]interface{}{http.StatusOK: []*events.Webhook{}},
+		}),
+		common.NewHTTPHandler(webhooksPath, http.MethodDelete, o.deleteHandler, &common.OperationSpec{
+			Summary:   "Deletes a webhook.",
+			Params:    []common.Param{{Name: "id", In: common.InQuery, Description: "Id of the webhook.", Required: true}},
+			Responses: map[int]interface{}{http.StatusNoContent: nil},
+		}),
+	}
+
+	if o.outbox == nil {
+		return handlers
+	}
+
+	idParam := common.Param{Name: "id", In: common.InQuery, Description: "Id of the event.", Required: true}
+
+	return append(handlers,
+		common.NewHTTPHandler(deadLettersPath, http.MethodGet, o.listDeadHandler, &common.OperationSpec{
+			Summary:   "Lists the events of a tenant, or all events, whose delivery failed every attempt.",
+			Params:    []common.Param{common.QueryParam("tenant", "Tenant of the events.")},
+			Responses: map[int]interface{}{http.StatusOK: []*outbox.Event{}},
+		}),
+		common.NewHTTPHandler(deadLettersPath, http.MethodPost, o.requeueHandler, &common.OperationSpec{
+			Summary:   "Requeues a dead event for delivery, with all its attempts.",
+			Params:    []common.Param{idParam},
+			Responses: map[int]interface{}{http.StatusOK: &outbox.Event{}},
+		}),
+		common.NewHTTPHandler(deadLettersPath, http.MethodDelete, o.discardHandler, &common.OperationSpec{
+			Summary:   "Discards a dead event.",
+			Params:    []common.Param{idParam},
+			Responses: map[int]interface{}{http.StatusNoContent: nil},
+		}),
+	)
+}
+
+func (o *Operation) registerHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("handling register webhook request")
+
+	request := &RegisterWebhookRequest{}
+
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "failed to decode request: %s", err.Error())
+
+		return
+	}
+
+	err = validateURL(request.URL)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "invalid webhook url: %s", err.Error())
+
+		return
+	}
+
+	secret := request.Secret
+	if secret == "" {
+		secret, err = newSecret()
+		if err != nil {
+			common.WriteErrorResponsef(w, logger,
+				http.StatusInternalServerError, "failed to generate webhook secret: %s", err.Error())
+
+			return
+		}
+	}
+
+	webhook := &events.Webhook{
+		ID:     uuid.New().String(),
+		Tenant: request.Tenant,
+		URL:    request.URL,
+		Secret: secret,
+		Topics: request.Topics,
+	}
+
+	err = o.webhooks.Save(webhook)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	// the secret is only disclosed on registration
+	w.WriteHeader(http.StatusCreated)
+	common.WriteResponse(w, logger, webhook)
+}
+
+func (o *Operation) listHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := r.URL.Query().Get("tenant")
+
+	all, err := o.webhooks.List()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	webhooks := make([]*events.Webhook, 0, len(all))
+
+	for _, webhook := range all {
+		if tenant != "" && webhook.Tenant != tenant {
+			continue
+		}
+
+		webhook.Secret = ""
+		webhooks = append(webhooks, webhook)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	common.WriteResponse(w, logger, webhooks)
+}
+
+func (o *Operation) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing id parameter")
+
+		return
+	}
+
+	hook, err := o.webhooks.Get(id)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "webhook %s not found", id)
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	err = o.webhooks.Delete(id)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	o.auditDeleted(hook)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o *Operation) listDeadHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := r.URL.Query().Get("tenant")
+
+	all, err := o.outbox.Dead()
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	dead := make([]*outbox.Event, 0, len(all))
+
+	for _, e := range all {
+		if tenant == "" || e.Tenant == tenant {
+			dead = append(dead, e)
+		}
+	}
+
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i].Created.Before(dead[j].Created)
+	})
+
+	common.WriteResponse(w, logger, dead)
+}
+
+func (o *Operation) requeueHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing id parameter")
+
+		return
+	}
+
+	e, err := o.outbox.Requeue(id)
+	if errors.Is(err, storage.ErrValueNotFound) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "dead event %s not found", id)
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	common.WriteResponse(w, logger, e)
+}
+
+func (o *Operation) discardHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		common.WriteErrorResponsef(w, logger, http.StatusBadRequest, "missing id parameter")
+
+		return
+	}
+
+	e, err := o.outbox.Get(id)
+	if errors.Is(err, storage.ErrValueNotFound) || (err == nil && e.Status != outbox.StatusDead) {
+		common.WriteErrorResponsef(w, logger, http.StatusNotFound, "dead event %s not found", id)
+
+		return
+	}
+
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	err = o.outbox.Delete(id)
+	if err != nil {
+		common.WriteErrorResponsef(w, logger, http.StatusInternalServerError, "%s", err.Error())
+
+		return
+	}
+
+	o.auditDiscarded(e)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%s is not an absolute http(s) url", raw)
+	}
+
+	return nil
+}
+
+func newSecret() (string, error) {
+	secret := make([]byte, secretSize)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+func (o *Operation) auditDeleted(hook *events.Webhook) {
+	if o.audit == nil {
+		return
+	}
+
+	err := o.audit.Record(audit.ActionDataDeleted, "", audit.ActorAdmin, &deletedWebhook{
+		Resource: "webhook",
+		ID:       hook.ID,
+		Tenant:   hook.Tenant,
+		URL:      hook.URL,
+	})
+	if err != nil {
+		logger.Errorf("failed to audit the deletion of webhook %s: %s", hook.ID, err.Error())
+	}
+}
+
+func (o *Operation) auditDiscarded(e *outbox.Event) {
+	if o.audit == nil {
+		return
+	}
+
+	err := o.audit.Record(audit.ActionDataDeleted, e.Subject, audit.ActorAdmin, &discardedEvent{
+		Resource: "event",
+		ID:       e.ID,
+		Topic:    e.Topic,
+		Tenant:   e.Tenant,
+	})
+	if err != nil {
+		logger.Errorf("failed to audit the discard of event %s: %s", e.ID, err.Error())
+	}
+}
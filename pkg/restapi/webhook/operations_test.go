@@ -0,0 +1,386 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package webhook // nolint:testpackage // changing to different package requires exposing internal REST handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/events"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/audit"
+	"github.com/trustbloc/edge-agent/pkg/restapi/common/store/outbox"
+	"github.com/trustbloc/edge-core/pkg/storage"
+	"github.com/trustbloc/edge-core/pkg/storage/memstore"
+	"github.com/trustbloc/edge-core/pkg/storage/mockstore"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("returns an instance", func(t *testing.T) {
+		o := newOperation(t)
+		require.Len(t, o.GetRESTHandlers(), 3)
+
+		o.outbox = newOutbox(t)
+		require.Len(t, o.GetRESTHandlers(), 6)
+	})
+
+	t.Run("error if webhook store is missing", func(t *testing.T) {
+		_, err := New(&Config{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing webhook store")
+	})
+}
+
+func TestOperation_RegisterHandler(t *testing.T) {
+	t.Run("registers a webhook with a generated secret", func(t *testing.T) {
+		o := newOperation(t)
+
+		w := httptest.NewRecorder()
+		o.registerHandler(w, newRequest(t, http.MethodPost, "/webhooks", &RegisterWebhookRequest{
+			Tenant: "acme",
+			URL:    "https://acme.example.com/events",
+			Topics: []string{"user.created"},
+		}))
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		result := &events.Webhook{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+		require.NotEmpty(t, result.ID)
+		require.Len(t, result.Secret, 2*secretSize)
+		require.Equal(t, "acme", result.Tenant)
+
+		saved, err := o.webhooks.Get(result.ID)
+		require.NoError(t, err)
+		require.Equal(t, result, saved)
+	})
+
+	t.Run("keeps the given secret", func(t *testing.T) {
+		o := newOperation(t)
+
+		w := httptest.NewRecorder()
+		o.registerHandler(w, newRequest(t, http.MethodPost, "/webhooks", &RegisterWebhookRequest{
+			URL:    "http://localhost:8080/events",
+			Secret: "secret",
+		}))
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		result := &events.Webhook{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(result))
+		require.Equal(t, "secret", result.Secret)
+	})
+
+	t.Run("err badrequest if request is invalid", func(t *testing.T) {
+		o := newOperation(t)
+
+		w := httptest.NewRecorder()
+		o.registerHandler(w, httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewReader([]byte("{"))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "failed to decode request")
+
+		for _, u := range []string{"", "/events", "ftp://example.com", "https://%zz"} {
+			w = httptest.NewRecorder()
+			o.registerHandler(w, newRequest(t, http.MethodPost, "/webhooks", &RegisterWebhookRequest{URL: u}))
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), "invalid webhook url")
+		}
+	})
+
+	t.Run("err internalservererror if webhook cannot be saved", func(t *testing.T) {
+		o := newFailingOperation(t, &mockstore.MockStore{Store: map[string][]byte{}, ErrPut: errors.New("test")})
+
+		w := httptest.NewRecorder()
+		o.registerHandler(w, newRequest(t, http.MethodPost, "/webhooks", &RegisterWebhookRequest{
+			URL: "https://example.com",
+		}))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to save webhook")
+	})
+}
+
+func TestOperation_ListHandler(t *testing.T) {
+	t.Run("lists the webhooks of a tenant without their secrets", func(t *testing.T) {
+		o := newOperation(t)
+		require.NoError(t, o.webhooks.Save(&events.Webhook{ID: "2", Tenant: "acme", URL: "https://a", Secret: "s"}))
+		require.NoError(t, o.webhooks.Save(&events.Webhook{ID: "1", Tenant: "acme", URL: "https://b", Secret: "s"}))
+		require.NoError(t, o.webhooks.Save(&events.Webhook{ID: "3", Tenant: "other", URL: "https://c", Secret: "s"}))
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, "/webhooks?tenant=acme", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result []*events.Webhook
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Equal(t, []*events.Webhook{
+			{ID: "1", Tenant: "acme", URL: "https://b"},
+			{ID: "2", Tenant: "acme", URL: "https://a"},
+		}, result)
+
+		w = httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Len(t, result, 3)
+	})
+
+	t.Run("err internalservererror if webhooks cannot be listed", func(t *testing.T) {
+		o := newFailingOperation(t, &mockstore.MockStore{Store: map[string][]byte{}, ErrGetAll: errors.New("test")})
+
+		w := httptest.NewRecorder()
+		o.listHandler(w, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to list webhooks")
+	})
+}
+
+func TestOperation_DeleteHandler(t *testing.T) {
+	t.Run("deletes the webhook", func(t *testing.T) {
+		o := newOperation(t)
+		require.NoError(t, o.webhooks.Save(&events.Webhook{ID: "1", URL: "https://a"}))
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks?id=1", nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		all, err := o.webhooks.List()
+		require.NoError(t, err)
+		require.Empty(t, all)
+	})
+
+	t.Run("audits the deletion", func(t *testing.T) {
+		o := newOperation(t)
+		require.NoError(t, o.webhooks.Save(&events.Webhook{ID: "1", Tenant: "acme", URL: "https://a"}))
+
+		var err error
+
+		o.audit, err = audit.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks?id=1", nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		entries, err := o.audit.List("")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, audit.ActionDataDeleted, entries[0].Action)
+		require.Equal(t, audit.ActorAdmin, entries[0].Actor)
+		require.JSONEq(t, `{"resource": "webhook", "id": "1", "tenant": "acme", "url": "https://a"}`,
+			string(entries[0].Details))
+	})
+
+	t.Run("err badrequest if id is missing", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newOperation(t).deleteHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "missing id parameter")
+	})
+
+	t.Run("err notfound if webhook does not exist", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newOperation(t).deleteHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks?id=1", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "webhook 1 not found")
+	})
+
+	t.Run("err internalservererror if store fails", func(t *testing.T) {
+		o := newFailingOperation(t, &mockstore.MockStore{
+			Store: map[string][]byte{"1": []byte("{}")}, ErrDelete: errors.New("test"),
+		})
+
+		w := httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks?id=1", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to delete webhook 1")
+
+		o = newFailingOperation(t, &mockstore.MockStore{Store: map[string][]byte{"1": []byte("{")}})
+
+		w = httptest.NewRecorder()
+		o.deleteHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks?id=1", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+		require.Contains(t, w.Body.String(), "failed to unmarshal webhook")
+	})
+}
+
+func TestOperation_DeadLetters(t *testing.T) {
+	t.Run("lists the dead events of a tenant", func(t *testing.T) {
+		o := newOperation(t)
+		o.outbox = newOutbox(t)
+
+		first := newDeadEvent(t, o.outbox, "acme")
+		second := newDeadEvent(t, o.outbox, "acme")
+		newDeadEvent(t, o.outbox, "other")
+		require.NoError(t, o.outbox.Enqueue(&outbox.Event{ID: "ready", Tenant: "acme"}))
+
+		w := httptest.NewRecorder()
+		o.listDeadHandler(w, httptest.NewRequest(http.MethodGet, "/webhooks/dead-letters?tenant=acme", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var result []*outbox.Event
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Len(t, result, 2)
+		require.Equal(t, first.ID, result[0].ID)
+		require.Equal(t, second.ID, result[1].ID)
+
+		w = httptest.NewRecorder()
+		o.listDeadHandler(w, httptest.NewRequest(http.MethodGet, "/webhooks/dead-letters", nil))
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Len(t, result, 3)
+	})
+
+	t.Run("requeues a dead event", func(t *testing.T) {
+		o := newOperation(t)
+		o.outbox = newOutbox(t)
+		e := newDeadEvent(t, o.outbox, "acme")
+
+		w := httptest.NewRecorder()
+		o.requeueHandler(w, httptest.NewRequest(http.MethodPost, "/webhooks/dead-letters?id="+e.ID, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		requeued, err := o.outbox.Get(e.ID)
+		require.NoError(t, err)
+		require.Equal(t, outbox.StatusReady, requeued.Status)
+		require.Zero(t, requeued.Attempts)
+
+		w = httptest.NewRecorder()
+		o.requeueHandler(w, httptest.NewRequest(http.MethodPost, "/webhooks/dead-letters?id="+e.ID, nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "dead event "+e.ID+" not found")
+
+		w = httptest.NewRecorder()
+		o.requeueHandler(w, httptest.NewRequest(http.MethodPost, "/webhooks/dead-letters", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("discards a dead event and audits it", func(t *testing.T) {
+		o := newOperation(t)
+		o.outbox = newOutbox(t)
+		e := newDeadEvent(t, o.outbox, "acme")
+
+		var err error
+
+		o.audit, err = audit.NewStore(memstore.NewProvider())
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.discardHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks/dead-letters?id="+e.ID, nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		_, err = o.outbox.Get(e.ID)
+		require.True(t, errors.Is(err, storage.ErrValueNotFound))
+
+		entries, err := o.audit.List("")
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.JSONEq(t, `{"resource": "event", "id": "`+e.ID+`", "topic": "test.topic", "tenant": "acme"}`,
+			string(entries[0].Details))
+
+		w = httptest.NewRecorder()
+		o.discardHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks/dead-letters?id="+e.ID, nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		o.discardHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks/dead-letters", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("does not discard the events pending delivery", func(t *testing.T) {
+		o := newOperation(t)
+		o.outbox = newOutbox(t)
+		require.NoError(t, o.outbox.Enqueue(&outbox.Event{ID: "ready"}))
+
+		w := httptest.NewRecorder()
+		o.discardHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks/dead-letters?id=ready", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("err internalservererror if outbox fails", func(t *testing.T) {
+		o := newOperation(t)
+
+		var err error
+
+		o.outbox, err = outbox.NewStore(&mockstore.Provider{Store: &mockstore.MockStore{
+			Store:     map[string][]byte{"1": []byte("{")},
+			ErrGetAll: errors.New("test"),
+		}})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		o.listDeadHandler(w, httptest.NewRequest(http.MethodGet, "/webhooks/dead-letters", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		w = httptest.NewRecorder()
+		o.requeueHandler(w, httptest.NewRequest(http.MethodPost, "/webhooks/dead-letters?id=1", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		w = httptest.NewRecorder()
+		o.discardHandler(w, httptest.NewRequest(http.MethodDelete, "/webhooks/dead-letters?id=1", nil))
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func newOutbox(t *testing.T) *outbox.Store {
+	t.Helper()
+
+	s, err := outbox.NewStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	return s
+}
+
+func newDeadEvent(t *testing.T, s *outbox.Store, tenant string) *outbox.Event {
+	t.Helper()
+
+	e, err := outbox.NewEvent("test.topic", "sub", nil)
+	require.NoError(t, err)
+
+	e.Tenant = tenant
+	e.Status = outbox.StatusDead
+	e.Attempts = 12
+	require.NoError(t, s.Update(e))
+
+	// the events are listed in creation order
+	time.Sleep(time.Millisecond)
+
+	return e
+}
+
+func newOperation(t *testing.T) *Operation {
+	t.Helper()
+
+	webhooks, err := events.NewWebhookStore(memstore.NewProvider())
+	require.NoError(t, err)
+
+	o, err := New(&Config{Webhooks: webhooks})
+	require.NoError(t, err)
+
+	return o
+}
+
+func newFailingOperation(t *testing.T, s *mockstore.MockStore) *Operation {
+	t.Helper()
+
+	webhooks, err := events.NewWebhookStore(&mockstore.Provider{Store: s})
+	require.NoError(t, err)
+
+	o, err := New(&Config{Webhooks: webhooks})
+	require.NoError(t, err)
+
+	return o
+}
+
+func newRequest(t *testing.T, method, target string, body interface{}) *http.Request {
+	t.Helper()
+
+	bits, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	return httptest.NewRequest(method, target, bytes.NewReader(bits))
+}